@@ -8,7 +8,7 @@
 //
 // Synopsis:
 //
-//	boot [-v][-no-load][-no-exec]
+//	boot [-v][-no-load][-no-exec][-measure][-policy FILE][-tui][-timeout DURATION][-slots LABEL_A,LABEL_B [-slot-state PATH]]
 //
 // Description:
 //
@@ -17,6 +17,12 @@
 //	-v prints messages
 //	-no-load prints the boot image paths it was going to load, but doesn't load + exec them
 //	-no-exec loads the boot image, but doesn't exec it
+//	-measure measures the boot image into the TPM before exec'ing it
+//	-policy verifies the signatures of the boot image against a policy file, see package bootpolicy
+//	-tui shows a full-screen boot menu, navigated with the cursor keys, whose entries' kernel cmdline can be edited with 'e'
+//	-timeout is how long the menu waits for a key before booting the default entries, negative to wait indefinitely
+//	-slots boots from the partition, by GPT partition label, of the active A/B boot slot, see package bootslot. The boot attempt is recorded right before the image is executed, not with -no-load or -no-exec
+//	-slot-state is the partition or file, or efivar for an EFI variable, that the state of the boot slots is kept in
 //
 // Notes:
 //
@@ -32,12 +38,17 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/u-root/u-root/pkg/boot"
 	"github.com/u-root/u-root/pkg/boot/bootcmd"
+	"github.com/u-root/u-root/pkg/boot/bootpolicy"
+	"github.com/u-root/u-root/pkg/boot/bootslot"
 	"github.com/u-root/u-root/pkg/boot/localboot"
+	"github.com/u-root/u-root/pkg/boot/measuredboot"
 	"github.com/u-root/u-root/pkg/boot/menu"
 	"github.com/u-root/u-root/pkg/cmdline"
 	"github.com/u-root/u-root/pkg/mount"
@@ -49,6 +60,13 @@ var (
 	verbose = flag.Bool("v", false, "Print debug messages")
 	noLoad  = flag.Bool("no-load", false, "print chosen boot configuration, but do not load + exec it")
 	noExec  = flag.Bool("no-exec", false, "load boot configuration, but do not exec it")
+	measure = flag.Bool("measure", false, "Measure the chosen kernel, initramfs and command line into the TPM 2.0 before kexec")
+	policy  = flag.String("policy", "", "Boot policy file. Enforces the signatures it lists on the chosen image if non-empty path")
+	tui     = flag.Bool("tui", false, "Show a full-screen boot menu, with cursor key navigation and kernel cmdline editing")
+	timeout = flag.Duration("timeout", 10*time.Second, "Time to wait for a key in the boot menu before booting the default entries. Negative waits indefinitely")
+
+	slots     = flag.String("slots", "", "comma separated GPT partition labels of the A and B boot slots. Boots from the active slot, falling back to the other one after failed boots of an upgrade")
+	slotState = flag.String("slot-state", "efivar", "partition or file that the boot slot state is kept in, or efivar for an EFI variable")
 
 	removeCmdlineItem = flag.String("remove", "console", "comma separated list of kernel params value to remove from parsed kernel configuration (default to console)")
 	reuseCmdlineItem  = flag.String("reuse", "console", "comma separated list of kernel params value to reuse from current kernel (default to console)")
@@ -64,6 +82,20 @@ func cmdlineModifier(li *boot.LinuxImage) {
 	li.Cmdline = f.Update(cmdline.NewCmdLine(), li.Cmdline)
 }
 
+// recordSlot records the boot attempt of the slot st was peeked for in s.
+// The state may have changed since, e.g. by the menu's shell, in which case
+// the image picked from st's slot is not booted.
+func recordSlot(s bootslot.Store, st bootslot.State) error {
+	next, err := bootslot.Next(s)
+	if err != nil {
+		return fmt.Errorf("boot slots: %w", err)
+	}
+	if next.Active != st.Active {
+		return fmt.Errorf("boot slots: active slot changed from %v to %v since the menu started", st.Active, next.Active)
+	}
+	return nil
+}
+
 func main() {
 	flag.Parse()
 
@@ -86,6 +118,28 @@ func main() {
 		}
 	}
 
+	var (
+		slotStore bootslot.Store
+		st        bootslot.State
+	)
+	if *slots != "" {
+		labels := strings.Split(*slots, ",")
+		if len(labels) != 2 {
+			log.Fatalf("-slots needs the partition labels of 2 slots, got %q", *slots)
+		}
+		s, err := bootslot.OpenStore(*slotState)
+		if err != nil {
+			log.Fatalf("Boot slots: %v", err)
+		}
+		st, err = bootslot.Peek(s)
+		if err != nil {
+			log.Fatalf("Boot slots: %v", err)
+		}
+		log.Printf("Boot slots: %v", st)
+		slotStore = s
+		blockDevs = blockDevs.FilterPartLabel(labels[st.Active.Index()])
+	}
+
 	log.Printf("Booting from the following block devices: %v", blockDevs)
 
 	var l = ulog.Null
@@ -101,9 +155,30 @@ func main() {
 	boot.ApplyLinuxModifiers(images, cmdlineModifier)
 
 	menuEntries := menu.OSImages(*verbose, images...)
+	if *measure {
+		m, err := measuredboot.New()
+		if err != nil {
+			log.Fatalf("Measured boot: %v", err)
+		}
+		menu.Measure(m, menuEntries...)
+	}
+	if *policy != "" {
+		p, err := bootpolicy.Load(*policy)
+		if err != nil {
+			log.Fatalf("Boot policy: %v", err)
+		}
+		menu.Verify(p, menuEntries...)
+	}
+	if slotStore != nil && !*noLoad && !*noExec {
+		menu.BeforeExec(func() error { return recordSlot(slotStore, st) }, menuEntries...)
+	}
 	menuEntries = append(menuEntries, menu.Reboot{})
 	menuEntries = append(menuEntries, menu.StartShell{})
 
+	menu.SetInitialTimeout(*timeout)
 	// Boot does not return.
+	if *tui {
+		bootcmd.ShowTUIAndBoot(menuEntries, mountPool, *noLoad, *noExec)
+	}
 	bootcmd.ShowMenuAndBoot(menuEntries, mountPool, *noLoad, *noExec)
 }
@@ -0,0 +1,45 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/boot/bootslot"
+)
+
+func TestRecordSlot(t *testing.T) {
+	s := &bootslot.FileStore{Path: filepath.Join(t.TempDir(), "state")}
+	if err := bootslot.SetActive(s, bootslot.B, 2); err != nil {
+		t.Fatalf("SetActive() = %v", err)
+	}
+	stored := bootslot.State{Active: bootslot.B, Tries: 2}
+
+	// A dry run only peeks, which leaves the stored state alone.
+	st, err := bootslot.Peek(s)
+	if err != nil {
+		t.Fatalf("Peek() = %v", err)
+	}
+	if got, err := s.Load(); err != nil || got != stored {
+		t.Errorf("Load() after Peek() = %v, %v, want %v", got, err, stored)
+	}
+
+	if err := recordSlot(s, st); err != nil {
+		t.Fatalf("recordSlot() = %v", err)
+	}
+	want := bootslot.State{Active: bootslot.B, Tries: 1}
+	if got, err := s.Load(); err != nil || got != want {
+		t.Errorf("Load() after recordSlot() = %v, %v, want %v", got, err, want)
+	}
+
+	// The state changed since st was peeked.
+	if err := bootslot.SetActive(s, bootslot.A, 0); err != nil {
+		t.Fatalf("SetActive() = %v", err)
+	}
+	if err := recordSlot(s, st); err == nil {
+		t.Errorf("recordSlot() after SetActive(A) = nil, want error")
+	}
+}
@@ -9,9 +9,11 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/u-root/u-root/pkg/acpi"
 	"github.com/u-root/u-root/pkg/boot"
+	"github.com/u-root/u-root/pkg/boot/bootpolicy"
 	"github.com/u-root/u-root/pkg/boot/fit"
 	"github.com/u-root/u-root/pkg/vfile"
 )
@@ -21,9 +23,11 @@ var (
 	debug      = flag.Bool("d", false, "Print debug output")
 	cmdline    = flag.String("c", "earlyprintk=ttyS0,115200,keep console=ttyS0", "command line")
 	config     = flag.String("config", "", "FIT configuration to use")
+	compatible = flag.String("compatible", "", "comma separated compatible strings, most specific first, to select the FIT configuration by. Defaults to those of the running kernel's device tree")
 	kernel     = flag.String("k", "", "Kernel image node name.")
 	initramfs  = flag.String("i", "", "InitRAMFS node name -- default none")
 	ringPath   = flag.String("r", "", "Path to PGP keyring. Enforces signature if non-empty path")
+	policy     = flag.String("policy", "", "Boot policy file. Enforces the signatures it lists on the FIT file if non-empty path")
 	rsdpLookup = flag.Bool("rsdp", false, "Derrive RSDP table pointer from environment")
 )
 
@@ -46,10 +50,13 @@ func main() {
 
 	f.Cmdline, f.Kernel, f.InitRAMFS, f.ConfigOverride = *cmdline, *kernel, *initramfs, *config
 
-	kn, in, err := f.LoadConfig()
-	if err == nil {
-		f.Kernel, f.InitRAMFS = kn, in
-	} else {
+	if *compatible != "" {
+		f.Compatible = strings.Split(*compatible, ",")
+	} else if c, err := fit.SystemCompatible(); err == nil {
+		f.Compatible = c
+	}
+
+	if err := f.ApplyConfig(); err != nil {
 		v("Configuration is not available: %v", err)
 	}
 
@@ -57,7 +64,7 @@ func main() {
 		log.Fatal("kernel name is not found in fit configuration or pass through -k.")
 	}
 
-	v("Kernel name=%s, initramfs=%s", f.Kernel, f.InitRAMFS)
+	v("Kernel name=%s, initramfs=%s, loadables=%v, dtb=%s", f.Kernel, f.InitRAMFS, f.Loadables, f.DTB)
 
 	kernelCmd := *cmdline
 	if *rsdpLookup {
@@ -79,7 +86,16 @@ func main() {
 		f.KeyRing = ring
 	}
 
-	if err := f.Load(boot.WithVerbose(*debug)); err != nil {
+	opts := []boot.LoadOption{boot.WithVerbose(*debug)}
+	if *policy != "" {
+		p, err := bootpolicy.Load(*policy)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, boot.WithVerifier(p))
+	}
+
+	if err := f.Load(opts...); err != nil {
 		log.Fatal(err)
 	}
 
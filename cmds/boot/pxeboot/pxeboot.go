@@ -17,6 +17,34 @@
 //
 //   - a pxelinux.0, in which case we will ignore the pxelinux and try to parse
 //     pxelinux.cfg/<files>
+//
+// Files may also be fetched over HTTPS, from servers that can ask for the
+// client certificate given with -cert and -key, and whose certificates are
+// checked against the CA bundle given with -ca-cert.
+//
+// HTTP and HTTPS fetches go through the proxy given with -proxy, or else
+// those of the http_proxy, https_proxy and all_proxy environment variables,
+// but for the hosts of -no-proxy or no_proxy.
+//
+// With -http-boot, pxeboot asks for UEFI HTTP Boot, with the HTTPClient
+// vendor class. Servers that answer with the same class give the URI of a
+// FIT image, Unified Kernel Image, Linux kernel or ISO image to boot rather
+// than a config. -firmware-ca adds the CAs that the firmware was provisioned
+// with, in the TlsCaCertificate EFI variable, to those trusted for HTTPS.
+//
+// Over IPv6, the boot file comes from the Boot File URL option of DHCPv6,
+// and its Boot File Parameters are appended to the kernel command line.
+// Networks whose router advertisements have hosts configure their own
+// addresses need -ipv6-stateless, which only asks DHCPv6 for configuration.
+// -server may be an IPv6 address, and -file a URI with an IPv6 literal, such
+// as tftp://[2001:db8::1]/pxelinux.0.
+//
+// With -measure, the kernel, initramfs and command line of the chosen image
+// are measured into the PCRs of the TPM, and the event log of these
+// measurements is handed to the kernel, see package measuredboot.
+//
+// With -policy, the chosen image only boots if its files have the signatures
+// that the policy file requires of their URLs, see package bootpolicy.
 package main
 
 import (
@@ -25,11 +53,14 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"net/url"
 	"strings"
 	"time"
 
 	"github.com/u-root/u-root/pkg/boot"
 	"github.com/u-root/u-root/pkg/boot/bootcmd"
+	"github.com/u-root/u-root/pkg/boot/bootpolicy"
+	"github.com/u-root/u-root/pkg/boot/measuredboot"
 	"github.com/u-root/u-root/pkg/boot/menu"
 	"github.com/u-root/u-root/pkg/boot/netboot"
 	"github.com/u-root/u-root/pkg/curl"
@@ -38,6 +69,8 @@ import (
 	"github.com/u-root/u-root/pkg/ulog"
 
 	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/vishvananda/netlink"
 )
 
 var (
@@ -49,9 +82,20 @@ var (
 	verbose     = flag.Bool("v", false, "Verbose output")
 	ipv4        = flag.Bool("ipv4", true, "use IPV4")
 	ipv6        = flag.Bool("ipv6", true, "use IPV6")
+	v6Stateless = flag.Bool("ipv6-stateless", false, "Get an IPv6 address from router advertisements, and only the boot file URL from DHCPv6")
 	cmdAppend   = flag.String("cmd", "", "Kernel command to append for each image")
 	bootfile    = flag.String("file", "", "Boot file name (default tftp) or full URI to use instead of DHCP.")
-	server      = flag.String("server", "0.0.0.0", "Server IP (Requires -file for effect)")
+	server      = flag.String("server", "0.0.0.0", "Server IPv4 or IPv6 address (Requires -file for effect)")
+	caCert      = flag.String("ca-cert", "", "PEM bundle of the CAs to trust for HTTPS instead of the system ones")
+	cert        = flag.String("cert", "", "PEM client certificate for HTTPS")
+	key         = flag.String("key", "", "PEM private key of the -cert client certificate")
+	insecure    = flag.Bool("insecure", false, "Accept any HTTPS server certificate")
+	proxy       = flag.String("proxy", "", "Proxy of HTTP and HTTPS fetches, http://[USER:PASSWORD@]HOST[:PORT] or socks5://[USER:PASSWORD@]HOST[:PORT]")
+	noProxy     = flag.String("no-proxy", "", "Comma separated hosts to fetch from without the proxy, overriding no_proxy")
+	httpBoot    = flag.Bool("http-boot", false, "Ask DHCP servers for a UEFI HTTP Boot URI, or boot the -file URI as an HTTP Boot image")
+	firmwareCA  = flag.Bool("firmware-ca", false, "Also trust the CAs of the UEFI TlsCaCertificate variable for HTTPS")
+	measure     = flag.Bool("measure", false, "Measure the chosen kernel, initramfs and command line into the TPM 2.0 before kexec")
+	policy      = flag.String("policy", "", "Boot policy file. Enforces the signatures it lists on the chosen image if non-empty path")
 )
 
 const (
@@ -60,8 +104,9 @@ const (
 )
 
 // NetbootImages requests DHCP on every ifaceNames interface, and parses
-// netboot images from the DHCP leases. Returns bootable OSes.
-func NetbootImages(ifaceNames string) ([]boot.OSImage, error) {
+// netboot images from the DHCP leases, fetching them with schemes. Returns
+// bootable OSes.
+func NetbootImages(ifaceNames string, schemes curl.Schemes) ([]boot.OSImage, error) {
 	filteredIfs, err := dhclient.Interfaces(ifaceNames)
 	if err != nil {
 		return nil, err
@@ -75,8 +120,10 @@ func NetbootImages(ifaceNames string) ([]boot.OSImage, error) {
 	defer cancel()
 
 	c := dhclient.Config{
-		Timeout: dhcpTimeout,
-		Retries: dhcpTries,
+		Timeout:     dhcpTimeout,
+		Retries:     dhcpTries,
+		HTTPBoot:    *httpBoot,
+		V6Stateless: *v6Stateless,
 	}
 	if *verbose {
 		c.LogLevel = dhclient.LogSummary
@@ -109,7 +156,7 @@ func NetbootImages(ifaceNames string) ([]boot.OSImage, error) {
 			}
 
 			// Don't use the other context, as it's for the DHCP timeout.
-			imgs, err := netboot.BootImages(context.Background(), ulog.Log, curl.DefaultSchemes, result.Lease)
+			imgs, err := netboot.BootImages(context.Background(), ulog.Log, schemes, result.Lease)
 			if err != nil {
 				log.Printf("Failed to boot lease %v: %v", result.Lease, err)
 				continue
@@ -126,17 +173,46 @@ func newManualLease() (dhclient.Lease, error) {
 		return nil, err
 	}
 
+	serverIP := net.ParseIP(*server)
+	if serverIP != nil && serverIP.To4() == nil {
+		return newManualLease6(filteredIfs[0], serverIP)
+	}
+
 	d, err := dhcpv4.New()
 	if err != nil {
 		return nil, err
 	}
 
 	d.BootFileName = *bootfile
-	d.ServerIPAddr = net.ParseIP(*server)
+	d.ServerIPAddr = serverIP
+	if *httpBoot {
+		d.UpdateOption(dhcpv4.OptClassIdentifier(dhclient.HTTPBootClass))
+	}
 
 	return dhclient.NewPacket4(filteredIfs[0], d), nil
 }
 
+// newManualLease6 returns a DHCPv6 lease of the -file boot file on the IPv6
+// server, unless -file is a URI.
+func newManualLease6(iface netlink.Link, server net.IP) (dhclient.Lease, error) {
+	u, err := url.Parse(*bootfile)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" {
+		u = &url.URL{
+			Scheme: dhclient.DefaultScheme,
+			Host:   "[" + server.String() + "]",
+			Path:   *bootfile,
+		}
+	}
+	m, err := dhcpv6.NewMessage(dhcpv6.WithOption(dhcpv6.OptBootFileURL(u.String())))
+	if err != nil {
+		return nil, err
+	}
+	return dhclient.NewPacket6(iface, m), nil
+}
+
 func dumpNetDebugInfo() {
 	log.Println("Dump debug info of network status")
 	commands := []string{"ip link", "ip addr", "ip route show table all", "ip -6 route show table all", "ip neigh"}
@@ -157,10 +233,20 @@ func main() {
 		ifName = flag.Args()[0]
 	}
 
+	schemes, err := curl.HTTPSSchemes(curl.DefaultSchemes, curl.TLSOptions{
+		CAFile:             *caCert,
+		CertFile:           *cert,
+		KeyFile:            *key,
+		InsecureSkipVerify: *insecure,
+		FirmwareCAs:        *firmwareCA,
+	}, curl.ProxyOptions{URL: *proxy, NoProxy: *noProxy})
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	var images []boot.OSImage
-	var err error
 	if *bootfile == "" {
-		images, err = NetbootImages(ifName)
+		images, err = NetbootImages(ifName, schemes)
 		if err != nil {
 			dumpNetDebugInfo()
 		}
@@ -169,7 +255,7 @@ func main() {
 		var l dhclient.Lease
 		l, err = newManualLease()
 		if err == nil {
-			images, err = netboot.BootImages(context.Background(), ulog.Log, curl.DefaultSchemes, l)
+			images, err = netboot.BootImages(context.Background(), ulog.Log, schemes, l)
 		}
 	}
 
@@ -184,6 +270,20 @@ func main() {
 	}
 
 	menuEntries := menu.OSImages(*verbose, images...)
+	if *measure {
+		m, err := measuredboot.New()
+		if err != nil {
+			log.Fatalf("Measured boot: %v", err)
+		}
+		menu.Measure(m, menuEntries...)
+	}
+	if *policy != "" {
+		p, err := bootpolicy.Load(*policy)
+		if err != nil {
+			log.Fatalf("Boot policy: %v", err)
+		}
+		menu.Verify(p, menuEntries...)
+	}
 	menuEntries = append(menuEntries, menu.Reboot{})
 	menuEntries = append(menuEntries, menu.StartShell{})
 
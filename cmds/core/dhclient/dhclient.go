@@ -12,16 +12,45 @@
 //
 // Options:
 //
-//	-timeout:  lease timeout in seconds
-//	-renewals: number of DHCP renewals before exiting
-//	-verbose:  verbose output
+//	-timeout:      lease timeout in seconds
+//	-renewals:     number of DHCP renewals before exiting
+//	-verbose:      verbose output
+//	-pd:           request a delegated IPv6 prefix
+//	-pd-length:    length of the prefix to hint to the server
+//	-pd-iface:     interface to install the delegated prefix on
+//	-daemon:       keep running, renewing and rebinding leases as they age
+//	-state-dir:    directory to store leases in with -daemon
+//	-request:      comma separated DHCPv4 option codes to request, e.g. 26,42,119
+//	-vendor-class: DHCPv4 vendor class identifier (option 60)
+//	-user-class:   comma separated DHCPv4 user classes (option 77)
+//	-env-dir:      directory to write the options of each lease to, as
+//	               <interface>.env4 and <interface>.env6 shell variables
+//	-hook:         program to run on BOUND, RENEW, REBIND and EXPIRE events,
+//	               with the event in $reason and the lease in the environment
+//	-duid:         DUID to send: ll (of the first interface), uuid (of the
+//	               SMBIOS system UUID), uuid:<UUID> or raw hex bytes
+//	-client-id:    DHCPv4 client identifier: mac, duid (RFC 4361), serial
+//	               (SMBIOS system serial number) or raw hex bytes
+//	-race:         race DHCP on all interfaces and only configure the lease
+//	               chosen by the given policy: first, or boot for the first
+//	               one with boot information
+//	-vlan:         create the given 802.1Q VLAN on each interface and use it
+//	               instead, as <interface>.<id>
+//	-vlan-from-dhcp: run DHCP again on the VLAN of DHCPv4 option 132, if
+//	               the untagged lease has one
 package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/insomniacslk/dhcp/dhcpv4"
@@ -44,8 +73,170 @@ var (
 	v6Server = flag.String("v6-server", "ff02::1:2", "DHCPv6 server address to send to (multicast or unicast)")
 
 	v4Port = flag.Int("v4-port", dhcpv4.ServerPort, "DHCPv4 server port to send to")
+
+	pd       = flag.Bool("pd", false, "Request a delegated IPv6 prefix (IA_PD)")
+	pdLength = flag.Int("pd-length", 0, "Length of the delegated prefix to hint to the server, 0 for none")
+	pdIface  = flag.String("pd-iface", "", "Interface to install the delegated prefix on")
+
+	daemon   = flag.Bool("daemon", false, "Keep running to renew leases, and store them in -state-dir")
+	stateDir = flag.String("state-dir", dhclient.DefaultStateDir, "Directory to store leases in")
+
+	request     = flag.String("request", "", "Comma separated DHCPv4 option codes to request in addition to the defaults")
+	vendorClass = flag.String("vendor-class", "", "DHCPv4 vendor class identifier (option 60), defaults to \"PXE UROOT\"")
+	userClass   = flag.String("user-class", "", "Comma separated DHCPv4 user classes (option 77)")
+	envDir      = flag.String("env-dir", "", "Directory to write the options of each lease to as shell variables")
+	hook        = flag.String("hook", "", "Program to run with the lease in its environment when it is bound, renewed, rebound or expires")
+
+	duid     = flag.String("duid", "", "DUID to identify with: ll, uuid, uuid:<UUID> or hex bytes")
+	clientID = flag.String("client-id", "", "DHCPv4 client identifier: mac, duid, serial or hex bytes")
+	race     = flag.String("race", "", "Only configure the lease chosen by this policy among all interfaces: first or boot")
+
+	vlan         = flag.Int("vlan", 0, "802.1Q VLAN ID to create on each interface and DHCP on instead")
+	vlanFromDHCP = flag.Bool("vlan-from-dhcp", false, "DHCP again on the VLAN of DHCPv4 option 132 of the untagged lease")
+)
+
+var (
+	errOptionCode = errors.New("invalid DHCPv4 option code")
+	errNoDUID     = errors.New("-client-id duid needs a -duid")
 )
 
+// parseOptionCodes parses a comma separated list of DHCPv4 option codes.
+func parseOptionCodes(s string) ([]dhcpv4.OptionCode, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var codes []dhcpv4.OptionCode
+	for _, f := range strings.Split(s, ",") {
+		code, err := strconv.ParseUint(strings.TrimSpace(f), 10, 8)
+		if err != nil || code == 0 || code == 255 {
+			return nil, fmt.Errorf("%w: %q", errOptionCode, f)
+		}
+		codes = append(codes, dhcpv4.GenericOptionCode(code))
+	}
+	return codes, nil
+}
+
+// identify sets the DUID and DHCPv4 client identifier of c from the
+// -duid and -client-id flags. A DUID-LL is the one of the first of ifs,
+// as a client has one DUID for all its interfaces.
+func identify(c *dhclient.Config, ifs []netlink.Link) error {
+	if *duid != "" {
+		var mac net.HardwareAddr
+		if len(ifs) > 0 {
+			mac = ifs[0].Attrs().HardwareAddr
+		}
+		d, err := dhclient.ParseDUID(*duid, mac)
+		if err != nil {
+			return err
+		}
+		c.DUID = d
+	}
+	switch *clientID {
+	case "":
+	case "mac":
+		c.V4ClientIdentifier = true
+	case "duid":
+		if c.DUID == nil {
+			return errNoDUID
+		}
+		c.V4ClientIdentifier = true
+	default:
+		id, err := dhclient.ParseClientID(*clientID)
+		if err != nil {
+			return err
+		}
+		c.V4ClientID = id
+	}
+	return nil
+}
+
+// raceAll races DHCP on ifs and returns the chosen result alone.
+func raceAll(ifs []netlink.Link, c dhclient.Config, linkUpTimeout time.Duration) chan *dhclient.Result {
+	p, err := dhclient.ParsePolicy(*race)
+	if err != nil {
+		log.Fatal(err)
+	}
+	result, err := dhclient.Race(context.Background(), ifs, *ipv4, *ipv6, c, linkUpTimeout, p)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Chose the %s lease on %s", result.Protocol, result.Interface.Attrs().Name)
+	r := make(chan *dhclient.Result, 1)
+	r <- result
+	close(r)
+	return r
+}
+
+// addVLANs returns the VLANs id created on each of ifs.
+func addVLANs(ifs []netlink.Link, id int) ([]netlink.Link, error) {
+	var vlans []netlink.Link
+	for _, iface := range ifs {
+		v, err := dhclient.AddVLAN(iface, id)
+		if err != nil {
+			return nil, err
+		}
+		vlans = append(vlans, v)
+	}
+	return vlans, nil
+}
+
+// followVLAN forwards the results of r, but for DHCPv4 leases with a VLAN
+// ID in option 132: DHCP runs again on that VLAN, and its results are
+// forwarded instead.
+func followVLAN(r chan *dhclient.Result, c dhclient.Config, linkUpTimeout time.Duration) chan *dhclient.Result {
+	out := make(chan *dhclient.Result)
+	go func() {
+		defer close(out)
+		var wg sync.WaitGroup
+		for result := range r {
+			var id int
+			var ok bool
+			if result.Err == nil && result.Protocol == dhclient.NetIPv4 {
+				id, ok = dhclient.VLANID(result.Lease)
+			}
+			if !ok {
+				out <- result
+				continue
+			}
+			v, err := dhclient.AddVLAN(result.Interface, id)
+			if err != nil {
+				out <- &dhclient.Result{Protocol: result.Protocol, Interface: result.Interface, Err: err}
+				continue
+			}
+			log.Printf("Lease on %s directs to VLAN %d, trying again on %s", result.Interface.Attrs().Name, id, v.Attrs().Name)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for result := range dhclient.SendRequests(context.Background(), []netlink.Link{v}, *ipv4, *ipv6, c, linkUpTimeout) {
+					out <- result
+				}
+			}()
+		}
+		wg.Wait()
+	}()
+	return out
+}
+
+// envPath returns the path of the environment file of the lease of
+// protocol p on interface ifname.
+func envPath(ifname string, p dhclient.NetworkProtocol) string {
+	if p == dhclient.NetIPv6 {
+		return filepath.Join(*envDir, ifname+".env6")
+	}
+	return filepath.Join(*envDir, ifname+".env4")
+}
+
+// writeEnv writes the options of l to its environment file, if asked to.
+func writeEnv(l dhclient.Lease, p dhclient.NetworkProtocol) {
+	if *envDir == "" {
+		return
+	}
+	name := l.Link().Attrs().Name
+	if err := dhclient.WriteEnvFile(envPath(name, p), l); err != nil {
+		log.Printf("Could not write options of %s: %v", name, err)
+	}
+}
+
 func main() {
 	flag.Parse()
 	if len(flag.Args()) > 1 {
@@ -56,15 +247,28 @@ func main() {
 		ifName = flag.Args()[0]
 	}
 
+	requested, err := parseOptionCodes(*request)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	filteredIfs, err := dhclient.Interfaces(ifName)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if *vlan != 0 {
+		if filteredIfs, err = addVLANs(filteredIfs, *vlan); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *vlanFromDHCP {
+		requested = append(requested, dhcpv4.Option8021PVLANID)
+	}
 
-	configureAll(filteredIfs)
+	configureAll(filteredIfs, requested)
 }
 
-func configureAll(ifs []netlink.Link) {
+func configureAll(ifs []netlink.Link, requested []dhcpv4.OptionCode) {
 	packetTimeout := time.Duration(*timeout) * time.Second
 
 	c := dhclient.Config{
@@ -78,6 +282,17 @@ func configureAll(ifs []netlink.Link) {
 			IP:   net.ParseIP(*v6Server),
 			Port: *v6Port,
 		},
+		V6PrefixDelegation: *pd || *pdIface != "",
+		V6PrefixLength:     *pdLength,
+		V6Downstream:       *pdIface,
+		V4RequestedOptions: requested,
+		V4VendorClass:      *vendorClass,
+	}
+	if *userClass != "" {
+		c.V4UserClasses = strings.Split(*userClass, ",")
+	}
+	if err := identify(&c, ifs); err != nil {
+		log.Fatal(err)
 	}
 	if *verbose {
 		c.LogLevel = dhclient.LogSummary
@@ -85,8 +300,18 @@ func configureAll(ifs []netlink.Link) {
 	if *vverbose {
 		c.LogLevel = dhclient.LogDebug
 	}
-	r := dhclient.SendRequests(context.Background(), ifs, *ipv4, *ipv6, c, 30*time.Second)
+	linkUpTimeout := 30 * time.Second
+	var r chan *dhclient.Result
+	if *race != "" {
+		r = raceAll(ifs, c, linkUpTimeout)
+	} else {
+		r = dhclient.SendRequests(context.Background(), ifs, *ipv4, *ipv6, c, linkUpTimeout)
+	}
+	if *vlanFromDHCP {
+		r = followVLAN(r, c, linkUpTimeout)
+	}
 
+	var wg sync.WaitGroup
 	for result := range r {
 		if result.Err != nil {
 			log.Printf("Could not configure %s for %s: %v", result.Interface.Attrs().Name, result.Protocol, result.Err)
@@ -96,7 +321,54 @@ func configureAll(ifs []netlink.Link) {
 			log.Printf("Could not configure %s for %s: %v", result.Interface.Attrs().Name, result.Protocol, err)
 		} else {
 			log.Printf("Configured %s with %s", result.Interface.Attrs().Name, result.Lease)
+			writeEnv(result.Lease, result.Protocol)
+			if err := runHook(dhclient.EventBound, result.Lease); err != nil {
+				log.Printf("Could not run hook for %s: %v", result.Interface.Attrs().Name, err)
+			}
+			if *daemon {
+				wg.Add(1)
+				go func(result *dhclient.Result) {
+					defer wg.Done()
+					maintain(result, c, linkUpTimeout)
+				}(result)
+			}
 		}
 	}
 	log.Printf("Finished trying to configure all interfaces.")
+	wg.Wait()
+}
+
+// maintain stores the lease of result and keeps it alive, reconfiguring
+// the interface whenever the lease changes.
+func maintain(result *dhclient.Result, c dhclient.Config, linkUpTimeout time.Duration) {
+	path := dhclient.StatePath(*stateDir, result.Interface.Attrs().Name, result.Protocol)
+	obtained := time.Now()
+	if err := dhclient.SaveLease(path, result.Lease, obtained); err != nil {
+		log.Printf("Could not store lease of %s: %v", result.Interface.Attrs().Name, err)
+	}
+	dhclient.Maintain(context.Background(), result.Lease, obtained, c, linkUpTimeout, func(l dhclient.Lease, e dhclient.Event, changed bool) error {
+		if e == dhclient.EventExpire {
+			return runHook(e, l)
+		}
+		if err := dhclient.SaveLease(path, l, time.Now()); err != nil {
+			log.Printf("Could not store lease of %s: %v", result.Interface.Attrs().Name, err)
+		}
+		// IPv6 addresses carry the lifetimes of the lease, which the
+		// kernel needs to learn even if nothing else changed.
+		if changed || result.Protocol == dhclient.NetIPv6 {
+			if err := l.Configure(); err != nil {
+				return err
+			}
+			writeEnv(l, result.Protocol)
+		}
+		return runHook(e, l)
+	})
+}
+
+// runHook runs the -hook program for event e of l, if there is one.
+func runHook(e dhclient.Event, l dhclient.Lease) error {
+	if *hook == "" {
+		return nil
+	}
+	return dhclient.RunHook(context.Background(), *hook, e, l)
 }
@@ -317,13 +317,21 @@ func (cmd *cmd) runSubCommand() error {
 		fmt.Fprint(cmd.Out, ipHelp)
 	}
 
-	switch c := cmd.findPrefix("address", "route", "link", "monitor", "neigh", "tunnel", "tuntap", "tap", "tcp_metrics", "tcpmetrics", "vrf", "xfrm", "help"); c {
+	c := cmd.findPrefix("address", "route", "rule", "link", "monitor", "neigh", "tunnel", "tuntap", "tap", "tcp_metrics", "tcpmetrics", "vrf", "xfrm", "help")
+	// As in iproute2, r is short for route rather than ambiguous.
+	if c == "" && cmd.currentToken() == "r" {
+		c = "route"
+	}
+
+	switch c {
 	case "address":
 		return cmd.address()
 	case "link":
 		return cmd.link()
 	case "route":
 		return cmd.route()
+	case "rule":
+		return cmd.rule()
 	case "neigh":
 		return cmd.neigh()
 	case "monitor":
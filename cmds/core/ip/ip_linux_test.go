@@ -184,6 +184,31 @@ func TestRunSubCommand(t *testing.T) {
 				Out:    new(bytes.Buffer),
 			},
 		},
+		{
+			name: "rule",
+			cmd: cmd{
+				Cursor: 0,
+				Args:   []string{"rule", "help"},
+				Out:    new(bytes.Buffer),
+			},
+		},
+		{
+			name: "rule invalid",
+			cmd: cmd{
+				Cursor: 0,
+				Args:   []string{"rule", "invalid"},
+				Out:    new(bytes.Buffer),
+			},
+			wantErr: true,
+		},
+		{
+			name: "r is route",
+			cmd: cmd{
+				Cursor: 0,
+				Args:   []string{"r", "help"},
+				Out:    new(bytes.Buffer),
+			},
+		},
 		{
 			name: "link invalid",
 			cmd: cmd{
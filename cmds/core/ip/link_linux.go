@@ -7,11 +7,14 @@ package main
 import (
 	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/vishvananda/netlink"
 )
 
 const linkHelp = `Usage: ip link add  [ name ] NAME
+		    [ link DEVICE ]
+		    [ master DEVICE ]
 		    [ txqueuelen PACKETS ]
 		    [ address LLADDR ]
 		    [ broadcast LLADDR ]
@@ -55,7 +58,21 @@ TYPE := { bareudp | bond |bridge | dummy |
           ip6gre | ip6gretap | ip6tnl | ipip |
           ipoib | ipvlan | ipvtap | macvlan |
           macvlan | sit | vlan | vrf |
-          vti | vxlan | xfrm }
+          vti | vxlan | wireguard | xfrm }
+
+ARGS of TYPE vlan := id VLANID [ protocol { 802.1q | 802.1ad } ]
+ARGS of TYPE macvlan := [ mode { private | vepa | bridge | passthru | source } ]
+ARGS of TYPE veth := [ peer [ name ] NAME [ address LLADDR ] ]
+ARGS of TYPE bond := [ mode BONDMODE ] [ miimon MIIMON ]
+		     [ updelay UPDELAY ] [ downdelay DOWNDELAY ]
+		     [ xmit_hash_policy { layer2 | layer2+3 | layer3+4 | encap2+3 | encap3+4 } ]
+		     [ lacp_rate { slow | fast } ] [ min_links MIN_LINKS ]
+ARGS of TYPE bridge := [ ageing_time AGEING_TIME ] [ hello_time HELLO_TIME ]
+		       [ vlan_filtering { 0 | 1 } ] [ mcast_snooping { 0 | 1 } ]
+ARGS of TYPE vrf := table TABLE
+
+BONDMODE := { balance-rr | active-backup | balance-xor | broadcast |
+              802.3ad | balance-tlb | balance-alb }
 
 `
 
@@ -309,68 +326,272 @@ func (cmd *cmd) linkAdd() error {
 		return err
 	}
 
+	link, err := cmd.parseLinkType(typeName, attrs)
+	if err != nil {
+		return err
+	}
+
+	return cmd.handle.LinkAdd(link)
+}
+
+// parseLinkType parses the ARGS of a link of type typeName and returns
+// the link to add.
+func (cmd *cmd) parseLinkType(typeName string, attrs netlink.LinkAttrs) (netlink.Link, error) {
+	var link netlink.Link
+
 	switch typeName {
 	case "dummy":
-		return cmd.handle.LinkAdd(&netlink.Dummy{LinkAttrs: attrs})
+		link = &netlink.Dummy{LinkAttrs: attrs}
 	case "ifb":
-		return cmd.handle.LinkAdd(&netlink.Ifb{LinkAttrs: attrs})
+		link = &netlink.Ifb{LinkAttrs: attrs}
 	case "vlan":
-		return cmd.handle.LinkAdd(&netlink.Vlan{LinkAttrs: attrs})
+		return cmd.parseVlan(attrs)
 	case "macvlan":
-		return cmd.handle.LinkAdd(&netlink.Macvlan{LinkAttrs: attrs})
+		return cmd.parseMacvlan(attrs)
 	case "veth":
-		return cmd.handle.LinkAdd(&netlink.Veth{LinkAttrs: attrs})
+		return cmd.parseVeth(attrs)
 	case "vxlan":
-		return cmd.handle.LinkAdd(&netlink.Vxlan{LinkAttrs: attrs})
+		link = &netlink.Vxlan{LinkAttrs: attrs}
 	case "ipvlan":
-		return cmd.handle.LinkAdd(&netlink.IPVlan{LinkAttrs: attrs})
+		link = &netlink.IPVlan{LinkAttrs: attrs}
 	case "ipvtap":
-		return cmd.handle.LinkAdd(&netlink.IPVtap{IPVlan: netlink.IPVlan{LinkAttrs: attrs}})
+		link = &netlink.IPVtap{IPVlan: netlink.IPVlan{LinkAttrs: attrs}}
 	case "bond":
-		return cmd.handle.LinkAdd(netlink.NewLinkBond(attrs))
+		return cmd.parseBond(attrs)
 	case "geneve":
-		return cmd.handle.LinkAdd(&netlink.Geneve{LinkAttrs: attrs})
+		link = &netlink.Geneve{LinkAttrs: attrs}
 	case "gretap":
-		return cmd.handle.LinkAdd(&netlink.Gretap{LinkAttrs: attrs})
+		link = &netlink.Gretap{LinkAttrs: attrs}
 	case "ipip":
-		return cmd.handle.LinkAdd(&netlink.Iptun{LinkAttrs: attrs})
+		link = &netlink.Iptun{LinkAttrs: attrs}
 	case "ip6tln":
-		return cmd.handle.LinkAdd(&netlink.Ip6tnl{LinkAttrs: attrs})
+		link = &netlink.Ip6tnl{LinkAttrs: attrs}
 	case "sit":
-		return cmd.handle.LinkAdd(&netlink.Sittun{LinkAttrs: attrs})
+		link = &netlink.Sittun{LinkAttrs: attrs}
 	case "vti":
-		return cmd.handle.LinkAdd(&netlink.Vti{LinkAttrs: attrs})
+		link = &netlink.Vti{LinkAttrs: attrs}
 	case "gre":
-		return cmd.handle.LinkAdd(&netlink.Gretun{LinkAttrs: attrs})
+		link = &netlink.Gretun{LinkAttrs: attrs}
 	case "vrf":
-		if cmd.nextToken("table") != "table" {
-			return cmd.usage()
+		if !cmd.tokenRemains() || cmd.nextToken("table") != "table" {
+			return nil, cmd.usage()
 		}
 		tableID, err := cmd.parseUint32("TABLE")
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		return cmd.handle.LinkAdd(&netlink.Vrf{LinkAttrs: attrs, Table: tableID})
+		link = &netlink.Vrf{LinkAttrs: attrs, Table: tableID}
 	case "bridge":
-		return cmd.handle.LinkAdd(&netlink.Bridge{LinkAttrs: attrs})
+		return cmd.parseBridge(attrs)
 	case "xfrm":
-		return cmd.handle.LinkAdd(&netlink.Xfrmi{LinkAttrs: attrs})
+		link = &netlink.Xfrmi{LinkAttrs: attrs}
 	case "ipoib":
-		return cmd.handle.LinkAdd(&netlink.IPoIB{LinkAttrs: attrs})
+		link = &netlink.IPoIB{LinkAttrs: attrs}
 	case "bareudp":
-		return cmd.handle.LinkAdd(&netlink.BareUDP{LinkAttrs: attrs})
+		link = &netlink.BareUDP{LinkAttrs: attrs}
+	case "wireguard":
+		link = &netlink.Wireguard{LinkAttrs: attrs}
 	default:
-		return fmt.Errorf("unsupported link type %s", typeName)
+		return nil, fmt.Errorf("unsupported link type %s", typeName)
+	}
+
+	if cmd.tokenRemains() {
+		cmd.nextToken()
+		return nil, cmd.usage()
+	}
+
+	return link, nil
+}
+
+func (cmd *cmd) parseVlan(attrs netlink.LinkAttrs) (netlink.Link, error) {
+	vlan := &netlink.Vlan{LinkAttrs: attrs, VlanId: -1}
+
+	for cmd.tokenRemains() {
+		switch cmd.nextToken("id", "protocol") {
+		case "id":
+			id, err := cmd.parseUint16("VLANID")
+			if err != nil || id > 4094 {
+				return nil, fmt.Errorf("invalid VLANID %q", cmd.currentToken())
+			}
+			vlan.VlanId = int(id)
+		case "protocol":
+			proto := netlink.StringToVlanProtocol(strings.ToLower(cmd.nextToken("802.1q", "802.1ad")))
+			if proto == netlink.VLAN_PROTOCOL_UNKNOWN {
+				return nil, cmd.usage()
+			}
+			vlan.VlanProtocol = proto
+		default:
+			return nil, cmd.usage()
+		}
+	}
+
+	if vlan.ParentIndex == 0 {
+		return nil, fmt.Errorf("vlan needs a link")
+	}
+	if vlan.VlanId < 0 {
+		return nil, fmt.Errorf("vlan needs an id")
+	}
+
+	return vlan, nil
+}
+
+var macvlanModes = map[string]netlink.MacvlanMode{
+	"private":  netlink.MACVLAN_MODE_PRIVATE,
+	"vepa":     netlink.MACVLAN_MODE_VEPA,
+	"bridge":   netlink.MACVLAN_MODE_BRIDGE,
+	"passthru": netlink.MACVLAN_MODE_PASSTHRU,
+	"source":   netlink.MACVLAN_MODE_SOURCE,
+}
+
+func (cmd *cmd) parseMacvlan(attrs netlink.LinkAttrs) (netlink.Link, error) {
+	macvlan := &netlink.Macvlan{LinkAttrs: attrs}
+
+	for cmd.tokenRemains() {
+		switch cmd.nextToken("mode") {
+		case "mode":
+			mode, ok := macvlanModes[cmd.nextToken("private", "vepa", "bridge", "passthru", "source")]
+			if !ok {
+				return nil, cmd.usage()
+			}
+			macvlan.Mode = mode
+		default:
+			return nil, cmd.usage()
+		}
+	}
+
+	if macvlan.ParentIndex == 0 {
+		return nil, fmt.Errorf("macvlan needs a link")
+	}
+
+	return macvlan, nil
+}
+
+func (cmd *cmd) parseVeth(attrs netlink.LinkAttrs) (netlink.Link, error) {
+	veth := &netlink.Veth{LinkAttrs: attrs}
+
+	if !cmd.tokenRemains() {
+		return veth, nil
+	}
+	if cmd.nextToken("peer") != "peer" {
+		return nil, cmd.usage()
+	}
+	veth.PeerName = cmd.parseName()
+
+	for cmd.tokenRemains() {
+		switch cmd.nextToken("address") {
+		case "address":
+			hwAddr, err := cmd.parseHardwareAddress()
+			if err != nil {
+				return nil, err
+			}
+			veth.PeerHardwareAddr = hwAddr
+		default:
+			return nil, cmd.usage()
+		}
+	}
+
+	return veth, nil
+}
+
+func (cmd *cmd) parseBond(attrs netlink.LinkAttrs) (netlink.Link, error) {
+	bond := netlink.NewLinkBond(attrs)
+
+	for cmd.tokenRemains() {
+		var err error
+
+		switch cmd.nextToken("mode", "miimon", "updelay", "downdelay", "xmit_hash_policy", "lacp_rate", "min_links") {
+		case "mode":
+			bond.Mode = netlink.StringToBondMode(cmd.nextToken("balance-rr", "active-backup", "balance-xor", "broadcast", "802.3ad", "balance-tlb", "balance-alb"))
+			if bond.Mode == netlink.BOND_MODE_UNKNOWN {
+				return nil, cmd.usage()
+			}
+		case "miimon":
+			bond.Miimon, err = cmd.parseInt("MIIMON")
+		case "updelay":
+			bond.UpDelay, err = cmd.parseInt("UPDELAY")
+		case "downdelay":
+			bond.DownDelay, err = cmd.parseInt("DOWNDELAY")
+		case "xmit_hash_policy":
+			bond.XmitHashPolicy = netlink.StringToBondXmitHashPolicy(cmd.nextToken("layer2", "layer2+3", "layer3+4", "encap2+3", "encap3+4"))
+			if bond.XmitHashPolicy == netlink.BOND_XMIT_HASH_POLICY_UNKNOWN {
+				return nil, cmd.usage()
+			}
+		case "lacp_rate":
+			bond.LacpRate = netlink.StringToBondLacpRate(cmd.nextToken("slow", "fast"))
+			if bond.LacpRate == netlink.BOND_LACP_RATE_UNKNOWN {
+				return nil, cmd.usage()
+			}
+		case "min_links":
+			bond.MinLinks, err = cmd.parseInt("MIN_LINKS")
+		default:
+			return nil, cmd.usage()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return bond, nil
+}
+
+func (cmd *cmd) parseBridge(attrs netlink.LinkAttrs) (netlink.Link, error) {
+	bridge := &netlink.Bridge{LinkAttrs: attrs}
+
+	for cmd.tokenRemains() {
+		switch cmd.nextToken("ageing_time", "hello_time", "vlan_filtering", "mcast_snooping") {
+		case "ageing_time":
+			v, err := cmd.parseUint32("AGEING_TIME")
+			if err != nil {
+				return nil, err
+			}
+			bridge.AgeingTime = &v
+		case "hello_time":
+			v, err := cmd.parseUint32("HELLO_TIME")
+			if err != nil {
+				return nil, err
+			}
+			bridge.HelloTime = &v
+		case "vlan_filtering":
+			v, err := cmd.parseBool("1", "0")
+			if err != nil {
+				return nil, err
+			}
+			bridge.VlanFiltering = &v
+		case "mcast_snooping":
+			v, err := cmd.parseBool("1", "0")
+			if err != nil {
+				return nil, err
+			}
+			bridge.MulticastSnooping = &v
+		default:
+			return nil, cmd.usage()
+		}
 	}
+
+	return bridge, nil
 }
 
 func (cmd *cmd) parseLinkAttrs() (string, netlink.LinkAttrs, error) {
 	typeName := ""
-	attrs := netlink.LinkAttrs{Name: cmd.parseName()}
+	attrs := netlink.LinkAttrs{}
 
 	for cmd.tokenRemains() {
-		switch cmd.nextToken("type", "txqueuelen", "txqlen", "address", "mtu", "index", "numtxqueues", "numrxqueues") {
+		switch cmd.nextToken("name", "type", "link", "master", "txqueuelen", "txqlen", "address", "mtu", "index", "numtxqueues", "numrxqueues") {
+		case "name":
+			attrs.Name = cmd.nextToken("device-name")
+		case "link":
+			parent, err := netlink.LinkByName(cmd.nextToken("device name"))
+			if err != nil {
+				return "", netlink.LinkAttrs{}, err
+			}
+			attrs.ParentIndex = parent.Attrs().Index
+		case "master":
+			master, err := netlink.LinkByName(cmd.nextToken("device name"))
+			if err != nil {
+				return "", netlink.LinkAttrs{}, err
+			}
+			attrs.MasterIndex = master.Attrs().Index
 		case "txqueuelen", "txqlen":
 			qlen, err := cmd.parseInt("PACKETS")
 			if err != nil {
@@ -412,7 +633,16 @@ func (cmd *cmd) parseLinkAttrs() (string, netlink.LinkAttrs, error) {
 		case "type":
 			typeName = cmd.nextToken("TYPE")
 		default:
-			return "", netlink.LinkAttrs{}, cmd.usage()
+			if typeName == "" && attrs.Name == "" {
+				attrs.Name = cmd.currentToken()
+				continue
+			}
+			if typeName == "" {
+				return "", netlink.LinkAttrs{}, cmd.usage()
+			}
+			// The ARGS of the type follow.
+			cmd.Cursor--
+			return typeName, attrs, nil
 		}
 	}
 
@@ -155,6 +155,50 @@ func TestParseLinkAttrs(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "link before name",
+			cmd: cmd{
+				Cursor: 2,
+				Args:   []string{"ip", "link", "add", "link", "lo", "lo.10", "type", "vlan", "id", "10"},
+				Out:    new(bytes.Buffer),
+			},
+			wantType: "vlan",
+			wantAttrs: netlink.LinkAttrs{
+				Name:        "lo.10",
+				ParentIndex: 1,
+			},
+		},
+		{
+			name: "master",
+			cmd: cmd{
+				Cursor: 2,
+				Args:   []string{"ip", "link", "add", "dummy0", "master", "lo", "type", "dummy"},
+				Out:    new(bytes.Buffer),
+			},
+			wantType: "dummy",
+			wantAttrs: netlink.LinkAttrs{
+				Name:        "dummy0",
+				MasterIndex: 1,
+			},
+		},
+		{
+			name: "link not found",
+			cmd: cmd{
+				Cursor: 2,
+				Args:   []string{"ip", "link", "add", "link", "nonexistent0", "vlan0", "type", "vlan", "id", "10"},
+				Out:    new(bytes.Buffer),
+			},
+			wantErr: true,
+		},
+		{
+			name: "no type",
+			cmd: cmd{
+				Cursor: 2,
+				Args:   []string{"ip", "link", "add", "name", "eth0", "mtu", "1500"},
+				Out:    new(bytes.Buffer),
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -174,3 +218,186 @@ func TestParseLinkAttrs(t *testing.T) {
 		})
 	}
 }
+
+func TestParseLinkType(t *testing.T) {
+	attrs := netlink.LinkAttrs{Name: "link0", ParentIndex: 1}
+	u32 := func(v uint32) *uint32 { return &v }
+	on := true
+
+	bond := netlink.NewLinkBond(attrs)
+	bond.Mode = netlink.BOND_MODE_802_3AD
+	bond.Miimon = 100
+	bond.UpDelay = 200
+	bond.DownDelay = 300
+	bond.XmitHashPolicy = netlink.BOND_XMIT_HASH_POLICY_LAYER3_4
+	bond.LacpRate = netlink.BOND_LACP_RATE_FAST
+	bond.MinLinks = 1
+
+	tests := []struct {
+		name     string
+		typeName string
+		attrs    netlink.LinkAttrs
+		args     []string
+		want     netlink.Link
+		wantErr  bool
+	}{
+		{
+			name:     "dummy",
+			typeName: "dummy",
+			attrs:    attrs,
+			want:     &netlink.Dummy{LinkAttrs: attrs},
+		},
+		{
+			name:     "dummy with args",
+			typeName: "dummy",
+			attrs:    attrs,
+			args:     []string{"id", "10"},
+			wantErr:  true,
+		},
+		{
+			name:     "vlan",
+			typeName: "vlan",
+			attrs:    attrs,
+			args:     []string{"id", "10", "protocol", "802.1ad"},
+			want:     &netlink.Vlan{LinkAttrs: attrs, VlanId: 10, VlanProtocol: netlink.VLAN_PROTOCOL_8021AD},
+		},
+		{
+			name:     "vlan without id",
+			typeName: "vlan",
+			attrs:    attrs,
+			wantErr:  true,
+		},
+		{
+			name:     "vlan without link",
+			typeName: "vlan",
+			attrs:    netlink.LinkAttrs{Name: "link0"},
+			args:     []string{"id", "10"},
+			wantErr:  true,
+		},
+		{
+			name:     "vlan invalid id",
+			typeName: "vlan",
+			attrs:    attrs,
+			args:     []string{"id", "4095"},
+			wantErr:  true,
+		},
+		{
+			name:     "vlan invalid protocol",
+			typeName: "vlan",
+			attrs:    attrs,
+			args:     []string{"id", "10", "protocol", "802.1x"},
+			wantErr:  true,
+		},
+		{
+			name:     "macvlan",
+			typeName: "macvlan",
+			attrs:    attrs,
+			args:     []string{"mode", "bridge"},
+			want:     &netlink.Macvlan{LinkAttrs: attrs, Mode: netlink.MACVLAN_MODE_BRIDGE},
+		},
+		{
+			name:     "macvlan invalid mode",
+			typeName: "macvlan",
+			attrs:    attrs,
+			args:     []string{"mode", "hub"},
+			wantErr:  true,
+		},
+		{
+			name:     "veth",
+			typeName: "veth",
+			attrs:    attrs,
+			args:     []string{"peer", "name", "link1", "address", "02:00:00:00:00:01"},
+			want:     &netlink.Veth{LinkAttrs: attrs, PeerName: "link1", PeerHardwareAddr: net.HardwareAddr{2, 0, 0, 0, 0, 1}},
+		},
+		{
+			name:     "veth without peer",
+			typeName: "veth",
+			attrs:    attrs,
+			want:     &netlink.Veth{LinkAttrs: attrs},
+		},
+		{
+			name:     "veth invalid",
+			typeName: "veth",
+			attrs:    attrs,
+			args:     []string{"name", "link1"},
+			wantErr:  true,
+		},
+		{
+			name:     "bond",
+			typeName: "bond",
+			attrs:    attrs,
+			args:     []string{"mode", "802.3ad", "miimon", "100", "updelay", "200", "downdelay", "300", "xmit_hash_policy", "layer3+4", "lacp_rate", "fast", "min_links", "1"},
+			want:     bond,
+		},
+		{
+			name:     "bond invalid mode",
+			typeName: "bond",
+			attrs:    attrs,
+			args:     []string{"mode", "round-robin"},
+			wantErr:  true,
+		},
+		{
+			name:     "bond invalid miimon",
+			typeName: "bond",
+			attrs:    attrs,
+			args:     []string{"miimon", "often"},
+			wantErr:  true,
+		},
+		{
+			name:     "bridge",
+			typeName: "bridge",
+			attrs:    attrs,
+			args:     []string{"ageing_time", "30000", "hello_time", "200", "vlan_filtering", "1", "mcast_snooping", "1"},
+			want:     &netlink.Bridge{LinkAttrs: attrs, AgeingTime: u32(30000), HelloTime: u32(200), VlanFiltering: &on, MulticastSnooping: &on},
+		},
+		{
+			name:     "bridge invalid",
+			typeName: "bridge",
+			attrs:    attrs,
+			args:     []string{"vlan_filtering", "yes"},
+			wantErr:  true,
+		},
+		{
+			name:     "vrf",
+			typeName: "vrf",
+			attrs:    attrs,
+			args:     []string{"table", "10"},
+			want:     &netlink.Vrf{LinkAttrs: attrs, Table: 10},
+		},
+		{
+			name:     "vrf without table",
+			typeName: "vrf",
+			attrs:    attrs,
+			wantErr:  true,
+		},
+		{
+			name:     "wireguard",
+			typeName: "wireguard",
+			attrs:    attrs,
+			want:     &netlink.Wireguard{LinkAttrs: attrs},
+		},
+		{
+			name:     "unsupported",
+			typeName: "hub",
+			attrs:    attrs,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := cmd{
+				Cursor: 0,
+				Args:   append([]string{tt.typeName}, tt.args...),
+				Out:    new(bytes.Buffer),
+			}
+			got, err := cmd.parseLinkType(tt.typeName, tt.attrs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseLinkType() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("parseLinkType() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
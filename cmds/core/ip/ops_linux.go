@@ -144,8 +144,19 @@ func (cmd *cmd) showLinks(addresses [][]netlink.Addr, links []netlink.Link, filt
 		if cmd.Opts.Details {
 			switch v := v.(type) {
 			case *netlink.Bridge:
+				var helloTime, ageingTime uint32
+				var vlanFiltering int
+				if v.HelloTime != nil {
+					helloTime = *v.HelloTime
+				}
+				if v.AgeingTime != nil {
+					ageingTime = *v.AgeingTime
+				}
+				if v.VlanFiltering != nil && *v.VlanFiltering {
+					vlanFiltering = 1
+				}
 				fmt.Fprintf(cmd.Out, "    bridge hello_time %d ageing_time %d vlan_filtering %d numtxqueues %d numrxqueues %d gso_max_size %d gso_max_segs %d\n",
-					v.HelloTime, v.AgeingTime, v.VlanFiltering, v.NumTxQueues, v.NumRxQueues, v.GSOMaxSize, v.GSOMaxSegs)
+					helloTime, ageingTime, vlanFiltering, v.NumTxQueues, v.NumRxQueues, v.GSOMaxSize, v.GSOMaxSegs)
 			case *netlink.Vlan:
 				fmt.Fprintf(cmd.Out, "    vlan %s vlan-id %d numtxqueues %d numrxqueues %d gso_max_size %d gso_max_segs %d\n",
 					v.VlanProtocol, v.VlanId, v.NumTxQueues, v.NumRxQueues, v.GSOMaxSize, v.GSOMaxSegs)
@@ -5,8 +5,12 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
 	"net"
+	"os"
+	"strconv"
+	"strings"
 
 	"github.com/vishvananda/netlink"
 	"golang.org/x/sys/unix"
@@ -25,11 +29,11 @@ SELECTOR := [ root PREFIX ] [ match PREFIX ] [ exact PREFIX ]
             [ table TABLE_ID ] [ proto RTPROTO ]
             [ type TYPE ] [ scope SCOPE ]
 ROUTE := NODE_SPEC [ INFO_SPEC ]
-NODE_SPEC := [ TYPE ] PREFIX [ tos TOS ]
+NODE_SPEC := [ TYPE ] { PREFIX | default } [ tos TOS ]
              [ table TABLE_ID ] [ proto RTPROTO ]
              [ scope SCOPE ] [ metric METRIC ] OPTIONS
 INFO_SPEC := [ nexthop NH ]...
-NH := [ via ADDRESS ]
+NH := [ via ADDRESS ] [ dev STRING ]
 FAMILY := [ inet | inet6 | mpls | bridge | link ]
 OPTIONS := FLAGS [ mtu NUMBER ] [ advmss NUMBER ]
            [ rtt TIME ] [ rttvar TIME ] [ reordering NUMBER ]
@@ -40,7 +44,7 @@ OPTIONS := FLAGS [ mtu NUMBER ] [ advmss NUMBER ]
 		   [ fastopen_no_cookie BOOL ]
 TYPE := { unicast | local | broadcast | multicast | throw |
           unreachable | prohibit | blackhole | nat }
-TABLE_ID := [ local | main | default | all | NUMBER ]
+TABLE_ID := [ local | main | default | all | NAME | NUMBER ]
 SCOPE := [ host | link | global | NUMBER ]
 BOOL := [1|0]
 OPTIONS := OPTION [ OPTIONS ]
@@ -77,109 +81,79 @@ func routeTypeToString(routeType int) string {
 	return "unknown"
 }
 
-func (cmd *cmd) routeAdddefault() error {
-	nh, nhval, err := cmd.parseNextHop()
-	if err != nil {
-		return err
-	}
-	// TODO: NHFLAGS.
-	l, err := cmd.parseDeviceName(true)
+// parseRoute parses a route, and looks up the device it is through, if
+// one is given.
+func (cmd *cmd) parseRoute() (*netlink.Route, error) {
+	ns := cmd.nextToken("default", "CIDR")
+	route, d, err := cmd.parseRouteAddAppendReplaceDel(ns)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	switch nh {
-	case "via":
-		fmt.Fprintf(cmd.Out, "Add default route %v via %v", nhval, l.Attrs().Name)
-		r := &netlink.Route{LinkIndex: l.Attrs().Index, Gw: nhval}
-		if err := cmd.handle.RouteAdd(r); err != nil {
-			return fmt.Errorf("error adding default route to %v: %v", l.Attrs().Name, err)
-		}
-		return nil
-	}
-	return cmd.usage()
-}
-
-func (cmd *cmd) routeAdd() error {
-	ns := cmd.nextToken("default", "CIDR")
-	switch ns {
-	case "default":
-		return cmd.routeAdddefault()
-	default:
-		route, d, err := cmd.parseRouteAddAppendReplaceDel(ns)
-		if err != nil {
-			return err
-		}
 
+	if d != "" {
 		link, err := netlink.LinkByName(d)
 		if err != nil {
-			return fmt.Errorf("error getting link %s: %v", d, err)
+			return nil, fmt.Errorf("error getting link %s: %v", d, err)
 		}
 
 		route.LinkIndex = link.Attrs().Index
+	}
 
-		if err := cmd.handle.RouteAdd(route); err != nil {
-			return fmt.Errorf("error adding route %s -> %s: %v", route.Dst.IP, d, err)
-		}
-		return nil
+	return route, nil
+}
+
+// routeDst describes the destination of a route in errors.
+func routeDst(route *netlink.Route) string {
+	if route.Dst == nil {
+		return "default"
 	}
+	return route.Dst.String()
 }
 
-func (cmd *cmd) routeAppend() error {
-	ns := cmd.nextToken("default", "CIDR")
-	route, d, err := cmd.parseRouteAddAppendReplaceDel(ns)
+func (cmd *cmd) routeAdd() error {
+	route, err := cmd.parseRoute()
 	if err != nil {
 		return err
 	}
 
-	link, err := netlink.LinkByName(d)
-	if err != nil {
-		return fmt.Errorf("error getting link %s: %v", d, err)
+	if err := cmd.handle.RouteAdd(route); err != nil {
+		return fmt.Errorf("error adding route %s: %v", routeDst(route), err)
 	}
+	return nil
+}
 
-	route.LinkIndex = link.Attrs().Index
+func (cmd *cmd) routeAppend() error {
+	route, err := cmd.parseRoute()
+	if err != nil {
+		return err
+	}
 
 	if err := cmd.handle.RouteAppend(route); err != nil {
-		return fmt.Errorf("error appending route %s -> %s: %v", route.Dst.IP, d, err)
+		return fmt.Errorf("error appending route %s: %v", routeDst(route), err)
 	}
 	return nil
 }
 
 func (cmd *cmd) routeReplace() error {
-	ns := cmd.nextToken("default", "CIDR")
-	route, d, err := cmd.parseRouteAddAppendReplaceDel(ns)
+	route, err := cmd.parseRoute()
 	if err != nil {
 		return err
 	}
 
-	link, err := netlink.LinkByName(d)
-	if err != nil {
-		return fmt.Errorf("error getting link %s: %v", d, err)
-	}
-
-	route.LinkIndex = link.Attrs().Index
-
 	if err := cmd.handle.RouteReplace(route); err != nil {
-		return fmt.Errorf("error appending route %s -> %s: %v", route.Dst.IP, d, err)
+		return fmt.Errorf("error replacing route %s: %v", routeDst(route), err)
 	}
 	return nil
 }
 
 func (cmd *cmd) routeDel() error {
-	ns := cmd.nextToken("default", "CIDR")
-	route, d, err := cmd.parseRouteAddAppendReplaceDel(ns)
+	route, err := cmd.parseRoute()
 	if err != nil {
 		return err
 	}
 
-	link, err := netlink.LinkByName(d)
-	if err != nil {
-		return fmt.Errorf("error getting link %s: %v", d, err)
-	}
-
-	route.LinkIndex = link.Attrs().Index
-
 	if err := cmd.handle.RouteDel(route); err != nil {
-		return fmt.Errorf("error deleting route %s -> %s: %v", route.Dst.IP, d, err)
+		return fmt.Errorf("error deleting route %s: %v", routeDst(route), err)
 	}
 	return nil
 }
@@ -189,18 +163,39 @@ func (cmd *cmd) parseRouteAddAppendReplaceDel(ns string) (*netlink.Route, string
 
 	route := &netlink.Route{}
 
-	_, route.Dst, err = net.ParseCIDR(ns)
-	if err != nil {
+	// A default route has no destination, and is of the family of its
+	// gateway unless one is chosen.
+	if ns == "default" {
+		if cmd.Family == netlink.FAMILY_V6 {
+			route.Family = netlink.FAMILY_V6
+		}
+	} else if _, route.Dst, err = net.ParseCIDR(ns); err != nil {
 		return nil, "", err
 	}
 
-	d := cmd.nextToken("dev", "device-name")
-	if d == "dev" {
-		d = cmd.nextToken("device-name")
+	var d string
+
+	// The device may be given first without the dev keyword.
+	if cmd.tokenRemains() {
+		switch t := cmd.peekToken("via", "dev", "device-name"); t {
+		case "via", "dev", "type", "tos", "table", "proto", "scope", "metric", "mtu", "advmss", "rtt", "rttvar", "reordering", "window", "cwnd", "initcwnd", "ssthresh", "realms", "src", "rto_min", "hoplimit", "initrwnd", "congctl", "features", "quickack", "fastopen_no_cookie":
+		default:
+			d = cmd.nextToken("device-name")
+		}
 	}
 
 	for cmd.tokenRemains() {
-		switch cmd.nextToken("type", "tos", "table", "proto", "scope", "metric", "mtu", "advmss", "rtt", "rttvar", "reordering", "window", "cwnd", "initcwnd", "ssthresh", "realms", "src", "rto_min", "hoplimit", "initrwnd", "congctl", "features", "quickack", "fastopen_no_cookie") {
+		switch cmd.nextToken("via", "dev", "type", "tos", "table", "proto", "scope", "metric", "mtu", "advmss", "rtt", "rttvar", "reordering", "window", "cwnd", "initcwnd", "ssthresh", "realms", "src", "rto_min", "hoplimit", "initrwnd", "congctl", "features", "quickack", "fastopen_no_cookie") {
+		case "via":
+			token := cmd.nextToken("ADDRESS")
+			route.Gw = net.ParseIP(token)
+			if route.Gw == nil {
+				return nil, "", fmt.Errorf("failed to parse gateway IP: %v", token)
+			}
+
+		case "dev":
+			d = cmd.nextToken("device-name")
+
 		case "tos":
 			route.Tos, err = cmd.parseInt("TOS")
 			if err != nil {
@@ -208,7 +203,7 @@ func (cmd *cmd) parseRouteAddAppendReplaceDel(ns string) (*netlink.Route, string
 			}
 
 		case "table":
-			route.Table, err = cmd.parseInt("TABLE_ID")
+			route.Table, err = parseTableID(cmd.nextToken("TABLE_ID"))
 			if err != nil {
 				return nil, "", err
 			}
@@ -400,7 +395,7 @@ func (cmd *cmd) parseRouteShowListFlush() (*netlink.Route, uint64, *net.IPNet, *
 
 		case "table":
 			filterMask |= netlink.RT_FILTER_TABLE
-			table, err := cmd.parseInt("TABLE_ID")
+			table, err := parseTableID(cmd.nextToken("TABLE_ID"))
 			if err != nil {
 				return nil, 0, nil, nil, nil, err
 			}
@@ -606,11 +601,91 @@ var rtProto = map[int]string{
 	unix.RTPROT_ZEBRA:    "zebra",
 }
 
+// rtTablesPath names the routing tables besides the reserved ones, a table
+// ID and name per line.
+var rtTablesPath = "/etc/iproute2/rt_tables"
+
+var reservedTables = []struct {
+	name string
+	id   int
+}{
+	{"local", unix.RT_TABLE_LOCAL},
+	{"main", unix.RT_TABLE_MAIN},
+	{"default", unix.RT_TABLE_DEFAULT},
+	{"all", unix.RT_TABLE_UNSPEC},
+}
+
+// routeTables returns the names of the routing tables, the reserved ones first.
+func routeTables() []struct {
+	name string
+	id   int
+} {
+	tables := reservedTables
+	f, err := os.Open(rtTablesPath)
+	if err != nil {
+		return tables
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line, _, _ := strings.Cut(s.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		id, err := strconv.ParseUint(fields[0], 0, 32)
+		if err != nil {
+			continue
+		}
+		tables = append(tables, struct {
+			name string
+			id   int
+		}{fields[1], int(id)})
+	}
+	return tables
+}
+
+// parseTableID parses a routing table, by name or ID.
+func parseTableID(token string) (int, error) {
+	for _, t := range routeTables() {
+		if t.name == token {
+			return t.id, nil
+		}
+	}
+	id, err := strconv.ParseUint(token, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid table ID %q", token)
+	}
+	return int(id), nil
+}
+
+// tableName returns the name of a routing table, or its ID if it has none
+// or names are not wanted.
+func (cmd *cmd) tableName(id int) string {
+	if !cmd.Opts.Numeric {
+		for _, t := range routeTables() {
+			if t.id == id {
+				return t.name
+			}
+		}
+	}
+	return strconv.Itoa(id)
+}
+
+// table returns where routes not in the main table are shown to be.
+func (cmd *cmd) table(r netlink.Route) string {
+	if r.Table == 0 || r.Table == unix.RT_TABLE_MAIN {
+		return ""
+	}
+	return " table " + cmd.tableName(r.Table)
+}
+
 const (
-	defaultFmt   = "%vdefault via %v dev %s proto %s metric %d\n"
-	routeFmt     = "%v%v dev %s proto %s scope %s src %s metric %d\n"
-	route6Fmt    = "%v%s dev %s proto %s metric %d\n"
-	routeVia6Fmt = "%v%s via %s dev %s proto %s metric %d\n"
+	defaultFmt   = "%vdefault via %v dev %s%s proto %s metric %d\n"
+	routeFmt     = "%v%v dev %s%s proto %s scope %s src %s metric %d\n"
+	route6Fmt    = "%v%s dev %s%s proto %s metric %d\n"
+	routeVia6Fmt = "%v%s via %s dev %s%s proto %s metric %d\n"
 )
 
 func (cmd *cmd) defaultRoute(r netlink.Route, name string) {
@@ -632,7 +707,7 @@ func (cmd *cmd) defaultRoute(r netlink.Route, name string) {
 		detail = routeTypeToString(r.Type) + " "
 	}
 
-	fmt.Fprintf(cmd.Out, defaultFmt, detail, gw, name, proto, metric)
+	fmt.Fprintf(cmd.Out, defaultFmt, detail, gw, name, cmd.table(r), proto, metric)
 }
 
 func (cmd *cmd) showRoute(r netlink.Route, name string) {
@@ -676,7 +751,7 @@ func (cmd *cmd) printIPv4Route(r netlink.Route, name string) {
 		detail = routeTypeToString(r.Type) + " "
 	}
 
-	fmt.Fprintf(cmd.Out, routeFmt, detail, dest, name, proto, scope, src, metric)
+	fmt.Fprintf(cmd.Out, routeFmt, detail, dest, name, cmd.table(r), proto, scope, src, metric)
 }
 
 func (cmd *cmd) printIPv6Route(r netlink.Route, name string) {
@@ -700,9 +775,9 @@ func (cmd *cmd) printIPv6Route(r netlink.Route, name string) {
 
 	if r.Gw != nil {
 		gw := r.Gw
-		fmt.Fprintf(cmd.Out, routeVia6Fmt, detail, dest, gw, name, proto, metric)
+		fmt.Fprintf(cmd.Out, routeVia6Fmt, detail, dest, gw, name, cmd.table(r), proto, metric)
 	} else {
-		fmt.Fprintf(cmd.Out, route6Fmt, detail, dest, name, proto, metric)
+		fmt.Fprintf(cmd.Out, route6Fmt, detail, dest, name, cmd.table(r), proto, metric)
 	}
 }
 
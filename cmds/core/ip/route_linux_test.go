@@ -257,6 +257,43 @@ func TestParseRouteAddAppendReplaceDel(t *testing.T) {
 			args:    []string{"dev", "lo", "table", "ac"},
 			wantErr: true,
 		},
+		{
+			name:         "via in a named table",
+			addr:         "192.0.0.2/24",
+			args:         []string{"via", "192.0.0.1", "table", "local", "dev", "lo"},
+			expectedLink: "lo",
+			expected: netlink.Route{
+				Dst:   dst,
+				Gw:    net.ParseIP("192.0.0.1"),
+				Table: unix.RT_TABLE_LOCAL,
+			},
+		},
+		{
+			name:         "default without device",
+			addr:         "default",
+			args:         []string{"via", "192.0.0.1", "table", "100"},
+			expectedLink: "",
+			expected: netlink.Route{
+				Gw:    net.ParseIP("192.0.0.1"),
+				Table: 100,
+			},
+		},
+		{
+			name:         "device without dev",
+			addr:         "192.0.0.2/24",
+			args:         []string{"lo", "metric", "5"},
+			expectedLink: "lo",
+			expected: netlink.Route{
+				Dst:      dst,
+				Priority: 5,
+			},
+		},
+		{
+			name:    "via invalid",
+			addr:    "default",
+			args:    []string{"via", "gateway"},
+			wantErr: true,
+		},
 		{
 			name:    "tos invalid",
 			addr:    "192.0.0.2/24",
@@ -608,6 +645,22 @@ func TestShowRoute(t *testing.T) {
 			linkName: "eth0",
 			expected: "192.0.0.0/24 dev eth0 proto redirect scope host src 127.0.0.1 metric 0\n",
 		},
+		{
+			name: "IPv4 route in another table",
+			cmd: cmd{
+				Family: netlink.FAMILY_V4,
+				Out:    new(bytes.Buffer),
+			},
+			route: netlink.Route{
+				Dst:      dst,
+				Protocol: 1,
+				Scope:    netlink.SCOPE_LINK,
+				Src:      net.IPv4(192, 0, 0, 4),
+				Table:    100,
+			},
+			linkName: "eth1",
+			expected: "192.0.0.0/24 dev eth1 table 100 proto redirect scope link src 192.0.0.4 metric 0\n",
+		},
 		{
 			name: "IPv4 route with FAMILY_V6",
 			cmd: cmd{
@@ -0,0 +1,396 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const ruleHelp = `Usage: ip rule { add | del } SELECTOR ACTION
+       ip rule { flush | show | list } [ SELECTOR ]
+       ip rule help
+SELECTOR := [ not ] [ from PREFIX ] [ to PREFIX ] [ tos TOS ]
+            [ fwmark FWMARK[/MASK] ] [ iif STRING ] [ oif STRING ]
+            [ pref NUMBER ] [ ipproto PROTOCOL ]
+            [ sport [ NUMBER | NUMBER-NUMBER ] ]
+            [ dport [ NUMBER | NUMBER-NUMBER ] ]
+ACTION := [ table TABLE_ID ] [ goto NUMBER ]
+          [ suppress_prefixlength NUMBER ] [ suppress_ifgroup GROUP ]
+TABLE_ID := [ local | main | default | NUMBER ]
+`
+
+var ipProtos = map[string]int{
+	"icmp":   unix.IPPROTO_ICMP,
+	"tcp":    unix.IPPROTO_TCP,
+	"udp":    unix.IPPROTO_UDP,
+	"ipv6":   unix.IPPROTO_IPV6,
+	"gre":    unix.IPPROTO_GRE,
+	"esp":    unix.IPPROTO_ESP,
+	"icmpv6": unix.IPPROTO_ICMPV6,
+	"sctp":   unix.IPPROTO_SCTP,
+}
+
+func ipProtoName(proto int) string {
+	for name, p := range ipProtos {
+		if p == proto {
+			return name
+		}
+	}
+	return strconv.Itoa(proto)
+}
+
+// ruleFamily is the family of rules to work on: ip rule defaults to IPv4.
+func (cmd *cmd) ruleFamily() int {
+	if cmd.Family == netlink.FAMILY_ALL {
+		return netlink.FAMILY_V4
+	}
+	return cmd.Family
+}
+
+func (cmd *cmd) parseRulePrefix() (*net.IPNet, error) {
+	token := cmd.nextToken("PREFIX", "all")
+	if token == "all" {
+		return nil, nil
+	}
+	if !strings.Contains(token, "/") {
+		ip := net.ParseIP(token)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid prefix %q", token)
+		}
+		bits := 128
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, prefix, err := net.ParseCIDR(token)
+	if err != nil {
+		return nil, err
+	}
+	return prefix, nil
+}
+
+func (cmd *cmd) parsePortRange() (*netlink.RulePortRange, error) {
+	token := cmd.nextToken("NUMBER", "NUMBER-NUMBER")
+	first, last, isRange := strings.Cut(token, "-")
+	start, err := strconv.ParseUint(first, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", token)
+	}
+	end := start
+	if isRange {
+		if end, err = strconv.ParseUint(last, 10, 16); err != nil || end < start {
+			return nil, fmt.Errorf("invalid port range %q", token)
+		}
+	}
+	return netlink.NewRulePortRange(uint16(start), uint16(end)), nil
+}
+
+// parseRule parses a rule, and returns it with the mask of the fields
+// given for use as a filter.
+func (cmd *cmd) parseRule() (*netlink.Rule, uint64, error) {
+	rule := netlink.NewRule()
+	rule.Family = cmd.ruleFamily()
+
+	var filterMask uint64
+
+	for cmd.tokenRemains() {
+		switch c := cmd.nextToken("not", "from", "to", "tos", "fwmark", "iif", "oif", "pref", "ipproto", "sport", "dport", "table", "goto", "suppress_prefixlength", "suppress_ifgroup"); c {
+		case "not":
+			rule.Invert = true
+		case "from", "to":
+			prefix, err := cmd.parseRulePrefix()
+			if err != nil {
+				return nil, 0, err
+			}
+			if prefix == nil {
+				continue
+			}
+			if prefix.IP.To4() != nil {
+				rule.Family = netlink.FAMILY_V4
+			} else {
+				rule.Family = netlink.FAMILY_V6
+			}
+			if c == "from" {
+				rule.Src = prefix
+				filterMask |= netlink.RT_FILTER_SRC
+			} else {
+				rule.Dst = prefix
+				filterMask |= netlink.RT_FILTER_DST
+			}
+		case "tos", "dsfield":
+			tos, err := strconv.ParseUint(cmd.nextToken("TOS"), 0, 8)
+			if err != nil {
+				return nil, 0, fmt.Errorf("invalid TOS: %v", err)
+			}
+			rule.Tos = uint(tos)
+			filterMask |= netlink.RT_FILTER_TOS
+		case "fwmark":
+			token := cmd.nextToken("FWMARK[/MASK]")
+			mark, mask, hasMask := strings.Cut(token, "/")
+			v, err := strconv.ParseUint(mark, 0, 32)
+			if err != nil {
+				return nil, 0, fmt.Errorf("invalid fwmark %q", token)
+			}
+			rule.Mark = int(v)
+			filterMask |= netlink.RT_FILTER_MARK
+			if hasMask {
+				m, err := strconv.ParseUint(mask, 0, 32)
+				if err != nil {
+					return nil, 0, fmt.Errorf("invalid fwmark mask %q", token)
+				}
+				rule.Mask = int(m)
+				filterMask |= netlink.RT_FILTER_MASK
+			}
+		case "iif":
+			rule.IifName = cmd.nextToken("STRING")
+		case "oif":
+			rule.OifName = cmd.nextToken("STRING")
+		case "pref", "preference", "priority":
+			pref, err := cmd.parseUint32("NUMBER")
+			if err != nil {
+				return nil, 0, err
+			}
+			rule.Priority = int(pref)
+			filterMask |= netlink.RT_FILTER_PRIORITY
+		case "ipproto":
+			token := cmd.nextToken("PROTOCOL")
+			proto, ok := ipProtos[token]
+			if !ok {
+				p, err := strconv.ParseUint(token, 10, 8)
+				if err != nil {
+					return nil, 0, fmt.Errorf("invalid ipproto %q", token)
+				}
+				proto = int(p)
+			}
+			rule.IPProto = proto
+		case "sport", "dport":
+			ports, err := cmd.parsePortRange()
+			if err != nil {
+				return nil, 0, err
+			}
+			if c == "sport" {
+				rule.Sport = ports
+			} else {
+				rule.Dport = ports
+			}
+		case "table", "lookup":
+			table, err := parseTableID(cmd.nextToken("TABLE_ID"))
+			if err != nil {
+				return nil, 0, err
+			}
+			rule.Table = table
+			filterMask |= netlink.RT_FILTER_TABLE
+		case "goto":
+			target, err := cmd.parseUint32("NUMBER")
+			if err != nil {
+				return nil, 0, err
+			}
+			rule.Goto = int(target)
+		case "suppress_prefixlength":
+			n, err := cmd.parseUint8("NUMBER")
+			if err != nil {
+				return nil, 0, err
+			}
+			rule.SuppressPrefixlen = int(n)
+		case "suppress_ifgroup":
+			n, err := cmd.parseUint32("GROUP")
+			if err != nil {
+				return nil, 0, err
+			}
+			rule.SuppressIfgroup = int(n)
+		default:
+			return nil, 0, cmd.usage()
+		}
+	}
+
+	return rule, filterMask, nil
+}
+
+func (cmd *cmd) ruleAdd() error {
+	rule, _, err := cmd.parseRule()
+	if err != nil {
+		return err
+	}
+	if rule.Table == 0 && rule.Goto < 0 {
+		return fmt.Errorf("a rule needs a table to look up or a rule to go to")
+	}
+	return cmd.handle.RuleAdd(rule)
+}
+
+func (cmd *cmd) ruleDel() error {
+	rule, _, err := cmd.parseRule()
+	if err != nil {
+		return err
+	}
+	return cmd.handle.RuleDel(rule)
+}
+
+func (cmd *cmd) filteredRuleList() ([]netlink.Rule, error) {
+	filter, filterMask, err := cmd.parseRule()
+	if err != nil {
+		return nil, err
+	}
+	return cmd.handle.RuleListFiltered(filter.Family, filter, filterMask)
+}
+
+// ruleFlush deletes the rules, but for the one of priority 0, which looks
+// up the local table and without which the host would not know its
+// own addresses. The kernel leaves out a priority of 0, which netlink
+// reports as -1.
+func (cmd *cmd) ruleFlush() error {
+	rules, err := cmd.filteredRuleList()
+	if err != nil {
+		return err
+	}
+	for i := range rules {
+		if rules[i].Priority <= 0 {
+			continue
+		}
+		rules[i].Family = cmd.ruleFamily()
+		if err := cmd.handle.RuleDel(&rules[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cmd *cmd) ruleShow() error {
+	rules, err := cmd.filteredRuleList()
+	if err != nil {
+		return err
+	}
+	return cmd.showRules(rules)
+}
+
+type Rule struct {
+	Priority int    `json:"priority"`
+	Not      bool   `json:"not,omitempty"`
+	Src      string `json:"src"`
+	Dst      string `json:"dst,omitempty"`
+	Tos      string `json:"tos,omitempty"`
+	FwMark   string `json:"fwmark,omitempty"`
+	FwMask   string `json:"fwmask,omitempty"`
+	Iif      string `json:"iif,omitempty"`
+	Oif      string `json:"oif,omitempty"`
+	IPProto  string `json:"ipproto,omitempty"`
+	Sport    string `json:"sport,omitempty"`
+	Dport    string `json:"dport,omitempty"`
+	Table    string `json:"table,omitempty"`
+	Goto     int    `json:"goto,omitempty"`
+}
+
+func portRange(r *netlink.RulePortRange) string {
+	if r == nil {
+		return ""
+	}
+	if r.Start == r.End {
+		return strconv.Itoa(int(r.Start))
+	}
+	return fmt.Sprintf("%d-%d", r.Start, r.End)
+}
+
+func (cmd *cmd) printableRule(r netlink.Rule) Rule {
+	pr := Rule{Priority: r.Priority, Not: r.Invert, Src: "all", Iif: r.IifName, Oif: r.OifName, Sport: portRange(r.Sport), Dport: portRange(r.Dport)}
+	if pr.Priority < 0 {
+		pr.Priority = 0
+	}
+	if r.Src != nil {
+		pr.Src = r.Src.String()
+	}
+	if r.Dst != nil {
+		pr.Dst = r.Dst.String()
+	}
+	if r.Tos != 0 {
+		pr.Tos = fmt.Sprintf("0x%x", r.Tos)
+	}
+	if r.Mark >= 0 {
+		pr.FwMark = fmt.Sprintf("0x%x", r.Mark)
+		if r.Mask >= 0 && uint32(r.Mask) != 0xffffffff {
+			pr.FwMask = fmt.Sprintf("0x%x", r.Mask)
+		}
+	}
+	if r.IPProto > 0 {
+		pr.IPProto = ipProtoName(r.IPProto)
+	}
+	if r.Goto >= 0 {
+		pr.Goto = r.Goto
+	} else if r.Table != 0 {
+		pr.Table = cmd.tableName(r.Table)
+	}
+	return pr
+}
+
+// showRules prints the rules like ip rule show does.
+func (cmd *cmd) showRules(rules []netlink.Rule) error {
+	if cmd.Opts.JSON {
+		obj := make([]Rule, 0, len(rules))
+		for _, r := range rules {
+			obj = append(obj, cmd.printableRule(r))
+		}
+		return printJSON(*cmd, obj)
+	}
+
+	for _, r := range rules {
+		pr := cmd.printableRule(r)
+		var b strings.Builder
+		if pr.Not {
+			b.WriteString("not ")
+		}
+		fmt.Fprintf(&b, "from %s", pr.Src)
+		for _, kv := range [][2]string{{"to", pr.Dst}, {"tos", pr.Tos}, {"iif", pr.Iif}, {"oif", pr.Oif}, {"ipproto", pr.IPProto}, {"sport", pr.Sport}, {"dport", pr.Dport}} {
+			if kv[1] != "" {
+				fmt.Fprintf(&b, " %s %s", kv[0], kv[1])
+			}
+		}
+		if pr.FwMark != "" {
+			fmt.Fprintf(&b, " fwmark %s", pr.FwMark)
+			if pr.FwMask != "" {
+				fmt.Fprintf(&b, "/%s", pr.FwMask)
+			}
+		}
+		switch {
+		case r.Goto >= 0:
+			fmt.Fprintf(&b, " goto %d", pr.Goto)
+		case pr.Table != "":
+			fmt.Fprintf(&b, " lookup %s", pr.Table)
+		}
+		if r.SuppressPrefixlen >= 0 {
+			fmt.Fprintf(&b, " suppress_prefixlength %d", r.SuppressPrefixlen)
+		}
+		if r.SuppressIfgroup >= 0 {
+			fmt.Fprintf(&b, " suppress_ifgroup %d", r.SuppressIfgroup)
+		}
+		fmt.Fprintf(cmd.Out, "%d:\t%s\n", pr.Priority, b.String())
+	}
+	return nil
+}
+
+func (cmd *cmd) rule() error {
+	if !cmd.tokenRemains() {
+		return cmd.ruleShow()
+	}
+
+	switch cmd.findPrefix("show", "list", "add", "del", "flush", "help") {
+	case "add":
+		return cmd.ruleAdd()
+	case "del":
+		return cmd.ruleDel()
+	case "show", "list":
+		return cmd.ruleShow()
+	case "flush":
+		return cmd.ruleFlush()
+	case "help":
+		fmt.Fprint(cmd.Out, ruleHelp)
+		return nil
+	}
+	return cmd.usage()
+}
@@ -0,0 +1,208 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+func TestParseTableID(t *testing.T) {
+	rtTables := filepath.Join(t.TempDir(), "rt_tables")
+	if err := os.WriteFile(rtTables, []byte("# reserved values\n255\tlocal\n254\tmain\n\n100 uplink1 # first uplink\n0x65 uplink2\nbogus\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(p string) { rtTablesPath = p }(rtTablesPath)
+	rtTablesPath = rtTables
+
+	for _, tt := range []struct {
+		token   string
+		want    int
+		wantErr bool
+	}{
+		{token: "main", want: unix.RT_TABLE_MAIN},
+		{token: "local", want: unix.RT_TABLE_LOCAL},
+		{token: "default", want: unix.RT_TABLE_DEFAULT},
+		{token: "all", want: unix.RT_TABLE_UNSPEC},
+		{token: "uplink1", want: 100},
+		{token: "uplink2", want: 101},
+		{token: "1000", want: 1000},
+		{token: "uplink3", wantErr: true},
+		{token: "-1", wantErr: true},
+	} {
+		t.Run(tt.token, func(t *testing.T) {
+			got, err := parseTableID(tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTableID(%q) = %v, want error %t", tt.token, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseTableID(%q) = %d, want %d", tt.token, got, tt.want)
+			}
+		})
+	}
+
+	c := &cmd{}
+	for id, want := range map[int]string{254: "main", 100: "uplink1", 101: "uplink2", 102: "102"} {
+		if got := c.tableName(id); got != want {
+			t.Errorf("tableName(%d) = %q, want %q", id, got, want)
+		}
+	}
+	c.Opts.Numeric = true
+	if got := c.tableName(254); got != "254" {
+		t.Errorf("tableName(254) = %q, want 254 with -N", got)
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	src := &net.IPNet{IP: net.IPv4(10, 0, 1, 0).To4(), Mask: net.CIDRMask(24, 32)}
+	host := &net.IPNet{IP: net.ParseIP("2001:db8::1"), Mask: net.CIDRMask(128, 128)}
+
+	for _, tt := range []struct {
+		name     string
+		family   int
+		args     []string
+		want     func(*netlink.Rule)
+		wantMask uint64
+		wantErr  bool
+	}{
+		{
+			name: "empty",
+			want: func(*netlink.Rule) {},
+		},
+		{
+			name: "from lookup",
+			args: []string{"from", "10.0.1.0/24", "lookup", "100", "pref", "1000"},
+			want: func(r *netlink.Rule) {
+				r.Src = src
+				r.Table = 100
+				r.Priority = 1000
+			},
+			wantMask: netlink.RT_FILTER_SRC | netlink.RT_FILTER_TABLE | netlink.RT_FILTER_PRIORITY,
+		},
+		{
+			name: "fwmark",
+			args: []string{"fwmark", "0x10/0xff", "table", "main"},
+			want: func(r *netlink.Rule) {
+				r.Mark = 0x10
+				r.Mask = 0xff
+				r.Table = unix.RT_TABLE_MAIN
+			},
+			wantMask: netlink.RT_FILTER_MARK | netlink.RT_FILTER_MASK | netlink.RT_FILTER_TABLE,
+		},
+		{
+			name: "IPv6 host",
+			args: []string{"not", "to", "2001:db8::1", "iif", "eth0", "oif", "eth1", "tos", "0x10", "goto", "200"},
+			want: func(r *netlink.Rule) {
+				r.Family = netlink.FAMILY_V6
+				r.Invert = true
+				r.Dst = host
+				r.IifName = "eth0"
+				r.OifName = "eth1"
+				r.Tos = 0x10
+				r.Goto = 200
+			},
+			wantMask: netlink.RT_FILTER_DST | netlink.RT_FILTER_TOS,
+		},
+		{
+			name:   "ports",
+			family: netlink.FAMILY_V6,
+			args:   []string{"from", "all", "ipproto", "tcp", "sport", "1000-2000", "dport", "22", "table", "10", "suppress_prefixlength", "0"},
+			want: func(r *netlink.Rule) {
+				r.Family = netlink.FAMILY_V6
+				r.IPProto = unix.IPPROTO_TCP
+				r.Sport = netlink.NewRulePortRange(1000, 2000)
+				r.Dport = netlink.NewRulePortRange(22, 22)
+				r.Table = 10
+				r.SuppressPrefixlen = 0
+			},
+			wantMask: netlink.RT_FILTER_TABLE,
+		},
+		{name: "bad prefix", args: []string{"from", "10.0.1"}, wantErr: true},
+		{name: "bad fwmark", args: []string{"fwmark", "mark"}, wantErr: true},
+		{name: "bad mask", args: []string{"fwmark", "1/m"}, wantErr: true},
+		{name: "bad ports", args: []string{"sport", "2000-1000"}, wantErr: true},
+		{name: "bad ipproto", args: []string{"ipproto", "tcpp"}, wantErr: true},
+		{name: "bad table", args: []string{"table", "nope"}, wantErr: true},
+		{name: "unknown", args: []string{"via", "10.0.0.1"}, wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := cmd{Cursor: -1, Args: tt.args, Family: tt.family, Out: new(bytes.Buffer)}
+			rule, mask, err := c.parseRule()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRule() = %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			want := netlink.NewRule()
+			want.Family = netlink.FAMILY_V4
+			tt.want(want)
+			if diff := cmp.Diff(want, rule); diff != "" {
+				t.Errorf("parseRule() mismatch (-want +got):\n%s", diff)
+			}
+			if mask != tt.wantMask {
+				t.Errorf("parseRule() mask = %#x, want %#x", mask, tt.wantMask)
+			}
+		})
+	}
+}
+
+func TestShowRules(t *testing.T) {
+	rule := func(f func(*netlink.Rule)) netlink.Rule {
+		r := netlink.NewRule()
+		f(r)
+		return *r
+	}
+	rules := []netlink.Rule{
+		rule(func(r *netlink.Rule) { r.Priority, r.Table = -1, unix.RT_TABLE_LOCAL }),
+		rule(func(r *netlink.Rule) {
+			r.Priority, r.Table = 100, 100
+			r.Src = &net.IPNet{IP: net.IPv4(10, 0, 1, 0).To4(), Mask: net.CIDRMask(24, 32)}
+			r.Mark, r.Mask = 1, -1
+		}),
+		rule(func(r *netlink.Rule) {
+			r.Priority, r.Table = 200, 101
+			r.Invert = true
+			r.Mark, r.Mask = 0x10, 0xff
+			r.IifName = "eth1"
+			r.IPProto, r.Dport = unix.IPPROTO_UDP, netlink.NewRulePortRange(67, 68)
+		}),
+		rule(func(r *netlink.Rule) { r.Priority, r.Goto = 300, 32766 }),
+		rule(func(r *netlink.Rule) { r.Priority, r.Table, r.SuppressPrefixlen = 32765, unix.RT_TABLE_MAIN, 0 }),
+		rule(func(r *netlink.Rule) { r.Priority, r.Table = 32766, unix.RT_TABLE_MAIN }),
+	}
+
+	var out bytes.Buffer
+	c := &cmd{Out: &out}
+	if err := c.showRules(rules); err != nil {
+		t.Fatal(err)
+	}
+	want := `0:	from all lookup local
+100:	from 10.0.1.0/24 fwmark 0x1 lookup 100
+200:	not from all iif eth1 ipproto udp dport 67-68 fwmark 0x10/0xff lookup 101
+300:	from all goto 32766
+32765:	from all lookup main suppress_prefixlength 0
+32766:	from all lookup main
+`
+	if diff := cmp.Diff(want, out.String()); diff != "" {
+		t.Errorf("showRules() mismatch (-want +got):\n%s", diff)
+	}
+
+	out.Reset()
+	c.Opts.JSON = true
+	if err := c.showRules(rules[1:2]); err != nil {
+		t.Fatal(err)
+	}
+	if want := `[{"priority":100,"src":"10.0.1.0/24","fwmark":"0x1","table":"100"}]`; out.String() != want {
+		t.Errorf("showRules() = %s, want %s", out.String(), want)
+	}
+}
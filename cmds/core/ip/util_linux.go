@@ -10,7 +10,7 @@ import (
 )
 
 type Printable interface {
-	Link | []Link | Vrf | []Vrf | Neigh | []Neigh | Route | []Route | Tunnel | []Tunnel | Tuntap | []Tuntap
+	Link | []Link | Vrf | []Vrf | Neigh | []Neigh | Route | []Route | Rule | []Rule | Tunnel | []Tunnel | Tuntap | []Tuntap
 }
 
 func printJSON[T Printable](cmd cmd, data T) error {
@@ -10,10 +10,26 @@
 // Description:
 //		 Loads a kernel for later execution.
 //
+//		 Multiboot, Multiboot2 and ESXBootInfo kernels are loaded along with
+//		 the modules given with --module.
+//
+//		 The kernel, initrd and command line of Unified Kernel Images are
+//		 loaded from their sections. The initrds given are loaded after that
+//		 of the UKI, and the command line given replaces its own.
+//
+//		 With --verify-sig, the kernel is loaded with kexec_file_load only if
+//		 it has a PE signature and the running kernel enforces signatures,
+//		 with lockdown, IMA appraisal or CONFIG_KEXEC_SIG_FORCE. The running
+//		 kernel checks the signature against its trusted and platform keyrings.
+//		 It does not check the initrd and command line of a UKI, so UKIs are
+//		 loaded with --verify-sig only if they verify as a whole with the
+//		 --policy given, see package bootpolicy.
+//
 // Options:
 //      --append string        Append to the kernel command line
 //  -c, --cmdline string       Append to the kernel command line
 //  -d, --debug                Print debug info (default true)
+//      --dtb string           Use file as the device tree, or the one compatible with the machine in a directory
 //  -e, --exec                 Execute a currently loaded kernel
 //  -x, --extra string         Add a cpio containing extra files
 //      --initramfs string     Use file as the kernel's initial ramdisk
@@ -21,8 +37,10 @@
 //  -l, --load                 Load the new kernel into the current kernel
 //  -L, --loadsyscall          Use the kexec load syscall (not file_load) (default true)
 //      --module stringArray   Load multiboot module with command line args (e.g --module="mod arg1")
+//      --policy string        Load only files whose signatures verify with the boot policy in file
 //  -p, --purgatory string     pick a purgatory, use '-p xyz' to get a list (default "default")
 //      --reuse-cmdline        Use the kernel command line from running system
+//      --verify-sig           Load the kernel only if the running kernel verifies its signature
 
 package main
 
@@ -36,11 +54,14 @@ import (
 	"flag"
 
 	"github.com/u-root/u-root/pkg/boot"
+	"github.com/u-root/u-root/pkg/boot/bootpolicy"
 	"github.com/u-root/u-root/pkg/boot/kexec"
 	"github.com/u-root/u-root/pkg/boot/linux"
 	"github.com/u-root/u-root/pkg/boot/multiboot"
 	"github.com/u-root/u-root/pkg/boot/purgatory"
+	"github.com/u-root/u-root/pkg/boot/uki"
 	"github.com/u-root/u-root/pkg/cmdline"
+	"github.com/u-root/u-root/pkg/dt"
 	"github.com/u-root/u-root/pkg/uroot/unixflag"
 	"github.com/u-root/uio/uio"
 )
@@ -58,8 +79,10 @@ type options struct {
 	load         bool
 	loadSyscall  bool
 	modules      []string
+	policy       string
 	purgatory    string
 	reuseCmdline bool
+	verifySig    bool
 }
 
 func (o *options) parseCmdline(args []string, f *flag.FlagSet) {
@@ -71,7 +94,7 @@ func (o *options) parseCmdline(args []string, f *flag.FlagSet) {
 	f.BoolVar(&o.debug, "debug", false, "Print debug info")
 	f.BoolVar(&o.debug, "d", false, "Print debug info (shorthand)")
 
-	f.StringVar(&o.dtb, "dtb", "", "FILE used as the flatten device tree blob")
+	f.StringVar(&o.dtb, "dtb", "", "FILE used as the flatten device tree blob, or a directory of device trees to pick the one compatible with the machine from")
 
 	f.BoolVar(&o.exec, "exec", false, "Execute a currently loaded kernel")
 	f.BoolVar(&o.exec, "e", false, "Execute a currently loaded kernel (shorthand)")
@@ -103,8 +126,12 @@ func (o *options) parseCmdline(args []string, f *flag.FlagSet) {
 	f.StringVar(&o.purgatory, "purgatory", "default", "picks a purgatory only if loading a Linux kernel with kexec_load, use '-p xyz' to get a list")
 	f.StringVar(&o.purgatory, "p", "default", "picks a purgatory only if loading a Linux kernel with kexec_load, use '-p xyz' to get a list (shorthand)")
 
+	f.StringVar(&o.policy, "policy", "", "Load only files whose signatures verify with the boot policy in file")
+
 	f.BoolVar(&o.reuseCmdline, "reuse-cmdline", false, "Use the kernel command line from running system")
 
+	f.BoolVar(&o.verifySig, "verify-sig", false, "Load the kernel only if the running kernel verifies its signature")
+
 	unixargs := unixflag.ArgsToGoArgs(args[1:])
 	hackedArgs := hackLoadFlagValue(unixargs)
 
@@ -150,6 +177,29 @@ func hackLoadFlagValue(in []string) []string {
 	return out
 }
 
+// dtbFile returns the device tree file path, or if path is a directory, such
+// as the /boot/dtbs of a distribution, the one under it that is compatible
+// with the running machine.
+func dtbFile(path string) (string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !fi.IsDir() {
+		return path, nil
+	}
+	machine, err := dt.ReadFile("/sys/firmware/fdt")
+	if err != nil {
+		return "", fmt.Errorf("reading the device tree of the machine: %w", err)
+	}
+	p, err := linux.SelectDTBFile(path, machine)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Using device tree %s", p)
+	return p, nil
+}
+
 func main() {
 	if err := run(os.Args); err != nil {
 		log.Fatalf("%v", err)
@@ -176,6 +226,11 @@ func run(args []string) error {
 		return fmt.Errorf("--reuse-cmdline and other command line options are mutually exclusive")
 	}
 
+	if opts.verifySig && opts.loadSyscall {
+		f.PrintDefaults()
+		return fmt.Errorf("--verify-sig needs kexec_file_load, and cannot be used with --loadsyscall")
+	}
+
 	if !opts.load && !opts.exec {
 		opts.load = true
 		opts.exec = true
@@ -202,6 +257,9 @@ func run(args []string) error {
 		defer kernel.Close()
 		var image boot.OSImage
 		if err := multiboot.Probe(kernel); err == nil {
+			if opts.verifySig {
+				return fmt.Errorf("%s: multiboot kernels cannot be verified", opts.kernelpath)
+			}
 			image = &boot.MultibootImage{
 				Modules: multiboot.LazyOpenModules(opts.modules),
 				Kernel:  kernel,
@@ -221,6 +279,11 @@ func run(args []string) error {
 					files = append(files, uio.NewLazyFile(n))
 				}
 			}
+			u, ukiErr := uki.Parse(kernel)
+			if ukiErr == nil && u.Initrd != nil {
+				// The initrds given follow that of the UKI.
+				files = append([]io.ReaderAt{u.Initrd}, files...)
+			}
 			var i io.ReaderAt
 			if len(files) > 0 {
 				i = boot.CatInitrds(files...)
@@ -228,20 +291,49 @@ func run(args []string) error {
 
 			var dtb io.ReaderAt
 			if len(opts.dtb) > 0 {
-				dtb, err = os.Open(opts.dtb)
+				p, err := dtbFile(opts.dtb)
+				if err != nil {
+					return err
+				}
+				dtb, err = os.Open(p)
 				if err != nil {
-					return fmt.Errorf("failed to open dtb file %s: %w", opts.dtb, err)
+					return fmt.Errorf("failed to open dtb file %s: %w", p, err)
+				}
+			}
+			if ukiErr == nil {
+				// The command line and device tree given replace
+				// those of the UKI.
+				log.Printf("Loading UKI %s", u.Label())
+				u.Initrd = i
+				if newCmdline != "" {
+					u.Cmdline = newCmdline
+				}
+				if dtb != nil {
+					u.DTB = dtb
+				}
+				u.LoadSyscall = opts.loadSyscall
+				u.VerifySignature = opts.verifySig
+				image = u
+			} else {
+				image = &boot.LinuxImage{
+					Kernel:          uio.NewLazyFile(opts.kernelpath),
+					Initrd:          i,
+					Cmdline:         newCmdline,
+					LoadSyscall:     opts.loadSyscall,
+					DTB:             dtb,
+					VerifySignature: opts.verifySig,
 				}
 			}
-			image = &boot.LinuxImage{
-				Kernel:      uio.NewLazyFile(opts.kernelpath),
-				Initrd:      i,
-				Cmdline:     newCmdline,
-				LoadSyscall: opts.loadSyscall,
-				DTB:         dtb,
+		}
+		loadOpts := []boot.LoadOption{boot.WithVerbose(opts.debug)}
+		if opts.policy != "" {
+			p, err := bootpolicy.Load(opts.policy)
+			if err != nil {
+				return err
 			}
+			loadOpts = append(loadOpts, boot.WithVerifier(p))
 		}
-		if err := image.Load(boot.WithVerbose(opts.debug)); err != nil {
+		if err := image.Load(loadOpts...); err != nil {
 			return err
 		}
 	}
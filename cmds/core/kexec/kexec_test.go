@@ -183,6 +183,16 @@ func TestParseCmdline(t *testing.T) {
 				kernelpath:   "/path/to/kernel",
 			},
 		},
+		{
+			name: "Test verify signature",
+			args: []string{"kexec", "--verify-sig", "--policy", "/etc/boot-policy", "-l", "/path/to/kernel"},
+			expected: options{
+				load:       true,
+				policy:     "/etc/boot-policy",
+				verifySig:  true,
+				kernelpath: "/path/to/kernel",
+			},
+		},
 		{
 			name: "Test all set unix style flags",
 			args: []string{"kexec", "-delL", "/path/to/kernel"},
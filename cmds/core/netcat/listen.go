@@ -58,7 +58,7 @@ func (c *cmd) setupListener(network, address string) (net.Listener, error) {
 	switch c.config.ProtocolOptions.SocketType {
 	case netcat.SOCKET_TYPE_TCP, netcat.SOCKET_TYPE_UNIX:
 		if c.config.SSLConfig.Enabled || c.config.SSLConfig.VerifyTrust {
-			tlsConfig, err := c.config.SSLConfig.GenerateTLSConfiguration()
+			tlsConfig, err := c.config.SSLConfig.ServerTLSConfiguration()
 			if err != nil {
 				return nil, fmt.Errorf("failed generating TLS configuration: %v", err)
 			}
@@ -145,6 +145,7 @@ func (c *Connections) Broadcast(output io.Writer, senderID uint32, message strin
 // listenForConnections listens for incoming connections on a specified listener and reads data from these.
 // The function reads data from the connections and writes it to the output writer.
 // The first connection to be accepted is used to write data to from stdin.
+// With a command to execute, each connection is connected to its own instance of the command instead.
 // If keep open is set, the maximum number of connections is set to maxConnections else it is set to 1.
 // In broker mode, the function reads from all connections and broadcasts the messages to all other connections.
 // In chat mode, the function prepends the user id to the message before broadcasting.
@@ -179,11 +180,14 @@ func (c *cmd) listenForConnections(output io.Writer, listener net.Listener) erro
 			break
 		}
 
-		go once.Do(func() {
-			if _, err := io.Copy(conn, c.stdin); err != nil {
-				log.Printf("failed to write to connection: %v", err)
-			}
-		})
+		// with a command, each connection talks to its own instance instead of stdin
+		if !c.execOnConnect() {
+			go once.Do(func() {
+				if _, err := io.Copy(conn, c.stdin); err != nil {
+					log.Printf("failed to write to connection: %v", err)
+				}
+			})
+		}
 
 		atomic.AddUint32(&connectionsHandled, 1)
 		connectionID := atomic.LoadUint32(&connectionsHandled)
@@ -197,6 +201,13 @@ func (c *cmd) listenForConnections(output io.Writer, listener net.Listener) erro
 				connections.Delete(id)
 			}()
 
+			if c.execOnConnect() {
+				if err := c.config.CommandExec.Execute(conn, io.MultiWriter(conn, output), c.stderr, c.config.Misc.EOL); err != nil {
+					log.Printf("run command: %v", err)
+				}
+				return
+			}
+
 			// broadcast messages to all connections in broker mode
 			if c.config.ListenModeOptions.BrokerMode {
 				scanner := bufio.NewScanner(conn)
@@ -240,6 +251,11 @@ func (c *cmd) listenForConnections(output io.Writer, listener net.Listener) erro
 	return nil
 }
 
+// execOnConnect tells whether each accepted connection runs the command to execute.
+func (c *cmd) execOnConnect() bool {
+	return c.config.CommandExec.Type != netcat.EXEC_TYPE_NONE && c.config.CommandExec.Command != ""
+}
+
 // parseRemoteAddr parses the remote address of a connection and returns a list of possible addresses.
 // For UNIX sockets, the returned address is the path to the socket file.
 // For TCP and UDP sockets, the remote addresses are combinations of IP address and port and any domain name.
@@ -748,3 +748,61 @@ func isSubset(t *testing.T, gotAddress, wantAddress []string) bool {
 
 	return true
 }
+
+func TestListenExecOnConnect(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		socketType netcat.SocketType
+		network    string
+		ssl        bool
+	}{
+		{name: "TCP", socketType: netcat.SOCKET_TYPE_TCP, network: "tcp"},
+		{name: "TLS with temporary certificate", socketType: netcat.SOCKET_TYPE_TCP, network: "tcp", ssl: true},
+		{name: "UDP", socketType: netcat.SOCKET_TYPE_UDP, network: "udp"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config := netcat.DefaultConfig()
+			config.ConnectionMode = netcat.CONNECTION_MODE_LISTEN
+			config.ConnectionModeOptions.SourcePort = ""
+			config.ProtocolOptions.SocketType = tt.socketType
+			config.ListenModeOptions.MaxConnections = 1
+			config.CommandExec = netcat.Exec{Type: netcat.EXEC_TYPE_SHELL, Command: "head -n 1"}
+			config.SSLConfig.Enabled = tt.ssl
+			server := &cmd{stdin: &bytes.Buffer{}, stderr: io.Discard, config: &config}
+
+			listener, err := server.setupListener(tt.network, "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("setupListener() = %v", err)
+			}
+			defer listener.Close()
+			done := make(chan error)
+			go func() {
+				done <- server.listenForConnections(io.Discard, listener)
+			}()
+
+			clientConfig := netcat.DefaultConfig()
+			clientConfig.ConnectionModeOptions.SourcePort = ""
+			clientConfig.ProtocolOptions.SocketType = tt.socketType
+			clientConfig.SSLConfig.Enabled = tt.ssl
+			client := &cmd{config: &clientConfig}
+			conn, err := client.establishConnection(tt.network, listener.Addr().String())
+			if err != nil {
+				t.Fatalf("establishConnection() = %v", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+			if _, err := io.WriteString(conn, "ping\n"); err != nil {
+				t.Fatal(err)
+			}
+			b := make([]byte, 16)
+			n, err := conn.Read(b)
+			if err != nil || string(b[:n]) != "ping\n" {
+				t.Errorf("command replied %q, %v, want %q", b[:n], err, "ping\n")
+			}
+			if err := <-done; err != nil {
+				t.Errorf("listenForConnections() = %v", err)
+			}
+		})
+	}
+}
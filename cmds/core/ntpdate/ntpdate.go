@@ -8,7 +8,8 @@
 //
 // Synopsis:
 //
-//	ntpdate [--config=/etc/ntp.conf] [--rtc] [--verbose] [server ...]
+//	ntpdate [--config=/etc/ntp.conf] [--rtc] [--verbose] [--nts [--ca=FILE]]
+//		[--daemon [--minpoll=64s] [--maxpoll=1024s] [--step=128ms]] [server ...]
 //
 // Description:
 //
@@ -18,7 +19,15 @@
 //	By default --config is set to /etc/ntp.conf, config lookup can be disabled
 //	by setting --config to an empty string.
 //	If servers are specified on the command line, they are tried first.
-//	time.google.com is used as the last resort.
+//	time.google.com is used as the last resort, time.cloudflare.com with --nts.
+//
+//	With --nts, servers are authenticated with Network Time Security (RFC 8915):
+//	they are NTS key establishment servers, trusted against the system roots or
+//	those of --ca.
+//
+//	With --daemon, ntpdate keeps running and disciplines the clock: it polls
+//	all servers, starting every --minpoll and backing off up to --maxpoll while
+//	the clock is stable. Offsets under --step are slewed, larger ones stepped.
 //
 // Options:
 //
@@ -26,8 +35,14 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
+	"os"
 
 	"github.com/u-root/u-root/pkg/ntpdate"
 )
@@ -36,17 +51,79 @@ var (
 	config  = flag.String("config", ntpdate.DefaultNTPConfig, "NTP config file.")
 	setRTC  = flag.Bool("rtc", false, "Set RTC time as well")
 	verbose = flag.Bool("verbose", false, "Verbose output")
+	nts     = flag.Bool("nts", false, "Authenticate servers with NTS")
+	ca      = flag.String("ca", "", "PEM file of the roots to trust for NTS, instead of the system ones")
+	daemon  = flag.Bool("daemon", false, "Keep the clock disciplined")
+	minPoll = flag.Duration("minpoll", ntpdate.DefaultMinPoll, "Shortest interval between polls")
+	maxPoll = flag.Duration("maxpoll", ntpdate.DefaultMaxPoll, "Longest interval between polls")
+	step    = flag.Duration("step", ntpdate.DefaultStepThreshold, "Smallest offset to step rather than slew")
 )
 
 const (
-	fallback = "time.google.com"
+	fallback    = "time.google.com"
+	ntsFallback = "time.cloudflare.com"
 )
 
+var errNoCertificates = errors.New("no certificates")
+
+func tlsConfig(ca string) (*tls.Config, error) {
+	if ca == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(ca)
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("%w: %q", errNoCertificates, ca)
+	}
+	return &tls.Config{RootCAs: roots}, nil
+}
+
+func discipline() error {
+	fb := fallback
+	if *nts {
+		fb = ntsFallback
+	}
+	c, err := tlsConfig(*ca)
+	if err != nil {
+		return err
+	}
+	d := &ntpdate.Daemon{
+		Servers:       ntpdate.Servers(flag.Args(), *config, fb),
+		NTS:           *nts,
+		TLSConfig:     c,
+		MinPoll:       *minPoll,
+		MaxPoll:       *maxPoll,
+		StepThreshold: *step,
+		SetRTC:        *setRTC,
+	}
+	if *daemon {
+		return d.Run(context.Background(), log.Printf)
+	}
+
+	// Like without NTS, a single adjustment is a step.
+	d.StepThreshold = -1
+	s, _, err := d.Poll()
+	if err != nil {
+		return err
+	}
+	log.Printf("adjust time server %s offset %+f sec", s.Server, s.Offset.Seconds())
+	return nil
+}
+
 func main() {
 	flag.Parse()
 	if *verbose {
 		ntpdate.Debug = log.Printf
 	}
+	if *nts || *daemon {
+		if err := discipline(); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		return
+	}
 	server, offset, err := ntpdate.SetTime(flag.Args(), *config, fallback, *setRTC)
 	if err != nil {
 		log.Fatalf("Error: %v", err)
@@ -6,22 +6,53 @@
 //
 // Synopsis:
 //
-//	ping [-hV] [-c COUNT] [-i INTERVAL] [-s PACKETSIZE] [-w DEADLINE] DESTINATION
+//	ping [-hVfAb] [-c COUNT] [-i INTERVAL] [-s PACKETSIZE] [-w DEADLINE] [-W TIMEOUT] [-until-reply] [-t TTL] [-clock CLOCK] [-output FORMAT] DESTINATION
+//	ping -g [-c COUNT] [-i INTERVAL] [-s PACKETSIZE] [-w DEADLINE] [-W TIMEOUT] NETWORK/PREFIXLEN
 //
 // Options:
 //
-//	-6: use ipv6 (ip6:ipv6-icmp)
+//	-6: use ipv6 (ip6:ipv6-icmp), by default the IP version of DESTINATION's address
 //	-s: data size (default: 64)
 //	-c: # iterations, 0 to run forever (default)
 //	-i: interval in milliseconds (default: 1000)
 //	-V: version
-//	-w: wait time in milliseconds (default: 100)
+//	-w: deadline in seconds: stop once it passed, however many requests were
+//	    sent (default: none). See the incompatible change below
+//	-W: time to wait for each reply in milliseconds (default: 100)
+//	-until-reply: stop at the first reply, exiting successfully, e.g. to wait
+//	    for a gateway with -w 30 in a boot script
 //	-a: Audible rings a bell when a packet is received
+//	-f: flood: send the next request as soon as the previous one is answered,
+//	    printing a dot per request sent and erasing it once answered
+//	    and an E per request answered by an ICMP error
+//	-A: adaptive: pace the requests by the round trip time, at most INTERVAL apart
+//	-clock: clock to take the round trip times with: software (default), kernel
+//	    or hardware. Replies the clock does not work for fall back to the next
+//	    best one, which the reply shows
+//	-b: allow pinging a broadcast address. Like for multicast addresses, every
+//	    host answering is printed, and replies repeated by a host are marked DUP!
+//	-t: TTL, or hop limit, of unicast and multicast requests (default: system's)
+//	-output: text (default), json for a single document holding the replies and
+//	    the summary once done, or ndjson for a "reply" record per reply, an
+//	    "error" record per ICMP error and a closing "summary" record. Sweeps
+//	    always print text
+//	-g: sweep: ping every host of the network at once and print those answering.
+//	    COUNT requests go to each host not answering yet (default: 1), INTERVAL
+//	    apart (default: 1), and replies are waited for up to TIMEOUT (default: 1000)
 //	-h: help
+//
+// Incompatible change:
+//
+//	-w used to be the time to wait for each reply in milliseconds. It is now
+//	the deadline in seconds, as in iputils ping, and the wait for each reply
+//	moved to -W. Scripts passing -w 1000 for a one second wait now ping for
+//	up to 1000 seconds; change them to -W 1000.
 package main
 
 import (
-	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -29,143 +60,272 @@ import (
 	"math"
 	"net"
 	"os"
+	"os/signal"
+	"strings"
 	"time"
 
+	"github.com/u-root/u-root/pkg/ping"
 	"github.com/u-root/u-root/pkg/uroot/util"
-	"golang.org/x/net/icmp"
-	"golang.org/x/net/ipv4"
-	"golang.org/x/net/ipv6"
 )
 
-const usage = "ping [-V] [-6] [-c count] [-i interval] [-s packetsize] [-w deadline] [-a audible] destination"
+const usage = "ping [-V] [-6] [-f] [-A] [-c count] [-i interval] [-s packetsize] [-w deadline] [-W timeout] [-until-reply] [-a audible] [-b] [-t ttl] [-g] [-clock clock] [-output format] destination"
+
+// Output formats.
+const (
+	outputText   = "text"
+	outputJSON   = "json"
+	outputNDJSON = "ndjson"
+)
+
+var (
+	errNoReply = errors.New("no reply")
+	errOutput  = errors.New("unknown output format")
+)
+
+// jsonResult is the document of the json output format.
+type jsonResult struct {
+	Replies []ping.ReplyRecord `json:"replies"`
+	Errors  []ping.ErrorRecord `json:"errors,omitempty"`
+	Summary ping.SummaryRecord `json:"summary"`
+}
+
+// ndjsonReply, ndjsonError and ndjsonSummary are the records of the
+// ndjson output format.
+type ndjsonReply struct {
+	Type string `json:"type"`
+	ping.ReplyRecord
+}
+
+type ndjsonError struct {
+	Type string `json:"type"`
+	ping.ErrorRecord
+}
+
+type ndjsonSummary struct {
+	Type string `json:"type"`
+	ping.SummaryRecord
+}
 
 type params struct {
 	packetSize int
 	intv       int
 	wtf        int
+	deadline   int
+	untilReply bool
 	iter       uint64
 	host       string
 	net6       bool
 	audible    bool
+	flood      bool
+	adaptive   bool
+	sweep      bool
+	clock      ping.Clock
+	broadcast  bool
+	ttl        int
+	output     string
 }
 
 type cmd struct {
 	stdout io.Writer
-	conn   net.PacketConn
+	// conn replaces the raw socket of pkg/ping if set.
+	conn net.PacketConn
 	params
 }
 
-func command(stdin io.Writer, p params) (*cmd, error) {
-	netname, address := "ip4:icmp", "0.0.0.0"
-	if p.net6 {
-		netname, address = "ip6:ipv6-icmp", "::1"
-	}
-	conn, err := icmp.ListenPacket(netname, address)
-	if err != nil {
-		return nil, fmt.Errorf("can't setup %s socket on %s: %v", netname, address, err)
-	}
-
-	return &cmd{stdin, conn, p}, nil
+func command(stdout io.Writer, p params) *cmd {
+	return &cmd{stdout: stdout, params: p}
 }
 
-func (c *cmd) run() error {
-	defer c.conn.Close()
-	if c.packetSize < 8 {
-		return fmt.Errorf("packet size too small (must be >= 8): %v", c.packetSize)
+func (c *cmd) run(ctx context.Context) error {
+	if c.sweep {
+		return c.runSweep(ctx)
+	}
+	opts := &ping.Options{
+		Host:       c.host,
+		PacketSize: c.packetSize,
+		Count:      c.iter,
+		Interval:   time.Duration(c.intv) * time.Millisecond,
+		Timeout:    time.Duration(c.wtf) * time.Millisecond,
+		Deadline:   time.Duration(c.deadline) * time.Second,
+		UntilReply: c.untilReply,
+		Flood:      c.flood,
+		Adaptive:   c.adaptive,
+		Clock:      c.clock,
+		Broadcast:  c.broadcast,
+		TTL:        c.ttl,
+		Conn:       c.conn,
 	}
-
-	network := "ip4"
 	if c.net6 {
-		network = "ip6"
+		opts.Network = "ip6"
 	}
-
-	addr, err := net.ResolveIPAddr(network, c.host)
-	if err != nil {
-		return fmt.Errorf("failed to resolve address: %v", err)
+	text := c.output == "" || c.output == outputText
+	if c.flood && text {
+		// Unanswered requests leave their dot behind.
+		opts.OnSend = func(int) { fmt.Fprint(c.stdout, ".") }
 	}
-
-	interval := time.Duration(c.intv)
-	waitFor := time.Duration(c.wtf) * time.Millisecond
-	for i := uint64(0); i < c.iter; i++ {
-		msg, err := c.ping(addr, i+1, waitFor)
-		if err != nil {
-			return fmt.Errorf("ping failed: %v", err)
+	// Several hosts answer broadcast and multicast addresses, so the
+	// replies name their sender.
+	addr, _, _ := strings.Cut(c.host, "%")
+	ip := net.ParseIP(addr)
+	group := c.broadcast || ip != nil && ip.IsMulticast()
+	var answered int
+	enc := json.NewEncoder(c.stdout)
+	replies := []ping.ReplyRecord{}
+	var icmpErrors []ping.ErrorRecord
+	// ICMP errors are printed like iputils does, instead of the request
+	// timing out silently.
+	opts.OnError = func(seq int, e *ping.ICMPError) {
+		switch c.output {
+		case outputJSON:
+			icmpErrors = append(icmpErrors, e.Record(seq))
+		case outputNDJSON:
+			enc.Encode(ndjsonError{"error", e.Record(seq)})
+		default:
+			if c.flood {
+				if !e.Redirect() {
+					fmt.Fprint(c.stdout, "\bE")
+				}
+				return
+			}
+			fmt.Fprintf(c.stdout, "From %v icmp_seq=%d %v\n", e.From, seq, e)
+		}
+	}
+	s, err := ping.Ping(ctx, opts, func(r ping.Reply) {
+		switch c.output {
+		case outputJSON:
+			replies = append(replies, r.Record())
+			return
+		case outputNDJSON:
+			enc.Encode(ndjsonReply{"reply", r.Record()})
+			return
+		}
+		if c.flood {
+			// Only the first reply to a request erases its dot.
+			if r.Seq != answered {
+				fmt.Fprint(c.stdout, "\b \b")
+				answered = r.Seq
+			}
+			return
+		}
+		var from any = c.host
+		if group {
+			from = r.From
+		}
+		msg := fmt.Sprintf("%d bytes from %v: icmp_seq=%v", r.Size, from, r.Seq)
+		if r.TTL != 0 {
+			msg += fmt.Sprintf(" ttl=%d", r.TTL)
+		}
+		msg += fmt.Sprintf(" time=%v", r.RTT)
+		if c.clock != ping.ClockSoftware {
+			msg += fmt.Sprintf(" clock=%v", r.Clock)
+		}
+		if r.Dup {
+			msg += " (DUP!)"
 		}
 		if c.audible {
 			msg = "\a" + msg
 		}
 		fmt.Fprintf(c.stdout, "%s\n", msg)
-		time.Sleep(time.Millisecond * interval)
+	})
+	if errors.Is(err, context.Canceled) {
+		err = nil
 	}
-
-	return nil
-}
-
-func (c *cmd) ping(addr *net.IPAddr, i uint64, waitFor time.Duration) (string, error) {
-	c.conn.SetDeadline(time.Now().Add(waitFor))
-
-	var echoRequestType icmp.Type = ipv4.ICMPTypeEcho
-	if c.net6 {
-		echoRequestType = ipv6.ICMPTypeEchoRequest
+	if err != nil {
+		return fmt.Errorf("ping failed: %w", err)
 	}
-
-	wm := icmp.Message{Type: echoRequestType, Code: 0, Body: &icmp.Echo{
-		ID:   os.Getpid() & 0xffff,
-		Seq:  int(i),
-		Data: bytes.Repeat([]byte{1}, c.packetSize)},
+	switch c.output {
+	case outputJSON:
+		err = enc.Encode(jsonResult{replies, icmpErrors, s.Record(c.host)})
+	case outputNDJSON:
+		err = enc.Encode(ndjsonSummary{"summary", s.Record(c.host)})
+	default:
+		if c.flood {
+			fmt.Fprintln(c.stdout)
+		}
+		c.printStats(s)
 	}
-	wb, err := wm.Marshal(nil)
 	if err != nil {
-		return "", fmt.Errorf("icmp.Message.Marshal failed: %v", err)
+		return err
 	}
-
-	startTime := time.Now()
-	_, err = c.conn.WriteTo(wb, addr)
-	if err != nil {
-		return "", fmt.Errorf("conn.Write failed: %v", err)
+	if s.Received == 0 {
+		return fmt.Errorf("%w from %s", errNoReply, c.host)
 	}
+	return nil
+}
 
-	rb := make([]byte, 1500)
-	n, _, err := c.conn.ReadFrom(rb)
+// runSweep pings every host of the network c.host.
+func (c *cmd) runSweep(ctx context.Context) error {
+	_, network, err := net.ParseCIDR(c.host)
 	if err != nil {
-		return "", fmt.Errorf("conn.Read failed: %v", err)
+		return err
 	}
-
-	latency := time.Since(startTime)
-
-	var echoReplyType icmp.Type = ipv4.ICMPTypeEchoReply
-	if c.net6 {
-		echoReplyType = ipv6.ICMPTypeEchoReply
+	if c.deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(c.deadline)*time.Second)
+		defer cancel()
+	}
+	replies, err := ping.Sweep(ctx, network, &ping.Options{
+		PacketSize: c.packetSize,
+		Count:      c.iter,
+		Interval:   time.Duration(c.intv) * time.Millisecond,
+		Timeout:    time.Duration(c.wtf) * time.Millisecond,
+		Conn:       c.conn,
+	}, func(r ping.Reply) {
+		fmt.Fprintf(c.stdout, "%v is alive (time=%v)\n", r.From, r.RTT)
+	})
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		err = nil
 	}
-
-	msg, err := icmp.ParseMessage(echoReplyType.Protocol(), rb[:n])
 	if err != nil {
-		return "", fmt.Errorf("icmp.ParseMessage failed: %v", err)
+		return fmt.Errorf("sweep failed: %w", err)
 	}
-
-	echoReply, ok := msg.Body.(*icmp.Echo)
-	if !ok {
-		return "", fmt.Errorf("got %+v; want echo reply", msg)
+	hosts, _ := ping.SweepHosts(network)
+	fmt.Fprintf(c.stdout, "\n--- %s sweep ---\n%d of %d hosts alive\n", network, len(replies), len(hosts))
+	if len(replies) == 0 {
+		return fmt.Errorf("%w from %s", errNoReply, network)
 	}
+	return nil
+}
 
-	if echoReply.ID != os.Getpid()&0xffff {
-		return "", fmt.Errorf("got id %v; want %v", echoReply.ID, os.Getpid()&0xffff)
+// printStats prints the summary of the pings like iputils does, adding
+// the rate the requests went out at.
+func (c *cmd) printStats(s *ping.Stats) {
+	ms := func(d time.Duration) float64 {
+		return float64(d/time.Microsecond) / 1000
 	}
-	if echoReply.Seq != int(i) {
-		return "", fmt.Errorf("got seq %v; want %v", echoReply.Seq, i)
+	fmt.Fprintf(c.stdout, "\n--- %s ping statistics ---\n", c.host)
+	extra := ""
+	if s.Duplicates > 0 {
+		extra = fmt.Sprintf(", +%d duplicates", s.Duplicates)
+	}
+	if s.Errors > 0 {
+		extra += fmt.Sprintf(", +%d errors", s.Errors)
+	}
+	fmt.Fprintf(c.stdout, "%d packets transmitted, %d received%s, %.1f%% packet loss, time %dms, %.1f packets/s\n",
+		s.Sent, s.Received, extra, s.Loss(), s.Elapsed.Milliseconds(), s.Rate())
+	if s.Received > 0 {
+		fmt.Fprintf(c.stdout, "rtt min/avg/max/mdev = %.3f/%.3f/%.3f/%.3f ms\n",
+			ms(s.Min), ms(s.Avg), ms(s.Max), ms(s.StdDev))
 	}
-
-	return fmt.Sprintf("%d bytes from %v: icmp_seq=%v time=%v", n, c.host, i, latency), nil
 }
 
 func main() {
 	var (
 		net6       = flag.Bool("6", false, "use ipv4 (means ip4:icmp) or 6 (ip6:ipv6-icmp)")
-		packetSize = flag.Int("s", 56, "Data size")
+		packetSize = flag.Int("s", ping.DEFPACKETSIZE, "Data size")
 		iter       = flag.Uint64("c", math.MaxUint64, "# iterations")
 		intv       = flag.Int("i", 1000, "interval in milliseconds")
-		wtf        = flag.Int("w", 100, "wait time in milliseconds")
+		deadline   = flag.Int("w", 0, "deadline in seconds, 0 for none (formerly the per-reply wait in milliseconds, now -W)")
+		wtf        = flag.Int("W", 100, "time to wait for each reply in milliseconds (formerly -w)")
+		untilReply = flag.Bool("until-reply", false, "Stop at the first reply")
 		audible    = flag.Bool("a", false, "Audible rings a bell when a packet is received")
+		flood      = flag.Bool("f", false, "Flood: send the next request as soon as the previous one is answered")
+		adaptive   = flag.Bool("A", false, "Adaptive: pace the requests by the round trip time")
+		sweep      = flag.Bool("g", false, "Sweep: ping every host of the destination network, e.g. 192.168.1.0/24")
+		broadcast  = flag.Bool("b", false, "Allow pinging a broadcast address")
+		ttl        = flag.Int("t", 0, "TTL of the requests, 0 for the system's default")
+		clockName  = flag.String("clock", "software", "Clock to take round trip times with: software, kernel or hardware")
+		output     = flag.String("output", outputText, "Output format: text, json or ndjson")
 	)
 
 	flag.Usage = util.Usage(flag.Usage, usage)
@@ -176,11 +336,35 @@ func main() {
 		os.Exit(1)
 	}
 	host := flag.Args()[0]
-	cmd, err := command(os.Stdout, params{*packetSize, *intv, *wtf, *iter, host, *net6, *audible})
+	clock, err := ping.ParseClock(*clockName)
 	if err != nil {
-		log.Fatal(err)
+		flag.Usage()
+		os.Exit(1)
+	}
+	switch *output {
+	case outputText, outputJSON, outputNDJSON:
+	default:
+		log.Fatalf("%v: %q", errOutput, *output)
+	}
+	// Sweeps default to one request per host and a short pause between
+	// them, unless set.
+	if *sweep {
+		set := map[string]bool{}
+		flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		if !set["c"] {
+			*iter = 1
+		}
+		if !set["i"] {
+			*intv = int(ping.DEFSWEEPINTERVAL / time.Millisecond)
+		}
+		if !set["W"] {
+			*wtf = int(ping.DEFSWEEPTIMEOUT / time.Millisecond)
+		}
 	}
-	if err := cmd.run(); err != nil {
+	// Interrupting ping ends it like reaching the count does.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := command(os.Stdout, params{*packetSize, *intv, *wtf, *deadline, *untilReply, *iter, host, *net6, *audible, *flood, *adaptive, *sweep, clock, *broadcast, *ttl, *output}).run(ctx); err != nil {
 		log.Fatal(err)
 	}
 }
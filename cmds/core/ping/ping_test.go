@@ -6,6 +6,8 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"net"
 	"strconv"
 	"strings"
@@ -85,8 +87,9 @@ func parsePingLines(t *testing.T, output []byte) []pingOutputLine {
 	var lines []pingOutputLine
 
 	for _, line := range bytes.Split(output, []byte("\n")) {
+		// The statistics follow an empty line.
 		if len(line) == 0 {
-			continue
+			break
 		}
 
 		var pl pingOutputLine
@@ -139,7 +142,7 @@ func TestPing(t *testing.T) {
 		},
 	}
 
-	err := cmd.run()
+	err := cmd.run(context.Background())
 	if err != nil {
 		t.Error(err)
 	}
@@ -163,21 +166,43 @@ func TestPing(t *testing.T) {
 	}
 }
 
+func TestPingFlood(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	cmd := &cmd{
+		stdout: stdout,
+		conn:   &testConn{},
+		params: params{
+			host:       "1.1.1.1",
+			packetSize: 56,
+			wtf:        100,
+			iter:       3,
+			flood:      true,
+		},
+	}
+	if err := cmd.run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	out := stdout.String()
+	if want := strings.Repeat(".\b \b", 3) + "\n"; !strings.HasPrefix(out, want) {
+		t.Errorf("output = %q, want it to start with %q", out, want)
+	}
+	if want := "3 packets transmitted, 3 received, 0.0% packet loss"; !strings.Contains(out, want) {
+		t.Errorf("output = %q, want it to contain %q", out, want)
+	}
+}
+
 func TestRawPing(t *testing.T) {
 	guest.SkipIfNotInVM(t)
 	stdout := &bytes.Buffer{}
-	cmd, err := command(stdout, params{
+	cmd := command(stdout, params{
 		packetSize: 56,
 		host:       "127.0.0.1",
 		intv:       1000,
 		wtf:        100,
 		iter:       1,
 	})
-	if err != nil {
-		t.Errorf("command() failed: %v", err)
-	}
 
-	err = cmd.run()
+	err := cmd.run(context.Background())
 	if err != nil {
 		t.Errorf("run() failed: %v", err)
 	}
@@ -201,3 +226,63 @@ func TestRawPing(t *testing.T) {
 		t.Errorf("expected addr, got %s", lines[0].addr)
 	}
 }
+
+func TestPingNDJSON(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	cmd := &cmd{
+		stdout: stdout,
+		conn:   &testConn{},
+		params: params{
+			host:       "192.0.2.1",
+			packetSize: 56,
+			intv:       1,
+			wtf:        100,
+			iter:       2,
+			output:     outputNDJSON,
+		},
+	}
+	if err := cmd.run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	dec := json.NewDecoder(stdout)
+	var types []string
+	for dec.More() {
+		var rec struct {
+			Type string `json:"type"`
+			Seq  int    `json:"seq"`
+			Sent int    `json:"sent"`
+		}
+		if err := dec.Decode(&rec); err != nil {
+			t.Fatalf("output is not NDJSON: %v", err)
+		}
+		types = append(types, rec.Type)
+		if rec.Type == "summary" && rec.Sent != 2 {
+			t.Errorf("summary = %+v, want 2 requests sent", rec)
+		}
+	}
+	if got, want := strings.Join(types, ","), "reply,reply,summary"; got != want {
+		t.Errorf("records = %s, want %s", got, want)
+	}
+}
+
+func TestPingUntilReply(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	cmd := &cmd{
+		stdout: stdout,
+		conn:   &testConn{},
+		params: params{
+			host:       "192.0.2.1",
+			packetSize: 56,
+			intv:       1,
+			wtf:        100,
+			deadline:   30,
+			untilReply: true,
+		},
+	}
+	if err := cmd.run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if want := "1 packets transmitted, 1 received"; !strings.Contains(stdout.String(), want) {
+		t.Errorf("output = %q, want it to contain %q", stdout.String(), want)
+	}
+}
@@ -6,147 +6,371 @@
 //
 // Synopsis:
 //
-//	scp [-t|-f] [FILE]
+//	scp [-r] [-p] [-d] -t TARGET
+//	scp [-r] [-p] -f FILE...
 //
 // Description:
 //
-//	If -t is given, decode SCP protocol from stdin and write to FILE.
-//	If -f is given, stream FILE over SCP protocol to stdout.
+//	If -t is given, decode SCP protocol from stdin and write to TARGET.
+//	If -f is given, stream FILEs over SCP protocol to stdout.
+//
+//	This is the remote end of OpenSSH's scp, which sshd runs for
+//	"scp -O"; newer clients use the sftp subsystem instead.
 //
 // Options:
 //
 //	-t: Act as the target
 //	-f: Act as the source
+//	-r: Copy directories recursively
+//	-p: Preserve modification and access times
+//	-d: TARGET must be a directory
 //	-v: Passed if SCP is verbose, ignored
 package main
 
 import (
+	"bufio"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const (
 	SUCCESS = 0
+	WARNING = 1
+	ERROR   = 2
 )
 
 var (
-	isTarget = flag.Bool("t", false, "Act as the target")
-	isSource = flag.Bool("f", false, "Act as the source")
-	_        = flag.Bool("v", false, "Ignored")
+	isTarget  = flag.Bool("t", false, "Act as the target")
+	isSource  = flag.Bool("f", false, "Act as the source")
+	recursive = flag.Bool("r", false, "Copy directories recursively")
+	preserve  = flag.Bool("p", false, "Preserve modification and access times")
+	targetDir = flag.Bool("d", false, "Target must be a directory")
+	_         = flag.Bool("v", false, "Ignored")
 )
 
-func scpSingleSource(w io.Writer, r io.Reader, pth string) error {
-	f, err := os.Open(pth)
+var (
+	errProtocol = errors.New("protocol error")
+	errName     = errors.New("invalid file name")
+)
+
+// scp is one end of an SCP session.
+type scp struct {
+	w io.Writer
+	r *bufio.Reader
+
+	recursive bool
+	preserve  bool
+	targetDir bool
+}
+
+func newSCP(w io.Writer, r io.Reader) *scp {
+	return &scp{w: w, r: bufio.NewReader(r)}
+}
+
+// ack reads the response of the peer, an error if it is not SUCCESS.
+func (s *scp) ack() error {
+	b, err := s.r.ReadByte()
 	if err != nil {
 		return err
 	}
+	if b == SUCCESS {
+		return nil
+	}
+	msg, _ := s.r.ReadString('\n')
+	return fmt.Errorf("response was not success: %q", strings.TrimSuffix(msg, "\n"))
+}
+
+// fail tells the peer about err and returns it.
+func (s *scp) fail(err error) error {
+	fmt.Fprintf(s.w, "%cscp: %v\n", WARNING, err)
+	return err
+}
+
+// send writes the protocol message line and waits for its ack.
+func (s *scp) send(format string, args ...interface{}) error {
+	fmt.Fprintf(s.w, format, args...)
+	return s.ack()
+}
+
+func (s *scp) sendTimes(fi os.FileInfo) error {
+	if !s.preserve {
+		return nil
+	}
+	t := fi.ModTime().Unix()
+	return s.send("T%d 0 %d 0\n", t, t)
+}
+
+func (s *scp) sendFile(pth string, fi os.FileInfo) error {
+	f, err := os.Open(pth)
+	if err != nil {
+		return s.fail(err)
+	}
 	defer f.Close()
-	s, err := f.Stat()
+	if err := s.sendTimes(fi); err != nil {
+		return err
+	}
+	if err := s.send("C%04o %d %s\n", fi.Mode().Perm(), fi.Size(), path.Base(pth)); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(s.w, f, fi.Size()); err != nil {
+		return fmt.Errorf("copy error: %v", err)
+	}
+	reply(s.w, SUCCESS)
+	return s.ack()
+}
+
+func (s *scp) sendDir(pth string, fi os.FileInfo) error {
+	entries, err := os.ReadDir(pth)
 	if err != nil {
+		return s.fail(err)
+	}
+	if err := s.sendTimes(fi); err != nil {
 		return err
 	}
-	filename := path.Base(pth)
-	fmt.Fprintf(w, "C0%o %d %s\n", s.Mode(), s.Size(), filename)
-	if response(r) != SUCCESS {
-		return fmt.Errorf("response was not success")
+	if err := s.send("D%04o 0 %s\n", fi.Mode().Perm(), path.Base(pth)); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := s.source(filepath.Join(pth, e.Name())); err != nil {
+			return err
+		}
 	}
-	_, err = io.Copy(w, f)
+	return s.send("E\n")
+}
+
+// source sends pth, and what is in it if it is a directory and recursive
+// is set.
+func (s *scp) source(pth string) error {
+	fi, err := os.Stat(pth)
 	if err != nil {
-		return fmt.Errorf("copy error: %v", err)
+		return s.fail(err)
+	}
+	switch {
+	case fi.Mode().IsRegular():
+		return s.sendFile(pth, fi)
+	case fi.IsDir() && s.recursive:
+		return s.sendDir(pth, fi)
+	case fi.IsDir():
+		return s.fail(fmt.Errorf("%s: not a regular file, use -r", pth))
+	default:
+		return s.fail(fmt.Errorf("%s: not a regular file", pth))
 	}
-	reply(w, SUCCESS)
+}
 
-	if response(r) != SUCCESS {
-		return fmt.Errorf("response was not success")
+// parseEntry parses the mode, size and name of a C or D message line.
+func parseEntry(line string) (os.FileMode, int64, string, error) {
+	f := strings.SplitN(line[1:], " ", 3)
+	if len(f) != 3 {
+		return 0, 0, "", fmt.Errorf("%w: %q", errProtocol, line)
 	}
-	return nil
+	mode, err := strconv.ParseUint(f[0], 8, 32)
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("%w: mode in %q", errProtocol, line)
+	}
+	size, err := strconv.ParseInt(f[1], 10, 64)
+	if err != nil || size < 0 {
+		return 0, 0, "", fmt.Errorf("%w: size in %q", errProtocol, line)
+	}
+	// The peer must not write outside of the target.
+	if f[2] == "" || f[2] == "." || f[2] == ".." || strings.Contains(f[2], "/") {
+		return 0, 0, "", fmt.Errorf("%w: %q", errName, f[2])
+	}
+	return os.FileMode(mode).Perm(), size, f[2], nil
 }
 
-func scpSingleSink(w io.Writer, r io.Reader, path string) error {
-	var mode os.FileMode
-	var size int64
-	filename := ""
+// times is a time message waiting for the file it is about.
+type times struct {
+	set          bool
+	mtime, atime time.Time
+}
 
-	// Ignore the filename, assume it has been provided on the command line.
-	// This will not work with directories and recursive copy, but that's not
-	// supported right now.
-	if _, err := fmt.Fscanf(r, "C0%o %d %s\n", &mode, &size, &filename); err != nil {
-		if err == io.ErrUnexpectedEOF {
-			return io.EOF
-		}
-		return fmt.Errorf("fscanf: %v", err)
+func parseTimes(line string) (times, error) {
+	var m, mu, a, au int64
+	if _, err := fmt.Sscanf(line, "T%d %d %d %d", &m, &mu, &a, &au); err != nil {
+		return times{}, fmt.Errorf("%w: %q", errProtocol, line)
 	}
-	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, mode)
+	return times{set: true, mtime: time.Unix(m, mu*1000), atime: time.Unix(a, au*1000)}, nil
+}
+
+func (t times) apply(pth string) error {
+	if !t.set {
+		return nil
+	}
+	return os.Chtimes(pth, t.atime, t.mtime)
+}
+
+// receive writes the file of a C message line to pth.
+func (s *scp) receive(pth string, mode os.FileMode, size int64, t times) error {
+	f, err := os.OpenFile(pth, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
 	if err != nil {
-		return fmt.Errorf("open error: %v", err)
+		return s.fail(fmt.Errorf("open error: %v", err))
 	}
-	reply(w, SUCCESS)
 	defer f.Close()
+	reply(s.w, SUCCESS)
 
-	_, err = io.CopyN(f, r, size)
-	if err != nil {
+	if _, err := io.CopyN(f, s.r, size); err != nil {
 		return fmt.Errorf("copy error: %v", err)
 	}
-	if response(r) != SUCCESS {
-		return fmt.Errorf("response was not success")
+	if err := s.ack(); err != nil {
+		return err
+	}
+	if s.preserve {
+		if err := f.Chmod(mode); err != nil {
+			return s.fail(err)
+		}
 	}
-	reply(w, SUCCESS)
+	if err := t.apply(pth); err != nil {
+		return s.fail(err)
+	}
+	reply(s.w, SUCCESS)
 	return nil
 }
 
-func scpSource(w io.Writer, r io.Reader, path string) error {
-	// Sink->Source is started with a response
-	if response(r) != SUCCESS {
-		return fmt.Errorf("response was not success")
+// sink writes what the peer sends to target: into it if it is a
+// directory, as it otherwise.
+func (s *scp) sink(target string) error {
+	fi, err := os.Stat(target)
+	isDir := err == nil && fi.IsDir()
+	if s.targetDir && !isDir {
+		return s.fail(fmt.Errorf("%s: not a directory", target))
 	}
-	return scpSingleSource(w, r, path)
-}
 
-func scpSink(w io.Writer, r io.Reader, path string) error {
-	reply(w, SUCCESS)
+	type dir struct {
+		path  string
+		times times
+	}
+	var dirs []dir
+	var t times
+	reply(s.w, SUCCESS)
 	for {
-		if err := scpSingleSink(w, r, path); err != nil {
-			if err == io.EOF {
-				break
+		line, err := s.r.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", errProtocol, err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+
+		switch line[0] {
+		case WARNING:
+			log.Printf("scp: %s", line[1:])
+			continue
+		case ERROR:
+			return fmt.Errorf("peer error: %s", line[1:])
+		case 'T':
+			if t, err = parseTimes(line); err != nil {
+				return s.fail(err)
 			}
-			return err
+			reply(s.w, SUCCESS)
+			continue
+		case 'E':
+			if len(dirs) == 0 {
+				return s.fail(fmt.Errorf("%w: unexpected E", errProtocol))
+			}
+			d := dirs[len(dirs)-1]
+			dirs = dirs[:len(dirs)-1]
+			if err := d.times.apply(d.path); err != nil {
+				return s.fail(err)
+			}
+			reply(s.w, SUCCESS)
+			continue
+		case 'C', 'D':
+		default:
+			return s.fail(fmt.Errorf("%w: %q", errProtocol, line))
+		}
+
+		mode, size, name, err := parseEntry(line)
+		if err != nil {
+			return s.fail(err)
+		}
+		pth := target
+		switch {
+		case len(dirs) > 0:
+			pth = filepath.Join(dirs[len(dirs)-1].path, name)
+		case isDir:
+			pth = filepath.Join(target, name)
+		}
+
+		if line[0] == 'C' {
+			if err := s.receive(pth, mode, size, t); err != nil {
+				return err
+			}
+			t = times{}
+			continue
 		}
+
+		if !s.recursive {
+			return s.fail(fmt.Errorf("%s: received directory without -r", name))
+		}
+		if err := os.Mkdir(pth, mode|0o700); err != nil && !errors.Is(err, os.ErrExist) {
+			return s.fail(err)
+		}
+		dirs = append(dirs, dir{path: pth, times: t})
+		t = times{}
+		reply(s.w, SUCCESS)
 	}
-	return nil
 }
 
-func reply(out io.Writer, r byte) {
-	out.Write([]byte{r})
+func scpSource(w io.Writer, r io.Reader, path string) error {
+	s := newSCP(w, r)
+	// Sink->Source is started with a response
+	if err := s.ack(); err != nil {
+		return err
+	}
+	return s.source(path)
 }
 
-func response(in io.Reader) byte {
-	b := make([]byte, 1)
-	in.Read(b)
-	return b[0]
+func scpSink(w io.Writer, r io.Reader, path string) error {
+	return newSCP(w, r).sink(path)
 }
 
-func main() {
-	flag.Parse()
+func reply(out io.Writer, r byte) {
+	out.Write([]byte{r})
+}
 
-	if flag.NArg() == 0 {
-		log.Fatalf("no file provided")
+func run(w io.Writer, r io.Reader, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("no file provided")
 	}
 
 	if *isSource == *isTarget {
-		log.Fatalf("-t or -f needs to be supplied, and not both")
+		return fmt.Errorf("-t or -f needs to be supplied, and not both")
 	}
 
+	s := newSCP(w, r)
+	s.recursive, s.preserve, s.targetDir = *recursive, *preserve, *targetDir
 	if *isSource {
-		if err := scpSource(os.Stdout, os.Stdin, flag.Args()[0]); err != nil {
-			log.Fatalf("scp: %v", err)
+		// Sink->Source is started with a response
+		if err := s.ack(); err != nil {
+			return err
 		}
-	} else if *isTarget {
-		if err := scpSink(os.Stdout, os.Stdin, flag.Args()[0]); err != nil {
-			log.Fatalf("scp: %v", err)
+		var errs []error
+		for _, p := range args {
+			if err := s.source(p); err != nil {
+				errs = append(errs, err)
+			}
 		}
+		return errors.Join(errs...)
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("only one target allowed")
+	}
+	return s.sink(args[0])
+}
+
+func main() {
+	flag.Parse()
+	if err := run(os.Stdout, os.Stdin, flag.Args()); err != nil {
+		log.Fatalf("scp: %v", err)
 	}
 }
@@ -5,12 +5,17 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestScpSource(t *testing.T) {
@@ -24,7 +29,8 @@ func TestScpSource(t *testing.T) {
 	defer os.Remove(tf.Name())
 	tf.Write([]byte("test-file-contents"))
 
-	r.Write([]byte{0})
+	// Start, then acks of the C line and of the contents.
+	r.Write([]byte{0, 0, 0})
 	err = scpSource(&w, &r, tf.Name())
 	if err != nil {
 		t.Fatalf("error: %v", err)
@@ -82,3 +88,123 @@ func TestScpSink(t *testing.T) {
 		t.Fatalf("Expected 'test-file-contents', got '%v'", string(m))
 	}
 }
+
+// copyTree runs a source of src into a sink of dst.
+func copyTree(t *testing.T, src, dst string, recursive, preserve bool) (error, error) {
+	t.Helper()
+	sr, sw := io.Pipe()
+	tr, tw := io.Pipe()
+	source := &scp{w: sw, r: bufio.NewReader(tr), recursive: recursive, preserve: preserve}
+	sink := &scp{w: tw, r: bufio.NewReader(sr), recursive: recursive, preserve: preserve}
+
+	done := make(chan error)
+	go func() {
+		err := sink.sink(dst)
+		// Unblock the source if the sink gave up.
+		tr.Close()
+		done <- err
+	}()
+	err := source.ack()
+	if err == nil {
+		err = source.source(src)
+	}
+	sw.Close()
+	return err, <-done
+}
+
+func TestRecursive(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "src")
+	for _, d := range []string{"src/sub", "src/empty"} {
+		if err := os.MkdirAll(filepath.Join(filepath.Dir(src), d), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(src, "a"), []byte("a contents"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "sub", "b"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Unix(1500000000, 0)
+	for _, p := range []string{"a", "sub/b", "sub", ""} {
+		if err := os.Chtimes(filepath.Join(src, p), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range []struct {
+		name      string
+		recursive bool
+		preserve  bool
+		wantErr   bool
+	}{
+		{name: "recursive", recursive: true},
+		{name: "preserve", recursive: true, preserve: true},
+		{name: "not recursive", wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := t.TempDir()
+			serr, terr := copyTree(t, src, dst, tt.recursive, tt.preserve)
+			if tt.wantErr {
+				if serr == nil {
+					t.Fatal("source succeeded, want an error")
+				}
+				return
+			}
+			if serr != nil || terr != nil {
+				t.Fatalf("source: %v, sink: %v", serr, terr)
+			}
+
+			for _, f := range []struct {
+				path     string
+				contents string
+				perm     os.FileMode
+			}{
+				{path: "src/a", contents: "a contents", perm: 0o640},
+				{path: "src/sub/b", perm: 0o600},
+			} {
+				p := filepath.Join(dst, f.path)
+				b, err := os.ReadFile(p)
+				if err != nil || string(b) != f.contents {
+					t.Errorf("%s: %q, %v, want %q", f.path, b, err, f.contents)
+				}
+				fi, err := os.Stat(p)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if fi.Mode().Perm() != f.perm {
+					t.Errorf("%s: mode %v, want %v", f.path, fi.Mode().Perm(), f.perm)
+				}
+			}
+			if fi, err := os.Stat(filepath.Join(dst, "src", "empty")); err != nil || !fi.IsDir() {
+				t.Errorf("src/empty: %v, want a directory", err)
+			}
+			for _, p := range []string{"src/a", "src/sub/b", "src/sub", "src"} {
+				fi, err := os.Stat(filepath.Join(dst, p))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := fi.ModTime().Equal(mtime); got != tt.preserve {
+					t.Errorf("%s: mtime %v, preserved %t, want %t", p, fi.ModTime(), got, tt.preserve)
+				}
+			}
+		})
+	}
+}
+
+func TestSinkNames(t *testing.T) {
+	for _, name := range []string{"..", ".", "a/b", "/etc/passwd", ""} {
+		t.Run(name, func(t *testing.T) {
+			var w bytes.Buffer
+			r := strings.NewReader(fmt.Sprintf("C0600 1 %s\nx\x00", name))
+			s := newSCP(&w, r)
+			s.recursive = true
+			if err := s.sink(t.TempDir()); !errors.Is(err, errName) {
+				t.Errorf("sink() = %v, want %v", err, errName)
+			}
+			if !strings.HasPrefix(w.String(), "\x00\x01scp: ") {
+				t.Errorf("sink wrote %q, want an error message", w.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// directTCPIPReq is the payload of a direct-tcpip channel, RFC 4254 7.2.
+type directTCPIPReq struct {
+	DestAddr string
+	DestPort uint32
+	OrigAddr string
+	OrigPort uint32
+}
+
+// dialTimeout bounds connecting to the destination of a forwarding.
+const dialTimeout = 10 * time.Second
+
+// closeWriter is implemented by connections that can be half closed.
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// forward connects a direct-tcpip channel, as opened by ssh -L and -J, to
+// its destination.
+func forward(newChannel ssh.NewChannel, perms *ssh.Permissions) {
+	if _, ok := perms.Extensions[permitForwarding]; !ok {
+		newChannel.Reject(ssh.Prohibited, "port forwarding is not permitted")
+		return
+	}
+	r := &directTCPIPReq{}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), r); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, "invalid direct-tcpip request")
+		return
+	}
+	addr := net.JoinHostPort(r.DestAddr, strconv.FormatUint(uint64(r.DestPort), 10))
+	if !permitted(perms, r.DestAddr, r.DestPort) {
+		newChannel.Reject(ssh.Prohibited, "port forwarding to "+addr+" is not permitted")
+		return
+	}
+	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		log.Printf("Could not accept channel: %v", err)
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	log.Printf("Forwarding %s:%d to %s", r.OrigAddr, r.OrigPort, addr)
+	splice(channel, conn)
+}
+
+// splice copies between a and b until both directions are done, passing
+// on half closes.
+func splice(a, b io.ReadWriteCloser) {
+	var wg sync.WaitGroup
+	cp := func(dst, src io.ReadWriteCloser) {
+		defer wg.Done()
+		io.Copy(dst, src)
+		if c, ok := dst.(closeWriter); ok {
+			c.CloseWrite()
+		} else {
+			dst.Close()
+		}
+	}
+	wg.Add(2)
+	go cp(a, b)
+	go cp(b, a)
+	wg.Wait()
+	a.Close()
+	b.Close()
+}
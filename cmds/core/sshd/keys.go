@@ -0,0 +1,337 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Permissions of a key, named like the OpenSSH certificate extensions and
+// critical options they stand for.
+const (
+	permitPTY        = "permit-pty"
+	permitForwarding = "permit-port-forwarding"
+	forceCommand     = "force-command"
+	fingerprint      = "pubkey-fp"
+)
+
+// Restrictions of a key, as critical options, checked by authorize and
+// permitted.
+const (
+	// sourceAddress holds the patterns of from=.
+	sourceAddress = "source-address"
+	// validBefore holds expiry-time= as Unix seconds.
+	validBefore = "valid-before"
+	// permitOpen holds the host:port destinations of permitopen=.
+	permitOpen = "permit-open"
+)
+
+// originalCommand is the environment variable a forced command finds the
+// requested one in.
+const originalCommand = "SSH_ORIGINAL_COMMAND"
+
+var (
+	errOption            = errors.New("invalid authorized_keys option")
+	errUnsupportedOption = errors.New("unsupported authorized_keys option")
+)
+
+// hostKey returns the host key in path. If it does not exist and generate
+// is set, an ed25519 key is generated and saved there first.
+func hostKey(path string, generate bool) (ssh.Signer, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && generate {
+		b, err = generateHostKey(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ssh.ParsePrivateKey(b)
+}
+
+// generateHostKey saves a new ed25519 key in OpenSSH format to path and
+// returns it.
+func generateHostKey(path string) ([]byte, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "u-root sshd host key")
+	if err != nil {
+		return nil, err
+	}
+	b := pem.EncodeToMemory(block)
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		return nil, err
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Generated host key %s %s", path, ssh.FingerprintSHA256(signer.PublicKey()))
+	return b, nil
+}
+
+// unquote returns the value of an option, which is quoted and may escape
+// quotes with a backslash.
+func unquote(v string) (string, error) {
+	if len(v) < 2 || v[0] != '"' || v[len(v)-1] != '"' {
+		return "", fmt.Errorf("%w: %q is not quoted", errOption, v)
+	}
+	return strings.ReplaceAll(v[1:len(v)-1], `\"`, `"`), nil
+}
+
+// parseFrom checks the patterns of a from= option. They are addresses with
+// the wildcards * and ?, or CIDR address/masklen, and negated by a !.
+func parseFrom(v string) error {
+	for _, pat := range strings.Split(v, ",") {
+		pat = strings.TrimPrefix(pat, "!")
+		if pat == "" {
+			return fmt.Errorf("%w: empty from pattern in %q", errOption, v)
+		}
+		if strings.Contains(pat, "/") {
+			if _, _, err := net.ParseCIDR(pat); err != nil {
+				return fmt.Errorf("%w: %v", errOption, err)
+			}
+		}
+	}
+	return nil
+}
+
+// parseExpiry parses the YYYYMMDD[HHMM[SS]] time of an expiry-time= option,
+// in the local time zone, or in UTC with a Z suffix.
+func parseExpiry(v string) (time.Time, error) {
+	loc := time.Local
+	if strings.HasSuffix(v, "Z") {
+		v, loc = strings.TrimSuffix(v, "Z"), time.UTC
+	}
+	for _, layout := range []string{"20060102", "200601021504", "20060102150405"} {
+		if len(v) == len(layout) {
+			t, err := time.ParseInLocation(layout, v, loc)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("%w: %v", errOption, err)
+			}
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%w: expiry time %q is not YYYYMMDD[HHMM[SS]]", errOption, v)
+}
+
+// parsePermitOpen checks the host:port destination of a permitopen= option.
+// Host and port may be *.
+func parsePermitOpen(v string) error {
+	host, port, err := net.SplitHostPort(v)
+	if err != nil || host == "" {
+		return fmt.Errorf("%w: permitopen %q is not host:port", errOption, v)
+	}
+	if port == "*" {
+		return nil
+	}
+	if n, err := strconv.ParseUint(port, 10, 16); err != nil || n == 0 {
+		return fmt.Errorf("%w: invalid permitopen port in %q", errOption, v)
+	}
+	return nil
+}
+
+// permissions returns the permissions of a key with the authorized_keys
+// options opts. Options of features sshd does not have, e.g. agent
+// forwarding, hold without doing anything. Other options sshd does not
+// know fail with errUnsupportedOption, as the key would be allowed more than
+// they say otherwise.
+func permissions(pubKey ssh.PublicKey, opts []string) (*ssh.Permissions, error) {
+	p := &ssh.Permissions{
+		CriticalOptions: map[string]string{},
+		Extensions: map[string]string{
+			fingerprint:      ssh.FingerprintSHA256(pubKey),
+			permitPTY:        "",
+			permitForwarding: "",
+		},
+	}
+	for _, o := range opts {
+		name, value, hasValue := strings.Cut(o, "=")
+		name = strings.ToLower(name)
+		switch name {
+		case "command", "from", "expiry-time", "permitopen":
+			if !hasValue {
+				return nil, fmt.Errorf("%w: %q", errOption, o)
+			}
+			var err error
+			if value, err = unquote(value); err != nil {
+				return nil, err
+			}
+		}
+		switch name {
+		case "command":
+			p.CriticalOptions[forceCommand] = value
+		case "from":
+			if err := parseFrom(value); err != nil {
+				return nil, err
+			}
+			if _, ok := p.CriticalOptions[sourceAddress]; ok {
+				return nil, fmt.Errorf("%w: more than one from", errOption)
+			}
+			p.CriticalOptions[sourceAddress] = value
+		case "expiry-time":
+			t, err := parseExpiry(value)
+			if err != nil {
+				return nil, err
+			}
+			before := t.Unix()
+			// The earliest of several expiry times counts.
+			if v, ok := p.CriticalOptions[validBefore]; ok {
+				if earlier, _ := strconv.ParseInt(v, 10, 64); earlier < before {
+					before = earlier
+				}
+			}
+			p.CriticalOptions[validBefore] = strconv.FormatInt(before, 10)
+		case "permitopen":
+			if err := parsePermitOpen(value); err != nil {
+				return nil, err
+			}
+			if open, ok := p.CriticalOptions[permitOpen]; ok {
+				value = open + "," + value
+			}
+			p.CriticalOptions[permitOpen] = value
+		case "restrict":
+			delete(p.Extensions, permitPTY)
+			delete(p.Extensions, permitForwarding)
+		case "no-pty":
+			delete(p.Extensions, permitPTY)
+		case "pty":
+			p.Extensions[permitPTY] = ""
+		case "no-port-forwarding":
+			delete(p.Extensions, permitForwarding)
+		case "port-forwarding":
+			p.Extensions[permitForwarding] = ""
+		case "agent-forwarding", "no-agent-forwarding", "x11-forwarding", "no-x11-forwarding",
+			"user-rc", "no-user-rc", "permitlisten", "tunnel", "environment", "no-touch-required":
+			dprintf("Ignoring authorized_keys option %q of a feature sshd does not have", o)
+		default:
+			return nil, fmt.Errorf("%w: %q", errUnsupportedOption, o)
+		}
+	}
+	return p, nil
+}
+
+// wildcard matches s against pattern, in which * matches any number of
+// characters and ? one.
+func wildcard(pattern, s string) bool {
+	for ; pattern != ""; pattern = pattern[1:] {
+		switch pattern[0] {
+		case '*':
+			for i := 0; i <= len(s); i++ {
+				if wildcard(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if s == "" {
+				return false
+			}
+		default:
+			if s == "" || pattern[0] != s[0] {
+				return false
+			}
+		}
+		s = s[1:]
+	}
+	return s == ""
+}
+
+// matchFrom returns whether ip matches the patterns of a from= option: one
+// of them, and none negated.
+func matchFrom(patterns string, ip net.IP) bool {
+	match := false
+	for _, pat := range strings.Split(patterns, ",") {
+		pat, negated := strings.CutPrefix(pat, "!")
+		var m bool
+		if _, n, err := net.ParseCIDR(pat); err == nil {
+			m = n.Contains(ip)
+		} else {
+			m = wildcard(strings.ToLower(pat), ip.String())
+		}
+		if m && negated {
+			return false
+		}
+		match = match || m
+	}
+	return match
+}
+
+// authorize checks the from= and expiry-time= options in p for a login from
+// addr at now.
+func authorize(p *ssh.Permissions, addr net.Addr, now time.Time) error {
+	if from, ok := p.CriticalOptions[sourceAddress]; ok {
+		host, _, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			return err
+		}
+		if ip := net.ParseIP(host); ip == nil || !matchFrom(from, ip) {
+			return fmt.Errorf("key is not permitted from %s", host)
+		}
+	}
+	if v, ok := p.CriticalOptions[validBefore]; ok {
+		before, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return err
+		}
+		if !now.Before(time.Unix(before, 0)) {
+			return fmt.Errorf("key expired at %v", time.Unix(before, 0))
+		}
+	}
+	return nil
+}
+
+// permitted returns whether the permitopen= destinations in p allow
+// forwarding to host and port.
+func permitted(p *ssh.Permissions, host string, port uint32) bool {
+	open, ok := p.CriticalOptions[permitOpen]
+	if !ok {
+		return true
+	}
+	for _, dest := range strings.Split(open, ",") {
+		h, po, _ := net.SplitHostPort(dest)
+		if (h == "*" || strings.EqualFold(h, host)) && (po == "*" || po == strconv.FormatUint(uint64(port), 10)) {
+			return true
+		}
+	}
+	return false
+}
+
+// authorizedKeys parses an authorized_keys file and returns the
+// permissions of each key, by its wire format. Keys with options sshd does
+// not support are left out.
+func authorizedKeys(b []byte) (map[string]*ssh.Permissions, error) {
+	keys := map[string]*ssh.Permissions{}
+	for len(b) > 0 {
+		pubKey, _, opts, rest, err := ssh.ParseAuthorizedKey(b)
+		if err != nil {
+			return nil, err
+		}
+		b = rest
+		p, err := permissions(pubKey, opts)
+		if errors.Is(err, errUnsupportedOption) {
+			log.Printf("Skipping key %s: %v", ssh.FingerprintSHA256(pubKey), err)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys[string(pubKey.Marshal())] = p
+	}
+	return keys, nil
+}
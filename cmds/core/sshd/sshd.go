@@ -12,8 +12,10 @@ import (
 	"net"
 	"os"
 	"os/exec"
+	"time"
 
 	"github.com/u-root/u-root/pkg/pty"
+	"github.com/u-root/u-root/pkg/sftp"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -33,6 +35,9 @@ type (
 	exitStatusReq struct {
 		ExitStatus uint32
 	}
+	subsystemReq struct {
+		Name string
+	}
 )
 
 var (
@@ -41,18 +46,20 @@ var (
 	privkey = flag.String("privatekey", "id_rsa", "Path of private key")
 	ip      = flag.String("ip", "0.0.0.0", "ip address to listen on")
 	port    = flag.String("port", "2022", "port to listen on")
+	genkey  = flag.Bool("genkey", false, "Generate an ed25519 private key if there is none")
 	dprintf = func(string, ...interface{}) {}
 )
 
 // start a command
 // TODO: use /etc/passwd, but the Go support for that is incomplete
-func runCommand(c ssh.Channel, p *pty.Pty, cmd string, args ...string) error {
+func runCommand(c ssh.Channel, p *pty.Pty, env []string, cmd string, args ...string) error {
 	var ps *os.ProcessState
 	defer c.Close()
 
 	if p != nil {
 		log.Printf("Executing PTY command %s %v", cmd, args)
 		p.Command(cmd, args...)
+		p.C.Env = append(os.Environ(), env...)
 		if err := p.C.Start(); err != nil {
 			dprintf("Failed to execute: %v", err)
 			return err
@@ -63,10 +70,25 @@ func runCommand(c ssh.Channel, p *pty.Pty, cmd string, args ...string) error {
 		ps, _ = p.C.Process.Wait()
 	} else {
 		e := exec.Command(cmd, args...)
-		e.Stdin, e.Stdout, e.Stderr = c, c, c
+		e.Env = append(os.Environ(), env...)
+		e.Stdout, e.Stderr = c, c
+		// Waiting for a copy from c would wait for the client to close
+		// its stdin, which an scp client fetching files never does.
+		stdin, err := e.StdinPipe()
+		if err != nil {
+			return err
+		}
 		log.Printf("Executing non-PTY command %s %v", cmd, args)
 		// execute command and wait for response
-		if err := e.Run(); err != nil {
+		if err := e.Start(); err != nil {
+			dprintf("Failed to execute: %v", err)
+			return err
+		}
+		go func() {
+			io.Copy(stdin, c)
+			stdin.Close()
+		}()
+		if err := e.Wait(); err != nil {
 			dprintf("Failed to execute: %v", err)
 			return err
 		}
@@ -87,6 +109,19 @@ func runCommand(c ssh.Channel, p *pty.Pty, cmd string, args ...string) error {
 	return nil
 }
 
+// serveSFTP serves the sftp subsystem on c, which scp also uses since
+// OpenSSH 9.0.
+func serveSFTP(c ssh.Channel) {
+	defer c.Close()
+	log.Printf("Serving sftp")
+	code := uint32(0)
+	if err := sftp.Serve(c); err != nil {
+		dprintf("sftp: %v", err)
+		code = 1
+	}
+	c.SendRequest("exit-status", false, ssh.Marshal(exitStatusReq{code}))
+}
+
 func newPTY(b []byte) (*pty.Pty, error) {
 	ptyReq := &ptyReq{}
 	err := ssh.Unmarshal(b, ptyReq)
@@ -125,10 +160,24 @@ func init() {
 	}
 }
 
-func session(chans <-chan ssh.NewChannel) {
+// forced returns the command forced by the authorized_keys options of
+// perms in place of the requested one, and its environment.
+func forced(perms *ssh.Permissions, requested string) (string, []string, bool) {
+	cmd, ok := perms.CriticalOptions[forceCommand]
+	if !ok {
+		return "", nil, false
+	}
+	return cmd, []string{originalCommand + "=" + requested}, true
+}
+
+func session(perms *ssh.Permissions, chans <-chan ssh.NewChannel) {
 	var p *pty.Pty
 	// Service the incoming Channel channel.
 	for newChannel := range chans {
+		if newChannel.ChannelType() == "direct-tcpip" {
+			go forward(newChannel, perms)
+			continue
+		}
 		// Channels have a type, depending on the application level
 		// protocol intended. In the case of a shell, the type is
 		// "session" and ServerShell may be used to present a simple
@@ -151,8 +200,15 @@ func session(chans <-chan ssh.NewChannel) {
 				dprintf("Request %v", req.Type)
 				switch req.Type {
 				case "shell":
-					err := runCommand(channel, p, shell)
-					req.Reply(true, []byte(fmt.Sprintf("%v", err)))
+					// Clients wait for the reply before reading
+					// the output, and runCommand closes the
+					// channel, so reply first.
+					req.Reply(true, nil)
+					if cmd, env, ok := forced(perms, ""); ok {
+						runCommand(channel, p, env, shell, "-c", cmd)
+					} else {
+						runCommand(channel, p, nil, shell)
+					}
 				case "exec":
 					e := &execReq{}
 					if err := ssh.Unmarshal(req.Payload, e); err != nil {
@@ -161,9 +217,32 @@ func session(chans <-chan ssh.NewChannel) {
 					}
 					// Execute command using user's shell. This is what OpenSSH does
 					// so it's the least surprising to the user.
-					err := runCommand(channel, p, shell, "-c", e.Command)
-					req.Reply(true, []byte(fmt.Sprintf("%v", err)))
+					cmd, env, ok := forced(perms, e.Command)
+					if !ok {
+						cmd = e.Command
+					}
+					req.Reply(true, nil)
+					runCommand(channel, p, env, shell, "-c", cmd)
+				case "subsystem":
+					s := &subsystemReq{}
+					if err := ssh.Unmarshal(req.Payload, s); err != nil || s.Name != "sftp" {
+						log.Printf("Not handling subsystem %q", s.Name)
+						req.Reply(false, nil)
+						break
+					}
+					// Forced commands replace subsystems too.
+					req.Reply(true, nil)
+					if cmd, env, ok := forced(perms, ""); ok {
+						runCommand(channel, p, env, shell, "-c", cmd)
+						break
+					}
+					go serveSFTP(channel)
 				case "pty-req":
+					if _, ok := perms.Extensions[permitPTY]; !ok {
+						log.Printf("PTY not permitted")
+						req.Reply(false, nil)
+						break
+					}
 					p, err = newPTY(req.Payload)
 					req.Reply(err == nil, nil)
 				default:
@@ -182,6 +261,7 @@ type params struct {
 	ip      string
 	port    string
 	debug   bool
+	genkey  bool
 }
 
 func parseParams() params {
@@ -191,6 +271,7 @@ func parseParams() params {
 		privkey: *privkey,
 		ip:      *ip,
 		port:    *port,
+		genkey:  *genkey,
 	}
 }
 
@@ -216,15 +297,9 @@ func (c *cmd) run() error {
 		return err
 	}
 
-	authorizedKeysMap := map[string]bool{}
-	for len(authorizedKeysBytes) > 0 {
-		pubKey, _, _, rest, err := ssh.ParseAuthorizedKey(authorizedKeysBytes)
-		if err != nil {
-			return err
-		}
-
-		authorizedKeysMap[string(pubKey.Marshal())] = true
-		authorizedKeysBytes = rest
+	authorizedKeysMap, err := authorizedKeys(authorizedKeysBytes)
+	if err != nil {
+		return err
 	}
 
 	// An SSH server is represented by a ServerConfig, which holds
@@ -232,24 +307,19 @@ func (c *cmd) run() error {
 	config := &ssh.ServerConfig{
 		// Remove to disable public key auth.
 		PublicKeyCallback: func(c ssh.ConnMetadata, pubKey ssh.PublicKey) (*ssh.Permissions, error) {
-			if authorizedKeysMap[string(pubKey.Marshal())] {
-				return &ssh.Permissions{
-					// Record the public key used for authentication.
-					Extensions: map[string]string{
-						"pubkey-fp": ssh.FingerprintSHA256(pubKey),
-					},
-				}, nil
+			// The permissions record the public key used for
+			// authentication and its options.
+			if p, ok := authorizedKeysMap[string(pubKey.Marshal())]; ok {
+				if err := authorize(p, c.RemoteAddr(), time.Now()); err != nil {
+					return nil, err
+				}
+				return p, nil
 			}
 			return nil, fmt.Errorf("unknown public key for %q", c.User())
 		},
 	}
 
-	privateBytes, err := os.ReadFile(c.privkey)
-	if err != nil {
-		return err
-	}
-
-	private, err := ssh.ParsePrivateKey(privateBytes)
+	private, err := hostKey(c.privkey, c.genkey)
 	if err != nil {
 		return err
 	}
@@ -276,12 +346,12 @@ func (c *cmd) run() error {
 			log.Printf("failed to handshake: %v", err)
 			continue
 		}
-		log.Printf("%v logged in with key %s", conn.RemoteAddr(), conn.Permissions.Extensions["pubkey-fp"])
+		log.Printf("%v logged in with key %s", conn.RemoteAddr(), conn.Permissions.Extensions[fingerprint])
 
 		// The incoming Request channel must be serviced.
 		go ssh.DiscardRequests(reqs)
 
-		go session(chans)
+		go session(conn.Permissions, chans)
 	}
 }
 
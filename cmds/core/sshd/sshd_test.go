@@ -5,9 +5,13 @@
 package main
 
 import (
+	"bytes"
 	"errors"
+	"io"
 	"net"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -182,3 +186,293 @@ func TestSessionRun(t *testing.T) {
 		t.Errorf("expected hello u-root, got %q", string(b[:n]))
 	}
 }
+
+func TestPermissions(t *testing.T) {
+	pub, err := os.ReadFile("./testdata/id_rsa.pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		opts    string
+		command string
+		pty     bool
+		forward bool
+		skipped bool
+		wantErr error
+	}{
+		{pty: true, forward: true},
+		{opts: `no-pty `, forward: true},
+		{opts: `no-port-forwarding,from="10.0.0.0/8" `, pty: true},
+		{opts: `restrict,pty `, pty: true},
+		{opts: `command="echo \"hi\"",no-pty `, command: `echo "hi"`, forward: true},
+		{opts: `no-agent-forwarding,no-x11-forwarding,no-user-rc `, pty: true, forward: true},
+		{opts: `expiry-time="20300101",permitopen="localhost:22" `, pty: true, forward: true},
+		{opts: `command=echo `, wantErr: errOption},
+		{opts: `from="10.0.0.0/33" `, wantErr: errOption},
+		{opts: `from="10.0.0.1",from="10.0.0.2" `, wantErr: errOption},
+		{opts: `expiry-time="2030" `, wantErr: errOption},
+		{opts: `permitopen="localhost" `, wantErr: errOption},
+		// Restrictions sshd cannot enforce leave the key out.
+		{opts: `cert-authority `, skipped: true},
+		{opts: `verify-required `, skipped: true},
+		{opts: `no-pty,unknown-option `, skipped: true},
+	} {
+		t.Run(tt.opts, func(t *testing.T) {
+			keys, err := authorizedKeys(append([]byte(tt.opts), pub...))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("authorizedKeys() = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if tt.skipped {
+				if len(keys) != 0 {
+					t.Fatalf("got %d keys, want the key skipped", len(keys))
+				}
+				return
+			}
+			if len(keys) != 1 {
+				t.Fatalf("got %d keys, want 1", len(keys))
+			}
+			for _, p := range keys {
+				if got := p.CriticalOptions[forceCommand]; got != tt.command {
+					t.Errorf("command = %q, want %q", got, tt.command)
+				}
+				if _, got := p.Extensions[permitPTY]; got != tt.pty {
+					t.Errorf("pty = %t, want %t", got, tt.pty)
+				}
+				if _, got := p.Extensions[permitForwarding]; got != tt.forward {
+					t.Errorf("port forwarding = %t, want %t", got, tt.forward)
+				}
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		opts []string
+		addr string
+		ok   bool
+	}{
+		{addr: "192.0.2.1:22", ok: true},
+		{opts: []string{`from="192.0.2.0/24"`}, addr: "192.0.2.1:22", ok: true},
+		{opts: []string{`from="192.0.2.0/24"`}, addr: "198.51.100.1:22"},
+		{opts: []string{`from="192.0.2.*,!192.0.2.13"`}, addr: "192.0.2.12:22", ok: true},
+		{opts: []string{`from="192.0.2.*,!192.0.2.13"`}, addr: "192.0.2.13:22"},
+		{opts: []string{`from="192.0.2.?"`}, addr: "192.0.2.10:22"},
+		{opts: []string{`from="2001:db8::/32"`}, addr: "[2001:db8::1]:22", ok: true},
+		{opts: []string{`expiry-time="20240601120001Z"`}, addr: "192.0.2.1:22", ok: true},
+		{opts: []string{`expiry-time="20240601Z"`}, addr: "192.0.2.1:22"},
+		// The earliest expiry time counts.
+		{opts: []string{`expiry-time="20300101Z"`, `expiry-time="20240101Z"`}, addr: "192.0.2.1:22"},
+	} {
+		t.Run(strings.Join(tt.opts, ",")+" "+tt.addr, func(t *testing.T) {
+			p, err := permissions(testKey(t), tt.opts)
+			if err != nil {
+				t.Fatalf("permissions() = %v", err)
+			}
+			addr, err := net.ResolveTCPAddr("tcp", tt.addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := authorize(p, addr, now); (err == nil) != tt.ok {
+				t.Errorf("authorize() = %v, want ok %t", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestPermitted(t *testing.T) {
+	p, err := permissions(testKey(t), []string{`permitopen="localhost:22"`, `permitopen="[2001:db8::1]:*"`, `permitopen="*:8080"`})
+	if err != nil {
+		t.Fatalf("permissions() = %v", err)
+	}
+	for _, tt := range []struct {
+		host string
+		port uint32
+		want bool
+	}{
+		{host: "localhost", port: 22, want: true},
+		{host: "LocalHost", port: 22, want: true},
+		{host: "localhost", port: 23},
+		{host: "2001:db8::1", port: 443, want: true},
+		{host: "example.com", port: 8080, want: true},
+		{host: "example.com", port: 80},
+	} {
+		if got := permitted(p, tt.host, tt.port); got != tt.want {
+			t.Errorf("permitted(%s, %d) = %t, want %t", tt.host, tt.port, got, tt.want)
+		}
+	}
+}
+
+// testKey returns the public key in testdata.
+func testKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	b, err := os.ReadFile("./testdata/id_rsa.pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, _, _, _, err := ssh.ParseAuthorizedKey(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestHostKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ssh_host_ed25519_key")
+	if _, err := hostKey(path, false); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("hostKey() = %v, want %v", err, os.ErrNotExist)
+	}
+	k, err := hostKey(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.PublicKey().Type() != ssh.KeyAlgoED25519 {
+		t.Errorf("generated a %s key, want ed25519", k.PublicKey().Type())
+	}
+	// The key is kept.
+	again, err := hostKey(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(k.PublicKey().Marshal(), again.PublicKey().Marshal()) {
+		t.Error("loaded a different key than generated")
+	}
+}
+
+// freePort returns a port to listen on.
+func freePort(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	return port
+}
+
+// restricted starts a server accepting the test key with opts and
+// connects to it.
+func restricted(t *testing.T, opts string) *ssh.Client {
+	t.Helper()
+	pub, err := os.ReadFile("./testdata/id_rsa.pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	keys := filepath.Join(dir, "authorized_keys")
+	if err := os.WriteFile(keys, append([]byte(opts), pub...), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cmd := command(params{
+		privkey: filepath.Join(dir, "host_key"),
+		genkey:  true,
+		keys:    keys,
+		ip:      "127.0.0.1",
+		port:    freePort(t),
+	})
+	go cmd.run()
+
+	pk, err := os.ReadFile("./testdata/id_rsa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.ParsePrivateKey(pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := ssh.ClientConfig{
+		Auth:              []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback:   ssh.InsecureIgnoreHostKey(),
+		HostKeyAlgorithms: []string{ssh.KeyAlgoED25519},
+		Timeout:           time.Second,
+	}
+	clt := connect(t, net.JoinHostPort(cmd.ip, cmd.port), &cfg)
+	t.Cleanup(func() { clt.Close() })
+	return clt
+}
+
+func TestForward(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(c, c)
+		c.Close()
+	}()
+
+	clt := restricted(t, "")
+	c, err := clt.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() = %v", err)
+	}
+	if _, err := c.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	// The half close reaches the echo server, which closes in turn.
+	c.(interface{ CloseWrite() error }).CloseWrite()
+	b, err := io.ReadAll(c)
+	if err != nil || string(b) != "ping" {
+		t.Errorf("forwarded echo = %q, %v, want %q", b, err, "ping")
+	}
+	c.Close()
+}
+
+func TestRestrict(t *testing.T) {
+	clt := restricted(t, `restrict,command="echo forced $SSH_ORIGINAL_COMMAND" `)
+	if c, err := clt.Dial("tcp", "127.0.0.1:1"); err == nil {
+		c.Close()
+		t.Error("Dial() succeeded, want port forwarding to be prohibited")
+	}
+
+	session, err := clt.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	if err := session.RequestPty("xterm", 80, 24, ssh.TerminalModes{}); err == nil {
+		t.Error("RequestPty() succeeded, want it refused")
+	}
+	out, err := session.Output("echo hello")
+	if err != nil || string(out) != "forced echo hello\n" {
+		t.Errorf("Output() = %q, %v, want %q", out, err, "forced echo hello\n")
+	}
+}
+
+func TestPermitOpen(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+
+	clt := restricted(t, `permitopen="`+l.Addr().String()+`" `)
+	c, err := clt.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial(%s) = %v", l.Addr(), err)
+	}
+	c.Close()
+	if c, err := clt.Dial("tcp", "127.0.0.1:1"); err == nil {
+		c.Close()
+		t.Error("Dial() to a destination not in permitopen succeeded, want it prohibited")
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/u-root/u-root/pkg/curl"
+)
+
+// retryInterval is the wait before the first retry, it doubles after
+// each one.
+var retryInterval = time.Second
+
+var errRange = errors.New("server sent the wrong range")
+
+// backOff returns the backoff between the tries of c.
+func (c *cmd) backOff() backoff.BackOff {
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = retryInterval
+	b.MaxElapsedTime = 0
+	b.Reset()
+	return backoff.WithMaxRetries(b, uint64(c.tries-1))
+}
+
+// retry tells whether the download may go on after err: any error but
+// the HTTP codes that no retry will change.
+func retry(err error) bool {
+	var e *curl.HTTPClientCodeError
+	if errors.As(err, &e) {
+		return curl.RetryHTTP(nil, err)
+	}
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, errRange)
+}
+
+// download writes the HTTP URL u to the output file, hashing it with h.
+// Failed tries resume where the previous one stopped with a Range
+// request, and so does the first one with -c.
+func (c *cmd) download(ctx context.Context, u *url.URL, h hash.Hash) error {
+	var f *os.File
+	var offset int64
+	var err error
+	if c.outputPath == "/dev/stdout" {
+		f = os.Stdout
+	} else if c.resume {
+		if f, err = os.OpenFile(c.outputPath, os.O_RDWR|os.O_CREATE, 0o644); err != nil {
+			return err
+		}
+		defer f.Close()
+		// What is there already is part of the file to hash.
+		if offset, err = io.Copy(h, f); err != nil {
+			return err
+		}
+	} else {
+		if f, err = os.Create(c.outputPath); err != nil {
+			return err
+		}
+		defer f.Close()
+	}
+
+	b := backoff.WithContext(c.backOff(), ctx)
+	for {
+		offset, err = c.get(ctx, u, f, offset, h)
+		if err == nil || !retry(err) {
+			break
+		}
+		d := b.NextBackOff()
+		if d == backoff.Stop {
+			break
+		}
+		log.Printf("Error: Getting %v: %v, retrying in %v from byte %d", u, err, d, offset)
+		time.Sleep(d)
+	}
+	if err != nil {
+		// Do not leave an empty file behind when nothing came.
+		if offset == 0 && !c.resume && f != os.Stdout {
+			os.Remove(c.outputPath)
+		}
+		return fmt.Errorf("failed to download %v: %w", c.url, err)
+	}
+	return nil
+}
+
+// get requests u from offset and writes it to f and h, returning the
+// offset it got to.
+func (c *cmd) get(ctx context.Context, u *url.URL, f *os.File, offset int64, h hash.Hash) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return offset, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return offset, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK && offset > 0:
+		// The server does not do ranges, start over.
+		if f == os.Stdout {
+			return offset, fmt.Errorf("%w: cannot resume on stdout without range support", errRange)
+		}
+		if err := f.Truncate(0); err != nil {
+			return offset, err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return offset, err
+		}
+		h.Reset()
+		offset = 0
+
+	case resp.StatusCode == http.StatusOK:
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		var start int64
+		if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-", &start); err != nil || start != offset {
+			return offset, fmt.Errorf("%w: %q from byte %d", errRange, resp.Header.Get("Content-Range"), offset)
+		}
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// The file is complete.
+		return offset, nil
+	default:
+		return offset, &curl.HTTPClientCodeError{Err: curl.ErrStatusNotOk, HTTPCode: resp.StatusCode}
+	}
+	n, err := io.Copy(io.MultiWriter(f, h), resp.Body)
+	return offset + n, err
+}
@@ -0,0 +1,188 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/u-root/u-root/pkg/curl"
+)
+
+var image = bytes.Repeat([]byte("0123456789abcdef"), 4096)
+
+// flakyServer serves image with range support, but breaks the connection
+// after half the image to the first breaks requests, and answers the
+// first failures requests with 503.
+type flakyServer struct {
+	mu       sync.Mutex
+	failures int
+	breaks   int
+	ranges   []string
+}
+
+func (s *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.ranges = append(s.ranges, r.Header.Get("Range"))
+	fail := s.failures > 0
+	if fail {
+		s.failures--
+	}
+	brk := !fail && s.breaks > 0
+	if brk {
+		s.breaks--
+	}
+	s.mu.Unlock()
+
+	switch {
+	case fail:
+		w.WriteHeader(http.StatusServiceUnavailable)
+	case brk:
+		w.Header().Set("Content-Length", fmt.Sprint(len(image)))
+		w.Write(image[:len(image)/2])
+		panic(http.ErrAbortHandler)
+	default:
+		http.ServeContent(w, r, "image", time.Time{}, bytes.NewReader(image))
+	}
+}
+
+func init() {
+	retryInterval = time.Millisecond
+}
+
+func TestDownload(t *testing.T) {
+	sum := fmt.Sprintf("%x", sha256.Sum256(image))
+	for _, tt := range []struct {
+		name    string
+		args    []string
+		partial []byte
+		srv     *flakyServer
+		ranges  []string
+		err     error
+	}{
+		{
+			name:   "plain",
+			args:   []string{"-sha256", sum},
+			srv:    &flakyServer{},
+			ranges: []string{""},
+		},
+		{
+			name:   "resume after a broken connection",
+			args:   []string{"-tries", "3", "-sha256", sum},
+			srv:    &flakyServer{breaks: 1},
+			ranges: []string{"", fmt.Sprintf("bytes=%d-", len(image)/2)},
+		},
+		{
+			name:   "retry server errors",
+			args:   []string{"-tries", "3"},
+			srv:    &flakyServer{failures: 2},
+			ranges: []string{"", "", ""},
+		},
+		{
+			name:   "too many server errors",
+			args:   []string{"-tries", "2"},
+			srv:    &flakyServer{failures: 2},
+			ranges: []string{"", ""},
+			err:    curl.ErrStatusNotOk,
+		},
+		{
+			name:    "continue",
+			args:    []string{"-c", "-sha256", sum},
+			partial: image[:1000],
+			srv:     &flakyServer{},
+			ranges:  []string{"bytes=1000-"},
+		},
+		{
+			name:    "continue a complete file",
+			args:    []string{"-c", "-sha256", sum},
+			partial: image,
+			srv:     &flakyServer{},
+			ranges:  []string{fmt.Sprintf("bytes=%d-", len(image))},
+		},
+		{
+			name:    "checksum mismatch",
+			args:    []string{"-c", "-sha256", sum},
+			partial: []byte("garbage"),
+			srv:     &flakyServer{},
+			ranges:  []string{"bytes=7-"},
+			err:     errChecksum,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.srv)
+			defer srv.Close()
+			out := filepath.Join(t.TempDir(), "image")
+			if tt.partial != nil {
+				if err := os.WriteFile(out, tt.partial, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			args := append([]string{"wget", "-O", out}, tt.args...)
+			c, err := command(append(args, srv.URL+"/image")...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := c.run(); !errors.Is(err, tt.err) {
+				t.Fatalf("run() = %v, want %v", err, tt.err)
+			}
+			if got, want := strings.Join(tt.srv.ranges, ","), strings.Join(tt.ranges, ","); got != want {
+				t.Errorf("ranges requested = %q, want %q", got, want)
+			}
+			if tt.err != nil {
+				return
+			}
+			b, err := os.ReadFile(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, image) {
+				t.Errorf("downloaded %d bytes, want the %d bytes of the image", len(b), len(image))
+			}
+		})
+	}
+}
+
+func TestDownloadTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(&flakyServer{})
+	defer srv.Close()
+	dir := t.TempDir()
+	ca := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{name: "unknown CA", wantErr: true},
+		{name: "CA bundle", args: []string{"-ca-certificate", ca}},
+		{name: "no check", args: []string{"-no-check-certificate"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			out := filepath.Join(dir, "image")
+			args := append([]string{"wget", "-O", out}, tt.args...)
+			c, err := command(append(args, srv.URL+"/image")...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := c.run(); (err != nil) != tt.wantErr {
+				t.Fatalf("run() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -6,12 +6,39 @@
 //
 // Synopsis:
 //
-//	wget URL
+//	wget [-O FILE] [-c] [-tries N] [-sha256 HEX] [TLS OPTIONS] [PROXY OPTIONS] URL
 //
 // Description:
 //
 //	Returns a non-zero code on failure.
 //
+// Options:
+//
+//	-O:      output file, - for stdout
+//	-c:      continue a partial download of the output file (HTTP only)
+//	-tries:  number of attempts, retrying transient errors with exponential
+//	         backoff and resuming HTTP downloads where they stopped
+//	-sha256: expected SHA256 of the file, in hex
+//
+// TLS options:
+//
+//	-ca-certificate:       PEM bundle of the CAs to trust instead of the
+//	                       system ones
+//	-certificate:          PEM client certificate, for servers that ask
+//	                       for one
+//	-private-key:          PEM private key of the client certificate
+//	-no-check-certificate: accept any server certificate
+//
+// Proxy options:
+//
+//	-proxy:          proxy of HTTP and HTTPS requests, as
+//	                 http://[USER:PASSWORD@]HOST[:PORT], or socks5://... for
+//	                 a SOCKS5 proxy (default: $http_proxy, $https_proxy or
+//	                 $all_proxy, but for the hosts of $no_proxy)
+//	-no-proxy:       do not use proxies
+//	-proxy-user:     user to authenticate to the proxy as
+//	-proxy-password: password of the -proxy-user
+//
 // Notes:
 //
 //	There are a few differences with GNU wget:
@@ -24,11 +51,17 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
+	"hash"
+	"io"
 	"log"
+	"net/http"
 	"net/url"
 	"os"
 	"path"
@@ -38,18 +71,30 @@ import (
 	"github.com/u-root/uio/uio"
 )
 
-var errEmptyURL = errors.New("empty url")
+var (
+	errEmptyURL = errors.New("empty url")
+	errChecksum = errors.New("SHA256 mismatch")
+	errTries    = errors.New("tries must be at least 1")
+)
 
 type cmd struct {
 	url        string
 	outputPath string
+	resume     bool
+	tries      int
+	sha256     []byte
+	tls        curl.TLSOptions
+	proxy      curl.ProxyOptions
+
+	// client is the HTTP client built from tls and proxy.
+	client *http.Client
 }
 
 // flags parses wget flags
 // wget is old school, and allows flags after the URL.
 // This code does not process the -- flag specified in the
 // man page, as the command itself does not seem to either.
-func flags(args ...string) (string, string, error) {
+func flags(args ...string) (*cmd, error) {
 	// -- takes priority over everything else.
 	// flag package does not allow - as a flag.
 	// except, in spite of the docs, wget on linux seems
@@ -60,41 +105,58 @@ func flags(args ...string) (string, string, error) {
 	// the slices package is a good place to start.
 
 	if len(args) == 0 {
-		return "", "", errEmptyURL
+		return nil, errEmptyURL
 	}
 
+	c := &cmd{}
 	f := flag.NewFlagSet(args[0], flag.ContinueOnError)
-	var outPath = f.String("O", "", "output file")
+	f.StringVar(&c.outputPath, "O", "", "output file")
+	f.BoolVar(&c.resume, "c", false, "continue a partial download")
+	f.IntVar(&c.tries, "tries", 1, "number of attempts")
+	sum := f.String("sha256", "", "expected SHA256 of the file, in hex")
+	f.StringVar(&c.tls.CAFile, "ca-certificate", "", "PEM bundle of the CAs to trust instead of the system ones")
+	f.StringVar(&c.tls.CertFile, "certificate", "", "PEM client certificate")
+	f.StringVar(&c.tls.KeyFile, "private-key", "", "PEM private key of the client certificate")
+	f.BoolVar(&c.tls.InsecureSkipVerify, "no-check-certificate", false, "accept any server certificate")
+	f.StringVar(&c.proxy.URL, "proxy", "", "proxy of HTTP and HTTPS requests")
+	direct := f.Bool("no-proxy", false, "do not use proxies")
+	f.StringVar(&c.proxy.User, "proxy-user", "", "user to authenticate to the proxy as")
+	f.StringVar(&c.proxy.Password, "proxy-password", "", "password of the proxy user")
 
 	if err := f.Parse(args[1:]); err != nil {
-		return "", "", err
+		return nil, err
 	}
 
 	if len(f.Args()) == 0 {
-		return "", "", errEmptyURL
+		return nil, errEmptyURL
 	}
 
-	URL := f.Args()[0]
+	c.url = f.Args()[0]
 
 	// Now, it is allowed to have switches after the URL,
 	// handle following flags
 	if err := f.Parse(f.Args()[1:]); err != nil {
-		return "", "", err
+		return nil, err
 	}
 
-	return *outPath, URL, nil
+	if c.tries < 1 {
+		return nil, errTries
+	}
+	if *direct {
+		c.proxy.NoProxy = "*"
+	}
+	if *sum != "" {
+		b, err := hex.DecodeString(*sum)
+		if err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("%w: invalid SHA256 %q", errChecksum, *sum)
+		}
+		c.sha256 = b
+	}
+	return c, nil
 }
 
 func command(args ...string) (*cmd, error) {
-	outPath, URL, err := flags(args...)
-	if err != nil {
-		return nil, err
-	}
-
-	return &cmd{
-		outputPath: outPath,
-		url:        URL,
-	}, nil
+	return flags(args...)
 }
 
 func (c *cmd) run() error {
@@ -116,25 +178,55 @@ func (c *cmd) run() error {
 		c.outputPath = "/dev/stdout"
 	}
 
+	t, err := c.tls.Transport()
+	if err != nil {
+		return err
+	}
+	if err := c.proxy.Apply(t); err != nil {
+		return err
+	}
+	c.client = &http.Client{Transport: t}
+
+	h := sha256.New()
+	switch parsedURL.Scheme {
+	case "http", "https":
+		err = c.download(context.Background(), parsedURL, h)
+	default:
+		err = c.fetch(context.Background(), parsedURL, h)
+	}
+	if err != nil {
+		return err
+	}
+	if c.sha256 != nil {
+		if sum := h.Sum(nil); !bytes.Equal(sum, c.sha256) {
+			return fmt.Errorf("%w: got %x, want %x", errChecksum, sum, c.sha256)
+		}
+	}
+	return nil
+}
+
+// fetch writes u to the output file, hashing it with h.
+func (c *cmd) fetch(ctx context.Context, u *url.URL, h hash.Hash) error {
 	schemes := curl.Schemes{
 		"tftp": curl.DefaultTFTPClient,
-		"http": curl.DefaultHTTPClient,
+		"http": curl.NewHTTPClient(c.client),
 
 		// curl.DefaultSchemes doesn't support HTTPS by default.
-		"https": curl.DefaultHTTPClient,
+		"https": curl.NewHTTPClient(c.client),
 		"file":  &curl.LocalFileClient{},
 	}
+	if c.tries > 1 {
+		for name, s := range schemes {
+			schemes[name] = &curl.SchemeWithRetries{Scheme: s, BackOff: c.backOff()}
+		}
+	}
 
-	reader, err := schemes.FetchWithoutCache(context.Background(), parsedURL)
+	reader, err := schemes.FetchWithoutCache(ctx, u)
 	if err != nil {
 		return fmt.Errorf("failed to download %v: %w", c.url, err)
 	}
 
-	if err := uio.ReadIntoFile(reader, c.outputPath); err != nil {
-		return err
-	}
-
-	return nil
+	return uio.ReadIntoFile(io.TeeReader(reader, h), c.outputPath)
 }
 
 func usage() {
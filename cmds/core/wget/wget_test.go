@@ -206,12 +206,18 @@ func TestFlags(t *testing.T) {
 		{name: "opt but no url", args: []string{"wget", "-O", "b"}, out: "", url: "", err: errEmptyURL},
 		{name: "url with -O first", args: []string{"wget", "-O", "b", "a"}, out: "b", url: "a", err: nil},
 		{name: "url with -O last", args: []string{"wget", "a", "-O", "b"}, out: "b", url: "a", err: nil},
+		{name: "zero tries", args: []string{"wget", "-tries", "0", "a"}, out: "", url: "", err: errTries},
+		{name: "bad sha256", args: []string{"wget", "-sha256", "abcd", "a"}, out: "", url: "", err: errChecksum},
 	} {
 		t.Run(tt.name, func(t *testing.T) {
-			o, u, err := flags(tt.args...)
+			c, err := flags(tt.args...)
 			if !errors.Is(err, tt.err) {
 				t.Errorf("err:got %v, want %v", err, tt.err)
 			}
+			var o, u string
+			if c != nil {
+				o, u = c.outputPath, c.url
+			}
 			if o != tt.out {
 				t.Errorf("out:got %q, want %q", o, tt.out)
 			}
@@ -223,6 +229,32 @@ func TestFlags(t *testing.T) {
 	}
 }
 
+func TestProxyFlags(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		args []string
+		want curl.ProxyOptions
+	}{
+		{name: "none", args: []string{"wget", "a"}},
+		{
+			name: "proxy",
+			args: []string{"wget", "-proxy", "socks5://proxy:1080", "-proxy-user", "u", "-proxy-password", "p", "a"},
+			want: curl.ProxyOptions{URL: "socks5://proxy:1080", User: "u", Password: "p"},
+		},
+		{name: "no proxy", args: []string{"wget", "a", "-no-proxy"}, want: curl.ProxyOptions{NoProxy: "*"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := flags(tt.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.proxy != tt.want {
+				t.Errorf("proxy options = %+v, want %+v", c.proxy, tt.want)
+			}
+		})
+	}
+}
+
 func TestStdout(t *testing.T) {
 	srv := httptest.NewServer(handler{})
 	defer srv.Close()
@@ -0,0 +1,395 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+// arp shows and changes the neighbor tables, ARP for IPv4 and NDP for
+// IPv6, and announces addresses to the network.
+//
+// Synopsis:
+//
+//	arp [-4|-6] [-i IFACE]
+//	arp -s ADDR HWADDR [-i IFACE]
+//	arp -d ADDR [-i IFACE]
+//	arp -g ADDR -i IFACE [-c COUNT]
+//
+// Description:
+//
+//	Without -s, -d or -g, arp lists the neighbor entries, of both families
+//	unless -4 or -6 is given.
+//
+//	-s adds a permanent entry, replacing any there is; -d deletes the entries
+//	of ADDR, on all interfaces unless -i is given. Without -i, -s uses the
+//	interface ADDR is routed through.
+//
+//	-g announces ADDR, an address of IFACE, so that switches and hosts
+//	holding stale entries for it update them: it sends gratuitous ARPs for
+//	IPv4 addresses and unsolicited neighbor advertisements for IPv6 ones,
+//	COUNT of them a second apart.
+//
+// Options:
+//
+//	-4: only list IPv4 entries
+//	-6: only list IPv6 entries
+//	-i: interface
+//	-s: add an entry
+//	-d: delete the entries of an address
+//	-g: announce an address
+//	-c: number of announcements (default 3)
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/mdlayher/packet"
+	"github.com/vishvananda/netlink"
+)
+
+var (
+	errUsage   = errors.New("usage: arp [-4|-6] [-i IFACE] | -s ADDR HWADDR [-i IFACE] | -d ADDR [-i IFACE] | -g ADDR -i IFACE [-c COUNT]")
+	errAddr    = errors.New("invalid address")
+	errHWAddr  = errors.New("invalid hardware address")
+	errNoEntry = errors.New("no entry")
+	errNoRoute = errors.New("no route")
+	errNoMAC   = errors.New("no Ethernet address")
+)
+
+// neighbors are the netlink operations arp uses, which netlink.Handle
+// implements.
+type neighbors interface {
+	NeighList(linkIndex, family int) ([]netlink.Neigh, error)
+	NeighSet(neigh *netlink.Neigh) error
+	NeighDel(neigh *netlink.Neigh) error
+	LinkByName(name string) (netlink.Link, error)
+	LinkByIndex(index int) (netlink.Link, error)
+	RouteGet(destination net.IP) ([]netlink.Route, error)
+}
+
+type cmd struct {
+	h      neighbors
+	out    io.Writer
+	family int
+	iface  string
+	// send sends a frame to dst on ifi.
+	send  func(ifi *net.Interface, etherType layers.EthernetType, dst net.HardwareAddr, b []byte) error
+	count int
+	// interval is the time between announcements.
+	interval time.Duration
+}
+
+var states = []struct {
+	state int
+	name  string
+}{
+	{netlink.NUD_INCOMPLETE, "INCOMPLETE"},
+	{netlink.NUD_REACHABLE, "REACHABLE"},
+	{netlink.NUD_STALE, "STALE"},
+	{netlink.NUD_DELAY, "DELAY"},
+	{netlink.NUD_PROBE, "PROBE"},
+	{netlink.NUD_FAILED, "FAILED"},
+	{netlink.NUD_NOARP, "NOARP"},
+	{netlink.NUD_PERMANENT, "PERMANENT"},
+}
+
+func state(s int) string {
+	var names []string
+	for _, st := range states {
+		if s&st.state != 0 {
+			names = append(names, st.name)
+		}
+	}
+	if len(names) == 0 {
+		return "NONE"
+	}
+	return strings.Join(names, ",")
+}
+
+func parseIP(s string) (net.IP, error) {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("%w: %q", errAddr, s)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4, nil
+	}
+	return ip, nil
+}
+
+func family(ip net.IP) int {
+	if ip.To4() != nil {
+		return netlink.FAMILY_V4
+	}
+	return netlink.FAMILY_V6
+}
+
+// linkIndex returns the index of the interface of c, 0 for all.
+func (c *cmd) linkIndex() (int, error) {
+	if c.iface == "" {
+		return 0, nil
+	}
+	l, err := c.h.LinkByName(c.iface)
+	if err != nil {
+		return 0, err
+	}
+	return l.Attrs().Index, nil
+}
+
+// list prints the neighbor entries.
+func (c *cmd) list() error {
+	idx, err := c.linkIndex()
+	if err != nil {
+		return err
+	}
+	neighs, err := c.h.NeighList(idx, c.family)
+	if err != nil {
+		return err
+	}
+	names := map[int]string{}
+	w := tabwriter.NewWriter(c.out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "Address\tHWaddress\tState\tFlags\tIface")
+	for _, n := range neighs {
+		// Skip the multicast and unspecified entries of NDP.
+		if n.IP == nil || n.IP.IsMulticast() || n.IP.IsUnspecified() {
+			continue
+		}
+		name, ok := names[n.LinkIndex]
+		if !ok {
+			name = fmt.Sprint(n.LinkIndex)
+			if l, err := c.h.LinkByIndex(n.LinkIndex); err == nil {
+				name = l.Attrs().Name
+			}
+			names[n.LinkIndex] = name
+		}
+		hw := "(incomplete)"
+		if len(n.HardwareAddr) > 0 {
+			hw = n.HardwareAddr.String()
+		}
+		flags := ""
+		if n.Flags&netlink.NTF_ROUTER != 0 {
+			flags = "router"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", n.IP, hw, state(n.State), flags, name)
+	}
+	return w.Flush()
+}
+
+// set adds a permanent entry of addr, replacing any there is.
+func (c *cmd) set(addr, hwaddr string) error {
+	ip, err := parseIP(addr)
+	if err != nil {
+		return err
+	}
+	hw, err := net.ParseMAC(hwaddr)
+	if err != nil {
+		return fmt.Errorf("%w: %q", errHWAddr, hwaddr)
+	}
+	idx, err := c.linkIndex()
+	if err != nil {
+		return err
+	}
+	if idx == 0 {
+		routes, err := c.h.RouteGet(ip)
+		if err != nil {
+			return err
+		}
+		if len(routes) == 0 {
+			return fmt.Errorf("%w to %s", errNoRoute, ip)
+		}
+		idx = routes[0].LinkIndex
+	}
+	return c.h.NeighSet(&netlink.Neigh{
+		LinkIndex:    idx,
+		Family:       family(ip),
+		State:        netlink.NUD_PERMANENT,
+		IP:           ip,
+		HardwareAddr: hw,
+	})
+}
+
+// del deletes the entries of addr.
+func (c *cmd) del(addr string) error {
+	ip, err := parseIP(addr)
+	if err != nil {
+		return err
+	}
+	idx, err := c.linkIndex()
+	if err != nil {
+		return err
+	}
+	neighs, err := c.h.NeighList(idx, family(ip))
+	if err != nil {
+		return err
+	}
+	found := false
+	for i := range neighs {
+		if !neighs[i].IP.Equal(ip) {
+			continue
+		}
+		found = true
+		if err := c.h.NeighDel(&neighs[i]); err != nil {
+			return err
+		}
+	}
+	if !found {
+		return fmt.Errorf("%w for %s", errNoEntry, ip)
+	}
+	return nil
+}
+
+// announcement returns a frame announcing that ip is at the address of
+// ifi, and the address to send it to: a gratuitous ARP request for IPv4,
+// RFC 5227, and an unsolicited neighbor advertisement for IPv6, RFC 4861.
+func announcement(ifi *net.Interface, ip net.IP) (layers.EthernetType, net.HardwareAddr, []byte, error) {
+	if len(ifi.HardwareAddr) != 6 {
+		return 0, nil, nil, fmt.Errorf("%w on %s", errNoMAC, ifi.Name)
+	}
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if ip4 := ip.To4(); ip4 != nil {
+		dst := layers.EthernetBroadcast
+		eth := &layers.Ethernet{SrcMAC: ifi.HardwareAddr, DstMAC: dst, EthernetType: layers.EthernetTypeARP}
+		arp := &layers.ARP{
+			AddrType:          layers.LinkTypeEthernet,
+			Protocol:          layers.EthernetTypeIPv4,
+			HwAddressSize:     6,
+			ProtAddressSize:   4,
+			Operation:         layers.ARPRequest,
+			SourceHwAddress:   ifi.HardwareAddr,
+			SourceProtAddress: ip4,
+			DstHwAddress:      make([]byte, 6),
+			DstProtAddress:    ip4,
+		}
+		err := gopacket.SerializeLayers(buf, opts, eth, arp)
+		return layers.EthernetTypeARP, dst, buf.Bytes(), err
+	}
+
+	// All nodes, and its multicast Ethernet address.
+	allNodes := net.IPv6linklocalallnodes
+	dst := net.HardwareAddr{0x33, 0x33, 0, 0, 0, 1}
+	src := ip
+	if addrs, err := ifi.Addrs(); err == nil {
+		for _, a := range addrs {
+			if n, ok := a.(*net.IPNet); ok && n.IP.To4() == nil && n.IP.IsLinkLocalUnicast() {
+				src = n.IP
+				break
+			}
+		}
+	}
+	eth := &layers.Ethernet{SrcMAC: ifi.HardwareAddr, DstMAC: dst, EthernetType: layers.EthernetTypeIPv6}
+	ip6 := &layers.IPv6{Version: 6, NextHeader: layers.IPProtocolICMPv6, HopLimit: 255, SrcIP: src, DstIP: allNodes}
+	icmp := &layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeNeighborAdvertisement, 0)}
+	if err := icmp.SetNetworkLayerForChecksum(ip6); err != nil {
+		return 0, nil, nil, err
+	}
+	na := &layers.ICMPv6NeighborAdvertisement{
+		// Override, since the point is to replace stale entries.
+		Flags:         0x20,
+		TargetAddress: ip,
+		Options:       layers.ICMPv6Options{{Type: layers.ICMPv6OptTargetAddress, Data: ifi.HardwareAddr}},
+	}
+	err := gopacket.SerializeLayers(buf, opts, eth, ip6, icmp, na)
+	return layers.EthernetTypeIPv6, dst, buf.Bytes(), err
+}
+
+// announce sends count announcements of addr on the interface of c.
+func (c *cmd) announce(addr string) error {
+	ip, err := parseIP(addr)
+	if err != nil {
+		return err
+	}
+	if c.iface == "" {
+		return errUsage
+	}
+	ifi, err := net.InterfaceByName(c.iface)
+	if err != nil {
+		return err
+	}
+	etherType, dst, b, err := announcement(ifi, ip)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < c.count; i++ {
+		if i > 0 {
+			time.Sleep(c.interval)
+		}
+		if err := c.send(ifi, etherType, dst, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func send(ifi *net.Interface, etherType layers.EthernetType, dst net.HardwareAddr, b []byte) error {
+	c, err := packet.Listen(ifi, packet.Raw, int(etherType), nil)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	_, err = c.WriteTo(b, &packet.Addr{HardwareAddr: dst})
+	return err
+}
+
+func run(args []string, out io.Writer, h neighbors) error {
+	f := flag.NewFlagSet("arp", flag.ContinueOnError)
+	v4 := f.Bool("4", false, "Only list IPv4 entries")
+	v6 := f.Bool("6", false, "Only list IPv6 entries")
+	iface := f.String("i", "", "Interface")
+	set := f.Bool("s", false, "Add an entry: -s ADDR HWADDR")
+	del := f.Bool("d", false, "Delete the entries of an address: -d ADDR")
+	announce := f.Bool("g", false, "Announce an address of the interface: -g ADDR")
+	count := f.Int("c", 3, "Number of announcements")
+	// Options may follow the addresses, as in the synopsis.
+	var pos []string
+	for {
+		if err := f.Parse(args); err != nil {
+			return err
+		}
+		if f.NArg() == 0 {
+			break
+		}
+		pos = append(pos, f.Arg(0))
+		args = f.Args()[1:]
+	}
+
+	c := &cmd{h: h, out: out, iface: *iface, send: send, count: *count, interval: time.Second}
+	switch {
+	case *v4 && !*v6:
+		c.family = netlink.FAMILY_V4
+	case *v6 && !*v4:
+		c.family = netlink.FAMILY_V6
+	}
+
+	switch {
+	case *set && len(pos) == 2:
+		return c.set(pos[0], pos[1])
+	case *del && len(pos) == 1:
+		return c.del(pos[0])
+	case *announce && len(pos) == 1:
+		return c.announce(pos[0])
+	case !*set && !*del && !*announce && len(pos) == 0:
+		return c.list()
+	}
+	return errUsage
+}
+
+func main() {
+	h, err := netlink.NewHandle()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := run(os.Args[1:], os.Stdout, h); err != nil {
+		log.Fatal(err)
+	}
+}
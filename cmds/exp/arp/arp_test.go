@@ -0,0 +1,240 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/vishvananda/netlink"
+)
+
+// fakeNeighbors is a neighbor table of links eth0, index 2, and eth1, index 3.
+type fakeNeighbors struct {
+	neighs  []netlink.Neigh
+	deleted []netlink.Neigh
+}
+
+var (
+	links   = map[int]string{2: "eth0", 3: "eth1"}
+	errLink = errors.New("Link not found")
+)
+
+func (f *fakeNeighbors) NeighList(linkIndex, family int) ([]netlink.Neigh, error) {
+	var neighs []netlink.Neigh
+	for _, n := range f.neighs {
+		if (linkIndex == 0 || n.LinkIndex == linkIndex) && (family == netlink.FAMILY_ALL || n.Family == family) {
+			neighs = append(neighs, n)
+		}
+	}
+	return neighs, nil
+}
+
+func (f *fakeNeighbors) NeighSet(neigh *netlink.Neigh) error {
+	f.neighs = append(f.neighs, *neigh)
+	return nil
+}
+
+func (f *fakeNeighbors) NeighDel(neigh *netlink.Neigh) error {
+	f.deleted = append(f.deleted, *neigh)
+	return nil
+}
+
+func (f *fakeNeighbors) LinkByName(name string) (netlink.Link, error) {
+	for i, n := range links {
+		if n == name {
+			return &netlink.Device{LinkAttrs: netlink.LinkAttrs{Index: i, Name: n}}, nil
+		}
+	}
+	return nil, errLink
+}
+
+func (f *fakeNeighbors) LinkByIndex(index int) (netlink.Link, error) {
+	if n, ok := links[index]; ok {
+		return &netlink.Device{LinkAttrs: netlink.LinkAttrs{Index: index, Name: n}}, nil
+	}
+	return nil, errors.New("Link not found")
+}
+
+func (f *fakeNeighbors) RouteGet(destination net.IP) ([]netlink.Route, error) {
+	return []netlink.Route{{LinkIndex: 3, Dst: &net.IPNet{IP: destination}}}, nil
+}
+
+func table() *fakeNeighbors {
+	mac := net.HardwareAddr{0x52, 0x54, 0, 0x12, 0x34, 0x56}
+	return &fakeNeighbors{neighs: []netlink.Neigh{
+		{LinkIndex: 2, Family: netlink.FAMILY_V4, IP: net.IPv4(192, 0, 2, 1).To4(), HardwareAddr: mac, State: netlink.NUD_REACHABLE, Flags: netlink.NTF_ROUTER},
+		{LinkIndex: 2, Family: netlink.FAMILY_V4, IP: net.IPv4(192, 0, 2, 9).To4(), State: netlink.NUD_FAILED},
+		{LinkIndex: 3, Family: netlink.FAMILY_V4, IP: net.IPv4(192, 0, 2, 1).To4(), HardwareAddr: mac, State: netlink.NUD_STALE},
+		{LinkIndex: 3, Family: netlink.FAMILY_V6, IP: net.ParseIP("fe80::1"), HardwareAddr: mac, State: netlink.NUD_PERMANENT},
+		{LinkIndex: 3, Family: netlink.FAMILY_V6, IP: net.ParseIP("ff02::2"), HardwareAddr: mac, State: netlink.NUD_NOARP},
+	}}
+}
+
+func TestList(t *testing.T) {
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{
+			want: `Address    HWaddress          State      Flags   Iface
+192.0.2.1  52:54:00:12:34:56  REACHABLE  router  eth0
+192.0.2.9  (incomplete)       FAILED             eth0
+192.0.2.1  52:54:00:12:34:56  STALE              eth1
+fe80::1    52:54:00:12:34:56  PERMANENT          eth1
+`,
+		},
+		{
+			args: []string{"-6"},
+			want: `Address  HWaddress          State      Flags  Iface
+fe80::1  52:54:00:12:34:56  PERMANENT         eth1
+`,
+		},
+		{
+			args: []string{"-4", "-i", "eth1"},
+			want: `Address    HWaddress          State  Flags  Iface
+192.0.2.1  52:54:00:12:34:56  STALE         eth1
+`,
+		},
+	} {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			var out bytes.Buffer
+			if err := run(tt.args, &out, table()); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestSet(t *testing.T) {
+	for _, tt := range []struct {
+		args      []string
+		wantIndex int
+		wantErr   error
+	}{
+		{args: []string{"-s", "192.0.2.7", "52:54:00:00:00:07", "-i", "eth1"}, wantIndex: 3},
+		{args: []string{"-i", "eth0", "-s", "192.0.2.7", "52:54:00:00:00:07"}, wantIndex: 2},
+		{args: []string{"-s", "192.0.2.7", "52:54:00:00:00:07", "-i", "eth9"}, wantErr: errLink},
+		{args: []string{"-s", "2001:db8::7", "52:54:00:00:00:07"}, wantIndex: 3},
+		{args: []string{"-s", "192.0.2.7", "52:54"}, wantErr: errHWAddr},
+		{args: []string{"-s", "192.0.2", "52:54:00:00:00:07"}, wantErr: errAddr},
+		{args: []string{"-s", "192.0.2.7"}, wantErr: errUsage},
+		{args: []string{"192.0.2.7"}, wantErr: errUsage},
+	} {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			f := &fakeNeighbors{}
+			if err := run(tt.args, &bytes.Buffer{}, f); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("run() = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if len(f.neighs) != 1 {
+				t.Fatalf("got %d entries added, want 1", len(f.neighs))
+			}
+			n := f.neighs[0]
+			if n.LinkIndex != tt.wantIndex || n.State != netlink.NUD_PERMANENT || n.HardwareAddr.String() != "52:54:00:00:00:07" {
+				t.Errorf("added %+v, want a permanent entry on link %d", n, tt.wantIndex)
+			}
+		})
+	}
+}
+
+func TestDel(t *testing.T) {
+	for _, tt := range []struct {
+		args    []string
+		want    []int
+		wantErr error
+	}{
+		{args: []string{"-d", "192.0.2.1"}, want: []int{2, 3}},
+		{args: []string{"-i", "eth1", "-d", "192.0.2.1"}, want: []int{3}},
+		{args: []string{"-d", "fe80::1"}, want: []int{3}},
+		{args: []string{"-d", "192.0.2.2"}, wantErr: errNoEntry},
+		{args: []string{"-d"}, wantErr: errUsage},
+	} {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			f := table()
+			if err := run(tt.args, &bytes.Buffer{}, f); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("run() = %v, want %v", err, tt.wantErr)
+			}
+			var got []int
+			for _, n := range f.deleted {
+				got = append(got, n.LinkIndex)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("deleted from links %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("deleted from links %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestAnnouncement(t *testing.T) {
+	mac := net.HardwareAddr{0x52, 0x54, 0, 0xab, 0xcd, 0xef}
+	ifi := &net.Interface{Index: 1 << 30, Name: "test0", HardwareAddr: mac}
+
+	typ, dst, b, err := announcement(ifi, net.IPv4(192, 0, 2, 5).To4())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != layers.EthernetTypeARP || !bytes.Equal(dst, layers.EthernetBroadcast) {
+		t.Errorf("sending %v to %v, want ARP to broadcast", typ, dst)
+	}
+	p := gopacket.NewPacket(b, layers.LayerTypeEthernet, gopacket.Default)
+	arp, ok := p.Layer(layers.LayerTypeARP).(*layers.ARP)
+	if !ok {
+		t.Fatalf("no ARP in %v", p)
+	}
+	if arp.Operation != layers.ARPRequest || !bytes.Equal(arp.SourceHwAddress, mac) ||
+		!net.IP(arp.SourceProtAddress).Equal(net.IPv4(192, 0, 2, 5)) || !net.IP(arp.DstProtAddress).Equal(net.IPv4(192, 0, 2, 5)) {
+		t.Errorf("ARP %+v is not a gratuitous request for 192.0.2.5 at %v", arp, mac)
+	}
+
+	ip := net.ParseIP("2001:db8::5")
+	typ, dst, b, err = announcement(ifi, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != layers.EthernetTypeIPv6 || dst.String() != "33:33:00:00:00:01" {
+		t.Errorf("sending %v to %v, want IPv6 to all nodes", typ, dst)
+	}
+	p = gopacket.NewPacket(b, layers.LayerTypeEthernet, gopacket.Default)
+	ip6, ok := p.Layer(layers.LayerTypeIPv6).(*layers.IPv6)
+	if !ok || ip6.HopLimit != 255 || !ip6.DstIP.Equal(net.IPv6linklocalallnodes) {
+		t.Errorf("IPv6 %+v, want hop limit 255 to all nodes", ip6)
+	}
+	na, ok := p.Layer(layers.LayerTypeICMPv6NeighborAdvertisement).(*layers.ICMPv6NeighborAdvertisement)
+	if !ok {
+		t.Fatalf("no neighbor advertisement in %v", p)
+	}
+	if !na.TargetAddress.Equal(ip) || na.Flags&0x20 == 0 || len(na.Options) != 1 || !bytes.Equal(na.Options[0].Data, mac) {
+		t.Errorf("advertisement %+v, want an override of %v at %v", na, ip, mac)
+	}
+}
+
+func TestAnnounce(t *testing.T) {
+	c := &cmd{count: 2, iface: "lo"}
+	if err := c.announce("127.0.0.1"); !errors.Is(err, errNoMAC) {
+		t.Errorf("announce() on lo = %v, want %v", err, errNoMAC)
+	}
+	c.iface = ""
+	if err := c.announce("127.0.0.1"); !errors.Is(err, errUsage) {
+		t.Errorf("announce() without interface = %v, want %v", err, errUsage)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command bootslot shows and changes the state of A/B boot slots, see package
+// bootslot and the -slots flag of boot.
+//
+// Synopsis:
+//
+//	bootslot [-state PATH] status
+//	bootslot [-state PATH] good
+//	bootslot [-state PATH] [-tries N] activate a|b
+//
+// Description:
+//
+//	status prints the active slot and whether it is good or its tries left.
+//	good marks the active slot as booted successfully. Run it once the OS
+//	in the slot is up, or boot falls back to the other slot.
+//	activate makes a slot active, e.g. after installing an upgrade into it.
+//	boot tries it -tries times before falling back to the current slot.
+//
+// Options:
+//
+//	-state: the state partition or file, or efivar for an EFI variable
+//	-tries: boots of an activated slot until it is marked good, 0 for good
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/u-root/u-root/pkg/boot/bootslot"
+)
+
+var (
+	state = flag.String("state", "efivar", "State partition or file of the boot slots, or efivar for an EFI variable")
+	tries = flag.Uint("tries", 3, "Boots of an activated slot until it is marked good before falling back, 0 to mark it good right away")
+)
+
+var errUsage = errors.New("usage: bootslot [-state PATH] status | good | [-tries N] activate a|b")
+
+func run(out io.Writer, s bootslot.Store, tries uint, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	switch args[0] {
+	case "status":
+		if len(args) != 1 {
+			return errUsage
+		}
+		st, err := s.Load()
+		if errors.Is(err, bootslot.ErrNoState) {
+			st = bootslot.DefaultState
+		} else if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, st)
+	case "good":
+		if len(args) != 1 {
+			return errUsage
+		}
+		return bootslot.MarkGood(s)
+	case "activate":
+		if len(args) != 2 {
+			return errUsage
+		}
+		slot, err := bootslot.ParseSlot(args[1])
+		if err != nil {
+			return err
+		}
+		if tries > 255 {
+			return fmt.Errorf("-tries %d is more than 255", tries)
+		}
+		return bootslot.SetActive(s, slot, uint8(tries))
+	default:
+		return errUsage
+	}
+	return nil
+}
+
+func main() {
+	flag.Parse()
+	s, err := bootslot.OpenStore(*state)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := run(os.Stdout, s, *tries, flag.Args()); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/boot/bootslot"
+)
+
+func TestRun(t *testing.T) {
+	s := &bootslot.FileStore{Path: filepath.Join(t.TempDir(), "state")}
+	for _, tt := range []struct {
+		args []string
+		want string
+		err  error
+	}{
+		{args: []string{"status"}, want: "active slot a, good\n"},
+		{args: []string{"activate", "b"}},
+		{args: []string{"status"}, want: "active slot b, 3 tries left\n"},
+		{args: []string{"good"}},
+		{args: []string{"status"}, want: "active slot b, good\n"},
+		{args: nil, err: errUsage},
+		{args: []string{"activate"}, err: errUsage},
+		{args: []string{"reboot"}, err: errUsage},
+	} {
+		var out strings.Builder
+		err := run(&out, s, 3, tt.args)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("run(%q) = %v, want %v", tt.args, err, tt.err)
+		}
+		if out.String() != tt.want {
+			t.Errorf("run(%q) printed %q, want %q", tt.args, out.String(), tt.want)
+		}
+	}
+	if err := run(&strings.Builder{}, s, 3, []string{"activate", "c"}); err == nil {
+		t.Errorf("run(activate c) = nil, want error")
+	}
+}
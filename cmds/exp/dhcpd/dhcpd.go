@@ -0,0 +1,170 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// dhcpd is a minimal DHCPv4 server to bootstrap the machines of an
+// isolated provisioning network.
+//
+// Synopsis:
+//
+//	dhcpd [OPTIONS...]
+//
+// Options:
+//
+//	-interface:   interface to serve on (default: eth0)
+//	-ip:          address and subnet of the server (default: 192.168.0.1/24)
+//	-range:       first and last address to hand out, e.g. 192.168.0.100-192.168.0.200
+//	-reserve:     comma separated MAC=IP reservations
+//	-router:      default gateway to announce
+//	-dns:         comma separated name servers to announce
+//	-lease:       lease time (default: 1h)
+//	-tftp-server: boot server to announce (option 66)
+//	-bootfile:    boot file to announce (option 67)
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/server4"
+	"github.com/u-root/u-root/pkg/dhcpd"
+)
+
+var (
+	iface      = flag.String("interface", "eth0", "Interface to serve DHCPv4 on")
+	selfIP     = flag.String("ip", "192.168.0.1/24", "Address and subnet of the server")
+	addrRange  = flag.String("range", "", "First and last address to hand out, separated by a dash")
+	reserve    = flag.String("reserve", "", "Comma separated MAC=IP address reservations")
+	router     = flag.String("router", "", "Default gateway to announce")
+	dns        = flag.String("dns", "", "Comma separated name servers to announce")
+	leaseTime  = flag.Duration("lease", dhcpd.DefaultLeaseTime, "Lease time")
+	tftpServer = flag.String("tftp-server", "", "Boot server to announce (option 66)")
+	bootFile   = flag.String("bootfile", "", "Boot file to announce (option 67)")
+)
+
+var (
+	errRange       = errors.New("invalid address range")
+	errReservation = errors.New("invalid reservation")
+	errIP          = errors.New("invalid IPv4 address")
+)
+
+// parseIP parses an IPv4 address.
+func parseIP(s string) (net.IP, error) {
+	ip := net.ParseIP(strings.TrimSpace(s))
+	if ip.To4() == nil {
+		return nil, fmt.Errorf("%w: %q", errIP, s)
+	}
+	return ip.To4(), nil
+}
+
+// parseIPs parses a comma separated list of IPv4 addresses.
+func parseIPs(s string) ([]net.IP, error) {
+	var ips []net.IP
+	for _, f := range strings.Split(s, ",") {
+		ip, err := parseIP(f)
+		if err != nil {
+			return nil, err
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+// parseRange parses a range of the form first-last.
+func parseRange(s string) (net.IP, net.IP, error) {
+	first, last, ok := strings.Cut(s, "-")
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %q", errRange, s)
+	}
+	start, err := parseIP(first)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", errRange, err)
+	}
+	end, err := parseIP(last)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", errRange, err)
+	}
+	return start, end, nil
+}
+
+// parseReservations parses a comma separated list of MAC=IP pairs.
+func parseReservations(s string) (map[string]net.IP, error) {
+	r := map[string]net.IP{}
+	for _, f := range strings.Split(s, ",") {
+		m, i, ok := strings.Cut(f, "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", errReservation, f)
+		}
+		mac, err := net.ParseMAC(strings.TrimSpace(m))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errReservation, err)
+		}
+		ip, err := parseIP(i)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errReservation, err)
+		}
+		r[mac.String()] = ip
+	}
+	return r, nil
+}
+
+// config returns the server configuration of the flags.
+func config() (dhcpd.Config, error) {
+	ip, subnet, err := net.ParseCIDR(*selfIP)
+	if err != nil {
+		return dhcpd.Config{}, err
+	}
+	c := dhcpd.Config{
+		ServerIP:   ip.To4(),
+		Subnet:     subnet,
+		LeaseTime:  *leaseTime,
+		TFTPServer: *tftpServer,
+		BootFile:   *bootFile,
+	}
+	if *addrRange != "" {
+		if c.RangeStart, c.RangeEnd, err = parseRange(*addrRange); err != nil {
+			return dhcpd.Config{}, err
+		}
+	}
+	if *reserve != "" {
+		if c.Reservations, err = parseReservations(*reserve); err != nil {
+			return dhcpd.Config{}, err
+		}
+	}
+	if *router != "" {
+		if c.Router, err = parseIP(*router); err != nil {
+			return dhcpd.Config{}, err
+		}
+	}
+	if *dns != "" {
+		if c.DNS, err = parseIPs(*dns); err != nil {
+			return dhcpd.Config{}, err
+		}
+	}
+	return c, nil
+}
+
+func main() {
+	flag.Parse()
+	c, err := config()
+	if err != nil {
+		log.Fatal(err)
+	}
+	s, err := dhcpd.New(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	server, err := server4.NewServer(*iface, &net.UDPAddr{Port: dhcpv4.ServerPort}, s.Handler)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Serving DHCPv4 on %s", *iface)
+	if err := server.Serve(); err != nil {
+		log.Fatal(err)
+	}
+}
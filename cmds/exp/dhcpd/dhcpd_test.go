@@ -0,0 +1,47 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	for _, tt := range []struct {
+		in         string
+		start, end net.IP
+		err        error
+	}{
+		{in: "192.168.0.100-192.168.0.200", start: net.IPv4(192, 168, 0, 100), end: net.IPv4(192, 168, 0, 200)},
+		{in: "192.168.0.100 - 192.168.0.200", start: net.IPv4(192, 168, 0, 100), end: net.IPv4(192, 168, 0, 200)},
+		{in: "192.168.0.100", err: errRange},
+		{in: "192.168.0.100-fe80::1", err: errIP},
+	} {
+		start, end, err := parseRange(tt.in)
+		if !errors.Is(err, tt.err) || !start.Equal(tt.start) || !end.Equal(tt.end) {
+			t.Errorf("parseRange(%q) = %v, %v, %v, want %v, %v, %v", tt.in, start, end, err, tt.start, tt.end, tt.err)
+		}
+	}
+}
+
+func TestParseReservations(t *testing.T) {
+	r, err := parseReservations("02:00:00:00:00:0A=192.168.0.10, 02:00:00:00:00:0b=192.168.0.11")
+	if err != nil {
+		t.Fatalf("parseReservations() = %v", err)
+	}
+	if ip := r["02:00:00:00:00:0a"]; !ip.Equal(net.IPv4(192, 168, 0, 10)) {
+		t.Errorf("reservation of 02:00:00:00:00:0a = %v, want 192.168.0.10", ip)
+	}
+	if ip := r["02:00:00:00:00:0b"]; !ip.Equal(net.IPv4(192, 168, 0, 11)) {
+		t.Errorf("reservation of 02:00:00:00:00:0b = %v, want 192.168.0.11", ip)
+	}
+	for _, in := range []string{"02:00:00:00:00:0a", "nomac=192.168.0.10", "02:00:00:00:00:0a=nope"} {
+		if _, err := parseReservations(in); !errors.Is(err, errReservation) {
+			t.Errorf("parseReservations(%q) = %v, want %v", in, err, errReservation)
+		}
+	}
+}
@@ -0,0 +1,563 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// dnsquery queries a DNS server and prints the response, like dig.
+//
+// Synopsis:
+//
+//	dnsquery [@SERVER] [-p PORT] [-x ADDR] [+OPTION...] [NAME] [TYPE]
+//
+// Description:
+//
+//	dnsquery sends a query for NAME of TYPE, A by default, to SERVER, or the
+//	first nameserver of /etc/resolv.conf, and prints the response. TYPE is
+//	one of A, AAAA, ANY, CNAME, MX, NS, PTR, SOA, SRV and TXT. Without NAME,
+//	the root name servers are queried. Truncated UDP responses are retried
+//	over TCP.
+//
+// Options:
+//
+//	-p PORT:        port of the server (default: 53, 853 with +tls)
+//	-x ADDR:        reverse lookup of the address ADDR
+//	+short:         only print the data of the answers
+//	+json:          print the response as JSON
+//	+tcp:           query over TCP
+//	+tls:           query over TLS, RFC 7858
+//	+tls-name=NAME: name to verify the certificate of the server against
+//	                (default: SERVER)
+//	+norecurse:     do not ask for recursion
+//	+timeout=DUR:   how long to wait for a response (default: 5s)
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	resolvConf = "/etc/resolv.conf"
+	// udpSize is the EDNS0 UDP payload size advertised, which avoids
+	// fragmentation.
+	udpSize = 1232
+)
+
+var (
+	errUsage      = errors.New("usage: dnsquery [@SERVER] [-p PORT] [-x ADDR] [+OPTION...] [NAME] [TYPE]")
+	errOption     = errors.New("unknown option")
+	errAddr       = errors.New("invalid address")
+	errNameserver = errors.New("no nameserver")
+	errResponse   = errors.New("invalid response")
+)
+
+var types = map[string]dnsmessage.Type{
+	"A":     dnsmessage.TypeA,
+	"AAAA":  dnsmessage.TypeAAAA,
+	"ANY":   dnsmessage.TypeALL,
+	"CNAME": dnsmessage.TypeCNAME,
+	"MX":    dnsmessage.TypeMX,
+	"NS":    dnsmessage.TypeNS,
+	"PTR":   dnsmessage.TypePTR,
+	"SOA":   dnsmessage.TypeSOA,
+	"SRV":   dnsmessage.TypeSRV,
+	"TXT":   dnsmessage.TypeTXT,
+}
+
+var rcodes = map[dnsmessage.RCode]string{
+	dnsmessage.RCodeSuccess:        "NOERROR",
+	dnsmessage.RCodeFormatError:    "FORMERR",
+	dnsmessage.RCodeServerFailure:  "SERVFAIL",
+	dnsmessage.RCodeNameError:      "NXDOMAIN",
+	dnsmessage.RCodeNotImplemented: "NOTIMP",
+	dnsmessage.RCodeRefused:        "REFUSED",
+}
+
+func typeName(t dnsmessage.Type) string {
+	for n, typ := range types {
+		if typ == t {
+			return n
+		}
+	}
+	return fmt.Sprintf("TYPE%d", t)
+}
+
+func className(c dnsmessage.Class) string {
+	if c == dnsmessage.ClassINET {
+		return "IN"
+	}
+	return fmt.Sprintf("CLASS%d", c)
+}
+
+func rcodeName(r dnsmessage.RCode) string {
+	if n, ok := rcodes[r]; ok {
+		return n
+	}
+	return fmt.Sprintf("RCODE%d", r)
+}
+
+type cmd struct {
+	stdout io.Writer
+
+	server  string
+	port    string
+	name    string
+	qtype   dnsmessage.Type
+	short   bool
+	json    bool
+	tcp     bool
+	tls     bool
+	tlsName string
+	recurse bool
+	timeout time.Duration
+
+	// tlsConfig is the base TLS configuration, the system roots if nil.
+	tlsConfig *tls.Config
+}
+
+// reverse returns the name of the PTR record of addr.
+func reverse(addr string) (string, error) {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return "", fmt.Errorf("%w: %q", errAddr, addr)
+	}
+	var b strings.Builder
+	if ip4 := ip.To4(); ip4 != nil {
+		for i := len(ip4) - 1; i >= 0; i-- {
+			fmt.Fprintf(&b, "%d.", ip4[i])
+		}
+		return b.String() + "in-addr.arpa.", nil
+	}
+	for i := len(ip) - 1; i >= 0; i-- {
+		fmt.Fprintf(&b, "%x.%x.", ip[i]&0xf, ip[i]>>4)
+	}
+	return b.String() + "ip6.arpa.", nil
+}
+
+// nameserver returns the first nameserver of the resolv.conf at path.
+func nameserver(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if w := strings.Fields(s.Text()); len(w) > 1 && w[0] == "nameserver" {
+			return w[1], nil
+		}
+	}
+	if err := s.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("%w in %s", errNameserver, path)
+}
+
+// parse parses the dig style arguments, defaulting the server to the first
+// nameserver of resolv.
+func parse(args []string, resolv string) (*cmd, error) {
+	c := &cmd{stdout: os.Stdout, qtype: dnsmessage.TypeA, recurse: true, timeout: 5 * time.Second}
+	var haveType bool
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		switch {
+		case strings.HasPrefix(a, "@"):
+			c.server = a[1:]
+		case a == "-p" || a == "-x":
+			if i+1 == len(args) {
+				return nil, errUsage
+			}
+			i++
+			if a == "-p" {
+				if _, err := strconv.ParseUint(args[i], 10, 16); err != nil {
+					return nil, fmt.Errorf("%w: port %q", errUsage, args[i])
+				}
+				c.port = args[i]
+				break
+			}
+			n, err := reverse(args[i])
+			if err != nil {
+				return nil, err
+			}
+			c.name, c.qtype, haveType = n, dnsmessage.TypePTR, true
+		case strings.HasPrefix(a, "+"):
+			opt, value, _ := strings.Cut(a[1:], "=")
+			switch opt {
+			case "short":
+				c.short = true
+			case "json":
+				c.json = true
+			case "tcp":
+				c.tcp = true
+			case "tls":
+				c.tls = true
+			case "tls-name":
+				c.tlsName = value
+			case "norecurse":
+				c.recurse = false
+			case "timeout":
+				d, err := time.ParseDuration(value)
+				if err != nil {
+					return nil, fmt.Errorf("%w: %v", errOption, err)
+				}
+				c.timeout = d
+			default:
+				return nil, fmt.Errorf("%w: %q", errOption, a)
+			}
+		case strings.HasPrefix(a, "-"):
+			return nil, fmt.Errorf("%w: %q", errOption, a)
+		default:
+			if t, ok := types[strings.ToUpper(a)]; ok && !haveType {
+				c.qtype, haveType = t, true
+				break
+			}
+			if c.name != "" {
+				return nil, errUsage
+			}
+			c.name = a
+		}
+	}
+	if c.name == "" {
+		c.name = "."
+		if !haveType {
+			c.qtype = dnsmessage.TypeNS
+		}
+	}
+	if !strings.HasSuffix(c.name, ".") {
+		c.name += "."
+	}
+	if c.port == "" {
+		c.port = "53"
+		if c.tls {
+			c.port = "853"
+		}
+	}
+	if c.server == "" {
+		s, err := nameserver(resolv)
+		if err != nil {
+			return nil, err
+		}
+		c.server = s
+	}
+	return c, nil
+}
+
+// query returns the query message and its id.
+func (c *cmd) query() ([]byte, uint16, error) {
+	name, err := dnsmessage.NewName(c.name)
+	if err != nil {
+		return nil, 0, err
+	}
+	var id [2]byte
+	rand.Read(id[:])
+	h := dnsmessage.Header{ID: binary.BigEndian.Uint16(id[:]), RecursionDesired: c.recurse}
+	b := dnsmessage.NewBuilder(nil, h)
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, 0, err
+	}
+	if err := b.Question(dnsmessage.Question{Name: name, Type: c.qtype, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, 0, err
+	}
+	if err := b.StartAdditionals(); err != nil {
+		return nil, 0, err
+	}
+	var opt dnsmessage.ResourceHeader
+	if err := opt.SetEDNS0(udpSize, dnsmessage.RCodeSuccess, false); err != nil {
+		return nil, 0, err
+	}
+	if err := b.OPTResource(opt, dnsmessage.OPTResource{}); err != nil {
+		return nil, 0, err
+	}
+	msg, err := b.Finish()
+	return msg, h.ID, err
+}
+
+// datagram exchanges msg over UDP, skipping responses of other ids.
+func (c *cmd) datagram(addr string, msg []byte, id uint16) ([]byte, error) {
+	conn, err := net.DialTimeout("udp", addr, c.timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(c.timeout))
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+	b := make([]byte, 65535)
+	for {
+		n, err := conn.Read(b)
+		if err != nil {
+			return nil, err
+		}
+		if n >= 2 && binary.BigEndian.Uint16(b) == id {
+			return b[:n], nil
+		}
+	}
+}
+
+// stream exchanges msg over TCP or TLS, with the length prefixes of RFC
+// 1035 4.2.2.
+func (c *cmd) stream(addr string, msg []byte) ([]byte, error) {
+	d := &net.Dialer{Timeout: c.timeout}
+	var conn net.Conn
+	var err error
+	if c.tls {
+		conf := &tls.Config{}
+		if c.tlsConfig != nil {
+			conf = c.tlsConfig.Clone()
+		}
+		conf.ServerName = c.tlsName
+		if conf.ServerName == "" {
+			conf.ServerName = c.server
+		}
+		conn, err = (&tls.Dialer{NetDialer: d, Config: conf}).Dial("tcp", addr)
+	} else {
+		conn, err = d.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(c.timeout))
+
+	if _, err := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(msg))), msg...)); err != nil {
+		return nil, err
+	}
+	var l [2]byte
+	if _, err := io.ReadFull(conn, l[:]); err != nil {
+		return nil, err
+	}
+	b := make([]byte, binary.BigEndian.Uint16(l[:]))
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// exchange sends the query and returns the response and the protocol it
+// came over.
+func (c *cmd) exchange() (*dnsmessage.Message, string, error) {
+	msg, id, err := c.query()
+	if err != nil {
+		return nil, "", err
+	}
+	addr := net.JoinHostPort(c.server, c.port)
+
+	var b []byte
+	proto := "udp"
+	if !c.tcp && !c.tls {
+		if b, err = c.datagram(addr, msg, id); err != nil {
+			return nil, "", err
+		}
+		// The truncated flag is in the third byte.
+		if len(b) > 2 && b[2]&0x2 != 0 {
+			b = nil
+		}
+	}
+	if b == nil {
+		proto = "tcp"
+		if c.tls {
+			proto = "tls"
+		}
+		if b, err = c.stream(addr, msg); err != nil {
+			return nil, "", err
+		}
+	}
+
+	m := &dnsmessage.Message{}
+	if err := m.Unpack(b); err != nil {
+		return nil, "", fmt.Errorf("%w: %v", errResponse, err)
+	}
+	if m.ID != id || !m.Response {
+		return nil, "", fmt.Errorf("%w: id %d, want a response to %d", errResponse, m.ID, id)
+	}
+	return m, proto, nil
+}
+
+// data returns the presentation format of the data of a record.
+func data(body dnsmessage.ResourceBody) string {
+	switch r := body.(type) {
+	case *dnsmessage.AResource:
+		return net.IP(r.A[:]).String()
+	case *dnsmessage.AAAAResource:
+		return net.IP(r.AAAA[:]).String()
+	case *dnsmessage.CNAMEResource:
+		return r.CNAME.String()
+	case *dnsmessage.NSResource:
+		return r.NS.String()
+	case *dnsmessage.PTRResource:
+		return r.PTR.String()
+	case *dnsmessage.MXResource:
+		return fmt.Sprintf("%d %s", r.Pref, r.MX)
+	case *dnsmessage.SRVResource:
+		return fmt.Sprintf("%d %d %d %s", r.Priority, r.Weight, r.Port, r.Target)
+	case *dnsmessage.TXTResource:
+		q := make([]string, len(r.TXT))
+		for i, t := range r.TXT {
+			q[i] = strconv.Quote(t)
+		}
+		return strings.Join(q, " ")
+	case *dnsmessage.SOAResource:
+		return fmt.Sprintf("%s %s %d %d %d %d %d", r.NS, r.MBox, r.Serial, r.Refresh, r.Retry, r.Expire, r.MinTTL)
+	case *dnsmessage.UnknownResource:
+		return fmt.Sprintf(`\# %d %x`, len(r.Data), r.Data)
+	}
+	return fmt.Sprint(body)
+}
+
+func flags(h dnsmessage.Header) []string {
+	var f []string
+	for _, flag := range []struct {
+		set  bool
+		name string
+	}{
+		{h.Response, "qr"},
+		{h.Authoritative, "aa"},
+		{h.Truncated, "tc"},
+		{h.RecursionDesired, "rd"},
+		{h.RecursionAvailable, "ra"},
+		{h.AuthenticData, "ad"},
+		{h.CheckingDisabled, "cd"},
+	} {
+		if flag.set {
+			f = append(f, flag.name)
+		}
+	}
+	return f
+}
+
+type record struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Class string `json:"class"`
+	TTL   uint32 `json:"ttl"`
+	Data  string `json:"data"`
+}
+
+type question struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Class string `json:"class"`
+}
+
+type response struct {
+	Server     string     `json:"server"`
+	Protocol   string     `json:"protocol"`
+	ID         uint16     `json:"id"`
+	Status     string     `json:"status"`
+	Flags      []string   `json:"flags"`
+	Question   []question `json:"question"`
+	Answer     []record   `json:"answer"`
+	Authority  []record   `json:"authority"`
+	Additional []record   `json:"additional"`
+	TimeMS     int64      `json:"time_ms"`
+}
+
+// records returns the records of rs, without the EDNS0 pseudo record.
+func records(rs []dnsmessage.Resource) []record {
+	out := []record{}
+	for _, r := range rs {
+		if r.Header.Type == dnsmessage.TypeOPT {
+			continue
+		}
+		out = append(out, record{
+			Name:  r.Header.Name.String(),
+			Type:  typeName(r.Header.Type),
+			Class: className(r.Header.Class),
+			TTL:   r.Header.TTL,
+			Data:  data(r.Body),
+		})
+	}
+	return out
+}
+
+func (c *cmd) print(m *dnsmessage.Message, proto string, rtt time.Duration) error {
+	r := response{
+		Server:     net.JoinHostPort(c.server, c.port),
+		Protocol:   proto,
+		ID:         m.ID,
+		Status:     rcodeName(m.RCode),
+		Flags:      flags(m.Header),
+		Question:   []question{},
+		Answer:     records(m.Answers),
+		Authority:  records(m.Authorities),
+		Additional: records(m.Additionals),
+		TimeMS:     rtt.Milliseconds(),
+	}
+	for _, q := range m.Questions {
+		r.Question = append(r.Question, question{Name: q.Name.String(), Type: typeName(q.Type), Class: className(q.Class)})
+	}
+
+	switch {
+	case c.json:
+		e := json.NewEncoder(c.stdout)
+		e.SetIndent("", "  ")
+		return e.Encode(r)
+	case c.short:
+		for _, a := range r.Answer {
+			fmt.Fprintln(c.stdout, a.Data)
+		}
+		return nil
+	}
+
+	w := c.stdout
+	fmt.Fprintf(w, ";; ->>HEADER<<- opcode: QUERY, status: %s, id: %d\n", r.Status, r.ID)
+	fmt.Fprintf(w, ";; flags: %s; QUERY: %d, ANSWER: %d, AUTHORITY: %d, ADDITIONAL: %d\n",
+		strings.Join(r.Flags, " "), len(r.Question), len(r.Answer), len(r.Authority), len(r.Additional))
+	fmt.Fprintf(w, "\n;; QUESTION SECTION:\n")
+	for _, q := range r.Question {
+		fmt.Fprintf(w, ";%s\t\t%s\t%s\n", q.Name, q.Class, q.Type)
+	}
+	for _, s := range []struct {
+		name    string
+		records []record
+	}{
+		{"ANSWER", r.Answer},
+		{"AUTHORITY", r.Authority},
+		{"ADDITIONAL", r.Additional},
+	} {
+		if len(s.records) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n;; %s SECTION:\n", s.name)
+		for _, rr := range s.records {
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n", rr.Name, rr.TTL, rr.Class, rr.Type, rr.Data)
+		}
+	}
+	fmt.Fprintf(w, "\n;; Query time: %d msec\n", r.TimeMS)
+	fmt.Fprintf(w, ";; SERVER: %s#%s(%s)\n", c.server, c.port, proto)
+	return nil
+}
+
+func (c *cmd) run() error {
+	start := time.Now()
+	m, proto, err := c.exchange()
+	if err != nil {
+		return err
+	}
+	return c.print(m, proto, time.Since(start))
+}
+
+func main() {
+	c, err := parse(os.Args[1:], resolvConf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := c.run(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,293 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestParse(t *testing.T) {
+	resolv := filepath.Join(t.TempDir(), "resolv.conf")
+	if err := os.WriteFile(resolv, []byte("search example.com\nnameserver 10.0.0.1\nnameserver 10.0.0.2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		args    []string
+		want    cmd
+		wantErr error
+	}{
+		{
+			args: []string{"u-root.org"},
+			want: cmd{server: "10.0.0.1", port: "53", name: "u-root.org.", qtype: dnsmessage.TypeA, recurse: true},
+		},
+		{
+			args: []string{"@1.1.1.1", "+tls", "+short", "srv", "_ssh._tcp.example.com."},
+			want: cmd{server: "1.1.1.1", port: "853", name: "_ssh._tcp.example.com.", qtype: dnsmessage.TypeSRV, short: true, tls: true, recurse: true},
+		},
+		{
+			args: []string{"@::1", "-p", "5353", "+json", "+tcp", "+norecurse", "example.com", "txt"},
+			want: cmd{server: "::1", port: "5353", name: "example.com.", qtype: dnsmessage.TypeTXT, json: true, tcp: true},
+		},
+		{
+			args: []string{"-x", "192.0.2.1", "+tls-name=dns.example"},
+			want: cmd{server: "10.0.0.1", port: "53", name: "1.2.0.192.in-addr.arpa.", qtype: dnsmessage.TypePTR, tlsName: "dns.example", recurse: true},
+		},
+		{
+			args: []string{},
+			want: cmd{server: "10.0.0.1", port: "53", name: ".", qtype: dnsmessage.TypeNS, recurse: true},
+		},
+		{args: []string{"+bogus"}, wantErr: errOption},
+		{args: []string{"-q"}, wantErr: errOption},
+		{args: []string{"-p"}, wantErr: errUsage},
+		{args: []string{"-p", "port"}, wantErr: errUsage},
+		{args: []string{"a.example", "b.example"}, wantErr: errUsage},
+		{args: []string{"-x", "nope"}, wantErr: errAddr},
+		{args: []string{"+timeout=soon"}, wantErr: errOption},
+	} {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			c, err := parse(tt.args, resolv)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("parse() = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			c.stdout, c.timeout = nil, 0
+			if *c != tt.want {
+				t.Errorf("parse() = %+v, want %+v", *c, tt.want)
+			}
+		})
+	}
+
+	if _, err := parse(nil, filepath.Join(t.TempDir(), "resolv.conf")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("parse() without resolv.conf = %v, want %v", err, os.ErrNotExist)
+	}
+	empty := filepath.Join(t.TempDir(), "resolv.conf")
+	if err := os.WriteFile(empty, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parse(nil, empty); !errors.Is(err, errNameserver) {
+		t.Errorf("parse() without nameserver = %v, want %v", err, errNameserver)
+	}
+}
+
+func TestReverse(t *testing.T) {
+	for addr, want := range map[string]string{
+		"192.0.2.10":  "10.2.0.192.in-addr.arpa.",
+		"2001:db8::1": "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.",
+	} {
+		if got, err := reverse(addr); err != nil || got != want {
+			t.Errorf("reverse(%q) = %q, %v, want %q", addr, got, err, want)
+		}
+	}
+}
+
+// answer returns the response to the query b, truncated if truncate and
+// the name asks for it.
+func answer(t *testing.T, b []byte, truncate bool) []byte {
+	var q dnsmessage.Message
+	if err := q.Unpack(b); err != nil {
+		t.Errorf("unpacking query: %v", err)
+		return nil
+	}
+	r := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: q.ID, Response: true, RecursionDesired: q.RecursionDesired, RecursionAvailable: true},
+		Questions: q.Questions,
+	}
+	question := q.Questions[0]
+	h := dnsmessage.ResourceHeader{Name: question.Name, Type: question.Type, Class: dnsmessage.ClassINET, TTL: 300}
+	switch {
+	case question.Name.String() == "nx.example.":
+		r.RCode = dnsmessage.RCodeNameError
+	case question.Name.String() == "big.example." && truncate:
+		r.Truncated = true
+	case question.Type == dnsmessage.TypeA:
+		r.Answers = []dnsmessage.Resource{
+			{Header: h, Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}},
+			{Header: h, Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 2}}},
+		}
+	case question.Type == dnsmessage.TypeSRV:
+		target := dnsmessage.MustNewName("host.example.")
+		r.Answers = []dnsmessage.Resource{{Header: h, Body: &dnsmessage.SRVResource{Priority: 10, Weight: 5, Port: 22, Target: target}}}
+	case question.Type == dnsmessage.TypeTXT:
+		r.Answers = []dnsmessage.Resource{{Header: h, Body: &dnsmessage.TXTResource{TXT: []string{"v=spf1 -all", `say "hi"`}}}}
+	}
+	p, err := r.Pack()
+	if err != nil {
+		t.Errorf("packing response: %v", err)
+	}
+	return p
+}
+
+func serveStream(t *testing.T, l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		var n [2]byte
+		if _, err := io.ReadFull(conn, n[:]); err == nil {
+			b := make([]byte, binary.BigEndian.Uint16(n[:]))
+			if _, err := io.ReadFull(conn, b); err == nil {
+				r := answer(t, b, false)
+				conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(r))), r...))
+			}
+		}
+		conn.Close()
+	}
+}
+
+// server serves DNS on UDP and TCP of a port, and TLS on another, returning
+// the ports and the roots to trust.
+func server(t *testing.T) (string, string, *x509.CertPool) {
+	var udp net.PacketConn
+	var tcp net.Listener
+	for i := 0; ; i++ {
+		var err error
+		if udp, err = net.ListenPacket("udp", "127.0.0.1:0"); err != nil {
+			t.Fatal(err)
+		}
+		if tcp, err = net.Listen("tcp", udp.LocalAddr().String()); err == nil {
+			break
+		}
+		udp.Close()
+		if i == 10 {
+			t.Fatalf("no port free for UDP and TCP: %v", err)
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		DNSNames:     []string{"dns.example"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	tl, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		udp.Close()
+		tcp.Close()
+		tl.Close()
+	})
+
+	go func() {
+		b := make([]byte, 512)
+		for {
+			n, addr, err := udp.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			udp.WriteTo(answer(t, b[:n], true), addr)
+		}
+	}()
+	go serveStream(t, tcp)
+	go serveStream(t, tl)
+	_, port, _ := net.SplitHostPort(udp.LocalAddr().String())
+	_, tlsPort, _ := net.SplitHostPort(tl.Addr().String())
+	return port, tlsPort, roots
+}
+
+func TestQuery(t *testing.T) {
+	port, tlsPort, roots := server(t)
+	for _, tt := range []struct {
+		name    string
+		args    []string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "text",
+			args: []string{"a.example"},
+			want: []string{
+				";; ->>HEADER<<- opcode: QUERY, status: NOERROR",
+				";; flags: qr rd ra; QUERY: 1, ANSWER: 2, AUTHORITY: 0, ADDITIONAL: 0",
+				";a.example.\t\tIN\tA",
+				";; ANSWER SECTION:\na.example.\t300\tIN\tA\t192.0.2.1\na.example.\t300\tIN\tA\t192.0.2.2\n",
+				"(udp)",
+			},
+		},
+		{name: "short", args: []string{"+short", "a.example"}, want: []string{"192.0.2.1\n192.0.2.2\n"}},
+		{name: "srv", args: []string{"+short", "_ssh._tcp.example", "SRV"}, want: []string{"10 5 22 host.example.\n"}},
+		{name: "txt", args: []string{"+short", "example", "TXT"}, want: []string{`"v=spf1 -all" "say \"hi\""` + "\n"}},
+		{name: "nxdomain", args: []string{"nx.example"}, want: []string{"status: NXDOMAIN"}},
+		{name: "truncated", args: []string{"big.example"}, want: []string{"ANSWER: 2", "(tcp)"}},
+		{name: "tcp", args: []string{"+tcp", "a.example"}, want: []string{"ANSWER: 2", "(tcp)"}},
+		{name: "tls", args: []string{"+tls", "-p", tlsPort, "a.example"}, want: []string{"ANSWER: 2", "(tls)"}},
+		{name: "tls name", args: []string{"+tls", "+tls-name=dns.example", "-p", tlsPort, "a.example"}, want: []string{"(tls)"}},
+		{name: "tls wrong name", args: []string{"+tls", "+tls-name=other.example", "-p", tlsPort, "a.example"}, wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"@127.0.0.1", "-p", port, "+timeout=2s"}, tt.args...)
+			c, err := parse(args, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out bytes.Buffer
+			c.stdout, c.tlsConfig = &out, &tls.Config{RootCAs: roots}
+			if err := c.run(); (err != nil) != tt.wantErr {
+				t.Fatalf("run() = %v, want error %t", err, tt.wantErr)
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(out.String(), w) {
+					t.Errorf("output %q does not contain %q", out.String(), w)
+				}
+			}
+		})
+	}
+
+	t.Run("json", func(t *testing.T) {
+		c, err := parse([]string{"@127.0.0.1", "-p", port, "+json", "a.example"}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		c.stdout = &out
+		if err := c.run(); err != nil {
+			t.Fatal(err)
+		}
+		var r response
+		if err := json.Unmarshal(out.Bytes(), &r); err != nil {
+			t.Fatalf("output %q: %v", out.String(), err)
+		}
+		if r.Status != "NOERROR" || r.Protocol != "udp" || len(r.Answer) != 2 || r.Answer[1].Data != "192.0.2.2" || r.Answer[0].Type != "A" {
+			t.Errorf("response %+v, want two A answers over udp", r)
+		}
+	})
+}
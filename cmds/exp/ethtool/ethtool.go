@@ -0,0 +1,465 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+// ethtool shows and changes the settings of network interface cards.
+//
+// Synopsis:
+//
+//	ethtool DEV
+//	ethtool -s DEV [speed N] [duplex half|full] [autoneg on|off]
+//	ethtool -i DEV
+//	ethtool -g DEV
+//	ethtool -G DEV [rx N] [rx-mini N] [rx-jumbo N] [tx N]
+//	ethtool -k DEV
+//	ethtool -K DEV FEATURE on|off...
+//	ethtool -S DEV
+//	ethtool -p DEV [SECONDS]
+//
+// Description:
+//
+//	ethtool queries and controls network drivers and hardware through the
+//	SIOCETHTOOL ioctl, like ethtool(8).
+//
+//	With only a device, ethtool shows its link modes, speed, duplex, port,
+//	auto-negotiation and whether a link is detected; -s changes the speed,
+//	in Mb/s, duplex and auto-negotiation.
+//
+//	-i shows the driver, its version, the firmware version and the bus of
+//	the device.
+//
+//	-g shows the maximum and current sizes of the rings of the device, and
+//	-G changes them.
+//
+//	-k shows the features of the device, offloads among them, by their
+//	kernel names, and -K changes them. -K also takes the short names of
+//	ethtool(8): rx, tx, sg, tso, gso, gro, lro, rxvlan, txvlan, ntuple and
+//	rxhash.
+//
+//	-S shows the statistics of the driver.
+//
+//	-p blinks the LEDs of the device to identify its port, for SECONDS or
+//	until interrupted.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/safchain/ethtool"
+)
+
+var (
+	errUsage = errors.New("usage: ethtool DEV | -s DEV [speed N] [duplex half|full] [autoneg on|off] | -i DEV | -g DEV | -G DEV [rx N] [rx-mini N] [rx-jumbo N] [tx N] | -k DEV | -K DEV FEATURE on|off... | -S DEV | -p DEV [SECONDS]")
+	errArg   = errors.New("invalid argument")
+)
+
+// nic are the ethtool operations, which handle implements.
+type nic interface {
+	CmdGet(ecmd *ethtool.EthtoolCmd, intf string) (uint32, error)
+	CmdSet(ecmd *ethtool.EthtoolCmd, intf string) (uint32, error)
+	LinkState(intf string) (uint32, error)
+	DriverInfo(intf string) (ethtool.DrvInfo, error)
+	Features(intf string) (map[string]bool, error)
+	Change(intf string, config map[string]bool) error
+	Stats(intf string) (map[string]uint64, error)
+	Rings(intf string) (ringParam, error)
+	SetRings(intf string, r ringParam) error
+	Identify(intf string, secs uint32) error
+}
+
+type cmd struct {
+	nic nic
+	out io.Writer
+}
+
+// linkModes are the bits of the link modes in the supported and advertised
+// masks of struct ethtool_cmd, see linux/ethtool.h.
+var linkModes = []struct {
+	bit  uint
+	name string
+}{
+	{0, "10baseT/Half"},
+	{1, "10baseT/Full"},
+	{2, "100baseT/Half"},
+	{3, "100baseT/Full"},
+	{4, "1000baseT/Half"},
+	{5, "1000baseT/Full"},
+	{12, "10000baseT/Full"},
+	{15, "2500baseX/Full"},
+	{17, "1000baseKX/Full"},
+	{18, "10000baseKX4/Full"},
+	{19, "10000baseKR/Full"},
+	{21, "20000baseMLD2/Full"},
+	{22, "20000baseKR2/Full"},
+	{23, "40000baseKR4/Full"},
+	{24, "40000baseCR4/Full"},
+	{25, "40000baseSR4/Full"},
+	{26, "40000baseLR4/Full"},
+	{27, "56000baseKR4/Full"},
+	{28, "56000baseCR4/Full"},
+	{29, "56000baseSR4/Full"},
+	{30, "56000baseLR4/Full"},
+}
+
+const supportedAutoneg = 1 << 6
+
+var ports = map[uint8]string{
+	0x00: "Twisted Pair",
+	0x01: "AUI",
+	0x02: "BNC",
+	0x03: "MII",
+	0x04: "FIBRE",
+	0x05: "Direct Attach Copper",
+	0xef: "None",
+	0xff: "Other",
+}
+
+// featureAliases are the short names ethtool(8) takes for features.
+var featureAliases = map[string][]string{
+	"rx":     {"rx-checksum"},
+	"tx":     {"tx-checksum-ipv4", "tx-checksum-ip-generic", "tx-checksum-ipv6", "tx-checksum-fcoe-crc", "tx-checksum-sctp"},
+	"sg":     {"tx-scatter-gather", "tx-scatter-gather-fraglist"},
+	"tso":    {"tx-tcp-segmentation", "tx-tcp-ecn-segmentation", "tx-tcp-mangleid-segmentation", "tx-tcp6-segmentation"},
+	"gso":    {"tx-generic-segmentation"},
+	"gro":    {"rx-gro"},
+	"lro":    {"rx-lro"},
+	"rxvlan": {"rx-vlan-hw-parse"},
+	"txvlan": {"tx-vlan-hw-insert"},
+	"ntuple": {"rx-ntuple-filter"},
+	"rxhash": {"rx-hashing"},
+}
+
+func modes(mask uint32) string {
+	var s []string
+	for _, m := range linkModes {
+		if mask&(1<<m.bit) != 0 {
+			s = append(s, m.name)
+		}
+	}
+	if len(s) == 0 {
+		return "Not reported"
+	}
+	return strings.Join(s, " ")
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+func parseOnOff(s string) (bool, error) {
+	switch s {
+	case "on":
+		return true, nil
+	case "off":
+		return false, nil
+	}
+	return false, fmt.Errorf("%w: %q is not on or off", errArg, s)
+}
+
+func speed(e *ethtool.EthtoolCmd) uint32 {
+	return uint32(e.Speed_hi)<<16 | uint32(e.Speed)
+}
+
+func (c *cmd) settings(dev string) error {
+	var e ethtool.EthtoolCmd
+	if _, err := c.nic.CmdGet(&e, dev); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "Settings for %s:\n", dev)
+	fmt.Fprintf(c.out, "\tSupported link modes:   %s\n", modes(e.Supported))
+	autoneg := "No"
+	if e.Supported&supportedAutoneg != 0 {
+		autoneg = "Yes"
+	}
+	fmt.Fprintf(c.out, "\tSupports auto-negotiation: %s\n", autoneg)
+	fmt.Fprintf(c.out, "\tAdvertised link modes:  %s\n", modes(e.Advertising))
+
+	s := "Unknown!"
+	if sp := speed(&e); sp != 0 && sp != math.MaxUint16 && sp != math.MaxUint32 {
+		s = fmt.Sprintf("%dMb/s", sp)
+	}
+	fmt.Fprintf(c.out, "\tSpeed: %s\n", s)
+	var d string
+	switch e.Duplex {
+	case 0:
+		d = "Half"
+	case 1:
+		d = "Full"
+	default:
+		d = fmt.Sprintf("Unknown! (%d)", e.Duplex)
+	}
+	fmt.Fprintf(c.out, "\tDuplex: %s\n", d)
+	p, ok := ports[e.Port]
+	if !ok {
+		p = fmt.Sprintf("Unknown! (%d)", e.Port)
+	}
+	fmt.Fprintf(c.out, "\tPort: %s\n", p)
+	fmt.Fprintf(c.out, "\tAuto-negotiation: %s\n", onOff(e.Autoneg != 0))
+
+	if link, err := c.nic.LinkState(dev); err == nil {
+		detected := "no"
+		if link != 0 {
+			detected = "yes"
+		}
+		fmt.Fprintf(c.out, "\tLink detected: %s\n", detected)
+	}
+	return nil
+}
+
+func (c *cmd) setSettings(dev string, args []string) error {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return errUsage
+	}
+	var e ethtool.EthtoolCmd
+	if _, err := c.nic.CmdGet(&e, dev); err != nil {
+		return err
+	}
+	for ; len(args) > 0; args = args[2:] {
+		opt, v := args[0], args[1]
+		switch opt {
+		case "speed":
+			n, err := strconv.ParseUint(v, 10, 32)
+			if err != nil {
+				return fmt.Errorf("%w: speed %q", errArg, v)
+			}
+			e.Speed, e.Speed_hi = uint16(n), uint16(n>>16)
+		case "duplex":
+			switch v {
+			case "half":
+				e.Duplex = 0
+			case "full":
+				e.Duplex = 1
+			default:
+				return fmt.Errorf("%w: duplex %q", errArg, v)
+			}
+		case "autoneg":
+			on, err := parseOnOff(v)
+			if err != nil {
+				return err
+			}
+			e.Autoneg = 0
+			if on {
+				// Advertise all the supported modes, as ethtool(8)
+				// does without advertise.
+				e.Autoneg = 1
+				e.Advertising = e.Supported
+			}
+		default:
+			return fmt.Errorf("%w: %q", errArg, opt)
+		}
+	}
+	_, err := c.nic.CmdSet(&e, dev)
+	return err
+}
+
+func (c *cmd) driverInfo(dev string) error {
+	d, err := c.nic.DriverInfo(dev)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "driver: %s\n", d.Driver)
+	fmt.Fprintf(c.out, "version: %s\n", d.Version)
+	fmt.Fprintf(c.out, "firmware-version: %s\n", d.FwVersion)
+	fmt.Fprintf(c.out, "expansion-rom-version: %s\n", d.EromVersion)
+	fmt.Fprintf(c.out, "bus-info: %s\n", d.BusInfo)
+	return nil
+}
+
+func (c *cmd) rings(dev string) error {
+	r, err := c.nic.Rings(dev)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "Ring parameters for %s:\n", dev)
+	fmt.Fprintf(c.out, "Pre-set maximums:\n")
+	fmt.Fprintf(c.out, "RX:\t\t%d\nRX Mini:\t%d\nRX Jumbo:\t%d\nTX:\t\t%d\n", r.RxMaxPending, r.RxMiniMaxPending, r.RxJumboMaxPending, r.TxMaxPending)
+	fmt.Fprintf(c.out, "Current hardware settings:\n")
+	fmt.Fprintf(c.out, "RX:\t\t%d\nRX Mini:\t%d\nRX Jumbo:\t%d\nTX:\t\t%d\n", r.RxPending, r.RxMiniPending, r.RxJumboPending, r.TxPending)
+	return nil
+}
+
+func (c *cmd) setRings(dev string, args []string) error {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return errUsage
+	}
+	r, err := c.nic.Rings(dev)
+	if err != nil {
+		return err
+	}
+	for ; len(args) > 0; args = args[2:] {
+		opt, v := args[0], args[1]
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return fmt.Errorf("%w: %s %q", errArg, opt, v)
+		}
+		var cur *uint32
+		var max uint32
+		switch opt {
+		case "rx":
+			cur, max = &r.RxPending, r.RxMaxPending
+		case "rx-mini":
+			cur, max = &r.RxMiniPending, r.RxMiniMaxPending
+		case "rx-jumbo":
+			cur, max = &r.RxJumboPending, r.RxJumboMaxPending
+		case "tx":
+			cur, max = &r.TxPending, r.TxMaxPending
+		default:
+			return fmt.Errorf("%w: %q", errArg, opt)
+		}
+		if uint32(n) > max {
+			return fmt.Errorf("%w: %s %d is more than the maximum %d", errArg, opt, n, max)
+		}
+		*cur = uint32(n)
+	}
+	return c.nic.SetRings(dev, r)
+}
+
+func (c *cmd) features(dev string) error {
+	f, err := c.nic.Features(dev)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(f))
+	for name := range f {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(c.out, "Features for %s:\n", dev)
+	for _, name := range names {
+		fmt.Fprintf(c.out, "%s: %s\n", name, onOff(f[name]))
+	}
+	return nil
+}
+
+func (c *cmd) setFeatures(dev string, args []string) error {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return errUsage
+	}
+	f, err := c.nic.Features(dev)
+	if err != nil {
+		return err
+	}
+	config := map[string]bool{}
+	for ; len(args) > 0; args = args[2:] {
+		name := args[0]
+		on, err := parseOnOff(args[1])
+		if err != nil {
+			return err
+		}
+		if _, ok := f[name]; ok {
+			config[name] = on
+			continue
+		}
+		aliases, ok := featureAliases[name]
+		if !ok {
+			return fmt.Errorf("%w: unknown feature %q", errArg, name)
+		}
+		// Only the features of an alias the device has.
+		for _, a := range aliases {
+			if _, ok := f[a]; ok {
+				config[a] = on
+			}
+		}
+	}
+	return c.nic.Change(dev, config)
+}
+
+func (c *cmd) stats(dev string) error {
+	s, err := c.nic.Stats(dev)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(c.out, "NIC statistics:\n")
+	for _, name := range names {
+		fmt.Fprintf(c.out, "     %s: %d\n", name, s[name])
+	}
+	return nil
+}
+
+func (c *cmd) identify(dev string, args []string) error {
+	var secs uint64
+	switch len(args) {
+	case 0:
+	case 1:
+		var err error
+		if secs, err = strconv.ParseUint(args[0], 10, 32); err != nil {
+			return fmt.Errorf("%w: seconds %q", errArg, args[0])
+		}
+	default:
+		return errUsage
+	}
+	return c.nic.Identify(dev, uint32(secs))
+}
+
+func (c *cmd) run(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	if !strings.HasPrefix(args[0], "-") {
+		if len(args) != 1 {
+			return errUsage
+		}
+		return c.settings(args[0])
+	}
+	if len(args) < 2 {
+		return errUsage
+	}
+
+	opt, dev, args := args[0], args[1], args[2:]
+	// Only the changes take arguments.
+	switch opt {
+	case "-s", "--change", "-G", "--set-ring", "-K", "--features", "--offload", "-p", "--identify":
+	default:
+		if len(args) != 0 {
+			return errUsage
+		}
+	}
+	switch opt {
+	case "-s", "--change":
+		return c.setSettings(dev, args)
+	case "-i", "--driver":
+		return c.driverInfo(dev)
+	case "-g", "--show-ring":
+		return c.rings(dev)
+	case "-G", "--set-ring":
+		return c.setRings(dev, args)
+	case "-k", "--show-features", "--show-offload":
+		return c.features(dev)
+	case "-K", "--features", "--offload":
+		return c.setFeatures(dev, args)
+	case "-S", "--statistics":
+		return c.stats(dev)
+	case "-p", "--identify":
+		return c.identify(dev, args)
+	}
+	return errUsage
+}
+
+func main() {
+	h, err := newHandle()
+	if err != nil {
+		log.Fatal(err)
+	}
+	c := &cmd{nic: h, out: os.Stdout}
+	if err := c.run(os.Args[1:]); err != nil {
+		log.Fatalf("ethtool: %v", err)
+	}
+}
@@ -0,0 +1,282 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/safchain/ethtool"
+)
+
+type fakeNIC struct {
+	settings ethtool.EthtoolCmd
+	rings    ringParam
+	features map[string]bool
+	identify uint32
+}
+
+func (f *fakeNIC) check(intf string) error {
+	if intf != "eth0" {
+		return os.ErrNotExist
+	}
+	return nil
+}
+
+func (f *fakeNIC) CmdGet(e *ethtool.EthtoolCmd, intf string) (uint32, error) {
+	*e = f.settings
+	return speed(e), f.check(intf)
+}
+
+func (f *fakeNIC) CmdSet(e *ethtool.EthtoolCmd, intf string) (uint32, error) {
+	f.settings = *e
+	return speed(e), f.check(intf)
+}
+
+func (f *fakeNIC) LinkState(intf string) (uint32, error) {
+	return 1, f.check(intf)
+}
+
+func (f *fakeNIC) DriverInfo(intf string) (ethtool.DrvInfo, error) {
+	return ethtool.DrvInfo{Driver: "e1000e", Version: "6.1.0", FwVersion: "0.13-4", BusInfo: "0000:00:19.0"}, f.check(intf)
+}
+
+func (f *fakeNIC) Features(intf string) (map[string]bool, error) {
+	return f.features, f.check(intf)
+}
+
+func (f *fakeNIC) Change(intf string, config map[string]bool) error {
+	for k, v := range config {
+		f.features[k] = v
+	}
+	return f.check(intf)
+}
+
+func (f *fakeNIC) Stats(intf string) (map[string]uint64, error) {
+	return map[string]uint64{"tx_packets": 2, "rx_packets": 1}, f.check(intf)
+}
+
+func (f *fakeNIC) Rings(intf string) (ringParam, error) {
+	return f.rings, f.check(intf)
+}
+
+func (f *fakeNIC) SetRings(intf string, r ringParam) error {
+	f.rings = r
+	return f.check(intf)
+}
+
+func (f *fakeNIC) Identify(intf string, secs uint32) error {
+	f.identify = secs
+	return f.check(intf)
+}
+
+func newFake() *fakeNIC {
+	return &fakeNIC{
+		settings: ethtool.EthtoolCmd{
+			Supported:   0x7f | 1<<7,
+			Advertising: 0x2f,
+			Speed:       1000,
+			Duplex:      1,
+			Autoneg:     1,
+		},
+		rings: ringParam{RxMaxPending: 4096, TxMaxPending: 4096, RxPending: 256, TxPending: 256},
+		features: map[string]bool{
+			"rx-gro":                  true,
+			"tx-checksum-ipv4":        true,
+			"tx-checksum-ipv6":        true,
+			"tx-generic-segmentation": true,
+		},
+	}
+}
+
+func TestShow(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		args []string
+		want string
+		err  error
+	}{
+		{
+			name: "settings",
+			args: []string{"eth0"},
+			want: "Settings for eth0:\n" +
+				"\tSupported link modes:   10baseT/Half 10baseT/Full 100baseT/Half 100baseT/Full 1000baseT/Half 1000baseT/Full\n" +
+				"\tSupports auto-negotiation: Yes\n" +
+				"\tAdvertised link modes:  10baseT/Half 10baseT/Full 100baseT/Half 100baseT/Full 1000baseT/Full\n" +
+				"\tSpeed: 1000Mb/s\n" +
+				"\tDuplex: Full\n" +
+				"\tPort: Twisted Pair\n" +
+				"\tAuto-negotiation: on\n" +
+				"\tLink detected: yes\n",
+		},
+		{
+			name: "driver",
+			args: []string{"-i", "eth0"},
+			want: "driver: e1000e\nversion: 6.1.0\nfirmware-version: 0.13-4\nexpansion-rom-version: \nbus-info: 0000:00:19.0\n",
+		},
+		{
+			name: "rings",
+			args: []string{"-g", "eth0"},
+			want: "Ring parameters for eth0:\n" +
+				"Pre-set maximums:\nRX:\t\t4096\nRX Mini:\t0\nRX Jumbo:\t0\nTX:\t\t4096\n" +
+				"Current hardware settings:\nRX:\t\t256\nRX Mini:\t0\nRX Jumbo:\t0\nTX:\t\t256\n",
+		},
+		{
+			name: "features",
+			args: []string{"--show-offload", "eth0"},
+			want: "Features for eth0:\nrx-gro: on\ntx-checksum-ipv4: on\ntx-checksum-ipv6: on\ntx-generic-segmentation: on\n",
+		},
+		{
+			name: "stats",
+			args: []string{"-S", "eth0"},
+			want: "NIC statistics:\n     rx_packets: 1\n     tx_packets: 2\n",
+		},
+		{name: "not found", args: []string{"eth1"}, err: os.ErrNotExist},
+		{name: "no args", err: errUsage},
+		{name: "extra", args: []string{"eth0", "eth1"}, err: errUsage},
+		{name: "no dev", args: []string{"-i"}, err: errUsage},
+		{name: "show with args", args: []string{"-g", "eth0", "rx"}, err: errUsage},
+		{name: "unknown", args: []string{"-x", "eth0"}, err: errUsage},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			c := &cmd{nic: newFake(), out: &out}
+			if err := c.run(tt.args); !errors.Is(err, tt.err) {
+				t.Fatalf("run(%q) = %v, want %v", tt.args, err, tt.err)
+			}
+			if diff := cmp.Diff(tt.want, out.String()); diff != "" {
+				t.Errorf("run(%q) (-want +got):\n%s", tt.args, diff)
+			}
+		})
+	}
+}
+
+func TestSettingsUnknown(t *testing.T) {
+	f := newFake()
+	f.settings = ethtool.EthtoolCmd{Speed: 0xffff, Duplex: 0xff, Port: 0xff}
+	var out bytes.Buffer
+	c := &cmd{nic: f, out: &out}
+	if err := c.run([]string{"eth0"}); err != nil {
+		t.Fatal(err)
+	}
+	want := "Settings for eth0:\n" +
+		"\tSupported link modes:   Not reported\n" +
+		"\tSupports auto-negotiation: No\n" +
+		"\tAdvertised link modes:  Not reported\n" +
+		"\tSpeed: Unknown!\n" +
+		"\tDuplex: Unknown! (255)\n" +
+		"\tPort: Other\n" +
+		"\tAuto-negotiation: off\n" +
+		"\tLink detected: yes\n"
+	if diff := cmp.Diff(want, out.String()); diff != "" {
+		t.Errorf("run(eth0) (-want +got):\n%s", diff)
+	}
+}
+
+func TestSet(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		args     []string
+		settings func(*ethtool.EthtoolCmd)
+		rings    *ringParam
+		features map[string]bool
+		identify uint32
+		err      error
+	}{
+		{
+			name: "speed duplex",
+			args: []string{"-s", "eth0", "speed", "100", "duplex", "half", "autoneg", "off"},
+			settings: func(e *ethtool.EthtoolCmd) {
+				e.Speed, e.Duplex, e.Autoneg = 100, 0, 0
+			},
+		},
+		{
+			name: "speed hi",
+			args: []string{"-s", "eth0", "speed", "100000"},
+			settings: func(e *ethtool.EthtoolCmd) {
+				e.Speed, e.Speed_hi = 100000&0xffff, 100000>>16
+			},
+		},
+		{
+			name: "autoneg",
+			args: []string{"--change", "eth0", "autoneg", "on"},
+			settings: func(e *ethtool.EthtoolCmd) {
+				e.Advertising = e.Supported
+			},
+		},
+		{
+			name:  "rings",
+			args:  []string{"-G", "eth0", "rx", "4096", "tx", "1024"},
+			rings: &ringParam{RxMaxPending: 4096, TxMaxPending: 4096, RxPending: 4096, TxPending: 1024},
+		},
+		{
+			name: "features",
+			args: []string{"-K", "eth0", "rx-gro", "off", "tx", "off"},
+			features: map[string]bool{
+				"rx-gro":                  false,
+				"tx-checksum-ipv4":        false,
+				"tx-checksum-ipv6":        false,
+				"tx-generic-segmentation": true,
+			},
+		},
+		{
+			name: "features alias",
+			args: []string{"--offload", "eth0", "gso", "off"},
+			features: map[string]bool{
+				"rx-gro":                  true,
+				"tx-checksum-ipv4":        true,
+				"tx-checksum-ipv6":        true,
+				"tx-generic-segmentation": false,
+			},
+		},
+		{name: "identify", args: []string{"-p", "eth0", "5"}, identify: 5},
+		{name: "identify forever", args: []string{"--identify", "eth0"}},
+		{name: "set no args", args: []string{"-s", "eth0"}, err: errUsage},
+		{name: "set odd", args: []string{"-s", "eth0", "speed"}, err: errUsage},
+		{name: "bad speed", args: []string{"-s", "eth0", "speed", "fast"}, err: errArg},
+		{name: "bad duplex", args: []string{"-s", "eth0", "duplex", "both"}, err: errArg},
+		{name: "bad autoneg", args: []string{"-s", "eth0", "autoneg", "yes"}, err: errArg},
+		{name: "unknown setting", args: []string{"-s", "eth0", "wol", "g"}, err: errArg},
+		{name: "ring too big", args: []string{"-G", "eth0", "rx", "8192"}, err: errArg},
+		{name: "bad ring", args: []string{"-G", "eth0", "rx", "many"}, err: errArg},
+		{name: "unknown ring", args: []string{"-G", "eth0", "big", "1"}, err: errArg},
+		{name: "unknown feature", args: []string{"-K", "eth0", "warp", "on"}, err: errArg},
+		{name: "bad feature value", args: []string{"-K", "eth0", "gro", "1"}, err: errArg},
+		{name: "bad seconds", args: []string{"-p", "eth0", "soon"}, err: errArg},
+		{name: "identify extra", args: []string{"-p", "eth0", "1", "2"}, err: errUsage},
+		{name: "not found", args: []string{"-s", "eth1", "speed", "10"}, err: os.ErrNotExist},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFake()
+			want := newFake()
+			if tt.settings != nil {
+				tt.settings(&want.settings)
+			}
+			if tt.rings != nil {
+				want.rings = *tt.rings
+			}
+			if tt.features != nil {
+				want.features = tt.features
+			}
+			want.identify = tt.identify
+
+			c := &cmd{nic: f, out: &bytes.Buffer{}}
+			if err := c.run(tt.args); !errors.Is(err, tt.err) {
+				t.Fatalf("run(%q) = %v, want %v", tt.args, err, tt.err)
+			}
+			if tt.err != nil {
+				return
+			}
+			if diff := cmp.Diff(want, f, cmp.AllowUnexported(fakeNIC{}, ringParam{})); diff != "" {
+				t.Errorf("run(%q) (-want +got):\n%s", tt.args, diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package main
+
+import (
+	"runtime"
+	"unsafe"
+
+	"github.com/safchain/ethtool"
+	"golang.org/x/sys/unix"
+)
+
+// ringParam is struct ethtool_ringparam.
+type ringParam struct {
+	cmd               uint32
+	RxMaxPending      uint32
+	RxMiniMaxPending  uint32
+	RxJumboMaxPending uint32
+	TxMaxPending      uint32
+	RxPending         uint32
+	RxMiniPending     uint32
+	RxJumboPending    uint32
+	TxPending         uint32
+}
+
+// value is struct ethtool_value.
+type value struct {
+	cmd  uint32
+	data uint32
+}
+
+// ifreq is struct ifreq with ifr_data, padded to the size of the union.
+type ifreq struct {
+	name [unix.IFNAMSIZ]byte
+	data uintptr
+	_    [16]byte
+}
+
+// handle adds the ioctls the ethtool package lacks.
+type handle struct {
+	*ethtool.Ethtool
+	fd int
+}
+
+func newHandle() (*handle, error) {
+	e, err := ethtool.NewEthtool()
+	if err != nil {
+		return nil, err
+	}
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		e.Close()
+		return nil, err
+	}
+	return &handle{Ethtool: e, fd: fd}, nil
+}
+
+func (h *handle) ioctl(intf string, data unsafe.Pointer) error {
+	ifr := ifreq{data: uintptr(data)}
+	copy(ifr.name[:unix.IFNAMSIZ-1], intf)
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(h.fd), unix.SIOCETHTOOL, uintptr(unsafe.Pointer(&ifr)))
+	runtime.KeepAlive(data)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func (h *handle) Rings(intf string) (ringParam, error) {
+	r := ringParam{cmd: unix.ETHTOOL_GRINGPARAM}
+	err := h.ioctl(intf, unsafe.Pointer(&r))
+	return r, err
+}
+
+func (h *handle) SetRings(intf string, r ringParam) error {
+	r.cmd = unix.ETHTOOL_SRINGPARAM
+	return h.ioctl(intf, unsafe.Pointer(&r))
+}
+
+// Identify blinks the LEDs of intf for secs seconds, or until interrupted
+// if secs is 0.
+func (h *handle) Identify(intf string, secs uint32) error {
+	v := value{cmd: unix.ETHTOOL_PHYS_ID, data: secs}
+	return h.ioctl(intf, unsafe.Pointer(&v))
+}
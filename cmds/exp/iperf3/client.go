@@ -0,0 +1,124 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+var (
+	errBusy       = errors.New("the server is busy running a test")
+	errServer     = errors.New("server error")
+	errTerminated = errors.New("the server has terminated")
+)
+
+type client struct {
+	addr     string
+	params   params
+	duration time.Duration
+	interval time.Duration
+	out      io.Writer
+}
+
+func connected(out io.Writer, id int, c net.Conn) {
+	local, remote := c.LocalAddr().(*net.TCPAddr), c.RemoteAddr().(*net.TCPAddr)
+	fmt.Fprintf(out, "[%3d] local %s port %d connected to %s port %d\n", id, local.IP, local.Port, remote.IP, remote.Port)
+}
+
+func (c *client) run() error {
+	host, port, err := net.SplitHostPort(c.addr)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "Connecting to host %s, port %s\n", host, port)
+	ctrl, err := net.Dial("tcp", c.addr)
+	if err != nil {
+		return err
+	}
+	defer ctrl.Close()
+	cookie, err := newCookie()
+	if err != nil {
+		return err
+	}
+	if _, err := ctrl.Write(cookie); err != nil {
+		return err
+	}
+
+	t := &test{out: c.out, sender: !c.params.Reverse, len: c.params.Len, interval: c.interval}
+	defer t.close()
+	var peer results
+	for {
+		state, err := readState(ctrl)
+		if err != nil {
+			return err
+		}
+		switch state {
+		case paramExchange:
+			err = writeJSON(ctrl, c.params)
+		case createStreams:
+			for i := 0; i < c.params.Parallel; i++ {
+				conn, err := net.Dial("tcp", c.addr)
+				if err != nil {
+					return err
+				}
+				t.streams = append(t.streams, &stream{conn: conn})
+				if _, err := conn.Write(cookie); err != nil {
+					return err
+				}
+				connected(c.out, i+1, conn)
+			}
+		case testStart:
+			fmt.Fprintln(c.out, header)
+		case testRunning:
+			if len(t.streams) == 0 {
+				return fmt.Errorf("%w: test running without streams", errProtocol)
+			}
+			t.run()
+			timer := time.NewTimer(c.duration)
+			select {
+			case <-timer.C:
+			case err = <-t.ended:
+				timer.Stop()
+			}
+			t.stop()
+			if err != nil {
+				writeState(ctrl, clientTerminate)
+				return err
+			}
+			err = writeState(ctrl, testEnd)
+		case exchangeResults:
+			if err = writeJSON(ctrl, t.results()); err == nil {
+				err = readJSON(ctrl, &peer)
+			}
+		case displayResults:
+			t.summary(&peer)
+			if err := writeState(ctrl, iperfDone); err != nil {
+				return err
+			}
+			fmt.Fprintln(c.out, "\niperf Done.")
+			return nil
+		case accessDenied:
+			return errBusy
+		case serverError:
+			var codes [2]int32
+			if err := binary.Read(ctrl, binary.BigEndian, &codes); err != nil {
+				return err
+			}
+			return fmt.Errorf("%w %d, errno %d", errServer, codes[0], codes[1])
+		case serverTerminate:
+			return errTerminated
+		default:
+			return fmt.Errorf("%w: unexpected state %d", errProtocol, state)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
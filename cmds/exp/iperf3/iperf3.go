@@ -0,0 +1,136 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// iperf3 measures the TCP throughput between two hosts, speaking the
+// protocol of iperf3.
+//
+// Synopsis:
+//
+//	iperf3 -s [-p PORT] [-i INTERVAL] [-1]
+//	iperf3 -c HOST [-p PORT] [-t TIME] [-P STREAMS] [-R] [-l LENGTH] [-i INTERVAL]
+//
+// Description:
+//
+//	The server waits for clients, running their tests one at a time. The
+//	client connects to the server and sends data to it, or receives data
+//	from it with -R, for TIME seconds, then both show the throughput of the
+//	sender and the receiver. The client and the server work with those of
+//	iperf3, for TCP tests in one direction.
+//
+// Options:
+//
+//	-s: run a server
+//	-c: run a client, testing against HOST
+//	-p: port (default 5201)
+//	-i: seconds between reports, 0 for none (default 1)
+//	-1: serve one test and exit
+//	-t: seconds to transmit for (default 10)
+//	-P: number of parallel streams (default 1)
+//	-R: reverse, the server sends and the client receives
+//	-l: length of the reads and writes, with an optional K, M or G (default 128K)
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPort = 5201
+	defaultLen  = 128 << 10
+)
+
+var (
+	errUsage = errors.New("usage: iperf3 -s [-p PORT] [-i INTERVAL] [-1] | -c HOST [-p PORT] [-t TIME] [-P STREAMS] [-R] [-l LENGTH] [-i INTERVAL]")
+	errArg   = errors.New("invalid argument")
+)
+
+// parseLen parses a LENGTH, in bytes unless followed by K, M or G.
+func parseLen(s string) (int, error) {
+	scale := 1
+	if i := strings.IndexAny(s, "KMGkmg"); i >= 0 && i == len(s)-1 {
+		scale = map[byte]int{'k': 1 << 10, 'm': 1 << 20, 'g': 1 << 30}[s[i]|0x20]
+		s = s[:i]
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 || n > (1<<30)/scale {
+		return 0, fmt.Errorf("%w: length %q", errArg, s)
+	}
+	return n * scale, nil
+}
+
+func run(args []string, out io.Writer) error {
+	f := flag.NewFlagSet("iperf3", flag.ContinueOnError)
+	f.SetOutput(io.Discard)
+	serve := f.Bool("s", false, "Run a server")
+	host := f.String("c", "", "Run a client, testing against HOST")
+	port := f.Int("p", defaultPort, "Port")
+	interval := f.Float64("i", 1, "Seconds between reports, 0 for none")
+	once := f.Bool("1", false, "Serve one test and exit")
+	secs := f.Int("t", 10, "Seconds to transmit for")
+	parallel := f.Int("P", 1, "Number of parallel streams")
+	reverse := f.Bool("R", false, "Reverse, the server sends")
+	length := f.String("l", "128K", "Length of the reads and writes")
+	if err := f.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	if f.NArg() != 0 || *serve == (*host != "") {
+		return errUsage
+	}
+	if *interval < 0 {
+		return fmt.Errorf("%w: interval %v", errArg, *interval)
+	}
+	every := time.Duration(*interval * float64(time.Second))
+	addr := net.JoinHostPort(*host, strconv.Itoa(*port))
+
+	if *serve {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		defer ln.Close()
+		s := &server{ln: ln, interval: every, out: out}
+		return s.serve(*once)
+	}
+
+	if *secs <= 0 {
+		return fmt.Errorf("%w: time %d", errArg, *secs)
+	}
+	if *parallel < 1 || *parallel > 128 {
+		return fmt.Errorf("%w: %d streams", errArg, *parallel)
+	}
+	l, err := parseLen(*length)
+	if err != nil {
+		return err
+	}
+	c := &client{
+		addr: addr,
+		params: params{
+			TCP:         true,
+			Time:        *secs,
+			Parallel:    *parallel,
+			Reverse:     *reverse,
+			Len:         l,
+			PacingTimer: 1000,
+		},
+		duration: time.Duration(*secs) * time.Second,
+		interval: every,
+		out:      out,
+	}
+	return c.run()
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		log.Fatalf("iperf3: %v", err)
+	}
+}
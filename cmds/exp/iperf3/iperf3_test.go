@@ -0,0 +1,332 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseLen(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want int
+		err  error
+	}{
+		{in: "1000", want: 1000},
+		{in: "128K", want: 128 << 10},
+		{in: "1m", want: 1 << 20},
+		{in: "1G", want: 1 << 30},
+		{in: "2G", err: errArg},
+		{in: "0", err: errArg},
+		{in: "K", err: errArg},
+		{in: "1KB", err: errArg},
+	} {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseLen(tt.in)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("parseLen(%q) = %v, want %v", tt.in, err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("parseLen(%q) = %d, want %d", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnits(t *testing.T) {
+	for _, tt := range []struct {
+		got, want string
+	}{
+		{transfer(0), "0.00 Bytes"},
+		{transfer(1023), "1023 Bytes"},
+		{transfer(1536), "1.50 KBytes"},
+		{transfer(112 << 20), " 112 MBytes"},
+		{transfer(35 << 29), "17.5 GBytes"},
+		{bitrate(125000, 1), "1.00 Mbits"},
+		{bitrate(117625000, 1), " 941 Mbits"},
+		{bitrate(1, 0), "0.00 bits"},
+		{bitrate(5e9, 2), "20.0 Gbits"},
+	} {
+		if tt.got != tt.want {
+			t.Errorf("got %q, want %q", tt.got, tt.want)
+		}
+	}
+}
+
+func TestStreamID(t *testing.T) {
+	var got []int
+	for i := 0; i < 4; i++ {
+		got = append(got, streamID(i))
+	}
+	if diff := cmp.Diff([]int{1, 3, 4, 5}, got); diff != "" {
+		t.Errorf("streamID (-want +got):\n%s", diff)
+	}
+}
+
+func TestProto(t *testing.T) {
+	c, err := newCookie()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c) != cookieSize || c[cookieSize-1] != 0 || !regexp.MustCompile(`^[a-z2-7]{36}$`).Match(c[:cookieSize-1]) {
+		t.Errorf("newCookie() = %q, want 36 of [a-z2-7] and a NUL", c)
+	}
+
+	var b bytes.Buffer
+	p := params{TCP: true, Time: 10, Parallel: 2, Len: defaultLen}
+	if err := writeJSON(&b, p); err != nil {
+		t.Fatal(err)
+	}
+	if n := binary.BigEndian.Uint32(b.Bytes()); int(n) != b.Len()-4 {
+		t.Errorf("JSON length %d, want %d", n, b.Len()-4)
+	}
+	if s := b.String()[4:]; s != `{"tcp":true,"omit":0,"time":10,"parallel":2,"len":131072}` {
+		t.Errorf("JSON = %s", s)
+	}
+	var got params
+	if err := readJSON(&b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != p {
+		t.Errorf("readJSON = %+v, want %+v", got, p)
+	}
+
+	b.Reset()
+	binary.Write(&b, binary.BigEndian, uint32(maxJSON+1))
+	if err := readJSON(&b, &got); !errors.Is(err, errProtocol) {
+		t.Errorf("readJSON(too long) = %v, want %v", err, errProtocol)
+	}
+	b.Reset()
+	writeJSON(&b, "x")
+	if err := readJSON(&b, &got); !errors.Is(err, errProtocol) {
+		t.Errorf("readJSON(string) = %v, want %v", err, errProtocol)
+	}
+
+	b.Reset()
+	writeState(&b, accessDenied)
+	if s, err := readState(&b); err != nil || s != accessDenied {
+		t.Errorf("readState = %d, %v, want %d", s, err, accessDenied)
+	}
+}
+
+func serve(t *testing.T) (string, <-chan error, *bytes.Buffer) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	var out bytes.Buffer
+	s := &server{ln: ln, out: &out}
+	errc := make(chan error, 1)
+	go func() { errc <- s.serve(true) }()
+	return ln.Addr().String(), errc, &out
+}
+
+// totals returns the sender and receiver lines of the summary.
+func totals(t *testing.T, out string) []string {
+	t.Helper()
+	return regexp.MustCompile(`(?m)^\[SUM\].*(sender|receiver)$|^\[  \d\].*(sender|receiver)$`).FindAllString(out, -1)
+}
+
+func TestClientServer(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		parallel int
+		reverse  bool
+		lines    int
+	}{
+		{name: "send", parallel: 1, lines: 2},
+		{name: "reverse", parallel: 1, reverse: true, lines: 2},
+		{name: "parallel", parallel: 3, lines: 8},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, errc, sout := serve(t)
+			var out bytes.Buffer
+			c := &client{
+				addr:     addr,
+				params:   params{TCP: true, Time: 1, Parallel: tt.parallel, Reverse: tt.reverse, Len: 16 << 10},
+				duration: 200 * time.Millisecond,
+				out:      &out,
+			}
+			if err := c.run(); err != nil {
+				t.Fatalf("client: %v", err)
+			}
+			if err := <-errc; err != nil {
+				t.Fatalf("server: %v", err)
+			}
+			if !strings.HasSuffix(out.String(), "\niperf Done.\n") {
+				t.Errorf("client output does not end with iperf Done.:\n%s", &out)
+			}
+			for name, o := range map[string]string{"client": out.String(), "server": sout.String()} {
+				if n := len(totals(t, o)); n != tt.lines {
+					t.Errorf("%s has %d summary lines, want %d:\n%s", name, n, tt.lines, o)
+				}
+				if strings.Contains(o, " 0.00 Bytes") {
+					t.Errorf("%s transferred no bytes:\n%s", name, o)
+				}
+			}
+		})
+	}
+}
+
+// fakeServer runs a server that sends states after reading the cookie
+// of the client.
+func fakeServer(t *testing.T, states ...[]byte) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		if _, err := readCookie(c); err != nil {
+			return
+		}
+		for _, s := range states {
+			c.Write(s)
+		}
+		// Wait for the client to close.
+		c.Read(make([]byte, 1))
+	}()
+	return ln.Addr().String()
+}
+
+func TestClientErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		states [][]byte
+		err    error
+	}{
+		{name: "busy", states: [][]byte{{0xff}}, err: errBusy},
+		{name: "server error", states: [][]byte{{0xfe}, {0, 0, 0, ieUnimp, 0, 0, 0, 0}}, err: errServer},
+		{name: "terminate", states: [][]byte{{serverTerminate}}, err: errTerminated},
+		{name: "unexpected", states: [][]byte{{iperfDone}}, err: errProtocol},
+		{name: "running without streams", states: [][]byte{{testRunning}}, err: errProtocol},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &client{
+				addr:     fakeServer(t, tt.states...),
+				params:   params{TCP: true, Time: 1, Parallel: 1, Len: 1024},
+				duration: time.Second,
+				out:      &bytes.Buffer{},
+			}
+			if err := c.run(); !errors.Is(err, tt.err) {
+				t.Errorf("run() = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestServerUnsupported(t *testing.T) {
+	addr, errc, _ := serve(t)
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	cookie, err := newCookie()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Write(cookie)
+	if s, err := readState(c); err != nil || s != paramExchange {
+		t.Fatalf("state = %d, %v, want %d", s, err, paramExchange)
+	}
+	if err := writeJSON(c, params{UDP: true, Time: 1, Parallel: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := readState(c); err != nil || s != serverError {
+		t.Fatalf("state = %d, %v, want %d", s, err, serverError)
+	}
+	var codes [2]int32
+	if err := binary.Read(c, binary.BigEndian, &codes); err != nil || codes[0] != ieUnimp {
+		t.Errorf("error codes = %v, %v, want %d", codes, err, ieUnimp)
+	}
+	if err := <-errc; !errors.Is(err, errUnsupported) {
+		t.Errorf("serve() = %v, want %v", err, errUnsupported)
+	}
+}
+
+// syncBuffer is a bytes.Buffer to read while a client writes to it.
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (s *syncBuffer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.Write(p)
+}
+
+func (s *syncBuffer) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.String()
+}
+
+func TestServerBusy(t *testing.T) {
+	addr, errc, _ := serve(t)
+	var out syncBuffer
+	c := &client{
+		addr:     addr,
+		params:   params{TCP: true, Time: 1, Parallel: 1, Len: 1024},
+		duration: 500 * time.Millisecond,
+		out:      &out,
+	}
+	done := make(chan error, 1)
+	go func() { done <- c.run() }()
+
+	// Wait for the test to run.
+	for !strings.Contains(out.String(), "connected to") {
+		time.Sleep(10 * time.Millisecond)
+	}
+	other := &client{addr: addr, params: c.params, duration: time.Second, out: &bytes.Buffer{}}
+	if err := other.run(); !errors.Is(err, errBusy) {
+		t.Errorf("second client: %v, want %v", err, errBusy)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("client: %v", err)
+	}
+	if err := <-errc; err != nil {
+		t.Errorf("server: %v", err)
+	}
+}
+
+func TestRunUsage(t *testing.T) {
+	for _, tt := range []struct {
+		args []string
+		err  error
+	}{
+		{args: nil, err: errUsage},
+		{args: []string{"-s", "-c", "localhost"}, err: errUsage},
+		{args: []string{"-c", "localhost", "extra"}, err: errUsage},
+		{args: []string{"-x"}, err: errUsage},
+		{args: []string{"-c", "localhost", "-t", "0"}, err: errArg},
+		{args: []string{"-c", "localhost", "-P", "0"}, err: errArg},
+		{args: []string{"-c", "localhost", "-l", "big"}, err: errArg},
+		{args: []string{"-c", "localhost", "-i", "-1"}, err: errArg},
+	} {
+		if err := run(tt.args, &bytes.Buffer{}); !errors.Is(err, tt.err) {
+			t.Errorf("run(%q) = %v, want %v", tt.args, err, tt.err)
+		}
+	}
+}
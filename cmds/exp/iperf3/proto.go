@@ -0,0 +1,154 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// States of a test, which the server and the client send each other over
+// the control connection, see iperf_api.h of iperf3.
+const (
+	testStart       = 1
+	testRunning     = 2
+	testEnd         = 4
+	paramExchange   = 9
+	createStreams   = 10
+	serverTerminate = 11
+	clientTerminate = 12
+	exchangeResults = 13
+	displayResults  = 14
+	iperfDone       = 16
+	accessDenied    = -1
+	serverError     = -2
+)
+
+// ieUnimp is the iperf3 error the server reports for tests it does not
+// implement.
+const ieUnimp = 13
+
+const (
+	// cookieSize is the size of the cookie identifying a test, NUL
+	// included, which the client sends first on every connection.
+	cookieSize = 37
+	// maxJSON bounds the parameters and results read.
+	maxJSON = 1 << 20
+)
+
+var errProtocol = errors.New("protocol error")
+
+// params are the parameters of a test, which the client sends.
+type params struct {
+	TCP           bool   `json:"tcp,omitempty"`
+	UDP           bool   `json:"udp,omitempty"`
+	Omit          int    `json:"omit"`
+	Time          int    `json:"time"`
+	Parallel      int    `json:"parallel"`
+	Reverse       bool   `json:"reverse,omitempty"`
+	Bidirectional bool   `json:"bidirectional,omitempty"`
+	Len           int    `json:"len"`
+	Bandwidth     uint64 `json:"bandwidth,omitempty"`
+	PacingTimer   int    `json:"pacing_timer,omitempty"`
+}
+
+// results are the results of a test on one side, which the client and the
+// server exchange.
+type results struct {
+	CPUUtilTotal         float64        `json:"cpu_util_total"`
+	CPUUtilUser          float64        `json:"cpu_util_user"`
+	CPUUtilSystem        float64        `json:"cpu_util_system"`
+	SenderHasRetransmits int            `json:"sender_has_retransmits"`
+	Streams              []streamResult `json:"streams"`
+}
+
+type streamResult struct {
+	ID          int     `json:"id"`
+	Bytes       uint64  `json:"bytes"`
+	Retransmits int     `json:"retransmits"`
+	Jitter      float64 `json:"jitter"`
+	Errors      int     `json:"errors"`
+	Packets     int     `json:"packets"`
+	StartTime   float64 `json:"start_time"`
+	EndTime     float64 `json:"end_time"`
+}
+
+// streamID returns the iperf3 ID of the i-th stream: iperf3 numbers them
+// 1, 3, 4 and so on, and matches the results by them.
+func streamID(i int) int {
+	if i == 0 {
+		return 1
+	}
+	return i + 2
+}
+
+// newCookie returns a random cookie, of the characters of iperf3 ones.
+func newCookie() ([]byte, error) {
+	const chars = "abcdefghijklmnopqrstuvwxyz234567"
+	b := make([]byte, cookieSize)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	for i := range b[:cookieSize-1] {
+		b[i] = chars[int(b[i])%len(chars)]
+	}
+	b[cookieSize-1] = 0
+	return b, nil
+}
+
+func readCookie(r io.Reader) ([]byte, error) {
+	b := make([]byte, cookieSize)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func writeState(w io.Writer, state int8) error {
+	_, err := w.Write([]byte{byte(state)})
+	return err
+}
+
+func readState(r io.Reader) (int8, error) {
+	var b [1]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, err
+	}
+	return int8(b[0]), nil
+}
+
+// writeJSON writes v as JSON, after its length as a 32 bit big endian
+// number.
+func writeJSON(w io.Writer, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	msg := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(b)), uint32(len(b)))
+	_, err = w.Write(append(msg, b...))
+	return err
+}
+
+func readJSON(r io.Reader, v any) error {
+	var n uint32
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return err
+	}
+	if n > maxJSON {
+		return fmt.Errorf("%w: %d bytes of JSON", errProtocol, n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%w: %v", errProtocol, err)
+	}
+	return nil
+}
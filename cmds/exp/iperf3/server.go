@@ -0,0 +1,197 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+var (
+	errUnsupported = errors.New("unsupported test")
+	errTimeout     = errors.New("timed out waiting for the streams")
+	errClient      = errors.New("the client has terminated")
+)
+
+// handshakeTimeout bounds the reads of cookies and the wait for the
+// streams of a test.
+const handshakeTimeout = 10 * time.Second
+
+type server struct {
+	ln       net.Listener
+	interval time.Duration
+	out      io.Writer
+}
+
+const banner = "-----------------------------------------------------------"
+
+// serve runs the tests of clients one after the other, those of once only.
+func (s *server) serve(once bool) error {
+	// Control connections and streams come in on the same port, told apart
+	// by the cookies.
+	conns := make(chan net.Conn)
+	go func() {
+		defer close(conns)
+		for {
+			c, err := s.ln.Accept()
+			if err != nil {
+				return
+			}
+			conns <- c
+		}
+	}()
+
+	for {
+		fmt.Fprintf(s.out, "%s\nServer listening on %d\n%s\n", banner, s.ln.Addr().(*net.TCPAddr).Port, banner)
+		ctrl, ok := <-conns
+		if !ok {
+			return nil
+		}
+		err := s.test(ctrl, conns)
+		if err != nil {
+			fmt.Fprintf(s.out, "iperf3: error - %v\n", err)
+		}
+		if once {
+			return err
+		}
+	}
+}
+
+// deny tells the client of c the server is busy.
+func deny(c net.Conn) {
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(handshakeTimeout))
+	if _, err := readCookie(c); err == nil {
+		writeState(c, accessDenied)
+	}
+}
+
+func (s *server) test(ctrl net.Conn, conns <-chan net.Conn) error {
+	defer ctrl.Close()
+	ctrl.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	cookie, err := readCookie(ctrl)
+	if err != nil {
+		return err
+	}
+	remote := ctrl.RemoteAddr().(*net.TCPAddr)
+	fmt.Fprintf(s.out, "Accepted connection from %s, port %d\n", remote.IP, remote.Port)
+
+	var p params
+	if err := writeState(ctrl, paramExchange); err != nil {
+		return err
+	}
+	if err := readJSON(ctrl, &p); err != nil {
+		return err
+	}
+	if p.UDP || p.Bidirectional || p.Parallel < 1 {
+		writeState(ctrl, serverError)
+		binary.Write(ctrl, binary.BigEndian, [2]int32{ieUnimp, 0})
+		return fmt.Errorf("%w: only TCP tests in one direction are", errUnsupported)
+	}
+	if p.Len <= 0 {
+		p.Len = defaultLen
+	}
+
+	t := &test{out: s.out, sender: p.Reverse, len: p.Len, interval: s.interval}
+	defer t.close()
+	if err := writeState(ctrl, createStreams); err != nil {
+		return err
+	}
+	timeout := time.NewTimer(handshakeTimeout)
+	defer timeout.Stop()
+	for len(t.streams) < p.Parallel {
+		select {
+		case c, ok := <-conns:
+			if !ok {
+				return net.ErrClosed
+			}
+			c.SetReadDeadline(time.Now().Add(handshakeTimeout))
+			ck, err := readCookie(c)
+			if err != nil || !bytes.Equal(ck, cookie) {
+				// Another client.
+				if err == nil {
+					writeState(c, accessDenied)
+				}
+				c.Close()
+				continue
+			}
+			c.SetReadDeadline(time.Time{})
+			t.streams = append(t.streams, &stream{conn: c})
+			connected(s.out, len(t.streams), c)
+		case <-timeout.C:
+			return errTimeout
+		}
+	}
+
+	// Turn away other clients until the test is over.
+	over := make(chan struct{})
+	defer close(over)
+	go func() {
+		for {
+			select {
+			case c, ok := <-conns:
+				if !ok {
+					return
+				}
+				go deny(c)
+			case <-over:
+				return
+			}
+		}
+	}()
+
+	if err := writeState(ctrl, testStart); err != nil {
+		return err
+	}
+	if err := writeState(ctrl, testRunning); err != nil {
+		return err
+	}
+	fmt.Fprintln(s.out, header)
+	t.run()
+	// The client ends the test, in time unless it is gone.
+	if p.Time > 0 {
+		ctrl.SetReadDeadline(time.Now().Add(time.Duration(p.Time)*time.Second + handshakeTimeout))
+	} else {
+		ctrl.SetReadDeadline(time.Time{})
+	}
+	state, err := readState(ctrl)
+	t.stop()
+	switch {
+	case err != nil:
+		return err
+	case state == clientTerminate:
+		return errClient
+	case state != testEnd:
+		return fmt.Errorf("%w: unexpected state %d", errProtocol, state)
+	}
+
+	ctrl.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	var peer results
+	if err := writeState(ctrl, exchangeResults); err != nil {
+		return err
+	}
+	if err := readJSON(ctrl, &peer); err != nil {
+		return err
+	}
+	if err := writeJSON(ctrl, t.results()); err != nil {
+		return err
+	}
+	if err := writeState(ctrl, displayResults); err != nil {
+		return err
+	}
+	t.summary(&peer)
+	if state, err = readState(ctrl); err != nil {
+		return err
+	}
+	if state != iperfDone {
+		return fmt.Errorf("%w: unexpected state %d", errProtocol, state)
+	}
+	return nil
+}
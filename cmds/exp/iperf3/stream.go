@@ -0,0 +1,214 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const header = "[ ID] Interval           Transfer     Bitrate"
+
+// stream is a data connection of a test.
+type stream struct {
+	conn  net.Conn
+	bytes atomic.Uint64
+}
+
+// copy sends or receives on s until done is closed or the peer closes it.
+func (s *stream) copy(send bool, buf []byte, done <-chan struct{}) error {
+	for {
+		var n int
+		var err error
+		if send {
+			n, err = s.conn.Write(buf)
+		} else {
+			n, err = s.conn.Read(buf)
+		}
+		s.bytes.Add(uint64(n))
+		select {
+		case <-done:
+			return nil
+		default:
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// test runs the streams of a test on one side, reporting the bytes
+// transferred every interval.
+type test struct {
+	out      io.Writer
+	streams  []*stream
+	sender   bool
+	len      int
+	interval time.Duration
+
+	start, end time.Time
+	wg         sync.WaitGroup
+	done       chan struct{}
+	// ended is sent to when a stream ends before done is closed.
+	ended    chan error
+	reported chan struct{}
+}
+
+func (t *test) run() {
+	t.start = time.Now()
+	t.done = make(chan struct{})
+	t.ended = make(chan error, len(t.streams))
+	t.reported = make(chan struct{})
+	for _, s := range t.streams {
+		t.wg.Add(1)
+		go func(s *stream) {
+			defer t.wg.Done()
+			err := s.copy(t.sender, make([]byte, t.len), t.done)
+			select {
+			case <-t.done:
+			default:
+				t.ended <- err
+			}
+		}(s)
+	}
+	go t.report()
+}
+
+// stop stops the streams, interrupting those blocked in a read or a write.
+func (t *test) stop() {
+	close(t.done)
+	for _, s := range t.streams {
+		s.conn.SetDeadline(time.Now())
+	}
+	t.wg.Wait()
+	t.end = time.Now()
+	<-t.reported
+}
+
+func (t *test) close() {
+	for _, s := range t.streams {
+		s.conn.Close()
+	}
+}
+
+func (t *test) line(id string, from, to float64, bytes uint64, role string) {
+	fmt.Fprintf(t.out, "[%3s] %6.2f-%-6.2f sec  %s  %s/sec%s\n", id, from, to, transfer(bytes), bitrate(bytes, to-from), role)
+}
+
+func (t *test) report() {
+	defer close(t.reported)
+	if t.interval <= 0 {
+		<-t.done
+		return
+	}
+	tick := time.NewTicker(t.interval)
+	defer tick.Stop()
+	last := make([]uint64, len(t.streams))
+	from := 0.0
+	for {
+		select {
+		case <-t.done:
+			return
+		case now := <-tick.C:
+			to := now.Sub(t.start).Seconds()
+			var sum uint64
+			for i, s := range t.streams {
+				b := s.bytes.Load()
+				t.line(fmt.Sprint(i+1), from, to, b-last[i], "")
+				sum += b - last[i]
+				last[i] = b
+			}
+			if len(t.streams) > 1 {
+				t.line("SUM", from, to, sum, "")
+			}
+			from = to
+		}
+	}
+}
+
+// results returns the results of the side of t, once stopped.
+func (t *test) results() *results {
+	r := &results{}
+	for i, s := range t.streams {
+		r.Streams = append(r.Streams, streamResult{
+			ID:          streamID(i),
+			Bytes:       s.bytes.Load(),
+			Retransmits: -1,
+			EndTime:     t.end.Sub(t.start).Seconds(),
+		})
+	}
+	return r
+}
+
+// summary prints the totals of the sender and the receiver, peer being the
+// results of the other side.
+func (t *test) summary(peer *results) {
+	sender, receiver := t.results(), peer
+	if !t.sender {
+		sender, receiver = receiver, sender
+	}
+	fmt.Fprintln(t.out, "- - - - - - - - - - - - - - - - - - - - - - - - -")
+	fmt.Fprintln(t.out, header)
+
+	type sum struct {
+		bytes uint64
+		secs  float64
+	}
+	var sums [2]sum
+	for i := range t.streams {
+		for j, r := range []*results{sender, receiver} {
+			if r == nil || i >= len(r.Streams) {
+				continue
+			}
+			s := r.Streams[i]
+			t.line(fmt.Sprint(i+1), s.StartTime, s.EndTime, s.Bytes, roles[j])
+			sums[j].bytes += s.Bytes
+			sums[j].secs = max(sums[j].secs, s.EndTime)
+		}
+	}
+	if len(t.streams) > 1 {
+		for j, s := range sums {
+			t.line("SUM", 0, s.secs, s.bytes, roles[j])
+		}
+	}
+}
+
+var roles = []string{"                  sender", "                  receiver"}
+
+// unit formats v in the largest of units it is at least one of, like iperf3
+// does.
+func unit(v, scale float64, units []string) string {
+	i := 0
+	for ; v >= scale && i < len(units)-1; i++ {
+		v /= scale
+	}
+	f := "%4.0f %s"
+	switch {
+	case v < 9.995:
+		f = "%4.2f %s"
+	case v < 99.95:
+		f = "%4.1f %s"
+	}
+	return fmt.Sprintf(f, v, units[i])
+}
+
+func transfer(b uint64) string {
+	return unit(float64(b), 1024, []string{"Bytes", "KBytes", "MBytes", "GBytes", "TBytes"})
+}
+
+func bitrate(b uint64, secs float64) string {
+	if secs <= 0 {
+		return unit(0, 1000, []string{"bits"})
+	}
+	return unit(float64(b)*8/secs, 1000, []string{"bits", "Kbits", "Mbits", "Gbits", "Tbits"})
+}
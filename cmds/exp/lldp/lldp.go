@@ -0,0 +1,154 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+// lldp listens for LLDP frames and prints the switch, port and VLANs each
+// interface is plugged into.
+//
+// Synopsis:
+//
+//	lldp [OPTIONS...] [IFACE...]
+//
+// Without interfaces, lldp listens on all Ethernet interfaces that are up.
+//
+// Options:
+//
+//	-send:     also announce this host on the interfaces
+//	-name:     system name to announce (default: the host name)
+//	-timeout:  how long to wait for the neighbors, 0 to keep printing them
+//	           (default: 35s, switches usually send every 30s)
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/u-root/u-root/pkg/lldp"
+)
+
+var (
+	send    = flag.Bool("send", false, "Also announce this host on the interfaces")
+	name    = flag.String("name", "", "System name to announce, defaults to the host name")
+	timeout = flag.Duration("timeout", 35*time.Second, "How long to wait for the neighbors, 0 to keep printing them")
+)
+
+var errNoInterfaces = errors.New("no Ethernet interface is up")
+
+// interfaces returns the interfaces named, or all Ethernet interfaces that
+// are up.
+func interfaces(names []string) ([]*net.Interface, error) {
+	if len(names) > 0 {
+		var ifis []*net.Interface
+		for _, n := range names {
+			ifi, err := net.InterfaceByName(n)
+			if err != nil {
+				return nil, err
+			}
+			ifis = append(ifis, ifi)
+		}
+		return ifis, nil
+	}
+	all, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var ifis []*net.Interface
+	for i := range all {
+		if all[i].Flags&net.FlagUp != 0 && all[i].Flags&net.FlagLoopback == 0 && len(all[i].HardwareAddr) == 6 {
+			ifis = append(ifis, &all[i])
+		}
+	}
+	if len(ifis) == 0 {
+		return nil, errNoInterfaces
+	}
+	return ifis, nil
+}
+
+// listen prints the neighbors on ifi until ctx is done, or after the
+// first one if once is set, announcing this host every ttl/4 if send is
+// set.
+func listen(ctx context.Context, ifi *net.Interface, once bool) error {
+	c, err := lldp.Listen(ifi)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if *send {
+		go func() {
+			for {
+				if err := c.Send(*name, lldp.DefaultTTL); err != nil {
+					log.Printf("Could not announce on %s: %v", ifi.Name, err)
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(lldp.DefaultTTL / 4):
+				}
+			}
+		}()
+	}
+	for {
+		n, err := c.Receive(ctx)
+		if err != nil {
+			return err
+		}
+		log.Printf("%s: %v", ifi.Name, n)
+		if n.SystemDescription != "" {
+			log.Printf("%s: system description %q", ifi.Name, n.SystemDescription)
+		}
+		if once {
+			return nil
+		}
+	}
+}
+
+func run(names []string) error {
+	ifis, err := interfaces(names)
+	if err != nil {
+		return err
+	}
+	if *name == "" {
+		if *name, err = os.Hostname(); err != nil {
+			return err
+		}
+	}
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	var wg sync.WaitGroup
+	for _, ifi := range ifis {
+		wg.Add(1)
+		go func(ifi *net.Interface) {
+			defer wg.Done()
+			err := listen(ctx, ifi, *timeout > 0)
+			if errors.Is(err, context.DeadlineExceeded) {
+				log.Printf("%s: no neighbor heard of in %v", ifi.Name, *timeout)
+			} else if err != nil {
+				log.Printf("%s: %v", ifi.Name, err)
+			}
+		}(ifi)
+	}
+	wg.Wait()
+	return nil
+}
+
+func main() {
+	flag.Parse()
+	if err := run(flag.Args()); err != nil {
+		log.Fatal(err)
+	}
+}
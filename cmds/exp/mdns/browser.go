@@ -0,0 +1,205 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// browser sends one-shot queries, RFC 6762 section 5.1, from conn to dst,
+// caching the records of the responses.
+type browser struct {
+	conn    net.PacketConn
+	dst     net.Addr
+	timeout time.Duration
+	cache   []dnsmessage.Resource
+}
+
+// instance is an instance of a service.
+type instance struct {
+	name  string
+	host  string
+	port  uint16
+	addrs []net.IP
+	txt   []string
+}
+
+// collect caches the records of the response p, forgetting those said
+// goodbye to.
+func (b *browser) collect(p []byte) {
+	var m dnsmessage.Message
+	if err := m.Unpack(p); err != nil || !m.Response {
+		return
+	}
+	for _, rr := range append(m.Answers, m.Additionals...) {
+		rr.Header.Class &^= cacheFlush
+		k := key(rr)
+		cached := -1
+		for i, c := range b.cache {
+			if key(c) == k {
+				cached = i
+			}
+		}
+		switch {
+		case rr.Header.TTL == 0 && cached >= 0:
+			b.cache = append(b.cache[:cached], b.cache[cached+1:]...)
+		case rr.Header.TTL != 0 && cached < 0:
+			b.cache = append(b.cache, rr)
+		}
+	}
+}
+
+// query sends the questions qs and collects the responses until the
+// timeout.
+func (b *browser) query(qs []dnsmessage.Question) error {
+	m := dnsmessage.Message{Questions: qs}
+	p, err := m.Pack()
+	if err != nil {
+		return err
+	}
+	if _, err := b.conn.WriteTo(p, b.dst); err != nil {
+		return err
+	}
+	if err := b.conn.SetReadDeadline(time.Now().Add(b.timeout)); err != nil {
+		return err
+	}
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := b.conn.ReadFrom(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		b.collect(buf[:n])
+	}
+}
+
+// lookup returns the cached records of name and type typ.
+func (b *browser) lookup(name string, typ dnsmessage.Type) []dnsmessage.Resource {
+	var rrs []dnsmessage.Resource
+	for _, rr := range b.cache {
+		if rr.Header.Type == typ && strings.EqualFold(rr.Header.Name.String(), name) {
+			rrs = append(rrs, rr)
+		}
+	}
+	return rrs
+}
+
+// ptrs returns the sorted targets of the PTR records of name.
+func (b *browser) ptrs(name string) []string {
+	var names []string
+	for _, rr := range b.lookup(name, dnsmessage.TypePTR) {
+		names = append(names, rr.Body.(*dnsmessage.PTRResource).PTR.String())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func question(name string, typ dnsmessage.Type) dnsmessage.Question {
+	return dnsmessage.Question{Name: dnsmessage.MustNewName(name), Type: typ, Class: dnsmessage.ClassINET}
+}
+
+// types returns the types of services on the network.
+func (b *browser) types() ([]string, error) {
+	if err := b.query([]dnsmessage.Question{question(servicesName, dnsmessage.TypePTR)}); err != nil {
+		return nil, err
+	}
+	var types []string
+	for _, t := range b.ptrs(servicesName) {
+		types = append(types, strings.TrimSuffix(t, ".local."))
+	}
+	return types, nil
+}
+
+// resolve returns the instance name from the cache, with the questions
+// still needed to complete it.
+func (b *browser) resolve(name string) (*instance, []dnsmessage.Question) {
+	in := &instance{name: name}
+	var qs []dnsmessage.Question
+	srvs := b.lookup(name, dnsmessage.TypeSRV)
+	if len(srvs) == 0 {
+		qs = append(qs, question(name, dnsmessage.TypeSRV))
+	} else {
+		srv := srvs[0].Body.(*dnsmessage.SRVResource)
+		in.host, in.port = srv.Target.String(), srv.Port
+		for _, rr := range b.lookup(in.host, dnsmessage.TypeA) {
+			a := rr.Body.(*dnsmessage.AResource).A
+			in.addrs = append(in.addrs, net.IP(a[:]))
+		}
+		for _, rr := range b.lookup(in.host, dnsmessage.TypeAAAA) {
+			a := rr.Body.(*dnsmessage.AAAAResource).AAAA
+			in.addrs = append(in.addrs, net.IP(a[:]))
+		}
+		if len(in.addrs) == 0 {
+			qs = append(qs, question(in.host, dnsmessage.TypeA), question(in.host, dnsmessage.TypeAAAA))
+		}
+	}
+	txts := b.lookup(name, dnsmessage.TypeTXT)
+	if len(txts) == 0 {
+		qs = append(qs, question(name, dnsmessage.TypeTXT))
+	} else {
+		for _, t := range txts[0].Body.(*dnsmessage.TXTResource).TXT {
+			if t != "" {
+				in.txt = append(in.txt, t)
+			}
+		}
+	}
+	return in, qs
+}
+
+// instances returns the instances of the service type typ, asking again
+// for the records missing from the responses, twice at most.
+func (b *browser) instances(typ string) ([]*instance, error) {
+	name := typ + ".local."
+	qs := []dnsmessage.Question{question(name, dnsmessage.TypePTR)}
+	var insts []*instance
+	for round := 0; round < 3 && len(qs) > 0; round++ {
+		if err := b.query(qs); err != nil {
+			return nil, err
+		}
+		insts, qs = nil, nil
+		for _, n := range b.ptrs(name) {
+			in, more := b.resolve(n)
+			insts = append(insts, in)
+			qs = append(qs, more...)
+		}
+	}
+	return insts, nil
+}
+
+// show writes the instances, one per line: name, host and port,
+// addresses and TXT data.
+func show(out io.Writer, insts []*instance) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, in := range insts {
+		host := "?"
+		if in.host != "" {
+			host = net.JoinHostPort(in.host, strconv.Itoa(int(in.port)))
+		}
+		var addrs []string
+		for _, a := range in.addrs {
+			addrs = append(addrs, a.String())
+		}
+		var txt []string
+		for _, t := range in.txt {
+			txt = append(txt, strconv.Quote(t))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", in.name, host, strings.Join(addrs, ","), strings.Join(txt, " "))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,279 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// mdns advertises the machine with multicast DNS and DNS-SD, or browses for
+// the services of others.
+//
+// Synopsis:
+//
+//	mdns [-i IFACE] [-n NAME] [-s TYPE:PORT[,TYPE:PORT]...]
+//	mdns -b [-i IFACE] [-t TIMEOUT] [TYPE]
+//
+// Description:
+//
+//	Without -b, mdns answers queries for NAME.local, with the addresses of
+//	IFACE or of all interfaces, and for the services, an ssh server on port
+//	22 by default, until it is interrupted, then says goodbye. NAME is the
+//	hostname by default, the instances of its services are named alike.
+//
+//	With -b, mdns sends queries for the instances of the service TYPE, and
+//	shows those which answered within TIMEOUT, with their host, port,
+//	addresses and TXT data. Without TYPE, mdns shows the types of services
+//	on the network instead.
+//
+//	TYPE is of the form _SERVICE._tcp or _SERVICE._udp, as _ssh._tcp. mdns
+//	speaks over IPv4, and does not probe for conflicting names.
+//
+// Options:
+//
+//	-b: browse for services
+//	-i: interface to advertise or browse on
+//	-n: name to advertise (default: hostname)
+//	-s: services to advertise, none if empty (default: _ssh._tcp:22)
+//	-t: how long to wait for responses (default: 3s)
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+const (
+	mdnsPort = 5353
+	// servicesName is the name of the PTR records of the types of services,
+	// RFC 6763 section 9.
+	servicesName = "_services._dns-sd._udp.local."
+)
+
+var mdnsGroup = net.IPv4(224, 0, 0, 251)
+
+var (
+	errUsage = errors.New("usage: mdns [-i IFACE] [-n NAME] [-s TYPE:PORT[,TYPE:PORT]...] | -b [-i IFACE] [-t TIMEOUT] [TYPE]")
+	errArg   = errors.New("invalid argument")
+)
+
+// service is a service advertised on a port.
+type service struct {
+	typ  string
+	port uint16
+}
+
+// parseType parses a service type, _SERVICE._tcp or _SERVICE._udp, with an
+// optional .local domain.
+func parseType(s string) (string, error) {
+	t := strings.TrimSuffix(strings.TrimSuffix(s, "."), ".local")
+	svc, proto, ok := strings.Cut(t, ".")
+	if !ok || len(svc) < 2 || len(svc) > 16 || svc[0] != '_' || strings.Contains(svc[1:], "_") ||
+		(proto != "_tcp" && proto != "_udp") {
+		return "", fmt.Errorf("%w: service type %q", errArg, s)
+	}
+	return t, nil
+}
+
+// parseServices parses comma separated TYPE:PORT services.
+func parseServices(s string) ([]service, error) {
+	var svcs []service
+	for _, f := range strings.Split(s, ",") {
+		if f == "" {
+			continue
+		}
+		t, p, ok := strings.Cut(f, ":")
+		if !ok {
+			return nil, fmt.Errorf("%w: service %q needs a port", errArg, f)
+		}
+		typ, err := parseType(t)
+		if err != nil {
+			return nil, err
+		}
+		port, err := strconv.ParseUint(p, 10, 16)
+		if err != nil || port == 0 {
+			return nil, fmt.Errorf("%w: port %q", errArg, p)
+		}
+		svcs = append(svcs, service{typ: typ, port: uint16(port)})
+	}
+	return svcs, nil
+}
+
+// parseHost checks the name to advertise is a single DNS label, dropping
+// the domain of a hostname.
+func parseHost(s string) (string, error) {
+	s, _, _ = strings.Cut(s, ".")
+	if s == "" || len(s) > 63 {
+		return "", fmt.Errorf("%w: name %q", errArg, s)
+	}
+	return s, nil
+}
+
+// hostAddrs returns the addresses of ifi, or of all interfaces which are
+// up if ifi is nil, but loopback ones.
+func hostAddrs(ifi *net.Interface) ([]net.IP, error) {
+	ifis := []net.Interface{}
+	if ifi != nil {
+		ifis = append(ifis, *ifi)
+	} else {
+		all, err := net.Interfaces()
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range all {
+			if i.Flags&net.FlagUp != 0 && i.Flags&net.FlagLoopback == 0 {
+				ifis = append(ifis, i)
+			}
+		}
+	}
+	var ips []net.IP
+	for _, i := range ifis {
+		addrs, err := i.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range addrs {
+			if n, ok := a.(*net.IPNet); ok && !n.IP.IsLoopback() {
+				ips = append(ips, n.IP)
+			}
+		}
+	}
+	return ips, nil
+}
+
+func advertise(ifi *net.Interface, name string, svcs []service) error {
+	r := &responder{host: name, services: svcs, addrs: func() ([]net.IP, error) { return hostAddrs(ifi) }}
+	group := &net.UDPAddr{IP: mdnsGroup, Port: mdnsPort}
+	conn, err := net.ListenMulticastUDP("udp4", ifi, group)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Announce twice, a second apart, RFC 6762 section 8.3.
+	if err := r.announce(conn, group, false); err != nil {
+		return err
+	}
+	go func() {
+		time.Sleep(time.Second)
+		r.announce(conn, group, false)
+	}()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		<-sigs
+		r.announce(conn, group, true)
+		close(done)
+		conn.Close()
+	}()
+	err = r.serve(conn, group)
+	select {
+	case <-done:
+		return nil
+	default:
+		return err
+	}
+}
+
+func browse(ifi *net.Interface, typ string, timeout time.Duration, out io.Writer) error {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if ifi != nil {
+		if err := ipv4.NewPacketConn(conn).SetMulticastInterface(ifi); err != nil {
+			return err
+		}
+	}
+	b := &browser{conn: conn, dst: &net.UDPAddr{IP: mdnsGroup, Port: mdnsPort}, timeout: timeout}
+	if typ == "" {
+		types, err := b.types()
+		if err != nil {
+			return err
+		}
+		for _, t := range types {
+			fmt.Fprintln(out, t)
+		}
+		return nil
+	}
+	insts, err := b.instances(typ)
+	if err != nil {
+		return err
+	}
+	return show(out, insts)
+}
+
+func run(args []string, out io.Writer) error {
+	f := flag.NewFlagSet("mdns", flag.ContinueOnError)
+	f.SetOutput(io.Discard)
+	doBrowse := f.Bool("b", false, "Browse for services")
+	iface := f.String("i", "", "Interface to advertise or browse on")
+	name := f.String("n", "", "Name to advertise")
+	services := f.String("s", "_ssh._tcp:22", "Services to advertise")
+	timeout := f.Duration("t", 3*time.Second, "How long to wait for responses")
+	if err := f.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+
+	var ifi *net.Interface
+	if *iface != "" {
+		var err error
+		if ifi, err = net.InterfaceByName(*iface); err != nil {
+			return err
+		}
+	}
+
+	if *doBrowse {
+		if f.NArg() > 1 {
+			return errUsage
+		}
+		if *timeout <= 0 {
+			return fmt.Errorf("%w: timeout %v", errArg, *timeout)
+		}
+		var typ string
+		if f.NArg() == 1 {
+			var err error
+			if typ, err = parseType(f.Arg(0)); err != nil {
+				return err
+			}
+		}
+		return browse(ifi, typ, *timeout, out)
+	}
+
+	if f.NArg() != 0 {
+		return errUsage
+	}
+	if *name == "" {
+		h, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+		*name = h
+	}
+	host, err := parseHost(*name)
+	if err != nil {
+		return err
+	}
+	svcs, err := parseServices(*services)
+	if err != nil {
+		return err
+	}
+	return advertise(ifi, host, svcs)
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		log.Fatalf("mdns: %v", err)
+	}
+}
@@ -0,0 +1,337 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestParseType(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want string
+		err  error
+	}{
+		{in: "_ssh._tcp", want: "_ssh._tcp"},
+		{in: "_http._tcp.local.", want: "_http._tcp"},
+		{in: "_ipp._udp.local", want: "_ipp._udp"},
+		{in: "ssh._tcp", err: errArg},
+		{in: "_ssh._sctp", err: errArg},
+		{in: "_ssh", err: errArg},
+		{in: "_a_b._tcp", err: errArg},
+		{in: "_waytoolongservicename._tcp", err: errArg},
+	} {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseType(tt.in)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("parseType(%q) = %v, want %v", tt.in, err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("parseType(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseServices(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want []service
+		err  error
+	}{
+		{in: ""},
+		{in: "_ssh._tcp:22", want: []service{{typ: "_ssh._tcp", port: 22}}},
+		{in: "_ssh._tcp:22,_http._tcp:8080", want: []service{{typ: "_ssh._tcp", port: 22}, {typ: "_http._tcp", port: 8080}}},
+		{in: "_ssh._tcp", err: errArg},
+		{in: "_ssh._tcp:0", err: errArg},
+		{in: "_ssh._tcp:65536", err: errArg},
+		{in: "ssh:22", err: errArg},
+	} {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseServices(tt.in)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("parseServices(%q) = %v, want %v", tt.in, err, tt.err)
+			}
+			if diff := cmp.Diff(tt.want, got, cmp.AllowUnexported(service{})); diff != "" {
+				t.Errorf("parseServices(%q) (-want +got):\n%s", tt.in, diff)
+			}
+		})
+	}
+}
+
+func TestParseHost(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want string
+		err  error
+	}{
+		{in: "box", want: "box"},
+		{in: "box.example.com", want: "box"},
+		{in: ".example.com", err: errArg},
+		{in: string(make([]byte, 64)), err: errArg},
+	} {
+		got, err := parseHost(tt.in)
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Errorf("parseHost(%q) = %q, %v, want %q, %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func testResponder() *responder {
+	return &responder{
+		host:     "box",
+		services: []service{{typ: "_ssh._tcp", port: 22}},
+		addrs: func() ([]net.IP, error) {
+			return []net.IP{net.ParseIP("192.168.0.2"), net.ParseIP("fe80::2")}, nil
+		},
+	}
+}
+
+// names returns the names and types of rrs.
+func names(rrs []dnsmessage.Resource) []string {
+	var s []string
+	for _, rr := range rrs {
+		s = append(s, rr.Header.Name.String()+" "+rr.Header.Type.String())
+	}
+	return s
+}
+
+func TestAnswer(t *testing.T) {
+	r := testResponder()
+	all, err := r.records()
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := func(name string, typ dnsmessage.Type) dnsmessage.Question {
+		return dnsmessage.Question{Name: dnsmessage.MustNewName(name), Type: typ, Class: dnsmessage.ClassINET}
+	}
+
+	for _, tt := range []struct {
+		name       string
+		questions  []dnsmessage.Question
+		known      []dnsmessage.Resource
+		answers    []string
+		additional []string
+	}{
+		{
+			name:       "host",
+			questions:  []dnsmessage.Question{q("BOX.local.", dnsmessage.TypeA)},
+			answers:    []string{"box.local. TypeA"},
+			additional: []string{"box.local. TypeAAAA"},
+		},
+		{
+			name:      "any",
+			questions: []dnsmessage.Question{q("box.local.", dnsmessage.TypeALL)},
+			answers:   []string{"box.local. TypeA", "box.local. TypeAAAA"},
+		},
+		{
+			name:      "browse",
+			questions: []dnsmessage.Question{q("_ssh._tcp.local.", dnsmessage.TypePTR)},
+			answers:   []string{"_ssh._tcp.local. TypePTR"},
+			additional: []string{
+				"box._ssh._tcp.local. TypeSRV",
+				"box.local. TypeA",
+				"box.local. TypeAAAA",
+				"box._ssh._tcp.local. TypeTXT",
+			},
+		},
+		{
+			name:      "types",
+			questions: []dnsmessage.Question{q(servicesName, dnsmessage.TypePTR)},
+			answers:   []string{"_services._dns-sd._udp.local. TypePTR"},
+			additional: []string{
+				"_ssh._tcp.local. TypePTR",
+			},
+		},
+		{
+			name:      "known answer",
+			questions: []dnsmessage.Question{q("box.local.", dnsmessage.TypeALL)},
+			known:     all[:1],
+			answers:   []string{"box.local. TypeAAAA"},
+		},
+		{name: "other host", questions: []dnsmessage.Question{q("other.local.", dnsmessage.TypeA)}},
+		{name: "other type", questions: []dnsmessage.Question{q("box.local.", dnsmessage.TypeMX)}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := r.answer(&dnsmessage.Message{Questions: tt.questions, Answers: tt.known}, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.answers == nil {
+				if resp != nil {
+					t.Fatalf("answer = %v, want none", names(resp.Answers))
+				}
+				return
+			}
+			if diff := cmp.Diff(tt.answers, names(resp.Answers)); diff != "" {
+				t.Errorf("answers (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.additional, names(resp.Additionals)); diff != "" {
+				t.Errorf("additional records (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAnswerLegacy(t *testing.T) {
+	r := testResponder()
+	q := &dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: 42},
+		Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName("box._ssh._tcp.local."), Type: dnsmessage.TypeSRV, Class: dnsmessage.ClassINET}},
+	}
+	resp, err := r.answer(q, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.ID != 42 || len(resp.Questions) != 1 {
+		t.Errorf("response has ID %d and %d questions, want 42 and 1", resp.ID, len(resp.Questions))
+	}
+	for _, rr := range append(resp.Answers, resp.Additionals...) {
+		if rr.Header.TTL > legacyTTL || rr.Header.Class != dnsmessage.ClassINET {
+			t.Errorf("record %v has TTL %d and class %d, want at most %d and %d", rr.Header.Name, rr.Header.TTL, rr.Header.Class, legacyTTL, dnsmessage.ClassINET)
+		}
+	}
+	// The original records are untouched.
+	all, err := r.records()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if all[0].Header.TTL != hostTTL {
+		t.Errorf("TTL of %v = %d, want %d", all[0].Header.Name, all[0].Header.TTL, hostTTL)
+	}
+}
+
+func TestCollectGoodbye(t *testing.T) {
+	r := testResponder()
+	var hello, bye bytes.Buffer
+	for _, x := range []struct {
+		buf     *bytes.Buffer
+		goodbye bool
+	}{{&hello, false}, {&bye, true}} {
+		c := &recorder{}
+		if err := r.announce(c, nil, x.goodbye); err != nil {
+			t.Fatal(err)
+		}
+		x.buf.Write(c.b)
+	}
+
+	b := &browser{}
+	b.collect(hello.Bytes())
+	if got, want := len(b.cache), 6; got != want {
+		t.Fatalf("%d records cached, want %d", got, want)
+	}
+	// Announcing twice does not cache records twice.
+	b.collect(hello.Bytes())
+	if got, want := len(b.cache), 6; got != want {
+		t.Fatalf("%d records cached, want %d", got, want)
+	}
+	b.collect(bye.Bytes())
+	if len(b.cache) != 0 {
+		t.Errorf("records %v cached after goodbye, want none", names(b.cache))
+	}
+}
+
+// recorder is a net.PacketConn keeping the last packet written.
+type recorder struct {
+	net.PacketConn
+	b []byte
+}
+
+func (r *recorder) WriteTo(b []byte, _ net.Addr) (int, error) {
+	r.b = append([]byte(nil), b...)
+	return len(b), nil
+}
+
+func TestBrowse(t *testing.T) {
+	rconn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Skipf("no loopback: %v", err)
+	}
+	defer rconn.Close()
+	r := testResponder()
+	r.services = append(r.services, service{typ: "_http._tcp", port: 8080})
+	go r.serve(rconn, nil)
+
+	for _, tt := range []struct {
+		name string
+		typ  string
+		want string
+	}{
+		{name: "types", want: "_http._tcp\n_ssh._tcp\n"},
+		{name: "ssh", typ: "_ssh._tcp", want: "box._ssh._tcp.local.  box.local.:22  192.168.0.2,fe80::2  \n"},
+		{name: "none", typ: "_ipp._tcp"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			b := &browser{conn: conn, dst: rconn.LocalAddr(), timeout: 100 * time.Millisecond}
+			var out bytes.Buffer
+			if tt.typ == "" {
+				types, err := b.types()
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, typ := range types {
+					out.WriteString(typ + "\n")
+				}
+			} else {
+				insts, err := b.instances(tt.typ)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := show(&out, insts); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := out.String(); got != tt.want {
+				t.Errorf("browse %q = %q, want %q", tt.typ, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShowUnresolved(t *testing.T) {
+	var out bytes.Buffer
+	insts := []*instance{
+		{name: "a._http._tcp.local."},
+		{name: "b._http._tcp.local.", host: "b.local.", port: 80, addrs: []net.IP{net.ParseIP("10.0.0.1")}, txt: []string{"path=/"}},
+	}
+	if err := show(&out, insts); err != nil {
+		t.Fatal(err)
+	}
+	want := "a._http._tcp.local.  ?                      \n" +
+		"b._http._tcp.local.  b.local.:80  10.0.0.1  \"path=/\"\n"
+	if got := out.String(); got != want {
+		t.Errorf("show = %q, want %q", got, want)
+	}
+}
+
+func TestRunUsage(t *testing.T) {
+	for _, tt := range []struct {
+		args []string
+		err  error
+	}{
+		{args: []string{"-x"}, err: errUsage},
+		{args: []string{"extra"}, err: errUsage},
+		{args: []string{"-b", "_ssh._tcp", "_http._tcp"}, err: errUsage},
+		{args: []string{"-b", "ssh"}, err: errArg},
+		{args: []string{"-b", "-t", "0s"}, err: errArg},
+		{args: []string{"-s", "_ssh._tcp"}, err: errArg},
+	} {
+		if err := run(tt.args, &bytes.Buffer{}); !errors.Is(err, tt.err) {
+			t.Errorf("run(%q) = %v, want %v", tt.args, err, tt.err)
+		}
+	}
+}
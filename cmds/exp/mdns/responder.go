@@ -0,0 +1,241 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// hostTTL is the TTL of records naming hosts, otherTTL that of the
+	// others, RFC 6762 section 10.
+	hostTTL  = 120
+	otherTTL = 4500
+	// legacyTTL caps the TTLs of responses to legacy unicast queries, RFC
+	// 6762 section 6.7.
+	legacyTTL = 10
+	// cacheFlush is the top bit of the class of records only their owner
+	// has, and that of the class of questions wanting a unicast response.
+	cacheFlush = 1 << 15
+)
+
+// equalName returns whether the names a and b are equal, ignoring case.
+func equalName(a, b dnsmessage.Name) bool {
+	return strings.EqualFold(a.String(), b.String())
+}
+
+// key identifies a record, its TTL aside.
+func key(r dnsmessage.Resource) string {
+	return fmt.Sprintf("%s %d %s", strings.ToLower(r.Header.Name.String()), r.Header.Type, r.Body.GoString())
+}
+
+// responder answers the queries about its host and services.
+type responder struct {
+	// host is the name of the host, without the .local domain.
+	host     string
+	services []service
+	addrs    func() ([]net.IP, error)
+}
+
+// records returns all the records of r. The host and the service types
+// were checked to be valid names before.
+func (r *responder) records() ([]dnsmessage.Resource, error) {
+	ips, err := r.addrs()
+	if err != nil {
+		return nil, err
+	}
+	var rrs []dnsmessage.Resource
+	add := func(name string, unique bool, ttl uint32, body dnsmessage.ResourceBody) {
+		class := dnsmessage.ClassINET
+		if unique {
+			class |= cacheFlush
+		}
+		rrs = append(rrs, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(name), Type: typeOf(body), Class: class, TTL: ttl},
+			Body:   body,
+		})
+	}
+
+	host := dnsmessage.MustNewName(r.host + ".local.")
+	for _, ip := range ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			add(host.String(), true, hostTTL, &dnsmessage.AResource{A: [4]byte(ip4)})
+		} else {
+			add(host.String(), true, hostTTL, &dnsmessage.AAAAResource{AAAA: [16]byte(ip.To16())})
+		}
+	}
+	for _, s := range r.services {
+		typ := s.typ + ".local."
+		inst := r.host + "." + typ
+		add(servicesName, false, otherTTL, &dnsmessage.PTRResource{PTR: dnsmessage.MustNewName(typ)})
+		add(typ, false, otherTTL, &dnsmessage.PTRResource{PTR: dnsmessage.MustNewName(inst)})
+		add(inst, true, hostTTL, &dnsmessage.SRVResource{Port: s.port, Target: host})
+		// A service without TXT data has a TXT record of one empty
+		// string, RFC 6763 section 6.1.
+		add(inst, true, otherTTL, &dnsmessage.TXTResource{TXT: []string{""}})
+	}
+	return rrs, nil
+}
+
+func typeOf(body dnsmessage.ResourceBody) dnsmessage.Type {
+	switch body.(type) {
+	case *dnsmessage.AResource:
+		return dnsmessage.TypeA
+	case *dnsmessage.AAAAResource:
+		return dnsmessage.TypeAAAA
+	case *dnsmessage.PTRResource:
+		return dnsmessage.TypePTR
+	case *dnsmessage.SRVResource:
+		return dnsmessage.TypeSRV
+	case *dnsmessage.TXTResource:
+		return dnsmessage.TypeTXT
+	}
+	return 0
+}
+
+// matches returns whether the record h answers the question q.
+func matches(h dnsmessage.ResourceHeader, q dnsmessage.Question) bool {
+	class := q.Class &^ cacheFlush
+	return (class == dnsmessage.ClassINET || class == dnsmessage.ClassANY) &&
+		(q.Type == h.Type || q.Type == dnsmessage.TypeALL) &&
+		equalName(q.Name, h.Name)
+}
+
+// known returns whether the querier knows the record rr already, with at
+// least half of its TTL left, RFC 6762 section 7.1.
+func known(answers []dnsmessage.Resource, rr dnsmessage.Resource) bool {
+	for _, a := range answers {
+		if a.Header.TTL >= rr.Header.TTL/2 && key(a) == key(rr) {
+			return true
+		}
+	}
+	return false
+}
+
+// answer returns the response to the query q, nil if r has no answers to
+// it. Responses to legacy unicast queries, from other ports than the mDNS
+// one, repeat their ID and questions.
+func (r *responder) answer(q *dnsmessage.Message, legacy bool) (*dnsmessage.Message, error) {
+	all, err := r.records()
+	if err != nil {
+		return nil, err
+	}
+	resp := &dnsmessage.Message{Header: dnsmessage.Header{Response: true, Authoritative: true}}
+	seen := map[string]bool{}
+	for _, qu := range q.Questions {
+		for _, rr := range all {
+			if !matches(rr.Header, qu) || known(q.Answers, rr) || seen[key(rr)] {
+				continue
+			}
+			seen[key(rr)] = true
+			resp.Answers = append(resp.Answers, rr)
+		}
+	}
+	if len(resp.Answers) == 0 {
+		return nil, nil
+	}
+
+	// The records the querier will want next go along, RFC 6763 section
+	// 12: the SRV and TXT records of instances, the addresses of hosts.
+	var extra func(name dnsmessage.Name)
+	extra = func(name dnsmessage.Name) {
+		for _, rr := range all {
+			if !equalName(rr.Header.Name, name) || known(q.Answers, rr) || seen[key(rr)] {
+				continue
+			}
+			seen[key(rr)] = true
+			resp.Additionals = append(resp.Additionals, rr)
+			if srv, ok := rr.Body.(*dnsmessage.SRVResource); ok {
+				extra(srv.Target)
+			}
+		}
+	}
+	for _, a := range resp.Answers {
+		switch b := a.Body.(type) {
+		case *dnsmessage.PTRResource:
+			extra(b.PTR)
+		case *dnsmessage.SRVResource:
+			extra(b.Target)
+		case *dnsmessage.AResource, *dnsmessage.AAAAResource:
+			// The other addresses, RFC 6762 section 6.2.
+			extra(a.Header.Name)
+		}
+	}
+
+	if legacy {
+		resp.ID = q.ID
+		resp.Questions = q.Questions
+		for _, rrs := range [][]dnsmessage.Resource{resp.Answers, resp.Additionals} {
+			for i := range rrs {
+				rrs[i].Header.Class &^= cacheFlush
+				rrs[i].Header.TTL = min(rrs[i].Header.TTL, legacyTTL)
+			}
+		}
+	}
+	return resp, nil
+}
+
+// announce sends all the records of r to group, with a TTL of 0 to say
+// goodbye.
+func (r *responder) announce(conn net.PacketConn, group net.Addr, goodbye bool) error {
+	all, err := r.records()
+	if err != nil {
+		return err
+	}
+	if goodbye {
+		for i := range all {
+			all[i].Header.TTL = 0
+		}
+	}
+	m := dnsmessage.Message{Header: dnsmessage.Header{Response: true, Authoritative: true}, Answers: all}
+	b, err := m.Pack()
+	if err != nil {
+		return err
+	}
+	_, err = conn.WriteTo(b, group)
+	return err
+}
+
+// serve answers the queries received on conn, sending the responses to
+// group unless the querier asked for a unicast one.
+func (r *responder) serve(conn net.PacketConn, group net.Addr) error {
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		var q dnsmessage.Message
+		if err := q.Unpack(buf[:n]); err != nil || q.Response || q.OpCode != 0 {
+			continue
+		}
+		legacy := false
+		if u, ok := from.(*net.UDPAddr); ok {
+			legacy = u.Port != mdnsPort
+		}
+		resp, err := r.answer(&q, legacy)
+		if err != nil {
+			return err
+		}
+		if resp == nil {
+			continue
+		}
+		b, err := resp.Pack()
+		if err != nil {
+			return err
+		}
+		to := group
+		if legacy || (len(q.Questions) > 0 && q.Questions[0].Class&cacheFlush != 0) {
+			to = from
+		}
+		if _, err := conn.WriteTo(b, to); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// netcfg applies a static network configuration from a YAML or JSON file,
+// creating bonds and VLANs and setting addresses, routes and DNS.
+//
+// Synopsis:
+//
+//	netcfg [OPTIONS...] FILE
+//
+// Options:
+//
+//	-n:           only check the file, do not apply it
+//	-resolv-conf: resolv.conf to write the DNS configuration to
+//	              (default: /etc/resolv.conf)
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+
+	"github.com/u-root/u-root/pkg/dhclient"
+	"github.com/u-root/u-root/pkg/netcfg"
+)
+
+var (
+	check      = flag.Bool("n", false, "Only check the file, do not apply it")
+	resolvConf = flag.String("resolv-conf", dhclient.ResolvConfPath, "resolv.conf to write the DNS configuration to")
+)
+
+var errUsage = errors.New("usage: netcfg [OPTIONS...] FILE")
+
+func run(path string) error {
+	c, err := netcfg.Load(path)
+	if err != nil {
+		return err
+	}
+	if *check {
+		return nil
+	}
+	if err := c.Apply(nil); err != nil {
+		return err
+	}
+	return c.WriteDNS(*resolvConf)
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 1 {
+		log.Fatal(errUsage)
+	}
+	if err := run(flag.Arg(0)); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,461 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+// nft programs stateless filter rules, accepting or dropping packets by
+// interface, address and port, with the syntax of nft(8).
+//
+// Synopsis:
+//
+//	nft [-a] list ruleset | tables | table [FAMILY] TABLE | chain [FAMILY] TABLE CHAIN
+//	nft add|delete table [FAMILY] TABLE
+//	nft add chain [FAMILY] TABLE CHAIN [{ type filter hook HOOK priority N ; [policy accept|drop ;] }]
+//	nft delete|flush chain [FAMILY] TABLE CHAIN
+//	nft add rule [FAMILY] TABLE CHAIN [MATCH]... [accept|drop]
+//	nft delete rule [FAMILY] TABLE CHAIN handle HANDLE
+//	nft flush ruleset
+//	nft -f FILE
+//
+// Description:
+//
+//	FAMILY is ip, ip6 or inet, which sees both, and is ip by default.
+//	HOOK is prerouting, input, forward, output or postrouting: chains with
+//	one are base chains, which packets enter, getting the verdict of the
+//	policy unless a rule matches them. A MATCH is one of
+//
+//	iifname NAME, oifname NAME      the interface, NAME* matching a prefix
+//	ip saddr|daddr ADDR[/LEN]       the IPv4 source or destination
+//	ip6 saddr|daddr ADDR[/LEN]      the IPv6 source or destination
+//	tcp|udp sport|dport PORT        the source or destination port
+//	meta l4proto PROTOCOL           the IP protocol
+//
+//	Commands are separated by newlines or semicolons, those of FILE being
+//	applied all at once, or not at all. flush ruleset deletes all tables.
+//	Listed rules with expressions nft does not know of show ... for them.
+//
+//	For instance, to only accept ssh connections, on eth0:
+//
+//	flush ruleset
+//	add table inet filter
+//	add chain inet filter input { type filter hook input priority 0 ; policy drop ; }
+//	add rule inet filter input iifname lo accept
+//	add rule inet filter input iifname eth0 tcp dport 22 accept
+//
+// Options:
+//
+//	-a: show the handles of rules
+//	-f: read commands from FILE
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/nftables"
+)
+
+var (
+	errUsage = errors.New("usage: nft [-a] [-f FILE] [list|add|delete|flush ...]")
+	errArg   = errors.New("invalid argument")
+)
+
+// ruleset are the operations on the ruleset of the kernel nft uses.
+type ruleset interface {
+	Tables(f nftables.Family) ([]nftables.Table, error)
+	Chains(t nftables.Table) ([]*nftables.Chain, error)
+	Rules(t nftables.Table, chain string) ([]*nftables.Rule, error)
+	Commit(b *nftables.Batch) error
+}
+
+type kernel struct{}
+
+func (kernel) Tables(f nftables.Family) ([]nftables.Table, error) { return nftables.Tables(f) }
+func (kernel) Chains(t nftables.Table) ([]*nftables.Chain, error) { return nftables.Chains(t) }
+func (kernel) Rules(t nftables.Table, chain string) ([]*nftables.Rule, error) {
+	return nftables.Rules(t, chain)
+}
+func (kernel) Commit(b *nftables.Batch) error { return b.Commit() }
+
+type cmd struct {
+	rs      ruleset
+	out     io.Writer
+	handles bool
+}
+
+// tokens splits s into words, quoted strings and the separators ; { and },
+// up to a # comment.
+func tokens(s string) ([]string, error) {
+	var toks []string
+	for {
+		s = strings.TrimLeft(s, " \t\r")
+		switch {
+		case s == "" || s[0] == '#':
+			return toks, nil
+		case s[0] == ';' || s[0] == '{' || s[0] == '}' || s[0] == '\n':
+			toks = append(toks, s[:1])
+			s = s[1:]
+		case s[0] == '"':
+			i := strings.IndexByte(s[1:], '"')
+			if i < 0 {
+				return nil, fmt.Errorf("%w: unterminated string %s", errArg, s)
+			}
+			toks = append(toks, s[1:i+1])
+			s = s[i+2:]
+		default:
+			i := strings.IndexAny(s, " \t\r\n;{}\"#")
+			if i < 0 {
+				i = len(s)
+			}
+			toks = append(toks, s[:i])
+			s = s[i:]
+		}
+	}
+}
+
+// commands splits toks into commands, at newlines and at semicolons out of
+// braces.
+func commands(toks []string) [][]string {
+	var cmds [][]string
+	var cur []string
+	depth := 0
+	for _, t := range toks {
+		switch {
+		case t == "{":
+			depth++
+		case t == "}":
+			depth--
+		case t == "\n", t == ";" && depth == 0:
+			if len(cur) > 0 {
+				cmds = append(cmds, cur)
+			}
+			cur, depth = nil, 0
+			continue
+		}
+		cur = append(cur, t)
+	}
+	if len(cur) > 0 {
+		cmds = append(cmds, cur)
+	}
+	return cmds
+}
+
+// table parses [FAMILY] TABLE, followed by n more arguments at least.
+func table(args []string, n int) (nftables.Table, []string, error) {
+	t := nftables.Table{Family: nftables.IPv4}
+	if len(args) > n+1 {
+		if f, err := nftables.ParseFamily(args[0]); err == nil {
+			t.Family = f
+			args = args[1:]
+		}
+	}
+	if len(args) < n+1 {
+		return t, nil, errUsage
+	}
+	t.Name = args[0]
+	return t, args[1:], nil
+}
+
+// parseChain parses the { type TYPE hook HOOK priority N ; policy VERDICT ; }
+// of a chain into c.
+func parseChain(c *nftables.Chain, args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+	if args[0] != "{" || args[len(args)-1] != "}" {
+		return fmt.Errorf("%w: chain %s needs { }", errArg, c.Name)
+	}
+	args = args[1 : len(args)-1]
+	var hook, priority bool
+	for len(args) > 0 {
+		if args[0] == ";" {
+			args = args[1:]
+			continue
+		}
+		if len(args) < 2 {
+			return fmt.Errorf("%w: %s needs a value", errArg, args[0])
+		}
+		var err error
+		switch k, v := args[0], args[1]; k {
+		case "type":
+			c.Type = v
+		case "hook":
+			c.Hook, err = nftables.ParseHook(v)
+			hook = true
+		case "priority":
+			var p int64
+			p, err = strconv.ParseInt(v, 10, 32)
+			c.Priority = int32(p)
+			priority = true
+		case "policy":
+			c.Policy, err = nftables.ParseVerdict(v)
+		default:
+			return fmt.Errorf("%w: %q in chain %s", errArg, k, c.Name)
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %s %q", errArg, args[0], args[1])
+		}
+		args = args[2:]
+	}
+	if (c.Type != "" || hook || priority) && (c.Type == "" || !hook || !priority) {
+		return fmt.Errorf("%w: base chain %s needs a type, a hook and a priority", errArg, c.Name)
+	}
+	return nil
+}
+
+// change adds the changes of the command args to b.
+func (c *cmd) change(b *nftables.Batch, args []string) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+	op, obj, args := args[0], args[1], args[2:]
+	switch obj {
+	case "ruleset":
+		if op != "flush" || len(args) != 0 {
+			return errUsage
+		}
+		tables, err := c.rs.Tables(0)
+		if err != nil {
+			return err
+		}
+		for _, t := range tables {
+			b.DelTable(t)
+		}
+		return nil
+
+	case "table":
+		t, rest, err := table(args, 0)
+		if err != nil || len(rest) != 0 {
+			return errUsage
+		}
+		switch op {
+		case "add":
+			b.AddTable(t)
+		case "delete":
+			b.DelTable(t)
+		default:
+			return errUsage
+		}
+		return nil
+
+	case "chain":
+		t, rest, err := table(args, 1)
+		if err != nil {
+			return err
+		}
+		ch := &nftables.Chain{Table: t, Name: rest[0]}
+		switch {
+		case op == "add":
+			if err := parseChain(ch, rest[1:]); err != nil {
+				return err
+			}
+			b.AddChain(ch)
+		case op == "delete" && len(rest) == 1:
+			b.DelChain(ch)
+		case op == "flush" && len(rest) == 1:
+			b.FlushChain(ch)
+		default:
+			return errUsage
+		}
+		return nil
+
+	case "rule":
+		t, rest, err := table(args, 1)
+		if err != nil {
+			return err
+		}
+		chain, rest := rest[0], rest[1:]
+		switch op {
+		case "add":
+			r, err := nftables.ParseRule(rest)
+			if err != nil {
+				return err
+			}
+			r.Table, r.Chain = t, chain
+			b.AddRule(r)
+		case "delete":
+			if len(rest) != 2 || rest[0] != "handle" {
+				return errUsage
+			}
+			h, err := strconv.ParseUint(rest[1], 10, 64)
+			if err != nil {
+				return fmt.Errorf("%w: handle %q", errArg, rest[1])
+			}
+			b.DelRule(&nftables.Rule{Table: t, Chain: chain, Handle: h})
+		default:
+			return errUsage
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: %s %q", errArg, op, obj)
+}
+
+func (c *cmd) showChain(ch *nftables.Chain) error {
+	rules, err := c.rs.Rules(ch.Table, ch.Name)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "\tchain %s {\n", ch.Name)
+	if ch.Type != "" {
+		policy := ch.Policy
+		if policy == nftables.NoVerdict {
+			policy = nftables.Accept
+		}
+		fmt.Fprintf(c.out, "\t\ttype %s hook %s priority %d; policy %s;\n", ch.Type, ch.Hook, ch.Priority, policy)
+	}
+	for _, r := range rules {
+		s := r.String()
+		if c.handles {
+			s += " # handle " + strconv.FormatUint(r.Handle, 10)
+		}
+		fmt.Fprintf(c.out, "\t\t%s\n", strings.TrimSpace(s))
+	}
+	fmt.Fprintf(c.out, "\t}\n")
+	return nil
+}
+
+// showTable shows the table t, only its chain named chain unless it is
+// empty.
+func (c *cmd) showTable(t nftables.Table, chain string) error {
+	chains, err := c.rs.Chains(t)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "table %s {\n", t)
+	found := false
+	for _, ch := range chains {
+		if chain != "" && ch.Name != chain {
+			continue
+		}
+		found = true
+		if err := c.showChain(ch); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(c.out, "}\n")
+	if chain != "" && !found {
+		return fmt.Errorf("chain %s %s: %w", t, chain, os.ErrNotExist)
+	}
+	return nil
+}
+
+// lookup returns whether the table t exists.
+func (c *cmd) lookup(t nftables.Table) error {
+	tables, err := c.rs.Tables(t.Family)
+	if err != nil {
+		return err
+	}
+	for _, tt := range tables {
+		if tt == t {
+			return nil
+		}
+	}
+	return fmt.Errorf("table %s: %w", t, os.ErrNotExist)
+}
+
+func (c *cmd) list(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	switch args[0] {
+	case "ruleset", "tables":
+		if len(args) != 1 {
+			return errUsage
+		}
+		tables, err := c.rs.Tables(0)
+		if err != nil {
+			return err
+		}
+		for _, t := range tables {
+			if args[0] == "tables" {
+				fmt.Fprintf(c.out, "table %s\n", t)
+				continue
+			}
+			if err := c.showTable(t, ""); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "table":
+		t, rest, err := table(args[1:], 0)
+		if err != nil || len(rest) != 0 {
+			return errUsage
+		}
+		if err := c.lookup(t); err != nil {
+			return err
+		}
+		return c.showTable(t, "")
+	case "chain":
+		t, rest, err := table(args[1:], 1)
+		if err != nil || len(rest) != 1 {
+			return errUsage
+		}
+		if err := c.lookup(t); err != nil {
+			return err
+		}
+		return c.showTable(t, rest[0])
+	}
+	return fmt.Errorf("%w: list %q", errArg, args[0])
+}
+
+// apply runs the commands of s, committing their changes at once.
+func (c *cmd) apply(s string) error {
+	toks, err := tokens(s)
+	if err != nil {
+		return err
+	}
+	var b nftables.Batch
+	for _, args := range commands(toks) {
+		if args[0] == "list" {
+			if len(b.Changes) != 0 {
+				return fmt.Errorf("%w: list after changes", errArg)
+			}
+			if err := c.list(args[1:]); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := c.change(&b, args); err != nil {
+			return err
+		}
+	}
+	return c.rs.Commit(&b)
+}
+
+func (c *cmd) run(args []string) error {
+	f := flag.NewFlagSet("nft", flag.ContinueOnError)
+	f.SetOutput(io.Discard)
+	f.BoolVar(&c.handles, "a", false, "Show the handles of rules")
+	file := f.String("f", "", "Read commands from FILE")
+	if err := f.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	if *file != "" {
+		if f.NArg() != 0 {
+			return errUsage
+		}
+		b, err := os.ReadFile(*file)
+		if err != nil {
+			return err
+		}
+		return c.apply(string(b))
+	}
+	if f.NArg() == 0 {
+		return errUsage
+	}
+	return c.apply(strings.Join(f.Args(), " "))
+}
+
+func main() {
+	c := &cmd{rs: kernel{}, out: os.Stdout}
+	if err := c.run(os.Args[1:]); err != nil {
+		log.Fatalf("nft: %v", err)
+	}
+}
@@ -0,0 +1,275 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/u-root/u-root/pkg/nftables"
+)
+
+type fakeRuleset struct {
+	tables []nftables.Table
+	chains []*nftables.Chain
+	rules  []*nftables.Rule
+	// changes are the changes of the last commit.
+	changes []string
+}
+
+func (f *fakeRuleset) Tables(fam nftables.Family) ([]nftables.Table, error) {
+	var ts []nftables.Table
+	for _, t := range f.tables {
+		if fam == 0 || t.Family == fam {
+			ts = append(ts, t)
+		}
+	}
+	return ts, nil
+}
+
+func (f *fakeRuleset) Chains(t nftables.Table) ([]*nftables.Chain, error) {
+	var cs []*nftables.Chain
+	for _, c := range f.chains {
+		if c.Table == t {
+			cs = append(cs, c)
+		}
+	}
+	return cs, nil
+}
+
+func (f *fakeRuleset) Rules(t nftables.Table, chain string) ([]*nftables.Rule, error) {
+	var rs []*nftables.Rule
+	for _, r := range f.rules {
+		if r.Table == t && r.Chain == chain {
+			rs = append(rs, r)
+		}
+	}
+	return rs, nil
+}
+
+func (f *fakeRuleset) Commit(b *nftables.Batch) error {
+	f.changes = nil
+	for _, c := range b.Changes {
+		f.changes = append(f.changes, c.String())
+	}
+	return nil
+}
+
+func TestTokens(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want []string
+		err  error
+	}{
+		{in: "list ruleset", want: []string{"list", "ruleset"}},
+		{
+			in:   "add chain t c { type filter hook input priority 0; }",
+			want: []string{"add", "chain", "t", "c", "{", "type", "filter", "hook", "input", "priority", "0", ";", "}"},
+		},
+		{in: `add rule t c iifname "eth 0" accept # comment`, want: []string{"add", "rule", "t", "c", "iifname", "eth 0", "accept"}},
+		{in: "a\nb;c", want: []string{"a", "\n", "b", ";", "c"}},
+		{in: `iifname "eth0`, err: errArg},
+	} {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := tokens(tt.in)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("tokens(%q) = %v, want %v", tt.in, err, tt.err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("tokens(%q) (-want +got):\n%s", tt.in, diff)
+			}
+		})
+	}
+}
+
+func TestCommands(t *testing.T) {
+	toks, err := tokens("flush ruleset\nadd chain t c { type filter hook input priority 0 ; policy drop ; } ; list tables\n\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"flush", "ruleset"},
+		{"add", "chain", "t", "c", "{", "type", "filter", "hook", "input", "priority", "0", ";", "policy", "drop", ";", "}"},
+		{"list", "tables"},
+	}
+	if diff := cmp.Diff(want, commands(toks)); diff != "" {
+		t.Errorf("commands (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseChain(t *testing.T) {
+	filter := nftables.Table{Family: nftables.Inet, Name: "filter"}
+	for _, tt := range []struct {
+		name string
+		args []string
+		want *nftables.Chain
+		err  error
+	}{
+		{name: "regular", want: &nftables.Chain{Table: filter, Name: "c"}},
+		{
+			name: "base",
+			args: []string{"{", "type", "filter", "hook", "input", "priority", "-10", ";", "policy", "drop", ";", "}"},
+			want: &nftables.Chain{Table: filter, Name: "c", Type: "filter", Hook: nftables.Input, Priority: -10, Policy: nftables.Drop},
+		},
+		{name: "no braces", args: []string{"type", "filter"}, err: errArg},
+		{name: "no hook", args: []string{"{", "type", "filter", "priority", "0", "}"}, err: errArg},
+		{name: "bad hook", args: []string{"{", "type", "filter", "hook", "in", "priority", "0", "}"}, err: errArg},
+		{name: "bad policy", args: []string{"{", "policy", "reject", "}"}, err: errArg},
+		{name: "unknown", args: []string{"{", "device", "eth0", "}"}, err: errArg},
+		{name: "no value", args: []string{"{", "type", "}"}, err: errArg},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := &nftables.Chain{Table: filter, Name: "c"}
+			err := parseChain(got, tt.args)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("parseChain(%q) = %v, want %v", tt.args, err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("parseChain(%q) (-want +got):\n%s", tt.args, diff)
+			}
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	filter := nftables.Table{Family: nftables.Inet, Name: "filter"}
+	nat := nftables.Table{Family: nftables.IPv4, Name: "nat"}
+	_, lan, _ := net.ParseCIDR("10.0.0.0/8")
+	f := &fakeRuleset{
+		tables: []nftables.Table{filter, nat},
+		chains: []*nftables.Chain{
+			{Table: filter, Name: "input", Type: "filter", Hook: nftables.Input, Policy: nftables.Drop},
+			{Table: filter, Name: "lan"},
+		},
+		rules: []*nftables.Rule{
+			{Table: filter, Chain: "input", Handle: 2, IIfName: "lo", Verdict: nftables.Accept},
+			{Table: filter, Chain: "input", Handle: 3, Protocol: nftables.TCP, DstPort: 22, Verdict: nftables.Accept},
+			{Table: filter, Chain: "lan", Handle: 4, Src: lan, Other: true},
+		},
+	}
+
+	for _, tt := range []struct {
+		name    string
+		args    []string
+		handles bool
+		want    string
+		err     error
+	}{
+		{name: "tables", args: []string{"tables"}, want: "table inet filter\ntable ip nat\n"},
+		{
+			name: "ruleset",
+			args: []string{"ruleset"},
+			want: "table inet filter {\n" +
+				"\tchain input {\n" +
+				"\t\ttype filter hook input priority 0; policy drop;\n" +
+				"\t\tiifname \"lo\" accept\n" +
+				"\t\ttcp dport 22 accept\n" +
+				"\t}\n" +
+				"\tchain lan {\n" +
+				"\t\tip saddr 10.0.0.0/8 ...\n" +
+				"\t}\n" +
+				"}\n" +
+				"table ip nat {\n" +
+				"}\n",
+		},
+		{
+			name:    "chain with handles",
+			args:    []string{"chain", "inet", "filter", "input"},
+			handles: true,
+			want: "table inet filter {\n" +
+				"\tchain input {\n" +
+				"\t\ttype filter hook input priority 0; policy drop;\n" +
+				"\t\tiifname \"lo\" accept # handle 2\n" +
+				"\t\ttcp dport 22 accept # handle 3\n" +
+				"\t}\n" +
+				"}\n",
+		},
+		{name: "table", args: []string{"table", "nat"}, want: "table ip nat {\n}\n"},
+		{name: "table not found", args: []string{"table", "filter"}, err: os.ErrNotExist},
+		{name: "chain not found", args: []string{"chain", "inet", "filter", "output"}, want: "table inet filter {\n}\n", err: os.ErrNotExist},
+		{name: "usage", err: errUsage},
+		{name: "unknown", args: []string{"sets"}, err: errArg},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			c := &cmd{rs: f, out: &out, handles: tt.handles}
+			if err := c.list(tt.args); !errors.Is(err, tt.err) {
+				t.Fatalf("list(%q) = %v, want %v", tt.args, err, tt.err)
+			}
+			if diff := cmp.Diff(tt.want, out.String()); diff != "" {
+				t.Errorf("list(%q) (-want +got):\n%s", tt.args, diff)
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rules.nft")
+	if err := os.WriteFile(file, []byte(`flush ruleset
+add table inet filter
+add chain inet filter input { type filter hook input priority 0 ; policy drop ; }
+add rule inet filter input iifname lo accept
+add rule inet filter input tcp dport 22 accept
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		args []string
+		want []string
+		err  error
+	}{
+		{name: "add table", args: []string{"add", "table", "inet", "filter"}, want: []string{"add table inet filter"}},
+		{name: "delete table", args: []string{"delete", "table", "nat"}, want: []string{"delete table ip nat"}},
+		{name: "flush chain", args: []string{"flush", "chain", "ip", "nat", "post"}, want: []string{"flush chain ip nat post"}},
+		{name: "delete rule", args: []string{"delete", "rule", "ip", "nat", "post", "handle", "7"}, want: []string{"delete rule ip nat post"}},
+		{
+			name: "add rule",
+			args: []string{"add", "rule", "inet", "filter", "input", "ip", "saddr", "10.0.0.0/8", "udp", "dport", "53", "accept"},
+			want: []string{"add rule inet filter input"},
+		},
+		{
+			name: "file",
+			args: []string{"-f", file},
+			want: []string{
+				"delete table ip old",
+				"add table inet filter",
+				"add chain inet filter input",
+				"add rule inet filter input",
+				"add rule inet filter input",
+			},
+		},
+		{name: "list", args: []string{"list", "tables"}},
+		{name: "list after change", args: []string{"add table t; list tables"}, err: errArg},
+		{name: "no args", err: errUsage},
+		{name: "file and args", args: []string{"-f", file, "list", "tables"}, err: errUsage},
+		{name: "bad flag", args: []string{"-x"}, err: errUsage},
+		{name: "no chain", args: []string{"add", "rule", "filter"}, err: errUsage},
+		{name: "bad handle", args: []string{"delete", "rule", "t", "c", "handle", "x"}, err: errArg},
+		{name: "unknown object", args: []string{"add", "set", "t", "s"}, err: errArg},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fakeRuleset{tables: []nftables.Table{{Family: nftables.IPv4, Name: "old"}}}
+			c := &cmd{rs: f, out: &bytes.Buffer{}}
+			if err := c.run(tt.args); !errors.Is(err, tt.err) {
+				t.Fatalf("run(%q) = %v, want %v", tt.args, err, tt.err)
+			}
+			if diff := cmp.Diff(tt.want, f.changes); diff != "" {
+				t.Errorf("run(%q) (-want +got):\n%s", tt.args, diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// slaac configures IPv6 on an interface from router advertisements:
+// addresses by stateless autoconfiguration, the default route, and the
+// DNS servers and search list announced with RDNSS and DNSSL.
+//
+// Synopsis:
+//
+//	slaac [OPTIONS...] IFACE
+//
+// Options:
+//
+//	-attempts:    number of router solicitations to send (default: 3)
+//	-n:           only print the advertisement, do not configure it
+//	-resolv-conf: resolv.conf to write the DNS configuration to
+//	              (default: /etc/resolv.conf)
+//	-timeout:     how long to wait for the link to come up (default: 10s)
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"time"
+
+	"github.com/u-root/u-root/pkg/dhclient"
+	"github.com/u-root/u-root/pkg/slaac"
+)
+
+var (
+	attempts   = flag.Int("attempts", slaac.Solicitations, "Number of router solicitations to send")
+	dryRun     = flag.Bool("n", false, "Only print the advertisement, do not configure it")
+	resolvConf = flag.String("resolv-conf", dhclient.ResolvConfPath, "resolv.conf to write the DNS configuration to")
+	timeout    = flag.Duration("timeout", 10*time.Second, "How long to wait for the link to come up")
+)
+
+var errUsage = errors.New("usage: slaac [OPTIONS...] IFACE")
+
+func run(ifname string) error {
+	if _, err := dhclient.IfUp(ifname, *timeout); err != nil {
+		return err
+	}
+	a, err := slaac.Solicit(context.Background(), ifname, *attempts)
+	if err != nil {
+		return err
+	}
+	log.Printf("Router %v on %s: lifetime %v, MTU %d", a.Router, ifname, a.RouterLifetime, a.MTU)
+	for _, addr := range a.Addresses() {
+		log.Printf("Address %v", addr.IPNet)
+	}
+	if a.DNS != nil {
+		log.Printf("DNS %v, search %v", a.DNS, a.Search)
+	}
+	if a.Managed || a.Other {
+		log.Printf("Router announces more configuration from DHCPv6")
+	}
+	if *dryRun {
+		return nil
+	}
+	if err := a.Configure(nil); err != nil {
+		return err
+	}
+	return a.WriteDNS(*resolvConf)
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 1 {
+		log.Fatal(errUsage)
+	}
+	if err := run(flag.Arg(0)); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -6,28 +6,53 @@
 //
 // Synopsis:
 //
-//	srvfiles [--h=HOST] [--p=PORT] [--d=DIR]
+//	srvfiles [--h=HOST] [--p=PORT] [--d=DIR] [--cert=FILE --key=FILE] [--v]
+//
+// Description:
+//
+//	srvfiles serves the files of DIR, e.g. so that neighbors can fetch
+//	kernels and initramfs from a machine being brought up. Range requests,
+//	to resume or parallelize downloads, are supported. Directories are
+//	served by their index.html, or else listed. With --cert and --key,
+//	srvfiles serves HTTPS.
 //
 // Options:
 //
-//	--h: hostname (default: 127.0.0.1)
-//	--p: port number (default: 8080)
-//	--d: directory to serve (default: .)
+//	--h:    hostname (default: 127.0.0.1)
+//	--p:    port number (default: 8080)
+//	--d:    directory to serve (default: .)
+//	--cert: PEM certificate file, to serve HTTPS
+//	--key:  PEM private key file of the certificate
+//	--v:    log requests
 package main
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"html/template"
 	"log"
 	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
 )
 
 var (
-	host = flag.String("h", "127.0.0.1", "hostname")
-	port = flag.String("p", "8080", "port number")
-	dir  = flag.String("d", ".", "directory to serve")
+	host    = flag.String("h", "127.0.0.1", "hostname")
+	port    = flag.String("p", "8080", "port number")
+	dir     = flag.String("d", ".", "directory to serve")
+	cert    = flag.String("cert", "", "PEM certificate file, to serve HTTPS")
+	key     = flag.String("key", "", "PEM private key file of the certificate")
+	verbose = flag.Bool("v", false, "log requests")
 )
 
+var errCertKey = errors.New("--cert and --key must be given together")
+
 var cacheHeaders = []string{
 	"ETag",
 	"If-Modified-Since",
@@ -48,8 +73,183 @@ func maxAgeHandler(h http.Handler) http.Handler {
 	})
 }
 
+// fileHandler serves the files of root, as http.FileServer does, but lists
+// directories with the sizes and modification times of their entries.
+type fileHandler struct {
+	root  http.FileSystem
+	files http.Handler
+}
+
+func newFileHandler(root http.FileSystem) *fileHandler {
+	return &fileHandler{root: root, files: http.FileServer(root)}
+}
+
+type entry struct {
+	Name    string
+	URL     string
+	Size    string
+	ModTime string
+}
+
+var listing = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Index of {{.Path}}</title></head>
+<body>
+<h1>Index of {{.Path}}</h1>
+<table>
+<tr><th align="left">Name</th><th align="right">Size</th><th align="left">Modified</th></tr>
+{{- if ne .Path "/"}}
+<tr><td><a href="../">../</a></td><td></td><td></td></tr>
+{{- end}}
+{{- range .Entries}}
+<tr><td><a href="{{.URL}}">{{.Name}}</a></td><td align="right">{{.Size}}</td><td>{{.ModTime}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+func (h *fileHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	p := path.Clean("/" + r.URL.Path)
+	// http.FileServer redirects directories to their URL with a trailing
+	// slash, and serves their index.html.
+	if !strings.HasSuffix(r.URL.Path, "/") {
+		h.files.ServeHTTP(w, r)
+		return
+	}
+	d, err := h.root.Open(p)
+	if err != nil {
+		h.files.ServeHTTP(w, r)
+		return
+	}
+	defer d.Close()
+	fi, err := d.Stat()
+	if err != nil || !fi.IsDir() {
+		h.files.ServeHTTP(w, r)
+		return
+	}
+	if index, err := h.root.Open(path.Join(p, "index.html")); err == nil {
+		index.Close()
+		h.files.ServeHTTP(w, r)
+		return
+	}
+	h.list(w, r, p, d)
+}
+
+// list lists the entries of the directory d, at p.
+func (h *fileHandler) list(w http.ResponseWriter, r *http.Request, p string, d http.File) {
+	fis, err := d.Readdir(-1)
+	if err != nil {
+		http.Error(w, "error reading directory", http.StatusInternalServerError)
+		return
+	}
+	sort.Slice(fis, func(i, j int) bool { return fis[i].Name() < fis[j].Name() })
+	entries := make([]entry, 0, len(fis))
+	for _, fi := range fis {
+		e := entry{
+			Name:    fi.Name(),
+			ModTime: fi.ModTime().UTC().Format(time.DateTime),
+		}
+		if fi.IsDir() {
+			e.Name += "/"
+			e.Size = "-"
+		} else {
+			e.Size = fmt.Sprint(fi.Size())
+		}
+		// url.URL escapes names like a:b, which would be taken for a scheme.
+		e.URL = (&url.URL{Path: e.Name}).String()
+		entries = append(entries, e)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+	if p != "/" {
+		p += "/"
+	}
+	if err := listing.Execute(w, struct {
+		Path    string
+		Entries []entry
+	}{p, entries}); err != nil {
+		log.Printf("srvfiles: listing %s: %v", p, err)
+	}
+}
+
+// statusWriter records the status and length of responses.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	n      int64
+}
+
+func (s *statusWriter) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusWriter) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.n += int64(n)
+	return n, err
+}
+
+// logRequests logs the requests served by h, and their responses.
+func logRequests(h http.Handler, l *log.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+		start := time.Now()
+		h.ServeHTTP(sw, r)
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		var rng string
+		if v := r.Header.Get("Range"); v != "" {
+			rng = " " + v
+		}
+		l.Printf("%s %s %s%s: %d, %d bytes in %v", r.RemoteAddr, r.Method, r.URL.Path, rng, sw.status, sw.n, time.Since(start).Round(time.Millisecond))
+	})
+}
+
+// serve serves the requests of the connections accepted by l with h, over
+// HTTPS if certFile is set.
+func serve(l net.Listener, h http.Handler, certFile, keyFile string) error {
+	s := &http.Server{Handler: h, ReadHeaderTimeout: 10 * time.Second}
+	var err error
+	if certFile != "" {
+		err = s.ServeTLS(l, certFile, keyFile)
+	} else {
+		err = s.Serve(l)
+	}
+	if errors.Is(err, http.ErrServerClosed) || errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
 func main() {
 	flag.Parse()
-	http.Handle("/", maxAgeHandler(http.FileServer(http.Dir(*dir))))
-	log.Fatal(http.ListenAndServe(net.JoinHostPort(*host, *port), nil))
+	if (*cert == "") != (*key == "") {
+		log.Fatal(errCertKey)
+	}
+	var h http.Handler = maxAgeHandler(newFileHandler(http.Dir(*dir)))
+	if *verbose {
+		h = logRequests(h, log.New(os.Stderr, "srvfiles: ", log.LstdFlags))
+	}
+	l, err := net.Listen("tcp", net.JoinHostPort(*host, *port))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := serve(l, h, *cert, *key); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -5,12 +5,24 @@ package main
 
 import (
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"io"
+	"log"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestSRVFiles(t *testing.T) {
@@ -48,3 +60,180 @@ func TestSRVFiles(t *testing.T) {
 		t.Errorf("Expected %q, got %q", content, b)
 	}
 }
+
+func get(t *testing.T, url string, header http.Header) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(b)
+}
+
+func TestFileHandler(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"vmlinuz":                 "0123456789",
+		"boot/initramfs.cpio":     "initramfs",
+		"boot/a:b":                "colon",
+		"boot/<script>":           "escaped",
+		"site/index.html":         "<p>index</p>",
+		"boot/grub/grub.cfg":      "menuentry",
+		"site/other/not-an-index": "",
+	} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var logs bytes.Buffer
+	s := httptest.NewServer(logRequests(newFileHandler(http.Dir(dir)), log.New(&logs, "", 0)))
+	defer s.Close()
+
+	for _, tt := range []struct {
+		name   string
+		path   string
+		header http.Header
+		status int
+		body   string
+		// contains are substrings of the body, if body is empty.
+		contains []string
+	}{
+		{name: "file", path: "/vmlinuz", status: http.StatusOK, body: "0123456789"},
+		{name: "range", path: "/vmlinuz", header: http.Header{"Range": {"bytes=2-5"}}, status: http.StatusPartialContent, body: "2345"},
+		{name: "open range", path: "/vmlinuz", header: http.Header{"Range": {"bytes=7-"}}, status: http.StatusPartialContent, body: "789"},
+		{name: "bad range", path: "/vmlinuz", header: http.Header{"Range": {"bytes=20-30"}}, status: http.StatusRequestedRangeNotSatisfiable},
+		{name: "missing", path: "/missing", status: http.StatusNotFound},
+		{name: "escape", path: "/../vmlinuz", status: http.StatusOK, body: "0123456789"},
+		{name: "index", path: "/site/", status: http.StatusOK, body: "<p>index</p>"},
+		{name: "redirect", path: "/boot", status: http.StatusOK, contains: []string{"Index of /boot/"}},
+		{name: "listing", path: "/boot/", status: http.StatusOK, contains: []string{
+			`<a href="../">../</a>`,
+			`<a href="./a:b">a:b</a>`,
+			`<a href="grub/">grub/</a></td><td align="right">-</td>`,
+			`<a href="initramfs.cpio">initramfs.cpio</a></td><td align="right">9</td>`,
+			`&lt;script&gt;`,
+		}},
+		{name: "root", path: "/", status: http.StatusOK, contains: []string{"Index of /<", `<a href="vmlinuz">`}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := get(t, s.URL+tt.path, tt.header)
+			if resp.StatusCode != tt.status {
+				t.Fatalf("GET %s = %s, want %d", tt.path, resp.Status, tt.status)
+			}
+			if tt.body != "" && body != tt.body {
+				t.Errorf("GET %s = %q, want %q", tt.path, body, tt.body)
+			}
+			for _, s := range tt.contains {
+				if !strings.Contains(body, s) {
+					t.Errorf("GET %s = %q, want it to contain %q", tt.path, body, s)
+				}
+			}
+		})
+	}
+
+	if strings.Contains(mustGetBody(t, s.URL+"/"), `href="../"`) {
+		t.Errorf("listing of / has a link to its parent")
+	}
+
+	resp, err := http.Post(s.URL+"/vmlinuz", "text/plain", strings.NewReader("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST = %s, want %d", resp.Status, http.StatusMethodNotAllowed)
+	}
+
+	if want := "GET /vmlinuz bytes=2-5: 206, 4 bytes"; !strings.Contains(logs.String(), want) {
+		t.Errorf("logs = %q, want them to contain %q", logs.String(), want)
+	}
+}
+
+func mustGetBody(t *testing.T, url string) string {
+	t.Helper()
+	_, body := get(t, url, nil)
+	return body
+}
+
+// writeCert writes a self-signed certificate for 127.0.0.1, and its key.
+func writeCert(t *testing.T, dir string) (string, string) {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "srvfiles test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return cert, keyFile
+}
+
+func TestServeTLS(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "initrd"), []byte("initrd"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cert, key := writeCert(t, t.TempDir())
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("no loopback: %v", err)
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- serve(l, newFileHandler(http.Dir(dir)), cert, key) }()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	resp, err := client.Get("https://" + l.Addr().String() + "/initrd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(b) != "initrd" {
+		t.Errorf("GET /initrd = %q, %v, want %q", b, err, "initrd")
+	}
+	if resp.TLS == nil {
+		t.Errorf("GET /initrd was not over TLS")
+	}
+
+	l.Close()
+	if err := <-errc; err != nil {
+		t.Errorf("serve = %v, want nil once closed", err)
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// inet_diag, see linux/inet_diag.h and linux/sock_diag.h.
+const (
+	sockDiagByFamily = 20
+	// Sizes of struct inet_diag_req_v2 and struct inet_diag_msg.
+	diagReqSize = 56
+	diagMsgSize = 72
+	sockIDSize  = 48
+)
+
+// TCP states, as in include/net/tcp_states.h, on which UDP sockets are
+// established when connected and closed otherwise.
+const (
+	tcpEstablished = 1 + iota
+	tcpSynSent
+	tcpSynRecv
+	tcpFinWait1
+	tcpFinWait2
+	tcpTimeWait
+	tcpClose
+	tcpCloseWait
+	tcpLastAck
+	tcpListen
+	tcpClosing
+	tcpNewSynRecv
+)
+
+var stateNames = map[uint8]string{
+	tcpEstablished: "ESTAB",
+	tcpSynSent:     "SYN-SENT",
+	tcpSynRecv:     "SYN-RECV",
+	tcpFinWait1:    "FIN-WAIT-1",
+	tcpFinWait2:    "FIN-WAIT-2",
+	tcpTimeWait:    "TIME-WAIT",
+	tcpClose:       "UNCONN",
+	tcpCloseWait:   "CLOSE-WAIT",
+	tcpLastAck:     "LAST-ACK",
+	tcpListen:      "LISTEN",
+	tcpClosing:     "CLOSING",
+	tcpNewSynRecv:  "SYN-RECV",
+}
+
+var errDiag = errors.New("invalid inet_diag message")
+
+// socket is an inet socket, as reported by inet_diag.
+type socket struct {
+	protocol uint8
+	state    uint8
+	local    netip.AddrPort
+	remote   netip.AddrPort
+	// iface is the index of the interface the socket is bound to, 0 for none.
+	iface  uint32
+	rqueue uint32
+	wqueue uint32
+	uid    uint32
+	inode  uint32
+}
+
+// stateName returns the name ss gives to the state of s.
+func (s *socket) stateName() string {
+	if n, ok := stateNames[s.state]; ok {
+		return n
+	}
+	return fmt.Sprintf("UNKNOWN-%d", s.state)
+}
+
+// request returns the netlink message requesting a dump of the sockets of
+// family and protocol in any of states, a bitmask of 1<<state.
+func request(family, protocol uint8, states uint32, seq uint32) []byte {
+	b := make([]byte, 0, unix.NLMSG_HDRLEN+diagReqSize)
+	b = binary.NativeEndian.AppendUint32(b, unix.NLMSG_HDRLEN+diagReqSize)
+	b = binary.NativeEndian.AppendUint16(b, sockDiagByFamily)
+	b = binary.NativeEndian.AppendUint16(b, unix.NLM_F_REQUEST|unix.NLM_F_DUMP)
+	b = binary.NativeEndian.AppendUint32(b, seq)
+	b = binary.NativeEndian.AppendUint32(b, 0)
+	// struct inet_diag_req_v2: family, protocol, ext, pad, states and
+	// a socket id left zero, which does not filter on a dump.
+	b = append(b, family, protocol, 0, 0)
+	b = binary.NativeEndian.AppendUint32(b, states)
+	return append(b, make([]byte, sockIDSize)...)
+}
+
+func addrPort(family uint8, addr []byte, port []byte) netip.AddrPort {
+	var a netip.Addr
+	if family == unix.AF_INET {
+		a = netip.AddrFrom4([4]byte(addr[:4]))
+	} else {
+		a = netip.AddrFrom16([16]byte(addr[:16]))
+	}
+	// Ports are in network byte order, the rest in host order.
+	return netip.AddrPortFrom(a, binary.BigEndian.Uint16(port))
+}
+
+// parseMsg parses a struct inet_diag_msg.
+func parseMsg(protocol uint8, b []byte) (*socket, error) {
+	if len(b) < diagMsgSize {
+		return nil, fmt.Errorf("%w: %d bytes", errDiag, len(b))
+	}
+	family := b[0]
+	if family != unix.AF_INET && family != unix.AF_INET6 {
+		return nil, fmt.Errorf("%w: family %d", errDiag, family)
+	}
+	id := b[4 : 4+sockIDSize]
+	return &socket{
+		protocol: protocol,
+		state:    b[1],
+		local:    addrPort(family, id[4:20], id[0:2]),
+		remote:   addrPort(family, id[20:36], id[2:4]),
+		iface:    binary.NativeEndian.Uint32(id[36:]),
+		rqueue:   binary.NativeEndian.Uint32(b[56:]),
+		wqueue:   binary.NativeEndian.Uint32(b[60:]),
+		uid:      binary.NativeEndian.Uint32(b[64:]),
+		inode:    binary.NativeEndian.Uint32(b[68:]),
+	}, nil
+}
+
+// parseMessages parses netlink messages answering a dump request, and
+// reports whether the dump is done.
+func parseMessages(protocol uint8, b []byte) ([]*socket, bool, error) {
+	msgs, err := syscall.ParseNetlinkMessage(b)
+	if err != nil {
+		return nil, false, err
+	}
+	var socks []*socket
+	for _, m := range msgs {
+		switch m.Header.Type {
+		case unix.NLMSG_DONE:
+			return socks, true, nil
+		case unix.NLMSG_ERROR:
+			if len(m.Data) < 4 {
+				return nil, false, fmt.Errorf("%w: truncated error", errDiag)
+			}
+			if errno := -int32(binary.NativeEndian.Uint32(m.Data)); errno != 0 {
+				return nil, false, syscall.Errno(errno)
+			}
+		case sockDiagByFamily:
+			s, err := parseMsg(protocol, m.Data)
+			if err != nil {
+				return nil, false, err
+			}
+			socks = append(socks, s)
+		}
+	}
+	return socks, false, nil
+}
+
+// dump returns the sockets of family and protocol in any of states.
+func dump(family, protocol uint8, states uint32) ([]*socket, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, unix.NETLINK_SOCK_DIAG)
+	if err != nil {
+		return nil, err
+	}
+	defer unix.Close(fd)
+
+	if err := unix.Sendto(fd, request(family, protocol, states, 1), 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, err
+	}
+	var socks []*socket
+	b := make([]byte, 32*1024)
+	for {
+		n, _, err := unix.Recvfrom(fd, b, 0)
+		if err != nil {
+			return nil, err
+		}
+		s, done, err := parseMessages(protocol, b[:n])
+		if err != nil {
+			return nil, err
+		}
+		socks = append(socks, s...)
+		if done {
+			return socks, nil
+		}
+	}
+}
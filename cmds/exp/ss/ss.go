@@ -0,0 +1,236 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+// ss lists the TCP and UDP sockets, as the kernel reports them over
+// netlink.
+//
+// Synopsis:
+//
+//	ss [-t] [-u] [-l|-a] [-p] [-4|-6]
+//
+// Description:
+//
+//	Without -l or -a, ss lists the sockets which are connected. Without -t
+//	or -u, it lists both TCP and UDP sockets.
+//
+//	Recv-Q and Send-Q are the bytes queued, and for listening TCP sockets the
+//	connections waiting to be accepted and the most there may be.
+//
+// Options:
+//
+//	-t: TCP sockets
+//	-u: UDP sockets
+//	-l: listening sockets only, including unconnected UDP sockets
+//	-a: all sockets
+//	-p: show the processes owning the sockets
+//	-4: IPv4 sockets only
+//	-6: IPv6 sockets only
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/sys/unix"
+)
+
+var errUsage = errors.New("usage: ss [-t] [-u] [-l|-a] [-p] [-4|-6]")
+
+// procFS is where the processes are looked up.
+var procFS = "/proc"
+
+type cmd struct {
+	out       io.Writer
+	protocols []uint8
+	families  []uint8
+	states    uint32
+	processes bool
+	// dump returns the sockets, see dump.
+	dump func(family, protocol uint8, states uint32) ([]*socket, error)
+}
+
+// owner is a file descriptor of a process.
+type owner struct {
+	name string
+	pid  int
+	fd   int
+}
+
+// owners returns the processes holding each socket inode. Processes which
+// cannot be looked into are skipped.
+func owners() (map[uint32][]owner, error) {
+	procs, err := os.ReadDir(procFS)
+	if err != nil {
+		return nil, err
+	}
+	m := map[uint32][]owner{}
+	for _, p := range procs {
+		pid, err := strconv.Atoi(p.Name())
+		if err != nil {
+			continue
+		}
+		fds, err := os.ReadDir(filepath.Join(procFS, p.Name(), "fd"))
+		if err != nil {
+			continue
+		}
+		comm, _ := os.ReadFile(filepath.Join(procFS, p.Name(), "comm"))
+		name := strings.TrimSuffix(string(comm), "\n")
+		for _, f := range fds {
+			link, err := os.Readlink(filepath.Join(procFS, p.Name(), "fd", f.Name()))
+			if err != nil {
+				continue
+			}
+			v, ok := strings.CutPrefix(link, "socket:[")
+			if !ok {
+				continue
+			}
+			inode, err := strconv.ParseUint(strings.TrimSuffix(v, "]"), 10, 32)
+			if err != nil {
+				continue
+			}
+			fd, _ := strconv.Atoi(f.Name())
+			m[uint32(inode)] = append(m[uint32(inode)], owner{name: name, pid: pid, fd: fd})
+		}
+	}
+	return m, nil
+}
+
+func users(o []owner) string {
+	var s []string
+	for _, u := range o {
+		s = append(s, fmt.Sprintf("(%q,pid=%d,fd=%d)", u.name, u.pid, u.fd))
+	}
+	return "users:(" + strings.Join(s, ",") + ")"
+}
+
+// address formats an address like ss: IPv6 addresses in brackets, and
+// wildcards as *.
+func address(ap netip.AddrPort) string {
+	a := ap.Addr().Unmap().String()
+	if ap.Addr().IsUnspecified() {
+		a = "*"
+	} else if ap.Addr().Is6() && !ap.Addr().Is4In6() {
+		a = "[" + a + "]"
+	}
+	port := "*"
+	if ap.Port() != 0 {
+		port = strconv.Itoa(int(ap.Port()))
+	}
+	return a + ":" + port
+}
+
+var protocolNames = map[uint8]string{
+	unix.IPPROTO_TCP: "tcp",
+	unix.IPPROTO_UDP: "udp",
+}
+
+func (c *cmd) run() error {
+	var socks []*socket
+	for _, p := range c.protocols {
+		for _, f := range c.families {
+			s, err := c.dump(f, p, c.states)
+			if err != nil {
+				return fmt.Errorf("%s: %w", protocolNames[p], err)
+			}
+			socks = append(socks, s...)
+		}
+	}
+	sort.SliceStable(socks, func(i, j int) bool {
+		if socks[i].protocol != socks[j].protocol {
+			return socks[i].protocol < socks[j].protocol
+		}
+		if c := socks[i].local.Addr().Compare(socks[j].local.Addr()); c != 0 {
+			return c < 0
+		}
+		return socks[i].local.Port() < socks[j].local.Port()
+	})
+
+	var procs map[uint32][]owner
+	if c.processes {
+		var err error
+		if procs, err = owners(); err != nil {
+			return err
+		}
+	}
+
+	w := tabwriter.NewWriter(c.out, 0, 8, 1, ' ', 0)
+	header := "Netid\tState\tRecv-Q\tSend-Q\tLocal Address:Port\tPeer Address:Port"
+	if c.processes {
+		header += "\tProcess"
+	}
+	fmt.Fprintln(w, header)
+	for _, s := range socks {
+		line := fmt.Sprintf("%s\t%s\t%d\t%d\t%s\t%s", protocolNames[s.protocol], s.stateName(), s.rqueue, s.wqueue, address(s.local), address(s.remote))
+		if c.processes {
+			line += "\t"
+			if o, ok := procs[s.inode]; ok {
+				line += users(o)
+			}
+		}
+		fmt.Fprintln(w, line)
+	}
+	return w.Flush()
+}
+
+func parse(args []string, out io.Writer) (*cmd, error) {
+	f := flag.NewFlagSet("ss", flag.ContinueOnError)
+	tcp := f.Bool("t", false, "TCP sockets")
+	udp := f.Bool("u", false, "UDP sockets")
+	listening := f.Bool("l", false, "Listening sockets only, including unconnected UDP sockets")
+	all := f.Bool("a", false, "All sockets")
+	processes := f.Bool("p", false, "Show the processes owning the sockets")
+	v4 := f.Bool("4", false, "IPv4 sockets only")
+	v6 := f.Bool("6", false, "IPv6 sockets only")
+	if err := f.Parse(args); err != nil {
+		return nil, err
+	}
+	if f.NArg() > 0 || (*listening && *all) || (*v4 && *v6) {
+		return nil, errUsage
+	}
+
+	c := &cmd{out: out, processes: *processes, dump: dump}
+	if *tcp || !*udp {
+		c.protocols = append(c.protocols, unix.IPPROTO_TCP)
+	}
+	if *udp || !*tcp {
+		c.protocols = append(c.protocols, unix.IPPROTO_UDP)
+	}
+	if !*v6 {
+		c.families = append(c.families, unix.AF_INET)
+	}
+	if !*v4 {
+		c.families = append(c.families, unix.AF_INET6)
+	}
+	switch {
+	case *all:
+		c.states = ^uint32(0)
+	case *listening:
+		c.states = 1<<tcpListen | 1<<tcpClose
+	default:
+		c.states = ^uint32(1<<tcpListen | 1<<tcpClose | 1<<tcpSynRecv | 1<<tcpTimeWait)
+	}
+	return c, nil
+}
+
+func main() {
+	c, err := parse(os.Args[1:], os.Stdout)
+	if err == nil {
+		err = c.run()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// message returns a netlink message of type typ carrying body.
+func message(typ uint16, body []byte) []byte {
+	b := binary.NativeEndian.AppendUint32(nil, uint32(unix.NLMSG_HDRLEN+len(body)))
+	b = binary.NativeEndian.AppendUint16(b, typ)
+	b = binary.NativeEndian.AppendUint16(b, unix.NLM_F_MULTI)
+	b = binary.NativeEndian.AppendUint32(b, 1)
+	b = binary.NativeEndian.AppendUint32(b, 0)
+	b = append(b, body...)
+	return append(b, make([]byte, (4-len(body)%4)%4)...)
+}
+
+// diagMsg returns a struct inet_diag_msg.
+func diagMsg(s *socket) []byte {
+	family := byte(unix.AF_INET6)
+	if s.local.Addr().Is4() {
+		family = unix.AF_INET
+	}
+	b := []byte{family, s.state, 0, 0}
+	b = binary.BigEndian.AppendUint16(b, s.local.Port())
+	b = binary.BigEndian.AppendUint16(b, s.remote.Port())
+	for _, a := range []netip.Addr{s.local.Addr(), s.remote.Addr()} {
+		addr := make([]byte, 16)
+		copy(addr, a.AsSlice())
+		b = append(b, addr...)
+	}
+	b = binary.NativeEndian.AppendUint32(b, s.iface)
+	// Cookie and expiry.
+	b = append(b, make([]byte, 12)...)
+	for _, v := range []uint32{s.rqueue, s.wqueue, s.uid, s.inode} {
+		b = binary.NativeEndian.AppendUint32(b, v)
+	}
+	return b
+}
+
+func TestParseMessages(t *testing.T) {
+	want := []*socket{
+		{
+			protocol: unix.IPPROTO_TCP,
+			state:    tcpListen,
+			local:    netip.MustParseAddrPort("0.0.0.0:22"),
+			remote:   netip.MustParseAddrPort("0.0.0.0:0"),
+			wqueue:   128,
+			inode:    1234,
+		},
+		{
+			protocol: unix.IPPROTO_TCP,
+			state:    tcpEstablished,
+			local:    netip.MustParseAddrPort("[2001:db8::1]:22"),
+			remote:   netip.MustParseAddrPort("[2001:db8::2]:50000"),
+			iface:    2,
+			rqueue:   10,
+			wqueue:   20,
+			uid:      1000,
+			inode:    5678,
+		},
+	}
+	var b []byte
+	for _, s := range want {
+		b = append(b, message(sockDiagByFamily, diagMsg(s))...)
+	}
+
+	got, done, err := parseMessages(unix.IPPROTO_TCP, b)
+	if err != nil || done {
+		t.Fatalf("parseMessages() = %v, %v, want more to come", done, err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseMessages() = %+v, want %+v", got, want)
+	}
+
+	got, done, err = parseMessages(unix.IPPROTO_TCP, append(b, message(unix.NLMSG_DONE, []byte{0, 0, 0, 0})...))
+	if err != nil || !done || len(got) != 2 {
+		t.Errorf("parseMessages() = %d sockets, %v, %v, want 2 and done", len(got), done, err)
+	}
+
+	errno := binary.NativeEndian.AppendUint32(nil, ^uint32(unix.EINVAL)+1)
+	if _, _, err := parseMessages(unix.IPPROTO_TCP, message(unix.NLMSG_ERROR, append(errno, make([]byte, 16)...))); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("parseMessages() of error = %v, want %v", err, syscall.EINVAL)
+	}
+	if _, _, err := parseMessages(unix.IPPROTO_TCP, message(sockDiagByFamily, make([]byte, 10))); !errors.Is(err, errDiag) {
+		t.Errorf("parseMessages() of short message = %v, want %v", err, errDiag)
+	}
+}
+
+func TestParse(t *testing.T) {
+	all := ^uint32(0)
+	for _, tt := range []struct {
+		args    []string
+		want    cmd
+		wantErr bool
+	}{
+		{
+			want: cmd{
+				protocols: []uint8{unix.IPPROTO_TCP, unix.IPPROTO_UDP},
+				families:  []uint8{unix.AF_INET, unix.AF_INET6},
+				states:    ^uint32(1<<tcpListen | 1<<tcpClose | 1<<tcpSynRecv | 1<<tcpTimeWait),
+			},
+		},
+		{
+			args: []string{"-t", "-l", "-4", "-p"},
+			want: cmd{
+				protocols: []uint8{unix.IPPROTO_TCP},
+				families:  []uint8{unix.AF_INET},
+				states:    1<<tcpListen | 1<<tcpClose,
+				processes: true,
+			},
+		},
+		{
+			args: []string{"-u", "-a", "-6"},
+			want: cmd{protocols: []uint8{unix.IPPROTO_UDP}, families: []uint8{unix.AF_INET6}, states: all},
+		},
+		{args: []string{"-l", "-a"}, wantErr: true},
+		{args: []string{"-4", "-6"}, wantErr: true},
+		{args: []string{"sport", "22"}, wantErr: true},
+	} {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			c, err := parse(tt.args, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parse() = %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			c.dump = nil
+			if !reflect.DeepEqual(*c, tt.want) {
+				t.Errorf("parse() = %+v, want %+v", *c, tt.want)
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	procFS = t.TempDir()
+	defer func() { procFS = "/proc" }()
+	for _, p := range []struct {
+		pid, comm string
+		fds       map[string]string
+	}{
+		{pid: "1", comm: "init\n", fds: map[string]string{"0": "/dev/console", "3": "socket:[1234]"}},
+		{pid: "42", comm: "dhcpd\n", fds: map[string]string{"7": "socket:[5678]", "8": "pipe:[5678]"}},
+		{pid: "self", comm: "ss\n"},
+	} {
+		fd := filepath.Join(procFS, p.pid, "fd")
+		if err := os.MkdirAll(fd, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(procFS, p.pid, "comm"), []byte(p.comm), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		for n, target := range p.fds {
+			if err := os.Symlink(target, filepath.Join(fd, n)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	socks := map[uint8][]*socket{
+		unix.AF_INET: {
+			{protocol: unix.IPPROTO_UDP, state: tcpClose, local: netip.MustParseAddrPort("0.0.0.0:67"), remote: netip.MustParseAddrPort("0.0.0.0:0"), inode: 5678},
+		},
+		unix.AF_INET6: {
+			{protocol: unix.IPPROTO_TCP, state: tcpListen, local: netip.MustParseAddrPort("[::]:22"), remote: netip.MustParseAddrPort("[::]:0"), wqueue: 128, inode: 1234},
+			{protocol: unix.IPPROTO_TCP, state: tcpEstablished, local: netip.MustParseAddrPort("[::ffff:192.0.2.1]:22"), remote: netip.MustParseAddrPort("[::ffff:192.0.2.2]:50000"), wqueue: 36, inode: 99},
+		},
+	}
+	var out bytes.Buffer
+	c := &cmd{
+		out:       &out,
+		protocols: []uint8{unix.IPPROTO_TCP, unix.IPPROTO_UDP},
+		families:  []uint8{unix.AF_INET, unix.AF_INET6},
+		processes: true,
+		dump: func(family, protocol uint8, states uint32) ([]*socket, error) {
+			var s []*socket
+			for _, sock := range socks[family] {
+				if sock.protocol == protocol {
+					s = append(s, sock)
+				}
+			}
+			return s, nil
+		},
+	}
+	if err := c.run(); err != nil {
+		t.Fatal(err)
+	}
+	want := `Netid State  Recv-Q Send-Q Local Address:Port Peer Address:Port Process
+tcp   LISTEN 0      128    *:22               *:*               users:(("init",pid=1,fd=3))
+tcp   ESTAB  0      36     192.0.2.1:22       192.0.2.2:50000   
+udp   UNCONN 0      0      *:67               *:*               users:(("dhcpd",pid=42,fd=7))
+`
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+
+	c.dump = func(family, protocol uint8, states uint32) ([]*socket, error) {
+		return nil, syscall.EPROTONOSUPPORT
+	}
+	if err := c.run(); !errors.Is(err, syscall.EPROTONOSUPPORT) {
+		t.Errorf("run() = %v, want %v", err, syscall.EPROTONOSUPPORT)
+	}
+}
+
+func TestDump(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	u, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer u.Close()
+
+	for _, tt := range []struct {
+		protocol uint8
+		addr     net.Addr
+		state    uint8
+	}{
+		{protocol: unix.IPPROTO_TCP, addr: l.Addr(), state: tcpListen},
+		{protocol: unix.IPPROTO_UDP, addr: u.LocalAddr(), state: tcpClose},
+	} {
+		socks, err := dump(unix.AF_INET, tt.protocol, 1<<tcpListen|1<<tcpClose)
+		if errors.Is(err, syscall.EPROTONOSUPPORT) || errors.Is(err, syscall.ENOENT) {
+			t.Skipf("no inet_diag: %v", err)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := netip.MustParseAddrPort(tt.addr.String())
+		found := false
+		for _, s := range socks {
+			if s.local == want {
+				found = true
+				if s.state != tt.state || s.inode == 0 {
+					t.Errorf("socket %+v, want state %d and an inode", s, tt.state)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("%v not in %d sockets", want, len(socks))
+		}
+	}
+}
@@ -0,0 +1,574 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+// tc shows and changes the queueing disciplines of interfaces, to delay,
+// drop or rate limit their traffic.
+//
+// Synopsis:
+//
+//	tc qdisc show [dev DEV]
+//	tc qdisc {add | change | replace} dev DEV {root | parent ID} [handle ID] QDISC
+//	tc qdisc del dev DEV {root | parent ID} [handle ID]
+//
+//	QDISC := netem [delay TIME [JITTER [CORRELATION]]] [loss PERCENT [CORRELATION]]
+//			[duplicate PERCENT [CORRELATION]] [corrupt PERCENT [CORRELATION]]
+//			[reorder PERCENT [CORRELATION] [gap DISTANCE]] [limit PACKETS]
+//		| tbf rate RATE burst SIZE {latency TIME | limit SIZE}
+//			[peakrate RATE mtu SIZE]
+//
+// Description:
+//
+//	tc attaches the netem qdisc, which delays, drops, duplicates, corrupts
+//	and reorders packets, and the tbf qdisc, a token bucket filter limiting
+//	the rate of traffic, like tc(8) of iproute2.
+//
+//	TIME is in microseconds unless followed by s, ms or us. RATE is in bytes
+//	a second unless followed by bit, kbit, mbit, gbit, or bps, kbps, mbps,
+//	gbps for bytes; the k, m and g of these are powers of 1000, those of
+//	kibit, kibps and such powers of 1024. SIZE is in bytes unless followed
+//	by k, m, or g, powers of 1024, or kbit, mbit, gbit. PERCENT and
+//	CORRELATION are percentages, with or without a %.
+//
+//	For example, to delay the packets sent by eth0 by 100ms, give or take
+//	10ms, and lose 1% of them:
+//
+//	tc qdisc add dev eth0 root netem delay 100ms 10ms loss 1%
+//
+//	and to limit them to 1mbit a second instead:
+//
+//	tc qdisc replace dev eth0 root tbf rate 1mbit burst 32k latency 400ms
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+)
+
+var (
+	errUsage  = errors.New("usage: tc qdisc show [dev DEV] | tc qdisc {add | change | replace | del} dev DEV {root | parent ID} [handle ID] [QDISC]")
+	errArg    = errors.New("invalid argument")
+	errNoDev  = errors.New("no device given")
+	errParent = errors.New("no parent given, root or parent ID")
+)
+
+// qdiscs are the netlink operations tc uses, which netlink.Handle
+// implements.
+type qdiscs interface {
+	LinkByName(name string) (netlink.Link, error)
+	LinkByIndex(index int) (netlink.Link, error)
+	QdiscList(link netlink.Link) ([]netlink.Qdisc, error)
+	QdiscAdd(qdisc netlink.Qdisc) error
+	QdiscChange(qdisc netlink.Qdisc) error
+	QdiscReplace(qdisc netlink.Qdisc) error
+	QdiscDel(qdisc netlink.Qdisc) error
+}
+
+type cmd struct {
+	h   qdiscs
+	out io.Writer
+}
+
+// parseTime parses a TIME, returning it in microseconds.
+func parseTime(s string) (uint32, error) {
+	num, scale := s, 1.0
+	for _, u := range []struct {
+		suffix string
+		scale  float64
+	}{
+		{"usecs", 1}, {"usec", 1}, {"us", 1},
+		{"msecs", 1e3}, {"msec", 1e3}, {"ms", 1e3},
+		{"secs", 1e6}, {"sec", 1e6}, {"s", 1e6},
+	} {
+		if strings.HasSuffix(s, u.suffix) {
+			num, scale = strings.TrimSuffix(s, u.suffix), u.scale
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(num, 64)
+	if err != nil || v < 0 || v*scale > math.MaxUint32 {
+		return 0, fmt.Errorf("%w: time %q", errArg, s)
+	}
+	return uint32(v * scale), nil
+}
+
+// rateUnits scale rates to bits a second.
+var rateUnits = []struct {
+	suffix string
+	scale  float64
+}{
+	{"kibit", 1 << 10}, {"mibit", 1 << 20}, {"gibit", 1 << 30},
+	{"kbit", 1e3}, {"mbit", 1e6}, {"gbit", 1e9}, {"bit", 1},
+	{"kibps", 8 << 10}, {"mibps", 8 << 20}, {"gibps", 8 << 30},
+	{"kbps", 8e3}, {"mbps", 8e6}, {"gbps", 8e9}, {"bps", 8},
+}
+
+// parseRate parses a RATE, returning it in bytes a second.
+func parseRate(s string) (uint64, error) {
+	num, scale := s, 8.0
+	for _, u := range rateUnits {
+		if strings.HasSuffix(strings.ToLower(s), u.suffix) {
+			num, scale = s[:len(s)-len(u.suffix)], u.scale
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(num, 64)
+	if err != nil || v <= 0 || v*scale/8 > math.MaxUint64 {
+		return 0, fmt.Errorf("%w: rate %q", errArg, s)
+	}
+	return uint64(v * scale / 8), nil
+}
+
+// parseSize parses a SIZE, returning it in bytes.
+func parseSize(s string) (uint32, error) {
+	num, scale := s, 1.0
+	for _, u := range []struct {
+		suffix string
+		scale  float64
+	}{
+		{"kbit", 1 << 7}, {"mbit", 1 << 17}, {"gbit", 1 << 27},
+		{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30},
+		{"k", 1 << 10}, {"m", 1 << 20}, {"g", 1 << 30}, {"b", 1},
+	} {
+		if strings.HasSuffix(strings.ToLower(s), u.suffix) {
+			num, scale = s[:len(s)-len(u.suffix)], u.scale
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(num, 64)
+	if err != nil || v < 0 || v*scale > math.MaxUint32 {
+		return 0, fmt.Errorf("%w: size %q", errArg, s)
+	}
+	return uint32(v * scale), nil
+}
+
+func parsePercent(s string) (float32, error) {
+	v, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 32)
+	if err != nil || v < 0 || v > 100 {
+		return 0, fmt.Errorf("%w: percentage %q", errArg, s)
+	}
+	return float32(v), nil
+}
+
+// parseHandle parses a handle, MAJOR:[MINOR] in hexadecimal.
+func parseHandle(s string) (uint32, error) {
+	maj, min, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, fmt.Errorf("%w: handle %q", errArg, s)
+	}
+	major, err := strconv.ParseUint(maj, 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("%w: handle %q", errArg, s)
+	}
+	var minor uint64
+	if min != "" {
+		if minor, err = strconv.ParseUint(min, 16, 16); err != nil {
+			return 0, fmt.Errorf("%w: handle %q", errArg, s)
+		}
+	}
+	return netlink.MakeHandle(uint16(major), uint16(minor)), nil
+}
+
+// optional pops the next argument if it parses, for the optional values
+// of netem.
+func optional(args *[]string, parse func(string) error) {
+	if len(*args) > 0 && parse((*args)[0]) == nil {
+		*args = (*args)[1:]
+	}
+}
+
+func parseNetem(attrs netlink.QdiscAttrs, args []string) (netlink.Qdisc, error) {
+	var n netlink.NetemQdiscAttrs
+	// percent parses a PERCENT and an optional CORRELATION.
+	percent := func(p, corr *float32) error {
+		if len(args) == 0 {
+			return fmt.Errorf("%w: missing percentage", errArg)
+		}
+		v, err := parsePercent(args[0])
+		if err != nil {
+			return err
+		}
+		*p, args = v, args[1:]
+		optional(&args, func(s string) (err error) {
+			*corr, err = parsePercent(s)
+			return err
+		})
+		return nil
+	}
+
+	for len(args) > 0 {
+		opt := args[0]
+		args = args[1:]
+
+		var err error
+		switch opt {
+		case "delay", "latency":
+			if len(args) == 0 {
+				return nil, fmt.Errorf("%w: missing delay", errArg)
+			}
+			if n.Latency, err = parseTime(args[0]); err != nil {
+				return nil, err
+			}
+			args = args[1:]
+			optional(&args, func(s string) (err error) {
+				n.Jitter, err = parseTime(s)
+				return err
+			})
+			if n.Jitter != 0 {
+				optional(&args, func(s string) (err error) {
+					n.DelayCorr, err = parsePercent(s)
+					return err
+				})
+			}
+		case "loss":
+			// Only the random loss model.
+			if len(args) > 0 && args[0] == "random" {
+				args = args[1:]
+			}
+			err = percent(&n.Loss, &n.LossCorr)
+		case "duplicate":
+			err = percent(&n.Duplicate, &n.DuplicateCorr)
+		case "corrupt":
+			err = percent(&n.CorruptProb, &n.CorruptCorr)
+		case "reorder":
+			err = percent(&n.ReorderProb, &n.ReorderCorr)
+		case "gap", "limit":
+			if len(args) == 0 {
+				return nil, fmt.Errorf("%w: missing %s", errArg, opt)
+			}
+			v, perr := strconv.ParseUint(args[0], 10, 32)
+			if perr != nil {
+				return nil, fmt.Errorf("%w: %s %q", errArg, opt, args[0])
+			}
+			args = args[1:]
+			if opt == "gap" {
+				n.Gap = uint32(v)
+			} else {
+				n.Limit = uint32(v)
+			}
+		default:
+			return nil, fmt.Errorf("%w: netem %q", errArg, opt)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if n.ReorderProb != 0 && n.Latency == 0 {
+		return nil, fmt.Errorf("%w: reordering needs a delay", errArg)
+	}
+	return netlink.NewNetem(attrs, n), nil
+}
+
+func parseTbf(attrs netlink.QdiscAttrs, args []string) (netlink.Qdisc, error) {
+	t := &netlink.Tbf{QdiscAttrs: attrs}
+	var burst, mtu, latency uint32
+	var hasLatency bool
+
+	for len(args) > 0 {
+		if len(args) < 2 {
+			return nil, fmt.Errorf("%w: tbf %s needs a value", errArg, args[0])
+		}
+		opt, v := args[0], args[1]
+		args = args[2:]
+
+		var err error
+		switch opt {
+		case "rate":
+			t.Rate, err = parseRate(v)
+		case "peakrate":
+			t.Peakrate, err = parseRate(v)
+		case "burst", "buffer", "maxburst":
+			burst, err = parseSize(v)
+		case "mtu", "minburst":
+			mtu, err = parseSize(v)
+		case "limit":
+			t.Limit, err = parseSize(v)
+		case "latency":
+			latency, err = parseTime(v)
+			hasLatency = true
+		default:
+			return nil, fmt.Errorf("%w: tbf %q", errArg, opt)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case t.Rate == 0 || burst == 0:
+		return nil, fmt.Errorf("%w: tbf needs a rate and a burst", errArg)
+	case (t.Limit != 0) == hasLatency:
+		return nil, fmt.Errorf("%w: tbf needs either a limit or a latency", errArg)
+	case t.Peakrate != 0 && mtu == 0:
+		return nil, fmt.Errorf("%w: tbf peakrate needs an mtu", errArg)
+	}
+
+	if hasLatency {
+		// The queue holds what can be sent in the latency, plus the burst.
+		limit := float64(t.Rate)*float64(latency)/1e6 + float64(burst)
+		if t.Peakrate != 0 {
+			limit = math.Min(limit, float64(t.Peakrate)*float64(latency)/1e6+float64(mtu))
+		}
+		t.Limit = uint32(limit)
+	}
+	t.Buffer = netlink.Xmittime(t.Rate, burst)
+	t.Minburst = mtu
+	return t, nil
+}
+
+// parseQdisc parses the arguments of a qdisc command after the command.
+// The kind and its arguments are optional when deleting.
+func (c *cmd) parseQdisc(args []string, del bool) (netlink.Qdisc, error) {
+	var attrs netlink.QdiscAttrs
+	for len(args) > 0 {
+		opt := args[0]
+		args = args[1:]
+		if opt == "root" {
+			attrs.Parent = netlink.HANDLE_ROOT
+			continue
+		}
+		if opt == "netem" || opt == "tbf" {
+			if attrs.LinkIndex == 0 {
+				return nil, errNoDev
+			}
+			if attrs.Parent == 0 {
+				return nil, errParent
+			}
+			if del {
+				return &netlink.GenericQdisc{QdiscAttrs: attrs, QdiscType: opt}, nil
+			}
+			if opt == "netem" {
+				return parseNetem(attrs, args)
+			}
+			return parseTbf(attrs, args)
+		}
+
+		if len(args) == 0 {
+			return nil, fmt.Errorf("%w: %s needs a value", errArg, opt)
+		}
+		v := args[0]
+		args = args[1:]
+
+		var err error
+		switch opt {
+		case "dev":
+			var link netlink.Link
+			if link, err = c.h.LinkByName(v); err == nil {
+				attrs.LinkIndex = link.Attrs().Index
+			}
+		case "parent":
+			attrs.Parent, err = parseHandle(v)
+		case "handle":
+			attrs.Handle, err = parseHandle(v)
+		default:
+			return nil, fmt.Errorf("%w: %q", errArg, opt)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if attrs.LinkIndex == 0 {
+		return nil, errNoDev
+	}
+	if attrs.Parent == 0 {
+		return nil, errParent
+	}
+	if !del {
+		return nil, fmt.Errorf("%w: no qdisc given, netem or tbf", errArg)
+	}
+	return &netlink.GenericQdisc{QdiscAttrs: attrs}, nil
+}
+
+func handle(h uint32) string {
+	if min := h & 0xffff; min != 0 {
+		return fmt.Sprintf("%x:%x", h>>16, min)
+	}
+	return fmt.Sprintf("%x:", h>>16)
+}
+
+// duration formats microseconds like tc does.
+func duration(us float64) string {
+	switch {
+	case us >= 1e6:
+		return strconv.FormatFloat(us/1e6, 'f', -1, 64) + "s"
+	case us >= 1e3:
+		return strconv.FormatFloat(us/1e3, 'f', -1, 64) + "ms"
+	}
+	return strconv.FormatFloat(us, 'f', -1, 64) + "us"
+}
+
+// rate formats bytes a second as bits a second.
+func rate(r uint64) string {
+	bits := float64(r) * 8
+	for _, u := range []struct {
+		unit  string
+		scale float64
+	}{{"Gbit", 1e9}, {"Mbit", 1e6}, {"Kbit", 1e3}} {
+		if bits >= u.scale {
+			return strconv.FormatFloat(bits/u.scale, 'f', -1, 64) + u.unit
+		}
+	}
+	return strconv.FormatFloat(bits, 'f', -1, 64) + "bit"
+}
+
+func size(b uint32) string {
+	switch {
+	case b >= 1<<20 && b%(1<<20) == 0:
+		return fmt.Sprintf("%dMb", b>>20)
+	case b >= 1<<10 && b%(1<<10) == 0:
+		return fmt.Sprintf("%dKb", b>>10)
+	}
+	return fmt.Sprintf("%db", b)
+}
+
+// percent formats a probability scaled to 32 bits as a percentage.
+func percent(p uint32) string {
+	return strconv.FormatFloat(math.Round(float64(p)/math.MaxUint32*1e6)/1e4, 'f', -1, 64) + "%"
+}
+
+func ticks(t uint32) float64 {
+	return math.Round(float64(t) / netlink.TickInUsec())
+}
+
+func (c *cmd) showQdisc(q netlink.Qdisc, dev string) {
+	a := q.Attrs()
+	parent := "root"
+	if a.Parent != netlink.HANDLE_ROOT {
+		parent = "parent " + handle(a.Parent)
+	}
+	fmt.Fprintf(c.out, "qdisc %s %s dev %s %s refcnt %d", q.Type(), handle(a.Handle), dev, parent, a.Refcnt)
+
+	switch q := q.(type) {
+	case *netlink.Netem:
+		fmt.Fprintf(c.out, " limit %d", q.Limit)
+		if q.Latency != 0 {
+			fmt.Fprintf(c.out, " delay %s", duration(ticks(q.Latency)))
+			if q.Jitter != 0 {
+				fmt.Fprintf(c.out, " %s", duration(ticks(q.Jitter)))
+				if q.DelayCorr != 0 {
+					fmt.Fprintf(c.out, " %s", percent(q.DelayCorr))
+				}
+			}
+		}
+		for _, p := range []struct {
+			name       string
+			prob, corr uint32
+		}{
+			{"loss", q.Loss, q.LossCorr},
+			{"duplicate", q.Duplicate, q.DuplicateCorr},
+			{"reorder", q.ReorderProb, q.ReorderCorr},
+			{"corrupt", q.CorruptProb, q.CorruptCorr},
+		} {
+			if p.prob == 0 {
+				continue
+			}
+			fmt.Fprintf(c.out, " %s %s", p.name, percent(p.prob))
+			if p.corr != 0 {
+				fmt.Fprintf(c.out, " %s", percent(p.corr))
+			}
+		}
+		if q.Gap != 0 {
+			fmt.Fprintf(c.out, " gap %d", q.Gap)
+		}
+	case *netlink.Tbf:
+		fmt.Fprintf(c.out, " rate %s burst %s", rate(q.Rate), size(netlink.Xmitsize(q.Rate, q.Buffer)))
+		if q.Peakrate != 0 {
+			fmt.Fprintf(c.out, " peakrate %s", rate(q.Peakrate))
+			// The kernel does not report the minburst, only the ones set
+			// here carry it.
+			if q.Minburst != 0 {
+				fmt.Fprintf(c.out, " minburst %s", size(q.Minburst))
+			}
+		}
+		fmt.Fprintf(c.out, " limit %s", size(q.Limit))
+	}
+	fmt.Fprintln(c.out)
+}
+
+func (c *cmd) show(args []string) error {
+	var link netlink.Link
+	switch len(args) {
+	case 0:
+	case 2:
+		if args[0] != "dev" {
+			return errUsage
+		}
+		var err error
+		if link, err = c.h.LinkByName(args[1]); err != nil {
+			return err
+		}
+	default:
+		return errUsage
+	}
+
+	qs, err := c.h.QdiscList(link)
+	if err != nil {
+		return err
+	}
+	names := map[int]string{}
+	for _, q := range qs {
+		i := q.Attrs().LinkIndex
+		if _, ok := names[i]; !ok {
+			names[i] = fmt.Sprint(i)
+			if l, err := c.h.LinkByIndex(i); err == nil {
+				names[i] = l.Attrs().Name
+			}
+		}
+		c.showQdisc(q, names[i])
+	}
+	return nil
+}
+
+func (c *cmd) run(args []string) error {
+	if len(args) < 1 || args[0] != "qdisc" {
+		return errUsage
+	}
+	if len(args) == 1 {
+		return c.show(nil)
+	}
+
+	op, args := args[1], args[2:]
+	switch op {
+	case "show", "list", "ls":
+		return c.show(args)
+	case "add", "change", "replace", "del", "delete":
+	default:
+		return errUsage
+	}
+
+	q, err := c.parseQdisc(args, op == "del" || op == "delete")
+	if err != nil {
+		return err
+	}
+	switch op {
+	case "add":
+		return c.h.QdiscAdd(q)
+	case "change":
+		return c.h.QdiscChange(q)
+	case "replace":
+		return c.h.QdiscReplace(q)
+	}
+	return c.h.QdiscDel(q)
+}
+
+func main() {
+	h, err := netlink.NewHandle()
+	if err != nil {
+		log.Fatal(err)
+	}
+	c := &cmd{h: h, out: os.Stdout}
+	if err := c.run(os.Args[1:]); err != nil {
+		log.Fatalf("tc: %v", err)
+	}
+}
@@ -0,0 +1,360 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/vishvananda/netlink"
+)
+
+type fakeQdiscs struct {
+	qdiscs []netlink.Qdisc
+	// op and qdisc are those of the last change.
+	op    string
+	qdisc netlink.Qdisc
+}
+
+func (f *fakeQdiscs) LinkByName(name string) (netlink.Link, error) {
+	if name != "eth0" {
+		return nil, os.ErrNotExist
+	}
+	return f.LinkByIndex(2)
+}
+
+func (f *fakeQdiscs) LinkByIndex(index int) (netlink.Link, error) {
+	if index != 2 {
+		return nil, os.ErrNotExist
+	}
+	return &netlink.Device{LinkAttrs: netlink.LinkAttrs{Index: 2, Name: "eth0"}}, nil
+}
+
+func (f *fakeQdiscs) QdiscList(link netlink.Link) ([]netlink.Qdisc, error) {
+	if link == nil {
+		return f.qdiscs, nil
+	}
+	var qs []netlink.Qdisc
+	for _, q := range f.qdiscs {
+		if q.Attrs().LinkIndex == link.Attrs().Index {
+			qs = append(qs, q)
+		}
+	}
+	return qs, nil
+}
+
+func (f *fakeQdiscs) change(op string, q netlink.Qdisc) error {
+	f.op, f.qdisc = op, q
+	return nil
+}
+
+func (f *fakeQdiscs) QdiscAdd(q netlink.Qdisc) error     { return f.change("add", q) }
+func (f *fakeQdiscs) QdiscChange(q netlink.Qdisc) error  { return f.change("change", q) }
+func (f *fakeQdiscs) QdiscReplace(q netlink.Qdisc) error { return f.change("replace", q) }
+func (f *fakeQdiscs) QdiscDel(q netlink.Qdisc) error     { return f.change("del", q) }
+
+func TestParseTime(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want uint32
+		err  error
+	}{
+		{in: "100", want: 100},
+		{in: "100us", want: 100},
+		{in: "10ms", want: 10000},
+		{in: "1.5msec", want: 1500},
+		{in: "2s", want: 2000000},
+		{in: "1sec", want: 1000000},
+		{in: "ms", err: errArg},
+		{in: "-1ms", err: errArg},
+		{in: "10h", err: errArg},
+	} {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseTime(tt.in)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("parseTime(%q) = %v, want %v", tt.in, err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("parseTime(%q) = %d, want %d", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want uint64
+		err  error
+	}{
+		{in: "1000", want: 1000},
+		{in: "8bit", want: 1},
+		{in: "1mbit", want: 125000},
+		{in: "1Mbit", want: 125000},
+		{in: "1kibit", want: 128},
+		{in: "2gbit", want: 250000000},
+		{in: "10kbps", want: 10000},
+		{in: "1mibps", want: 1 << 20},
+		{in: "0", err: errArg},
+		{in: "fast", err: errArg},
+	} {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseRate(tt.in)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("parseRate(%q) = %v, want %v", tt.in, err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("parseRate(%q) = %d, want %d", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want uint32
+		err  error
+	}{
+		{in: "1500", want: 1500},
+		{in: "1500b", want: 1500},
+		{in: "32k", want: 32 << 10},
+		{in: "10kb", want: 10 << 10},
+		{in: "1m", want: 1 << 20},
+		{in: "8kbit", want: 1 << 10},
+		{in: "k", err: errArg},
+		{in: "8g", err: errArg},
+	} {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseSize(tt.in)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("parseSize(%q) = %v, want %v", tt.in, err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("parseSize(%q) = %d, want %d", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseHandle(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want uint32
+		err  error
+	}{
+		{in: "1:", want: 0x10000},
+		{in: "1:2", want: 0x10002},
+		{in: "ffff:a", want: 0xffff000a},
+		{in: "1", err: errArg},
+		{in: "x:", err: errArg},
+		{in: "1:10000", err: errArg},
+	} {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseHandle(tt.in)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("parseHandle(%q) = %v, want %v", tt.in, err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("parseHandle(%q) = %#x, want %#x", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseQdisc(t *testing.T) {
+	root := netlink.QdiscAttrs{LinkIndex: 2, Parent: netlink.HANDLE_ROOT}
+	for _, tt := range []struct {
+		name string
+		args []string
+		del  bool
+		want netlink.Qdisc
+		err  error
+	}{
+		{
+			name: "netem delay loss",
+			args: []string{"dev", "eth0", "root", "netem", "delay", "100ms", "10ms", "loss", "1%"},
+			want: netlink.NewNetem(root, netlink.NetemQdiscAttrs{Latency: 100000, Jitter: 10000, Loss: 1}),
+		},
+		{
+			name: "netem all",
+			args: []string{
+				"dev", "eth0", "parent", "1:1", "handle", "10:", "netem",
+				"delay", "10ms", "5ms", "25", "loss", "random", "0.5", "30",
+				"duplicate", "1", "corrupt", "0.1", "reorder", "25%", "50%", "gap", "5", "limit", "100",
+			},
+			want: netlink.NewNetem(netlink.QdiscAttrs{LinkIndex: 2, Parent: 0x10001, Handle: 0x100000}, netlink.NetemQdiscAttrs{
+				Latency: 10000, Jitter: 5000, DelayCorr: 25,
+				Loss: 0.5, LossCorr: 30,
+				Duplicate:   1,
+				CorruptProb: 0.1,
+				ReorderProb: 25, ReorderCorr: 50, Gap: 5,
+				Limit: 100,
+			}),
+		},
+		{
+			name: "tbf latency",
+			args: []string{"dev", "eth0", "root", "tbf", "rate", "1mbit", "burst", "32k", "latency", "400ms"},
+			want: &netlink.Tbf{
+				QdiscAttrs: root,
+				Rate:       125000,
+				Limit:      125000*4/10 + 32<<10,
+				Buffer:     netlink.Xmittime(125000, 32<<10),
+			},
+		},
+		{
+			name: "tbf peakrate",
+			args: []string{"root", "dev", "eth0", "tbf", "rate", "10mbit", "burst", "10kb", "limit", "100k", "peakrate", "20mbit", "mtu", "1500"},
+			want: &netlink.Tbf{
+				QdiscAttrs: root,
+				Rate:       1250000,
+				Peakrate:   2500000,
+				Limit:      100 << 10,
+				Buffer:     netlink.Xmittime(1250000, 10<<10),
+				Minburst:   1500,
+			},
+		},
+		{
+			name: "del",
+			args: []string{"dev", "eth0", "root"},
+			del:  true,
+			want: &netlink.GenericQdisc{QdiscAttrs: root},
+		},
+		{
+			name: "del kind",
+			args: []string{"dev", "eth0", "root", "netem", "delay", "1ms"},
+			del:  true,
+			want: &netlink.GenericQdisc{QdiscAttrs: root, QdiscType: "netem"},
+		},
+		{name: "no dev", args: []string{"root", "netem"}, err: errNoDev},
+		{name: "no parent", args: []string{"dev", "eth0", "netem"}, err: errParent},
+		{name: "no kind", args: []string{"dev", "eth0", "root"}, err: errArg},
+		{name: "dev not found", args: []string{"dev", "eth1", "root", "netem"}, err: os.ErrNotExist},
+		{name: "bad handle", args: []string{"dev", "eth0", "root", "handle", "1", "netem"}, err: errArg},
+		{name: "missing value", args: []string{"dev"}, err: errArg},
+		{name: "unknown", args: []string{"dev", "eth0", "mtu", "1500"}, err: errArg},
+		{name: "netem unknown", args: []string{"dev", "eth0", "root", "netem", "slot", "1"}, err: errArg},
+		{name: "netem no delay", args: []string{"dev", "eth0", "root", "netem", "delay"}, err: errArg},
+		{name: "netem no loss", args: []string{"dev", "eth0", "root", "netem", "loss"}, err: errArg},
+		{name: "netem bad loss", args: []string{"dev", "eth0", "root", "netem", "loss", "101%"}, err: errArg},
+		{name: "netem reorder no delay", args: []string{"dev", "eth0", "root", "netem", "reorder", "25%"}, err: errArg},
+		{name: "tbf no burst", args: []string{"dev", "eth0", "root", "tbf", "rate", "1mbit", "latency", "1ms"}, err: errArg},
+		{name: "tbf no latency", args: []string{"dev", "eth0", "root", "tbf", "rate", "1mbit", "burst", "1k"}, err: errArg},
+		{name: "tbf limit and latency", args: []string{"dev", "eth0", "root", "tbf", "rate", "1mbit", "burst", "1k", "limit", "1k", "latency", "1ms"}, err: errArg},
+		{name: "tbf peakrate no mtu", args: []string{"dev", "eth0", "root", "tbf", "rate", "1mbit", "burst", "1k", "limit", "1k", "peakrate", "2mbit"}, err: errArg},
+		{name: "tbf missing value", args: []string{"dev", "eth0", "root", "tbf", "rate"}, err: errArg},
+		{name: "tbf unknown", args: []string{"dev", "eth0", "root", "tbf", "cell", "8"}, err: errArg},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &cmd{h: &fakeQdiscs{}}
+			got, err := c.parseQdisc(tt.args, tt.del)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("parseQdisc(%q) = %v, want %v", tt.args, err, tt.err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("parseQdisc(%q) (-want +got):\n%s", tt.args, diff)
+			}
+		})
+	}
+}
+
+func TestShow(t *testing.T) {
+	prob := func(p float32) uint32 { return netlink.Percentage2u32(p) }
+	ticks := func(us uint32) uint32 { return uint32(float64(us) * netlink.TickInUsec()) }
+	f := &fakeQdiscs{qdiscs: []netlink.Qdisc{
+		&netlink.GenericQdisc{
+			QdiscAttrs: netlink.QdiscAttrs{LinkIndex: 1, Parent: netlink.HANDLE_ROOT, Refcnt: 2},
+			QdiscType:  "noqueue",
+		},
+		&netlink.Netem{
+			QdiscAttrs:  netlink.QdiscAttrs{LinkIndex: 2, Parent: netlink.HANDLE_ROOT, Handle: 0x10000, Refcnt: 2},
+			Latency:     ticks(100000),
+			Jitter:      ticks(10000),
+			Limit:       1000,
+			Loss:        prob(1),
+			ReorderProb: prob(25),
+			ReorderCorr: prob(50),
+			Gap:         5,
+		},
+		&netlink.Tbf{
+			QdiscAttrs: netlink.QdiscAttrs{LinkIndex: 2, Parent: 0x10001, Handle: 0x100000, Refcnt: 1},
+			Rate:       125000,
+			Peakrate:   250000,
+			Buffer:     netlink.Xmittime(125000, 32<<10),
+			Limit:      82768,
+		},
+	}}
+
+	for _, tt := range []struct {
+		name string
+		args []string
+		want string
+		err  error
+	}{
+		{
+			name: "all",
+			want: "qdisc noqueue 0: dev 1 root refcnt 2\n" +
+				"qdisc netem 1: dev eth0 root refcnt 2 limit 1000 delay 100ms 10ms loss 1% reorder 25% 50% gap 5\n" +
+				"qdisc tbf 10: dev eth0 parent 1:1 refcnt 1 rate 1Mbit burst 32Kb peakrate 2Mbit limit 82768b\n",
+		},
+		{
+			name: "dev",
+			args: []string{"dev", "eth0"},
+			want: "qdisc netem 1: dev eth0 root refcnt 2 limit 1000 delay 100ms 10ms loss 1% reorder 25% 50% gap 5\n" +
+				"qdisc tbf 10: dev eth0 parent 1:1 refcnt 1 rate 1Mbit burst 32Kb peakrate 2Mbit limit 82768b\n",
+		},
+		{name: "dev not found", args: []string{"dev", "eth1"}, err: os.ErrNotExist},
+		{name: "usage", args: []string{"eth0"}, err: errUsage},
+		{name: "not dev", args: []string{"link", "eth0"}, err: errUsage},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			c := &cmd{h: f, out: &out}
+			if err := c.show(tt.args); !errors.Is(err, tt.err) {
+				t.Fatalf("show(%q) = %v, want %v", tt.args, err, tt.err)
+			}
+			if got := out.String(); got != tt.want {
+				t.Errorf("show(%q) = %q, want %q", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		args []string
+		op   string
+		err  error
+	}{
+		{name: "add", args: []string{"qdisc", "add", "dev", "eth0", "root", "netem", "delay", "1ms"}, op: "add"},
+		{name: "change", args: []string{"qdisc", "change", "dev", "eth0", "root", "netem", "loss", "1%"}, op: "change"},
+		{name: "replace", args: []string{"qdisc", "replace", "dev", "eth0", "root", "tbf", "rate", "1mbit", "burst", "32k", "latency", "400ms"}, op: "replace"},
+		{name: "del", args: []string{"qdisc", "del", "dev", "eth0", "root"}, op: "del"},
+		{name: "delete", args: []string{"qdisc", "delete", "dev", "eth0", "root"}, op: "del"},
+		{name: "show", args: []string{"qdisc"}},
+		{name: "ls", args: []string{"qdisc", "ls", "dev", "eth0"}},
+		{name: "no args", err: errUsage},
+		{name: "class", args: []string{"class", "show"}, err: errUsage},
+		{name: "unknown op", args: []string{"qdisc", "flush"}, err: errUsage},
+		{name: "add no kind", args: []string{"qdisc", "add", "dev", "eth0", "root"}, err: errArg},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fakeQdiscs{}
+			c := &cmd{h: f, out: &bytes.Buffer{}}
+			if err := c.run(tt.args); !errors.Is(err, tt.err) {
+				t.Fatalf("run(%q) = %v, want %v", tt.args, err, tt.err)
+			}
+			if f.op != tt.op {
+				t.Errorf("run(%q) did %q, want %q", tt.args, f.op, tt.op)
+			}
+		})
+	}
+}
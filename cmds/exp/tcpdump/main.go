@@ -51,11 +51,12 @@ type flags struct {
 	FilterFile             string
 	TimeStampInNanoSeconds bool
 	IcmpOnly               bool
+	WriteFile              string
 }
 
 const tcpdumpHelp = `       tcpdump [ -ADehnpqtvx# ] [ -icmp ]
                 [ -c count ] [ --count ] [ -F file ][ -i interface ]
-			    [ --number ] [ --print ] [ -s snaplen ] [ --nano ] 
+			    [ --number ] [ --print ] [ -s snaplen ] [ --nano ] [ -w file ]
 				[ EXPRESSION ]
 	EXPRESSION := [ EXPRESSION ] [ and ] [ or ] [ not ] 
 				  [ gateway host ] [ proto protocol ] [ ether type ] [ src host ]
@@ -92,6 +93,7 @@ func parseFlags(args []string, out io.Writer) (cmd, error) {
 	fs.BoolVar(&opts.TimeStampInNanoSeconds, "nano", false, "Print the timestamp in nanosecond resolution (instead of microseconds)")
 	fs.BoolVar(&opts.Data, "x", false, "When parsing and printing, in addition to printing the headers of each packet, print the data of each packet (minus its link level header) in hex")
 	fs.BoolVar(&opts.DataWithHeader, "xx", false, "When parsing and printing, in addition to printing the headers of each packet, print the data of each packet (including its link level header) in hex")
+	fs.StringVar(&opts.WriteFile, "w", "", "Write the raw packets to file in pcapng format rather than printing them. Standard output is used if file is \"-\"")
 	fs.StringVar(&opts.FilterFile, "F", "", "Use file as input for the filter expression.  An additional expression given on the command line is ignored.")
 	fs.BoolVar(&opts.ASCII, "A", false, "Print each packet (minus its link level header) in ASCII.  Handy for capturing web pages")
 	fs.BoolVar(&opts.Quiet, "q", false, "Quiet output. Print less protocol information so output lines are shorter")
@@ -184,6 +186,25 @@ func (cmd *cmd) run() error {
 	packetSource := gopacket.NewPacketSource(src, layers.LinkTypeEthernet)
 	packetSource.NoCopy = true
 
+	var pw *pcapngWriter
+	if cmd.Opts.WriteFile != "" {
+		w := cmd.Out
+		if cmd.Opts.WriteFile != "-" {
+			f, err := os.Create(cmd.Opts.WriteFile)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+		if pw, err = newPcapngWriter(w, cmd.Opts.Device, layers.LinkType(src.LinkType()), cmd.Opts.SnapshotLength); err != nil {
+			return err
+		}
+		// The packets may be written to standard output.
+		fmt.Fprintf(os.Stderr, "tcpdump: listening on %s, link-type %d, snapshot length %d bytes\n", cmd.Opts.Device, src.LinkType(), cmd.Opts.SnapshotLength)
+		return cmd.write(ctx, packetSource, pw)
+	}
+
 	fmt.Fprintf(cmd.Out, "tcpdump: verbose output suppressed, use -v for full protocol decode\nlistening on %s, link-type %d, snapshot length %d bytes\n", cmd.Opts.Device, src.LinkType(), cmd.Opts.SnapshotLength)
 
 	var (
@@ -218,6 +239,31 @@ func (cmd *cmd) run() error {
 	}
 }
 
+// write writes the packets of the source until the context is done or the
+// count is reached.
+func (cmd *cmd) write(ctx context.Context, packetSource *gopacket.PacketSource, pw *pcapngWriter) error {
+	var capturedPackets int
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Fprintf(os.Stderr, "\n%d packets captured\n", capturedPackets)
+
+			return nil
+		case packet, ok := <-packetSource.PacketsCtx(ctx):
+			if !ok {
+				return nil
+			}
+			if err := pw.writePacket(packet.Metadata().CaptureInfo, packet.Data()); err != nil {
+				return err
+			}
+			capturedPackets++
+			if cmd.Opts.CountPkg > 0 && capturedPackets >= cmd.Opts.CountPkg {
+				return nil
+			}
+		}
+	}
+}
+
 // processPacket processes a packet and prints the output to the output writer.
 // A timestamp of the packet is returned.
 func (cmd *cmd) processPacket(packet gopacket.Packet, num int, lastPkgTimeStamp time.Time) time.Time {
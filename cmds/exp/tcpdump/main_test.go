@@ -68,6 +68,18 @@ func TestParseFlags(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "write file",
+			args: []string{"cmd", "-i", "eth0", "-w", "out.pcapng", "udp", "port", "67"},
+			expectedCmd: cmd{
+				Opts: flags{
+					SnapshotLength: 262144,
+					Device:         "eth0",
+					Filter:         "udp port 67 ",
+					WriteFile:      "out.pcapng",
+				},
+			},
+		},
 		{
 			name:        "missing filter file",
 			args:        []string{"cmd", "-F", "xyz"},
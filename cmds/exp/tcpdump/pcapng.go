@@ -0,0 +1,107 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+)
+
+// pcapng block types and options, see
+// https://www.ietf.org/archive/id/draft-ietf-opsawg-pcapng-03.html
+const (
+	blockSectionHeader      = 0x0a0d0d0a
+	blockInterfaceDesc      = 0x00000001
+	blockEnhancedPacket     = 0x00000006
+	byteOrderMagic          = 0x1a2b3c4d
+	optEndOfOpt             = 0
+	optSHBUserAppl          = 4
+	optIFName               = 2
+	optIFTSResol            = 9
+	tsResolutionNanoseconds = 9
+)
+
+// pcapngWriter writes captured packets of a single interface in pcapng format.
+type pcapngWriter struct {
+	w io.Writer
+}
+
+// option appends a pcapng option to b, padding its value to 32 bits.
+func option(b []byte, code uint16, value []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+	b = append(b, value...)
+	return append(b, make([]byte, pad(len(value)))...)
+}
+
+func pad(n int) int {
+	return (4 - n%4) % 4
+}
+
+// block writes a block, prefixed and suffixed with its total length, in a
+// single write so that readers of a pipe never see part of one.
+func (p *pcapngWriter) block(typ uint32, body []byte) error {
+	n := uint32(12 + len(body))
+	b := make([]byte, 0, n)
+	b = binary.LittleEndian.AppendUint32(b, typ)
+	b = binary.LittleEndian.AppendUint32(b, n)
+	b = append(b, body...)
+	b = binary.LittleEndian.AppendUint32(b, n)
+	_, err := p.w.Write(b)
+	return err
+}
+
+// newPcapngWriter writes the section header and the description of the
+// interface the packets are captured on to w.
+func newPcapngWriter(w io.Writer, device string, linkType layers.LinkType, snaplen int) (*pcapngWriter, error) {
+	p := &pcapngWriter{w: w}
+
+	var shb []byte
+	shb = binary.LittleEndian.AppendUint32(shb, byteOrderMagic)
+	shb = binary.LittleEndian.AppendUint16(shb, 1)
+	shb = binary.LittleEndian.AppendUint16(shb, 0)
+	// The section length is not known in advance.
+	shb = binary.LittleEndian.AppendUint64(shb, ^uint64(0))
+	shb = option(shb, optSHBUserAppl, []byte("u-root tcpdump"))
+	shb = option(shb, optEndOfOpt, nil)
+	if err := p.block(blockSectionHeader, shb); err != nil {
+		return nil, err
+	}
+
+	var idb []byte
+	idb = binary.LittleEndian.AppendUint16(idb, uint16(linkType))
+	idb = binary.LittleEndian.AppendUint16(idb, 0)
+	idb = binary.LittleEndian.AppendUint32(idb, uint32(snaplen))
+	idb = option(idb, optIFName, []byte(device))
+	idb = option(idb, optIFTSResol, []byte{tsResolutionNanoseconds})
+	idb = option(idb, optEndOfOpt, nil)
+	if err := p.block(blockInterfaceDesc, idb); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// writePacket writes a packet as an enhanced packet block.
+func (p *pcapngWriter) writePacket(ci gopacket.CaptureInfo, data []byte) error {
+	length := ci.Length
+	if length < len(data) {
+		length = len(data)
+	}
+	ts := uint64(ci.Timestamp.UnixNano())
+
+	epb := make([]byte, 0, 20+len(data)+pad(len(data)))
+	// All packets are of the one interface described.
+	epb = binary.LittleEndian.AppendUint32(epb, 0)
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(ts>>32))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(ts))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(len(data)))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(length))
+	epb = append(epb, data...)
+	epb = append(epb, make([]byte, pad(len(data)))...)
+	return p.block(blockEnhancedPacket, epb)
+}
@@ -0,0 +1,146 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+)
+
+type pcapngBlock struct {
+	typ  uint32
+	body []byte
+}
+
+// readBlocks splits a pcapng stream into its blocks, checking their lengths.
+func readBlocks(t *testing.T, b []byte) []pcapngBlock {
+	t.Helper()
+	var blocks []pcapngBlock
+	for len(b) > 0 {
+		if len(b) < 12 {
+			t.Fatalf("truncated block %x", b)
+		}
+		n := binary.LittleEndian.Uint32(b[4:])
+		if n%4 != 0 || int(n) > len(b) {
+			t.Fatalf("block length %d of %d bytes", n, len(b))
+		}
+		if trailer := binary.LittleEndian.Uint32(b[n-4:]); trailer != n {
+			t.Fatalf("block length %d, trailing length %d", n, trailer)
+		}
+		blocks = append(blocks, pcapngBlock{typ: binary.LittleEndian.Uint32(b), body: b[8 : n-4]})
+		b = b[n:]
+	}
+	return blocks
+}
+
+func TestPcapngWriter(t *testing.T) {
+	var out bytes.Buffer
+	pw, err := newPcapngWriter(&out, "eth0", layers.LinkTypeEthernet, 65535)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Unix(1700000000, 123456789)
+	packets := [][]byte{
+		bytes.Repeat([]byte{0xaa}, 42),
+		bytes.Repeat([]byte{0xbb}, 60),
+	}
+	for _, p := range packets {
+		if err := pw.writePacket(gopacket.CaptureInfo{Timestamp: ts, CaptureLength: len(p), Length: 1514}, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	blocks := readBlocks(t, out.Bytes())
+	if len(blocks) != 4 {
+		t.Fatalf("got %d blocks, want 4", len(blocks))
+	}
+
+	shb := blocks[0]
+	if shb.typ != blockSectionHeader || binary.LittleEndian.Uint32(shb.body) != byteOrderMagic {
+		t.Errorf("section header block %x, %x", shb.typ, shb.body)
+	}
+	if major, minor := binary.LittleEndian.Uint16(shb.body[4:]), binary.LittleEndian.Uint16(shb.body[6:]); major != 1 || minor != 0 {
+		t.Errorf("version %d.%d, want 1.0", major, minor)
+	}
+
+	idb := blocks[1]
+	want := []byte{
+		1, 0, 0, 0, 0xff, 0xff, 0, 0,
+		optIFName, 0, 4, 0, 'e', 't', 'h', '0',
+		optIFTSResol, 0, 1, 0, tsResolutionNanoseconds, 0, 0, 0,
+		0, 0, 0, 0,
+	}
+	if idb.typ != blockInterfaceDesc {
+		t.Errorf("block type %x, want %x", idb.typ, blockInterfaceDesc)
+	}
+	if diff := cmp.Diff(want, idb.body); diff != "" {
+		t.Errorf("interface description block mismatch (-want +got):\n%s", diff)
+	}
+
+	for i, p := range packets {
+		epb := blocks[2+i]
+		if epb.typ != blockEnhancedPacket {
+			t.Errorf("block type %x, want %x", epb.typ, blockEnhancedPacket)
+		}
+		b := epb.body
+		got := uint64(binary.LittleEndian.Uint32(b[4:]))<<32 | uint64(binary.LittleEndian.Uint32(b[8:]))
+		if got != uint64(ts.UnixNano()) {
+			t.Errorf("timestamp %d, want %d", got, ts.UnixNano())
+		}
+		if caplen, length := binary.LittleEndian.Uint32(b[12:]), binary.LittleEndian.Uint32(b[16:]); caplen != uint32(len(p)) || length != 1514 {
+			t.Errorf("captured %d of %d bytes, want %d of 1514", caplen, length, len(p))
+		}
+		if len(b[20:]) != len(p)+pad(len(p)) || !bytes.Equal(b[20:20+len(p)], p) {
+			t.Errorf("packet data %x, want %x padded", b[20:], p)
+		}
+	}
+}
+
+// packets is a packet data source of fixed packets.
+type packets [][]byte
+
+func (p *packets) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	if len(*p) == 0 {
+		return nil, gopacket.CaptureInfo{}, io.EOF
+	}
+	data := (*p)[0]
+	*p = (*p)[1:]
+	return data, gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(data), Length: len(data)}, nil
+}
+
+func TestWrite(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		count int
+		want  int
+	}{
+		{name: "all", want: 3},
+		{name: "count", count: 2, want: 2},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			src := &packets{{1, 2, 3}, {4, 5}, {6}}
+			var out bytes.Buffer
+			pw, err := newPcapngWriter(&out, "lo", layers.LinkTypeEthernet, 262144)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := &cmd{Out: &out, Opts: flags{CountPkg: tt.count}}
+			if err := c.write(context.Background(), gopacket.NewPacketSource(src, layers.LinkTypeEthernet), pw); err != nil {
+				t.Fatal(err)
+			}
+			if got := len(readBlocks(t, out.Bytes())) - 2; got != tt.want {
+				t.Errorf("wrote %d packets, want %d", got, tt.want)
+			}
+		})
+	}
+}
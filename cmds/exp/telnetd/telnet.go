@@ -0,0 +1,297 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+)
+
+// Telnet commands, RFC 854.
+const (
+	cmdSE   = 240
+	cmdIP   = 244
+	cmdSB   = 250
+	cmdWILL = 251
+	cmdWONT = 252
+	cmdDO   = 253
+	cmdDONT = 254
+	cmdIAC  = 255
+)
+
+// Telnet options.
+const (
+	optEcho  = 1  // RFC 857
+	optSGA   = 3  // Suppress go ahead, RFC 858
+	optTType = 24 // Terminal type, RFC 1091
+	optNAWS  = 31 // Negotiate about window size, RFC 1073
+
+	ttypeIS   = 0
+	ttypeSend = 1
+)
+
+var (
+	// errInterrupted is returned by readLine when the client interrupts it.
+	errInterrupted = errors.New("interrupted")
+	// errLineTooLong is returned by readLine for lines longer than maxLine.
+	errLineTooLong = errors.New("line too long")
+)
+
+const (
+	// maxLine is the length of the longest line readLine reads.
+	maxLine = 256
+	// maxSubnegotiation is the length of the longest option parameters
+	// handled. Longer ones are read and ignored.
+	maxSubnegotiation = 64
+)
+
+// session is the NVT of a telnet client. Reads return the data sent by the
+// client, with the commands and options it negotiates removed, and writes
+// send data to it.
+type session struct {
+	r *bufio.Reader
+
+	wmu sync.Mutex
+	w   io.Writer
+
+	// cr is whether the last byte read was a carriage return, which
+	// clients follow with a line feed or a NUL.
+	cr bool
+
+	mu         sync.Mutex
+	term       string
+	rows, cols uint16
+	// resize is called with the window size of the client, if not nil.
+	resize func(rows, cols uint16)
+}
+
+func newSession(rw io.ReadWriter) *session {
+	return &session{r: bufio.NewReader(rw), w: rw}
+}
+
+// negotiate asks the client to let the server echo, to not send go aheads,
+// and to send its terminal type and window size. The answers are handled
+// by Read.
+func (s *session) negotiate() error {
+	return s.command(
+		cmdWILL, optEcho,
+		cmdWILL, optSGA,
+		cmdDO, optSGA,
+		cmdDO, optTType,
+		cmdDO, optNAWS,
+	)
+}
+
+// command sends pairs of commands and options to the client.
+func (s *session) command(pairs ...byte) error {
+	var b []byte
+	for i := 0; i+1 < len(pairs); i += 2 {
+		b = append(b, cmdIAC, pairs[i], pairs[i+1])
+	}
+	return s.send(b)
+}
+
+// send sends the commands of b to the client.
+func (s *session) send(b []byte) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	_, err := s.w.Write(b)
+	return err
+}
+
+// Write sends b to the client, escaping the bytes that would be taken for
+// commands.
+func (s *session) Write(b []byte) (int, error) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	if bytes.IndexByte(b, cmdIAC) < 0 {
+		return s.w.Write(b)
+	}
+	if _, err := s.w.Write(bytes.ReplaceAll(b, []byte{cmdIAC}, []byte{cmdIAC, cmdIAC})); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Term returns the terminal type of the client, if it sent one.
+func (s *session) Term() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.term
+}
+
+// onResize calls resize with the window size of the client, now if it sent
+// one, and whenever it changes.
+func (s *session) onResize(resize func(rows, cols uint16)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resize = resize
+	if s.rows != 0 || s.cols != 0 {
+		resize(s.rows, s.cols)
+	}
+}
+
+// Read reads the data sent by the client into b. Carriage returns are
+// passed on without the line feed or NUL following them, as a terminal
+// sends them.
+func (s *session) Read(b []byte) (int, error) {
+	n := 0
+	for n < len(b) {
+		if n > 0 && s.r.Buffered() == 0 {
+			break
+		}
+		c, err := s.r.ReadByte()
+		if err != nil {
+			if n > 0 {
+				return n, nil
+			}
+			return 0, err
+		}
+		cr := s.cr
+		s.cr = false
+		switch {
+		case c == cmdIAC:
+			d, ok, err := s.iac()
+			if err != nil {
+				if n > 0 {
+					return n, nil
+				}
+				return 0, err
+			}
+			if ok {
+				b[n] = d
+				n++
+			}
+		case cr && (c == '\n' || c == 0):
+		default:
+			s.cr = c == '\r'
+			b[n] = c
+			n++
+		}
+	}
+	return n, nil
+}
+
+// iac handles the command following an IAC, returning the byte of data it
+// stands for, if any.
+func (s *session) iac() (byte, bool, error) {
+	c, err := s.r.ReadByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch c {
+	case cmdIAC:
+		return cmdIAC, true, nil
+	case cmdIP:
+		// Interrupt process, as ^C does.
+		return 3, true, nil
+	case cmdWILL, cmdWONT, cmdDO, cmdDONT:
+		opt, err := s.r.ReadByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return 0, false, s.option(c, opt)
+	case cmdSB:
+		return 0, false, s.subnegotiation()
+	}
+	// NOP, go ahead, and the rest mean nothing to a pty.
+	return 0, false, nil
+}
+
+// option answers the client offering or asking for opt. The options the
+// server asked for are only acknowledged, so that negotiations end.
+func (s *session) option(cmd, opt byte) error {
+	switch cmd {
+	case cmdWILL:
+		switch opt {
+		case optTType:
+			return s.send([]byte{cmdIAC, cmdSB, optTType, ttypeSend, cmdIAC, cmdSE})
+		case optNAWS, optSGA:
+			return nil
+		}
+		return s.command(cmdDONT, opt)
+	case cmdDO:
+		switch opt {
+		case optEcho, optSGA:
+			return nil
+		}
+		return s.command(cmdWONT, opt)
+	}
+	return nil
+}
+
+// subnegotiation reads the parameters of an option, up to IAC SE.
+func (s *session) subnegotiation() error {
+	var b []byte
+	long := false
+	for {
+		c, err := s.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if c == cmdIAC {
+			if c, err = s.r.ReadByte(); err != nil {
+				return err
+			}
+			if c == cmdSE {
+				break
+			}
+		}
+		if len(b) == maxSubnegotiation {
+			long = true
+			continue
+		}
+		b = append(b, c)
+	}
+	if len(b) == 0 || long {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case b[0] == optNAWS && len(b) == 5:
+		s.cols = uint16(b[1])<<8 | uint16(b[2])
+		s.rows = uint16(b[3])<<8 | uint16(b[4])
+		if s.resize != nil {
+			s.resize(s.rows, s.cols)
+		}
+	case b[0] == optTType && len(b) > 1 && b[1] == ttypeIS:
+		s.term = string(bytes.ToLower(b[2:]))
+	}
+	return nil
+}
+
+// readLine reads a line of up to maxLine bytes from the client, without
+// echoing it, as for a password.
+func (s *session) readLine() (string, error) {
+	var line []byte
+	var c [1]byte
+	for {
+		if _, err := io.ReadFull(s, c[:]); err != nil {
+			return "", err
+		}
+		switch c[0] {
+		case '\r', '\n':
+			return string(line), nil
+		case 0x7f, '\b':
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+			}
+		case 3, 4:
+			// ^C and ^D.
+			return "", errInterrupted
+		default:
+			if len(line) == maxLine {
+				return "", errLineTooLong
+			}
+			line = append(line, c[0])
+		}
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+// telnetd serves shells to telnet clients, for console access when there is
+// no other way in.
+//
+// Synopsis:
+//
+//	telnetd [-a ADDR] [-i IFACE] [-s SHELL] -p PASSWORD|-P FILE
+//
+// Description:
+//
+//	telnetd runs SHELL on a pty for each telnet client that gives the
+//	password, as a last resort when SSH keys are not provisioned yet and
+//	there is no serial console. Telnet is not encrypted: the password and
+//	the session can be read by anyone on the network, so bind telnetd to a
+//	trusted interface with -i, and use sshd once possible.
+//
+//	Clients get three tries at the password, within a minute.
+//
+// Options:
+//
+//	-a: address to listen on (default: :23)
+//	-i: only accept connections received on network interface IFACE
+//	-s: shell to run (default: /bin/sh)
+//	-p: password of the clients
+//	-P: file whose first line is the password, to keep it out of ps
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/u-root/u-root/pkg/termios"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	errUsage    = errors.New("usage: telnetd [-a ADDR] [-i IFACE] [-s SHELL] -p PASSWORD|-P FILE")
+	errPassword = errors.New("empty password")
+)
+
+var (
+	// failDelay is the delay after a wrong password, against guessing.
+	failDelay = 2 * time.Second
+	// loginTimeout bounds the time clients have to give the password, so
+	// that they cannot hold connections open without logging in.
+	loginTimeout = time.Minute
+)
+
+const tries = 3
+
+type cmd struct {
+	addr     string
+	iface    string
+	shell    string
+	password []byte
+}
+
+func parse(args []string) (*cmd, error) {
+	f := flag.NewFlagSet("telnetd", flag.ContinueOnError)
+	f.SetOutput(io.Discard)
+	addr := f.String("a", ":23", "Address to listen on")
+	iface := f.String("i", "", "Only accept connections received on network interface IFACE")
+	shell := f.String("s", "/bin/sh", "Shell to run")
+	password := f.String("p", "", "Password of the clients")
+	file := f.String("P", "", "File whose first line is the password")
+	if err := f.Parse(args); err != nil {
+		return nil, fmt.Errorf("%w: %v", errUsage, err)
+	}
+	if f.NArg() != 0 || (*password == "") == (*file == "") {
+		return nil, errUsage
+	}
+	c := &cmd{addr: *addr, iface: *iface, shell: *shell, password: []byte(*password)}
+	if *file != "" {
+		b, err := os.ReadFile(*file)
+		if err != nil {
+			return nil, err
+		}
+		if i := bytes.IndexByte(b, '\n'); i >= 0 {
+			b = b[:i]
+		}
+		c.password = bytes.TrimSuffix(b, []byte("\r"))
+		if len(c.password) == 0 {
+			return nil, fmt.Errorf("%s: %w", *file, errPassword)
+		}
+	}
+	return c, nil
+}
+
+// login asks the client for the password, returning whether it gave it.
+func (c *cmd) login(s *session) (bool, error) {
+	for i := 0; i < tries; i++ {
+		if _, err := io.WriteString(s, "Password: "); err != nil {
+			return false, err
+		}
+		p, err := s.readLine()
+		if err != nil {
+			return false, err
+		}
+		if subtle.ConstantTimeCompare([]byte(p), c.password) == 1 {
+			_, err := io.WriteString(s, "\r\n")
+			return true, err
+		}
+		time.Sleep(failDelay)
+		if _, err := io.WriteString(s, "\r\nLogin incorrect\r\n"); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// openPTY opens a pty. pty.New is of no use, as it needs the controlling
+// terminal of telnetd, which has none when run as a daemon.
+func openPTY() (*os.File, *os.File, error) {
+	ptm, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	n, err := unix.IoctlGetInt(int(ptm.Fd()), unix.TIOCGPTN)
+	if err == nil {
+		err = unix.IoctlSetPointerInt(int(ptm.Fd()), unix.TIOCSPTLCK, 0)
+	}
+	if err != nil {
+		ptm.Close()
+		return nil, nil, err
+	}
+	pts, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		ptm.Close()
+		return nil, nil, err
+	}
+	return ptm, pts, nil
+}
+
+// runShell runs the shell on a pty for the client of s, until either exits.
+func (c *cmd) runShell(s *session) error {
+	ptm, pts, err := openPTY()
+	if err != nil {
+		return err
+	}
+	defer ptm.Close()
+	s.onResize(func(rows, cols uint16) {
+		termios.SetWinSize(ptm.Fd(), &termios.Winsize{Winsize: unix.Winsize{Row: rows, Col: cols}})
+	})
+
+	sh := exec.Command(c.shell)
+	sh.Stdin, sh.Stdout, sh.Stderr = pts, pts, pts
+	sh.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+	sh.Env = os.Environ()
+	if term := s.Term(); term != "" {
+		sh.Env = append(sh.Env, "TERM="+term)
+	}
+	err = sh.Start()
+	pts.Close()
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		io.Copy(ptm, s)
+		// The client is gone, hang up on the shell.
+		sh.Process.Signal(unix.SIGHUP)
+	}()
+	// Reads of ptm fail once the shell and its children closed the pts.
+	io.Copy(s, ptm)
+	return sh.Wait()
+}
+
+// handle serves the client of conn.
+func (c *cmd) handle(conn net.Conn) {
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(loginTimeout)); err != nil {
+		log.Printf("telnetd: %v: %v", conn.RemoteAddr(), err)
+		return
+	}
+	s := newSession(conn)
+	if err := s.negotiate(); err != nil {
+		log.Printf("telnetd: %v: %v", conn.RemoteAddr(), err)
+		return
+	}
+	ok, err := c.login(s)
+	if err != nil {
+		log.Printf("telnetd: %v: %v", conn.RemoteAddr(), err)
+		return
+	}
+	if !ok {
+		log.Printf("telnetd: %v: wrong password", conn.RemoteAddr())
+		return
+	}
+	log.Printf("telnetd: %v logged in", conn.RemoteAddr())
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		log.Printf("telnetd: %v: %v", conn.RemoteAddr(), err)
+		return
+	}
+	if err := c.runShell(s); err != nil {
+		log.Printf("telnetd: %v: %v", conn.RemoteAddr(), err)
+	}
+	log.Printf("telnetd: %v logged out", conn.RemoteAddr())
+}
+
+// listen listens on the address, and only on the interface if any.
+func (c *cmd) listen() (net.Listener, error) {
+	lc := net.ListenConfig{}
+	if c.iface != "" {
+		lc.Control = func(network, address string, rc syscall.RawConn) error {
+			var serr error
+			if err := rc.Control(func(fd uintptr) {
+				serr = unix.SetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE, c.iface)
+			}); err != nil {
+				return err
+			}
+			if serr != nil {
+				return fmt.Errorf("binding to %s: %w", c.iface, serr)
+			}
+			return nil
+		}
+	}
+	return lc.Listen(context.Background(), "tcp", c.addr)
+}
+
+func (c *cmd) serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go c.handle(conn)
+	}
+}
+
+func (c *cmd) run() error {
+	l, err := c.listen()
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	return c.serve(l)
+}
+
+func main() {
+	c, err := parse(os.Args[1:])
+	if err != nil {
+		log.Fatalf("telnetd: %v", err)
+	}
+	if err := c.run(); err != nil {
+		log.Fatalf("telnetd: %v", err)
+	}
+}
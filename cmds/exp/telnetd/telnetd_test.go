@@ -0,0 +1,273 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParse(t *testing.T) {
+	dir := t.TempDir()
+	pw := filepath.Join(dir, "pw")
+	if err := os.WriteFile(pw, []byte("s3cret\nignored\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	empty := filepath.Join(dir, "empty")
+	if err := os.WriteFile(empty, []byte("\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name string
+		args []string
+		want *cmd
+		err  error
+	}{
+		{name: "password", args: []string{"-p", "pw"}, want: &cmd{addr: ":23", shell: "/bin/sh", password: []byte("pw")}},
+		{name: "file", args: []string{"-a", ":2323", "-i", "eth0", "-s", "/bin/gosh", "-P", pw}, want: &cmd{addr: ":2323", iface: "eth0", shell: "/bin/gosh", password: []byte("s3cret")}},
+		{name: "no password", args: []string{}, err: errUsage},
+		{name: "both", args: []string{"-p", "pw", "-P", pw}, err: errUsage},
+		{name: "args", args: []string{"-p", "pw", "x"}, err: errUsage},
+		{name: "bad flag", args: []string{"-x"}, err: errUsage},
+		{name: "empty file", args: []string{"-P", empty}, err: errPassword},
+		{name: "missing file", args: []string{"-P", filepath.Join(dir, "missing")}, err: os.ErrNotExist},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parse(tt.args)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("parse(%q) = %v, want %v", tt.args, err, tt.err)
+			}
+			if diff := cmp.Diff(tt.want, got, cmp.AllowUnexported(cmd{})); diff != "" {
+				t.Errorf("parse(%q): (-want, +got)\n%s", tt.args, diff)
+			}
+		})
+	}
+}
+
+// rw reads from r, and records writes.
+type rw struct {
+	io.Reader
+	sent bytes.Buffer
+}
+
+func (rw *rw) Write(b []byte) (int, error) {
+	return rw.sent.Write(b)
+}
+
+func TestSessionRead(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		in    []byte
+		data  string
+		sent  []byte
+		term  string
+		rows  uint16
+		cols  uint16
+		sizes int
+	}{
+		{name: "data", in: []byte("ls -l"), data: "ls -l"},
+		{name: "cr lf", in: []byte("ls\r\npwd\r\x00"), data: "ls\rpwd\r"},
+		{name: "lf", in: []byte("ls\n"), data: "ls\n"},
+		{name: "iac iac", in: []byte{'a', cmdIAC, cmdIAC, 'b'}, data: "a\xffb"},
+		{name: "interrupt", in: []byte{'a', cmdIAC, cmdIP}, data: "a\x03"},
+		{
+			name: "naws",
+			in:   []byte{cmdIAC, cmdWILL, optNAWS, cmdIAC, cmdSB, optNAWS, 0, 80, 0, 24, cmdIAC, cmdSE, 'x'},
+			data: "x", rows: 24, cols: 80, sizes: 1,
+		},
+		{
+			name: "naws escaped",
+			in:   []byte{cmdIAC, cmdSB, optNAWS, 1, cmdIAC, cmdIAC, 0, 50, cmdIAC, cmdSE},
+			rows: 50, cols: 511, sizes: 1,
+		},
+		{
+			name: "ttype",
+			in:   []byte{cmdIAC, cmdWILL, optTType, cmdIAC, cmdSB, optTType, ttypeIS, 'X', 'T', 'E', 'R', 'M', cmdIAC, cmdSE},
+			sent: []byte{cmdIAC, cmdSB, optTType, ttypeSend, cmdIAC, cmdSE},
+			term: "xterm",
+		},
+		{
+			name: "ttype too long",
+			in:   append(append([]byte{cmdIAC, cmdSB, optTType, ttypeIS}, bytes.Repeat([]byte{'X'}, maxSubnegotiation)...), cmdIAC, cmdSE, 'z'),
+			data: "z",
+		},
+		{
+			name: "acknowledgments",
+			in:   []byte{cmdIAC, cmdDO, optEcho, cmdIAC, cmdDO, optSGA, cmdIAC, cmdWILL, optSGA, cmdIAC, cmdDONT, optEcho, 'y'},
+			data: "y",
+		},
+		{
+			name: "refusals",
+			in:   []byte{cmdIAC, cmdDO, 39, cmdIAC, cmdWILL, 36},
+			sent: []byte{cmdIAC, cmdWONT, 39, cmdIAC, cmdDONT, 36},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &rw{Reader: bytes.NewReader(tt.in)}
+			s := newSession(c)
+			var rows, cols uint16
+			var sizes int
+			s.onResize(func(r, c uint16) {
+				rows, cols = r, c
+				sizes++
+			})
+			data, err := io.ReadAll(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.data {
+				t.Errorf("read %q, want %q", data, tt.data)
+			}
+			if !bytes.Equal(c.sent.Bytes(), tt.sent) {
+				t.Errorf("sent %v, want %v", c.sent.Bytes(), tt.sent)
+			}
+			if s.Term() != tt.term || rows != tt.rows || cols != tt.cols || sizes != tt.sizes {
+				t.Errorf("term %q, %d rows, %d cols, %d resizes, want %q, %d, %d, %d", s.Term(), rows, cols, sizes, tt.term, tt.rows, tt.cols, tt.sizes)
+			}
+		})
+	}
+}
+
+func TestSessionWrite(t *testing.T) {
+	c := &rw{Reader: strings.NewReader("")}
+	s := newSession(c)
+	in := []byte{'a', cmdIAC, 'b'}
+	if n, err := s.Write(in); n != len(in) || err != nil {
+		t.Fatalf("Write = %d, %v, want %d, nil", n, err, len(in))
+	}
+	if want := []byte{'a', cmdIAC, cmdIAC, 'b'}; !bytes.Equal(c.sent.Bytes(), want) {
+		t.Errorf("sent %v, want %v", c.sent.Bytes(), want)
+	}
+}
+
+func TestReadLine(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want string
+		err  error
+	}{
+		{in: "secret\r\n", want: "secret"},
+		{in: "secrex\x7ft\r\x00", want: "secret"},
+		{in: "\x08\bpw\n", want: "pw"},
+		{in: "pw\x03", err: errInterrupted},
+		{in: "pw", err: io.EOF},
+		{in: strings.Repeat("a", maxLine) + "\r\n", want: strings.Repeat("a", maxLine)},
+		{in: strings.Repeat("a", maxLine+1) + "\r\n", err: errLineTooLong},
+	} {
+		s := newSession(&rw{Reader: strings.NewReader(tt.in)})
+		got, err := s.readLine()
+		if got != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("readLine(%q) = %q, %v, want %q, %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+// expect reads from r until it read s.
+func expect(t *testing.T, r *bufio.Reader, s string) {
+	t.Helper()
+	var got []byte
+	for !bytes.Contains(got, []byte(s)) {
+		b, err := r.ReadByte()
+		if err != nil {
+			t.Fatalf("waiting for %q, got %q: %v", s, got, err)
+		}
+		got = append(got, b)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("no /bin/sh")
+	}
+	if ptm, pts, err := openPTY(); err != nil {
+		t.Skipf("no pty: %v", err)
+	} else {
+		ptm.Close()
+		pts.Close()
+	}
+	failDelay = 0
+
+	c, err := parse([]string{"-p", "pw"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("no loopback: %v", err)
+	}
+	defer l.Close()
+	go c.serve(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	r := bufio.NewReader(conn)
+
+	expect(t, r, string([]byte{cmdIAC, cmdDO, optNAWS}))
+	if _, err := conn.Write([]byte{cmdIAC, cmdWILL, optNAWS, cmdIAC, cmdSB, optNAWS, 0, 100, 0, 40, cmdIAC, cmdSE}); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, r, "Password: ")
+	io.WriteString(conn, "wrong\r\n")
+	expect(t, r, "Login incorrect\r\nPassword: ")
+	io.WriteString(conn, "pw\r\n")
+	io.WriteString(conn, "stty size; echo done$((6*7))\r\n")
+	expect(t, r, "40 100")
+	expect(t, r, "done42")
+	io.WriteString(conn, "exit\r\n")
+	if _, err := io.ReadAll(r); err != nil {
+		t.Errorf("reading until the shell exits: %v", err)
+	}
+}
+
+func TestLoginRefused(t *testing.T) {
+	failDelay = 0
+	c := &cmd{password: []byte("pw")}
+	client, server := net.Pipe()
+	defer client.Close()
+	go c.handle(server)
+
+	r := bufio.NewReader(client)
+	for i := 0; i < tries; i++ {
+		expect(t, r, "Password: ")
+		io.WriteString(client, "guess\r\n")
+	}
+	expect(t, r, "Login incorrect\r\n")
+	if b, err := io.ReadAll(r); err != nil || len(b) != 0 {
+		t.Errorf("after %d tries, read %q, %v, want the connection closed", tries, b, err)
+	}
+}
+
+func TestLoginTimeout(t *testing.T) {
+	defer func(old time.Duration) { loginTimeout = old }(loginTimeout)
+	loginTimeout = 100 * time.Millisecond
+	c := &cmd{password: []byte("pw")}
+	client, server := net.Pipe()
+	defer client.Close()
+	go c.handle(server)
+
+	r := bufio.NewReader(client)
+	expect(t, r, "Password: ")
+	// The client does not answer, and is hung up on.
+	client.SetDeadline(time.Now().Add(10 * time.Second))
+	if b, err := io.ReadAll(r); err != nil || len(b) != 0 {
+		t.Errorf("without a password, read %q, %v, want the connection closed", b, err)
+	}
+}
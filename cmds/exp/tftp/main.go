@@ -8,6 +8,8 @@
 //
 //	Options:
 //		-m <ascii/binary>
+//		--blksize <8-65464>
+//			- Requests a block size other than 512 bytes, RFC 2348
 //
 //	Commands:
 //		q,quit
@@ -43,6 +45,8 @@
 //			- Prints the program/client configuration
 //		timeout <int>
 //			- Sets the total transmission timeout to <int> seconds. Default: 1
+//		blksize <int>
+//			- Sets the block size to request to <int> bytes. Default: 512
 //		trace
 //			- Activates packet tracing (not implemented)
 //		verbose
@@ -54,6 +58,7 @@ import (
 	"io"
 	"log"
 	"os"
+	"strconv"
 
 	flag "github.com/spf13/pflag"
 	tftppkg "github.com/u-root/u-root/pkg/tftp"
@@ -64,6 +69,7 @@ func main() {
 	f := tftppkg.Flags{}
 	flag.StringVarP(&f.Cmd, "c", "c", "", "Execute command as if it had been entered on the tftp prompt.  Must be specified last on the command line.")
 	flag.StringVarP(&f.Mode, "m", "m", "netascii", "Set the default transfer mode to mode.  This is usually used with -c.")
+	flag.IntVar(&f.Blksize, "blksize", 0, "Block size to request, 8 to 65464 bytes, instead of 512.")
 
 	flag.Parse()
 
@@ -86,6 +92,11 @@ func run(f tftppkg.Flags, cmdline, args []string, stdin io.Reader, stdout io.Wri
 	if err != nil {
 		return err
 	}
+	if f.Blksize != 0 {
+		if _, err := tftppkg.ValidateBlksize(strconv.Itoa(f.Blksize)); err != nil {
+			return err
+		}
+	}
 
 	clientcfg := &tftppkg.ClientCfg{
 		Host:    ip,
@@ -93,6 +104,7 @@ func run(f tftppkg.Flags, cmdline, args []string, stdin io.Reader, stdout io.Wri
 		Mode:    m,
 		Rexmt:   tftp.ClientRetransmit(10),
 		Timeout: tftp.ClientTimeout(1),
+		Blksize: f.Blksize,
 		Trace:   false,
 		Literal: f.Literal,
 		Verbose: f.Verbose,
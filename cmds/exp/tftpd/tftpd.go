@@ -0,0 +1,105 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// tftpd serves the files of a directory over TFTP.
+//
+// Synopsis:
+//
+//	tftpd [-a ADDR] [-c] [-v] DIR
+//
+// Description:
+//
+//	tftpd serves the files of DIR, e.g. the network boot programs and
+//	configurations of PXE clients, which can only get files within DIR. The
+//	blksize and tsize options of RFC 2348 and RFC 2349 are supported.
+//
+// Options:
+//
+//	-a: address to listen on (default: :69)
+//	-c: allow clients to create and overwrite files
+//	-v: log transfers
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+
+	tftppkg "github.com/u-root/u-root/pkg/tftp"
+	"pack.ag/tftp"
+)
+
+var (
+	errUsage  = errors.New("usage: tftpd [-a ADDR] [-c] [-v] DIR")
+	errNotDir = errors.New("not a directory")
+)
+
+type cmd struct {
+	addr    string
+	handler *tftppkg.FileHandler
+}
+
+func parse(args []string) (*cmd, error) {
+	f := flag.NewFlagSet("tftpd", flag.ContinueOnError)
+	f.SetOutput(io.Discard)
+	addr := f.String("a", ":69", "Address to listen on")
+	writable := f.Bool("c", false, "Allow clients to create and overwrite files")
+	verbose := f.Bool("v", false, "Log transfers")
+	if err := f.Parse(args); err != nil {
+		return nil, fmt.Errorf("%w: %v", errUsage, err)
+	}
+	if f.NArg() != 1 {
+		return nil, errUsage
+	}
+	fi, err := os.Stat(f.Arg(0))
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("%s: %w", f.Arg(0), errNotDir)
+	}
+	h := &tftppkg.FileHandler{Dir: f.Arg(0), Writable: *writable}
+	if *verbose {
+		h.Log = log.New(os.Stderr, "tftpd: ", log.LstdFlags)
+	}
+	return &cmd{addr: *addr, handler: h}, nil
+}
+
+// serve serves the requests received on conn.
+func (c *cmd) serve(conn *net.UDPConn) error {
+	s, err := tftp.NewServer(c.addr)
+	if err != nil {
+		return err
+	}
+	s.ReadHandler(c.handler)
+	s.WriteHandler(c.handler)
+	return s.Serve(conn)
+}
+
+func (c *cmd) run() error {
+	a, err := net.ResolveUDPAddr("udp", c.addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", a)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return c.serve(conn)
+}
+
+func main() {
+	c, err := parse(os.Args[1:])
+	if err != nil {
+		log.Fatalf("tftpd: %v", err)
+	}
+	if err := c.run(); err != nil {
+		log.Fatalf("tftpd: %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	tftppkg "github.com/u-root/u-root/pkg/tftp"
+	"pack.ag/tftp"
+)
+
+func TestParse(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name     string
+		args     []string
+		addr     string
+		writable bool
+		err      error
+	}{
+		{name: "defaults", args: []string{dir}, addr: ":69"},
+		{name: "flags", args: []string{"-a", "127.0.0.1:6969", "-c", "-v", dir}, addr: "127.0.0.1:6969", writable: true},
+		{name: "no dir", args: []string{}, err: errUsage},
+		{name: "two dirs", args: []string{dir, dir}, err: errUsage},
+		{name: "bad flag", args: []string{"-x", dir}, err: errUsage},
+		{name: "not a dir", args: []string{file}, err: errNotDir},
+		{name: "missing", args: []string{filepath.Join(dir, "missing")}, err: os.ErrNotExist},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := parse(tt.args)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("parse(%q) = %v, want %v", tt.args, err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if c.addr != tt.addr || c.handler.Writable != tt.writable || c.handler.Dir != dir {
+				t.Errorf("parse(%q) = %+v, %+v, want addr %q, writable %v", tt.args, c, c.handler, tt.addr, tt.writable)
+			}
+		})
+	}
+}
+
+func TestServe(t *testing.T) {
+	dir := t.TempDir()
+	want := bytes.Repeat([]byte("kernel"), 500)
+	if err := os.WriteFile(filepath.Join(dir, "vmlinuz"), want, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := parse([]string{"-a", "127.0.0.1:0", dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Skipf("no loopback: %v", err)
+	}
+	defer conn.Close()
+	go c.serve(conn)
+
+	client, err := tftppkg.NewClient(&tftppkg.ClientCfg{Mode: tftp.ModeOctet, Rexmt: tftp.ClientRetransmit(3), Timeout: tftp.ClientTimeout(1), Blksize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get("tftp://" + conn.LocalAddr().String() + "/vmlinuz")
+	if err != nil {
+		t.Fatalf("Get = %v", err)
+	}
+	var got bytes.Buffer
+	if _, err := got.ReadFrom(resp); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Errorf("got %d bytes, want %d", got.Len(), len(want))
+	}
+}
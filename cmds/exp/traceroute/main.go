@@ -5,24 +5,67 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math"
+	"net"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/u-root/u-root/pkg/traceroute"
 	"github.com/u-root/u-root/pkg/uroot/unixflag"
+	"golang.org/x/term"
 )
 
 var errFlags = errors.New("invalid flag/argument usage")
 
-func parseFlags(args []string) (*traceroute.Flags, error) {
+// seconds is a time.Duration flag that, like traceroute's -w, takes a number
+// of seconds, e.g. 5 or 0.5, and also a Go duration, e.g. 500ms.
+type seconds struct {
+	d *time.Duration
+}
+
+func (s seconds) String() string {
+	if s.d == nil {
+		return ""
+	}
+	return s.d.String()
+}
+
+func (s seconds) Set(v string) error {
+	if n, err := strconv.ParseFloat(v, 64); err == nil {
+		if n < 0 || math.IsInf(n, 0) || math.IsNaN(n) || n > math.MaxInt64/float64(time.Second) {
+			return fmt.Errorf("seconds %q out of range", v)
+		}
+		*s.d = time.Duration(n * float64(time.Second))
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return err
+	}
+	*s.d = d
+	return nil
+}
+
+// parseFlags parses the command line into the trace to run and the file
+// dot output is to be written to, "" for stdout.
+func parseFlags(args []string) (*traceroute.Flags, string, error) {
 	flags := &traceroute.Flags{}
 	trargs := &traceroute.Args{}
 
-	var af4, af6 bool
+	var af4, af6, paris bool
+	var port, dscp, ecn, flowLabel uint
+	var fill, tcpProbe, ipOption, prefer, gateways, dotFile string
 
 	f := flag.NewFlagSet(args[0], flag.ExitOnError)
 	// Short form flags - must be provided with a single dash (-)
@@ -30,39 +73,141 @@ func parseFlags(args []string) (*traceroute.Flags, error) {
 	// ALWAYS uses IPv4 in this case.
 	f.BoolVar(&af4, "4", false, "Explicitly force IPv4 tracerouting.")
 	f.BoolVar(&af6, "6", false, "Explicitly force IPv6 tracerouting.")
-	f.UintVar(&flags.DestPortSeq, "p", 0, "Destination port")
-	f.StringVar(&flags.Module, "m", "udp4", "udp, tcp, icmp")
+	f.StringVar(&prefer, "prefer", "ipv4", "IP version to trace with unless forced by -4 or -6, if the host has addresses of both: ipv4 or ipv6")
+	f.UintVar(&port, "p", 0, "Destination port")
+	f.IntVar(&flags.FirstTTL, "f", traceroute.DEFFIRSTHOP, "TTL of the first probes")
+	f.IntVar(&flags.ProbesPerHop, "q", traceroute.DEFNUMTRACES, "Number of probes per hop")
+	flags.Timeout = traceroute.DEFWAITSEC * time.Second
+	f.Var(seconds{&flags.Timeout}, "w", "Seconds to wait for the answer to a probe, e.g. 5 or 0.5, or a duration like 500ms")
+	f.IntVar(&flags.SimultaneousProbes, "N", 0, "Number of probes in flight at once, e.g. 16; 0 sends probes one after the other")
+	f.DurationVar(&flags.Interval, "z", traceroute.DEFINTERVAL, "Pause between two probes")
+	f.StringVar(&flags.Module, "m", "udp4", "udp, tcp, icmp, sctp")
+	f.BoolVar(&flags.ASN, "A", false, "Look up the origin AS of each hop with Team Cymru's whois service")
+	f.BoolVar(&flags.Numeric, "n", false, "Print hop addresses numerically, without reverse DNS lookups")
+	f.BoolVar(&flags.ICMP, "I", false, "Use ICMP ECHO for tracerouting. Same as -m icmp")
+	f.BoolVar(&flags.DontFragment, "F", false, "Do not fragment probes")
+	f.StringVar(&flags.Source, "s", "", "Source address of the probes")
+	f.StringVar(&flags.Interface, "i", "", "Network interface to send the probes on")
+	f.StringVar(&gateways, "g", "", "Comma separated IPv4 gateways to loose source route the probes through")
 
 	// Long form flags - must be provided with two dashes (--)
-	f.UintVar(&flags.DestPortSeq, "port", 0, "Destination port")
-	f.StringVar(&flags.Module, "module", "", "udp, tcp, icmp")
+	f.UintVar(&port, "port", 0, "Destination port")
+	f.BoolVar(&flags.FixedPort, "fixed-port", false, "Send all UDP probes to the destination port instead of counting it up per probe")
+	f.StringVar(&flags.Source, "source", "", "Source address of the probes")
+	f.StringVar(&flags.Interface, "interface", "", "Network interface to send the probes on")
+	f.StringVar(&gateways, "gateway", "", "Comma separated IPv4 gateways to loose source route the probes through")
+	f.IntVar(&flags.MaxTTL, "max-hops", traceroute.DEFNUMHOPS, "Largest TTL probed")
+	f.StringVar(&flags.Module, "module", "", "udp, tcp, icmp, sctp")
 	f.BoolVar(&flags.ICMP, "icmp", false, "Use ICMP method. Same as -m icmp")
 	f.BoolVar(&flags.TCP, "tcp", false, "Use TCP method. Same as -m tcp")
+	f.BoolVar(&flags.DontFragment, "dont-fragment", false, "Do not fragment probes, report links they are too large for")
+	f.StringVar(&ipOption, "ip-option", "none", "IPv4 option to record the path in: rr (record route), ts (timestamps) or none")
+	f.StringVar(&tcpProbe, "tcp-probe", "syn", "Kind of TCP probes: syn, ack or fin")
+	f.BoolVar(&flags.SCTP, "sctp", false, "Use SCTP INIT method. Same as -m sctp")
 	f.BoolVar(&flags.UDP, "udp", true, "Use UDP method. Same as -m udp")
+	f.StringVar(&flags.ASNDB, "asn-db", "", "Look up the origin AS of each hop in this prefix to ASN file instead of using whois")
+	f.StringVar(&flags.GeoIPDB, "geoip-db", "", "Look up the country and city of each hop in this MaxMind DB file")
+	f.StringVar(&flags.DNSServer, "dns-server", "", "Send DNS queries to this server instead of the system resolver")
+	f.DurationVar(&flags.LookupTimeout, "lookup-timeout", 2*time.Second, "Timeout of each DNS and AS lookup")
+	f.StringVar(&flags.Output, "output", traceroute.OutputText, "Output format: "+strings.Join(traceroute.OutputFormats(), ", "))
+	f.StringVar(&dotFile, "dot-file", "", "Write dot output to this file instead of stdout")
+	f.IntVar(&flags.Flows, "flows", 0, "Enumerate load balanced paths using this many UDP flows")
+	f.BoolVar(&paris, "paris", false, "Keep flow identifiers constant so that all probes follow the same load balanced path")
+	f.UintVar(&dscp, "dscp", 0, "DSCP value of the probes, 0-63")
+	f.UintVar(&flowLabel, "flow-label", 0, "IPv6 flow label of the probes, counted up per flow with --flows; 0 leaves it to the kernel")
+	f.UintVar(&ecn, "ecn", 0, "ECN codepoint of the probes: 1 ECT(1), 2 ECT(0) or 3 CE")
+	f.StringVar(&fill, "fill", "", "Hex pattern to fill the probe payload with, e.g. ff00")
+	f.StringVar(&flags.Pcap, "pcap", "", "Record the probes and the messages received in this pcap file")
+	f.BoolVar(&flags.MTU, "mtu", false, "Discover the path MTU with don't fragment UDP probes, like tracepath")
+	f.BoolVar(&flags.Continuous, "mtr", false, "Probe the path continuously and show loss and RTT statistics per hop, like mtr")
+	f.IntVar(&flags.Cycles, "c", 0, "Number of cycles in --mtr mode, 0 runs until interrupted")
+	f.DurationVar(&flags.CycleInterval, "cycle-interval", traceroute.DEFCYCLEINTERVAL, "Pause between two cycles in --mtr mode")
 
 	f.Parse(unixflag.ArgsToGoArgs(args[1:]))
 
 	leftoverArgs := f.Args()
 
-	if len(leftoverArgs) > 1 {
+	if len(leftoverArgs) > 2 {
 		// Error, print help and exit
 		f.Usage()
-		return nil, errFlags
+		return nil, "", errFlags
+	}
+
+	if len(leftoverArgs) < 1 || port > math.MaxUint16 || dscp > traceroute.MAXDSCP || ecn > traceroute.MAXECN || flowLabel > traceroute.MAXFLOWLABEL || flags.Cycles < 0 {
+		f.Usage()
+		return nil, "", errFlags
+	}
+	flags.DestPort = uint16(port)
+	flags.DSCP = uint8(dscp)
+	flags.ECN = uint8(ecn)
+	flags.FlowLabel = uint32(flowLabel)
+
+	// Like traceroute, an optional second argument is the packet length.
+	if len(leftoverArgs) == 2 {
+		n, err := strconv.Atoi(leftoverArgs[1])
+		if err != nil || n < 0 || n > traceroute.MAXDATALEN {
+			f.Usage()
+			return nil, "", errFlags
+		}
+		flags.PacketLen = n
+	}
+	tp, err := traceroute.ParseTCPProbe(tcpProbe)
+	if err != nil {
+		f.Usage()
+		return nil, "", errFlags
+	}
+	flags.TCPProbe = tp
+	opt, err := traceroute.ParseIPOption(ipOption)
+	if err != nil {
+		f.Usage()
+		return nil, "", errFlags
+	}
+	flags.IPOption = opt
+	if gateways != "" {
+		for _, s := range strings.Split(gateways, ",") {
+			gw := net.ParseIP(s)
+			if gw == nil {
+				f.Usage()
+				return nil, "", errFlags
+			}
+			flags.Gateways = append(flags.Gateways, gw)
+		}
+	}
+	if fill != "" {
+		b, err := hex.DecodeString(fill)
+		if err != nil {
+			f.Usage()
+			return nil, "", errFlags
+		}
+		flags.Fill = b
 	}
 
 	trargs.Host = leftoverArgs[0]
 
 	flags.Host = trargs.Host
 
-	// Evaluate AF and Module
+	// Evaluate AF and Module. Unless forced, the addresses of the host
+	// decide, in the order of preference.
+	if prefer != "ipv4" && prefer != "ipv6" {
+		f.Usage()
+		return nil, "", errFlags
+	}
 	af := "4"
-	if !af4 && af6 {
+	switch {
+	case !af4 && af6:
 		af = "6"
+	case !af4 && !af6:
+		flags.AnyFamily = true
+		if prefer == "ipv6" {
+			af = "6"
+		}
 	}
 
-	if (flags.TCP || flags.ICMP || flags.UDP) && flags.Module == "" {
+	if (flags.TCP || flags.SCTP || flags.ICMP || flags.UDP) && flags.Module == "" {
 		if flags.TCP {
 			flags.Module = "tcp"
+		} else if flags.SCTP {
+			flags.Module = "sctp"
 		} else if flags.ICMP {
 			flags.Module = "icmp"
 		} else if flags.UDP {
@@ -70,24 +215,69 @@ func parseFlags(args []string) (*traceroute.Flags, error) {
 		}
 	}
 
+	if paris {
+		flags.Strategy = traceroute.StrategyParis
+	}
+
 	flags.Proto = strings.ToLower(fmt.Sprintf("%s%s", flags.Module, af))
 
-	return flags, nil
+	if _, err := traceroute.NewOutputWriter(flags.Output, io.Discard); err != nil {
+		f.Usage()
+		return nil, "", errFlags
+	}
+	if flags.Output != traceroute.OutputDOT {
+		dotFile = ""
+	}
+
+	return flags, dotFile, nil
 }
 
-func run(args []string) error {
-	flags, err := parseFlags(args)
+func run(ctx context.Context, args []string) error {
+	flags, dotFile, err := parseFlags(args)
 	if err != nil {
 		return err
 	}
+	if flags.Continuous && term.IsTerminal(int(os.Stdout.Fd())) {
+		return monitor(ctx, flags)
+	}
+	out := os.Stdout
+	if dotFile != "" {
+		if out, err = os.Create(dotFile); err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+	if flags.Writer, err = traceroute.NewOutputWriter(flags.Output, out); err != nil {
+		return err
+	}
 	// Pass execution to pkg/traceroute.
 	// Setup can be quite complex with such amount of flags
 	// and the different modules.
-	return traceroute.RunTraceroute(flags)
+	return traceroute.RunTracerouteContext(ctx, flags)
+}
+
+// monitor redraws the statistics of a continuous trace after every
+// cycle. Interrupting it ends the trace.
+func monitor(ctx context.Context, flags *traceroute.Flags) error {
+	var b bytes.Buffer
+	_, err := traceroute.Monitor(ctx, flags, func(r *traceroute.Report) {
+		b.Reset()
+		// Move to the top left corner and clear the screen.
+		b.WriteString("\033[H\033[2J")
+		r.WriteText(&b)
+		os.Stdout.Write(b.Bytes())
+	})
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
 }
 
 func main() {
-	if err := run(os.Args); err != nil {
+	// Interrupting the trace still prints the hops found so far.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := run(ctx, os.Args); err != nil {
 		log.Fatal(err)
 	}
 }
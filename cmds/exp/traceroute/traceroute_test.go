@@ -7,6 +7,7 @@ package main
 import (
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/u-root/u-root/pkg/traceroute"
 )
@@ -36,6 +37,15 @@ func TestParseFlags(t *testing.T) {
 				Proto:  "icmp4",
 			},
 		},
+		{
+			name:    "ShortICMP4",
+			cmdline: []string{"progName", "-4", "-I", "www.google.com"},
+			exp: &traceroute.Flags{
+				Host:   "www.google.com",
+				Module: "icmp",
+				Proto:  "icmp4",
+			},
+		},
 		{
 			name:    "ModuleTCP4",
 			cmdline: []string{"progName", "-4", "-m", "tcp", "www.google.com"},
@@ -108,6 +118,83 @@ func TestParseFlags(t *testing.T) {
 				Proto:  "icmp6",
 			},
 		},
+		{
+			name:    "DirectSCTP4",
+			cmdline: []string{"progName", "-4", "--sctp", "www.google.com"},
+			exp: &traceroute.Flags{
+				Host:   "www.google.com",
+				Module: "sctp",
+				Proto:  "sctp4",
+				SCTP:   true,
+			},
+		},
+		{
+			name:    "TCPProbeACK",
+			cmdline: []string{"progName", "--tcp", "--tcp-probe", "ack", "www.google.com"},
+			exp: &traceroute.Flags{
+				Host:   "www.google.com",
+				Module: "tcp",
+				Proto:  "tcp4",
+				TCP:    true,
+			},
+		},
+		{
+			name:    "FlowLabel",
+			cmdline: []string{"progName", "-6", "--flow-label", "0x12345", "www.google.com"},
+			exp: &traceroute.Flags{
+				Host:   "www.google.com",
+				Module: "udp",
+				Proto:  "udp6",
+			},
+		},
+		{
+			name:    "FailFlowLabel",
+			cmdline: []string{"progName", "-6", "--flow-label", "0x100000", "www.google.com"},
+			err:     errFlags,
+		},
+		{
+			name:    "PreferIPv6",
+			cmdline: []string{"progName", "--prefer", "ipv6", "--dns-server", "192.0.2.53", "www.google.com"},
+			exp: &traceroute.Flags{
+				Host:      "www.google.com",
+				Module:    "udp",
+				Proto:     "udp6",
+				AnyFamily: true,
+				DNSServer: "192.0.2.53",
+			},
+		},
+		{
+			name:    "FailPrefer",
+			cmdline: []string{"progName", "--prefer", "ipx", "www.google.com"},
+			err:     errFlags,
+		},
+		{
+			name:    "FixedPort",
+			cmdline: []string{"progName", "--fixed-port", "-p", "53", "www.google.com"},
+			exp: &traceroute.Flags{
+				Host:   "www.google.com",
+				Module: "udp",
+				Proto:  "udp4",
+			},
+		},
+		{
+			name:    "DontFragment",
+			cmdline: []string{"progName", "-F", "www.google.com"},
+			exp: &traceroute.Flags{
+				Host:   "www.google.com",
+				Module: "udp",
+				Proto:  "udp4",
+			},
+		},
+		{
+			name:    "RecordRoute",
+			cmdline: []string{"progName", "--ip-option", "rr", "www.google.com"},
+			exp: &traceroute.Flags{
+				Host:   "www.google.com",
+				Module: "udp",
+				Proto:  "udp4",
+			},
+		},
 		{
 			name:    "ModuleUDP6",
 			cmdline: []string{"progName", "-6", "-m", "udp", "www.google.com"},
@@ -126,6 +213,120 @@ func TestParseFlags(t *testing.T) {
 				Proto:  "icmp6",
 			},
 		},
+		{
+			name:    "Paris",
+			cmdline: []string{"progName", "-4", "--paris", "www.google.com"},
+			exp: &traceroute.Flags{
+				Host:     "www.google.com",
+				Module:   "udp",
+				Proto:    "udp4",
+				Strategy: traceroute.StrategyParis,
+			},
+		},
+		{
+			name:    "SourceInterface",
+			cmdline: []string{"progName", "-4", "-s", "192.0.2.1", "-i", "eth1", "www.google.com"},
+			exp: &traceroute.Flags{
+				Host:      "www.google.com",
+				Module:    "udp",
+				Proto:     "udp4",
+				Source:    "192.0.2.1",
+				Interface: "eth1",
+			},
+		},
+		{
+			name:    "FailPortRange",
+			cmdline: []string{"progName", "-p", "70000", "www.google.com"},
+			err:     errFlags,
+		},
+		{
+			name:    "FailDSCPRange",
+			cmdline: []string{"progName", "--dscp", "64", "www.google.com"},
+			err:     errFlags,
+		},
+		{
+			name:    "PacketLen",
+			cmdline: []string{"progName", "--fill", "ff00", "www.google.com", "1400"},
+			exp: &traceroute.Flags{
+				Host:   "www.google.com",
+				Module: "udp",
+				Proto:  "udp4",
+				TracerouteOptions: traceroute.TracerouteOptions{
+					PacketLen: 1400,
+					Fill:      []byte{0xff, 0x00},
+				},
+			},
+		},
+		{
+			name:    "FailPacketLen",
+			cmdline: []string{"progName", "www.google.com", "huge"},
+			err:     errFlags,
+		},
+		{
+			name:    "FailFill",
+			cmdline: []string{"progName", "--fill", "xyz", "www.google.com"},
+			err:     errFlags,
+		},
+		{
+			name:    "FailTCPProbe",
+			cmdline: []string{"progName", "--tcp", "--tcp-probe", "xmas", "www.google.com"},
+			err:     errFlags,
+		},
+		{
+			name:    "Gateway",
+			cmdline: []string{"progName", "-g", "192.0.2.1,198.51.100.1", "www.google.com"},
+			exp: &traceroute.Flags{
+				Host:   "www.google.com",
+				Module: "udp",
+				Proto:  "udp4",
+			},
+		},
+		{
+			name:    "FailGateway",
+			cmdline: []string{"progName", "--gateway", "192.0.2.1,router", "www.google.com"},
+			err:     errFlags,
+		},
+		{
+			name:    "FailIPOption",
+			cmdline: []string{"progName", "--ip-option", "lsrr", "www.google.com"},
+			err:     errFlags,
+		},
+		{
+			name:    "OutputCSV",
+			cmdline: []string{"progName", "--output", "csv", "www.google.com"},
+			exp: &traceroute.Flags{
+				Host:   "www.google.com",
+				Module: "udp",
+				Proto:  "udp4",
+				Output: "csv",
+			},
+		},
+		{
+			name:    "FailOutput",
+			cmdline: []string{"progName", "--output", "xml", "www.google.com"},
+			err:     errFlags,
+		},
+		{
+			name:    "MTRCycles",
+			cmdline: []string{"progName", "--mtr", "-c", "10", "www.google.com"},
+			exp: &traceroute.Flags{
+				Host:       "www.google.com",
+				Module:     "udp",
+				Proto:      "udp4",
+				Continuous: true,
+				Cycles:     10,
+			},
+		},
+		{
+			name:    "FailNegativeCycles",
+			cmdline: []string{"progName", "--mtr", "-c", "-1", "www.google.com"},
+			err:     errFlags,
+		},
+		{
+			name:    "FailNoHost",
+			cmdline: []string{"progName", "-4"},
+			err:     errFlags,
+		},
 		{
 			name:    "FailInvalidFlags",
 			cmdline: []string{"progName", "-6", "--udp", "www.google.com", "random stuff to error out", "somemore"},
@@ -139,10 +340,36 @@ func TestParseFlags(t *testing.T) {
 	} {
 		t.Run(tt.name, func(t *testing.T) {
 			tt := tt
-			_, err := parseFlags(tt.cmdline)
+			_, _, err := parseFlags(tt.cmdline)
 			if !errors.Is(err, tt.err) {
 				t.Error(err)
 			}
 		})
 	}
 }
+
+func TestWaitSeconds(t *testing.T) {
+	for _, tt := range []struct {
+		w    string
+		want time.Duration
+	}{
+		{w: "5", want: 5 * time.Second},
+		{w: "0.5", want: 500 * time.Millisecond},
+		{w: "250ms", want: 250 * time.Millisecond},
+	} {
+		flags, _, err := parseFlags([]string{"progName", "-w", tt.w, "www.google.com"})
+		if err != nil {
+			t.Fatalf("parseFlags(-w %s) = %v", tt.w, err)
+		}
+		if flags.Timeout != tt.want {
+			t.Errorf("parseFlags(-w %s) timeout = %v, want %v", tt.w, flags.Timeout, tt.want)
+		}
+	}
+
+	var d time.Duration
+	for _, w := range []string{"-1", "5 seconds", "1e300"} {
+		if err := (seconds{&d}).Set(w); err == nil {
+			t.Errorf("Set(%q) = nil, want error", w)
+		}
+	}
+}
@@ -0,0 +1,388 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+// wg shows and changes the configuration of WireGuard interfaces.
+//
+// Synopsis:
+//
+//	wg [show [INTERFACE | all | interfaces]]
+//	wg showconf INTERFACE
+//	wg set INTERFACE [listen-port PORT] [fwmark MARK] [private-key FILE]
+//		[peer KEY [remove] [preshared-key FILE] [endpoint HOST:PORT]
+//		[persistent-keepalive SECONDS] [allowed-ips IP/CIDR[,IP/CIDR]...]]...
+//	wg setconf INTERFACE FILE
+//	wg addconf INTERFACE FILE
+//	wg genkey | genpsk | pubkey
+//
+// Description:
+//
+//	wg configures interfaces created with ip link add NAME type wireguard,
+//	like wg(8) of wireguard-tools.
+//
+//	show shows the configuration and state of the interfaces, all of them by
+//	default; show interfaces only lists their names. showconf prints the
+//	configuration of an interface in the format of setconf.
+//
+//	set changes the configuration of an interface: the keys are read from
+//	files, MARK, PORT and SECONDS may be off, and allowed-ips replaces the
+//	allowed IPs of the peer.
+//
+//	setconf replaces the configuration of an interface by that of FILE,
+//	while addconf adds the peers of FILE, in the format of wg(8):
+//
+//	[Interface]
+//	PrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=
+//	ListenPort = 51820
+//
+//	[Peer]
+//	PublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=
+//	Endpoint = 192.95.5.67:1234
+//	AllowedIPs = 10.192.122.3/32, 10.192.124.1/24
+//
+//	genkey prints a new private key and genpsk a new preshared key, and
+//	pubkey prints the public key of the private key read from stdin.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/u-root/u-root/pkg/wireguard"
+)
+
+var (
+	errUsage = errors.New("usage: wg [show [INTERFACE | all | interfaces]] | showconf INTERFACE | set INTERFACE ... | setconf INTERFACE FILE | addconf INTERFACE FILE | genkey | genpsk | pubkey")
+	errArg   = errors.New("invalid argument")
+)
+
+// device are the operations on WireGuard interfaces wg uses.
+type device interface {
+	Devices() ([]string, error)
+	Get(name string) (*wireguard.Device, error)
+	Configure(name string, c *wireguard.Config) error
+}
+
+type kernel struct{}
+
+func (kernel) Devices() ([]string, error)                       { return wireguard.Devices() }
+func (kernel) Get(name string) (*wireguard.Device, error)       { return wireguard.Get(name) }
+func (kernel) Configure(name string, c *wireguard.Config) error { return wireguard.Configure(name, c) }
+
+type cmd struct {
+	dev device
+	in  io.Reader
+	out io.Writer
+	now func() time.Time
+}
+
+func plural(n int64, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+// ago formats d, the time since a handshake, like wg does.
+func ago(d time.Duration) string {
+	if d < time.Second {
+		return "Now"
+	}
+	secs := int64(d.Seconds())
+	var parts []string
+	for _, u := range []struct {
+		secs int64
+		name string
+	}{
+		{365 * 24 * 3600, "year"},
+		{24 * 3600, "day"},
+		{3600, "hour"},
+		{60, "minute"},
+		{1, "second"},
+	} {
+		if n := secs / u.secs; n > 0 {
+			parts = append(parts, plural(n, u.name))
+			secs %= u.secs
+		}
+	}
+	return strings.Join(parts, ", ") + " ago"
+}
+
+func size(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	v, i := float64(n)/unit, 0
+	for ; v >= unit && i < 3; i++ {
+		v /= unit
+	}
+	return fmt.Sprintf("%.2f %ciB", v, "KMGT"[i])
+}
+
+func (c *cmd) show(d *wireguard.Device) {
+	fmt.Fprintf(c.out, "interface: %s\n", d.Name)
+	if !d.PublicKey.IsZero() {
+		fmt.Fprintf(c.out, "  public key: %s\n", d.PublicKey)
+	}
+	if !d.PrivateKey.IsZero() {
+		fmt.Fprintf(c.out, "  private key: (hidden)\n")
+	}
+	if d.ListenPort != 0 {
+		fmt.Fprintf(c.out, "  listening port: %d\n", d.ListenPort)
+	}
+	if d.FirewallMark != 0 {
+		fmt.Fprintf(c.out, "  fwmark: %#x\n", d.FirewallMark)
+	}
+
+	for _, p := range d.Peers {
+		fmt.Fprintf(c.out, "\npeer: %s\n", p.PublicKey)
+		if !p.PresharedKey.IsZero() {
+			fmt.Fprintf(c.out, "  preshared key: (hidden)\n")
+		}
+		if p.Endpoint != nil {
+			fmt.Fprintf(c.out, "  endpoint: %s\n", p.Endpoint)
+		}
+		ips := "(none)"
+		if len(p.AllowedIPs) > 0 {
+			s := make([]string, len(p.AllowedIPs))
+			for i, n := range p.AllowedIPs {
+				s[i] = n.String()
+			}
+			ips = strings.Join(s, ", ")
+		}
+		fmt.Fprintf(c.out, "  allowed ips: %s\n", ips)
+		if !p.LastHandshake.IsZero() {
+			fmt.Fprintf(c.out, "  latest handshake: %s\n", ago(c.now().Sub(p.LastHandshake)))
+		}
+		if p.RxBytes != 0 || p.TxBytes != 0 {
+			fmt.Fprintf(c.out, "  transfer: %s received, %s sent\n", size(p.RxBytes), size(p.TxBytes))
+		}
+		if p.PersistentKeepalive != 0 {
+			fmt.Fprintf(c.out, "  persistent keepalive: every %s\n", plural(int64(p.PersistentKeepalive.Seconds()), "second"))
+		}
+	}
+}
+
+func (c *cmd) showAll(args []string) error {
+	if len(args) > 1 {
+		return errUsage
+	}
+	var names []string
+	if len(args) == 1 && args[0] != "all" && args[0] != "interfaces" {
+		names = args
+	} else {
+		var err error
+		if names, err = c.dev.Devices(); err != nil {
+			return err
+		}
+	}
+	if len(args) == 1 && args[0] == "interfaces" {
+		if len(names) > 0 {
+			fmt.Fprintln(c.out, strings.Join(names, " "))
+		}
+		return nil
+	}
+
+	for i, name := range names {
+		d, err := c.dev.Get(name)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			fmt.Fprintln(c.out)
+		}
+		c.show(d)
+	}
+	return nil
+}
+
+func readKey(path string) (*wireguard.Key, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	k, err := wireguard.ParseKey(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &k, nil
+}
+
+func parseOff(name, v string, bits int) (int, error) {
+	if v == "off" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(v, 0, bits)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s %q", errArg, name, v)
+	}
+	return int(n), nil
+}
+
+// parseSet parses the arguments of set after the interface.
+func parseSet(args []string) (*wireguard.Config, error) {
+	c := &wireguard.Config{}
+	var peer *wireguard.PeerConfig
+
+	for len(args) > 0 {
+		name := args[0]
+		// Only remove takes no value.
+		if name == "remove" && peer != nil {
+			peer.Remove = true
+			args = args[1:]
+			continue
+		}
+		if len(args) < 2 {
+			return nil, fmt.Errorf("%w: %s needs a value", errArg, name)
+		}
+		v := args[1]
+		args = args[2:]
+
+		var err error
+		switch name {
+		case "listen-port":
+			var port int
+			port, err = parseOff(name, v, 16)
+			c.ListenPort = &port
+		case "fwmark":
+			var mark int
+			mark, err = parseOff(name, v, 32)
+			c.FirewallMark = &mark
+		case "private-key":
+			c.PrivateKey, err = readKey(v)
+		case "peer":
+			var k wireguard.Key
+			if k, err = wireguard.ParseKey(v); err == nil {
+				c.Peers = append(c.Peers, wireguard.PeerConfig{PublicKey: k})
+				peer = &c.Peers[len(c.Peers)-1]
+			}
+		default:
+			if peer == nil {
+				return nil, fmt.Errorf("%w: %q", errArg, name)
+			}
+			switch name {
+			case "preshared-key":
+				peer.PresharedKey, err = readKey(v)
+			case "endpoint":
+				peer.Endpoint, err = net.ResolveUDPAddr("udp", v)
+			case "persistent-keepalive":
+				var secs int
+				secs, err = parseOff(name, v, 16)
+				d := time.Duration(secs) * time.Second
+				peer.PersistentKeepalive = &d
+			case "allowed-ips":
+				peer.ReplaceAllowedIPs = true
+				peer.AllowedIPs, err = wireguard.ParseAllowedIPs(v)
+			default:
+				return nil, fmt.Errorf("%w: %q", errArg, name)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *cmd) conf(args []string, add bool) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	f, err := os.Open(args[1])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	conf, err := wireguard.ParseConfig(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", args[1], err)
+	}
+	if add {
+		conf.ReplacePeers = false
+		for i := range conf.Peers {
+			conf.Peers[i].ReplaceAllowedIPs = false
+		}
+	}
+	return c.dev.Configure(args[0], conf)
+}
+
+func (c *cmd) run(args []string) error {
+	if len(args) == 0 {
+		return c.showAll(nil)
+	}
+
+	switch args[0] {
+	case "show":
+		return c.showAll(args[1:])
+	case "showconf":
+		if len(args) != 2 {
+			return errUsage
+		}
+		d, err := c.dev.Get(args[1])
+		if err != nil {
+			return err
+		}
+		return d.WriteConfig(c.out)
+	case "set":
+		if len(args) < 2 {
+			return errUsage
+		}
+		conf, err := parseSet(args[2:])
+		if err != nil {
+			return err
+		}
+		return c.dev.Configure(args[1], conf)
+	case "setconf":
+		return c.conf(args[1:], false)
+	case "addconf":
+		return c.conf(args[1:], true)
+	case "genkey", "genpsk":
+		if len(args) != 1 {
+			return errUsage
+		}
+		gen := wireguard.GeneratePrivateKey
+		if args[0] == "genpsk" {
+			gen = wireguard.GenerateKey
+		}
+		k, err := gen()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(c.out, k)
+		return nil
+	case "pubkey":
+		if len(args) != 1 {
+			return errUsage
+		}
+		b, err := io.ReadAll(c.in)
+		if err != nil {
+			return err
+		}
+		k, err := wireguard.ParseKey(strings.TrimSpace(string(b)))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(c.out, k.PublicKey())
+		return nil
+	case "help", "-h", "--help":
+		return errUsage
+	}
+	return fmt.Errorf("%w: unknown command %q", errArg, args[0])
+}
+
+func main() {
+	c := &cmd{dev: kernel{}, in: os.Stdin, out: os.Stdout, now: time.Now}
+	if err := c.run(os.Args[1:]); err != nil {
+		log.Fatalf("wg: %v", err)
+	}
+}
@@ -0,0 +1,281 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/u-root/u-root/pkg/wireguard"
+)
+
+const (
+	privKey = "yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk="
+	pubKey  = "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg="
+	pskKey  = "E4U4nfurq8pmOSj2FVzD1dtlXlj5hDFXCrN9UxUFVCY="
+)
+
+type fakeDevice struct {
+	devices    map[string]*wireguard.Device
+	configured map[string]*wireguard.Config
+}
+
+func (f *fakeDevice) Devices() ([]string, error) {
+	return []string{"wg0", "wg1"}, nil
+}
+
+func (f *fakeDevice) Get(name string) (*wireguard.Device, error) {
+	d, ok := f.devices[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return d, nil
+}
+
+func (f *fakeDevice) Configure(name string, c *wireguard.Config) error {
+	f.configured[name] = c
+	return nil
+}
+
+func key(t *testing.T, s string) wireguard.Key {
+	t.Helper()
+	k, err := wireguard.ParseKey(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func cidr(t *testing.T, s string) net.IPNet {
+	t.Helper()
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *n
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(p, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestParseSet(t *testing.T) {
+	privFile := writeFile(t, "private", privKey+"\n")
+	pskFile := writeFile(t, "psk", pskKey)
+	badFile := writeFile(t, "bad", "key")
+
+	port, off := 51820, 0
+	keepalive := 25 * time.Second
+	priv, psk := key(t, privKey), key(t, pskKey)
+
+	for _, tt := range []struct {
+		name    string
+		args    []string
+		want    *wireguard.Config
+		wantErr error
+	}{
+		{
+			name: "interface",
+			args: []string{"listen-port", "51820", "fwmark", "off", "private-key", privFile},
+			want: &wireguard.Config{ListenPort: &port, FirewallMark: &off, PrivateKey: &priv},
+		},
+		{
+			name: "peers",
+			args: []string{
+				"peer", pubKey, "preshared-key", pskFile, "endpoint", "192.0.2.1:51820", "persistent-keepalive", "25", "allowed-ips", "10.0.0.0/24,fd00::1",
+				"peer", pskKey, "remove",
+			},
+			want: &wireguard.Config{Peers: []wireguard.PeerConfig{
+				{
+					PublicKey:           key(t, pubKey),
+					PresharedKey:        &psk,
+					Endpoint:            &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 51820},
+					PersistentKeepalive: &keepalive,
+					ReplaceAllowedIPs:   true,
+					AllowedIPs:          []net.IPNet{cidr(t, "10.0.0.0/24"), cidr(t, "fd00::1/128")},
+				},
+				{PublicKey: key(t, pskKey), Remove: true},
+			}},
+		},
+		{name: "no value", args: []string{"listen-port"}, wantErr: errArg},
+		{name: "bad port", args: []string{"listen-port", "65536"}, wantErr: errArg},
+		{name: "peer option without peer", args: []string{"endpoint", "192.0.2.1:1"}, wantErr: errArg},
+		{name: "remove without peer", args: []string{"remove"}, wantErr: errArg},
+		{name: "unknown", args: []string{"peer", pubKey, "via", "x"}, wantErr: errArg},
+		{name: "bad key file", args: []string{"private-key", badFile}, wantErr: os.ErrInvalid},
+		{name: "missing key file", args: []string{"private-key", filepath.Join(t.TempDir(), "none")}, wantErr: os.ErrNotExist},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSet(tt.args)
+			if tt.wantErr == os.ErrInvalid {
+				if err == nil {
+					t.Fatalf("parseSet() = %v, want an error", got)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("parseSet() = %v, want %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("parseSet() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestShow(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	f := &fakeDevice{devices: map[string]*wireguard.Device{
+		"wg0": {
+			Name:         "wg0",
+			PrivateKey:   key(t, privKey),
+			PublicKey:    key(t, privKey).PublicKey(),
+			ListenPort:   51820,
+			FirewallMark: 0x10,
+			Peers: []wireguard.Peer{
+				{
+					PublicKey:           key(t, pubKey),
+					PresharedKey:        key(t, pskKey),
+					Endpoint:            &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 51820},
+					AllowedIPs:          []net.IPNet{cidr(t, "10.0.0.0/24"), cidr(t, "fd00::/64")},
+					LastHandshake:       now.Add(-(3661 * time.Second)),
+					RxBytes:             1000,
+					TxBytes:             3 << 20,
+					PersistentKeepalive: 25 * time.Second,
+				},
+				{PublicKey: key(t, pskKey)},
+			},
+		},
+		"wg1": {Name: "wg1"},
+	}}
+
+	for _, tt := range []struct {
+		name string
+		args []string
+		want string
+	}{
+		{
+			name: "all",
+			want: `interface: wg0
+  public key: HIgo9xNzJMWLKASShiTqIybxZ0U3wGLiUeJ1PKf8ykw=
+  private key: (hidden)
+  listening port: 51820
+  fwmark: 0x10
+
+peer: ` + pubKey + `
+  preshared key: (hidden)
+  endpoint: 192.0.2.1:51820
+  allowed ips: 10.0.0.0/24, fd00::/64
+  latest handshake: 1 hour, 1 minute, 1 second ago
+  transfer: 1000 B received, 3.00 MiB sent
+  persistent keepalive: every 25 seconds
+
+peer: ` + pskKey + `
+  allowed ips: (none)
+
+interface: wg1
+`,
+		},
+		{name: "interface", args: []string{"show", "wg1"}, want: "interface: wg1\n"},
+		{name: "interfaces", args: []string{"show", "interfaces"}, want: "wg0 wg1\n"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			c := &cmd{dev: f, out: &out, now: func() time.Time { return now }}
+			if err := c.run(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.want, out.String()); diff != "" {
+				t.Errorf("run(%q) mismatch (-want +got):\n%s", tt.args, diff)
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	conf := writeFile(t, "wg0.conf", `[Interface]
+PrivateKey = `+privKey+`
+
+[Peer]
+PublicKey = `+pubKey+`
+AllowedIPs = 10.0.0.0/24
+`)
+	priv := key(t, privKey)
+	peers := func(replace bool) []wireguard.PeerConfig {
+		return []wireguard.PeerConfig{{PublicKey: key(t, pubKey), ReplaceAllowedIPs: replace, AllowedIPs: []net.IPNet{cidr(t, "10.0.0.0/24")}}}
+	}
+
+	f := &fakeDevice{
+		devices:    map[string]*wireguard.Device{"wg0": {Name: "wg0", ListenPort: 1}},
+		configured: map[string]*wireguard.Config{},
+	}
+	var out bytes.Buffer
+	c := &cmd{dev: f, out: &out, in: strings.NewReader(privKey + "\n"), now: time.Now}
+
+	if err := c.run([]string{"setconf", "wg0", conf}); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(&wireguard.Config{PrivateKey: &priv, ReplacePeers: true, Peers: peers(true)}, f.configured["wg0"]); diff != "" {
+		t.Errorf("setconf mismatch (-want +got):\n%s", diff)
+	}
+	if err := c.run([]string{"addconf", "wg1", conf}); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(&wireguard.Config{PrivateKey: &priv, Peers: peers(false)}, f.configured["wg1"]); diff != "" {
+		t.Errorf("addconf mismatch (-want +got):\n%s", diff)
+	}
+	if err := c.run([]string{"set", "wg2", "listen-port", "off"}); err != nil {
+		t.Fatal(err)
+	}
+	if p := f.configured["wg2"].ListenPort; p == nil || *p != 0 {
+		t.Errorf("set listen-port off = %v, want 0", p)
+	}
+
+	if err := c.run([]string{"pubkey"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.run([]string{"showconf", "wg0"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "HIgo9xNzJMWLKASShiTqIybxZ0U3wGLiUeJ1PKf8ykw=\n[Interface]\nListenPort = 1\n"; out.String() != want {
+		t.Errorf("pubkey and showconf = %q, want %q", out.String(), want)
+	}
+
+	for _, gen := range []string{"genkey", "genpsk"} {
+		out.Reset()
+		if err := c.run([]string{gen}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := wireguard.ParseKey(strings.TrimSpace(out.String())); err != nil {
+			t.Errorf("%s = %q: %v", gen, out.String(), err)
+		}
+	}
+
+	for _, args := range [][]string{{"showconf"}, {"set"}, {"setconf", "wg0"}, {"genkey", "x"}, {"show", "a", "b"}} {
+		if err := c.run(args); !errors.Is(err, errUsage) {
+			t.Errorf("run(%q) = %v, want %v", args, err, errUsage)
+		}
+	}
+	if err := c.run([]string{"up"}); !errors.Is(err, errArg) {
+		t.Errorf("run(up) = %v, want %v", err, errArg)
+	}
+	if err := c.run([]string{"showconf", "wg9"}); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("run(showconf wg9) = %v, want %v", err, os.ErrNotExist)
+	}
+}
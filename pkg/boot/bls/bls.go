@@ -8,6 +8,9 @@
 // entries are supported at the moment, while Type #2 EFI entries are left
 // unimplemented awaiting EFI boot support in u-root/LinuxBoot.
 //
+// Entries are sorted as the spec describes, by boot counter, sort-key,
+// machine-id and version, or else by file name.
+//
 // This package also supports the systemd-boot loader.conf as described in
 // https://www.freedesktop.org/software/systemd/man/loader.conf.html. Only the
 // "default" keyword is implemented.
@@ -19,7 +22,6 @@ import (
 	"log"
 	"os"
 	"path/filepath"
-	"sort"
 	"strconv"
 	"strings"
 
@@ -61,65 +63,31 @@ func ScanBLSEntries(l ulog.Logger, fsRoot string, variables map[string]string, g
 		loaderConf = make(map[string]string)
 	}
 
-	// TODO: Rank entries by version or machine-id attribute as suggested
-	// in the spec (but not mandated, surprisingly).
-	imgs := make(map[string]boot.OSImage)
+	var entries []*entry
 	for _, f := range files {
-		identifier := strings.TrimSuffix(filepath.Base(f), ".conf")
-
-		// If the config file name is the same as the Grub default option, pass true for grubDefaultFlag
-		var img boot.OSImage
-		var err error
-		if strings.Compare(identifier, grubDefaultSavedEntry) == 0 {
-			img, err = parseBLSEntry(f, fsRoot, variables, true)
-		} else {
-			img, err = parseBLSEntry(f, fsRoot, variables, false)
-		}
+		vals, err := parseConf(f)
 		if err != nil {
 			l.Printf("BootLoaderSpec skipping entry %s: %v", f, err)
 			continue
 		}
-		imgs[identifier] = img
-	}
-
-	return sortImages(loaderConf, imgs), nil
-}
-
-func sortImages(loaderConf map[string]string, imgs map[string]boot.OSImage) []boot.OSImage {
-	// rankedImages = sort(default-images) + sort(remaining images)
-	var rankedImages []boot.OSImage
-
-	pattern, ok := loaderConf["default"]
-	if !ok {
-		// All images are default.
-		pattern = "*"
+		entries = append(entries, newEntry(f, vals))
 	}
+	sortEntries(entries)
 
-	var defaultIdents []string
-	var otherIdents []string
-
-	// Find default and non-default identifiers.
-	for ident := range imgs {
-		ok, err := filepath.Match(pattern, ident)
-		if err != nil && ok {
-			defaultIdents = append(defaultIdents, ident)
-		} else {
-			otherIdents = append(otherIdents, ident)
+	// The first entry is the default, unless the Grub default or
+	// loader.conf selects another one, which then ranks higher than the
+	// others.
+	def := defaultEntry(entries, loaderConf["default"], grubDefaultSavedEntry)
+	var imgs []boot.OSImage
+	for i, e := range entries {
+		img, err := parseBLSVals(e.path, e.vals, fsRoot, variables, i == def)
+		if err != nil {
+			l.Printf("BootLoaderSpec skipping entry %s: %v", e.path, err)
+			continue
 		}
+		imgs = append(imgs, img)
 	}
-
-	// Sort them in the order we want them.
-	sort.Sort(sort.Reverse(sort.StringSlice(defaultIdents)))
-	sort.Sort(sort.Reverse(sort.StringSlice(otherIdents)))
-
-	// Add images to rankedImages in that sorted order, defaults first.
-	for _, ident := range defaultIdents {
-		rankedImages = append(rankedImages, imgs[ident])
-	}
-	for _, ident := range otherIdents {
-		rankedImages = append(rankedImages, imgs[ident])
-	}
-	return rankedImages
+	return imgs, nil
 }
 
 func parseConf(entryPath string) (map[string]string, error) {
@@ -273,9 +241,13 @@ func parseBLSEntry(entryPath, fsRoot string, variables map[string]string, grubDe
 	if err != nil {
 		return nil, fmt.Errorf("error parsing config in %s: %w", entryPath, err)
 	}
+	return parseBLSVals(entryPath, vals, fsRoot, variables, grubDefaultFlag)
+}
 
+// parseBLSVals returns the image of the values of the entry entryPath.
+func parseBLSVals(entryPath string, vals map[string]string, fsRoot string, variables map[string]string, grubDefaultFlag bool) (boot.OSImage, error) {
 	var img boot.OSImage
-	err = fmt.Errorf("neither linux, efi, nor multiboot present in BootLoaderSpec config")
+	err := fmt.Errorf("neither linux, efi, nor multiboot present in BootLoaderSpec config")
 	if _, ok := vals["linux"]; ok {
 		img, err = parseLinuxImage(vals, fsRoot, variables, grubDefaultFlag)
 	} else if _, ok := vals["multiboot"]; ok {
@@ -0,0 +1,197 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bls
+
+import (
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// entry is a Type #1 entry, before it is parsed into an image.
+type entry struct {
+	path string
+	vals map[string]string
+
+	// ident is the file name of the entry, without .conf and the boot
+	// counter.
+	ident string
+
+	// bad is set for entries that have no boot tries left.
+	bad bool
+}
+
+// bootCounter matches the boot counter of file names, +LEFT or +LEFT-DONE,
+// as described in https://systemd.io/AUTOMATIC_BOOT_ASSESSMENT.
+var bootCounter = regexp.MustCompile(`\+(\d+)(-\d+)?$`)
+
+func newEntry(path string, vals map[string]string) *entry {
+	e := &entry{
+		path:  path,
+		vals:  vals,
+		ident: strings.TrimSuffix(filepath.Base(path), ".conf"),
+	}
+	if m := bootCounter.FindStringSubmatch(e.ident); m != nil {
+		e.ident = strings.TrimSuffix(e.ident, m[0])
+		left, err := strconv.Atoi(m[1])
+		e.bad = err == nil && left == 0
+	}
+	return e
+}
+
+// less returns whether a sorts before b in the boot menu, as described in
+// the Sorting section of the spec: entries with no boot tries left last,
+// then those with a sort-key by sort-key, machine-id and decreasing
+// version, then the others by decreasing file name.
+func less(a, b *entry) bool {
+	if a.bad != b.bad {
+		return b.bad
+	}
+	ak, aok := a.vals["sort-key"]
+	bk, bok := b.vals["sort-key"]
+	if aok && bok {
+		if ak != bk {
+			return ak < bk
+		}
+		if a.vals["machine-id"] != b.vals["machine-id"] {
+			return a.vals["machine-id"] < b.vals["machine-id"]
+		}
+		if c := compareVersions(a.vals["version"], b.vals["version"]); c != 0 {
+			return c > 0
+		}
+	} else if aok != bok {
+		return aok
+	}
+	return compareVersions(a.ident, b.ident) > 0
+}
+
+func sortEntries(entries []*entry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return less(entries[i], entries[j])
+	})
+}
+
+// defaultEntry returns the index of the default entry of sorted entries:
+// the one named grubDefault, or else the first one that matches the default
+// glob pattern of loader.conf. It returns -1 if neither selects one, in which
+// case the first entry is the default.
+func defaultEntry(entries []*entry, pattern, grubDefault string) int {
+	if grubDefault != "" {
+		for i, e := range entries {
+			if e.ident == grubDefault {
+				return i
+			}
+		}
+	}
+	// Patterns such as @saved refer to EFI variables of systemd-boot.
+	if pattern != "" && !strings.HasPrefix(pattern, "@") {
+		for i, e := range entries {
+			for _, name := range []string{e.ident, e.ident + ".conf"} {
+				if ok, err := filepath.Match(pattern, name); err == nil && ok {
+					return i
+				}
+			}
+		}
+	}
+	return -1
+}
+
+func isVersionChar(c byte) bool {
+	return isDigit(c) || isAlpha(c) || strings.IndexByte("~-^.", c) >= 0
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isAlpha(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+func cmpBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	}
+	return -1
+}
+
+// compareVersions returns -1, 0 or 1 if version a is older than, the same
+// as, or newer than b, as described in
+// https://uapi-group.org/specifications/specs/version_format_specification.
+// E.g. 5.6.6-300.fc32 is newer than 5.6.6-200.fc32, which is newer than
+// 5.6.6~rc1.
+func compareVersions(a, b string) int {
+	for {
+		a = strings.TrimLeftFunc(a, func(r rune) bool { return r > 0x7f || !isVersionChar(byte(r)) })
+		b = strings.TrimLeftFunc(b, func(r rune) bool { return r > 0x7f || !isVersionChar(byte(r)) })
+
+		// Pre-releases, prefixed with ~, are older.
+		if strings.HasPrefix(a, "~") || strings.HasPrefix(b, "~") {
+			if c := cmpBool(!strings.HasPrefix(a, "~"), !strings.HasPrefix(b, "~")); c != 0 {
+				return c
+			}
+			a, b = a[1:], b[1:]
+		}
+
+		// Otherwise, the version that ends first is older.
+		if a == "" || b == "" {
+			return strings.Compare(a, b)
+		}
+
+		// The separators of releases, patches and point releases: a
+		// version with one where the other has none is older.
+		for _, sep := range []byte("-^.") {
+			if first(a) == sep || first(b) == sep {
+				if c := cmpBool(first(a) != sep, first(b) != sep); c != 0 {
+					return c
+				}
+				a, b = a[1:], b[1:]
+			}
+		}
+
+		var as, bs string
+		if isDigit(first(a)) || isDigit(first(b)) {
+			// Numbers are newer than letters, and compare by
+			// value, without leading zeros.
+			as, a = split(a, isDigit)
+			bs, b = split(b, isDigit)
+			if c := cmpBool(as != "", bs != ""); c != 0 {
+				return c
+			}
+			as, bs = strings.TrimLeft(as, "0"), strings.TrimLeft(bs, "0")
+			if c := cmpBool(len(as) > len(bs), len(as) < len(bs)); c != 0 {
+				return c
+			}
+		} else {
+			as, a = split(a, isAlpha)
+			bs, b = split(b, isAlpha)
+		}
+		if c := strings.Compare(as, bs); c != 0 {
+			return c
+		}
+	}
+}
+
+// first returns the first byte of s, or 0 if s is empty.
+func first(s string) byte {
+	if s == "" {
+		return 0
+	}
+	return s[0]
+}
+
+// split splits the prefix of s whose bytes satisfy f from the rest of s.
+func split(s string, f func(byte) bool) (string, string) {
+	i := 0
+	for i < len(s) && f(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
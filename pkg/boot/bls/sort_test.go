@@ -0,0 +1,114 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bls
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	for _, tt := range []struct {
+		a, b string
+		want int
+	}{
+		{"5.6.6-300.fc32", "5.6.6-300.fc32", 0},
+		{"5.6.6-300.fc32", "5.6.6-200.fc32", 1},
+		{"5.10.0", "5.9.0", 1},
+		{"5.6.6", "5.6.6-1", -1},
+		{"5.6.6~rc1", "5.6.6", -1},
+		{"5.6.6~rc1", "5.6.6~rc2", -1},
+		{"5.6.6^1", "5.6.6", 1},
+		{"5.6.6^1", "5.6.6.1", -1},
+		{"007", "7", 0},
+		{"1a", "1b", -1},
+		{"1.a", "1.1", -1},
+		{"0-rescue", "5.6.6-300.fc32", -1},
+	} {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := compareVersions(tt.b, tt.a); got != -tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func TestNewEntry(t *testing.T) {
+	for _, tt := range []struct {
+		path  string
+		ident string
+		bad   bool
+	}{
+		{"/loader/entries/fedora-5.6.6.conf", "fedora-5.6.6", false},
+		{"/loader/entries/fedora-5.6.6+3.conf", "fedora-5.6.6", false},
+		{"/loader/entries/fedora-5.6.6+0-3.conf", "fedora-5.6.6", true},
+		{"/loader/entries/fedora-5.6.6+1-2.conf", "fedora-5.6.6", false},
+	} {
+		e := newEntry(tt.path, nil)
+		if e.ident != tt.ident || e.bad != tt.bad {
+			t.Errorf("newEntry(%q) = (%q, bad %t), want (%q, bad %t)", tt.path, e.ident, e.bad, tt.ident, tt.bad)
+		}
+	}
+}
+
+func TestSortEntries(t *testing.T) {
+	entries := []*entry{
+		newEntry("a-5.6.6+0-3.conf", map[string]string{"sort-key": "a", "version": "5.6.6"}),
+		newEntry("b-0-rescue.conf", nil),
+		newEntry("b-5.6.6.conf", nil),
+		newEntry("f-5.6.6.conf", map[string]string{"sort-key": "fedora", "machine-id": "1", "version": "5.6.6"}),
+		newEntry("f-5.10.0.conf", map[string]string{"sort-key": "fedora", "machine-id": "1", "version": "5.10.0"}),
+		newEntry("f-6.0.0.conf", map[string]string{"sort-key": "fedora", "machine-id": "2", "version": "6.0.0"}),
+		newEntry("d-6.0.0.conf", map[string]string{"sort-key": "debian", "version": "6.0.0"}),
+	}
+	sortEntries(entries)
+
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.path)
+	}
+	want := []string{
+		"d-6.0.0.conf",
+		"f-5.10.0.conf",
+		"f-5.6.6.conf",
+		"f-6.0.0.conf",
+		"b-5.6.6.conf",
+		"b-0-rescue.conf",
+		"a-5.6.6+0-3.conf",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sortEntries() = %v, want %v", got, want)
+	}
+}
+
+func TestDefaultEntry(t *testing.T) {
+	entries := []*entry{
+		newEntry("fedora-5.6.6.conf", nil),
+		newEntry("fedora-0-rescue.conf", nil),
+		newEntry("debian-6.0.0.conf", nil),
+	}
+	for _, tt := range []struct {
+		name        string
+		pattern     string
+		grubDefault string
+		want        int
+	}{
+		{name: "none", want: -1},
+		{name: "pattern", pattern: "*-rescue", want: 1},
+		{name: "pattern with .conf", pattern: "debian-*.conf", want: 2},
+		{name: "first match", pattern: "fedora-*", want: 0},
+		{name: "no match", pattern: "arch-*", want: -1},
+		{name: "EFI variable", pattern: "@saved", want: -1},
+		{name: "grub default", pattern: "fedora-*", grubDefault: "debian-6.0.0", want: 2},
+		{name: "unknown grub default", pattern: "*-rescue", grubDefault: "arch", want: 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := defaultEntry(entries, tt.pattern, tt.grubDefault); got != tt.want {
+				t.Errorf("defaultEntry() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
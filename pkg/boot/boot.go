@@ -8,6 +8,7 @@ package boot
 
 import (
 	"fmt"
+	"io"
 
 	"github.com/u-root/u-root/pkg/boot/kexec"
 	"github.com/u-root/uio/ulog"
@@ -20,6 +21,8 @@ type loadOptions struct {
 	logger        ulog.Logger
 	verbose       bool
 	callKexecLoad bool
+	measurer      Measurer
+	verifier      Verifier
 }
 
 func defaultLoadOptions() *loadOptions {
@@ -63,6 +66,77 @@ func WithDryRun(dryRun bool) LoadOption {
 	}
 }
 
+// Measurer measures the kernel, initramfs and command line of Linux images
+// into a TPM before they are loaded, see package measuredboot.
+type Measurer interface {
+	// MeasureLinux measures li. It may replace li's kernel and
+	// initramfs, e.g. to hand an event log of the measurements to the
+	// kernel. LinuxImage.Load passes a copy of the image it loads, so
+	// the image loaded again is measured afresh.
+	MeasureLinux(li *LinuxImage) error
+}
+
+// WithMeasurer is a LoadOption that measures images with m before they are
+// loaded. Images are only measured when they are loaded with kexec, not with
+// WithDryRun, and those that cannot be measured fail to load.
+func WithMeasurer(m Measurer) LoadOption {
+	return func(o *loadOptions) {
+		o.measurer = m
+	}
+}
+
+// Verifier verifies the signatures of the files of images, such as kernels
+// and initramfs, before they are loaded, see package bootpolicy.
+type Verifier interface {
+	// Verify verifies asset, named by its path or URL.
+	Verify(name string, asset io.ReaderAt) error
+}
+
+// WithVerifier is a LoadOption that verifies the files of images with v
+// before they are loaded, also with WithDryRun. Images whose files do not
+// verify fail to load.
+func WithVerifier(v Verifier) LoadOption {
+	return func(o *loadOptions) {
+		o.verifier = v
+	}
+}
+
+// HasVerifier returns whether opts verify the files of images, for
+// OSImages that must not be loaded unverified.
+func HasVerifier(opts ...LoadOption) bool {
+	loadOpts := defaultLoadOptions()
+	for _, opt := range opts {
+		opt(loadOpts)
+	}
+	return loadOpts.verifier != nil
+}
+
+// VerifyAsset verifies asset with the Verifier of opts, if there is one.
+//
+// OSImages whose files are not a LinuxImage's or a MultibootImage's, such as
+// a FIT or a unified kernel image, call it in Load.
+func VerifyAsset(asset io.ReaderAt, opts ...LoadOption) error {
+	loadOpts := defaultLoadOptions()
+	for _, opt := range opts {
+		opt(loadOpts)
+	}
+	return verifyAsset(loadOpts.verifier, asset)
+}
+
+func verifyAsset(v Verifier, asset io.ReaderAt) error {
+	if v == nil {
+		return nil
+	}
+	if c, ok := asset.(*catInitrd); ok {
+		return c.verify(v)
+	}
+	name := stringer(asset)
+	if err := v.Verify(name, asset); err != nil {
+		return fmt.Errorf("verifying %s: %w", name, err)
+	}
+	return nil
+}
+
 // OSImage represents a bootable OS package.
 type OSImage interface {
 	fmt.Stringer
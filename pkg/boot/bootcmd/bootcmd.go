@@ -21,6 +21,16 @@ import (
 // and exits. If noLoad is false, a boot menu is shown to the user. The
 // user-chosen boot entry will be kexec'd unless noExec is true.
 func ShowMenuAndBoot(entries []menu.Entry, mountPool *mount.Pool, noLoad, noExec bool) {
+	showAndBoot(menu.ShowMenuAndLoad, entries, mountPool, noLoad, noExec)
+}
+
+// ShowTUIAndBoot is ShowMenuAndBoot with the full-screen boot menu of
+// menu.ShowTUIAndLoad.
+func ShowTUIAndBoot(entries []menu.Entry, mountPool *mount.Pool, noLoad, noExec bool) {
+	showAndBoot(menu.ShowTUIAndLoad, entries, mountPool, noLoad, noExec)
+}
+
+func showAndBoot(show func(allowEdit bool, entries ...menu.Entry) menu.Entry, entries []menu.Entry, mountPool *mount.Pool, noLoad, noExec bool) {
 	if noLoad {
 		log.Print("Not loading menu or kernel. Options:")
 		for i, entry := range entries {
@@ -30,7 +40,7 @@ func ShowMenuAndBoot(entries []menu.Entry, mountPool *mount.Pool, noLoad, noExec
 		os.Exit(0)
 	}
 
-	loadedEntry := menu.ShowMenuAndLoad(true, entries...)
+	loadedEntry := show(true, entries...)
 
 	// Clean up.
 	if mountPool != nil {
@@ -0,0 +1,362 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package bootpolicy verifies the signatures of boot assets, such as kernels,
+// initramfs and unified kernel images, against a policy before they are
+// kexec'd.
+//
+// A policy is a JSON file that lists trusted keys and, per asset source, the
+// signatures that assets from it need:
+//
+//	{
+//	  "keys": [
+//	    {"name": "distro", "type": "gpg", "path": "distro.gpg"},
+//	    {"name": "db", "type": "x509", "path": "db.pem"}
+//	  ],
+//	  "rules": [
+//	    {"source": "/boot/", "signatures": ["gpg"], "keys": ["distro"]},
+//	    {"source": "https://boot.example.com/", "signatures": ["authenticode"], "keys": ["db"]},
+//	    {"source": "tftp://10.0.0.1/", "signatures": ["pkcs7"], "keys": ["db"]}
+//	  ]
+//	}
+//
+// Key paths are relative to the policy file. GPG keys are OpenPGP key rings,
+// X.509 keys are PEM or DER certificates that signers must chain to.
+//
+// Sources are paths or URLs. A source covers the assets at its path and below
+// it, by whole path segments, with the same URL scheme and host; sources
+// ending with a slash only cover assets below them. The rule with the longest
+// source path that covers the path or URL of an asset applies. Assets need
+// every signature of their rule, by one of its keys:
+//
+//   - gpg, a detached OpenPGP signature at the asset's path or URL plus
+//     ".sig", by a GPG key;
+//   - pkcs7, a detached DER PKCS#7 signature at the asset's path or URL plus
+//     ".p7s", by an X.509 key;
+//   - authenticode, the Authenticode signature of an EFI stub kernel or a
+//     unified kernel image, by an X.509 key.
+//
+// Rules without signatures trust their source. Assets without a rule fail to
+// verify.
+package bootpolicy
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/u-root/u-root/pkg/boot"
+	"github.com/u-root/u-root/pkg/curl"
+	"github.com/u-root/u-root/pkg/vfile"
+	"github.com/u-root/uio/uio"
+)
+
+// KeyType is the type of a Key.
+type KeyType string
+
+// Key types.
+const (
+	KeyGPG  KeyType = "gpg"
+	KeyX509 KeyType = "x509"
+)
+
+// SignatureType is a signature that a Rule requires.
+type SignatureType string
+
+// Signature types.
+const (
+	GPG          SignatureType = "gpg"
+	PKCS7        SignatureType = "pkcs7"
+	Authenticode SignatureType = "authenticode"
+)
+
+// Suffixes of detached signatures.
+const (
+	GPGSuffix   = ".sig"
+	PKCS7Suffix = ".p7s"
+)
+
+var (
+	// ErrNoRule is returned for assets that no rule applies to.
+	ErrNoRule = errors.New("no policy rule for asset")
+
+	// ErrNoSignature is returned for assets whose detached signature
+	// cannot be read.
+	ErrNoSignature = errors.New("no detached signature")
+)
+
+// Key is a trusted key.
+type Key struct {
+	Name string  `json:"name"`
+	Type KeyType `json:"type"`
+	Path string  `json:"path"`
+}
+
+// Rule is the signatures that assets from Source need.
+type Rule struct {
+	Source     string          `json:"source"`
+	Signatures []SignatureType `json:"signatures"`
+	Keys       []string        `json:"keys"`
+
+	keyring openpgp.EntityList
+	roots   *x509.CertPool
+}
+
+// Policy is a boot policy. It implements boot.Verifier.
+type Policy struct {
+	Keys  []Key  `json:"keys"`
+	Rules []Rule `json:"rules"`
+
+	// Schemes fetch detached signatures. If nil, curl.DefaultSchemes
+	// are used.
+	Schemes curl.Schemes `json:"-"`
+}
+
+var _ boot.Verifier = &Policy{}
+
+// Load reads the policy file at path and the keys it lists.
+//
+// path must be an already trusted path, e.g. the policy is included in the
+// initramfs.
+func Load(path string) (*Policy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p := &Policy{}
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, fmt.Errorf("parsing policy %s: %w", path, err)
+	}
+	if err := p.loadKeys(filepath.Dir(path)); err != nil {
+		return nil, fmt.Errorf("policy %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// loadKeys reads the keys of the rules, relative to dir.
+func (p *Policy) loadKeys(dir string) error {
+	keys := make(map[string]Key)
+	for _, k := range p.Keys {
+		if k.Type != KeyGPG && k.Type != KeyX509 {
+			return fmt.Errorf("key %q has unknown type %q", k.Name, k.Type)
+		}
+		if !filepath.IsAbs(k.Path) {
+			k.Path = filepath.Join(dir, k.Path)
+		}
+		keys[k.Name] = k
+	}
+
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		for _, name := range r.Keys {
+			k, ok := keys[name]
+			if !ok {
+				return fmt.Errorf("rule for %s has unknown key %q", r.Source, name)
+			}
+			switch k.Type {
+			case KeyGPG:
+				ring, err := vfile.GetKeyRing(k.Path)
+				if err != nil {
+					return fmt.Errorf("key %q: %w", name, err)
+				}
+				el, ok := ring.(openpgp.EntityList)
+				if !ok {
+					return fmt.Errorf("key %q is not an OpenPGP key ring", name)
+				}
+				r.keyring = append(r.keyring, el...)
+			case KeyX509:
+				certs, err := readCertificates(k.Path)
+				if err != nil {
+					return fmt.Errorf("key %q: %w", name, err)
+				}
+				if r.roots == nil {
+					r.roots = x509.NewCertPool()
+				}
+				for _, c := range certs {
+					r.roots.AddCert(c)
+				}
+			}
+		}
+
+		for _, s := range r.Signatures {
+			switch s {
+			case GPG:
+				if len(r.keyring) == 0 {
+					return fmt.Errorf("rule for %s needs %s signatures but has no %s keys", r.Source, s, KeyGPG)
+				}
+			case PKCS7, Authenticode:
+				if r.roots == nil {
+					return fmt.Errorf("rule for %s needs %s signatures but has no %s keys", r.Source, s, KeyX509)
+				}
+			default:
+				return fmt.Errorf("rule for %s has unknown signature type %q", r.Source, s)
+			}
+		}
+	}
+	return nil
+}
+
+// readCertificates reads the PEM or DER certificates in path.
+func readCertificates(path string) ([]*x509.Certificate, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.Contains(string(b), "-----BEGIN") {
+		return x509.ParseCertificates(b)
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, c)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates in %s", path)
+	}
+	return certs, nil
+}
+
+// source returns name as a URL. Paths become file:// URLs.
+func source(name string) string {
+	if u, err := url.Parse(name); err == nil && u.Scheme != "" {
+		return name
+	}
+	p := filepath.Clean(name)
+	// Keep the trailing slash of directories, so that /boot/ does not
+	// prefix /bootx.
+	if strings.HasSuffix(name, "/") && p != "/" {
+		p += "/"
+	}
+	return "file://" + p
+}
+
+// sourceURL returns name as a parsed URL. Paths become file:// URLs.
+func sourceURL(name string) (*url.URL, error) {
+	u, err := url.Parse(name)
+	if err == nil && u.Scheme != "" {
+		return u, nil
+	}
+	if strings.Contains(name, "://") {
+		return nil, fmt.Errorf("bad URL %q: %v", name, err)
+	}
+	// Paths may have characters such as # and ?, which are not parsed.
+	return &url.URL{Scheme: "file", Path: strings.TrimPrefix(source(name), "file://")}, nil
+}
+
+// covers returns whether the rule source src covers the asset name: both have
+// the same scheme and host, and the path of name is the path of src or below
+// it, matched by whole path segments. Sources ending with a slash only cover
+// what is below them.
+func covers(src, name *url.URL) bool {
+	if !strings.EqualFold(src.Scheme, name.Scheme) || !strings.EqualFold(src.Host, name.Host) {
+		return false
+	}
+	sp, np := path.Clean("/"+src.Path), path.Clean("/"+name.Path)
+	if sp == "/" {
+		return true
+	}
+	if np == sp {
+		return !strings.HasSuffix(src.Path, "/")
+	}
+	return strings.HasPrefix(np, sp+"/")
+}
+
+// rule returns the rule with the longest source that covers name.
+func (p *Policy) rule(name string) *Rule {
+	u, err := sourceURL(name)
+	if err != nil {
+		return nil
+	}
+	var match *Rule
+	var matchLen int
+	for i, r := range p.Rules {
+		s, err := sourceURL(r.Source)
+		if err != nil || !covers(s, u) {
+			continue
+		}
+		if l := len(path.Clean("/" + s.Path)); match == nil || l > matchLen {
+			match, matchLen = &p.Rules[i], l
+		}
+	}
+	return match
+}
+
+// fetchSignature returns the detached signature of name at name plus suffix.
+func (p *Policy) fetchSignature(name, suffix string) ([]byte, error) {
+	u, err := url.Parse(source(name + suffix))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNoSignature, err)
+	}
+	s := p.Schemes
+	if s == nil {
+		s = curl.DefaultSchemes
+	}
+	f, err := s.Fetch(context.Background(), u)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNoSignature, err)
+	}
+	b, err := uio.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNoSignature, err)
+	}
+	return b, nil
+}
+
+// Verify implements boot.Verifier. It verifies that asset, named by its path
+// or URL, has the signatures of the rule for it.
+func (p *Policy) Verify(name string, asset io.ReaderAt) error {
+	r := p.rule(name)
+	if r == nil {
+		return ErrNoRule
+	}
+	for _, s := range r.Signatures {
+		switch s {
+		case GPG:
+			sig, err := p.fetchSignature(name, GPGSuffix)
+			if err != nil {
+				return err
+			}
+			if err := vfile.CheckDetachedSignature(r.keyring, uio.Reader(asset), bytes.NewReader(sig)); err != nil {
+				return fmt.Errorf("%s signature: %w", s, err)
+			}
+		case PKCS7:
+			sig, err := p.fetchSignature(name, PKCS7Suffix)
+			if err != nil {
+				return err
+			}
+			if _, err := vfile.CheckPKCS7Signature(r.roots, uio.Reader(asset), sig); err != nil {
+				return fmt.Errorf("%s signature: %w", s, err)
+			}
+		case Authenticode:
+			if _, err := vfile.CheckAuthenticode(r.roots, asset); err != nil {
+				return fmt.Errorf("%s signature: %w", s, err)
+			}
+		default:
+			return fmt.Errorf("unknown signature type %q", s)
+		}
+	}
+	return nil
+}
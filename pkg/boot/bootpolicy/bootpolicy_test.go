@@ -0,0 +1,230 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bootpolicy
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	pgperrors "github.com/ProtonMail/go-crypto/openpgp/errors"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/u-root/u-root/pkg/vfile"
+)
+
+var config = &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA}
+
+func newEntity(t *testing.T) *openpgp.Entity {
+	t.Helper()
+	e, err := openpgp.NewEntity("boot", "boot", "boot@example.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeKey(t *testing.T, path string, e *openpgp.Entity) {
+	t.Helper()
+	var b bytes.Buffer
+	if err := e.Serialize(&b); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, path, b.String())
+}
+
+// writeCert writes a self-signed PEM certificate to path.
+func writeCert(t *testing.T, path string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "db"},
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, path, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})))
+}
+
+func writeSigned(t *testing.T, path, content string, e *openpgp.Entity) {
+	t.Helper()
+	writeFile(t, path, content)
+	var sig bytes.Buffer
+	if err := openpgp.DetachSign(&sig, e, strings.NewReader(content), config); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, path+GPGSuffix, sig.String())
+}
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	trusted, untrusted := newEntity(t), newEntity(t)
+	writeKey(t, filepath.Join(dir, "keys", "trusted.gpg"), trusted)
+	writeCert(t, filepath.Join(dir, "keys", "db.pem"))
+	writeFile(t, filepath.Join(dir, "policy.json"), `{
+		"keys": [
+			{"name": "trusted", "type": "gpg", "path": "keys/trusted.gpg"},
+			{"name": "db", "type": "x509", "path": "keys/db.pem"}
+		],
+		"rules": [
+			{"source": "`+dir+`/boot/", "signatures": ["gpg"], "keys": ["trusted"]},
+			{"source": "`+dir+`/boot/efi/", "signatures": ["gpg", "authenticode"], "keys": ["trusted", "db"]},
+			{"source": "`+dir+`/initramfs/"}
+		]
+	}`)
+
+	writeSigned(t, filepath.Join(dir, "boot", "vmlinuz"), "kernel", trusted)
+	writeSigned(t, filepath.Join(dir, "boot", "untrusted"), "kernel", untrusted)
+	writeSigned(t, filepath.Join(dir, "boot", "efi", "vmlinuz.efi"), "kernel", trusted)
+	writeFile(t, filepath.Join(dir, "boot", "unsigned"), "kernel")
+	writeFile(t, filepath.Join(dir, "initramfs", "initrd"), "initrd")
+	writeFile(t, filepath.Join(dir, "bootx", "vmlinuz"), "kernel")
+
+	p, err := Load(filepath.Join(dir, "policy.json"))
+	if err != nil {
+		t.Fatalf("Load() = %v", err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		want error
+	}{
+		{name: "boot/vmlinuz"},
+		{name: "initramfs/initrd"},
+		{name: "boot/untrusted", want: pgperrors.ErrUnknownIssuer},
+		{name: "boot/unsigned", want: ErrNoSignature},
+		{name: "boot/efi/vmlinuz.efi", want: vfile.ErrNoAuthenticode},
+		{name: "bootx/vmlinuz", want: ErrNoRule},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name)
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			if err := p.Verify(path, f); !errors.Is(err, tt.want) {
+				t.Errorf("Verify() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeKey(t, filepath.Join(dir, "key.gpg"), newEntity(t))
+
+	for _, tt := range []struct {
+		name   string
+		policy string
+	}{
+		{
+			name:   "syntax",
+			policy: `{"rules": [`,
+		},
+		{
+			name:   "unknown key type",
+			policy: `{"keys": [{"name": "k", "type": "ssh", "path": "key.gpg"}]}`,
+		},
+		{
+			name:   "unknown key",
+			policy: `{"rules": [{"source": "/boot/", "signatures": ["gpg"], "keys": ["k"]}]}`,
+		},
+		{
+			name:   "missing key file",
+			policy: `{"keys": [{"name": "k", "type": "gpg", "path": "missing.gpg"}], "rules": [{"source": "/boot/", "keys": ["k"]}]}`,
+		},
+		{
+			name:   "no x509 keys",
+			policy: `{"keys": [{"name": "k", "type": "gpg", "path": "key.gpg"}], "rules": [{"source": "/boot/", "signatures": ["pkcs7"], "keys": ["k"]}]}`,
+		},
+		{
+			name:   "unknown signature type",
+			policy: `{"keys": [{"name": "k", "type": "gpg", "path": "key.gpg"}], "rules": [{"source": "/boot/", "signatures": ["md5"], "keys": ["k"]}]}`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "policy.json")
+			writeFile(t, path, tt.policy)
+			if _, err := Load(path); err == nil {
+				t.Errorf("Load() = nil, want error")
+			}
+		})
+	}
+}
+
+func TestRule(t *testing.T) {
+	p := &Policy{Rules: []Rule{
+		{Source: "https://boot.example.com"},
+		{Source: "https://boot.example.com/signed/"},
+		{Source: "tftp://10.0.0.1/boot/a"},
+		{Source: "/boot/"},
+		{Source: "/boot/efi"},
+	}}
+	for _, tt := range []struct {
+		name string
+		want string
+	}{
+		{name: "https://boot.example.com/vmlinuz", want: "https://boot.example.com"},
+		{name: "https://BOOT.example.com/vmlinuz", want: "https://boot.example.com"},
+		{name: "https://boot.example.com/signed/vmlinuz", want: "https://boot.example.com/signed/"},
+		{name: "https://boot.example.com/signedx/vmlinuz", want: "https://boot.example.com"},
+		{name: "https://boot.example.com.attacker.net/vmlinuz"},
+		{name: "https://boot.example.com:8443/vmlinuz"},
+		{name: "https://boot.example.com@attacker.net/vmlinuz"},
+		{name: "http://boot.example.com/vmlinuz"},
+		{name: "https://attacker.net/https://boot.example.com/vmlinuz"},
+		{name: "tftp://10.0.0.1/boot/a", want: "tftp://10.0.0.1/boot/a"},
+		{name: "tftp://10.0.0.1/boot/a/initrd", want: "tftp://10.0.0.1/boot/a"},
+		{name: "tftp://10.0.0.1/boot/ab"},
+		{name: "tftp://10.0.0.1/boot/a/../b"},
+		{name: "tftp://10.0.0.10/boot/a"},
+		{name: "/boot/vmlinuz", want: "/boot/"},
+		{name: "/boot"},
+		{name: "/bootx/vmlinuz"},
+		{name: "/boot/../etc/vmlinuz"},
+		{name: "/boot/efi", want: "/boot/efi"},
+		{name: "/boot/efi/vmlinuz.efi", want: "/boot/efi"},
+		{name: "/boot/efix", want: "/boot/"},
+		{name: "/boot/a#b", want: "/boot/"},
+		{name: "http://[::1"},
+	} {
+		var got string
+		if r := p.rule(tt.name); r != nil {
+			got = r.Source
+		}
+		if got != tt.want {
+			t.Errorf("rule(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,364 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package bootslot implements A/B boot slots with automatic rollback.
+//
+// A machine has two slots, A and B, each holding an OS to boot, e.g. on its
+// own partition. Their State says which slot is active and is kept in a Store:
+// a small state partition or an EFI variable.
+//
+// To upgrade, the running OS installs the new version into the inactive slot
+// and makes it active with SetActive and a number of tries. The boot loader
+// calls Next right before each boot, which counts down the tries of the active
+// slot; Peek tells the slot to boot without counting.
+// Once the new version is up, it calls MarkGood. If it runs out of tries
+// before, e.g. because it does not boot or panics, Next falls back to the
+// previous slot.
+package bootslot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+
+	guid "github.com/google/uuid"
+	"github.com/u-root/u-root/pkg/efivarfs"
+)
+
+// Slot is a boot slot.
+type Slot byte
+
+// Slots.
+const (
+	A Slot = 'a'
+	B Slot = 'b'
+)
+
+// ParseSlot parses the slot names "a" and "b".
+func ParseSlot(s string) (Slot, error) {
+	switch s {
+	case "a", "A":
+		return A, nil
+	case "b", "B":
+		return B, nil
+	}
+	return 0, fmt.Errorf("%q is not a boot slot, want a or b", s)
+}
+
+// Other returns the other slot.
+func (s Slot) Other() Slot {
+	if s == A {
+		return B
+	}
+	return A
+}
+
+// Index returns 0 for A and 1 for B.
+func (s Slot) Index() int {
+	if s == A {
+		return 0
+	}
+	return 1
+}
+
+// String implements fmt.Stringer.
+func (s Slot) String() string {
+	return string(s)
+}
+
+var (
+	// ErrNoState is returned by Stores that hold no state yet.
+	ErrNoState = errors.New("no boot slot state")
+
+	// ErrBadState is returned for corrupted states.
+	ErrBadState = errors.New("bad boot slot state")
+)
+
+// State is the state of the boot slots.
+type State struct {
+	// Active is the slot to boot.
+	Active Slot
+
+	// Tries is the number of boots of Active left before falling back to
+	// the other slot, unless Active is Good.
+	Tries uint8
+
+	// Good is whether Active booted successfully, see MarkGood.
+	Good bool
+
+	// RolledBack is whether Active was made active again because the
+	// other slot ran out of tries.
+	RolledBack bool
+}
+
+// DefaultState is the state of slots without a stored state: A is active
+// and good.
+var DefaultState = State{Active: A, Good: true}
+
+// String implements fmt.Stringer.
+func (s State) String() string {
+	str := fmt.Sprintf("active slot %s", s.Active)
+	if s.Good {
+		str += ", good"
+	} else {
+		str += fmt.Sprintf(", %d tries left", s.Tries)
+	}
+	if s.RolledBack {
+		str += ", rolled back"
+	}
+	return str
+}
+
+// The serialized state is 16 bytes:
+//
+//	offset size
+//	0      4    magic "UBSL"
+//	4      1    version, 1
+//	5      1    active slot, 'a' or 'b'
+//	6      1    tries
+//	7      1    flags: 1 good, 2 rolled back
+//	8      4    reserved, 0
+//	12     4    little-endian CRC-32 (IEEE) of bytes 0 to 11
+const (
+	magic   = "UBSL"
+	version = 1
+	size    = 16
+
+	flagGood       = 1 << 0
+	flagRolledBack = 1 << 1
+)
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (s State) MarshalBinary() ([]byte, error) {
+	if s.Active != A && s.Active != B {
+		return nil, fmt.Errorf("%w: invalid active slot %#x", ErrBadState, byte(s.Active))
+	}
+	b := make([]byte, size)
+	copy(b, magic)
+	b[4] = version
+	b[5] = byte(s.Active)
+	b[6] = s.Tries
+	if s.Good {
+		b[7] |= flagGood
+	}
+	if s.RolledBack {
+		b[7] |= flagRolledBack
+	}
+	binary.LittleEndian.PutUint32(b[12:], crc32.ChecksumIEEE(b[:12]))
+	return b, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler. It returns
+// ErrNoState if b does not start with the magic, e.g. on a blank partition.
+func (s *State) UnmarshalBinary(b []byte) error {
+	if len(b) < size || !bytes.Equal(b[:4], []byte(magic)) {
+		return ErrNoState
+	}
+	if b[4] != version {
+		return fmt.Errorf("%w: unknown version %d", ErrBadState, b[4])
+	}
+	if got, want := binary.LittleEndian.Uint32(b[12:]), crc32.ChecksumIEEE(b[:12]); got != want {
+		return fmt.Errorf("%w: checksum %#x, want %#x", ErrBadState, got, want)
+	}
+	active := Slot(b[5])
+	if active != A && active != B {
+		return fmt.Errorf("%w: invalid active slot %#x", ErrBadState, b[5])
+	}
+	*s = State{
+		Active:     active,
+		Tries:      b[6],
+		Good:       b[7]&flagGood != 0,
+		RolledBack: b[7]&flagRolledBack != 0,
+	}
+	return nil
+}
+
+// Store stores the State of boot slots.
+type Store interface {
+	// Load returns the stored state, or ErrNoState if there is none.
+	Load() (State, error)
+
+	// Save stores s.
+	Save(s State) error
+}
+
+// FileStore stores the state at the start of a file or a block device, such
+// as a small partition.
+type FileStore struct {
+	Path string
+}
+
+var _ Store = &FileStore{}
+
+// Load implements Store.Load.
+func (f *FileStore) Load() (State, error) {
+	file, err := os.Open(f.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return State{}, fmt.Errorf("%w: %v", ErrNoState, err)
+	}
+	if err != nil {
+		return State{}, err
+	}
+	defer file.Close()
+
+	b := make([]byte, size)
+	if _, err := io.ReadFull(file, b); errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return State{}, fmt.Errorf("%w in %s", ErrNoState, f.Path)
+	} else if err != nil {
+		return State{}, err
+	}
+	var s State
+	if err := s.UnmarshalBinary(b); err != nil {
+		return State{}, fmt.Errorf("%s: %w", f.Path, err)
+	}
+	return s, nil
+}
+
+// Save implements Store.Save.
+func (f *FileStore) Save(s State) error {
+	b, err := s.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.Path, os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteAt(b, 0); err != nil {
+		file.Close()
+		return err
+	}
+	// The state must survive the reboot into the slot.
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// StateVar is the EFI variable that EFIVarStore stores the state in.
+var StateVar = efivarfs.VariableDescriptor{
+	Name: "BootSlotState",
+	GUID: guid.MustParse("4c6ad2e1-7b3f-4f0e-9d5a-0e8b6d3c2a91"),
+}
+
+// EFIVarStore stores the state in the EFI variable StateVar.
+type EFIVarStore struct {
+	Vars efivarfs.EFIVar
+}
+
+var _ Store = &EFIVarStore{}
+
+// Load implements Store.Load.
+func (e *EFIVarStore) Load() (State, error) {
+	_, b, err := e.Vars.Get(StateVar)
+	if errors.Is(err, efivarfs.ErrVarNotExist) {
+		return State{}, fmt.Errorf("%w: %v", ErrNoState, err)
+	}
+	if err != nil {
+		return State{}, err
+	}
+	var s State
+	if err := s.UnmarshalBinary(b); err != nil {
+		return State{}, fmt.Errorf("EFI variable %s: %w", StateVar.Name, err)
+	}
+	return s, nil
+}
+
+// Save implements Store.Save.
+func (e *EFIVarStore) Save(s State) error {
+	b, err := s.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	attrs := efivarfs.AttributeNonVolatile | efivarfs.AttributeBootserviceAccess | efivarfs.AttributeRuntimeAccess
+	return e.Vars.Set(StateVar, attrs, b)
+}
+
+// OpenStore returns the Store named by path: "efivar" for an EFIVarStore,
+// anything else is the path of a FileStore.
+func OpenStore(path string) (Store, error) {
+	if path != "efivar" {
+		return &FileStore{Path: path}, nil
+	}
+	vars, err := efivarfs.New()
+	if err != nil {
+		return nil, err
+	}
+	return &EFIVarStore{Vars: vars}, nil
+}
+
+// load returns the state in s, or DefaultState if there is none.
+func load(s Store) (State, error) {
+	st, err := s.Load()
+	if errors.Is(err, ErrNoState) {
+		return DefaultState, nil
+	}
+	return st, err
+}
+
+// Next returns the state with the slot to boot and records the boot attempt
+// in s. If the active slot is not good and out of tries, it falls back to
+// the other slot.
+//
+// A missing or corrupted state is replaced by DefaultState, so that a machine
+// always boots.
+func Next(s Store) (State, error) {
+	st, err := Peek(s)
+	if err != nil {
+		return State{}, err
+	}
+	if err := s.Save(st); err != nil {
+		return State{}, fmt.Errorf("recording boot attempt: %w", err)
+	}
+	return st, nil
+}
+
+// Peek returns the state Next would return, without recording a boot attempt
+// in s, e.g. to pick the slot to boot from before knowing whether it will be
+// booted.
+func Peek(s Store) (State, error) {
+	st, err := load(s)
+	if errors.Is(err, ErrBadState) {
+		st = DefaultState
+	} else if err != nil {
+		return State{}, err
+	}
+
+	if !st.Good {
+		if st.Tries == 0 {
+			st = State{Active: st.Active.Other(), Good: true, RolledBack: true}
+		} else {
+			st.Tries--
+		}
+	}
+	return st, nil
+}
+
+// MarkGood marks the active slot as booted successfully. The OS in it calls
+// it once it is up.
+func MarkGood(s Store) error {
+	st, err := load(s)
+	if err != nil {
+		return err
+	}
+	st.Good = true
+	st.Tries = 0
+	return s.Save(st)
+}
+
+// SetActive makes slot the active slot, e.g. once an upgrade is installed in
+// it. It is booted up to tries times until it is marked good, before falling
+// back to the currently active slot. A slot set active with 0 tries is good
+// right away.
+func SetActive(s Store, slot Slot, tries uint8) error {
+	if slot != A && slot != B {
+		return fmt.Errorf("%w: invalid slot %#x", ErrBadState, byte(slot))
+	}
+	return s.Save(State{Active: slot, Tries: tries, Good: tries == 0})
+}
@@ -0,0 +1,219 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bootslot
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/efivarfs"
+)
+
+type fakeVars map[efivarfs.VariableDescriptor][]byte
+
+func (v fakeVars) Get(desc efivarfs.VariableDescriptor) (efivarfs.VariableAttributes, []byte, error) {
+	data, ok := v[desc]
+	if !ok {
+		return 0, nil, efivarfs.ErrVarNotExist
+	}
+	return efivarfs.AttributeNonVolatile, data, nil
+}
+
+func (v fakeVars) List() ([]efivarfs.VariableDescriptor, error) {
+	var descs []efivarfs.VariableDescriptor
+	for d := range v {
+		descs = append(descs, d)
+	}
+	return descs, nil
+}
+
+func (v fakeVars) Remove(desc efivarfs.VariableDescriptor) error {
+	delete(v, desc)
+	return nil
+}
+
+func (v fakeVars) Set(desc efivarfs.VariableDescriptor, _ efivarfs.VariableAttributes, data []byte) error {
+	v[desc] = data
+	return nil
+}
+
+func TestMarshal(t *testing.T) {
+	for _, s := range []State{
+		DefaultState,
+		{Active: B, Tries: 3},
+		{Active: A, Good: true, RolledBack: true},
+	} {
+		b, err := s.MarshalBinary()
+		if err != nil {
+			t.Fatalf("MarshalBinary(%v) = %v", s, err)
+		}
+		var got State
+		if err := got.UnmarshalBinary(b); err != nil {
+			t.Fatalf("UnmarshalBinary(%v) = %v", s, err)
+		}
+		if got != s {
+			t.Errorf("UnmarshalBinary(MarshalBinary(%v)) = %v", s, got)
+		}
+	}
+
+	if _, err := (State{Active: 'c'}).MarshalBinary(); !errors.Is(err, ErrBadState) {
+		t.Errorf("MarshalBinary(slot c) = %v, want %v", err, ErrBadState)
+	}
+
+	b, _ := DefaultState.MarshalBinary()
+	for _, tt := range []struct {
+		name string
+		b    []byte
+		want error
+	}{
+		{name: "blank", b: make([]byte, size), want: ErrNoState},
+		{name: "short", b: b[:8], want: ErrNoState},
+		{name: "version", b: append([]byte(magic+"\x02"), b[5:]...), want: ErrBadState},
+		{name: "checksum", b: append(b[:6:6], append([]byte{7}, b[7:]...)...), want: ErrBadState},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var s State
+			if err := s.UnmarshalBinary(tt.b); !errors.Is(err, tt.want) {
+				t.Errorf("UnmarshalBinary() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRollback(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		store func(t *testing.T) Store
+	}{
+		{
+			name: "file",
+			store: func(t *testing.T) Store {
+				return &FileStore{Path: filepath.Join(t.TempDir(), "state")}
+			},
+		},
+		{
+			name: "blank partition",
+			store: func(t *testing.T) Store {
+				path := filepath.Join(t.TempDir(), "state")
+				if err := os.WriteFile(path, make([]byte, 4096), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return &FileStore{Path: path}
+			},
+		},
+		{
+			name: "efivar",
+			store: func(t *testing.T) Store {
+				return &EFIVarStore{Vars: fakeVars{}}
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := tt.store(t)
+			next := func(want State) {
+				t.Helper()
+				got, err := Next(s)
+				if err != nil {
+					t.Fatalf("Next() = %v", err)
+				}
+				if got != want {
+					t.Errorf("Next() = %v, want %v", got, want)
+				}
+			}
+
+			next(DefaultState)
+
+			// An upgrade that boots.
+			if err := SetActive(s, B, 2); err != nil {
+				t.Fatalf("SetActive() = %v", err)
+			}
+			next(State{Active: B, Tries: 1})
+			if err := MarkGood(s); err != nil {
+				t.Fatalf("MarkGood() = %v", err)
+			}
+			next(State{Active: B, Good: true})
+			next(State{Active: B, Good: true})
+
+			// An upgrade that fails to boot twice.
+			if err := SetActive(s, A, 2); err != nil {
+				t.Fatalf("SetActive() = %v", err)
+			}
+			next(State{Active: A, Tries: 1})
+			next(State{Active: A, Tries: 0})
+			next(State{Active: B, Good: true, RolledBack: true})
+			next(State{Active: B, Good: true, RolledBack: true})
+
+			// Trusted right away.
+			if err := SetActive(s, A, 0); err != nil {
+				t.Fatalf("SetActive() = %v", err)
+			}
+			next(State{Active: A, Good: true})
+		})
+	}
+}
+
+func TestNextBadState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state")
+	if err := os.WriteFile(path, []byte(magic+"\x01b\x00\x00\x00\x00\x00\x00\xff\xff\xff\xff"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s := &FileStore{Path: path}
+	if _, err := s.Load(); !errors.Is(err, ErrBadState) {
+		t.Errorf("Load() = %v, want %v", err, ErrBadState)
+	}
+	if got, err := Next(s); err != nil || got != DefaultState {
+		t.Errorf("Next() = %v, %v, want %v", got, err, DefaultState)
+	}
+	if got, err := s.Load(); err != nil || got != DefaultState {
+		t.Errorf("Load() after Next() = %v, %v, want %v", got, err, DefaultState)
+	}
+}
+
+func TestPeek(t *testing.T) {
+	s := &FileStore{Path: filepath.Join(t.TempDir(), "state")}
+	if err := SetActive(s, B, 1); err != nil {
+		t.Fatalf("SetActive() = %v", err)
+	}
+	stored := State{Active: B, Tries: 1}
+	want := State{Active: B}
+	for i := 0; i < 2; i++ {
+		if got, err := Peek(s); err != nil || got != want {
+			t.Errorf("Peek() = %v, %v, want %v", got, err, want)
+		}
+		if got, err := s.Load(); err != nil || got != stored {
+			t.Errorf("Load() after Peek() = %v, %v, want %v", got, err, stored)
+		}
+	}
+	if got, err := Next(s); err != nil || got != want {
+		t.Errorf("Next() = %v, %v, want %v", got, err, want)
+	}
+	// Out of tries: both fall back to A.
+	want = State{Active: A, Good: true, RolledBack: true}
+	if got, err := Peek(s); err != nil || got != want {
+		t.Errorf("Peek() = %v, %v, want %v", got, err, want)
+	}
+}
+
+func TestParseSlot(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want Slot
+		err  bool
+	}{
+		{in: "a", want: A},
+		{in: "B", want: B},
+		{in: "c", err: true},
+	} {
+		got, err := ParseSlot(tt.in)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("ParseSlot(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+	if A.Other() != B || B.Other() != A || A.Index() != 0 || B.Index() != 1 {
+		t.Errorf("Other or Index are wrong")
+	}
+}
@@ -0,0 +1,344 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/boot"
+	"github.com/u-root/u-root/pkg/dt"
+	"github.com/u-root/uio/uio"
+)
+
+func stringList(name string, values ...string) dt.Property {
+	return dt.Property{Name: name, Value: []byte(strings.Join(values, "\x00") + "\x00")}
+}
+
+func u32(name string, v uint32) dt.Property {
+	return dt.Property{Name: name, Value: []byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}}
+}
+
+func u64(name string, v uint64) dt.Property {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return dt.Property{Name: name, Value: b}
+}
+
+func fdtBytes(t *testing.T, root *dt.Node) []byte {
+	t.Helper()
+	fdt := &dt.FDT{
+		Header:   dt.Header{Magic: dt.Magic, Version: 17, LastCompVersion: 16},
+		RootNode: root,
+	}
+	var b bytes.Buffer
+	if _, err := fdt.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// imageNode returns an image node with data and hash nodes of algos.
+func imageNode(name, typ string, data []byte, algos ...string) *dt.Node {
+	var children []*dt.Node
+	for i, algo := range algos {
+		h := hashes[algo]()
+		h.Write(data)
+		children = append(children, dt.NewNode(fmt.Sprintf("hash-%d", i+1), dt.WithProperty(
+			dt.PropertyString("algo", algo),
+			dt.Property{Name: "value", Value: h.Sum(nil)},
+		)))
+	}
+	return dt.NewNode(name, dt.WithProperty(
+		dt.PropertyString("type", typ),
+		dt.Property{Name: "data", Value: data},
+	), dt.WithChildren(children...))
+}
+
+// writeFIT writes a FIT of images and configs to a file and returns its path.
+// extra is appended to the FIT, as the external data of mkimage -E.
+func writeFIT(t *testing.T, images, configs []*dt.Node, extra []byte) string {
+	t.Helper()
+	root := dt.NewNode("", dt.WithChildren(
+		dt.NewNode("images", dt.WithChildren(images...)),
+		dt.NewNode("configurations", dt.WithProperty(dt.PropertyString("default", configs[0].Name)), dt.WithChildren(configs...)),
+	))
+	b := fdtBytes(t, root)
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	path := filepath.Join(t.TempDir(), "image.itb")
+	if err := os.WriteFile(path, append(b, extra...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func boardDTB(t *testing.T, compatible ...string) []byte {
+	return fdtBytes(t, dt.NewNode("", dt.WithProperty(stringList("compatible", compatible...))))
+}
+
+func TestSelectConfig(t *testing.T) {
+	path := writeFIT(t, []*dt.Node{
+		imageNode("kernel", "kernel", []byte("kernel"), "sha256"),
+		imageNode("fdt-evk", "flat_dt", boardDTB(t, "acme,board-evk", "acme,soc"), "crc32"),
+		imageNode("fdt-pro", "flat_dt", boardDTB(t, "acme,board-pro", "acme,soc"), "crc32"),
+	}, []*dt.Node{
+		dt.NewNode("conf-generic", dt.WithProperty(dt.PropertyString("kernel", "kernel"), stringList("compatible", "acme,soc"))),
+		dt.NewNode("conf-evk", dt.WithProperty(dt.PropertyString("kernel", "kernel"), dt.PropertyString("fdt", "fdt-evk"))),
+		dt.NewNode("conf-pro", dt.WithProperty(dt.PropertyString("kernel", "kernel"), dt.PropertyString("fdt", "fdt-pro"))),
+	}, nil)
+	i, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		compatible []string
+		want       string
+		wantErr    error
+	}{
+		{compatible: []string{"acme,board-pro", "acme,soc"}, want: "conf-pro"},
+		{compatible: []string{"acme,board-evk", "acme,soc"}, want: "conf-evk"},
+		{compatible: []string{"acme,board-new", "acme,soc"}, want: "conf-generic"},
+		{compatible: []string{"other,board"}, wantErr: ErrNoCompatibleConfig},
+	} {
+		got, err := i.SelectConfig(tt.compatible)
+		if got != tt.want || !errors.Is(err, tt.wantErr) {
+			t.Errorf("SelectConfig(%q) = %q, %v, want %q, %v", tt.compatible, got, err, tt.want, tt.wantErr)
+		}
+	}
+
+	// GetConfigName falls back to the default.
+	i.Compatible = []string{"other,board"}
+	if got, err := i.GetConfigName(); got != "conf-generic" || err != nil {
+		t.Errorf("GetConfigName() = %q, %v, want conf-generic", got, err)
+	}
+	i.Compatible = []string{"acme,board-evk"}
+	if err := i.ApplyConfig(); err != nil {
+		t.Fatalf("ApplyConfig() = %v", err)
+	}
+	if i.Kernel != "kernel" || i.DTB != "fdt-evk" {
+		t.Errorf("ApplyConfig() set kernel %q and DTB %q, want kernel and fdt-evk", i.Kernel, i.DTB)
+	}
+}
+
+func TestSystemCompatible(t *testing.T) {
+	defer func(old string) { compatiblePath = old }(compatiblePath)
+	compatiblePath = filepath.Join(t.TempDir(), "compatible")
+	if err := os.WriteFile(compatiblePath, []byte("acme,board-evk\x00acme,soc\x00"), 0o444); err != nil {
+		t.Fatal(err)
+	}
+	got, err := SystemCompatible()
+	if want := []string{"acme,board-evk", "acme,soc"}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("SystemCompatible() = %q, %v, want %q", got, err, want)
+	}
+}
+
+// verifyHashes verifies the hashes of image against its data in i.
+func verifyHashes(i *Image, image string) error {
+	r, err := i.ReadImage(image)
+	if err != nil {
+		return err
+	}
+	data, err := uio.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return i.VerifyHashes(image, data)
+}
+
+func TestVerifyHashes(t *testing.T) {
+	bad := imageNode("bad", "kernel", []byte("kernel"), "sha1")
+	bad.UpdateProperty("data", []byte("evil"))
+	path := writeFIT(t, []*dt.Node{
+		imageNode("none", "kernel", []byte("kernel")),
+		imageNode("all", "kernel", []byte("kernel"), "crc32", "md5", "sha1", "sha256", "sha384", "sha512"),
+		bad,
+		dt.NewNode("unsupported", dt.WithProperty(dt.Property{Name: "data", Value: []byte("kernel")}), dt.WithChildren(
+			dt.NewNode("hash-1", dt.WithProperty(dt.PropertyString("algo", "md4"), dt.Property{Name: "value", Value: []byte{1}})),
+		)),
+		dt.NewNode("novalue", dt.WithProperty(dt.Property{Name: "data", Value: []byte("kernel")}), dt.WithChildren(
+			dt.NewNode("hash-1", dt.WithProperty(dt.PropertyString("algo", "sha1"))),
+		)),
+	}, []*dt.Node{
+		dt.NewNode("conf", dt.WithProperty(dt.PropertyString("kernel", "all"))),
+	}, nil)
+	i, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		image   string
+		wantErr bool
+		want    error
+	}{
+		{image: "none"},
+		{image: "all"},
+		{image: "bad", wantErr: true, want: ErrHashMismatch},
+		{image: "unsupported", wantErr: true},
+		{image: "novalue", wantErr: true},
+		{image: "missing", wantErr: true},
+	} {
+		err := verifyHashes(i, tt.image)
+		if (err != nil) != tt.wantErr || (tt.want != nil && !errors.Is(err, tt.want)) {
+			t.Errorf("VerifyHashes(%s) = %v, want error %t %v", tt.image, err, tt.wantErr, tt.want)
+		}
+	}
+
+	// The data passed in is hashed, not the data in the FIT.
+	if err := i.VerifyHashes("all", []byte("evil")); !errors.Is(err, ErrHashMismatch) {
+		t.Errorf("VerifyHashes(all, evil) = %v, want %v", err, ErrHashMismatch)
+	}
+
+	// The hashes of the images of the test image were written by
+	// mkimage.
+	i, err = New("testdata/fitimage.itb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, image := range []string{"kernel@0", "kernel@1", "ramdisk@0"} {
+		if err := verifyHashes(i, image); err != nil {
+			t.Errorf("VerifyHashes(%s) = %v", image, err)
+		}
+	}
+}
+
+func TestLoadMultipleImages(t *testing.T) {
+	dtb := boardDTB(t, "acme,board")
+	extKernel := []byte("external kernel")
+	ext := imageNode("kernel", "kernel", extKernel, "sha256")
+	ext.RemoveProperty("data")
+	ext.Properties = append(ext.Properties, u32("data-offset", 0), u32("data-size", uint32(len(extKernel))))
+
+	bad := imageNode("bad-ramdisk", "ramdisk", []byte("ramdisk"), "sha256")
+	bad.UpdateProperty("data", []byte("evil"))
+
+	path := writeFIT(t, []*dt.Node{
+		ext,
+		imageNode("ramdisk", "ramdisk", []byte("ramdisk"), "sha256"),
+		imageNode("modules", "ramdisk", []byte("modules"), "sha256"),
+		imageNode("firmware", "firmware", []byte("firmware"), "sha256"),
+		imageNode("fdt", "flat_dt", dtb, "sha256"),
+		bad,
+	}, []*dt.Node{
+		dt.NewNode("conf", dt.WithProperty(
+			dt.PropertyString("kernel", "kernel"),
+			dt.PropertyString("ramdisk", "ramdisk"),
+			stringList("loadables", "firmware", "modules"),
+			dt.PropertyString("fdt", "fdt"),
+		)),
+		dt.NewNode("conf-bad", dt.WithProperty(
+			dt.PropertyString("kernel", "kernel"),
+			dt.PropertyString("ramdisk", "bad-ramdisk"),
+		)),
+		dt.NewNode("conf-overlay", dt.WithProperty(
+			dt.PropertyString("kernel", "kernel"),
+			stringList("fdt", "fdt", "overlay"),
+		)),
+	}, extKernel)
+
+	defer func(old func(i *boot.LinuxImage, opts ...boot.LoadOption) error) { loadImage = old }(loadImage)
+	var loaded *boot.LinuxImage
+	loadImage = func(li *boot.LinuxImage, opts ...boot.LoadOption) error {
+		loaded = li
+		return nil
+	}
+
+	i, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := i.ApplyConfig(); err != nil {
+		t.Fatalf("ApplyConfig() = %v", err)
+	}
+	if i.InitRAMFS != "ramdisk" || !reflect.DeepEqual(i.Loadables, []string{"modules"}) {
+		t.Errorf("ApplyConfig() set initramfs %q and loadables %q, want ramdisk and [modules]", i.InitRAMFS, i.Loadables)
+	}
+	if err := i.Load(); err != nil {
+		t.Fatalf("Load() = %v", err)
+	}
+	for _, c := range []struct {
+		name string
+		got  []byte
+		want []byte
+	}{
+		{name: "kernel", got: readAll(t, loaded.Kernel), want: extKernel},
+		{name: "initrd", got: readAll(t, loaded.Initrd), want: []byte("ramdisk" + strings.Repeat("\x00", 512-7) + "modules")},
+		{name: "dtb", got: readAll(t, loaded.DTB), want: dtb},
+	} {
+		if !bytes.Equal(c.got, c.want) {
+			t.Errorf("loaded %s %q, want %q", c.name, c.got, c.want)
+		}
+	}
+
+	i.ConfigOverride = "conf-bad"
+	if err := i.ApplyConfig(); err != nil {
+		t.Fatalf("ApplyConfig() = %v", err)
+	}
+	if err := i.Load(); !errors.Is(err, ErrHashMismatch) {
+		t.Errorf("Load() = %v, want %v", err, ErrHashMismatch)
+	}
+
+	i.ConfigOverride = "conf-overlay"
+	if err := i.ApplyConfig(); err == nil {
+		t.Errorf("ApplyConfig() with FDT overlays = nil, want error")
+	}
+
+	// External data needs the file.
+	i.ConfigOverride, i.File = "conf", nil
+	if _, err := i.ReadImage("kernel"); err == nil {
+		t.Errorf("ReadImage() of external data without File = nil, want error")
+	}
+}
+
+func readAll(t *testing.T, r interface {
+	ReadAt([]byte, int64) (int, error)
+},
+) []byte {
+	t.Helper()
+	b, err := uio.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestExternalDataOutOfRange(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		props []dt.Property
+	}{
+		{name: "huge size", props: []dt.Property{u32("data-offset", 0), u64("data-size", math.MaxUint64)}},
+		{name: "huge position", props: []dt.Property{u64("data-position", math.MaxUint64), u32("data-size", 4)}},
+		{name: "size past EOF", props: []dt.Property{u32("data-offset", 0), u32("data-size", 1<<30)}},
+		{name: "offset past EOF", props: []dt.Property{u32("data-offset", 64), u32("data-size", 4)}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			kernel := imageNode("kernel", "kernel", nil)
+			kernel.RemoveProperty("data")
+			kernel.Properties = append(kernel.Properties, tt.props...)
+			path := writeFIT(t, []*dt.Node{kernel}, []*dt.Node{
+				dt.NewNode("conf", dt.WithProperty(dt.PropertyString("kernel", "kernel"))),
+			}, []byte("external kernel"))
+			i, err := New(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := i.ReadImage("kernel"); err == nil {
+				t.Errorf("ReadImage() = nil, want error")
+			}
+		})
+	}
+}
@@ -8,13 +8,16 @@ package fit
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"slices"
 
 	"github.com/ProtonMail/go-crypto/openpgp"
 	"github.com/u-root/u-root/pkg/boot"
 	"github.com/u-root/u-root/pkg/dt"
+	"github.com/u-root/uio/uio"
 )
 
 // Image is a Flattened Image Tree implementation for OSImage.
@@ -28,14 +31,26 @@ type Image struct {
 	Kernel string
 	// InitRAMFS is the name of the initramfs node.
 	InitRAMFS string
+	// Loadables are the names of the optional ramdisk nodes whose
+	// contents are appended to the initramfs.
+	Loadables []string
+	// DTB is the name of the optional flat_dt node handed to the kernel.
+	DTB string
 	// ConfigOverride is the optional FIT config to use instead of default
 	ConfigOverride string
+	// Compatible are the optional compatible strings of the machine, most
+	// specific first, to select the config by instead of the default, see
+	// SelectConfig.
+	Compatible []string
 	// SkipInitRAMFS skips the search for an ramdisk entry in the config
 	SkipInitRAMFS bool
 	// BootRank ranks the priority of the images in boot menu
 	BootRank int
 	// KeyRing is the optional set of public keys used to validate images at Load
 	KeyRing openpgp.KeyRing
+	// File is the optional FIT file. If set, Load verifies it as a whole
+	// with the boot.Verifier it is given, instead of the images in it.
+	File io.ReaderAt
 }
 
 var _ = boot.OSImage(&Image{})
@@ -51,7 +66,7 @@ func New(n string) (*Image, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Image{name: n, Root: fdt}, nil
+	return &Image{name: n, Root: fdt, File: uio.NewLazyFile(n)}, nil
 }
 
 // ParseConfig reads r for a FIT image and returns a OSImage for each
@@ -68,11 +83,7 @@ func ParseConfig(r io.ReadSeeker) ([]Image, error) {
 
 	for _, n := range cn {
 		i := Image{name: n, Root: fdt, ConfigOverride: n}
-
-		kn, in, err := i.LoadConfig()
-
-		if err == nil {
-			i.Kernel, i.InitRAMFS = kn, in
+		if err := i.ApplyConfig(); err == nil {
 			images = append(images, i)
 		}
 	}
@@ -117,47 +128,75 @@ var loadImage = loadLinuxImage
 
 // Load loads an image and reboots
 func (i *Image) Load(opts ...boot.LoadOption) error {
+	if i.File != nil {
+		if err := boot.VerifyAsset(i.File, opts...); err != nil {
+			return err
+		}
+		opts = append(opts, boot.WithVerifier(nil))
+	}
+
 	image := &boot.LinuxImage{
 		Cmdline: i.Cmdline,
 	}
 
-	if i.KeyRing != nil {
-		kr, err := i.ReadSignedImage(i.Kernel, i.KeyRing)
-		if err != nil {
-			return err
+	kr, err := i.readVerifiedImage(i.Kernel)
+	if err != nil {
+		return err
+	}
+	image.Kernel = kr
+
+	var initrds []io.ReaderAt
+	for _, n := range append([]string{i.InitRAMFS}, i.Loadables...) {
+		if len(n) == 0 {
+			continue
 		}
-		image.Kernel = kr
-	} else {
-		kr, err := i.ReadImage(i.Kernel)
+		ir, err := i.readVerifiedImage(n)
 		if err != nil {
 			return err
 		}
-		image.Kernel = kr
+		initrds = append(initrds, ir)
+	}
+	switch len(initrds) {
+	case 0:
+	case 1:
+		image.Initrd = initrds[0]
+	default:
+		image.Initrd = boot.CatInitrds(initrds...)
 	}
 
-	if len(i.InitRAMFS) != 0 {
-		if i.KeyRing != nil {
-			ir, err := i.ReadSignedImage(i.InitRAMFS, i.KeyRing)
-			if err != nil {
-				return err
-			}
-			image.Initrd = ir
-		} else {
-			ir, err := i.ReadImage(i.InitRAMFS)
-			if err != nil {
-				return err
-			}
-			image.Initrd = ir
+	if len(i.DTB) != 0 {
+		dtb, err := i.readVerifiedImage(i.DTB)
+		if err != nil {
+			return err
 		}
+		image.DTB = dtb
 	}
 
 	return loadImage(image, opts...)
 }
 
+// readVerifiedImage reads an image node, verifying its signatures against
+// KeyRing, if set, and its hashes. The data is read once, so what is verified
+// is what is returned.
+func (i *Image) readVerifiedImage(image string) (*bytes.Reader, error) {
+	b, err := i.imageData(image)
+	if err != nil {
+		return nil, err
+	}
+	if i.KeyRing != nil {
+		if _, err := i.verifySignatures(image, b, i.KeyRing); err != nil {
+			return nil, err
+		}
+	}
+	if err := i.VerifyHashes(image, b); err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(b), nil
+}
+
 // ReadImage reads an image node from an FDT and returns the `data` contents.
 func (i *Image) ReadImage(image string) (*bytes.Reader, error) {
-	root := i.Root.Root().Walk("images").Walk(image)
-	b, err := root.Property("data").AsBytes()
+	b, err := i.imageData(image)
 	if err != nil {
 		return nil, err
 	}
@@ -165,11 +204,17 @@ func (i *Image) ReadImage(image string) (*bytes.Reader, error) {
 }
 
 // GetConfigName finds the name of the default configuration or returns the
-// override config if available
+// override config if available. With Compatible, it is the configuration
+// that SelectConfig selects, if any.
 func (i *Image) GetConfigName() (string, error) {
 	if len(i.ConfigOverride) != 0 {
 		return i.ConfigOverride, nil
 	}
+	if len(i.Compatible) != 0 {
+		if c, err := i.SelectConfig(i.Compatible); err == nil {
+			return c, nil
+		}
+	}
 
 	configs := i.Root.Root().Walk("configurations")
 	dc, err := configs.Property("default").AsString()
@@ -180,6 +225,152 @@ func (i *Image) GetConfigName() (string, error) {
 	return dc, nil
 }
 
+// Config is a configuration of a FIT image.
+type Config struct {
+	Name        string
+	Description string
+	// Kernel is the name of the kernel node.
+	Kernel string
+	// Ramdisks are the names of the ramdisk node and of the loadables
+	// that are ramdisks, whose contents make up the initramfs.
+	Ramdisks []string
+	// FDT is the name of the optional flat_dt node.
+	FDT string
+	// Compatible are the compatible strings of the configuration, or else
+	// those of the root node of its FDT.
+	Compatible []string
+}
+
+// ReadConfig reads the configuration name from a FIT image.
+//
+// Loadables other than ramdisks, such as firmware, are left out, as kexec
+// has nowhere to load them. FDT overlays are not supported.
+func (i *Image) ReadConfig(name string) (*Config, error) {
+	config := i.Root.Root().Walk("configurations").Walk(name)
+	if _, err := config.AsString(); err != nil {
+		return nil, err
+	}
+
+	c := &Config{Name: name}
+	c.Description, _ = config.Property("description").AsString()
+
+	var err error
+	c.Kernel, err = config.Property("kernel").AsString()
+	if err != nil {
+		return nil, err
+	}
+
+	// Allow missing initram nodes
+	if rn, err := config.Property("ramdisk").AsString(); err == nil {
+		c.Ramdisks = append(c.Ramdisks, rn)
+	}
+	loadables, _ := config.Property("loadables").AsStringList()
+	for _, l := range loadables {
+		t, err := i.Root.Root().Walk("images").Walk(l).Property("type").AsString()
+		if err != nil {
+			return nil, fmt.Errorf("config %s: loadable %s: %w", name, l, err)
+		}
+		if t == "ramdisk" {
+			c.Ramdisks = append(c.Ramdisks, l)
+		}
+	}
+
+	if fdts, err := config.Property("fdt").AsStringList(); err == nil && len(fdts) > 0 {
+		if len(fdts) > 1 {
+			return nil, fmt.Errorf("config %s: FDT overlays %v are not supported", name, fdts[1:])
+		}
+		c.FDT = fdts[0]
+	}
+
+	if compat, err := config.Property("compatible").AsStringList(); err == nil {
+		c.Compatible = compat
+	} else if c.FDT != "" {
+		c.Compatible = i.fdtCompatible(c.FDT)
+	}
+	return c, nil
+}
+
+// fdtCompatible returns the compatible strings of the root node of the FDT in
+// an image node, if any.
+func (i *Image) fdtCompatible(image string) []string {
+	b, err := i.imageData(image)
+	if err != nil {
+		return nil
+	}
+	fdt, err := dt.ReadFDT(bytes.NewReader(b))
+	if err != nil {
+		return nil
+	}
+	compat, _ := fdt.Root().Property("compatible").AsStringList()
+	return compat
+}
+
+// ErrNoCompatibleConfig is returned by SelectConfig if no configuration
+// matches.
+var ErrNoCompatibleConfig = errors.New("no compatible FIT configuration")
+
+// SelectConfig returns the name of the configuration that best matches
+// compatible, the compatible strings of a machine, most specific first, like
+// U-Boot does: that with the most specific match, or the first of those.
+func (i *Image) SelectConfig(compatible []string) (string, error) {
+	configs, err := i.Root.Root().Walk("configurations").ListChildNodes()
+	if err != nil {
+		return "", err
+	}
+
+	best, bestScore := "", len(compatible)
+	for _, name := range configs {
+		c, err := i.ReadConfig(name)
+		if err != nil {
+			continue
+		}
+		for score, compat := range compatible {
+			if score < bestScore && slices.Contains(c.Compatible, compat) {
+				best, bestScore = name, score
+				break
+			}
+		}
+	}
+	if best == "" {
+		return "", fmt.Errorf("%w for %q", ErrNoCompatibleConfig, compatible)
+	}
+	return best, nil
+}
+
+// compatiblePath is the compatible property of the device tree that the
+// running kernel booted with.
+var compatiblePath = "/sys/firmware/devicetree/base/compatible"
+
+// SystemCompatible returns the compatible strings of the machine, most
+// specific first, from the device tree of the running kernel. Machines
+// without a device tree, such as most x86 ones, have none.
+func SystemCompatible() ([]string, error) {
+	b, err := os.ReadFile(compatiblePath)
+	if err != nil {
+		return nil, err
+	}
+	p := dt.Property{Name: "compatible", Value: b}
+	return p.AsStringList()
+}
+
+// ApplyConfig sets the kernel, initramfs and DTB nodes of i to those of its
+// configuration, see GetConfigName.
+func (i *Image) ApplyConfig() error {
+	tc, err := i.GetConfigName()
+	if err != nil {
+		return err
+	}
+	c, err := i.ReadConfig(tc)
+	if err != nil {
+		return err
+	}
+	i.Kernel, i.InitRAMFS, i.Loadables, i.DTB = c.Kernel, "", nil, c.FDT
+	if len(c.Ramdisks) > 0 {
+		i.InitRAMFS, i.Loadables = c.Ramdisks[0], c.Ramdisks[1:]
+	}
+	return nil
+}
+
 // LoadConfig loads a configuration from a FIT image
 // Returns <kernel_name>, <ramdisk_name>, error
 func (i *Image) LoadConfig() (string, string, error) {
@@ -0,0 +1,143 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+// Checks the hashes of FIT images, as U-Boot does before booting them.
+//
+// Expected FDT Format:
+//  Node: images
+//  Node: image_name
+//   P: data
+//   Node: hash*
+//    P: value
+//    P: algo          (ex. 'sha256', 'crc32')
+
+package fit
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"math"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/dt"
+)
+
+// ErrHashMismatch is returned for images whose data does not match one of
+// their hashes.
+var ErrHashMismatch = errors.New("hash mismatch")
+
+var hashes = map[string]func() hash.Hash{
+	"crc32":  func() hash.Hash { return crc32.NewIEEE() },
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha384": sha512.New384,
+	"sha512": sha512.New,
+}
+
+// propUint returns the value of a <u32> or <u64> property. mkimage writes the
+// offsets and sizes of external data as <u32>.
+func propUint(p *dt.PropertyWalk) (uint64, error) {
+	b, err := p.AsBytes()
+	if err != nil {
+		return 0, err
+	}
+	switch len(b) {
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	case 8:
+		return binary.BigEndian.Uint64(b), nil
+	}
+	return 0, fmt.Errorf("property of %d bytes is not <u32> or <u64>", len(b))
+}
+
+// imageData returns the data of an image node. U-Boot's mkimage -E stores the
+// data outside of the FDT, after it, which needs File.
+func (i *Image) imageData(image string) ([]byte, error) {
+	iroot := i.Root.Root().Walk("images").Walk(image)
+	if b, err := iroot.Property("data").AsBytes(); err == nil {
+		return b, nil
+	}
+	size, err := propUint(iroot.Property("data-size"))
+	if err != nil {
+		// The error of a missing data property.
+		return iroot.Property("data").AsBytes()
+	}
+
+	var off uint64
+	if pos, err := propUint(iroot.Property("data-position")); err == nil {
+		off = pos
+	} else if rel, err := propUint(iroot.Property("data-offset")); err == nil {
+		// Relative to the 4-byte aligned end of the FDT.
+		off = (uint64(i.Root.Header.TotalSize)+3)&^3 + rel
+	} else {
+		return nil, fmt.Errorf("image %s has data-size, but no data-position or data-offset", image)
+	}
+	if i.File == nil {
+		return nil, fmt.Errorf("image %s has external data, but there is no FIT file to read it from", image)
+	}
+	// The data-size comes from the FIT, so check the data is in the file
+	// before allocating a buffer of that size.
+	if off > math.MaxInt64 || size > math.MaxInt64-off {
+		return nil, fmt.Errorf("image %s has external data of %d bytes at offset %d, which is out of range", image, size, off)
+	}
+	if size > 0 {
+		var last [1]byte
+		if _, err := i.File.ReadAt(last[:], int64(off+size-1)); err != nil {
+			return nil, fmt.Errorf("image %s has external data of %d bytes at offset %d, past the end of the FIT file: %w", image, size, off, err)
+		}
+	}
+	b := make([]byte, size)
+	if _, err := i.File.ReadAt(b, int64(off)); err != nil {
+		return nil, fmt.Errorf("reading external data of image %s: %w", image, err)
+	}
+	return b, nil
+}
+
+// VerifyHashes verifies data, the data of an image node as returned by
+// ReadImage, against the values of its hash nodes. Images without hash nodes
+// verify.
+func (i *Image) VerifyHashes(image string, data []byte) error {
+	iroot := i.Root.Root().Walk("images").Walk(image)
+	nodes, err := iroot.FindAll(func(n *dt.Node) bool {
+		return strings.HasPrefix(strings.ToLower(n.Name), "hash")
+	})
+	if err != nil {
+		return nil
+	}
+
+	for _, n := range nodes {
+		algo, ok := n.LookProperty("algo")
+		if !ok {
+			return fmt.Errorf("image %s: %s has no algo", image, n.Name)
+		}
+		name, err := algo.AsString()
+		if err != nil {
+			return fmt.Errorf("image %s: %s: %w", image, n.Name, err)
+		}
+		newHash, ok := hashes[name]
+		if !ok {
+			return fmt.Errorf("image %s: %s has unsupported algo %q", image, n.Name, name)
+		}
+		value, ok := n.LookProperty("value")
+		if !ok {
+			return fmt.Errorf("image %s: %s has no value", image, n.Name)
+		}
+
+		h := newHash()
+		h.Write(data)
+		if sum := h.Sum(nil); !bytes.Equal(sum, value.Value) {
+			return fmt.Errorf("image %s: %w: %s %x, want %x", image, ErrHashMismatch, name, sum, value.Value)
+		}
+	}
+	return nil
+}
@@ -193,11 +193,18 @@ func parseSignatures(n ...*dt.Node) ([]Signature, error) {
 // If the signature does not exist or does not match the keyring, both the file
 // and a signature error will be returned.
 func (i *Image) ReadSignedImage(image string, ring openpgp.KeyRing) (*bytes.Reader, error) {
-	iroot := i.Root.Root().Walk("images").Walk(image)
-	b, err := iroot.Property("data").AsBytes()
+	b, err := i.imageData(image)
 	if err != nil {
 		return nil, err
 	}
+	return i.verifySignatures(image, b, ring)
+}
+
+// verifySignatures verifies b, the data of an image node, against the
+// signatures of the node. Like ReadSignedImage, it returns a reader of b even
+// if they do not verify.
+func (i *Image) verifySignatures(image string, b []byte, ring openpgp.KeyRing) (*bytes.Reader, error) {
+	iroot := i.Root.Root().Walk("images").Walk(image)
 
 	br := bytes.NewReader(b)
 	sigNodes, err := iroot.FindAll(func(n *dt.Node) bool {
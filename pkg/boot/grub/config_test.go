@@ -55,6 +55,10 @@ func fakeDevices() (block.BlockDevices, *mount.Pool, error) {
 
 // Enable this to generate new configs.
 func DISABLEDTestGenerateConfigs(t *testing.T) {
+	// Parse configs as if booted from BIOS, so grub_platform is "pc".
+	defer func(old string) { efiDir = old }(efiDir)
+	efiDir = filepath.Join(t.TempDir(), "efi")
+
 	tests, err := filepath.Glob("testdata_new/*.json")
 	if err != nil {
 		t.Error("Failed to find test config files:", err)
@@ -80,6 +84,10 @@ func DISABLEDTestGenerateConfigs(t *testing.T) {
 }
 
 func TestConfigs(t *testing.T) {
+	// Parse configs as if booted from BIOS, so grub_platform is "pc".
+	defer func(old string) { efiDir = old }(efiDir)
+	efiDir = filepath.Join(t.TempDir(), "efi")
+
 	// find all saved configs
 	tests, err := filepath.Glob("testdata_new/*.json")
 	if err != nil {
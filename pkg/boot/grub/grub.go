@@ -10,7 +10,7 @@
 // - https://www.gnu.org/software/grub/manual/grub/html_node/Shell_002dlike-scripting.html
 // - https://www.gnu.org/software/grub/manual/grub/html_node/Commands.html
 //
-// See parser.append function for list of commands that are supported.
+// See parser.command function for list of commands that are supported.
 package grub
 
 import (
@@ -23,6 +23,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -33,7 +34,6 @@ import (
 	"github.com/u-root/u-root/pkg/curl"
 	"github.com/u-root/u-root/pkg/mount"
 	"github.com/u-root/u-root/pkg/mount/block"
-	"github.com/u-root/u-root/pkg/shlex"
 	"github.com/u-root/u-root/pkg/ulog"
 	"github.com/u-root/uio/uio"
 )
@@ -73,6 +73,38 @@ var mountFlags = uintptr(mount.ReadOnly)
 
 var errMissingKey = errors.New("key is not found")
 
+// maxDepth is how deeply functions may call each other, and maxCalls how
+// often functions may be called while parsing a config.
+const (
+	maxDepth = 32
+	maxCalls = 10000
+)
+
+// efiDir exists if Linux booted from EFI.
+var efiDir = "/sys/firmware/efi"
+
+// platform returns the grub_platform variable of the GRUB that would have
+// booted this machine.
+func platform() string {
+	if _, err := os.Stat(efiDir); err == nil {
+		return "efi"
+	}
+	return "pc"
+}
+
+// isName returns whether s is the name of a variable.
+func isName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isNameChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
 // absFileScheme creates a file:/// scheme with an absolute path. Technically,
 // file schemes must be absolute paths and Go makes that assumption.
 func absFileScheme(path string) (*url.URL, error) {
@@ -162,7 +194,7 @@ func findkeywordGrubEnv(file string, fsRoot string, key string) (string, error)
 // ParseConfigFile parses a grub configuration as specified in
 // https://www.gnu.org/software/grub/manual/grub/
 //
-// See parser.append function for list of commands that are supported.
+// See parser.command function for list of commands that are supported.
 //
 // `root` is the default scheme, host, and path for any files named as a
 // relative path - e.g. kernel and initramfs paths are requested relative to
@@ -235,14 +267,35 @@ type parser struct {
 	// Special variables:
 	//   * default: Default boot option.
 	//   * root: Root "partition" as a URL.
+	//   * grub_platform: "efi" or "pc".
 	variables map[string]string
 
+	// functions are the bodies of the functions defined so far.
+	functions map[string][]stmt
+
+	// args are the positional parameters of the running function, depth
+	// is the number of functions running, and calls the number of
+	// functions called.
+	args  []string
+	depth int
+	calls int
+
+	// entryPrefix, titlePrefix and idPrefix are the index, title and id
+	// of the submenus around the current entry, joined with >.
+	entryPrefix string
+	titlePrefix string
+	idPrefix    string
+
 	// curEntry is the current entry number as a string.
 	curEntry string
 
 	// curLabel is the last parsed label from a "menuentry".
 	curLabel string
 
+	// curKeys are the keys of the current entry: its index, its title and
+	// its id.
+	curKeys []string
+
 	devices   block.BlockDevices
 	mountPool *mount.Pool
 	schemes   curl.Schemes
@@ -270,8 +323,17 @@ func newParser(root *url.URL, devices block.BlockDevices, mountPool *mount.Pool,
 		linuxEntries: make(map[string]*boot.LinuxImage),
 		mbEntries:    make(map[string]*boot.MultibootImage),
 		variables: map[string]string{
-			"root": root.String(),
+			"root":          root.String(),
+			"grub_platform": platform(),
+			// GRUB sets these to tell scripts that it has the features.
+			"feature_all_video_module":     "y",
+			"feature_default_font_path":    "y",
+			"feature_menuentry_id":         "y",
+			"feature_menuentry_options":    "y",
+			"feature_platform_search_hint": "y",
+			"feature_timeout_style":        "y",
 		},
+		functions:   make(map[string][]stmt),
 		devices:     devices,
 		mountPool:   mountPool,
 		schemes:     s,
@@ -341,10 +403,15 @@ func (c *parser) appendFile(ctx context.Context, url string) error {
 	return c.append(ctx, string(config))
 }
 
-// CmdlineQuote quotes the command line as grub-core/lib/cmdline.c does
+// CmdlineQuote quotes the command line as grub-core/lib/cmdline.c does.
+// Empty arguments, such as those of "$var" with var unset, are dropped, so
+// that they leave no stray spaces. expand keeps them for test and [.
 func cmdlineQuote(args []string) string {
-	q := make([]string, len(args))
-	for i, s := range args {
+	var q []string
+	for _, s := range args {
+		if s == "" {
+			continue
+		}
 		// Replace \ with \\ unless it matches \xXX
 		s = anyEscape.ReplaceAllStringFunc(s, func(match string) string {
 			if hexEscape.MatchString(match) {
@@ -357,248 +424,584 @@ func cmdlineQuote(args []string) string {
 		if strings.ContainsRune(s, ' ') {
 			s = `"` + s + `"`
 		}
-		q[i] = s
+		q = append(q, s)
 	}
 	return strings.Join(q, " ")
 }
 
 // append parses `config` and adds the respective configuration to `c`.
-//
-// NOTE: This parser has outlived its usefulness already, given that it doesn't
-// even understand the {} scoping in GRUB. But let's get the tests to pass, and
-// then we can do a rewrite.
 func (c *parser) append(ctx context.Context, config string) error {
-	// Here's a shitty parser.
-	for _, line := range strings.Split(config, "\n") {
-		// Add extra backslash for OpenSUSE/Fedora/RHEL use case. shlex
-		// will convert it back to a single backslash.
-		line = hexEscape.ReplaceAllString(line, `\\$0`)
-		kv := shlex.Argv(line)
-		if len(kv) < 1 {
-			continue
+	_, err := c.run(ctx, parseScript(config))
+	return err
+}
+
+// run runs stmts and returns whether the last command succeeded.
+func (c *parser) run(ctx context.Context, stmts []stmt) (bool, error) {
+	ok := true
+	for _, s := range stmts {
+		var err error
+		switch s := s.(type) {
+		case *command:
+			ok, err = c.command(ctx, s.words)
+		case *ifBlock:
+			ok, err = c.runIf(ctx, s)
+		case *function:
+			c.functions[s.name] = s.body
+			ok = true
+		case *menuBlock:
+			ok, err = c.menuEntry(ctx, s)
 		}
-		directive := strings.ToLower(kv[0])
-		// blscfg len(kv) is 1 so need to be checked here
-		if directive == "blscfg" {
-			c.blscfgFound = true
+		if err != nil {
+			return false, err
 		}
+	}
+	return ok, nil
+}
 
-		// Used by tests (allow no parameters here)
-		if c.W != nil && directive == "echo" {
-			fmt.Fprintf(c.W, "echo:%#v\n", kv[1:])
+func (c *parser) runIf(ctx context.Context, b *ifBlock) (bool, error) {
+	for i, cond := range b.conds {
+		if i >= len(b.bodies) {
+			break
+		}
+		ok, err := c.run(ctx, cond)
+		if err != nil {
+			return false, err
 		}
+		if ok {
+			return c.run(ctx, b.bodies[i])
+		}
+	}
+	if len(b.bodies) > len(b.conds) {
+		return c.run(ctx, b.bodies[len(b.bodies)-1])
+	}
+	return true, nil
+}
 
-		if len(kv) <= 1 {
-			continue
+// call runs the function body with the positional parameters args.
+func (c *parser) call(ctx context.Context, name string, body []stmt, args []string) (bool, error) {
+	if c.depth >= maxDepth || c.calls >= maxCalls {
+		log.Printf("Warning: Grub parser skipping %s, functions are called too often", name)
+		return false, nil
+	}
+	savedArgs := c.args
+	c.args = args
+	c.depth++
+	c.calls++
+	defer func() {
+		c.args = savedArgs
+		c.depth--
+	}()
+	return c.run(ctx, body)
+}
+
+// menuEntry adds the menu entry or the entries of the submenu of b.
+//
+// The commands of an entry run with a copy of the variables, as GRUB runs
+// them in a new context when the entry is booted. Entries are keyed by their
+// index, title, and id, which are joined with > for entries in submenus, e.g.
+// "1>0", as the default variable names them.
+func (c *parser) menuEntry(ctx context.Context, b *menuBlock) (bool, error) {
+	title, id := menuOptions(c.expand(b.words))
+	if id == "" {
+		id = title
+	}
+	index := c.entryPrefix + strconv.Itoa(c.numEntry)
+	c.numEntry++
+
+	variables, numEntry := c.variables, c.numEntry
+	entryPrefix, titlePrefix, idPrefix := c.entryPrefix, c.titlePrefix, c.idPrefix
+	curEntry, curLabel, curKeys := c.curEntry, c.curLabel, c.curKeys
+	defer func() {
+		c.variables, c.numEntry = variables, numEntry
+		c.entryPrefix, c.titlePrefix, c.idPrefix = entryPrefix, titlePrefix, idPrefix
+		c.curEntry, c.curLabel, c.curKeys = curEntry, curLabel, curKeys
+	}()
+	c.variables = make(map[string]string, len(variables))
+	for k, v := range variables {
+		c.variables[k] = v
+	}
+
+	if b.submenu {
+		c.numEntry = 0
+		c.entryPrefix = index + ">"
+		c.titlePrefix += title + ">"
+		c.idPrefix += id + ">"
+		_, err := c.run(ctx, b.body)
+		return true, err
+	}
+
+	c.curEntry = index
+	c.curLabel = title
+	c.curKeys = nil
+	for _, k := range []string{index, title, c.titlePrefix + title, c.idPrefix + id} {
+		if !slices.Contains(c.curKeys, k) {
+			c.curKeys = append(c.curKeys, k)
 		}
-		arg := kv[1]
-
-		switch directive {
-		case "search.file", "search.fs_label", "search.fs_uuid":
-			// Alias to regular search directive.
-			kv = append(
-				[]string{"search", map[string]string{
-					"search.file":     "--file",
-					"search.fs_label": "--fs-label",
-					"search.fs_uuid":  "--fs-uuid",
-				}[directive]},
-				kv[1:]...,
-			)
-			fallthrough
-		case "search":
-			// Parses a line with this format:
-			//   search [--file|--label|--fs-uuid] [--set [var]] [--no-floppy] name
-			fs := pflag.NewFlagSet("grub.search", pflag.ContinueOnError)
-			searchUUID := fs.BoolP("fs-uuid", "u", false, "")
-			searchLabel := fs.BoolP("fs-label", "l", false, "")
-			searchFile := fs.BoolP("file", "f", false, "")
-			setVar := fs.String("set", "root", "")
-			// Ignored flags
-			fs.String("no-floppy", "", "ignored")
-			fs.String("hint", "", "ignored")
-			fs.SetNormalizeFunc(func(f *pflag.FlagSet, name string) pflag.NormalizedName {
-				// Everything that begins with "hint" is ignored.
-				if strings.HasPrefix(name, "hint") {
-					name = "hint"
-				}
-				return pflag.NormalizedName(name)
-			})
+	}
+	c.labelOrder = append(c.labelOrder, c.curKeys...)
+	_, err := c.run(ctx, b.body)
+	return true, err
+}
+
+// menuOptions returns the title and the --id of the arguments of a
+// menuentry or submenu.
+func menuOptions(args []string) (title, id string) {
+	for i := 0; i < len(args); i++ {
+		switch a := args[i]; {
+		case a == "--class" || a == "--users" || a == "--hotkey" || a == "--source":
+			i++
+		case a == "--id":
+			if i+1 < len(args) {
+				id = args[i+1]
+			}
+			i++
+		case strings.HasPrefix(a, "--id="):
+			id = strings.TrimPrefix(a, "--id=")
+		case strings.HasPrefix(a, "--"):
+		case title == "":
+			title = a
+		}
+	}
+	return title, id
+}
 
-			if err := fs.Parse(kv[1:]); err != nil || fs.NArg() != 1 {
-				log.Printf("Warning: Grub parser could not parse %q", kv)
+// lookup returns the value of the variable or positional parameter name.
+func (c *parser) lookup(name string) string {
+	switch name {
+	case "#":
+		return strconv.Itoa(len(c.args))
+	case "@", "*":
+		return strings.Join(c.args, " ")
+	}
+	if n, err := strconv.Atoi(name); err == nil {
+		if n >= 1 && n <= len(c.args) {
+			return c.args[n-1]
+		}
+		return ""
+	}
+	return c.variables[name]
+}
+
+// expand expands the variables of words into arguments. Like GRUB, the
+// values of variables outside of double quotes are split into several
+// arguments at white space.
+func (c *parser) expand(words []word) []string {
+	var args []string
+	for _, w := range words {
+		var arg strings.Builder
+		has := false
+		flush := func() {
+			if has {
+				args = append(args, arg.String())
+			}
+			arg.Reset()
+			has = false
+		}
+		for _, p := range w {
+			if !p.variable {
+				arg.WriteString(p.s)
+				has = true
 				continue
 			}
-			searchName := fs.Arg(0)
-			if *searchUUID && *searchLabel || *searchUUID && *searchFile || *searchLabel && *searchFile {
-				log.Printf("Warning: Grub parser found more than one search option in %q, skipping line", line)
+			v := c.lookup(p.s)
+			if p.quoted {
+				arg.WriteString(v)
+				has = true
 				continue
 			}
-			if !*searchUUID && !*searchLabel && !*searchFile {
-				// defaults to searchUUID
-				*searchUUID = true
+			fields := strings.Fields(v)
+			if len(fields) > 0 && v[0] != fields[0][0] {
+				flush()
 			}
-
-			switch {
-			case *searchUUID:
-				d := c.devices.FilterFSUUID(searchName)
-				if len(d) != 1 {
-					log.Printf("Error: Expected 1 device with UUID %q, found %d", searchName, len(d))
-					continue
-				}
-				mp, err := c.mountPool.Mount(d[0], mountFlags)
-				if err != nil {
-					log.Printf("Error: Could not mount %v: %v", d[0], err)
-					continue
-				}
-				setVal, err := absFileScheme(mp.Path)
-				if err != nil {
-					continue
-				}
-				c.variables[*setVar] = setVal.String()
-			case *searchLabel:
-				d := c.devices.FilterPartLabel(searchName)
-				if len(d) != 1 {
-					log.Printf("Error: Expected 1 device with label %q, found %d", searchName, len(d))
-					continue
-				}
-				mp, err := c.mountPool.Mount(d[0], mountFlags)
-				if err != nil {
-					log.Printf("Error: Could not mount %v: %v", d[0], err)
-					continue
-				}
-				setVal, err := absFileScheme(mp.Path)
-				if err != nil {
-					continue
-				}
-				c.variables[*setVar] = setVal.String()
-			case *searchFile:
-				// Make sure searchName stays in mountpoint. Remove "../" components.
-				cleanPath, err := filepath.Rel("/", filepath.Clean(filepath.Join("/", searchName)))
-				if err != nil {
-					log.Printf("Error: Could not clean path %q: %v", searchName, err)
-					continue
-				}
-				// Search through all the devices for the file.
-				for _, d := range c.devices {
-					mp, err := c.mountPool.Mount(d, mountFlags)
-					if err != nil {
-						log.Printf("Warning: Could not mount %v: %v", mp, err)
-						continue
-					}
-					file := filepath.Join(mp.Path, cleanPath)
-					if _, err := os.Stat(file); err == nil {
-						setVal, err := absFileScheme(mp.Path)
-						if err != nil {
-							continue
-						}
-						c.variables[*setVar] = setVal.String()
-						break
-					}
+			for i, f := range fields {
+				if i > 0 {
+					flush()
 				}
+				arg.WriteString(f)
+				has = true
 			}
-
-		case "set":
-			vals := strings.SplitN(arg, "=", 2)
-			if len(vals) == 2 {
-				// TODO: We cannot parse grub device syntax.
-				if vals[0] == "root" {
-					continue
-				}
-				// here we only add the support for the case: set default="${saved_entry}".
-				if vals[0] == "default" {
-					if vals[1] == "${saved_entry}" {
-						c.variables["default_saved_entry"] = vals[1]
-					} else {
-						c.variables[vals[0]] = vals[1]
-					}
-				} else {
-					c.variables[vals[0]] = vals[1]
-				}
+			if len(fields) > 0 && !strings.HasSuffix(v, fields[len(fields)-1]) {
+				flush()
 			}
+		}
+		flush()
+	}
+	return args
+}
 
-		case "configfile":
-			// TODO test that
-			if err := c.appendFile(ctx, arg); err != nil {
-				return err
-			}
+// setVariable sets the variable name to value, as set name=value does. w is
+// the unexpanded word of the assignment.
+func (c *parser) setVariable(name, value string, w word) {
+	// TODO: We cannot parse grub device syntax.
+	if name == "root" {
+		return
+	}
+	// here we only add the support for the case: set default="${saved_entry}".
+	if name == "default" && strings.HasSuffix(w.String(), "=${saved_entry}") {
+		c.variables["default_saved_entry"] = "${saved_entry}"
+		return
+	}
+	c.variables[name] = value
+}
 
-		case "devicetree":
-			if e, ok := c.linuxEntries[c.curEntry]; ok {
-				dtb, err := c.getFile(arg)
-				if err != nil {
-					return err
-				}
-				e.DTB = dtb
+// test evaluates the expression of the test and [ commands, such as
+// x$feature_platform_search_hint = xy. -a binds tighter than -o.
+func (c *parser) test(args []string) bool {
+	for _, or := range splitArgs(args, "-o") {
+		ok := true
+		for _, and := range splitArgs(or, "-a") {
+			if !c.testPrimary(and) {
+				ok = false
+				break
 			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func splitArgs(args []string, sep string) [][]string {
+	var s [][]string
+	start := 0
+	for i, a := range args {
+		if a == sep {
+			s = append(s, args[start:i])
+			start = i + 1
+		}
+	}
+	return append(s, args[start:])
+}
 
-		case "menuentry":
-			c.curEntry = strconv.Itoa(c.numEntry)
-			c.curLabel = arg
-			c.numEntry++
-			c.labelOrder = append(c.labelOrder, c.curEntry, c.curLabel)
+func (c *parser) testPrimary(args []string) bool {
+	if len(args) > 0 && args[0] == "!" {
+		return !c.testPrimary(args[1:])
+	}
+	switch len(args) {
+	case 1:
+		return args[0] != ""
+	case 2:
+		switch args[0] {
+		case "-z":
+			return args[1] == ""
+		case "-n":
+			return args[1] != ""
+		case "-e", "-f", "-d", "-s":
+			return c.testFile(args[0], args[1])
+		}
+	case 3:
+		a, b := args[0], args[2]
+		switch args[1] {
+		case "=", "==":
+			return a == b
+		case "!=":
+			return a != b
+		case "<":
+			return a < b
+		case ">":
+			return a > b
+		}
+		x, errx := strconv.ParseInt(a, 0, 64)
+		y, erry := strconv.ParseInt(b, 0, 64)
+		if errx != nil || erry != nil {
+			return false
+		}
+		switch args[1] {
+		case "-eq":
+			return x == y
+		case "-ne":
+			return x != y
+		case "-lt":
+			return x < y
+		case "-le":
+			return x <= y
+		case "-gt":
+			return x > y
+		case "-ge":
+			return x >= y
+		}
+	}
+	return false
+}
+
+// testFile tests a file relative to the root, which must be local.
+func (c *parser) testFile(op, name string) bool {
+	u, err := parseURL(name, c.variables["root"])
+	if err != nil || u.Scheme != "file" {
+		return false
+	}
+	fi, err := os.Stat(u.Path)
+	if err != nil {
+		return false
+	}
+	switch op {
+	case "-f":
+		return fi.Mode().IsRegular()
+	case "-d":
+		return fi.IsDir()
+	case "-s":
+		return fi.Size() > 0
+	}
+	return true
+}
 
-		case "linux", "linux16", "linuxefi":
-			k, err := c.getFile(arg)
+// command runs the command of words and returns whether it succeeded.
+// Commands this parser ignores, such as insmod, succeed.
+//
+// See the grub manual for the commands:
+// https://www.gnu.org/software/grub/manual/grub/html_node/Commands.html
+func (c *parser) command(ctx context.Context, words []word) (bool, error) {
+	kv := c.expand(words)
+	if len(kv) < 1 {
+		return true, nil
+	}
+	if body, ok := c.functions[kv[0]]; ok {
+		return c.call(ctx, kv[0], body, kv[1:])
+	}
+	// name=value sets a variable, as set does.
+	if name, value, ok := strings.Cut(kv[0], "="); ok && len(kv) == 1 && isName(name) {
+		c.setVariable(name, value, words[0])
+		return true, nil
+	}
+	directive := strings.ToLower(kv[0])
+	switch directive {
+	// blscfg len(kv) is 1 so need to be checked here
+	case "blscfg":
+		c.blscfgFound = true
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "test":
+		return c.test(kv[1:]), nil
+	case "[":
+		if kv[len(kv)-1] != "]" {
+			log.Printf("Warning: Grub parser found no ] in %q", kv)
+			return false, nil
+		}
+		return c.test(kv[1 : len(kv)-1]), nil
+	case "unset":
+		for _, name := range kv[1:] {
+			delete(c.variables, name)
+		}
+	}
+
+	// Used by tests (allow no parameters here)
+	if c.W != nil && directive == "echo" {
+		fmt.Fprintf(c.W, "echo:%#v\n", kv[1:])
+	}
+
+	if len(kv) <= 1 {
+		return true, nil
+	}
+	arg := kv[1]
+
+	switch directive {
+	case "search.file", "search.fs_label", "search.fs_uuid":
+		// Alias to regular search directive.
+		return c.search(append(
+			[]string{map[string]string{
+				"search.file":     "--file",
+				"search.fs_label": "--fs-label",
+				"search.fs_uuid":  "--fs-uuid",
+			}[directive]},
+			kv[1:]...,
+		)), nil
+	case "search":
+		return c.search(kv[1:]), nil
+
+	case "set":
+		vals := strings.SplitN(arg, "=", 2)
+		if len(vals) == 2 {
+			c.setVariable(vals[0], vals[1], words[1])
+		}
+
+	case "configfile":
+		// TODO test that
+		if err := c.appendFile(ctx, arg); err != nil {
+			return false, err
+		}
+
+	case "devicetree":
+		if e, ok := c.linuxEntries[c.curEntry]; ok {
+			dtb, err := c.getFile(arg)
 			if err != nil {
-				return err
+				return false, err
 			}
-			// from grub manual: "Any initrd must be reloaded after using this command" so we can replace the entry
-			entry := &boot.LinuxImage{
-				Name:    c.curLabel,
-				Kernel:  k,
-				Cmdline: cmdlineQuote(kv[2:]),
+			e.DTB = dtb
+		}
+
+	case "linux", "linux16", "linuxefi":
+		k, err := c.getFile(arg)
+		if err != nil {
+			return false, err
+		}
+		// from grub manual: "Any initrd must be reloaded after using this command" so we can replace the entry
+		entry := &boot.LinuxImage{
+			Name:    c.curLabel,
+			Kernel:  k,
+			Cmdline: cmdlineQuote(kv[2:]),
+		}
+		c.linuxEntries[c.curEntry] = entry
+		for _, key := range c.curKeys {
+			c.linuxEntries[key] = entry
+		}
+
+	case "initrd", "initrd16", "initrdefi":
+		if e, ok := c.linuxEntries[c.curEntry]; ok {
+			i, err := c.getFile(arg)
+			if err != nil {
+				return false, err
 			}
-			c.linuxEntries[c.curEntry] = entry
-			c.linuxEntries[c.curLabel] = entry
-
-		case "initrd", "initrd16", "initrdefi":
-			if e, ok := c.linuxEntries[c.curEntry]; ok {
-				i, err := c.getFile(arg)
-				if err != nil {
-					return err
+			e.Initrd = i
+		}
+
+	case "multiboot", "multiboot2":
+		// TODO handle --quirk-* arguments ? (change parsing)
+		k, err := c.getFile(arg)
+		if err != nil {
+			return false, err
+		}
+		// from grub manual: "Any initrd must be reloaded after using this command" so we can replace the entry
+		entry := &boot.MultibootImage{
+			Name:    c.curLabel,
+			Kernel:  k,
+			Cmdline: cmdlineQuote(kv[2:]),
+		}
+		c.mbEntries[c.curEntry] = entry
+		for _, key := range c.curKeys {
+			c.mbEntries[key] = entry
+		}
+
+	case "module", "module2":
+		// TODO handle --nounzip arguments ? (change parsing)
+		if e, ok := c.mbEntries[c.curEntry]; ok {
+			// The only allowed arg
+			cmdline := kv[1:]
+			if arg == "--nounzip" {
+				if len(kv) < 3 {
+					return false, fmt.Errorf("no file argument given: %v", kv)
 				}
-				e.Initrd = i
+				arg = kv[2]
+				cmdline = kv[2:]
 			}
-
-		case "multiboot", "multiboot2":
-			// TODO handle --quirk-* arguments ? (change parsing)
-			k, err := c.getFile(arg)
+			m, err := c.getFile(arg)
 			if err != nil {
-				return err
+				return false, err
 			}
-			// from grub manual: "Any initrd must be reloaded after using this command" so we can replace the entry
-			entry := &boot.MultibootImage{
-				Name:    c.curLabel,
-				Kernel:  k,
-				Cmdline: cmdlineQuote(kv[2:]),
+			// TODO: Lasy tryGzipFilter(m)
+			mod := multiboot.Module{
+				Module:  m,
+				Cmdline: cmdlineQuote(cmdline),
 			}
-			c.mbEntries[c.curEntry] = entry
-			c.mbEntries[c.curLabel] = entry
-
-		case "module", "module2":
-			// TODO handle --nounzip arguments ? (change parsing)
-			if e, ok := c.mbEntries[c.curEntry]; ok {
-				// The only allowed arg
-				cmdline := kv[1:]
-				if arg == "--nounzip" {
-					if len(kv) < 3 {
-						return fmt.Errorf("no file argument given: %v", kv)
-					}
-					arg = kv[2]
-					cmdline = kv[2:]
-				}
-				m, err := c.getFile(arg)
-				if err != nil {
-					return err
-				}
-				// TODO: Lasy tryGzipFilter(m)
-				mod := multiboot.Module{
-					Module:  m,
-					Cmdline: cmdlineQuote(cmdline),
+			e.Modules = append(e.Modules, mod)
+		}
+	}
+	return true, nil
+}
+
+// search sets a variable to the root of the device that has a file, label
+// or filesystem UUID, and returns whether it found one. args have this
+// format:
+//
+//	[--file|--label|--fs-uuid] [--set[=var]] [--no-floppy] [--hint=...] name
+func (c *parser) search(args []string) bool {
+	fs := pflag.NewFlagSet("grub.search", pflag.ContinueOnError)
+	searchUUID := fs.BoolP("fs-uuid", "u", false, "")
+	searchLabel := fs.BoolP("fs-label", "l", false, "")
+	searchFile := fs.BoolP("file", "f", false, "")
+	setVar := fs.StringP("set", "s", "root", "")
+	fs.Lookup("set").NoOptDefVal = "root"
+	// Ignored flags
+	fs.BoolP("no-floppy", "n", false, "ignored")
+	fs.Bool("efidisk-only", false, "ignored")
+	fs.String("hint", "", "ignored")
+	fs.SetNormalizeFunc(func(f *pflag.FlagSet, name string) pflag.NormalizedName {
+		switch {
+		case name == "label":
+			name = "fs-label"
+		// Everything that begins with "hint" is ignored.
+		case strings.HasPrefix(name, "hint"):
+			name = "hint"
+		}
+		return pflag.NormalizedName(name)
+	})
+
+	// The variable of --set is optional, so --set var is --set=var only
+	// if a name to search follows.
+	for i, a := range args {
+		if (a == "--set" || a == "-s") && i+2 < len(args) && isName(args[i+1]) {
+			args = append(append(args[:i:i], "--set="+args[i+1]), args[i+2:]...)
+			break
+		}
+	}
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		log.Printf("Warning: Grub parser could not parse search %q", args)
+		return false
+	}
+	searchName := fs.Arg(0)
+	if *searchUUID && *searchLabel || *searchUUID && *searchFile || *searchLabel && *searchFile {
+		log.Printf("Warning: Grub parser found more than one search option in %q, skipping line", args)
+		return false
+	}
+	if !*searchUUID && !*searchLabel && !*searchFile {
+		// defaults to searchUUID
+		*searchUUID = true
+	}
+
+	var root string
+	switch {
+	case *searchUUID, *searchLabel:
+		var d block.BlockDevices
+		if *searchUUID {
+			// GRUB ignores the case of UUIDs.
+			for _, dev := range c.devices {
+				if strings.EqualFold(dev.FsUUID, searchName) {
+					d = append(d, dev)
 				}
-				e.Modules = append(e.Modules, mod)
+			}
+		} else {
+			d = c.devices.FilterPartLabel(searchName)
+		}
+		if len(d) != 1 {
+			log.Printf("Error: Expected 1 device with UUID or label %q, found %d", searchName, len(d))
+			return false
+		}
+		mp, err := c.mountPool.Mount(d[0], mountFlags)
+		if err != nil {
+			log.Printf("Error: Could not mount %v: %v", d[0], err)
+			return false
+		}
+		root = mp.Path
+	case *searchFile:
+		// Make sure searchName stays in mountpoint. Remove "../" components.
+		cleanPath, err := filepath.Rel("/", filepath.Clean(filepath.Join("/", searchName)))
+		if err != nil {
+			log.Printf("Error: Could not clean path %q: %v", searchName, err)
+			return false
+		}
+		// Search through all the devices for the file.
+		for _, d := range c.devices {
+			mp, err := c.mountPool.Mount(d, mountFlags)
+			if err != nil {
+				log.Printf("Warning: Could not mount %v: %v", mp, err)
+				continue
+			}
+			if _, err := os.Stat(filepath.Join(mp.Path, cleanPath)); err == nil {
+				root = mp.Path
+				break
 			}
 		}
+		if root == "" {
+			return false
+		}
+	}
+
+	setVal, err := absFileScheme(root)
+	if err != nil {
+		return false
 	}
-	return nil
+	c.variables[*setVar] = setVal.String()
+	return true
 }
@@ -36,6 +36,11 @@ func TestCmdlineQuote(t *testing.T) {
 			in:   []string{`some stuff`},
 			want: `"some stuff"`,
 		},
+		{
+			desc: "drop empty",
+			in:   []string{"", "boot=live", "", "components", ""},
+			want: "boot=live components",
+		},
 	} {
 		t.Run(fmt.Sprintf("Test [%02d] %s", i, tt.desc), func(t *testing.T) {
 			got := cmdlineQuote(tt.in)
@@ -0,0 +1,412 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package grub
+
+import (
+	"strings"
+)
+
+// wordPart is a literal string or a variable reference in a word.
+type wordPart struct {
+	s string
+
+	// variable is set if s is the name of a variable.
+	variable bool
+
+	// quoted is set for variables in double quotes, whose values are not
+	// split into several arguments.
+	quoted bool
+}
+
+// word is an argument of a command, before its variables are expanded.
+type word []wordPart
+
+// String returns the word with its variables as ${name}.
+func (w word) String() string {
+	var b strings.Builder
+	for _, p := range w {
+		if p.variable {
+			b.WriteString("${" + p.s + "}")
+		} else {
+			b.WriteString(p.s)
+		}
+	}
+	return b.String()
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	// tokenSeparator is a newline or a semicolon.
+	tokenSeparator
+	tokenOpenBrace
+	tokenCloseBrace
+)
+
+type token struct {
+	kind tokenKind
+	word word
+
+	// bare is the text of words without quotes, escapes or variables,
+	// which may be keywords such as if and fi.
+	bare string
+}
+
+// lexer splits a script into tokens following the quoting rules of
+// https://www.gnu.org/software/grub/manual/grub/html_node/Quoting.html.
+type lexer struct {
+	s    string
+	i    int
+	toks []token
+
+	// The word being lexed.
+	parts  word
+	lit    strings.Builder
+	inWord bool
+	quoted bool
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\r' || b == '\v' || b == '\f'
+}
+
+func isNameChar(b byte) bool {
+	return b == '_' || '0' <= b && b <= '9' || 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z'
+}
+
+func isHex(b byte) bool {
+	return '0' <= b && b <= '9' || 'a' <= b && b <= 'f' || 'A' <= b && b <= 'F'
+}
+
+func (l *lexer) literal(b byte) {
+	l.lit.WriteByte(b)
+	l.inWord = true
+}
+
+func (l *lexer) flushLiteral() {
+	if l.lit.Len() > 0 {
+		l.parts = append(l.parts, wordPart{s: l.lit.String()})
+		l.lit.Reset()
+	}
+}
+
+func (l *lexer) endWord() {
+	l.flushLiteral()
+	if !l.inWord {
+		return
+	}
+	t := token{kind: tokenWord, word: l.parts}
+	if len(l.parts) == 0 {
+		// An empty quoted string, such as "".
+		t.word = word{{}}
+	} else if !l.quoted && len(l.parts) == 1 && !l.parts[0].variable {
+		t.bare = l.parts[0].s
+	}
+	switch t.bare {
+	case "{":
+		t = token{kind: tokenOpenBrace}
+	case "}":
+		t = token{kind: tokenCloseBrace}
+	}
+	l.toks = append(l.toks, t)
+	l.parts, l.inWord, l.quoted = nil, false, false
+}
+
+// escape lexes a backslash outside of quotes and the character it escapes.
+// A backslash before a newline continues the line, and one before a hex
+// sequence such as \x20 is kept, see hexEscape.
+func (l *lexer) escape() {
+	l.i++
+	l.quoted = true
+	switch {
+	case l.i == len(l.s):
+	case l.s[l.i] == '\n':
+		l.i++
+	case l.i+2 < len(l.s) && l.s[l.i] == 'x' && isHex(l.s[l.i+1]) && isHex(l.s[l.i+2]):
+		l.literal('\\')
+	default:
+		l.literal(l.s[l.i])
+		l.i++
+	}
+}
+
+func (l *lexer) singleQuote() {
+	l.i++
+	l.inWord, l.quoted = true, true
+	end := strings.IndexByte(l.s[l.i:], '\'')
+	if end < 0 {
+		end = len(l.s) - l.i
+	}
+	l.lit.WriteString(l.s[l.i : l.i+end])
+	l.i += end + 1
+}
+
+func (l *lexer) doubleQuote() {
+	l.i++
+	l.inWord, l.quoted = true, true
+	for l.i < len(l.s) {
+		switch c := l.s[l.i]; c {
+		case '"':
+			l.i++
+			return
+		case '\\':
+			l.i++
+			if l.i == len(l.s) {
+				return
+			}
+			switch l.s[l.i] {
+			case '$', '"', '\\':
+				l.literal(l.s[l.i])
+			case '\n':
+			default:
+				l.literal('\\')
+				continue
+			}
+			l.i++
+		case '$':
+			l.variable(true)
+		default:
+			l.literal(c)
+			l.i++
+		}
+	}
+}
+
+// variable lexes a reference to a variable, such as $root, ${root} or $1.
+func (l *lexer) variable(quoted bool) {
+	s := l.s[l.i+1:]
+	var name string
+	n := 0
+	if strings.HasPrefix(s, "{") {
+		if end := strings.IndexByte(s, '}'); end > 0 {
+			name, n = s[1:end], end+1
+		}
+	} else {
+		for n < len(s) && isNameChar(s[n]) {
+			n++
+		}
+		if n == 0 && s != "" && strings.IndexByte("?#@*", s[0]) >= 0 {
+			n = 1
+		}
+		name = s[:n]
+	}
+	if name == "" {
+		l.literal('$')
+		l.i++
+		return
+	}
+	l.flushLiteral()
+	l.parts = append(l.parts, wordPart{s: name, variable: true, quoted: quoted})
+	l.inWord = true
+	l.i += 1 + n
+}
+
+func lex(s string) []token {
+	l := &lexer{s: s}
+	for l.i < len(s) {
+		switch c := s[l.i]; {
+		case c == '\n' || c == ';':
+			l.endWord()
+			l.toks = append(l.toks, token{kind: tokenSeparator})
+			l.i++
+		case isSpace(c):
+			l.endWord()
+			l.i++
+		case c == '#' && !l.inWord:
+			for l.i < len(s) && s[l.i] != '\n' {
+				l.i++
+			}
+		case c == '\\':
+			l.escape()
+		case c == '\'':
+			l.singleQuote()
+		case c == '"':
+			l.doubleQuote()
+		case c == '$':
+			l.variable(false)
+		default:
+			l.literal(c)
+			l.i++
+		}
+	}
+	l.endWord()
+	return l.toks
+}
+
+// stmt is a command, an if block, a function or a menu entry.
+type stmt interface{}
+
+// command is a simple command, such as linux /vmlinuz.
+type command struct {
+	words []word
+}
+
+// ifBlock is an if statement. The commands of conds[i] select bodies[i],
+// and the last body is the else block if there is one more body than
+// conditions.
+type ifBlock struct {
+	conds  [][]stmt
+	bodies [][]stmt
+}
+
+// function defines the function name.
+type function struct {
+	name string
+	body []stmt
+}
+
+// menuBlock is a menuentry or submenu block. words are its title and
+// options.
+type menuBlock struct {
+	submenu bool
+	words   []word
+	body    []stmt
+}
+
+type scriptParser struct {
+	toks []token
+	i    int
+}
+
+// parseScript parses a GRUB script as described in
+// https://www.gnu.org/software/grub/manual/grub/html_node/Shell_002dlike-scripting.html.
+//
+// Unlike GRUB, parseScript does not reject scripts with syntax errors: blocks
+// left open end with the script, and stray fi and } are ignored.
+func parseScript(s string) []stmt {
+	p := &scriptParser{toks: lex(s)}
+	var stmts []stmt
+	for p.i < len(p.toks) {
+		stmts = append(stmts, p.list()...)
+		p.i++
+	}
+	return stmts
+}
+
+func (p *scriptParser) peek() (token, bool) {
+	if p.i >= len(p.toks) {
+		return token{}, false
+	}
+	return p.toks[p.i], true
+}
+
+// keyword returns the keyword at the current token, if it is one.
+func (p *scriptParser) keyword() string {
+	t, ok := p.peek()
+	if !ok || t.kind != tokenWord {
+		return ""
+	}
+	return t.bare
+}
+
+func (p *scriptParser) skipSeparators() {
+	for t, ok := p.peek(); ok && t.kind == tokenSeparator; t, ok = p.peek() {
+		p.i++
+	}
+}
+
+// block parses the body of a function or menu entry after its opening
+// brace.
+func (p *scriptParser) block() []stmt {
+	body := p.list()
+	if t, ok := p.peek(); ok && t.kind == tokenCloseBrace {
+		p.i++
+	}
+	return body
+}
+
+// list parses statements up to the end of the script, a closing brace, or a
+// keyword that ends a block, such as fi.
+func (p *scriptParser) list() []stmt {
+	var stmts []stmt
+	for {
+		t, ok := p.peek()
+		if !ok || t.kind == tokenCloseBrace {
+			return stmts
+		}
+		if t.kind != tokenWord {
+			p.i++
+			continue
+		}
+		switch t.bare {
+		case "then", "elif", "else", "fi":
+			return stmts
+		case "if":
+			stmts = append(stmts, p.ifBlock())
+		case "function":
+			if f := p.function(); f != nil {
+				stmts = append(stmts, f)
+			}
+		case "menuentry", "submenu":
+			stmts = append(stmts, p.menuBlock())
+		default:
+			stmts = append(stmts, p.command())
+		}
+	}
+}
+
+func (p *scriptParser) command() *command {
+	c := &command{}
+	for t, ok := p.peek(); ok && t.kind == tokenWord; t, ok = p.peek() {
+		c.words = append(c.words, t.word)
+		p.i++
+	}
+	return c
+}
+
+func (p *scriptParser) ifBlock() *ifBlock {
+	b := &ifBlock{}
+	for {
+		// Skip if or elif.
+		p.i++
+		b.conds = append(b.conds, p.list())
+		if p.keyword() != "then" {
+			return b
+		}
+		p.i++
+		b.bodies = append(b.bodies, p.list())
+
+		switch p.keyword() {
+		case "elif":
+			continue
+		case "else":
+			p.i++
+			b.bodies = append(b.bodies, p.list())
+			if p.keyword() == "fi" {
+				p.i++
+			}
+		case "fi":
+			p.i++
+		}
+		return b
+	}
+}
+
+func (p *scriptParser) function() *function {
+	p.i++
+	name := p.keyword()
+	if name == "" {
+		return nil
+	}
+	p.i++
+	p.skipSeparators()
+	if t, ok := p.peek(); !ok || t.kind != tokenOpenBrace {
+		return nil
+	}
+	p.i++
+	return &function{name: name, body: p.block()}
+}
+
+func (p *scriptParser) menuBlock() *menuBlock {
+	b := &menuBlock{submenu: p.keyword() == "submenu"}
+	p.i++
+	b.words = p.command().words
+	if t, ok := p.peek(); ok && t.kind == tokenOpenBrace {
+		p.i++
+		b.body = p.block()
+	}
+	return b
+}
@@ -0,0 +1,178 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package grub
+
+import (
+	"context"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/curl"
+	"github.com/u-root/u-root/pkg/mount"
+	"github.com/u-root/u-root/pkg/mount/block"
+)
+
+func testParser() *parser {
+	root := &url.URL{Scheme: "file", Path: "/boot"}
+	c := newParser(root, block.BlockDevices{}, &mount.Pool{}, curl.DefaultSchemes)
+	c.variables["grub_platform"] = "pc"
+	return c
+}
+
+func TestExpand(t *testing.T) {
+	for _, tt := range []struct {
+		line string
+		want []string
+	}{
+		{line: `echo $a`, want: []string{"echo", "x", "y"}},
+		{line: `echo "$a"`, want: []string{"echo", "x  y"}},
+		{line: `echo '$a'`, want: []string{"echo", "$a"}},
+		{line: `echo \$a`, want: []string{"echo", "$a"}},
+		{line: `echo pre${a}post`, want: []string{"echo", "prex", "ypost"}},
+		{line: `echo $empty "" "$empty"`, want: []string{"echo", "", ""}},
+		{line: `echo x$b`, want: []string{"echo", "x", "z"}},
+		{line: `echo $ ${} ${unterminated`, want: []string{"echo", "$", "${}", "${unterminated"}},
+		{line: `echo CentOS\x207`, want: []string{"echo", `CentOS\x207`}},
+	} {
+		t.Run(tt.line, func(t *testing.T) {
+			c := testParser()
+			c.variables["a"] = "x  y"
+			c.variables["b"] = " z"
+			toks := lex(tt.line)
+			var words []word
+			for _, tok := range toks {
+				words = append(words, tok.word)
+			}
+			if got := c.expand(words); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expand(%q) = %q, want %q", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTest(t *testing.T) {
+	c := testParser()
+	c.variables["root"] = "file://" + t.TempDir()
+	for _, tt := range []struct {
+		args []string
+		want bool
+	}{
+		{args: nil, want: false},
+		{args: []string{""}, want: false},
+		{args: []string{"a"}, want: true},
+		{args: []string{"-z", ""}, want: true},
+		{args: []string{"-n", ""}, want: false},
+		{args: []string{"xy", "=", "xy"}, want: true},
+		{args: []string{"x", "=", "xy"}, want: false},
+		{args: []string{"x", "!=", "xy"}, want: true},
+		{args: []string{"!", "x", "=", "x"}, want: false},
+		{args: []string{"2", "-lt", "10"}, want: true},
+		{args: []string{"a", "-lt", "10"}, want: false},
+		{args: []string{"x", "=", "y", "-o", "a", "=", "a"}, want: true},
+		{args: []string{"x", "=", "x", "-a", "a", "=", "b"}, want: false},
+		{args: []string{"a", "=", "a", "-o", "x", "=", "x", "-a", "a", "=", "b"}, want: true},
+		{args: []string{"-d", "/"}, want: true},
+		{args: []string{"-e", "/grubenv"}, want: false},
+	} {
+		if got := c.test(tt.args); got != tt.want {
+			t.Errorf("test(%q) = %t, want %t", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestScript(t *testing.T) {
+	const config = `
+function setopts {
+	set opts="$1 b"
+	set nargs=$#
+}
+if [ "$grub_platform" = efi ]; then
+	set plat=efi
+elif [ x$grub_platform = xpc ]; then set plat=pc; else set plat=other; fi
+setopts a
+x=1
+set default="More>two"
+
+if [ x"${feature_menuentry_id}" = xy ]; then
+	menuentry_id_option="--id"
+else
+	menuentry_id_option=""
+fi
+
+menuentry 'One' --class os $menuentry_id_option one {
+	set x=2
+	linux /vmlinuz-1 $opts plat=$plat nargs=$nargs '$opts' x=${x}
+}
+submenu 'More' {
+	menuentry 'Two' {
+		linux /vmlinuz-2 x=$x
+	}
+	menuentry "Three" $menuentry_id_option two {
+		linux /vmlinuz-3
+	}
+}
+if false; then
+	menuentry 'Never' {
+		linux /vmlinuz-never
+	}
+fi
+`
+	c := testParser()
+	if err := c.append(context.Background(), config); err != nil {
+		t.Fatalf("append() = %v", err)
+	}
+
+	for _, tt := range []struct {
+		keys    []string
+		name    string
+		cmdline string
+	}{
+		{
+			keys:    []string{"0", "One", "one"},
+			name:    "One",
+			cmdline: "a b plat=pc nargs=1 $opts x=2",
+		},
+		{
+			keys:    []string{"1>0", "Two", "More>Two"},
+			name:    "Two",
+			cmdline: "x=1",
+		},
+		{
+			keys: []string{"1>1", "Three", "More>Three", "More>two"},
+			name: "Three",
+		},
+	} {
+		for _, key := range tt.keys {
+			e, ok := c.linuxEntries[key]
+			if !ok {
+				t.Errorf("no entry %q", key)
+				continue
+			}
+			if e.Name != tt.name || e.Cmdline != tt.cmdline {
+				t.Errorf("entry %q = (%q, %q), want (%q, %q)", key, e.Name, e.Cmdline, tt.name, tt.cmdline)
+			}
+		}
+	}
+	if _, ok := c.linuxEntries["Never"]; ok {
+		t.Errorf("entry Never was added in a false if block")
+	}
+	if got, want := c.labelOrder, []string{"0", "One", "one", "1>0", "Two", "More>Two", "1>1", "Three", "More>Three", "More>two"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("labelOrder = %q, want %q", got, want)
+	}
+	if got := c.variables["default"]; got != "More>two" {
+		t.Errorf("default = %q, want %q", got, "More>two")
+	}
+	if got := c.variables["x"]; got != "1" {
+		t.Errorf("x = %q after the entries, want %q", got, "1")
+	}
+}
+
+func TestFunctionRecursion(t *testing.T) {
+	c := testParser()
+	if err := c.append(context.Background(), "function f {\n f; f\n}\nf\n"); err != nil {
+		t.Fatalf("append() = %v", err)
+	}
+}
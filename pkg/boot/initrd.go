@@ -15,6 +15,54 @@ import (
 	"github.com/u-root/uio/uio"
 )
 
+// catInitrd is a concatenation of initramfs files. It remembers where each
+// file is, so that they can be verified one by one.
+type catInitrd struct {
+	*uio.LazyOpenerAt
+
+	names []string
+
+	// parts are where the files are, once they are concatenated.
+	parts []*io.SectionReader
+	end   int64
+}
+
+func newCatInitrd(names []string, cat func(c *catInitrd) (io.ReaderAt, error)) *catInitrd {
+	c := &catInitrd{names: names}
+	c.LazyOpenerAt = uio.NewLazyOpenerAt(strings.Join(names, ","), func() (io.ReaderAt, error) {
+		return cat(c)
+	})
+	return c
+}
+
+// add records the next file of size bytes and returns the padding to write
+// after it.
+func (c *catInitrd) add(size int64) []byte {
+	c.parts = append(c.parts, io.NewSectionReader(c, c.end, size))
+	c.end += size
+	// Don't pad the ending or an already aligned file.
+	if len(c.parts) == len(c.names) || size%512 == 0 {
+		return nil
+	}
+	padding := make([]byte, 512-(size%512))
+	c.end += int64(len(padding))
+	return padding
+}
+
+// verify verifies the concatenated files one by one with v.
+func (c *catInitrd) verify(v Verifier) error {
+	// Concatenate the files, unless that already happened.
+	if _, err := c.ReadAt(nil, 0); err != nil && err != io.EOF {
+		return err
+	}
+	for i, p := range c.parts {
+		if err := v.Verify(c.names[i], p); err != nil {
+			return fmt.Errorf("verifying %s: %w", c.names[i], err)
+		}
+	}
+	return nil
+}
+
 // CatInitrdsWithFileCache lazily reads up multiple initrds into single tmpfs file
 // and return a os.File disguising as a io.ReaderAt.
 // It starts processing after first ReadAt call is made.
@@ -23,20 +71,18 @@ func CatInitrdsWithFileCache(initrds ...io.Reader) io.ReaderAt {
 	for _, initrd := range initrds {
 		names = append(names, stringer(initrd))
 	}
-	return uio.NewLazyOpenerAt(strings.Join(names, ","), func() (io.ReaderAt, error) {
+	return newCatInitrd(names, func(c *catInitrd) (io.ReaderAt, error) {
 		f, err := os.CreateTemp("", "combined-initrd")
 		if err != nil {
 			return nil, err
 		}
 		defer f.Close()
-		for i, ireader := range initrds {
+		for _, ireader := range initrds {
 			size, err := io.Copy(f, ireader)
 			if err != nil {
 				return nil, err
 			}
-			// Don't pad the ending or an already aligned file.
-			if i != len(initrds)-1 && size%512 != 0 {
-				padding := make([]byte, 512-(size%512))
+			if padding := c.add(size); padding != nil {
 				nr, err := f.Write(padding)
 				if err != nil {
 					return nil, err
@@ -66,18 +112,14 @@ func CatInitrds(initrds ...io.ReaderAt) io.ReaderAt {
 		names = append(names, stringer(initrd))
 	}
 
-	return uio.NewLazyOpenerAt(strings.Join(names, ","), func() (io.ReaderAt, error) {
+	return newCatInitrd(names, func(c *catInitrd) (io.ReaderAt, error) {
 		buf := new(bytes.Buffer)
-		for i, ireader := range initrds {
+		for _, ireader := range initrds {
 			size, err := buf.ReadFrom(uio.Reader(ireader))
 			if err != nil {
 				return nil, err
 			}
-			// Don't pad the ending or an already aligned file.
-			if i != len(initrds)-1 && size%512 != 0 {
-				padding := make([]byte, 512-(size%512))
-				buf.Write(padding)
-			}
+			buf.Write(c.add(size))
 		}
 		// Buffer doesn't implement ReadAt, so wrap in NewReader
 		return bytes.NewReader(buf.Bytes()), nil
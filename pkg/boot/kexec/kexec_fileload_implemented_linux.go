@@ -27,7 +27,7 @@ func FileLoad(kernel, ramfs *os.File, cmdline string) error {
 	}
 
 	if err := unix.KexecFileLoad(int(kernel.Fd()), ramfsfd, cmdline, flags); err != nil {
-		return fmt.Errorf("SYS_kexec_file_load(%d, %d, %s, %x) = %v", kernel.Fd(), ramfsfd, cmdline, flags, err)
+		return fmt.Errorf("SYS_kexec_file_load(%d, %d, %s, %x) = %w", kernel.Fd(), ramfsfd, cmdline, flags, err)
 	}
 	return nil
 }
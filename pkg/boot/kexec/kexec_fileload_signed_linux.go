@@ -0,0 +1,153 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package kexec
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+var (
+	// ErrNotEnforced is returned by FileLoadSigned when the running
+	// kernel would load kernels whose signature it cannot verify.
+	ErrNotEnforced = errors.New("kernel signature verification is not enforced: boot with lockdown=integrity, an IMA appraise policy for KEXEC_KERNEL_CHECK, or CONFIG_KEXEC_SIG_FORCE")
+
+	// ErrSignatureRejected is returned by FileLoadSigned when the running
+	// kernel failed to verify the signature of the kernel.
+	ErrSignatureRejected = errors.New("kernel signature verification failed")
+)
+
+// The files telling how the running kernel enforces signatures.
+var (
+	lockdownFile  = "/sys/kernel/security/lockdown"
+	imaPolicyFile = "/sys/kernel/security/ima/policy"
+	configFile    = "/proc/config.gz"
+)
+
+// lockdown returns the lockdown mode of the running kernel, e.g. integrity,
+// or "none".
+func lockdown() string {
+	b, err := os.ReadFile(lockdownFile)
+	if err != nil {
+		return "none"
+	}
+	// The mode in effect is in brackets: none [integrity] confidentiality.
+	for _, m := range strings.Fields(string(b)) {
+		if strings.HasPrefix(m, "[") && strings.HasSuffix(m, "]") {
+			return strings.Trim(m, "[]")
+		}
+	}
+	return "none"
+}
+
+// imaAppraisesKexec returns whether the IMA policy, if it can be read,
+// appraises kexec kernels.
+func imaAppraisesKexec() bool {
+	f, err := os.Open(imaPolicyFile)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || fields[0] != "appraise" {
+			continue
+		}
+		for _, f := range fields[1:] {
+			if f == "func=KEXEC_KERNEL_CHECK" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sigForced returns whether the running kernel was built with
+// CONFIG_KEXEC_SIG_FORCE, if it has /proc/config.gz.
+func sigForced() bool {
+	f, err := os.Open(configFile)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	z, err := gzip.NewReader(f)
+	if err != nil {
+		return false
+	}
+	s := bufio.NewScanner(z)
+	for s.Scan() {
+		if bytes.Equal(s.Bytes(), []byte("CONFIG_KEXEC_SIG_FORCE=y")) {
+			return true
+		}
+	}
+	return false
+}
+
+// SignatureEnforcement returns how the running kernel makes kexec_file_load
+// fail for kernels without a valid signature, or ErrNotEnforced if it does
+// not. The kernel verifies signatures against its builtin and secondary
+// trusted keyrings, and then against the platform keyring of the UEFI db
+// and MOK keys.
+func SignatureEnforcement() (string, error) {
+	if sigForced() {
+		return "CONFIG_KEXEC_SIG_FORCE", nil
+	}
+	if imaAppraisesKexec() {
+		return "IMA appraisal", nil
+	}
+	if m := lockdown(); m != "none" {
+		return "lockdown=" + m, nil
+	}
+	return "", ErrNotEnforced
+}
+
+// verificationErrors are the reasons of the errors of kexec_file_load when
+// the kernel fails to verify a signature.
+var verificationErrors = map[unix.Errno]string{
+	unix.EKEYREJECTED: "the signature does not match the kernel, or its key was rejected",
+	unix.ENOKEY:       "the signing key is in neither the trusted nor the platform keyring",
+	unix.EKEYEXPIRED:  "the signing key has expired",
+	unix.ENODATA:      "the kernel found no signature",
+	unix.EBADMSG:      "the kernel cannot parse the signature",
+	unix.ELIBBAD:      "the kernel cannot parse the signature",
+	unix.ENOPKG:       "the kernel does not support the algorithms of the signature",
+	unix.EPERM:        "rejected by lockdown, or missing CAP_SYS_BOOT",
+	unix.EACCES:       "rejected by the IMA appraisal policy",
+}
+
+// fileLoad is FileLoad, but for tests.
+var fileLoad = FileLoad
+
+// FileLoadSigned loads kernel as FileLoad does, but only if it is signed and
+// the running kernel enforces the verification of its signature, which is
+// done by kexec_file_load against the keyrings of the running kernel.
+// Failures to verify the signature are ErrSignatureRejected.
+func FileLoadSigned(kernel, ramfs *os.File, cmdline string) error {
+	sig, err := ReadSignature(kernel)
+	if err != nil {
+		return fmt.Errorf("%s: %w", kernel.Name(), err)
+	}
+	if _, err := SignatureEnforcement(); err != nil {
+		return err
+	}
+	if err := fileLoad(kernel, ramfs, cmdline); err != nil {
+		var errno unix.Errno
+		if errors.As(err, &errno) {
+			if reason, ok := verificationErrors[errno]; ok {
+				return fmt.Errorf("%w: %s: %s (signed by %v): %w", ErrSignatureRejected, kernel.Name(), reason, sig, err)
+			}
+		}
+		return err
+	}
+	return nil
+}
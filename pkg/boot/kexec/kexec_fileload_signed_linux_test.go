@@ -0,0 +1,156 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package kexec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// enforce points the files of SignatureEnforcement at files with the
+// contents, missing if empty.
+func enforce(t *testing.T, lockdown, policy, config string) {
+	t.Helper()
+	dir := t.TempDir()
+	write := func(name string, b []byte) string {
+		p := filepath.Join(dir, name)
+		if len(b) > 0 {
+			if err := os.WriteFile(p, b, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return p
+	}
+	var z []byte
+	if config != "" {
+		var b bytes.Buffer
+		w := gzip.NewWriter(&b)
+		w.Write([]byte(config))
+		w.Close()
+		z = b.Bytes()
+	}
+	saved := []string{lockdownFile, imaPolicyFile, configFile}
+	lockdownFile = write("lockdown", []byte(lockdown))
+	imaPolicyFile = write("policy", []byte(policy))
+	configFile = write("config.gz", z)
+	t.Cleanup(func() {
+		lockdownFile, imaPolicyFile, configFile = saved[0], saved[1], saved[2]
+	})
+}
+
+func TestSignatureEnforcement(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		lockdown string
+		policy   string
+		config   string
+		want     string
+		err      error
+	}{
+		{name: "none", lockdown: "[none] integrity confidentiality\n", err: ErrNotEnforced},
+		{name: "no securityfs", err: ErrNotEnforced},
+		{name: "integrity", lockdown: "none [integrity] confidentiality\n", want: "lockdown=integrity"},
+		{name: "confidentiality", lockdown: "none integrity [confidentiality]\n", want: "lockdown=confidentiality"},
+		{
+			name:     "ima",
+			lockdown: "[none] integrity confidentiality\n",
+			policy:   "measure func=KEXEC_KERNEL_CHECK\nappraise func=KEXEC_KERNEL_CHECK appraise_type=imasig\n",
+			want:     "IMA appraisal",
+		},
+		{name: "ima measure only", policy: "measure func=KEXEC_KERNEL_CHECK\n", err: ErrNotEnforced},
+		{name: "sig force", config: "CONFIG_KEXEC_SIG=y\nCONFIG_KEXEC_SIG_FORCE=y\n", want: "CONFIG_KEXEC_SIG_FORCE"},
+		{name: "sig not forced", config: "CONFIG_KEXEC_SIG=y\n# CONFIG_KEXEC_SIG_FORCE is not set\n", err: ErrNotEnforced},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			enforce(t, tt.lockdown, tt.policy, tt.config)
+			got, err := SignatureEnforcement()
+			if got != tt.want || !errors.Is(err, tt.err) {
+				t.Errorf("SignatureEnforcement() = %q, %v, want %q, %v", got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestFileLoadSigned(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, b []byte) *os.File {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { f.Close() })
+		return f
+	}
+	signed := write("signed", testPE(t, winCert(certPKCSSigned, testPKCS7(t, testCert(t, "Kernel Signing")))))
+	unsigned := write("unsigned", testPE(t, nil))
+
+	defer func(f func(kernel, ramfs *os.File, cmdline string) error) { fileLoad = f }(fileLoad)
+	for _, tt := range []struct {
+		name     string
+		kernel   *os.File
+		lockdown string
+		loadErr  error
+		err      error
+		msg      string
+	}{
+		{name: "loaded", kernel: signed, lockdown: "none [integrity] confidentiality"},
+		{name: "unsigned", kernel: unsigned, lockdown: "none [integrity] confidentiality", err: ErrUnsigned},
+		{name: "not enforced", kernel: signed, lockdown: "[none] integrity confidentiality", err: ErrNotEnforced},
+		{
+			name:     "untrusted key",
+			kernel:   signed,
+			lockdown: "none [integrity] confidentiality",
+			loadErr:  fmt.Errorf("SYS_kexec_file_load(3, 0, , 4) = %w", unix.ENOKEY),
+			err:      ErrSignatureRejected,
+			msg:      "neither the trusted nor the platform keyring (signed by CN=Kernel Signing)",
+		},
+		{
+			name:     "rejected",
+			kernel:   signed,
+			lockdown: "none [integrity] confidentiality",
+			loadErr:  unix.EKEYREJECTED,
+			err:      unix.EKEYREJECTED,
+			msg:      "does not match the kernel",
+		},
+		{
+			name:     "other errors",
+			kernel:   signed,
+			lockdown: "none [integrity] confidentiality",
+			loadErr:  unix.ENOMEM,
+			err:      unix.ENOMEM,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			enforce(t, tt.lockdown, "", "")
+			loaded := false
+			fileLoad = func(kernel, ramfs *os.File, cmdline string) error {
+				loaded = true
+				return tt.loadErr
+			}
+			err := FileLoadSigned(tt.kernel, nil, "console=ttyS0")
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("FileLoadSigned = %v, want %v", err, tt.err)
+			}
+			if err != nil && !strings.Contains(err.Error(), tt.msg) {
+				t.Errorf("FileLoadSigned = %v, want it to contain %q", err, tt.msg)
+			}
+			if wantLoad := tt.err == nil || tt.loadErr != nil; loaded != wantLoad {
+				t.Errorf("kexec_file_load called: %v, want %v", loaded, wantLoad)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package kexec
+
+import (
+	"crypto/x509"
+	"debug/pe"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var (
+	// ErrUnsigned is returned for kernels without a PE signature.
+	ErrUnsigned = errors.New("kernel is not signed")
+
+	// ErrBadSignature is returned for kernels whose signature cannot be
+	// parsed.
+	ErrBadSignature = errors.New("malformed kernel signature")
+)
+
+// The attribute certificates of a PE image, WIN_CERTIFICATE.
+const (
+	certRevision    = 0x0200
+	certPKCSSigned  = 0x0002
+	certHeaderSize  = 8
+	certTableDirIdx = pe.IMAGE_DIRECTORY_ENTRY_SECURITY
+)
+
+var oidSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+
+// Signature is the Authenticode signature of a PE image, e.g. of an EFI stub
+// kernel signed with sbsign or pesign.
+type Signature struct {
+	// Certificates are those of the PKCS#7 signatures of the image: the
+	// signers and the intermediates leading to the keys the kernel trusts.
+	Certificates []*x509.Certificate
+}
+
+// String returns the subjects of the certificates of s.
+func (s *Signature) String() string {
+	if len(s.Certificates) == 0 {
+		return "no certificates"
+	}
+	var names []string
+	for _, c := range s.Certificates {
+		names = append(names, c.Subject.String())
+	}
+	return strings.Join(names, ", ")
+}
+
+// ReadSignature reads the signature of the PE image kernel, as kexec_file_load
+// verifies it. It returns ErrUnsigned for kernels, such as those built
+// without the EFI stub, without one.
+func ReadSignature(kernel io.ReaderAt) (*Signature, error) {
+	f, err := pe.NewFile(kernel)
+	if err != nil {
+		return nil, fmt.Errorf("%w: not a PE image: %v", ErrUnsigned, err)
+	}
+	var dirs []pe.DataDirectory
+	switch oh := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		dirs = oh.DataDirectory[:min(oh.NumberOfRvaAndSizes, 16)]
+	case *pe.OptionalHeader64:
+		dirs = oh.DataDirectory[:min(oh.NumberOfRvaAndSizes, 16)]
+	}
+	if len(dirs) <= certTableDirIdx || dirs[certTableDirIdx].Size == 0 {
+		return nil, ErrUnsigned
+	}
+	// The certificate table is at a file offset, not a virtual address.
+	dir := dirs[certTableDirIdx]
+	// The size comes from the image, so check the table is in it before
+	// allocating it.
+	var last [1]byte
+	if _, err := kernel.ReadAt(last[:], int64(dir.VirtualAddress)+int64(dir.Size)-1); err != nil {
+		return nil, fmt.Errorf("%w: certificate table of %d bytes at %#x is past the end of the image: %v", ErrBadSignature, dir.Size, dir.VirtualAddress, err)
+	}
+	table := make([]byte, dir.Size)
+	if _, err := kernel.ReadAt(table, int64(dir.VirtualAddress)); err != nil {
+		return nil, fmt.Errorf("%w: reading the certificate table: %v", ErrBadSignature, err)
+	}
+
+	s := &Signature{}
+	for len(table) >= certHeaderSize {
+		length := binary.LittleEndian.Uint32(table[0:])
+		revision := binary.LittleEndian.Uint16(table[4:])
+		typ := binary.LittleEndian.Uint16(table[6:])
+		if length < certHeaderSize || uint64(length) > uint64(len(table)) {
+			return nil, fmt.Errorf("%w: certificate of %d bytes in a table of %d", ErrBadSignature, length, len(table))
+		}
+		if revision == certRevision && typ == certPKCSSigned {
+			certs, err := pkcs7Certificates(table[certHeaderSize:length])
+			if err != nil {
+				return nil, err
+			}
+			s.Certificates = append(s.Certificates, certs...)
+		}
+		// Entries are 8-byte aligned.
+		next := (uint64(length) + 7) &^ 7
+		if next >= uint64(len(table)) {
+			break
+		}
+		table = table[next:]
+	}
+	if len(s.Certificates) == 0 {
+		return nil, fmt.Errorf("%w: no PKCS#7 certificates", ErrBadSignature)
+	}
+	return s, nil
+}
+
+// signedData is the PKCS#7 SignedData of RFC 2315, up to its certificates.
+type signedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      asn1.RawValue
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+// pkcs7Certificates returns the certificates of the PKCS#7 SignedData b.
+func pkcs7Certificates(b []byte) ([]*x509.Certificate, error) {
+	var ci contentInfo
+	// Signing tools pad the signature to 8 bytes, so ignore trailing data.
+	if _, err := asn1.Unmarshal(b, &ci); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadSignature, err)
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("%w: content type %v is not signed data", ErrBadSignature, ci.ContentType)
+	}
+	var sd signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadSignature, err)
+	}
+	certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadSignature, err)
+	}
+	return certs, nil
+}
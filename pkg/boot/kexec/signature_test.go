@@ -0,0 +1,189 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package kexec
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"debug/pe"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCert returns a self-signed certificate for the common name.
+func testCert(t *testing.T, name string) []byte {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+// testPKCS7 returns a PKCS#7 SignedData with the certificates, and without
+// signers, which ReadSignature does not look at.
+func testPKCS7(t *testing.T, certs ...[]byte) []byte {
+	t.Helper()
+	sd, err := asn1.Marshal(struct {
+		Version          int
+		DigestAlgorithms asn1.RawValue
+		ContentInfo      struct{ ContentType asn1.ObjectIdentifier }
+		Certificates     asn1.RawValue
+		SignerInfos      asn1.RawValue
+	}{
+		Version:          1,
+		DigestAlgorithms: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true},
+		ContentInfo:      struct{ ContentType asn1.ObjectIdentifier }{asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 4}},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: bytes.Join(certs, nil)},
+		SignerInfos:      asn1.RawValue{Tag: asn1.TagSet, IsCompound: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := asn1.Marshal(struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue
+	}{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sd},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// winCert returns a WIN_CERTIFICATE of the type, padded to 8 bytes.
+func winCert(typ uint16, cert []byte) []byte {
+	b := binary.LittleEndian.AppendUint32(nil, uint32(certHeaderSize+len(cert)))
+	b = binary.LittleEndian.AppendUint16(b, certRevision)
+	b = binary.LittleEndian.AppendUint16(b, typ)
+	b = append(b, cert...)
+	for len(b)%8 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// testPE returns a PE32+ image whose certificate table is table.
+func testPE(t *testing.T, table []byte) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	dos := make([]byte, 0x40)
+	copy(dos, "MZ")
+	binary.LittleEndian.PutUint32(dos[0x3c:], 0x40)
+	b.Write(dos)
+	b.WriteString("PE\x00\x00")
+	oh := pe.OptionalHeader64{Magic: 0x20b, NumberOfRvaAndSizes: 16}
+	fh := pe.FileHeader{Machine: pe.IMAGE_FILE_MACHINE_AMD64, SizeOfOptionalHeader: uint16(binary.Size(oh))}
+	headers := b.Len() + binary.Size(fh) + binary.Size(oh)
+	if len(table) > 0 {
+		oh.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_SECURITY] = pe.DataDirectory{VirtualAddress: uint32(headers), Size: uint32(len(table))}
+	}
+	if err := binary.Write(&b, binary.LittleEndian, fh); err != nil {
+		t.Fatal(err)
+	}
+	if err := binary.Write(&b, binary.LittleEndian, oh); err != nil {
+		t.Fatal(err)
+	}
+	b.Write(table)
+	return b.Bytes()
+}
+
+// certTableSize sets the size of the certificate table of a testPE image.
+func certTableSize(image []byte, size uint32) []byte {
+	var oh pe.OptionalHeader64
+	dirs := binary.Size(oh) - binary.Size(oh.DataDirectory)
+	off := 0x40 + 4 + binary.Size(pe.FileHeader{}) + dirs + binary.Size(pe.DataDirectory{})*pe.IMAGE_DIRECTORY_ENTRY_SECURITY + 4
+	image = bytes.Clone(image)
+	binary.LittleEndian.PutUint32(image[off:], size)
+	return image
+}
+
+func TestReadSignature(t *testing.T) {
+	vendor, distro := testCert(t, "Vendor Secure Boot Signing"), testCert(t, "Distro Kernel Signing")
+	for _, tt := range []struct {
+		name  string
+		image []byte
+		want  []string
+		err   error
+	}{
+		{
+			name:  "signed",
+			image: testPE(t, winCert(certPKCSSigned, testPKCS7(t, vendor))),
+			want:  []string{"CN=Vendor Secure Boot Signing"},
+		},
+		{
+			name:  "chain",
+			image: testPE(t, winCert(certPKCSSigned, testPKCS7(t, distro, vendor))),
+			want:  []string{"CN=Distro Kernel Signing", "CN=Vendor Secure Boot Signing"},
+		},
+		{
+			name:  "two signatures",
+			image: testPE(t, append(winCert(certPKCSSigned, testPKCS7(t, vendor)), winCert(certPKCSSigned, testPKCS7(t, distro))...)),
+			want:  []string{"CN=Vendor Secure Boot Signing", "CN=Distro Kernel Signing"},
+		},
+		{
+			name:  "other certificate types",
+			image: testPE(t, append(winCert(1, vendor), winCert(certPKCSSigned, testPKCS7(t, distro))...)),
+			want:  []string{"CN=Distro Kernel Signing"},
+		},
+		{name: "unsigned", image: testPE(t, nil), err: ErrUnsigned},
+		{name: "not PE", image: []byte("testkernel"), err: ErrUnsigned},
+		{name: "only X.509", image: testPE(t, winCert(1, vendor)), err: ErrBadSignature},
+		{name: "not PKCS#7", image: testPE(t, winCert(certPKCSSigned, vendor)), err: ErrBadSignature},
+		{name: "garbage", image: testPE(t, winCert(certPKCSSigned, []byte("garbage"))), err: ErrBadSignature},
+		{name: "overlong", image: testPE(t, []byte{0xff, 0, 0, 0, 0, 2, 2, 0}), err: ErrBadSignature},
+		{
+			name:  "oversized table",
+			image: certTableSize(testPE(t, winCert(certPKCSSigned, testPKCS7(t, vendor))), 0xffffffff),
+			err:   ErrBadSignature,
+		},
+		{
+			name:  "truncated table",
+			image: testPE(t, winCert(certPKCSSigned, testPKCS7(t, vendor)))[:340],
+			err:   ErrBadSignature,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := ReadSignature(bytes.NewReader(tt.image))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("ReadSignature = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			var got []string
+			for _, c := range s.Certificates {
+				got = append(got, c.Subject.String())
+			}
+			if strings.Join(got, "; ") != strings.Join(tt.want, "; ") {
+				t.Errorf("ReadSignature = %q, want %q", got, tt.want)
+			}
+			if s.String() != strings.Join(tt.want, ", ") {
+				t.Errorf("String() = %q, want %q", s, strings.Join(tt.want, ", "))
+			}
+		})
+	}
+}
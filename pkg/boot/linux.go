@@ -30,6 +30,11 @@ type LinuxImage struct {
 	LoadSyscall bool
 	DTB         io.ReaderAt
 
+	// VerifySignature loads the kernel only if it is signed and the
+	// running kernel verifies its signature, as kexec.FileLoadSigned
+	// does. It cannot be used with LoadSyscall.
+	VerifySignature bool
+
 	// ReservedRanges are additional physical memory pieces that will be
 	// avoided when allocating kexec segments. Only used for LoadSyscall.
 	//
@@ -41,7 +46,10 @@ type LinuxImage struct {
 
 var _ OSImage = &LinuxImage{}
 
-var errNilKernel = errors.New("kernel image is empty, nothing to execute")
+var (
+	errNilKernel     = errors.New("kernel image is empty, nothing to execute")
+	errSignedSyscall = errors.New("kexec_load cannot verify kernel signatures, use kexec_file_load")
+)
 
 // named is satisifed by *os.File.
 type named interface {
@@ -199,11 +207,17 @@ func (li *LinuxImage) loadImage(loadOpts *loadOptions) (*os.File, *os.File, erro
 		return nil, nil, errNilKernel
 	}
 
-	k, err := CopyToFileIfNotRegular(util.TryGzipFilter(li.Kernel), loadOpts.verbose)
+	k, err := CopyToFileIfNotRegular(util.TryDecompressFilter(li.Kernel), loadOpts.verbose)
 	if err != nil {
 		return nil, nil, err
 	}
 
+	// The kernel only unpacks initramfs compressed in the formats it was
+	// built with, so decompress it here, before the DTB is appended.
+	if li.Initrd != nil {
+		li.Initrd = util.TryDecompressFilter(li.Initrd)
+	}
+
 	// Append device-tree file to the end of initrd.
 	if li.DTB != nil {
 		if li.Initrd != nil {
@@ -231,6 +245,34 @@ func (li *LinuxImage) Load(opts ...LoadOption) error {
 		opt(loadOpts)
 	}
 
+	if li.VerifySignature && li.LoadSyscall {
+		return errSignedSyscall
+	}
+
+	if li.Kernel == nil {
+		return errNilKernel
+	}
+	for _, asset := range []io.ReaderAt{li.Kernel, li.Initrd, li.DTB} {
+		if asset == nil {
+			continue
+		}
+		if err := verifyAsset(loadOpts.verifier, asset); err != nil {
+			return err
+		}
+	}
+
+	// The measurer and loadImage replace the initramfs, e.g. to append
+	// an event log or the DTB. Do that on a copy, so that li loads the
+	// same files again if this load fails or is retried.
+	img := *li
+	li = &img
+
+	if loadOpts.measurer != nil && loadOpts.callKexecLoad {
+		if err := loadOpts.measurer.MeasureLinux(li); err != nil {
+			return fmt.Errorf("measuring %s: %w", li.Label(), err)
+		}
+	}
+
 	k, i, err := li.loadImage(loadOpts)
 	if err != nil {
 		return err
@@ -246,10 +288,20 @@ func (li *LinuxImage) Load(opts ...LoadOption) error {
 	}
 	loadOpts.logger.Printf("Command line: %s", li.Cmdline)
 	loadOpts.logger.Printf("DTB: %#v", li.DTB)
+	if li.VerifySignature {
+		sig, err := kexec.ReadSignature(k)
+		if err != nil {
+			return fmt.Errorf("%s: %w", k.Name(), err)
+		}
+		loadOpts.logger.Printf("Signed by: %v", sig)
+	}
 
 	if !loadOpts.callKexecLoad {
 		return nil
 	}
+	if li.VerifySignature {
+		return kexec.FileLoadSigned(k, i, li.Cmdline)
+	}
 	if li.LoadSyscall {
 		return linux.KexecLoad(k, i, li.Cmdline, li.DTB, li.ReservedRanges)
 	}
@@ -0,0 +1,88 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/dt"
+)
+
+// ErrNoCompatibleDTB is returned when no device tree is compatible with the
+// machine.
+var ErrNoCompatibleDTB = errors.New("no device tree is compatible with the machine")
+
+// compatible returns the compatible strings of the root node of fdt, from
+// the most to the least specific.
+func compatible(fdt *dt.FDT) []string {
+	p, ok := fdt.RootNode.LookProperty("compatible")
+	if !ok {
+		return nil
+	}
+	c, err := p.AsStringList()
+	if err != nil {
+		return nil
+	}
+	return c
+}
+
+// SelectDTB returns the index of the device tree of dtbs that describes the
+// machine booted with the device tree machine: the first of those
+// compatible with the most specific of its compatible strings, as
+// bootloaders choose them.
+func SelectDTB(machine *dt.FDT, dtbs []*dt.FDT) (int, error) {
+	want := compatible(machine)
+	if len(want) == 0 {
+		return -1, fmt.Errorf("%w: the machine has no compatible strings", ErrNoCompatibleDTB)
+	}
+	for _, w := range want {
+		for i, d := range dtbs {
+			for _, c := range compatible(d) {
+				if c == w {
+					Debug("Device tree %d is compatible with %q", i, w)
+					return i, nil
+				}
+			}
+		}
+	}
+	return -1, fmt.Errorf("%w: %s", ErrNoCompatibleDTB, strings.Join(want, ", "))
+}
+
+// SelectDTBFile returns the path of the .dtb file under dir, e.g. the
+// /boot/dtbs of a distribution, that describes the machine booted with the
+// device tree machine, as SelectDTB does. Files that are not device trees
+// are skipped.
+func SelectDTBFile(dir string, machine *dt.FDT) (string, error) {
+	var paths []string
+	var dtbs []*dt.FDT
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || filepath.Ext(path) != ".dtb" {
+			return nil
+		}
+		fdt, err := dt.ReadFile(path)
+		if err != nil {
+			Debug("Skipping %s: %v", path, err)
+			return nil
+		}
+		paths = append(paths, path)
+		dtbs = append(dtbs, fdt)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	i, err := SelectDTB(machine, dtbs)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", dir, err)
+	}
+	return paths[i], nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/dt"
+)
+
+// compatibleFDT returns a device tree compatible with the strings.
+func compatibleFDT(compatible ...string) *dt.FDT {
+	root := dt.NewNode("/")
+	if len(compatible) > 0 {
+		root.Properties = append(root.Properties, dt.Property{Name: "compatible", Value: []byte(strings.Join(compatible, "\x00") + "\x00")})
+	}
+	return &dt.FDT{RootNode: root}
+}
+
+func TestSelectDTB(t *testing.T) {
+	dtbs := []*dt.FDT{
+		compatibleFDT("vendor,board-rev-a", "vendor,soc"),
+		compatibleFDT("vendor,board-rev-b", "vendor,soc"),
+		compatibleFDT("other,board", "other,soc"),
+		compatibleFDT(),
+	}
+	for _, tt := range []struct {
+		name    string
+		machine *dt.FDT
+		want    int
+		err     error
+	}{
+		{name: "exact", machine: compatibleFDT("vendor,board-rev-b", "vendor,soc"), want: 1},
+		{name: "most specific first", machine: compatibleFDT("other,board", "vendor,soc"), want: 2},
+		{name: "fallback", machine: compatibleFDT("vendor,board-rev-c", "vendor,soc"), want: 0},
+		{name: "none", machine: compatibleFDT("acme,board"), want: -1, err: ErrNoCompatibleDTB},
+		{name: "no compatible", machine: compatibleFDT(), want: -1, err: ErrNoCompatibleDTB},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SelectDTB(tt.machine, dtbs)
+			if got != tt.want || !errors.Is(err, tt.err) {
+				t.Errorf("SelectDTB = %d, %v, want %d, %v", got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestSelectDTBFile(t *testing.T) {
+	dir := t.TempDir()
+	for name, b := range map[string][]byte{
+		"vendor/board-a.dtb": fdtBytes(t, compatibleFDT("vendor,board-a", "vendor,soc")),
+		"vendor/board-b.dtb": fdtBytes(t, compatibleFDT("vendor,board-b", "vendor,soc")),
+		"vendor/board-b.dts": []byte("/dts-v1/;"),
+		"vendor/broken.dtb":  []byte("not a device tree"),
+	} {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := SelectDTBFile(dir, compatibleFDT("vendor,board-b", "vendor,soc"))
+	if want := filepath.Join(dir, "vendor/board-b.dtb"); got != want || err != nil {
+		t.Errorf("SelectDTBFile = %q, %v, want %q, nil", got, err, want)
+	}
+	if _, err := SelectDTBFile(dir, compatibleFDT("acme,board")); !errors.Is(err, ErrNoCompatibleDTB) {
+		t.Errorf("SelectDTBFile = %v, want %v", err, ErrNoCompatibleDTB)
+	}
+	if _, err := SelectDTBFile(filepath.Join(dir, "missing"), compatibleFDT("vendor,soc")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("SelectDTBFile = %v, want %v", err, os.ErrNotExist)
+	}
+}
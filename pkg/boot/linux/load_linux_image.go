@@ -6,6 +6,7 @@ package linux
 
 import (
 	"bytes"
+	"crypto/rand"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -36,21 +37,42 @@ const (
 	kernelAlignSize = 1 << 21 // 2 MB.
 )
 
-var errNoChosenNode = fmt.Errorf("no /chosen node in device tree")
+// seedReader is the source of the kaslr-seed and rng-seed of the next
+// kernel.
+var seedReader io.Reader = rand.Reader
 
-// sanitizeFDT cleanups boot param properties from chosen node of the given FDT.
-func sanitizeFDT(fdt *dt.FDT) (*dt.Node, error) {
+// rngSeedSize is the size of the rng-seed the kernel's own kexec_file_load
+// passes.
+const rngSeedSize = 128
+
+// sanitizeFDT cleanups boot param properties from chosen node of the given
+// FDT, adding the node if there is none.
+func sanitizeFDT(fdt *dt.FDT) *dt.Node {
 	// Clear old entries in case we've already been through kexec to get
 	// to this instance of runtime.
-	chosen, _ := fdt.NodeByName("chosen")
-	if chosen == nil {
-		return nil, errNoChosenNode
+	chosen, ok := fdt.RootNode.LookupChildByName("chosen")
+	if !ok {
+		chosen = dt.NewNode("chosen")
+		fdt.RootNode.Children = append(fdt.RootNode.Children, chosen)
 	}
 	for _, property := range []string{"linux,elfcorehdr", "linux,usable-memory-range", "kaslr-seed", "rng-seed", "linux,initrd-start", "linux,initrd-end"} {
 		chosen.RemoveProperty(property)
 	}
 
-	return chosen, nil
+	return chosen
+}
+
+// seedFDT gives the next kernel fresh seeds, for the randomization of its
+// address space and for its entropy pool: the running kernel consumed, and
+// zeroed, those it booted with.
+func seedFDT(chosen *dt.Node) {
+	seed := make([]byte, 8+rngSeedSize)
+	if _, err := io.ReadFull(seedReader, seed); err != nil {
+		Debug("No seeds for the next kernel: %v", err)
+		return
+	}
+	chosen.UpdateProperty("kaslr-seed", seed[:8])
+	chosen.UpdateProperty("rng-seed", seed[8:])
 }
 
 var ErrMemmapEmpty = errors.New("memory map is empty or contains no information about system RAM")
@@ -132,10 +154,7 @@ func kexecLoadImageMM(mm kexec.MemoryMap, kernel, ramfs *os.File, fdt *dt.FDT, c
 
 	Debug("Added %#x byte (size %#x) kernel at %s with offset %#x with alignment %#x", len(kernelBuf), kImage.Header.ImageSize, kernelRange, kImage.Header.TextOffset, kernelAlignSize)
 
-	chosen, err := sanitizeFDT(fdt)
-	if err != nil {
-		return nil, fmt.Errorf("sanitizeFDT(%v) = %w", fdt, err)
-	}
+	chosen := sanitizeFDT(fdt)
 	Debug("FDT after sanitization: %s", fdt)
 
 	if ramfs != nil {
@@ -172,6 +191,7 @@ func kexecLoadImageMM(mm kexec.MemoryMap, kernel, ramfs *os.File, fdt *dt.FDT, c
 	} else {
 		chosen.RemoveProperty("bootargs")
 	}
+	seedFDT(chosen)
 
 	var dtbBuffer bytes.Buffer
 	if _, err := fdt.Write(&dtbBuffer); err != nil {
@@ -109,8 +109,24 @@ func trampoline(kernelEntry, dtbBase uint64) []byte {
 	return t
 }
 
+// seedByte is the byte of the seeds of the tests.
+type seedByte byte
+
+func (b seedByte) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(b)
+	}
+	return len(p), nil
+}
+
 func TestKexecLoadImage(t *testing.T) {
 	Debug = t.Logf
+	defer func(r io.Reader) { seedReader = r }(seedReader)
+	seedReader = seedByte(0x5a)
+	seeds := []dt.Property{
+		{Name: "kaslr-seed", Value: bytes.Repeat([]byte{0x5a}, 8)},
+		{Name: "rng-seed", Value: bytes.Repeat([]byte{0x5a}, rngSeedSize)},
+	}
 
 	for _, tt := range []struct {
 		name string
@@ -146,7 +162,7 @@ func TestKexecLoadImage(t *testing.T) {
 			}),
 			segments: kexec.Segments{
 				kexec.NewSegment(fdtBytes(t, &dt.FDT{RootNode: dt.NewNode("/", dt.WithChildren(
-					dt.NewNode("chosen"),
+					dt.NewNode("chosen", dt.WithProperty(seeds...)),
 					dt.NewNode("test memory", dt.WithProperty(
 						dt.PropertyString("device_type", "memory"),
 						dt.PropertyRegion("reg", 0x100000, 0xf00000),
@@ -179,7 +195,7 @@ func TestKexecLoadImage(t *testing.T) {
 						// TODO: should this actually be 0x100005?
 						dt.PropertyU64("linux,initrd-end", 0x101000),
 						dt.PropertyString("bootargs", "foobar"),
-					)),
+					), dt.WithProperty(seeds...)),
 					dt.NewNode("test memory", dt.WithProperty(
 						dt.PropertyString("device_type", "memory"),
 						dt.PropertyRegion("reg", 0x100000, 0xf00000),
@@ -207,7 +223,7 @@ func TestKexecLoadImage(t *testing.T) {
 			}),
 			segments: kexec.Segments{
 				kexec.NewSegment(fdtBytes(t, &dt.FDT{RootNode: dt.NewNode("/", dt.WithChildren(
-					dt.NewNode("chosen"),
+					dt.NewNode("chosen", dt.WithProperty(seeds...)),
 					dt.NewNode("test memory", dt.WithProperty(
 						dt.PropertyString("device_type", "memory"),
 						dt.PropertyRegion("reg", 0x100000, 0xf00000),
@@ -226,7 +242,18 @@ func TestKexecLoadImage(t *testing.T) {
 					dt.PropertyRegion("reg", 0x100000, 0xf00000),
 				)),
 			))}),
-			errs: []error{errNoChosenNode},
+			entry: 0x101000,
+			segments: kexec.Segments{
+				kexec.NewSegment(fdtBytes(t, &dt.FDT{RootNode: dt.NewNode("/", dt.WithChildren(
+					dt.NewNode("test memory", dt.WithProperty(
+						dt.PropertyString("device_type", "memory"),
+						dt.PropertyRegion("reg", 0x100000, 0xf00000),
+					)),
+					dt.NewNode("chosen", dt.WithProperty(seeds...)),
+				))}), kexec.Range{Start: 0x100000, Size: 0x1000}),
+				kexec.NewSegment(trampoline(0x200000, 0x100000), kexec.Range{Start: 0x101000, Size: 0x1000}),
+				kexec.NewSegment(readFile(t, "../image/testdata/Image"), kexec.Range{Start: 0x200000, Size: 0xa00000}),
+			},
 		},
 		{
 			name:   "not enough space for kernel image",
@@ -362,7 +389,7 @@ func TestKexecLoadImage(t *testing.T) {
 			},
 			segments: kexec.Segments{
 				kexec.NewSegment(fdtBytes(t, &dt.FDT{RootNode: dt.NewNode("/", dt.WithChildren(
-					dt.NewNode("chosen"),
+					dt.NewNode("chosen", dt.WithProperty(seeds...)),
 					dt.NewNode("test memory", dt.WithProperty(
 						dt.PropertyString("device_type", "memory"),
 						dt.PropertyRegion("reg", 0x100000, 0xf00000),
@@ -16,6 +16,8 @@ import (
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/klauspost/compress/zstd"
+	"github.com/u-root/u-root/pkg/boot/kexec"
 	"github.com/u-root/u-root/pkg/curl"
 	"github.com/u-root/u-root/pkg/mount"
 	"github.com/u-root/uio/uio"
@@ -174,6 +176,16 @@ func setupTestFile(t *testing.T, path, content string) *os.File {
 	return nf
 }
 
+func zstdCompress(t *testing.T, content string) []byte {
+	t.Helper()
+	z, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+	return z.EncodeAll([]byte(content), nil)
+}
+
 // GenerateCatDummyInitrd return padded string from the given list of strings following the same padding format of CatInitrds.
 func GenerateCatDummyInitrd(t *testing.T, initrds ...string) string {
 	var ins []io.ReaderAt
@@ -240,6 +252,15 @@ func TestLoadLinuxImage(t *testing.T) {
 			wantKernel: setupTestFile(t, filepath.Join(testDir, "non_empty_inird_w_dtb_present_bzImage"), "testkernel"),
 			wantInitrd: setupTestFile(t, filepath.Join(testDir, "non_empty_inird_w_dtb_present_initramfs"), GenerateCatDummyInitrd(t, "testinitrd", "testdtb")),
 		},
+		{
+			name: "compressed kernel and initrd",
+			li: &LinuxImage{
+				Kernel: bytes.NewReader(zstdCompress(t, "testkernel")),
+				Initrd: bytes.NewReader(zstdCompress(t, "testinitrd")),
+			},
+			wantKernel: setupTestFile(t, filepath.Join(testDir, "compressed_kernel_and_initrd_bzImage"), "testkernel"),
+			wantInitrd: setupTestFile(t, filepath.Join(testDir, "compressed_kernel_and_initrd_initramfs"), "testinitrd"),
+		},
 		{
 			name: "oringnal kernel and initrd are files, skip copying",
 			li: &LinuxImage{
@@ -294,3 +315,130 @@ func TestLoadLinuxImage(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadVerifySignature(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		li   *LinuxImage
+		err  error
+	}{
+		{
+			name: "kexec_load",
+			li:   &LinuxImage{Kernel: strings.NewReader("testkernel"), LoadSyscall: true, VerifySignature: true},
+			err:  errSignedSyscall,
+		},
+		{
+			name: "unsigned",
+			li:   &LinuxImage{Kernel: strings.NewReader("testkernel"), VerifySignature: true},
+			err:  kexec.ErrUnsigned,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.li.Load(WithDryRun(true)); !errors.Is(err, tt.err) {
+				t.Errorf("Load() = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+// fakeVerifier rejects assets named reject and records the others.
+type fakeVerifier struct {
+	reject   string
+	verified map[string]string
+}
+
+var errRejected = errors.New("rejected")
+
+func (v *fakeVerifier) Verify(name string, asset io.ReaderAt) error {
+	if name == v.reject {
+		return errRejected
+	}
+	b, err := uio.ReadAll(asset)
+	if err != nil {
+		return err
+	}
+	v.verified[name] = string(b)
+	return nil
+}
+
+func TestLoadVerifier(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		reject string
+		want   map[string]string
+		err    error
+	}{
+		{
+			name: "verified",
+			want: map[string]string{
+				"vmlinuz": "kernel",
+				"initrd1": "initrd 1",
+				"initrd2": "initrd 2",
+			},
+		},
+		{
+			name:   "kernel rejected",
+			reject: "vmlinuz",
+			err:    errRejected,
+		},
+		{
+			name:   "initrd rejected",
+			reject: "initrd2",
+			err:    errRejected,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			li := &LinuxImage{
+				Kernel: file{name: "vmlinuz", content: []byte("kernel")},
+				Initrd: CatInitrds(
+					file{name: "initrd1", content: []byte("initrd 1")},
+					file{name: "initrd2", content: []byte("initrd 2")},
+				),
+			}
+			v := &fakeVerifier{reject: tt.reject, verified: map[string]string{}}
+			err := li.Load(WithDryRun(true), WithVerifier(v))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Load() = %v, want %v", err, tt.err)
+			}
+			if diff := cmp.Diff(tt.want, v.verified); err == nil && diff != "" {
+				t.Errorf("verified assets (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+var errMeasure = errors.New("measuring failed")
+
+// fakeMeasurer records the initramfs it measures and appends to it, as
+// measuredboot appends its event log, then fails so that nothing is
+// kexec'd.
+type fakeMeasurer struct {
+	measured []string
+}
+
+func (m *fakeMeasurer) MeasureLinux(li *LinuxImage) error {
+	b, err := uio.ReadAll(li.Initrd)
+	if err != nil {
+		return err
+	}
+	m.measured = append(m.measured, string(b))
+	li.Initrd = strings.NewReader(string(b) + " log")
+	return errMeasure
+}
+
+func TestLoadMeasurerCopy(t *testing.T) {
+	initrd := strings.NewReader("initrd")
+	li := &LinuxImage{Kernel: strings.NewReader("kernel"), Initrd: initrd}
+	m := &fakeMeasurer{}
+	for i := 0; i < 2; i++ {
+		if err := li.Load(WithMeasurer(m)); !errors.Is(err, errMeasure) {
+			t.Fatalf("Load() = %v, want %v", err, errMeasure)
+		}
+	}
+	if want := []string{"initrd", "initrd"}; !cmp.Equal(m.measured, want) {
+		t.Errorf("measured %q, want %q", m.measured, want)
+	}
+	if li.Initrd != initrd {
+		t.Errorf("Load() replaced the initramfs of the image")
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package localboot
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	guid "github.com/google/uuid"
+	"github.com/u-root/u-root/pkg/boot"
+	"github.com/u-root/u-root/pkg/boot/uki"
+	"github.com/u-root/u-root/pkg/efivarfs"
+	"github.com/u-root/u-root/pkg/mount"
+	"github.com/u-root/u-root/pkg/mount/block"
+	"github.com/u-root/u-root/pkg/uefivars"
+	uefiboot "github.com/u-root/u-root/pkg/uefivars/boot"
+	"github.com/u-root/u-root/pkg/ulog"
+	"github.com/u-root/uio/uio"
+)
+
+// Partition type GUIDs of the partitions that firmware and boot loaders boot
+// from: EFI System Partitions and Extended Boot Loader Partitions of the Boot
+// Loader Specification.
+var bootPartitionTypes = []string{
+	block.SystemPartitionGUID.String(),
+	"bc13c2ff-59e6-4262-a352-b275fd6f7172",
+}
+
+// removableMediaPaths are the files that firmware boots from a partition when
+// a boot entry has no file path, UEFI spec v2.8A section 3.5.1.1.
+var removableMediaPaths = map[string]string{
+	"386":     "EFI/BOOT/BOOTIA32.EFI",
+	"amd64":   "EFI/BOOT/BOOTX64.EFI",
+	"arm":     "EFI/BOOT/BOOTARM.EFI",
+	"arm64":   "EFI/BOOT/BOOTAA64.EFI",
+	"riscv64": "EFI/BOOT/BOOTRISCV64.EFI",
+}
+
+// efiVars returns the EFI variables. It is mocked in tests.
+var efiVars = func() (efivarfs.EFIVar, error) {
+	return efivarfs.New()
+}
+
+var bootGUID = guid.MustParse(uefiboot.BootUUID)
+
+// bootPartitions returns the EFI System and Extended Boot Loader Partitions of
+// devices.
+func bootPartitions(devices block.BlockDevices) map[string]bool {
+	parts := make(map[string]bool)
+	for _, t := range bootPartitionTypes {
+		for _, d := range devices.FilterPartType(t) {
+			parts[d.Name] = true
+		}
+	}
+	return parts
+}
+
+// parseUKIs returns the unified kernel images in EFI/Linux of a boot
+// partition mounted at mountDir, as type #2 entries of the Boot Loader
+// Specification. Later versions, by file name, come first.
+func parseUKIs(l ulog.Logger, mountDir string) []boot.OSImage {
+	files, err := filepath.Glob(filepath.Join(mountDir, "EFI", "Linux", "*.efi"))
+	if err != nil {
+		return nil
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(files)))
+
+	var imgs []boot.OSImage
+	for _, f := range files {
+		img, err := uki.Parse(uio.NewLazyFile(f))
+		if err != nil {
+			l.Printf("Skipping %s: %v", f, err)
+			continue
+		}
+		imgs = append(imgs, img)
+	}
+	return imgs
+}
+
+// bootEntries returns the active boot entries of BootOrder, in order. Entries
+// that are missing or cannot be parsed are skipped.
+func bootEntries(l ulog.Logger, vars efivarfs.EFIVar) ([]*uefiboot.BootEntryVar, error) {
+	_, data, err := vars.Get(efivarfs.VariableDescriptor{Name: "BootOrder", GUID: bootGUID})
+	if err != nil {
+		return nil, fmt.Errorf("reading BootOrder: %w", err)
+	}
+	order, err := uefiboot.ParseBootOrder(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*uefiboot.BootEntryVar
+	for _, num := range order {
+		_, data, err := vars.Get(efivarfs.VariableDescriptor{Name: fmt.Sprintf("Boot%04X", num), GUID: bootGUID})
+		if err != nil {
+			l.Printf("Skipping Boot%04X: %v", num, err)
+			continue
+		}
+		e, err := uefiboot.ParseBootVar(num, data)
+		if err != nil {
+			l.Printf("Skipping Boot%04X: %v", num, err)
+			continue
+		}
+		if e.Attributes&uefiboot.LoadOptionActive == 0 || e.Attributes&uefiboot.LoadOptionCategoryMask != uefiboot.LoadOptionCategoryBoot {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// entryFile returns the partition GUID and the file that entry boots. The
+// file is empty for entries that boot the partition's removable media path.
+func entryFile(entry *uefiboot.BootEntryVar) (partGUID, file string, ok bool) {
+	for _, p := range entry.FilePathList {
+		switch n := p.(type) {
+		case *uefiboot.DppMediaHDD:
+			// Only GPT partitions are identified by a GUID.
+			if n.SigType != 2 {
+				return "", "", false
+			}
+			partGUID = n.PartSig.ToStdEnc().String()
+		case *uefiboot.DppMediaFilePath:
+			// Multiple file path nodes are concatenated.
+			file = filepath.Join(file, n.PathNameDecoded)
+		}
+	}
+	return partGUID, strings.TrimPrefix(file, "/"), partGUID != ""
+}
+
+// entryImages returns the images that entry boots from the partition mounted
+// at mountDir: a Linux kernel with its EFI stub, a unified kernel image, or
+// else a boot loader, whose images are loaderImages, those parsed from the
+// partition's configs.
+func entryImages(entry *uefiboot.BootEntryVar, mountDir, file string, loaderImages []boot.OSImage) ([]boot.OSImage, error) {
+	if file == "" {
+		file = removableMediaPaths[runtime.GOARCH]
+	}
+	path := filepath.Join(mountDir, file)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if img, err := uki.Parse(uio.NewLazyFile(path)); err == nil {
+		img.Name = entry.Description
+		return []boot.OSImage{img}, nil
+	}
+	if !isLinux(f) {
+		return loaderImages, nil
+	}
+
+	li := &boot.LinuxImage{
+		Name:   entry.Description,
+		Kernel: uio.NewLazyFile(path),
+	}
+	// The optional data of Linux entries, as efibootmgr -u writes it,
+	// is the kernel command line in UCS-2.
+	cmdline, err := uefivars.DecodeUTF16(entry.OptionalData)
+	if err != nil {
+		return nil, fmt.Errorf("command line of Boot%04X: %w", entry.Number, err)
+	}
+	// The EFI stub, which is not run by kexec, loads initrd= files from
+	// the partition of the kernel.
+	var args []string
+	var initrds []io.ReaderAt
+	for _, arg := range strings.Fields(strings.TrimRight(cmdline, "\x00")) {
+		if initrd, ok := strings.CutPrefix(arg, "initrd="); ok {
+			initrd = strings.ReplaceAll(initrd, "\\", "/")
+			initrds = append(initrds, uio.NewLazyFile(filepath.Join(mountDir, initrd)))
+			continue
+		}
+		args = append(args, arg)
+	}
+	li.Cmdline = strings.Join(args, " ")
+	switch len(initrds) {
+	case 0:
+	case 1:
+		li.Initrd = initrds[0]
+	default:
+		li.Initrd = boot.CatInitrds(initrds...)
+	}
+	return []boot.OSImage{li}, nil
+}
+
+// isLinux returns whether r is a Linux kernel image, which is a PE image with
+// the EFI stub.
+func isLinux(r io.ReaderAt) bool {
+	for _, m := range []struct {
+		off   int64
+		magic string
+	}{
+		// The x86 boot protocol header.
+		{0x202, "HdrS"},
+		{0x38, "ARM\x64"},
+		{0x38, "RSC\x05"},
+	} {
+		b := make([]byte, len(m.magic))
+		if _, err := r.ReadAt(b, m.off); err == nil && string(b) == m.magic {
+			return true
+		}
+	}
+	return false
+}
+
+// efiImages returns the images of the boot entries of the firmware, in their
+// BootOrder, as far as they can be mapped to images on devices. parsed are
+// the images parsed from the configs on each device, by device name.
+func efiImages(l ulog.Logger, devices block.BlockDevices, mp *mount.Pool, parsed map[string][]boot.OSImage) []boot.OSImage {
+	vars, err := efiVars()
+	if err != nil {
+		l.Printf("No EFI variables: %v", err)
+		return nil
+	}
+	entries, err := bootEntries(l, vars)
+	if err != nil {
+		l.Printf("No EFI boot entries: %v", err)
+		return nil
+	}
+
+	var images []boot.OSImage
+	for _, e := range entries {
+		partGUID, file, ok := entryFile(e)
+		if !ok {
+			l.Printf("Skipping Boot%04X %q: not on a GPT partition", e.Number, e.Description)
+			continue
+		}
+		parts := devices.FilterPartID(partGUID)
+		if len(parts) == 0 {
+			l.Printf("Skipping Boot%04X %q: no partition %s", e.Number, e.Description, partGUID)
+			continue
+		}
+		m, err := mp.Mount(parts[0], mount.ReadOnly)
+		if err != nil {
+			l.Printf("Skipping Boot%04X %q: %v", e.Number, e.Description, err)
+			continue
+		}
+		imgs, err := entryImages(e, m.Path, file, parsed[parts[0].Name])
+		if err != nil {
+			l.Printf("Skipping Boot%04X %q: %v", e.Number, e.Description, err)
+			continue
+		}
+		images = append(images, imgs...)
+	}
+	return images
+}
@@ -0,0 +1,216 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package localboot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	guid "github.com/google/uuid"
+	"github.com/u-root/u-root/pkg/boot"
+	"github.com/u-root/u-root/pkg/efivarfs"
+	uefiboot "github.com/u-root/u-root/pkg/uefivars/boot"
+	"github.com/u-root/u-root/pkg/ulog/ulogtest"
+	"github.com/u-root/uio/uio"
+)
+
+type fakeVars map[string][]byte
+
+func (v fakeVars) Get(desc efivarfs.VariableDescriptor) (efivarfs.VariableAttributes, []byte, error) {
+	data, ok := v[desc.Name]
+	if !ok || desc.GUID != bootGUID {
+		return 0, nil, efivarfs.ErrVarNotExist
+	}
+	return efivarfs.AttributeNonVolatile, data, nil
+}
+
+func (v fakeVars) List() ([]efivarfs.VariableDescriptor, error) {
+	return nil, efivarfs.ErrVarsUnavailable
+}
+
+func (v fakeVars) Remove(efivarfs.VariableDescriptor) error {
+	return efivarfs.ErrVarPermission
+}
+
+func (v fakeVars) Set(efivarfs.VariableDescriptor, efivarfs.VariableAttributes, []byte) error {
+	return efivarfs.ErrVarPermission
+}
+
+func ucs2(s string) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, utf16.Encode([]rune(s)))
+	return b.Bytes()
+}
+
+// loadOption returns an EFI_LOAD_OPTION booting file from the GPT partition
+// part.
+func loadOption(attrs uint32, desc, part, file, optional string) []byte {
+	var paths bytes.Buffer
+	w := func(v any) { binary.Write(&paths, binary.LittleEndian, v) }
+	if part != "" {
+		// HD(1,GPT,part,0x800,0x100000)
+		g := guid.MustParse(part)
+		// The signature is a GUID in mixed endian.
+		sig := []byte{g[3], g[2], g[1], g[0], g[5], g[4], g[7], g[6]}
+		sig = append(sig, g[8:]...)
+		w([]byte{4, 1})
+		w(uint16(42))
+		w(uint32(1))
+		w(uint64(0x800))
+		w(uint64(0x100000))
+		w(sig)
+		w([]byte{2, 2})
+	}
+	if file != "" {
+		name := ucs2(file + "\x00")
+		w([]byte{4, 4})
+		w(uint16(4 + len(name)))
+		w(name)
+	}
+	w([]byte{0x7f, 0xff, 4, 0})
+
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, attrs)
+	binary.Write(&b, binary.LittleEndian, uint16(paths.Len()))
+	b.Write(ucs2(desc + "\x00"))
+	b.Write(paths.Bytes())
+	b.Write(ucs2(optional))
+	return b.Bytes()
+}
+
+const (
+	testESP  = "c0ffee00-1234-4567-89ab-cdef01234567"
+	linuxApp = uefiboot.LoadOptionActive
+)
+
+func TestBootEntries(t *testing.T) {
+	vars := fakeVars{
+		"BootOrder": {3, 0, 1, 0, 2, 0, 0, 0},
+		"Boot0000":  loadOption(linuxApp, "Linux", testESP, `\vmlinuz`, ""),
+		"Boot0001":  loadOption(0, "Inactive", testESP, `\vmlinuz`, ""),
+		"Boot0002":  loadOption(linuxApp|0x100, "UiApp", "", "", ""),
+		"Boot0003":  loadOption(linuxApp, "Shim", testESP, `\EFI\distro\shimx64.efi`, ""),
+	}
+	entries := func() string {
+		t.Helper()
+		entries, err := bootEntries(ulogtest.Logger{TB: t}, vars)
+		if err != nil {
+			t.Fatalf("bootEntries() = %v", err)
+		}
+		var got []string
+		for _, e := range entries {
+			part, file, ok := entryFile(e)
+			got = append(got, fmt.Sprintf("%s %s %s %t", e.Description, part, file, ok))
+		}
+		return strings.Join(got, "\n")
+	}
+
+	shim := "Shim " + testESP + " EFI/distro/shimx64.efi true"
+	linux := "Linux " + testESP + " vmlinuz true"
+	if got, want := entries(), shim+"\n"+linux; got != want {
+		t.Errorf("entries\n%s\nwant\n%s", got, want)
+	}
+
+	// A dangling or corrupt entry in BootOrder does not hide the others.
+	vars["BootOrder"] = []byte{3, 0, 4, 0, 1, 0, 0, 0}
+	vars["Boot0001"] = []byte{1, 0}
+	if got, want := entries(), shim+"\n"+linux; got != want {
+		t.Errorf("entries with a dangling entry\n%s\nwant\n%s", got, want)
+	}
+
+	delete(vars, "BootOrder")
+	if _, err := bootEntries(ulogtest.Logger{TB: t}, vars); err == nil {
+		t.Errorf("bootEntries() without BootOrder = nil, want error")
+	}
+}
+
+func TestEntryImages(t *testing.T) {
+	dir := t.TempDir()
+	kernel := make([]byte, 0x400)
+	copy(kernel, "MZ")
+	copy(kernel[0x202:], "HdrS")
+	for name, content := range map[string][]byte{
+		"vmlinuz":                  kernel,
+		"initrd.img":               []byte("initrd"),
+		"ucode.img":                []byte("ucode"),
+		"EFI/distro/shimx64.efi":   []byte("MZ shim"),
+		"EFI/BOOT/BOOTX64.EFI":     []byte("MZ fallback"),
+		"EFI/BOOT/BOOTAA64.EFI":    []byte("MZ fallback"),
+		"EFI/BOOT/BOOTRISCV64.EFI": []byte("MZ fallback"),
+	} {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	loaderImages := []boot.OSImage{&boot.LinuxImage{Name: "from grub.cfg"}}
+
+	entry := func(file, optional string) *uefiboot.BootEntryVar {
+		e, err := uefiboot.ParseBootVar(0, loadOption(linuxApp, "entry", testESP, file, optional))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+
+	t.Run("kernel", func(t *testing.T) {
+		e := entry(`\vmlinuz`, `root=/dev/sda2 initrd=\ucode.img quiet initrd=\initrd.img`)
+		_, file, _ := entryFile(e)
+		imgs, err := entryImages(e, dir, file, loaderImages)
+		if err != nil {
+			t.Fatalf("entryImages() = %v", err)
+		}
+		if len(imgs) != 1 {
+			t.Fatalf("entryImages() = %v, want 1 image", imgs)
+		}
+		li, ok := imgs[0].(*boot.LinuxImage)
+		if !ok {
+			t.Fatalf("entryImages() = %T, want *boot.LinuxImage", imgs[0])
+		}
+		if li.Name != "entry" || li.Cmdline != "root=/dev/sda2 quiet" {
+			t.Errorf("image %q with cmdline %q, want entry with root=/dev/sda2 quiet", li.Name, li.Cmdline)
+		}
+		initrd, err := uio.ReadAll(li.Initrd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "ucode" + strings.Repeat("\x00", 512-5) + "initrd"; string(initrd) != want {
+			t.Errorf("initrd %q, want %q", initrd, want)
+		}
+	})
+
+	for _, tt := range []struct {
+		name string
+		file string
+	}{
+		{name: "boot loader", file: `\EFI\distro\shimx64.efi`},
+		{name: "removable media path"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			e := entry(tt.file, "")
+			_, file, _ := entryFile(e)
+			imgs, err := entryImages(e, dir, file, loaderImages)
+			if err != nil {
+				t.Fatalf("entryImages() = %v", err)
+			}
+			if len(imgs) != 1 || imgs[0] != loaderImages[0] {
+				t.Errorf("entryImages() = %v, want the boot loader's images", imgs)
+			}
+		})
+	}
+
+	if _, err := entryImages(entry(`\missing.efi`, ""), dir, "missing.efi", loaderImages); err == nil {
+		t.Errorf("entryImages() of a missing file = nil, want error")
+	}
+}
@@ -82,8 +82,16 @@ func parseUnmounted(l ulog.Logger, device *block.BlockDev, mountPool *mount.Pool
 }
 
 // Localboot tries to boot from any local filesystem by parsing grub configuration
+//
+// The images that the firmware's boot entries boot come first, in BootOrder,
+// so that the boot menu matches what the firmware would have done. Entries
+// boot a Linux kernel or unified kernel image, or a boot loader whose images
+// are those parsed from its partition. The unified kernel images of EFI System
+// Partitions are found as well.
 func Localboot(l ulog.Logger, blockDevs block.BlockDevices, mp *mount.Pool) ([]boot.OSImage, error) {
 	var images []boot.OSImage
+	parsed := make(map[string][]boot.OSImage)
+	bootParts := bootPartitions(blockDevs)
 	for _, device := range blockDevs {
 		imgs := parseUnmounted(l, device, mp)
 		if len(imgs) > 0 {
@@ -94,10 +102,25 @@ func Localboot(l ulog.Logger, blockDevs block.BlockDevices, mp *mount.Pool) ([]b
 				continue
 			}
 			imgs = parse(l, device, blockDevs, m.Path, mp)
+			if bootParts[device.Name] {
+				imgs = append(imgs, parseUKIs(l, m.Path)...)
+			}
+			parsed[device.Name] = imgs
 			images = append(images, imgs...)
 		}
 	}
 
-	sort.Sort(byRank(images))
-	return images, nil
+	sort.Stable(byRank(images))
+
+	// Entries may boot the same boot loader, whose images are also
+	// among all images.
+	var ordered []boot.OSImage
+	seen := make(map[boot.OSImage]bool)
+	for _, img := range append(efiImages(l, blockDevs, mp, parsed), images...) {
+		if !seen[img] {
+			seen[img] = true
+			ordered = append(ordered, img)
+		}
+	}
+	return ordered, nil
 }
@@ -0,0 +1,92 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package measuredboot
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// Event types of the TCG PC Client Platform Firmware Profile Specification,
+// Section 10.4.1.
+const (
+	// EvNoAction events are not extended into PCRs, such as the Spec ID
+	// event at the start of logs.
+	EvNoAction uint32 = 0x3
+
+	// EvIPL events measure the boot loader's loading of the next stage,
+	// such as a kernel, its initramfs and its command line.
+	EvIPL uint32 = 0xd
+)
+
+// algSHA256 is the TPM_ALG_ID of SHA-256.
+const algSHA256 uint16 = 0x000b
+
+// Event is a measurement extended into a PCR.
+type Event struct {
+	PCR    uint32
+	Type   uint32
+	Digest [sha256.Size]byte
+
+	// Data describes what was measured.
+	Data []byte
+}
+
+// Log is a TCG event log in the crypto agile format of the TCG PC Client
+// Platform Firmware Profile Specification, Section 10.2, with digests of the
+// SHA-256 bank.
+type Log struct {
+	Events []Event
+}
+
+// specIDEvent returns the TCG_EfiSpecIDEvent that starts the log, Section
+// 10.4.5.1.
+func specIDEvent() []byte {
+	var b bytes.Buffer
+	b.WriteString("Spec ID Event03\x00")
+	for _, v := range []any{
+		uint32(0), // platformClass: client
+		uint8(0),  // specVersionMinor
+		uint8(2),  // specVersionMajor
+		uint8(0),  // specErrata
+		uint8(2),  // uintnSize: UINT64
+		uint32(1), // numberOfAlgorithms
+		algSHA256,
+		uint16(sha256.Size),
+		uint8(0), // vendorInfoSize
+	} {
+		binary.Write(&b, binary.LittleEndian, v)
+	}
+	return b.Bytes()
+}
+
+// MarshalBinary returns the log as the kernel exposes firmware event logs in
+// binary_bios_measurements: a TCG_PCR_EVENT with the Spec ID event, then a
+// TCG_PCR_EVENT2 per event.
+func (l *Log) MarshalBinary() ([]byte, error) {
+	var b bytes.Buffer
+	w := func(v any) {
+		binary.Write(&b, binary.LittleEndian, v)
+	}
+
+	spec := specIDEvent()
+	w(uint32(0))
+	w(EvNoAction)
+	w([20]byte{})
+	w(uint32(len(spec)))
+	b.Write(spec)
+
+	for _, e := range l.Events {
+		w(e.PCR)
+		w(e.Type)
+		w(uint32(1))
+		w(algSHA256)
+		w(e.Digest)
+		w(uint32(len(e.Data)))
+		b.Write(e.Data)
+	}
+	return b.Bytes(), nil
+}
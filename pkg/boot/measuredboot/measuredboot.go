@@ -0,0 +1,170 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package measuredboot measures the images that u-root kexecs into a TPM 2.0.
+//
+// The kernel, initramfs and command line about to be loaded are hashed with
+// SHA-256 and extended into PCRs, as GRUB does: the kernel and initramfs into
+// PCR 9, the command line into PCR 8. The kernel and initramfs are measured
+// as kexec loads them: decompressed, if they are compressed with gzip, zstd,
+// lz4 or xz. The measurements are recorded in a TCG event log, which is
+// handed to the next kernel as LogPath in an extra initramfs archive appended
+// to its initramfs. That archive is not measured.
+//
+// The log only has the events of u-root. The events of the firmware are in
+// the log it hands to the kernels it boots.
+package measuredboot
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/u-root/u-root/pkg/boot"
+	"github.com/u-root/u-root/pkg/boot/util"
+	"github.com/u-root/u-root/pkg/cpio"
+	"github.com/u-root/u-root/pkg/tss"
+	"github.com/u-root/uio/uio"
+)
+
+// Default PCRs of the measurements.
+const (
+	DefaultKernelPCR  uint32 = 9
+	DefaultInitrdPCR  uint32 = 9
+	DefaultCmdlinePCR uint32 = 8
+)
+
+// LogPath is the path of the event log in the initramfs of the next kernel.
+const LogPath = "measuredboot/event_log"
+
+var errTPMVersion = errors.New("measured boot needs a TPM 2.0")
+
+// TPM extends PCRs of the SHA-256 bank of a TPM 2.0. *tss.TPM implements it.
+type TPM interface {
+	Extend(hash []byte, pcr uint32) error
+}
+
+// Measurer measures Linux images into a TPM and records the measurements in
+// Log.
+//
+// Measurer implements boot.Measurer.
+type Measurer struct {
+	TPM TPM
+
+	KernelPCR  uint32
+	InitrdPCR  uint32
+	CmdlinePCR uint32
+
+	Log Log
+}
+
+var _ boot.Measurer = &Measurer{}
+
+// New returns a Measurer that extends the default PCRs of the system's TPM,
+// which must be a TPM 2.0.
+func New() (*Measurer, error) {
+	t, err := tss.NewTPM()
+	if err != nil {
+		return nil, err
+	}
+	if t.Version != tss.TPMVersion20 {
+		t.Close()
+		return nil, errTPMVersion
+	}
+	return &Measurer{
+		TPM:        t,
+		KernelPCR:  DefaultKernelPCR,
+		InitrdPCR:  DefaultInitrdPCR,
+		CmdlinePCR: DefaultCmdlinePCR,
+	}, nil
+}
+
+// Close closes the TPM.
+func (m *Measurer) Close() error {
+	if c, ok := m.TPM.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Measure hashes r, extends the hash into pcr and records it in the log as
+// an EV_IPL event described by desc.
+func (m *Measurer) Measure(pcr uint32, r io.Reader, desc string) error {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return fmt.Errorf("hashing %s: %w", desc, err)
+	}
+	e := Event{PCR: pcr, Type: EvIPL, Data: []byte(desc)}
+	h.Sum(e.Digest[:0])
+	if err := m.TPM.Extend(e.Digest[:], pcr); err != nil {
+		return fmt.Errorf("extending PCR %d with %s: %w", pcr, desc, err)
+	}
+	m.Log.Events = append(m.Log.Events, e)
+	return nil
+}
+
+// MeasureLinux implements boot.Measurer. It measures the kernel and the
+// initramfs of li, both decompressed as for kexec, its device tree and its
+// command line. It then replaces the kernel and initramfs of li with the
+// decompressed ones it measured, and appends the event log to the initramfs,
+// so li must be the copy of the image that is about to be kexec'd.
+func (m *Measurer) MeasureLinux(li *boot.LinuxImage) error {
+	if li.Kernel == nil {
+		return fmt.Errorf("no kernel to measure")
+	}
+	kernel := util.TryDecompressFilter(li.Kernel)
+	if err := m.Measure(m.KernelPCR, uio.Reader(kernel), "kernel "+li.Label()); err != nil {
+		return err
+	}
+	var initrd io.ReaderAt
+	if li.Initrd != nil {
+		initrd = util.TryDecompressFilter(li.Initrd)
+		if err := m.Measure(m.InitrdPCR, uio.Reader(initrd), "initrd"); err != nil {
+			return err
+		}
+	}
+	if li.DTB != nil {
+		if err := m.Measure(m.InitrdPCR, uio.Reader(li.DTB), "dtb"); err != nil {
+			return err
+		}
+	}
+	if err := m.Measure(m.CmdlinePCR, bytes.NewBufferString(li.Cmdline), "kernel_cmdline: "+li.Cmdline); err != nil {
+		return err
+	}
+
+	archive, err := m.logArchive()
+	if err != nil {
+		return err
+	}
+	li.Kernel = kernel
+	if initrd != nil {
+		li.Initrd = boot.CatInitrds(initrd, archive)
+	} else {
+		li.Initrd = archive
+	}
+	return nil
+}
+
+// logArchive returns a newc cpio archive with the event log at LogPath.
+func (m *Measurer) logArchive() (io.ReaderAt, error) {
+	b, err := m.Log.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	w := cpio.Newc.Writer(&buf)
+	if err := cpio.WriteRecords(w, []cpio.Record{
+		cpio.Directory(path.Dir(LogPath), 0o755),
+		cpio.StaticFile(LogPath, string(b), 0o444),
+	}); err != nil {
+		return nil, err
+	}
+	if err := cpio.WriteTrailer(w); err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(buf.Bytes()), nil
+}
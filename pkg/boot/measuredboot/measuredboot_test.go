@@ -0,0 +1,209 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package measuredboot
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/boot"
+	"github.com/u-root/u-root/pkg/cpio"
+	"github.com/u-root/u-root/pkg/tss"
+	"github.com/u-root/u-root/pkg/txtlog"
+	"github.com/u-root/uio/uio"
+)
+
+type extension struct {
+	pcr  uint32
+	hash []byte
+}
+
+type fakeTPM struct {
+	extended []extension
+	err      error
+}
+
+func (t *fakeTPM) Extend(hash []byte, pcr uint32) error {
+	t.extended = append(t.extended, extension{pcr: pcr, hash: hash})
+	return t.err
+}
+
+func sum(s string) []byte {
+	h := sha256.Sum256([]byte(s))
+	return h[:]
+}
+
+func TestMeasureLinux(t *testing.T) {
+	const initrd = "initrd contents"
+	tpm := &fakeTPM{}
+	m := &Measurer{
+		TPM:        tpm,
+		KernelPCR:  DefaultKernelPCR,
+		InitrdPCR:  DefaultInitrdPCR,
+		CmdlinePCR: DefaultCmdlinePCR,
+	}
+	li := &boot.LinuxImage{
+		Name:    "linux",
+		Kernel:  strings.NewReader("kernel contents"),
+		Initrd:  strings.NewReader(initrd),
+		Cmdline: "console=ttyS0",
+	}
+	if err := m.MeasureLinux(li); err != nil {
+		t.Fatalf("MeasureLinux() = %v", err)
+	}
+
+	want := []extension{
+		{pcr: 9, hash: sum("kernel contents")},
+		{pcr: 9, hash: sum(initrd)},
+		{pcr: 8, hash: sum("console=ttyS0")},
+	}
+	if !reflect.DeepEqual(tpm.extended, want) {
+		t.Errorf("extended %v, want %v", tpm.extended, want)
+	}
+	var descs []string
+	for _, e := range m.Log.Events {
+		descs = append(descs, string(e.Data))
+	}
+	if want := []string{"kernel linux", "initrd", "kernel_cmdline: console=ttyS0"}; !reflect.DeepEqual(descs, want) {
+		t.Errorf("events %q, want %q", descs, want)
+	}
+
+	// The initramfs is followed by an archive with the log, after padding
+	// to 512 bytes.
+	b, err := uio.ReadAll(li.Initrd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(b, []byte(initrd)) {
+		t.Fatalf("initrd does not start with the original one")
+	}
+	recs, err := cpio.ReadAllRecords(cpio.Newc.Reader(bytes.NewReader(b[512:])))
+	if err != nil {
+		t.Fatalf("reading log archive: %v", err)
+	}
+	wantLog, err := m.Log.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, r := range recs {
+		if r.Name != LogPath {
+			continue
+		}
+		found = true
+		got, err := uio.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, wantLog) {
+			t.Errorf("log in initrd differs from Log")
+		}
+	}
+	if !found {
+		t.Errorf("no %s in initrd", LogPath)
+	}
+}
+
+func gzipped(t *testing.T, s string) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	z := gzip.NewWriter(&b)
+	if _, err := z.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestMeasureLinuxCompressed(t *testing.T) {
+	tpm := &fakeTPM{}
+	m := &Measurer{TPM: tpm, KernelPCR: DefaultKernelPCR, InitrdPCR: DefaultInitrdPCR, CmdlinePCR: DefaultCmdlinePCR}
+	li := &boot.LinuxImage{
+		Kernel: bytes.NewReader(gzipped(t, "kernel contents")),
+		Initrd: bytes.NewReader(gzipped(t, "initrd contents")),
+	}
+	if err := m.MeasureLinux(li); err != nil {
+		t.Fatalf("MeasureLinux() = %v", err)
+	}
+	if len(tpm.extended) < 2 || !bytes.Equal(tpm.extended[0].hash, sum("kernel contents")) || !bytes.Equal(tpm.extended[1].hash, sum("initrd contents")) {
+		t.Errorf("extended %v, want the decompressed kernel and initrd", tpm.extended)
+	}
+	// The image is left with what was measured.
+	for name, r := range map[string]io.ReaderAt{"kernel": li.Kernel, "initrd": li.Initrd} {
+		b, err := uio.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := name + " contents"; !bytes.HasPrefix(b, []byte(want)) {
+			t.Errorf("%s = %q..., want it to start with %q", name, b[:min(len(b), 16)], want)
+		}
+	}
+}
+
+func TestMeasureLinuxErrors(t *testing.T) {
+	errExtend := errors.New("extend failed")
+	m := &Measurer{TPM: &fakeTPM{err: errExtend}}
+	li := &boot.LinuxImage{Kernel: strings.NewReader("kernel")}
+	if err := m.MeasureLinux(li); !errors.Is(err, errExtend) {
+		t.Errorf("MeasureLinux() = %v, want %v", err, errExtend)
+	}
+	if len(m.Log.Events) != 0 {
+		t.Errorf("events %v were logged although not extended", m.Log.Events)
+	}
+	if err := m.MeasureLinux(&boot.LinuxImage{}); err == nil {
+		t.Errorf("MeasureLinux() of an image without kernel = nil, want error")
+	}
+}
+
+func TestLogMarshalBinary(t *testing.T) {
+	l := Log{Events: []Event{
+		{PCR: 9, Type: EvIPL, Data: []byte("kernel")},
+		{PCR: 8, Type: EvIPL, Data: []byte("kernel_cmdline: quiet")},
+	}}
+	copy(l.Events[0].Digest[:], sum("kernel"))
+	copy(l.Events[1].Digest[:], sum("quiet"))
+	b, err := l.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := filepath.Join(t.TempDir(), "binary_bios_measurements")
+	if err := os.WriteFile(p, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(old string) { txtlog.DefaultTCPABinaryLog = old }(txtlog.DefaultTCPABinaryLog)
+	txtlog.DefaultTCPABinaryLog = p
+
+	parsed, err := txtlog.ParseLog(txtlog.Bios, tss.TPMVersion20)
+	if err != nil {
+		t.Fatalf("parsing log: %v", err)
+	}
+	if len(parsed.PcrList) != 3 {
+		t.Fatalf("log has %d events, want the Spec ID event and 2 more", len(parsed.PcrList))
+	}
+	for i, e := range l.Events {
+		got, ok := parsed.PcrList[i+1].(*txtlog.TcgPcrEvent2)
+		if !ok {
+			t.Fatalf("event %d is a %T, want a crypto agile event", i, parsed.PcrList[i+1])
+		}
+		if got.PcrIndex() != int(e.PCR) || got.PcrEventType() != e.Type {
+			t.Errorf("event %d in PCR %d of type %#x, want PCR %d of type %#x", i, got.PcrIndex(), got.PcrEventType(), e.PCR, e.Type)
+		}
+		digests := *got.Digests()
+		if len(digests) != 1 || digests[0].DigestAlg != txtlog.TPMAlgSha256 || !bytes.Equal(digests[0].Digest, e.Digest[:]) {
+			t.Errorf("event %d digests %v, want %x", i, digests, e.Digest)
+		}
+	}
+}
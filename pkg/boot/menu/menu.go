@@ -69,7 +69,8 @@ func parseBootNum(choice string, entries []Entry) (int, error) {
 	return num, nil
 }
 
-// SetInitialTimeout sets the initial timeout of the menu to the provided duration
+// SetInitialTimeout sets the initial timeout of the menu to the provided
+// duration. A negative duration waits indefinitely.
 func SetInitialTimeout(timeout time.Duration) {
 	initialTimeout = timeout
 }
@@ -228,7 +229,12 @@ func showMenuAndLoadFromFile(file *os.File, allowEdit bool, entries ...Entry) En
 	}
 
 	fmt.Println("")
+	return loadDefault(entries)
+}
 
+// loadDefault loads the first of entries whose IsDefault() is true and that
+// loads, and returns it.
+func loadDefault(entries []Entry) Entry {
 	// We only get one shot at actually booting, so boot the first kernel
 	// that can be loaded correctly.
 	for _, e := range entries {
@@ -264,16 +270,62 @@ func OSImages(verbose bool, imgs ...boot.OSImage) []Entry {
 	return menu
 }
 
+// Measure makes the OSImageActions of entries measure their images with m
+// when they are loaded, see boot.WithMeasurer.
+func Measure(m boot.Measurer, entries ...Entry) {
+	for _, e := range entries {
+		if oia, ok := e.(*OSImageAction); ok {
+			oia.Measurer = m
+		}
+	}
+}
+
+// Verify makes the OSImageActions of entries verify their images with v when
+// they are loaded, see boot.WithVerifier.
+func Verify(v boot.Verifier, entries ...Entry) {
+	for _, e := range entries {
+		if oia, ok := e.(*OSImageAction); ok {
+			oia.Verifier = v
+		}
+	}
+}
+
+// BeforeExec makes the OSImageActions of entries call f right before their
+// loaded image is executed, and not execute it if f fails.
+func BeforeExec(f func() error, entries ...Entry) {
+	for _, e := range entries {
+		if oia, ok := e.(*OSImageAction); ok {
+			oia.BeforeExec = f
+		}
+	}
+}
+
 // OSImageAction is a menu.Entry that boots an OSImage.
 type OSImageAction struct {
 	boot.OSImage
 	Verbose     bool
 	NoKexecLoad bool
+
+	// Measurer measures the image into a TPM when it is loaded, if set.
+	Measurer boot.Measurer
+
+	// Verifier verifies the files of the image when it is loaded, if set.
+	Verifier boot.Verifier
+
+	// BeforeExec runs right before the loaded image is executed, if set.
+	BeforeExec func() error
 }
 
 // Load implements Entry.Load by loading the OS image into memory.
 func (oia OSImageAction) Load() error {
-	if err := oia.OSImage.Load(boot.WithVerbose(oia.Verbose), boot.WithDryRun(oia.NoKexecLoad)); err != nil {
+	opts := []boot.LoadOption{boot.WithVerbose(oia.Verbose), boot.WithDryRun(oia.NoKexecLoad)}
+	if oia.Measurer != nil {
+		opts = append(opts, boot.WithMeasurer(oia.Measurer))
+	}
+	if oia.Verifier != nil {
+		opts = append(opts, boot.WithVerifier(oia.Verifier))
+	}
+	if err := oia.OSImage.Load(opts...); err != nil {
 		return fmt.Errorf("could not load image %s: %v", oia.OSImage, err)
 	}
 	return nil
@@ -281,6 +333,11 @@ func (oia OSImageAction) Load() error {
 
 // Exec executes the loaded image.
 func (oia OSImageAction) Exec() error {
+	if oia.BeforeExec != nil {
+		if err := oia.BeforeExec(); err != nil {
+			return err
+		}
+	}
 	return boot.Execute()
 }
 
@@ -72,7 +72,12 @@ func (t *xterm) Close() error {
 	return term.Restore(int(t.fileInput.Fd()), t.oldState)
 }
 
+// SetTimeout sets the timeout for reading a line. A negative duration waits
+// indefinitely.
 func (t *xterm) SetTimeout(dur time.Duration) error {
+	if dur < 0 {
+		return t.fileInput.SetDeadline(time.Time{})
+	}
 	return t.fileInput.SetDeadline(time.Now().Add(dur))
 }
 
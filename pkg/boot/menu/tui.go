@@ -0,0 +1,425 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package menu
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+	"unicode"
+
+	"golang.org/x/term"
+)
+
+// Keys that readKey decodes from escape sequences. Other keys are their rune.
+const (
+	keyUp rune = -1 - iota
+	keyDown
+	keyRight
+	keyLeft
+	keyHome
+	keyEnd
+	keyDelete
+	keyPageUp
+	keyPageDown
+	keyUnknown
+)
+
+// Control keys.
+const (
+	keyCtrlA     rune = 0x01
+	keyCtrlE     rune = 0x05
+	keyCtrlK     rune = 0x0b
+	keyCtrlU     rune = 0x15
+	keyCtrlX     rune = 0x18
+	keyEscape    rune = 0x1b
+	keyBackspace rune = 0x7f
+	keyEnter     rune = '\r'
+)
+
+// Final bytes of CSI and SS3 escape sequences, e.g. ESC [ A, and the
+// parameters of those ending in ~, e.g. ESC [ 3 ~.
+var (
+	finalKeys = map[byte]rune{
+		'A': keyUp,
+		'B': keyDown,
+		'C': keyRight,
+		'D': keyLeft,
+		'H': keyHome,
+		'F': keyEnd,
+	}
+	tildeKeys = map[string]rune{
+		"1": keyHome,
+		"3": keyDelete,
+		"4": keyEnd,
+		"5": keyPageUp,
+		"6": keyPageDown,
+		"7": keyHome,
+		"8": keyEnd,
+	}
+)
+
+// readKey reads a key from r, decoding the escape sequences of VT100 and
+// xterm cursor keys. An escape with nothing buffered after it is keyEscape.
+func readKey(r *bufio.Reader) (rune, error) {
+	c, _, err := r.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	switch c {
+	case '\n':
+		return keyEnter, nil
+	case '\b':
+		return keyBackspace, nil
+	case keyEscape:
+	default:
+		return c, nil
+	}
+	if r.Buffered() == 0 {
+		return keyEscape, nil
+	}
+	intro, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if intro != '[' && intro != 'O' {
+		return keyUnknown, nil
+	}
+	var param []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b < 0x40 || b > 0x7e {
+			param = append(param, b)
+			continue
+		}
+		k, ok := finalKeys[b]
+		if b == '~' {
+			k, ok = tildeKeys[string(param)]
+		}
+		if !ok {
+			return keyUnknown, nil
+		}
+		return k, nil
+	}
+}
+
+// cmdline returns the kernel command line of e, if it has one.
+func cmdline(e Entry) (string, bool) {
+	var cmdline string
+	var ok bool
+	e.Edit(func(s string) string {
+		cmdline, ok = s, true
+		return s
+	})
+	return cmdline, ok
+}
+
+// tui is the state of the full-screen menu of ShowTUIAndLoad.
+type tui struct {
+	entries   []Entry
+	allowEdit bool
+
+	// selected is the index of the highlighted entry, top the index of
+	// the first entry on screen.
+	selected int
+	top      int
+
+	// height is the number of rows of the terminal, or 0 if unknown.
+	height int
+
+	// deadline is when the countdown to booting the default entries
+	// ends. It is zero if there is no timeout or once a key is pressed.
+	deadline time.Time
+
+	// editing is whether the kernel command line of the selected entry
+	// is being edited in line, with the cursor at pos.
+	editing bool
+	line    []rune
+	pos     int
+
+	// status is shown below the entries, e.g. why an entry did not load.
+	status string
+}
+
+// run shows the menu on w until the user chooses an entry, which it returns,
+// or the countdown ends, when it returns nil.
+//
+// next waits up to d, or indefinitely if d is 0, for a key and returns
+// os.ErrDeadlineExceeded if none is pressed.
+func (t *tui) run(w io.Writer, next func(d time.Duration) (rune, error)) Entry {
+	if len(t.entries) == 0 {
+		return nil
+	}
+	for {
+		t.render(w)
+
+		var wait time.Duration
+		if !t.deadline.IsZero() {
+			left := time.Until(t.deadline)
+			if left <= 0 {
+				return nil
+			}
+			// Wake up every full second to update the countdown.
+			if wait = left % time.Second; wait == 0 {
+				wait = time.Second
+			}
+		}
+		k, err := next(wait)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			continue
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("BUG: Please report: Terminal read error: %v.", err)
+			}
+			return nil
+		}
+
+		// Any key stops the countdown.
+		t.deadline = time.Time{}
+		if e := t.handle(k); e != nil {
+			return e
+		}
+	}
+}
+
+// handle handles key k and returns the entry that the user chose, if any.
+func (t *tui) handle(k rune) Entry {
+	if t.editing {
+		return t.edit(k)
+	}
+
+	t.status = ""
+	switch k {
+	case keyUp, 'k':
+		t.selected--
+	case keyDown, 'j':
+		t.selected++
+	case keyHome, keyPageUp:
+		t.selected = 0
+	case keyEnd, keyPageDown:
+		t.selected = len(t.entries) - 1
+	case keyEnter:
+		return t.entries[t.selected]
+	case 'e':
+		if !t.allowEdit {
+			break
+		}
+		c, ok := cmdline(t.entries[t.selected])
+		if !ok {
+			t.status = "This entry has no kernel command line to edit."
+			break
+		}
+		t.editing = true
+		t.line = []rune(c)
+		t.pos = len(t.line)
+	default:
+		if n := int(k - '1'); n >= 0 && n < 9 && n < len(t.entries) {
+			t.selected = n
+		}
+	}
+	t.selected = max(0, min(t.selected, len(t.entries)-1))
+	return nil
+}
+
+// edit handles key k while the command line is edited. It returns the
+// selected entry if the user chose to boot it.
+func (t *tui) edit(k rune) Entry {
+	switch k {
+	case keyLeft:
+		t.pos = max(0, t.pos-1)
+	case keyRight:
+		t.pos = min(len(t.line), t.pos+1)
+	case keyHome, keyCtrlA:
+		t.pos = 0
+	case keyEnd, keyCtrlE:
+		t.pos = len(t.line)
+	case keyBackspace:
+		if t.pos > 0 {
+			t.line = slices.Delete(t.line, t.pos-1, t.pos)
+			t.pos--
+		}
+	case keyDelete:
+		if t.pos < len(t.line) {
+			t.line = slices.Delete(t.line, t.pos, t.pos+1)
+		}
+	case keyCtrlK:
+		t.line = t.line[:t.pos]
+	case keyCtrlU:
+		t.line = t.line[t.pos:]
+		t.pos = 0
+	case keyEscape:
+		t.editing = false
+	case keyEnter, keyCtrlX:
+		e := t.entries[t.selected]
+		c := string(t.line)
+		e.Edit(func(string) string { return c })
+		t.editing = false
+		if k == keyCtrlX {
+			return e
+		}
+	default:
+		if k >= 0 && unicode.IsPrint(k) {
+			t.line = slices.Insert(t.line, t.pos, k)
+			t.pos++
+		}
+	}
+	return nil
+}
+
+// render draws the menu, or the command line editor, on w.
+//
+// The terminal is in raw mode, so lines end in \r\n.
+func (t *tui) render(w io.Writer) {
+	var b strings.Builder
+	// Move the cursor home and clear the screen.
+	b.WriteString("\033[H\033[2J")
+	b.WriteString("Welcome to LinuxBoot's Menu\r\n\r\n")
+	if t.editing {
+		t.renderEditor(&b)
+	} else {
+		t.renderMenu(&b)
+	}
+	_, _ = io.WriteString(w, b.String())
+}
+
+func (t *tui) renderMenu(b *strings.Builder) {
+	// Scroll the selected entry into view, leaving room for the header
+	// and the help below the entries.
+	rows := len(t.entries)
+	if t.height > 0 {
+		rows = max(1, min(rows, t.height-8))
+	}
+	if t.selected < t.top {
+		t.top = t.selected
+	} else if t.selected >= t.top+rows {
+		t.top = t.selected - rows + 1
+	}
+
+	for i := t.top; i < t.top+rows && i < len(t.entries); i++ {
+		if i == t.selected {
+			// Reverse video.
+			fmt.Fprintf(b, "\033[7m %02d. %s \033[0m\r\n", i+1, t.entries[i].Label())
+		} else {
+			fmt.Fprintf(b, " %02d. %s \r\n", i+1, t.entries[i].Label())
+		}
+	}
+
+	b.WriteString("\r\nUse the up and down keys to select an entry, Enter to boot it")
+	if t.allowEdit {
+		b.WriteString(", 'e' to edit its kernel cmdline")
+	}
+	b.WriteString(".\r\n")
+	if t.status != "" {
+		fmt.Fprintf(b, "%s\r\n", t.status)
+	}
+	if !t.deadline.IsZero() {
+		secs := (time.Until(t.deadline) + time.Second - 1) / time.Second
+		fmt.Fprintf(b, "The default entry will be booted automatically in %ds.\r\n", secs)
+	}
+}
+
+func (t *tui) renderEditor(b *strings.Builder) {
+	fmt.Fprintf(b, "Kernel cmdline of %s:\r\n\r\n", t.entries[t.selected].Label())
+	b.WriteString(string(t.line[:t.pos]))
+	// Save the cursor position, so that it stays at pos however the
+	// line wraps.
+	b.WriteString("\0337")
+	b.WriteString(string(t.line[t.pos:]))
+	b.WriteString("\r\n\r\nEnter saves the cmdline, Ctrl-X saves it and boots the entry, Esc discards the changes.\r\n")
+	b.WriteString("\0338")
+}
+
+// ShowTUIAndLoad is ShowMenuAndLoad with a full-screen menu on the default
+// tty, whose entries are chosen with the cursor keys and whose kernel command
+// lines can be edited in line.
+//
+// Unless a key is pressed, the default entries are booted after the initial
+// timeout, see SetInitialTimeout. A negative timeout waits indefinitely.
+func ShowTUIAndLoad(allowEdit bool, entries ...Entry) Entry {
+	f, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		log.Printf("Failed to open /dev/tty: %s\n", err)
+		return nil
+	}
+	defer f.Close()
+
+	return showTUIAndLoadFromFile(f, allowEdit, entries...)
+}
+
+// showTUIAndLoadFromFile lets the user choose one of entries in a full-screen
+// menu on file and loads it. If no entry is chosen by the user, the first
+// entry whose IsDefault() is true and that loads is returned.
+//
+// The user is left to call Entry.Exec when this function returns.
+func showTUIAndLoadFromFile(file *os.File, allowEdit bool, entries ...Entry) Entry {
+	t := &tui{entries: entries, allowEdit: allowEdit}
+	if initialTimeout >= 0 {
+		t.deadline = time.Now().Add(initialTimeout)
+	}
+	// Fd puts file into blocking mode, in which reads ignore deadlines.
+	fd := int(file.Fd())
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		log.Printf("BUG: Error setting Fd %d to nonblocking: %v", fd, err)
+	}
+	if _, h, err := term.GetSize(fd); err == nil {
+		t.height = h
+	}
+
+	in := bufio.NewReader(file)
+	next := func(d time.Duration) (rune, error) {
+		if in.Buffered() == 0 {
+			var deadline time.Time
+			if d > 0 {
+				deadline = time.Now().Add(d)
+			}
+			if err := file.SetReadDeadline(deadline); err != nil {
+				return 0, err
+			}
+		}
+		return readKey(in)
+	}
+
+	for {
+		oldState, err := term.MakeRaw(fd)
+		if err != nil {
+			log.Printf("BUG: Please report: We cannot actually let you choose from menu (MakeRaw failed): %v", err)
+		}
+		entry := t.run(file, next)
+		fmt.Fprint(file, "\033[H\033[2J")
+		if oldState != nil {
+			if err := term.Restore(fd, oldState); err != nil {
+				log.Printf("Failed to restore terminal %s: %v", file.Name(), err)
+			}
+		}
+
+		if entry == nil {
+			break
+		}
+		if err := entry.Load(); err != nil {
+			log.Printf("Failed to load %s: %v", entry.Label(), err)
+			t.status = fmt.Sprintf("Failed to load %s: %v", entry.Label(), err)
+			continue
+		}
+
+		// Entry was successfully loaded. Leave it to the caller to
+		// exec, so the caller can clean up the OS before rebooting or
+		// kexecing (e.g. unmount file systems).
+		return entry
+	}
+
+	fmt.Println("")
+	return loadDefault(entries)
+}
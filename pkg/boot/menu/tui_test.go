@@ -0,0 +1,284 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package menu
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/u-root/u-root/pkg/testutil"
+)
+
+func TestReadKey(t *testing.T) {
+	in := bufio.NewReader(strings.NewReader("a\x1b[A\x1bOB\x1b[3~\x1b[1;5C\x1b[15~\r\nä\x7f\b\x1b"))
+	var got []rune
+	for {
+		k, err := readKey(in)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("readKey() = %v", err)
+		}
+		got = append(got, k)
+	}
+	want := []rune{'a', keyUp, keyDown, keyDelete, keyRight, keyUnknown, keyEnter, keyEnter, 'ä', keyBackspace, keyBackspace, keyEscape}
+	if string(got) != string(want) {
+		t.Errorf("readKey() = %q, want %q", got, want)
+	}
+}
+
+func keys(s string) []rune {
+	return []rune(s)
+}
+
+func TestTUIHandle(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		keys      []rune
+		allowEdit bool
+		// chosen is the 1-based entry that is chosen, 0 for none.
+		chosen   int
+		selected int
+		cmdlines []string
+	}{
+		{
+			name:     "enter",
+			keys:     keys("\r"),
+			chosen:   1,
+			cmdlines: []string{"before", "before", ""},
+		},
+		{
+			name:     "down_enter",
+			keys:     []rune{keyDown, keyDown, keyDown, keyEnter},
+			chosen:   3,
+			selected: 2,
+		},
+		{
+			name:     "up_at_top",
+			keys:     []rune{keyUp, 'j', 'j', 'k'},
+			selected: 1,
+		},
+		{
+			name:     "number",
+			keys:     keys("29"),
+			selected: 1,
+		},
+		{
+			name:     "end",
+			keys:     []rune{keyEnd},
+			selected: 2,
+		},
+		{
+			name:     "editing_not_allowed",
+			keys:     keys("eX\r"),
+			chosen:   1,
+			cmdlines: []string{"before", "before", ""},
+		},
+		{
+			name:      "edit_and_save",
+			keys:      append(keys("je after"), keyEnter),
+			allowEdit: true,
+			selected:  1,
+			cmdlines:  []string{"before", "before after", ""},
+		},
+		{
+			name:      "edit_and_boot",
+			keys:      append(keys("e"), keyHome, 'x', keyDelete, keyDelete, keyCtrlE, keyBackspace, keyCtrlX),
+			allowEdit: true,
+			chosen:    1,
+			cmdlines:  []string{"xfor", "before", ""},
+		},
+		{
+			name:      "edit_kill",
+			keys:      []rune{'e', keyLeft, keyLeft, keyLeft, keyCtrlK, keyLeft, keyCtrlU, keyRight, keyEnter},
+			allowEdit: true,
+			cmdlines:  []string{"f", "before", ""},
+		},
+		{
+			name:      "edit_discard",
+			keys:      append(keys("eab"), keyEscape, keyEnter),
+			allowEdit: true,
+			chosen:    1,
+			cmdlines:  []string{"before", "before", ""},
+		},
+		{
+			name:      "edit_not_editable",
+			keys:      []rune{keyEnd, 'e', 'x'},
+			allowEdit: true,
+			selected:  2,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			entries := []*testEntry{
+				{label: "1", cmdline: "before"},
+				{label: "2", cmdline: "before"},
+			}
+			tui := &tui{
+				entries:   []Entry{entries[0], entries[1], Reboot{}},
+				allowEdit: tt.allowEdit,
+			}
+
+			var chosen Entry
+			for _, k := range tt.keys {
+				if chosen = tui.handle(k); chosen != nil {
+					break
+				}
+				tui.render(io.Discard)
+			}
+
+			var want Entry
+			if tt.chosen > 0 {
+				want = tui.entries[tt.chosen-1]
+			}
+			if chosen != want {
+				t.Errorf("chosen entry %v, want %v", chosen, want)
+			}
+			if chosen == nil && tui.selected != tt.selected {
+				t.Errorf("selected entry %d, want %d", tui.selected, tt.selected)
+			}
+			for i, c := range tt.cmdlines {
+				if i < len(entries) && entries[i].cmdline != c {
+					t.Errorf("entry %d cmdline %q, want %q", i+1, entries[i].cmdline, c)
+				}
+			}
+		})
+	}
+}
+
+func TestTUIRun(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		timeout time.Duration
+		keys    []rune
+		want    int
+	}{
+		{
+			name:    "timeout",
+			timeout: 10 * time.Millisecond,
+		},
+		{
+			name:    "key_stops_countdown",
+			timeout: 10 * time.Millisecond,
+			keys:    []rune{keyDown, keyEnter},
+			want:    2,
+		},
+		{
+			name:    "no_timeout",
+			timeout: -1,
+			keys:    []rune{keyEnter},
+			want:    1,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tui := &tui{entries: []Entry{&testEntry{label: "1"}, &testEntry{label: "2"}}}
+			if tt.timeout >= 0 {
+				tui.deadline = time.Now().Add(tt.timeout)
+			}
+			var screen strings.Builder
+			got := tui.run(&screen, func(d time.Duration) (rune, error) {
+				if d == 0 && !tui.deadline.IsZero() {
+					t.Errorf("next waits indefinitely during the countdown")
+				}
+				if len(tt.keys) == 0 {
+					if d == 0 {
+						return 0, io.EOF
+					}
+					time.Sleep(d)
+					return 0, os.ErrDeadlineExceeded
+				}
+				k := tt.keys[0]
+				tt.keys = tt.keys[1:]
+				return k, nil
+			})
+
+			var want Entry
+			if tt.want > 0 {
+				want = tui.entries[tt.want-1]
+			}
+			if got != want {
+				t.Errorf("run() = %v, want %v", got, want)
+			}
+			if tt.timeout > 0 && !strings.Contains(screen.String(), "booted automatically in 1s") {
+				t.Errorf("run() did not show the countdown:\n%s", screen.String())
+			}
+		})
+	}
+}
+
+func TestShowTUIAndLoadFromFile(t *testing.T) {
+	// This test takes too long to run for the VM test and doesn't use
+	// anything root-specific.
+	testutil.SkipIfInVMTest(t)
+
+	for _, tt := range []struct {
+		name      string
+		entries   []*testEntry
+		userEntry string
+		wantLabel string
+	}{
+		{
+			name: "timeout_gets_first_default",
+			entries: []*testEntry{
+				{label: "1", isDefault: false},
+				{label: "2", isDefault: true},
+			},
+			wantLabel: "2",
+		},
+		{
+			name: "choose_second",
+			entries: []*testEntry{
+				{label: "1", isDefault: true},
+				{label: "2", isDefault: true},
+			},
+			userEntry: "\x1b[B\r",
+			wantLabel: "2",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			master, slave, err := pty.Open()
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			defer master.Close()
+			defer slave.Close()
+			// Drain the screen, so that writes do not block.
+			go func() { _, _ = io.Copy(io.Discard, master) }()
+
+			var entries []Entry
+			for _, e := range tt.entries {
+				entries = append(entries, e)
+			}
+
+			timer := time.NewTimer(initialTimeout * 4)
+			entry := make(chan Entry)
+			go func() {
+				entry <- showTUIAndLoadFromFile(slave, true, entries...)
+			}()
+
+			if tt.userEntry != "" {
+				// Wait until the menu reads, as ttys are asynchronous.
+				time.Sleep(inputDelay)
+				if _, err := master.Write([]byte(tt.userEntry)); err != nil {
+					t.Fatalf("failed to write keys: %v", err)
+				}
+			}
+
+			select {
+			case <-timer.C:
+				t.Errorf("Test %s timed out after %v", tt.name, initialTimeout*4)
+			case got := <-entry:
+				if got == nil || got.Label() != tt.wantLabel {
+					t.Errorf("showTUIAndLoadFromFile() = %v, want entry %s", got, tt.wantLabel)
+				}
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@
 package boot
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"strings"
@@ -28,6 +29,8 @@ type MultibootImage struct {
 
 var _ OSImage = &MultibootImage{}
 
+var errMeasureMultiboot = errors.New("measuring multiboot images is not supported")
+
 // Label returns either Name or a short description.
 func (mi *MultibootImage) Label() string {
 	if len(mi.Name) > 0 {
@@ -52,6 +55,17 @@ func (mi *MultibootImage) Load(opts ...LoadOption) error {
 	for _, opt := range opts {
 		opt(loadOpts)
 	}
+	if loadOpts.measurer != nil && loadOpts.callKexecLoad {
+		return errMeasureMultiboot
+	}
+	if err := verifyAsset(loadOpts.verifier, mi.Kernel); err != nil {
+		return err
+	}
+	for _, mod := range mi.Modules {
+		if err := verifyAsset(loadOpts.verifier, mod.Module); err != nil {
+			return err
+		}
+	}
 
 	entryPoint, segments, err := multiboot.PrepareLoad(loadOpts.verbose, mi.Kernel, mi.Cmdline, mi.Modules, mi.IBFT)
 	if err != nil {
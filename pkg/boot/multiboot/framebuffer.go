@@ -0,0 +1,128 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package multiboot
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/u-root/u-root/pkg/boot/bzimage"
+	"github.com/u-root/uio/uio"
+)
+
+// bootParams are the x86 boot parameters of the running kernel, whose
+// screen_info describes the console it was booted with.
+var bootParams = "/sys/kernel/boot_params/data"
+
+// Linux video types of screen_info.orig_video_isVGA.
+const (
+	videoTypeVGAText = 0x01
+	videoTypeVLFB    = 0x23
+	videoTypeEFI     = 0x70
+
+	// videoCapability64BitBase is set in screen_info.capabilities when
+	// ext_lfb_base holds the upper 32 bits of the framebuffer address.
+	videoCapability64BitBase = 1 << 1
+)
+
+// Multiboot2 framebuffer types.
+const (
+	framebufferTypeRGB     = 1
+	framebufferTypeEGAText = 2
+)
+
+// framebuffer describes the framebuffer the loaded kernel is handed over.
+type framebuffer struct {
+	Addr   uint64
+	Pitch  uint32
+	Width  uint32
+	Height uint32
+	BPP    uint8
+	Type   uint8
+
+	// The position and size of the color fields of RGB framebuffers.
+	RedPos, RedSize     uint8
+	GreenPos, GreenSize uint8
+	BluePos, BlueSize   uint8
+}
+
+// marshal writes out the framebuffer info tag.
+func (fb *framebuffer) marshal() []byte {
+	buf := uio.NewLittleEndianBuffer(nil)
+	buf.Write64(fb.Addr)
+	buf.Write32(fb.Pitch)
+	buf.Write32(fb.Width)
+	buf.Write32(fb.Height)
+	buf.Write8(fb.BPP)
+	buf.Write8(fb.Type)
+	// Reserved.
+	buf.Write16(0)
+	if fb.Type == framebufferTypeRGB {
+		buf.Write8(fb.RedPos)
+		buf.Write8(fb.RedSize)
+		buf.Write8(fb.GreenPos)
+		buf.Write8(fb.GreenSize)
+		buf.Write8(fb.BluePos)
+		buf.Write8(fb.BlueSize)
+	}
+	return buf.Data()
+}
+
+// parseScreenInfo returns the framebuffer of the screen_info of x86 boot
+// parameters, or nil if the console is not one multiboot2 describes.
+func parseScreenInfo(bp []byte) (*framebuffer, error) {
+	var lp bzimage.LinuxParams
+	if err := lp.UnmarshalBinary(bp); err != nil {
+		return nil, fmt.Errorf("parsing boot params: %w", err)
+	}
+	switch lp.OrigVideoIsVGA {
+	case videoTypeVGAText:
+		return &framebuffer{
+			Addr:   0xB8000,
+			Pitch:  2 * uint32(lp.OrigVideoCols),
+			Width:  uint32(lp.OrigVideoCols),
+			Height: uint32(lp.OrigVideoLines),
+			BPP:    16,
+			Type:   framebufferTypeEGAText,
+		}, nil
+
+	case videoTypeVLFB, videoTypeEFI:
+		// capabilities and ext_lfb_base are not part of LinuxParams.
+		addr := uint64(lp.Lfbbase)
+		if binary.LittleEndian.Uint32(bp[0x36:])&videoCapability64BitBase != 0 {
+			addr |= uint64(binary.LittleEndian.Uint32(bp[0x3a:])) << 32
+		}
+		return &framebuffer{
+			Addr:      addr,
+			Pitch:     uint32(lp.Lfblinelength),
+			Width:     uint32(lp.Lfbwidth),
+			Height:    uint32(lp.Lfbheight),
+			BPP:       uint8(lp.Lfbdepth),
+			Type:      framebufferTypeRGB,
+			RedPos:    lp.Redpos,
+			RedSize:   lp.Redsize,
+			GreenPos:  lp.Greenpos,
+			GreenSize: lp.Greensize,
+			BluePos:   lp.Bluepos,
+			BlueSize:  lp.Bluesize,
+		}, nil
+	}
+	return nil, nil
+}
+
+// currentFramebuffer returns the framebuffer Linux was booted with, or nil if
+// there is none or it is unknown, as on other architectures than x86.
+func currentFramebuffer() (*framebuffer, error) {
+	bp, err := os.ReadFile(bootParams)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseScreenInfo(bp)
+}
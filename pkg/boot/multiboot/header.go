@@ -67,6 +67,7 @@ type header struct {
 }
 
 type imageType interface {
+	loadKernel(m *multiboot) (uintptr, error)
 	addInfo(m *multiboot) (uintptr, error)
 	name() string
 	bootMagic() uintptr
@@ -0,0 +1,263 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package multiboot
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+)
+
+var (
+	// ErrArchNotSupported indicates that a valid multiboot2 header was
+	// found for an architecture other than i386.
+	ErrArchNotSupported = errors.New("multiboot2 architecture not supported")
+
+	// ErrTagNotSupported indicates that a valid multiboot2 header
+	// contained a tag that is not optional and that this package does not
+	// support.
+	ErrTagNotSupported = errors.New("multiboot2 header tag not supported")
+
+	errBadTag = errors.New("malformed multiboot2 header tag")
+)
+
+const (
+	// header2Magic is the magic value found in a multiboot2 kernel header.
+	header2Magic = 0xE85250D6
+
+	// boot2Magic is the magic expected by the loaded OS in EAX at boot
+	// handover.
+	boot2Magic = 0x36D76289
+
+	// header2Search is the part of the image the multiboot2 header must be
+	// contained in.
+	header2Search = 32768
+
+	// arch2I386 is the 32-bit protected mode of i386, the only
+	// architecture defined for x86.
+	arch2I386 = 0
+)
+
+// Multiboot2 header tag types, as defined in
+// https://www.gnu.org/software/grub/manual/multiboot2/multiboot.html#Header-tags.
+const (
+	headerTagEnd               = 0
+	headerTagInfoRequest       = 1
+	headerTagAddress           = 2
+	headerTagEntryAddress      = 3
+	headerTagConsoleFlags      = 4
+	headerTagFramebuffer       = 5
+	headerTagModuleAlign       = 6
+	headerTagEFIBootServices   = 7
+	headerTagEntryAddressEFI32 = 8
+	headerTagEntryAddressEFI64 = 9
+	headerTagRelocatable       = 10
+)
+
+const (
+	// headerTagFlagOptional marks tags the OS can boot without the
+	// bootloader understanding.
+	headerTagFlagOptional = 1
+
+	sizeofHeader2Mandatory = 16
+	sizeofHeaderTag        = 8
+)
+
+// header2Mandatory is the fixed part of the multiboot2 header, which is
+// followed by the tags.
+type header2Mandatory struct {
+	Magic        uint32
+	Architecture uint32
+	HeaderLength uint32
+	Checksum     uint32
+}
+
+// headerTag is the header of every multiboot2 header tag.
+type headerTag struct {
+	Type  uint16
+	Flags uint16
+	Size  uint32
+}
+
+func (t headerTag) optional() bool {
+	return t.Flags&headerTagFlagOptional != 0
+}
+
+// addressTag is the a.out kludge of multiboot2: where the image is to be
+// loaded when it is not an ELF file, or its program headers are not to be
+// used.
+type addressTag struct {
+	HeaderAddr  uint32
+	LoadAddr    uint32
+	LoadEndAddr uint32
+	BSSEndAddr  uint32
+}
+
+// framebufferTag is the preferred graphics mode of the loaded OS.
+type framebufferTag struct {
+	Width  uint32
+	Height uint32
+	Depth  uint32
+}
+
+// header2 represents a Multiboot2 header loaded from the file.
+type header2 struct {
+	header2Mandatory
+
+	// offset is the offset of the header in the file.
+	offset uint32
+
+	// requests are the information tag types the OS cannot boot without,
+	// and optionalRequests those it can.
+	requests         []uint32
+	optionalRequests []uint32
+
+	address *addressTag
+	entry   *uint32
+
+	// framebuffer is the framebuffer tag, and framebufferRequired
+	// whether it is not optional.
+	framebuffer         *framebufferTag
+	framebufferRequired bool
+}
+
+func (h *header2) name() string {
+	return "multiboot2"
+}
+
+func (h *header2) bootMagic() uintptr {
+	return boot2Magic
+}
+
+// parseHeader2 parses the multiboot2 header as defined in
+// https://www.gnu.org/software/grub/manual/multiboot2/multiboot.html#OS-image-format
+func parseHeader2(r io.Reader) (*header2, error) {
+	// The multiboot2 header must be contained completely within the
+	// first 32768 bytes of the OS image.
+	buf := make([]byte, header2Search)
+	n, err := io.ReadAtLeast(r, buf, sizeofHeader2Mandatory)
+	if err != nil {
+		return nil, err
+	}
+	buf = buf[:n]
+
+	// The multiboot2 header must be 64-bit aligned.
+	for off := 0; off+sizeofHeader2Mandatory <= len(buf); off += 8 {
+		h := header2Mandatory{
+			Magic:        binary.LittleEndian.Uint32(buf[off:]),
+			Architecture: binary.LittleEndian.Uint32(buf[off+4:]),
+			HeaderLength: binary.LittleEndian.Uint32(buf[off+8:]),
+			Checksum:     binary.LittleEndian.Uint32(buf[off+12:]),
+		}
+		if h.Magic != header2Magic || h.Magic+h.Architecture+h.HeaderLength+h.Checksum != 0 {
+			continue
+		}
+		if h.HeaderLength < sizeofHeader2Mandatory || uint64(off)+uint64(h.HeaderLength) > uint64(len(buf)) {
+			continue
+		}
+		if h.Architecture != arch2I386 {
+			return nil, fmt.Errorf("%w: %d", ErrArchNotSupported, h.Architecture)
+		}
+		hdr := &header2{header2Mandatory: h, offset: uint32(off)}
+		if err := hdr.parseTags(buf[off+sizeofHeader2Mandatory : off+int(h.HeaderLength)]); err != nil {
+			return nil, err
+		}
+		return hdr, nil
+	}
+	return nil, ErrHeaderNotFound
+}
+
+// parseTags parses the header tags that follow the mandatory part of the
+// header, up to the end tag.
+func (h *header2) parseTags(b []byte) error {
+	for len(b) >= sizeofHeaderTag {
+		t := headerTag{
+			Type:  binary.LittleEndian.Uint16(b),
+			Flags: binary.LittleEndian.Uint16(b[2:]),
+			Size:  binary.LittleEndian.Uint32(b[4:]),
+		}
+		if t.Size < sizeofHeaderTag || uint64(t.Size) > uint64(len(b)) {
+			return fmt.Errorf("%w: type %d has size %d", errBadTag, t.Type, t.Size)
+		}
+		data := b[sizeofHeaderTag:t.Size]
+
+		switch t.Type {
+		case headerTagEnd:
+			return nil
+
+		case headerTagInfoRequest:
+			for ; len(data) >= 4; data = data[4:] {
+				typ := binary.LittleEndian.Uint32(data)
+				if t.optional() {
+					h.optionalRequests = append(h.optionalRequests, typ)
+				} else {
+					h.requests = append(h.requests, typ)
+				}
+			}
+
+		case headerTagAddress:
+			if len(data) < 16 {
+				return fmt.Errorf("%w: address tag has size %d", errBadTag, t.Size)
+			}
+			h.address = &addressTag{
+				HeaderAddr:  binary.LittleEndian.Uint32(data),
+				LoadAddr:    binary.LittleEndian.Uint32(data[4:]),
+				LoadEndAddr: binary.LittleEndian.Uint32(data[8:]),
+				BSSEndAddr:  binary.LittleEndian.Uint32(data[12:]),
+			}
+
+		case headerTagEntryAddress:
+			if len(data) < 4 {
+				return fmt.Errorf("%w: entry address tag has size %d", errBadTag, t.Size)
+			}
+			entry := binary.LittleEndian.Uint32(data)
+			h.entry = &entry
+
+		case headerTagFramebuffer:
+			if len(data) < 12 {
+				return fmt.Errorf("%w: framebuffer tag has size %d", errBadTag, t.Size)
+			}
+			h.framebuffer = &framebufferTag{
+				Width:  binary.LittleEndian.Uint32(data),
+				Height: binary.LittleEndian.Uint32(data[4:]),
+				Depth:  binary.LittleEndian.Uint32(data[8:]),
+			}
+			h.framebufferRequired = !t.optional()
+
+		case headerTagModuleAlign:
+			// Modules are always loaded at page boundaries.
+
+		case headerTagConsoleFlags, headerTagRelocatable:
+			// The console is left as it is, and the image is
+			// loaded at the addresses it was linked at.
+			log.Printf("Ignoring multiboot2 header tag %d", t.Type)
+
+		case headerTagEntryAddressEFI32, headerTagEntryAddressEFI64:
+			// These entry points are only used along with the EFI
+			// boot services tag, which cannot be honored.
+
+		case headerTagEFIBootServices:
+			// EFI boot services are gone by the time Linux kexecs.
+			if !t.optional() {
+				return fmt.Errorf("%w: EFI boot services", ErrTagNotSupported)
+			}
+
+		default:
+			if !t.optional() {
+				return fmt.Errorf("%w: type %d", ErrTagNotSupported, t.Type)
+			}
+		}
+
+		// Tags are padded to be 64-bit aligned.
+		next := (t.Size + 7) &^ 7
+		if uint64(next) >= uint64(len(b)) {
+			break
+		}
+		b = b[next:]
+	}
+	return fmt.Errorf("%w: no end tag", errBadTag)
+}
@@ -0,0 +1,357 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package multiboot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/acpi"
+	"github.com/u-root/u-root/pkg/boot/kexec"
+)
+
+// tag2 marshals a multiboot2 header tag, padded to 8 bytes.
+func tag2(typ, flags uint16, data ...uint32) []byte {
+	b := binary.LittleEndian.AppendUint16(nil, typ)
+	b = binary.LittleEndian.AppendUint16(b, flags)
+	b = binary.LittleEndian.AppendUint32(b, uint32(8+4*len(data)))
+	for _, d := range data {
+		b = binary.LittleEndian.AppendUint32(b, d)
+	}
+	return append(b, make([]byte, (8-len(b)%8)%8)...)
+}
+
+// header2Bytes marshals a multiboot2 header of arch with tags, which end
+// with an end tag.
+func header2Bytes(arch uint32, tags ...[]byte) []byte {
+	tb := bytes.Join(append(tags, tag2(headerTagEnd, 0)), nil)
+	length := uint32(sizeofHeader2Mandatory + len(tb))
+	b := binary.LittleEndian.AppendUint32(nil, header2Magic)
+	b = binary.LittleEndian.AppendUint32(b, arch)
+	b = binary.LittleEndian.AppendUint32(b, length)
+	b = binary.LittleEndian.AppendUint32(b, -(header2Magic + arch + length))
+	return append(b, tb...)
+}
+
+// image2 returns an image of size bytes with hdr at offset.
+func image2(hdr []byte, offset, size int) []byte {
+	b := bytes.Repeat([]byte{0xDE, 0xAD, 0xBE, 0xEF}, size/4)
+	copy(b[offset:], hdr)
+	return b
+}
+
+func TestParseHeader2(t *testing.T) {
+	entry := uint32(0x100040)
+	for _, tt := range []struct {
+		name  string
+		image []byte
+		want  *header2
+		err   error
+	}{
+		{
+			name:  "no tags",
+			image: image2(header2Bytes(arch2I386), 0, 8192),
+			want:  &header2{},
+		},
+		{
+			name: "tags",
+			image: image2(header2Bytes(arch2I386,
+				tag2(headerTagInfoRequest, 0, infoTagCmdline, infoTagMmap),
+				tag2(headerTagInfoRequest, headerTagFlagOptional, infoTagFramebuffer),
+				tag2(headerTagEntryAddress, 0, entry),
+				tag2(headerTagFramebuffer, headerTagFlagOptional, 1024, 768, 32),
+				tag2(headerTagModuleAlign, 0),
+				tag2(headerTagRelocatable, headerTagFlagOptional, 0x100000, 0x1000000, 0x1000, 0),
+				tag2(headerTagEFIBootServices, headerTagFlagOptional),
+				tag2(42, headerTagFlagOptional),
+			), 4096, 8192),
+			want: &header2{
+				offset:           4096,
+				requests:         []uint32{infoTagCmdline, infoTagMmap},
+				optionalRequests: []uint32{infoTagFramebuffer},
+				entry:            &entry,
+				framebuffer:      &framebufferTag{Width: 1024, Height: 768, Depth: 32},
+			},
+		},
+		{
+			name:  "address tag",
+			image: image2(header2Bytes(arch2I386, tag2(headerTagAddress, 0, 0x100000, 0x100000, 0x102000, 0x104000)), 0, 8192),
+			want:  &header2{address: &addressTag{HeaderAddr: 0x100000, LoadAddr: 0x100000, LoadEndAddr: 0x102000, BSSEndAddr: 0x104000}},
+		},
+		{
+			name:  "end of the search area",
+			image: image2(header2Bytes(arch2I386), header2Search-24, 65536),
+			want:  &header2{offset: header2Search - 24},
+		},
+		{
+			name:  "beyond the search area",
+			image: image2(header2Bytes(arch2I386), header2Search, 65536),
+			err:   ErrHeaderNotFound,
+		},
+		{
+			name:  "unaligned",
+			image: image2(header2Bytes(arch2I386), 4, 8192),
+			err:   ErrHeaderNotFound,
+		},
+		{
+			name:  "bad checksum",
+			image: image2(append(header2Bytes(arch2I386)[:12], 0, 0, 0, 0), 0, 8192),
+			err:   ErrHeaderNotFound,
+		},
+		{
+			name:  "mips",
+			image: image2(header2Bytes(4), 0, 8192),
+			err:   ErrArchNotSupported,
+		},
+		{
+			name:  "required EFI boot services",
+			image: image2(header2Bytes(arch2I386, tag2(headerTagEFIBootServices, 0)), 0, 8192),
+			err:   ErrTagNotSupported,
+		},
+		{
+			name:  "required unknown tag",
+			image: image2(header2Bytes(arch2I386, tag2(42, 0)), 0, 8192),
+			err:   ErrTagNotSupported,
+		},
+		{
+			name:  "short entry tag",
+			image: image2(header2Bytes(arch2I386, tag2(headerTagEntryAddress, 0)), 0, 8192),
+			err:   errBadTag,
+		},
+		{
+			name:  "no end tag",
+			image: image2(header2Bytes(arch2I386)[:sizeofHeader2Mandatory], 0, 8192),
+			err:   errBadTag,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseHeader2(bytes.NewReader(tt.image))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("parseHeader2() = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			got.header2Mandatory = header2Mandatory{}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseHeader2() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbeMultiboot2(t *testing.T) {
+	if err := Probe(bytes.NewReader(image2(header2Bytes(arch2I386), 8, 8192))); err != nil {
+		t.Errorf("Probe(multiboot2 image) = %v, want nil", err)
+	}
+	if err := Probe(bytes.NewReader(image2(nil, 0, 8192))); !errors.Is(err, ErrHeaderNotFound) {
+		t.Errorf("Probe(image without header) = %v, want %v", err, ErrHeaderNotFound)
+	}
+}
+
+func TestLoadKernelAddress(t *testing.T) {
+	entry := uint32(0x100100)
+	// The text of the kernel starts 0x40 bytes before its header, which
+	// is 0x80 bytes into the image.
+	image := image2(header2Bytes(arch2I386,
+		tag2(headerTagAddress, 0, 0x100040, 0x100000, 0x101000, 0x102000),
+		tag2(headerTagEntryAddress, 0, entry),
+	), 0x80, 0x2000)
+	h, err := parseHeader2(bytes.NewReader(image))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &multiboot{kernel: bytes.NewReader(image)}
+	got, err := h.loadKernel(m)
+	if err != nil {
+		t.Fatalf("loadKernel() = %v", err)
+	}
+	if got != uintptr(entry) {
+		t.Errorf("loadKernel() = %#x, want %#x", got, entry)
+	}
+	want := kexec.Segments{kexec.NewSegment(image[0x40:0x1040], kexec.Range{Start: 0x100000, Size: 0x2000})}
+	if !kexec.SegmentsEqual(m.mem.Segments, want) {
+		t.Errorf("segments = %v, want %v", m.mem.Segments, want)
+	}
+
+	for _, tt := range []struct {
+		name string
+		tags [][]byte
+	}{
+		{
+			name: "no entry",
+			tags: [][]byte{tag2(headerTagAddress, 0, 0x100040, 0x100000, 0, 0)},
+		},
+		{
+			name: "load address before the image",
+			tags: [][]byte{tag2(headerTagAddress, 0, 0x100040, 0xF0000, 0, 0), tag2(headerTagEntryAddress, 0, entry)},
+		},
+		{
+			name: "load end beyond the image",
+			tags: [][]byte{tag2(headerTagAddress, 0, 0x100040, 0x100000, 0x200000, 0), tag2(headerTagEntryAddress, 0, entry)},
+		},
+		{
+			name: "bss end before load end",
+			tags: [][]byte{tag2(headerTagAddress, 0, 0x100040, 0x100000, 0x101000, 0x100800), tag2(headerTagEntryAddress, 0, entry)},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			image := image2(header2Bytes(arch2I386, tt.tags...), 0x80, 0x2000)
+			h, err := parseHeader2(bytes.NewReader(image))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := h.loadKernel(&multiboot{kernel: bytes.NewReader(image)}); !errors.Is(err, errBadTag) {
+				t.Errorf("loadKernel() = %v, want %v", err, errBadTag)
+			}
+		})
+	}
+}
+
+// parseInfo2 returns the tags of multiboot2 boot information.
+func parseInfo2(t *testing.T, b []byte) map[uint32][][]byte {
+	t.Helper()
+	if got := binary.LittleEndian.Uint32(b); int(got) != len(b) {
+		t.Fatalf("total_size = %d, want %d", got, len(b))
+	}
+	tags := map[uint32][][]byte{}
+	for b = b[8:]; ; {
+		typ, size := binary.LittleEndian.Uint32(b), binary.LittleEndian.Uint32(b[4:])
+		if typ == infoTagEnd {
+			if size != 8 || len(b) != 8 {
+				t.Fatalf("end tag of size %d with %d bytes left", size, len(b))
+			}
+			return tags
+		}
+		tags[typ] = append(tags[typ], b[8:size])
+		b = b[(size+7)&^7:]
+	}
+}
+
+func TestAddInfo2(t *testing.T) {
+	bp := make([]byte, 4096)
+	bp[0x0f] = videoTypeEFI
+	binary.LittleEndian.PutUint16(bp[0x12:], 1024)
+	binary.LittleEndian.PutUint16(bp[0x14:], 768)
+	binary.LittleEndian.PutUint16(bp[0x16:], 32)
+	binary.LittleEndian.PutUint32(bp[0x18:], 0x80000000)
+	binary.LittleEndian.PutUint16(bp[0x24:], 4096)
+	copy(bp[0x26:], []byte{8, 16, 8, 8, 8, 0})
+	binary.LittleEndian.PutUint32(bp[0x36:], videoCapability64BitBase)
+	binary.LittleEndian.PutUint32(bp[0x3a:], 0x40)
+	rsdp := acpi.NewRSDP(0x7FFE0000, 0x100)
+	defer func(bp string, rsdp func() ([]byte, error)) {
+		bootParams, getRSDP = bp, rsdp
+	}(bootParams, getRSDP)
+	bootParams = filepath.Join(t.TempDir(), "data")
+	if err := os.WriteFile(bootParams, bp, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	getRSDP = func() ([]byte, error) {
+		return rsdp, nil
+	}
+
+	m := &multiboot{
+		cmdLine:    "console=ttyS0",
+		bootloader: bootloader,
+		modules: []Module{
+			{Module: bytes.NewReader([]byte("module one")), Cmdline: "one arg"},
+		},
+		mem: kexec.Memory{
+			Phys: kexec.MemoryMap{
+				{Range: kexec.Range{Start: 0, Size: 0x9F000}, Type: kexec.RangeRAM},
+				{Range: kexec.Range{Start: 0x100000, Size: 0x7FF00000}, Type: kexec.RangeRAM},
+				{Range: kexec.Range{Start: 0xFEC00000, Size: 0x1000}, Type: kexec.RangeReserved},
+			},
+		},
+	}
+	h := &header2{requests: []uint32{infoTagCmdline, infoTagModule, infoTagMmap, infoTagFramebuffer, infoTagACPINew}}
+	addr, err := h.addInfo(m)
+	if err != nil {
+		t.Fatalf("addInfo() = %v", err)
+	}
+	if addr%8 != 0 {
+		t.Errorf("info at %#x, want it 8-byte aligned", addr)
+	}
+	var info []byte
+	for _, s := range m.mem.Segments {
+		if s.Phys.Start == addr {
+			info = s.Buf
+		}
+	}
+	tags := parseInfo2(t, info)
+
+	mod := m.loadedModules[0]
+	want := map[uint32][][]byte{
+		infoTagCmdline:        {[]byte("console=ttyS0\x00")},
+		infoTagBootLoaderName: {[]byte(bootloader + "\x00")},
+		infoTagModule:         {append(binary.LittleEndian.AppendUint64(nil, uint64(mod.End)<<32|uint64(mod.Start)), "one arg\x00"...)},
+		infoTagBasicMeminfo:   {basicMeminfoTag(0x9F000, 0x7FF00000)},
+		infoTagMmap: {mmapTag(memoryMaps{
+			{Size: 20, BaseAddr: 0, Length: 0x9F000, Type: 1},
+			{Size: 20, BaseAddr: 0x100000, Length: 0x7FF00000, Type: 1},
+			{Size: 20, BaseAddr: 0xFEC00000, Length: 0x1000, Type: 2},
+		})},
+		infoTagFramebuffer: {(&framebuffer{
+			Addr:      0x40_80000000,
+			Pitch:     4096,
+			Width:     1024,
+			Height:    768,
+			BPP:       32,
+			Type:      framebufferTypeRGB,
+			RedSize:   8,
+			RedPos:    16,
+			GreenSize: 8,
+			GreenPos:  8,
+			BlueSize:  8,
+			BluePos:   0,
+		}).marshal()},
+		infoTagACPIOld: {rsdp[:20]},
+		infoTagACPINew: {rsdp},
+	}
+	if !reflect.DeepEqual(tags, want) {
+		t.Errorf("info tags = %v, want %v", tags, want)
+	}
+	if b := m.mem.Segments.GetPhys(kexec.Range{Start: uintptr(mod.Start), Size: uint(mod.End - mod.Start)}); !bytes.Equal(b, []byte("module one")) {
+		t.Errorf("module loaded as %q, want %q", b, "module one")
+	}
+
+	h = &header2{framebufferRequired: true}
+	bootParams = filepath.Join(t.TempDir(), "missing")
+	if _, err := h.addInfo(m); !errors.Is(err, ErrInfoNotAvailable) {
+		t.Errorf("addInfo() without a required framebuffer = %v, want %v", err, ErrInfoNotAvailable)
+	}
+	h = &header2{requests: []uint32{infoTagACPIOld}}
+	getRSDP = func() ([]byte, error) {
+		return nil, os.ErrNotExist
+	}
+	if _, err := h.addInfo(m); !errors.Is(err, ErrInfoNotAvailable) {
+		t.Errorf("addInfo() without a required RSDP = %v, want %v", err, ErrInfoNotAvailable)
+	}
+}
+
+func TestParseScreenInfo(t *testing.T) {
+	bp := make([]byte, 4096)
+	if fb, err := parseScreenInfo(bp); fb != nil || err != nil {
+		t.Errorf("parseScreenInfo(no console) = %+v, %v, want nil, nil", fb, err)
+	}
+
+	bp[0x07] = 80
+	bp[0x0e] = 25
+	bp[0x0f] = videoTypeVGAText
+	want := &framebuffer{Addr: 0xB8000, Pitch: 160, Width: 80, Height: 25, BPP: 16, Type: framebufferTypeEGAText}
+	if fb, err := parseScreenInfo(bp); err != nil || !reflect.DeepEqual(fb, want) {
+		t.Errorf("parseScreenInfo(VGA text) = %+v, %v, want %+v, nil", fb, err, want)
+	}
+
+	if _, err := parseScreenInfo(bp[:0x40]); err == nil {
+		t.Errorf("parseScreenInfo(short boot params) = nil, want an error")
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package multiboot
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/u-root/uio/uio"
+)
+
+// ErrInfoNotAvailable indicates that a multiboot2 kernel requires boot
+// information that cannot be provided.
+var ErrInfoNotAvailable = errors.New("multiboot2 boot information not available")
+
+// Multiboot2 boot information tag types, as defined in
+// https://www.gnu.org/software/grub/manual/multiboot2/multiboot.html#Boot-information-format.
+const (
+	infoTagEnd            = 0
+	infoTagCmdline        = 1
+	infoTagBootLoaderName = 2
+	infoTagModule         = 3
+	infoTagBasicMeminfo   = 4
+	infoTagMmap           = 6
+	infoTagFramebuffer    = 8
+	infoTagACPIOld        = 14
+	infoTagACPINew        = 15
+)
+
+// sizeofMmap2Entry is the size of a multiboot2 memory map entry, which unlike
+// a multiboot one has no size field.
+const sizeofMmap2Entry = 24
+
+type infoTag struct {
+	typ  uint32
+	data []byte
+}
+
+// info2 represents the Multiboot2 boot information passed to the loaded
+// kernel, a list of tags.
+type info2 struct {
+	tags []infoTag
+}
+
+func (i *info2) add(typ uint32, data []byte) {
+	i.tags = append(i.tags, infoTag{typ: typ, data: data})
+}
+
+func (i *info2) addString(typ uint32, s string) {
+	i.add(typ, append([]byte(s), 0))
+}
+
+func (i *info2) has(typ uint32) bool {
+	for _, t := range i.tags {
+		if t.typ == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// marshal writes out the exact bytes of the multiboot2 boot information
+// expected by the kernel being loaded.
+func (i *info2) marshal() []byte {
+	buf := uio.NewLittleEndianBuffer(nil)
+	// total_size is filled in at the end.
+	buf.Write32(0)
+	buf.Write32(0)
+
+	tags := append(i.tags, infoTag{typ: infoTagEnd})
+	for _, t := range tags {
+		// The size of a tag does not include the padding that aligns
+		// the next one to 8 bytes.
+		buf.Write32(t.typ)
+		buf.Write32(uint32(8 + len(t.data)))
+		buf.WriteBytes(t.data)
+		if pad := (8 - len(t.data)%8) % 8; pad != 0 {
+			buf.WriteBytes(make([]byte, pad))
+		}
+	}
+	b := buf.Data()
+	binary.LittleEndian.PutUint32(b, uint32(len(b)))
+	return b
+}
+
+// moduleTag marshals a module tag of a module loaded at [start, end).
+func moduleTag(mod module, cmdline string) []byte {
+	buf := uio.NewLittleEndianBuffer(nil)
+	buf.Write32(mod.Start)
+	buf.Write32(mod.End)
+	buf.WriteBytes(append([]byte(cmdline), 0))
+	return buf.Data()
+}
+
+// mmapTag marshals a multiboot2 memory map tag.
+func mmapTag(m memoryMaps) []byte {
+	buf := uio.NewLittleEndianBuffer(nil)
+	buf.Write32(sizeofMmap2Entry)
+	// entry_version.
+	buf.Write32(0)
+	for _, mm := range m {
+		buf.Write64(mm.BaseAddr)
+		buf.Write64(mm.Length)
+		buf.Write32(mm.Type)
+		// Reserved.
+		buf.Write32(0)
+	}
+	return buf.Data()
+}
+
+// basicMeminfoTag marshals the amount of lower and upper memory, in KiB.
+func basicMeminfoTag(lower, upper uint32) []byte {
+	buf := uio.NewLittleEndianBuffer(nil)
+	buf.Write32(lower >> 10)
+	buf.Write32(upper >> 10)
+	return buf.Data()
+}
@@ -3,7 +3,10 @@
 // license that can be found in the LICENSE file.
 
 // Package multiboot implements bootloading multiboot kernels as defined by
-// https://www.gnu.org/software/grub/manual/multiboot/multiboot.html.
+// https://www.gnu.org/software/grub/manual/multiboot/multiboot.html,
+// multiboot2 kernels as defined by
+// https://www.gnu.org/software/grub/manual/multiboot2/multiboot.html, and
+// ESXBootInfo kernels.
 //
 // Package multiboot crafts kexec segments that can be used with the kexec_load
 // system call.
@@ -20,6 +23,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/u-root/u-root/pkg/acpi"
 	"github.com/u-root/u-root/pkg/boot/ibft"
 	"github.com/u-root/u-root/pkg/boot/kexec"
 	"github.com/u-root/u-root/pkg/boot/multiboot/internal/trampoline"
@@ -129,15 +133,18 @@ func (m memoryMaps) String() string {
 	return strings.Join(s, "\n")
 }
 
-// Probe checks if `kernel` is multiboot v1 or esxBootInfo kernel.
-// If the `kernel` is gzip'ed, it will decompress it.
-// Only Gzip decmpression is supported at present.
+// Probe checks if `kernel` is multiboot v1, multiboot2 or esxBootInfo kernel.
+// If the `kernel` is compressed with gzip, zstd, lz4 or xz, it will
+// decompress it.
 func Probe(kernel io.ReaderAt) error {
-	r := util.TryGzipFilter(kernel)
+	r := util.TryDecompressFilter(kernel)
 	_, err := parseHeader(uio.Reader(r))
 	if err == ErrHeaderNotFound {
 		_, err = parseMutiHeader(uio.Reader(r))
 	}
+	if err == ErrHeaderNotFound {
+		_, err = parseHeader2(uio.Reader(r))
+	}
 	return err
 }
 
@@ -189,9 +196,9 @@ func Load(debug bool, kernel io.ReaderAt, cmdline string, modules []Module, ibft
 // Load can set up an arbitrary number of modules, and takes care of the
 // multiboot info structure, including the memory map.
 func PrepareLoad(debug bool, kernel io.ReaderAt, cmdline string, modules []Module, ibft *ibft.IBFT) (uintptr, kexec.Segments, error) {
-	kernel = util.TryGzipFilter(kernel)
+	kernel = util.TryDecompressFilter(kernel)
 	for i, mod := range modules {
-		modules[i].Module = util.TryGzipFilter(mod.Module)
+		modules[i].Module = util.TryDecompressFilter(mod.Module)
 	}
 
 	m, err := newMB(kernel, cmdline, modules)
@@ -271,21 +278,19 @@ func (m *multiboot) load(debug bool, ibft *ibft.IBFT) error {
 		esxBootInfoHeader, err = parseMutiHeader(uio.Reader(m.kernel))
 		header = esxBootInfoHeader
 	}
+	if err == ErrHeaderNotFound {
+		var multiboot2Header *header2
+		multiboot2Header, err = parseHeader2(uio.Reader(m.kernel))
+		header = multiboot2Header
+	}
 	if err != nil {
 		return fmt.Errorf("error parsing headers: %v", err)
 	}
 	log.Printf("Found %s image", header.name())
 
-	log.Printf("Getting kernel entry point")
-	kernelEntry, err := getEntryPoint(m.kernel)
+	kernelEntry, err := header.loadKernel(m)
 	if err != nil {
-		return fmt.Errorf("error getting kernel entry point: %v", err)
-	}
-	log.Printf("Kernel entry point at %#x", kernelEntry)
-
-	log.Printf("Parsing ELF segments")
-	if _, err := m.mem.LoadElfSegments(m.kernel); err != nil {
-		return fmt.Errorf("error loading ELF segments: %v", err)
+		return err
 	}
 
 	log.Printf("Parsing memory map")
@@ -337,6 +342,90 @@ func (m *multiboot) load(debug bool, ibft *ibft.IBFT) error {
 	return nil
 }
 
+// loadELF loads the segments of the ELF kernel, and returns its entry point.
+func (m *multiboot) loadELF() (uintptr, error) {
+	log.Printf("Getting kernel entry point")
+	kernelEntry, err := getEntryPoint(m.kernel)
+	if err != nil {
+		return 0, fmt.Errorf("error getting kernel entry point: %v", err)
+	}
+	log.Printf("Kernel entry point at %#x", kernelEntry)
+
+	log.Printf("Parsing ELF segments")
+	if _, err := m.mem.LoadElfSegments(m.kernel); err != nil {
+		return 0, fmt.Errorf("error loading ELF segments: %v", err)
+	}
+	return kernelEntry, nil
+}
+
+func (h *header) loadKernel(m *multiboot) (uintptr, error) {
+	return m.loadELF()
+}
+
+func (*esxBootInfoHeader) loadKernel(m *multiboot) (uintptr, error) {
+	return m.loadELF()
+}
+
+// loadKernel loads the kernel where its address tag says, or else as the
+// ELF file it is, and returns the entry point of its entry address tag or
+// else of its ELF header.
+func (h *header2) loadKernel(m *multiboot) (uintptr, error) {
+	if h.address == nil {
+		entry, err := m.loadELF()
+		if err != nil {
+			return 0, err
+		}
+		if h.entry != nil {
+			entry = uintptr(*h.entry)
+			log.Printf("Kernel entry point at %#x from the entry address tag", entry)
+		}
+		return entry, nil
+	}
+	if h.entry == nil {
+		return 0, fmt.Errorf("%w: address tag without an entry address tag", errBadTag)
+	}
+
+	b, err := uio.ReadAll(m.kernel)
+	if err != nil {
+		return 0, fmt.Errorf("error reading kernel: %v", err)
+	}
+	a := *h.address
+	// The image is loaded from its beginning if load_addr is -1, and
+	// otherwise from where load_addr is relative to the header.
+	var off uint32
+	if a.LoadAddr == 0xFFFFFFFF {
+		if a.HeaderAddr < h.offset {
+			return 0, fmt.Errorf("%w: header address %#x is below the image", errBadTag, a.HeaderAddr)
+		}
+		a.LoadAddr = a.HeaderAddr - h.offset
+	} else {
+		if a.LoadAddr > a.HeaderAddr || a.HeaderAddr-a.LoadAddr > h.offset {
+			return 0, fmt.Errorf("%w: load address %#x is not before header address %#x in the image", errBadTag, a.LoadAddr, a.HeaderAddr)
+		}
+		off = h.offset - (a.HeaderAddr - a.LoadAddr)
+	}
+	size := uint32(len(b)) - off
+	if a.LoadEndAddr != 0 {
+		if a.LoadEndAddr < a.LoadAddr || a.LoadEndAddr-a.LoadAddr > size {
+			return 0, fmt.Errorf("%w: load end address %#x is not within the image", errBadTag, a.LoadEndAddr)
+		}
+		size = a.LoadEndAddr - a.LoadAddr
+	}
+	memSize := size
+	if a.BSSEndAddr != 0 {
+		if a.BSSEndAddr < a.LoadAddr+size {
+			return 0, fmt.Errorf("%w: bss end address %#x is before the load end address", errBadTag, a.BSSEndAddr)
+		}
+		memSize = a.BSSEndAddr - a.LoadAddr
+	}
+	m.mem.Segments.Insert(kexec.NewSegment(b[off:off+size], kexec.Range{
+		Start: uintptr(a.LoadAddr),
+		Size:  uint(memSize),
+	}))
+	log.Printf("Kernel loaded at [%#x, %#x), entry point at %#x", a.LoadAddr, a.LoadAddr+memSize, *h.entry)
+	return uintptr(*h.entry), nil
+}
+
 func getEntryPoint(r io.ReaderAt) (uintptr, error) {
 	f, err := elf.NewFile(r)
 	if err != nil {
@@ -417,6 +506,79 @@ func (*esxBootInfoHeader) addInfo(m *multiboot) (addr uintptr, err error) {
 	return memRange.Start, nil
 }
 
+// getRSDP returns the ACPI RSDP passed to multiboot2 kernels.
+var getRSDP = func() ([]byte, error) {
+	r, err := acpi.GetRSDP()
+	if err != nil {
+		return nil, err
+	}
+	return r.AllData(), nil
+}
+
+// addInfo collects and adds the multiboot2 boot information into the
+// segments.
+//
+// The information is a list of tags, as described in
+// https://www.gnu.org/software/grub/manual/multiboot2/multiboot.html#Boot-information-format.
+// It includes the cmdline, the modules, the memory map, and if they are
+// known, the framebuffer and the ACPI RSDP.
+func (h *header2) addInfo(m *multiboot) (addr uintptr, err error) {
+	var mi info2
+	mi.addString(infoTagCmdline, m.cmdLine)
+	mi.addString(infoTagBootLoaderName, m.bootloader)
+
+	if len(m.modules) > 0 {
+		mods, err := m.loadModules()
+		if err != nil {
+			return 0, err
+		}
+		for i, mod := range mods {
+			mi.add(infoTagModule, moduleTag(mod, m.modules[i].Cmdline))
+		}
+	}
+
+	lower, upper := m.memoryBoundaries()
+	mi.add(infoTagBasicMeminfo, basicMeminfoTag(lower, upper))
+	mi.add(infoTagMmap, mmapTag(m.memoryMap()))
+
+	fb, err := currentFramebuffer()
+	if err != nil {
+		log.Printf("Cannot find the framebuffer: %v", err)
+	}
+	if fb != nil {
+		if h.framebuffer != nil {
+			log.Printf("Passing the current %dx%dx%d framebuffer, the kernel prefers %dx%dx%d",
+				fb.Width, fb.Height, fb.BPP, h.framebuffer.Width, h.framebuffer.Height, h.framebuffer.Depth)
+		}
+		mi.add(infoTagFramebuffer, fb.marshal())
+	} else if h.framebufferRequired {
+		return 0, fmt.Errorf("%w: the kernel requires a framebuffer", ErrInfoNotAvailable)
+	}
+
+	if rsdp, err := getRSDP(); err != nil {
+		log.Printf("Cannot find the ACPI RSDP: %v", err)
+	} else {
+		// The old tag is a copy of the ACPI 1.0 RSDP, and the new
+		// one of the ACPI 2.0 RSDP, whose revision is 2 or more.
+		mi.add(infoTagACPIOld, rsdp[:20])
+		if rsdp[15] >= 2 {
+			mi.add(infoTagACPINew, rsdp)
+		}
+	}
+
+	for _, typ := range h.requests {
+		if !mi.has(typ) {
+			return 0, fmt.Errorf("%w: the kernel requires tag type %d", ErrInfoNotAvailable, typ)
+		}
+	}
+
+	r, err := m.mem.AddKexecSegment(mi.marshal())
+	if err != nil {
+		return 0, err
+	}
+	return r.Start, nil
+}
+
 func (m multiboot) memoryMap() memoryMaps {
 	var ret memoryMaps
 	for _, r := range m.mem.Phys {
@@ -5,6 +5,7 @@
 package ipxe
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"testing"
@@ -31,9 +32,7 @@ func FuzzParseIpxeConfig(f *testing.F) {
 			return
 		}
 
-		parser := &parser{
-			log: ulogtest.Logger{t},
-		}
-		parser.parseIpxe(string(data))
+		parser := newParser(ulogtest.Logger{t}, nil)
+		parser.parseIpxe(context.Background(), string(data))
 	})
 }
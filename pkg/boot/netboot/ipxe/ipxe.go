@@ -2,7 +2,10 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-// Package ipxe implements a trivial IPXE config file parser.
+// Package ipxe implements an interpreter for iPXE scripts.
+//
+// See https://ipxe.org/scripting for the language and https://ipxe.org/cmd
+// for its commands.
 package ipxe
 
 import (
@@ -13,6 +16,7 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"runtime"
 	"strings"
 
 	"github.com/u-root/u-root/pkg/boot"
@@ -21,15 +25,37 @@ import (
 	"github.com/u-root/uio/uio"
 )
 
-// ErrNotIpxeScript is returned when the config file is not an
-// ipxe script.
-var ErrNotIpxeScript = errors.New("config file is not ipxe as it does not start with #!ipxe")
+var (
+	// ErrNotIpxeScript is returned when the config file is not an
+	// ipxe script.
+	ErrNotIpxeScript = errors.New("config file is not ipxe as it does not start with #!ipxe")
+
+	errFalse    = errors.New("condition is false")
+	errUsage    = errors.New("invalid arguments")
+	errNoKernel = errors.New("no kernel to boot")
+	errNoLabel  = errors.New("no such label")
+)
+
+const (
+	// maxSteps limits the number of lines a script runs, as goto may
+	// loop forever.
+	maxSteps = 10000
+
+	// maxChains limits the number of scripts chained from each other.
+	maxChains = 16
+)
+
+// efiDir is where Linux shows EFI firmware, which sets the platform setting.
+var efiDir = "/sys/firmware/efi"
 
 // parser encapsulates a parsed ipxe configuration file.
 //
-// We currently only support kernel and initrd commands.
+// It runs the commands that select and boot a kernel, and those that
+// control the script, such as set, goto and chain. Other commands are
+// ignored.
 type parser struct {
 	bootImage *boot.LinuxImage
+	initrds   []io.Reader
 
 	// wd is the current working directory.
 	//
@@ -39,16 +65,84 @@ type parser struct {
 	log ulog.Logger
 
 	schemes curl.Schemes
+
+	// settings are the variables of the script, such as ip and
+	// net0/mac, expanded in ${name}.
+	settings map[string]string
+
+	// script is the script being run.
+	script *script
+
+	// done is set when the script boots or exits.
+	done   bool
+	steps  int
+	chains int
+}
+
+// script is the lines of an iPXE script and its labels.
+type script struct {
+	lines  []string
+	labels map[string]int
+
+	// pc is the index of the line being run.
+	pc int
+}
+
+// ParseOption is an optional parameter to ParseConfig.
+type ParseOption func(*parser)
+
+// WithSettings is a ParseOption that sets iPXE settings, such as ip, mac or
+// net0/mac, that scripts read.
+func WithSettings(settings map[string]string) ParseOption {
+	return func(p *parser) {
+		for name, value := range settings {
+			p.settings[name] = value
+		}
+	}
+}
+
+func newParser(l ulog.Logger, s curl.Schemes) *parser {
+	return &parser{
+		bootImage: &boot.LinuxImage{},
+		log:       l,
+		schemes:   s,
+		settings: map[string]string{
+			"platform":  platform(),
+			"buildarch": buildarch(),
+		},
+	}
+}
+
+// platform returns the platform setting of iPXE, efi or pcbios.
+func platform() string {
+	if _, err := os.Stat(efiDir); err == nil {
+		return "efi"
+	}
+	return "pcbios"
+}
+
+// buildarch returns the buildarch setting of iPXE, which names the CPU
+// architecture.
+func buildarch() string {
+	switch runtime.GOARCH {
+	case "amd64":
+		return "x86_64"
+	case "386":
+		return "i386"
+	case "arm":
+		return "arm32"
+	}
+	return runtime.GOARCH
 }
 
 // ParseConfig returns a new configuration with the file at URL and default
 // schemes.
 //
 // `s` is used to get files referred to by URLs in the configuration.
-func ParseConfig(ctx context.Context, l ulog.Logger, configURL *url.URL, s curl.Schemes) (*boot.LinuxImage, error) {
-	c := &parser{
-		schemes: s,
-		log:     l,
+func ParseConfig(ctx context.Context, l ulog.Logger, configURL *url.URL, s curl.Schemes, opts ...ParseOption) (*boot.LinuxImage, error) {
+	c := newParser(l, s)
+	for _, opt := range opts {
+		opt(c)
 	}
 	if err := c.getAndParseFile(ctx, configURL); err != nil {
 		return nil, err
@@ -62,14 +156,22 @@ func (c *parser) getAndParseFile(ctx context.Context, u *url.URL) error {
 	if err != nil {
 		return err
 	}
+	return c.parseFile(ctx, u, r)
+}
+
+// parseFile parses the config file r downloaded from `u` and fills in `c`.
+func (c *parser) parseFile(ctx context.Context, u *url.URL, r io.ReaderAt) error {
+	// Check the magic first, so that images such as ISOs are not read
+	// into memory.
+	magic := make([]byte, len("#!ipxe"))
+	if _, err := r.ReadAt(magic, 0); err != nil || string(magic) != "#!ipxe" {
+		return ErrNotIpxeScript
+	}
 	data, err := uio.ReadAll(r)
 	if err != nil {
 		return err
 	}
 	config := string(data)
-	if !strings.HasPrefix(config, "#!ipxe") {
-		return ErrNotIpxeScript
-	}
 	c.log.Printf("Got ipxe config file %s:\n%s\n", r, config)
 
 	// Parent dir of the config file.
@@ -78,7 +180,7 @@ func (c *parser) getAndParseFile(ctx context.Context, u *url.URL) error {
 		Host:   u.Host,
 		Path:   path.Dir(u.Path),
 	}
-	return c.parseIpxe(config)
+	return c.parseIpxe(ctx, config)
 }
 
 // getFile parses `surl` and returns an io.Reader for the requested url.
@@ -149,70 +251,317 @@ func parseURL(name string, wd *url.URL) (*url.URL, error) {
 	return u, nil
 }
 
-func (c *parser) createInitrd(initrds []io.Reader) {
-	if len(initrds) > 0 {
-		c.bootImage.Initrd = boot.CatInitrdsWithFileCache(initrds...)
+func (c *parser) createInitrd() {
+	if len(c.initrds) > 0 {
+		c.bootImage.Initrd = boot.CatInitrdsWithFileCache(c.initrds...)
 	}
 }
 
-// parseIpxe parses `config` and constructs a BootImage for `c`.
-func (c *parser) parseIpxe(config string) error {
-	// A trivial ipxe script parser.
-	// Currently only supports kernel and initrd commands.
-	c.bootImage = &boot.LinuxImage{}
+// parseIpxe runs the script `config` and constructs a BootImage for `c`.
+//
+// Like iPXE, it stops at the first command that fails, unless the failure is
+// handled with ||.
+func (c *parser) parseIpxe(ctx context.Context, config string) error {
+	sc := &script{
+		lines:  strings.Split(config, "\n"),
+		labels: make(map[string]int),
+	}
+	for i, line := range sc.lines {
+		label, ok := strings.CutPrefix(strings.TrimSpace(line), ":")
+		if f := strings.Fields(label); ok && len(f) > 0 {
+			sc.labels[f[0]] = i
+		}
+	}
+
+	parent := c.script
+	c.script = sc
+	defer func() { c.script = parent }()
 
-	var initrds []io.Reader
-	for _, line := range strings.Split(config, "\n") {
-		// Skip blank lines and comment lines.
-		line = strings.TrimSpace(line)
-		if line == "" || line[0] == '#' {
+	for ; sc.pc < len(sc.lines) && !c.done; sc.pc++ {
+		// Skip blank lines, comment lines and labels.
+		line := strings.TrimSpace(sc.lines[sc.pc])
+		if line == "" || line[0] == '#' || line[0] == ':' {
 			continue
 		}
 
-		args := strings.Fields(line)
-		if len(args) == 0 {
-			continue
+		c.steps++
+		if c.steps > maxSteps {
+			return fmt.Errorf("script ran more than %d commands", maxSteps)
+		}
+		if err := c.run(ctx, splitArgs(c.expand(line))); err != nil {
+			return fmt.Errorf("line %d %q: %w", sc.pc+1, line, err)
 		}
-		cmd := strings.ToLower(args[0])
+	}
 
-		switch cmd {
-		case "kernel":
-			if len(args) > 1 {
-				k, err := c.getFile(args[1])
-				if err != nil {
-					return err
-				}
-				c.bootImage.Kernel = k
+	// EOF - we should go ahead and boot.
+	if !c.done {
+		c.done = true
+		c.createInitrd()
+	}
+	return nil
+}
+
+// run runs the commands of a line, such as "isset ${ip} || dhcp". The
+// command after || runs if the one before failed, and the one after && if it
+// succeeded. A trailing || ignores any failure.
+func (c *parser) run(ctx context.Context, args []string) error {
+	var err error
+	for exec := true; len(args) > 0; {
+		cmd, op := args, ""
+		for i, arg := range args {
+			if arg == "||" || arg == "&&" {
+				cmd, op = args[:i], arg
+				break
 			}
+		}
+		args = args[len(cmd):]
+		if op != "" {
+			args = args[1:]
+		}
 
-			// Add cmdline if there are any.
-			if len(args) > 2 {
-				c.bootImage.Cmdline = strings.Join(args[2:], " ")
+		if exec {
+			pc := c.script.pc
+			err = c.command(ctx, cmd)
+			// Nothing else runs after a goto or boot.
+			if c.done || c.script.pc != pc {
+				return err
 			}
+		}
+		switch op {
+		case "||":
+			exec = err != nil
+			if exec {
+				c.log.Printf("Ignoring failed ipxe cmd %q: %v", strings.Join(cmd, " "), err)
+				err = nil
+			}
+		case "&&":
+			exec = err == nil
+		}
+	}
+	return err
+}
 
-		case "initrd":
-			if len(args) > 1 {
-				for _, f := range strings.Split(args[1], ",") {
-					i, err := c.getFileWithoutCache(f)
-					if err != nil {
-						return err
-					}
-					initrds = append(initrds, i)
-				}
+// command runs a command of the script.
+func (c *parser) command(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+	switch cmd := strings.ToLower(args[0]); cmd {
+	case "set":
+		if len(args) < 2 {
+			return errUsage
+		}
+		c.settings[args[1]] = strings.Join(args[2:], " ")
+
+	case "clear":
+		if len(args) != 2 {
+			return errUsage
+		}
+		delete(c.settings, args[1])
+
+	case "isset":
+		if len(args) < 2 || args[1] == "" {
+			return errFalse
+		}
+
+	case "iseq":
+		if len(args) != 3 {
+			return errUsage
+		}
+		if args[1] != args[2] {
+			return errFalse
+		}
+
+	case "goto":
+		if len(args) != 2 {
+			return errUsage
+		}
+		pc, ok := c.script.labels[args[1]]
+		if !ok {
+			return fmt.Errorf("%w %q", errNoLabel, args[1])
+		}
+		c.script.pc = pc
+
+	case "dhcp", "ifopen", "ifconf":
+		// u-root configured the network before it got the script.
+
+	case "kernel", "imgselect", "imgload":
+		args = imageArgs(args[1:])
+		if len(args) == 0 {
+			return errUsage
+		}
+		k, err := c.getFile(args[0])
+		if err != nil {
+			return err
+		}
+		c.bootImage.Kernel = k
+		c.bootImage.Cmdline = strings.Join(args[1:], " ")
+
+	case "initrd", "module", "imgfetch":
+		args = imageArgs(args[1:])
+		if len(args) == 0 {
+			return errUsage
+		}
+		for _, f := range strings.Split(args[0], ",") {
+			i, err := c.getFileWithoutCache(f)
+			if err != nil {
+				return err
 			}
+			c.initrds = append(c.initrds, i)
+		}
+
+	case "imgargs":
+		if len(args) < 2 {
+			return errUsage
+		}
+		c.bootImage.Cmdline = strings.Join(args[2:], " ")
 
-		case "boot":
-			// Stop parsing at this point, we should go ahead and
-			// boot.
-			c.createInitrd(initrds)
-			return nil
+	case "imgfree":
+		c.bootImage = &boot.LinuxImage{}
+		c.initrds = nil
 
-		default:
-			c.log.Printf("Ignoring unsupported ipxe cmd: %s", line)
+	case "boot":
+		// Stop parsing at this point, we should go ahead and
+		// boot.
+		if c.bootImage.Kernel == nil {
+			return errNoKernel
 		}
+		c.done = true
+		c.createInitrd()
+
+	case "chain", "imgexec":
+		return c.chain(ctx, imageArgs(args[1:]))
+
+	case "exit":
+		c.done = true
+		c.createInitrd()
+		if len(args) > 1 && args[1] != "0" {
+			return fmt.Errorf("script exited with status %s", args[1])
+		}
+
+	default:
+		c.log.Printf("Ignoring unsupported ipxe cmd: %s", strings.Join(args, " "))
 	}
+	return nil
+}
 
-	// EOF - we should go ahead and boot.
-	c.createInitrd(initrds)
+// chain runs the script at the URL args[0], or boots the kernel there with
+// the command line args[1:].
+func (c *parser) chain(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	u, err := parseURL(args[0], c.wd)
+	if err != nil {
+		return err
+	}
+	r, err := c.schemes.Fetch(ctx, u)
+	if err != nil {
+		return err
+	}
+
+	magic := make([]byte, len("#!ipxe"))
+	if _, err := r.ReadAt(magic, 0); err == nil && string(magic) == "#!ipxe" {
+		if c.chains >= maxChains {
+			return fmt.Errorf("chained more than %d scripts", maxChains)
+		}
+		c.chains++
+		return c.parseFile(ctx, u, r)
+	}
+
+	c.bootImage.Kernel = r
+	c.bootImage.Cmdline = strings.Join(args[1:], " ")
+	c.done = true
+	c.createInitrd()
 	return nil
 }
+
+// imageArgs returns the URL and arguments of an image command, such as
+// "kernel --name linux vmlinuz console=ttyS0", without its options.
+func imageArgs(args []string) []string {
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		switch args[0] {
+		case "-n", "--name", "-t", "--timeout":
+			// Options with a value.
+			if len(args) > 1 {
+				args = args[1:]
+			}
+		}
+		args = args[1:]
+	}
+	return args
+}
+
+// expand replaces the settings ${name} and ${name:type} in s with their
+// values. Like iPXE, it expands the innermost setting first, so ${${a}}
+// is the setting named by a.
+func (c *parser) expand(s string) string {
+	end := len(s)
+	for {
+		i := strings.LastIndex(s[:end], "${")
+		if i < 0 {
+			return s
+		}
+		j := strings.IndexByte(s[i:], '}')
+		if j < 0 {
+			end = i
+			continue
+		}
+		s = s[:i] + c.setting(s[i+2:i+j]) + s[i+j+1:]
+		end = i
+	}
+}
+
+// setting returns the value of a setting, formatted as the type after a
+// colon in name, such as mac:hexhyp.
+func (c *parser) setting(name string) string {
+	name, typ, _ := strings.Cut(name, ":")
+	v := c.settings[name]
+	switch typ {
+	case "hexhyp":
+		return strings.ReplaceAll(v, ":", "-")
+	case "hexraw":
+		return strings.ReplaceAll(v, ":", "")
+	case "uristring":
+		return url.PathEscape(v)
+	}
+	return v
+}
+
+// splitArgs splits a line into arguments separated by white space. Quotes
+// and backslashes escape white space, as in a shell.
+func splitArgs(line string) []string {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, r := range line {
+		switch {
+		case escaped:
+			arg.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inArg = true, true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			arg.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t' || r == '\r':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args
+}
@@ -6,6 +6,7 @@ package ipxe
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/url"
@@ -124,6 +125,7 @@ func TestIpxeConfig(t *testing.T) {
 		desc       string
 		schemeFunc func() curl.Schemes
 		curl       *url.URL
+		opts       []ParseOption
 		want       *boot.LinuxImage
 		err        error
 	}{
@@ -439,9 +441,103 @@ func TestIpxeConfig(t *testing.T) {
 				Initrd: strings.NewReader(content2),
 			},
 		},
+		{
+			desc: "settings, conditions and goto",
+			schemeFunc: func() curl.Schemes {
+				s := make(curl.Schemes)
+				fs := curl.NewMockScheme("http")
+				conf := `#!ipxe
+				isset ${ip} || dhcp
+				set base http://someplace.com/foobar/pxefiles
+				iseq ${platform} efi && goto efi || goto bios
+				:bios
+				kernel ${base}/nonexistent
+				goto done
+				:efi
+				kernel --name linux ${base}/kernel console=ttyS0 BOOTIF=01-${net0/mac:hexhyp}
+				:done
+				initrd ${base}/initrd-file
+				boot`
+				fs.Add("someplace.com", "/foobar/pxefiles/ipxeconfig", conf)
+				fs.Add("someplace.com", "/foobar/pxefiles/kernel", content1)
+				fs.Add("someplace.com", "/foobar/pxefiles/initrd-file", content2)
+				s.Register(fs.Scheme, fs)
+				return s
+			},
+			curl: &url.URL{
+				Scheme: "http",
+				Host:   "someplace.com",
+				Path:   "/foobar/pxefiles/ipxeconfig",
+			},
+			opts: []ParseOption{WithSettings(map[string]string{
+				"platform": "efi",
+				"net0/mac": "52:54:00:12:34:56",
+			})},
+			want: &boot.LinuxImage{
+				Kernel:  strings.NewReader(content1),
+				Initrd:  strings.NewReader(content2),
+				Cmdline: "console=ttyS0 BOOTIF=01-52-54-00-12-34-56",
+			},
+		},
+		{
+			desc: "chain to a script, after a failed chain",
+			schemeFunc: func() curl.Schemes {
+				s := make(curl.Schemes)
+				fs := curl.NewMockScheme("http")
+				conf := `#!ipxe
+				chain missing.ipxe || chain ${next}
+				kernel http://someplace.com/foobar/pxefiles/nonexistent`
+				next := `#!ipxe
+				kernel kernel
+				initrd initrd-file
+				boot`
+				fs.Add("someplace.com", "/foobar/pxefiles/ipxeconfig", conf)
+				fs.Add("someplace.com", "/foobar/pxefiles/next.ipxe", next)
+				fs.Add("someplace.com", "/foobar/pxefiles/kernel", content1)
+				fs.Add("someplace.com", "/foobar/pxefiles/initrd-file", content2)
+				s.Register(fs.Scheme, fs)
+				return s
+			},
+			curl: &url.URL{
+				Scheme: "http",
+				Host:   "someplace.com",
+				Path:   "/foobar/pxefiles/ipxeconfig",
+			},
+			opts: []ParseOption{WithSettings(map[string]string{"next": "next.ipxe"})},
+			want: &boot.LinuxImage{
+				Kernel: strings.NewReader(content1),
+				Initrd: strings.NewReader(content2),
+			},
+		},
+		{
+			desc: "chain to a kernel",
+			schemeFunc: func() curl.Schemes {
+				s := make(curl.Schemes)
+				fs := curl.NewMockScheme("http")
+				conf := `#!ipxe
+				initrd initrd-file
+				chain --autofree kernel "root=LABEL=my root"
+				kernel http://someplace.com/foobar/pxefiles/nonexistent`
+				fs.Add("someplace.com", "/foobar/pxefiles/ipxeconfig", conf)
+				fs.Add("someplace.com", "/foobar/pxefiles/kernel", content1)
+				fs.Add("someplace.com", "/foobar/pxefiles/initrd-file", content2)
+				s.Register(fs.Scheme, fs)
+				return s
+			},
+			curl: &url.URL{
+				Scheme: "http",
+				Host:   "someplace.com",
+				Path:   "/foobar/pxefiles/ipxeconfig",
+			},
+			want: &boot.LinuxImage{
+				Kernel:  strings.NewReader(content1),
+				Initrd:  strings.NewReader(content2),
+				Cmdline: "root=LABEL=my root",
+			},
+		},
 	} {
 		t.Run(fmt.Sprintf("Test [%02d] %s", i, tt.desc), func(t *testing.T) {
-			got, err := ParseConfig(context.Background(), ulogtest.Logger{t}, tt.curl, tt.schemeFunc())
+			got, err := ParseConfig(context.Background(), ulogtest.Logger{t}, tt.curl, tt.schemeFunc(), tt.opts...)
 			if !reflect.DeepEqual(err, tt.err) {
 				t.Errorf("ParseConfig() got %v, want %v", err, tt.err)
 				return
@@ -465,3 +561,78 @@ func TestIpxeConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestIpxeScriptErrors(t *testing.T) {
+	for _, tt := range []struct {
+		desc   string
+		script string
+		err    error
+	}{
+		{
+			desc:   "unhandled failure",
+			script: "isset ${unset}\nboot",
+			err:    errFalse,
+		},
+		{
+			desc:   "failure before &&",
+			script: "iseq a b && echo equal",
+			err:    errFalse,
+		},
+		{
+			desc:   "missing label",
+			script: "goto nowhere",
+			err:    errNoLabel,
+		},
+		{
+			desc:   "boot without kernel",
+			script: "boot",
+			err:    errNoKernel,
+		},
+		{
+			desc:   "missing chained script",
+			script: "chain missing.ipxe",
+			err:    curl.ErrNoSuchFile,
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			fs := curl.NewMockScheme("http")
+			fs.Add("someplace.com", "/ipxeconfig", "#!ipxe\n"+tt.script)
+			s := make(curl.Schemes)
+			s.Register(fs.Scheme, fs)
+
+			_, err := ParseConfig(context.Background(), ulogtest.Logger{t}, mustParseURL("http://someplace.com/ipxeconfig"), s)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("ParseConfig() = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestIpxeLoop(t *testing.T) {
+	c := newParser(ulogtest.Logger{t}, nil)
+	if err := c.parseIpxe(context.Background(), "#!ipxe\n:loop\ngoto loop"); err == nil {
+		t.Errorf("parseIpxe() of an endless loop = nil, want error")
+	}
+}
+
+func TestExpand(t *testing.T) {
+	c := newParser(ulogtest.Logger{t}, nil)
+	c.settings["mac"] = "52:54:00:12:34:56"
+	c.settings["name"] = "mac"
+	c.settings["path"] = "a b/c"
+	for _, tt := range []struct {
+		line string
+		want []string
+	}{
+		{line: "echo ${mac}", want: []string{"echo", "52:54:00:12:34:56"}},
+		{line: "echo ${mac:hexhyp} ${mac:hexraw}", want: []string{"echo", "52-54-00-12-34-56", "525400123456"}},
+		{line: "echo ${${name}}", want: []string{"echo", "52:54:00:12:34:56"}},
+		{line: "echo x${unset}y ${unterminated", want: []string{"echo", "xy", "${unterminated"}},
+		{line: "echo ${path} ${path:uristring}", want: []string{"echo", "a", "b/c", "a%20b%2Fc"}},
+		{line: `echo "${path}" 'a  b' a\ b`, want: []string{"echo", "a b/c", "a  b", "a b"}},
+	} {
+		if got := splitArgs(c.expand(tt.line)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitArgs(expand(%q)) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
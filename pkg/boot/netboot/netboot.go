@@ -15,11 +15,13 @@ import (
 	"net"
 	"net/url"
 	"path"
+	"strings"
 
 	"github.com/u-root/u-root/pkg/boot"
 	"github.com/u-root/u-root/pkg/boot/netboot/ipxe"
 	"github.com/u-root/u-root/pkg/boot/netboot/pxe"
 	"github.com/u-root/u-root/pkg/boot/netboot/simple"
+	"github.com/u-root/u-root/pkg/boot/syslinux"
 	"github.com/u-root/u-root/pkg/curl"
 	"github.com/u-root/u-root/pkg/dhclient"
 	"github.com/u-root/u-root/pkg/ulog"
@@ -40,25 +42,100 @@ func BootImages(ctx context.Context, l ulog.Logger, s curl.Schemes, lease dhclie
 	}
 	l.Printf("Boot URI: %s", uri)
 
+	var params []string
+	if p6, ok := lease.(*dhclient.Packet6); ok {
+		params = p6.BootParams()
+	}
+
+	if lease.HTTPBoot() {
+		l.Printf("Lease offers HTTP Boot, trying to parse boot file as an image...")
+		images, err := simple.FetchAndProbe(ctx, uri, s)
+		if err != nil {
+			return nil, err
+		}
+		return appendParams(images, params), nil
+	}
+
 	// IP only makes sense for v4 anyway, because the PXE probing of files
 	// uses a MAC address and an IPv4 address to look at files.
 	var ip net.IP
+	var n *syslinux.Network
+	mac := lease.Link().Attrs().HardwareAddr
 	if p4, ok := lease.(*dhclient.Packet4); ok {
 		ip = p4.Lease().IP
+
+		m, _ := p4.Message()
+		n = &syslinux.Network{
+			IP:     p4.Lease(),
+			Server: m.ServerIPAddr,
+			MAC:    mac,
+		}
+		if routers := m.Router(); len(routers) > 0 {
+			n.Gateway = routers[0]
+		}
 	}
-	return getBootImages(ctx, l, s, uri, lease.Link().Attrs().HardwareAddr, ip), nil
+	return appendParams(getBootImages(ctx, l, s, uri, mac, ip, n), params), nil
+}
+
+// appendParams appends the boot file parameters params to the command line
+// of images.
+func appendParams(images []boot.OSImage, params []string) []boot.OSImage {
+	if len(params) == 0 {
+		return images
+	}
+	for _, img := range images {
+		img.Edit(func(cmdline string) string {
+			if cmdline == "" {
+				return strings.Join(params, " ")
+			}
+			return cmdline + " " + strings.Join(params, " ")
+		})
+	}
+	return images
+}
+
+// ipxeSettings returns the iPXE settings of the boot interface, which
+// scripts read as ${ip} or ${net0/ip}.
+func ipxeSettings(mac net.HardwareAddr, n *syslinux.Network) map[string]string {
+	s := map[string]string{"mac": mac.String()}
+	if n != nil {
+		if n.IP != nil {
+			s["ip"] = n.IP.IP.String()
+			s["netmask"] = net.IP(n.IP.Mask).String()
+		}
+		if n.Gateway != nil {
+			s["gateway"] = n.Gateway.String()
+		}
+		if n.Server != nil {
+			s["next-server"] = n.Server.String()
+		}
+	}
+	settings := make(map[string]string)
+	for name, value := range s {
+		settings[name] = value
+		settings["net0/"+name] = value
+	}
+	return settings
 }
 
 // getBootImages attempts to parse the file at uri as an ipxe config and returns
 // the ipxe boot image. Otherwise falls back to pxe and uses the uri directory,
 // ip, and mac address to search for pxe configs.
-func getBootImages(ctx context.Context, l ulog.Logger, schemes curl.Schemes, uri *url.URL, mac net.HardwareAddr, ip net.IP) []boot.OSImage {
+//
+// n is the network configuration of IPv4 leases, used by iPXE settings and
+// the IPAPPEND directive of pxelinux.
+func getBootImages(ctx context.Context, l ulog.Logger, schemes curl.Schemes, uri *url.URL, mac net.HardwareAddr, ip net.IP, n *syslinux.Network) []boot.OSImage {
 	var images []boot.OSImage
 
+	var pxeOpts []syslinux.ParseOption
+	if n != nil {
+		pxeOpts = append(pxeOpts, syslinux.WithNetwork(n))
+	}
+
 	// 1: Attempt to download the given url as is.
 	//
 	// 1.1: Try ipxe config file.
-	ipc, err := ipxe.ParseConfig(ctx, l, uri, schemes)
+	ipc, err := ipxe.ParseConfig(ctx, l, uri, schemes, ipxe.WithSettings(ipxeSettings(mac, n)))
 	if err != nil {
 		l.Printf("Parsing boot files as iPXE failed, trying other formats...: %v", err)
 	}
@@ -86,7 +163,7 @@ func getBootImages(ctx context.Context, l ulog.Logger, schemes curl.Schemes, uri
 		Host:   uri.Host,
 		Path:   path.Dir(uri.Path),
 	}
-	pxeImages, err := pxe.ParseConfig(ctx, wd, mac, ip, schemes)
+	pxeImages, err := pxe.ParseConfig(ctx, wd, mac, ip, schemes, pxeOpts...)
 	if err != nil {
 		l.Printf("Failed to try parsing pxelinux config: %v", err)
 	}
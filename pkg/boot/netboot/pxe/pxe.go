@@ -22,8 +22,8 @@ import (
 )
 
 // ParseConfig probes for config files based on the Mac and IP given
-// and uses s to fetch files.
-func ParseConfig(ctx context.Context, workingDir *url.URL, mac net.HardwareAddr, ip net.IP, s curl.Schemes) ([]boot.OSImage, error) {
+// and uses s to fetch files. opts are passed to syslinux.ParseConfigFile.
+func ParseConfig(ctx context.Context, workingDir *url.URL, mac net.HardwareAddr, ip net.IP, s curl.Schemes, opts ...syslinux.ParseOption) ([]boot.OSImage, error) {
 	rootDir := *workingDir
 	rootDir.Path = ""
 
@@ -33,7 +33,7 @@ func ParseConfig(ctx context.Context, workingDir *url.URL, mac net.HardwareAddr,
 		// with DHCP option 210."
 		//
 		// https://wiki.syslinux.org/wiki/index.php?title=Config#Working_directory
-		imgs, err := syslinux.ParseConfigFile(ctx, s, path.Join("pxelinux.cfg", relname), &rootDir, workingDir.Path)
+		imgs, err := syslinux.ParseConfigFile(ctx, s, path.Join("pxelinux.cfg", relname), &rootDir, workingDir.Path, opts...)
 		if curl.IsURLError(err) {
 			// We didn't find the file.
 			// TODO(hugelgupf): log this.
@@ -5,21 +5,34 @@
 package simple
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
 	l "log"
 	"math"
 	"net/url"
+	"os"
+	"path"
 
 	"github.com/u-root/u-root/pkg/boot"
 	"github.com/u-root/u-root/pkg/boot/fit"
+	"github.com/u-root/u-root/pkg/boot/grub"
+	"github.com/u-root/u-root/pkg/boot/syslinux"
+	"github.com/u-root/u-root/pkg/boot/uki"
 	"github.com/u-root/u-root/pkg/curl"
+	"github.com/u-root/u-root/pkg/mount"
+	"github.com/u-root/u-root/pkg/mount/block"
+	"github.com/u-root/u-root/pkg/mount/loop"
+	"golang.org/x/sys/unix"
 )
 
 // FetchAndProbe fetches the file at the specified URL and checks if it is an
 // Image file type rather than a config such as ipxe.
-// TODO: detect nonFIT multiboot and bzImage Linux kernel files
+//
+// FetchAndProbe detects, in order, FIT images, Unified Kernel Images, Linux
+// kernels and ISO 9660 images with a GRUB or isolinux config.
+// TODO: detect nonFIT multiboot kernel files
 func FetchAndProbe(ctx context.Context, u *url.URL, s curl.Schemes) ([]boot.OSImage, error) {
 	file, err := s.Fetch(ctx, u)
 	if err != nil {
@@ -30,14 +43,114 @@ func FetchAndProbe(ctx context.Context, u *url.URL, s curl.Schemes) ([]boot.OSIm
 	fimgs, err := fit.ParseConfig(io.NewSectionReader(file, 0, math.MaxInt64))
 	if err == nil {
 		for i := range fimgs {
+			fimgs[i].File = file
 			images = append(images, &fimgs[i])
 		}
 	} else {
 		l.Printf("Parsing boot file as FIT image failed: %v", err)
 	}
+	if len(images) > 0 {
+		return images, nil
+	}
+
+	img, err := uki.Parse(file)
+	if err == nil {
+		return []boot.OSImage{img}, nil
+	}
+	l.Printf("Parsing boot file as Unified Kernel Image failed: %v", err)
+
+	if isKernel(file) {
+		return []boot.OSImage{&boot.LinuxImage{
+			Name:   path.Base(u.Path),
+			Kernel: file,
+		}}, nil
+	}
+
+	if isISO(file) {
+		images, err := probeISO(ctx, file)
+		if err != nil {
+			return nil, fmt.Errorf("parsing boot file as ISO image failed: %w", err)
+		}
+		return images, nil
+	}
+	return nil, fmt.Errorf("exhausted all supported simple file types")
+}
+
+// hasMagic returns whether r has magic at offset off.
+func hasMagic(r io.ReaderAt, off int64, magic string) bool {
+	b := make([]byte, len(magic))
+	if _, err := r.ReadAt(b, off); err != nil {
+		return false
+	}
+	return bytes.Equal(b, []byte(magic))
+}
+
+// isKernel returns whether r is a bzImage or the Image of arm64 or riscv
+// Linux kernels.
+func isKernel(r io.ReaderAt) bool {
+	// The x86 boot protocol header, with the boot sector signature.
+	if hasMagic(r, 0x1fe, "\x55\xaa") && hasMagic(r, 0x202, "HdrS") {
+		return true
+	}
+	return hasMagic(r, 0x38, "ARM\x64") || hasMagic(r, 0x38, "RSC\x05")
+}
+
+// isISO returns whether r is an ISO 9660 file system, whose first volume
+// descriptor begins at 32KiB.
+func isISO(r io.ReaderAt) bool {
+	return hasMagic(r, 0x8001, "CD001")
+}
+
+// probeISO copies the ISO image in r to a temporary file, mounts it with a
+// loop device and parses its GRUB or isolinux configs.
+//
+// The image stays mounted, since the images returned read their kernel and
+// initramfs from it.
+func probeISO(ctx context.Context, r io.ReaderAt) ([]boot.OSImage, error) {
+	f, err := os.CreateTemp("", "netboot-iso-")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, io.NewSectionReader(r, 0, math.MaxInt64)); err != nil {
+		os.Remove(f.Name())
+		return nil, err
+	}
 
+	dev, err := loop.New(f.Name(), "iso9660", "")
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, err
+	}
+	dir, err := os.MkdirTemp("", "netboot-iso-mount-")
+	if err != nil {
+		dev.Free()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	mp, err := dev.Mount(dir, unix.MS_RDONLY|unix.MS_NOATIME)
+	if err != nil {
+		os.RemoveAll(dir)
+		dev.Free()
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	images, err := grub.ParseLocalConfig(ctx, dir, block.BlockDevices{}, &mount.Pool{})
+	if err != nil {
+		l.Printf("Parsing GRUB config of ISO image failed: %v", err)
+	}
+	simages, err := syslinux.ParseLocalConfig(ctx, dir)
+	if err != nil {
+		l.Printf("Parsing isolinux config of ISO image failed: %v", err)
+	}
+	images = append(images, simages...)
 	if len(images) == 0 {
-		return nil, fmt.Errorf("exhausted all supported simple file types")
+		mp.Unmount(mount.MNT_DETACH)
+		os.RemoveAll(dir)
+		dev.Free()
+		os.Remove(f.Name())
+		return nil, fmt.Errorf("no GRUB or isolinux config found")
 	}
 	return images, nil
 }
@@ -0,0 +1,46 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package simple
+
+import (
+	"bytes"
+	"testing"
+)
+
+func withMagic(size int, magics map[int]string) *bytes.Reader {
+	b := make([]byte, size)
+	for off, magic := range magics {
+		copy(b[off:], magic)
+	}
+	return bytes.NewReader(b)
+}
+
+func TestProbe(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		magics map[int]string
+		size   int
+		kernel bool
+		iso    bool
+	}{
+		{name: "bzImage", size: 4096, magics: map[int]string{0x1fe: "\x55\xaa", 0x202: "HdrS"}, kernel: true},
+		{name: "boot sector only", size: 4096, magics: map[int]string{0x1fe: "\x55\xaa"}},
+		{name: "arm64 Image", size: 4096, magics: map[int]string{0x38: "ARM\x64"}, kernel: true},
+		{name: "riscv Image", size: 4096, magics: map[int]string{0x38: "RSC\x05"}, kernel: true},
+		{name: "iso9660", size: 0x9000, magics: map[int]string{0x8001: "CD001"}, iso: true},
+		{name: "truncated", size: 0x8002, magics: map[int]string{0x8001: "C"}},
+		{name: "empty", size: 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := withMagic(tt.size, tt.magics)
+			if got := isKernel(r); got != tt.kernel {
+				t.Errorf("isKernel() = %t, want %t", got, tt.kernel)
+			}
+			if got := isISO(r); got != tt.iso {
+				t.Errorf("isISO() = %t, want %t", got, tt.iso)
+			}
+		})
+	}
+}
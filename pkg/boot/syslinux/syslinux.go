@@ -7,8 +7,8 @@
 // See http://www.syslinux.org/wiki/index.php?title=Config for general syslinux
 // config features.
 //
-// Currently, only the APPEND, INCLUDE, KERNEL, LABEL, DEFAULT, and INITRD
-// directives are partially supported.
+// Currently, only the APPEND, INCLUDE, KERNEL, LABEL, DEFAULT, INITRD, FDT,
+// IPAPPEND and MENU directives are partially supported.
 package syslinux
 
 import (
@@ -16,9 +16,11 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/url"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/u-root/u-root/pkg/boot"
@@ -81,11 +83,38 @@ func ParseLocalConfig(ctx context.Context, diskDir string) ([]boot.OSImage, erro
 	return nil, fmt.Errorf("no valid syslinux config found on %s", diskDir)
 }
 
+// Network is the network configuration of a PXE client, which IPAPPEND
+// passes to the kernel.
+type Network struct {
+	// IP is the address and netmask of the client.
+	IP *net.IPNet
+
+	// Server is the address of the boot server.
+	Server net.IP
+
+	// Gateway is the default gateway of the client.
+	Gateway net.IP
+
+	// MAC is the hardware address of the boot interface.
+	MAC net.HardwareAddr
+}
+
+// ParseOption is an optional parameter to ParseConfigFile.
+type ParseOption func(*parser)
+
+// WithNetwork is a ParseOption that sets the network configuration used by
+// IPAPPEND directives. Without it, IPAPPEND is ignored.
+func WithNetwork(n *Network) ParseOption {
+	return func(p *parser) {
+		p.network = n
+	}
+}
+
 // ParseConfigFile parses a Syslinux configuration as specified in
 // http://www.syslinux.org/wiki/index.php?title=Config
 //
-// Currently, only the APPEND, INCLUDE, KERNEL, LABEL, DEFAULT, and INITRD
-// directives are partially supported.
+// Currently, only the APPEND, INCLUDE, KERNEL, LABEL, DEFAULT, INITRD, FDT,
+// IPAPPEND and MENU directives are partially supported.
 //
 // `s` is used to fetch any files that must be parsed or provided.
 //
@@ -98,12 +127,19 @@ func ParseLocalConfig(ctx context.Context, diskDir string) ([]boot.OSImage, erro
 // For PXE clients, rootdir will be the the URL without the path, and wd the
 // path component of the URL (e.g. rootdir = http://foobar.com, wd =
 // barfoo/pxelinux.cfg/).
-func ParseConfigFile(ctx context.Context, s curl.Schemes, configFile string, rootdir *url.URL, wd string) ([]boot.OSImage, error) {
+func ParseConfigFile(ctx context.Context, s curl.Schemes, configFile string, rootdir *url.URL, wd string, opts ...ParseOption) ([]boot.OSImage, error) {
 	p := newParser(rootdir, wd, s)
+	for _, opt := range opts {
+		opt(p)
+	}
 	if err := p.appendFile(ctx, configFile); err != nil {
 		return nil, err
 	}
 
+	for label, e := range p.linuxEntries {
+		e.Cmdline = p.ipAppend(e.Cmdline, p.ipAppendFlags[label])
+	}
+
 	// Assign the right label to display to users.
 	for label, displayLabel := range p.menuLabel {
 		if e, ok := p.linuxEntries[label]; ok {
@@ -117,14 +153,19 @@ func ParseConfigFile(ctx context.Context, s curl.Schemes, configFile string, roo
 	// Intended order:
 	//
 	// 1. nerfDefaultEntry
-	// 2. defaultEntry
-	// 3. labels in order they appeared in config
+	// 2. menuDefaultEntry
+	// 3. defaultEntry
+	// 4. labels in order they appeared in config
 	if len(p.labelOrder) == 0 {
 		return nil, nil
 	}
 	if len(p.defaultEntry) > 0 {
 		p.labelOrder = append([]string{p.defaultEntry}, p.labelOrder...)
 	}
+	// "MENU DEFAULT" overrides the DEFAULT directive in menus.
+	if len(p.menuDefaultEntry) > 0 {
+		p.labelOrder = append([]string{p.menuDefaultEntry}, p.labelOrder...)
+	}
 	if len(p.nerfDefaultEntry) > 0 {
 		p.labelOrder = append([]string{p.nerfDefaultEntry}, p.labelOrder...)
 	}
@@ -142,6 +183,36 @@ func ParseConfigFile(ctx context.Context, s curl.Schemes, configFile string, roo
 	return images, nil
 }
 
+// ipAppend returns cmdline with the network options selected by the
+// IPAPPEND flags, as described in
+// https://wiki.syslinux.org/wiki/index.php?title=SYSLINUX#IPAPPEND_flag_val_.5BPXELINUX_only.5D
+func (c *parser) ipAppend(cmdline string, flags int) string {
+	n := c.network
+	if n == nil {
+		return cmdline
+	}
+	var opts []string
+	if cmdline != "" {
+		opts = append(opts, cmdline)
+	}
+	if flags&1 != 0 && n.IP != nil {
+		opts = append(opts, fmt.Sprintf("ip=%s:%s:%s:%s", n.IP.IP, ipString(n.Server), ipString(n.Gateway), net.IP(n.IP.Mask)))
+	}
+	if flags&2 != 0 && n.MAC != nil {
+		// ARP hardware type 1 is Ethernet.
+		opts = append(opts, "BOOTIF=01-"+strings.ReplaceAll(n.MAC.String(), ":", "-"))
+	}
+	return strings.Join(opts, " ")
+}
+
+// ipString returns ip as a string, or 0.0.0.0 if it is not set.
+func ipString(ip net.IP) string {
+	if ip == nil {
+		return net.IPv4zero.String()
+	}
+	return ip.String()
+}
+
 func dedupStrings(list []string) []string {
 	var newList []string
 	seen := make(map[string]struct{})
@@ -166,15 +237,25 @@ type parser struct {
 	menuLabel map[string]string
 
 	defaultEntry     string
+	menuDefaultEntry string
 	nerfDefaultEntry string
 
+	// ipAppendFlags are the IPAPPEND flags of each label.
+	ipAppendFlags map[string]int
+	network       *Network
+
 	// parser internals.
-	globalAppend string
-	scope        scope
-	curEntry     string
-	wd           string
-	rootdir      *url.URL
-	schemes      curl.Schemes
+	globalAppend   string
+	globalIPAppend int
+	scope          scope
+	curEntry       string
+	inText         bool
+
+	// menuDepth is the number of submenus around the current line.
+	menuDepth int
+	wd        string
+	rootdir   *url.URL
+	schemes   curl.Schemes
 }
 
 type scope uint8
@@ -201,10 +282,23 @@ func newParser(rootdir *url.URL, wd string, s curl.Schemes) *parser {
 		rootdir:      rootdir,
 		schemes:      s,
 		menuLabel:    make(map[string]string),
+
+		ipAppendFlags: make(map[string]int),
 	}
 }
 
 func parseURL(name string, rootdir *url.URL, wd string) (*url.URL, error) {
+	// PXELINUX reads ::file from the root of the TFTP server, and
+	// host::file from another TFTP server.
+	//
+	// https://wiki.syslinux.org/wiki/index.php?title=PXELINUX#TFTP_servers
+	if host, file, ok := strings.Cut(name, "::"); ok && !strings.Contains(host, "/") {
+		if len(host) > 0 {
+			return &url.URL{Scheme: "tftp", Host: host, Path: path.Join("/", file)}, nil
+		}
+		name = path.Join("/", file)
+	}
+
 	u, err := url.Parse(name)
 	if err != nil {
 		return nil, fmt.Errorf("could not parse URL %q: %v", name, err)
@@ -280,12 +374,32 @@ func (c *parser) appendFile(ctx context.Context, url string) error {
 	return c.append(ctx, string(config))
 }
 
+// include parses the config file at `url`, ignoring files that do not exist.
+func (c *parser) include(ctx context.Context, url string) error {
+	if err := c.appendFile(ctx, url); curl.IsURLError(err) {
+		log.Printf("failed to parse %s: %v", url, err)
+		// Means we didn't find the file. Just ignore
+		// it.
+		// TODO(hugelgupf): plumb a logger through here.
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
 // Append parses `config` and adds the respective configuration to `c`.
 func (c *parser) append(ctx context.Context, config string) error {
 	// Here's a shitty parser.
 	for _, line := range strings.Split(config, "\n") {
 		// This is stupid. There should be a FieldsN(...).
 		kv := strings.Fields(line)
+
+		// Skip the help text between "text help" and "endtext".
+		if c.inText {
+			c.inText = len(kv) == 0 || !strings.EqualFold(kv[0], "endtext")
+			continue
+		}
 		if len(kv) <= 1 {
 			continue
 		}
@@ -305,16 +419,28 @@ func (c *parser) append(ctx context.Context, config string) error {
 			c.nerfDefaultEntry = arg
 
 		case "include":
-			if err := c.appendFile(ctx, arg); curl.IsURLError(err) {
-				log.Printf("failed to parse %s: %v", arg, err)
-				// Means we didn't find the file. Just ignore
-				// it.
-				// TODO(hugelgupf): plumb a logger through here.
-				continue
-			} else if err != nil {
+			if err := c.include(ctx, arg); err != nil {
 				return err
 			}
 
+		case "ipappend", "sysappend":
+			// SYSAPPEND is the newer name of IPAPPEND, whose
+			// flags it extends.
+			flags, err := strconv.Atoi(arg)
+			if err != nil {
+				log.Printf("invalid %s flags %q: %v", directive, arg, err)
+				continue
+			}
+			switch c.scope {
+			case scopeGlobal:
+				c.globalIPAppend = flags
+			case scopeEntry:
+				c.ipAppendFlags[c.curEntry] = flags
+			}
+
+		case "text":
+			c.inText = strings.EqualFold(arg, "help")
+
 		case "menu":
 			opt := strings.Fields(arg)
 			if len(opt) < 1 {
@@ -329,14 +455,44 @@ func (c *parser) append(ctx context.Context, config string) error {
 				// We track these separately because "menu
 				// label" directives may happen before we know
 				// whether this is a Linux or Multiboot entry.
-				c.menuLabel[c.curEntry] = strings.Join(opt[1:], " ")
+				//
+				// A ^ marks the hotkey of the entry.
+				if len(c.curEntry) > 0 {
+					c.menuLabel[c.curEntry] = strings.Replace(strings.Join(opt[1:], " "), "^", "", 1)
+				}
 
 			case "default":
 				// Are we in label scope?
 				//
 				// "Only valid after a LABEL statement" -syslinux wiki.
-				if c.scope == scopeEntry {
-					c.defaultEntry = c.curEntry
+				//
+				// In a submenu, it only selects the default
+				// of that submenu.
+				if c.scope == scopeEntry && len(c.curEntry) > 0 && c.menuDepth == 0 {
+					c.menuDefaultEntry = c.curEntry
+				}
+
+			case "begin":
+				// Submenus are flattened into the main menu,
+				// but the "menu label" of a submenu does not
+				// belong to the label before it.
+				c.curEntry = ""
+				c.menuDepth++
+
+			case "end":
+				c.curEntry = ""
+				if c.menuDepth > 0 {
+					c.menuDepth--
+				}
+
+			case "include":
+				// The labels of "menu include file tag" go
+				// into a submenu, which is flattened too.
+				if len(opt) < 2 {
+					continue
+				}
+				if err := c.include(ctx, opt[1]); err != nil {
+					return err
 				}
 			}
 
@@ -348,6 +504,7 @@ func (c *parser) append(ctx context.Context, config string) error {
 				Cmdline: c.globalAppend,
 				Name:    c.curEntry,
 			}
+			c.ipAppendFlags[c.curEntry] = c.globalIPAppend
 			c.labelOrder = append(c.labelOrder, c.curEntry)
 
 		case "kernel":
@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -563,6 +564,94 @@ func TestParseGeneral(t *testing.T) {
 				},
 			},
 		},
+		{
+			desc: "menu default overrides default",
+			configFiles: map[string]string{
+				"/foobar/pxelinux.cfg/default": `
+					default foo
+					label foo
+					kernel ./pxefiles/kernel1
+					label bar
+					menu default
+					kernel ./pxefiles/kernel2`,
+			},
+			want: []boot.OSImage{
+				&boot.LinuxImage{
+					Name:   "bar",
+					Kernel: strings.NewReader(kernel2),
+				},
+				&boot.LinuxImage{
+					Name:   "foo",
+					Kernel: strings.NewReader(kernel1),
+				},
+			},
+		},
+		{
+			desc: "menu labels with hotkeys, submenus and help text",
+			configFiles: map[string]string{
+				"/foobar/pxelinux.cfg/default": `
+					label foo
+					menu label ^Foo
+					kernel ./pxefiles/kernel1
+					text help
+					  append not=this
+					endtext
+					menu begin advanced
+					menu label ^Advanced options
+					label bar
+					menu default
+					kernel ./pxefiles/kernel2
+					menu end`,
+			},
+			want: []boot.OSImage{
+				&boot.LinuxImage{
+					Name:   "Foo",
+					Kernel: strings.NewReader(kernel1),
+				},
+				&boot.LinuxImage{
+					Name:   "bar",
+					Kernel: strings.NewReader(kernel2),
+				},
+			},
+		},
+		{
+			desc: "menu include",
+			configFiles: map[string]string{
+				"/foobar/pxelinux.cfg/default": `
+					menu include pxelinux.cfg/other sub
+					label bar
+					kernel ./pxefiles/kernel2`,
+				"/foobar/pxelinux.cfg/other": `
+					label foo
+					kernel ./pxefiles/kernel1`,
+			},
+			want: []boot.OSImage{
+				&boot.LinuxImage{
+					Name:   "foo",
+					Kernel: strings.NewReader(kernel1),
+				},
+				&boot.LinuxImage{
+					Name:   "bar",
+					Kernel: strings.NewReader(kernel2),
+				},
+			},
+		},
+		{
+			desc: "paths relative to the TFTP root and server",
+			configFiles: map[string]string{
+				"/foobar/pxelinux.cfg/default": `
+					label foo
+					kernel 2.3.4.5::/barfoo/pxefiles/kernel1
+					initrd ::foobar/pxefiles/initrd1`,
+			},
+			want: []boot.OSImage{
+				&boot.LinuxImage{
+					Name:   "foo",
+					Kernel: strings.NewReader(kernel1),
+					Initrd: strings.NewReader(initrd1),
+				},
+			},
+		},
 	} {
 		t.Run(fmt.Sprintf("Test [%02d] %s", i, tt.desc), func(t *testing.T) {
 			fs := newMockScheme()
@@ -664,6 +753,53 @@ func TestParseCorner(t *testing.T) {
 	}
 }
 
+func TestIPAppend(t *testing.T) {
+	fs := curl.NewMockScheme("tftp")
+	fs.Add("1.2.3.4", "/foobar/pxefiles/kernel1", "kernel1")
+	fs.Add("1.2.3.4", "/foobar/pxelinux.cfg/default", `
+		ipappend 2
+		append console=ttyS0
+		label foo
+		kernel ./pxefiles/kernel1
+		label bar
+		kernel ./pxefiles/kernel1
+		ipappend 3
+		label baz
+		kernel ./pxefiles/kernel1
+		append -
+		ipappend 0`)
+	s := make(curl.Schemes)
+	s.Register(fs.Scheme, fs)
+
+	n := &Network{
+		IP: &net.IPNet{
+			IP:   net.IP{192, 168, 0, 2},
+			Mask: net.IPv4Mask(255, 255, 255, 0),
+		},
+		Server: net.IP{192, 168, 0, 1},
+		MAC:    net.HardwareAddr{0x52, 0x54, 0x00, 0x12, 0x34, 0x56},
+	}
+	rootdir := &url.URL{Scheme: "tftp", Host: "1.2.3.4", Path: "/"}
+	got, err := ParseConfigFile(context.Background(), s, "pxelinux.cfg/default", rootdir, "foobar", WithNetwork(n))
+	if err != nil {
+		t.Fatalf("ParseConfigFile() = %v", err)
+	}
+
+	want := []string{
+		"console=ttyS0 BOOTIF=01-52-54-00-12-34-56",
+		"console=ttyS0 ip=192.168.0.2:192.168.0.1:0.0.0.0:255.255.255.0 BOOTIF=01-52-54-00-12-34-56",
+		"",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("ParseConfigFile yielded %d images, want %d images", len(got), len(want))
+	}
+	for i, img := range got {
+		if cmdline := img.(*boot.LinuxImage).Cmdline; cmdline != want[i] {
+			t.Errorf("image %d cmdline = %q, want %q", i, cmdline, want[i])
+		}
+	}
+}
+
 func TestParseURL(t *testing.T) {
 	for _, tt := range []struct {
 		filename string
@@ -694,6 +830,18 @@ func TestParseURL(t *testing.T) {
 			rootdir:  nil,
 			want:     mustParseURL("http://[2002::2]/blabla"),
 		},
+		{
+			filename: "::foobar",
+			rootdir:  mustParseURL("tftp://1.2.3.4/files"),
+			wd:       "more",
+			want:     mustParseURL("tftp://1.2.3.4/files/foobar"),
+		},
+		{
+			filename: "2.3.4.5::foobar",
+			rootdir:  mustParseURL("http://1.2.3.4/files"),
+			wd:       "more",
+			want:     mustParseURL("tftp://2.3.4.5/foobar"),
+		},
 	} {
 		got, err := parseURL(tt.filename, tt.rootdir, tt.wd)
 		if err != nil {
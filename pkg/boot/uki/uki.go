@@ -0,0 +1,229 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package uki reads and boots Unified Kernel Images, EFI applications that
+// bundle a Linux kernel with its initrd, command line and os-release, as
+// specified in
+// https://uapi-group.org/specifications/specs/unified_kernel_image/.
+package uki
+
+import (
+	"bufio"
+	"debug/pe"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/boot"
+)
+
+var (
+	// ErrNotUKI is returned for files that are not Unified Kernel Images.
+	ErrNotUKI = errors.New("not a unified kernel image")
+
+	// ErrUnverified is returned by Load for images with VerifySignature
+	// that cannot be verified as a whole.
+	ErrUnverified = errors.New("the sections of the UKI cannot be verified without a boot.Verifier")
+)
+
+// The PE sections of a UKI.
+const (
+	sectionLinux     = ".linux"
+	sectionInitrd    = ".initrd"
+	sectionCmdline   = ".cmdline"
+	sectionOSRelease = ".osrel"
+	sectionUname     = ".uname"
+	sectionDTB       = ".dtb"
+)
+
+// Image is a Unified Kernel Image.
+type Image struct {
+	Name string
+
+	// Kernel, Initrd and DTB are the contents of the .linux, .initrd and
+	// .dtb sections. Initrd and DTB are nil if the UKI has none.
+	Kernel io.ReaderAt
+	Initrd io.ReaderAt
+	DTB    io.ReaderAt
+
+	// Cmdline is the command line of the .cmdline section.
+	Cmdline string
+
+	// OSRelease are the os-release fields of the .osrel section, e.g.
+	// PRETTY_NAME and VERSION_ID.
+	OSRelease map[string]string
+
+	// Uname is the kernel release of the .uname section.
+	Uname string
+
+	BootRank    int
+	LoadSyscall bool
+
+	// VerifySignature loads the UKI only if the boot.Verifier given to
+	// Load verifies it as a whole, e.g. its Authenticode signature with a
+	// bootpolicy authenticode rule, and the running kernel verifies the
+	// signature of its kernel, as boot.LinuxImage.VerifySignature does.
+	// The running kernel does not verify the initrd and command line,
+	// so Load fails with ErrUnverified without a boot.Verifier.
+	VerifySignature bool
+
+	// uki is the whole image.
+	uki io.ReaderAt
+}
+
+var _ boot.OSImage = &Image{}
+
+// section returns the contents of the section name of f, or nil if it has
+// none. The file alignment padding of the section is not part of them.
+func section(f *pe.File, r io.ReaderAt, name string) *io.SectionReader {
+	s := f.Section(name)
+	if s == nil {
+		return nil
+	}
+	size := s.Size
+	if s.VirtualSize != 0 && s.VirtualSize < size {
+		size = s.VirtualSize
+	}
+	return io.NewSectionReader(r, int64(s.Offset), int64(size))
+}
+
+// text returns the text of the section name of f, without the NUL bytes and
+// white space around it.
+func text(f *pe.File, r io.ReaderAt, name string) (string, error) {
+	s := section(f, r, name)
+	if s == nil {
+		return "", nil
+	}
+	b, err := io.ReadAll(s)
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %w", name, err)
+	}
+	return strings.TrimSpace(strings.TrimRight(string(b), "\x00")), nil
+}
+
+// Parse parses the UKI r. The sections of the returned Image read from r.
+func Parse(r io.ReaderAt) (*Image, error) {
+	f, err := pe.NewFile(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotUKI, err)
+	}
+	kernel := section(f, r, sectionLinux)
+	if kernel == nil {
+		return nil, fmt.Errorf("%w: no %s section", ErrNotUKI, sectionLinux)
+	}
+	img := &Image{Kernel: kernel, uki: r}
+	// A nil *io.SectionReader is not a nil io.ReaderAt.
+	if s := section(f, r, sectionInitrd); s != nil {
+		img.Initrd = s
+	}
+	if s := section(f, r, sectionDTB); s != nil {
+		img.DTB = s
+	}
+	if img.Cmdline, err = text(f, r, sectionCmdline); err != nil {
+		return nil, err
+	}
+	if img.Uname, err = text(f, r, sectionUname); err != nil {
+		return nil, err
+	}
+	osrel, err := text(f, r, sectionOSRelease)
+	if err != nil {
+		return nil, err
+	}
+	img.OSRelease = parseOSRelease(osrel)
+	return img, nil
+}
+
+// parseOSRelease parses the KEY=VALUE lines of os-release, as described in
+// os-release(5). Values may be quoted.
+func parseOSRelease(s string) map[string]string {
+	m := make(map[string]string)
+	sc := bufio.NewScanner(strings.NewReader(s))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		switch {
+		case len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"':
+			if u, err := strconv.Unquote(v); err == nil {
+				v = u
+			} else {
+				v = v[1 : len(v)-1]
+			}
+		case len(v) >= 2 && v[0] == '\'' && v[len(v)-1] == '\'':
+			v = v[1 : len(v)-1]
+		}
+		m[k] = v
+	}
+	return m
+}
+
+// Label returns the Name, or else the PRETTY_NAME and kernel release of the
+// UKI.
+func (i *Image) Label() string {
+	if i.Name != "" {
+		return i.Name
+	}
+	name := i.OSRelease["PRETTY_NAME"]
+	if name == "" {
+		name = i.OSRelease["NAME"]
+	}
+	if name == "" {
+		name = "Unified Kernel Image"
+	}
+	if i.Uname != "" {
+		return fmt.Sprintf("%s (%s)", name, i.Uname)
+	}
+	return name
+}
+
+// Rank returns the priority of the image in the boot menu.
+func (i *Image) Rank() int {
+	return i.BootRank
+}
+
+// Edit edits the kernel command line.
+func (i *Image) Edit(f func(cmdline string) string) {
+	i.Cmdline = f(i.Cmdline)
+}
+
+// String implements fmt.Stringer.
+func (i *Image) String() string {
+	return fmt.Sprintf("UKI(\n  Name: %s\n  OS: %s\n  Uname: %s\n  Initrd: %t\n  DTB: %t\n  Cmdline: %s\n)",
+		i.Name, i.OSRelease["PRETTY_NAME"], i.Uname, i.Initrd != nil, i.DTB != nil, i.Cmdline)
+}
+
+// loadImage is mocked in tests.
+var loadImage = func(li *boot.LinuxImage, opts ...boot.LoadOption) error {
+	return li.Load(opts...)
+}
+
+// Load loads the kernel, initrd and command line of the UKI.
+func (i *Image) Load(opts ...boot.LoadOption) error {
+	if i.VerifySignature && (i.uki == nil || !boot.HasVerifier(opts...)) {
+		return fmt.Errorf("UKI: %w", ErrUnverified)
+	}
+	if i.uki != nil {
+		// The UKI is verified as a whole, not the sections in it.
+		if err := boot.VerifyAsset(i.uki, opts...); err != nil {
+			return fmt.Errorf("UKI: %w", err)
+		}
+		opts = append(opts, boot.WithVerifier(nil))
+	}
+	return loadImage(&boot.LinuxImage{
+		Name:            i.Label(),
+		Kernel:          i.Kernel,
+		Initrd:          i.Initrd,
+		Cmdline:         i.Cmdline,
+		DTB:             i.DTB,
+		LoadSyscall:     i.LoadSyscall,
+		VerifySignature: i.VerifySignature,
+	}, opts...)
+}
@@ -0,0 +1,238 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uki
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/boot"
+)
+
+type testSection struct {
+	name string
+	data string
+}
+
+// testUKI returns a PE image with sections, whose raw data is padded to
+// the file alignment.
+func testUKI(t *testing.T, sections ...testSection) []byte {
+	t.Helper()
+	const fileAlign = 0x200
+	var b bytes.Buffer
+	dos := make([]byte, 0x40)
+	copy(dos, "MZ")
+	binary.LittleEndian.PutUint32(dos[0x3c:], 0x40)
+	b.Write(dos)
+	b.WriteString("PE\x00\x00")
+	oh := pe.OptionalHeader64{Magic: 0x20b, NumberOfRvaAndSizes: 16, FileAlignment: fileAlign}
+	fh := pe.FileHeader{
+		Machine:              pe.IMAGE_FILE_MACHINE_AMD64,
+		NumberOfSections:     uint16(len(sections)),
+		SizeOfOptionalHeader: uint16(binary.Size(oh)),
+	}
+	if err := binary.Write(&b, binary.LittleEndian, fh); err != nil {
+		t.Fatal(err)
+	}
+	if err := binary.Write(&b, binary.LittleEndian, oh); err != nil {
+		t.Fatal(err)
+	}
+
+	var data []byte
+	headers := (uint32(b.Len()+len(sections)*binary.Size(pe.SectionHeader32{})) + fileAlign - 1) &^ (fileAlign - 1)
+	offset := headers
+	for i, s := range sections {
+		raw := (uint32(len(s.data)) + fileAlign - 1) &^ (fileAlign - 1)
+		sh := pe.SectionHeader32{
+			VirtualSize:      uint32(len(s.data)),
+			VirtualAddress:   uint32(0x1000 * (i + 1)),
+			SizeOfRawData:    raw,
+			PointerToRawData: offset,
+		}
+		copy(sh.Name[:], s.name)
+		if err := binary.Write(&b, binary.LittleEndian, sh); err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, s.data...)
+		data = append(data, make([]byte, raw-uint32(len(s.data)))...)
+		offset += raw
+	}
+	b.Write(make([]byte, int(headers)-b.Len()))
+	b.Write(data)
+	return b.Bytes()
+}
+
+func readAll(t *testing.T, r io.ReaderAt) string {
+	t.Helper()
+	if r == nil {
+		return ""
+	}
+	b, err := io.ReadAll(io.NewSectionReader(r, 0, 1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+const osRelease = `NAME="Fedora Linux"
+VERSION_ID=40
+# A comment.
+PRETTY_NAME="Fedora Linux 40 (Forty)"
+VARIANT='Server Edition'
+HOME_URL="https://fedoraproject.org/"
+`
+
+func TestParse(t *testing.T) {
+	img, err := Parse(bytes.NewReader(testUKI(t,
+		testSection{".text", "stub"},
+		testSection{".osrel", osRelease},
+		testSection{".cmdline", "root=/dev/sda1 ro\x00"},
+		testSection{".uname", "6.8.5-301.fc40.x86_64\n"},
+		testSection{".linux", "bzImage"},
+		testSection{".initrd", "initramfs"},
+	)))
+	if err != nil {
+		t.Fatalf("Parse() = %v", err)
+	}
+	if got := readAll(t, img.Kernel); got != "bzImage" {
+		t.Errorf("Kernel = %q, want %q", got, "bzImage")
+	}
+	if got := readAll(t, img.Initrd); got != "initramfs" {
+		t.Errorf("Initrd = %q, want %q", got, "initramfs")
+	}
+	if img.DTB != nil {
+		t.Errorf("DTB = %v, want nil", img.DTB)
+	}
+	if want := "root=/dev/sda1 ro"; img.Cmdline != want {
+		t.Errorf("Cmdline = %q, want %q", img.Cmdline, want)
+	}
+	if want := "6.8.5-301.fc40.x86_64"; img.Uname != want {
+		t.Errorf("Uname = %q, want %q", img.Uname, want)
+	}
+	wantOS := map[string]string{
+		"NAME":        "Fedora Linux",
+		"VERSION_ID":  "40",
+		"PRETTY_NAME": "Fedora Linux 40 (Forty)",
+		"VARIANT":     "Server Edition",
+		"HOME_URL":    "https://fedoraproject.org/",
+	}
+	if !reflect.DeepEqual(img.OSRelease, wantOS) {
+		t.Errorf("OSRelease = %v, want %v", img.OSRelease, wantOS)
+	}
+	if want := "Fedora Linux 40 (Forty) (6.8.5-301.fc40.x86_64)"; img.Label() != want {
+		t.Errorf("Label() = %q, want %q", img.Label(), want)
+	}
+}
+
+func TestParseMinimal(t *testing.T) {
+	img, err := Parse(bytes.NewReader(testUKI(t, testSection{".linux", "bzImage"})))
+	if err != nil {
+		t.Fatalf("Parse() = %v", err)
+	}
+	if img.Initrd != nil || img.DTB != nil || img.Cmdline != "" || len(img.OSRelease) != 0 {
+		t.Errorf("Parse() = %v, want only a kernel", img)
+	}
+	if want := "Unified Kernel Image"; img.Label() != want {
+		t.Errorf("Label() = %q, want %q", img.Label(), want)
+	}
+}
+
+func TestParseNotUKI(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		image []byte
+	}{
+		{name: "not PE", image: []byte("bzImage")},
+		{name: "no .linux", image: testUKI(t, testSection{".text", "grub"})},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(bytes.NewReader(tt.image)); !errors.Is(err, ErrNotUKI) {
+				t.Errorf("Parse() = %v, want %v", err, ErrNotUKI)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	defer func(f func(*boot.LinuxImage, ...boot.LoadOption) error) {
+		loadImage = f
+	}(loadImage)
+	var got *boot.LinuxImage
+	loadImage = func(li *boot.LinuxImage, _ ...boot.LoadOption) error {
+		got = li
+		return nil
+	}
+
+	img, err := Parse(bytes.NewReader(testUKI(t,
+		testSection{".osrel", osRelease},
+		testSection{".cmdline", "root=/dev/sda1 ro"},
+		testSection{".linux", "bzImage"},
+		testSection{".initrd", "initramfs"},
+		testSection{".dtb", "fdt"},
+	)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img.Edit(func(cmdline string) string {
+		return cmdline + " quiet"
+	})
+	if err := img.Load(); err != nil {
+		t.Fatalf("Load() = %v", err)
+	}
+	if got.Name != "Fedora Linux 40 (Forty)" || got.Cmdline != "root=/dev/sda1 ro quiet" || got.VerifySignature {
+		t.Errorf("Load() loaded %v", got)
+	}
+	for _, s := range []struct {
+		name string
+		r    io.ReaderAt
+		want string
+	}{
+		{"kernel", got.Kernel, "bzImage"},
+		{"initrd", got.Initrd, "initramfs"},
+		{"dtb", got.DTB, "fdt"},
+	} {
+		if b := readAll(t, s.r); b != s.want {
+			t.Errorf("loaded %s = %q, want %q", s.name, b, s.want)
+		}
+	}
+
+	// Without a verifier, the sections cannot be trusted.
+	img.VerifySignature = true
+	got = nil
+	if err := img.Load(); !errors.Is(err, ErrUnverified) || got != nil {
+		t.Errorf("Load() with VerifySignature and no verifier = %v, want %v", err, ErrUnverified)
+	}
+
+	// The whole UKI is verified before any section is used.
+	v := &fakeVerifier{err: errBadSignature}
+	if err := img.Load(boot.WithVerifier(v)); !errors.Is(err, errBadSignature) || got != nil {
+		t.Errorf("Load() of a UKI that does not verify = %v, want %v", err, errBadSignature)
+	}
+	v.err = nil
+	if err := img.Load(boot.WithVerifier(v)); err != nil || got == nil || !got.VerifySignature {
+		t.Errorf("Load() of a verified UKI = %v, loaded %v", err, got)
+	}
+	if len(v.verified) != 2 || v.verified[1] != img.uki {
+		t.Errorf("verified %v, want the whole UKI", v.verified)
+	}
+}
+
+var errBadSignature = errors.New("bad signature")
+
+// fakeVerifier records the assets it verifies, failing with err.
+type fakeVerifier struct {
+	err      error
+	verified []io.ReaderAt
+}
+
+func (v *fakeVerifier) Verify(_ string, asset io.ReaderAt) error {
+	v.verified = append(v.verified, asset)
+	return v.err
+}
@@ -9,6 +9,9 @@ import (
 	"compress/gzip"
 	"io"
 
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/therootcompany/xz"
 	"github.com/u-root/uio/uio"
 )
 
@@ -21,10 +24,66 @@ func readGzip(r io.Reader) ([]byte, error) {
 	return io.ReadAll(z)
 }
 
+func readZstd(r io.Reader) ([]byte, error) {
+	z, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer z.Close()
+	return io.ReadAll(z)
+}
+
+func readLz4(r io.Reader) ([]byte, error) {
+	return io.ReadAll(lz4.NewReader(r))
+}
+
+func readXz(r io.Reader) ([]byte, error) {
+	// therootcompany/xz, unlike ulikunitz/xz, has the BCJ filters that
+	// the kernel's xz images use.
+	z, err := xz.NewReader(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(z)
+}
+
+// decompressors are the compression formats TryDecompressFilter detects by
+// the magic bytes at the start of their data.
+var decompressors = []struct {
+	magic []byte
+	read  func(io.Reader) ([]byte, error)
+}{
+	{magic: []byte{0x1f, 0x8b}, read: readGzip},
+	{magic: []byte{0x28, 0xb5, 0x2f, 0xfd}, read: readZstd},
+	{magic: []byte{0x04, 0x22, 0x4d, 0x18}, read: readLz4},
+	// The legacy lz4 frame of lz4 -l, which the kernel's lz4 images use.
+	{magic: []byte{0x02, 0x21, 0x4c, 0x18}, read: readLz4},
+	{magic: []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, read: readXz},
+}
+
+// TryDecompressFilter detects gzip, zstd, lz4 and xz data in an io.ReaderAt by
+// its magic bytes and returns the decompressed data. If the data is not
+// compressed in one of these formats or fails to decompress, the io.ReaderAt
+// is returned.
+func TryDecompressFilter(r io.ReaderAt) io.ReaderAt {
+	magic := make([]byte, 6)
+	n, _ := r.ReadAt(magic, 0)
+	for _, d := range decompressors {
+		if !bytes.HasPrefix(magic[:n], d.magic) {
+			continue
+		}
+		if b, err := d.read(uio.Reader(r)); err == nil {
+			return bytes.NewReader(b)
+		}
+		return r
+	}
+	return r
+}
+
 // TryGzipFilter tries to read from an io.ReaderAt to see if it is a Gzip. If it is not, the
 // io.ReaderAt is returned.
-// TODO: could be tryCompressionFilters, grub support gz,xz and lzop
-// TODO: do we want to keep the filter inside multiboot? This could be the responsibility of the caller...
+//
+// Deprecated: use TryDecompressFilter, which also detects zstd, lz4 and xz.
 func TryGzipFilter(r io.ReaderAt) io.ReaderAt {
 	b, err := readGzip(uio.Reader(r))
 	if err == nil {
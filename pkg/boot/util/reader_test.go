@@ -0,0 +1,89 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package util
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/u-root/uio/uio"
+	"github.com/ulikunitz/xz"
+)
+
+var content = []byte(strings.Repeat("not actually a kernel\n", 100))
+
+func compress(t *testing.T, newWriter func(io.Writer) (io.WriteCloser, error)) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	w, err := newWriter(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestTryDecompressFilter(t *testing.T) {
+	gz := compress(t, func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil })
+	for _, tt := range []struct {
+		name string
+		in   []byte
+		want []byte
+	}{
+		{name: "plain", in: content, want: content},
+		{name: "short", in: []byte{0x1f}, want: []byte{0x1f}},
+		{name: "gzip", in: gz, want: content},
+		{
+			name: "zstd",
+			in: compress(t, func(w io.Writer) (io.WriteCloser, error) {
+				return zstd.NewWriter(w)
+			}),
+			want: content,
+		},
+		{
+			name: "lz4",
+			in: compress(t, func(w io.Writer) (io.WriteCloser, error) {
+				return lz4.NewWriter(w), nil
+			}),
+			want: content,
+		},
+		{
+			name: "lz4 legacy",
+			in: compress(t, func(w io.Writer) (io.WriteCloser, error) {
+				z := lz4.NewWriter(w)
+				return z, z.Apply(lz4.LegacyOption(true))
+			}),
+			want: content,
+		},
+		{
+			name: "xz",
+			in: compress(t, func(w io.Writer) (io.WriteCloser, error) {
+				return xz.NewWriter(w)
+			}),
+			want: content,
+		},
+		{name: "corrupt", in: gz[:len(gz)/2], want: gz[:len(gz)/2]},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := uio.ReadAll(TryDecompressFilter(bytes.NewReader(tt.in)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("TryDecompressFilter() = %d bytes %q..., want %d bytes", len(got), got[:min(len(got), 16)], len(tt.want))
+			}
+		})
+	}
+}
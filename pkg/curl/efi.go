@@ -0,0 +1,62 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package curl
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var errSignatureList = errors.New("invalid EFI signature list")
+
+// efiCertX509 is EFI_CERT_X509_GUID, the type of signature lists of DER
+// certificates, in the mixed-endian encoding of EFI.
+var efiCertX509 = []byte{0xa1, 0x59, 0xc0, 0xa5, 0xe4, 0x94, 0xa7, 0x4a, 0x87, 0xb5, 0xab, 0x15, 0x5c, 0x2b, 0xf0, 0x72}
+
+// firmwareCAs returns the CAs that firmware setup provisioned for UEFI
+// HTTPS Boot.
+func firmwareCAs() ([]*x509.Certificate, error) {
+	b, err := readTLSCAVar()
+	if err != nil {
+		return nil, fmt.Errorf("reading the TlsCaCertificate EFI variable: %w", err)
+	}
+	return parseSignatureLists(b)
+}
+
+// parseSignatureLists returns the certificates of EFI_SIGNATURE_LISTs, as
+// described in the UEFI Specification 2.10 Section 32.4.1. Lists of other
+// signature types are skipped.
+func parseSignatureLists(b []byte) ([]*x509.Certificate, error) {
+	const (
+		listHeaderSize = 28
+		ownerSize      = 16
+	)
+	var certs []*x509.Certificate
+	for len(b) > 0 {
+		if len(b) < listHeaderSize {
+			return nil, fmt.Errorf("%w: %d trailing bytes", errSignatureList, len(b))
+		}
+		listSize := uint64(binary.LittleEndian.Uint32(b[16:]))
+		headerSize := uint64(binary.LittleEndian.Uint32(b[20:]))
+		sigSize := uint64(binary.LittleEndian.Uint32(b[24:]))
+		if listSize > uint64(len(b)) || listHeaderSize+headerSize > listSize || sigSize <= ownerSize || (listSize-listHeaderSize-headerSize)%sigSize != 0 {
+			return nil, fmt.Errorf("%w: size %d, header size %d, signature size %d", errSignatureList, listSize, headerSize, sigSize)
+		}
+		if bytes.Equal(b[:16], efiCertX509) {
+			for sigs := b[listHeaderSize+headerSize : listSize]; len(sigs) > 0; sigs = sigs[sigSize:] {
+				cert, err := x509.ParseCertificate(sigs[ownerSize:sigSize])
+				if err != nil {
+					return nil, fmt.Errorf("%w: %v", errSignatureList, err)
+				}
+				certs = append(certs, cert)
+			}
+		}
+		b = b[listSize:]
+	}
+	return certs, nil
+}
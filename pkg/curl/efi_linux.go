@@ -0,0 +1,27 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package curl
+
+import (
+	guid "github.com/google/uuid"
+	"github.com/u-root/u-root/pkg/efivarfs"
+)
+
+// tlsCAVar is the EFI variable of the CAs of HTTPS Boot.
+var tlsCAVar = efivarfs.VariableDescriptor{
+	Name: "TlsCaCertificate",
+	GUID: guid.MustParse("fd2340d0-3dab-4349-a6c7-3b4f12b48eae"),
+}
+
+// readTLSCAVar returns the TlsCaCertificate EFI variable, it is replaced in
+// tests.
+var readTLSCAVar = func() ([]byte, error) {
+	e, err := efivarfs.New()
+	if err != nil {
+		return nil, err
+	}
+	_, data, err := efivarfs.ReadVariable(e, tlsCAVar)
+	return data, err
+}
@@ -0,0 +1,15 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package curl
+
+import "errors"
+
+// readTLSCAVar returns the TlsCaCertificate EFI variable, which is only read
+// on Linux.
+var readTLSCAVar = func() ([]byte, error) {
+	return nil, errors.ErrUnsupported
+}
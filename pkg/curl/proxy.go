@@ -0,0 +1,222 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package curl
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+var errProxy = errors.New("invalid proxy")
+
+// ProxyOptions are the proxy HTTP fetches go through, for networks that
+// only reach the internet through one.
+type ProxyOptions struct {
+	// URL is the proxy of all requests: http://[USER:PASSWORD@]HOST[:PORT]
+	// for an HTTP proxy, which HTTPS requests CONNECT through, or
+	// socks5://[USER:PASSWORD@]HOST[:PORT] for a SOCKS5 proxy. Without a
+	// scheme, it is an HTTP proxy. If empty, the proxies are those of the
+	// environment, as with ProxyFromEnvironment.
+	URL string
+
+	// NoProxy are the hosts reached directly, in the syntax of NO_PROXY,
+	// which it replaces if not empty.
+	NoProxy string
+
+	// User and Password authenticate to proxies whose URLs have no
+	// credentials, basic authentication for HTTP proxies.
+	User     string
+	Password string
+}
+
+// proxies are the proxies of the schemes of requests.
+type proxies struct {
+	http, https, all *url.URL
+	noProxy          string
+	// user authenticates to proxies without credentials if not nil.
+	user *url.Userinfo
+}
+
+// parseProxy parses the URL of a proxy, an HTTP one if it has no scheme.
+func parseProxy(s string) (*url.URL, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if !strings.Contains(s, "://") {
+		s = "http://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errProxy, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("%w: unsupported scheme %q", errProxy, u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("%w: %q has no host", errProxy, s)
+	}
+	return u, nil
+}
+
+// getenv returns the variable name, in lower case or else in upper case, as
+// curl does.
+func getenv(name string) string {
+	if v := os.Getenv(strings.ToLower(name)); v != "" {
+		return v
+	}
+	return os.Getenv(name)
+}
+
+func proxiesFromEnvironment() (*proxies, error) {
+	p := &proxies{noProxy: getenv("NO_PROXY")}
+	for _, v := range []struct {
+		name string
+		u    **url.URL
+	}{
+		{"HTTP_PROXY", &p.http},
+		{"HTTPS_PROXY", &p.https},
+		{"ALL_PROXY", &p.all},
+	} {
+		u, err := parseProxy(getenv(v.name))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", v.name, err)
+		}
+		*v.u = u
+	}
+	return p, nil
+}
+
+// noProxy returns whether host, of the form HOST[:PORT], matches one of the
+// comma separated patterns of list: * for all hosts, a domain for it and
+// its subdomains, an IP address or a CIDR prefix, each with an optional
+// port.
+func noProxy(list string, host string) bool {
+	name, port, err := net.SplitHostPort(host)
+	if err != nil {
+		name = host
+	}
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	ip := net.ParseIP(name)
+	for _, p := range strings.Split(list, ",") {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p == "*" {
+			return true
+		}
+		if _, n, err := net.ParseCIDR(p); err == nil {
+			if ip != nil && n.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if pn, pp, err := net.SplitHostPort(p); err == nil {
+			if pp != port {
+				continue
+			}
+			p = pn
+		}
+		p = strings.TrimPrefix(strings.Trim(p, "[]"), ".")
+		switch {
+		case p == "":
+		case ip != nil:
+			if pip := net.ParseIP(p); pip != nil && pip.Equal(ip) {
+				return true
+			}
+		case name == p || strings.HasSuffix(name, "."+p):
+			return true
+		}
+	}
+	return false
+}
+
+// loopback returns whether host, of the form HOST[:PORT], is this machine.
+func loopback(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.IsLoopback()
+	}
+	return strings.EqualFold(host, "localhost")
+}
+
+func (p *proxies) proxy(req *http.Request) (*url.URL, error) {
+	var u *url.URL
+	switch req.URL.Scheme {
+	case "http":
+		u = p.http
+	case "https":
+		u = p.https
+	}
+	if u == nil {
+		u = p.all
+	}
+	if u == nil || loopback(req.URL.Host) || noProxy(p.noProxy, req.URL.Host) {
+		return nil, nil
+	}
+	if u.User == nil && p.user != nil {
+		withUser := *u
+		withUser.User = p.user
+		return &withUser, nil
+	}
+	return u, nil
+}
+
+// ProxyFromEnvironment returns the proxy of req from the environment, as
+// curl and wget do: HTTP_PROXY for http requests, HTTPS_PROXY for https ones,
+// and ALL_PROXY for those without a proxy of their scheme, all in lower
+// case or else in upper case. The hosts of NO_PROXY, and localhost, are
+// reached directly. Proxies are of the form of ProxyOptions.URL.
+func ProxyFromEnvironment(req *http.Request) (*url.URL, error) {
+	p, err := proxiesFromEnvironment()
+	if err != nil {
+		return nil, err
+	}
+	return p.proxy(req)
+}
+
+// Proxy returns the function choosing the proxy of requests, for
+// http.Transport.Proxy.
+func (o ProxyOptions) Proxy() (func(*http.Request) (*url.URL, error), error) {
+	if o.URL == "" && o.NoProxy == "" && o.User == "" {
+		return ProxyFromEnvironment, nil
+	}
+	var p *proxies
+	if o.URL != "" {
+		u, err := parseProxy(o.URL)
+		if err != nil {
+			return nil, err
+		}
+		p = &proxies{all: u, noProxy: getenv("NO_PROXY")}
+	} else {
+		var err error
+		if p, err = proxiesFromEnvironment(); err != nil {
+			return nil, err
+		}
+	}
+	if o.NoProxy != "" {
+		p.noProxy = o.NoProxy
+	}
+	if o.User != "" {
+		p.user = url.UserPassword(o.User, o.Password)
+	}
+	return p.proxy, nil
+}
+
+// Apply makes the requests of t go through the proxies of o.
+func (o ProxyOptions) Apply(t *http.Transport) error {
+	proxy, err := o.Proxy()
+	if err != nil {
+		return err
+	}
+	t.Proxy = proxy
+	return nil
+}
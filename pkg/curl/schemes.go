@@ -68,11 +68,13 @@ type FileScheme interface {
 }
 
 var (
-	// DefaultHTTPClient is the default HTTP FileScheme.
+	// DefaultHTTPClient is the default HTTP FileScheme. It reuses
+	// connections and fetches large files in DefaultSegments parallel
+	// segments.
 	//
 	// It is not recommended to use this for HTTPS. We recommend creating an
 	// http.Client that accepts only a private pool of certificates.
-	DefaultHTTPClient = NewHTTPClient(http.DefaultClient)
+	DefaultHTTPClient = NewHTTPClient(&http.Client{Transport: NewTransport()}, WithSegments(DefaultSegments, DefaultSegmentSize))
 
 	// DefaultTFTPClient is the default TFTP FileScheme.
 	DefaultTFTPClient = NewTFTPClient(tftp.ClientMode(tftp.ModeOctet), tftp.ClientBlocksize(1450), tftp.ClientWindowsize(64))
@@ -402,13 +404,20 @@ func (h *HTTPClientCodeError) Unwrap() error {
 // HTTPClient implements FileScheme for HTTP files.
 type HTTPClient struct {
 	c *http.Client
+
+	segments    int
+	segmentSize int64
 }
 
 // NewHTTPClient returns a new HTTP FileScheme based on the given http.Client.
-func NewHTTPClient(c *http.Client) *HTTPClient {
-	return &HTTPClient{
+func NewHTTPClient(c *http.Client, opts ...HTTPOpt) *HTTPClient {
+	h := &HTTPClient{
 		c: c,
 	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
 }
 
 func httpFetch(ctx context.Context, c *http.Client, u *url.URL) (io.Reader, error) {
@@ -422,13 +431,17 @@ func httpFetch(ctx context.Context, c *http.Client, u *url.URL) (io.Reader, erro
 	}
 
 	if resp.StatusCode != 200 {
+		resp.Body.Close()
 		return nil, &HTTPClientCodeError{ErrStatusNotOk, resp.StatusCode}
 	}
-	return resp.Body, nil
+	return eofCloser{resp.Body}, nil
 }
 
 // Fetch implements FileScheme.Fetch for HTTP.
 func (h HTTPClient) Fetch(ctx context.Context, u *url.URL) (io.ReaderAt, error) {
+	if h.segments > 1 && h.segmentSize > 0 {
+		return h.fetchSegments(ctx, u)
+	}
 	r, err := httpFetch(ctx, h.c, u)
 	if err != nil {
 		return nil, err
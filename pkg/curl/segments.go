@@ -0,0 +1,186 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package curl
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/u-root/uio/uio"
+	"golang.org/x/sync/errgroup"
+)
+
+// Defaults of DefaultHTTPClient for segmented downloads.
+const (
+	DefaultSegments    = 4
+	DefaultSegmentSize = 4 << 20
+)
+
+var errSegment = errors.New("server sent the wrong segment")
+
+// NewTransport returns an http.Transport that speaks HTTP/2 to the HTTPS
+// servers supporting it and keeps enough idle connections per server for
+// the parallel segments of DefaultSegments downloads to reuse. Requests go
+// through the proxies of ProxyFromEnvironment.
+func NewTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = ProxyFromEnvironment
+	t.ForceAttemptHTTP2 = true
+	t.MaxIdleConnsPerHost = 4 * DefaultSegments
+	t.IdleConnTimeout = 90 * time.Second
+	return t
+}
+
+// HTTPOpt is an option of NewHTTPClient.
+type HTTPOpt func(*HTTPClient)
+
+// WithSegments makes Fetch download files larger than size in n parallel
+// range requests, of at least size bytes each, from servers that support
+// ranges. The file is then held in memory.
+//
+// FetchWithoutCache is not affected, it streams the file.
+func WithSegments(n int, size int64) HTTPOpt {
+	return func(h *HTTPClient) {
+		h.segments = n
+		h.segmentSize = size
+	}
+}
+
+// eofCloser closes the body of a response once read to the end, which
+// puts the connection back in the pool of the transport.
+type eofCloser struct {
+	io.ReadCloser
+}
+
+func (r eofCloser) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	if err == io.EOF {
+		r.ReadCloser.Close()
+	}
+	return n, err
+}
+
+// contentRange returns the first and last byte and the total size in the
+// Content-Range of resp, total being -1 if unknown.
+func contentRange(resp *http.Response) (start, end, total int64, err error) {
+	cr := resp.Header.Get("Content-Range")
+	if _, err := fmt.Sscanf(cr, "bytes %d-%d/%d", &start, &end, &total); err == nil {
+		return start, end, total, nil
+	}
+	if _, err := fmt.Sscanf(cr, "bytes %d-%d/*", &start, &end); err == nil {
+		return start, end, -1, nil
+	}
+	return 0, 0, 0, fmt.Errorf("%w: Content-Range %q", errSegment, cr)
+}
+
+// getRange requests bytes [start, end] of u, only if it is still the
+// version ifRange identifies.
+func (h HTTPClient) getRange(ctx context.Context, u *url.URL, start, end int64, ifRange string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	if ifRange != "" {
+		req.Header.Set("If-Range", ifRange)
+	}
+	return h.c.Do(req)
+}
+
+// segment reads bytes [start, start+len(b)) of u into b.
+func (h HTTPClient) segment(ctx context.Context, u *url.URL, b []byte, start int64, ifRange string) error {
+	end := start + int64(len(b)) - 1
+	resp, err := h.getRange(ctx, u, start, end, ifRange)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		// The file changed since the first segment if If-Range was
+		// set, or the server no longer does ranges.
+		return &HTTPClientCodeError{ErrStatusNotOk, resp.StatusCode}
+	}
+	if s, e, _, err := contentRange(resp); err != nil || s != start || e != end {
+		return fmt.Errorf("%w: got %q, want bytes %d-%d", errSegment, resp.Header.Get("Content-Range"), start, end)
+	}
+	_, err = io.ReadFull(resp.Body, b)
+	return err
+}
+
+// fetchSegments fetches u in h.segments range requests. The first one
+// tells whether the server supports ranges and the size of the file; u is
+// streamed as is if it does not or the file is small.
+func (h HTTPClient) fetchSegments(ctx context.Context, u *url.URL) (io.ReaderAt, error) {
+	resp, err := h.getRange(ctx, u, 0, h.segmentSize-1, "")
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return uio.NewCachingReader(eofCloser{resp.Body}), nil
+	case http.StatusPartialContent:
+	case http.StatusRequestedRangeNotSatisfiable:
+		// An empty file.
+		resp.Body.Close()
+		return bytes.NewReader(nil), nil
+	default:
+		resp.Body.Close()
+		return nil, &HTTPClientCodeError{ErrStatusNotOk, resp.StatusCode}
+	}
+	start, end, total, err := contentRange(resp)
+	switch {
+	case err != nil:
+		resp.Body.Close()
+		return nil, err
+	case total < 0:
+		// Without its size, the file cannot be split.
+		resp.Body.Close()
+		r, err := httpFetch(ctx, h.c, u)
+		if err != nil {
+			return nil, err
+		}
+		return uio.NewCachingReader(r), nil
+	case start != 0 || end >= total:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: Content-Range %q", errSegment, resp.Header.Get("Content-Range"))
+	case end+1 == total:
+		// The first segment is the whole file.
+		return uio.NewCachingReader(eofCloser{resp.Body}), nil
+	}
+
+	// Segments after the first split the rest of the file evenly.
+	buf := make([]byte, total)
+	first := end + 1
+	size := max(h.segmentSize, (total-first+int64(h.segments)-2)/int64(h.segments-1))
+	// If-Range only takes strong validators.
+	ifRange := resp.Header.Get("ETag")
+	if ifRange == "" || strings.HasPrefix(ifRange, "W/") {
+		ifRange = resp.Header.Get("Last-Modified")
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		defer resp.Body.Close()
+		_, err := io.ReadFull(resp.Body, buf[:first])
+		return err
+	})
+	for start := first; start < total; start += size {
+		start, end := start, min(start+size, total)
+		g.Go(func() error {
+			return h.segment(ctx, u, buf[start:end], start, ifRange)
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(buf), nil
+}
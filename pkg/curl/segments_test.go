@@ -0,0 +1,200 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package curl
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// rangeServer serves content, with range support unless noRanges is set,
+// and records the ranges and protocols of the requests.
+type rangeServer struct {
+	content  []byte
+	noRanges bool
+	// etag returns the ETag of the nth request.
+	etag func(n int) string
+
+	mu     sync.Mutex
+	ranges []string
+	protos []string
+}
+
+func (s *rangeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	n := len(s.ranges)
+	s.ranges = append(s.ranges, r.Header.Get("Range"))
+	s.protos = append(s.protos, r.Proto)
+	s.mu.Unlock()
+
+	if s.noRanges {
+		w.Write(s.content)
+		return
+	}
+	if s.etag != nil {
+		w.Header().Set("ETag", s.etag(n))
+	}
+	http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(s.content))
+}
+
+func (s *rangeServer) sortedRanges() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := append([]string(nil), s.ranges...)
+	sort.Strings(r)
+	return strings.Join(r, ",")
+}
+
+func content(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i * 7)
+	}
+	return b
+}
+
+func TestFetchSegments(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		srv    *rangeServer
+		ranges string
+		err    error
+	}{
+		{
+			name:   "segments",
+			srv:    &rangeServer{content: content(10000)},
+			ranges: "bytes=0-999,bytes=1000-3999,bytes=4000-6999,bytes=7000-9999",
+		},
+		{
+			name:   "minimum segment size",
+			srv:    &rangeServer{content: content(2500)},
+			ranges: "bytes=0-999,bytes=1000-1999,bytes=2000-2499",
+		},
+		{
+			name:   "small file",
+			srv:    &rangeServer{content: content(1000)},
+			ranges: "bytes=0-999",
+		},
+		{
+			name:   "empty file",
+			srv:    &rangeServer{content: nil},
+			ranges: "bytes=0-999",
+		},
+		{
+			name:   "no range support",
+			srv:    &rangeServer{content: content(10000), noRanges: true},
+			ranges: "bytes=0-999",
+		},
+		{
+			name: "file changed",
+			srv: &rangeServer{content: content(10000), etag: func(n int) string {
+				if n == 0 {
+					return `"v1"`
+				}
+				return `"v2"`
+			}},
+			err: ErrStatusNotOk,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(tt.srv)
+			defer ts.Close()
+			u, err := url.Parse(ts.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			h := NewHTTPClient(&http.Client{Transport: NewTransport()}, WithSegments(4, 1000))
+			r, err := h.Fetch(context.Background(), u)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Fetch() = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			got, err := io.ReadAll(io.NewSectionReader(r, 0, 1<<20))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.srv.content) {
+				t.Errorf("Fetch() got %d bytes, want the %d bytes of the file", len(got), len(tt.srv.content))
+			}
+			if got := tt.srv.sortedRanges(); got != tt.ranges {
+				t.Errorf("ranges requested = %q, want %q", got, tt.ranges)
+			}
+		})
+	}
+}
+
+func TestConnectionReuse(t *testing.T) {
+	srv := &rangeServer{content: content(10000)}
+	ts := httptest.NewUnstartedServer(srv)
+	var conns atomic.Int32
+	ts.Config.ConnState = func(_ net.Conn, s http.ConnState) {
+		if s == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := NewHTTPClient(&http.Client{Transport: NewTransport()}, WithSegments(4, 1000))
+	for i := 0; i < 3; i++ {
+		if _, err := h.Fetch(context.Background(), u); err != nil {
+			t.Fatal(err)
+		}
+		r, err := h.FetchWithoutCache(context.Background(), u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := conns.Load(); n > 4 {
+		t.Errorf("%d connections for 4 parallel segments, want at most 4", n)
+	}
+}
+
+func TestHTTP2(t *testing.T) {
+	srv := &rangeServer{content: content(10000)}
+	ts := httptest.NewUnstartedServer(srv)
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := TLSOptions{InsecureSkipVerify: true}.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewHTTPClient(c, WithSegments(4, 1000)).Fetch(context.Background(), u); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range srv.protos {
+		if p != "HTTP/2.0" {
+			t.Errorf("request protocol %s, want HTTP/2.0", p)
+		}
+	}
+}
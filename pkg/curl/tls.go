@@ -0,0 +1,122 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package curl
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+var (
+	errNoCertificates = errors.New("no PEM certificates")
+	errKeyPair        = errors.New("client certificate and key must be given together")
+)
+
+// TLSOptions are the certificates used to fetch from HTTPS servers, e.g. a
+// provisioning server that only serves the clients it issued certificates
+// to.
+type TLSOptions struct {
+	// CAFile is a PEM bundle of the CAs to trust instead of the system
+	// ones.
+	CAFile string
+
+	// CertFile and KeyFile are the PEM client certificate and private key
+	// presented to servers that ask for one.
+	CertFile string
+	KeyFile  string
+
+	// InsecureSkipVerify accepts any server certificate.
+	InsecureSkipVerify bool
+
+	// FirmwareCAs also trusts the CAs that firmware setup provisioned
+	// for UEFI HTTPS Boot, in the TlsCaCertificate EFI variable.
+	FirmwareCAs bool
+}
+
+// Config returns the TLS configuration of o.
+func (o TLSOptions) Config() (*tls.Config, error) {
+	c := &tls.Config{InsecureSkipVerify: o.InsecureSkipVerify}
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%w: %q", errNoCertificates, o.CAFile)
+		}
+	}
+	if o.FirmwareCAs {
+		certs, err := firmwareCAs()
+		if err != nil {
+			return nil, err
+		}
+		if c.RootCAs == nil {
+			if c.RootCAs, err = x509.SystemCertPool(); err != nil {
+				c.RootCAs = x509.NewCertPool()
+			}
+		}
+		for _, cert := range certs {
+			c.RootCAs.AddCert(cert)
+		}
+	}
+	if (o.CertFile == "") != (o.KeyFile == "") {
+		return nil, errKeyPair
+	}
+	if o.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	return c, nil
+}
+
+// Transport returns an http.Transport using the TLS configuration of o, and
+// NewTransport's settings otherwise.
+func (o TLSOptions) Transport() (*http.Transport, error) {
+	c, err := o.Config()
+	if err != nil {
+		return nil, err
+	}
+	t := NewTransport()
+	t.TLSClientConfig = c
+	return t, nil
+}
+
+// Client returns an http.Client using the Transport of o.
+func (o TLSOptions) Client() (*http.Client, error) {
+	t, err := o.Transport()
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: t}, nil
+}
+
+// HTTPSSchemes returns s with "http" and "https" fetched by an HTTPClient
+// using the TLS configuration of o and the proxies of p, in DefaultSegments
+// segments.
+func HTTPSSchemes(s Schemes, o TLSOptions, p ProxyOptions) (Schemes, error) {
+	t, err := o.Transport()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.Apply(t); err != nil {
+		return nil, err
+	}
+	h := NewHTTPClient(&http.Client{Transport: t}, WithSegments(DefaultSegments, DefaultSegmentSize))
+	schemes := make(Schemes, len(s)+1)
+	for name, fs := range s {
+		schemes[name] = fs
+	}
+	schemes.Register("http", h)
+	schemes.Register("https", h)
+	return schemes, nil
+}
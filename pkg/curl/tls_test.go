@@ -0,0 +1,192 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package curl
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writePEM writes the PEM block typ of b to name in dir.
+func writePEM(t *testing.T, dir, name, typ string, b []byte) string {
+	t.Helper()
+	p := filepath.Join(dir, name)
+	if err := os.WriteFile(p, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: b}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// clientCert returns a self-signed client certificate and the paths of
+// its certificate and key files.
+func clientCert(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, writePEM(t, dir, "client.pem", "CERTIFICATE", der), writePEM(t, dir, "client.key", "EC PRIVATE KEY", k)
+}
+
+// signatureList returns an EFI_SIGNATURE_LIST of type typ with the
+// signatures sigs.
+func signatureList(typ []byte, sigs ...[]byte) []byte {
+	var b bytes.Buffer
+	b.Write(typ)
+	size := 0
+	if len(sigs) > 0 {
+		size = 16 + len(sigs[0])
+	}
+	for _, v := range []uint32{uint32(28 + len(sigs)*size), 0, uint32(size)} {
+		binary.Write(&b, binary.LittleEndian, v)
+	}
+	for _, sig := range sigs {
+		b.Write(make([]byte, 16))
+		b.Write(sig)
+	}
+	return b.Bytes()
+}
+
+func TestTLSOptions(t *testing.T) {
+	dir := t.TempDir()
+	cert, certFile, keyFile := clientCert(t, dir)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	clients := x509.NewCertPool()
+	clients.AddCert(cert)
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clients}
+	ts.StartTLS()
+	defer ts.Close()
+	caFile := writePEM(t, dir, "ca.pem", "CERTIFICATE", ts.Certificate().Raw)
+
+	defer func(f func() ([]byte, error)) {
+		readTLSCAVar = f
+	}(readTLSCAVar)
+	readTLSCAVar = func() ([]byte, error) {
+		// A list of another type comes first.
+		other := signatureList(make([]byte, 16), make([]byte, 32))
+		return append(other, signatureList(efiCertX509, ts.Certificate().Raw)...), nil
+	}
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name    string
+		o       TLSOptions
+		wantErr bool
+	}{
+		{name: "client certificate", o: TLSOptions{CAFile: caFile, CertFile: certFile, KeyFile: keyFile}},
+		{name: "insecure", o: TLSOptions{InsecureSkipVerify: true, CertFile: certFile, KeyFile: keyFile}},
+		{name: "firmware CAs", o: TLSOptions{FirmwareCAs: true, CertFile: certFile, KeyFile: keyFile}},
+		{name: "unknown CA", o: TLSOptions{CertFile: certFile, KeyFile: keyFile}, wantErr: true},
+		{name: "no client certificate", o: TLSOptions{CAFile: caFile}, wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := HTTPSSchemes(DefaultSchemes, tt.o, ProxyOptions{})
+			if err != nil {
+				t.Fatalf("HTTPSSchemes() = %v", err)
+			}
+			r, err := s.FetchWithoutCache(context.Background(), u)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("FetchWithoutCache(%v) = nil, want an error", u)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FetchWithoutCache(%v) = %v", u, err)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil || string(got) != "client" {
+				t.Errorf("FetchWithoutCache(%v) = %q, %v, want client", u, got, err)
+			}
+		})
+	}
+
+	if _, ok := DefaultSchemes["https"]; ok {
+		t.Errorf("HTTPSSchemes() changed DefaultSchemes")
+	}
+}
+
+func TestTLSOptionsErrors(t *testing.T) {
+	defer func(f func() ([]byte, error)) {
+		readTLSCAVar = f
+	}(readTLSCAVar)
+
+	dir := t.TempDir()
+	_, certFile, keyFile := clientCert(t, dir)
+	for _, tt := range []struct {
+		name     string
+		o        TLSOptions
+		tlsCAVar []byte
+		err      error
+	}{
+		{name: "no key", o: TLSOptions{CertFile: certFile}, err: errKeyPair},
+		{name: "no certificate", o: TLSOptions{KeyFile: keyFile}, err: errKeyPair},
+		{name: "CA file without certificates", o: TLSOptions{CAFile: keyFile}, err: errNoCertificates},
+		{name: "missing CA file", o: TLSOptions{CAFile: filepath.Join(dir, "none")}, err: os.ErrNotExist},
+		{name: "no firmware CAs", o: TLSOptions{FirmwareCAs: true}, err: os.ErrNotExist},
+		{name: "truncated signature list", o: TLSOptions{FirmwareCAs: true}, tlsCAVar: signatureList(efiCertX509, []byte("cert"))[:40], err: errSignatureList},
+		{name: "invalid certificate", o: TLSOptions{FirmwareCAs: true}, tlsCAVar: signatureList(efiCertX509, []byte("cert")), err: errSignatureList},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			readTLSCAVar = func() ([]byte, error) {
+				if tt.tlsCAVar == nil {
+					return nil, os.ErrNotExist
+				}
+				return tt.tlsCAVar, nil
+			}
+			if _, err := tt.o.Config(); !errors.Is(err, tt.err) {
+				t.Errorf("Config() = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
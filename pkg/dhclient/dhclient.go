@@ -9,7 +9,6 @@ package dhclient
 import (
 	"bytes"
 	"context"
-	"errors"
 	"fmt"
 	"log"
 	"net"
@@ -50,6 +49,40 @@ func isIPv6LinkReady(l netlink.Link) (bool, error) {
 	return false, nil
 }
 
+// isIPv6GlobalReady returns true if l has a non-tentative global unicast
+// address, such as one configured from router advertisements.
+func isIPv6GlobalReady(l netlink.Link) (bool, error) {
+	addrs, err := netlink.AddrList(l, netlink.FAMILY_V6)
+	if err != nil {
+		return false, err
+	}
+	for _, addr := range addrs {
+		if addr.IP.IsGlobalUnicast() && addr.Flags&unix.IFA_F_TENTATIVE == 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// waitIPv6 polls ready until it returns true, and fails once timeout fires
+// or ctx is done, saying it was waiting for what.
+func waitIPv6(ctx context.Context, timeout <-chan time.Time, what string, ready func() (bool, error)) error {
+	for {
+		if ok, err := ready(); err != nil {
+			return err
+		} else if ok {
+			return nil
+		}
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-timeout:
+			return fmt.Errorf("timeout after waiting for %s", what)
+		case <-ctx.Done():
+			return fmt.Errorf("timeout after waiting for %s", what)
+		}
+	}
+}
+
 // isIPv6RouteReady returns true if serverAddr is reachable.
 func isIPv6RouteReady(l netlink.Link, serverAddr net.IP) (bool, error) {
 	if serverAddr.IsMulticast() {
@@ -136,6 +169,10 @@ type Lease interface {
 	// they were part of the DHCP message.
 	ISCSIBoot() (*net.TCPAddr, string, error)
 
+	// HTTPBoot returns whether Boot is the URI of an image offered to UEFI
+	// HTTP Boot clients, rather than a PXE boot file.
+	HTTPBoot() bool
+
 	// Link is the interface the configuration is for.
 	Link() netlink.Link
 
@@ -193,11 +230,63 @@ type Config struct {
 	// address).
 	V4ServerAddr *net.UDPAddr
 
-	// If true, add Client Identifier (61) option to the IPv4 request.
+	// If true, add Client Identifier (61) option to the IPv4 request: the
+	// hardware address, or the IAID and DUID (RFC 4361) if DUID is set.
 	V4ClientIdentifier bool
+
+	// V4ClientID is sent verbatim, type byte first, as Client Identifier
+	// (61) option instead of the one of V4ClientIdentifier.
+	V4ClientID []byte
+
+	// DUID identifies the client in DHCPv6 messages.
+	//
+	// If not set, a DUID-LLT of the interface is sent, whose time makes
+	// it change at each run.
+	DUID dhcpv6.DUID
+
+	// V4RequestedOptions are requested from the server in addition to the
+	// ones needed to configure the interface and to boot.
+	V4RequestedOptions []dhcpv4.OptionCode
+
+	// V4VendorClass is sent as Vendor Class Identifier (60) option.
+	//
+	// If not set, it defaults to "PXE UROOT".
+	V4VendorClass string
+
+	// V4UserClasses are sent as User Class (77) option, RFC 3004.
+	V4UserClasses []string
+
+	// HTTPBoot asks for an HTTP boot URI as UEFI HTTP Boot clients do,
+	// with the HTTPClient vendor class and the HTTP client architecture
+	// type of the CPU. V4VendorClass still overrides the IPv4 vendor
+	// class.
+	HTTPBoot bool
+
+	// V6PrefixDelegation requests a prefix to be delegated (IA_PD, RFC
+	// 8415 Section 6.3) along with the address.
+	V6PrefixDelegation bool
+
+	// V6PrefixLength is the length of the prefix hinted to the server
+	// when requesting a delegation, 0 to leave it to the server.
+	V6PrefixLength int
+
+	// V6Downstream is the name of the interface the delegated prefix is
+	// installed on when configuring the lease, see Packet6.ConfigurePrefix.
+	//
+	// If not set, the prefix is not installed.
+	V6Downstream string
+
+	// V6Stateless sends an Information-Request (RFC 8415 Section 18.2.6)
+	// instead of soliciting an address, for networks whose router
+	// advertisements have hosts configure their own addresses (SLAAC)
+	// and only point them at DHCPv6 for other configuration, such as the
+	// boot file URL. The lease is returned once the interface has a
+	// global address from the router advertisements.
+	V6Stateless bool
 }
 
-func lease4(ctx context.Context, iface netlink.Link, c Config) (Lease, error) {
+// newClient4 returns a DHCPv4 client on iface configured by c.
+func newClient4(iface netlink.Link, c Config) (*nclient4.Client, error) {
 	mods := []nclient4.ClientOpt{
 		nclient4.WithTimeout(c.Timeout),
 		nclient4.WithRetry(c.Retries),
@@ -211,7 +300,11 @@ func lease4(ctx context.Context, iface netlink.Link, c Config) (Lease, error) {
 	if c.V4ServerAddr != nil {
 		mods = append(mods, nclient4.WithServerAddr(c.V4ServerAddr))
 	}
-	client, err := nclient4.New(iface.Attrs().Name, mods...)
+	return nclient4.New(iface.Attrs().Name, mods...)
+}
+
+func lease4(ctx context.Context, iface netlink.Link, c Config) (Lease, error) {
+	client, err := newClient4(iface, c)
 	if err != nil {
 		return nil, err
 	}
@@ -226,10 +319,19 @@ func lease4(ctx context.Context, iface netlink.Link, c Config) (Lease, error) {
 		},
 		c.Modifiers4...)
 
-	if c.V4ClientIdentifier {
-		// Client Id is hardware type + mac per RFC 2132 9.14.
-		ident := []byte{0x01} // Type ethernet
-		ident = append(ident, iface.Attrs().HardwareAddr...)
+	if c.HTTPBoot {
+		reqmods = append(reqmods, withHTTPBoot4(httpBootArch()))
+	}
+	if c.V4VendorClass != "" {
+		reqmods = append(reqmods, dhcpv4.WithOption(dhcpv4.OptClassIdentifier(c.V4VendorClass)))
+	}
+	if c.V4UserClasses != nil {
+		reqmods = append(reqmods, dhcpv4.WithOption(dhcpv4.OptRFC3004UserClass(c.V4UserClasses)))
+	}
+	if c.V4RequestedOptions != nil {
+		reqmods = append(reqmods, dhcpv4.WithRequestedOptions(c.V4RequestedOptions...))
+	}
+	if ident := clientID4(iface, c); ident != nil {
 		reqmods = append(reqmods, dhcpv4.WithOption(dhcpv4.OptClientIdentifier(ident)))
 	}
 
@@ -245,11 +347,6 @@ func lease4(ctx context.Context, iface netlink.Link, c Config) (Lease, error) {
 }
 
 func lease6(ctx context.Context, iface netlink.Link, c Config, linkUpTimeout time.Duration) (Lease, error) {
-	clientPort := dhcpv6.DefaultClientPort
-	if c.V6ClientPort != nil {
-		clientPort = *c.V6ClientPort
-	}
-
 	// For ipv6, we cannot bind to the port until Duplicate Address
 	// Detection (DAD) is complete which is indicated by the link being no
 	// longer marked as "tentative". This usually takes about a second.
@@ -259,63 +356,22 @@ func lease6(ctx context.Context, iface netlink.Link, c Config, linkUpTimeout tim
 	//
 	// Hardcode the timeout to 30s for now.
 	linkTimeout := time.After(linkUpTimeout)
-	for {
-		if ready, err := isIPv6LinkReady(iface); err != nil {
-			return nil, err
-		} else if ready {
-			break
-		}
-		select {
-		case <-time.After(100 * time.Millisecond):
-			continue
-		case <-linkTimeout:
-			return nil, errors.New("timeout after waiting for a non-tentative IPv6 address")
-		case <-ctx.Done():
-			return nil, errors.New("timeout after waiting for a non-tentative IPv6 address")
-		}
+	if err := waitIPv6(ctx, linkTimeout, "a non-tentative IPv6 address", func() (bool, error) {
+		return isIPv6LinkReady(iface)
+	}); err != nil {
+		return nil, err
 	}
 
 	// If user specified a non-multicast address, make sure it's routable before we start.
 	if c.V6ServerAddr != nil {
-		for {
-			if ready, err := isIPv6RouteReady(iface, c.V6ServerAddr.IP); err != nil {
-				return nil, err
-			} else if ready {
-				break
-			}
-			select {
-			case <-time.After(100 * time.Millisecond):
-				continue
-			case <-linkTimeout:
-				return nil, errors.New("timeout after waiting for a route")
-			case <-ctx.Done():
-				return nil, errors.New("timeout after waiting for a route")
-			}
+		if err := waitIPv6(ctx, linkTimeout, "a route", func() (bool, error) {
+			return isIPv6RouteReady(iface, c.V6ServerAddr.IP)
+		}); err != nil {
+			return nil, err
 		}
 	}
 
-	mods := []nclient6.ClientOpt{
-		nclient6.WithTimeout(c.Timeout),
-		nclient6.WithRetry(c.Retries),
-	}
-	switch c.LogLevel {
-	case LogSummary:
-		mods = append(mods, nclient6.WithSummaryLogger())
-	case LogDebug:
-		mods = append(mods, nclient6.WithDebugLogger())
-	}
-	if c.V6ServerAddr != nil {
-		mods = append(mods, nclient6.WithBroadcastAddr(c.V6ServerAddr))
-	}
-	conn, err := nclient6.NewIPv6UDPConn(iface.Attrs().Name, clientPort)
-	if err != nil {
-		return nil, err
-	}
-	i, err := net.InterfaceByName(iface.Attrs().Name)
-	if err != nil {
-		return nil, err
-	}
-	client, err := nclient6.NewWithConn(conn, i.HardwareAddr, mods...)
+	client, err := newClient6(iface, c)
 	if err != nil {
 		return nil, err
 	}
@@ -328,6 +384,34 @@ func lease6(ctx context.Context, iface netlink.Link, c Config, linkUpTimeout tim
 		},
 		c.Modifiers6...)
 
+	if c.DUID != nil {
+		reqmods = append(reqmods, dhcpv6.WithClientID(c.DUID))
+	}
+	if c.HTTPBoot {
+		reqmods = append(reqmods, withHTTPBoot6(httpBootArch()))
+	}
+	if c.V6Stateless {
+		log.Printf("Attempting to get DHCPv6 information on %s", iface.Attrs().Name)
+		p, err := informationRequest(ctx, client, reqmods...)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Got DHCPv6 information on %s: %v", iface.Attrs().Name, p.Summary())
+
+		// The address comes from router advertisements instead.
+		if err := waitIPv6(ctx, linkTimeout, "an IPv6 address from router advertisements", func() (bool, error) {
+			return isIPv6GlobalReady(iface)
+		}); err != nil {
+			return nil, err
+		}
+		packet := NewPacket6(iface, p)
+		packet.stateless = true
+		return packet, nil
+	}
+	if c.V6PrefixDelegation {
+		reqmods = append(reqmods, withIAPD(client.InterfaceAddr(), c.V6PrefixLength))
+	}
+
 	log.Printf("Attempting to get DHCPv6 lease on %s", iface.Attrs().Name)
 	p, err := client.RapidSolicit(ctx, reqmods...)
 	if err != nil {
@@ -335,10 +419,41 @@ func lease6(ctx context.Context, iface netlink.Link, c Config, linkUpTimeout tim
 	}
 
 	packet := NewPacket6(iface, p)
+	packet.downstream = c.V6Downstream
 	log.Printf("Got DHCPv6 lease on %s: %v", iface.Attrs().Name, p.Summary())
 	return packet, nil
 }
 
+// newClient6 returns a DHCPv6 client on iface configured by c.
+func newClient6(iface netlink.Link, c Config) (*nclient6.Client, error) {
+	clientPort := dhcpv6.DefaultClientPort
+	if c.V6ClientPort != nil {
+		clientPort = *c.V6ClientPort
+	}
+	mods := []nclient6.ClientOpt{
+		nclient6.WithTimeout(c.Timeout),
+		nclient6.WithRetry(c.Retries),
+	}
+	switch c.LogLevel {
+	case LogSummary:
+		mods = append(mods, nclient6.WithSummaryLogger())
+	case LogDebug:
+		mods = append(mods, nclient6.WithDebugLogger())
+	}
+	if c.V6ServerAddr != nil {
+		mods = append(mods, nclient6.WithBroadcastAddr(c.V6ServerAddr))
+	}
+	conn, err := nclient6.NewIPv6UDPConn(iface.Attrs().Name, clientPort)
+	if err != nil {
+		return nil, err
+	}
+	i, err := net.InterfaceByName(iface.Attrs().Name)
+	if err != nil {
+		return nil, err
+	}
+	return nclient6.NewWithConn(conn, i.HardwareAddr, mods...)
+}
+
 // NetworkProtocol is either IPv4 or IPv6.
 type NetworkProtocol int
 
@@ -358,7 +473,7 @@ func (n NetworkProtocol) String() string {
 	case NetBoth:
 		return "IPv4+IPv6"
 	}
-	return fmt.Sprintf("unknown network protocol (%#x)", n)
+	return fmt.Sprintf("unknown network protocol (%#x)", int(n))
 }
 
 // Result is the result of a particular DHCP attempt.
@@ -5,6 +5,7 @@
 package dhclient
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"net/url"
@@ -12,6 +13,8 @@ import (
 
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/dhcpv6/nclient6"
+	"github.com/insomniacslk/dhcp/iana"
 	"github.com/vishvananda/netlink"
 	"golang.org/x/sys/unix"
 )
@@ -20,6 +23,13 @@ import (
 type Packet6 struct {
 	p     *dhcpv6.Message
 	iface netlink.Link
+	// downstream is the name of the interface to install delegated
+	// prefixes on, "" to not install them.
+	downstream string
+	// stateless is set for replies to Information-Requests, which come
+	// with no address as the interface configures its own from router
+	// advertisements.
+	stateless bool
 }
 
 // NewPacket6 wraps a DHCPv6 packet with some convenience methods.
@@ -46,14 +56,37 @@ func Configure6(iface netlink.Link, packet *dhcpv6.Message) error {
 	return p.Configure()
 }
 
-// Configure configures interface using this packet.
+// Configure configures interface using this packet, and installs the
+// delegated prefixes on the downstream interface if there is one.
 func (p *Packet6) Configure() error {
 	l := p.Lease()
-	if l == nil {
+	if l == nil && p.DelegatedPrefixes() == nil && !p.stateless {
 		return fmt.Errorf("no lease returned")
 	}
+	if l != nil {
+		if err := p.configureAddr(l); err != nil {
+			return err
+		}
+	}
+
+	if ips := p.DNS(); ips != nil {
+		if err := WriteDNSSettings(ips, nil, "", ResolvConfPath); err != nil {
+			return err
+		}
+	}
 
-	// Add the address to the iface.
+	if p.downstream != "" {
+		downstream, err := netlink.LinkByName(p.downstream)
+		if err != nil {
+			return fmt.Errorf("cannot get downstream interface %q by name: %w", p.downstream, err)
+		}
+		return p.ConfigurePrefix(downstream)
+	}
+	return nil
+}
+
+// configureAddr adds the leased address l to the interface.
+func (p *Packet6) configureAddr(l *dhcpv6.OptIAAddress) error {
 	dst := &netlink.Addr{
 		IPNet: &net.IPNet{
 			IP: l.IPv6Addr,
@@ -82,20 +115,61 @@ func (p *Packet6) Configure() error {
 			return fmt.Errorf("add/replace %s to %v: %w", dst, p.iface, err)
 		}
 	}
+	return nil
+}
 
-	if ips := p.DNS(); ips != nil {
-		if err := WriteDNSSettings(ips, nil, "", ResolvConfPath); err != nil {
-			return err
+// ConfigurePrefix installs the delegated prefixes on the downstream
+// interface. Each gets the first address of its first /64 assigned, for
+// the hosts behind downstream to be routed through, and, if longer than
+// that, an unreachable route for the rest of it, so that packets to
+// unused parts are not sent back upstream.
+func (p *Packet6) ConfigurePrefix(downstream netlink.Link) error {
+	for _, pfx := range p.DelegatedPrefixes() {
+		dst := &netlink.Addr{
+			IPNet:       DelegatedAddr(pfx.Prefix),
+			PreferedLft: int(pfx.PreferredLifetime.Seconds()),
+			ValidLft:    int(pfx.ValidLifetime.Seconds()),
+		}
+		if err := netlink.AddrReplace(downstream, dst); err != nil {
+			return fmt.Errorf("add/replace %s to %v: %w", dst, downstream.Attrs().Name, err)
+		}
+
+		if ones, _ := pfx.Prefix.Mask.Size(); ones >= 64 {
+			continue
+		}
+		r := &netlink.Route{
+			Dst:  pfx.Prefix,
+			Type: unix.RTN_UNREACHABLE,
+		}
+		if err := netlink.RouteReplace(r); err != nil {
+			return fmt.Errorf("add %s: %w", r, err)
 		}
 	}
 	return nil
 }
 
+// DelegatedAddr returns the address a router assigns itself on the
+// downstream interface of a delegated prefix: the first one of the first
+// /64 of prefix, or of prefix itself if it is longer than that.
+func DelegatedAddr(prefix *net.IPNet) *net.IPNet {
+	ones, _ := prefix.Mask.Size()
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, prefix.IP.Mask(prefix.Mask))
+	if ones < 128 {
+		ip[net.IPv6len-1] |= 1
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(max(ones, 64), 128)}
+}
+
 func (p *Packet6) String() string {
+	s := "IPv6 DHCP Lease came with no IP"
 	if p.Lease() != nil {
-		return fmt.Sprintf("IPv6 DHCP Lease IP %s", p.Lease().IPv6Addr)
+		s = fmt.Sprintf("IPv6 DHCP Lease IP %s", p.Lease().IPv6Addr)
+	}
+	for _, pfx := range p.DelegatedPrefixes() {
+		s += fmt.Sprintf(", delegated prefix %s", pfx.Prefix)
 	}
-	return "IPv6 DHCP Lease came with no IP"
+	return s
 }
 
 // Lease returns lease information assigned.
@@ -107,18 +181,91 @@ func (p *Packet6) Lease() *dhcpv6.OptIAAddress {
 	return iana.Options.OneAddress()
 }
 
+// DelegatedPrefixes returns the prefixes delegated by the server that are
+// still valid, nil if there are none.
+func (p *Packet6) DelegatedPrefixes() []*dhcpv6.OptIAPrefix {
+	var prefixes []*dhcpv6.OptIAPrefix
+	for _, iapd := range p.p.Options.IAPD() {
+		for _, pfx := range iapd.Options.Prefixes() {
+			if pfx.Prefix != nil && pfx.ValidLifetime > 0 {
+				prefixes = append(prefixes, pfx)
+			}
+		}
+	}
+	return prefixes
+}
+
+// withIAPD requests a delegated prefix of length bits, or of the length
+// the server chooses if 0. The IAID is derived from the hardware address
+// of the interface, so that it is stable across requests.
+func withIAPD(hwAddr net.HardwareAddr, length int) dhcpv6.Modifier {
+	var iaid [4]byte
+	copy(iaid[:], hwAddr[max(len(hwAddr)-len(iaid), 0):])
+	var hints []*dhcpv6.OptIAPrefix
+	if length > 0 {
+		hints = append(hints, &dhcpv6.OptIAPrefix{
+			Prefix: &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(length, 128)},
+		})
+	}
+	return dhcpv6.WithIAPD(iaid, hints...)
+}
+
 // DNS returns DNS servers assigned.
 func (p *Packet6) DNS() []net.IP {
 	return p.p.Options.DNS()
 }
 
-// Boot returns the boot file URL and parameters assigned.
+// Boot returns the boot file URL assigned.
+//
+// Link-local addresses, such as tftp://[fe80::1]/pxelinux.0, are only
+// reachable through the interface the packet was received on, which is added
+// to them as zone.
 func (p *Packet6) Boot() (*url.URL, error) {
 	uri := p.p.Options.BootFileURL()
 	if len(uri) == 0 {
 		return nil, fmt.Errorf("packet does not contain boot file URL")
 	}
-	return url.Parse(uri)
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil && ip.IsLinkLocalUnicast() && p.iface != nil {
+		host := "[" + ip.String() + "%" + p.iface.Attrs().Name + "]"
+		if port := u.Port(); port != "" {
+			host += ":" + port
+		}
+		u.Host = host
+	}
+	return u, nil
+}
+
+// BootParams returns the parameters of the boot file, RFC 5970 Section 3.2,
+// which are kernel command line arguments when it is a Linux kernel.
+func (p *Packet6) BootParams() []string {
+	return p.p.Options.BootFileParam()
+}
+
+// informationRequest sends an Information-Request, RFC 8415 Section 18.2.6,
+// which asks for configuration but no address, and returns the reply.
+func informationRequest(ctx context.Context, c *nclient6.Client, modifiers ...dhcpv6.Modifier) (*dhcpv6.Message, error) {
+	m, err := dhcpv6.NewMessage()
+	if err != nil {
+		return nil, err
+	}
+	m.MessageType = dhcpv6.MessageTypeInformationRequest
+	m.AddOption(dhcpv6.OptClientID(&dhcpv6.DUIDLL{
+		HWType:        iana.HWTypeEthernet,
+		LinkLayerAddr: c.InterfaceAddr(),
+	}))
+	m.AddOption(dhcpv6.OptRequestedOption(
+		dhcpv6.OptionDNSRecursiveNameServer,
+		dhcpv6.OptionDomainSearchList,
+	))
+	m.AddOption(dhcpv6.OptElapsedTime(0))
+	for _, mod := range modifiers {
+		mod(m)
+	}
+	return c.SendAndRead(ctx, c.RemoteAddr(), m, nclient6.IsMessageType(dhcpv6.MessageTypeReply))
 }
 
 // ISCSIBoot returns the target address and volume name to boot from if
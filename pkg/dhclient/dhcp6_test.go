@@ -0,0 +1,133 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhclient
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/vishvananda/netlink"
+)
+
+func mustCIDR(t *testing.T, s string) *net.IPNet {
+	t.Helper()
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestDelegatedAddr(t *testing.T) {
+	for _, tt := range []struct {
+		prefix string
+		want   string
+	}{
+		{prefix: "2001:db8:1200::/56", want: "2001:db8:1200::1/64"},
+		{prefix: "2001:db8:1234:5678::/64", want: "2001:db8:1234:5678::1/64"},
+		{prefix: "2001:db8:1234:5678:9a00::/72", want: "2001:db8:1234:5678:9a00::1/72"},
+		{prefix: "2001:db8::5/128", want: "2001:db8::5/128"},
+	} {
+		if got := DelegatedAddr(mustCIDR(t, tt.prefix)); got.String() != tt.want {
+			t.Errorf("DelegatedAddr(%s) = %s, want %s", tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestDelegatedPrefixes(t *testing.T) {
+	m, err := dhcpv6.NewMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	iapd := &dhcpv6.OptIAPD{}
+	iapd.Options.Add(&dhcpv6.OptIAPrefix{
+		PreferredLifetime: time.Hour,
+		ValidLifetime:     2 * time.Hour,
+		Prefix:            mustCIDR(t, "2001:db8:1200::/56"),
+	})
+	// Prefixes the server withdraws have a valid lifetime of 0.
+	iapd.Options.Add(&dhcpv6.OptIAPrefix{Prefix: mustCIDR(t, "2001:db8:3400::/56")})
+	m.AddOption(iapd)
+
+	p := NewPacket6(nil, m)
+	got := p.DelegatedPrefixes()
+	if len(got) != 1 || got[0].Prefix.String() != "2001:db8:1200::/56" {
+		t.Errorf("DelegatedPrefixes() = %v, want [2001:db8:1200::/56]", got)
+	}
+	if s, want := p.String(), "IPv6 DHCP Lease came with no IP, delegated prefix 2001:db8:1200::/56"; s != want {
+		t.Errorf("String() = %q, want %q", s, want)
+	}
+}
+
+func TestWithIAPD(t *testing.T) {
+	hw := net.HardwareAddr{0x52, 0x54, 0x00, 0x12, 0x34, 0x56}
+	for _, tt := range []struct {
+		length int
+		hints  int
+	}{
+		{length: 0, hints: 0},
+		{length: 56, hints: 1},
+	} {
+		m, err := dhcpv6.NewMessage(withIAPD(hw, tt.length))
+		if err != nil {
+			t.Fatal(err)
+		}
+		iapd := m.Options.OneIAPD()
+		if iapd == nil {
+			t.Fatalf("withIAPD(%d) added no IA_PD", tt.length)
+		}
+		if want := [4]byte{0x00, 0x12, 0x34, 0x56}; iapd.IaId != want {
+			t.Errorf("IAID = %x, want %x", iapd.IaId, want)
+		}
+		hints := iapd.Options.Prefixes()
+		if len(hints) != tt.hints {
+			t.Fatalf("withIAPD(%d) hints %v, want %d", tt.length, hints, tt.hints)
+		}
+		if tt.hints > 0 {
+			if ones, _ := hints[0].Prefix.Mask.Size(); ones != tt.length {
+				t.Errorf("hinted length = %d, want %d", ones, tt.length)
+			}
+		}
+	}
+}
+
+func TestBoot6(t *testing.T) {
+	iface := &netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth0"}}
+	for _, tt := range []struct {
+		uri  string
+		want string
+	}{
+		{uri: "tftp://[2001:db8::1]/pxelinux.0", want: "tftp://[2001:db8::1]/pxelinux.0"},
+		{uri: "http://[2001:db8::1]:8080/boot.ipxe", want: "http://[2001:db8::1]:8080/boot.ipxe"},
+		{uri: "tftp://[fe80::1]/pxelinux.0", want: "tftp://[fe80::1%25eth0]/pxelinux.0"},
+		{uri: "http://[fe80::1]:8080/boot.ipxe", want: "http://[fe80::1%25eth0]:8080/boot.ipxe"},
+		{uri: "http://boot.example.com/boot.ipxe", want: "http://boot.example.com/boot.ipxe"},
+	} {
+		m, err := dhcpv6.NewMessage(dhcpv6.WithOption(dhcpv6.OptBootFileURL(tt.uri)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		u, err := NewPacket6(iface, m).Boot()
+		if err != nil {
+			t.Fatalf("Boot() of %s = %v", tt.uri, err)
+		}
+		if got := u.String(); got != tt.want {
+			t.Errorf("Boot() of %s = %s, want %s", tt.uri, got, tt.want)
+		}
+	}
+}
+
+func TestBootParams(t *testing.T) {
+	m, err := dhcpv6.NewMessage(dhcpv6.WithOption(dhcpv6.OptBootFileParam("console=ttyS0", "ip=dhcp6")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := NewPacket6(nil, m).BootParams(), []string{"console=ttyS0", "ip=dhcp6"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("BootParams() = %q, want %q", got, want)
+	}
+}
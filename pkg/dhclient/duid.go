@@ -0,0 +1,152 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhclient
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/u-root/u-root/pkg/smbios"
+	"github.com/vishvananda/netlink"
+)
+
+var (
+	errDUID     = errors.New("invalid DUID")
+	errClientID = errors.New("invalid client identifier")
+	errNoSMBIOS = errors.New("no SMBIOS system information")
+)
+
+// systemInfo returns the SMBIOS system information, it is replaced in
+// tests.
+var systemInfo = func() (*smbios.SystemInfo, error) {
+	info, err := smbios.FromSysfs()
+	if err != nil {
+		return nil, err
+	}
+	return info.GetSystemInfo()
+}
+
+// ParseDUID parses a DUID specification:
+//
+//	ll             DUID-LL of the hardware address mac
+//	uuid           DUID-UUID of the SMBIOS system UUID
+//	uuid:<UUID>    DUID-UUID of the given UUID
+//	<hex bytes>    any DUID, type included, e.g. 00:04:...
+func ParseDUID(s string, mac net.HardwareAddr) (dhcpv6.DUID, error) {
+	switch {
+	case s == "ll":
+		if mac == nil {
+			return nil, fmt.Errorf("%w: no hardware address for DUID-LL", errDUID)
+		}
+		return &dhcpv6.DUIDLL{HWType: iana.HWTypeEthernet, LinkLayerAddr: mac}, nil
+
+	case s == "uuid":
+		si, err := systemInfo()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errNoSMBIOS, err)
+		}
+		return DUIDUUID(si.UUID)
+
+	case strings.HasPrefix(s, "uuid:"):
+		b, err := hex.DecodeString(strings.ReplaceAll(strings.TrimPrefix(s, "uuid:"), "-", ""))
+		if err != nil || len(b) != 16 {
+			return nil, fmt.Errorf("%w: %q", errDUID, s)
+		}
+		d := &dhcpv6.DUIDUUID{}
+		copy(d.UUID[:], b)
+		return d, nil
+	}
+	b, err := parseHex(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", errDUID, s)
+	}
+	d, err := dhcpv6.DUIDFromBytes(b)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q: %w", errDUID, s, err)
+	}
+	return d, nil
+}
+
+// DUIDUUID returns the DUID-UUID (RFC 6355) of the SMBIOS system UUID u.
+// SMBIOS stores the first three fields of the UUID little endian, the
+// DUID holds it in network byte order.
+func DUIDUUID(u smbios.UUID) (dhcpv6.DUID, error) {
+	var zero, ones smbios.UUID
+	for i := range ones {
+		ones[i] = 0xff
+	}
+	if u == zero || u == ones {
+		return nil, fmt.Errorf("%w: system UUID is %v", errNoSMBIOS, u)
+	}
+	d := &dhcpv6.DUIDUUID{UUID: [16]byte{
+		u[3], u[2], u[1], u[0],
+		u[5], u[4],
+		u[7], u[6],
+	}}
+	copy(d.UUID[8:], u[8:])
+	return d, nil
+}
+
+// ParseClientID parses a DHCPv4 client identifier specification, "serial"
+// for the SMBIOS system serial number, or hex bytes with the type first.
+func ParseClientID(s string) ([]byte, error) {
+	if s == "serial" {
+		si, err := systemInfo()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errNoSMBIOS, err)
+		}
+		serial := strings.TrimSpace(si.SerialNumber)
+		if serial == "" {
+			return nil, fmt.Errorf("%w: no system serial number", errNoSMBIOS)
+		}
+		// Type 0 is for identifiers other than hardware addresses, RFC
+		// 2132 Section 9.14.
+		return append([]byte{0}, serial...), nil
+	}
+	b, err := parseHex(s)
+	if err != nil || len(b) < 2 {
+		return nil, fmt.Errorf("%w: %q", errClientID, s)
+	}
+	return b, nil
+}
+
+// parseHex parses hex bytes, optionally separated by colons.
+func parseHex(s string) ([]byte, error) {
+	return hex.DecodeString(strings.ReplaceAll(s, ":", ""))
+}
+
+// iaid returns the IAID of iface, the last 4 bytes of its hardware
+// address like dhcpv6.NewSolicit uses.
+func iaid(iface netlink.Link) []byte {
+	mac := iface.Attrs().HardwareAddr
+	if len(mac) < 4 {
+		return make([]byte, 4)
+	}
+	return mac[len(mac)-4:]
+}
+
+// clientID4 returns the DHCPv4 client identifier of iface configured by
+// c, nil for none.
+func clientID4(iface netlink.Link, c Config) []byte {
+	switch {
+	case c.V4ClientID != nil:
+		return c.V4ClientID
+	case !c.V4ClientIdentifier:
+		return nil
+	case c.DUID != nil:
+		// Type 255, IAID and DUID, RFC 4361 Section 6.1.
+		ident := append([]byte{0xff}, iaid(iface)...)
+		return append(ident, c.DUID.ToBytes()...)
+	default:
+		// Client Id is hardware type + mac per RFC 2132 9.14.
+		ident := []byte{0x01} // Type ethernet
+		return append(ident, iface.Attrs().HardwareAddr...)
+	}
+}
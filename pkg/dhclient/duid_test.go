@@ -0,0 +1,130 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhclient
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/u-root/u-root/pkg/smbios"
+	"github.com/vishvananda/netlink"
+)
+
+// fakeSystem makes systemInfo return si for the test.
+func fakeSystem(t *testing.T, si *smbios.SystemInfo) {
+	orig := systemInfo
+	t.Cleanup(func() { systemInfo = orig })
+	systemInfo = func() (*smbios.SystemInfo, error) {
+		if si == nil {
+			return nil, errors.New("no SMBIOS")
+		}
+		return si, nil
+	}
+}
+
+func TestParseDUID(t *testing.T) {
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01}
+	// The SMBIOS encoding of 00112233-4455-6677-8899-aabbccddeeff.
+	fakeSystem(t, &smbios.SystemInfo{UUID: smbios.UUID{
+		0x33, 0x22, 0x11, 0x00, 0x55, 0x44, 0x77, 0x66,
+		0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff,
+	}})
+	uuid := &dhcpv6.DUIDUUID{UUID: [16]byte{
+		0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77,
+		0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff,
+	}}
+	for _, tt := range []struct {
+		in   string
+		want dhcpv6.DUID
+		err  error
+	}{
+		{in: "ll", want: &dhcpv6.DUIDLL{HWType: iana.HWTypeEthernet, LinkLayerAddr: mac}},
+		{in: "uuid", want: uuid},
+		{in: "uuid:00112233-4455-6677-8899-aabbccddeeff", want: uuid},
+		{in: "00:04:00:11:22:33:44:55:66:77:88:99:aa:bb:cc:dd:ee:ff", want: uuid},
+		{in: "00030001020000000001", want: &dhcpv6.DUIDLL{HWType: iana.HWTypeEthernet, LinkLayerAddr: mac}},
+		{in: "uuid:0011", err: errDUID},
+		{in: "duid", err: errDUID},
+		{in: "00", err: errDUID},
+	} {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseDUID(tt.in, mac)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("ParseDUID(%q) = %v, want %v", tt.in, err, tt.err)
+			}
+			if tt.want != nil && (got == nil || !tt.want.Equal(got)) {
+				t.Errorf("ParseDUID(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDUIDNoSMBIOS(t *testing.T) {
+	fakeSystem(t, nil)
+	if _, err := ParseDUID("uuid", nil); !errors.Is(err, errNoSMBIOS) {
+		t.Errorf("ParseDUID(uuid) = %v, want %v", err, errNoSMBIOS)
+	}
+	if _, err := ParseDUID("ll", nil); !errors.Is(err, errDUID) {
+		t.Errorf("ParseDUID(ll) without MAC = %v, want %v", err, errDUID)
+	}
+	fakeSystem(t, &smbios.SystemInfo{})
+	if _, err := ParseDUID("uuid", nil); !errors.Is(err, errNoSMBIOS) {
+		t.Errorf("ParseDUID(uuid) of a zero UUID = %v, want %v", err, errNoSMBIOS)
+	}
+}
+
+func TestParseClientID(t *testing.T) {
+	fakeSystem(t, &smbios.SystemInfo{SerialNumber: "SN1234 "})
+	for _, tt := range []struct {
+		in   string
+		want []byte
+		err  error
+	}{
+		{in: "serial", want: []byte("\x00SN1234")},
+		{in: "01:02:00:00:00:00:01", want: []byte{1, 2, 0, 0, 0, 0, 1}},
+		{in: "ff00", want: []byte{0xff, 0}},
+		{in: "01", err: errClientID},
+		{in: "host", err: errClientID},
+	} {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseClientID(tt.in)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("ParseClientID(%q) = %v, want %v", tt.in, err, tt.err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("ParseClientID(%q) = %x, want %x", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientID4(t *testing.T) {
+	iface := &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{HardwareAddr: net.HardwareAddr{0x02, 0, 0x0a, 0x0b, 0x0c, 0x0d}}}
+	duid := &dhcpv6.DUIDLL{HWType: iana.HWTypeEthernet, LinkLayerAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01}}
+	for _, tt := range []struct {
+		name string
+		c    Config
+		want []byte
+	}{
+		{name: "none", c: Config{DUID: duid}},
+		{name: "mac", c: Config{V4ClientIdentifier: true}, want: []byte{1, 0x02, 0, 0x0a, 0x0b, 0x0c, 0x0d}},
+		{
+			name: "duid",
+			c:    Config{V4ClientIdentifier: true, DUID: duid},
+			want: []byte{0xff, 0x0a, 0x0b, 0x0c, 0x0d, 0, 3, 0, 1, 0x02, 0, 0, 0, 0, 0x01},
+		},
+		{name: "verbatim", c: Config{V4ClientIdentifier: true, V4ClientID: []byte("\x00host")}, want: []byte("\x00host")},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := clientID4(iface, tt.c); !bytes.Equal(got, tt.want) {
+				t.Errorf("clientID4() = %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
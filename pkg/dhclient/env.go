@@ -0,0 +1,155 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhclient
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// Environ returns the configuration of l as KEY=value strings, named
+// after the variables ISC dhclient passes to its dhclient-script. Options
+// without a name of their own are included as new_option_<code>, hex
+// encoded.
+func Environ(l Lease) []string {
+	env := map[string]string{}
+	if iface := l.Link(); iface != nil {
+		env["interface"] = iface.Attrs().Name
+	}
+	switch m4, m6 := l.Message(); {
+	case m4 != nil:
+		environ4(env, m4)
+	case m6 != nil:
+		environ6(env, m6)
+	}
+
+	vars := make([]string, 0, len(env))
+	for k, v := range env {
+		vars = append(vars, k+"="+v)
+	}
+	sort.Strings(vars)
+	return vars
+}
+
+// WriteEnvFile writes the configuration of l to path as shell variable
+// assignments, which a script can source.
+func WriteEnvFile(path string, l Lease) error {
+	var b bytes.Buffer
+	for _, v := range Environ(l) {
+		k, v, _ := strings.Cut(v, "=")
+		fmt.Fprintf(&b, "%s='%s'\n", k, strings.ReplaceAll(v, "'", `'\''`))
+	}
+	return os.WriteFile(path, b.Bytes(), 0o644)
+}
+
+func environ4(env map[string]string, m *dhcpv4.DHCPv4) {
+	env["new_ip_address"] = m.YourIPAddr.String()
+	for code, v := range m.Options {
+		var key, value string
+		switch code {
+		case dhcpv4.OptionSubnetMask.Code():
+			key, value = "new_subnet_mask", net.IP(m.SubnetMask()).String()
+		case dhcpv4.OptionBroadcastAddress.Code():
+			key, value = "new_broadcast_address", m.BroadcastAddress().String()
+		case dhcpv4.OptionRouter.Code():
+			key, value = "new_routers", ipList(m.Router())
+		case dhcpv4.OptionDomainNameServer.Code():
+			key, value = "new_domain_name_servers", ipList(m.DNS())
+		case dhcpv4.OptionNTPServers.Code():
+			key, value = "new_ntp_servers", ipList(m.NTPServers())
+		case dhcpv4.OptionDomainName.Code():
+			key, value = "new_domain_name", m.DomainName()
+		case dhcpv4.OptionHostName.Code():
+			key, value = "new_host_name", m.HostName()
+		case dhcpv4.OptionDNSDomainSearchList.Code():
+			if labels := m.DomainSearch(); labels != nil {
+				key, value = "new_domain_search", strings.Join(labels.Labels, " ")
+			}
+		case dhcpv4.OptionInterfaceMTU.Code():
+			if len(v) == 2 {
+				key, value = "new_interface_mtu", strconv.Itoa(int(v[0])<<8|int(v[1]))
+			}
+		case dhcpv4.OptionIPAddressLeaseTime.Code():
+			key, value = "new_dhcp_lease_time", seconds(m.IPAddressLeaseTime(0))
+		case dhcpv4.OptionRenewTimeValue.Code():
+			key, value = "new_dhcp_renewal_time", seconds(m.IPAddressRenewalTime(0))
+		case dhcpv4.OptionRebindingTimeValue.Code():
+			key, value = "new_dhcp_rebinding_time", seconds(m.IPAddressRebindingTime(0))
+		case dhcpv4.OptionServerIdentifier.Code():
+			key, value = "new_dhcp_server_identifier", m.ServerIdentifier().String()
+		case dhcpv4.OptionDHCPMessageType.Code():
+			key, value = "new_dhcp_message_type", strconv.Itoa(int(m.MessageType()))
+		case dhcpv4.OptionClassIdentifier.Code():
+			key, value = "new_vendor_class_identifier", m.ClassIdentifier()
+		}
+		if key == "" {
+			key, value = fmt.Sprintf("new_option_%d", code), hex.EncodeToString(v)
+		}
+		env[key] = value
+	}
+}
+
+func environ6(env map[string]string, m *dhcpv6.Message) {
+	p := NewPacket6(nil, m)
+	if l := p.Lease(); l != nil {
+		env["new_ip6_address"] = l.IPv6Addr.String()
+		env["new_ip6_prefixlen"] = "128"
+		env["new_preferred_life"] = seconds(l.PreferredLifetime)
+		env["new_max_life"] = seconds(l.ValidLifetime)
+	}
+	var prefixes []string
+	for _, pfx := range p.DelegatedPrefixes() {
+		prefixes = append(prefixes, pfx.Prefix.String())
+	}
+	if prefixes != nil {
+		env["new_ip6_prefix"] = strings.Join(prefixes, " ")
+	}
+	for _, o := range m.Options.Options {
+		var key, value string
+		switch o.Code() {
+		case dhcpv6.OptionClientID, dhcpv6.OptionIANA, dhcpv6.OptionIAPD, dhcpv6.OptionStatusCode:
+			// Covered above, or of no use to the configuration.
+			continue
+		case dhcpv6.OptionServerID:
+			key, value = "new_dhcp6_server_id", hex.EncodeToString(o.ToBytes())
+		case dhcpv6.OptionDNSRecursiveNameServer:
+			key, value = "new_dhcp6_name_servers", ipList(m.Options.DNS())
+		case dhcpv6.OptionDomainSearchList:
+			if labels := m.Options.DomainSearchList(); labels != nil {
+				key, value = "new_dhcp6_domain_search", strings.Join(labels.Labels, " ")
+			}
+		case dhcpv6.OptionNTPServer:
+			key, value = "new_dhcp6_ntp_servers", ipList(m.Options.NTPServers())
+		}
+		if key == "" {
+			key, value = fmt.Sprintf("new_dhcp6_option_%d", uint16(o.Code())), hex.EncodeToString(o.ToBytes())
+		}
+		env[key] = value
+	}
+}
+
+// ipList formats ips as a space separated list.
+func ipList(ips []net.IP) string {
+	s := make([]string, len(ips))
+	for i, ip := range ips {
+		s[i] = ip.String()
+	}
+	return strings.Join(s, " ")
+}
+
+// seconds formats d in whole seconds.
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(d / time.Second))
+}
@@ -0,0 +1,95 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhclient
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/rfc1035label"
+	"github.com/vishvananda/netlink"
+)
+
+func TestEnviron4(t *testing.T) {
+	m, err := dhcpv4.New(
+		dhcpv4.WithYourIP(net.IPv4(192, 0, 2, 5)),
+		dhcpv4.WithNetmask(net.CIDRMask(24, 32)),
+		dhcpv4.WithRouter(net.IPv4(192, 0, 2, 1)),
+		dhcpv4.WithDNS(net.IPv4(192, 0, 2, 53), net.IPv4(192, 0, 2, 54)),
+		dhcpv4.WithLeaseTime(3600),
+		dhcpv4.WithOption(dhcpv4.OptDomainSearch(&rfc1035label.Labels{Labels: []string{"example.com", "example.org"}})),
+		dhcpv4.WithGeneric(dhcpv4.OptionInterfaceMTU, []byte{0x05, 0xdc}),
+		dhcpv4.WithGeneric(dhcpv4.GenericOptionCode(224), []byte{0xca, 0xfe}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eth := &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "eth0"}}
+	want := []string{
+		"interface=eth0",
+		"new_dhcp_lease_time=3600",
+		"new_domain_name_servers=192.0.2.53 192.0.2.54",
+		"new_domain_search=example.com example.org",
+		"new_interface_mtu=1500",
+		"new_ip_address=192.0.2.5",
+		"new_option_224=cafe",
+		"new_routers=192.0.2.1",
+		"new_subnet_mask=255.255.255.0",
+	}
+	if got := Environ(NewPacket4(eth, m)); !reflect.DeepEqual(got, want) {
+		t.Errorf("Environ() = %q, want %q", got, want)
+	}
+}
+
+func TestEnviron6(t *testing.T) {
+	m, err := dhcpv6.NewMessage(dhcpv6.WithDNS(net.ParseIP("2001:db8::53")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Options.Del(dhcpv6.OptionElapsedTime)
+	iana := &dhcpv6.OptIANA{}
+	iana.Options.Add(&dhcpv6.OptIAAddress{
+		IPv6Addr:          net.ParseIP("2001:db8::5"),
+		PreferredLifetime: time.Hour,
+		ValidLifetime:     2 * time.Hour,
+	})
+	m.AddOption(iana)
+
+	want := []string{
+		"new_dhcp6_name_servers=2001:db8::53",
+		"new_ip6_address=2001:db8::5",
+		"new_ip6_prefixlen=128",
+		"new_max_life=7200",
+		"new_preferred_life=3600",
+	}
+	if got := Environ(NewPacket6(nil, m)); !reflect.DeepEqual(got, want) {
+		t.Errorf("Environ() = %q, want %q", got, want)
+	}
+}
+
+func TestWriteEnvFile(t *testing.T) {
+	m, err := dhcpv4.New(dhcpv4.WithYourIP(net.IPv4(192, 0, 2, 5)), dhcpv4.WithOption(dhcpv4.OptDomainName("it's.example")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "eth0.env")
+	if err := WriteEnvFile(path, NewPacket4(nil, m)); err != nil {
+		t.Fatalf("WriteEnvFile() = %v", err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "new_domain_name='it'\\''s.example'\nnew_ip_address='192.0.2.5'\n"
+	if string(b) != want {
+		t.Errorf("WriteEnvFile() wrote %q, want %q", b, want)
+	}
+}
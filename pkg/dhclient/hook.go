@@ -0,0 +1,33 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhclient
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// RunHook runs the program at path for event e of lease l, and waits for
+// it to exit.
+//
+// Like ISC dhclient-script, the program finds the event in the reason
+// variable of its environment, suffixed with 6 for DHCPv6 leases, e.g.
+// BOUND or RENEW6, and the configuration of l in the variables Environ
+// returns.
+func RunHook(ctx context.Context, path string, e Event, l Lease) error {
+	reason := e.String()
+	if _, m6 := l.Message(); m6 != nil {
+		reason += "6"
+	}
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Env = append(append(os.Environ(), "reason="+reason), Environ(l)...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("hook %s for %s: %w", path, reason, err)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhclient
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+func TestRunHook(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skipf("no shell: %v", err)
+	}
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	hook := filepath.Join(dir, "hook")
+	if err := os.WriteFile(hook, []byte("#!/bin/sh\necho \"$reason $new_ip_address\" >> "+out+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	m4, err := dhcpv4.New(dhcpv4.WithYourIP(net.IPv4(192, 0, 2, 5)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m6, err := dhcpv6.NewMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RunHook(context.Background(), hook, EventBound, NewPacket4(nil, m4)); err != nil {
+		t.Fatalf("RunHook() = %v", err)
+	}
+	if err := RunHook(context.Background(), hook, EventExpire, NewPacket6(nil, m6)); err != nil {
+		t.Fatalf("RunHook() = %v", err)
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), "BOUND 192.0.2.5\nEXPIRE6 \n"; got != want {
+		t.Errorf("hook saw %q, want %q", got, want)
+	}
+
+	if err := os.WriteFile(hook, []byte("#!/bin/sh\nexit 1\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := RunHook(context.Background(), hook, EventRenew, NewPacket4(nil, m4)); err == nil || !strings.Contains(err.Error(), "RENEW") {
+		t.Errorf("RunHook() = %v, want failure of the RENEW hook", err)
+	}
+}
+
+func TestEventString(t *testing.T) {
+	for e, want := range map[Event]string{
+		EventBound:  "BOUND",
+		EventRenew:  "RENEW",
+		EventRebind: "REBIND",
+		EventExpire: "EXPIRE",
+		Event(0):    "unknown event 0",
+	} {
+		if got := e.String(); got != want {
+			t.Errorf("Event(%d).String() = %q, want %q", int(e), got, want)
+		}
+	}
+}
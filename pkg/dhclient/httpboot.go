@@ -0,0 +1,82 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhclient
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+// HTTPBootClass is the vendor class of UEFI HTTP Boot clients, and of the
+// servers that offer them a boot URI, as described in the UEFI
+// Specification 2.10 Section 24.7.
+const HTTPBootClass = "HTTPClient"
+
+// httpBootEnterprise is the enterprise number of DHCPv6 vendor classes of
+// HTTP Boot.
+const httpBootEnterprise = 343
+
+// httpBootArch returns the client architecture type of HTTP Boot, RFC 4578,
+// for the CPU architecture u-root runs on.
+func httpBootArch() iana.Arch {
+	switch runtime.GOARCH {
+	case "386":
+		return iana.EFI_X86_HTTP
+	case "arm":
+		return iana.EFI_ARM32_HTTP
+	case "arm64":
+		return iana.EFI_ARM64_HTTP
+	case "riscv64":
+		return iana.EFI_RISCV64_HTTP
+	}
+	return iana.EFI_X86_64_HTTP
+}
+
+// httpBootVendorClass returns the vendor class that HTTP Boot clients send,
+// e.g. HTTPClient:Arch:00016:UNDI:003001 on x86-64.
+func httpBootVendorClass(arch iana.Arch) string {
+	return fmt.Sprintf("%s:Arch:%05d:UNDI:003001", HTTPBootClass, uint16(arch))
+}
+
+// withHTTPBoot4 makes a DHCPv4 request ask for an HTTP boot URI.
+func withHTTPBoot4(arch iana.Arch) dhcpv4.Modifier {
+	return func(m *dhcpv4.DHCPv4) {
+		m.UpdateOption(dhcpv4.OptClassIdentifier(httpBootVendorClass(arch)))
+		m.UpdateOption(dhcpv4.OptClientArch(arch))
+	}
+}
+
+// withHTTPBoot6 makes a DHCPv6 request ask for an HTTP boot URI.
+func withHTTPBoot6(arch iana.Arch) dhcpv6.Modifier {
+	return func(m dhcpv6.DHCPv6) {
+		m.UpdateOption(&dhcpv6.OptVendorClass{
+			EnterpriseNumber: httpBootEnterprise,
+			Data:             [][]byte{[]byte(httpBootVendorClass(arch))},
+		})
+		m.UpdateOption(dhcpv6.OptClientArchType(arch))
+	}
+}
+
+// HTTPBoot returns whether the server offered an HTTP boot URI, with the
+// HTTPClient vendor class.
+func (p *Packet4) HTTPBoot() bool {
+	return strings.HasPrefix(p.P.ClassIdentifier(), HTTPBootClass)
+}
+
+// HTTPBoot returns whether the server offered an HTTP boot URI, with the
+// HTTPClient vendor class.
+func (p *Packet6) HTTPBoot() bool {
+	for _, class := range p.p.Options.VendorClass(httpBootEnterprise) {
+		if strings.HasPrefix(string(class), HTTPBootClass) {
+			return true
+		}
+	}
+	return false
+}
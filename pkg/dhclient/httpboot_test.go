@@ -0,0 +1,72 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhclient
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+func TestHTTPBootRequest(t *testing.T) {
+	const class = "HTTPClient:Arch:00016:UNDI:003001"
+	arch := iana.EFI_X86_64_HTTP
+
+	m4 := mustNew(t, dhcpv4.WithOption(dhcpv4.OptClassIdentifier("PXE UROOT")), withHTTPBoot4(arch))
+	if got := m4.ClassIdentifier(); got != class {
+		t.Errorf("DHCPv4 vendor class = %q, want %q", got, class)
+	}
+	if got := m4.ClientArch(); !reflect.DeepEqual(got, []iana.Arch{arch}) {
+		t.Errorf("DHCPv4 client arch = %v, want %v", got, arch)
+	}
+
+	m6, err := dhcpv6.NewMessage(withHTTPBoot6(arch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m6.Options.VendorClass(httpBootEnterprise); len(got) != 1 || string(got[0]) != class {
+		t.Errorf("DHCPv6 vendor class = %q, want %q", got, class)
+	}
+	if got := m6.Options.ArchTypes(); !reflect.DeepEqual(got, iana.Archs{arch}) {
+		t.Errorf("DHCPv6 client arch = %v, want %v", got, arch)
+	}
+}
+
+func TestHTTPBoot(t *testing.T) {
+	for _, tt := range []struct {
+		class string
+		want  bool
+	}{
+		{class: "HTTPClient", want: true},
+		{class: "HTTPClient:Arch:00016", want: true},
+		{class: "PXEClient", want: false},
+		{class: "", want: false},
+	} {
+		m4 := mustNew(t, withNetbootInfo("http://10.0.0.1/boot.iso", ""))
+		if tt.class != "" {
+			m4.UpdateOption(dhcpv4.OptClassIdentifier(tt.class))
+		}
+		if got := NewPacket4(nil, m4).HTTPBoot(); got != tt.want {
+			t.Errorf("Packet4 with vendor class %q: HTTPBoot() = %t, want %t", tt.class, got, tt.want)
+		}
+
+		m6, err := dhcpv6.NewMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.class != "" {
+			m6.AddOption(&dhcpv6.OptVendorClass{
+				EnterpriseNumber: httpBootEnterprise,
+				Data:             [][]byte{[]byte(tt.class)},
+			})
+		}
+		if got := NewPacket6(nil, m6).HTTPBoot(); got != tt.want {
+			t.Errorf("Packet6 with vendor class %q: HTTPBoot() = %t, want %t", tt.class, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/vishvananda/netlink"
+)
+
+// Policy chooses the lease to keep when racing DHCP on several
+// interfaces.
+type Policy int
+
+// Policies.
+const (
+	// PolicyFirst keeps the first lease obtained.
+	PolicyFirst Policy = iota
+
+	// PolicyBoot keeps the first lease with boot information, see
+	// Lease.Boot, or the first lease obtained if none has any once all
+	// requests are done.
+	PolicyBoot
+)
+
+var (
+	errPolicy   = errors.New("unknown lease policy")
+	errNoLeases = errors.New("no lease obtained on any interface")
+)
+
+var policies = map[string]Policy{
+	"first": PolicyFirst,
+	"boot":  PolicyBoot,
+}
+
+// ParsePolicy returns the policy named s, first or boot.
+func ParsePolicy(s string) (Policy, error) {
+	p, ok := policies[s]
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", errPolicy, s)
+	}
+	return p, nil
+}
+
+func (p Policy) String() string {
+	for s, q := range policies {
+		if p == q {
+			return s
+		}
+	}
+	return fmt.Sprintf("unknown policy (%d)", int(p))
+}
+
+// accepts tells whether l is kept right away under p.
+func (p Policy) accepts(l Lease) bool {
+	switch p {
+	case PolicyBoot:
+		u, err := l.Boot()
+		return err == nil && u != nil
+	default:
+		return true
+	}
+}
+
+// choose returns the result chosen by p from r, calling cancel once it is
+// known.
+func choose(r <-chan *Result, p Policy, cancel func()) (*Result, error) {
+	var first *Result
+	for result := range r {
+		if result.Err != nil {
+			log.Printf("Could not get a %s lease on %s: %v", result.Protocol, result.Interface.Attrs().Name, result.Err)
+			continue
+		}
+		if p.accepts(result.Lease) {
+			cancel()
+			return result, nil
+		}
+		if first == nil {
+			first = result
+		}
+	}
+	cancel()
+	if first == nil {
+		return nil, errNoLeases
+	}
+	return first, nil
+}
+
+// Race sends DHCP requests on all ifs at once like SendRequests, and
+// returns the lease chosen by p. The requests still in flight are
+// canceled as soon as it is chosen; the lease is not configured.
+//
+// The interfaces that were down and did not win are set down again in
+// the background, once the requests on them have ended.
+func Race(ctx context.Context, ifs []netlink.Link, ipv4, ipv6 bool, c Config, linkUpTimeout time.Duration, p Policy) (*Result, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	r := SendRequests(ctx, ifs, ipv4, ipv6, c, linkUpTimeout)
+	result, err := choose(r, p, cancel)
+
+	var down []netlink.Link
+	for _, iface := range ifs {
+		if iface.Attrs().Flags&net.FlagUp != 0 {
+			continue
+		}
+		if result != nil && iface.Attrs().Index == result.Interface.Attrs().Index {
+			continue
+		}
+		down = append(down, iface)
+	}
+	go func() {
+		// Interfaces still being brought up would come back up.
+		for range r {
+		}
+		for _, iface := range down {
+			if err := netlink.LinkSetDown(iface); err != nil {
+				log.Printf("Could not set %s down again: %v", iface.Attrs().Name, err)
+			}
+		}
+	}()
+	return result, err
+}
@@ -0,0 +1,65 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhclient
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+func TestParsePolicy(t *testing.T) {
+	for _, s := range []string{"first", "boot"} {
+		p, err := ParsePolicy(s)
+		if err != nil || p.String() != s {
+			t.Errorf("ParsePolicy(%q) = %v, %v, want %s", s, p, err, s)
+		}
+	}
+	if _, err := ParsePolicy("best"); !errors.Is(err, errPolicy) {
+		t.Errorf("ParsePolicy(best) = %v, want %v", err, errPolicy)
+	}
+}
+
+func TestChoose(t *testing.T) {
+	link := func(name string) netlink.Link {
+		return &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: name}}
+	}
+	plain := &Result{NetIPv4, link("eth0"), NewPacket4(link("eth0"), mustNew(t)), nil}
+	boot := &Result{NetIPv4, link("eth1"), NewPacket4(link("eth1"), mustNew(t, withNetbootInfo("pxelinux.0", "10.0.0.1"))), nil}
+	failed := &Result{NetIPv6, link("eth2"), nil, errors.New("timeout")}
+
+	results := func(rs ...*Result) <-chan *Result {
+		r := make(chan *Result, len(rs))
+		for _, result := range rs {
+			r <- result
+		}
+		close(r)
+		return r
+	}
+	for _, tt := range []struct {
+		name    string
+		results []*Result
+		p       Policy
+		want    *Result
+		err     error
+	}{
+		{name: "first", results: []*Result{failed, plain, boot}, p: PolicyFirst, want: plain},
+		{name: "boot", results: []*Result{failed, plain, boot}, p: PolicyBoot, want: boot},
+		{name: "boot fallback", results: []*Result{plain, failed}, p: PolicyBoot, want: plain},
+		{name: "none", results: []*Result{failed}, p: PolicyFirst, err: errNoLeases},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var canceled bool
+			got, err := choose(results(tt.results...), tt.p, func() { canceled = true })
+			if got != tt.want || !errors.Is(err, tt.err) {
+				t.Errorf("choose() = %v, %v, want %v, %v", got, err, tt.want, tt.err)
+			}
+			if !canceled {
+				t.Errorf("choose() did not cancel the other requests")
+			}
+		})
+	}
+}
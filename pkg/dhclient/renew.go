@@ -0,0 +1,409 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/nclient4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/dhcpv6/nclient6"
+	"github.com/vishvananda/netlink"
+)
+
+// DefaultStateDir is the directory leases are stored in by default.
+const DefaultStateDir = "/var/lib/dhclient"
+
+// minRetry is the shortest pause between two failed attempts to renew
+// or rebind a lease, as RFC 2131 Section 4.4.5 recommends.
+const minRetry = 60 * time.Second
+
+var (
+	errLeaseRefused = errors.New("server refused to extend the lease")
+	errNoLease      = errors.New("no lease in state file")
+)
+
+// StatePath returns the path of the state file of the lease of protocol
+// p on interface ifname in dir.
+func StatePath(dir, ifname string, p NetworkProtocol) string {
+	ext := ".lease4"
+	if p == NetIPv6 {
+		ext = ".lease6"
+	}
+	return filepath.Join(dir, ifname+ext)
+}
+
+// leaseState is the content of a state file.
+type leaseState struct {
+	Interface string          `json:"interface"`
+	Protocol  NetworkProtocol `json:"protocol"`
+	// Obtained is the time the lease was obtained or last extended at,
+	// which its times count from.
+	Obtained time.Time `json:"obtained"`
+	// Message is the DHCP message holding the lease.
+	Message []byte `json:"message"`
+}
+
+// SaveLease writes l, obtained at the given time, to the state file at
+// path, replacing it atomically.
+func SaveLease(path string, l Lease, obtained time.Time) error {
+	s := leaseState{Interface: l.Link().Attrs().Name, Obtained: obtained}
+	switch m4, m6 := l.Message(); {
+	case m4 != nil:
+		s.Protocol, s.Message = NetIPv4, m4.ToBytes()
+	case m6 != nil:
+		s.Protocol, s.Message = NetIPv6, m6.ToBytes()
+	default:
+		return errNoLease
+	}
+	b, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// LoadLease reads a lease written by SaveLease from path, and returns it
+// with the time it was obtained at.
+func LoadLease(path string) (Lease, time.Time, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	var s leaseState
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, time.Time{}, fmt.Errorf("%s: %w", path, err)
+	}
+	iface, err := netlink.LinkByName(s.Interface)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("cannot get interface %q by name: %w", s.Interface, err)
+	}
+	switch s.Protocol {
+	case NetIPv4:
+		m, err := dhcpv4.FromBytes(s.Message)
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("%s: %w", path, err)
+		}
+		return NewPacket4(iface, m), s.Obtained, nil
+	case NetIPv6:
+		m, err := dhcpv6.MessageFromBytes(s.Message)
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("%s: %w", path, err)
+		}
+		return NewPacket6(iface, m), s.Obtained, nil
+	}
+	return nil, time.Time{}, fmt.Errorf("%s: %w", path, errNoLease)
+}
+
+// LeaseTimes returns the times after obtaining l at which to renew it
+// with the server that granted it (T1), to rebind it with any server
+// (T2), and at which it expires. An expiry of 0 means l does not expire.
+func LeaseTimes(l Lease) (renew, rebind, expire time.Duration) {
+	m4, m6 := l.Message()
+	if m4 != nil {
+		expire = m4.IPAddressLeaseTime(0)
+		if expire == 0 || expire == time.Duration(0xffffffff)*time.Second {
+			return 0, 0, 0
+		}
+		return m4.IPAddressRenewalTime(expire / 2), m4.IPAddressRebindingTime(expire * 7 / 8), expire
+	}
+	if m6 == nil {
+		return 0, 0, 0
+	}
+
+	// The lease lasts as long as its shortest-lived address or prefix,
+	// and is extended as early as the earliest IA asks for.
+	var preferred time.Duration
+	update := func(t1, t2, pref, valid time.Duration) {
+		if expire == 0 || valid < expire {
+			expire = valid
+		}
+		if preferred == 0 || pref < preferred {
+			preferred = pref
+		}
+		if t1 > 0 && (renew == 0 || t1 < renew) {
+			renew = t1
+		}
+		if t2 > 0 && (rebind == 0 || t2 < rebind) {
+			rebind = t2
+		}
+	}
+	for _, iana := range m6.Options.IANA() {
+		for _, a := range iana.Options.Addresses() {
+			update(iana.T1, iana.T2, a.PreferredLifetime, a.ValidLifetime)
+		}
+	}
+	for _, iapd := range m6.Options.IAPD() {
+		for _, pfx := range iapd.Options.Prefixes() {
+			update(iapd.T1, iapd.T2, pfx.PreferredLifetime, pfx.ValidLifetime)
+		}
+	}
+	if expire == 0 || expire == time.Duration(0xffffffff)*time.Second {
+		return 0, 0, 0
+	}
+	// RFC 8415 Section 14.2 recommends these defaults.
+	if renew == 0 {
+		renew = preferred / 2
+	}
+	if rebind == 0 {
+		rebind = preferred * 4 / 5
+	}
+	return renew, rebind, expire
+}
+
+// Renew asks the server that granted l to extend it or, if rebind is
+// set, any server. It returns the extended lease, which may differ from
+// l, or errLeaseRefused if the server refused to extend it and a new one
+// has to be requested.
+func Renew(ctx context.Context, l Lease, c Config, rebind bool) (Lease, error) {
+	m4, m6 := l.Message()
+	switch {
+	case m4 != nil:
+		return renew4(ctx, l.Link(), m4, c, rebind)
+	case m6 != nil:
+		p, err := renew6(ctx, l.Link(), m6, c, rebind)
+		if err != nil {
+			return nil, err
+		}
+		if old, ok := l.(*Packet6); ok {
+			p.downstream = old.downstream
+		}
+		return p, nil
+	}
+	return nil, errNoLease
+}
+
+func renew4(ctx context.Context, iface netlink.Link, lease *dhcpv4.DHCPv4, c Config, rebind bool) (*Packet4, error) {
+	client, err := newClient4(iface, c)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	// Renewals and rebinds are requests from the leased address, which
+	// RFC 2131 Section 4.3.2 has carry neither the server identifier
+	// nor the requested address.
+	mods := append([]dhcpv4.Modifier{
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest),
+		dhcpv4.WithHwAddr(client.InterfaceAddr()),
+		dhcpv4.WithClientIP(lease.YourIPAddr),
+		dhcpv4.WithRequestedOptions(dhcpv4.OptionSubnetMask),
+	}, c.Modifiers4...)
+	// The server knows the lease by the client identifier it was
+	// requested with, if any.
+	if ident := clientID4(iface, c); ident != nil {
+		mods = append(mods, dhcpv4.WithOption(dhcpv4.OptClientIdentifier(ident)))
+	}
+	req, err := dhcpv4.New(mods...)
+	if err != nil {
+		return nil, err
+	}
+	dest := &net.UDPAddr{IP: lease.ServerIdentifier(), Port: dhcpv4.ServerPort}
+	if rebind || dest.IP == nil {
+		dest = client.RemoteAddr()
+	}
+	resp, err := client.SendAndRead(ctx, dest, req, nclient4.IsMessageType(dhcpv4.MessageTypeAck, dhcpv4.MessageTypeNak))
+	if err != nil {
+		return nil, err
+	}
+	if resp.MessageType() == dhcpv4.MessageTypeNak {
+		return nil, fmt.Errorf("%w: %s", errLeaseRefused, resp.Message())
+	}
+	return NewPacket4(iface, resp), nil
+}
+
+func renew6(ctx context.Context, iface netlink.Link, reply *dhcpv6.Message, c Config, rebind bool) (*Packet6, error) {
+	client, err := newClient6(iface, c)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	// A Renew, or a Rebind to all servers, holds the IAs of the reply
+	// with the addresses and prefixes to extend (RFC 8415 Section 18.2.4).
+	typ := dhcpv6.MessageTypeRenew
+	if rebind {
+		typ = dhcpv6.MessageTypeRebind
+	}
+	msg, err := dhcpv6.NewMessage(c.Modifiers6...)
+	if err != nil {
+		return nil, err
+	}
+	msg.MessageType = typ
+	msg.UpdateOption(dhcpv6.OptClientID(reply.Options.ClientID()))
+	if !rebind {
+		msg.UpdateOption(dhcpv6.OptServerID(reply.Options.ServerID()))
+	}
+	for _, iana := range reply.Options.IANA() {
+		msg.AddOption(iana)
+	}
+	for _, iapd := range reply.Options.IAPD() {
+		msg.AddOption(iapd)
+	}
+	msg.UpdateOption(dhcpv6.OptElapsedTime(0))
+
+	resp, err := client.SendAndRead(ctx, client.RemoteAddr(), msg, nclient6.IsMessageType(dhcpv6.MessageTypeReply))
+	if err != nil {
+		return nil, err
+	}
+	if s := resp.Options.Status(); s != nil && s.StatusCode != 0 {
+		return nil, fmt.Errorf("%w: %v", errLeaseRefused, s)
+	}
+	p := NewPacket6(iface, resp)
+	if p.Lease() == nil && p.DelegatedPrefixes() == nil {
+		return nil, fmt.Errorf("%w: reply holds no address or prefix", errLeaseRefused)
+	}
+	return p, nil
+}
+
+// Event is what happened to a lease.
+type Event int
+
+// Events of a lease.
+const (
+	// EventBound is a new lease obtained.
+	EventBound Event = iota + 1
+	// EventRenew is a lease extended by the server that granted it.
+	EventRenew
+	// EventRebind is a lease extended by any server.
+	EventRebind
+	// EventExpire is a lease that expired or was refused to be extended.
+	EventExpire
+)
+
+func (e Event) String() string {
+	switch e {
+	case EventBound:
+		return "BOUND"
+	case EventRenew:
+		return "RENEW"
+	case EventRebind:
+		return "REBIND"
+	case EventExpire:
+		return "EXPIRE"
+	}
+	return fmt.Sprintf("unknown event %d", int(e))
+}
+
+// LeaseFunc is called with a lease whenever Maintain extended, replaced
+// or lost it. changed tells whether it differs from the previous one.
+type LeaseFunc func(l Lease, e Event, changed bool) error
+
+// Maintain keeps l, obtained at the given time, alive until ctx is done.
+// It sleeps until the renewal time of l (T1) and renews it, rebinds it
+// from the rebinding time (T2) on, and requests a new lease once it has
+// expired, retrying at half the remaining time, but no more often than
+// once a minute. fn is called with every lease obtained, and with l once
+// it expired. Maintain only returns the error of ctx.
+func Maintain(ctx context.Context, l Lease, obtained time.Time, c Config, linkUpTimeout time.Duration, fn LeaseFunc) error {
+	name := l.Link().Attrs().Name
+	call := func(l Lease, e Event, changed bool) {
+		if fn == nil {
+			return
+		}
+		if err := fn(l, e, changed); err != nil {
+			log.Printf("Could not apply %v lease on %s: %v", e, name, err)
+		}
+	}
+	expired := false
+	for {
+		renew, rebind, expire := LeaseTimes(l)
+		if expire == 0 {
+			// Infinite leases need no care.
+			<-ctx.Done()
+			return ctx.Err()
+		}
+
+		age := time.Since(obtained)
+		var next Lease
+		var err error
+		var e Event
+		var deadline time.Duration
+		switch {
+		case age < renew:
+			if err := sleep(ctx, renew-age); err != nil {
+				return err
+			}
+			continue
+		case age < rebind:
+			log.Printf("Renewing lease on %s", name)
+			next, err = Renew(ctx, l, c, false)
+			e, deadline = EventRenew, rebind
+		case age < expire:
+			log.Printf("Rebinding lease on %s", name)
+			next, err = Renew(ctx, l, c, true)
+			e, deadline = EventRebind, expire
+		default:
+			if !expired {
+				call(l, EventExpire, false)
+				expired = true
+			}
+			log.Printf("Lease on %s expired, requesting a new one", name)
+			next, err = request(ctx, l, c, linkUpTimeout)
+			e = EventBound
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			log.Printf("Could not extend lease on %s: %v", name, err)
+			if errors.Is(err, errLeaseRefused) {
+				// The lease is gone, restart from scratch.
+				obtained = time.Time{}
+				continue
+			}
+			wait := minRetry
+			if deadline > 0 {
+				wait = max((deadline-time.Since(obtained))/2, minRetry)
+			}
+			if err := sleep(ctx, wait); err != nil {
+				return err
+			}
+			continue
+		}
+
+		changed := expired || next.String() != l.String()
+		l, obtained, expired = next, time.Now(), false
+		log.Printf("Extended lease on %s: %s", name, l)
+		call(l, e, changed)
+	}
+}
+
+// request obtains a new lease of the protocol of l on its interface.
+func request(ctx context.Context, l Lease, c Config, linkUpTimeout time.Duration) (Lease, error) {
+	if m4, _ := l.Message(); m4 != nil {
+		return lease4(ctx, l.Link(), c)
+	}
+	return lease6(ctx, l.Link(), c, linkUpTimeout)
+}
+
+// sleep waits for d or until ctx is done, and returns the error of ctx
+// in the latter case.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhclient
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/vishvananda/netlink"
+)
+
+func TestLeaseTimes4(t *testing.T) {
+	for _, tt := range []struct {
+		name                  string
+		mods                  []dhcpv4.Modifier
+		renew, rebind, expire time.Duration
+	}{
+		{
+			name:  "defaults",
+			mods:  []dhcpv4.Modifier{dhcpv4.WithLeaseTime(3600)},
+			renew: 30 * time.Minute, rebind: 52*time.Minute + 30*time.Second, expire: time.Hour,
+		},
+		{
+			name: "server times",
+			mods: []dhcpv4.Modifier{
+				dhcpv4.WithLeaseTime(3600),
+				dhcpv4.WithGeneric(dhcpv4.OptionRenewTimeValue, dhcpv4.Duration(10*time.Minute).ToBytes()),
+				dhcpv4.WithGeneric(dhcpv4.OptionRebindingTimeValue, dhcpv4.Duration(20*time.Minute).ToBytes()),
+			},
+			renew: 10 * time.Minute, rebind: 20 * time.Minute, expire: time.Hour,
+		},
+		{
+			name: "infinite",
+			mods: []dhcpv4.Modifier{dhcpv4.WithLeaseTime(0xffffffff)},
+		},
+		{
+			name: "no lease time",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := dhcpv4.New(tt.mods...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			renew, rebind, expire := LeaseTimes(NewPacket4(nil, m))
+			if renew != tt.renew || rebind != tt.rebind || expire != tt.expire {
+				t.Errorf("LeaseTimes() = %v, %v, %v, want %v, %v, %v", renew, rebind, expire, tt.renew, tt.rebind, tt.expire)
+			}
+		})
+	}
+}
+
+func TestLeaseTimes6(t *testing.T) {
+	m, err := dhcpv6.NewMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	iana := &dhcpv6.OptIANA{}
+	iana.Options.Add(&dhcpv6.OptIAAddress{
+		IPv6Addr:          net.ParseIP("2001:db8::5"),
+		PreferredLifetime: time.Hour,
+		ValidLifetime:     2 * time.Hour,
+	})
+	m.AddOption(iana)
+	iapd := &dhcpv6.OptIAPD{T1: 20 * time.Minute, T2: 40 * time.Minute}
+	iapd.Options.Add(&dhcpv6.OptIAPrefix{
+		PreferredLifetime: 3 * time.Hour,
+		ValidLifetime:     4 * time.Hour,
+		Prefix:            mustCIDR(t, "2001:db8:1200::/56"),
+	})
+	m.AddOption(iapd)
+
+	// The IA_PD asks to be renewed first, the address expires first.
+	renew, rebind, expire := LeaseTimes(NewPacket6(nil, m))
+	if renew != 20*time.Minute || rebind != 40*time.Minute || expire != 2*time.Hour {
+		t.Errorf("LeaseTimes() = %v, %v, %v, want 20m0s, 40m0s, 2h0m0s", renew, rebind, expire)
+	}
+
+	// Without T1 and T2, RFC 8415 defaults apply to the preferred lifetime.
+	iapd.T1, iapd.T2 = 0, 0
+	renew, rebind, expire = LeaseTimes(NewPacket6(nil, m))
+	if renew != 30*time.Minute || rebind != 48*time.Minute || expire != 2*time.Hour {
+		t.Errorf("LeaseTimes() = %v, %v, %v, want 30m0s, 48m0s, 2h0m0s", renew, rebind, expire)
+	}
+}
+
+func TestSaveLease(t *testing.T) {
+	lo, err := netlink.LinkByName("lo")
+	if err != nil {
+		t.Skipf("no loopback interface: %v", err)
+	}
+	m, err := dhcpv4.New(dhcpv4.WithYourIP(net.IPv4(192, 0, 2, 5)), dhcpv4.WithLeaseTime(3600))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := StatePath(filepath.Join(t.TempDir(), "state"), "lo", NetIPv4)
+	obtained := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := SaveLease(path, NewPacket4(lo, m), obtained); err != nil {
+		t.Fatalf("SaveLease() = %v", err)
+	}
+
+	l, got, err := LoadLease(path)
+	if err != nil {
+		t.Fatalf("LoadLease() = %v", err)
+	}
+	if !got.Equal(obtained) {
+		t.Errorf("LoadLease() obtained at %v, want %v", got, obtained)
+	}
+	if m4, _ := l.Message(); m4 == nil || !m4.YourIPAddr.Equal(m.YourIPAddr) {
+		t.Errorf("LoadLease() = %v, want lease of %v", l, m.YourIPAddr)
+	}
+	if name := l.Link().Attrs().Name; name != "lo" {
+		t.Errorf("LoadLease() lease on %q, want lo", name)
+	}
+}
+
+func TestStatePath(t *testing.T) {
+	if got, want := StatePath("/var/lib/dhclient", "eth0", NetIPv6), "/var/lib/dhclient/eth0.lease6"; got != want {
+		t.Errorf("StatePath() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhclient
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/vishvananda/netlink"
+)
+
+var errVLAN = errors.New("invalid VLAN ID")
+
+// VLANName returns the name of the VLAN id on parent, e.g. eth0.100.
+func VLANName(parent string, id int) string {
+	return fmt.Sprintf("%s.%d", parent, id)
+}
+
+// AddVLAN creates the 802.1Q VLAN id on parent, named after VLANName, and
+// returns it. An existing VLAN of that name is returned as is, if it is
+// the same.
+func AddVLAN(parent netlink.Link, id int) (netlink.Link, error) {
+	if id < 1 || id > 4094 {
+		return nil, fmt.Errorf("%w: %d", errVLAN, id)
+	}
+	name := VLANName(parent.Attrs().Name, id)
+	if l, err := netlink.LinkByName(name); err == nil {
+		if v, ok := l.(*netlink.Vlan); ok && v.VlanId == id && v.ParentIndex == parent.Attrs().Index {
+			return l, nil
+		}
+		return nil, fmt.Errorf("interface %s exists and is not VLAN %d on %s", name, id, parent.Attrs().Name)
+	}
+	// The VLAN only comes up along with its parent.
+	if err := netlink.LinkSetUp(parent); err != nil {
+		return nil, fmt.Errorf("interface %s: can't make it up: %w", parent.Attrs().Name, err)
+	}
+	vlan := &netlink.Vlan{
+		LinkAttrs: netlink.LinkAttrs{Name: name, ParentIndex: parent.Attrs().Index},
+		VlanId:    id,
+	}
+	if err := netlink.LinkAdd(vlan); err != nil {
+		return nil, fmt.Errorf("adding VLAN %d on %s: %w", id, parent.Attrs().Name, err)
+	}
+	return netlink.LinkByName(name)
+}
+
+// VLANID returns the VLAN ID of DHCPv4 option 132 in l, and whether there
+// is one. Servers send it as 16 or 32 bit integer, or as decimal string.
+func VLANID(l Lease) (int, bool) {
+	m, _ := l.Message()
+	if m == nil {
+		return 0, false
+	}
+	b := m.Options.Get(dhcpv4.Option8021PVLANID)
+	var id int
+	if n, err := strconv.Atoi(strings.TrimRight(string(b), "\x00")); err == nil {
+		id = n
+	} else if len(b) == 2 {
+		id = int(binary.BigEndian.Uint16(b))
+	} else if len(b) == 4 {
+		id = int(binary.BigEndian.Uint32(b))
+	}
+	if id < 1 || id > 4094 {
+		return 0, false
+	}
+	return id, true
+}
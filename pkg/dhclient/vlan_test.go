@@ -0,0 +1,46 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhclient
+
+import (
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+func TestVLANID(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		value []byte
+		want  int
+		ok    bool
+	}{
+		{name: "none"},
+		{name: "16 bit", value: []byte{0, 100}, want: 100, ok: true},
+		{name: "32 bit", value: []byte{0, 0, 0x0f, 0xfe}, want: 4094, ok: true},
+		{name: "string", value: []byte("1000"), want: 1000, ok: true},
+		{name: "nul terminated string", value: []byte("42\x00"), want: 42, ok: true},
+		{name: "zero", value: []byte{0, 0}},
+		{name: "too large", value: []byte("4095")},
+		{name: "garbage", value: []byte{1, 2, 3}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var mods []dhcpv4.Modifier
+			if tt.value != nil {
+				mods = append(mods, dhcpv4.WithGeneric(dhcpv4.Option8021PVLANID, tt.value))
+			}
+			id, ok := VLANID(NewPacket4(nil, mustNew(t, mods...)))
+			if id != tt.want || ok != tt.ok {
+				t.Errorf("VLANID() = %d, %t, want %d, %t", id, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestVLANName(t *testing.T) {
+	if got := VLANName("eth0", 100); got != "eth0.100" {
+		t.Errorf("VLANName() = %q, want eth0.100", got)
+	}
+}
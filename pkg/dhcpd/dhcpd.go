@@ -0,0 +1,321 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package dhcpd implements a minimal DHCPv4 server handing out addresses
+// of a static range, with per-MAC reservations and the boot server and
+// file options PXE clients chain to.
+//
+// It keeps its leases in memory only, which is enough to bootstrap the
+// machines of an isolated provisioning network.
+package dhcpd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+const (
+	// DefaultLeaseTime is the lease time if the config has none.
+	DefaultLeaseTime = time.Hour
+
+	// offerTime is how long an offered address is held for the client to
+	// request it.
+	offerTime = time.Minute
+)
+
+var (
+	errNoServerIP = errors.New("server IP is not in the subnet")
+	errRange      = errors.New("address range is not in the subnet")
+	errNoAddress  = errors.New("no free address left")
+)
+
+// Config is a DHCPv4 server configuration.
+type Config struct {
+	// ServerIP is the address of the server, and its server identifier.
+	ServerIP net.IP
+
+	// Subnet is the network the addresses are handed out of.
+	Subnet *net.IPNet
+
+	// RangeStart and RangeEnd are the first and last address of the
+	// range handed out to clients without a reservation.
+	RangeStart, RangeEnd net.IP
+
+	// Reservations assign fixed addresses to hardware addresses, keyed
+	// by their net.HardwareAddr.String form. They need not be in the
+	// range, but must be in the subnet.
+	Reservations map[string]net.IP
+
+	// Router is announced as the default gateway (option 3), if set.
+	Router net.IP
+
+	// DNS are announced as the domain name servers (option 6).
+	DNS []net.IP
+
+	// LeaseTime is the time the addresses are leased for. If not set, it
+	// defaults to DefaultLeaseTime.
+	LeaseTime time.Duration
+
+	// TFTPServer is the boot server (option 66) PXE clients fetch the
+	// boot file from. If it is an IP address, it is also sent as next
+	// server address (siaddr).
+	TFTPServer string
+
+	// BootFile is the boot file name (option 67).
+	BootFile string
+}
+
+// lease is an address handed out to a client.
+type lease struct {
+	mac     string
+	ip      uint32
+	expires time.Time
+}
+
+// Server is a DHCPv4 server serving a Config.
+type Server struct {
+	c Config
+
+	mu sync.Mutex
+	// leases are the leases by hardware address.
+	leases map[string]*lease
+	// addrs are the leases by address. Declined addresses have a lease
+	// without hardware address.
+	addrs map[uint32]*lease
+
+	// now returns the current time.
+	now func() time.Time
+}
+
+// New returns a server for c.
+func New(c Config) (*Server, error) {
+	if c.Subnet == nil || !c.Subnet.Contains(c.ServerIP) {
+		return nil, fmt.Errorf("%w: %v not in %v", errNoServerIP, c.ServerIP, c.Subnet)
+	}
+	if c.RangeStart != nil || c.RangeEnd != nil {
+		if !c.Subnet.Contains(c.RangeStart) || !c.Subnet.Contains(c.RangeEnd) || ip4(c.RangeStart) > ip4(c.RangeEnd) {
+			return nil, fmt.Errorf("%w: %v-%v not in %v", errRange, c.RangeStart, c.RangeEnd, c.Subnet)
+		}
+	}
+	for mac, ip := range c.Reservations {
+		if !c.Subnet.Contains(ip) {
+			return nil, fmt.Errorf("%w: reservation %v of %s not in %v", errRange, ip, mac, c.Subnet)
+		}
+	}
+	if c.LeaseTime == 0 {
+		c.LeaseTime = DefaultLeaseTime
+	}
+	return &Server{
+		c:      c,
+		leases: map[string]*lease{},
+		addrs:  map[uint32]*lease{},
+		now:    time.Now,
+	}, nil
+}
+
+// ip4 returns ip as a number, to iterate over ranges.
+func ip4(ip net.IP) uint32 {
+	ip = ip.To4()
+	if ip == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(ip)
+}
+
+// ipOf returns the address of number n.
+func ipOf(n uint32) net.IP {
+	return binary.BigEndian.AppendUint32(nil, n)
+}
+
+// Handler answers m on conn, to be served by a server4.Server.
+func (s *Server) Handler(conn net.PacketConn, peer net.Addr, m *dhcpv4.DHCPv4) {
+	reply, err := s.Reply(m)
+	if err != nil {
+		log.Printf("Not answering %s from %s: %v", m.MessageType(), m.ClientHWAddr, err)
+		return
+	}
+	if reply == nil {
+		return
+	}
+	log.Printf("Sending %s to %s", reply.MessageType(), peer)
+	if _, err := conn.WriteTo(reply.ToBytes(), peer); err != nil {
+		log.Printf("Could not send %s to %s: %v", reply.MessageType(), peer, err)
+	}
+}
+
+// Reply returns the reply to m, nil if it does not warrant one.
+func (s *Server) Reply(m *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, error) {
+	if m.OpCode != dhcpv4.OpcodeBootRequest {
+		return nil, nil
+	}
+	mac := m.ClientHWAddr.String()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+
+	switch m.MessageType() {
+	case dhcpv4.MessageTypeDiscover:
+		ip, err := s.allocate(mac, m.RequestedIPAddress(), now)
+		if err != nil {
+			return nil, err
+		}
+		s.bind(mac, ip, now.Add(offerTime))
+		return s.reply(m, dhcpv4.MessageTypeOffer, ipOf(ip))
+
+	case dhcpv4.MessageTypeRequest:
+		if sid := m.ServerIdentifier(); sid != nil && !sid.Equal(s.c.ServerIP) {
+			// The client chose another server's offer.
+			if l := s.leases[mac]; l != nil && l.expires.Sub(now) <= offerTime {
+				s.release(l)
+			}
+			return nil, nil
+		}
+		want := m.RequestedIPAddress()
+		if want == nil {
+			// Renewing or rebinding clients use their address.
+			want = m.ClientIPAddr
+		}
+		if ip, err := s.allocate(mac, want, now); err != nil || ip != ip4(want) {
+			return s.reply(m, dhcpv4.MessageTypeNak, nil)
+		}
+		s.bind(mac, ip4(want), now.Add(s.c.LeaseTime))
+		return s.reply(m, dhcpv4.MessageTypeAck, want)
+
+	case dhcpv4.MessageTypeDecline:
+		// Someone else uses the address, keep it from being handed out
+		// again for a lease time.
+		if l := s.leases[mac]; l != nil {
+			s.release(l)
+			s.addrs[l.ip] = &lease{ip: l.ip, expires: now.Add(s.c.LeaseTime)}
+		}
+		return nil, nil
+
+	case dhcpv4.MessageTypeRelease:
+		if l := s.leases[mac]; l != nil && l.ip == ip4(m.ClientIPAddr) {
+			s.release(l)
+		}
+		return nil, nil
+
+	case dhcpv4.MessageTypeInform:
+		return s.reply(m, dhcpv4.MessageTypeAck, nil)
+	}
+	return nil, nil
+}
+
+// allocate returns the address for mac: its reservation, its current
+// lease, the address it wants if free, or the first free one of the
+// range, in that order.
+func (s *Server) allocate(mac string, want net.IP, now time.Time) (uint32, error) {
+	if ip, ok := s.c.Reservations[mac]; ok {
+		return ip4(ip), nil
+	}
+	if l := s.leases[mac]; l != nil {
+		return l.ip, nil
+	}
+	if want != nil && s.free(ip4(want), now) {
+		return ip4(want), nil
+	}
+	if s.c.RangeStart != nil {
+		for ip := ip4(s.c.RangeStart); ip <= ip4(s.c.RangeEnd) && ip != 0; ip++ {
+			if s.free(ip, now) {
+				return ip, nil
+			}
+		}
+	}
+	return 0, errNoAddress
+}
+
+// free tells whether ip is in the range and neither leased nor reserved.
+func (s *Server) free(ip uint32, now time.Time) bool {
+	if s.c.RangeStart == nil || ip < ip4(s.c.RangeStart) || ip > ip4(s.c.RangeEnd) || ip == ip4(s.c.ServerIP) {
+		return false
+	}
+	if l := s.addrs[ip]; l != nil && now.Before(l.expires) {
+		return false
+	}
+	for _, r := range s.c.Reservations {
+		if ip4(r) == ip {
+			return false
+		}
+	}
+	return true
+}
+
+// bind leases ip to mac until expires.
+func (s *Server) bind(mac string, ip uint32, expires time.Time) {
+	if l := s.leases[mac]; l != nil {
+		s.release(l)
+	}
+	if l := s.addrs[ip]; l != nil {
+		s.release(l)
+	}
+	l := &lease{mac: mac, ip: ip, expires: expires}
+	s.leases[mac] = l
+	s.addrs[ip] = l
+}
+
+// release frees l.
+func (s *Server) release(l *lease) {
+	if s.leases[l.mac] == l {
+		delete(s.leases, l.mac)
+	}
+	if s.addrs[l.ip] == l {
+		delete(s.addrs, l.ip)
+	}
+}
+
+// reply returns a reply to m of type t leasing ip, if set.
+func (s *Server) reply(m *dhcpv4.DHCPv4, t dhcpv4.MessageType, ip net.IP) (*dhcpv4.DHCPv4, error) {
+	mods := []dhcpv4.Modifier{
+		dhcpv4.WithMessageType(t),
+		// RFC 2131, Section 4.3.1. Server Identifier: MUST
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(s.c.ServerIP)),
+	}
+	if t != dhcpv4.MessageTypeNak {
+		mods = append(mods,
+			dhcpv4.WithNetmask(s.c.Subnet.Mask),
+			dhcpv4.WithServerIP(s.c.ServerIP),
+		)
+		if s.c.Router != nil {
+			mods = append(mods, dhcpv4.WithRouter(s.c.Router))
+		}
+		if s.c.DNS != nil {
+			mods = append(mods, dhcpv4.WithDNS(s.c.DNS...))
+		}
+		if s.c.TFTPServer != "" {
+			mods = append(mods, dhcpv4.WithOption(dhcpv4.OptTFTPServerName(s.c.TFTPServer)))
+			if ip := net.ParseIP(s.c.TFTPServer); ip != nil {
+				mods = append(mods, dhcpv4.WithServerIP(ip))
+			}
+		}
+		if s.c.BootFile != "" {
+			mods = append(mods, dhcpv4.WithOption(dhcpv4.OptBootFileName(s.c.BootFile)))
+		}
+	}
+	if ip != nil {
+		mods = append(mods,
+			dhcpv4.WithYourIP(ip),
+			// RFC 2131, Section 4.3.1. IP lease time: MUST
+			dhcpv4.WithOption(dhcpv4.OptIPAddressLeaseTime(s.c.LeaseTime)),
+		)
+	}
+	reply, err := dhcpv4.NewReplyFromRequest(m, mods...)
+	if err != nil {
+		return nil, err
+	}
+	reply.BootFileName = s.c.BootFile
+	// RFC 6842, MUST include Client Identifier if client specified one.
+	if val := m.Options.Get(dhcpv4.OptionClientIdentifier); len(val) > 0 {
+		reply.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionClientIdentifier, val))
+	}
+	return reply, nil
+}
@@ -0,0 +1,196 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhcpd
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+var (
+	serverIP = net.IPv4(192, 168, 0, 1)
+	macA     = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x0a}
+	macB     = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x0b}
+	macR     = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x0c}
+)
+
+func newServer(t *testing.T) *Server {
+	t.Helper()
+	_, subnet, _ := net.ParseCIDR("192.168.0.0/24")
+	s, err := New(Config{
+		ServerIP:     serverIP,
+		Subnet:       subnet,
+		RangeStart:   net.IPv4(192, 168, 0, 100),
+		RangeEnd:     net.IPv4(192, 168, 0, 101),
+		Reservations: map[string]net.IP{macR.String(): net.IPv4(192, 168, 0, 10)},
+		Router:       serverIP,
+		TFTPServer:   "192.168.0.2",
+		BootFile:     "pxelinux.0",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func message(t *testing.T, mac net.HardwareAddr, typ dhcpv4.MessageType, mods ...dhcpv4.Modifier) *dhcpv4.DHCPv4 {
+	t.Helper()
+	m, err := dhcpv4.New(append([]dhcpv4.Modifier{dhcpv4.WithHwAddr(mac), dhcpv4.WithMessageType(typ)}, mods...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func reply(t *testing.T, s *Server, m *dhcpv4.DHCPv4) *dhcpv4.DHCPv4 {
+	t.Helper()
+	r, err := s.Reply(m)
+	if err != nil {
+		t.Fatalf("Reply(%s) = %v", m.MessageType(), err)
+	}
+	if r == nil {
+		t.Fatalf("Reply(%s) = nil, want a reply", m.MessageType())
+	}
+	return r
+}
+
+func TestLease(t *testing.T) {
+	s := newServer(t)
+	offer := reply(t, s, message(t, macA, dhcpv4.MessageTypeDiscover))
+	if offer.MessageType() != dhcpv4.MessageTypeOffer || !offer.YourIPAddr.Equal(net.IPv4(192, 168, 0, 100)) {
+		t.Fatalf("offer = %s of %v, want OFFER of 192.168.0.100", offer.MessageType(), offer.YourIPAddr)
+	}
+	if got := offer.TFTPServerName(); got != "192.168.0.2" {
+		t.Errorf("offer TFTP server = %q, want 192.168.0.2", got)
+	}
+	if got := offer.BootFileNameOption(); got != "pxelinux.0" {
+		t.Errorf("offer boot file = %q, want pxelinux.0", got)
+	}
+	if !offer.ServerIPAddr.Equal(net.IPv4(192, 168, 0, 2)) {
+		t.Errorf("offer next server = %v, want 192.168.0.2", offer.ServerIPAddr)
+	}
+
+	// The offered address is held for the client.
+	if other := reply(t, s, message(t, macB, dhcpv4.MessageTypeDiscover)); !other.YourIPAddr.Equal(net.IPv4(192, 168, 0, 101)) {
+		t.Errorf("second offer of %v, want 192.168.0.101", other.YourIPAddr)
+	}
+
+	ack := reply(t, s, message(t, macA, dhcpv4.MessageTypeRequest,
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(serverIP)),
+		dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(offer.YourIPAddr))))
+	if ack.MessageType() != dhcpv4.MessageTypeAck || !ack.YourIPAddr.Equal(offer.YourIPAddr) {
+		t.Errorf("ack = %s of %v, want ACK of %v", ack.MessageType(), ack.YourIPAddr, offer.YourIPAddr)
+	}
+	if got := ack.IPAddressLeaseTime(0); got != DefaultLeaseTime {
+		t.Errorf("lease time = %v, want %v", got, DefaultLeaseTime)
+	}
+	if !ack.ServerIdentifier().Equal(serverIP) {
+		t.Errorf("server identifier = %v, want %v", ack.ServerIdentifier(), serverIP)
+	}
+
+	// Renewals request the address of the client.
+	renew := reply(t, s, message(t, macA, dhcpv4.MessageTypeRequest, dhcpv4.WithClientIP(offer.YourIPAddr)))
+	if renew.MessageType() != dhcpv4.MessageTypeAck {
+		t.Errorf("renewal = %s, want ACK", renew.MessageType())
+	}
+
+	// Requests for the address of another client are refused.
+	nak := reply(t, s, message(t, macB, dhcpv4.MessageTypeRequest, dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(offer.YourIPAddr))))
+	if nak.MessageType() != dhcpv4.MessageTypeNak {
+		t.Errorf("conflicting request = %s, want NAK", nak.MessageType())
+	}
+}
+
+func TestReservation(t *testing.T) {
+	s := newServer(t)
+	offer := reply(t, s, message(t, macR, dhcpv4.MessageTypeDiscover, dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(net.IPv4(192, 168, 0, 100)))))
+	if !offer.YourIPAddr.Equal(net.IPv4(192, 168, 0, 10)) {
+		t.Errorf("offer of %v, want reserved 192.168.0.10", offer.YourIPAddr)
+	}
+	// Nobody else gets the reserved address.
+	nak := reply(t, s, message(t, macA, dhcpv4.MessageTypeRequest, dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(net.IPv4(192, 168, 0, 10)))))
+	if nak.MessageType() != dhcpv4.MessageTypeNak {
+		t.Errorf("request of reserved address = %s, want NAK", nak.MessageType())
+	}
+}
+
+func TestExhausted(t *testing.T) {
+	s := newServer(t)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	reply(t, s, message(t, macA, dhcpv4.MessageTypeDiscover))
+	reply(t, s, message(t, macB, dhcpv4.MessageTypeDiscover))
+	third := net.HardwareAddr{0x02, 0, 0, 0, 0, 0x0d}
+	if _, err := s.Reply(message(t, third, dhcpv4.MessageTypeDiscover)); !errors.Is(err, errNoAddress) {
+		t.Errorf("Reply() = %v, want %v", err, errNoAddress)
+	}
+
+	// Offers expire, and released addresses are free again.
+	now = now.Add(2 * offerTime)
+	if offer := reply(t, s, message(t, third, dhcpv4.MessageTypeDiscover)); !offer.YourIPAddr.Equal(net.IPv4(192, 168, 0, 100)) {
+		t.Errorf("offer of %v after expiry, want 192.168.0.100", offer.YourIPAddr)
+	}
+	reply(t, s, message(t, macB, dhcpv4.MessageTypeRequest, dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(net.IPv4(192, 168, 0, 101)))))
+	if r, err := s.Reply(message(t, macB, dhcpv4.MessageTypeRelease, dhcpv4.WithClientIP(net.IPv4(192, 168, 0, 101)))); r != nil || err != nil {
+		t.Errorf("Reply(RELEASE) = %v, %v, want nil, nil", r, err)
+	}
+	if offer := reply(t, s, message(t, macA, dhcpv4.MessageTypeDiscover)); !offer.YourIPAddr.Equal(net.IPv4(192, 168, 0, 101)) {
+		t.Errorf("offer of %v after release, want 192.168.0.101", offer.YourIPAddr)
+	}
+}
+
+func TestOtherServer(t *testing.T) {
+	s := newServer(t)
+	reply(t, s, message(t, macA, dhcpv4.MessageTypeDiscover))
+	r, err := s.Reply(message(t, macA, dhcpv4.MessageTypeRequest,
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(net.IPv4(192, 168, 0, 254))),
+		dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(net.IPv4(192, 168, 0, 200)))))
+	if r != nil || err != nil {
+		t.Errorf("Reply() = %v, %v, want no reply to a request for another server", r, err)
+	}
+	// The offer is withdrawn.
+	if offer := reply(t, s, message(t, macB, dhcpv4.MessageTypeDiscover)); !offer.YourIPAddr.Equal(net.IPv4(192, 168, 0, 100)) {
+		t.Errorf("offer of %v, want withdrawn 192.168.0.100", offer.YourIPAddr)
+	}
+}
+
+func TestNew(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("192.168.0.0/24")
+	for _, tt := range []struct {
+		name string
+		c    Config
+		want error
+	}{
+		{name: "no subnet", c: Config{ServerIP: serverIP}, want: errNoServerIP},
+		{name: "server outside", c: Config{ServerIP: net.IPv4(10, 0, 0, 1), Subnet: subnet}, want: errNoServerIP},
+		{
+			name: "range outside",
+			c:    Config{ServerIP: serverIP, Subnet: subnet, RangeStart: net.IPv4(192, 168, 0, 10), RangeEnd: net.IPv4(192, 168, 1, 10)},
+			want: errRange,
+		},
+		{
+			name: "range reversed",
+			c:    Config{ServerIP: serverIP, Subnet: subnet, RangeStart: net.IPv4(192, 168, 0, 20), RangeEnd: net.IPv4(192, 168, 0, 10)},
+			want: errRange,
+		},
+		{
+			name: "reservation outside",
+			c:    Config{ServerIP: serverIP, Subnet: subnet, Reservations: map[string]net.IP{macA.String(): net.IPv4(10, 0, 0, 5)}},
+			want: errRange,
+		},
+		{name: "reservations only", c: Config{ServerIP: serverIP, Subnet: subnet}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.c); !errors.Is(err, tt.want) {
+				t.Errorf("New() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
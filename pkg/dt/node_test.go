@@ -464,3 +464,29 @@ func TestAsRegion(t *testing.T) {
 		})
 	}
 }
+
+func TestAsStringList(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		value   []byte
+		want    []string
+		wantErr bool
+	}{
+		{name: "one", value: []byte("linux,dummy-virt\x00"), want: []string{"linux,dummy-virt"}},
+		{name: "two", value: []byte("vendor,board\x00vendor,soc\x00"), want: []string{"vendor,board", "vendor,soc"}},
+		{name: "empty", value: []byte{}, wantErr: true},
+		{name: "unterminated", value: []byte("vendor,board"), wantErr: true},
+		{name: "binary", value: []byte{1, 2, 0}, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &Property{Name: "compatible", Value: tc.value}
+			got, err := p.AsStringList()
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("AsStringList() = %v, want error %v", err, tc.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("AsStringList() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -126,3 +126,11 @@ func (pq *PropertyWalk) AsBytes() ([]byte, error) {
 	}
 	return pq.p.Value, nil
 }
+
+// AsStringList returns the PropertyWalk value as a []string.
+func (pq *PropertyWalk) AsStringList() ([]string, error) {
+	if pq.err != nil {
+		return nil, pq.err
+	}
+	return pq.p.AsStringList()
+}
@@ -0,0 +1,89 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lldp
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"time"
+
+	"github.com/gopacket/gopacket/layers"
+	"github.com/mdlayher/packet"
+	"golang.org/x/sys/unix"
+)
+
+// Conn sends and receives LLDP frames on an interface.
+type Conn struct {
+	ifi *net.Interface
+	c   *packet.Conn
+}
+
+// Listen returns a Conn on ifi, which joins the LLDP multicast group.
+func Listen(ifi *net.Interface) (*Conn, error) {
+	c, err := packet.Listen(ifi, packet.Raw, int(layers.EthernetTypeLinkLayerDiscovery), nil)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := c.SyscallConn()
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	mreq := &unix.PacketMreq{
+		Ifindex: int32(ifi.Index),
+		Type:    unix.PACKET_MR_MULTICAST,
+		Alen:    uint16(len(Multicast)),
+	}
+	copy(mreq.Address[:], Multicast)
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		serr = unix.SetsockoptPacketMreq(int(fd), unix.SOL_PACKET, unix.PACKET_ADD_MEMBERSHIP, mreq)
+	}); err != nil {
+		serr = err
+	}
+	if serr != nil {
+		c.Close()
+		return nil, serr
+	}
+	return &Conn{ifi: ifi, c: c}, nil
+}
+
+// Close closes c.
+func (c *Conn) Close() error {
+	return c.c.Close()
+}
+
+// Send sends an LLDP frame announcing the interface of c, see Frame.
+func (c *Conn) Send(systemName string, ttl time.Duration) error {
+	b, err := Frame(c.ifi, systemName, ttl)
+	if err != nil {
+		return err
+	}
+	_, err = c.c.WriteTo(b, &packet.Addr{HardwareAddr: Multicast})
+	return err
+}
+
+// Receive returns the next neighbor heard of, until ctx is done. Frames
+// that do not parse are skipped, and so are the ones sent by c.
+func (c *Conn) Receive(ctx context.Context) (*Neighbor, error) {
+	stop := context.AfterFunc(ctx, func() { c.c.SetReadDeadline(time.Now()) })
+	defer stop()
+	defer c.c.SetReadDeadline(time.Time{})
+
+	b := make([]byte, 9000)
+	for {
+		n, _, err := c.c.ReadFrom(b)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if nb, err := Parse(b[:n]); err == nil && !bytes.Equal(nb.Source, c.ifi.HardwareAddr) {
+			return nb, nil
+		}
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lldp
+
+import (
+	"context"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// newLink runs the rest of the test in a new network namespace with the
+// veth pair host0 and switch0 up.
+func newLink(t *testing.T) (*net.Interface, *net.Interface) {
+	t.Helper()
+	runtime.LockOSThread()
+	t.Cleanup(runtime.UnlockOSThread)
+	orig, err := netns.Get()
+	if err != nil {
+		t.Skipf("no network namespaces: %v", err)
+	}
+	t.Cleanup(func() { orig.Close() })
+	ns, err := netns.New()
+	if err != nil {
+		t.Skipf("cannot create a network namespace: %v", err)
+	}
+	t.Cleanup(func() {
+		netns.Set(orig)
+		ns.Close()
+	})
+	h, err := netlink.NewHandleAt(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(h.Close)
+	if err := h.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "host0"}, PeerName: "switch0"}); err != nil {
+		t.Skipf("cannot create veth pair: %v", err)
+	}
+	var ifis []*net.Interface
+	for _, name := range []string{"host0", "switch0"} {
+		l, err := h.LinkByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := h.LinkSetUp(l); err != nil {
+			t.Fatal(err)
+		}
+		ifis = append(ifis, &net.Interface{Index: l.Attrs().Index, Name: name, HardwareAddr: l.Attrs().HardwareAddr})
+	}
+	return ifis[0], ifis[1]
+}
+
+func TestConn(t *testing.T) {
+	host, sw := newLink(t)
+	hc, err := Listen(host)
+	if err != nil {
+		t.Fatalf("Listen(%s) = %v", host.Name, err)
+	}
+	defer hc.Close()
+	sc, err := Listen(sw)
+	if err != nil {
+		t.Fatalf("Listen(%s) = %v", sw.Name, err)
+	}
+	defer sc.Close()
+
+	if err := sc.Send("tor1", time.Minute); err != nil {
+		t.Fatalf("Send() = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	n, err := hc.Receive(ctx)
+	if err != nil {
+		t.Fatalf("Receive() = %v", err)
+	}
+	if n.SystemName != "tor1" || n.PortID != "switch0" || n.ChassisID != sw.HardwareAddr.String() {
+		t.Errorf("Receive() = %v, want switch0 of tor1", n)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := hc.Receive(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Receive() = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package lldp receives and sends Link Layer Discovery Protocol (IEEE
+// 802.1AB) frames, to tell which switch port an interface is plugged into.
+package lldp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+)
+
+// DefaultTTL is how long neighbors keep the information sent by Frame.
+const DefaultTTL = 120 * time.Second
+
+// Multicast is the nearest bridge group address LLDP frames are sent to.
+var Multicast = net.HardwareAddr{0x01, 0x80, 0xc2, 0x00, 0x00, 0x0e}
+
+var errNotLLDP = errors.New("not an LLDP frame")
+
+// VLAN is a VLAN the port of a neighbor is in.
+type VLAN struct {
+	ID   uint16
+	Name string
+}
+
+// Neighbor is the information a neighbor sent about itself and its port.
+type Neighbor struct {
+	// Source is the hardware address the frame came from.
+	Source net.HardwareAddr
+
+	ChassisID         string
+	PortID            string
+	PortDescription   string
+	SystemName        string
+	SystemDescription string
+
+	// TTL is how long the information is valid.
+	TTL time.Duration
+
+	// Capabilities are the enabled capabilities, e.g. bridge or router.
+	Capabilities []string
+
+	// ManagementAddress is where the neighbor is managed, nil if not
+	// announced.
+	ManagementAddress net.IP
+
+	// PortVLAN is the untagged VLAN of the port (802.1 PVID), 0 if not
+	// announced.
+	PortVLAN uint16
+
+	// VLANs are the VLANs announced with their names.
+	VLANs []VLAN
+}
+
+// id returns the chassis or port id b of subtype mac or network address
+// as text, and as is otherwise.
+func id(b []byte, mac, netAddr bool) string {
+	switch {
+	case mac && len(b) == 6:
+		return net.HardwareAddr(b).String()
+	case netAddr && len(b) == 1+net.IPv4len && b[0] == byte(layers.IANAAddressFamilyIPV4):
+		return net.IP(b[1:]).String()
+	case netAddr && len(b) == 1+net.IPv6len && b[0] == byte(layers.IANAAddressFamilyIPV6):
+		return net.IP(b[1:]).String()
+	}
+	return string(b)
+}
+
+// capabilities returns the names of the capabilities set in c.
+func capabilities(c layers.LLDPCapabilities) []string {
+	var names []string
+	for _, n := range []struct {
+		set  bool
+		name string
+	}{
+		{c.Other, "other"},
+		{c.Repeater, "repeater"},
+		{c.Bridge, "bridge"},
+		{c.WLANAP, "wlan-ap"},
+		{c.Router, "router"},
+		{c.Phone, "phone"},
+		{c.DocSis, "docsis"},
+		{c.StationOnly, "station"},
+		{c.CVLAN, "c-vlan"},
+		{c.SVLAN, "s-vlan"},
+		{c.TMPR, "tpmr"},
+	} {
+		if n.set {
+			names = append(names, n.name)
+		}
+	}
+	return names
+}
+
+// Parse parses the LLDP Ethernet frame b.
+func Parse(b []byte) (*Neighbor, error) {
+	p := gopacket.NewPacket(b, layers.LayerTypeEthernet, gopacket.Default)
+	if err := p.ErrorLayer(); err != nil {
+		return nil, fmt.Errorf("%w: %w", errNotLLDP, err.Error())
+	}
+	eth, _ := p.Layer(layers.LayerTypeEthernet).(*layers.Ethernet)
+	lldp, _ := p.Layer(layers.LayerTypeLinkLayerDiscovery).(*layers.LinkLayerDiscovery)
+	info, _ := p.Layer(layers.LayerTypeLinkLayerDiscoveryInfo).(*layers.LinkLayerDiscoveryInfo)
+	if eth == nil || lldp == nil || info == nil {
+		return nil, errNotLLDP
+	}
+	n := &Neighbor{
+		Source:            eth.SrcMAC,
+		ChassisID:         id(lldp.ChassisID.ID, lldp.ChassisID.Subtype == layers.LLDPChassisIDSubTypeMACAddr, lldp.ChassisID.Subtype == layers.LLDPChassisIDSubTypeNetworkAddr),
+		PortID:            id(lldp.PortID.ID, lldp.PortID.Subtype == layers.LLDPPortIDSubtypeMACAddr, lldp.PortID.Subtype == layers.LLDPPortIDSubtypeNetworkAddr),
+		PortDescription:   info.PortDescription,
+		SystemName:        info.SysName,
+		SystemDescription: info.SysDescription,
+		TTL:               time.Duration(lldp.TTL) * time.Second,
+		Capabilities:      capabilities(info.SysCapabilities.EnabledCap),
+	}
+	switch a := info.MgmtAddress; {
+	case a.Subtype == layers.IANAAddressFamilyIPV4 && len(a.Address) == net.IPv4len,
+		a.Subtype == layers.IANAAddressFamilyIPV6 && len(a.Address) == net.IPv6len:
+		n.ManagementAddress = net.IP(a.Address)
+	}
+	if dot1, err := info.Decode8021(); err == nil {
+		n.PortVLAN = dot1.PVID
+		for _, v := range dot1.VLANNames {
+			n.VLANs = append(n.VLANs, VLAN{ID: v.ID, Name: v.Name})
+		}
+	}
+	return n, nil
+}
+
+func (n *Neighbor) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "chassis %s port %s", n.ChassisID, n.PortID)
+	if n.SystemName != "" {
+		fmt.Fprintf(&b, " system %q", n.SystemName)
+	}
+	if n.PortDescription != "" {
+		fmt.Fprintf(&b, " port description %q", n.PortDescription)
+	}
+	if n.PortVLAN != 0 {
+		fmt.Fprintf(&b, " vlan %d", n.PortVLAN)
+	}
+	for _, v := range n.VLANs {
+		fmt.Fprintf(&b, " vlan %d (%s)", v.ID, v.Name)
+	}
+	if n.ManagementAddress != nil {
+		fmt.Fprintf(&b, " management %v", n.ManagementAddress)
+	}
+	return b.String()
+}
+
+// value returns the TLV typ with v.
+func value(typ layers.LLDPTLVType, v []byte) layers.LinkLayerDiscoveryValue {
+	return layers.LinkLayerDiscoveryValue{Type: typ, Length: uint16(len(v)), Value: v}
+}
+
+// Frame returns an LLDP frame announcing ifi, of the host systemName,
+// valid for ttl.
+func Frame(ifi *net.Interface, systemName string, ttl time.Duration) ([]byte, error) {
+	eth := &layers.Ethernet{
+		SrcMAC:       ifi.HardwareAddr,
+		DstMAC:       Multicast,
+		EthernetType: layers.EthernetTypeLinkLayerDiscovery,
+	}
+	lldp := &layers.LinkLayerDiscovery{
+		ChassisID: layers.LLDPChassisID{Subtype: layers.LLDPChassisIDSubTypeMACAddr, ID: ifi.HardwareAddr},
+		PortID:    layers.LLDPPortID{Subtype: layers.LLDPPortIDSubtypeIfaceName, ID: []byte(ifi.Name)},
+		TTL:       uint16(ttl / time.Second),
+		Values: []layers.LinkLayerDiscoveryValue{
+			value(layers.LLDPTLVPortDescription, []byte(ifi.Name)),
+		},
+	}
+	if systemName != "" {
+		lldp.Values = append(lldp.Values, value(layers.LLDPTLVSysName, []byte(systemName)))
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{}, eth, lldp); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,115 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lldp
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+)
+
+// switchFrame returns an LLDP frame like a switch sends.
+func switchFrame(t *testing.T) []byte {
+	t.Helper()
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x00, 0x1b, 0x21, 0xab, 0xcd, 0x10},
+		DstMAC:       Multicast,
+		EthernetType: layers.EthernetTypeLinkLayerDiscovery,
+	}
+	lldp := &layers.LinkLayerDiscovery{
+		ChassisID: layers.LLDPChassisID{Subtype: layers.LLDPChassisIDSubTypeMACAddr, ID: []byte{0x00, 0x1b, 0x21, 0xab, 0xcd, 0x00}},
+		PortID:    layers.LLDPPortID{Subtype: layers.LLDPPortIDSubtypeLocal, ID: []byte("Ethernet1/17")},
+		TTL:       120,
+		Values: []layers.LinkLayerDiscoveryValue{
+			value(layers.LLDPTLVPortDescription, []byte("rack12 server3")),
+			value(layers.LLDPTLVSysName, []byte("tor12.example.com")),
+			value(layers.LLDPTLVSysDescription, []byte("Switch OS 1.0")),
+			// Bridge and router capable, bridge enabled.
+			value(layers.LLDPTLVSysCapabilities, []byte{0x00, 0x14, 0x00, 0x04}),
+			// 192.0.2.12, ifIndex 0, no OID.
+			value(layers.LLDPTLVMgmtAddress, []byte{5, 1, 192, 0, 2, 12, 2, 0, 0, 0, 0, 0}),
+			// 802.1 port VLAN ID 100 and VLAN name 100 "provisioning".
+			value(layers.LLDPTLVOrgSpecific, []byte{0x00, 0x80, 0xc2, 1, 0, 100}),
+			value(layers.LLDPTLVOrgSpecific, append([]byte{0x00, 0x80, 0xc2, 3, 0, 100, 12}, "provisioning"...)),
+		},
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{}, eth, lldp); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestParse(t *testing.T) {
+	got, err := Parse(switchFrame(t))
+	if err != nil {
+		t.Fatalf("Parse() = %v", err)
+	}
+	want := &Neighbor{
+		Source:            net.HardwareAddr{0x00, 0x1b, 0x21, 0xab, 0xcd, 0x10},
+		ChassisID:         "00:1b:21:ab:cd:00",
+		PortID:            "Ethernet1/17",
+		PortDescription:   "rack12 server3",
+		SystemName:        "tor12.example.com",
+		SystemDescription: "Switch OS 1.0",
+		TTL:               2 * time.Minute,
+		Capabilities:      []string{"bridge"},
+		ManagementAddress: net.IP{192, 0, 2, 12},
+		PortVLAN:          100,
+		VLANs:             []VLAN{{ID: 100, Name: "provisioning"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse() = %+v, want %+v", got, want)
+	}
+	if s, want := got.String(), `chassis 00:1b:21:ab:cd:00 port Ethernet1/17 system "tor12.example.com" port description "rack12 server3" vlan 100 vlan 100 (provisioning) management 192.0.2.12`; s != want {
+		t.Errorf("String() = %s, want %s", s, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	b := switchFrame(t)
+	for _, tt := range []struct {
+		name string
+		b    []byte
+	}{
+		{name: "truncated", b: b[:40]},
+		{name: "not lldp", b: append(append([]byte{}, b[:12]...), 0x08, 0x00)},
+		{name: "empty", b: nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(tt.b); !errors.Is(err, errNotLLDP) {
+				t.Errorf("Parse() = %v, want %v", err, errNotLLDP)
+			}
+		})
+	}
+}
+
+func TestFrame(t *testing.T) {
+	ifi := &net.Interface{Name: "eth0", HardwareAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 1}}
+	b, err := Frame(ifi, "server3", DefaultTTL)
+	if err != nil {
+		t.Fatalf("Frame() = %v", err)
+	}
+	n, err := Parse(b)
+	if err != nil {
+		t.Fatalf("Parse(Frame()) = %v", err)
+	}
+	want := &Neighbor{
+		Source:          ifi.HardwareAddr,
+		ChassisID:       "02:00:00:00:00:01",
+		PortID:          "eth0",
+		PortDescription: "eth0",
+		SystemName:      "server3",
+		TTL:             DefaultTTL,
+	}
+	if !reflect.DeepEqual(n, want) {
+		t.Errorf("Parse(Frame()) = %+v, want %+v", n, want)
+	}
+}
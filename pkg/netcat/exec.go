@@ -6,7 +6,6 @@ package netcat
 
 import (
 	"bufio"
-	"bytes"
 	"fmt"
 	"io"
 	"log"
@@ -60,12 +59,10 @@ func ParseCommands(execs ...Exec) (Exec, error) {
 // Execute a given command on the host system
 // stdout of the command is send to to the connection
 // stderr of the command is displayed on stdout of the host
+// The lines read from stdin are passed on to the command as they come, with eol as line ending.
 // The host process exits with the exit code of the command unless --keep-open is specified
 func (n *Exec) Execute(stdin io.ReadWriter, stdout io.Writer, stderr io.Writer, eol []byte) error {
-	var (
-		cmd    *exec.Cmd
-		buffer bytes.Buffer
-	)
+	var cmd *exec.Cmd
 
 	if n.Command == "" {
 		return fmt.Errorf("empty command")
@@ -86,20 +83,31 @@ func (n *Exec) Execute(stdin io.ReadWriter, stdout io.Writer, stderr io.Writer,
 	cmd.Stdout = stdout
 	cmd.Stderr = stderr
 
-	scanner := bufio.NewScanner(stdin)
-	for scanner.Scan() {
-		buffer.WriteString(scanner.Text())
-		buffer.Write(eol)
+	// Wait closes the pipe once the command exits, which stops the copy
+	// even if stdin never ends, e.g. an open connection.
+	pipe, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("exec stdin: %w", err)
 	}
-	if err := scanner.Err(); err != nil {
-		log.Fatal(err)
-	}
-	cmd.Stdin = &buffer
 
 	if err := cmd.Start(); err != nil {
 		return fmt.Errorf("exec start: %w", err)
 	}
 
+	go func() {
+		defer pipe.Close()
+		scanner := bufio.NewScanner(stdin)
+		for scanner.Scan() {
+			line := append(append([]byte(nil), scanner.Bytes()...), eol...)
+			if _, err := pipe.Write(line); err != nil {
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			log.Printf("exec: reading input: %v", err)
+		}
+	}()
+
 	// Wait waits for the command to exit and waits for any copying to stdin or copying from stdout or stderr to complete.
 	if err := cmd.Wait(); err != nil {
 		return fmt.Errorf("exec wait: %w", err)
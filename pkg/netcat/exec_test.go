@@ -6,6 +6,7 @@ package netcat
 
 import (
 	"bytes"
+	"io"
 	"testing"
 )
 
@@ -136,3 +137,22 @@ func TestExec_Execute(t *testing.T) {
 		})
 	}
 }
+
+func TestExecuteStreams(t *testing.T) {
+	// The connection stays open, the command only needs the first line.
+	r, w := io.Pipe()
+	defer w.Close()
+	go io.WriteString(w, "hello\n")
+	stdout := &bytes.Buffer{}
+
+	exec := Exec{Type: EXEC_TYPE_SHELL, Command: "head -n 1"}
+	if err := exec.Execute(struct {
+		io.Reader
+		io.Writer
+	}{r, io.Discard}, stdout, io.Discard, []byte("\r\n")); err != nil {
+		t.Fatalf("Execute() = %v", err)
+	}
+	if got := stdout.String(); got != "hello\r\n" {
+		t.Errorf("Execute() output = %q, want %q", got, "hello\r\n")
+	}
+}
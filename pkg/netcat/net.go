@@ -7,18 +7,25 @@ package netcat
 import (
 	"fmt"
 	"net"
+	"sync"
 
 	"github.com/u-root/u-root/pkg/ulog"
 )
 
-// UDPListener implements net.UDPListener for UDP
+// UDPListener implements net.Listener for UDP and Unix datagram sockets.
+// The first datagram makes its sender the peer of the one connection it
+// accepts.
 type UDPListener struct {
-	conn net.Conn
+	conn net.PacketConn
+
+	accepted  bool
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
 // NewUDPListener creates a new UDPListener
 func NewUDPListener(network, addr string, _ ulog.Logger) (*UDPListener, error) {
-	var conn net.Conn
+	var conn net.PacketConn
 
 	switch network {
 	case "udp", "udp4", "udp6":
@@ -41,19 +48,71 @@ func NewUDPListener(network, addr string, _ ulog.Logger) (*UDPListener, error) {
 		if err != nil {
 			return nil, fmt.Errorf("failed to listen on Unixgram address: %v", err)
 		}
+	default:
+		return nil, fmt.Errorf("unsupported datagram network %q", network)
 	}
-	return &UDPListener{conn: conn}, nil
+	return &UDPListener{conn: conn, done: make(chan struct{})}, nil
 }
 
-// Accept waits for and returns the next connection to the listener.
+// Accept waits for the first datagram and returns a connection to its
+// sender. Later calls block until the listener is closed.
 func (l *UDPListener) Accept() (net.Conn, error) {
-	return l.conn, nil
+	if l.accepted {
+		<-l.done
+		return nil, net.ErrClosed
+	}
+	b := make([]byte, udpBufferSize)
+	n, peer, err := l.conn.ReadFrom(b)
+	if err != nil {
+		return nil, err
+	}
+	l.accepted = true
+	if peer == nil {
+		// Unbound Unix datagram sockets have no address to answer to.
+		peer = &net.UnixAddr{Net: "unixgram"}
+	}
+	return &udpConn{PacketConn: l.conn, peer: peer, pending: b[:n]}, nil
 }
 
 func (l *UDPListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
 	return l.conn.Close()
 }
 
 func (l *UDPListener) Addr() net.Addr {
 	return l.conn.LocalAddr()
 }
+
+// udpBufferSize is large enough for any UDP datagram.
+const udpBufferSize = 65535
+
+// udpConn is the connection of a UDPListener to its peer. Datagrams from
+// other senders are dropped.
+type udpConn struct {
+	net.PacketConn
+
+	peer    net.Addr
+	pending []byte
+}
+
+func (c *udpConn) Read(b []byte) (int, error) {
+	if c.pending != nil {
+		n := copy(b, c.pending)
+		c.pending = nil
+		return n, nil
+	}
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(b)
+		if err != nil || addr == nil || addr.String() == c.peer.String() {
+			return n, err
+		}
+	}
+}
+
+func (c *udpConn) Write(b []byte) (int, error) {
+	return c.PacketConn.WriteTo(b, c.peer)
+}
+
+func (c *udpConn) RemoteAddr() net.Addr {
+	return c.peer
+}
@@ -4,10 +4,16 @@
 package netcat
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"fmt"
+	"math/big"
 	"os"
+	"time"
 )
 
 type SSLOptions struct {
@@ -33,16 +39,19 @@ func (s *SSLOptions) GenerateTLSConfiguration() (*tls.Config, error) {
 		InsecureSkipVerify: !s.VerifyTrust,
 	}
 
-	if s.CertFilePath == "" || s.KeyFilePath == "" {
-		return nil, fmt.Errorf("both  certificate and key file must be provided")
+	if (s.CertFilePath == "") != (s.KeyFilePath == "") {
+		return nil, fmt.Errorf("both certificate and key file must be provided")
 	}
 
-	cer, err := tls.LoadX509KeyPair(s.CertFilePath, s.KeyFilePath)
-	if err != nil {
-		return nil, fmt.Errorf("connection: %v", err)
-	}
+	// Clients only need a certificate for servers that ask for one.
+	if s.CertFilePath != "" {
+		cer, err := tls.LoadX509KeyPair(s.CertFilePath, s.KeyFilePath)
+		if err != nil {
+			return nil, fmt.Errorf("connection: %v", err)
+		}
 
-	tlsConfig.Certificates = []tls.Certificate{cer}
+		tlsConfig.Certificates = []tls.Certificate{cer}
+	}
 
 	if s.VerifyTrust {
 		caCert, err := os.ReadFile(s.TrustFilePath)
@@ -67,3 +76,52 @@ func (s *SSLOptions) GenerateTLSConfiguration() (*tls.Config, error) {
 
 	return tlsConfig, nil
 }
+
+// ServerTLSConfiguration returns the TLS configuration of a listener. Like
+// Ncat, it generates a temporary self-signed certificate if none is given.
+func (s *SSLOptions) ServerTLSConfiguration() (*tls.Config, error) {
+	tlsConfig, err := s.GenerateTLSConfiguration()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tlsConfig.Certificates) == 0 {
+		cer, err := temporaryCertificate()
+		if err != nil {
+			return nil, fmt.Errorf("generating temporary certificate: %v", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cer}
+	}
+
+	return tlsConfig, nil
+}
+
+// temporaryCertificate returns a self-signed certificate valid for a day.
+func temporaryCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -0,0 +1,383 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package netcfg applies a static network configuration, described in a
+// YAML or JSON file, with netlink.
+//
+// A configuration looks like:
+//
+//	interfaces:
+//	  - name: bond0
+//	    type: bond
+//	    mode: active-backup
+//	    members: [eth0, eth1]
+//	  - name: bond0.100
+//	    type: vlan
+//	    link: bond0
+//	    vlan_id: 100
+//	    mtu: 1500
+//	    addresses: [192.0.2.10/24, 2001:db8::10/64]
+//	    routes:
+//	      - to: default
+//	        via: 192.0.2.1
+//	dns:
+//	  nameservers: [192.0.2.53]
+//	  search: [example.com]
+package netcfg
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/u-root/u-root/pkg/dhclient"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	"gopkg.in/yaml.v2"
+)
+
+// Interface types.
+const (
+	// TypeDevice is an existing interface, such as an Ethernet device.
+	TypeDevice = "device"
+	// TypeVLAN is an 802.1Q VLAN interface on top of Link.
+	TypeVLAN = "vlan"
+	// TypeBond is a bond of Members.
+	TypeBond = "bond"
+)
+
+var (
+	errName    = errors.New("interface has no name")
+	errType    = errors.New("unknown interface type")
+	errVLAN    = errors.New("invalid VLAN")
+	errBond    = errors.New("invalid bond")
+	errAddress = errors.New("invalid address")
+	errRoute   = errors.New("invalid route")
+	errDNS     = errors.New("invalid name server")
+)
+
+// Config is a network configuration.
+type Config struct {
+	Interfaces []Interface `yaml:"interfaces"`
+	DNS        DNS         `yaml:"dns"`
+}
+
+// Interface is the configuration of one interface.
+type Interface struct {
+	Name string `yaml:"name"`
+
+	// Type is one of TypeDevice, TypeVLAN and TypeBond. If empty, it is
+	// TypeDevice.
+	Type string `yaml:"type"`
+
+	// Link is the interface a VLAN is on.
+	Link string `yaml:"link"`
+
+	// VLANID is the ID of a VLAN.
+	VLANID int `yaml:"vlan_id"`
+
+	// Mode is the mode of a bond, as understood by the bonding driver,
+	// e.g. active-backup or 802.3ad. If empty, it is balance-rr.
+	Mode string `yaml:"mode"`
+
+	// Members are the interfaces enslaved to a bond.
+	Members []string `yaml:"members"`
+
+	// MTU is set if not 0.
+	MTU int `yaml:"mtu"`
+
+	// MAC is the hardware address to set, if not empty.
+	MAC string `yaml:"mac"`
+
+	// Addresses are the addresses to add, in CIDR notation.
+	Addresses []string `yaml:"addresses"`
+
+	// Routes are the routes to add via the interface.
+	Routes []Route `yaml:"routes"`
+
+	// Down leaves the interface down.
+	Down bool `yaml:"down"`
+}
+
+// Route is a route via an interface.
+type Route struct {
+	// To is the destination in CIDR notation, or "default".
+	To string `yaml:"to"`
+
+	// Via is the gateway, if any.
+	Via string `yaml:"via"`
+
+	// Metric is the priority of the route.
+	Metric int `yaml:"metric"`
+}
+
+// DNS is the resolver configuration.
+type DNS struct {
+	Nameservers []string `yaml:"nameservers"`
+	Search      []string `yaml:"search"`
+	Domain      string   `yaml:"domain"`
+}
+
+// Parse parses a configuration, in YAML or JSON, and validates it.
+func Parse(b []byte) (*Config, error) {
+	var c Config
+	if err := yaml.UnmarshalStrict(b, &c); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Load reads and parses the configuration file at path.
+func Load(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := Parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Validate checks c for errors that can be found without applying it.
+func (c *Config) Validate() error {
+	for _, i := range c.Interfaces {
+		if err := i.validate(); err != nil {
+			return err
+		}
+	}
+	for _, ns := range c.DNS.Nameservers {
+		if net.ParseIP(ns) == nil {
+			return fmt.Errorf("%w: %q", errDNS, ns)
+		}
+	}
+	return nil
+}
+
+func (i *Interface) validate() error {
+	if i.Name == "" {
+		return errName
+	}
+	switch i.Type {
+	case "", TypeDevice:
+	case TypeVLAN:
+		if i.Link == "" || i.VLANID < 1 || i.VLANID > 4094 {
+			return fmt.Errorf("%w: %s needs a link and an ID from 1 to 4094", errVLAN, i.Name)
+		}
+	case TypeBond:
+		if len(i.Members) == 0 {
+			return fmt.Errorf("%w: %s has no members", errBond, i.Name)
+		}
+		if i.Mode != "" && netlink.StringToBondMode(i.Mode) == netlink.BOND_MODE_UNKNOWN {
+			return fmt.Errorf("%w: %s has unknown mode %q", errBond, i.Name, i.Mode)
+		}
+	default:
+		return fmt.Errorf("%w: %s is of type %q", errType, i.Name, i.Type)
+	}
+	if i.MAC != "" {
+		if _, err := net.ParseMAC(i.MAC); err != nil {
+			return fmt.Errorf("%s: %w", i.Name, err)
+		}
+	}
+	for _, a := range i.Addresses {
+		if _, err := netlink.ParseAddr(a); err != nil {
+			return fmt.Errorf("%w: %s: %q", errAddress, i.Name, a)
+		}
+	}
+	for _, r := range i.Routes {
+		if _, err := r.route(nil); err != nil {
+			return fmt.Errorf("%s: %w", i.Name, err)
+		}
+	}
+	return nil
+}
+
+// route returns the netlink route of r via link, which may be nil.
+func (r Route) route(link netlink.Link) (*netlink.Route, error) {
+	route := &netlink.Route{Priority: r.Metric}
+	if link != nil {
+		route.LinkIndex = link.Attrs().Index
+	}
+	if r.Via != "" {
+		if route.Gw = net.ParseIP(r.Via); route.Gw == nil {
+			return nil, fmt.Errorf("%w: gateway %q", errRoute, r.Via)
+		}
+	}
+	if r.To != "default" {
+		_, dst, err := net.ParseCIDR(r.To)
+		if err != nil {
+			return nil, fmt.Errorf("%w: destination %q", errRoute, r.To)
+		}
+		route.Dst = dst
+	} else if route.Gw == nil {
+		return nil, fmt.Errorf("%w: default route without gateway", errRoute)
+	} else if route.Gw.To4() == nil {
+		// Default routes have no destination to tell their family.
+		route.Family = netlink.FAMILY_V6
+	}
+	return route, nil
+}
+
+// Apply applies c with h, which may be nil to use the network namespace
+// of the caller. It creates the bonds and VLANs first, then configures
+// and brings up every interface, and adds the routes last, once all
+// gateways are reachable. The DNS configuration is left to WriteDNS.
+func (c *Config) Apply(h *netlink.Handle) error {
+	if h == nil {
+		h = &netlink.Handle{}
+	}
+	// VLANs may be on bonds, so create those first.
+	for _, i := range c.Interfaces {
+		if i.Type == TypeBond {
+			if err := i.createBond(h); err != nil {
+				return err
+			}
+		}
+	}
+	for _, i := range c.Interfaces {
+		if i.Type == TypeVLAN {
+			if err := i.createVLAN(h); err != nil {
+				return err
+			}
+		}
+	}
+	for _, i := range c.Interfaces {
+		if err := i.configure(h); err != nil {
+			return err
+		}
+	}
+	for _, i := range c.Interfaces {
+		if err := i.addRoutes(h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteDNS writes the DNS configuration of c to the resolv.conf at path,
+// unless it is empty.
+func (c *Config) WriteDNS(path string) error {
+	d := c.DNS
+	if d.Nameservers == nil && d.Search == nil && d.Domain == "" {
+		return nil
+	}
+	var ns []net.IP
+	for _, s := range d.Nameservers {
+		ns = append(ns, net.ParseIP(s))
+	}
+	return dhclient.WriteDNSSettings(ns, d.Search, d.Domain, path)
+}
+
+// createBond creates the bond i if it does not exist, and enslaves its
+// members.
+func (i *Interface) createBond(h *netlink.Handle) error {
+	link, err := h.LinkByName(i.Name)
+	if err != nil {
+		bond := netlink.NewLinkBond(netlink.LinkAttrs{Name: i.Name})
+		if i.Mode != "" {
+			bond.Mode = netlink.StringToBondMode(i.Mode)
+		}
+		if err := h.LinkAdd(bond); err != nil {
+			return fmt.Errorf("creating bond %s: %w", i.Name, err)
+		}
+		if link, err = h.LinkByName(i.Name); err != nil {
+			return err
+		}
+	}
+	for _, name := range i.Members {
+		m, err := h.LinkByName(name)
+		if err != nil {
+			return fmt.Errorf("bond %s: %w", i.Name, err)
+		}
+		if m.Attrs().MasterIndex == link.Attrs().Index {
+			continue
+		}
+		// The bonding driver only takes members that are down.
+		if err := h.LinkSetDown(m); err != nil {
+			return fmt.Errorf("bond %s: %w", i.Name, err)
+		}
+		if err := h.LinkSetMasterByIndex(m, link.Attrs().Index); err != nil {
+			return fmt.Errorf("adding %s to bond %s: %w", name, i.Name, err)
+		}
+		if err := h.LinkSetUp(m); err != nil {
+			return fmt.Errorf("bond %s: %w", i.Name, err)
+		}
+	}
+	return nil
+}
+
+// createVLAN creates the VLAN i if it does not exist.
+func (i *Interface) createVLAN(h *netlink.Handle) error {
+	if _, err := h.LinkByName(i.Name); err == nil {
+		return nil
+	}
+	parent, err := h.LinkByName(i.Link)
+	if err != nil {
+		return fmt.Errorf("VLAN %s: %w", i.Name, err)
+	}
+	vlan := &netlink.Vlan{
+		LinkAttrs: netlink.LinkAttrs{Name: i.Name, ParentIndex: parent.Attrs().Index},
+		VlanId:    i.VLANID,
+	}
+	if err := h.LinkAdd(vlan); err != nil {
+		return fmt.Errorf("creating VLAN %s: %w", i.Name, err)
+	}
+	return nil
+}
+
+// configure sets the MTU, hardware address and addresses of i, and
+// brings it up.
+func (i *Interface) configure(h *netlink.Handle) error {
+	link, err := h.LinkByName(i.Name)
+	if err != nil {
+		return err
+	}
+	if i.MTU != 0 && link.Attrs().MTU != i.MTU {
+		if err := h.LinkSetMTU(link, i.MTU); err != nil {
+			return fmt.Errorf("setting MTU of %s: %w", i.Name, err)
+		}
+	}
+	if i.MAC != "" {
+		mac, _ := net.ParseMAC(i.MAC)
+		if err := h.LinkSetHardwareAddr(link, mac); err != nil {
+			return fmt.Errorf("setting MAC of %s: %w", i.Name, err)
+		}
+	}
+	for _, a := range i.Addresses {
+		addr, _ := netlink.ParseAddr(a)
+		if err := h.AddrReplace(link, addr); err != nil {
+			return fmt.Errorf("adding %s to %s: %w", a, i.Name, err)
+		}
+	}
+	if i.Down {
+		return h.LinkSetDown(link)
+	}
+	return h.LinkSetUp(link)
+}
+
+// addRoutes adds the routes of i.
+func (i *Interface) addRoutes(h *netlink.Handle) error {
+	if len(i.Routes) == 0 {
+		return nil
+	}
+	link, err := h.LinkByName(i.Name)
+	if err != nil {
+		return err
+	}
+	for _, r := range i.Routes {
+		route, _ := r.route(link)
+		if route.Gw == nil {
+			route.Scope = netlink.SCOPE_LINK
+		}
+		if err := h.RouteReplace(route); err != nil && !errors.Is(err, unix.EEXIST) {
+			return fmt.Errorf("adding route to %s via %s: %w", r.To, i.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,296 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netcfg
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+const yamlConfig = `
+interfaces:
+  - name: bond0
+    type: bond
+    mode: active-backup
+    members: [eth0, eth1]
+  - name: bond0.100
+    type: vlan
+    link: bond0
+    vlan_id: 100
+    mtu: 1400
+    addresses: [192.0.2.10/24, 2001:db8::10/64]
+    routes:
+      - to: default
+        via: 192.0.2.1
+      - to: 198.51.100.0/24
+        metric: 10
+dns:
+  nameservers: [192.0.2.53]
+  search: [example.com]
+`
+
+const jsonConfig = `{
+  "interfaces": [
+    {"name": "bond0", "type": "bond", "mode": "active-backup", "members": ["eth0", "eth1"]},
+    {"name": "bond0.100", "type": "vlan", "link": "bond0", "vlan_id": 100, "mtu": 1400,
+     "addresses": ["192.0.2.10/24", "2001:db8::10/64"],
+     "routes": [{"to": "default", "via": "192.0.2.1"}, {"to": "198.51.100.0/24", "metric": 10}]}
+  ],
+  "dns": {"nameservers": ["192.0.2.53"], "search": ["example.com"]}
+}`
+
+func TestParse(t *testing.T) {
+	y, err := Parse([]byte(yamlConfig))
+	if err != nil {
+		t.Fatalf("Parse(YAML) = %v", err)
+	}
+	j, err := Parse([]byte(jsonConfig))
+	if err != nil {
+		t.Fatalf("Parse(JSON) = %v", err)
+	}
+	if !reflect.DeepEqual(y, j) {
+		t.Errorf("Parse(YAML) = %+v, Parse(JSON) = %+v, want them equal", y, j)
+	}
+	if len(y.Interfaces) != 2 || y.Interfaces[1].VLANID != 100 || len(y.Interfaces[1].Routes) != 2 {
+		t.Errorf("Parse() = %+v, want a bond and a VLAN with two routes", y)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		in   string
+		want error
+	}{
+		{name: "no name", in: "interfaces: [{addresses: [192.0.2.1/24]}]", want: errName},
+		{name: "type", in: "interfaces: [{name: br0, type: bridge}]", want: errType},
+		{name: "vlan id", in: "interfaces: [{name: eth0.5000, type: vlan, link: eth0, vlan_id: 5000}]", want: errVLAN},
+		{name: "vlan link", in: "interfaces: [{name: eth0.5, type: vlan, vlan_id: 5}]", want: errVLAN},
+		{name: "bond members", in: "interfaces: [{name: bond0, type: bond}]", want: errBond},
+		{name: "bond mode", in: "interfaces: [{name: bond0, type: bond, mode: fast, members: [eth0]}]", want: errBond},
+		{name: "address", in: "interfaces: [{name: eth0, addresses: [192.0.2.1]}]", want: errAddress},
+		{name: "route", in: "interfaces: [{name: eth0, routes: [{to: 192.0.2.0}]}]", want: errRoute},
+		{name: "default route", in: "interfaces: [{name: eth0, routes: [{to: default}]}]", want: errRoute},
+		{name: "gateway", in: "interfaces: [{name: eth0, routes: [{to: default, via: router}]}]", want: errRoute},
+		{name: "dns", in: "dns: {nameservers: [dns.example.com]}", want: errDNS},
+		{name: "ok", in: "interfaces: [{name: eth0, addresses: [192.0.2.1/24], routes: [{to: default, via: '192.0.2.254'}]}]"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse([]byte(tt.in)); !errors.Is(err, tt.want) {
+				t.Errorf("Parse(%q) = %v, want %v", tt.in, err, tt.want)
+			}
+		})
+	}
+	if _, err := Parse([]byte("interfaces: [{name: eth0, adresses: [192.0.2.1/24]}]")); err == nil {
+		t.Errorf("Parse() of an unknown field = nil, want an error")
+	}
+}
+
+func TestWriteDNS(t *testing.T) {
+	c, err := Parse([]byte(yamlConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	if err := c.WriteDNS(path); err != nil {
+		t.Fatalf("WriteDNS() = %v", err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), "nameserver 192.0.2.53\nsearch example.com\n"; got != want {
+		t.Errorf("resolv.conf = %q, want %q", got, want)
+	}
+}
+
+// newNamespace runs the rest of the test in a new network namespace and
+// returns a handle in it with the veth pairs eth0 and eth1.
+func newNamespace(t *testing.T) *netlink.Handle {
+	t.Helper()
+	runtime.LockOSThread()
+	t.Cleanup(runtime.UnlockOSThread)
+	orig, err := netns.Get()
+	if err != nil {
+		t.Skipf("no network namespaces: %v", err)
+	}
+	t.Cleanup(func() { orig.Close() })
+	ns, err := netns.New()
+	if err != nil {
+		t.Skipf("cannot create a network namespace: %v", err)
+	}
+	t.Cleanup(func() {
+		netns.Set(orig)
+		ns.Close()
+	})
+	h, err := netlink.NewHandleAt(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(h.Close)
+	for _, name := range []string{"eth0", "eth1"} {
+		if err := h.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: name}, PeerName: name + "p"}); err != nil {
+			t.Skipf("cannot create veth pairs: %v", err)
+		}
+	}
+	return h
+}
+
+func TestApply(t *testing.T) {
+	c, err := Parse([]byte(`
+interfaces:
+  - name: eth0
+    mac: 02:00:00:00:00:01
+    mtu: 1400
+    addresses: [192.0.2.10/24, 2001:db8::10/64]
+    routes:
+      - to: default
+        via: 192.0.2.1
+      - to: 198.51.100.0/24
+        metric: 10
+  - name: eth1
+    down: true
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newNamespace(t)
+	if err := c.Apply(h); err != nil {
+		t.Fatalf("Apply() = %v", err)
+	}
+	// Applying again changes nothing.
+	if err := c.Apply(h); err != nil {
+		t.Fatalf("second Apply() = %v", err)
+	}
+
+	eth0, err := h.LinkByName("eth0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mac := eth0.Attrs().HardwareAddr.String(); mac != "02:00:00:00:00:01" {
+		t.Errorf("eth0 MAC = %s, want 02:00:00:00:00:01", mac)
+	}
+	if eth0.Attrs().MTU != 1400 {
+		t.Errorf("eth0 MTU = %d, want 1400", eth0.Attrs().MTU)
+	}
+	if eth0.Attrs().Flags&net.FlagUp == 0 {
+		t.Errorf("eth0 is down, want it up")
+	}
+	if eth1, err := h.LinkByName("eth1"); err != nil || eth1.Attrs().Flags&net.FlagUp != 0 {
+		t.Errorf("eth1 = %v, %v, want it down", eth1, err)
+	}
+
+	addrs, err := h.AddrList(eth0, netlink.FAMILY_V4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || addrs[0].IPNet.String() != "192.0.2.10/24" {
+		t.Errorf("eth0 addresses = %v, want 192.0.2.10/24", addrs)
+	}
+	routes, err := h.RouteList(eth0, netlink.FAMILY_V4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotDefault, gotMetric bool
+	for _, r := range routes {
+		if r.Dst == nil && r.Gw.String() == "192.0.2.1" {
+			gotDefault = true
+		}
+		if r.Dst != nil && r.Dst.String() == "198.51.100.0/24" && r.Priority == 10 {
+			gotMetric = true
+		}
+	}
+	if !gotDefault || !gotMetric {
+		t.Errorf("eth0 routes = %v, want a default route and one to 198.51.100.0/24", routes)
+	}
+}
+
+func TestApplyVLAN(t *testing.T) {
+	c, err := Parse([]byte(`
+interfaces:
+  - name: eth0.100
+    type: vlan
+    link: eth0
+    vlan_id: 100
+    addresses: [192.0.2.10/24]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newNamespace(t)
+	eth0, err := h.LinkByName("eth0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.LinkAdd(&netlink.Vlan{LinkAttrs: netlink.LinkAttrs{Name: "probe0", ParentIndex: eth0.Attrs().Index}, VlanId: 1}); err != nil {
+		t.Skipf("no VLAN support: %v", err)
+	}
+	if err := c.Apply(h); err != nil {
+		t.Fatalf("Apply() = %v", err)
+	}
+	if err := c.Apply(h); err != nil {
+		t.Fatalf("second Apply() = %v", err)
+	}
+
+	vlan, err := h.LinkByName("eth0.100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := vlan.(*netlink.Vlan); !ok || v.VlanId != 100 || v.ParentIndex != eth0.Attrs().Index {
+		t.Errorf("eth0.100 = %+v, want VLAN 100 on eth0", vlan)
+	}
+	if vlan.Attrs().Flags&net.FlagUp == 0 {
+		t.Errorf("eth0.100 is down, want it up")
+	}
+}
+
+func TestApplyBond(t *testing.T) {
+	c, err := Parse([]byte(`
+interfaces:
+  - name: bond0
+    type: bond
+    mode: active-backup
+    members: [eth0, eth1]
+    addresses: [192.0.2.10/24]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newNamespace(t)
+	if err := h.LinkAdd(netlink.NewLinkBond(netlink.LinkAttrs{Name: "probe0"})); err != nil {
+		t.Skipf("no bonding support: %v", err)
+	}
+	if err := c.Apply(h); err != nil {
+		t.Fatalf("Apply() = %v", err)
+	}
+	if err := c.Apply(h); err != nil {
+		t.Fatalf("second Apply() = %v", err)
+	}
+
+	bond, err := h.LinkByName("bond0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, ok := bond.(*netlink.Bond); !ok || b.Mode != netlink.BOND_MODE_ACTIVE_BACKUP {
+		t.Errorf("bond0 = %+v, want an active-backup bond", bond)
+	}
+	for _, name := range []string{"eth0", "eth1"} {
+		m, err := h.LinkByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Attrs().MasterIndex != bond.Attrs().Index {
+			t.Errorf("%s is not a member of bond0", name)
+		}
+	}
+}
@@ -0,0 +1,735 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nftables
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+var errMessage = errors.New("invalid nftables netlink message")
+
+// Sizes of the registers loaded and of the fields of headers matched.
+const (
+	ifNameLen = unix.IFNAMSIZ
+
+	ipv4Src   = 12
+	ipv4Dst   = 16
+	ipv6Src   = 8
+	ipv6Dst   = 24
+	portSrc   = 0
+	portDst   = 2
+	portLen   = 2
+	protoLen  = 1
+	familyLen = 1
+)
+
+// Kernel verdicts, of linux/netfilter.h.
+const (
+	nfDrop   = 0
+	nfAccept = 1
+)
+
+func nested(typ int) *nl.RtAttr {
+	return nl.NewRtAttr(typ|int(nl.NLA_F_NESTED), nil)
+}
+
+func be32(v uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, v)
+}
+
+// nfgenmsg is a struct nfgenmsg, the header of nftables messages.
+type nfgenmsg []byte
+
+func newNfgenmsg(f Family, resID uint16) nfgenmsg {
+	return nfgenmsg{byte(f), unix.NFNETLINK_V0, byte(resID >> 8), byte(resID)}
+}
+
+func (m nfgenmsg) Len() int {
+	return len(m)
+}
+
+func (m nfgenmsg) Serialize() []byte {
+	return m
+}
+
+func request(msg int, flags int, f Family) *nl.NetlinkRequest {
+	req := nl.NewNetlinkRequest(unix.NFNL_SUBSYS_NFTABLES<<8|msg, flags)
+	req.AddData(newNfgenmsg(f, 0))
+	return req
+}
+
+// expr returns an expression of a rule, of the nested attrs.
+func expr(name string, attrs ...*nl.RtAttr) *nl.RtAttr {
+	e := nested(unix.NFTA_LIST_ELEM)
+	e.AddRtAttr(unix.NFTA_EXPR_NAME, nl.ZeroTerminated(name))
+	data := nested(unix.NFTA_EXPR_DATA)
+	for _, a := range attrs {
+		data.AddChild(a)
+	}
+	e.AddChild(data)
+	return e
+}
+
+func value(typ int, b []byte) *nl.RtAttr {
+	a := nested(typ)
+	a.AddRtAttr(unix.NFTA_DATA_VALUE, b)
+	return a
+}
+
+// loadMeta loads the meta data key into register 1.
+func loadMeta(key uint32) *nl.RtAttr {
+	return expr("meta",
+		nl.NewRtAttr(unix.NFTA_META_DREG, be32(unix.NFT_REG_1)),
+		nl.NewRtAttr(unix.NFTA_META_KEY, be32(key)))
+}
+
+// loadPayload loads len bytes at offset of the header base into register 1.
+func loadPayload(base, offset, len uint32) *nl.RtAttr {
+	return expr("payload",
+		nl.NewRtAttr(unix.NFTA_PAYLOAD_DREG, be32(unix.NFT_REG_1)),
+		nl.NewRtAttr(unix.NFTA_PAYLOAD_BASE, be32(base)),
+		nl.NewRtAttr(unix.NFTA_PAYLOAD_OFFSET, be32(offset)),
+		nl.NewRtAttr(unix.NFTA_PAYLOAD_LEN, be32(len)))
+}
+
+// mask masks register 1 with m.
+func mask(m []byte) *nl.RtAttr {
+	return expr("bitwise",
+		nl.NewRtAttr(unix.NFTA_BITWISE_SREG, be32(unix.NFT_REG_1)),
+		nl.NewRtAttr(unix.NFTA_BITWISE_DREG, be32(unix.NFT_REG_1)),
+		nl.NewRtAttr(unix.NFTA_BITWISE_LEN, be32(uint32(len(m)))),
+		value(unix.NFTA_BITWISE_MASK, m),
+		value(unix.NFTA_BITWISE_XOR, make([]byte, len(m))))
+}
+
+// equal breaks out of the rule unless register 1 holds b.
+func equal(b []byte) *nl.RtAttr {
+	return expr("cmp",
+		nl.NewRtAttr(unix.NFTA_CMP_SREG, be32(unix.NFT_REG_1)),
+		nl.NewRtAttr(unix.NFTA_CMP_OP, be32(unix.NFT_CMP_EQ)),
+		value(unix.NFTA_CMP_DATA, b))
+}
+
+// verdictCode returns the kernel verdict of v, for a rule or a policy.
+func verdictCode(v Verdict) uint32 {
+	if v == Drop {
+		return nfDrop
+	}
+	return nfAccept
+}
+
+func verdict(v Verdict) *nl.RtAttr {
+	data := nested(unix.NFTA_IMMEDIATE_DATA)
+	code := nested(unix.NFTA_DATA_VERDICT)
+	code.AddRtAttr(unix.NFTA_VERDICT_CODE, be32(verdictCode(v)))
+	data.AddChild(code)
+	return expr("immediate", nl.NewRtAttr(unix.NFTA_IMMEDIATE_DREG, be32(unix.NFT_REG_VERDICT)), data)
+}
+
+// ifName returns the bytes interface names are compared with: the name and
+// NULs, or only the prefix for wildcards.
+func ifName(name string) []byte {
+	if prefix, ok := bytes.CutSuffix([]byte(name), []byte("*")); ok {
+		return prefix
+	}
+	b := make([]byte, ifNameLen)
+	copy(b, name)
+	return b
+}
+
+// matchPrefix matches the address at offset of the network header with n.
+func matchPrefix(offset uint32, n *net.IPNet) []*nl.RtAttr {
+	e := []*nl.RtAttr{loadPayload(unix.NFT_PAYLOAD_NETWORK_HEADER, offset, uint32(len(n.IP)))}
+	if ones, bits := n.Mask.Size(); ones != bits {
+		e = append(e, mask(n.Mask))
+	}
+	return append(e, equal(n.IP.Mask(n.Mask)))
+}
+
+// exprs returns the expressions of r, in the table of family f.
+func (r *Rule) exprs(f Family) ([]*nl.RtAttr, error) {
+	var e []*nl.RtAttr
+	if r.IIfName != "" {
+		e = append(e, loadMeta(unix.NFT_META_IIFNAME), equal(ifName(r.IIfName)))
+	}
+	if r.OIfName != "" {
+		e = append(e, loadMeta(unix.NFT_META_OIFNAME), equal(ifName(r.OIfName)))
+	}
+
+	var v4, v6 bool
+	for _, n := range []*net.IPNet{r.Src, r.Dst} {
+		if n != nil {
+			v4, v6 = v4 || isIPv4(n), v6 || !isIPv4(n)
+		}
+	}
+	switch {
+	case v4 && v6:
+		return nil, fmt.Errorf("%w: IPv4 and IPv6 addresses", errRule)
+	case v4 && f == IPv6, v6 && f == IPv4:
+		return nil, fmt.Errorf("%w: addresses of another family than the %s table", errRule, f)
+	case f == Inet && (v4 || v6):
+		// Inet tables see both, which the addresses tell apart.
+		nfproto := []byte{unix.NFPROTO_IPV6}
+		if v4 {
+			nfproto = []byte{unix.NFPROTO_IPV4}
+		}
+		e = append(e, loadMeta(unix.NFT_META_NFPROTO), equal(nfproto))
+	}
+	src, dst := uint32(ipv6Src), uint32(ipv6Dst)
+	if v4 {
+		src, dst = ipv4Src, ipv4Dst
+	}
+	if r.Src != nil {
+		e = append(e, matchPrefix(src, r.Src)...)
+	}
+	if r.Dst != nil {
+		e = append(e, matchPrefix(dst, r.Dst)...)
+	}
+
+	if (r.SrcPort != 0 || r.DstPort != 0) && r.Protocol != TCP && r.Protocol != UDP {
+		return nil, fmt.Errorf("%w: ports need TCP or UDP", errRule)
+	}
+	if r.Protocol != 0 {
+		e = append(e, loadMeta(unix.NFT_META_L4PROTO), equal([]byte{r.Protocol}))
+	}
+	for _, p := range []struct {
+		offset uint32
+		port   uint16
+	}{{portSrc, r.SrcPort}, {portDst, r.DstPort}} {
+		if p.port != 0 {
+			e = append(e,
+				loadPayload(unix.NFT_PAYLOAD_TRANSPORT_HEADER, p.offset, portLen),
+				equal(binary.BigEndian.AppendUint16(nil, p.port)))
+		}
+	}
+	if r.Verdict != NoVerdict {
+		e = append(e, verdict(r.Verdict))
+	}
+	return e, nil
+}
+
+// message returns the netlink message of c.
+func (c Change) message() (*nl.NetlinkRequest, error) {
+	t := c.Table
+	switch {
+	case c.Rule != nil:
+		r := c.Rule
+		var req *nl.NetlinkRequest
+		switch c.Op {
+		case Add:
+			req = request(unix.NFT_MSG_NEWRULE, unix.NLM_F_CREATE|unix.NLM_F_APPEND|unix.NLM_F_ACK, t.Family)
+		case Delete:
+			req = request(unix.NFT_MSG_DELRULE, unix.NLM_F_ACK, t.Family)
+		default:
+			return nil, fmt.Errorf("%w: %s", errRule, c)
+		}
+		req.AddData(nl.NewRtAttr(unix.NFTA_RULE_TABLE, nl.ZeroTerminated(t.Name)))
+		req.AddData(nl.NewRtAttr(unix.NFTA_RULE_CHAIN, nl.ZeroTerminated(r.Chain)))
+		if c.Op == Delete {
+			req.AddData(nl.NewRtAttr(unix.NFTA_RULE_HANDLE, binary.BigEndian.AppendUint64(nil, r.Handle)))
+			return req, nil
+		}
+		e, err := r.exprs(t.Family)
+		if err != nil {
+			return nil, err
+		}
+		exprs := nested(unix.NFTA_RULE_EXPRESSIONS)
+		for _, a := range e {
+			exprs.AddChild(a)
+		}
+		req.AddData(exprs)
+		return req, nil
+
+	case c.Chain != nil:
+		ch := c.Chain
+		var req *nl.NetlinkRequest
+		switch c.Op {
+		case Add:
+			req = request(unix.NFT_MSG_NEWCHAIN, unix.NLM_F_CREATE|unix.NLM_F_ACK, t.Family)
+		case Delete:
+			req = request(unix.NFT_MSG_DELCHAIN, unix.NLM_F_ACK, t.Family)
+		case Flush:
+			// Deleting the rules of a chain, without a handle.
+			req = request(unix.NFT_MSG_DELRULE, unix.NLM_F_ACK, t.Family)
+			req.AddData(nl.NewRtAttr(unix.NFTA_RULE_TABLE, nl.ZeroTerminated(t.Name)))
+			req.AddData(nl.NewRtAttr(unix.NFTA_RULE_CHAIN, nl.ZeroTerminated(ch.Name)))
+			return req, nil
+		}
+		req.AddData(nl.NewRtAttr(unix.NFTA_CHAIN_TABLE, nl.ZeroTerminated(t.Name)))
+		req.AddData(nl.NewRtAttr(unix.NFTA_CHAIN_NAME, nl.ZeroTerminated(ch.Name)))
+		if c.Op == Add && ch.Type != "" {
+			hook := nested(unix.NFTA_CHAIN_HOOK)
+			hook.AddRtAttr(unix.NFTA_HOOK_HOOKNUM, be32(uint32(ch.Hook)))
+			hook.AddRtAttr(unix.NFTA_HOOK_PRIORITY, be32(uint32(ch.Priority)))
+			req.AddData(hook)
+			req.AddData(nl.NewRtAttr(unix.NFTA_CHAIN_TYPE, nl.ZeroTerminated(ch.Type)))
+		}
+		if c.Op == Add && ch.Policy != NoVerdict {
+			req.AddData(nl.NewRtAttr(unix.NFTA_CHAIN_POLICY, be32(verdictCode(ch.Policy))))
+		}
+		return req, nil
+	}
+
+	var req *nl.NetlinkRequest
+	switch c.Op {
+	case Add:
+		req = request(unix.NFT_MSG_NEWTABLE, unix.NLM_F_CREATE|unix.NLM_F_ACK, t.Family)
+	case Delete:
+		req = request(unix.NFT_MSG_DELTABLE, unix.NLM_F_ACK, t.Family)
+	default:
+		return nil, fmt.Errorf("%w: %s", errRule, c)
+	}
+	req.AddData(nl.NewRtAttr(unix.NFTA_TABLE_NAME, nl.ZeroTerminated(t.Name)))
+	return req, nil
+}
+
+// Commit applies the changes of b, all of them or none.
+func (b *Batch) Commit() error {
+	if len(b.Changes) == 0 {
+		return nil
+	}
+	begin := nl.NewNetlinkRequest(unix.NFNL_MSG_BATCH_BEGIN, 0)
+	begin.AddData(newNfgenmsg(0, unix.NFNL_SUBSYS_NFTABLES))
+	msg := begin.Serialize()
+	changes := map[uint32]Change{}
+	for _, c := range b.Changes {
+		req, err := c.message()
+		if err != nil {
+			return fmt.Errorf("%s: %w", c, err)
+		}
+		changes[req.Seq] = c
+		msg = append(msg, req.Serialize()...)
+	}
+	end := nl.NewNetlinkRequest(unix.NFNL_MSG_BATCH_END, 0)
+	end.AddData(newNfgenmsg(0, unix.NFNL_SUBSYS_NFTABLES))
+	msg = append(msg, end.Serialize()...)
+
+	s, err := nl.Subscribe(unix.NETLINK_NETFILTER)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	if err := unix.Sendto(s.GetFd(), msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	// Every change is acknowledged, the first error failing the batch.
+	for acked := 0; acked < len(changes); {
+		msgs, _, err := s.Receive()
+		if err != nil {
+			return err
+		}
+		for _, m := range msgs {
+			if m.Header.Type != unix.NLMSG_ERROR {
+				continue
+			}
+			if len(m.Data) < 4 {
+				return fmt.Errorf("%w: error of %d bytes", errMessage, len(m.Data))
+			}
+			if errno := -int32(binary.NativeEndian.Uint32(m.Data)); errno != 0 {
+				if c, ok := changes[m.Header.Seq]; ok {
+					return fmt.Errorf("%s: %w", c, syscall.Errno(errno))
+				}
+				return syscall.Errno(errno)
+			}
+			if _, ok := changes[m.Header.Seq]; ok {
+				acked++
+			}
+		}
+	}
+	return nil
+}
+
+func attrType(a syscall.NetlinkRouteAttr) uint16 {
+	return a.Attr.Type & nl.NLA_TYPE_MASK
+}
+
+// dump returns the families and attributes of the replies to a dump of
+// the objects of msg in the family f, all of them if f is 0.
+func dump(msg int, f Family, attrs ...*nl.RtAttr) ([]Family, [][]syscall.NetlinkRouteAttr, error) {
+	req := request(msg, unix.NLM_F_DUMP, f)
+	for _, a := range attrs {
+		req.AddData(a)
+	}
+	msgs, err := req.Execute(unix.NETLINK_NETFILTER, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	var families []Family
+	var replies [][]syscall.NetlinkRouteAttr
+	for _, m := range msgs {
+		if len(m) < nl.SizeofNfgenmsg {
+			return nil, nil, fmt.Errorf("%w: %d bytes", errMessage, len(m))
+		}
+		a, err := nl.ParseRouteAttr(m[nl.SizeofNfgenmsg:])
+		if err != nil {
+			return nil, nil, err
+		}
+		families = append(families, Family(m[0]))
+		replies = append(replies, a)
+	}
+	return families, replies, nil
+}
+
+// Tables returns the tables of the family f, of all families if f is 0.
+func Tables(f Family) ([]Table, error) {
+	families, replies, err := dump(unix.NFT_MSG_GETTABLE, f)
+	if err != nil {
+		return nil, err
+	}
+	var tables []Table
+	for i, attrs := range replies {
+		t := Table{Family: families[i]}
+		for _, a := range attrs {
+			if attrType(a) == unix.NFTA_TABLE_NAME {
+				t.Name = nl.BytesToString(a.Value)
+			}
+		}
+		tables = append(tables, t)
+	}
+	return tables, nil
+}
+
+// verdictOf returns the Verdict of a kernel verdict, false for those there
+// is none for.
+func verdictOf(code uint32) (Verdict, bool) {
+	switch code {
+	case nfAccept:
+		return Accept, true
+	case nfDrop:
+		return Drop, true
+	}
+	return NoVerdict, false
+}
+
+func parseChain(t Table, attrs []syscall.NetlinkRouteAttr) (*Chain, error) {
+	c := &Chain{Table: t}
+	for _, a := range attrs {
+		switch attrType(a) {
+		case unix.NFTA_CHAIN_NAME:
+			c.Name = nl.BytesToString(a.Value)
+		case unix.NFTA_CHAIN_TYPE:
+			c.Type = nl.BytesToString(a.Value)
+		case unix.NFTA_CHAIN_POLICY:
+			if len(a.Value) < 4 {
+				return nil, fmt.Errorf("%w: policy of %d bytes", errMessage, len(a.Value))
+			}
+			c.Policy, _ = verdictOf(binary.BigEndian.Uint32(a.Value))
+		case unix.NFTA_CHAIN_HOOK:
+			hook, err := nl.ParseRouteAttr(a.Value)
+			if err != nil {
+				return nil, err
+			}
+			for _, h := range hook {
+				if len(h.Value) < 4 {
+					continue
+				}
+				switch attrType(h) {
+				case unix.NFTA_HOOK_HOOKNUM:
+					c.Hook = Hook(binary.BigEndian.Uint32(h.Value))
+				case unix.NFTA_HOOK_PRIORITY:
+					c.Priority = int32(binary.BigEndian.Uint32(h.Value))
+				}
+			}
+		}
+	}
+	return c, nil
+}
+
+// Chains returns the chains of the table t.
+func Chains(t Table) ([]*Chain, error) {
+	families, replies, err := dump(unix.NFT_MSG_GETCHAIN, t.Family)
+	if err != nil {
+		return nil, err
+	}
+	var chains []*Chain
+	for i, attrs := range replies {
+		var table string
+		for _, a := range attrs {
+			if attrType(a) == unix.NFTA_CHAIN_TABLE {
+				table = nl.BytesToString(a.Value)
+			}
+		}
+		if families[i] != t.Family || table != t.Name {
+			continue
+		}
+		c, err := parseChain(t, attrs)
+		if err != nil {
+			return nil, err
+		}
+		chains = append(chains, c)
+	}
+	return chains, nil
+}
+
+// exprData is an expression of a rule, its data attributes by type.
+type exprData struct {
+	name  string
+	attrs map[uint16][]byte
+}
+
+func (e exprData) u32(typ uint16) (uint32, bool) {
+	b, ok := e.attrs[typ]
+	if !ok || len(b) < 4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(b), true
+}
+
+// data returns the value of the nested data attribute typ.
+func (e exprData) data(typ uint16, kind uint16) []byte {
+	attrs, err := nl.ParseRouteAttr(e.attrs[typ])
+	if err != nil {
+		return nil
+	}
+	for _, a := range attrs {
+		if attrType(a) == kind {
+			return a.Value
+		}
+	}
+	return nil
+}
+
+func parseExprs(b []byte) ([]exprData, error) {
+	elems, err := nl.ParseRouteAttr(b)
+	if err != nil {
+		return nil, err
+	}
+	var exprs []exprData
+	for _, elem := range elems {
+		attrs, err := nl.ParseRouteAttr(elem.Value)
+		if err != nil {
+			return nil, err
+		}
+		e := exprData{attrs: map[uint16][]byte{}}
+		for _, a := range attrs {
+			switch attrType(a) {
+			case unix.NFTA_EXPR_NAME:
+				e.name = nl.BytesToString(a.Value)
+			case unix.NFTA_EXPR_DATA:
+				data, err := nl.ParseRouteAttr(a.Value)
+				if err != nil {
+					return nil, err
+				}
+				for _, d := range data {
+					e.attrs[attrType(d)] = d.Value
+				}
+			}
+		}
+		exprs = append(exprs, e)
+	}
+	return exprs, nil
+}
+
+// load is what a rule loaded into register 1: meta data or a payload.
+type load struct {
+	meta              bool
+	key               uint32
+	base, offset, len uint32
+	mask              []byte
+	// valid is false after loads into other registers.
+	valid bool
+}
+
+// match sets the field of r that comparing l with b matches, reporting
+// whether Rule describes it. f is the family of the addresses.
+func (r *Rule) match(l load, b []byte, f Family) bool {
+	if l.meta {
+		switch l.key {
+		case unix.NFT_META_IIFNAME, unix.NFT_META_OIFNAME:
+			name := string(b)
+			if i := bytes.IndexByte(b, 0); i >= 0 {
+				name = string(b[:i])
+			} else if len(b) < ifNameLen {
+				name += "*"
+			}
+			if l.key == unix.NFT_META_IIFNAME {
+				r.IIfName = name
+			} else {
+				r.OIfName = name
+			}
+		case unix.NFT_META_L4PROTO:
+			if len(b) != protoLen {
+				return false
+			}
+			r.Protocol = b[0]
+		default:
+			return false
+		}
+		return true
+	}
+
+	switch l.base {
+	case unix.NFT_PAYLOAD_TRANSPORT_HEADER:
+		if l.len != portLen || len(b) != portLen || l.mask != nil {
+			return false
+		}
+		switch l.offset {
+		case portSrc:
+			r.SrcPort = binary.BigEndian.Uint16(b)
+		case portDst:
+			r.DstPort = binary.BigEndian.Uint16(b)
+		default:
+			return false
+		}
+		return true
+	case unix.NFT_PAYLOAD_NETWORK_HEADER:
+		size, src, dst := net.IPv4len, uint32(ipv4Src), uint32(ipv4Dst)
+		switch f {
+		case IPv4:
+		case IPv6:
+			size, src, dst = net.IPv6len, ipv6Src, ipv6Dst
+		default:
+			return false
+		}
+		// nft(8) loads only the bytes of byte aligned prefixes.
+		if l.len > uint32(size) || len(b) != int(l.len) {
+			return false
+		}
+		ip := make(net.IP, size)
+		copy(ip, b)
+		ones := 8 * int(l.len)
+		if l.mask != nil {
+			m := make(net.IPMask, size)
+			copy(m, l.mask)
+			var bits int
+			if ones, bits = m.Size(); bits == 0 {
+				return false
+			}
+		}
+		n := &net.IPNet{IP: ip, Mask: net.CIDRMask(ones, 8*size)}
+		switch l.offset {
+		case src:
+			r.Src = n
+		case dst:
+			r.Dst = n
+		default:
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// parseExprs parses the expressions of a rule of a table of family f into
+// r, setting Other for those r does not describe.
+func (r *Rule) parseExprs(exprs []exprData, f Family) {
+	var l load
+	for _, e := range exprs {
+		switch e.name {
+		case "meta":
+			key, ok := e.u32(unix.NFTA_META_KEY)
+			dreg, dok := e.u32(unix.NFTA_META_DREG)
+			l = load{meta: true, key: key, valid: ok && dok && dreg == unix.NFT_REG_1}
+		case "payload":
+			dreg, dok := e.u32(unix.NFTA_PAYLOAD_DREG)
+			base, bok := e.u32(unix.NFTA_PAYLOAD_BASE)
+			offset, ook := e.u32(unix.NFTA_PAYLOAD_OFFSET)
+			n, lok := e.u32(unix.NFTA_PAYLOAD_LEN)
+			l = load{base: base, offset: offset, len: n, valid: dok && bok && ook && lok && dreg == unix.NFT_REG_1}
+		case "bitwise":
+			m := e.data(unix.NFTA_BITWISE_MASK, unix.NFTA_DATA_VALUE)
+			xor := e.data(unix.NFTA_BITWISE_XOR, unix.NFTA_DATA_VALUE)
+			if !l.valid || l.meta || l.mask != nil || len(m) != int(l.len) || !bytes.Equal(xor, make([]byte, len(xor))) {
+				r.Other = true
+				l.valid = false
+				continue
+			}
+			l.mask = m
+		case "cmp":
+			op, _ := e.u32(unix.NFTA_CMP_OP)
+			b := e.data(unix.NFTA_CMP_DATA, unix.NFTA_DATA_VALUE)
+			if !l.valid || op != unix.NFT_CMP_EQ {
+				r.Other = true
+				continue
+			}
+			if l.meta && l.key == unix.NFT_META_NFPROTO && len(b) == familyLen {
+				// Tells the family of the addresses of inet tables.
+				f = Family(b[0])
+				continue
+			}
+			if !r.match(l, b, f) {
+				r.Other = true
+			}
+		case "immediate":
+			dreg, _ := e.u32(unix.NFTA_IMMEDIATE_DREG)
+			data := e.data(unix.NFTA_IMMEDIATE_DATA, unix.NFTA_DATA_VERDICT)
+			attrs, _ := nl.ParseRouteAttr(data)
+			ok := false
+			for _, a := range attrs {
+				if attrType(a) == unix.NFTA_VERDICT_CODE && len(a.Value) >= 4 {
+					r.Verdict, ok = verdictOf(binary.BigEndian.Uint32(a.Value))
+				}
+			}
+			if dreg != unix.NFT_REG_VERDICT || !ok {
+				r.Other = true
+			}
+		case "counter":
+			// Counting does not change what the rule does.
+		default:
+			r.Other = true
+		}
+	}
+}
+
+func parseRule(t Table, attrs []syscall.NetlinkRouteAttr) (*Rule, error) {
+	r := &Rule{Table: t}
+	for _, a := range attrs {
+		switch attrType(a) {
+		case unix.NFTA_RULE_CHAIN:
+			r.Chain = nl.BytesToString(a.Value)
+		case unix.NFTA_RULE_HANDLE:
+			if len(a.Value) < 8 {
+				return nil, fmt.Errorf("%w: handle of %d bytes", errMessage, len(a.Value))
+			}
+			r.Handle = binary.BigEndian.Uint64(a.Value)
+		case unix.NFTA_RULE_EXPRESSIONS:
+			exprs, err := parseExprs(a.Value)
+			if err != nil {
+				return nil, err
+			}
+			r.parseExprs(exprs, t.Family)
+		}
+	}
+	return r, nil
+}
+
+// Rules returns the rules of the chain of the table t.
+func Rules(t Table, chain string) ([]*Rule, error) {
+	families, replies, err := dump(unix.NFT_MSG_GETRULE, t.Family,
+		nl.NewRtAttr(unix.NFTA_RULE_TABLE, nl.ZeroTerminated(t.Name)),
+		nl.NewRtAttr(unix.NFTA_RULE_CHAIN, nl.ZeroTerminated(chain)))
+	if err != nil {
+		return nil, err
+	}
+	var rules []*Rule
+	for i, attrs := range replies {
+		var table string
+		for _, a := range attrs {
+			if attrType(a) == unix.NFTA_RULE_TABLE {
+				table = nl.BytesToString(a.Value)
+			}
+		}
+		if families[i] != t.Family || table != t.Name {
+			continue
+		}
+		r, err := parseRule(t, attrs)
+		if err != nil {
+			return nil, err
+		}
+		if r.Chain == chain {
+			rules = append(rules, r)
+		}
+	}
+	return rules, nil
+}
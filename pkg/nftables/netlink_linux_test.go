@@ -0,0 +1,161 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nftables
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// encode returns the attributes of a rule listed with the expressions of r.
+func encode(t *testing.T, tb Table, r *Rule, extra ...*nl.RtAttr) []byte {
+	t.Helper()
+	e, err := r.exprs(tb.Family)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exprs := nested(unix.NFTA_RULE_EXPRESSIONS)
+	for _, a := range append(e, extra...) {
+		exprs.AddChild(a)
+	}
+	b := nl.NewRtAttr(unix.NFTA_RULE_CHAIN, nl.ZeroTerminated("input")).Serialize()
+	b = append(b, nl.NewRtAttr(unix.NFTA_RULE_HANDLE, []byte{0, 0, 0, 0, 0, 0, 0, 7}).Serialize()...)
+	return append(b, exprs.Serialize()...)
+}
+
+func TestRuleRoundTrip(t *testing.T) {
+	for _, tt := range []struct {
+		family Family
+		rule   string
+	}{
+		{IPv4, "accept"},
+		{IPv4, "iifname eth0 tcp dport 22 accept"},
+		{IPv4, "iifname eth* oifname wg0 drop"},
+		{IPv4, "ip saddr 10.0.0.0/8 ip daddr 192.168.1.1 drop"},
+		{IPv4, "ip saddr 10.16.0.0/12 udp sport 53 udp dport 1024 accept"},
+		{IPv6, "ip6 saddr fe80::/10 meta l4proto icmpv6 accept"},
+		{IPv6, "ip6 daddr 2001:db8::1 tcp dport 443 drop"},
+		{Inet, "ip saddr 10.0.0.1 drop"},
+		{Inet, "ip6 daddr 2001:db8::/33 accept"},
+		{Inet, "meta l4proto udp"},
+	} {
+		t.Run(tt.family.String()+" "+tt.rule, func(t *testing.T) {
+			want, err := ParseRule(strings.Fields(tt.rule))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tb := Table{Family: tt.family, Name: "filter"}
+			want.Table, want.Chain, want.Handle = tb, "input", 7
+			attrs, err := nl.ParseRouteAttr(encode(t, tb, want))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := parseRule(tb, attrs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("parseRule (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRuleShortPrefix(t *testing.T) {
+	// nft(8) loads only the bytes of byte aligned prefixes, and counts.
+	tb := Table{Family: IPv4, Name: "filter"}
+	r := &Rule{Table: tb, Chain: "input"}
+	attrs, err := nl.ParseRouteAttr(encode(t, tb, r,
+		loadPayload(unix.NFT_PAYLOAD_NETWORK_HEADER, ipv4Src, 2),
+		equal([]byte{10, 1}),
+		expr("counter"),
+		verdict(Drop)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parseRule(tb, attrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := got.String(); s != "ip saddr 10.1.0.0/16 drop" {
+		t.Errorf("String() = %q, want %q", s, "ip saddr 10.1.0.0/16 drop")
+	}
+}
+
+func TestRuleOther(t *testing.T) {
+	tb := Table{Family: IPv4, Name: "filter"}
+	for _, tt := range []struct {
+		name  string
+		exprs []*nl.RtAttr
+	}{
+		{"limit", []*nl.RtAttr{expr("limit"), verdict(Accept)}},
+		{"meta mark", []*nl.RtAttr{loadMeta(unix.NFT_META_MARK), equal([]byte{1, 0, 0, 0})}},
+		{"ttl", []*nl.RtAttr{loadPayload(unix.NFT_PAYLOAD_NETWORK_HEADER, 8, 1), equal([]byte{1})}},
+		{"cmp without load", []*nl.RtAttr{equal([]byte{1})}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			attrs, err := nl.ParseRouteAttr(encode(t, tb, &Rule{}, tt.exprs...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := parseRule(tb, attrs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Other {
+				t.Errorf("parseRule(%s) = %s, want Other set", tt.name, got)
+			}
+		})
+	}
+}
+
+func TestRuleExprsErrors(t *testing.T) {
+	for _, tt := range []struct {
+		family Family
+		r      *Rule
+	}{
+		{IPv4, &Rule{Src: prefixOf("10.0.0.1"), Dst: prefixOf("::1")}},
+		{IPv6, &Rule{Src: prefixOf("10.0.0.1")}},
+		{IPv4, &Rule{Dst: prefixOf("::1")}},
+		{IPv4, &Rule{DstPort: 22}},
+		{IPv4, &Rule{Protocol: ICMP, DstPort: 22}},
+	} {
+		if _, err := tt.r.exprs(tt.family); !errors.Is(err, errRule) {
+			t.Errorf("exprs(%s, %s) = %v, want %v", tt.family, tt.r, err, errRule)
+		}
+	}
+}
+
+func TestChainMessage(t *testing.T) {
+	tb := Table{Family: Inet, Name: "filter"}
+	want := &Chain{Table: tb, Name: "input", Type: "filter", Hook: Input, Priority: -10, Policy: Drop}
+	req, err := Change{Op: Add, Table: tb, Chain: want}.message()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Type != unix.NFNL_SUBSYS_NFTABLES<<8|unix.NFT_MSG_NEWCHAIN {
+		t.Errorf("type = %#x, want NFT_MSG_NEWCHAIN", req.Type)
+	}
+	b := req.Serialize()[unix.SizeofNlMsghdr:]
+	if Family(b[0]) != Inet {
+		t.Errorf("family = %d, want %d", b[0], Inet)
+	}
+	attrs, err := nl.ParseRouteAttr(b[nl.SizeofNfgenmsg:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parseChain(tb, attrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("parseChain (-want +got):\n%s", diff)
+	}
+}
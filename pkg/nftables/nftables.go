@@ -0,0 +1,427 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package nftables programs stateless filter rules, accepting or dropping
+// packets by interface, address and port, through the nftables netlink API.
+package nftables
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var errRule = errors.New("invalid rule")
+
+// Family is the family of a table, the packets its chains see.
+type Family uint8
+
+// Families, the NFPROTO_ ones of linux/netfilter.h.
+const (
+	Inet Family = 1
+	IPv4 Family = 2
+	IPv6 Family = 10
+)
+
+var familyNames = map[Family]string{Inet: "inet", IPv4: "ip", IPv6: "ip6"}
+
+func (f Family) String() string {
+	if s, ok := familyNames[f]; ok {
+		return s
+	}
+	return fmt.Sprintf("family %d", f)
+}
+
+// ParseFamily parses the name of a family as nft(8) does: inet, ip or ip6.
+func ParseFamily(s string) (Family, error) {
+	for f, name := range familyNames {
+		if s == name {
+			return f, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: family %q", errRule, s)
+}
+
+// Hook is the point of the network stack packets enter a base chain from.
+type Hook uint32
+
+// Hooks, the NF_INET_ ones of linux/netfilter.h.
+const (
+	Prerouting Hook = iota
+	Input
+	Forward
+	Output
+	Postrouting
+)
+
+var hookNames = []string{"prerouting", "input", "forward", "output", "postrouting"}
+
+func (h Hook) String() string {
+	if int(h) < len(hookNames) {
+		return hookNames[h]
+	}
+	return fmt.Sprintf("hook %d", h)
+}
+
+// ParseHook parses the name of a hook.
+func ParseHook(s string) (Hook, error) {
+	for i, name := range hookNames {
+		if s == name {
+			return Hook(i), nil
+		}
+	}
+	return 0, fmt.Errorf("%w: hook %q", errRule, s)
+}
+
+// Verdict is what becomes of a packet matching a rule, or reaching the end
+// of a base chain.
+type Verdict uint8
+
+// Verdicts.
+const (
+	// NoVerdict is that of rules which only match, counting for instance,
+	// and of chains keeping the default policy, accepting.
+	NoVerdict Verdict = iota
+	Accept
+	Drop
+)
+
+func (v Verdict) String() string {
+	switch v {
+	case Accept:
+		return "accept"
+	case Drop:
+		return "drop"
+	}
+	return ""
+}
+
+// ParseVerdict parses accept or drop.
+func ParseVerdict(s string) (Verdict, error) {
+	switch s {
+	case "accept":
+		return Accept, nil
+	case "drop":
+		return Drop, nil
+	}
+	return NoVerdict, fmt.Errorf("%w: verdict %q", errRule, s)
+}
+
+// Table is a table of chains.
+type Table struct {
+	Family Family
+	Name   string
+}
+
+func (t Table) String() string {
+	return t.Family.String() + " " + t.Name
+}
+
+// Chain is a chain of rules.
+type Chain struct {
+	Table Table
+	Name  string
+	// Type, Hook, Priority and Policy are those of base chains, which
+	// packets enter from Hook. Type is empty for regular chains.
+	Type     string
+	Hook     Hook
+	Priority int32
+	Policy   Verdict
+}
+
+// Rule is a rule of a chain: packets matching all of its set fields get its
+// verdict.
+type Rule struct {
+	Table Table
+	Chain string
+	// Handle identifies the rule in its chain, for deleting it.
+	Handle uint64
+
+	// IIfName and OIfName are the input and output interfaces, a trailing
+	// * matching all those starting alike.
+	IIfName string
+	OIfName string
+	Src     *net.IPNet
+	Dst     *net.IPNet
+	// Protocol is the IP protocol, which ports need to be TCP or UDP.
+	Protocol uint8
+	SrcPort  uint16
+	DstPort  uint16
+	Verdict  Verdict
+
+	// Other is set for listed rules with expressions Rule does not
+	// describe.
+	Other bool
+}
+
+// Protocols.
+const (
+	ICMP   = 1
+	TCP    = 6
+	UDP    = 17
+	ICMPv6 = 58
+)
+
+var protocolNames = map[uint8]string{ICMP: "icmp", TCP: "tcp", UDP: "udp", ICMPv6: "icmpv6"}
+
+func protocolName(p uint8) string {
+	if s, ok := protocolNames[p]; ok {
+		return s
+	}
+	return strconv.Itoa(int(p))
+}
+
+func parseProtocol(s string) (uint8, error) {
+	for p, name := range protocolNames {
+		if s == name {
+			return p, nil
+		}
+	}
+	p, err := strconv.ParseUint(s, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("%w: protocol %q", errRule, s)
+	}
+	return uint8(p), nil
+}
+
+func parsePrefix(s string) (*net.IPNet, error) {
+	if ip, n, err := net.ParseCIDR(s); err == nil {
+		if !ip.Equal(n.IP) {
+			return nil, fmt.Errorf("%w: %q has host bits set", errRule, s)
+		}
+		return n, nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("%w: address %q", errRule, s)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+func isIPv4(n *net.IPNet) bool {
+	return len(n.IP) == net.IPv4len
+}
+
+// ParseRule parses the matches and verdict of a rule in the syntax of
+// nft(8):
+//
+//	[iifname NAME] [oifname NAME] [ip|ip6 saddr|daddr ADDR[/LEN]]...
+//	[tcp|udp sport|dport PORT]... [meta l4proto PROTOCOL] [accept|drop]
+func ParseRule(args []string) (*Rule, error) {
+	r := &Rule{}
+	// protocol sets the protocol of ports or of meta l4proto.
+	protocol := func(p uint8) error {
+		if r.Protocol != 0 && r.Protocol != p {
+			return fmt.Errorf("%w: both %s and %s", errRule, protocolName(r.Protocol), protocolName(p))
+		}
+		r.Protocol = p
+		return nil
+	}
+
+	for len(args) > 0 {
+		tok := args[0]
+		args = args[1:]
+		if v, err := ParseVerdict(tok); err == nil {
+			if len(args) != 0 {
+				return nil, fmt.Errorf("%w: %q after the verdict", errRule, args[0])
+			}
+			r.Verdict = v
+			break
+		}
+
+		var err error
+		switch tok {
+		case "iifname", "oifname":
+			if len(args) == 0 {
+				return nil, fmt.Errorf("%w: %s needs an interface", errRule, tok)
+			}
+			name := args[0]
+			args = args[1:]
+			if name == "" || len(name) > 15 {
+				return nil, fmt.Errorf("%w: interface %q", errRule, name)
+			}
+			if tok == "iifname" {
+				r.IIfName = name
+			} else {
+				r.OIfName = name
+			}
+		case "ip", "ip6":
+			if len(args) < 2 || (args[0] != "saddr" && args[0] != "daddr") {
+				return nil, fmt.Errorf("%w: %s needs saddr or daddr and an address", errRule, tok)
+			}
+			var n *net.IPNet
+			if n, err = parsePrefix(args[1]); err != nil {
+				return nil, err
+			}
+			if isIPv4(n) != (tok == "ip") {
+				return nil, fmt.Errorf("%w: %s address %q", errRule, tok, args[1])
+			}
+			if args[0] == "saddr" {
+				r.Src = n
+			} else {
+				r.Dst = n
+			}
+			args = args[2:]
+		case "tcp", "udp":
+			if len(args) < 2 || (args[0] != "sport" && args[0] != "dport") {
+				return nil, fmt.Errorf("%w: %s needs sport or dport and a port", errRule, tok)
+			}
+			port, perr := strconv.ParseUint(args[1], 10, 16)
+			if perr != nil || port == 0 {
+				return nil, fmt.Errorf("%w: port %q", errRule, args[1])
+			}
+			if args[0] == "sport" {
+				r.SrcPort = uint16(port)
+			} else {
+				r.DstPort = uint16(port)
+			}
+			args = args[2:]
+			p := uint8(TCP)
+			if tok == "udp" {
+				p = UDP
+			}
+			err = protocol(p)
+		case "meta":
+			if len(args) < 2 || args[0] != "l4proto" {
+				return nil, fmt.Errorf("%w: only meta l4proto is supported", errRule)
+			}
+			var p uint8
+			if p, err = parseProtocol(args[1]); err == nil {
+				err = protocol(p)
+			}
+			args = args[2:]
+		default:
+			return nil, fmt.Errorf("%w: %q", errRule, tok)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func prefix(n *net.IPNet) string {
+	if ones, bits := n.Mask.Size(); ones == bits {
+		return n.IP.String()
+	}
+	return n.String()
+}
+
+// String returns the matches and the verdict of r in the syntax of
+// ParseRule.
+func (r *Rule) String() string {
+	var s []string
+	if r.IIfName != "" {
+		s = append(s, "iifname", strconv.Quote(r.IIfName))
+	}
+	if r.OIfName != "" {
+		s = append(s, "oifname", strconv.Quote(r.OIfName))
+	}
+	for _, a := range []struct {
+		name string
+		n    *net.IPNet
+	}{{"saddr", r.Src}, {"daddr", r.Dst}} {
+		if a.n == nil {
+			continue
+		}
+		ip := "ip6"
+		if isIPv4(a.n) {
+			ip = "ip"
+		}
+		s = append(s, ip, a.name, prefix(a.n))
+	}
+	proto := protocolName(r.Protocol)
+	if r.SrcPort != 0 {
+		s = append(s, proto, "sport", strconv.Itoa(int(r.SrcPort)))
+	}
+	if r.DstPort != 0 {
+		s = append(s, proto, "dport", strconv.Itoa(int(r.DstPort)))
+	}
+	if r.Protocol != 0 && r.SrcPort == 0 && r.DstPort == 0 {
+		s = append(s, "meta", "l4proto", proto)
+	}
+	if r.Other {
+		s = append(s, "...")
+	}
+	if r.Verdict != NoVerdict {
+		s = append(s, r.Verdict.String())
+	}
+	return strings.Join(s, " ")
+}
+
+// Op is what a Change does.
+type Op int
+
+// Ops.
+const (
+	Add Op = iota
+	Delete
+	// Flush deletes the rules of a chain.
+	Flush
+)
+
+// Change is a change to the ruleset: to Rule, or to Chain when Rule is
+// nil, or to Table when both are.
+type Change struct {
+	Op    Op
+	Table Table
+	Chain *Chain
+	Rule  *Rule
+}
+
+// Batch is a list of changes the kernel applies all at once, or not at all.
+type Batch struct {
+	Changes []Change
+}
+
+// AddTable adds the table t, if it does not exist.
+func (b *Batch) AddTable(t Table) {
+	b.Changes = append(b.Changes, Change{Op: Add, Table: t})
+}
+
+// DelTable deletes the table t, with its chains and rules.
+func (b *Batch) DelTable(t Table) {
+	b.Changes = append(b.Changes, Change{Op: Delete, Table: t})
+}
+
+// AddChain adds the chain c, or changes its policy if it exists.
+func (b *Batch) AddChain(c *Chain) {
+	b.Changes = append(b.Changes, Change{Op: Add, Table: c.Table, Chain: c})
+}
+
+// DelChain deletes the chain c, which must have no rules.
+func (b *Batch) DelChain(c *Chain) {
+	b.Changes = append(b.Changes, Change{Op: Delete, Table: c.Table, Chain: c})
+}
+
+// FlushChain deletes the rules of the chain c.
+func (b *Batch) FlushChain(c *Chain) {
+	b.Changes = append(b.Changes, Change{Op: Flush, Table: c.Table, Chain: c})
+}
+
+// AddRule appends the rule r to its chain.
+func (b *Batch) AddRule(r *Rule) {
+	b.Changes = append(b.Changes, Change{Op: Add, Table: r.Table, Chain: &Chain{Table: r.Table, Name: r.Chain}, Rule: r})
+}
+
+// DelRule deletes the rule of the handle of r.
+func (b *Batch) DelRule(r *Rule) {
+	b.Changes = append(b.Changes, Change{Op: Delete, Table: r.Table, Chain: &Chain{Table: r.Table, Name: r.Chain}, Rule: r})
+}
+
+func (c Change) String() string {
+	op := [...]string{Add: "add", Delete: "delete", Flush: "flush"}[c.Op]
+	switch {
+	case c.Rule != nil:
+		return fmt.Sprintf("%s rule %s %s", op, c.Table, c.Chain.Name)
+	case c.Chain != nil:
+		return fmt.Sprintf("%s chain %s %s", op, c.Table, c.Chain.Name)
+	}
+	return fmt.Sprintf("%s table %s", op, c.Table)
+}
@@ -0,0 +1,116 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nftables
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func prefixOf(s string) *net.IPNet {
+	n, err := parsePrefix(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+func TestParseRule(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want *Rule
+		err  error
+	}{
+		{in: "accept", want: &Rule{Verdict: Accept}},
+		{in: "tcp dport 22 accept", want: &Rule{Protocol: TCP, DstPort: 22, Verdict: Accept}},
+		{
+			in:   "iifname eth* ip saddr 10.0.0.0/8 udp sport 53 udp dport 1024 drop",
+			want: &Rule{IIfName: "eth*", Src: prefixOf("10.0.0.0/8"), Protocol: UDP, SrcPort: 53, DstPort: 1024, Verdict: Drop},
+		},
+		{
+			in:   "oifname lo ip6 daddr fe80::1 meta l4proto icmpv6",
+			want: &Rule{OIfName: "lo", Dst: prefixOf("fe80::1"), Protocol: ICMPv6},
+		},
+		{in: "meta l4proto 132 drop", want: &Rule{Protocol: 132, Verdict: Drop}},
+		{in: "", want: &Rule{}},
+		{in: "accept drop", err: errRule},
+		{in: "tcp dport 22 udp sport 53", err: errRule},
+		{in: "tcp dport 0", err: errRule},
+		{in: "tcp dport 65536", err: errRule},
+		{in: "tcp port 22", err: errRule},
+		{in: "ip saddr fe80::1", err: errRule},
+		{in: "ip6 daddr 10.0.0.1", err: errRule},
+		{in: "ip saddr 10.0.0.1/8", err: errRule},
+		{in: "ip saddr", err: errRule},
+		{in: "iifname", err: errRule},
+		{in: "iifname averyveryverylongname", err: errRule},
+		{in: "meta mark 1", err: errRule},
+		{in: "meta l4proto sctp", err: errRule},
+		{in: "counter accept", err: errRule},
+	} {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseRule(strings.Fields(tt.in))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("ParseRule(%q) = %v, want %v", tt.in, err, tt.err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("ParseRule(%q) (-want +got):\n%s", tt.in, diff)
+			}
+		})
+	}
+}
+
+func TestRuleString(t *testing.T) {
+	for _, tt := range []struct {
+		r    *Rule
+		want string
+	}{
+		{&Rule{}, ""},
+		{&Rule{Verdict: Drop}, "drop"},
+		{
+			&Rule{IIfName: "eth*", OIfName: "lo", Src: prefixOf("10.0.0.0/8"), Dst: prefixOf("10.0.0.1"), Protocol: TCP, SrcPort: 1, DstPort: 2, Verdict: Accept},
+			`iifname "eth*" oifname "lo" ip saddr 10.0.0.0/8 ip daddr 10.0.0.1 tcp sport 1 tcp dport 2 accept`,
+		},
+		{&Rule{Dst: prefixOf("2001:db8::/32"), Protocol: ICMPv6, Other: true, Verdict: Drop}, "ip6 daddr 2001:db8::/32 meta l4proto icmpv6 ... drop"},
+	} {
+		if got := tt.r.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestBatch(t *testing.T) {
+	tb := Table{Family: Inet, Name: "filter"}
+	c := &Chain{Table: tb, Name: "input", Type: "filter", Hook: Input, Policy: Drop}
+	r := &Rule{Table: tb, Chain: "input", Handle: 4}
+	var b Batch
+	b.AddTable(tb)
+	b.AddChain(c)
+	b.AddRule(r)
+	b.DelRule(r)
+	b.FlushChain(c)
+	b.DelChain(c)
+	b.DelTable(tb)
+	var got []string
+	for _, c := range b.Changes {
+		got = append(got, c.String())
+	}
+	want := []string{
+		"add table inet filter",
+		"add chain inet filter input",
+		"add rule inet filter input",
+		"delete rule inet filter input",
+		"flush chain inet filter input",
+		"delete chain inet filter input",
+		"delete table inet filter",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("changes (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,25 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ntpdate
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// set sets the Timex field p, of a size depending on the architecture.
+func set[T int32 | int64](p *T, v int64) {
+	*p = T(v)
+}
+
+// slew has the kernel correct the clock by offset, at up to 500 ppm,
+// replacing any correction still going on.
+func slew(offset time.Duration) error {
+	tx := unix.Timex{Modes: unix.ADJ_OFFSET_SINGLESHOT}
+	set(&tx.Offset, offset.Microseconds())
+	_, err := unix.Adjtimex(&tx)
+	return err
+}
@@ -0,0 +1,17 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package ntpdate
+
+import (
+	"errors"
+	"time"
+)
+
+// slew is unsupported, the clock is stepped instead.
+func slew(time.Duration) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,202 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ntpdate
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+// Defaults of a Daemon, those of ntpd.
+const (
+	DefaultMinPoll       = 64 * time.Second
+	DefaultMaxPoll       = 1024 * time.Second
+	DefaultStepThreshold = 128 * time.Millisecond
+
+	queryTimeout = 5 * time.Second
+)
+
+// Sample is a measurement of the system clock against a server.
+type Sample struct {
+	Server string
+	// Offset is what the system clock is behind the server.
+	Offset time.Duration
+	RTT    time.Duration
+}
+
+type clock interface {
+	SetSystemTime(time.Time) error
+	SetRTCTime(time.Time) error
+	// Slew gradually corrects the system clock by offset.
+	Slew(offset time.Duration) error
+}
+
+type systemClock struct {
+	realGetterSetter
+}
+
+func (*systemClock) Slew(offset time.Duration) error {
+	return slew(offset)
+}
+
+// Daemon keeps the system clock disciplined to NTP servers: it polls them
+// periodically, slews the clock for small offsets and steps it for large
+// ones. The zero values of its fields are the defaults.
+type Daemon struct {
+	Servers []string
+	// NTS authenticates the servers with Network Time Security, the
+	// servers then being those of NTS key establishment.
+	NTS bool
+	// TLSConfig is used for NTS key establishment, the system roots
+	// are trusted if nil.
+	TLSConfig *tls.Config
+
+	// Polls start at MinPoll, double as the clock is stable up to
+	// MaxPoll and return to MinPoll on steps and failures.
+	MinPoll, MaxPoll time.Duration
+	// Offsets of StepThreshold and more are stepped, smaller ones slewed;
+	// all are stepped if it is negative.
+	StepThreshold time.Duration
+	// SetRTC sets the RTC too when stepping.
+	SetRTC bool
+
+	clock    clock
+	query    func(server string) (Sample, error)
+	sessions map[string]*ntsSession
+}
+
+func (d *Daemon) init() {
+	if d.MinPoll == 0 {
+		d.MinPoll = DefaultMinPoll
+	}
+	if d.MaxPoll < d.MinPoll {
+		d.MaxPoll = max(DefaultMaxPoll, d.MinPoll)
+	}
+	if d.StepThreshold == 0 {
+		d.StepThreshold = DefaultStepThreshold
+	}
+	if d.clock == nil {
+		d.clock = &systemClock{}
+	}
+	if d.query == nil {
+		d.query = d.plainQuery
+		if d.NTS {
+			d.query = d.ntsQuery
+		}
+	}
+	if d.sessions == nil {
+		d.sessions = map[string]*ntsSession{}
+	}
+}
+
+func (d *Daemon) plainQuery(server string) (Sample, error) {
+	r, err := ntp.QueryWithOptions(server, ntp.QueryOptions{Timeout: queryTimeout})
+	if err != nil {
+		return Sample{}, err
+	}
+	if err := r.Validate(); err != nil {
+		return Sample{}, err
+	}
+	return Sample{Server: server, Offset: r.ClockOffset, RTT: r.RTT}, nil
+}
+
+// ntsQuery queries server with NTS, establishing keys first if there are
+// none or no cookies are left.
+func (d *Daemon) ntsQuery(server string) (Sample, error) {
+	s := d.sessions[server]
+	if s == nil || len(s.cookies) == 0 {
+		var err error
+		if s, err = ntsKE(server, d.TLSConfig, queryTimeout); err != nil {
+			return Sample{}, err
+		}
+		d.sessions[server] = s
+	}
+	sample, err := ntsQuery(s, queryTimeout)
+	if errors.Is(err, errNTSNak) {
+		delete(d.sessions, server)
+	}
+	return sample, err
+}
+
+// Poll queries all servers once and corrects the clock with the sample of
+// the lowest round trip time. It returns the sample and whether the clock
+// was stepped.
+func (d *Daemon) Poll() (Sample, bool, error) {
+	d.init()
+	var best *Sample
+	var errs []error
+	for _, server := range d.Servers {
+		s, err := d.query(server)
+		if err != nil {
+			Debug("Error getting time from %s: %v", server, err)
+			errs = append(errs, fmt.Errorf("%s: %w", server, err))
+			continue
+		}
+		Debug("%s: offset %v, rtt %v", server, s.Offset, s.RTT)
+		if best == nil || s.RTT < best.RTT {
+			best = &s
+		}
+	}
+	if best == nil {
+		return Sample{}, false, fmt.Errorf("unable to get any time from servers %v: %w", d.Servers, errors.Join(errs...))
+	}
+
+	offset := best.Offset
+	if offset.Abs() < d.StepThreshold {
+		err := d.clock.Slew(offset)
+		if err == nil {
+			return *best, false, nil
+		}
+		if !errors.Is(err, errors.ErrUnsupported) {
+			return *best, false, fmt.Errorf("unable to slew system time: %w", err)
+		}
+	}
+
+	t := time.Now().Add(offset)
+	if err := d.clock.SetSystemTime(t); err != nil {
+		return *best, false, fmt.Errorf("unable to set system time: %w", err)
+	}
+	// A slew still going on would take the clock off again.
+	d.clock.Slew(0)
+	if d.SetRTC {
+		if err := d.clock.SetRTCTime(t); err != nil {
+			return *best, true, fmt.Errorf("unable to set RTC time: %w", err)
+		}
+	}
+	return *best, true, nil
+}
+
+// Run polls until ctx is done, logging each correction with logf.
+func (d *Daemon) Run(ctx context.Context, logf func(string, ...interface{})) error {
+	d.init()
+	if len(d.Servers) == 0 {
+		return fmt.Errorf("no servers")
+	}
+	interval := d.MinPoll
+	for {
+		s, stepped, err := d.Poll()
+		switch {
+		case err != nil:
+			logf("%v", err)
+			interval = d.MinPoll
+		case stepped:
+			logf("step time server %s offset %v", s.Server, s.Offset)
+			interval = d.MinPoll
+		default:
+			logf("slew time server %s offset %v", s.Server, s.Offset)
+			interval = min(2*interval, d.MaxPoll)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ntpdate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type mockClock struct {
+	set, rtc  []time.Time
+	slews     []time.Duration
+	slewError error
+}
+
+func (m *mockClock) SetSystemTime(t time.Time) error {
+	m.set = append(m.set, t)
+	return nil
+}
+
+func (m *mockClock) SetRTCTime(t time.Time) error {
+	m.rtc = append(m.rtc, t)
+	return nil
+}
+
+func (m *mockClock) Slew(offset time.Duration) error {
+	m.slews = append(m.slews, offset)
+	return m.slewError
+}
+
+// samples returns a query of the samples by server, the others failing.
+func samples(s ...Sample) func(string) (Sample, error) {
+	return func(server string) (Sample, error) {
+		for _, sample := range s {
+			if sample.Server == server {
+				return sample, nil
+			}
+		}
+		return Sample{}, fmt.Errorf("no answer")
+	}
+}
+
+func TestPoll(t *testing.T) {
+	a := Sample{Server: "a", Offset: 10 * time.Millisecond, RTT: 30 * time.Millisecond}
+	b := Sample{Server: "b", Offset: -20 * time.Millisecond, RTT: 20 * time.Millisecond}
+	far := Sample{Server: "far", Offset: -time.Minute, RTT: time.Millisecond}
+	for _, tt := range []struct {
+		name      string
+		servers   []string
+		samples   []Sample
+		slewError error
+		setRTC    bool
+		threshold time.Duration
+		want      Sample
+		stepped   bool
+		slews     []time.Duration
+		wantErr   bool
+	}{
+		{name: "lowest rtt", servers: []string{"a", "b", "c"}, samples: []Sample{a, b}, want: b, slews: []time.Duration{b.Offset}},
+		{name: "step", servers: []string{"a", "far"}, samples: []Sample{a, far}, setRTC: true, want: far, stepped: true, slews: []time.Duration{0}},
+		{
+			name: "slew unsupported", servers: []string{"a"}, samples: []Sample{a}, slewError: errors.ErrUnsupported,
+			want: a, stepped: true, slews: []time.Duration{a.Offset, 0},
+		},
+		{name: "always step", servers: []string{"a"}, samples: []Sample{a}, threshold: -1, want: a, stepped: true, slews: []time.Duration{0}},
+		{name: "slew fails", servers: []string{"a"}, samples: []Sample{a}, slewError: errors.New("nope"), want: a, wantErr: true, slews: []time.Duration{a.Offset}},
+		{name: "no answers", servers: []string{"c", "d"}, wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &mockClock{slewError: tt.slewError}
+			d := &Daemon{Servers: tt.servers, SetRTC: tt.setRTC, StepThreshold: tt.threshold, clock: m, query: samples(tt.samples...)}
+			got, stepped, err := d.Poll()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Poll() = %v, want error %t", err, tt.wantErr)
+			}
+			if got != tt.want || stepped != tt.stepped {
+				t.Errorf("Poll() = %v, %t, want %v, %t", got, stepped, tt.want, tt.stepped)
+			}
+			if fmt.Sprint(m.slews) != fmt.Sprint(tt.slews) {
+				t.Errorf("slews %v, want %v", m.slews, tt.slews)
+			}
+			if stepped {
+				if len(m.set) != 1 || time.Until(m.set[0].Add(-tt.want.Offset)).Abs() > time.Second {
+					t.Errorf("set times %v, want now plus %v", m.set, tt.want.Offset)
+				}
+				if tt.setRTC && len(m.rtc) != 1 {
+					t.Errorf("set RTC %v, want once", m.rtc)
+				}
+			} else if len(m.set) != 0 {
+				t.Errorf("set times %v, want none", m.set)
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	var polls []time.Time
+	m := &mockClock{}
+	d := &Daemon{
+		Servers: []string{"a"},
+		MinPoll: 10 * time.Millisecond,
+		MaxPoll: 40 * time.Millisecond,
+		clock:   m,
+		query: func(string) (Sample, error) {
+			polls = append(polls, time.Now())
+			return Sample{Server: "a"}, nil
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if err := d.Run(ctx, t.Logf); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Run() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	// Polls back off to 40ms, from 20ms after the first.
+	if len(polls) < 4 || len(polls) > 12 {
+		t.Fatalf("%d polls, want about 8", len(polls))
+	}
+	if last := polls[len(polls)-1].Sub(polls[len(polls)-2]); last < 40*time.Millisecond {
+		t.Errorf("last interval %v, want at least MaxPoll", last)
+	}
+	if err := (&Daemon{}).Run(context.Background(), t.Logf); err == nil {
+		t.Error("Run() without servers succeeded, want an error")
+	}
+}
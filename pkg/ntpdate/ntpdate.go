@@ -89,7 +89,9 @@ func (*realGetterSetter) SetRTCTime(t time.Time) error {
 	return r.Set(t)
 }
 
-func setTime(servers []string, config string, fallback string, setRTC bool, gs timeGetterSetter) (string, float64, error) {
+// Servers returns servers followed by those of the config file, or the
+// fallback if there are none.
+func Servers(servers []string, config string, fallback string) []string {
 	servers = servers[:]
 
 	if config != "" {
@@ -109,7 +111,11 @@ func setTime(servers []string, config string, fallback string, setRTC bool, gs t
 		Debug("No servers provided, falling back to %v", fallback)
 		servers = append(servers, fallback)
 	}
+	return servers
+}
 
+func setTime(servers []string, config string, fallback string, setRTC bool, gs timeGetterSetter) (string, float64, error) {
+	servers = Servers(servers, config, fallback)
 	if len(servers) == 0 {
 		return "", 0, fmt.Errorf("no servers")
 	}
@@ -0,0 +1,344 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ntpdate
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// NTS, RFC 8915, authenticates NTP servers with keys agreed over TLS by
+// NTS Key Establishment (NTS-KE) and cookies the server keeps no state for.
+const (
+	ntsKEPort = "4460"
+	ntsALPN   = "ntske/1"
+	ntsLabel  = "EXPORTER-network-time-security"
+
+	ntpPort = "123"
+)
+
+// NTS-KE record types.
+const (
+	recEnd         = 0
+	recNextProto   = 1
+	recError       = 2
+	recWarning     = 3
+	recAEAD        = 4
+	recCookie      = 5
+	recServer      = 6
+	recPort        = 7
+	recordCritical = 0x8000
+)
+
+const (
+	protoNTPv4    = 0
+	aeadSIVCMAC   = 15
+	ntsCookieWant = 8
+)
+
+// NTP extension field types of NTS.
+const (
+	efUniqueID      = 0x0104
+	efCookie        = 0x0204
+	efPlaceholder   = 0x0304
+	efAuthenticator = 0x0404
+)
+
+const (
+	ntpHeaderSize = 48
+	// ntpEpoch is the NTP era 0 epoch, 1900, in Unix time.
+	ntpEpoch = 2208988800
+)
+
+var (
+	errNTSKE    = errors.New("NTS key establishment failed")
+	errNTSNak   = errors.New("NTS cookie refused by server")
+	errResponse = errors.New("invalid NTP response")
+)
+
+// ntsSession are the keys and cookies agreed with an NTS-KE server.
+type ntsSession struct {
+	c2s, s2c *aesSIV
+	cookies  [][]byte
+	// server is the NTP server to use the cookies with.
+	server string
+}
+
+// record appends an NTS-KE record to b.
+func record(b []byte, typ uint16, body []byte) []byte {
+	b = binary.BigEndian.AppendUint16(b, typ)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(body)))
+	return append(b, body...)
+}
+
+// ntsKE establishes an NTS session with the NTS-KE server host, a host
+// name or a host:port.
+func ntsKE(host string, conf *tls.Config, timeout time.Duration) (*ntsSession, error) {
+	addr := host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		addr = net.JoinHostPort(host, ntsKEPort)
+	}
+	hostname, _, _ := net.SplitHostPort(addr)
+
+	c := &tls.Config{}
+	if conf != nil {
+		c = conf.Clone()
+	}
+	c.NextProtos = []string{ntsALPN}
+	c.MinVersion = tls.VersionTLS13
+	if c.ServerName == "" {
+		c.ServerName = hostname
+	}
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", addr, c)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errNTSKE, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	var req []byte
+	req = record(req, recordCritical|recNextProto, []byte{0, protoNTPv4})
+	req = record(req, recAEAD, []byte{0, aeadSIVCMAC})
+	req = record(req, recordCritical|recEnd, nil)
+	if _, err := conn.Write(req); err != nil {
+		return nil, fmt.Errorf("%w: %v", errNTSKE, err)
+	}
+
+	s := &ntsSession{}
+	server, port := hostname, ntpPort
+	for end := false; !end; {
+		var h [4]byte
+		if _, err := io.ReadFull(conn, h[:]); err != nil {
+			return nil, fmt.Errorf("%w: %v", errNTSKE, err)
+		}
+		typ := binary.BigEndian.Uint16(h[:]) &^ recordCritical
+		body := make([]byte, binary.BigEndian.Uint16(h[2:]))
+		if _, err := io.ReadFull(conn, body); err != nil {
+			return nil, fmt.Errorf("%w: %v", errNTSKE, err)
+		}
+		switch typ {
+		case recEnd:
+			end = true
+		case recNextProto:
+			if !bytes.Equal(body, []byte{0, protoNTPv4}) {
+				return nil, fmt.Errorf("%w: NTPv4 not offered", errNTSKE)
+			}
+		case recAEAD:
+			if !bytes.Equal(body, []byte{0, aeadSIVCMAC}) {
+				return nil, fmt.Errorf("%w: AEAD_AES_SIV_CMAC_256 not offered", errNTSKE)
+			}
+		case recError:
+			return nil, fmt.Errorf("%w: error record %x", errNTSKE, body)
+		case recWarning:
+			Debug("NTS-KE warning %x from %s", body, host)
+		case recCookie:
+			s.cookies = append(s.cookies, body)
+		case recServer:
+			server = string(body)
+		case recPort:
+			if len(body) != 2 {
+				return nil, fmt.Errorf("%w: port record of %d bytes", errNTSKE, len(body))
+			}
+			port = strconv.Itoa(int(binary.BigEndian.Uint16(body)))
+		default:
+			if binary.BigEndian.Uint16(h[:])&recordCritical != 0 {
+				return nil, fmt.Errorf("%w: unknown critical record %d", errNTSKE, typ)
+			}
+		}
+	}
+	s.server = net.JoinHostPort(server, port)
+	if len(s.cookies) == 0 {
+		return nil, fmt.Errorf("%w: no cookies", errNTSKE)
+	}
+
+	state := conn.ConnectionState()
+	if state.NegotiatedProtocol != ntsALPN {
+		return nil, fmt.Errorf("%w: server did not negotiate %s", errNTSKE, ntsALPN)
+	}
+	for i, aead := range []**aesSIV{&s.c2s, &s.s2c} {
+		ctx := []byte{0, protoNTPv4, 0, aeadSIVCMAC, byte(i)}
+		key, err := state.ExportKeyingMaterial(ntsLabel, ctx, sivKeySize)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errNTSKE, err)
+		}
+		if *aead, err = newAESSIV(key); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// extension appends an NTP extension field to b, padding its body.
+func extension(b []byte, typ uint16, body []byte) []byte {
+	n := (len(body) + 3) &^ 3
+	b = binary.BigEndian.AppendUint16(b, typ)
+	b = binary.BigEndian.AppendUint16(b, uint16(4+n))
+	b = append(b, body...)
+	return append(b, make([]byte, n-len(body))...)
+}
+
+// extensions calls f with the type, body and start offset of each of the
+// extension fields in b.
+func extensions(b []byte, f func(typ uint16, body []byte, start int) error) error {
+	for off := 0; off < len(b); {
+		if len(b)-off < 4 {
+			return fmt.Errorf("%w: truncated extension field", errResponse)
+		}
+		typ := binary.BigEndian.Uint16(b[off:])
+		n := int(binary.BigEndian.Uint16(b[off+2:]))
+		if n < 4 || n%4 != 0 || off+n > len(b) {
+			return fmt.Errorf("%w: extension field length %d", errResponse, n)
+		}
+		if err := f(typ, b[off+4:off+n], off); err != nil {
+			return err
+		}
+		off += n
+	}
+	return nil
+}
+
+func toNTPTime(t time.Time) uint64 {
+	sec := uint64(t.Unix() + ntpEpoch)
+	frac := uint64(t.Nanosecond()) << 32 / 1e9
+	return sec<<32 | frac
+}
+
+func fromNTPTime(v uint64) time.Time {
+	nsec := (v & 0xffffffff) * 1e9 >> 32
+	return time.Unix(int64(v>>32)-ntpEpoch, int64(nsec))
+}
+
+// request returns an NTS protected client request, sending the first
+// cookie and asking for as many as it takes to have ntsCookieWant again.
+func (s *ntsSession) request(uid []byte, xmt time.Time) []byte {
+	b := make([]byte, ntpHeaderSize)
+	// Leap not in sync, version 4, client mode.
+	b[0] = 3<<6 | 4<<3 | 3
+	binary.BigEndian.PutUint64(b[40:], toNTPTime(xmt))
+
+	cookie := s.cookies[0]
+	s.cookies = s.cookies[1:]
+	b = extension(b, efUniqueID, uid)
+	b = extension(b, efCookie, cookie)
+	for i := len(s.cookies) + 1; i < ntsCookieWant; i++ {
+		b = extension(b, efPlaceholder, make([]byte, len(cookie)))
+	}
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	c := s.c2s.seal(nil, b, nonce)
+	var auth []byte
+	auth = binary.BigEndian.AppendUint16(auth, uint16(len(nonce)))
+	auth = binary.BigEndian.AppendUint16(auth, uint16(len(c)))
+	auth = append(auth, nonce...)
+	auth = append(auth, c...)
+	return extension(b, efAuthenticator, auth)
+}
+
+// response authenticates the response b to the request with uid and
+// transmit time xmt, keeping the cookies it carries.
+func (s *ntsSession) response(b, uid []byte, xmt uint64) error {
+	if len(b) < ntpHeaderSize {
+		return fmt.Errorf("%w: %d bytes", errResponse, len(b))
+	}
+	if mode := b[0] & 7; mode != 4 {
+		return fmt.Errorf("%w: mode %d", errResponse, mode)
+	}
+	if binary.BigEndian.Uint64(b[24:]) != xmt {
+		return fmt.Errorf("%w: origin timestamp does not match", errResponse)
+	}
+	// An unauthenticated kiss, which may only make us renegotiate.
+	if b[1] == 0 && string(b[12:16]) == "NTSN" {
+		return errNTSNak
+	}
+
+	var gotUID, authenticated bool
+	err := extensions(b[ntpHeaderSize:], func(typ uint16, body []byte, start int) error {
+		switch typ {
+		case efUniqueID:
+			gotUID = bytes.Equal(body, uid)
+		case efAuthenticator:
+			if len(body) < 4 {
+				return errAuth
+			}
+			nl := int(binary.BigEndian.Uint16(body))
+			cl := int(binary.BigEndian.Uint16(body[2:]))
+			np := (nl + 3) &^ 3
+			if 4+np+cl > len(body) {
+				return errAuth
+			}
+			nonce := body[4 : 4+nl]
+			plain, err := s.s2c.open(body[4+np:4+np+cl], b[:ntpHeaderSize+start], nonce)
+			if err != nil {
+				return err
+			}
+			authenticated = true
+			return extensions(plain, func(typ uint16, body []byte, _ int) error {
+				if typ == efCookie {
+					s.cookies = append(s.cookies, append([]byte(nil), body...))
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !gotUID {
+		return fmt.Errorf("%w: unique identifier does not match", errResponse)
+	}
+	if !authenticated {
+		return fmt.Errorf("%w: not authenticated", errAuth)
+	}
+	return nil
+}
+
+// ntsQuery measures the system clock against the NTP server of s.
+func ntsQuery(s *ntsSession, timeout time.Duration) (Sample, error) {
+	conn, err := net.DialTimeout("udp", s.server, timeout)
+	if err != nil {
+		return Sample{}, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	uid := make([]byte, 32)
+	rand.Read(uid)
+	t1 := time.Now()
+	req := s.request(uid, t1)
+	if _, err := conn.Write(req); err != nil {
+		return Sample{}, err
+	}
+	b := make([]byte, 2048)
+	n, err := conn.Read(b)
+	if err != nil {
+		return Sample{}, err
+	}
+	t4 := time.Now()
+	b = b[:n]
+	if err := s.response(b, uid, binary.BigEndian.Uint64(req[40:])); err != nil {
+		return Sample{}, err
+	}
+	if b[1] == 0 || b[1] > 15 || b[0]>>6 == 3 {
+		return Sample{}, fmt.Errorf("%w: stratum %d, leap %d", errResponse, b[1], b[0]>>6)
+	}
+
+	t2 := fromNTPTime(binary.BigEndian.Uint64(b[32:]))
+	t3 := fromNTPTime(binary.BigEndian.Uint64(b[40:]))
+	return Sample{
+		Server: s.server,
+		Offset: (t2.Sub(t1) + t3.Sub(t4)) / 2,
+		RTT:    t4.Sub(t1) - t3.Sub(t2),
+	}, nil
+}
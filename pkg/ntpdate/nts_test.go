@@ -0,0 +1,275 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ntpdate
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// ntsServer is an NTS-KE and NTS NTP server whose clock is skew ahead.
+type ntsServer struct {
+	t      *testing.T
+	ke     net.Listener
+	ntp    net.PacketConn
+	roots  *x509.CertPool
+	skew   time.Duration
+	tamper atomic.Bool
+	nak    atomic.Bool
+
+	sessions atomic.Int32
+	mu       sync.Mutex
+	keys     map[string][2]*aesSIV
+}
+
+func newNTSServer(t *testing.T, skew time.Duration) *ntsServer {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		IsCA:         true,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &ntsServer{t: t, skew: skew, roots: x509.NewCertPool(), keys: map[string][2]*aesSIV{}}
+	s.roots.AddCert(cert)
+
+	s.ke, err = tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		NextProtos:   []string{ntsALPN},
+		MinVersion:   tls.VersionTLS13,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.ntp, err = net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		s.ke.Close()
+		s.ntp.Close()
+	})
+	go s.serveKE()
+	go s.serveNTP()
+	return s
+}
+
+func (s *ntsServer) cookie(keys [2]*aesSIV) []byte {
+	c := make([]byte, 40)
+	rand.Read(c)
+	s.mu.Lock()
+	s.keys[string(c)] = keys
+	s.mu.Unlock()
+	return c
+}
+
+func (s *ntsServer) serveKE() {
+	for {
+		conn, err := s.ke.Accept()
+		if err != nil {
+			return
+		}
+		s.handleKE(conn.(*tls.Conn))
+	}
+}
+
+func (s *ntsServer) handleKE(conn *tls.Conn) {
+	defer conn.Close()
+	for {
+		var h [4]byte
+		// Clients not trusting us hang up.
+		if _, err := io.ReadFull(conn, h[:]); err != nil {
+			return
+		}
+		if _, err := io.CopyN(io.Discard, conn, int64(binary.BigEndian.Uint16(h[2:]))); err != nil {
+			return
+		}
+		if binary.BigEndian.Uint16(h[:])&^recordCritical == recEnd {
+			break
+		}
+	}
+	var keys [2]*aesSIV
+	state := conn.ConnectionState()
+	for i := range keys {
+		k, err := state.ExportKeyingMaterial(ntsLabel, []byte{0, 0, 0, 15, byte(i)}, sivKeySize)
+		if err != nil {
+			s.t.Errorf("exporting keys: %v", err)
+			return
+		}
+		keys[i], _ = newAESSIV(k)
+	}
+	s.sessions.Add(1)
+
+	_, port, _ := net.SplitHostPort(s.ntp.LocalAddr().String())
+	p, _ := strconv.Atoi(port)
+	var b []byte
+	b = record(b, recordCritical|recNextProto, []byte{0, 0})
+	b = record(b, recAEAD, []byte{0, 15})
+	b = record(b, recServer, []byte("127.0.0.1"))
+	b = record(b, recPort, binary.BigEndian.AppendUint16(nil, uint16(p)))
+	for i := 0; i < ntsCookieWant; i++ {
+		b = record(b, recCookie, s.cookie(keys))
+	}
+	b = record(b, recordCritical|recEnd, nil)
+	conn.Write(b)
+}
+
+func (s *ntsServer) serveNTP() {
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := s.ntp.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if resp := s.handleNTP(buf[:n]); resp != nil {
+			s.ntp.WriteTo(resp, addr)
+		}
+	}
+}
+
+func (s *ntsServer) handleNTP(req []byte) []byte {
+	now := time.Now().Add(s.skew)
+	var uid []byte
+	var keys [2]*aesSIV
+	var cookies int
+	err := extensions(req[ntpHeaderSize:], func(typ uint16, body []byte, start int) error {
+		switch typ {
+		case efUniqueID:
+			uid = body
+		case efCookie, efPlaceholder:
+			cookies++
+			if typ == efCookie {
+				s.mu.Lock()
+				keys = s.keys[string(body)]
+				delete(s.keys, string(body))
+				s.mu.Unlock()
+			}
+		case efAuthenticator:
+			if keys[0] == nil {
+				return errNTSNak
+			}
+			nl := int(binary.BigEndian.Uint16(body))
+			cl := int(binary.BigEndian.Uint16(body[2:]))
+			_, err := keys[0].open(body[4+nl:4+nl+cl], req[:ntpHeaderSize+start], body[4:4+nl])
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		s.t.Errorf("NTS request: %v", err)
+		return nil
+	}
+
+	b := make([]byte, ntpHeaderSize)
+	b[0] = 4<<3 | 4
+	b[1] = 2
+	copy(b[24:32], req[40:48])
+	binary.BigEndian.PutUint64(b[16:], toNTPTime(now.Add(-time.Minute)))
+	binary.BigEndian.PutUint64(b[32:], toNTPTime(now))
+	binary.BigEndian.PutUint64(b[40:], toNTPTime(now))
+	if s.nak.Load() {
+		b[1] = 0
+		copy(b[12:16], "NTSN")
+		return extension(b, efUniqueID, uid)
+	}
+	b = extension(b, efUniqueID, uid)
+
+	var plain []byte
+	for i := 0; i < cookies; i++ {
+		plain = extension(plain, efCookie, s.cookie(keys))
+	}
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	c := keys[1].seal(plain, b, nonce)
+	auth := binary.BigEndian.AppendUint16(nil, uint16(len(nonce)))
+	auth = binary.BigEndian.AppendUint16(auth, uint16(len(c)))
+	auth = append(append(auth, nonce...), c...)
+	b = extension(b, efAuthenticator, auth)
+	if s.tamper.Load() {
+		b[40] ^= 1
+	}
+	return b
+}
+
+func TestNTS(t *testing.T) {
+	s := newNTSServer(t, time.Hour)
+	m := &mockClock{}
+	d := &Daemon{
+		Servers:   []string{s.ke.Addr().String()},
+		NTS:       true,
+		TLSConfig: &tls.Config{RootCAs: s.roots},
+		clock:     m,
+	}
+
+	// More polls than cookies from key establishment.
+	for i := 0; i < 2*ntsCookieWant; i++ {
+		sample, stepped, err := d.Poll()
+		if err != nil {
+			t.Fatalf("Poll() = %v", err)
+		}
+		if !stepped || (sample.Offset-time.Hour).Abs() > time.Second {
+			t.Fatalf("Poll() = %v, %t, want an hour offset stepped", sample, stepped)
+		}
+	}
+	if n := s.sessions.Load(); n != 1 {
+		t.Errorf("%d key establishments, want 1", n)
+	}
+	if got := len(d.sessions[d.Servers[0]].cookies); got != ntsCookieWant {
+		t.Errorf("%d cookies left, want %d", got, ntsCookieWant)
+	}
+
+	s.tamper.Store(true)
+	if _, _, err := d.Poll(); !errors.Is(err, errAuth) {
+		t.Errorf("Poll(tampered) = %v, want %v", err, errAuth)
+	}
+	s.tamper.Store(false)
+
+	// A refused cookie renegotiates keys.
+	s.nak.Store(true)
+	if _, _, err := d.Poll(); !errors.Is(err, errNTSNak) {
+		t.Errorf("Poll(NTSN) = %v, want %v", err, errNTSNak)
+	}
+	s.nak.Store(false)
+	if _, _, err := d.Poll(); err != nil {
+		t.Errorf("Poll() = %v", err)
+	}
+	if n := s.sessions.Load(); n != 2 {
+		t.Errorf("%d key establishments, want 2", n)
+	}
+
+	// Servers must be trusted.
+	d = &Daemon{Servers: d.Servers, NTS: true, TLSConfig: &tls.Config{RootCAs: x509.NewCertPool()}, clock: m}
+	if _, _, err := d.Poll(); !errors.Is(err, errNTSKE) {
+		t.Errorf("Poll(untrusted) = %v, want %v", err, errNTSKE)
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ntpdate
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"errors"
+)
+
+// sivKeySize is the key size of AEAD_AES_SIV_CMAC_256, the only algorithm
+// NTS requires.
+const sivKeySize = 32
+
+var errAuth = errors.New("message authentication failed")
+
+// aesSIV is AES-SIV, RFC 5297, which NTS authenticates packets with.
+type aesSIV struct {
+	mac cipher.Block
+	ctr cipher.Block
+}
+
+func newAESSIV(key []byte) (*aesSIV, error) {
+	if len(key) != sivKeySize {
+		return nil, aes.KeySizeError(len(key))
+	}
+	mac, err := aes.NewCipher(key[:sivKeySize/2])
+	if err != nil {
+		return nil, err
+	}
+	ctr, err := aes.NewCipher(key[sivKeySize/2:])
+	if err != nil {
+		return nil, err
+	}
+	return &aesSIV{mac: mac, ctr: ctr}, nil
+}
+
+// dbl doubles b in GF(2^128).
+func dbl(b []byte) {
+	carry := b[0] >> 7
+	for i := 0; i < len(b)-1; i++ {
+		b[i] = b[i]<<1 | b[i+1]>>7
+	}
+	b[len(b)-1] = b[len(b)-1]<<1 ^ carry*0x87
+}
+
+func xor(dst, b []byte) {
+	for i := range b {
+		dst[i] ^= b[i]
+	}
+}
+
+// cmac returns the CMAC of m, RFC 4493.
+func (s *aesSIV) cmac(m []byte) []byte {
+	k := make([]byte, aes.BlockSize)
+	s.mac.Encrypt(k, k)
+	dbl(k)
+
+	last := make([]byte, aes.BlockSize)
+	n := len(m)
+	if n > 0 && n%aes.BlockSize == 0 {
+		n -= aes.BlockSize
+		copy(last, m[n:])
+	} else {
+		n -= n % aes.BlockSize
+		last[copy(last, m[n:])] = 0x80
+		dbl(k)
+	}
+	xor(last, k)
+
+	x := make([]byte, aes.BlockSize)
+	for i := 0; i < n; i += aes.BlockSize {
+		xor(x, m[i:i+aes.BlockSize])
+		s.mac.Encrypt(x, x)
+	}
+	xor(x, last)
+	s.mac.Encrypt(x, x)
+	return x
+}
+
+// s2v returns the synthetic IV of the plaintext p and the associated data.
+func (s *aesSIV) s2v(p []byte, ad [][]byte) []byte {
+	d := s.cmac(make([]byte, aes.BlockSize))
+	for _, a := range ad {
+		dbl(d)
+		xor(d, s.cmac(a))
+	}
+	if len(p) >= aes.BlockSize {
+		t := append([]byte(nil), p...)
+		xor(t[len(t)-aes.BlockSize:], d)
+		return s.cmac(t)
+	}
+	dbl(d)
+	pad := make([]byte, aes.BlockSize)
+	pad[copy(pad, p)] = 0x80
+	xor(d, pad)
+	return s.cmac(d)
+}
+
+func (s *aesSIV) crypt(v, in []byte) []byte {
+	q := append([]byte(nil), v...)
+	q[8] &= 0x7f
+	q[12] &= 0x7f
+	out := make([]byte, len(in))
+	cipher.NewCTR(s.ctr, q).XORKeyStream(out, in)
+	return out
+}
+
+// seal returns the synthetic IV and the ciphertext of p, authenticating
+// the associated data too.
+func (s *aesSIV) seal(p []byte, ad ...[]byte) []byte {
+	v := s.s2v(p, ad)
+	return append(v, s.crypt(v, p)...)
+}
+
+// open returns the plaintext of c, sealed with the associated data ad.
+func (s *aesSIV) open(c []byte, ad ...[]byte) ([]byte, error) {
+	if len(c) < aes.BlockSize {
+		return nil, errAuth
+	}
+	v := c[:aes.BlockSize]
+	p := s.crypt(v, c[aes.BlockSize:])
+	if subtle.ConstantTimeCompare(v, s.s2v(p, ad)) != 1 {
+		return nil, errAuth
+	}
+	return p, nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ntpdate
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestCMAC(t *testing.T) {
+	// RFC 4493 section 4, the key doubled for the CTR half.
+	k := "2b7e1516 28aed2a6 abf71588 09cf4f3c"
+	s, err := newAESSIV(unhex(t, k+k))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		m, mac string
+	}{
+		{m: "", mac: "bb1d6929 e9593728 7fa37d12 9b756746"},
+		{m: "6bc1bee2 2e409f96 e93d7e11 7393172a", mac: "070a16b4 6b4d4144 f79bdd9d d04a287c"},
+		{
+			m: "6bc1bee2 2e409f96 e93d7e11 7393172a ae2d8a57 1e03ac9c 9eb76fac 45af8e51 " +
+				"30c81c46 a35ce411",
+			mac: "dfa66747 de9ae630 30ca3261 1497c827",
+		},
+	} {
+		if got := s.cmac(unhex(t, tt.m)); !bytes.Equal(got, unhex(t, tt.mac)) {
+			t.Errorf("cmac(%s) = %x, want %s", tt.m, got, tt.mac)
+		}
+	}
+}
+
+func TestAESSIV(t *testing.T) {
+	// RFC 5297 appendix A.1.
+	s, err := newAESSIV(unhex(t, "fffefdfc fbfaf9f8 f7f6f5f4 f3f2f1f0 f0f1f2f3 f4f5f6f7 f8f9fafb fcfdfeff"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ad := unhex(t, "10111213 14151617 18191a1b 1c1d1e1f 20212223 24252627")
+	p := unhex(t, "11223344 55667788 99aabbcc ddee")
+	want := unhex(t, "85632d07 c6e8f37f 950acd32 0a2ecc93 40c02b96 90c4dc04 daef7f6a fe5c")
+
+	c := s.seal(p, ad)
+	if !bytes.Equal(c, want) {
+		t.Errorf("seal() = %x, want %x", c, want)
+	}
+	got, err := s.open(c, ad)
+	if err != nil || !bytes.Equal(got, p) {
+		t.Errorf("open() = %x, %v, want %x", got, err, p)
+	}
+
+	c[len(c)-1] ^= 1
+	if _, err := s.open(c, ad); !errors.Is(err, errAuth) {
+		t.Errorf("open(tampered) = %v, want %v", err, errAuth)
+	}
+	if _, err := s.open(want, ad, nil); !errors.Is(err, errAuth) {
+		t.Errorf("open(other associated data) = %v, want %v", err, errAuth)
+	}
+	if _, err := newAESSIV(make([]byte, 16)); err == nil {
+		t.Error("newAESSIV(16 bytes) succeeded, want an error")
+	}
+}
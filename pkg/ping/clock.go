@@ -0,0 +1,102 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ping
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+var (
+	errClock        = errors.New("unknown clock")
+	errNoTimestamps = errors.New("kernel timestamps are not supported")
+)
+
+// Clock is what takes the send and receive times round trip times are
+// measured between.
+type Clock int
+
+const (
+	// ClockSoftware takes the times in ping itself, before sending a
+	// request and after reading its reply.
+	ClockSoftware Clock = iota
+	// ClockKernel takes them in the kernel, as the request is handed
+	// to the network interface and as the reply arrives from it.
+	ClockKernel
+	// ClockHardware takes them in the network interface itself. The
+	// interface must support hardware timestamps and have them enabled,
+	// e.g. with hwstamp_ctl.
+	ClockHardware
+)
+
+var clockNames = []string{
+	ClockSoftware: "software",
+	ClockKernel:   "kernel",
+	ClockHardware: "hardware",
+}
+
+func (c Clock) String() string {
+	if c < 0 || int(c) >= len(clockNames) {
+		return fmt.Sprintf("Clock(%d)", int(c))
+	}
+	return clockNames[c]
+}
+
+// ParseClock returns the clock called s, e.g. "kernel".
+func ParseClock(s string) (Clock, error) {
+	for c, name := range clockNames {
+		if strings.EqualFold(s, name) {
+			return Clock(c), nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %q", errClock, s)
+}
+
+// Timestamp holds the times the kernel and the network interface took
+// of a message, zero for those not taken. Hardware times are those of
+// the clock of the interface, not of the system.
+type Timestamp struct {
+	Kernel   time.Time
+	Hardware time.Time
+}
+
+// TimestampConn is a connection that tells the times the kernel and the
+// network interface received and sent messages at.
+type TimestampConn interface {
+	net.PacketConn
+	// ReadFromTimestamp is ReadFrom, returning the times the message
+	// was received at, too.
+	ReadFromTimestamp(b []byte) (int, net.Addr, Timestamp, error)
+	// SentTimestamp returns the times the latest message written was
+	// sent at, if known by now.
+	SentTimestamp() (Timestamp, bool)
+}
+
+// readReply reads a message from conn, together with the times it was
+// received at if conn tells them.
+func readReply(conn net.PacketConn, b []byte) (int, net.Addr, Timestamp, error) {
+	if tc, ok := conn.(TimestampConn); ok {
+		return tc.ReadFromTimestamp(b)
+	}
+	n, from, err := conn.ReadFrom(b)
+	return n, from, Timestamp{}, err
+}
+
+// roundTrip returns the round trip time between sent and received, on
+// the best clock both were taken with up to max. Without a timestamp of
+// the request, start is the time it was sent at and now that the reply
+// was read at.
+func roundTrip(start, now time.Time, sent Timestamp, haveSent bool, received Timestamp, max Clock) (time.Duration, Clock) {
+	switch {
+	case max >= ClockHardware && haveSent && !sent.Hardware.IsZero() && !received.Hardware.IsZero():
+		return received.Hardware.Sub(sent.Hardware), ClockHardware
+	case max >= ClockKernel && haveSent && !sent.Kernel.IsZero() && !received.Kernel.IsZero():
+		return received.Kernel.Sub(sent.Kernel), ClockKernel
+	}
+	return now.Sub(start), ClockSoftware
+}
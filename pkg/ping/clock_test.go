@@ -0,0 +1,58 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ping
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseClock(t *testing.T) {
+	for _, c := range []Clock{ClockSoftware, ClockKernel, ClockHardware} {
+		got, err := ParseClock(c.String())
+		if err != nil || got != c {
+			t.Errorf("ParseClock(%q) = %v, %v, want %v", c, got, err, c)
+		}
+	}
+	if got, err := ParseClock("Kernel"); err != nil || got != ClockKernel {
+		t.Errorf("ParseClock(Kernel) = %v, %v, want %v", got, err, ClockKernel)
+	}
+	if _, err := ParseClock("atomic"); !errors.Is(err, errClock) {
+		t.Errorf("ParseClock(atomic) = %v, want %v", err, errClock)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	start := time.Unix(1000, 0)
+	now := start.Add(time.Millisecond)
+	at := func(us int) time.Time { return start.Add(time.Duration(us) * time.Microsecond) }
+	kernel := func(us int) Timestamp { return Timestamp{Kernel: at(us)} }
+	both := func(us, hwus int) Timestamp {
+		return Timestamp{Kernel: at(us), Hardware: time.Unix(5, 0).Add(time.Duration(hwus) * time.Microsecond)}
+	}
+	for _, tt := range []struct {
+		name      string
+		sent      Timestamp
+		haveSent  bool
+		received  Timestamp
+		max       Clock
+		want      time.Duration
+		wantClock Clock
+	}{
+		{name: "Software", sent: kernel(10), haveSent: true, received: kernel(200), max: ClockSoftware, want: time.Millisecond, wantClock: ClockSoftware},
+		{name: "Kernel", sent: kernel(10), haveSent: true, received: kernel(200), max: ClockKernel, want: 190 * time.Microsecond, wantClock: ClockKernel},
+		{name: "NoSentTimestamp", received: kernel(200), max: ClockKernel, want: time.Millisecond, wantClock: ClockSoftware},
+		{name: "Hardware", sent: both(10, 20), haveSent: true, received: both(200, 150), max: ClockHardware, want: 130 * time.Microsecond, wantClock: ClockHardware},
+		{name: "HardwareFallback", sent: kernel(10), haveSent: true, received: both(200, 150), max: ClockHardware, want: 190 * time.Microsecond, wantClock: ClockKernel},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, clock := roundTrip(start, now, tt.sent, tt.haveSent, tt.received, tt.max)
+			if got != tt.want || clock != tt.wantClock {
+				t.Errorf("roundTrip() = %v, %v, want %v, %v", got, clock, tt.want, tt.wantClock)
+			}
+		})
+	}
+}
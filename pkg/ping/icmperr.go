@@ -0,0 +1,175 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ping
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// ICMPError is an ICMP error message a router or the destination sent
+// in answer to an echo request.
+type ICMPError struct {
+	Type icmp.Type
+	Code int
+	// From is the address of the router or host that sent the error.
+	From net.IP
+	// Gateway is the better first hop a Redirect names.
+	Gateway net.IP
+	// MTU is the MTU of the next hop an IPv6 Packet Too Big or an IPv4
+	// Fragmentation Needed message tells, 0 if it does not.
+	MTU int
+}
+
+// icmpErrorNames are the descriptions of the ICMP errors, as iputils
+// prints them, by type and code.
+var icmpErrorNames = map[icmp.Type][]string{
+	ipv4.ICMPTypeDestinationUnreachable: {
+		"Destination Net Unreachable",
+		"Destination Host Unreachable",
+		"Destination Protocol Unreachable",
+		"Destination Port Unreachable",
+		"Frag needed and DF set",
+		"Source Route Failed",
+		"Destination Net Unknown",
+		"Destination Host Unknown",
+		"Source Host Isolated",
+		"Destination Net Prohibited",
+		"Destination Host Prohibited",
+		"Destination Net Unreachable for Type of Service",
+		"Destination Host Unreachable for Type of Service",
+		"Packet filtered",
+		"Precedence Violation",
+		"Precedence Cutoff",
+	},
+	ipv4.ICMPTypeRedirect: {
+		"Redirect Network",
+		"Redirect Host",
+		"Redirect Type of Service and Network",
+		"Redirect Type of Service and Host",
+	},
+	ipv4.ICMPTypeTimeExceeded: {
+		"Time to live exceeded",
+		"Frag reassembly time exceeded",
+	},
+	ipv4.ICMPTypeParameterProblem: {
+		"Parameter problem",
+	},
+	ipv6.ICMPTypeDestinationUnreachable: {
+		"No route",
+		"Administratively prohibited",
+		"Beyond scope",
+		"Address unreachable",
+		"Port unreachable",
+		"Source address failed ingress/egress policy",
+		"Reject route to destination",
+	},
+	ipv6.ICMPTypePacketTooBig: {
+		"Packet too big",
+	},
+	ipv6.ICMPTypeTimeExceeded: {
+		"Time exceeded: Hop limit",
+		"Time exceeded: Defrag",
+	},
+	ipv6.ICMPTypeParameterProblem: {
+		"Parameter problem",
+	},
+}
+
+func (e *ICMPError) Error() string {
+	names := icmpErrorNames[e.Type]
+	s := fmt.Sprintf("%v, code %d", e.Type, e.Code)
+	if e.Code >= 0 && e.Code < len(names) {
+		s = names[e.Code]
+	}
+	switch {
+	case e.Gateway != nil:
+		s += fmt.Sprintf(" (New nexthop: %v)", e.Gateway)
+	case e.MTU != 0:
+		s += fmt.Sprintf(": mtu=%d", e.MTU)
+	}
+	return s
+}
+
+// Redirect reports whether e is a Redirect, which tells about a better
+// route rather than that the request was dropped.
+func (e *ICMPError) Redirect() bool {
+	return e.Type == ipv4.ICMPTypeRedirect
+}
+
+// icmpError returns the ICMP error in b and the sequence number of the
+// request of p it quotes, if it is one.
+func (p *pinger) icmpError(b []byte) (int, *ICMPError, bool) {
+	proto := ipv4.ICMPTypeEcho.Protocol()
+	if p.ipv6 {
+		proto = ipv6.ICMPTypeEchoRequest.Protocol()
+	}
+	m, err := icmp.ParseMessage(proto, b)
+	if err != nil {
+		return 0, nil, false
+	}
+	e := &ICMPError{Type: m.Type, Code: m.Code}
+	var quoted []byte
+	switch body := m.Body.(type) {
+	case *icmp.DstUnreach:
+		quoted = body.Data
+		if !p.ipv6 && m.Code == 4 && len(b) >= 8 {
+			e.MTU = int(binary.BigEndian.Uint16(b[6:8]))
+		}
+	case *icmp.TimeExceeded:
+		quoted = body.Data
+	case *icmp.ParamProb:
+		quoted = body.Data
+	case *icmp.PacketTooBig:
+		quoted = body.Data
+		e.MTU = body.MTU
+	case *icmp.RawBody:
+		// Package icmp does not know Redirects, whose body starts
+		// with the address of the gateway.
+		if m.Type != ipv4.ICMPTypeRedirect || len(body.Data) < net.IPv4len {
+			return 0, nil, false
+		}
+		e.Gateway = net.IP(append([]byte{}, body.Data[:net.IPv4len]...))
+		quoted = body.Data[net.IPv4len:]
+	default:
+		return 0, nil, false
+	}
+	seq, ok := p.quotedSeq(quoted)
+	return seq, e, ok
+}
+
+// quotedSeq returns the sequence number of the request of p at the
+// start of the packet quoted by an ICMP error.
+func (p *pinger) quotedSeq(q []byte) (int, bool) {
+	var dst net.IP
+	echo := byte(ipv4.ICMPTypeEcho)
+	if p.ipv6 {
+		if len(q) < ipv6.HeaderLen || int(q[6]) != ipv6.ICMPTypeEchoRequest.Protocol() {
+			return 0, false
+		}
+		dst, q = net.IP(q[24:40]), q[ipv6.HeaderLen:]
+		echo = byte(ipv6.ICMPTypeEchoRequest)
+	} else {
+		if len(q) < ipv4.HeaderLen || int(q[9]) != ipv4.ICMPTypeEcho.Protocol() {
+			return 0, false
+		}
+		hl := int(q[0]&0x0f) * 4
+		if hl < ipv4.HeaderLen || len(q) < hl {
+			return 0, false
+		}
+		dst, q = net.IP(q[16:20]), q[hl:]
+	}
+	// The ICMP header of the request, holding its ID and sequence
+	// number, is all of it that routers must quote.
+	if len(q) < 8 || q[0] != echo || !dst.Equal(p.dest) || int(binary.BigEndian.Uint16(q[4:6])) != p.id {
+		return 0, false
+	}
+	return int(binary.BigEndian.Uint16(q[6:8])), true
+}
@@ -0,0 +1,488 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package ping sends ICMP and ICMPv6 echo requests and reports the
+// replies.
+package ping
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	DEFPACKETSIZE = 56
+	MINPACKETSIZE = 8
+	DEFINTERVAL   = time.Second
+	DEFTIMEOUT    = 100 * time.Millisecond
+)
+
+var (
+	errPacketSize = errors.New("packet size too small")
+	errNoAddr     = errors.New("no address of the requested family")
+	errMode       = errors.New("flood and adaptive mode exclude each other")
+	errBroadcast  = errors.New("socket does not support broadcasts")
+)
+
+// Options describes the echo requests to send.
+type Options struct {
+	Host string
+	// Network is "ip4" or "ip6" to force the IP version, "" to use that
+	// of the address Host resolves to, preferring IPv4.
+	Network string
+	// PacketSize is the number of data bytes following the ICMP header.
+	PacketSize int
+	// Count is the number of requests to send, 0 to send them until the
+	// context is done.
+	Count uint64
+	// Interval is the pause between two requests, Timeout the time to
+	// wait for the reply to each.
+	Interval time.Duration
+	Timeout  time.Duration
+	// Deadline, unless 0, ends the ping that long after it started,
+	// however many requests were sent.
+	Deadline time.Duration
+	// UntilReply ends the ping at the first reply.
+	UntilReply bool
+	// Flood sends the next request as soon as the previous one has been
+	// answered or has timed out, ignoring Interval.
+	Flood bool
+	// Adaptive paces the requests by the smoothed round trip time, at
+	// most Interval apart.
+	Adaptive bool
+	// Broadcast allows pinging broadcast addresses. Like multicast
+	// addresses, they wait Timeout for the replies of all hosts.
+	Broadcast bool
+	// TTL is the TTL or hop limit of the requests, of unicast and
+	// multicast ones alike, 0 for the default of the system.
+	TTL int
+	// Clock takes the times round trip times are measured between.
+	// Clocks the path to the destination does not support fall back to
+	// the next best one, see Reply.Clock.
+	Clock Clock
+	// OnSend is called with the sequence number of every request right
+	// after it was sent, unless nil.
+	OnSend func(seq int)
+	// OnError is called with the ICMP errors answering the requests,
+	// unless nil. Those other than Redirects answer the request in place
+	// of a reply, the request counts as lost.
+	OnError func(seq int, err *ICMPError)
+	// Conn sends the requests and reads the replies instead of a raw
+	// socket of the IP version of the destination.
+	Conn net.PacketConn
+}
+
+func (o Options) withDefaults() Options {
+	if o.Interval == 0 {
+		o.Interval = DEFINTERVAL
+	}
+	if o.Timeout == 0 {
+		o.Timeout = DEFTIMEOUT
+	}
+	return o
+}
+
+// Reply is the answer to an echo request.
+type Reply struct {
+	Seq int
+	// From is the address the reply came from, nil if the connection
+	// did not tell.
+	From net.IP
+	// Size is the length of the ICMP message received.
+	Size int
+	RTT  time.Duration
+	// Clock is the clock that measured RTT.
+	Clock Clock
+	// Dup is set if From answered the request before.
+	Dup bool
+	// TTL is the TTL, or hop limit, the reply arrived with, 0 if the
+	// connection did not tell.
+	TTL int
+}
+
+// ReplyFunc is called with every reply as soon as it arrives.
+type ReplyFunc func(Reply)
+
+// TTLConn is a connection that tells the TTL of the messages it reads.
+type TTLConn interface {
+	net.PacketConn
+	// ReceivedTTL returns the TTL, or hop limit, of the latest message
+	// read, if known.
+	ReceivedTTL() (int, bool)
+}
+
+// Stats summarizes the replies to the requests sent.
+type Stats struct {
+	Dest     net.IP
+	Sent     int
+	Received int
+	// Duplicates counts the replies to requests answered before, by
+	// the same or, for broadcast and multicast addresses, other hosts.
+	Duplicates int
+	// Errors counts the requests answered by an ICMP error.
+	Errors int
+	// Min, Avg, Max and StdDev are the statistics of the round trip
+	// times, zero without any reply.
+	Min    time.Duration
+	Avg    time.Duration
+	Max    time.Duration
+	StdDev time.Duration
+	// Elapsed is the time from sending the first request to the end of
+	// the wait for the reply to the last one.
+	Elapsed time.Duration
+	// sum and sumSq accumulate the round trip times in nanoseconds.
+	sum   float64
+	sumSq float64
+}
+
+// Loss returns the percentage of requests that were not answered.
+func (s *Stats) Loss() float64 {
+	if s.Sent == 0 {
+		return 0
+	}
+	return 100 * float64(s.Sent-s.Received) / float64(s.Sent)
+}
+
+// Rate returns the number of requests sent per second.
+func (s *Stats) Rate() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Sent) / s.Elapsed.Seconds()
+}
+
+// add accounts for a reply after rtt.
+func (s *Stats) add(rtt time.Duration) {
+	s.Received++
+	if s.Received == 1 || rtt < s.Min {
+		s.Min = rtt
+	}
+	if rtt > s.Max {
+		s.Max = rtt
+	}
+	s.sum += float64(rtt)
+	s.sumSq += float64(rtt) * float64(rtt)
+	n := float64(s.Received)
+	mean := s.sum / n
+	s.Avg = time.Duration(mean)
+	s.StdDev = time.Duration(math.Sqrt(max(s.sumSq/n-mean*mean, 0)))
+}
+
+// ResolveDest returns the address of host to ping. Unless network
+// forces an IP version, IPv4 addresses are preferred over IPv6 ones.
+func ResolveDest(ctx context.Context, host, network string) (net.IP, error) {
+	if network == "" {
+		network = "ip"
+	}
+	addrs, err := net.DefaultResolver.LookupIP(ctx, network, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		if a.To4() != nil {
+			return a, nil
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("%w: %s", errNoAddr, host)
+	}
+	return addrs[0], nil
+}
+
+// Ping sends echo requests to opts.Host and calls fn, unless nil, with
+// every reply. Requests not answered within opts.Timeout count as lost.
+// Requests to broadcast and multicast addresses wait the whole timeout
+// and report the replies of every host answering them. The ping ends
+// after opts.Count requests, at opts.Deadline or, with opts.UntilReply,
+// at the first reply, whichever comes first.
+// Cancelling ctx stops sending and returns the statistics so far
+// together with the error of ctx.
+func Ping(ctx context.Context, opts *Options, fn ReplyFunc) (*Stats, error) {
+	o := opts.withDefaults()
+	if o.PacketSize < MINPACKETSIZE {
+		return nil, fmt.Errorf("%w: %d, must be >= %d", errPacketSize, o.PacketSize, MINPACKETSIZE)
+	}
+	if o.Flood && o.Adaptive {
+		return nil, errMode
+	}
+	dest, err := ResolveDest(ctx, o.Host, o.Network)
+	if err != nil {
+		return nil, err
+	}
+	p := newPinger(dest, o.PacketSize)
+	p.clock = o.Clock
+	p.group = o.Broadcast || dest.IsMulticast()
+	conn := o.Conn
+	if conn == nil {
+		if conn, err = listen(p.ipv6, &o); err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+	}
+	// Cancelling ctx cuts the wait for a reply short.
+	stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	s := &Stats{Dest: dest}
+	var start, deadline time.Time
+	if o.Deadline > 0 {
+		deadline = time.Now().Add(o.Deadline)
+	}
+	// srtt is the round trip time smoothed like TCP does, which paces
+	// adaptive mode.
+	var srtt time.Duration
+	for seq := 1; o.Count == 0 || uint64(seq) <= o.Count; seq++ {
+		if seq > 1 {
+			pause := o.pause(srtt)
+			if !deadline.IsZero() {
+				pause = min(pause, time.Until(deadline))
+			}
+			if pause > 0 {
+				timer := time.NewTimer(pause)
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					timer.Stop()
+				}
+			}
+		}
+		if ctx.Err() != nil {
+			return s, ctx.Err()
+		}
+		timeout := o.Timeout
+		if !deadline.IsZero() {
+			if timeout = min(timeout, time.Until(deadline)); timeout <= 0 {
+				return s, nil
+			}
+		}
+		if seq == 1 {
+			start = time.Now()
+		}
+		s.Sent++
+		replies, err := p.ping(conn, seq, timeout, o.OnSend, o.OnError)
+		s.Elapsed = time.Since(start)
+		var ie *ICMPError
+		if errors.As(err, &ie) {
+			s.Errors++
+			continue
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			if ctx.Err() != nil {
+				// The wait was cut short, so the request does not
+				// count as lost.
+				s.Sent--
+				return s, ctx.Err()
+			}
+			continue
+		}
+		if err != nil {
+			return s, err
+		}
+		// The first reply answers the request, the others are those of
+		// further hosts of a group or duplicates.
+		rtt := replies[0].RTT
+		s.add(rtt)
+		s.Duplicates += len(replies) - 1
+		if srtt == 0 {
+			srtt = rtt
+		} else {
+			srtt += (rtt - srtt) / 8
+		}
+		if fn != nil {
+			for _, r := range replies {
+				fn(r)
+			}
+		}
+		if o.UntilReply {
+			return s, nil
+		}
+	}
+	return s, nil
+}
+
+// pause returns the time to wait before sending the next request, given
+// the smoothed round trip time so far.
+func (o *Options) pause(srtt time.Duration) time.Duration {
+	switch {
+	case o.Flood:
+		return 0
+	case o.Adaptive && srtt > 0:
+		return min(srtt, o.Interval)
+	}
+	return o.Interval
+}
+
+// listen opens the raw socket to send echo requests and read the replies
+// on, with the options of opts set.
+func listen(ipv6 bool, opts *Options) (net.PacketConn, error) {
+	network, address := "ip4:icmp", "0.0.0.0"
+	if ipv6 {
+		network, address = "ip6:ipv6-icmp", "::"
+	}
+	c, err := net.ListenPacket(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("can't setup %s socket on %s: %w", network, address, err)
+	}
+	if err := setSocketOptions(c, ipv6, opts); err != nil {
+		c.Close()
+		return nil, err
+	}
+	conn, err := wrapConn(c.(*net.IPConn), ipv6, opts.Clock)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// setSocketOptions sets the options of opts on conn.
+func setSocketOptions(conn net.PacketConn, v6 bool, opts *Options) error {
+	if opts.Broadcast {
+		if err := setBroadcast(conn); err != nil {
+			return fmt.Errorf("can't allow broadcasts: %w", err)
+		}
+	}
+	if opts.TTL == 0 {
+		return nil
+	}
+	if v6 {
+		pc := ipv6.NewPacketConn(conn)
+		return errors.Join(pc.SetHopLimit(opts.TTL), pc.SetMulticastHopLimit(opts.TTL))
+	}
+	pc := ipv4.NewPacketConn(conn)
+	return errors.Join(pc.SetTTL(opts.TTL), pc.SetMulticastTTL(opts.TTL))
+}
+
+// pinger sends the echo requests of one destination.
+type pinger struct {
+	dest net.IP
+	ipv6 bool
+	id   int
+	data []byte
+	// clock is the best clock to measure round trip times with.
+	clock Clock
+	// group is set if the destination is a broadcast or multicast
+	// address, which several hosts answer.
+	group bool
+}
+
+func newPinger(dest net.IP, size int) *pinger {
+	return &pinger{
+		dest: dest,
+		ipv6: dest.To4() == nil,
+		id:   os.Getpid() & 0xffff,
+		data: bytes.Repeat([]byte{1}, size),
+	}
+}
+
+// request returns the echo request with seq.
+func (p *pinger) request(seq int) ([]byte, error) {
+	var typ icmp.Type = ipv4.ICMPTypeEcho
+	if p.ipv6 {
+		typ = ipv6.ICMPTypeEchoRequest
+	}
+	m := icmp.Message{Type: typ, Body: &icmp.Echo{ID: p.id, Seq: seq, Data: p.data}}
+	return m.Marshal(nil)
+}
+
+// replySeq returns the sequence number of the message b if it is the
+// reply to a request of p.
+func (p *pinger) replySeq(b []byte) (int, bool) {
+	var typ icmp.Type = ipv4.ICMPTypeEchoReply
+	if p.ipv6 {
+		typ = ipv6.ICMPTypeEchoReply
+	}
+	m, err := icmp.ParseMessage(typ.Protocol(), b)
+	if err != nil || m.Type != typ {
+		return 0, false
+	}
+	echo, ok := m.Body.(*icmp.Echo)
+	if !ok || echo.ID != p.id {
+		return 0, false
+	}
+	return echo.Seq, true
+}
+
+// ping sends the request with seq and waits up to timeout for its reply,
+// or, if p.group is set, for the replies of all the hosts answering it.
+// onSend, unless nil, is called once the request is out, and onError,
+// unless nil, with the ICMP errors answering it. Unless p.group is set,
+// an error other than a Redirect ends the wait and is returned. Other
+// messages the socket receives, such as the replies to other ping
+// processes or, on loopback, the request itself, are skipped.
+func (p *pinger) ping(conn net.PacketConn, seq int, timeout time.Duration, onSend func(int), onError func(int, *ICMPError)) ([]Reply, error) {
+	wb, err := p.request(seq)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	if err := conn.SetReadDeadline(start.Add(timeout)); err != nil {
+		return nil, err
+	}
+	if _, err := conn.WriteTo(wb, &net.IPAddr{IP: p.dest}); err != nil {
+		return nil, err
+	}
+	if onSend != nil {
+		onSend(seq)
+	}
+
+	var replies []Reply
+	var sent Timestamp
+	var haveSent bool
+	rb := make([]byte, 1500)
+	for {
+		n, from, received, err := readReply(conn, rb)
+		if errors.Is(err, os.ErrDeadlineExceeded) && len(replies) > 0 {
+			return replies, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		var fromIP net.IP
+		if a, ok := from.(*net.IPAddr); ok {
+			fromIP = a.IP
+		}
+		if s, e, ok := p.icmpError(rb[:n]); ok && s == seq {
+			e.From = fromIP
+			if onError != nil {
+				onError(seq, e)
+			}
+			if !p.group && !e.Redirect() {
+				return nil, e
+			}
+			continue
+		}
+		if s, ok := p.replySeq(rb[:n]); !ok || s != seq {
+			continue
+		}
+		r := Reply{Seq: seq, From: fromIP, Size: n}
+		if tc, ok := conn.(TTLConn); ok {
+			r.TTL, _ = tc.ReceivedTTL()
+		}
+		if tc, ok := conn.(TimestampConn); ok && p.clock != ClockSoftware && !haveSent {
+			sent, haveSent = tc.SentTimestamp()
+		}
+		r.RTT, r.Clock = roundTrip(start, now, sent, haveSent, received, p.clock)
+		for _, prev := range replies {
+			if prev.From.Equal(r.From) {
+				r.Dup = true
+			}
+		}
+		replies = append(replies, r)
+		if !p.group {
+			return replies, nil
+		}
+	}
+}
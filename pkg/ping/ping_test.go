@@ -0,0 +1,432 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// fakeConn answers the echo requests written to it like a host on
+// loopback does, which also hands the requests themselves back.
+type fakeConn struct {
+	ipv6 bool
+	// drop are the sequence numbers of the requests not answered.
+	drop map[int]bool
+	// foreign is set to answer every request with a reply to another
+	// ping process first.
+	foreign bool
+	// alive are the only addresses answering, if set.
+	alive map[string]bool
+	// unreach are the sequence numbers of the requests a router answers
+	// with a Destination Unreachable, redirect those it redirects.
+	unreach  map[int]bool
+	redirect map[int]bool
+	// responders answer every request in place of its destination, like
+	// the hosts of a broadcast or multicast group do. The first one
+	// answers twice.
+	responders []net.IP
+	// mu guards the messages to read and the deadline, which cancelling
+	// a ping and the sender of a sweep touch concurrently.
+	mu       sync.Mutex
+	queue    []fakeMessage
+	deadline time.Time
+}
+
+type fakeMessage struct {
+	b    []byte
+	from net.Addr
+}
+
+func (c *fakeConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	proto, reply := ipv4.ICMPTypeEcho.Protocol(), icmp.Type(ipv4.ICMPTypeEchoReply)
+	if c.ipv6 {
+		proto, reply = ipv6.ICMPTypeEchoRequest.Protocol(), ipv6.ICMPTypeEchoReply
+	}
+	m, err := icmp.ParseMessage(proto, b)
+	if err != nil {
+		return 0, err
+	}
+	echo := m.Body.(*icmp.Echo)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.queue = append(c.queue, fakeMessage{append([]byte{}, b...), addr})
+	if c.foreign {
+		fb, _ := (&icmp.Message{Type: reply, Body: &icmp.Echo{ID: echo.ID + 1, Seq: echo.Seq}}).Marshal(nil)
+		c.queue = append(c.queue, fakeMessage{fb, addr})
+	}
+	if c.unreach[echo.Seq] || c.redirect[echo.Seq] {
+		c.queue = append(c.queue, c.icmpError(b, addr.(*net.IPAddr).IP, c.redirect[echo.Seq]))
+	}
+	if c.drop[echo.Seq] || c.unreach[echo.Seq] || c.alive != nil && !c.alive[addr.(*net.IPAddr).IP.String()] {
+		return len(b), nil
+	}
+	rb, _ := (&icmp.Message{Type: reply, Body: echo}).Marshal(nil)
+	if c.responders == nil {
+		c.queue = append(c.queue, fakeMessage{rb, addr})
+	}
+	for i, ip := range c.responders {
+		c.queue = append(c.queue, fakeMessage{rb, &net.IPAddr{IP: ip}})
+		if i == 0 {
+			c.queue = append(c.queue, fakeMessage{rb, &net.IPAddr{IP: ip}})
+		}
+	}
+	return len(b), nil
+}
+
+// fakeRouter is the router sending ICMP errors.
+var fakeRouter = net.ParseIP("192.0.2.254")
+
+// icmpError returns the Destination Unreachable or, for IPv4, Redirect
+// quoting request b to dst.
+func (c *fakeConn) icmpError(b []byte, dst net.IP, redirect bool) fakeMessage {
+	var m icmp.Message
+	if c.ipv6 {
+		h := make([]byte, ipv6.HeaderLen)
+		h[0], h[6] = 6<<4, byte(ipv6.ICMPTypeEchoRequest.Protocol())
+		copy(h[24:], dst.To16())
+		m = icmp.Message{Type: ipv6.ICMPTypeDestinationUnreachable, Code: 3, Body: &icmp.DstUnreach{Data: append(h, b...)}}
+	} else {
+		h := make([]byte, ipv4.HeaderLen)
+		h[0], h[9] = 4<<4|ipv4.HeaderLen/4, byte(ipv4.ICMPTypeEcho.Protocol())
+		copy(h[16:], dst.To4())
+		m = icmp.Message{Type: ipv4.ICMPTypeDestinationUnreachable, Code: 1, Body: &icmp.DstUnreach{Data: append(h, b...)}}
+		if redirect {
+			m = icmp.Message{Type: ipv4.ICMPTypeRedirect, Code: 1, Body: &icmp.RawBody{Data: append(net.ParseIP("192.0.2.253").To4(), append(h, b...)...)}}
+		}
+	}
+	mb, _ := m.Marshal(nil)
+	return fakeMessage{mb, &net.IPAddr{IP: fakeRouter}}
+}
+
+func (c *fakeConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.queue) == 0 || !c.deadline.IsZero() && !time.Now().Before(c.deadline) {
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+	m := c.queue[0]
+	c.queue = c.queue[1:]
+	return copy(b, m.b), m.from, nil
+}
+
+func (c *fakeConn) Close() error                       { return nil }
+func (c *fakeConn) LocalAddr() net.Addr                { return nil }
+func (c *fakeConn) SetDeadline(t time.Time) error      { return c.SetReadDeadline(t) }
+func (c *fakeConn) SetWriteDeadline(t time.Time) error { return nil }
+
+func (c *fakeConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	return nil
+}
+
+func TestPing(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		host     string
+		conn     *fakeConn
+		received []int
+	}{
+		{
+			name:     "IPv4",
+			host:     "192.0.2.1",
+			conn:     &fakeConn{},
+			received: []int{1, 2, 3},
+		},
+		{
+			name:     "IPv6",
+			host:     "2001:db8::1",
+			conn:     &fakeConn{ipv6: true},
+			received: []int{1, 2, 3},
+		},
+		{
+			name:     "Loss",
+			host:     "192.0.2.1",
+			conn:     &fakeConn{drop: map[int]bool{2: true}},
+			received: []int{1, 3},
+		},
+		{
+			name:     "OtherProcess",
+			host:     "2001:db8::1",
+			conn:     &fakeConn{ipv6: true, foreign: true},
+			received: []int{1, 2, 3},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var seqs []int
+			s, err := Ping(context.Background(), &Options{
+				Host:       tt.host,
+				PacketSize: DEFPACKETSIZE,
+				Count:      3,
+				Interval:   time.Millisecond,
+				Conn:       tt.conn,
+			}, func(r Reply) {
+				if r.Size != DEFPACKETSIZE+8 || !r.From.Equal(net.ParseIP(tt.host)) {
+					t.Errorf("reply = %+v, want %d bytes from %s", r, DEFPACKETSIZE+8, tt.host)
+				}
+				seqs = append(seqs, r.Seq)
+			})
+			if err != nil {
+				t.Fatalf("Ping() = %v", err)
+			}
+			if len(seqs) != len(tt.received) {
+				t.Fatalf("replies to %v, want %v", seqs, tt.received)
+			}
+			for i := range seqs {
+				if seqs[i] != tt.received[i] {
+					t.Fatalf("replies to %v, want %v", seqs, tt.received)
+				}
+			}
+			if s.Sent != 3 || s.Received != len(tt.received) || s.Min > s.Avg || s.Avg > s.Max {
+				t.Errorf("stats = %+v, want %d of 3 received", s, len(tt.received))
+			}
+		})
+	}
+}
+
+func TestPingCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s, err := Ping(ctx, &Options{
+		Host:       "192.0.2.1",
+		PacketSize: DEFPACKETSIZE,
+		Interval:   time.Millisecond,
+		Conn:       &fakeConn{},
+	}, func(r Reply) {
+		if r.Seq == 2 {
+			cancel()
+		}
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Ping() = %v, want %v", err, context.Canceled)
+	}
+	if s == nil || s.Sent != 2 || s.Received != 2 {
+		t.Errorf("stats = %+v, want 2 of 2 received", s)
+	}
+}
+
+func TestPingPacketSize(t *testing.T) {
+	if _, err := Ping(context.Background(), &Options{Host: "192.0.2.1", PacketSize: 4}, nil); !errors.Is(err, errPacketSize) {
+		t.Errorf("Ping() = %v, want %v", err, errPacketSize)
+	}
+}
+
+func TestResolveDest(t *testing.T) {
+	for _, tt := range []struct {
+		host    string
+		network string
+		want    string
+	}{
+		{host: "192.0.2.1", want: "192.0.2.1"},
+		{host: "2001:db8::1", want: "2001:db8::1"},
+		{host: "2001:db8::1", network: "ip6", want: "2001:db8::1"},
+	} {
+		ip, err := ResolveDest(context.Background(), tt.host, tt.network)
+		if err != nil || !ip.Equal(net.ParseIP(tt.want)) {
+			t.Errorf("ResolveDest(%s, %q) = %v, %v, want %s", tt.host, tt.network, ip, err, tt.want)
+		}
+	}
+}
+
+func TestPause(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		opts Options
+		srtt time.Duration
+		want time.Duration
+	}{
+		{name: "Interval", opts: Options{Interval: time.Second}, srtt: time.Millisecond, want: time.Second},
+		{name: "Flood", opts: Options{Interval: time.Second, Flood: true}, srtt: time.Millisecond, want: 0},
+		{name: "Adaptive", opts: Options{Interval: time.Second, Adaptive: true}, srtt: time.Millisecond, want: time.Millisecond},
+		{name: "AdaptiveSlow", opts: Options{Interval: time.Second, Adaptive: true}, srtt: 2 * time.Second, want: time.Second},
+		{name: "AdaptiveNoRTT", opts: Options{Interval: time.Second, Adaptive: true}, want: time.Second},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.opts.pause(tt.srtt); got != tt.want {
+				t.Errorf("pause(%v) = %v, want %v", tt.srtt, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPingFlood(t *testing.T) {
+	var sent int
+	s, err := Ping(context.Background(), &Options{
+		Host:       "192.0.2.1",
+		PacketSize: DEFPACKETSIZE,
+		Count:      100,
+		Flood:      true,
+		OnSend:     func(int) { sent++ },
+		Conn:       &fakeConn{},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Ping() = %v", err)
+	}
+	// Without the default interval of a second between them, 100
+	// requests take well below that.
+	if sent != 100 || s.Received != 100 || s.Elapsed >= time.Second || s.Rate() <= 100 {
+		t.Errorf("sent %d, stats = %+v, rate %.1f/s, want 100 requests answered in under a second", sent, s, s.Rate())
+	}
+	if _, err := Ping(context.Background(), &Options{Host: "192.0.2.1", PacketSize: DEFPACKETSIZE, Flood: true, Adaptive: true}, nil); !errors.Is(err, errMode) {
+		t.Errorf("Ping(flood, adaptive) = %v, want %v", err, errMode)
+	}
+}
+
+func TestPingGroup(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		host      string
+		broadcast bool
+		conn      *fakeConn
+	}{
+		{
+			name:      "Broadcast",
+			host:      "192.0.2.255",
+			broadcast: true,
+			conn:      &fakeConn{responders: []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2")}},
+		},
+		{
+			name: "Multicast",
+			host: "ff02::1",
+			conn: &fakeConn{ipv6: true, responders: []net.IP{net.ParseIP("fe80::1"), net.ParseIP("fe80::2")}},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			s, err := Ping(context.Background(), &Options{
+				Host:       tt.host,
+				PacketSize: DEFPACKETSIZE,
+				Count:      2,
+				Interval:   time.Millisecond,
+				Broadcast:  tt.broadcast,
+				Conn:       tt.conn,
+			}, func(r Reply) {
+				got = append(got, fmt.Sprintf("%d %v %t", r.Seq, r.From, r.Dup))
+			})
+			if err != nil {
+				t.Fatalf("Ping() = %v", err)
+			}
+			a, b := tt.conn.responders[0], tt.conn.responders[1]
+			want := []string{
+				fmt.Sprintf("1 %v false", a), fmt.Sprintf("1 %v true", a), fmt.Sprintf("1 %v false", b),
+				fmt.Sprintf("2 %v false", a), fmt.Sprintf("2 %v true", a), fmt.Sprintf("2 %v false", b),
+			}
+			if strings.Join(got, ", ") != strings.Join(want, ", ") {
+				t.Errorf("replies = %v, want %v", got, want)
+			}
+			if s.Sent != 2 || s.Received != 2 || s.Duplicates != 4 {
+				t.Errorf("stats = %+v, want 2 of 2 received and 4 duplicates", s)
+			}
+		})
+	}
+}
+
+func TestPingDeadline(t *testing.T) {
+	start := time.Now()
+	s, err := Ping(context.Background(), &Options{
+		Host:       "192.0.2.1",
+		PacketSize: DEFPACKETSIZE,
+		Interval:   10 * time.Millisecond,
+		Deadline:   100 * time.Millisecond,
+		Conn:       &fakeConn{drop: map[int]bool{1: true, 2: true, 3: true}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Ping() = %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Ping() took %v, want it to end at the deadline of 100ms", d)
+	}
+	if s.Sent < 4 || s.Received != s.Sent-3 {
+		t.Errorf("stats = %+v, want all but the first 3 requests answered", s)
+	}
+}
+
+func TestPingUntilReply(t *testing.T) {
+	s, err := Ping(context.Background(), &Options{
+		Host:       "192.0.2.1",
+		PacketSize: DEFPACKETSIZE,
+		Count:      10,
+		Interval:   time.Millisecond,
+		Timeout:    time.Millisecond,
+		UntilReply: true,
+		Conn:       &fakeConn{drop: map[int]bool{1: true, 2: true}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Ping() = %v", err)
+	}
+	if s.Sent != 3 || s.Received != 1 {
+		t.Errorf("stats = %+v, want 1 of 3 received", s)
+	}
+}
+
+func TestPingICMPError(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		host   string
+		conn   *fakeConn
+		errors []string
+		stats  Stats
+	}{
+		{
+			name:   "Unreachable",
+			host:   "192.0.2.1",
+			conn:   &fakeConn{unreach: map[int]bool{2: true}},
+			errors: []string{"2 Destination Host Unreachable"},
+			stats:  Stats{Sent: 3, Received: 2, Errors: 1},
+		},
+		{
+			name:   "Redirect",
+			host:   "192.0.2.1",
+			conn:   &fakeConn{redirect: map[int]bool{1: true}},
+			errors: []string{"1 Redirect Host (New nexthop: 192.0.2.253)"},
+			stats:  Stats{Sent: 3, Received: 3},
+		},
+		{
+			name:   "IPv6Unreachable",
+			host:   "2001:db8::1",
+			conn:   &fakeConn{ipv6: true, unreach: map[int]bool{1: true, 3: true}},
+			errors: []string{"1 Address unreachable", "3 Address unreachable"},
+			stats:  Stats{Sent: 3, Received: 1, Errors: 2},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			s, err := Ping(context.Background(), &Options{
+				Host:       tt.host,
+				PacketSize: DEFPACKETSIZE,
+				Count:      3,
+				Interval:   time.Millisecond,
+				Conn:       tt.conn,
+				OnError: func(seq int, err *ICMPError) {
+					if !err.From.Equal(fakeRouter) {
+						t.Errorf("error from %v, want %v", err.From, fakeRouter)
+					}
+					got = append(got, fmt.Sprintf("%d %v", seq, err))
+				},
+			}, nil)
+			if err != nil {
+				t.Fatalf("Ping() = %v", err)
+			}
+			if strings.Join(got, ", ") != strings.Join(tt.errors, ", ") {
+				t.Errorf("errors = %q, want %q", got, tt.errors)
+			}
+			if s.Sent != tt.stats.Sent || s.Received != tt.stats.Received || s.Errors != tt.stats.Errors {
+				t.Errorf("stats = %+v, want %d sent, %d received and %d errors", s, tt.stats.Sent, tt.stats.Received, tt.stats.Errors)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ping
+
+import (
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// ReplyRecord is the machine-readable form of a reply.
+type ReplyRecord struct {
+	Seq  int    `json:"seq"`
+	From string `json:"from,omitempty"`
+	Size int    `json:"size"`
+	TTL  int    `json:"ttl,omitempty"`
+	// RTT is the round trip time in milliseconds, taken with Clock.
+	RTT   float64 `json:"rtt_ms"`
+	Clock string  `json:"clock"`
+	Dup   bool    `json:"dup,omitempty"`
+}
+
+// ErrorRecord is the machine-readable form of an ICMP error answering a
+// request.
+type ErrorRecord struct {
+	Seq     int    `json:"seq"`
+	From    string `json:"from,omitempty"`
+	Type    int    `json:"icmp_type"`
+	Code    int    `json:"icmp_code"`
+	Error   string `json:"error"`
+	Gateway string `json:"gateway,omitempty"`
+	MTU     int    `json:"mtu,omitempty"`
+}
+
+// SummaryRecord is the machine-readable form of the statistics of a
+// ping.
+type SummaryRecord struct {
+	Host       string  `json:"host"`
+	Dest       string  `json:"dest"`
+	Sent       int     `json:"sent"`
+	Received   int     `json:"received"`
+	Duplicates int     `json:"duplicates,omitempty"`
+	Errors     int     `json:"errors,omitempty"`
+	Loss       float64 `json:"loss_pct"`
+	// Elapsed is the time the ping took in milliseconds.
+	Elapsed float64 `json:"time_ms"`
+	// RTT summarizes the round trip times, nil without any reply.
+	RTT *RTTRecord `json:"rtt,omitempty"`
+}
+
+// RTTRecord holds round trip time statistics in milliseconds.
+type RTTRecord struct {
+	Min    float64 `json:"min_ms"`
+	Avg    float64 `json:"avg_ms"`
+	Max    float64 `json:"max_ms"`
+	StdDev float64 `json:"stddev_ms"`
+}
+
+// ms converts d to milliseconds, rounded to microseconds.
+func ms(d time.Duration) float64 {
+	return float64(d/time.Microsecond) / 1000
+}
+
+// Record returns r in machine-readable form.
+func (r Reply) Record() ReplyRecord {
+	rec := ReplyRecord{
+		Seq:   r.Seq,
+		Size:  r.Size,
+		TTL:   r.TTL,
+		RTT:   ms(r.RTT),
+		Clock: r.Clock.String(),
+		Dup:   r.Dup,
+	}
+	if r.From != nil {
+		rec.From = r.From.String()
+	}
+	return rec
+}
+
+// Record returns e, which answered the request with seq, in
+// machine-readable form.
+func (e *ICMPError) Record(seq int) ErrorRecord {
+	rec := ErrorRecord{
+		Seq:   seq,
+		Type:  icmpTypeNumber(e.Type),
+		Code:  e.Code,
+		Error: e.Error(),
+		MTU:   e.MTU,
+	}
+	if e.From != nil {
+		rec.From = e.From.String()
+	}
+	if e.Gateway != nil {
+		rec.Gateway = e.Gateway.String()
+	}
+	return rec
+}
+
+// icmpTypeNumber returns the number of ICMP or ICMPv6 type t.
+func icmpTypeNumber(t icmp.Type) int {
+	switch t := t.(type) {
+	case ipv4.ICMPType:
+		return int(t)
+	case ipv6.ICMPType:
+		return int(t)
+	}
+	return -1
+}
+
+// Record returns s, the statistics of pinging host, in machine-readable
+// form.
+func (s *Stats) Record(host string) SummaryRecord {
+	rec := SummaryRecord{
+		Host:       host,
+		Sent:       s.Sent,
+		Received:   s.Received,
+		Duplicates: s.Duplicates,
+		Errors:     s.Errors,
+		Loss:       s.Loss(),
+		Elapsed:    ms(s.Elapsed),
+	}
+	if s.Dest != nil {
+		rec.Dest = s.Dest.String()
+	}
+	if s.Received > 0 {
+		rec.RTT = &RTTRecord{Min: ms(s.Min), Avg: ms(s.Avg), Max: ms(s.Max), StdDev: ms(s.StdDev)}
+	}
+	return rec
+}
@@ -0,0 +1,47 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ping
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+func TestRecord(t *testing.T) {
+	r := Reply{Seq: 3, From: net.ParseIP("192.0.2.1"), Size: 64, TTL: 64, RTT: 1500 * time.Microsecond}
+	b, err := json.Marshal(r.Record())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), `{"seq":3,"from":"192.0.2.1","size":64,"ttl":64,"rtt_ms":1.5,"clock":"software"}`; got != want {
+		t.Errorf("reply record = %s, want %s", got, want)
+	}
+
+	e := &ICMPError{Type: ipv4.ICMPTypeDestinationUnreachable, Code: 1, From: net.ParseIP("192.0.2.254")}
+	b, err = json.Marshal(e.Record(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), `{"seq":2,"from":"192.0.2.254","icmp_type":3,"icmp_code":1,"error":"Destination Host Unreachable"}`; got != want {
+		t.Errorf("error record = %s, want %s", got, want)
+	}
+
+	s := &Stats{Dest: net.ParseIP("192.0.2.1"), Sent: 2, Elapsed: time.Second}
+	b, err = json.Marshal(s.Record("example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), `{"host":"example.com","dest":"192.0.2.1","sent":2,"received":0,"loss_pct":100,"time_ms":1000}`; got != want {
+		t.Errorf("summary record = %s, want %s", got, want)
+	}
+	s.add(time.Millisecond)
+	if rec := s.Record("example.com"); rec.RTT == nil || rec.RTT.Min != 1 || rec.Loss != 50 {
+		t.Errorf("summary record = %+v, want 1 of 2 received in 1ms", rec)
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ping
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// rawConn is a raw ICMP socket that tells the TTL of the messages read
+// and, with SO_TIMESTAMPING enabled, the times they were sent and
+// received at.
+type rawConn struct {
+	*net.IPConn
+	ipv6 bool
+	oob  []byte
+	// ttl is that of the latest message read, 0 if it is unknown.
+	ttl int
+}
+
+// wrapConn enables the timestamps of clock and the reporting of hop
+// limits on c.
+func wrapConn(c *net.IPConn, ipv6 bool, clock Clock) (net.PacketConn, error) {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		if ipv6 {
+			serr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_RECVHOPLIMIT, 1)
+		}
+		if serr == nil && clock != ClockSoftware {
+			serr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_TIMESTAMPING, timestampFlags[clock])
+		}
+	}); err != nil {
+		return nil, err
+	}
+	if serr != nil {
+		return nil, serr
+	}
+	return &rawConn{IPConn: c, ipv6: ipv6, oob: make([]byte, 512)}, nil
+}
+
+// ReadFrom reads an ICMP message, without the IPv4 header the socket
+// returns it with.
+func (c *rawConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, from, _, err := c.ReadFromTimestamp(b)
+	return n, from, err
+}
+
+func (c *rawConn) ReadFromTimestamp(b []byte) (int, net.Addr, Timestamp, error) {
+	c.ttl = 0
+	n, oobn, _, from, err := c.ReadMsgIP(b, c.oob)
+	if err != nil {
+		return n, nil, Timestamp{}, err
+	}
+	if !c.ipv6 && n > 0 {
+		hl := int(b[0]&0x0f) * 4
+		if hl > n {
+			hl = n
+		}
+		if n > 8 {
+			c.ttl = int(b[8])
+		}
+		n = copy(b, b[hl:n])
+	}
+	msgs, err := unix.ParseSocketControlMessage(c.oob[:oobn])
+	if err != nil {
+		return n, from, Timestamp{}, nil
+	}
+	for _, m := range msgs {
+		if m.Header.Level == unix.IPPROTO_IPV6 && m.Header.Type == unix.IPV6_HOPLIMIT && len(m.Data) >= 4 {
+			c.ttl = int(binary.NativeEndian.Uint32(m.Data))
+		}
+	}
+	return n, from, parseTimestamp(msgs), nil
+}
+
+func (c *rawConn) ReceivedTTL() (int, bool) {
+	return c.ttl, c.ttl != 0
+}
+
+// setBroadcast allows conn to send to broadcast addresses.
+func setBroadcast(conn net.PacketConn) error {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return errBroadcast
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		serr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_BROADCAST, 1)
+	}); err != nil {
+		return err
+	}
+	return serr
+}
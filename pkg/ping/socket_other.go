@@ -0,0 +1,20 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package ping
+
+import "net"
+
+func wrapConn(c *net.IPConn, ipv6 bool, clock Clock) (net.PacketConn, error) {
+	if clock != ClockSoftware {
+		return nil, errNoTimestamps
+	}
+	return c, nil
+}
+
+func setBroadcast(net.PacketConn) error {
+	return errBroadcast
+}
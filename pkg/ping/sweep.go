@@ -0,0 +1,244 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ping
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// MAXSWEEPHOSTS is the largest number of addresses Sweep pings.
+	MAXSWEEPHOSTS = 1 << 16
+	// DEFSWEEPINTERVAL is the default pause between two requests of a
+	// sweep, DEFSWEEPTIMEOUT the default time to wait for replies after
+	// the last one.
+	DEFSWEEPINTERVAL = time.Millisecond
+	DEFSWEEPTIMEOUT  = time.Second
+	// sweepPoll bounds each read of a sweep, so that it notices when it
+	// is over.
+	sweepPoll = 50 * time.Millisecond
+)
+
+var errSweepSize = errors.New("network too large to sweep")
+
+// SweepHosts returns the addresses of network to ping in a sweep. IPv4
+// networks larger than a /31 leave out their network and broadcast
+// addresses.
+func SweepHosts(network *net.IPNet) ([]net.IP, error) {
+	addr, ok := netip.AddrFromSlice(network.IP)
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", errNoAddr, network)
+	}
+	addr = addr.Unmap()
+	ones, bits := network.Mask.Size()
+	if addr.Is4() && bits == 8*net.IPv6len {
+		ones -= 96
+		bits = 32
+	}
+	if bits-ones > 16 {
+		return nil, fmt.Errorf("%w: %v has more than %d addresses", errSweepSize, network, MAXSWEEPHOSTS)
+	}
+	prefix := netip.PrefixFrom(addr, ones).Masked()
+	var hosts []net.IP
+	for a := prefix.Addr(); prefix.Contains(a); a = a.Next() {
+		hosts = append(hosts, net.IP(a.AsSlice()))
+	}
+	if addr.Is4() && bits-ones > 1 {
+		hosts = hosts[1 : len(hosts)-1]
+	}
+	return hosts, nil
+}
+
+// Sweep pings every host of network at once, and calls fn, unless nil,
+// with the first reply of every host that answered. Other than in Ping,
+// opts.Host is ignored, opts.Count is the number of requests sent to
+// hosts that did not answer yet, 1 by default, opts.Interval is the
+// pause between two requests of the sweep, DEFSWEEPINTERVAL by default,
+// and opts.Timeout is the time to wait for replies once all requests are
+// out, DEFSWEEPTIMEOUT by default. Sweep returns the replies sorted by
+// address. Cancelling ctx returns those so far together with the error of
+// ctx.
+func Sweep(ctx context.Context, network *net.IPNet, opts *Options, fn ReplyFunc) ([]Reply, error) {
+	o := *opts
+	if o.Count == 0 {
+		o.Count = 1
+	}
+	if o.Interval == 0 {
+		o.Interval = DEFSWEEPINTERVAL
+	}
+	if o.Timeout == 0 {
+		o.Timeout = DEFSWEEPTIMEOUT
+	}
+	if o.PacketSize < MINPACKETSIZE {
+		return nil, fmt.Errorf("%w: %d, must be >= %d", errPacketSize, o.PacketSize, MINPACKETSIZE)
+	}
+	hosts, err := SweepHosts(network)
+	if err != nil {
+		return nil, err
+	}
+	if len(hosts) == 0 {
+		return nil, nil
+	}
+	p := newPinger(hosts[0], o.PacketSize)
+	conn := o.Conn
+	if conn == nil {
+		if conn, err = listen(p.ipv6, &o); err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+	}
+
+	s := &sweep{
+		pinger:  p,
+		timeout: o.Timeout,
+		sent:    map[sweepProbe]time.Time{},
+		replies: map[string]Reply{},
+		fn:      fn,
+	}
+	sendCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() { errc <- s.send(sendCtx, conn, hosts, &o) }()
+	rerr := s.receive(ctx, conn, errc)
+	cancel()
+
+	replies := make([]Reply, 0, len(s.replies))
+	for _, r := range s.replies {
+		replies = append(replies, r)
+	}
+	sort.Slice(replies, func(i, j int) bool { return bytes.Compare(replies[i].From.To16(), replies[j].From.To16()) < 0 })
+	if rerr != nil {
+		return replies, rerr
+	}
+	return replies, ctx.Err()
+}
+
+// sweepProbe identifies a request of a sweep.
+type sweepProbe struct {
+	host string
+	seq  int
+}
+
+// sweep is the state of a running sweep.
+type sweep struct {
+	*pinger
+	timeout time.Duration
+	mu      sync.Mutex
+	// sent holds the time each request went out, replies the first
+	// reply of each host.
+	sent    map[sweepProbe]time.Time
+	replies map[string]Reply
+	fn      ReplyFunc
+}
+
+// send sends opts.Count rounds of requests, each to the hosts that have
+// not answered yet. The requests of round n have sequence number n.
+func (s *sweep) send(ctx context.Context, conn net.PacketConn, hosts []net.IP, opts *Options) error {
+	for seq := 1; uint64(seq) <= opts.Count; seq++ {
+		wb, err := s.request(seq)
+		if err != nil {
+			return err
+		}
+		for _, h := range hosts {
+			s.mu.Lock()
+			_, done := s.replies[h.String()]
+			s.mu.Unlock()
+			if done {
+				continue
+			}
+			timer := time.NewTimer(opts.Interval)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			}
+			s.mu.Lock()
+			s.sent[sweepProbe{h.String(), seq}] = time.Now()
+			s.mu.Unlock()
+			if _, err := conn.WriteTo(wb, &net.IPAddr{IP: h}); err != nil {
+				// The kernel refuses some addresses, e.g. broadcast
+				// ones. They count as not answering.
+				continue
+			}
+			if opts.OnSend != nil {
+				opts.OnSend(seq)
+			}
+		}
+		// The next round waits for the replies to this one.
+		if uint64(seq) < opts.Count {
+			timer := time.NewTimer(opts.Timeout)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			}
+		}
+	}
+	return nil
+}
+
+// receive reads replies until timeout after send, whose error comes in
+// on errc, is done, or until ctx is done.
+func (s *sweep) receive(ctx context.Context, conn net.PacketConn, errc <-chan error) error {
+	rb := make([]byte, 1500)
+	var end time.Time
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if end.IsZero() {
+			select {
+			case err := <-errc:
+				if err != nil && !errors.Is(err, context.Canceled) {
+					return err
+				}
+				end = time.Now().Add(s.timeout)
+			default:
+			}
+		} else if !time.Now().Before(end) {
+			return nil
+		}
+		if err := conn.SetReadDeadline(time.Now().Add(sweepPoll)); err != nil {
+			return err
+		}
+		n, from, err := conn.ReadFrom(rb)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		a, ok := from.(*net.IPAddr)
+		if !ok {
+			continue
+		}
+		seq, ok := s.replySeq(rb[:n])
+		if !ok {
+			continue
+		}
+		s.mu.Lock()
+		start, sent := s.sent[sweepProbe{a.IP.String(), seq}]
+		_, done := s.replies[a.IP.String()]
+		if sent && !done {
+			r := Reply{Seq: seq, From: a.IP, Size: n, RTT: time.Since(start)}
+			s.replies[a.IP.String()] = r
+			if s.fn != nil {
+				s.fn(r)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
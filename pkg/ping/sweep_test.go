@@ -0,0 +1,95 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ping
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSweepHosts(t *testing.T) {
+	for _, tt := range []struct {
+		cidr  string
+		first string
+		last  string
+		n     int
+		err   error
+	}{
+		{cidr: "192.0.2.0/24", first: "192.0.2.1", last: "192.0.2.254", n: 254},
+		{cidr: "192.0.2.77/30", first: "192.0.2.77", last: "192.0.2.78", n: 2},
+		{cidr: "192.0.2.0/31", first: "192.0.2.0", last: "192.0.2.1", n: 2},
+		{cidr: "192.0.2.1/32", first: "192.0.2.1", last: "192.0.2.1", n: 1},
+		{cidr: "2001:db8::/126", first: "2001:db8::", last: "2001:db8::3", n: 4},
+		{cidr: "10.0.0.0/8", err: errSweepSize},
+		{cidr: "2001:db8::/64", err: errSweepSize},
+	} {
+		t.Run(tt.cidr, func(t *testing.T) {
+			_, network, err := net.ParseCIDR(tt.cidr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			hosts, err := SweepHosts(network)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("SweepHosts() = %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				return
+			}
+			if len(hosts) != tt.n || !hosts[0].Equal(net.ParseIP(tt.first)) || !hosts[len(hosts)-1].Equal(net.ParseIP(tt.last)) {
+				t.Errorf("SweepHosts() = %d hosts %v..%v, want %d hosts %s..%s", len(hosts), hosts[0], hosts[len(hosts)-1], tt.n, tt.first, tt.last)
+			}
+		})
+	}
+}
+
+func TestSweep(t *testing.T) {
+	_, network, _ := net.ParseCIDR("192.0.2.0/28")
+	conn := &fakeConn{alive: map[string]bool{"192.0.2.9": true, "192.0.2.3": true}}
+	var seen []string
+	replies, err := Sweep(context.Background(), network, &Options{
+		PacketSize: DEFPACKETSIZE,
+		Count:      2,
+		Interval:   time.Microsecond,
+		Timeout:    10 * time.Millisecond,
+		Conn:       conn,
+	}, func(r Reply) { seen = append(seen, r.From.String()) })
+	if err != nil {
+		t.Fatalf("Sweep() = %v", err)
+	}
+	var got []string
+	for _, r := range replies {
+		if r.Seq != 1 {
+			t.Errorf("reply %+v, want the reply of the first round", r)
+		}
+		got = append(got, r.From.String())
+	}
+	if want := []string{"192.0.2.3", "192.0.2.9"}; !slices.Equal(got, want) || len(seen) != len(want) {
+		t.Errorf("Sweep() = %v, reported %v, want %v", got, seen, want)
+	}
+}
+
+func TestSweepCancel(t *testing.T) {
+	_, network, _ := net.ParseCIDR("192.0.2.0/24")
+	ctx, cancel := context.WithCancel(context.Background())
+	replies, err := Sweep(ctx, network, &Options{
+		PacketSize: DEFPACKETSIZE,
+		Interval:   time.Millisecond,
+		Conn:       &fakeConn{},
+	}, func(r Reply) {
+		if r.From.Equal(net.IPv4(192, 0, 2, 3)) {
+			cancel()
+		}
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Sweep() = %v, want %v", err, context.Canceled)
+	}
+	if len(replies) < 3 || len(replies) > 200 {
+		t.Errorf("Sweep() = %d replies, want the few before cancelling", len(replies))
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ping
+
+import (
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// timestampFlags are the SO_TIMESTAMPING flags of each clock. The
+// hardware clock asks for kernel timestamps, too, to fall back on.
+var timestampFlags = map[Clock]int{
+	ClockKernel: unix.SOF_TIMESTAMPING_TX_SOFTWARE | unix.SOF_TIMESTAMPING_RX_SOFTWARE |
+		unix.SOF_TIMESTAMPING_SOFTWARE | unix.SOF_TIMESTAMPING_OPT_TSONLY,
+	ClockHardware: unix.SOF_TIMESTAMPING_TX_SOFTWARE | unix.SOF_TIMESTAMPING_RX_SOFTWARE |
+		unix.SOF_TIMESTAMPING_SOFTWARE | unix.SOF_TIMESTAMPING_OPT_TSONLY |
+		unix.SOF_TIMESTAMPING_TX_HARDWARE | unix.SOF_TIMESTAMPING_RX_HARDWARE |
+		unix.SOF_TIMESTAMPING_RAW_HARDWARE,
+}
+
+// SentTimestamp reads the timestamps of the messages sent from the
+// error queue of the socket, and returns the latest.
+func (c *rawConn) SentTimestamp() (Timestamp, bool) {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return Timestamp{}, false
+	}
+	var ts Timestamp
+	var ok bool
+	oob := make([]byte, 512)
+	rc.Control(func(fd uintptr) {
+		for {
+			_, oobn, _, _, err := unix.Recvmsg(int(fd), nil, oob, unix.MSG_ERRQUEUE|unix.MSG_DONTWAIT)
+			if err != nil {
+				return
+			}
+			msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+			if err != nil {
+				continue
+			}
+			if t := parseTimestamp(msgs); t != (Timestamp{}) {
+				ts, ok = t, true
+			}
+		}
+	})
+	return ts, ok
+}
+
+// parseTimestamp returns the times of the SCM_TIMESTAMPING control
+// message among msgs.
+func parseTimestamp(msgs []unix.SocketControlMessage) Timestamp {
+	var ts Timestamp
+	for _, m := range msgs {
+		if m.Header.Level != unix.SOL_SOCKET || m.Header.Type != unix.SCM_TIMESTAMPING || len(m.Data) < int(unsafe.Sizeof(unix.ScmTimestamping{})) {
+			continue
+		}
+		st := (*unix.ScmTimestamping)(unsafe.Pointer(&m.Data[0]))
+		// The second timespec is deprecated and always zero.
+		ts.Kernel = timespec(st.Ts[0])
+		ts.Hardware = timespec(st.Ts[2])
+	}
+	return ts
+}
+
+// timespec converts t, zero if it is.
+func timespec(t unix.Timespec) time.Time {
+	if t.Sec == 0 && t.Nsec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(t.Sec), int64(t.Nsec))
+}
@@ -0,0 +1,49 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ping
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestKernelTimestamps(t *testing.T) {
+	for _, host := range []string{"127.0.0.1", "::1"} {
+		t.Run(host, func(t *testing.T) {
+			conn, err := listen(host == "::1", &Options{Clock: ClockHardware})
+			if err != nil {
+				t.Skipf("no raw socket: %v", err)
+			}
+			defer conn.Close()
+			var replies []Reply
+			if _, err := Ping(context.Background(), &Options{
+				Host:       host,
+				PacketSize: DEFPACKETSIZE,
+				Count:      2,
+				Interval:   time.Millisecond,
+				Timeout:    time.Second,
+				Clock:      ClockHardware,
+				Conn:       conn,
+			}, func(r Reply) { replies = append(replies, r) }); err != nil {
+				t.Fatalf("Ping() = %v", err)
+			}
+			// Loopback has no hardware clock to take the times. The
+			// kernel turns on receive timestamps lazily once the first
+			// socket asks, so the first reply may miss its own.
+			for i, r := range replies {
+				if i > 0 && r.Clock != ClockKernel || r.RTT <= 0 || r.RTT > time.Second {
+					t.Errorf("reply = %+v, want one timed by the kernel", r)
+				}
+				if r.TTL == 0 {
+					t.Errorf("reply = %+v, want its TTL", r)
+				}
+			}
+			if len(replies) != 2 {
+				t.Errorf("%d replies, want 2", len(replies))
+			}
+		})
+	}
+}
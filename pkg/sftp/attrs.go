@@ -0,0 +1,236 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sftp
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Attribute flags.
+const (
+	attrSize        = 0x1
+	attrUIDGID      = 0x2
+	attrPermissions = 0x4
+	attrACModTime   = 0x8
+	attrExtended    = 0x80000000
+)
+
+// POSIX file types of the permissions attribute.
+const (
+	modeFIFO    = 0o010000
+	modeChar    = 0o020000
+	modeDir     = 0o040000
+	modeBlock   = 0o060000
+	modeRegular = 0o100000
+	modeSymlink = 0o120000
+	modeSocket  = 0o140000
+
+	modeSetuid = 0o4000
+	modeSetgid = 0o2000
+	modeSticky = 0o1000
+)
+
+// attrs are the attributes of a file, only those in flags are valid.
+type attrs struct {
+	flags        uint32
+	size         uint64
+	uid, gid     uint32
+	permissions  uint32
+	atime, mtime uint32
+}
+
+// posixMode returns the POSIX st_mode of m.
+func posixMode(m os.FileMode) uint32 {
+	p := uint32(m.Perm())
+	switch {
+	case m.IsDir():
+		p |= modeDir
+	case m&os.ModeSymlink != 0:
+		p |= modeSymlink
+	case m&os.ModeNamedPipe != 0:
+		p |= modeFIFO
+	case m&os.ModeSocket != 0:
+		p |= modeSocket
+	case m&os.ModeCharDevice != 0:
+		p |= modeChar
+	case m&os.ModeDevice != 0:
+		p |= modeBlock
+	default:
+		p |= modeRegular
+	}
+	if m&os.ModeSetuid != 0 {
+		p |= modeSetuid
+	}
+	if m&os.ModeSetgid != 0 {
+		p |= modeSetgid
+	}
+	if m&os.ModeSticky != 0 {
+		p |= modeSticky
+	}
+	return p
+}
+
+// fileMode returns the os.FileMode of the permission bits of p.
+func fileMode(p uint32) os.FileMode {
+	m := os.FileMode(p & 0o777)
+	if p&modeSetuid != 0 {
+		m |= os.ModeSetuid
+	}
+	if p&modeSetgid != 0 {
+		m |= os.ModeSetgid
+	}
+	if p&modeSticky != 0 {
+		m |= os.ModeSticky
+	}
+	return m
+}
+
+// fileAttrs returns the attributes of fi, the modification time standing
+// for the access time too.
+func fileAttrs(fi os.FileInfo) attrs {
+	a := attrs{
+		flags:       attrSize | attrPermissions | attrACModTime,
+		size:        uint64(fi.Size()),
+		permissions: posixMode(fi.Mode()),
+		atime:       uint32(fi.ModTime().Unix()),
+		mtime:       uint32(fi.ModTime().Unix()),
+	}
+	if uid, gid, ok := owner(fi); ok {
+		a.flags |= attrUIDGID
+		a.uid, a.gid = uid, gid
+	}
+	return a
+}
+
+func (a attrs) encode(p packet) packet {
+	p = p.uint32(a.flags)
+	if a.flags&attrSize != 0 {
+		p = p.uint64(a.size)
+	}
+	if a.flags&attrUIDGID != 0 {
+		p = p.uint32(a.uid).uint32(a.gid)
+	}
+	if a.flags&attrPermissions != 0 {
+		p = p.uint32(a.permissions)
+	}
+	if a.flags&attrACModTime != 0 {
+		p = p.uint32(a.atime).uint32(a.mtime)
+	}
+	return p
+}
+
+// decodeAttrs decodes attributes, skipping the extended ones.
+func decodeAttrs(d *decoder) attrs {
+	a := attrs{flags: d.uint32()}
+	if a.flags&attrSize != 0 {
+		a.size = d.uint64()
+	}
+	if a.flags&attrUIDGID != 0 {
+		a.uid, a.gid = d.uint32(), d.uint32()
+	}
+	if a.flags&attrPermissions != 0 {
+		a.permissions = d.uint32()
+	}
+	if a.flags&attrACModTime != 0 {
+		a.atime, a.mtime = d.uint32(), d.uint32()
+	}
+	if a.flags&attrExtended != 0 {
+		for n := d.uint32(); n > 0 && d.err == nil; n-- {
+			d.string()
+			d.string()
+		}
+	}
+	return a
+}
+
+// apply sets the attributes a of name, or of f if not nil.
+func (a attrs) apply(name string, f *os.File) error {
+	if a.flags&attrSize != 0 {
+		var err error
+		if f != nil {
+			err = f.Truncate(int64(a.size))
+		} else {
+			err = os.Truncate(name, int64(a.size))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if a.flags&attrPermissions != 0 {
+		var err error
+		if f != nil {
+			err = f.Chmod(fileMode(a.permissions))
+		} else {
+			err = os.Chmod(name, fileMode(a.permissions))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if a.flags&attrUIDGID != 0 {
+		var err error
+		if f != nil {
+			err = f.Chown(int(a.uid), int(a.gid))
+		} else {
+			err = os.Chown(name, int(a.uid), int(a.gid))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if a.flags&attrACModTime != 0 {
+		if err := os.Chtimes(name, time.Unix(int64(a.atime), 0), time.Unix(int64(a.mtime), 0)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// special sets the execute bit c of ls -l to set, or to its upper case if
+// not executable.
+func special(c *byte, set byte) {
+	if *c == 'x' {
+		*c = set
+	} else {
+		*c = set - 'a' + 'A'
+	}
+}
+
+// longName returns the ls -l line of name, which clients show as is.
+func longName(name string, fi os.FileInfo) string {
+	m := fi.Mode()
+	mode := []byte(m.Perm().String())
+	switch {
+	case m.IsDir():
+		mode[0] = 'd'
+	case m&os.ModeSymlink != 0:
+		mode[0] = 'l'
+	case m&os.ModeNamedPipe != 0:
+		mode[0] = 'p'
+	case m&os.ModeSocket != 0:
+		mode[0] = 's'
+	case m&os.ModeCharDevice != 0:
+		mode[0] = 'c'
+	case m&os.ModeDevice != 0:
+		mode[0] = 'b'
+	}
+	if m&os.ModeSetuid != 0 {
+		special(&mode[3], 's')
+	}
+	if m&os.ModeSetgid != 0 {
+		special(&mode[6], 's')
+	}
+	if m&os.ModeSticky != 0 {
+		special(&mode[9], 't')
+	}
+	uid, gid, _ := owner(fi)
+	date := fi.ModTime().Format("Jan _2 15:04")
+	if time.Since(fi.ModTime()) > 180*24*time.Hour {
+		date = fi.ModTime().Format("Jan _2  2006")
+	}
+	return fmt.Sprintf("%s %4d %-8d %-8d %8d %s %s", mode, links(fi), uid, gid, fi.Size(), date, name)
+}
@@ -0,0 +1,149 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sftp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Packet types of version 3 of the protocol.
+const (
+	fxpInit     = 1
+	fxpVersion  = 2
+	fxpOpen     = 3
+	fxpClose    = 4
+	fxpRead     = 5
+	fxpWrite    = 6
+	fxpLstat    = 7
+	fxpFstat    = 8
+	fxpSetstat  = 9
+	fxpFsetstat = 10
+	fxpOpendir  = 11
+	fxpReaddir  = 12
+	fxpRemove   = 13
+	fxpMkdir    = 14
+	fxpRmdir    = 15
+	fxpRealpath = 16
+	fxpStat     = 17
+	fxpRename   = 18
+	fxpReadlink = 19
+	fxpSymlink  = 20
+	fxpStatus   = 101
+	fxpHandle   = 102
+	fxpData     = 103
+	fxpName     = 104
+	fxpAttrs    = 105
+	fxpExtended = 200
+)
+
+// Status codes.
+const (
+	fxOK               = 0
+	fxEOF              = 1
+	fxNoSuchFile       = 2
+	fxPermissionDenied = 3
+	fxFailure          = 4
+	fxBadMessage       = 5
+	fxOpUnsupported    = 8
+)
+
+// maxPacket is the largest packet accepted, well above the 32 KiB data
+// plus header that clients must support and the 256 KiB OpenSSH sends.
+const maxPacket = 1 << 20
+
+var errShortPacket = errors.New("packet too short")
+
+// readPacket reads a packet and returns its type and payload.
+func readPacket(r io.Reader) (byte, []byte, error) {
+	var l [4]byte
+	if _, err := io.ReadFull(r, l[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(l[:])
+	if n == 0 || n > maxPacket {
+		return 0, nil, fmt.Errorf("invalid packet length %d", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return 0, nil, err
+	}
+	return b[0], b[1:], nil
+}
+
+// decoder reads the fields of a payload, remembering the first error.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil || len(d.b) < n {
+		d.err = errShortPacket
+		return nil
+	}
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b
+}
+
+func (d *decoder) uint32() uint32 {
+	if b := d.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *decoder) uint64() uint64 {
+	if b := d.next(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uint32()
+	if n > uint32(len(d.b)) {
+		d.err = errShortPacket
+		return nil
+	}
+	return d.next(int(n))
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+// packet builds a packet of type typ.
+type packet []byte
+
+func newPacket(typ byte) packet {
+	// The length is filled in by bytes.
+	return packet{0, 0, 0, 0, typ}
+}
+
+func (p packet) uint32(v uint32) packet {
+	return binary.BigEndian.AppendUint32(p, v)
+}
+
+func (p packet) uint64(v uint64) packet {
+	return binary.BigEndian.AppendUint64(p, v)
+}
+
+func (p packet) string(s string) packet {
+	return append(p.uint32(uint32(len(s))), s...)
+}
+
+func (p packet) data(b []byte) packet {
+	return append(p.uint32(uint32(len(b))), b...)
+}
+
+// bytes returns the packet with its length.
+func (p packet) bytes() []byte {
+	binary.BigEndian.PutUint32(p, uint32(len(p)-4))
+	return p
+}
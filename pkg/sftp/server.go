@@ -0,0 +1,381 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package sftp implements the server side of the SSH File Transfer
+// Protocol, version 3 (draft-ietf-secsh-filexfer-02), which OpenSSH, WinSCP
+// and recent scp clients speak.
+//
+// The server serves the local file system, relative paths being relative
+// to the working directory of the process.
+package sftp
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Open flags.
+const (
+	fxfRead   = 0x1
+	fxfWrite  = 0x2
+	fxfAppend = 0x4
+	fxfCreat  = 0x8
+	fxfTrunc  = 0x10
+	fxfExcl   = 0x20
+)
+
+const (
+	// version is the protocol version served.
+	version = 3
+
+	// maxRead is the most bytes returned by a read; OpenSSH asks for
+	// 32 KiB, or more if the server advertises it with limits@openssh.com.
+	maxRead = 256 << 10
+
+	// readdirCount is the most entries returned by a readdir.
+	readdirCount = 128
+
+	posixRename = "posix-rename@openssh.com"
+)
+
+var (
+	errUnsupported = errors.New("operation unsupported")
+	errHandle      = errors.New("invalid handle")
+	errIsDir       = errors.New("is a directory")
+	errNotDir      = errors.New("not a directory")
+	errExists      = errors.New("file exists")
+)
+
+// handle is an open file or directory.
+type handle struct {
+	name   string
+	f      *os.File
+	dir    bool
+	append bool
+}
+
+type server struct {
+	rw      io.ReadWriter
+	handles map[string]*handle
+	next    uint64
+}
+
+// Serve answers the SFTP requests read from rw, typically the channel of
+// an SSH "sftp" subsystem, until it is closed.
+func Serve(rw io.ReadWriter) error {
+	s := &server{rw: rw, handles: map[string]*handle{}}
+	defer s.closeAll()
+	for {
+		typ, b, err := readPacket(rw)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := rw.Write(s.reply(typ, b).bytes()); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *server) closeAll() {
+	for _, h := range s.handles {
+		h.f.Close()
+	}
+}
+
+// reply returns the response to the request typ with payload b.
+func (s *server) reply(typ byte, b []byte) packet {
+	d := &decoder{b: b}
+	if typ == fxpInit {
+		// Extensions follow the client version, higher versions
+		// settle on ours.
+		return newPacket(fxpVersion).uint32(version).string(posixRename).string("1")
+	}
+	id := d.uint32()
+	p, err := s.request(typ, id, d)
+	// A short packet makes the request fail on empty fields.
+	if d.err != nil {
+		err = d.err
+	}
+	if err != nil || p == nil {
+		return status(id, err)
+	}
+	return p
+}
+
+// status returns the status response of err.
+func status(id uint32, err error) packet {
+	code, msg := uint32(fxOK), "Success"
+	switch {
+	case err == nil:
+	case errors.Is(err, io.EOF):
+		code, msg = fxEOF, "End of file"
+	case errors.Is(err, errShortPacket):
+		code, msg = fxBadMessage, err.Error()
+	case errors.Is(err, errUnsupported):
+		code, msg = fxOpUnsupported, err.Error()
+	case errors.Is(err, os.ErrNotExist):
+		code, msg = fxNoSuchFile, err.Error()
+	case errors.Is(err, os.ErrPermission):
+		code, msg = fxPermissionDenied, err.Error()
+	default:
+		code, msg = fxFailure, err.Error()
+	}
+	return newPacket(fxpStatus).uint32(id).uint32(code).string(msg).string("")
+}
+
+// name returns the NAME response of a single entry.
+func name(id uint32, n string) packet {
+	return attrs{}.encode(newPacket(fxpName).uint32(id).uint32(1).string(n).string(n))
+}
+
+// request runs the request typ, returning its response or the error of
+// the status to respond with. A nil response is status OK.
+func (s *server) request(typ byte, id uint32, d *decoder) (packet, error) {
+	switch typ {
+	case fxpOpen:
+		return s.open(id, d.string(), d.uint32(), decodeAttrs(d))
+	case fxpOpendir:
+		return s.opendir(id, d.string())
+	case fxpClose:
+		hn := d.string()
+		h, err := s.handle(hn)
+		if err != nil {
+			return nil, err
+		}
+		delete(s.handles, hn)
+		return nil, h.f.Close()
+	case fxpRead:
+		return s.read(id, d.string(), int64(d.uint64()), d.uint32())
+	case fxpWrite:
+		return nil, s.write(d.string(), int64(d.uint64()), d.bytes())
+	case fxpReaddir:
+		return s.readdir(id, d.string())
+	case fxpStat, fxpLstat:
+		stat := os.Stat
+		if typ == fxpLstat {
+			stat = os.Lstat
+		}
+		fi, err := stat(d.string())
+		if err != nil {
+			return nil, err
+		}
+		return fileAttrs(fi).encode(newPacket(fxpAttrs).uint32(id)), nil
+	case fxpFstat:
+		h, err := s.handle(d.string())
+		if err != nil {
+			return nil, err
+		}
+		fi, err := h.f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		return fileAttrs(fi).encode(newPacket(fxpAttrs).uint32(id)), nil
+	case fxpSetstat:
+		n := d.string()
+		return nil, decodeAttrs(d).apply(n, nil)
+	case fxpFsetstat:
+		h, err := s.handle(d.string())
+		if err != nil {
+			return nil, err
+		}
+		return nil, decodeAttrs(d).apply(h.name, h.f)
+	case fxpRemove:
+		n := d.string()
+		if fi, err := os.Lstat(n); err == nil && fi.IsDir() {
+			return nil, &os.PathError{Op: "remove", Path: n, Err: errIsDir}
+		}
+		return nil, os.Remove(n)
+	case fxpMkdir:
+		n := d.string()
+		a := decodeAttrs(d)
+		perm := os.FileMode(0o777)
+		if a.flags&attrPermissions != 0 {
+			perm = fileMode(a.permissions)
+		}
+		return nil, os.Mkdir(n, perm)
+	case fxpRmdir:
+		n := d.string()
+		if fi, err := os.Lstat(n); err == nil && !fi.IsDir() {
+			return nil, &os.PathError{Op: "rmdir", Path: n, Err: errNotDir}
+		}
+		return nil, os.Remove(n)
+	case fxpRealpath:
+		p, err := realpath(d.string())
+		if err != nil {
+			return nil, err
+		}
+		return name(id, p), nil
+	case fxpRename:
+		oldName, newName := d.string(), d.string()
+		// Unlike rename(2), SFTP renames do not replace files.
+		if _, err := os.Lstat(newName); err == nil {
+			return nil, &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: errExists}
+		}
+		return nil, os.Rename(oldName, newName)
+	case fxpReadlink:
+		target, err := os.Readlink(d.string())
+		if err != nil {
+			return nil, err
+		}
+		return name(id, target), nil
+	case fxpSymlink:
+		// OpenSSH sends the target first, unlike the draft, and
+		// all clients follow it.
+		target, link := d.string(), d.string()
+		return nil, os.Symlink(target, link)
+	case fxpExtended:
+		if d.string() != posixRename {
+			return nil, errUnsupported
+		}
+		return nil, os.Rename(d.string(), d.string())
+	}
+	return nil, errUnsupported
+}
+
+// handle returns the open handle h.
+func (s *server) handle(h string) (*handle, error) {
+	f, ok := s.handles[h]
+	if !ok {
+		return nil, errHandle
+	}
+	return f, nil
+}
+
+// newHandle returns the HANDLE response of h.
+func (s *server) newHandle(id uint32, h *handle) packet {
+	s.next++
+	n := strconv.FormatUint(s.next, 10)
+	s.handles[n] = h
+	return newPacket(fxpHandle).uint32(id).string(n)
+}
+
+func (s *server) open(id uint32, n string, pflags uint32, a attrs) (packet, error) {
+	var flags int
+	switch {
+	case pflags&fxfRead != 0 && pflags&fxfWrite != 0:
+		flags = os.O_RDWR
+	case pflags&fxfWrite != 0:
+		flags = os.O_WRONLY
+	default:
+		flags = os.O_RDONLY
+	}
+	if pflags&fxfAppend != 0 {
+		flags |= os.O_APPEND
+	}
+	if pflags&fxfCreat != 0 {
+		flags |= os.O_CREATE
+	}
+	if pflags&fxfTrunc != 0 {
+		flags |= os.O_TRUNC
+	}
+	if pflags&fxfExcl != 0 {
+		flags |= os.O_EXCL
+	}
+	perm := os.FileMode(0o666)
+	if a.flags&attrPermissions != 0 {
+		perm = fileMode(a.permissions)
+	}
+	f, err := os.OpenFile(n, flags, perm)
+	if err != nil {
+		return nil, err
+	}
+	return s.newHandle(id, &handle{name: n, f: f, append: pflags&fxfAppend != 0}), nil
+}
+
+func (s *server) opendir(id uint32, n string) (packet, error) {
+	f, err := os.Open(n)
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := f.Stat(); err != nil || !fi.IsDir() {
+		f.Close()
+		if err == nil {
+			err = &os.PathError{Op: "opendir", Path: n, Err: errNotDir}
+		}
+		return nil, err
+	}
+	return s.newHandle(id, &handle{name: n, f: f, dir: true}), nil
+}
+
+func (s *server) read(id uint32, hn string, offset int64, n uint32) (packet, error) {
+	h, err := s.handle(hn)
+	if err != nil {
+		return nil, err
+	}
+	if h.dir {
+		return nil, errIsDir
+	}
+	b := make([]byte, min(n, maxRead))
+	m, err := h.f.ReadAt(b, offset)
+	if m == 0 && err != nil {
+		return nil, err
+	}
+	return newPacket(fxpData).uint32(id).data(b[:m]), nil
+}
+
+func (s *server) write(hn string, offset int64, b []byte) error {
+	h, err := s.handle(hn)
+	if err != nil {
+		return err
+	}
+	if h.dir {
+		return errIsDir
+	}
+	// Appends ignore the offset, and WriteAt refuses them.
+	if h.append {
+		_, err = h.f.Write(b)
+	} else {
+		_, err = h.f.WriteAt(b, offset)
+	}
+	return err
+}
+
+func (s *server) readdir(id uint32, hn string) (packet, error) {
+	h, err := s.handle(hn)
+	if err != nil {
+		return nil, err
+	}
+	if !h.dir {
+		return nil, errNotDir
+	}
+	entries, err := h.f.ReadDir(readdirCount)
+	if len(entries) == 0 {
+		if err == nil {
+			err = io.EOF
+		}
+		return nil, err
+	}
+	p := newPacket(fxpName).uint32(id)
+	var n uint32
+	names := packet{}
+	for _, e := range entries {
+		fi, err := e.Info()
+		if err != nil {
+			// Removed since it was listed.
+			continue
+		}
+		names = fileAttrs(fi).encode(names.string(e.Name()).string(longName(e.Name(), fi)))
+		n++
+	}
+	return append(p.uint32(n), names...), nil
+}
+
+// realpath returns the absolute, clean path of p, the working directory
+// for an empty one.
+func realpath(p string) (string, error) {
+	if p == "" {
+		p = "."
+	}
+	p, err := filepath.Abs(p)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(p), nil
+}
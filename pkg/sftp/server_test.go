@@ -0,0 +1,213 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sftp
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// client sends requests to a server and decodes the responses.
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	id   uint32
+}
+
+func newClient(t *testing.T) *client {
+	c, s := net.Pipe()
+	done := make(chan error)
+	go func() {
+		done <- Serve(s)
+	}()
+	t.Cleanup(func() {
+		c.Close()
+		if err := <-done; err != nil {
+			t.Errorf("Serve() = %v", err)
+		}
+	})
+	return &client{t: t, conn: c}
+}
+
+// call sends the request typ with the fields of args and returns the
+// response after its id.
+func (c *client) call(typ byte, args func(packet) packet) (byte, *decoder) {
+	c.t.Helper()
+	c.id++
+	p := newPacket(typ).uint32(c.id)
+	if args != nil {
+		p = args(p)
+	}
+	if _, err := c.conn.Write(p.bytes()); err != nil {
+		c.t.Fatal(err)
+	}
+	rtyp, b, err := readPacket(c.conn)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	d := &decoder{b: b}
+	if id := d.uint32(); id != c.id {
+		c.t.Fatalf("response id %d, want %d", id, c.id)
+	}
+	return rtyp, d
+}
+
+// status calls typ and checks that the server responds with status code.
+func (c *client) status(code uint32, typ byte, args func(packet) packet) {
+	c.t.Helper()
+	rtyp, d := c.call(typ, args)
+	if rtyp != fxpStatus {
+		c.t.Fatalf("request %d: response %d, want status %d", typ, rtyp, code)
+	}
+	if got := d.uint32(); got != code {
+		c.t.Fatalf("request %d: status %d (%s), want %d", typ, got, d.string(), code)
+	}
+}
+
+// handle calls typ and returns the handle responded.
+func (c *client) handle(typ byte, args func(packet) packet) string {
+	c.t.Helper()
+	rtyp, d := c.call(typ, args)
+	if rtyp != fxpHandle {
+		c.t.Fatalf("request %d: response %d (%d %s), want a handle", typ, rtyp, d.uint32(), d.string())
+	}
+	return d.string()
+}
+
+// attrs calls typ and returns the attributes responded.
+func (c *client) attrs(typ byte, args func(packet) packet) attrs {
+	c.t.Helper()
+	rtyp, d := c.call(typ, args)
+	if rtyp != fxpAttrs {
+		c.t.Fatalf("request %d: response %d, want attributes", typ, rtyp)
+	}
+	return decodeAttrs(d)
+}
+
+// names calls typ and returns the names responded.
+func (c *client) names(typ byte, args func(packet) packet) []string {
+	c.t.Helper()
+	rtyp, d := c.call(typ, args)
+	if rtyp != fxpName {
+		c.t.Fatalf("request %d: response %d, want names", typ, rtyp)
+	}
+	var names []string
+	for n := d.uint32(); n > 0; n-- {
+		names = append(names, d.string())
+		d.string()
+		decodeAttrs(d)
+	}
+	if d.err != nil {
+		c.t.Fatalf("request %d: %v", typ, d.err)
+	}
+	return names
+}
+
+func str(ss ...string) func(packet) packet {
+	return func(p packet) packet {
+		for _, s := range ss {
+			p = p.string(s)
+		}
+		return p
+	}
+}
+
+func TestServer(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a")
+	sub := filepath.Join(dir, "sub")
+	b := filepath.Join(sub, "b")
+	link := filepath.Join(sub, "link")
+
+	c := newClient(t)
+	if _, err := c.conn.Write(newPacket(fxpInit).uint32(3).bytes()); err != nil {
+		t.Fatal(err)
+	}
+	typ, v, err := readPacket(c.conn)
+	if err != nil || typ != fxpVersion {
+		t.Fatalf("init: response %d, %v, want version", typ, err)
+	}
+	if d := (&decoder{b: v}); d.uint32() != 3 || d.string() != posixRename {
+		t.Errorf("init: version payload %q, want version 3 with %s", v, posixRename)
+	}
+
+	if got := c.names(fxpRealpath, str(dir+"/sub/..")); len(got) != 1 || got[0] != dir {
+		t.Errorf("realpath = %q, want %q", got, dir)
+	}
+
+	// Write a file in two pieces.
+	h := c.handle(fxpOpen, func(p packet) packet {
+		return p.string(a).uint32(fxfWrite | fxfCreat | fxfTrunc).uint32(attrPermissions).uint32(0o640)
+	})
+	c.status(fxOK, fxpWrite, func(p packet) packet { return p.string(h).uint64(0).string("hello") })
+	c.status(fxOK, fxpWrite, func(p packet) packet { return p.string(h).uint64(5).string(" world") })
+	if got := c.attrs(fxpFstat, str(h)); got.size != 11 {
+		t.Errorf("fstat size = %d, want 11", got.size)
+	}
+	c.status(fxOK, fxpClose, str(h))
+	c.status(fxFailure, fxpClose, str(h))
+
+	if got := c.attrs(fxpStat, str(a)); got.permissions != modeRegular|0o640 {
+		t.Errorf("stat permissions = %o, want %o", got.permissions, modeRegular|0o640)
+	}
+
+	// Read it back.
+	h = c.handle(fxpOpen, func(p packet) packet { return p.string(a).uint32(fxfRead).uint32(0) })
+	typ, d := c.call(fxpRead, func(p packet) packet { return p.string(h).uint64(6).uint32(100) })
+	if got := d.string(); typ != fxpData || got != "world" {
+		t.Errorf("read = %d %q, want data %q", typ, got, "world")
+	}
+	c.status(fxEOF, fxpRead, func(p packet) packet { return p.string(h).uint64(11).uint32(100) })
+	c.status(fxOK, fxpClose, str(h))
+
+	// Directories.
+	c.status(fxOK, fxpMkdir, func(p packet) packet { return p.string(sub).uint32(0) })
+	c.status(fxFailure, fxpMkdir, func(p packet) packet { return p.string(sub).uint32(0) })
+	h = c.handle(fxpOpendir, str(dir))
+	entries := c.names(fxpReaddir, str(h))
+	sort.Strings(entries)
+	if len(entries) != 2 || entries[0] != "a" || entries[1] != "sub" {
+		t.Errorf("readdir = %q, want [a sub]", entries)
+	}
+	c.status(fxEOF, fxpReaddir, str(h))
+	c.status(fxOK, fxpClose, str(h))
+	c.status(fxFailure, fxpOpendir, str(a))
+
+	// Renames do not replace files, unless POSIX ones.
+	c.status(fxOK, fxpRename, str(a, b))
+	if err := os.WriteFile(a, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c.status(fxFailure, fxpRename, str(a, b))
+	c.status(fxOK, fxpExtended, str(posixRename, b, a))
+
+	c.status(fxOK, fxpSetstat, func(p packet) packet { return p.string(a).uint32(attrPermissions).uint32(0o600) })
+	if fi, err := os.Stat(a); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Errorf("setstat: got %v, %v, want mode 0600", fi.Mode(), err)
+	}
+
+	// OpenSSH sends the target first.
+	c.status(fxOK, fxpSymlink, str("../a", link))
+	if got := c.names(fxpReadlink, str(link)); len(got) != 1 || got[0] != "../a" {
+		t.Errorf("readlink = %q, want [../a]", got)
+	}
+	if got := c.attrs(fxpLstat, str(link)); got.permissions&0o170000 != modeSymlink {
+		t.Errorf("lstat permissions = %o, want a symlink", got.permissions)
+	}
+
+	c.status(fxFailure, fxpRemove, str(sub))
+	c.status(fxFailure, fxpRmdir, str(a))
+	c.status(fxOK, fxpRemove, str(link))
+	c.status(fxOK, fxpRmdir, str(sub))
+	c.status(fxNoSuchFile, fxpStat, str(sub))
+
+	c.status(fxOpUnsupported, 99, nil)
+	c.status(fxOpUnsupported, fxpExtended, str("statvfs@openssh.com", dir))
+	c.status(fxBadMessage, fxpStat, nil)
+	c.status(fxFailure, fxpRead, func(p packet) packet { return p.string("nope").uint64(0).uint32(1) })
+}
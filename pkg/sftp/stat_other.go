@@ -0,0 +1,18 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build plan9 || windows
+
+package sftp
+
+import "os"
+
+// Plan 9 and Windows owners are not numeric.
+func owner(os.FileInfo) (uint32, uint32, bool) {
+	return 0, 0, false
+}
+
+func links(os.FileInfo) uint64 {
+	return 1
+}
@@ -0,0 +1,250 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package slaac
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/insomniacslk/dhcp/rfc1035label"
+	"golang.org/x/net/ipv6"
+)
+
+// Neighbor discovery option types, RFC 4861 Section 4.6, RFC 8106.
+const (
+	optSourceLinkAddr = 1
+	optPrefixInfo     = 3
+	optMTU            = 5
+	optRDNSS          = 25
+	optDNSSL          = 31
+)
+
+// raHeaderLen is the length of a router advertisement up to its options.
+const raHeaderLen = 16
+
+// Infinity is the lifetime of prefixes and servers that do not expire.
+const Infinity = time.Duration(0xffffffff) * time.Second
+
+var (
+	errShort   = errors.New("message too short")
+	errNotRA   = errors.New("not a router advertisement")
+	errOptions = errors.New("malformed option")
+)
+
+// Prefix is a prefix information option.
+type Prefix struct {
+	Prefix *net.IPNet
+
+	// OnLink tells the prefix is reachable without a router.
+	OnLink bool
+
+	// Autonomous tells addresses may be configured in the prefix.
+	Autonomous bool
+
+	ValidLifetime     time.Duration
+	PreferredLifetime time.Duration
+}
+
+// RouterAdvertisement is an ICMPv6 router advertisement, RFC 4861
+// Section 4.2.
+type RouterAdvertisement struct {
+	HopLimit uint8
+
+	// Managed tells addresses are available from DHCPv6.
+	Managed bool
+
+	// Other tells other configuration is available from DHCPv6.
+	Other bool
+
+	// RouterLifetime is how long the router is a default router, 0 if
+	// it is none.
+	RouterLifetime time.Duration
+
+	ReachableTime time.Duration
+	RetransTimer  time.Duration
+
+	// SourceLinkAddr is the hardware address of the router.
+	SourceLinkAddr net.HardwareAddr
+
+	// MTU of the link, 0 if not announced.
+	MTU uint32
+
+	Prefixes []Prefix
+
+	// DNS are the recursive DNS servers (RFC 8106), valid for
+	// DNSLifetime.
+	DNS         []net.IP
+	DNSLifetime time.Duration
+
+	// Search is the DNS search list (RFC 8106), valid for
+	// SearchLifetime.
+	Search         []string
+	SearchLifetime time.Duration
+}
+
+// seconds returns the lifetime in seconds at b.
+func seconds(b []byte) time.Duration {
+	return time.Duration(binary.BigEndian.Uint32(b)) * time.Second
+}
+
+// ParseRouterAdvertisement parses the ICMPv6 message b, including its
+// ICMP header.
+func ParseRouterAdvertisement(b []byte) (*RouterAdvertisement, error) {
+	if len(b) < raHeaderLen {
+		return nil, errShort
+	}
+	if ipv6.ICMPType(b[0]) != ipv6.ICMPTypeRouterAdvertisement || b[1] != 0 {
+		return nil, fmt.Errorf("%w: type %d code %d", errNotRA, b[0], b[1])
+	}
+	ra := &RouterAdvertisement{
+		HopLimit:       b[4],
+		Managed:        b[5]&0x80 != 0,
+		Other:          b[5]&0x40 != 0,
+		RouterLifetime: time.Duration(binary.BigEndian.Uint16(b[6:8])) * time.Second,
+		ReachableTime:  time.Duration(binary.BigEndian.Uint32(b[8:12])) * time.Millisecond,
+		RetransTimer:   time.Duration(binary.BigEndian.Uint32(b[12:16])) * time.Millisecond,
+	}
+	for opts := b[raHeaderLen:]; len(opts) > 0; {
+		if len(opts) < 2 || opts[1] == 0 || int(opts[1])*8 > len(opts) {
+			return nil, errOptions
+		}
+		typ, o := opts[0], opts[2:int(opts[1])*8]
+		opts = opts[int(opts[1])*8:]
+		switch typ {
+		case optSourceLinkAddr:
+			ra.SourceLinkAddr = net.HardwareAddr(append([]byte(nil), o[:6]...))
+		case optPrefixInfo:
+			if len(o) < 30 || o[0] > 128 {
+				return nil, fmt.Errorf("%w: prefix information", errOptions)
+			}
+			ip := net.IP(append([]byte(nil), o[14:30]...))
+			mask := net.CIDRMask(int(o[0]), 128)
+			ra.Prefixes = append(ra.Prefixes, Prefix{
+				Prefix:            &net.IPNet{IP: ip.Mask(mask), Mask: mask},
+				OnLink:            o[1]&0x80 != 0,
+				Autonomous:        o[1]&0x40 != 0,
+				ValidLifetime:     seconds(o[2:6]),
+				PreferredLifetime: seconds(o[6:10]),
+			})
+		case optMTU:
+			ra.MTU = binary.BigEndian.Uint32(o[2:6])
+		case optRDNSS:
+			if len(o) < 22 {
+				return nil, fmt.Errorf("%w: RDNSS", errOptions)
+			}
+			ra.DNSLifetime = seconds(o[2:6])
+			for a := o[6:]; len(a) >= net.IPv6len; a = a[net.IPv6len:] {
+				ra.DNS = append(ra.DNS, net.IP(append([]byte(nil), a[:net.IPv6len]...)))
+			}
+		case optDNSSL:
+			ra.SearchLifetime = seconds(o[2:6])
+			labels, err := rfc1035label.FromBytes(o[6:])
+			if err != nil {
+				return nil, fmt.Errorf("%w: DNSSL: %w", errOptions, err)
+			}
+			// The option is padded with zeros, which parse as empty names.
+			for _, l := range labels.Labels {
+				if l != "" {
+					ra.Search = append(ra.Search, l)
+				}
+			}
+		}
+	}
+	return ra, nil
+}
+
+// option appends the option typ with data, padded to 8 bytes, to b.
+func option(b []byte, typ byte, data []byte) []byte {
+	n := (2 + len(data) + 7) / 8
+	b = append(b, typ, byte(n))
+	b = append(b, data...)
+	return append(b, make([]byte, n*8-2-len(data))...)
+}
+
+// lifetime returns d in seconds as the 4 bytes of an option.
+func lifetime(d time.Duration) []byte {
+	return binary.BigEndian.AppendUint32(nil, uint32(d/time.Second))
+}
+
+// Marshal returns ra as ICMPv6 message. The checksum is left for the
+// kernel to fill in.
+func (ra *RouterAdvertisement) Marshal() []byte {
+	b := make([]byte, raHeaderLen)
+	b[0] = byte(ipv6.ICMPTypeRouterAdvertisement)
+	b[4] = ra.HopLimit
+	if ra.Managed {
+		b[5] |= 0x80
+	}
+	if ra.Other {
+		b[5] |= 0x40
+	}
+	binary.BigEndian.PutUint16(b[6:8], uint16(ra.RouterLifetime/time.Second))
+	binary.BigEndian.PutUint32(b[8:12], uint32(ra.ReachableTime/time.Millisecond))
+	binary.BigEndian.PutUint32(b[12:16], uint32(ra.RetransTimer/time.Millisecond))
+
+	if ra.SourceLinkAddr != nil {
+		b = option(b, optSourceLinkAddr, ra.SourceLinkAddr)
+	}
+	if ra.MTU != 0 {
+		b = option(b, optMTU, binary.BigEndian.AppendUint32(make([]byte, 2), ra.MTU))
+	}
+	for _, p := range ra.Prefixes {
+		ones, _ := p.Prefix.Mask.Size()
+		o := []byte{byte(ones), 0}
+		if p.OnLink {
+			o[1] |= 0x80
+		}
+		if p.Autonomous {
+			o[1] |= 0x40
+		}
+		o = append(o, lifetime(p.ValidLifetime)...)
+		o = append(o, lifetime(p.PreferredLifetime)...)
+		o = append(o, make([]byte, 4)...)
+		b = option(b, optPrefixInfo, append(o, p.Prefix.IP.To16()...))
+	}
+	if ra.DNS != nil {
+		o := append(make([]byte, 2), lifetime(ra.DNSLifetime)...)
+		for _, ip := range ra.DNS {
+			o = append(o, ip.To16()...)
+		}
+		b = option(b, optRDNSS, o)
+	}
+	if ra.Search != nil {
+		o := append(make([]byte, 2), lifetime(ra.SearchLifetime)...)
+		labels := rfc1035label.Labels{Labels: ra.Search}
+		b = option(b, optDNSSL, append(o, labels.ToBytes()...))
+	}
+	return b
+}
+
+// routerSolicitation returns an ICMPv6 router solicitation, RFC 4861
+// Section 4.1. It goes without source link-layer address option, which
+// is not allowed while the interface has no link-local address yet.
+func routerSolicitation() []byte {
+	b := make([]byte, 8)
+	b[0] = byte(ipv6.ICMPTypeRouterSolicitation)
+	return b
+}
+
+// EUI64 returns the address in prefix with the modified EUI-64 interface
+// identifier of mac, RFC 4291 Appendix A. prefix must be a /64.
+func EUI64(prefix *net.IPNet, mac net.HardwareAddr) (net.IP, error) {
+	if ones, bits := prefix.Mask.Size(); ones != 64 || bits != 128 {
+		return nil, fmt.Errorf("prefix %v is not a /64", prefix)
+	}
+	if len(mac) != 6 {
+		return nil, fmt.Errorf("hardware address %v is not a MAC-48", mac)
+	}
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, prefix.IP.To16()[:8])
+	ip[8] = mac[0] ^ 0x02
+	ip[9], ip[10] = mac[1], mac[2]
+	ip[11], ip[12] = 0xff, 0xfe
+	ip[13], ip[14], ip[15] = mac[3], mac[4], mac[5]
+	return ip, nil
+}
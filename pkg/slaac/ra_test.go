@@ -0,0 +1,122 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package slaac
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func mustCIDR(t *testing.T, s string) *net.IPNet {
+	t.Helper()
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func testRA(t *testing.T) *RouterAdvertisement {
+	return &RouterAdvertisement{
+		HopLimit:       64,
+		Other:          true,
+		RouterLifetime: 30 * time.Minute,
+		ReachableTime:  30 * time.Second,
+		RetransTimer:   time.Second,
+		SourceLinkAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01},
+		MTU:            1480,
+		Prefixes: []Prefix{
+			{
+				Prefix:            mustCIDR(t, "2001:db8:1::/64"),
+				OnLink:            true,
+				Autonomous:        true,
+				ValidLifetime:     Infinity,
+				PreferredLifetime: time.Hour,
+			},
+			{
+				Prefix:            mustCIDR(t, "2001:db8:2::/48"),
+				OnLink:            true,
+				ValidLifetime:     time.Hour,
+				PreferredLifetime: time.Hour,
+			},
+		},
+		DNS:            []net.IP{net.ParseIP("2001:db8::53"), net.ParseIP("2001:db8::54")},
+		DNSLifetime:    time.Hour,
+		Search:         []string{"example.com", "lab.example.org"},
+		SearchLifetime: time.Hour,
+	}
+}
+
+func TestRouterAdvertisement(t *testing.T) {
+	ra := testRA(t)
+	b := ra.Marshal()
+	if len(b)%8 != 0 {
+		t.Errorf("Marshal() = %d bytes, want options padded to 8 bytes", len(b))
+	}
+	got, err := ParseRouterAdvertisement(b)
+	if err != nil {
+		t.Fatalf("ParseRouterAdvertisement() = %v", err)
+	}
+	if !reflect.DeepEqual(got, ra) {
+		t.Errorf("ParseRouterAdvertisement() = %+v, want %+v", got, ra)
+	}
+}
+
+func TestParseRouterAdvertisementErrors(t *testing.T) {
+	b := testRA(t).Marshal()
+	for _, tt := range []struct {
+		name string
+		b    []byte
+		want error
+	}{
+		{name: "short", b: b[:10], want: errShort},
+		{name: "solicitation", b: routerSolicitation(), want: errShort},
+		{name: "type", b: append([]byte{128}, b[1:]...), want: errNotRA},
+		{name: "truncated option", b: b[:len(b)-8], want: errOptions},
+		{name: "zero length option", b: append(append([]byte{}, b[:raHeaderLen]...), 1, 0, 0, 0, 0, 0, 0, 0), want: errOptions},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseRouterAdvertisement(tt.b); !errors.Is(err, tt.want) {
+				t.Errorf("ParseRouterAdvertisement() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEUI64(t *testing.T) {
+	ip, err := EUI64(mustCIDR(t, "2001:db8:1::/64"), net.HardwareAddr{0x00, 0x1b, 0x21, 0xab, 0xcd, 0xef})
+	if err != nil {
+		t.Fatalf("EUI64() = %v", err)
+	}
+	if want := net.ParseIP("2001:db8:1::21b:21ff:feab:cdef"); !ip.Equal(want) {
+		t.Errorf("EUI64() = %v, want %v", ip, want)
+	}
+	if _, err := EUI64(mustCIDR(t, "2001:db8::/48"), net.HardwareAddr{0, 1, 2, 3, 4, 5}); err == nil {
+		t.Errorf("EUI64() of a /48 = nil, want an error")
+	}
+	if _, err := EUI64(mustCIDR(t, "2001:db8::/64"), nil); err == nil {
+		t.Errorf("EUI64() without MAC = nil, want an error")
+	}
+}
+
+func TestAddresses(t *testing.T) {
+	a := &Advertisement{
+		RouterAdvertisement: testRA(t),
+		Interface:           &net.Interface{HardwareAddr: net.HardwareAddr{0x00, 0x1b, 0x21, 0xab, 0xcd, 0xef}},
+	}
+	addrs := a.Addresses()
+	if len(addrs) != 1 {
+		t.Fatalf("Addresses() = %v, want 1 address", addrs)
+	}
+	if got, want := addrs[0].IPNet.String(), "2001:db8:1:0:21b:21ff:feab:cdef/64"; got != want {
+		t.Errorf("Addresses() = %s, want %s", got, want)
+	}
+	if addrs[0].PreferedLft != 3600 || addrs[0].ValidLft != 0xffffffff {
+		t.Errorf("lifetimes = %d, %d, want 3600, forever", addrs[0].PreferedLft, addrs[0].ValidLft)
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package slaac configures IPv6 from router advertisements: addresses by
+// stateless address autoconfiguration (RFC 4862), the default route, and
+// the DNS servers and search list (RFC 8106), without DHCPv6.
+package slaac
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/u-root/u-root/pkg/dhclient"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	// SolicitationInterval is the time between router solicitations,
+	// RFC 4861 Section 10.
+	SolicitationInterval = 4 * time.Second
+
+	// Solicitations is how many router solicitations are sent by
+	// default.
+	Solicitations = 3
+)
+
+var (
+	errNoRouter = errors.New("no router advertisement received")
+	allRouters  = net.ParseIP("ff02::2")
+)
+
+// Advertisement is a router advertisement received on an interface.
+type Advertisement struct {
+	*RouterAdvertisement
+
+	// Router is the link-local address of the router.
+	Router net.IP
+
+	// Interface is the interface the advertisement was received on.
+	Interface *net.Interface
+}
+
+// Solicit sends up to attempts router solicitations on ifname, waiting
+// SolicitationInterval for an advertisement after each, and returns the
+// first advertisement received.
+func Solicit(ctx context.Context, ifname string, attempts int) (*Advertisement, error) {
+	ifi, err := net.InterfaceByName(ifname)
+	if err != nil {
+		return nil, err
+	}
+	c, err := icmp.ListenPacket("ip6:ipv6-icmp", "::")
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	p := c.IPv6PacketConn()
+
+	var f ipv6.ICMPFilter
+	f.SetAll(true)
+	f.Accept(ipv6.ICMPTypeRouterAdvertisement)
+	if err := p.SetICMPFilter(&f); err != nil {
+		return nil, err
+	}
+	if err := p.SetControlMessage(ipv6.FlagHopLimit|ipv6.FlagInterface, true); err != nil {
+		return nil, err
+	}
+	// Neighbor discovery messages are only accepted if they were not
+	// forwarded, RFC 4861 Section 6.1.
+	if err := p.SetMulticastHopLimit(255); err != nil {
+		return nil, err
+	}
+	if err := p.SetMulticastInterface(ifi); err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() { p.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	rs := routerSolicitation()
+	dst := &net.IPAddr{IP: allRouters, Zone: ifname}
+	b := make([]byte, 1500)
+	for i := 0; i < attempts; i++ {
+		if _, err := p.WriteTo(rs, &ipv6.ControlMessage{HopLimit: 255, IfIndex: ifi.Index}, dst); err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(SolicitationInterval)
+		if err := p.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+		for {
+			n, cm, src, err := p.ReadFrom(b)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			var nerr net.Error
+			if errors.As(err, &nerr) && nerr.Timeout() {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if a := accept(b[:n], cm, src, ifi); a != nil {
+				return a, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w on %s", errNoRouter, ifname)
+}
+
+// accept returns the advertisement in b if it is a valid one received
+// on ifi, RFC 4861 Section 6.1.2.
+func accept(b []byte, cm *ipv6.ControlMessage, src net.Addr, ifi *net.Interface) *Advertisement {
+	if cm == nil || cm.IfIndex != ifi.Index || cm.HopLimit != 255 {
+		return nil
+	}
+	addr, ok := src.(*net.IPAddr)
+	if !ok || !addr.IP.IsLinkLocalUnicast() {
+		return nil
+	}
+	ra, err := ParseRouterAdvertisement(b)
+	if err != nil {
+		return nil
+	}
+	return &Advertisement{RouterAdvertisement: ra, Router: addr.IP, Interface: ifi}
+}
+
+// Addresses returns the addresses to configure for a, those of the
+// autonomous /64 prefixes with the EUI-64 interface identifier of the
+// interface, with their lifetimes.
+func (a *Advertisement) Addresses() []*netlink.Addr {
+	var addrs []*netlink.Addr
+	for _, p := range a.Prefixes {
+		if !p.Autonomous || p.ValidLifetime == 0 || p.PreferredLifetime > p.ValidLifetime {
+			continue
+		}
+		ip, err := EUI64(p.Prefix, a.Interface.HardwareAddr)
+		if err != nil {
+			continue
+		}
+		addrs = append(addrs, &netlink.Addr{
+			IPNet:       &net.IPNet{IP: ip, Mask: p.Prefix.Mask},
+			PreferedLft: int(p.PreferredLifetime / time.Second),
+			ValidLft:    int(p.ValidLifetime / time.Second),
+		})
+	}
+	return addrs
+}
+
+// Configure adds the addresses of a and the routes to its on-link
+// prefixes, the default route via its router if it is a default router,
+// and sets its MTU, with h, which may be nil to use the network namespace
+// of the caller. The DNS configuration is left to WriteDNS.
+func (a *Advertisement) Configure(h *netlink.Handle) error {
+	if h == nil {
+		h = &netlink.Handle{}
+	}
+	link, err := h.LinkByIndex(a.Interface.Index)
+	if err != nil {
+		return err
+	}
+	addrs := a.Addresses()
+	for _, addr := range addrs {
+		if err := h.AddrReplace(link, addr); err != nil {
+			return fmt.Errorf("adding %v to %s: %w", addr.IPNet, a.Interface.Name, err)
+		}
+	}
+	for _, p := range a.Prefixes {
+		if !p.OnLink || p.ValidLifetime == 0 || hasAddr(p.Prefix, addrs) {
+			continue
+		}
+		route := &netlink.Route{LinkIndex: link.Attrs().Index, Dst: p.Prefix, Scope: netlink.SCOPE_LINK}
+		if err := h.RouteReplace(route); err != nil {
+			return fmt.Errorf("adding route to %v: %w", p.Prefix, err)
+		}
+	}
+	if a.RouterLifetime > 0 {
+		route := &netlink.Route{
+			LinkIndex: link.Attrs().Index,
+			Gw:        a.Router,
+			Family:    netlink.FAMILY_V6,
+		}
+		if err := h.RouteReplace(route); err != nil {
+			return fmt.Errorf("adding default route via %v: %w", a.Router, err)
+		}
+	}
+	if a.MTU >= 1280 {
+		// Only IPv6 takes the MTU of the link from the router.
+		path := filepath.Join("/proc/sys/net/ipv6/conf", a.Interface.Name, "mtu")
+		if err := os.WriteFile(path, []byte(strconv.Itoa(int(a.MTU))), 0o644); err != nil {
+			return fmt.Errorf("setting MTU of %s: %w", a.Interface.Name, err)
+		}
+	}
+	return nil
+}
+
+// hasAddr tells whether one of addrs is in prefix, which brings the
+// route to prefix along.
+func hasAddr(prefix *net.IPNet, addrs []*netlink.Addr) bool {
+	for _, a := range addrs {
+		if prefix.Contains(a.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteDNS writes the DNS servers and search list of a to the
+// resolv.conf at path, unless there are none.
+func (a *Advertisement) WriteDNS(path string) error {
+	if a.DNS == nil || a.DNSLifetime == 0 {
+		return nil
+	}
+	var search []string
+	if a.SearchLifetime > 0 {
+		search = a.Search
+	}
+	return dhclient.WriteDNSSettings(a.DNS, search, "", path)
+}
@@ -0,0 +1,205 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package slaac
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+)
+
+// newLink runs the rest of the test in a new network namespace with the
+// veth pair host0 and router0, and returns it with a handle in it.
+func newLink(t *testing.T) (netns.NsHandle, *netlink.Handle) {
+	t.Helper()
+	runtime.LockOSThread()
+	t.Cleanup(runtime.UnlockOSThread)
+	orig, err := netns.Get()
+	if err != nil {
+		t.Skipf("no network namespaces: %v", err)
+	}
+	t.Cleanup(func() { orig.Close() })
+	ns, err := netns.New()
+	if err != nil {
+		t.Skipf("cannot create a network namespace: %v", err)
+	}
+	t.Cleanup(func() {
+		netns.Set(orig)
+		ns.Close()
+	})
+	h, err := netlink.NewHandleAt(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(h.Close)
+
+	// Skip duplicate address detection to use the link-local addresses
+	// right away, and keep the kernel from autoconfiguring itself.
+	for k, v := range map[string]string{"accept_dad": "0", "accept_ra": "0"} {
+		if err := os.WriteFile(filepath.Join("/proc/sys/net/ipv6/conf/default", k), []byte(v), 0o644); err != nil {
+			t.Skipf("cannot configure IPv6: %v", err)
+		}
+	}
+	veth := &netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{Name: "host0", HardwareAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 0x0a}},
+		PeerName:  "router0",
+	}
+	if err := h.LinkAdd(veth); err != nil {
+		t.Skipf("cannot create veth pair: %v", err)
+	}
+	for _, name := range []string{"host0", "router0"} {
+		l, err := h.LinkByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := h.LinkSetUp(l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ns, h
+}
+
+// router answers router solicitations on ifname in ns with ra until ctx
+// is done. It returns once it listens.
+func router(ctx context.Context, t *testing.T, ns netns.NsHandle, ifname string, ra *RouterAdvertisement) {
+	ready := make(chan struct{})
+	go func() {
+		// The thread ends with the goroutine, there is no need to
+		// return it to its namespace.
+		runtime.LockOSThread()
+		if err := netns.Set(ns); err != nil {
+			t.Error(err)
+			close(ready)
+			return
+		}
+		ifi, err := net.InterfaceByName(ifname)
+		if err != nil {
+			t.Error(err)
+			close(ready)
+			return
+		}
+		c, err := icmp.ListenPacket("ip6:ipv6-icmp", "::")
+		if err != nil {
+			t.Error(err)
+			close(ready)
+			return
+		}
+		context.AfterFunc(ctx, func() { c.Close() })
+		p := c.IPv6PacketConn()
+		var f ipv6.ICMPFilter
+		f.SetAll(true)
+		f.Accept(ipv6.ICMPTypeRouterSolicitation)
+		p.SetICMPFilter(&f)
+		p.SetControlMessage(ipv6.FlagInterface, true)
+		p.SetMulticastHopLimit(255)
+		// Hosts are not in the all-routers group.
+		if err := p.JoinGroup(ifi, &net.IPAddr{IP: allRouters}); err != nil {
+			t.Error(err)
+		}
+		close(ready)
+
+		b := make([]byte, 1500)
+		for {
+			_, cm, _, err := p.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			if cm == nil || cm.IfIndex != ifi.Index {
+				continue
+			}
+			dst := &net.IPAddr{IP: net.IPv6linklocalallnodes, Zone: ifname}
+			if _, err := p.WriteTo(ra.Marshal(), &ipv6.ControlMessage{HopLimit: 255, IfIndex: ifi.Index}, dst); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	<-ready
+}
+
+func TestSolicit(t *testing.T) {
+	ns, h := newLink(t)
+	ra := testRA(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	router(ctx, t, ns, "router0", ra)
+
+	a, err := Solicit(ctx, "host0", 2)
+	if err != nil {
+		t.Fatalf("Solicit() = %v", err)
+	}
+	if !a.Router.IsLinkLocalUnicast() || len(a.Prefixes) != 2 || a.RouterLifetime != ra.RouterLifetime {
+		t.Fatalf("Solicit() = %+v, want the advertisement of the router", a)
+	}
+	if err := a.Configure(h); err != nil {
+		t.Fatalf("Configure() = %v", err)
+	}
+
+	host0, err := h.LinkByName("host0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs, err := h.AddrList(host0, netlink.FAMILY_V6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := net.ParseIP("2001:db8:1::ff:fe00:a")
+	var found bool
+	for _, addr := range addrs {
+		found = found || addr.IP.Equal(want)
+	}
+	if !found {
+		t.Errorf("host0 addresses = %v, want %v", addrs, want)
+	}
+
+	routes, err := h.RouteList(host0, netlink.FAMILY_V6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotDefault, gotOnLink bool
+	for _, r := range routes {
+		if r.Dst == nil && r.Gw.Equal(a.Router) {
+			gotDefault = true
+		}
+		if r.Dst != nil && r.Dst.String() == "2001:db8:2::/48" {
+			gotOnLink = true
+		}
+	}
+	if !gotDefault || !gotOnLink {
+		t.Errorf("host0 routes = %v, want a default route via %v and one to 2001:db8:2::/48", routes, a.Router)
+	}
+	if b, err := os.ReadFile("/proc/sys/net/ipv6/conf/host0/mtu"); err != nil || string(b) != "1480\n" {
+		t.Errorf("host0 IPv6 MTU = %q, %v, want 1480", b, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	if err := a.WriteDNS(path); err != nil {
+		t.Fatalf("WriteDNS() = %v", err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), "nameserver 2001:db8::53\nnameserver 2001:db8::54\nsearch example.com lab.example.org\n"; got != want {
+		t.Errorf("resolv.conf = %q, want %q", got, want)
+	}
+}
+
+func TestSolicitTimeout(t *testing.T) {
+	newLink(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := Solicit(ctx, "host0", 1); err != context.DeadlineExceeded {
+		t.Errorf("Solicit() = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tftp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/ulog"
+	"pack.ag/tftp"
+)
+
+// ErrInvalidName is returned for file names that do not name a file of the
+// served directory.
+var ErrInvalidName = errors.New("invalid file name")
+
+// FileHandler serves the files of Dir to TFTP clients, and receives files
+// into Dir if Writable. The blksize and tsize options of RFC 2348 and RFC
+// 2349 are negotiated by the tftp.Server it handles the requests of.
+type FileHandler struct {
+	Dir      string
+	Writable bool
+
+	// Log logs the transfers, and their errors, if not nil.
+	Log ulog.Logger
+}
+
+func (h *FileHandler) logf(format string, v ...any) {
+	if h.Log != nil {
+		h.Log.Printf(format, v...)
+	}
+}
+
+// Path returns the path of the file name within Dir. Leading slashes, as
+// PXE clients send, are ignored, and names cannot escape Dir with "..".
+func (h *FileHandler) Path(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if name == "" || strings.ContainsRune(name, 0) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	rel := filepath.Clean("/" + name)
+	if rel == "/" {
+		return "", fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	return filepath.Join(h.Dir, rel), nil
+}
+
+// ServeTFTP sends the file requested by r.
+func (h *FileHandler) ServeTFTP(r tftp.ReadRequest) {
+	p, err := h.Path(r.Name())
+	if err != nil {
+		h.logf("%v: read %q: %v", r.Addr(), r.Name(), err)
+		r.WriteError(tftp.ErrCodeAccessViolation, err.Error())
+		return
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		h.logf("%v: read %q: %v", r.Addr(), r.Name(), err)
+		r.WriteError(tftp.ErrCodeFileNotFound, fmt.Sprintf("file %q not found", r.Name()))
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		h.logf("%v: read %q: not a regular file", r.Addr(), r.Name())
+		r.WriteError(tftp.ErrCodeFileNotFound, fmt.Sprintf("file %q not found", r.Name()))
+		return
+	}
+	r.WriteSize(fi.Size())
+	n, err := io.Copy(r, f)
+	if err != nil {
+		h.logf("%v: read %q: %v", r.Addr(), r.Name(), err)
+		return
+	}
+	h.logf("%v: sent %q, %d bytes", r.Addr(), r.Name(), n)
+}
+
+// ReceiveTFTP writes the file sent by w, if h is Writable.
+func (h *FileHandler) ReceiveTFTP(w tftp.WriteRequest) {
+	if !h.Writable {
+		h.logf("%v: write %q: not writable", w.Addr(), w.Name())
+		w.WriteError(tftp.ErrCodeAccessViolation, "writes are not allowed")
+		return
+	}
+	p, err := h.Path(w.Name())
+	if err != nil {
+		h.logf("%v: write %q: %v", w.Addr(), w.Name(), err)
+		w.WriteError(tftp.ErrCodeAccessViolation, err.Error())
+		return
+	}
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		h.logf("%v: write %q: %v", w.Addr(), w.Name(), err)
+		w.WriteError(tftp.ErrCodeAccessViolation, fmt.Sprintf("cannot create file %q", w.Name()))
+		return
+	}
+	n, err := io.Copy(f, w)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		h.logf("%v: write %q: %v", w.Addr(), w.Name(), err)
+		return
+	}
+	h.logf("%v: received %q, %d bytes", w.Addr(), w.Name(), n)
+}
@@ -0,0 +1,167 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tftp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"pack.ag/tftp"
+)
+
+func TestFileHandlerPath(t *testing.T) {
+	h := &FileHandler{Dir: "/srv/tftp"}
+	for _, tt := range []struct {
+		name string
+		want string
+		err  error
+	}{
+		{name: "pxelinux.0", want: "/srv/tftp/pxelinux.0"},
+		{name: "/pxelinux.cfg/default", want: "/srv/tftp/pxelinux.cfg/default"},
+		{name: "efi\\grubx64.efi", want: "/srv/tftp/efi/grubx64.efi"},
+		{name: "../../etc/passwd", want: "/srv/tftp/etc/passwd"},
+		{name: "a/../../b", want: "/srv/tftp/b"},
+		{name: "", err: ErrInvalidName},
+		{name: "/", err: ErrInvalidName},
+		{name: "..", err: ErrInvalidName},
+		{name: "a\x00b", err: ErrInvalidName},
+	} {
+		got, err := h.Path(tt.name)
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Errorf("Path(%q) = %q, %v, want %q, %v", tt.name, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestValidateBlksize(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want int
+		err  error
+	}{
+		{in: "1428", want: 1428},
+		{in: "8", want: 8},
+		{in: "65464", want: 65464},
+		{in: "7", err: ErrInvalidBlksize},
+		{in: "65465", err: ErrInvalidBlksize},
+		{in: "big", err: ErrInvalidBlksize},
+	} {
+		got, err := ValidateBlksize(tt.in)
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Errorf("ValidateBlksize(%q) = %d, %v, want %d, %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+// serveDir serves h on a local port, returning its host and port.
+func serveDir(t *testing.T, h *FileHandler) (string, string) {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Skipf("no loopback: %v", err)
+	}
+	s, err := tftp.NewServer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.ReadHandler(h)
+	s.WriteHandler(h)
+	go s.Serve(conn)
+	t.Cleanup(func() { s.Close() })
+	host, port, err := net.SplitHostPort(conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return host, port
+}
+
+func TestFileHandler(t *testing.T) {
+	dir := t.TempDir()
+	// Larger than a block of most sizes, and not a multiple of them.
+	nbp := bytes.Repeat([]byte("network boot program "), 1000)
+	if err := os.WriteFile(filepath.Join(dir, "pxelinux.0"), nbp, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "pxelinux.cfg"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &FileHandler{Dir: dir}
+	host, port := serveDir(t, h)
+
+	for _, blksize := range []int{0, 1428, 8192} {
+		t.Run(fmt.Sprintf("get blksize %d", blksize), func(t *testing.T) {
+			c, err := NewClient(&ClientCfg{Mode: tftp.ModeOctet, Rexmt: tftp.ClientRetransmit(3), Timeout: tftp.ClientTimeout(1), Blksize: blksize})
+			if err != nil {
+				t.Fatal(err)
+			}
+			local := filepath.Join(t.TempDir(), "nbp")
+			if err := executeGet(c, host, port, []string{"/pxelinux.0", local}); err != nil {
+				t.Fatalf("get = %v", err)
+			}
+			got, err := os.ReadFile(local)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, nbp) {
+				t.Errorf("got %d bytes, want the %d of pxelinux.0", len(got), len(nbp))
+			}
+		})
+	}
+
+	c, err := NewClient(&ClientCfg{Mode: tftp.ModeOctet, Rexmt: tftp.ClientRetransmit(3), Timeout: tftp.ClientTimeout(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"missing", "pxelinux.cfg"} {
+		if _, err := c.Get(constructURL(host, port, "", name)); err == nil {
+			t.Errorf("Get(%q) = nil, want an error", name)
+		}
+	}
+
+	upload := []byte("uploaded log")
+	if err := c.Put(constructURL(host, port, "", "log"), bytes.NewReader(upload), int64(len(upload))); err == nil {
+		t.Errorf("Put to a read-only handler = nil, want an error")
+	}
+	h.Writable = true
+	if err := c.Put(constructURL(host, port, "", "log"), bytes.NewReader(upload), int64(len(upload))); err != nil {
+		t.Fatalf("Put = %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "log"))
+	if err != nil || !bytes.Equal(got, upload) {
+		t.Errorf("uploaded log = %q, %v, want %q", got, err, upload)
+	}
+}
+
+// sizeResp is a Response of the wrong size.
+type sizeResp struct {
+	io.Reader
+	size int64
+}
+
+func (r *sizeResp) Size() (int64, error) {
+	return r.size, nil
+}
+
+type sizeClient struct {
+	ClientMock
+}
+
+func (*sizeClient) Get(string) (Response, error) {
+	return &sizeResp{Reader: bytes.NewReader([]byte("short")), size: 100}, nil
+}
+
+func TestGetSizeMismatch(t *testing.T) {
+	local := filepath.Join(t.TempDir(), "f")
+	if err := executeGet(&sizeClient{}, "localhost", "69", []string{"f", local}); !errors.Is(err, errSizeNoMatch) {
+		t.Errorf("executeGet = %v, want %v", err, errSizeNoMatch)
+	}
+}
@@ -22,6 +22,7 @@ type Flags struct {
 	Cmd       string
 	Mode      string
 	PortRange string
+	Blksize   int
 	Literal   bool
 	Verbose   bool
 }
@@ -34,6 +35,9 @@ type ClientCfg struct {
 	Mode    tftp.TransferMode
 	Rexmt   tftp.ClientOpt
 	Timeout tftp.ClientOpt
+	// Blksize is the block size requested with the blksize option of RFC
+	// 2348, 512 bytes without it if 0.
+	Blksize int
 	Trace   bool
 	Literal bool
 	Verbose bool
@@ -65,6 +69,7 @@ func RunInteractive(f Flags, ipPort []string, stdin io.Reader, stdout io.Writer)
 		Mode:    tftp.ModeNetASCII,
 		Rexmt:   tftp.ClientRetransmit(10),
 		Timeout: tftp.ClientTimeout(1),
+		Blksize: f.Blksize,
 		Trace:   false,
 		Literal: f.Literal,
 	}
@@ -140,11 +145,20 @@ func ExecuteOp(input []string, clientcfg *ClientCfg, stdout io.Writer) (bool, er
 			statusString(clientcfg.Trace),
 			statusString(clientcfg.Literal),
 		)
+		fmt.Fprintf(stdout, "Blksize: %s\n", blksizeString(clientcfg.Blksize))
 	case "timeout":
 		var val int
 		val, err = strconv.Atoi(input[1])
 
 		clientcfg.Timeout = tftp.ClientTimeout(val)
+	case "blksize":
+		if len(input) > 1 {
+			var val int
+			if val, err = ValidateBlksize(input[1]); err == nil {
+				clientcfg.Blksize = val
+			}
+		}
+		fmt.Fprintf(stdout, "Block size is %s.\n", blksizeString(clientcfg.Blksize))
 	case "trace":
 		clientcfg.Trace = !clientcfg.Trace
 		fmt.Fprintf(stdout, "Packet tracing %s.\n", statusString(clientcfg.Trace))
@@ -176,6 +190,13 @@ func statusString(state bool) string {
 	return "off"
 }
 
+func blksizeString(blksize int) string {
+	if blksize == 0 {
+		return "512 (default)"
+	}
+	return strconv.Itoa(blksize)
+}
+
 func printHelp() string {
 	var s strings.Builder
 
@@ -193,6 +214,7 @@ func printHelp() string {
 	fmt.Fprintf(&s, "ascii\tset mode to netascii\n")
 	fmt.Fprintf(&s, "rexmt\tset per-packet transmission timeout\n")
 	fmt.Fprintf(&s, "timeout\tset total retransmission timeout\n")
+	fmt.Fprintf(&s, "blksize\tset the block size to request, 8 to 65464 bytes\n")
 	fmt.Fprintf(&s, "?\t\tprint help information\n")
 	fmt.Fprintf(&s, "help\tprint help information\n")
 	return s.String()
@@ -210,6 +232,19 @@ func readHostInteractive(in *bufio.Scanner, out io.Writer) string {
 	return in.Text()
 }
 
+// ErrInvalidBlksize is returned by ValidateBlksize for block sizes out of
+// the range of RFC 2348.
+var ErrInvalidBlksize = errors.New("invalid block size")
+
+// ValidateBlksize parses a block size of 8 to 65464 bytes.
+func ValidateBlksize(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 8 || n > 65464 {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidBlksize, s)
+	}
+	return n, nil
+}
+
 // ErrInvalidTransferMode is returned by ValidateMode in case
 // the provided mode string has no matching tftp.TransferMode.
 var ErrInvalidTransferMode = errors.New("invalid transfer mode")
@@ -303,44 +338,38 @@ func executeGet(client ClientIf, host, port string, files []string) error {
 	}
 
 	for _, file := range ret.remotefiles {
-		resp, err := client.Get(constructURL(host, port, "", file))
-		if err != nil {
-			return err
-		}
-
-		localfile, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY, 0o666)
-		if err != nil {
-			return nil
-		}
-		defer localfile.Close()
-
-		if ret.localfile != "" && len(ret.remotefiles) == 1 {
-			localfile, err = os.OpenFile(ret.localfile, os.O_CREATE|os.O_WRONLY, 0o666)
-			if err != nil {
-				return err
-			}
-		}
-
-		datalen, err := resp.Size()
-		if err != nil {
+		if err := getFile(client, constructURL(host, port, "", file), file, ret.localfile, len(ret.remotefiles)); err != nil {
 			return err
 		}
+	}
 
-		data := make([]byte, datalen)
-		nR, err := resp.Read(data)
-		if err != nil {
-			return err
-		}
+	return nil
+}
 
-		nW, err := localfile.Write(data)
-		if err != nil {
-			return err
-		}
+// getFile gets url into file, or into localfile if it is the only one.
+// The size of the file is checked against the one sent with the tsize
+// option of RFC 2349, if the server sent one.
+func getFile(client ClientIf, url, file, localfile string, n int) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
 
-		if nR != nW {
-			return errSizeNoMatch
-		}
+	if localfile != "" && n == 1 {
+		file = localfile
+	}
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o666)
+	if err != nil {
+		return err
 	}
+	defer f.Close()
 
-	return nil
+	nW, err := io.Copy(f, resp)
+	if err != nil {
+		return err
+	}
+	if size, err := resp.Size(); err == nil && size != 0 && size != nW {
+		return fmt.Errorf("%w: got %d bytes, want %d", errSizeNoMatch, nW, size)
+	}
+	return f.Close()
 }
@@ -0,0 +1,233 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCymruServer is Team Cymru's IP to ASN whois service.
+const DefaultCymruServer = "whois.cymru.com:43"
+
+var errNoASN = errors.New("no origin AS known")
+
+// ASNResolver returns the number of the AS originating the prefix an
+// address belongs to.
+type ASNResolver interface {
+	LookupASN(ip net.IP) (uint32, error)
+}
+
+// CymruResolver looks up origin ASes with Team Cymru's whois service.
+type CymruResolver struct {
+	// Server is the host:port of the whois server, DefaultCymruServer if
+	// empty.
+	Server string
+	// Timeout bounds the whole lookup, connecting included.
+	Timeout time.Duration
+}
+
+// LookupASN implements ASNResolver.
+func (c *CymruResolver) LookupASN(ip net.IP) (uint32, error) {
+	server := c.Server
+	if server == "" {
+		server = DefaultCymruServer
+	}
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DEFLOOKUPSEC * time.Second
+	}
+
+	conn, err := net.DialTimeout("tcp", server, timeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return 0, err
+	}
+
+	if _, err := fmt.Fprintf(conn, "begin\nnoheader\n%s\nend\n", ip); err != nil {
+		return 0, err
+	}
+	return parseCymru(conn)
+}
+
+// parseCymru parses a whois answer like
+//
+//	15169   | 8.8.8.8          | GOOGLE, US
+//
+// where unknown addresses have NA as AS.
+func parseCymru(r io.Reader) (uint32, error) {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		fields := strings.Split(s.Text(), "|")
+		if len(fields) < 2 {
+			continue
+		}
+		as := strings.TrimSpace(fields[0])
+		if as == "NA" {
+			return 0, errNoASN
+		}
+		n, err := strconv.ParseUint(as, 10, 32)
+		if err != nil {
+			continue
+		}
+		return uint32(n), nil
+	}
+	if err := s.Err(); err != nil {
+		return 0, err
+	}
+	return 0, errNoASN
+}
+
+type asnPrefix struct {
+	net *net.IPNet
+	asn uint32
+}
+
+// PrefixDB is a local prefix to ASN database, for when there is no
+// route to a whois server.
+type PrefixDB struct {
+	// prefixes is sorted longest prefix first.
+	prefixes []asnPrefix
+}
+
+// ReadPrefixDB reads a prefix to ASN database. Each line holds a prefix
+// in CIDR notation and an AS number, optionally prefixed with "AS":
+//
+//	8.8.8.0/24 15169
+//	2001:4860::/32 AS15169
+//
+// Empty lines and lines starting with # are ignored.
+func ReadPrefixDB(r io.Reader) (*PrefixDB, error) {
+	db := &PrefixDB{}
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: want prefix and ASN, got %q", line, text)
+		}
+		_, n, err := net.ParseCIDR(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		as, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(fields[1]), "AS"), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		db.prefixes = append(db.prefixes, asnPrefix{net: n, asn: uint32(as)})
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(db.prefixes, func(i, j int) bool {
+		li, _ := db.prefixes[i].net.Mask.Size()
+		lj, _ := db.prefixes[j].net.Mask.Size()
+		return li > lj
+	})
+	return db, nil
+}
+
+// LoadPrefixDB reads a prefix to ASN database from a file.
+func LoadPrefixDB(path string) (*PrefixDB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadPrefixDB(f)
+}
+
+// LookupASN implements ASNResolver with a longest prefix match.
+func (db *PrefixDB) LookupASN(ip net.IP) (uint32, error) {
+	for _, p := range db.prefixes {
+		if p.net.Contains(ip) {
+			return p.asn, nil
+		}
+	}
+	return 0, errNoASN
+}
+
+type asnResult struct {
+	asn uint32
+	err error
+}
+
+// ASNCache remembers the answers, failures included, of another
+// resolver.
+type ASNCache struct {
+	Resolver ASNResolver
+
+	mu    sync.Mutex
+	cache map[string]asnResult
+}
+
+// LookupASN implements ASNResolver.
+func (c *ASNCache) LookupASN(ip net.IP) (uint32, error) {
+	key := ip.String()
+	c.mu.Lock()
+	r, ok := c.cache[key]
+	c.mu.Unlock()
+	if ok {
+		return r.asn, r.err
+	}
+
+	asn, err := c.Resolver.LookupASN(ip)
+	c.mu.Lock()
+	if c.cache == nil {
+		c.cache = map[string]asnResult{}
+	}
+	c.cache[key] = asnResult{asn: asn, err: err}
+	c.mu.Unlock()
+	return asn, err
+}
+
+// annotateASN looks up the origin AS of every distinct public hop
+// address.
+func annotateASN(printMap map[int]*Probe, r ASNResolver) {
+	forEachHopAddr(printMap, func(pbs []*Probe) {
+		ip := pbs[0].Saddr
+		if ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+			return
+		}
+		asn, err := r.LookupASN(ip)
+		if err != nil {
+			return
+		}
+		for _, pb := range pbs {
+			pb.ASN = asn
+		}
+	})
+}
+
+// newASNResolver returns the resolver selected by f, nil if AS lookups
+// are off.
+func newASNResolver(f *Flags) (ASNResolver, error) {
+	if f.ASNDB != "" {
+		db, err := LoadPrefixDB(f.ASNDB)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	}
+	if f.ASN {
+		return &ASNCache{Resolver: &CymruResolver{Timeout: f.LookupTimeout}}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute_test
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/u-root/u-root/pkg/traceroute"
+)
+
+func TestPrefixDB(t *testing.T) {
+	db, err := traceroute.ReadPrefixDB(strings.NewReader(`
+# comment
+8.0.0.0/8 3356
+8.8.8.0/24 AS15169
+2001:4860::/32 15169
+`))
+	if err != nil {
+		t.Fatalf("ReadPrefixDB() = %v", err)
+	}
+
+	for _, tt := range []struct {
+		ip      string
+		asn     uint32
+		wantErr bool
+	}{
+		{ip: "8.8.8.8", asn: 15169},
+		{ip: "8.8.4.4", asn: 3356},
+		{ip: "2001:4860:4860::8888", asn: 15169},
+		{ip: "192.0.2.1", wantErr: true},
+	} {
+		asn, err := db.LookupASN(net.ParseIP(tt.ip))
+		if (err != nil) != tt.wantErr || asn != tt.asn {
+			t.Errorf("LookupASN(%s) = %d, %v, want %d, error %t", tt.ip, asn, err, tt.asn, tt.wantErr)
+		}
+	}
+
+	if _, err := traceroute.ReadPrefixDB(strings.NewReader("8.8.8.0/24\n")); err == nil {
+		t.Errorf("ReadPrefixDB() with missing ASN = nil, want error")
+	}
+}
+
+// fakeWhois answers Cymru bulk queries, knowing only 8.8.8.8.
+func fakeWhois(t *testing.T) (string, *atomic.Int32) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("no loopback: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	queries := new(atomic.Int32)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			queries.Add(1)
+			s := bufio.NewScanner(conn)
+			for s.Scan() {
+				switch ip := s.Text(); ip {
+				case "begin", "noheader":
+				case "end":
+					conn.Close()
+				case "8.8.8.8":
+					fmt.Fprintf(conn, "15169   | %-16s | GOOGLE, US\n", ip)
+				default:
+					fmt.Fprintf(conn, "NA      | %-16s | NA\n", ip)
+				}
+			}
+		}
+	}()
+	return ln.Addr().String(), queries
+}
+
+func TestCymruResolver(t *testing.T) {
+	addr, queries := fakeWhois(t)
+	r := &traceroute.ASNCache{Resolver: &traceroute.CymruResolver{Server: addr, Timeout: 5 * time.Second}}
+
+	for i := 0; i < 2; i++ {
+		asn, err := r.LookupASN(net.ParseIP("8.8.8.8"))
+		if err != nil || asn != 15169 {
+			t.Errorf("LookupASN(8.8.8.8) = %d, %v, want 15169, nil", asn, err)
+		}
+	}
+	if _, err := r.LookupASN(net.ParseIP("192.0.2.1")); err == nil {
+		t.Errorf("LookupASN(192.0.2.1) = nil, want error")
+	}
+	if n := queries.Load(); n != 2 {
+		t.Errorf("whois server saw %d queries, want 2", n)
+	}
+}
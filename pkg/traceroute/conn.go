@@ -0,0 +1,284 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+var errReplyConn = errors.New("reply connections can not send probes")
+
+// Packet is a probe ready to be sent.
+type Packet struct {
+	// IPv4 is the header IPv4 probes are sent with as is.
+	IPv4 *ipv4.Header
+	// IPv6 holds the hop limit and traffic class of IPv6 probes.
+	IPv6 *ipv6.ControlMessage
+	// Payload is the transport segment or ICMP message following the IP
+	// header.
+	Payload []byte
+	// DontFragment forbids fragmenting IPv6 probes. IPv4 probes set the
+	// flag in their header instead.
+	DontFragment bool
+	// FlowLabel is the flow label of IPv6 probes, zero to leave it to
+	// the kernel.
+	FlowLabel uint32
+}
+
+// packet6 returns the IPv6 probe of flow, sent with the don't fragment
+// setting and flow label of t.
+func (t *Trace) packet6(cm *ipv6.ControlMessage, payload []byte, flow int) *Packet {
+	return &Packet{
+		IPv6:         cm,
+		Payload:      payload,
+		DontFragment: t.Options.DontFragment,
+		FlowLabel:    t.flowLabel(flow),
+	}
+}
+
+// ProbeConn sends probes and reads the replies to them. The raw
+// sockets of a trace are ProbeConns, so that tests can substitute a
+// fake network.
+type ProbeConn interface {
+	// WritePacket sends p to dst.
+	WritePacket(p *Packet, dst net.IP) error
+	// ReadReply reads the next message received into b, without its IP
+	// header. It returns the length of the message and its source.
+	ReadReply(b []byte) (int, net.IP, error)
+	SetReadDeadline(t time.Time) error
+	Close() error
+}
+
+// ReplyInfo is what the kernel tells about a message received.
+type ReplyInfo struct {
+	// At is the time the message was received, which unlike the time
+	// it is read is not skewed by how soon the reader is scheduled.
+	At time.Time
+	// TTL is the TTL or hop limit the message arrived with, zero if
+	// unknown.
+	TTL int
+}
+
+// ReplyInfoConn is a ProbeConn that tells when and with which TTL each
+// message was received.
+type ReplyInfoConn interface {
+	ProbeConn
+	// ReadReplyInfo is ReadReply that also returns what is known about
+	// the message received.
+	ReadReplyInfo(b []byte) (int, net.IP, ReplyInfo, error)
+}
+
+// readReply reads the next message from c together with its ReplyInfo if
+// c is a ReplyInfoConn. Otherwise the message is timed when read and its
+// TTL is unknown.
+func readReply(c ProbeConn, b []byte) (int, net.IP, ReplyInfo, error) {
+	if ic, ok := c.(ReplyInfoConn); ok {
+		return ic.ReadReplyInfo(b)
+	}
+	n, from, err := c.ReadReply(b)
+	return n, from, ReplyInfo{At: time.Now()}, err
+}
+
+// Network opens the connections of a trace.
+type Network interface {
+	// ProbeConn opens a connection to send probes of network on, e.g.
+	// "ip4:udp".
+	ProbeConn(network string) (ProbeConn, error)
+	// ReplyConn opens a connection to read messages of network
+	// addressed to laddr from, e.g. "ip6:ipv6-icmp". A nil laddr reads
+	// messages to any address.
+	ReplyConn(network string, laddr net.IP) (ProbeConn, error)
+}
+
+// network returns the Network of t, raw sockets unless set. With
+// Capture set, its traffic is recorded.
+func (t *Trace) network() Network {
+	var n Network = &rawNetwork{src: t.SrcIP, iface: t.Interface}
+	if t.Network != nil {
+		n = t.Network
+	}
+	if t.Capture != nil {
+		return &captureNetwork{Network: n, w: t.Capture, local: t.SrcIP}
+	}
+	return n
+}
+
+// rawNetwork opens raw IP sockets. Probe sockets are bound to src and,
+// if set, to the interface iface.
+type rawNetwork struct {
+	src   net.IP
+	iface string
+}
+
+func (n *rawNetwork) ProbeConn(network string) (ProbeConn, error) {
+	var lc net.ListenConfig
+	var addr string
+	if n.src != nil {
+		addr = n.src.String()
+	}
+	if n.iface != "" {
+		lc.Control = func(_, _ string, rc syscall.RawConn) error {
+			return bindToDevice(rc, n.iface)
+		}
+		if n.src.To4() == nil && n.src.IsLinkLocalUnicast() {
+			addr += "%" + n.iface
+		}
+	}
+	c, err := lc.ListenPacket(context.Background(), network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(network, "ip4") {
+		rc, err := ipv4.NewRawConn(c)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		return &rawConn4{rawConn: rawConn{PacketConn: c}, raw: rc}, nil
+	}
+
+	pc := ipv6.NewPacketConn(c)
+	// Raw IPv6 sockets do not compute upper layer checksums unless told
+	// where to put them. The kernel always does for ICMPv6.
+	var offset int
+	switch network {
+	case "ip6:udp":
+		offset = udp6ChecksumOffset
+	case "ip6:tcp":
+		offset = tcp6ChecksumOffset
+	}
+	if offset != 0 {
+		if err := pc.SetChecksum(true, offset); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return &rawConn6{rawConn: rawConn{PacketConn: c}, pc: pc}, nil
+}
+
+func (n *rawNetwork) ReplyConn(network string, laddr net.IP) (ProbeConn, error) {
+	var addr *net.IPAddr
+	if laddr != nil {
+		addr = &net.IPAddr{IP: laddr}
+	}
+	c, err := net.ListenIP(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	// Without kernel timestamps, answers are timed when read, and
+	// without hop limits, the TTL of IPv6 ones is unknown.
+	info := enableReplyInfo(c, strings.HasPrefix(network, "ip6")) == nil
+	return &rawConn{PacketConn: c, info: info}, nil
+}
+
+// rawConn reads from a raw IP socket. Reads on IPv4 sockets strip the
+// IP header, IPv6 sockets never return it.
+type rawConn struct {
+	net.PacketConn
+	// info is set if the kernel reports the receive time and the IPv6
+	// hop limit of messages in control messages.
+	info bool
+}
+
+func (c *rawConn) WritePacket(*Packet, net.IP) error {
+	return errReplyConn
+}
+
+func (c *rawConn) ReadReply(b []byte) (int, net.IP, error) {
+	n, addr, err := c.ReadFrom(b)
+	if err != nil {
+		return 0, nil, err
+	}
+	return n, addr.(*net.IPAddr).IP, nil
+}
+
+func (c *rawConn) ReadReplyInfo(b []byte) (int, net.IP, ReplyInfo, error) {
+	ipc, ok := c.PacketConn.(*net.IPConn)
+	if !ok {
+		n, from, err := c.ReadReply(b)
+		return n, from, ReplyInfo{At: time.Now()}, err
+	}
+	var oob [128]byte
+	n, oobn, _, addr, err := ipc.ReadMsgIP(b, oob[:])
+	ri := ReplyInfo{At: time.Now()}
+	if err != nil {
+		return 0, nil, ri, err
+	}
+	// Unlike ReadFrom, ReadMsgIP leaves the IPv4 header in place.
+	if addr.IP.To4() != nil {
+		n, ri.TTL = stripIPv4Header(b, n)
+	}
+	if c.info {
+		parseReplyInfo(oob[:oobn], &ri)
+	}
+	return n, addr.IP, ri, nil
+}
+
+// stripIPv4Header removes the IPv4 header in front of the n bytes of
+// message in b. It returns the length of the rest and the TTL of the
+// header, zero if there is none.
+func stripIPv4Header(b []byte, n int) (int, int) {
+	if n < ipv4.HeaderLen || b[0]>>4 != ipv4.Version {
+		return n, 0
+	}
+	l := int(b[0]&0x0f) << 2
+	if l < ipv4.HeaderLen || l > n {
+		return n, 0
+	}
+	ttl := int(b[8])
+	copy(b, b[l:n])
+	return n - l, ttl
+}
+
+// rawConn4 sends IPv4 probes together with their own IP header.
+type rawConn4 struct {
+	rawConn
+	raw *ipv4.RawConn
+}
+
+func (c *rawConn4) WritePacket(p *Packet, _ net.IP) error {
+	return c.raw.WriteTo(p.IPv4, p.Payload, nil)
+}
+
+// rawConn6 sends IPv6 probes, the kernel builds their IP header.
+type rawConn6 struct {
+	rawConn
+	pc *ipv6.PacketConn
+	df bool
+	// labels are the flow labels leased for the socket.
+	labels map[uint32]bool
+}
+
+func (c *rawConn6) WritePacket(p *Packet, dst net.IP) error {
+	if p.DontFragment && !c.df {
+		if err := setDontFragment6(c.PacketConn); err != nil {
+			return err
+		}
+		c.df = true
+	}
+	if p.FlowLabel == 0 {
+		_, err := c.pc.WriteTo(p.Payload, p.IPv6, &net.IPAddr{IP: dst})
+		return err
+	}
+	if !c.labels[p.FlowLabel] {
+		if err := leaseFlowLabel6(c.PacketConn, dst, p.FlowLabel); err != nil {
+			return err
+		}
+		if c.labels == nil {
+			c.labels = map[uint32]bool{}
+		}
+		c.labels[p.FlowLabel] = true
+	}
+	return writeFlowLabel6(c.PacketConn, p, dst)
+}
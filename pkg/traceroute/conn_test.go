@@ -0,0 +1,445 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+// fakeReply is a message delivered to a reply connection.
+type fakeReply struct {
+	msg  []byte
+	from net.IP
+	ttl  int
+}
+
+// fakeNetwork is an IPv4 path through routers to dest. Probes with a
+// TTL too small to reach dest expire at the router the TTL runs out at.
+// UDP probes reaching dest are answered with Port Unreachable, ICMP
+// echo requests with echo replies, TCP probes with RSTs as by a closed
+// port and SCTP INITs with INIT ACKs. Without a dest every probe is lost.
+type fakeNetwork struct {
+	routers []net.IP
+	dest    net.IP
+	// mtu is the MTU of the links behind the first router, zero for no
+	// limit. The router answers larger probes that must not be
+	// fragmented with Fragmentation Needed.
+	mtu int
+	// returnHops is how many hops more than the probe took its answer
+	// takes back.
+	returnHops int
+	// ipID, unless zero, is the IP ID a NAT in front of the routers
+	// rewrites every probe to.
+	ipID int
+	// natHop, unless zero, is the first hop that quotes probes with their
+	// source rewritten to natSrc and their source port raised by 1000.
+	natHop  int
+	natSrc  net.IP
+	replies chan fakeReply
+	// tcpReplies and sctpReplies are delivered to TCP and SCTP reply
+	// connections.
+	tcpReplies  chan fakeReply
+	sctpReplies chan fakeReply
+	// dstUnreach is the code of the Destination Unreachable answers of
+	// dest to UDP probes, Port Unreachable unless a test changes it.
+	dstUnreach uint8
+}
+
+func newFakeNetwork(dest net.IP, routers ...net.IP) *fakeNetwork {
+	return &fakeNetwork{
+		routers:     routers,
+		dest:        dest,
+		replies:     make(chan fakeReply, 256),
+		tcpReplies:  make(chan fakeReply, 256),
+		sctpReplies: make(chan fakeReply, 256),
+		dstUnreach:  ICMP4PortUnreach,
+	}
+}
+
+func (n *fakeNetwork) ProbeConn(network string) (ProbeConn, error) {
+	return &fakeConn{n: n, done: make(chan struct{})}, nil
+}
+
+func (n *fakeNetwork) ReplyConn(network string, laddr net.IP) (ProbeConn, error) {
+	c := &fakeConn{n: n, done: make(chan struct{})}
+	switch network {
+	case "ip4:icmp":
+		c.replies = n.replies
+	case "ip4:tcp":
+		c.replies = n.tcpReplies
+	case "ip4:132":
+		c.replies = n.sctpReplies
+	}
+	return c, nil
+}
+
+// replyTTL returns the TTL an answer from hop arrives with, sent with an
+// initial TTL of 64.
+func (n *fakeNetwork) replyTTL(hop int) int {
+	return 64 - (hop - 1) - n.returnHops
+}
+
+func (n *fakeNetwork) send(p *Packet) error {
+	if n.dest == nil {
+		return nil
+	}
+	h := p.IPv4
+	if h == nil {
+		return errors.New("fake network only carries IPv4")
+	}
+	// Routers record themselves while forwarding the probe.
+	if len(h.Options) > 3 && h.Options[0] == IPOptRecordRoute {
+		opts := append([]byte{}, h.Options...)
+		for _, r := range n.routers[:min(h.TTL-1, len(n.routers))] {
+			if p := int(opts[2]); p+3 <= int(opts[1]) {
+				copy(opts[p-1:], r.To4())
+				opts[2] += 4
+			}
+		}
+		hc := *h
+		hc.Options = opts
+		h = &hc
+	}
+	if n.ipID != 0 {
+		hc := *h
+		hc.ID = n.ipID
+		h = &hc
+	}
+	l4 := append([]byte{}, p.Payload[:8]...)
+	if n.natHop != 0 && min(h.TTL, len(n.routers)+1) >= n.natHop {
+		hc := *h
+		hc.Src = n.natSrc
+		h = &hc
+		binary.BigEndian.PutUint16(l4, binary.BigEndian.Uint16(l4)+1000)
+	}
+	quoted, err := h.Marshal()
+	if err != nil {
+		return err
+	}
+	quoted = append(quoted, l4...)
+
+	if n.mtu > 0 && h.TTL > 1 && h.TotalLen > n.mtu && h.Flags&ipv4.DontFragment != 0 {
+		msg := append([]byte{ICMP4DstUnreach, ICMP4FragNeeded, 0, 0, 0, 0, byte(n.mtu >> 8), byte(n.mtu)}, quoted...)
+		n.replies <- fakeReply{msg: msg, from: n.routers[0], ttl: n.replyTTL(1)}
+		return nil
+	}
+	if h.TTL <= len(n.routers) {
+		msg := append([]byte{ICMP4TimeExceeded, ICMP4TTLExcd, 0, 0, 0, 0, 0, 0}, quoted...)
+		n.replies <- fakeReply{msg: msg, from: n.routers[h.TTL-1], ttl: n.replyTTL(h.TTL)}
+		return nil
+	}
+	switch h.Protocol {
+	case 17:
+		msg := append([]byte{ICMP4DstUnreach, n.dstUnreach, 0, 0, 0, 0, 0, 0}, quoted...)
+		n.replies <- fakeReply{msg: msg, from: n.dest, ttl: n.replyTTL(len(n.routers) + 1)}
+	case 1:
+		msg := append([]byte{}, p.Payload...)
+		msg[0] = ICMP4EchoReply
+		n.replies <- fakeReply{msg: msg, from: n.dest, ttl: n.replyTTL(len(n.routers) + 1)}
+	case 6:
+		probe, err := ParseTCP(p.Payload)
+		if err != nil {
+			return err
+		}
+		rst := TCPHeader{Src: probe.Dst, Dst: probe.Src, DataOffset: 5 << 4, Flags: TCP_RST}
+		if probe.Flags&TCP_ACK != 0 {
+			rst.SeqNum = probe.AckNum
+		} else {
+			rst.Flags |= TCP_ACK
+			rst.AckNum = probe.SeqNum + 1
+		}
+		n.tcpReplies <- fakeReply{msg: rst.Marshal(), from: n.dest, ttl: n.replyTTL(len(n.routers) + 1)}
+	case sctpProto:
+		hdr, _, err := ParseSCTP(p.Payload)
+		if err != nil {
+			return err
+		}
+		msg := buildSCTPInit(hdr.Dst, hdr.Src, 0)
+		binary.BigEndian.PutUint32(msg[4:8], binary.BigEndian.Uint32(p.Payload[sctpTagOffset:]))
+		msg[12] = SCTPChunkInitAck
+		n.sctpReplies <- fakeReply{msg: msg, from: n.dest, ttl: n.replyTTL(len(n.routers) + 1)}
+	}
+	return nil
+}
+
+type fakeConn struct {
+	n *fakeNetwork
+	// replies are the messages read, nil for connections never read.
+	replies chan fakeReply
+
+	mu       sync.Mutex
+	deadline time.Time
+	once     sync.Once
+	done     chan struct{}
+}
+
+func (c *fakeConn) WritePacket(p *Packet, dst net.IP) error {
+	return c.n.send(p)
+}
+
+func (c *fakeConn) ReadReply(b []byte) (int, net.IP, error) {
+	n, from, _, err := c.ReadReplyInfo(b)
+	return n, from, err
+}
+
+func (c *fakeConn) ReadReplyInfo(b []byte) (int, net.IP, ReplyInfo, error) {
+	if c.replies == nil {
+		<-c.done
+		return 0, nil, ReplyInfo{}, net.ErrClosed
+	}
+	c.mu.Lock()
+	var timeout <-chan time.Time
+	if !c.deadline.IsZero() {
+		timeout = time.After(time.Until(c.deadline))
+	}
+	c.mu.Unlock()
+	select {
+	case r := <-c.replies:
+		return copy(b, r.msg), r.from, ReplyInfo{At: time.Now(), TTL: r.ttl}, nil
+	case <-timeout:
+		return 0, nil, ReplyInfo{}, errors.New("i/o timeout")
+	case <-c.done:
+		return 0, nil, ReplyInfo{}, net.ErrClosed
+	}
+}
+
+func (c *fakeConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	return nil
+}
+
+func (c *fakeConn) Close() error {
+	c.once.Do(func() { close(c.done) })
+	return nil
+}
+
+func TestTraceFakeNetwork(t *testing.T) {
+	src := net.IPv4(192, 0, 2, 100)
+	dest := net.IPv4(198, 51, 100, 1)
+	routers := []net.IP{
+		net.IPv4(192, 0, 2, 1),
+		net.IPv4(203, 0, 113, 1),
+		net.IPv4(203, 0, 113, 2),
+	}
+
+	for _, tt := range []struct {
+		proto string
+		send  func(*Trace, context.Context) error
+	}{
+		{proto: "udp4", send: (*Trace).SendTracesUDP4},
+		{proto: "icmp4", send: (*Trace).SendTracesICMP4},
+		{proto: "sctp4", send: (*Trace).SendTracesSCTP4},
+	} {
+		t.Run(tt.proto, func(t *testing.T) {
+			cc := Coms{
+				SendChan: make(chan *Probe),
+				RecvChan: make(chan *Probe),
+			}
+			tr := NewTrace(tt.proto, dest, src, cc, &Flags{TracerouteOptions: TracerouteOptions{
+				MaxTTL:       10,
+				ProbesPerHop: 2,
+				Interval:     time.Millisecond,
+				Timeout:      time.Second,
+			}})
+			tr.Network = newFakeNetwork(dest.To4(), routers...)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go tt.send(tr, ctx)
+			printMap := runTransmission(ctx, cc, 1, tr.Options.Timeout, nil)
+
+			if got, want := DestTTL(printMap), len(routers)+1; got != want {
+				t.Fatalf("DestTTL() = %d, want %d", got, want)
+			}
+			for ttl := 1; ttl <= len(routers)+1; ttl++ {
+				want := dest
+				if ttl <= len(routers) {
+					want = routers[ttl-1]
+				}
+				// The trace ends with the first answer of the
+				// destination.
+				pbs := GetProbesByTLL(printMap, ttl)
+				if n := len(pbs); n != 2 && !(ttl > len(routers) && n == 1) {
+					t.Errorf("TTL %d: %d answered probes, want 2", ttl, n)
+				}
+				for _, pb := range pbs {
+					if !pb.Saddr.Equal(want) {
+						t.Errorf("TTL %d answered by %v, want %v", ttl, pb.Saddr, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestPathMTUFakeNetwork(t *testing.T) {
+	dest := net.IPv4(198, 51, 100, 1)
+	n := newFakeNetwork(dest.To4(), net.IPv4(192, 0, 2, 1))
+	tr := &Trace{
+		DestIP:   dest.To4(),
+		SrcIP:    net.IPv4(192, 0, 2, 100).To4(),
+		destPort: DEFUDPPORT,
+		Options:  TracerouteOptions{FirstTTL: 1, MaxTTL: 5, ProbesPerHop: 1, Timeout: time.Second},
+		Network:  n,
+	}
+	hops, err := tr.DiscoverPathMTU(context.Background())
+	if err != nil {
+		t.Fatalf("DiscoverPathMTU() = %v", err)
+	}
+	if len(hops) != 2 || !hops[1].Reached || !hops[1].Addr.Equal(dest) {
+		t.Fatalf("DiscoverPathMTU() = %+v, want 2 hops ending at %v", hops, dest)
+	}
+	if hops[1].PMTU != interfaceMTU(tr.SrcIP) {
+		t.Errorf("path MTU = %d, want %d", hops[1].PMTU, interfaceMTU(tr.SrcIP))
+	}
+}
+
+// failingNetwork fails to open any connection with err.
+type failingNetwork struct {
+	err error
+}
+
+func (n failingNetwork) ProbeConn(string) (ProbeConn, error) {
+	return nil, &net.OpError{Op: "listen", Net: "ip4:udp", Err: os.NewSyscallError("socket", n.err)}
+}
+
+func (n failingNetwork) ReplyConn(string, net.IP) (ProbeConn, error) {
+	return n.ProbeConn("")
+}
+
+func TestSendErrors(t *testing.T) {
+	for _, tt := range []struct {
+		errno syscall.Errno
+		want  error
+	}{
+		{errno: syscall.EPERM, want: ErrPermission},
+		{errno: syscall.EACCES, want: ErrPermission},
+		{errno: syscall.ENETUNREACH, want: ErrNetworkUnreachable},
+		{errno: syscall.EHOSTUNREACH, want: ErrNetworkUnreachable},
+	} {
+		t.Run(tt.errno.Error(), func(t *testing.T) {
+			cc := Coms{
+				SendChan: make(chan *Probe),
+				RecvChan: make(chan *Probe),
+			}
+			ip := net.IPv4(192, 0, 2, 1)
+			tr := NewTrace("udp4", ip, ip, cc, &Flags{})
+			tr.Network = failingNetwork{err: tt.errno}
+
+			errc := make(chan error, 1)
+			go func() { errc <- tr.SendTracesUDP4(context.Background()) }()
+			// Nothing was sent, so there is nothing to wait for.
+			if pm := runTransmission(context.Background(), cc, 1, time.Hour, nil); len(pm) != 0 {
+				t.Errorf("runTransmission() = %v, want no answers", pm)
+			}
+			err := <-errc
+			if !errors.Is(err, tt.want) || !errors.Is(err, tt.errno) {
+				t.Errorf("SendTracesUDP4() = %v, want %v wrapping %v", err, tt.want, tt.errno)
+			}
+		})
+	}
+}
+
+func TestTraceCanceled(t *testing.T) {
+	for _, tt := range []struct {
+		proto string
+		send  func(*Trace, context.Context) error
+	}{
+		{proto: "udp4", send: (*Trace).SendTracesUDP4},
+		{proto: "tcp4", send: (*Trace).SendTracesTCP4},
+		{proto: "icmp4", send: (*Trace).SendTracesICMP4},
+		{proto: "sctp4", send: (*Trace).SendTracesSCTP4},
+	} {
+		t.Run(tt.proto, func(t *testing.T) {
+			cc := Coms{
+				SendChan: make(chan *Probe),
+				RecvChan: make(chan *Probe),
+			}
+			ip := net.IPv4(192, 0, 2, 1)
+			tr := NewTrace(tt.proto, ip, ip, cc, &Flags{TracerouteOptions: TracerouteOptions{
+				MaxTTL:       MAXHOPS,
+				ProbesPerHop: 3,
+				Interval:     time.Hour,
+				Timeout:      time.Hour,
+			}})
+			tr.Network = newFakeNetwork(nil)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			errc := make(chan error, 1)
+			go func() { errc <- tt.send(tr, ctx) }()
+			time.AfterFunc(10*time.Millisecond, cancel)
+			runTransmission(ctx, cc, 1, time.Hour, nil)
+
+			select {
+			case err := <-errc:
+				if !errors.Is(err, context.Canceled) {
+					t.Errorf("send = %v, want %v", err, context.Canceled)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("send did not return after cancel")
+			}
+			done := make(chan struct{})
+			go func() {
+				tr.Wait()
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatalf("receive loops did not end after cancel")
+			}
+		})
+	}
+}
+
+func TestStripIPv4Header(t *testing.T) {
+	hdr := ipv4Header(net.IPv4(192, 0, 2, 1), net.IPv4(192, 0, 2, 2), 1, 64, 4)
+	for _, tt := range []struct {
+		name string
+		b    []byte
+		want []byte
+		ttl  int
+	}{
+		{name: "Header", b: append(hdr, 1, 2, 3, 4), want: []byte{1, 2, 3, 4}, ttl: 64},
+		{name: "Short", b: hdr[:10], want: hdr[:10]},
+		{name: "NotIPv4", b: []byte{0x60, 0, 0, 0}, want: []byte{0x60, 0, 0, 0}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b := append([]byte{}, tt.b...)
+			if n, ttl := stripIPv4Header(b, len(b)); string(b[:n]) != string(tt.want) || ttl != tt.ttl {
+				t.Errorf("stripIPv4Header() = %x, %d, want %x, %d", b[:n], ttl, tt.want, tt.ttl)
+			}
+		})
+	}
+}
+
+func TestReadReplyInfo(t *testing.T) {
+	replies := make(chan fakeReply, 2)
+	fc := &fakeConn{replies: replies, done: make(chan struct{})}
+	from := net.IPv4(192, 0, 2, 1)
+
+	replies <- fakeReply{msg: []byte{1}, from: from, ttl: 60}
+	if _, _, ri, err := readReply(fc, make([]byte, 1)); err != nil || ri.TTL != 60 {
+		t.Errorf("readReply() = %+v, %v, want TTL 60", ri, err)
+	}
+	// Connections that tell nothing are timed when read.
+	before := time.Now()
+	replies <- fakeReply{msg: []byte{1}, from: from, ttl: 60}
+	if _, _, ri, err := readReply(struct{ ProbeConn }{fc}, make([]byte, 1)); err != nil || ri.TTL != 0 || ri.At.Before(before) {
+		t.Errorf("readReply() = %+v, %v, want the time it was read and no TTL", ri, err)
+	}
+}
@@ -15,11 +15,15 @@ const (
 
 	DEFPORT    = 0
 	DEFTCPPORT = 80
+	DEFUDPPORT = 33434
 
 	DEFWAITSEC    = 5
 	DEFHEREFACTOR = 3
 	DEFNEARFACTOR = 10
 	DEFSENDSECS   = 0
+	DEFLOOKUPSEC  = 2
+
+	DEFLOOKUPWORKERS = 16
 
 	DEFMODULE = "default"
 
@@ -27,6 +31,7 @@ const (
 	IPV6HdrLen    = 40
 
 	TCPDEFPORT   = 443
+	SCTPDEFPORT  = 80
 	DEFNUMHOPS   = 20
 	DEFNUMTRACES = 3
 )
@@ -0,0 +1,57 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// OutputDOT prints the topology of a trace as a Graphviz graph.
+const OutputDOT = "dot"
+
+// WriteDOT writes topo as a Graphviz DOT digraph called name to w,
+// marking the destination dest. Hops are nodes ranked by TTL, links are edges labeled and
+// weighted with the number of probes that saw them. Render it with e.g.
+//
+//	dot -Tsvg trace.dot > trace.svg
+func (topo *Topology) WriteDOT(w io.Writer, name string, dest string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %q {\n", name)
+	b.WriteString("\trankdir=LR;\n\tnode [shape=box];\n")
+
+	ttls := make([]int, 0, len(topo.Nodes))
+	for ttl := range topo.Nodes {
+		ttls = append(ttls, ttl)
+	}
+	sort.Ints(ttls)
+	// An address answering at several TTLs is a single node, ranked at
+	// the first of them.
+	seen := map[string]bool{}
+	for _, ttl := range ttls {
+		fmt.Fprintf(&b, "\tsubgraph ttl%d {\n\t\trank=same;\n", ttl)
+		for _, addr := range topo.Nodes[ttl] {
+			if seen[addr] {
+				continue
+			}
+			seen[addr] = true
+			shape := ""
+			if addr == dest {
+				shape = ", shape=doubleoctagon"
+			}
+			fmt.Fprintf(&b, "\t\t%q [label=\"%s\\nTTL %d\"%s];\n", addr, addr, ttl, shape)
+		}
+		b.WriteString("\t}\n")
+	}
+	for _, l := range topo.Links {
+		fmt.Fprintf(&b, "\t%q -> %q [label=\"%d\", weight=%d];\n", l.From, l.To, l.Probes, l.Probes)
+	}
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,35 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+var (
+	// ErrPermission is returned if the raw sockets of a trace can not
+	// be opened, usually because the process lacks CAP_NET_RAW.
+	ErrPermission = errors.New("raw sockets not permitted")
+	// ErrNetworkUnreachable is returned if there is no route to the
+	// destination or to pick a source address with.
+	ErrNetworkUnreachable = errors.New("network unreachable")
+)
+
+// sockErr annotates an error of op on a socket, wrapping ErrPermission
+// or ErrNetworkUnreachable if it is either.
+func sockErr(op string, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, os.ErrPermission):
+		return fmt.Errorf("%s: %w: %w", op, ErrPermission, err)
+	case errors.Is(err, syscall.ENETUNREACH), errors.Is(err, syscall.EHOSTUNREACH):
+		return fmt.Errorf("%s: %w: %w", op, ErrNetworkUnreachable, err)
+	}
+	return fmt.Errorf("%s: %w", op, err)
+}
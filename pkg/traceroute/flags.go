@@ -4,18 +4,54 @@
 
 package traceroute
 
+import "time"
+
 type Flags struct {
-	Host         string
-	Proto        string
-	ICMP         bool
-	TCP          bool
-	MaxHops      int
-	DestPortSeq  uint
-	TOS          int
-	ProbesPerHop int
-	Source       string
-	Module       string
-	UDP          bool
+	Host     string
+	Proto    string
+	ICMP     bool
+	TCP      bool
+	SCTP     bool
+	TOS      int
+	Source   string
+	Module   string
+	UDP      bool
+	Strategy ProbeStrategy
+	Flows    int
+	Output   string
+	ASN      bool
+	ASNDB    string
+	// GeoIPDB is a MaxMind DB file to look up the location of hops in.
+	GeoIPDB string
+	// Numeric turns off reverse DNS lookups of hop addresses.
+	Numeric bool
+	// DNSServer is the server to send DNS queries to instead of the
+	// system resolver, both to resolve Host and the names of hops.
+	DNSServer string
+	// AnyFamily traces Host with the other IP version than that of
+	// Proto if Host has no address of Proto's.
+	AnyFamily bool
+	// LookupTimeout bounds each DNS and AS lookup.
+	LookupTimeout time.Duration
+	// MTU discovers the path MTU instead of tracing the route.
+	MTU bool
+	// Interface is the network interface to send probes on. Source,
+	// if set, is the address to send them from.
+	Interface string
+	// Continuous probes the path over and over like mtr, see Monitor.
+	Continuous bool
+	// Cycles is the number of times a continuous trace probes the path,
+	// zero to probe it until cancelled. CycleInterval is the pause
+	// between two cycles.
+	Cycles        int
+	CycleInterval time.Duration
+	// Writer prints the trace instead of the writer of Output.
+	Writer OutputWriter
+	// Pcap is the file to record the probes and the messages received
+	// in, for later analysis.
+	Pcap string
+
+	TracerouteOptions
 }
 
 type Args struct {
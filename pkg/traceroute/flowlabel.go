@@ -0,0 +1,20 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+// MAXFLOWLABEL is the largest IPv6 flow label, RFC 6437.
+const MAXFLOWLABEL = 1<<20 - 1
+
+// flowLabel returns the flow label of the IPv6 probes of flow, zero to
+// leave it to the kernel. A trace of a single path keeps it constant
+// like a Paris trace keeps its ports. Each flow of a multipath trace
+// counts Options.FlowLabel up by one, so that balancers hashing on the
+// label split them like they do on ports.
+func (t *Trace) flowLabel(flow int) uint32 {
+	if t.Options.FlowLabel == 0 {
+		return 0
+	}
+	return (t.Options.FlowLabel-1+uint32(flow))%MAXFLOWLABEL + 1
+}
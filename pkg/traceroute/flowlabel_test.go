@@ -0,0 +1,47 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+func TestFlowLabel(t *testing.T) {
+	for _, tt := range []struct {
+		label uint32
+		flow  int
+		want  uint32
+	}{
+		{label: 0, flow: 3, want: 0},
+		{label: 0x12345, flow: 0, want: 0x12345},
+		{label: 0x12345, flow: 2, want: 0x12347},
+		// Labels wrap around without becoming zero.
+		{label: MAXFLOWLABEL, flow: 1, want: 1},
+	} {
+		tr := &Trace{Options: TracerouteOptions{FlowLabel: tt.label}}
+		if got := tr.flowLabel(tt.flow); got != tt.want {
+			t.Errorf("flowLabel(%d) with label %#x = %#x, want %#x", tt.flow, tt.label, got, tt.want)
+		}
+	}
+}
+
+func TestPacket6FlowLabel(t *testing.T) {
+	tr := &Trace{
+		SrcIP:   net.ParseIP("2001:db8::1"),
+		DestIP:  net.ParseIP("2001:db8::2"),
+		Options: TracerouteOptions{FlowLabel: 0xabcde, DontFragment: true},
+	}
+	cm, pl := tr.BuildUDP6Pkt(1234, 33434, 5, 1, 0)
+	p := tr.packet6(cm, pl, 1)
+	if p.FlowLabel != 0xabcdf || !p.DontFragment {
+		t.Fatalf("packet6() = %+v, want flow label 0xabcdf not to be fragmented", p)
+	}
+	h := ipv6Header(tr.SrcIP, tr.DestIP, 17, cm.HopLimit, cm.TrafficClass, p.FlowLabel, len(pl))
+	if got := binary.BigEndian.Uint32(h) & MAXFLOWLABEL; got != p.FlowLabel {
+		t.Errorf("captured flow label %#x, want %#x", got, p.FlowLabel)
+	}
+}
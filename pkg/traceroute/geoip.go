@@ -0,0 +1,374 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+)
+
+var (
+	errNoGeo       = errors.New("no location known")
+	errMMDBCorrupt = errors.New("corrupt MaxMind database")
+)
+
+// mmdbMetadataMarker precedes the metadata at the end of an MMDB file.
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// Geo is the location of an address.
+type Geo struct {
+	// Country is the ISO 3166-1 code of the country, e.g. "DE".
+	Country string `json:"country,omitempty"`
+	// City is the English name of the city.
+	City string `json:"city,omitempty"`
+}
+
+// String formats g like "DE Berlin".
+func (g Geo) String() string {
+	switch {
+	case g.City == "":
+		return g.Country
+	case g.Country == "":
+		return g.City
+	}
+	return g.Country + " " + g.City
+}
+
+// GeoResolver returns the location of an address.
+type GeoResolver interface {
+	LookupGeo(ip net.IP) (Geo, error)
+}
+
+// MMDB is a MaxMind DB file, such as GeoLite2-City.mmdb, held in memory.
+// It reads the format on its own, so that lookups work offline without
+// any library.
+type MMDB struct {
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	tree       []byte
+	data       []byte
+	// ipv4Start is the node IPv4 addresses start at in an IPv6 tree.
+	ipv4Start uint
+}
+
+// NewMMDB parses the MaxMind DB file b.
+func NewMMDB(b []byte) (*MMDB, error) {
+	i := bytes.LastIndex(b, mmdbMetadataMarker)
+	if i < 0 {
+		return nil, fmt.Errorf("%w: no metadata", errMMDBCorrupt)
+	}
+	v, _, err := (&mmdbDecoder{buf: b[i+len(mmdbMetadataMarker):]}).decode(0)
+	if err != nil {
+		return nil, err
+	}
+	meta, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: metadata is no map", errMMDBCorrupt)
+	}
+	db := &MMDB{
+		nodeCount:  mmdbUint(meta["node_count"]),
+		recordSize: mmdbUint(meta["record_size"]),
+		ipVersion:  mmdbUint(meta["ip_version"]),
+	}
+	switch db.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("%w: record size %d", errMMDBCorrupt, db.recordSize)
+	}
+	if db.ipVersion != 4 && db.ipVersion != 6 {
+		return nil, fmt.Errorf("%w: IP version %d", errMMDBCorrupt, db.ipVersion)
+	}
+	// The search tree is followed by 16 zero bytes and the data section.
+	treeLen := db.nodeCount * db.recordSize / 4
+	if treeLen+16 > uint(i) {
+		return nil, fmt.Errorf("%w: search tree exceeds file", errMMDBCorrupt)
+	}
+	db.tree = b[:treeLen]
+	db.data = b[treeLen+16 : i]
+
+	if db.ipVersion == 6 {
+		node := uint(0)
+		for n := 0; n < 96 && node < db.nodeCount; n++ {
+			node = db.record(node, 0)
+		}
+		db.ipv4Start = node
+	}
+	return db, nil
+}
+
+// LoadMMDB reads a MaxMind DB file.
+func LoadMMDB(path string) (*MMDB, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewMMDB(b)
+}
+
+// record returns the left (bit 0) or right (bit 1) record of node.
+func (db *MMDB) record(node uint, bit byte) uint {
+	size := db.recordSize / 4
+	n := db.tree[node*size : (node+1)*size]
+	switch db.recordSize {
+	case 24:
+		n = n[bit*3:]
+		return uint(n[0])<<16 | uint(n[1])<<8 | uint(n[2])
+	case 28:
+		// The middle byte holds the high nibbles of both records.
+		if bit == 0 {
+			return uint(n[3]>>4)<<24 | uint(n[0])<<16 | uint(n[1])<<8 | uint(n[2])
+		}
+		return uint(n[3]&0x0f)<<24 | uint(n[4])<<16 | uint(n[5])<<8 | uint(n[6])
+	}
+	return uint(binary.BigEndian.Uint32(n[bit*4:]))
+}
+
+// Lookup returns the data record of the network ip belongs to, decoded
+// into maps, slices, strings and numbers.
+func (db *MMDB) Lookup(ip net.IP) (any, error) {
+	addr := ip.To4()
+	node := db.ipv4Start
+	if addr == nil {
+		if db.ipVersion == 4 {
+			return nil, errNoGeo
+		}
+		addr = ip.To16()
+		node = 0
+	}
+	for i := 0; i < len(addr)*8 && node < db.nodeCount; i++ {
+		node = db.record(node, addr[i/8]>>(7-i%8)&1)
+	}
+	switch {
+	case node == db.nodeCount:
+		return nil, errNoGeo
+	case node < db.nodeCount:
+		return nil, fmt.Errorf("%w: search tree deeper than address", errMMDBCorrupt)
+	}
+	v, _, err := (&mmdbDecoder{buf: db.data}).decode(node - db.nodeCount - 16)
+	return v, err
+}
+
+// LookupGeo implements GeoResolver for GeoIP2 and GeoLite2 country and
+// city databases.
+func (db *MMDB) LookupGeo(ip net.IP) (Geo, error) {
+	v, err := db.Lookup(ip)
+	if err != nil {
+		return Geo{}, err
+	}
+	rec, _ := v.(map[string]any)
+	var g Geo
+	if country, ok := rec["country"].(map[string]any); ok {
+		g.Country, _ = country["iso_code"].(string)
+	}
+	if city, ok := rec["city"].(map[string]any); ok {
+		if names, ok := city["names"].(map[string]any); ok {
+			g.City, _ = names["en"].(string)
+		}
+	}
+	if g == (Geo{}) {
+		return Geo{}, errNoGeo
+	}
+	return g, nil
+}
+
+// Types of MMDB data fields.
+const (
+	mmdbExtended = iota
+	mmdbPointer
+	mmdbString
+	mmdbDouble
+	mmdbBytes
+	mmdbUint16
+	mmdbUint32
+	mmdbMap
+	mmdbInt32
+	mmdbUint64
+	mmdbUint128
+	mmdbArray
+	mmdbContainer
+	mmdbEndMarker
+	mmdbBool
+	mmdbFloat
+)
+
+// mmdbDecoder decodes fields of an MMDB data section.
+type mmdbDecoder struct {
+	buf []byte
+}
+
+// bytes returns the n bytes at off.
+func (d *mmdbDecoder) bytes(off, n uint) ([]byte, error) {
+	if off+n > uint(len(d.buf)) || off+n < off {
+		return nil, errMMDBCorrupt
+	}
+	return d.buf[off : off+n], nil
+}
+
+// uint decodes the n byte big endian number at off.
+func (d *mmdbDecoder) uint(off, n uint) (uint64, error) {
+	b, err := d.bytes(off, n)
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+// decode decodes the field at off and returns it with the offset of the
+// field following it.
+func (d *mmdbDecoder) decode(off uint) (any, uint, error) {
+	ctrl, err := d.bytes(off, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	off++
+	typ := uint(ctrl[0] >> 5)
+	if typ == mmdbPointer {
+		// Pointers have a size of their own and point to a field that
+		// is not followed.
+		n := uint(ctrl[0]>>3&3) + 1
+		v, err := d.uint(off, n)
+		if err != nil {
+			return nil, 0, err
+		}
+		ptr := uint(v)
+		switch n {
+		case 1:
+			ptr |= uint(ctrl[0]&7) << 8
+		case 2:
+			ptr = (ptr | uint(ctrl[0]&7)<<16) + 2048
+		case 3:
+			ptr = (ptr | uint(ctrl[0]&7)<<24) + 526336
+		}
+		// Pointers to pointers are invalid, and would allow loops.
+		if t, err := d.bytes(ptr, 1); err != nil || t[0]>>5 == mmdbPointer {
+			return nil, 0, errMMDBCorrupt
+		}
+		v2, _, err := d.decode(ptr)
+		return v2, off + n, err
+	}
+	if typ == mmdbExtended {
+		ext, err := d.bytes(off, 1)
+		if err != nil {
+			return nil, 0, err
+		}
+		typ = 7 + uint(ext[0])
+		off++
+	}
+
+	size := uint(ctrl[0] & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		v, err := d.uint(off, n)
+		if err != nil {
+			return nil, 0, err
+		}
+		off += n
+		switch n {
+		case 1:
+			size = 29 + uint(v)
+		case 2:
+			size = 285 + uint(v)
+		default:
+			size = 65821 + uint(v)
+		}
+	}
+
+	switch typ {
+	case mmdbString, mmdbBytes:
+		b, err := d.bytes(off, size)
+		if err != nil {
+			return nil, 0, err
+		}
+		if typ == mmdbString {
+			return string(b), off + size, nil
+		}
+		return append([]byte{}, b...), off + size, nil
+	case mmdbDouble:
+		v, err := d.uint(off, 8)
+		return math.Float64frombits(v), off + 8, err
+	case mmdbFloat:
+		v, err := d.uint(off, 4)
+		return float64(math.Float32frombits(uint32(v))), off + 4, err
+	case mmdbUint16, mmdbUint32, mmdbUint64:
+		if size > 8 {
+			return nil, 0, errMMDBCorrupt
+		}
+		v, err := d.uint(off, size)
+		return v, off + size, err
+	case mmdbInt32:
+		if size > 4 {
+			return nil, 0, errMMDBCorrupt
+		}
+		v, err := d.uint(off, size)
+		return int64(int32(uint32(v))), off + size, err
+	case mmdbUint128:
+		// Nothing traceroute looks at is that large.
+		b, err := d.bytes(off, size)
+		return append([]byte{}, b...), off + size, err
+	case mmdbBool:
+		return size != 0, off, nil
+	case mmdbMap:
+		m := make(map[string]any, size)
+		for i := uint(0); i < size; i++ {
+			k, next, err := d.decode(off)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("%w: map key is no string", errMMDBCorrupt)
+			}
+			if m[key], off, err = d.decode(next); err != nil {
+				return nil, 0, err
+			}
+		}
+		return m, off, nil
+	case mmdbArray:
+		a := make([]any, 0, min(size, uint(len(d.buf))))
+		for i := uint(0); i < size; i++ {
+			var v any
+			if v, off, err = d.decode(off); err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+		}
+		return a, off, nil
+	}
+	return nil, 0, fmt.Errorf("%w: field type %d", errMMDBCorrupt, typ)
+}
+
+// mmdbUint returns the unsigned number v, zero if it is none.
+func mmdbUint(v any) uint {
+	n, _ := v.(uint64)
+	return uint(n)
+}
+
+// annotateGeo looks up the location of every distinct public hop
+// address.
+func annotateGeo(printMap map[int]*Probe, r GeoResolver) {
+	forEachHopAddr(printMap, func(pbs []*Probe) {
+		ip := pbs[0].Saddr
+		if ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+			return
+		}
+		g, err := r.LookupGeo(ip)
+		if err != nil {
+			return
+		}
+		for _, pb := range pbs {
+			pb.Geo = g
+		}
+	})
+}
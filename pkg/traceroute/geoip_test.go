@@ -0,0 +1,207 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"testing"
+)
+
+// mmdbPtr is a pointer field to the data section offset it holds.
+type mmdbPtr int
+
+// encodeMMDB encodes v as an MMDB data field.
+func encodeMMDB(v any) []byte {
+	ctrl := func(typ, size int) []byte {
+		if typ > 7 {
+			return []byte{byte(size), byte(typ - 7)}
+		}
+		return []byte{byte(typ<<5 | size)}
+	}
+	switch v := v.(type) {
+	case string:
+		return append(ctrl(mmdbString, len(v)), v...)
+	case int:
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], uint32(v))
+		return append(ctrl(mmdbUint32, 4), b[:]...)
+	case mmdbPtr:
+		return []byte{mmdbPointer<<5 | byte(v>>8&7), byte(v)}
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b := ctrl(mmdbMap, len(v))
+		for _, k := range keys {
+			b = append(b, encodeMMDB(k)...)
+			b = append(b, encodeMMDB(v[k])...)
+		}
+		return b
+	}
+	panic(fmt.Sprintf("can not encode %T", v))
+}
+
+// mmdbEntry maps a network to the data section offset of its record.
+type mmdbEntry struct {
+	cidr string
+	off  int
+}
+
+// buildMMDB builds an IPv6 MaxMind DB with records of recordSize bits.
+// IPv4 networks are stored in the IPv4-compatible range as MaxMind does.
+func buildMMDB(t *testing.T, recordSize int, data []byte, entries []mmdbEntry) []byte {
+	t.Helper()
+	const empty, dataRef = -1, 1 << 24
+	nodes := [][2]int{{empty, empty}}
+	for _, e := range entries {
+		_, n, err := net.ParseCIDR(e.cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ones, bits := n.Mask.Size()
+		ip := n.IP.To16()
+		if bits == 32 {
+			ones += 96
+			ip = append(make(net.IP, 12), n.IP.To4()...)
+		}
+		node := 0
+		for i := 0; i < ones; i++ {
+			bit := ip[i/8] >> (7 - i%8) & 1
+			if i == ones-1 {
+				nodes[node][bit] = dataRef + e.off
+				break
+			}
+			if nodes[node][bit] == empty {
+				nodes = append(nodes, [2]int{empty, empty})
+				nodes[node][bit] = len(nodes) - 1
+			}
+			node = nodes[node][bit]
+		}
+	}
+
+	var b []byte
+	count := len(nodes)
+	for _, n := range nodes {
+		var rec [2]uint32
+		for i, r := range n {
+			switch {
+			case r == empty:
+				rec[i] = uint32(count)
+			case r >= dataRef:
+				rec[i] = uint32(count + 16 + r - dataRef)
+			default:
+				rec[i] = uint32(r)
+			}
+		}
+		switch recordSize {
+		case 24:
+			b = append(b, byte(rec[0]>>16), byte(rec[0]>>8), byte(rec[0]),
+				byte(rec[1]>>16), byte(rec[1]>>8), byte(rec[1]))
+		case 28:
+			b = append(b, byte(rec[0]>>16), byte(rec[0]>>8), byte(rec[0]),
+				byte(rec[0]>>24<<4|rec[1]>>24&0x0f),
+				byte(rec[1]>>16), byte(rec[1]>>8), byte(rec[1]))
+		case 32:
+			b = binary.BigEndian.AppendUint32(b, rec[0])
+			b = binary.BigEndian.AppendUint32(b, rec[1])
+		}
+	}
+	b = append(b, make([]byte, 16)...)
+	b = append(b, data...)
+	b = append(b, mmdbMetadataMarker...)
+	return append(b, encodeMMDB(map[string]any{
+		"node_count":  count,
+		"record_size": recordSize,
+		"ip_version":  6,
+	})...)
+}
+
+func TestMMDB(t *testing.T) {
+	berlin := encodeMMDB(map[string]any{"names": map[string]any{"en": "Berlin", "de": "Berlin"}})
+	data := append([]byte{}, berlin...)
+	de := len(data)
+	data = append(data, encodeMMDB(map[string]any{
+		"country": map[string]any{"iso_code": "DE"},
+		"city":    mmdbPtr(0),
+	})...)
+	us := len(data)
+	data = append(data, encodeMMDB(map[string]any{"country": map[string]any{"iso_code": "US"}})...)
+	entries := []mmdbEntry{
+		{cidr: "192.0.2.0/24", off: de},
+		{cidr: "2001:db8::/32", off: us},
+	}
+
+	for _, size := range []int{24, 28, 32} {
+		t.Run(fmt.Sprint(size), func(t *testing.T) {
+			db, err := NewMMDB(buildMMDB(t, size, data, entries))
+			if err != nil {
+				t.Fatalf("NewMMDB() = %v", err)
+			}
+			for _, tt := range []struct {
+				ip   string
+				want Geo
+				err  error
+			}{
+				{ip: "192.0.2.77", want: Geo{Country: "DE", City: "Berlin"}},
+				{ip: "2001:db8::1", want: Geo{Country: "US"}},
+				{ip: "198.51.100.1", err: errNoGeo},
+				{ip: "2001:db9::1", err: errNoGeo},
+			} {
+				g, err := db.LookupGeo(net.ParseIP(tt.ip))
+				if !errors.Is(err, tt.err) || g != tt.want {
+					t.Errorf("LookupGeo(%s) = %+v, %v, want %+v, %v", tt.ip, g, err, tt.want, tt.err)
+				}
+			}
+		})
+	}
+}
+
+func TestMMDBCorrupt(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		b    []byte
+	}{
+		{name: "NoMetadata", b: []byte("not a database")},
+		{name: "TruncatedMetadata", b: append(append([]byte{}, mmdbMetadataMarker...), mmdbMap<<5|3)},
+		{name: "TreeExceedsFile", b: append(append([]byte{}, mmdbMetadataMarker...), encodeMMDB(map[string]any{
+			"node_count":  1000,
+			"record_size": 24,
+			"ip_version":  6,
+		})...)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewMMDB(tt.b); !errors.Is(err, errMMDBCorrupt) {
+				t.Errorf("NewMMDB() = %v, want %v", err, errMMDBCorrupt)
+			}
+		})
+	}
+}
+
+func TestMMDBPointerLoop(t *testing.T) {
+	d := &mmdbDecoder{buf: encodeMMDB(mmdbPtr(0))}
+	if _, _, err := d.decode(0); !errors.Is(err, errMMDBCorrupt) {
+		t.Errorf("decode() = %v, want %v", err, errMMDBCorrupt)
+	}
+}
+
+func TestAnnotateGeo(t *testing.T) {
+	db, err := NewMMDB(buildMMDB(t, 24, encodeMMDB(map[string]any{"country": map[string]any{"iso_code": "NL"}}),
+		[]mmdbEntry{{cidr: "0.0.0.0/0", off: 0}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	public := &Probe{ID: 1, Saddr: net.IPv4(145, 100, 0, 1)}
+	private := &Probe{ID: 2, Saddr: net.IPv4(10, 0, 0, 1)}
+	annotateGeo(map[int]*Probe{1: public, 2: private}, db)
+	if public.Geo.Country != "NL" || private.Geo != (Geo{}) {
+		t.Errorf("geo = %+v, %+v, want NL for the public address only", public.Geo, private.Geo)
+	}
+}
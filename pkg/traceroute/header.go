@@ -7,8 +7,12 @@ package traceroute
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
 
 	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
 )
 
 type UDPHeader struct {
@@ -47,67 +51,162 @@ type ICMPHeader struct {
 	Seq      uint16
 }
 
-// checksum function
-func checkSum(buf []byte) uint16 {
-	sum := uint32(0)
+// ICMPv4 message types and codes, RFC 792.
+const (
+	ICMP4EchoReply    = 0
+	ICMP4DstUnreach   = 3
+	ICMP4EchoRequest  = 8
+	ICMP4TimeExceeded = 11
+
+	ICMP4PortUnreach = 3
+	ICMP4FragNeeded  = 4
+	ICMP4TTLExcd     = 0
+)
+
+// ICMPv6 message types and codes, RFC 4443.
+const (
+	ICMP6DstUnreach   = 1
+	ICMP6PacketTooBig = 2
+	ICMP6TimeExceeded = 3
+	ICMP6EchoRequest  = 128
+	ICMP6EchoReply    = 129
+
+	ICMP6PortUnreach  = 4
+	ICMP6HopLimitExcd = 0
+)
+
+// ICMP4HeaderLen and ICMP6HeaderLen are the lengths of the fixed ICMP
+// error message headers that precede the invoking packet.
+const (
+	ICMP4HeaderLen = 8
+	ICMP6HeaderLen = 8
+)
+
+var (
+	errNotICMP4Error = errors.New("not an ICMPv4 error message")
+	errNotICMP6Error = errors.New("not an ICMPv6 error message")
+)
+
+// ICMP4Error is an ICMPv4 Time Exceeded or Destination Unreachable
+// message together with the invoking packet quoted in its body.
+type ICMP4Error struct {
+	Type uint8
+	Code uint8
+	// Quoted is the IPv4 header of the probe that triggered the error.
+	Quoted *ipv4.Header
+	// Payload is whatever of the probe follows the quoted IPv4 header,
+	// at least 8 bytes of its upper-layer header.
+	Payload []byte
+	// Extensions are the RFC 4884 extension objects appended by the
+	// router, if any.
+	Extensions []ICMPExtension
+	// MTU is the next-hop MTU of a Fragmentation Needed message, RFC
+	// 1191. Routers predating it leave it zero.
+	MTU int
+}
 
-	for ; len(buf) >= 2; buf = buf[2:] {
-		sum += uint32(buf[0])<<8 | uint32(buf[1])
+// ParseICMP4Error parses an ICMPv4 message as read from a raw ip4:icmp
+// socket, i.e. without the outer IPv4 header. Only Time Exceeded and
+// Destination Unreachable messages are accepted.
+func ParseICMP4Error(buf []byte) (*ICMP4Error, error) {
+	if len(buf) < ICMP4HeaderLen+ipv4.HeaderLen+8 {
+		return nil, fmt.Errorf("ICMPv4 message too short: %d bytes", len(buf))
+	}
+	if buf[0] != ICMP4TimeExceeded && buf[0] != ICMP4DstUnreach {
+		return nil, errNotICMP4Error
+	}
+	// RFC 4884 puts the length of the original datagram, in 32-bit
+	// words, in the second byte of the unused field.
+	datagram, exts := splitICMPExtensions(buf[ICMP4HeaderLen:], int(buf[5])*4)
+	hdr, err := ipv4.ParseHeader(datagram)
+	if err != nil {
+		return nil, err
 	}
-	if len(buf) > 0 {
-		sum += uint32(buf[0]) << 8
+	if len(datagram) < hdr.Len+8 {
+		return nil, fmt.Errorf("ICMPv4 message too short for quoted header: %d bytes", len(buf))
 	}
-	for sum > 0xffff {
-		sum = (sum >> 16) + (sum & 0xffff)
+	m := &ICMP4Error{
+		Type:       buf[0],
+		Code:       buf[1],
+		Quoted:     hdr,
+		Payload:    datagram[hdr.Len:],
+		Extensions: exts,
 	}
-	csum := ^uint16(sum)
-	/*
-	 * From RFC 768:
-	 * If the computed checksum is zero, it is transmitted as all ones (the
-	 * equivalent in one's complement arithmetic). An all zero transmitted
-	 * checksum value means that the transmitter generated no checksum (for
-	 * debugging or for higher level protocols that don't care).
-	 */
-	if csum == 0 {
-		csum = 0xffff
+	if m.Type == ICMP4DstUnreach && m.Code == ICMP4FragNeeded {
+		m.MTU = int(binary.BigEndian.Uint16(buf[6:8]))
 	}
-	return csum
+	return m, nil
 }
 
-func (u *UDPHeader) checksum(ip *ipv4.Header, payload []byte) {
-	var pseudoHeader []byte
-
-	pseudoHeader = append(pseudoHeader, ip.Src.To4()...)
-	pseudoHeader = append(pseudoHeader, ip.Dst.To4()...)
-	pseudoHeader = append(pseudoHeader, []byte{
-		0,
-		17,
-		0, byte(u.Length),
-	}...)
-
-	var b bytes.Buffer
-	binary.Write(&b, binary.BigEndian, pseudoHeader)
-	binary.Write(&b, binary.BigEndian, u)
-	binary.Write(&b, binary.BigEndian, &payload)
-	u.Chksum = checkSum(b.Bytes())
+// ICMP6Error is an ICMPv6 Time Exceeded, Destination Unreachable or
+// Packet Too Big message together with the invoking packet quoted in its
+// body.
+type ICMP6Error struct {
+	Type uint8
+	Code uint8
+	// Quoted is the IPv6 header of the probe that triggered the error.
+	Quoted *ipv6.Header
+	// Payload is whatever of the probe follows the quoted IPv6 header
+	// and its extension headers, starting with its upper-layer header.
+	Payload []byte
+	// NextHeader is the protocol of the upper-layer header, past any
+	// extension headers in front of it.
+	NextHeader int
+	// FinalDst is the final destination named by a routing header of
+	// the probe, nil without one. The quoted destination is then the
+	// segment the probe was on.
+	FinalDst net.IP
+	// Extensions are the RFC 4884 extension objects appended by the
+	// router, if any.
+	Extensions []ICMPExtension
+	// MTU is the MTU of the next link of a Packet Too Big message.
+	MTU int
 }
 
-func (t *TCPHeader) checksum(ip *ipv4.Header, payload []byte) {
-	var pseudoHeader []byte
-
-	pseudoHeader = append(pseudoHeader, ip.Src.To4()...)
-	pseudoHeader = append(pseudoHeader, ip.Dst.To4()...)
-	pseudoHeader = append(pseudoHeader, []byte{
-		0,
-		6,
-		0, byte(len(payload) + 20),
-	}...)
-
-	var b bytes.Buffer
-	binary.Write(&b, binary.BigEndian, pseudoHeader)
-	binary.Write(&b, binary.BigEndian, t)
-	binary.Write(&b, binary.BigEndian, &payload)
-	t.Checksum = checkSum(b.Bytes())
+// ParseICMP6Error parses an ICMPv6 message as read from a raw
+// ip6:ipv6-icmp socket. Only Time Exceeded, Destination Unreachable and
+// Packet Too Big messages are accepted.
+func ParseICMP6Error(buf []byte) (*ICMP6Error, error) {
+	if len(buf) < ICMP6HeaderLen+ipv6.HeaderLen {
+		return nil, fmt.Errorf("ICMPv6 message too short: %d bytes", len(buf))
+	}
+	var mtu int
+	datagram := buf[ICMP6HeaderLen:]
+	var exts []ICMPExtension
+	switch buf[0] {
+	case ICMP6TimeExceeded, ICMP6DstUnreach:
+		// RFC 4884 puts the length of the original datagram, in 64-bit
+		// words, in the first byte of the unused field.
+		datagram, exts = splitICMPExtensions(datagram, int(buf[4])*8)
+	case ICMP6PacketTooBig:
+		mtu = int(binary.BigEndian.Uint32(buf[4:8]))
+	default:
+		return nil, errNotICMP6Error
+	}
+	hdr, err := ipv6.ParseHeader(datagram)
+	if err != nil {
+		return nil, err
+	}
+	payload, next, final := skipIPv6ExtHeaders(datagram[ipv6.HeaderLen:], hdr.NextHeader)
+	return &ICMP6Error{
+		Type:       buf[0],
+		Code:       buf[1],
+		Quoted:     hdr,
+		Payload:    payload,
+		NextHeader: next,
+		FinalDst:   final,
+		Extensions: exts,
+		MTU:        mtu,
+	}, nil
+}
+
+// dst returns the destination of the quoted probe, the final one if it
+// was routed through intermediate destinations.
+func (m *ICMP6Error) dst() net.IP {
+	if m.FinalDst != nil {
+		return m.FinalDst
+	}
+	return m.Quoted.Dst
 }
 
 func ParseTCP(data []byte) (*TCPHeader, error) {
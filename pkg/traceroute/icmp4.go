@@ -5,90 +5,140 @@
 package traceroute
 
 import (
-	"bytes"
+	"context"
 	"encoding/binary"
-	"log"
-	"net"
 	"time"
 
 	"golang.org/x/net/ipv4"
 )
 
-func (t *Trace) SendTracesICMP4() {
-	conn, err := net.ListenPacket("ip4:icmp", t.SrcIP.String())
+// SendTracesICMP4 sends ICMP Echo Requests with increasing TTLs. All
+// requests of a trace share the echo identifier, the sequence number
+// identifies the probe.
+func (t *Trace) SendTracesICMP4(ctx context.Context) error {
+	defer close(t.SendChan)
+
+	conn, err := t.network().ProbeConn("ip4:icmp")
 	if err != nil {
-		log.Fatal(err)
+		return sockErr("opening probe socket", err)
 	}
 	defer conn.Close()
 
-	rSocket, err := ipv4.NewRawConn(conn)
+	recvConn, err := t.network().ReplyConn("ip4:icmp", t.SrcIP.To4())
 	if err != nil {
-		log.Fatal("can not create raw socket:", err)
+		return sockErr("opening ICMP socket", err)
 	}
-	id := uint16(1)
+	t.receive(ctx, recvConn, func() { t.ReceiveTracesICMP4(ctx, recvConn) })
+
+	seq := uint16(1)
 	mod := uint16(1 << 15)
-	for ttl := 1; ttl <= int(t.MaxHops); ttl++ {
-		for j := 0; j < t.TracesPerHop; j++ {
-			hdr, payload := t.BuildICMP4Pkt(uint8(ttl), id, id, 0)
-			rSocket.WriteTo(hdr, payload, nil)
+	for ttl := t.Options.FirstTTL; ttl <= t.Options.MaxTTL; ttl++ {
+		for j := 0; j < t.Options.ProbesPerHop; j++ {
+			release, err := t.acquireSlot(ctx)
+			if err != nil {
+				return err
+			}
+			hdr, payload, err := t.BuildICMP4Pkt(uint8(ttl), t.icmpID, seq, t.Options.TOS())
+			if err != nil {
+				return err
+			}
+			if err := t.setIPv4Options(hdr); err != nil {
+				return err
+			}
 			pb := &Probe{
-				ID:       uint32(hdr.ID),
+				ID:       uint32(seq),
 				Dest:     t.DestIP.To4(),
 				TTL:      ttl,
 				Sendtime: time.Now(),
+				release:  release,
+				TOS:      uint8(t.Options.TOS()),
+			}
+			if err := t.sendProbe(ctx, pb); err != nil {
+				return err
+			}
+			if err := conn.WritePacket(&Packet{IPv4: hdr, Payload: payload}, t.DestIP); err != nil {
+				return sockErr("sending probe", err)
+			}
+			seq = (seq + 1) % mod
+			if err := t.pause(ctx, ttl); err != nil {
+				return err
 			}
-			t.SendChan <- pb
-			id = (id + 1) % mod
-			go t.ReceiveTracesICMP4()
-			time.Sleep(time.Microsecond * time.Duration(100000/t.PacketRate))
 		}
 	}
-	for _, port := range []uint16{
-		80,
-		8080,
-		443,
-		8443,
-	} {
-		go t.IPv4TCPProbe(port)
-	}
+	return nil
 }
 
-func (t *Trace) ReceiveTracesICMP4() {
-	laddr := &net.IPAddr{IP: t.SrcIP.To4()}
-	recvICMPConn, err := net.ListenIP("ip4:icmp", laddr)
-	if err != nil {
-		log.Fatal("bind failure:", err)
-	}
+// ReceiveTracesICMP4 matches Echo Replies from the destination and
+// Time Exceeded messages from intermediate hops to the probes that
+// triggered them.
+func (t *Trace) ReceiveTracesICMP4(ctx context.Context, recvICMPConn ProbeConn) {
+	defer recvICMPConn.Close()
+
 	buf := make([]byte, 1500)
-	n, raddr, err := recvICMPConn.ReadFrom(buf)
-	if err != nil {
-		log.Fatal(err)
+	for {
+		n, from, ri, err := readReply(recvICMPConn, buf)
+		if err != nil {
+			return
+		}
+		pb, ok := t.matchICMP4(buf[:n])
+		if !ok {
+			continue
+		}
+		pb.Saddr = from
+		pb.RecvTime = ri.At
+		pb.ReplyTTL = ri.TTL
+		if !t.deliver(ctx, pb) {
+			return
+		}
 	}
-	icmpType := buf[0]
-
-	if (icmpType == 11 || (icmpType == 3 && buf[1] == 3)) && (n >= 36) { //TTL Exceeded or Port Unreachable
-		id := binary.BigEndian.Uint16(buf[32:34])
-		dstip := net.IP(buf[24:28])
-		//srcip := net.IP(buf[20:24])
+}
 
-		if dstip.Equal(t.DestIP) {
-			pb := &Probe{
-				ID:       uint32(id),
-				Saddr:    net.ParseIP(raddr.String()),
-				RecvTime: time.Now(),
-			}
-			t.ReceiveChan <- pb
+// matchICMP4 returns the probe, identified by the sequence number of
+// the echo request, that msg answers if msg belongs to this trace.
+func (t *Trace) matchICMP4(msg []byte) (*Probe, bool) {
+	if len(msg) >= ICMP4HeaderLen && msg[0] == ICMP4EchoReply {
+		if binary.BigEndian.Uint16(msg[4:6]) != t.icmpID {
+			return nil, false
 		}
+		return &Probe{ID: uint32(binary.BigEndian.Uint16(msg[6:8])), QuotedTOS: -1}, true
+	}
+
+	icmpErr, err := ParseICMP4Error(msg)
+	if err != nil {
+		return nil, false
 	}
+	if !t.isDest(icmpErr.Quoted.Dst) || icmpErr.Payload[0] != ICMP4EchoRequest {
+		return nil, false
+	}
+	if binary.BigEndian.Uint16(icmpErr.Payload[4:6]) != t.icmpID {
+		return nil, false
+	}
+	return &Probe{
+		ID:        uint32(binary.BigEndian.Uint16(icmpErr.Payload[6:8])),
+		MPLS:      MPLSLabels(icmpErr.Extensions),
+		Unreach:   icmpErr.unreach(),
+		FragMTU:   icmpErr.fragMTU(),
+		QuotedTOS: icmpErr.Quoted.TOS,
+		Quoted:    icmpErr.quotedHeader(),
+		Recorded:  ParseRecordedRoute(icmpErr.Quoted.Options),
+	}, true
 }
 
-func (t *Trace) BuildICMP4Pkt(ttl uint8, id, seq uint16, tos int) (*ipv4.Header, []byte) {
+func (t *Trace) BuildICMP4Pkt(ttl uint8, id, seq uint16, tos int) (*ipv4.Header, []byte, error) {
+	payload := make([]byte, t.Options.payloadLen(ipv4.HeaderLen+ICMP4HeaderLen, 2))
+	t.Options.fill(payload)
+	// Balancers may hash on the ICMP checksum, which the sequence
+	// number would otherwise change.
+	if t.Strategy == StrategyParis {
+		putChecksumCompensation(payload, seq)
+	}
+
 	iph := &ipv4.Header{
 		Version:  ipv4.Version,
 		TOS:      tos,
 		Len:      ipv4.HeaderLen,
-		TotalLen: 40,
-		ID:       int(id),
+		TotalLen: ipv4.HeaderLen + ICMP4HeaderLen + len(payload),
+		ID:       int(seq),
 		Flags:    0,
 		FragOff:  0,
 		TTL:      int(ttl),
@@ -100,30 +150,18 @@ func (t *Trace) BuildICMP4Pkt(ttl uint8, id, seq uint16, tos int) (*ipv4.Header,
 
 	h, err := iph.Marshal()
 	if err != nil {
-		log.Fatal(err)
+		return nil, nil, err
 	}
 	iph.Checksum = int(checkSum(h))
 
 	icmp := ICMPHeader{
-		IType:    8, //Echo
+		IType:    ICMP4EchoRequest,
 		ICode:    0,
 		Checksum: 0,
 		ID:       id,
 		Seq:      seq,
 	}
 
-	payload := make([]byte, 32)
-	for i := 0; i < 32; i++ {
-		payload[i] = uint8(i + 64)
-	}
-
-	var b bytes.Buffer
-	binary.Write(&b, binary.BigEndian, icmp)
-	binary.Write(&b, binary.BigEndian, &payload)
-	icmp.Checksum = checkSum(b.Bytes())
-
-	var buf bytes.Buffer
-	binary.Write(&buf, binary.BigEndian, &icmp)
-	binary.Write(&buf, binary.BigEndian, &payload)
-	return iph, buf.Bytes()
+	icmp.checksum(payload)
+	return iph, packet(icmp.Marshal(), payload), nil
 }
@@ -5,103 +5,140 @@
 package traceroute
 
 import (
-	"bytes"
+	"context"
 	"encoding/binary"
-	"log"
-	"net"
 	"time"
 
 	"golang.org/x/net/ipv6"
 )
 
-func (t *Trace) SendTracesICMP6() {
-	conn, err := net.ListenPacket("ip6:ipv6-icmp", t.SrcIP.String())
+// SendTracesICMP6 sends ICMPv6 Echo Requests with increasing hop
+// limits. All requests of a trace share the echo identifier, the
+// sequence number identifies the probe.
+func (t *Trace) SendTracesICMP6(ctx context.Context) error {
+	defer close(t.SendChan)
+
+	conn, err := t.network().ProbeConn("ip6:ipv6-icmp")
 	if err != nil {
-		log.Fatal(err)
+		return sockErr("opening probe socket", err)
 	}
 	defer conn.Close()
 
-	pktconn := ipv6.NewPacketConn(conn)
-	id := uint16(1)
+	recvConn, err := t.network().ReplyConn("ip6:ipv6-icmp", nil)
+	if err != nil {
+		return sockErr("opening ICMPv6 socket", err)
+	}
+	t.receive(ctx, recvConn, func() { t.ReceiveTraceICMP6(ctx, recvConn) })
+
+	seq := uint16(1)
 	mod := uint16(1 << 15)
 
-	for ttl := 1; ttl < int(t.MaxHops); ttl++ {
-		for j := 0; j < t.TracesPerHop; j++ {
-			cm, payload := t.BuildICMP6Pkt(ttl, id, id, 0)
-			pktconn.WriteTo(payload, cm, &net.UDPAddr{IP: t.DestIP})
+	for ttl := t.Options.FirstTTL; ttl <= t.Options.MaxTTL; ttl++ {
+		for j := 0; j < t.Options.ProbesPerHop; j++ {
+			release, err := t.acquireSlot(ctx)
+			if err != nil {
+				return err
+			}
+			cm, payload := t.BuildICMP6Pkt(ttl, t.icmpID, seq, t.Options.TOS())
 			pb := &Probe{
-				ID:       uint32(id),
+				ID:       uint32(seq),
 				Dest:     t.DestIP,
 				TTL:      ttl,
 				Sendtime: time.Now(),
+				release:  release,
+				TOS:      uint8(t.Options.TOS()),
+			}
+			if err := t.sendProbe(ctx, pb); err != nil {
+				return err
+			}
+			if err := conn.WritePacket(t.packet6(cm, payload, 0), t.DestIP); err != nil {
+				return sockErr("sending probe", err)
+			}
+			seq = (seq + 1) % mod
+			if err := t.pause(ctx, ttl); err != nil {
+				return err
 			}
-			t.SendChan <- pb
-			id = (id + 1) % mod
-			go t.ReceiveTraceICMP6()
-			time.Sleep(time.Microsecond * time.Duration(100000/t.PacketRate))
 		}
 	}
-	for _, port := range []uint16{
-		80,
-		8080,
-		443,
-		8443,
-	} {
-		go t.IPv6TCPProbe(port)
-	}
+	return nil
 }
 
-func (t *Trace) ReceiveTraceICMP6() {
-	recvICMPConn, err := net.ListenIP("ip6:ipv6-icmp", nil)
-	if err != nil {
-		log.Fatal(err)
-	}
+// ReceiveTraceICMP6 matches Echo Replies from the destination and
+// Time Exceeded messages from intermediate hops to the probes that
+// triggered them.
+func (t *Trace) ReceiveTraceICMP6(ctx context.Context, recvICMPConn ProbeConn) {
+	defer recvICMPConn.Close()
 
 	buf := make([]byte, 1500)
-	n, raddr, err := recvICMPConn.ReadFrom(buf)
-	if err != nil {
-		log.Fatal(err)
+	for {
+		n, from, ri, err := readReply(recvICMPConn, buf)
+		if err != nil {
+			return
+		}
+		pb, ok := t.matchICMP6(buf[:n])
+		if !ok {
+			continue
+		}
+		pb.Saddr = from
+		pb.RecvTime = ri.At
+		pb.ReplyTTL = ri.TTL
+		if !t.deliver(ctx, pb) {
+			return
+		}
 	}
+}
 
-	icmpType := buf[0]
-	if (icmpType == 1 || (icmpType == 3 && buf[1] == 0)) && (n >= 36) { // destination unreachable or ttl exceed in transit
-
-		id := binary.BigEndian.Uint16(buf[14+ipv6.HeaderLen : 16+ipv6.HeaderLen])
-		//fmt.Printf("%v\n", id)
-		ipv6hdr, _ := ipv6.ParseHeader(buf[8:])
-		if ipv6hdr.Dst.Equal(t.DestIP) {
-			pb := &Probe{
-				ID:       uint32(id),
-				Saddr:    net.ParseIP(raddr.String()),
-				RecvTime: time.Now(),
-			}
-			t.ReceiveChan <- pb
+// matchICMP6 returns the probe, identified by the sequence number of
+// the echo request, that msg answers if msg belongs to this trace.
+func (t *Trace) matchICMP6(msg []byte) (*Probe, bool) {
+	if len(msg) >= ICMP6HeaderLen && msg[0] == ICMP6EchoReply {
+		if binary.BigEndian.Uint16(msg[4:6]) != t.icmpID {
+			return nil, false
 		}
+		return &Probe{ID: uint32(binary.BigEndian.Uint16(msg[6:8])), QuotedTOS: -1}, true
+	}
 
+	icmpErr, err := ParseICMP6Error(msg)
+	if err != nil {
+		return nil, false
+	}
+	if !icmpErr.dst().Equal(t.DestIP) || len(icmpErr.Payload) < 8 || icmpErr.Payload[0] != ICMP6EchoRequest {
+		return nil, false
+	}
+	if binary.BigEndian.Uint16(icmpErr.Payload[4:6]) != t.icmpID {
+		return nil, false
 	}
+	return &Probe{
+		ID:        uint32(binary.BigEndian.Uint16(icmpErr.Payload[6:8])),
+		MPLS:      MPLSLabels(icmpErr.Extensions),
+		Unreach:   icmpErr.unreach(),
+		FragMTU:   icmpErr.fragMTU(),
+		QuotedTOS: icmpErr.Quoted.TrafficClass,
+		Quoted:    icmpErr.quotedHeader(),
+	}, true
 }
 
 func (t *Trace) BuildICMP6Pkt(ttl int, id uint16, seq uint16, tc int) (*ipv6.ControlMessage, []byte) {
 	ctlmsg := &ipv6.ControlMessage{
-		TrafficClass: 0,
+		TrafficClass: tc,
 		HopLimit:     int(ttl),
 	}
 
 	icmppkt := ICMPHeader{
-		IType:    128,
+		IType:    ICMP6EchoRequest,
 		ICode:    0,
 		Checksum: 0,
 		ID:       id,
 		Seq:      seq,
 	}
 
-	payload := make([]byte, 32)
-	for i := 0; i < 32; i++ {
-		payload[i] = uint8(i + 64)
+	payload := make([]byte, t.Options.payloadLen(ipv6.HeaderLen+ICMP6HeaderLen, 2))
+	t.Options.fill(payload)
+	// Balancers may hash on the ICMP checksum, which the sequence
+	// number would otherwise change.
+	if t.Strategy == StrategyParis {
+		putChecksumCompensation(payload, seq)
 	}
 
-	var b bytes.Buffer
-	binary.Write(&b, binary.BigEndian, icmppkt)
-	binary.Write(&b, binary.BigEndian, &payload)
-	return ctlmsg, b.Bytes()
+	return ctlmsg, packet(icmppkt.Marshal(), payload)
 }
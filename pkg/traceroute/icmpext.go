@@ -0,0 +1,157 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ICMP extension object classes, RFC 4950 and RFC 5837.
+const (
+	ICMPExtClassMPLS      = 1
+	ICMPExtClassInterface = 2
+
+	ICMPExtMPLSIncoming = 1
+)
+
+const (
+	icmpExtVersion = 2
+	icmpExtHdrLen  = 4
+	icmpObjHdrLen  = 4
+	// icmpExtCompatLen is where routers that predate RFC 4884 put the
+	// extension structure: right after 128 bytes of original datagram.
+	icmpExtCompatLen = 128
+)
+
+var (
+	errICMPExtVersion  = errors.New("unsupported ICMP extension version")
+	errICMPExtChecksum = errors.New("bad ICMP extension checksum")
+)
+
+// ICMPExtension is an object of an ICMP extension structure, RFC 4884.
+type ICMPExtension struct {
+	ClassNum uint8
+	CType    uint8
+	Data     []byte
+}
+
+// MPLSLabel is an entry of an MPLS label stack as quoted by a router in
+// an ICMP extension object, RFC 4950.
+type MPLSLabel struct {
+	Label uint32 `json:"label"`
+	// Exp is the experimental use, nowadays traffic class, field.
+	Exp uint8 `json:"exp"`
+	// S marks the bottom of the stack.
+	S   bool  `json:"s"`
+	TTL uint8 `json:"ttl"`
+}
+
+func (l MPLSLabel) String() string {
+	s := 0
+	if l.S {
+		s = 1
+	}
+	return fmt.Sprintf("L=%d,E=%d,S=%d,T=%d", l.Label, l.Exp, s, l.TTL)
+}
+
+// ParseICMPExtensions parses an ICMP extension structure: a version and
+// checksum header followed by a list of objects.
+func ParseICMPExtensions(b []byte) ([]ICMPExtension, error) {
+	if len(b) < icmpExtHdrLen {
+		return nil, fmt.Errorf("ICMP extension structure too short: %d bytes", len(b))
+	}
+	if b[0]>>4 != icmpExtVersion {
+		return nil, errICMPExtVersion
+	}
+	if binary.BigEndian.Uint16(b[2:4]) != 0 && checkSum(b) != 0xffff {
+		return nil, errICMPExtChecksum
+	}
+
+	var exts []ICMPExtension
+	for b = b[icmpExtHdrLen:]; len(b) > 0; {
+		if len(b) < icmpObjHdrLen {
+			return nil, fmt.Errorf("ICMP extension object header too short: %d bytes", len(b))
+		}
+		l := int(binary.BigEndian.Uint16(b[0:2]))
+		if l < icmpObjHdrLen || l > len(b) {
+			return nil, fmt.Errorf("invalid ICMP extension object length %d", l)
+		}
+		exts = append(exts, ICMPExtension{
+			ClassNum: b[2],
+			CType:    b[3],
+			Data:     b[icmpObjHdrLen:l],
+		})
+		b = b[l:]
+	}
+	return exts, nil
+}
+
+// MPLSLabels returns the label stack of all MPLS extension objects in
+// exts, top of the stack first.
+func MPLSLabels(exts []ICMPExtension) []MPLSLabel {
+	var labels []MPLSLabel
+	for _, e := range exts {
+		if e.ClassNum != ICMPExtClassMPLS || e.CType != ICMPExtMPLSIncoming {
+			continue
+		}
+		for d := e.Data; len(d) >= 4; d = d[4:] {
+			v := binary.BigEndian.Uint32(d)
+			labels = append(labels, MPLSLabel{
+				Label: v >> 12,
+				Exp:   uint8(v>>9) & 0x7,
+				S:     v&(1<<8) != 0,
+				TTL:   uint8(v),
+			})
+		}
+	}
+	return labels
+}
+
+// splitICMPExtensions splits the body of an ICMP error message, what comes
+// after its 8 byte header, into the original datagram and the extension
+// objects. length is the length of the original datagram in bytes as
+// announced by the message, zero if it did not announce one.
+func splitICMPExtensions(body []byte, length int) ([]byte, []ICMPExtension) {
+	if length > 0 {
+		if length >= len(body) {
+			return body, nil
+		}
+		exts, err := ParseICMPExtensions(body[length:])
+		if err != nil {
+			return body[:length], nil
+		}
+		return body[:length], exts
+	}
+
+	// Non-compliant routers leave the length zero. Only believe them if
+	// the checksum of what looks like an extension structure verifies.
+	if len(body) < icmpExtCompatLen+icmpExtHdrLen {
+		return body, nil
+	}
+	ext := body[icmpExtCompatLen:]
+	if binary.BigEndian.Uint16(ext[2:4]) == 0 {
+		return body, nil
+	}
+	exts, err := ParseICMPExtensions(ext)
+	if err != nil {
+		return body, nil
+	}
+	return body[:icmpExtCompatLen], exts
+}
+
+// mplsString formats a label stack the way traceroute -e does.
+func mplsString(labels []MPLSLabel) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	s := make([]string, 0, len(labels))
+	for _, l := range labels {
+		s = append(s, "<MPLS:"+l.String()+">")
+	}
+	return strings.Join(s, " ")
+}
@@ -0,0 +1,219 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/net/ipv4"
+)
+
+var errIPOption = errors.New("unknown IPv4 option")
+
+// IPv4 option types, RFC 791.
+const (
+	IPOptEnd         = 0
+	IPOptNOP         = 1
+	IPOptRecordRoute = 7
+	IPOptTimestamp   = 68
+	IPOptLSRR        = 131
+)
+
+// Layout of the options probes are sent with. Options take at most 40
+// bytes, which leaves room for nine addresses, or four addresses with
+// their timestamps. A source route takes room from them.
+const (
+	ipOptMaxLen       = 40
+	ipOptRRSlots      = 9
+	ipOptTSAddr       = 1
+	ipOptTSAddrSlots  = 4
+	ipOptTSSlotLength = 8
+)
+
+// IPOption selects the IPv4 option probes are sent with. Routers that
+// honor it record their address or a timestamp in it, and ICMP errors
+// quote it back as it was when the probe expired.
+type IPOption int
+
+const (
+	// IPOptionNone sends probes without options.
+	IPOptionNone IPOption = iota
+	// IPOptionRecordRoute has up to nine routers record their address.
+	IPOptionRecordRoute
+	// IPOptionTimestamp has up to four routers record their address
+	// together with a timestamp.
+	IPOptionTimestamp
+)
+
+func (o IPOption) String() string {
+	switch o {
+	case IPOptionNone:
+		return "none"
+	case IPOptionRecordRoute:
+		return "rr"
+	case IPOptionTimestamp:
+		return "ts"
+	}
+	return fmt.Sprintf("IPOption(%d)", int(o))
+}
+
+// ParseIPOption parses the name of an IPv4 option, e.g. "rr".
+func ParseIPOption(s string) (IPOption, error) {
+	for _, o := range []IPOption{IPOptionNone, IPOptionRecordRoute, IPOptionTimestamp} {
+		if strings.EqualFold(s, o.String()) {
+			return o, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %q", errIPOption, s)
+}
+
+// data returns the option as sent, with as many empty slots as fit in
+// room bytes and padded to whole words. It is nil for IPOptionNone or
+// if not even one slot fits.
+func (o IPOption) data(room int) []byte {
+	switch o {
+	case IPOptionRecordRoute:
+		slots := min(ipOptRRSlots, (room-4)/4)
+		if slots < 1 {
+			return nil
+		}
+		b := make([]byte, 4+4*slots)
+		b[0] = IPOptRecordRoute
+		b[1] = byte(3 + 4*slots)
+		b[2] = 4
+		return b
+	case IPOptionTimestamp:
+		slots := min(ipOptTSAddrSlots, (room-4)/ipOptTSSlotLength)
+		if slots < 1 {
+			return nil
+		}
+		b := make([]byte, 4+ipOptTSSlotLength*slots)
+		b[0] = IPOptTimestamp
+		b[1] = byte(len(b))
+		b[2] = 5
+		b[3] = ipOptTSAddr
+		return b
+	}
+	return nil
+}
+
+// setIPv4Options adds the source route through Options.Gateways and
+// the option selected by Options.IPOption to the header of a probe and
+// sets its don't fragment flag if Options.DontFragment is.
+func (t *Trace) setIPv4Options(iph *ipv4.Header) error {
+	opts := t.sourceRoute()
+	opts = append(opts, t.Options.IPOption.data(ipOptMaxLen-len(opts))...)
+	if len(opts) == 0 && !t.Options.DontFragment {
+		return nil
+	}
+	if t.Options.DontFragment {
+		iph.Flags |= ipv4.DontFragment
+	}
+	if len(t.Options.Gateways) > 0 {
+		iph.Dst = t.Options.Gateways[0].To4()
+	}
+	iph.Options = opts
+	iph.Len = ipv4.HeaderLen + len(opts)
+	iph.TotalLen += len(opts)
+	iph.Checksum = 0
+	h, err := iph.Marshal()
+	if err != nil {
+		return err
+	}
+	iph.Checksum = int(checkSum(h))
+	return nil
+}
+
+// RecordedRoute is what routers filled into the Record Route or
+// Timestamp option of a probe.
+type RecordedRoute struct {
+	// Addrs are the addresses of the routers that recorded themselves,
+	// in path order.
+	Addrs []net.IP `json:"addrs,omitempty"`
+	// Timestamps are the times the routers saw the probe, in
+	// milliseconds since midnight UT. Routers without a standard clock
+	// set the high bit.
+	Timestamps []uint32 `json:"timestamps_ms,omitempty"`
+	// Overflow counts the routers that found no room left to record.
+	Overflow int `json:"overflow,omitempty"`
+}
+
+// ParseRecordedRoute decodes the Record Route or Timestamp option from
+// the options of an IPv4 header. It returns nil if there is none.
+func ParseRecordedRoute(opts []byte) *RecordedRoute {
+	for len(opts) > 0 {
+		switch opts[0] {
+		case IPOptEnd:
+			return nil
+		case IPOptNOP:
+			opts = opts[1:]
+			continue
+		}
+		if len(opts) < 2 || int(opts[1]) < 2 || int(opts[1]) > len(opts) {
+			return nil
+		}
+		opt := opts[:opts[1]]
+		opts = opts[opts[1]:]
+		switch opt[0] {
+		case IPOptRecordRoute:
+			if len(opt) < 3 {
+				return nil
+			}
+			// The pointer is the 1-based offset of the next free slot.
+			end := min(int(opt[2])-1, len(opt))
+			r := &RecordedRoute{}
+			for i := 3; i+4 <= end; i += 4 {
+				r.Addrs = append(r.Addrs, net.IP(append([]byte{}, opt[i:i+4]...)))
+			}
+			return r
+		case IPOptTimestamp:
+			if len(opt) < 4 {
+				return nil
+			}
+			end := min(int(opt[2])-1, len(opt))
+			r := &RecordedRoute{Overflow: int(opt[3] >> 4)}
+			withAddrs := opt[3]&0x0f != 0
+			for i := 4; i < end; {
+				if withAddrs {
+					if i+8 > end {
+						break
+					}
+					r.Addrs = append(r.Addrs, net.IP(append([]byte{}, opt[i:i+4]...)))
+					i += 4
+				} else if i+4 > end {
+					break
+				}
+				r.Timestamps = append(r.Timestamps, binary.BigEndian.Uint32(opt[i:]))
+				i += 4
+			}
+			return r
+		}
+	}
+	return nil
+}
+
+// String formats r like "192.0.2.1 192.0.2.2" or, with timestamps,
+// "192.0.2.1@1234ms".
+func (r *RecordedRoute) String() string {
+	var s []string
+	for i, a := range r.Addrs {
+		if i < len(r.Timestamps) {
+			s = append(s, fmt.Sprintf("%s@%dms", a, r.Timestamps[i]))
+		} else {
+			s = append(s, a.String())
+		}
+	}
+	for _, ts := range r.Timestamps[min(len(r.Addrs), len(r.Timestamps)):] {
+		s = append(s, fmt.Sprintf("%dms", ts))
+	}
+	if r.Overflow > 0 {
+		s = append(s, fmt.Sprintf("+%d", r.Overflow))
+	}
+	return strings.Join(s, " ")
+}
@@ -0,0 +1,128 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+func TestParseRecordedRoute(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		opts []byte
+		want string
+	}{
+		{
+			name: "RecordRoute",
+			opts: []byte{IPOptRecordRoute, 11, 12, 192, 0, 2, 1, 192, 0, 2, 2, 0},
+			want: "192.0.2.1 192.0.2.2",
+		},
+		{
+			name: "RecordRouteNOP",
+			opts: []byte{IPOptNOP, IPOptRecordRoute, 7, 8, 192, 0, 2, 1, 0, 0, 0, 0},
+			want: "192.0.2.1",
+		},
+		{
+			name: "TimestampAddrs",
+			opts: []byte{IPOptTimestamp, 20, 13, 2<<4 | ipOptTSAddr, 192, 0, 2, 1, 0, 0, 0x04, 0xd2, 0, 0, 0, 0, 0, 0, 0, 0},
+			want: "192.0.2.1@1234ms +2",
+		},
+		{
+			name: "TimestampOnly",
+			opts: []byte{IPOptTimestamp, 12, 9, 0, 0, 0, 0, 7, 0, 0, 0, 0},
+			want: "7ms",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := ParseRecordedRoute(tt.opts)
+			if r == nil {
+				t.Fatalf("ParseRecordedRoute() = nil, want %q", tt.want)
+			}
+			if got := r.String(); got != tt.want {
+				t.Errorf("ParseRecordedRoute() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	for _, opts := range [][]byte{nil, {IPOptEnd, IPOptRecordRoute}, {IPOptRecordRoute, 200, 4}} {
+		if r := ParseRecordedRoute(opts); r != nil {
+			t.Errorf("ParseRecordedRoute(%v) = %v, want nil", opts, r)
+		}
+	}
+}
+
+func TestSetIPv4Options(t *testing.T) {
+	for _, opt := range []IPOption{IPOptionRecordRoute, IPOptionTimestamp} {
+		t.Run(opt.String(), func(t *testing.T) {
+			tr := &Trace{
+				DestIP:  net.IPv4(192, 0, 2, 1).To4(),
+				SrcIP:   net.IPv4(192, 0, 2, 2).To4(),
+				Options: TracerouteOptions{IPOption: opt},
+			}
+			iph, pl, err := tr.BuildUDP4Pkt(1234, 33434, 1, 1, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tr.setIPv4Options(iph); err != nil {
+				t.Fatalf("setIPv4Options() = %v", err)
+			}
+			b, err := iph.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if checkSum(b) != 0xffff {
+				t.Errorf("header checksum does not verify")
+			}
+			h, err := ipv4.ParseHeader(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h.Len != len(b) || h.Len%4 != 0 || h.TotalLen != h.Len+len(pl) {
+				t.Errorf("header length %d, total length %d, want %d and %d", h.Len, h.TotalLen, len(b), len(b)+len(pl))
+			}
+			if r := ParseRecordedRoute(h.Options); r == nil || len(r.Addrs) != 0 {
+				t.Errorf("ParseRecordedRoute() = %v, want an empty route", r)
+			}
+		})
+	}
+}
+
+func TestRecordRouteFakeNetwork(t *testing.T) {
+	src := net.IPv4(192, 0, 2, 100)
+	dest := net.IPv4(198, 51, 100, 1)
+	routers := []net.IP{net.IPv4(192, 0, 2, 1), net.IPv4(203, 0, 113, 1)}
+
+	cc := Coms{
+		SendChan: make(chan *Probe),
+		RecvChan: make(chan *Probe),
+	}
+	tr := NewTrace("udp4", dest, src, cc, &Flags{TracerouteOptions: TracerouteOptions{
+		MaxTTL:       5,
+		ProbesPerHop: 1,
+		Interval:     time.Millisecond,
+		Timeout:      time.Second,
+		IPOption:     IPOptionRecordRoute,
+	}})
+	tr.Network = newFakeNetwork(dest.To4(), routers...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go tr.SendTracesUDP4(ctx)
+	printMap := runTransmission(ctx, cc, 1, tr.Options.Timeout, nil)
+
+	for ttl, want := range map[int]string{1: "", 2: "192.0.2.1", 3: "192.0.2.1 203.0.113.1"} {
+		pbs := GetProbesByTLL(printMap, ttl)
+		if len(pbs) != 1 || pbs[0].Recorded == nil {
+			t.Fatalf("TTL %d: answers %v, want one with a recorded route", ttl, pbs)
+		}
+		if got := pbs[0].Recorded.String(); got != want {
+			t.Errorf("TTL %d: recorded route %q, want %q", ttl, got, want)
+		}
+	}
+}
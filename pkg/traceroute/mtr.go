@@ -0,0 +1,265 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"time"
+)
+
+// DEFCYCLEINTERVAL is the default pause between two cycles of a
+// continuous trace.
+const DEFCYCLEINTERVAL = time.Second
+
+var errMonitorMTU = errors.New("path MTU discovery can not be monitored")
+
+// MTRHop holds the statistics of one TTL of a continuous trace.
+type MTRHop struct {
+	TTL int
+	// Addrs are the addresses that answered probes of this TTL, in the
+	// order they first did. There are several behind load balancers.
+	Addrs []net.IP
+	// Names are the reverse DNS names of Addrs, "" if there is none.
+	Names []string
+	ASN   uint32
+	// Sent and Received count the probes sent with this TTL and the
+	// answers to them.
+	Sent     int
+	Received int
+	// Last, Best and Worst are the round trip time of the latest, the
+	// fastest and the slowest answer.
+	Last  time.Duration
+	Best  time.Duration
+	Worst time.Duration
+	// sum and sumSq accumulate the round trip times in nanoseconds for
+	// their mean and standard deviation.
+	sum   float64
+	sumSq float64
+}
+
+// Loss returns the percentage of probes that were not answered.
+func (h *MTRHop) Loss() float64 {
+	if h.Sent == 0 {
+		return 0
+	}
+	return 100 * float64(h.Sent-h.Received) / float64(h.Sent)
+}
+
+// Avg returns the mean round trip time.
+func (h *MTRHop) Avg() time.Duration {
+	if h.Received == 0 {
+		return 0
+	}
+	return time.Duration(h.sum / float64(h.Received))
+}
+
+// StdDev returns the standard deviation of the round trip times.
+func (h *MTRHop) StdDev() time.Duration {
+	if h.Received == 0 {
+		return 0
+	}
+	n := float64(h.Received)
+	mean := h.sum / n
+	return time.Duration(math.Sqrt(max(h.sumSq/n-mean*mean, 0)))
+}
+
+// addAnswer accounts for an answer from addr after rtt.
+func (h *MTRHop) addAnswer(addr net.IP, rtt time.Duration) {
+	h.Received++
+	h.Last = rtt
+	if h.Received == 1 || rtt < h.Best {
+		h.Best = rtt
+	}
+	if rtt > h.Worst {
+		h.Worst = rtt
+	}
+	h.sum += float64(rtt)
+	h.sumSq += float64(rtt) * float64(rtt)
+	for _, a := range h.Addrs {
+		if a.Equal(addr) {
+			return
+		}
+	}
+	h.Addrs = append(h.Addrs, addr)
+	h.Names = append(h.Names, "")
+}
+
+// Report is the state of a continuous trace.
+type Report struct {
+	Host string
+	Dest net.IP
+	// Cycles is the number of times the path was probed.
+	Cycles int
+	Hops   []MTRHop
+}
+
+// MonitorFunc is called with the report of a continuous trace after
+// every cycle. The report must not be retained.
+type MonitorFunc func(r *Report)
+
+// Monitor traces the route described by f over and over like mtr does,
+// sending one probe per TTL and cycle, and keeps the loss and round trip
+// time statistics of every hop. It runs f.Cycles cycles, or until ctx is
+// done if f.Cycles is zero, and calls fn, unless nil, after each of them.
+// Cancelling ctx returns the report so far together with the error of
+// ctx.
+func Monitor(ctx context.Context, f *Flags, fn MonitorFunc) (_ *Report, err error) {
+	if f.MTU {
+		return nil, errMonitorMTU
+	}
+	// Names are looked up once per address rather than every cycle.
+	fc := *f
+	fc.Numeric = true
+	fc.ProbesPerHop = 1
+	t, err := newTracer(&fc)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := t.close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	interval := f.CycleInterval
+	if interval <= 0 {
+		interval = DEFCYCLEINTERVAL
+	}
+	r := &Report{Host: f.Host, Dest: t.dest}
+	resolver := NewResolver(f.DNSServer)
+	timeout := f.LookupTimeout
+	if timeout == 0 {
+		timeout = DEFLOOKUPSEC * time.Second
+	}
+	names := map[string]string{}
+
+	for f.Cycles == 0 || r.Cycles < f.Cycles {
+		if r.Cycles > 0 {
+			t.rearm()
+			timer := time.NewTimer(interval)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return r, ctx.Err()
+			}
+		}
+		printMap, sent, err := t.run(ctx, nil)
+		if err != nil {
+			return r, err
+		}
+		if ctx.Err() != nil {
+			// The cycle was cut short, so its unanswered probes do
+			// not count as lost.
+			return r, ctx.Err()
+		}
+		r.add(printMap, sent, t.mod.Options.FirstTTL)
+
+		if !f.Numeric {
+			for i := range r.Hops {
+				h := &r.Hops[i]
+				for j, addr := range h.Addrs {
+					name, ok := names[addr.String()]
+					if !ok {
+						name = lookupName(ctx, resolver, addr, timeout)
+						names[addr.String()] = name
+					}
+					h.Names[j] = name
+				}
+			}
+		}
+		if fn != nil {
+			fn(r)
+		}
+	}
+	return r, nil
+}
+
+// rearm gives t a fresh trace, so that it can run again.
+func (t *tracer) rearm() {
+	limiter := t.mod.limiter
+	t.cc = Coms{
+		SendChan: make(chan *Probe),
+		RecvChan: make(chan *Probe),
+	}
+	t.mod = NewTrace(t.f.Proto, t.dest, t.mod.SrcIP, t.cc, t.f)
+	t.mod.Capture = t.capture
+	// Rate-limiting routers stay slowed down.
+	t.mod.limiter = limiter
+}
+
+// add accounts for the answers of one cycle to the probes counted per
+// TTL in sent, from firstTTL up to the farthest TTL answered.
+func (r *Report) add(printMap map[int]*Probe, sent map[int]int, firstTTL int) {
+	r.Cycles++
+	if len(printMap) == 0 {
+		return
+	}
+	destTTL := DestTTL(printMap)
+	for ttl := firstTTL; ttl <= destTTL; ttl++ {
+		i := ttl - firstTTL
+		for len(r.Hops) <= i {
+			r.Hops = append(r.Hops, MTRHop{TTL: firstTTL + len(r.Hops)})
+		}
+		h := &r.Hops[i]
+		pbs := GetProbesByTLL(printMap, ttl)
+		h.Sent += max(sent[ttl], len(pbs))
+		for _, pb := range pbs {
+			h.addAnswer(pb.Saddr, pb.RecvTime.Sub(pb.Sendtime))
+			if pb.ASN != 0 {
+				h.ASN = pb.ASN
+			}
+		}
+	}
+}
+
+// WriteText writes r as a table like the report of mtr.
+func (r *Report) WriteText(w io.Writer) error {
+	ms := func(d time.Duration) float64 {
+		return float64(d/time.Microsecond) / 1000
+	}
+	if _, err := fmt.Fprintf(w, "mtr to %s (%s), %d cycles\n", r.Host, r.Dest, r.Cycles); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "%-4s %-40s %6s %5s %5s %8s %8s %8s %8s %8s\n",
+		"TTL", "Host", "Loss%", "Snt", "Rcv", "Last", "Avg", "Best", "Wrst", "StDev"); err != nil {
+		return err
+	}
+	for i := range r.Hops {
+		h := &r.Hops[i]
+		host := "???"
+		if len(h.Addrs) > 0 {
+			host = mtrHost(h, 0)
+		}
+		if _, err := fmt.Fprintf(w, "%-4d %-40s %5.1f%% %5d %5d %8.3f %8.3f %8.3f %8.3f %8.3f\n",
+			h.TTL, host, h.Loss(), h.Sent, h.Received,
+			ms(h.Last), ms(h.Avg()), ms(h.Best), ms(h.Worst), ms(h.StdDev())); err != nil {
+			return err
+		}
+		for j := 1; j < len(h.Addrs); j++ {
+			if _, err := fmt.Fprintf(w, "%-4s %s\n", "", mtrHost(h, j)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mtrHost formats the i-th address of h.
+func mtrHost(h *MTRHop, i int) string {
+	s := h.Addrs[i].String()
+	if h.Names[i] != "" {
+		s = fmt.Sprintf("%s (%s)", h.Names[i], s)
+	}
+	if i == 0 && h.ASN != 0 {
+		s = fmt.Sprintf("[AS%d] %s", h.ASN, s)
+	}
+	return s
+}
@@ -0,0 +1,92 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMTRHopStats(t *testing.T) {
+	h := MTRHop{Sent: 4}
+	ip := net.IPv4(192, 0, 2, 1)
+	// One of the four probes is lost.
+	for _, rtt := range []time.Duration{2, 4, 4} {
+		h.addAnswer(ip, rtt*time.Millisecond)
+	}
+	if got := h.Loss(); got != 25 {
+		t.Errorf("Loss() = %v, want 25", got)
+	}
+	if h.Best != 2*time.Millisecond || h.Worst != 4*time.Millisecond || h.Last != 4*time.Millisecond {
+		t.Errorf("Best, Worst, Last = %v, %v, %v, want 2ms, 4ms, 4ms", h.Best, h.Worst, h.Last)
+	}
+	if got, want := h.Avg(), time.Duration(10*time.Millisecond/3); got != want {
+		t.Errorf("Avg() = %v, want %v", got, want)
+	}
+	// sqrt(((2-10/3)^2 + 2(4-10/3)^2) / 3) is 0.943ms.
+	if got := h.StdDev(); got < 942*time.Microsecond || got > 944*time.Microsecond {
+		t.Errorf("StdDev() = %v, want 0.943ms", got)
+	}
+	if len(h.Addrs) != 1 {
+		t.Errorf("Addrs = %v, want only %v", h.Addrs, ip)
+	}
+
+	var empty MTRHop
+	if empty.Loss() != 0 || empty.Avg() != 0 || empty.StdDev() != 0 {
+		t.Errorf("statistics of a hop that was never probed are not zero")
+	}
+}
+
+func TestReportAdd(t *testing.T) {
+	router := net.IPv4(192, 0, 2, 1)
+	dest := net.IPv4(198, 51, 100, 1)
+	start := time.Now()
+	answer := func(id uint32, ttl int, from net.IP, rtt time.Duration) *Probe {
+		return &Probe{ID: id, TTL: ttl, Saddr: from, Sendtime: start, RecvTime: start.Add(rtt), Done: true}
+	}
+
+	r := &Report{Host: "example.com", Dest: dest}
+	sent := map[int]int{1: 1, 2: 1, 3: 1}
+	r.add(map[int]*Probe{
+		1: answer(1, 1, router, time.Millisecond),
+		3: answer(3, 3, dest, 3*time.Millisecond),
+	}, sent, 1)
+	// All probes were lost.
+	r.add(map[int]*Probe{}, map[int]int{1: 1}, 1)
+	r.add(map[int]*Probe{
+		4: answer(4, 1, router, 2*time.Millisecond),
+		5: answer(5, 2, net.IPv4(203, 0, 113, 1), 2*time.Millisecond),
+		6: answer(6, 3, dest, 4*time.Millisecond),
+	}, sent, 1)
+
+	if r.Cycles != 3 || len(r.Hops) != 3 {
+		t.Fatalf("Cycles = %d, %d hops, want 3, 3", r.Cycles, len(r.Hops))
+	}
+	for i, want := range []struct{ sent, received int }{{2, 2}, {2, 1}, {2, 2}} {
+		h := r.Hops[i]
+		if h.TTL != i+1 || h.Sent != want.sent || h.Received != want.received {
+			t.Errorf("hop %d: TTL %d, %d sent, %d received, want TTL %d, %d, %d",
+				i, h.TTL, h.Sent, h.Received, i+1, want.sent, want.received)
+		}
+	}
+
+	var b bytes.Buffer
+	if err := r.WriteText(&b); err != nil {
+		t.Fatalf("WriteText() = %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("WriteText() wrote %d lines, want 5:\n%s", len(lines), b.String())
+	}
+	if !strings.HasPrefix(lines[0], "mtr to example.com (198.51.100.1), 3 cycles") {
+		t.Errorf("header = %q", lines[0])
+	}
+	if f := strings.Fields(lines[3]); f[1] != "203.0.113.1" || f[2] != "50.0%" {
+		t.Errorf("TTL 2 line = %q, want 203.0.113.1 with 50.0%% loss", lines[3])
+	}
+}
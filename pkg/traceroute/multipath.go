@@ -0,0 +1,122 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"fmt"
+	"sort"
+)
+
+// FlowPath is the list of hops a single flow took to the destination.
+type FlowPath struct {
+	Flow int
+	Port uint16
+	// Hops holds the answered probes of the flow per TTL, Hops[0] being
+	// TTL 1. TTLs without any answer are empty.
+	Hops [][]*Probe
+}
+
+// Link is an edge between hops at consecutive TTLs, seen by at least one
+// flow.
+type Link struct {
+	From string
+	To   string
+	// TTL is the TTL at which From answered.
+	TTL int
+	// Probes counts the pairs of probes that saw the link, across all
+	// flows.
+	Probes int
+	Flows  []int
+}
+
+// Topology is the merge of the paths taken by all flows of a multipath
+// trace.
+type Topology struct {
+	Paths []*FlowPath
+	// Nodes holds the distinct addresses answering per TTL.
+	Nodes map[int][]string
+	Links []*Link
+}
+
+// BuildTopology groups the answered probes of a multipath trace into per
+// flow hop lists and merges them into a topology.
+func BuildTopology(printMap map[int]*Probe, flows int) *Topology {
+	if flows < 1 {
+		flows = 1
+	}
+	topo := &Topology{
+		Nodes: map[int][]string{},
+	}
+
+	for flow := 0; flow < flows; flow++ {
+		path := &FlowPath{Flow: flow}
+		for _, pb := range printMap {
+			if pb.Flow != flow || pb.TTL < 1 {
+				continue
+			}
+			path.Port = pb.Port
+			for len(path.Hops) < pb.TTL {
+				path.Hops = append(path.Hops, nil)
+			}
+			path.Hops[pb.TTL-1] = append(path.Hops[pb.TTL-1], pb)
+		}
+		// A flow may see the destination more than once; it is only the
+		// last hop once.
+		for i, pbs := range path.Hops {
+			if len(pbs) > 0 && pbs[0].Saddr.Equal(pbs[0].Dest) {
+				path.Hops = path.Hops[:i+1]
+				break
+			}
+		}
+		topo.Paths = append(topo.Paths, path)
+	}
+
+	seen := map[string]bool{}
+	links := map[[2]string]*Link{}
+	for _, path := range topo.Paths {
+		for i, pbs := range path.Hops {
+			ttl := i + 1
+			for _, pb := range pbs {
+				key := fmt.Sprintf("%d/%s", ttl, pb.Saddr)
+				if !seen[key] {
+					seen[key] = true
+					topo.Nodes[ttl] = append(topo.Nodes[ttl], pb.Saddr.String())
+				}
+			}
+			if i+1 >= len(path.Hops) {
+				continue
+			}
+			for _, from := range pbs {
+				for _, to := range path.Hops[i+1] {
+					k := [2]string{from.Saddr.String(), to.Saddr.String()}
+					l, ok := links[k]
+					if !ok {
+						l = &Link{From: k[0], To: k[1], TTL: ttl}
+						links[k] = l
+						topo.Links = append(topo.Links, l)
+					}
+					l.Probes++
+					if n := len(l.Flows); n == 0 || l.Flows[n-1] != path.Flow {
+						l.Flows = append(l.Flows, path.Flow)
+					}
+				}
+			}
+		}
+	}
+
+	for _, addrs := range topo.Nodes {
+		sort.Strings(addrs)
+	}
+	sort.Slice(topo.Links, func(i, j int) bool {
+		if topo.Links[i].TTL != topo.Links[j].TTL {
+			return topo.Links[i].TTL < topo.Links[j].TTL
+		}
+		if topo.Links[i].From != topo.Links[j].From {
+			return topo.Links[i].From < topo.Links[j].From
+		}
+		return topo.Links[i].To < topo.Links[j].To
+	})
+	return topo
+}
@@ -0,0 +1,91 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// QuotedHeader holds the source address and the ports of a probe as
+// quoted in the ICMP error it caused. Middleboxes that rewrite them on
+// the way, such as NATs, do not always restore them in the quote.
+type QuotedHeader struct {
+	Src net.IP
+	// SrcPort and DstPort are the ports of UDP, TCP and SCTP probes,
+	// zero for other ones.
+	SrcPort uint16
+	DstPort uint16
+}
+
+// quotedHeader returns the quoted source address src of a probe with the
+// ports from its upper-layer header payload of protocol proto.
+func quotedHeader(src net.IP, proto int, payload []byte) *QuotedHeader {
+	q := &QuotedHeader{Src: src}
+	switch proto {
+	case 6, 17, sctpProto:
+		if len(payload) >= 4 {
+			q.SrcPort = binary.BigEndian.Uint16(payload[0:2])
+			q.DstPort = binary.BigEndian.Uint16(payload[2:4])
+		}
+	}
+	return q
+}
+
+func (m *ICMP4Error) quotedHeader() *QuotedHeader {
+	return quotedHeader(m.Quoted.Src, m.Quoted.Protocol, m.Payload)
+}
+
+func (m *ICMP6Error) quotedHeader() *QuotedHeader {
+	return quotedHeader(m.Quoted.Src, m.NextHeader, m.Payload)
+}
+
+// NATChange describes how the source address and ports a probe was sent
+// with, src, sport and dport, differ from the quoted ones q, e.g.
+// "src 192.168.1.2->203.0.113.7, sport 1234->61000". Ports that are zero
+// on either side are not compared. It returns "" if nothing changed or
+// nothing was quoted.
+func NATChange(src net.IP, sport, dport uint16, q *QuotedHeader) string {
+	if q == nil {
+		return ""
+	}
+	var changes []string
+	if src != nil && q.Src != nil && !src.Equal(q.Src) {
+		changes = append(changes, fmt.Sprintf("src %s->%s", src, q.Src))
+	}
+	if sport != 0 && q.SrcPort != 0 && sport != q.SrcPort {
+		changes = append(changes, fmt.Sprintf("sport %d->%d", sport, q.SrcPort))
+	}
+	if dport != 0 && q.DstPort != 0 && dport != q.DstPort {
+		changes = append(changes, fmt.Sprintf("dport %d->%d", dport, q.DstPort))
+	}
+	return strings.Join(changes, ", ")
+}
+
+// hasNAT reports whether an answer of h quotes its probe rewritten.
+func (h *Hop) hasNAT() bool {
+	for _, p := range h.Probes {
+		if p.NAT != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// natTracker marks the first hop of a trace that quotes probes
+// rewritten.
+type natTracker struct {
+	seen bool
+}
+
+// mark sets NATStart on h if it is the first hop to show a rewrite.
+func (n *natTracker) mark(h *Hop) {
+	if !n.seen && h.hasNAT() {
+		h.NATStart = true
+		n.seen = true
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNATChange(t *testing.T) {
+	src := net.IPv4(192, 168, 1, 2)
+	for _, tt := range []struct {
+		name   string
+		quoted *QuotedHeader
+		want   string
+	}{
+		{name: "NoQuote"},
+		{name: "Unchanged", quoted: &QuotedHeader{Src: src, SrcPort: 1234, DstPort: 33434}},
+		{
+			name:   "Source",
+			quoted: &QuotedHeader{Src: net.IPv4(203, 0, 113, 7), SrcPort: 61000, DstPort: 33434},
+			want:   "src 192.168.1.2->203.0.113.7, sport 1234->61000",
+		},
+		{name: "DstPort", quoted: &QuotedHeader{Src: src, SrcPort: 1234, DstPort: 80}, want: "dport 33434->80"},
+		{name: "NoPorts", quoted: &QuotedHeader{Src: src}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NATChange(src, 1234, 33434, tt.quoted); got != tt.want {
+				t.Errorf("NATChange() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNATFakeNetwork(t *testing.T) {
+	src := net.IPv4(192, 168, 1, 2)
+	dest := net.IPv4(198, 51, 100, 1)
+	routers := []net.IP{net.IPv4(192, 168, 1, 1), net.IPv4(203, 0, 113, 1), net.IPv4(203, 0, 113, 2)}
+
+	cc := Coms{
+		SendChan: make(chan *Probe),
+		RecvChan: make(chan *Probe),
+	}
+	opts := TracerouteOptions{
+		MaxTTL:       6,
+		ProbesPerHop: 1,
+		Interval:     time.Millisecond,
+		Timeout:      time.Second,
+	}
+	tr := NewTrace("udp4", dest, src, cc, &Flags{TracerouteOptions: opts})
+	n := newFakeNetwork(dest.To4(), routers...)
+	n.natHop = 2
+	n.natSrc = net.IPv4(203, 0, 113, 7).To4()
+	tr.Network = n
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go tr.SendTracesUDP4(ctx)
+	printMap := runTransmission(ctx, cc, 1, tr.Options.Timeout, nil)
+
+	r := NewResult("dest", dest, "udp4", opts, 1, printMap)
+	if len(r.Hops) != 4 {
+		t.Fatalf("%d hops, want 4", len(r.Hops))
+	}
+	for _, h := range r.Hops {
+		if len(h.Probes) != 1 {
+			t.Fatalf("TTL %d: %d answers, want 1", h.TTL, len(h.Probes))
+		}
+		rewritten := h.TTL >= n.natHop
+		if got := h.Probes[0].NAT != ""; got != rewritten {
+			t.Errorf("TTL %d: NAT %q, want a rewrite %t", h.TTL, h.Probes[0].NAT, rewritten)
+		}
+		if h.NATStart != (h.TTL == n.natHop) {
+			t.Errorf("TTL %d: NAT start %t, want it on TTL %d only", h.TTL, h.NATStart, n.natHop)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"net"
+	"time"
+)
+
+// DEFINTERVAL is the default pause between two probes.
+const DEFINTERVAL = 100 * time.Millisecond
+
+// TracerouteOptions are the probing parameters of a trace. Zero fields
+// take their default value.
+type TracerouteOptions struct {
+	// FirstTTL is the TTL of the first probes.
+	FirstTTL int
+	// MaxTTL is the largest TTL probed.
+	MaxTTL int
+	// ProbesPerHop is the number of probes sent per TTL.
+	ProbesPerHop int
+	// Timeout is how long to wait for the answer to a probe. Later
+	// answers are ignored.
+	Timeout time.Duration
+	// Interval is the pause between sending two probes. It does not
+	// apply when SimultaneousProbes is set.
+	Interval time.Duration
+	// SimultaneousProbes is the number of probes that may await their
+	// answer at once. Probes for many TTLs are then in flight together
+	// and are only held back by answers or timeouts. Zero sends probes
+	// one after the other, Interval apart.
+	SimultaneousProbes int
+	// IPOption is the IPv4 option probes are sent with. It does not
+	// apply to IPv6.
+	IPOption IPOption
+	// Gateways are the routers IPv4 probes are loose source routed
+	// through, in order, at most MAXGATEWAYS4. Many routers drop source
+	// routed packets.
+	Gateways []net.IP
+	// DontFragment sets the don't fragment flag of IPv4 probes and keeps
+	// the local stack from fragmenting IPv6 probes. Probes too large
+	// for a link are then answered with Fragmentation Needed or Packet
+	// Too Big.
+	DontFragment bool
+	// TCPProbe is the kind of segment TCP probes are, SYN by default.
+	TCPProbe TCPProbe
+	// DestPort is the destination port of UDP and TCP probes. UDP
+	// probes use it as the base of the ports they vary.
+	DestPort uint16
+	// FixedPort sends all UDP probes to DestPort instead of a port of
+	// their own, for targets behind firewalls that only let it through.
+	FixedPort bool
+	// FlowLabel is the flow label of IPv6 probes, at most MAXFLOWLABEL.
+	// Each flow of a multipath trace counts it up by one. Zero leaves it
+	// to the kernel.
+	FlowLabel uint32
+	// DSCP is the differentiated services codepoint probes are sent
+	// with, RFC 2474.
+	DSCP uint8
+	// ECN is the ECN codepoint probes are sent with, RFC 3168.
+	ECN uint8
+	// PacketLen is the length of UDP and ICMP probes including their IP
+	// header, like the packetlen argument of traceroute. Zero sends
+	// DEFPAYLOADLEN bytes of payload. Probes are never shorter than
+	// their headers and the bytes identifying them.
+	PacketLen int
+	// Fill is the pattern the payload of UDP and ICMP probes is filled
+	// with, repeated as needed. Without one the payload counts up.
+	Fill []byte
+}
+
+// DefaultTracerouteOptions returns the options used for traces that do
+// not set them, with DestPort depending on the protocol.
+func DefaultTracerouteOptions(proto string) TracerouteOptions {
+	o := TracerouteOptions{
+		FirstTTL:     DEFFIRSTHOP,
+		MaxTTL:       DEFNUMHOPS,
+		ProbesPerHop: DEFNUMTRACES,
+		Timeout:      DEFWAITSEC * time.Second,
+		Interval:     DEFINTERVAL,
+	}
+	switch proto {
+	case "udp4", "udp6":
+		o.DestPort = DEFUDPPORT
+	case "tcp4", "tcp6":
+		o.DestPort = TCPDEFPORT
+	case "sctp4", "sctp6":
+		o.DestPort = SCTPDEFPORT
+	}
+	return o
+}
+
+// withDefaults returns o with zero fields set from
+// DefaultTracerouteOptions.
+func (o TracerouteOptions) withDefaults(proto string) TracerouteOptions {
+	d := DefaultTracerouteOptions(proto)
+	if o.FirstTTL <= 0 {
+		o.FirstTTL = d.FirstTTL
+	}
+	if o.MaxTTL <= 0 {
+		o.MaxTTL = d.MaxTTL
+	}
+	if o.MaxTTL > MAXHOPS {
+		o.MaxTTL = MAXHOPS
+	}
+	if o.ProbesPerHop <= 0 {
+		o.ProbesPerHop = d.ProbesPerHop
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = d.Timeout
+	}
+	if o.Interval <= 0 {
+		o.Interval = d.Interval
+	}
+	if o.DestPort == 0 {
+		o.DestPort = d.DestPort
+	}
+	return o
+}
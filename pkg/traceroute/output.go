@@ -0,0 +1,359 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OutputCSV prints one line of comma separated values per answered probe.
+const OutputCSV = "csv"
+
+// Header describes a trace that is about to start.
+type Header struct {
+	Host    string
+	Dest    net.IP
+	Proto   string
+	MaxHops int
+	// Flows is the number of flows of a multipath trace, 1 otherwise.
+	Flows int
+}
+
+// OutputWriter prints a trace in one output format. WriteHeader is
+// called before the first probe is sent, WriteHop with every hop as soon
+// as it is complete and WriteResult once the trace is over, with its
+// result and the topology of its flows. A trace that was cancelled ends
+// with the result of the hops found so far. Formats that print the trace
+// as a whole ignore the hops and wait for the result.
+type OutputWriter interface {
+	WriteHeader(h Header) error
+	WriteHop(h Hop) error
+	WriteResult(r *Result, topo *Topology) error
+}
+
+// outputFormats are the formats NewOutputWriter knows, in the order
+// OutputFormats lists them.
+var outputFormats = []struct {
+	name string
+	new  func(w io.Writer) OutputWriter
+}{
+	{OutputText, func(w io.Writer) OutputWriter { return &textWriter{w: w} }},
+	{OutputJSON, func(w io.Writer) OutputWriter { return &jsonWriter{w: w} }},
+	{OutputNDJSON, func(w io.Writer) OutputWriter { return &ndjsonWriter{enc: json.NewEncoder(w)} }},
+	{OutputSummary, func(w io.Writer) OutputWriter { return &summaryWriter{w: w} }},
+	{OutputCSV, func(w io.Writer) OutputWriter { return &csvWriter{w: csv.NewWriter(w)} }},
+	{OutputDOT, func(w io.Writer) OutputWriter { return &dotWriter{w: w} }},
+}
+
+// OutputFormats returns the names of the formats NewOutputWriter knows.
+func OutputFormats() []string {
+	names := make([]string, 0, len(outputFormats))
+	for _, f := range outputFormats {
+		names = append(names, f.name)
+	}
+	return names
+}
+
+// NewOutputWriter returns the writer of format, printing to w. The empty
+// format is text.
+func NewOutputWriter(format string, w io.Writer) (OutputWriter, error) {
+	if format == "" {
+		format = OutputText
+	}
+	for _, f := range outputFormats {
+		if f.name == format {
+			return f.new(w), nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %q", errOutputFormat, format)
+}
+
+// textWriter prints a line per hop like traceroute does. Multipath
+// traces print the path of every flow once the trace is over.
+type textWriter struct {
+	w     io.Writer
+	flows int
+}
+
+func (t *textWriter) WriteHeader(h Header) error {
+	t.flows = h.Flows
+	if h.Flows > 1 {
+		_, err := fmt.Fprintf(t.w, "traceroute to %s (%s), %d hops max, %d flows\n", h.Host, h.Dest, h.MaxHops, h.Flows)
+		return err
+	}
+	_, err := fmt.Fprintf(t.w, "traceroute to %s (%s), %d hops max, %d byte packets\n", h.Host, h.Dest, h.MaxHops, 60)
+	return err
+}
+
+func (t *textWriter) WriteHop(h Hop) error {
+	if t.flows > 1 {
+		return nil
+	}
+	return writeHop(t.w, h)
+}
+
+func (t *textWriter) WriteResult(r *Result, topo *Topology) error {
+	if t.flows > 1 {
+		return writeTopology(t.w, topo)
+	}
+	return nil
+}
+
+// jsonWriter prints the result as a single JSON document.
+type jsonWriter struct {
+	w io.Writer
+}
+
+func (j *jsonWriter) WriteHeader(Header) error { return nil }
+
+func (j *jsonWriter) WriteHop(Hop) error { return nil }
+
+func (j *jsonWriter) WriteResult(r *Result, _ *Topology) error {
+	return r.WriteJSON(j.w)
+}
+
+// ndjsonWriter prints a "hop" record per hop and a closing "summary"
+// record, see Result.WriteNDJSON.
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+func (n *ndjsonWriter) WriteHeader(Header) error { return nil }
+
+func (n *ndjsonWriter) WriteHop(h Hop) error {
+	return n.enc.Encode(ndjsonHop{Type: "hop", Hop: h})
+}
+
+func (n *ndjsonWriter) WriteResult(r *Result, _ *Topology) error {
+	return n.enc.Encode(r.summary())
+}
+
+// summaryWriter prints a table of the loss and round trip time
+// statistics of each hop.
+type summaryWriter struct {
+	w io.Writer
+}
+
+func (s *summaryWriter) WriteHeader(h Header) error {
+	_, err := fmt.Fprintf(s.w, "traceroute to %s (%s), %d hops max\n%-4s %-40s %6s %4s %4s %8s %8s %8s %8s %8s\n",
+		h.Host, h.Dest, h.MaxHops,
+		"TTL", "Host", "Loss%", "Snt", "Rcv", "Min", "Avg", "Max", "StDev", "Jitter")
+	return err
+}
+
+func (s *summaryWriter) WriteHop(h Hop) error {
+	return writeSummaryHop(s.w, h)
+}
+
+func (s *summaryWriter) WriteResult(*Result, *Topology) error { return nil }
+
+// csvHeader are the columns of CSV output.
+var csvHeader = []string{"ttl", "flow", "addr", "name", "asn", "geo", "rtt_ms", "flag", "loss_pct"}
+
+// csvWriter prints a record per answered probe, and one without an
+// address for hops that were not answered at all.
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvWriter) WriteHeader(Header) error {
+	c.w.Write(csvHeader)
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvWriter) WriteHop(h Hop) error {
+	ttl := strconv.Itoa(h.TTL)
+	loss := strconv.FormatFloat(h.Loss, 'f', 1, 64)
+	if len(h.Probes) == 0 {
+		c.w.Write([]string{ttl, "", "", "", "", "", "", "", loss})
+	}
+	for _, p := range h.Probes {
+		var asn, geo string
+		if p.ASN != 0 {
+			asn = strconv.FormatUint(uint64(p.ASN), 10)
+		}
+		if p.Geo != nil {
+			geo = p.Geo.String()
+		}
+		c.w.Write([]string{
+			ttl,
+			strconv.Itoa(p.Flow),
+			p.Addr,
+			p.Name,
+			asn,
+			geo,
+			strconv.FormatFloat(p.RTT, 'f', 3, 64),
+			p.Flag,
+			loss,
+		})
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvWriter) WriteResult(*Result, *Topology) error { return nil }
+
+// dotWriter prints the topology of the trace as a Graphviz graph, see
+// Topology.WriteDOT.
+type dotWriter struct {
+	w    io.Writer
+	name string
+	dest string
+}
+
+func (d *dotWriter) WriteHeader(h Header) error {
+	d.name = "traceroute to " + h.Host
+	d.dest = h.Dest.String()
+	return nil
+}
+
+func (d *dotWriter) WriteHop(Hop) error { return nil }
+
+func (d *dotWriter) WriteResult(_ *Result, topo *Topology) error {
+	return topo.WriteDOT(d.w, d.name, d.dest)
+}
+
+// writeHop writes one line of text output, hops without answers are
+// left out.
+func writeHop(w io.Writer, h Hop) error {
+	if len(h.Probes) == 0 {
+		return nil
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "TTL: %-5d", h.TTL)
+	for _, p := range h.Probes {
+		addr := p.Addr
+		if p.Name != "" {
+			addr = fmt.Sprintf("%s (%s)", p.Name, p.Addr)
+		}
+		fmt.Fprintf(&b, "%-20s ", addr)
+		if p.ASN != 0 {
+			fmt.Fprintf(&b, "[AS%d] ", p.ASN)
+		}
+		if p.Geo != nil {
+			fmt.Fprintf(&b, "[%s] ", p.Geo)
+		}
+		fmt.Fprintf(&b, "(%-7.3fms) ", p.RTT)
+		if len(p.MPLS) > 0 {
+			fmt.Fprintf(&b, "%s ", mplsString(p.MPLS))
+		}
+		if p.TOSChange != "" {
+			fmt.Fprintf(&b, "[%s] ", p.TOSChange)
+		}
+		if p.Flag != "" {
+			fmt.Fprintf(&b, "%s ", p.Flag)
+		}
+		if p.TCPFlags != "" {
+			fmt.Fprintf(&b, "[%s] ", p.TCPFlags)
+		}
+		if p.Recorded != nil {
+			fmt.Fprintf(&b, "{%s} ", p.Recorded)
+		}
+		if p.Asymmetric {
+			fmt.Fprintf(&b, "[%d hops back] ", p.ReturnHops)
+		}
+		// Later hops quote the same rewrite.
+		if h.NATStart && p.NAT != "" {
+			fmt.Fprintf(&b, "[NAT %s] ", p.NAT)
+		}
+	}
+	// The missing answers were most likely dropped by the router, not
+	// lost on the way.
+	if h.RateLimited {
+		b.WriteString("rate-limited")
+	}
+	b.WriteString("\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeSummaryHop writes the line of h in summary output. Hops answered
+// by several routers list them on lines of their own.
+func writeSummaryHop(w io.Writer, h Hop) error {
+	var b strings.Builder
+	var addrs []string
+	for _, p := range h.Probes {
+		addr := p.Addr
+		if p.Name != "" {
+			addr = fmt.Sprintf("%s (%s)", p.Name, p.Addr)
+		}
+		if p.Flag != "" {
+			addr += " " + p.Flag
+		}
+		if !slices.Contains(addrs, addr) {
+			addrs = append(addrs, addr)
+		}
+	}
+	if len(addrs) == 0 {
+		addrs = []string{"*"}
+	}
+	fmt.Fprintf(&b, "%-4d %-40s %5.1f%% %4d %4d", h.TTL, addrs[0], h.Loss, h.Sent, len(h.Probes))
+	if s := h.RTT; s != nil {
+		fmt.Fprintf(&b, " %8.3f %8.3f %8.3f %8.3f %8.3f", s.Min, s.Avg, s.Max, s.StdDev, s.Jitter)
+	}
+	if h.RateLimited {
+		b.WriteString(" rate-limited")
+	}
+	b.WriteString("\n")
+	for _, addr := range addrs[1:] {
+		fmt.Fprintf(&b, "%-4s %s\n", "", addr)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeTopology writes the path of every flow of a multipath trace,
+// then the topology they merge into.
+func writeTopology(w io.Writer, topo *Topology) error {
+	var b strings.Builder
+	for _, path := range topo.Paths {
+		fmt.Fprintf(&b, "flow %d (port %d)\n", path.Flow, path.Port)
+		for i, pbs := range path.Hops {
+			fmt.Fprintf(&b, "TTL: %-5d", i+1)
+			if len(pbs) == 0 {
+				b.WriteString("*")
+			}
+			for _, pb := range pbs {
+				fmt.Fprintf(&b, "%-20s ", hopAddr(pb))
+				if pb.ASN != 0 {
+					fmt.Fprintf(&b, "[AS%d] ", pb.ASN)
+				}
+				if pb.Geo != (Geo{}) {
+					fmt.Fprintf(&b, "[%s] ", pb.Geo)
+				}
+				fmt.Fprintf(&b, "(%-7.3fms) ", float64(pb.RecvTime.Sub(pb.Sendtime)/time.Microsecond)/1000)
+				if len(pb.MPLS) > 0 {
+					fmt.Fprintf(&b, "%s ", mplsString(pb.MPLS))
+				}
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	b.WriteString("merged topology\n")
+	ttls := make([]int, 0, len(topo.Nodes))
+	for ttl := range topo.Nodes {
+		ttls = append(ttls, ttl)
+	}
+	sort.Ints(ttls)
+	for _, ttl := range ttls {
+		fmt.Fprintf(&b, "TTL: %-5d%v\n", ttl, topo.Nodes[ttl])
+	}
+	for _, l := range topo.Links {
+		fmt.Fprintf(&b, "%s -> %s (%d probes, flows %v)\n", l.From, l.To, l.Probes, l.Flows)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
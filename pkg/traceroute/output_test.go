@@ -0,0 +1,87 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestNewOutputWriter(t *testing.T) {
+	for _, format := range append(OutputFormats(), "") {
+		if _, err := NewOutputWriter(format, io.Discard); err != nil {
+			t.Errorf("NewOutputWriter(%q) = %v, want nil", format, err)
+		}
+	}
+	if _, err := NewOutputWriter("xml", io.Discard); !errors.Is(err, errOutputFormat) {
+		t.Errorf("NewOutputWriter(xml) = %v, want %v", err, errOutputFormat)
+	}
+}
+
+// writeTrace writes a trace of hops to dest through the writer of
+// format.
+func writeTrace(t *testing.T, format string, hops []Hop) string {
+	t.Helper()
+	var b strings.Builder
+	w, err := NewOutputWriter(format, &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := net.IPv4(198, 51, 100, 1)
+	if err := w.WriteHeader(Header{Host: "example.org", Dest: dest, Proto: "udp4", MaxHops: 30, Flows: 1}); err != nil {
+		t.Fatalf("WriteHeader() = %v", err)
+	}
+	for _, h := range hops {
+		if err := w.WriteHop(h); err != nil {
+			t.Fatalf("WriteHop() = %v", err)
+		}
+	}
+	r := &Result{Host: "example.org", Dest: dest.String(), Proto: "udp4", MaxHops: 30, Reached: true, Hops: hops}
+	if err := w.WriteResult(r, &Topology{Nodes: map[int][]string{}}); err != nil {
+		t.Fatalf("WriteResult() = %v", err)
+	}
+	return b.String()
+}
+
+func TestOutputWriters(t *testing.T) {
+	hops := []Hop{
+		{TTL: 1, Sent: 2, Loss: 50, Probes: []HopProbe{
+			{Addr: "192.0.2.1", Name: "gw.example.org", RTT: 1.5, ASN: 64496, Geo: &Geo{Country: "DE"}},
+		}},
+		{TTL: 2, Sent: 2, Loss: 100, Probes: []HopProbe{}},
+		{TTL: 3, Sent: 2, Probes: []HopProbe{
+			{Addr: "198.51.100.1", RTT: 3.25, Flag: "!P"},
+			{Addr: "198.51.100.1", RTT: 3.5, Flag: "!P"},
+		}},
+	}
+	for _, tt := range []struct {
+		format string
+		want   string
+	}{
+		{
+			format: OutputCSV,
+			want: "ttl,flow,addr,name,asn,geo,rtt_ms,flag,loss_pct\n" +
+				"1,0,192.0.2.1,gw.example.org,64496,DE,1.500,,50.0\n" +
+				"2,,,,,,,,100.0\n" +
+				"3,0,198.51.100.1,,,,3.250,!P,0.0\n" +
+				"3,0,198.51.100.1,,,,3.500,!P,0.0\n",
+		},
+		{
+			format: OutputText,
+			want: "traceroute to example.org (198.51.100.1), 30 hops max, 60 byte packets\n" +
+				"TTL: 1    gw.example.org (192.0.2.1) [AS64496] [DE] (1.500  ms) \n" +
+				"TTL: 3    198.51.100.1         (3.250  ms) !P 198.51.100.1         (3.500  ms) !P \n",
+		},
+	} {
+		t.Run(tt.format, func(t *testing.T) {
+			if got := writeTrace(t, tt.format, hops); got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
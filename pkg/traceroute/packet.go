@@ -0,0 +1,159 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"encoding/binary"
+	"net"
+
+	"golang.org/x/net/ipv4"
+)
+
+// Lengths of the headers probes are built of, without options.
+const (
+	UDPHeaderLen  = 8
+	TCPHeaderLen  = 20
+	ICMPHeaderLen = 8
+	SCTPHeaderLen = 12
+	SCTPChunkLen  = 4
+)
+
+// Marshal returns the wire format of h.
+func (h *UDPHeader) Marshal() []byte {
+	b := make([]byte, UDPHeaderLen)
+	binary.BigEndian.PutUint16(b[0:], h.Src)
+	binary.BigEndian.PutUint16(b[2:], h.Dst)
+	binary.BigEndian.PutUint16(b[4:], h.Length)
+	binary.BigEndian.PutUint16(b[6:], h.Chksum)
+	return b
+}
+
+// Marshal returns the wire format of h. Options are not part of h and
+// follow it.
+func (h *TCPHeader) Marshal() []byte {
+	b := make([]byte, TCPHeaderLen)
+	binary.BigEndian.PutUint16(b[0:], h.Src)
+	binary.BigEndian.PutUint16(b[2:], h.Dst)
+	binary.BigEndian.PutUint32(b[4:], h.SeqNum)
+	binary.BigEndian.PutUint32(b[8:], h.AckNum)
+	b[12] = h.DataOffset
+	b[13] = h.Flags
+	binary.BigEndian.PutUint16(b[14:], h.Window)
+	binary.BigEndian.PutUint16(b[16:], h.Checksum)
+	binary.BigEndian.PutUint16(b[18:], h.Urgent)
+	return b
+}
+
+// Marshal returns the wire format of h.
+func (h *ICMPHeader) Marshal() []byte {
+	b := make([]byte, ICMPHeaderLen)
+	b[0] = h.IType
+	b[1] = h.ICode
+	binary.BigEndian.PutUint16(b[2:], h.Checksum)
+	binary.BigEndian.PutUint16(b[4:], h.ID)
+	binary.BigEndian.PutUint16(b[6:], h.Seq)
+	return b
+}
+
+// Marshal returns the wire format of h.
+func (h *SCTPHeader) Marshal() []byte {
+	b := make([]byte, SCTPHeaderLen)
+	binary.BigEndian.PutUint16(b[0:], h.Src)
+	binary.BigEndian.PutUint16(b[2:], h.Dst)
+	binary.BigEndian.PutUint32(b[4:], h.VerTag)
+	binary.BigEndian.PutUint32(b[8:], h.Checksum)
+	return b
+}
+
+// Marshal returns the wire format of c.
+func (c *SCTPChunk) Marshal() []byte {
+	b := make([]byte, SCTPChunkLen)
+	b[0] = c.Type
+	b[1] = c.Flags
+	binary.BigEndian.PutUint16(b[2:], c.Length)
+	return b
+}
+
+// packet joins the marshalled headers of a packet and its payload.
+func packet(parts ...[]byte) []byte {
+	var n int
+	for _, p := range parts {
+		n += len(p)
+	}
+	b := make([]byte, 0, n)
+	for _, p := range parts {
+		b = append(b, p...)
+	}
+	return b
+}
+
+// checksum function
+func checkSum(buf []byte) uint16 {
+	sum := uint32(0)
+
+	for ; len(buf) >= 2; buf = buf[2:] {
+		sum += uint32(buf[0])<<8 | uint32(buf[1])
+	}
+	if len(buf) > 0 {
+		sum += uint32(buf[0]) << 8
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	csum := ^uint16(sum)
+	/*
+	 * From RFC 768:
+	 * If the computed checksum is zero, it is transmitted as all ones (the
+	 * equivalent in one's complement arithmetic). An all zero transmitted
+	 * checksum value means that the transmitter generated no checksum (for
+	 * debugging or for higher level protocols that don't care).
+	 */
+	if csum == 0 {
+		csum = 0xffff
+	}
+	return csum
+}
+
+// pseudoHeader4 returns the IPv4 pseudo-header the UDP and TCP checksums
+// of a segment of n bytes cover, RFC 768 and RFC 793.
+func pseudoHeader4(ip *ipv4.Header, proto uint8, n int) []byte {
+	b := make([]byte, 12)
+	copy(b[0:4], ip.Src.To4())
+	copy(b[4:8], ip.Dst.To4())
+	b[9] = proto
+	binary.BigEndian.PutUint16(b[10:], uint16(n))
+	return b
+}
+
+// pseudoHeader6 returns the IPv6 pseudo-header the checksum of an upper
+// layer packet of n bytes from src to dst covers, RFC 8200.
+func pseudoHeader6(src, dst net.IP, proto uint8, n int) []byte {
+	b := make([]byte, 40)
+	copy(b[0:16], src.To16())
+	copy(b[16:32], dst.To16())
+	binary.BigEndian.PutUint32(b[32:], uint32(n))
+	b[39] = proto
+	return b
+}
+
+// checksum sets the checksum of u, sent in ip with payload.
+func (u *UDPHeader) checksum(ip *ipv4.Header, payload []byte) {
+	u.Chksum = 0
+	u.Chksum = checkSum(packet(pseudoHeader4(ip, 17, int(u.Length)), u.Marshal(), payload))
+}
+
+// checksum sets the checksum of t, sent in ip with payload. The payload
+// includes any options.
+func (t *TCPHeader) checksum(ip *ipv4.Header, payload []byte) {
+	t.Checksum = 0
+	t.Checksum = checkSum(packet(pseudoHeader4(ip, 6, TCPHeaderLen+len(payload)), t.Marshal(), payload))
+}
+
+// checksum sets the checksum of an ICMPv4 message of h and payload.
+// ICMPv6 checksums are left to the kernel.
+func (h *ICMPHeader) checksum(payload []byte) {
+	h.Checksum = 0
+	h.Checksum = checkSum(packet(h.Marshal(), payload))
+}
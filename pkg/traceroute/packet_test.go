@@ -0,0 +1,114 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"encoding/hex"
+	"flag"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/ipv4"
+)
+
+var update = flag.Bool("update", false, "update the golden packets in testdata")
+
+// goldenTrace returns the trace the golden packets are built with.
+func goldenTrace(proto string, packetLen int) *Trace {
+	src, dst := net.IPv4(192, 0, 2, 2), net.IPv4(198, 51, 100, 1)
+	if strings.HasSuffix(proto, "6") {
+		src, dst = net.ParseIP("2001:db8::2"), net.ParseIP("2001:db8::1")
+	}
+	return NewTrace(proto, dst, src, Coms{}, &Flags{TracerouteOptions: TracerouteOptions{PacketLen: packetLen}})
+}
+
+// ipv4Packet returns the IPv4 packet of hdr and payload as built by a
+// probe builder.
+func ipv4Packet(hdr *ipv4.Header, payload []byte, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	b, err := hdr.Marshal()
+	return append(b, payload...), err
+}
+
+// hexDump formats b 16 bytes per line.
+func hexDump(b []byte) string {
+	var s strings.Builder
+	for len(b) > 0 {
+		n := min(len(b), 16)
+		s.WriteString(hex.EncodeToString(b[:n]))
+		s.WriteByte('\n')
+		b = b[n:]
+	}
+	return s.String()
+}
+
+func TestGoldenPackets(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		build func() ([]byte, error)
+	}{
+		{name: "udp4", build: func() ([]byte, error) {
+			return ipv4Packet(goldenTrace("udp4", 0).BuildUDP4Pkt(1234, 33434, 5, 7, 0))
+		}},
+		// Lengths and checksums of packets beyond 255 bytes take both
+		// of their bytes.
+		{name: "udp4_long", build: func() ([]byte, error) {
+			return ipv4Packet(goldenTrace("udp4", 360).BuildUDP4Pkt(1234, 33434, 5, 7, 0))
+		}},
+		{name: "tcp4_syn", build: func() ([]byte, error) {
+			return ipv4Packet(goldenTrace("tcp4", 0).BuildTCP4SYNPkt(1234, 80, 5, 0x01020304, 0))
+		}},
+		{name: "tcp4_ack", build: func() ([]byte, error) {
+			return ipv4Packet(goldenTrace("tcp4", 0).BuildTCP4ProbePkt(1234, 80, 5, 0x01020304, 0, TCPProbeACK))
+		}},
+		{name: "icmp4", build: func() ([]byte, error) {
+			return ipv4Packet(goldenTrace("icmp4", 0).BuildICMP4Pkt(5, 0x4242, 7, 0))
+		}},
+		{name: "icmp4_long", build: func() ([]byte, error) {
+			return ipv4Packet(goldenTrace("icmp4", 400).BuildICMP4Pkt(5, 0x4242, 7, 0))
+		}},
+		{name: "sctp4", build: func() ([]byte, error) {
+			return ipv4Packet(goldenTrace("sctp4", 0).BuildSCTP4InitPkt(1234, 80, 5, 7, 0))
+		}},
+		{name: "udp6_long", build: func() ([]byte, error) {
+			_, b := goldenTrace("udp6", 400).BuildUDP6Pkt(1234, 33434, 5, 7, 0)
+			return b, nil
+		}},
+		{name: "tcp6_syn", build: func() ([]byte, error) {
+			_, b := goldenTrace("tcp6", 0).BuildTCP6SYNPkt(1234, 80, 5, 0x01020304, 0)
+			return b, nil
+		}},
+		{name: "icmp6", build: func() ([]byte, error) {
+			_, b := goldenTrace("icmp6", 0).BuildICMP6Pkt(5, 0x4242, 7, 0)
+			return b, nil
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := tt.build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := hexDump(b)
+			golden := filepath.Join("testdata", tt.name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("packet =\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+// DEFPAYLOADLEN is the payload length of UDP and ICMP probes unless
+// TracerouteOptions.PacketLen is set.
+const DEFPAYLOADLEN = 32
+
+// payloadLen returns the payload length of probes with headers bytes of
+// headers in front of it, but no less than least bytes.
+func (o TracerouteOptions) payloadLen(headers, least int) int {
+	if o.PacketLen <= 0 {
+		return max(DEFPAYLOADLEN, least)
+	}
+	return max(o.PacketLen-headers, least)
+}
+
+// fill fills b with the pattern of o, or with bytes counting up from 64
+// if it has none.
+func (o TracerouteOptions) fill(b []byte) {
+	if len(o.Fill) == 0 {
+		for i := range b {
+			b[i] = uint8(i + 64)
+		}
+		return
+	}
+	for i := range b {
+		b[i] = o.Fill[i%len(o.Fill)]
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/ipv6"
+)
+
+// Constants of the pcap file format. Packets are stored as raw IP, so
+// that IPv4 and IPv6 traces share the link type.
+const (
+	pcapMagic      = 0xa1b2c3d4
+	pcapSnapLen    = 65535
+	pcapLinkTypeIP = 101
+)
+
+// PcapWriter writes packets to a pcap file, for Wireshark or tcpdump to
+// read. It is safe for concurrent use.
+type PcapWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewPcapWriter writes the pcap file header to w and returns the writer
+// of the packets following it.
+func NewPcapWriter(w io.Writer) (*PcapWriter, error) {
+	var hdr [24]byte
+	binary.LittleEndian.PutUint32(hdr[0:], pcapMagic)
+	binary.LittleEndian.PutUint16(hdr[4:], 2)
+	binary.LittleEndian.PutUint16(hdr[6:], 4)
+	binary.LittleEndian.PutUint32(hdr[16:], pcapSnapLen)
+	binary.LittleEndian.PutUint32(hdr[20:], pcapLinkTypeIP)
+	if _, err := w.Write(hdr[:]); err != nil {
+		return nil, err
+	}
+	return &PcapWriter{w: w}, nil
+}
+
+// WritePacket writes the IP packet pkt, captured at ts.
+func (p *PcapWriter) WritePacket(ts time.Time, pkt []byte) error {
+	capLen := min(len(pkt), pcapSnapLen)
+	var hdr [16]byte
+	binary.LittleEndian.PutUint32(hdr[0:], uint32(ts.Unix()))
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(ts.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(hdr[8:], uint32(capLen))
+	binary.LittleEndian.PutUint32(hdr[12:], uint32(len(pkt)))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, err := p.w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := p.w.Write(pkt[:capLen])
+	return err
+}
+
+// openCapture records the traffic of t in a new pcap file at path.
+func (t *tracer) openCapture(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(file)
+	w, err := NewPcapWriter(bw)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	t.capture = w
+	t.mod.Capture = w
+	t.closeCapture = func() error {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		return errors.Join(bw.Flush(), file.Close())
+	}
+	return nil
+}
+
+// close finishes the capture file of t, if any.
+func (t *tracer) close() error {
+	if t.closeCapture == nil {
+		return nil
+	}
+	return t.closeCapture()
+}
+
+// ipv6Header builds the IPv6 header in front of a payload of n bytes.
+func ipv6Header(src, dst net.IP, nextHeader, hopLimit, tc int, flowLabel uint32, n int) []byte {
+	h := make([]byte, ipv6.HeaderLen)
+	binary.BigEndian.PutUint32(h[0:], 6<<28|uint32(tc&0xff)<<20|flowLabel&MAXFLOWLABEL)
+	binary.BigEndian.PutUint16(h[4:], uint16(n))
+	h[6] = byte(nextHeader)
+	h[7] = byte(hopLimit)
+	copy(h[8:24], src.To16())
+	copy(h[24:40], dst.To16())
+	return h
+}
+
+// ipv4Header builds the IPv4 header in front of a payload of n bytes.
+func ipv4Header(src, dst net.IP, protocol, ttl, n int) []byte {
+	h := make([]byte, IPV4HdrMinLen)
+	h[0] = 4<<4 | IPV4HdrMinLen/4
+	binary.BigEndian.PutUint16(h[2:], uint16(IPV4HdrMinLen+n))
+	h[8] = byte(ttl)
+	h[9] = byte(protocol)
+	copy(h[12:16], src.To4())
+	copy(h[16:20], dst.To4())
+	binary.BigEndian.PutUint16(h[10:], checkSum(h))
+	return h
+}
+
+// ipProtocol returns the protocol number of a raw IP network, e.g.
+// "ip4:icmp" or "ip4:132".
+func ipProtocol(network string) int {
+	proto := network[strings.IndexByte(network, ':')+1:]
+	switch proto {
+	case "icmp":
+		return 1
+	case "tcp":
+		return 6
+	case "udp":
+		return 17
+	case "ipv6-icmp":
+		return 58
+	}
+	n, _ := strconv.Atoi(proto)
+	return n
+}
+
+// captureNetwork records every probe sent and every message received on
+// the connections of a Network.
+type captureNetwork struct {
+	Network
+	w *PcapWriter
+	// local is the address replies are addressed to.
+	local net.IP
+}
+
+func (n *captureNetwork) ProbeConn(network string) (ProbeConn, error) {
+	c, err := n.Network.ProbeConn(network)
+	if err != nil {
+		return nil, err
+	}
+	return &captureConn{ProbeConn: c, n: n, proto: ipProtocol(network)}, nil
+}
+
+func (n *captureNetwork) ReplyConn(network string, laddr net.IP) (ProbeConn, error) {
+	c, err := n.Network.ReplyConn(network, laddr)
+	if err != nil {
+		return nil, err
+	}
+	return &captureConn{ProbeConn: c, n: n, proto: ipProtocol(network)}, nil
+}
+
+type captureConn struct {
+	ProbeConn
+	n     *captureNetwork
+	proto int
+}
+
+func (c *captureConn) WritePacket(p *Packet, dst net.IP) error {
+	if err := c.ProbeConn.WritePacket(p, dst); err != nil {
+		return err
+	}
+	// The probe is out, failing to record it must not fail the trace.
+	var hdr []byte
+	switch {
+	case p.IPv4 != nil:
+		h, err := p.IPv4.Marshal()
+		if err != nil {
+			return nil
+		}
+		hdr = h
+	case p.IPv6 != nil:
+		hdr = ipv6Header(c.n.local, dst, c.proto, p.IPv6.HopLimit, p.IPv6.TrafficClass, p.FlowLabel, len(p.Payload))
+	}
+	c.n.w.WritePacket(time.Now(), append(hdr, p.Payload...))
+	return nil
+}
+
+// ReadReply records the messages read. Their IP header is not returned
+// by the socket and rebuilt, with a placeholder TTL of 64 unless the
+// socket tells the real one.
+func (c *captureConn) ReadReply(b []byte) (int, net.IP, error) {
+	n, from, _, err := c.ReadReplyInfo(b)
+	return n, from, err
+}
+
+// ReadReplyInfo records the messages read with the time they were
+// received.
+func (c *captureConn) ReadReplyInfo(b []byte) (int, net.IP, ReplyInfo, error) {
+	n, from, ri, err := readReply(c.ProbeConn, b)
+	if err != nil {
+		return n, from, ri, err
+	}
+	ttl := ri.TTL
+	if ttl == 0 {
+		ttl = 64
+	}
+	var hdr []byte
+	if from.To4() != nil {
+		hdr = ipv4Header(from, c.n.local, c.proto, ttl, n)
+	} else {
+		hdr = ipv6Header(from, c.n.local, c.proto, ttl, 0, 0, n)
+	}
+	c.n.w.WritePacket(ri.At, append(hdr, b[:n]...))
+	return n, from, ri, nil
+}
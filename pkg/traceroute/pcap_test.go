@@ -0,0 +1,104 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// readPcap returns the packets of a pcap file written by PcapWriter.
+func readPcap(t *testing.T, b []byte) [][]byte {
+	t.Helper()
+	if len(b) < 24 || binary.LittleEndian.Uint32(b) != pcapMagic || binary.LittleEndian.Uint32(b[20:]) != pcapLinkTypeIP {
+		t.Fatalf("bad pcap file header %x", b[:min(len(b), 24)])
+	}
+	var pkts [][]byte
+	for b = b[24:]; len(b) > 0; {
+		if len(b) < 16 {
+			t.Fatalf("truncated record header")
+		}
+		n := int(binary.LittleEndian.Uint32(b[8:]))
+		if orig := int(binary.LittleEndian.Uint32(b[12:])); orig != n || len(b) < 16+n {
+			t.Fatalf("record of %d bytes, originally %d, with %d bytes left", n, orig, len(b)-16)
+		}
+		pkts = append(pkts, b[16:16+n])
+		b = b[16+n:]
+	}
+	return pkts
+}
+
+func TestPcapWriter(t *testing.T) {
+	var b bytes.Buffer
+	w, err := NewPcapWriter(&b)
+	if err != nil {
+		t.Fatalf("NewPcapWriter() = %v", err)
+	}
+	ts := time.Unix(1700000000, 123456000)
+	if err := w.WritePacket(ts, []byte{0x45, 1, 2, 3}); err != nil {
+		t.Fatalf("WritePacket() = %v", err)
+	}
+	pkts := readPcap(t, b.Bytes())
+	if len(pkts) != 1 || !bytes.Equal(pkts[0], []byte{0x45, 1, 2, 3}) {
+		t.Fatalf("packets = %x, want the one written", pkts)
+	}
+	rec := b.Bytes()[24:]
+	if sec, usec := binary.LittleEndian.Uint32(rec), binary.LittleEndian.Uint32(rec[4:]); sec != 1700000000 || usec != 123456 {
+		t.Errorf("timestamp = %d.%06d, want 1700000000.123456", sec, usec)
+	}
+}
+
+func TestCaptureFakeNetwork(t *testing.T) {
+	src := net.IPv4(192, 0, 2, 100)
+	dest := net.IPv4(198, 51, 100, 1)
+	router := net.IPv4(192, 0, 2, 1)
+	cc := Coms{
+		SendChan: make(chan *Probe),
+		RecvChan: make(chan *Probe),
+	}
+	tr := NewTrace("udp4", dest, src, cc, &Flags{TracerouteOptions: TracerouteOptions{
+		MaxTTL:       2,
+		ProbesPerHop: 1,
+		Interval:     time.Millisecond,
+		Timeout:      time.Second,
+	}})
+	tr.Network = newFakeNetwork(dest.To4(), router)
+	var b bytes.Buffer
+	w, err := NewPcapWriter(&b)
+	if err != nil {
+		t.Fatalf("NewPcapWriter() = %v", err)
+	}
+	tr.Capture = w
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go tr.SendTracesUDP4(ctx)
+	runTransmission(ctx, cc, 1, tr.Options.Timeout, nil)
+	cancel()
+	tr.Wait()
+
+	var probes, replies int
+	for _, pkt := range readPcap(t, b.Bytes()) {
+		// checkSum reports a correct checksum as all ones.
+		if pkt[0]>>4 != 4 || len(pkt) < IPV4HdrMinLen || checkSum(pkt[:IPV4HdrMinLen]) != 0xffff {
+			t.Fatalf("packet %x is no valid IPv4 packet", pkt)
+		}
+		from, to := net.IP(pkt[12:16]), net.IP(pkt[16:20])
+		switch {
+		case pkt[9] == 17 && from.Equal(src) && to.Equal(dest):
+			probes++
+		case pkt[9] == 1 && to.Equal(src) && (from.Equal(router) || from.Equal(dest)):
+			replies++
+		default:
+			t.Errorf("unexpected packet %x", pkt)
+		}
+	}
+	if probes != 2 || replies != 2 {
+		t.Errorf("captured %d probes and %d replies, want 2 and 2", probes, replies)
+	}
+}
@@ -0,0 +1,287 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// Smallest MTUs every IPv4 and IPv6 link must support.
+const (
+	MINMTU4 = 68
+	MINMTU6 = 1280
+)
+
+// DEFMTU is the MTU path MTU discovery starts with if the MTU of the
+// outgoing interface is unknown.
+const DEFMTU = 1500
+
+// mtuPlateaus are the common MTUs of RFC 1191, used when a router does
+// not report the next-hop MTU or the local stack refuses a packet.
+var mtuPlateaus = []int{65535, 32000, 17914, 8166, 4352, 2002, 1492, 1006, 508, 296, 68}
+
+// maxMTUUpdates bounds how often the probe size of a single hop shrinks.
+const maxMTUUpdates = 16
+
+var errMTUProto = errors.New("path MTU discovery requires UDP probes")
+
+// MTUHop is a hop found by path MTU discovery.
+type MTUHop struct {
+	TTL int
+	// Addr is the address of the router or destination that answered,
+	// nil if no probe was answered.
+	Addr net.IP
+	RTT  time.Duration
+	// PMTU is the path MTU up to and including this hop.
+	PMTU int
+	// Reached is set if the destination itself answered.
+	Reached bool
+}
+
+// nextPlateau returns the largest common MTU below size, but no less
+// than floor.
+func nextPlateau(size, floor int) int {
+	for _, p := range mtuPlateaus {
+		if p < size {
+			return max(p, floor)
+		}
+	}
+	return floor
+}
+
+// interfaceMTU returns the MTU of the interface ip is assigned to.
+func interfaceMTU(ip net.IP) int {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return DEFMTU
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if n, ok := addr.(*net.IPNet); ok && n.IP.Equal(ip) {
+				return iface.MTU
+			}
+		}
+	}
+	return DEFMTU
+}
+
+// mtuAnswer is the outcome of a single path MTU probe.
+type mtuAnswer struct {
+	addr net.IP
+	rtt  time.Duration
+	// mtu is the MTU a Fragmentation Needed or Packet Too Big message
+	// reported, -1 for any other answer.
+	mtu     int
+	reached bool
+	// final is set for errors that end the trace.
+	final bool
+}
+
+// mtuProber sends a single probe of size bytes and waits for its answer.
+// It returns nil if no answer arrived in time.
+type mtuProber func(ttl, size int, seq uint16) (*mtuAnswer, error)
+
+// DiscoverPathMTU traces the path to DestIP like tracepath does: UDP
+// probes with the don't fragment bit set start at the MTU of the
+// outgoing interface and shrink whenever a router reports that they do
+// not fit the next link. Each hop is reported together with the path
+// MTU up to it. Discovery stops with the hops found so far once ctx is
+// done.
+func (t *Trace) DiscoverPathMTU(ctx context.Context) ([]MTUHop, error) {
+	if t.DestIP.To4() != nil {
+		return t.discoverPathMTU4(ctx)
+	}
+	return t.discoverPathMTU6(ctx)
+}
+
+func (t *Trace) discoverPathMTU(ctx context.Context, probe mtuProber, mtu, minMTU int) ([]MTUHop, error) {
+	var hops []MTUHop
+	seq := uint16(0)
+	for ttl := t.Options.FirstTTL; ttl <= t.Options.MaxTTL; ttl++ {
+		hop := MTUHop{TTL: ttl}
+		updates := 0
+		for j := 0; j < t.Options.ProbesPerHop; j++ {
+			if err := ctx.Err(); err != nil {
+				return hops, err
+			}
+			seq++
+			ans, err := probe(ttl, mtu, seq)
+			if errors.Is(err, syscall.EMSGSIZE) && mtu > minMTU && updates < maxMTUUpdates {
+				mtu = nextPlateau(mtu, minMTU)
+				updates++
+				j--
+				continue
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					return hops, ctx.Err()
+				}
+				return hops, err
+			}
+			if ans == nil {
+				continue
+			}
+			if ans.mtu >= 0 && updates < maxMTUUpdates {
+				if ans.mtu == 0 || ans.mtu >= mtu {
+					ans.mtu = nextPlateau(mtu, minMTU)
+				}
+				mtu = max(ans.mtu, minMTU)
+				updates++
+				// Try again with the smaller probe.
+				j--
+				continue
+			}
+			hop.Addr = ans.addr
+			hop.RTT = ans.rtt
+			hop.Reached = ans.reached
+			if ans.reached || ans.final {
+				hop.PMTU = mtu
+				return append(hops, hop), nil
+			}
+			break
+		}
+		hop.PMTU = mtu
+		hops = append(hops, hop)
+	}
+	return hops, nil
+}
+
+func (t *Trace) discoverPathMTU4(ctx context.Context) ([]MTUHop, error) {
+	conn, err := t.network().ProbeConn("ip4:udp")
+	if err != nil {
+		return nil, sockErr("opening probe socket", err)
+	}
+	defer conn.Close()
+
+	icmpConn, err := t.network().ReplyConn("ip4:icmp", nil)
+	if err != nil {
+		return nil, sockErr("opening ICMP socket", err)
+	}
+	defer icmpConn.Close()
+	// Closing the socket interrupts a probe waiting for its answer.
+	defer context.AfterFunc(ctx, func() { icmpConn.Close() })()
+
+	dest := t.DestIP.To4()
+	sport := uint16(1000 + t.PortOffset + rand.Int31n(500))
+	buf := make([]byte, 1500)
+	probe := func(ttl, size int, seq uint16) (*mtuAnswer, error) {
+		dport := t.destPort + seq
+		hdr, pl, err := t.buildUDP4Pkt(sport, dport, uint8(ttl), seq, t.Options.TOS(), size-ipv4.HeaderLen-8, true)
+		if err != nil {
+			return nil, err
+		}
+		sent := time.Now()
+		if err := conn.WritePacket(&Packet{IPv4: hdr, Payload: pl}, t.DestIP); err != nil {
+			return nil, sockErr("sending probe", err)
+		}
+		icmpConn.SetReadDeadline(sent.Add(t.Options.Timeout))
+		for {
+			n, from, ri, err := readReply(icmpConn, buf)
+			if err != nil {
+				var nerr net.Error
+				if errors.As(err, &nerr) && nerr.Timeout() {
+					return nil, nil
+				}
+				return nil, sockErr("reading reply", err)
+			}
+			icmpErr, err := ParseICMP4Error(buf[:n])
+			if err != nil || !icmpErr.Quoted.Dst.Equal(dest) || icmpErr.Quoted.Protocol != 17 {
+				continue
+			}
+			if len(icmpErr.Payload) < 4 || binary.BigEndian.Uint16(icmpErr.Payload[2:4]) != dport {
+				continue
+			}
+			ans := &mtuAnswer{
+				addr: from,
+				rtt:  ri.At.Sub(sent),
+				mtu:  -1,
+			}
+			switch {
+			case icmpErr.Type == ICMP4DstUnreach && icmpErr.Code == ICMP4FragNeeded:
+				ans.mtu = icmpErr.MTU
+			case icmpErr.Type == ICMP4DstUnreach && icmpErr.Code == ICMP4PortUnreach:
+				ans.reached = true
+			case icmpErr.Type == ICMP4DstUnreach:
+				ans.final = true
+			}
+			return ans, nil
+		}
+	}
+
+	return t.discoverPathMTU(ctx, probe, interfaceMTU(t.SrcIP), MINMTU4)
+}
+
+func (t *Trace) discoverPathMTU6(ctx context.Context) ([]MTUHop, error) {
+	conn, err := t.network().ProbeConn("ip6:udp")
+	if err != nil {
+		return nil, sockErr("opening probe socket", err)
+	}
+	defer conn.Close()
+
+	icmpConn, err := t.network().ReplyConn("ip6:ipv6-icmp", nil)
+	if err != nil {
+		return nil, sockErr("opening ICMPv6 socket", err)
+	}
+	defer icmpConn.Close()
+	defer context.AfterFunc(ctx, func() { icmpConn.Close() })()
+
+	sport := uint16(1000 + t.PortOffset + rand.Int31n(500))
+	buf := make([]byte, 1500)
+	probe := func(ttl, size int, seq uint16) (*mtuAnswer, error) {
+		dport := t.destPort + seq
+		cm, pl := t.buildUDP6Pkt(sport, dport, uint8(ttl), seq, t.Options.TOS(), size-ipv6.HeaderLen-8)
+		sent := time.Now()
+		if err := conn.WritePacket(&Packet{IPv6: cm, Payload: pl, DontFragment: true}, t.DestIP); err != nil {
+			return nil, sockErr("sending probe", err)
+		}
+		icmpConn.SetReadDeadline(sent.Add(t.Options.Timeout))
+		for {
+			n, from, ri, err := readReply(icmpConn, buf)
+			if err != nil {
+				var nerr net.Error
+				if errors.As(err, &nerr) && nerr.Timeout() {
+					return nil, nil
+				}
+				return nil, sockErr("reading reply", err)
+			}
+			icmpErr, err := ParseICMP6Error(buf[:n])
+			if err != nil || !icmpErr.dst().Equal(t.DestIP) || icmpErr.NextHeader != 17 {
+				continue
+			}
+			if len(icmpErr.Payload) < 4 || binary.BigEndian.Uint16(icmpErr.Payload[2:4]) != dport {
+				continue
+			}
+			ans := &mtuAnswer{
+				addr: from,
+				rtt:  ri.At.Sub(sent),
+				mtu:  -1,
+			}
+			switch {
+			case icmpErr.Type == ICMP6PacketTooBig:
+				ans.mtu = icmpErr.MTU
+			case icmpErr.Type == ICMP6DstUnreach && icmpErr.Code == ICMP6PortUnreach:
+				ans.reached = true
+			case icmpErr.Type == ICMP6DstUnreach:
+				ans.final = true
+			}
+			return ans, nil
+		}
+	}
+
+	return t.discoverPathMTU(ctx, probe, interfaceMTU(t.SrcIP), MINMTU6)
+}
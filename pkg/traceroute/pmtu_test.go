@@ -0,0 +1,113 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestNextPlateau(t *testing.T) {
+	for _, tt := range []struct {
+		size, floor, want int
+	}{
+		{size: 1500, floor: MINMTU4, want: 1492},
+		{size: 1492, floor: MINMTU4, want: 1006},
+		{size: 9000, floor: MINMTU4, want: 8166},
+		{size: 1500, floor: MINMTU6, want: 1492},
+		{size: 1492, floor: MINMTU6, want: MINMTU6},
+		{size: 68, floor: MINMTU4, want: MINMTU4},
+	} {
+		if got := nextPlateau(tt.size, tt.floor); got != tt.want {
+			t.Errorf("nextPlateau(%d, %d) = %d, want %d", tt.size, tt.floor, got, tt.want)
+		}
+	}
+}
+
+func TestDiscoverPathMTU(t *testing.T) {
+	routers := []net.IP{
+		net.IPv4(192, 0, 2, 1),
+		net.IPv4(192, 0, 2, 2),
+		net.IPv4(192, 0, 2, 3),
+	}
+	dest := net.IPv4(192, 0, 2, 4)
+	// Link MTU behind each router; the second router does not report
+	// it. Probes to the destination fail locally beyond 1000 bytes, as
+	// if the kernel had cached a smaller path MTU.
+	linkMTU := []int{1400, 1300, 1200}
+
+	probe := func(ttl, size int, seq uint16) (*mtuAnswer, error) {
+		if ttl == 4 && size > 1000 {
+			return nil, syscall.EMSGSIZE
+		}
+		for i := 0; i < ttl-1 && i < len(routers); i++ {
+			if size > linkMTU[i] {
+				a := &mtuAnswer{addr: routers[i], mtu: linkMTU[i]}
+				if i == 1 {
+					a.mtu = 0
+				}
+				return a, nil
+			}
+		}
+		if ttl > len(routers) {
+			return &mtuAnswer{addr: dest, reached: true, rtt: time.Millisecond, mtu: -1}, nil
+		}
+		return &mtuAnswer{addr: routers[ttl-1], rtt: time.Millisecond, mtu: -1}, nil
+	}
+
+	tr := &Trace{Options: TracerouteOptions{FirstTTL: 1, MaxTTL: 10, ProbesPerHop: 3}}
+	hops, err := tr.discoverPathMTU(context.Background(), probe, 1500, MINMTU4)
+	if err != nil {
+		t.Fatalf("discoverPathMTU() = %v", err)
+	}
+
+	want := []MTUHop{
+		{TTL: 1, Addr: routers[0], PMTU: 1500},
+		{TTL: 2, Addr: routers[1], PMTU: 1400},
+		{TTL: 3, Addr: routers[2], PMTU: 1006},
+		{TTL: 4, Addr: dest, PMTU: 508, Reached: true},
+	}
+	if len(hops) != len(want) {
+		t.Fatalf("got %d hops, want %d: %+v", len(hops), len(want), hops)
+	}
+	for i, h := range hops {
+		w := want[i]
+		if h.TTL != w.TTL || !h.Addr.Equal(w.Addr) || h.PMTU != w.PMTU || h.Reached != w.Reached {
+			t.Errorf("hop %d = %+v, want %+v", i, h, w)
+		}
+	}
+}
+
+func TestBuildUDP4PktLarge(t *testing.T) {
+	tr := &Trace{
+		DestIP: net.IPv4(192, 0, 2, 1).To4(),
+		SrcIP:  net.IPv4(192, 0, 2, 2).To4(),
+	}
+	hdr, pkt, err := tr.buildUDP4Pkt(1234, 33434, 1, 1, 0, 1472, true)
+	if err != nil {
+		t.Fatalf("buildUDP4Pkt() = %v", err)
+	}
+	if hdr.TotalLen != 1500 || len(pkt) != 1480 {
+		t.Fatalf("TotalLen = %d, UDP length = %d, want 1500, 1480", hdr.TotalLen, len(pkt))
+	}
+
+	// The pseudo-header length must not be truncated to eight bits.
+	pseudo := append(append([]byte{}, tr.SrcIP...), tr.DestIP...)
+	pseudo = append(pseudo, 0, 17, byte(len(pkt)>>8), byte(len(pkt)))
+	b := append(pseudo, pkt...)
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	if sum != 0xffff {
+		t.Errorf("UDP checksum does not verify: sum = %#x", sum)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"sync"
+	"time"
+)
+
+// MINRATEBACKOFF and MAXRATEBACKOFF bound the spacing of the probes to a
+// TTL whose router rate-limits its ICMP errors.
+const (
+	MINRATEBACKOFF = 250 * time.Millisecond
+	MAXRATEBACKOFF = 2 * time.Second
+)
+
+// rateLimiter holds the spacing of the probes per TTL. TTLs not found to
+// be rate-limited keep the spacing of the trace.
+type rateLimiter struct {
+	mu      sync.Mutex
+	spacing map[int]time.Duration
+}
+
+// backoff doubles the spacing of the probes to ttl, starting at
+// MINRATEBACKOFF and up to MAXRATEBACKOFF.
+func (l *rateLimiter) backoff(ttl int) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.spacing == nil {
+		l.spacing = map[int]time.Duration{}
+	}
+	l.spacing[ttl] = min(max(2*l.spacing[ttl], MINRATEBACKOFF), MAXRATEBACKOFF)
+}
+
+// interval returns the spacing of the probes to ttl, zero if it is not
+// rate-limited.
+func (l *rateLimiter) interval(ttl int) time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.spacing[ttl]
+}
+
+// rateLimited tells whether the router at ttl seems to rate-limit its
+// ICMP errors: after answering, it stopped answering the following
+// probes of the burst sent to it, while a router further down the path
+// did answer. Random loss hits probes regardless of their order.
+func (s *hopStream) rateLimited(ttl int) bool {
+	answered, lostAfter := false, false
+	for _, pb := range s.sent[ttl] {
+		switch {
+		case pb.Done && lostAfter:
+			// An answer after a loss is the pattern of random loss.
+			return false
+		case pb.Done:
+			answered = true
+		case answered:
+			lostAfter = true
+		}
+	}
+	if !lostAfter {
+		return false
+	}
+	for t, pbs := range s.sent {
+		if t <= ttl {
+			continue
+		}
+		for _, pb := range pbs {
+			if pb.Done {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimited(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		// hop and next are the answered flags of the probes of the hop
+		// and of the next one, in send order.
+		hop, next []bool
+		want      bool
+	}{
+		{name: "BurstLoss", hop: []bool{true, false, false}, next: []bool{true}, want: true},
+		{name: "AllAnswered", hop: []bool{true, true, true}, next: []bool{true}},
+		{name: "RandomLoss", hop: []bool{true, false, true}, next: []bool{true}},
+		{name: "LeadingLoss", hop: []bool{false, true, true}, next: []bool{true}},
+		{name: "NothingBeyond", hop: []bool{true, false, false}, next: []bool{false}},
+		{name: "Silent", hop: []bool{false, false, false}, next: []bool{true}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := &Trace{Options: TracerouteOptions{FirstTTL: 1, ProbesPerHop: 3, Timeout: time.Second}}
+			var limited bool
+			s := newHopStream(tr, func(_, _ int, _ []*Probe, l bool) { limited = l })
+			start := time.Now()
+			for ttl, done := range [][]bool{tt.hop, tt.next} {
+				for i, d := range done {
+					s.add(&Probe{TTL: ttl + 1, Sendtime: start.Add(time.Duration(i) * time.Millisecond), Done: d})
+				}
+			}
+			s.reportNext(start.Add(time.Hour))
+			if limited != tt.want {
+				t.Errorf("rate-limited = %v, want %v", limited, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimiterBackoff(t *testing.T) {
+	l := &rateLimiter{}
+	if d := l.interval(3); d != 0 {
+		t.Fatalf("interval(3) = %v before any backoff, want 0", d)
+	}
+	want := []time.Duration{MINRATEBACKOFF, 2 * MINRATEBACKOFF, 4 * MINRATEBACKOFF, MAXRATEBACKOFF, MAXRATEBACKOFF}
+	for i, w := range want {
+		l.backoff(3)
+		if d := l.interval(3); d != w {
+			t.Errorf("interval(3) after %d backoffs = %v, want %v", i+1, d, w)
+		}
+	}
+	if d := l.interval(4); d != 0 {
+		t.Errorf("interval(4) = %v, want other TTLs unaffected", d)
+	}
+}
+
+func TestPauseBackoff(t *testing.T) {
+	tr := &Trace{
+		Options: TracerouteOptions{Interval: time.Millisecond},
+		limiter: &rateLimiter{},
+		// Simultaneous probes are not spaced, unless rate-limited.
+		slots: make(chan struct{}, 1),
+	}
+	tr.limiter.backoff(2)
+
+	start := time.Now()
+	if err := tr.pause(context.Background(), 1); err != nil || time.Since(start) >= MINRATEBACKOFF {
+		t.Errorf("pause(1) = %v after %v, want no wait", err, time.Since(start))
+	}
+	start = time.Now()
+	if err := tr.pause(context.Background(), 2); err != nil || time.Since(start) < MINRATEBACKOFF {
+		t.Errorf("pause(2) = %v after %v, want to wait %v", err, time.Since(start), MINRATEBACKOFF)
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+)
+
+// NewResolver returns a resolver sending its queries to server, a host
+// with optional port, or the system resolver if server is empty.
+func NewResolver(server string) *net.Resolver {
+	if server == "" {
+		return net.DefaultResolver
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// annotateNames reverse resolves every distinct hop address, giving each
+// lookup at most timeout.
+func annotateNames(ctx context.Context, printMap map[int]*Probe, r *net.Resolver, timeout time.Duration) {
+	if timeout == 0 {
+		timeout = DEFLOOKUPSEC * time.Second
+	}
+	forEachHopAddr(printMap, func(pbs []*Probe) {
+		name := lookupName(ctx, r, pbs[0].Saddr, timeout)
+		for _, pb := range pbs {
+			pb.Name = name
+		}
+	})
+}
+
+// lookupName returns the first reverse DNS name of ip, or "" if there is
+// none.
+func lookupName(ctx context.Context, r *net.Resolver, ip net.IP, timeout time.Duration) string {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	names, err := r.LookupAddr(ctx, ip.String())
+	if err != nil || len(names) == 0 {
+		return ""
+	}
+	return strings.TrimSuffix(names[0], ".")
+}
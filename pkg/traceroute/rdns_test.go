@@ -0,0 +1,133 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute_test
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/u-root/u-root/pkg/traceroute"
+)
+
+// encodeName encodes a domain name in DNS wire format.
+func encodeName(name string) []byte {
+	var b []byte
+	for _, l := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		b = append(b, byte(len(l)))
+		b = append(b, l...)
+	}
+	return append(b, 0)
+}
+
+// fakeDNSServer answers every query with a single record of the type
+// asked for, with the data in records, or with none if there is no data
+// for the type.
+func fakeDNSServer(t *testing.T, records map[uint16][]byte) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("no loopback: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n < 12 {
+				continue
+			}
+			// Copy the question only, not the EDNS record that may follow.
+			q := 12
+			for q < n && buf[q] != 0 {
+				q += int(buf[q]) + 1
+			}
+			if q+5 > n {
+				continue
+			}
+			qtype := binary.BigEndian.Uint16(buf[q+1 : q+3])
+			rdata, ok := records[qtype]
+			// Header: same ID, response, recursion available, one
+			// question and an answer if there is one.
+			resp := append([]byte{}, buf[:2]...)
+			resp = append(resp, 0x81, 0x80, 0, 1, 0, 0, 0, 0, 0, 0)
+			resp = append(resp, buf[12:q+5]...)
+			if ok {
+				resp[7] = 1
+				answer := []byte{0xc0, 12, byte(qtype >> 8), byte(qtype), 0, 1, 0, 0, 0, 60, 0, 0}
+				binary.BigEndian.PutUint16(answer[10:12], uint16(len(rdata)))
+				resp = append(resp, answer...)
+				resp = append(resp, rdata...)
+			}
+			conn.WriteTo(resp, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// fakePTRServer answers every query with a single PTR record for name.
+func fakePTRServer(t *testing.T, name string) string {
+	return fakeDNSServer(t, map[uint16][]byte{12: encodeName(name)})
+}
+
+func TestNewResolver(t *testing.T) {
+	server := fakePTRServer(t, "hop1.example.net.")
+	r := traceroute.NewResolver(server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	names, err := r.LookupAddr(ctx, "192.0.2.1")
+	if err != nil {
+		t.Fatalf("LookupAddr() = %v", err)
+	}
+	if len(names) != 1 || names[0] != "hop1.example.net." {
+		t.Errorf("LookupAddr() = %v, want [hop1.example.net.]", names)
+	}
+
+	if traceroute.NewResolver("") != net.DefaultResolver {
+		t.Errorf("NewResolver(\"\") is not the system resolver")
+	}
+}
+
+func TestResolveDest(t *testing.T) {
+	v4 := net.IPv4(192, 0, 2, 1).To4()
+	v6 := net.ParseIP("2001:db8::1")
+	dual := traceroute.NewResolver(fakeDNSServer(t, map[uint16][]byte{1: v4, 28: v6}))
+	only6 := traceroute.NewResolver(fakeDNSServer(t, map[uint16][]byte{28: v6}))
+
+	for _, tt := range []struct {
+		name     string
+		r        *net.Resolver
+		proto    string
+		fallback bool
+		want     net.IP
+	}{
+		{name: "A", r: dual, proto: "udp4", want: v4},
+		{name: "AAAA", r: dual, proto: "icmp6", want: v6},
+		{name: "NoA", r: only6, proto: "udp4"},
+		{name: "Fallback", r: only6, proto: "udp4", fallback: true, want: v6},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			got, err := traceroute.ResolveDest(ctx, tt.r, "dest.example.net.", tt.proto, tt.fallback)
+			if tt.want == nil {
+				if err == nil {
+					t.Errorf("ResolveDest() = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil || !got.Equal(tt.want) {
+				t.Errorf("ResolveDest() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
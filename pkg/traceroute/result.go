@@ -0,0 +1,243 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"sort"
+	"time"
+)
+
+// Output formats of RunTraceroute. OutputSummary prints a table of the
+// loss and round trip time statistics of each hop.
+const (
+	OutputText    = "text"
+	OutputJSON    = "json"
+	OutputNDJSON  = "ndjson"
+	OutputSummary = "summary"
+)
+
+// Result is the machine-readable outcome of a trace.
+type Result struct {
+	Host    string `json:"host"`
+	Dest    string `json:"dest"`
+	Proto   string `json:"proto"`
+	MaxHops int    `json:"max_hops"`
+	Flows   int    `json:"flows,omitempty"`
+	// Reached is whether the destination itself answered.
+	Reached bool  `json:"reached"`
+	Hops    []Hop `json:"hops"`
+}
+
+// Hop holds the answers to all probes sent with one TTL. TTLs no probe
+// was answered for have no probes.
+type Hop struct {
+	TTL int `json:"ttl"`
+	// Sent is the number of probes sent with TTL that were answered or
+	// timed out before the trace ended, Loss the percentage of them that
+	// was not answered.
+	Sent int     `json:"sent"`
+	Loss float64 `json:"loss_pct"`
+	// RTT summarizes the round trip times of Probes, nil without any.
+	RTT *RTTStats `json:"rtt,omitempty"`
+	// RateLimited is set if the loss seems to be caused by the router
+	// rate-limiting its ICMP errors rather than by the path.
+	RateLimited bool `json:"rate_limited,omitempty"`
+	// NATStart is set on the first hop that quotes probes with their
+	// source address or ports rewritten. The rewriting box is the hop
+	// before it or, if that forwards before translating, the hop itself.
+	NATStart bool       `json:"nat_start,omitempty"`
+	Probes   []HopProbe `json:"probes"`
+}
+
+// HopProbe is a single answered probe.
+type HopProbe struct {
+	Addr string `json:"addr"`
+	Name string `json:"name,omitempty"`
+	// RTT is the round trip time in milliseconds.
+	RTT  float64     `json:"rtt_ms"`
+	Flow int         `json:"flow,omitempty"`
+	ASN  uint32      `json:"asn,omitempty"`
+	Geo  *Geo        `json:"geo,omitempty"`
+	MPLS []MPLSLabel `json:"mpls,omitempty"`
+	// TOSChange describes how the hop changed the DSCP or ECN field of
+	// the probe, see TOSChange.
+	TOSChange string `json:"tos_change,omitempty"`
+	// UnreachCode is the code of the Destination Unreachable message
+	// that answered the probe, if it was, and Flag its annotation, see
+	// UnreachFlag and FragFlag.
+	UnreachCode *uint8 `json:"unreach_code,omitempty"`
+	Flag        string `json:"flag,omitempty"`
+	// FragNeeded is set if the probe was too large for a link and not
+	// to be fragmented. NextHopMTU is the MTU of that link, if the
+	// router reported it.
+	FragNeeded bool `json:"frag_needed,omitempty"`
+	NextHopMTU int  `json:"next_hop_mtu,omitempty"`
+	// TCPFlags are the flags of the TCP segment the destination answered
+	// with, e.g. "RST,ACK".
+	TCPFlags string `json:"tcp_flags,omitempty"`
+	// Recorded is the IPv4 Record Route or Timestamp data the probe
+	// collected until it expired.
+	Recorded *RecordedRoute `json:"recorded,omitempty"`
+	// ReplyTTL is the TTL the answer arrived with and ReturnHops the
+	// length of the path it took back, estimated from it. Asymmetric is
+	// set if that differs from the TTL of the hop by ASYMMETRYHOPS or
+	// more.
+	ReplyTTL   int  `json:"reply_ttl,omitempty"`
+	ReturnHops int  `json:"return_hops,omitempty"`
+	Asymmetric bool `json:"asymmetric,omitempty"`
+	// NAT describes how the probe was rewritten on its way to the hop,
+	// see NATChange.
+	NAT string `json:"nat,omitempty"`
+}
+
+// NewResult builds the result of a trace to host at dest, probed with
+// opts, from the answered probes. Every TTL up to the destination counts
+// as probed opts.ProbesPerHop times per flow.
+func NewResult(host string, dest net.IP, proto string, opts TracerouteOptions, flows int, printMap map[int]*Probe) *Result {
+	opts = opts.withDefaults(proto)
+	r := &Result{
+		Host:    host,
+		Dest:    dest.String(),
+		Proto:   proto,
+		MaxHops: opts.MaxTTL,
+		Hops:    []Hop{},
+	}
+	if flows > 1 {
+		r.Flows = flows
+	}
+	if len(printMap) == 0 {
+		return r
+	}
+
+	destTTL := DestTTL(printMap)
+	var nat natTracker
+	for ttl := opts.FirstTTL; ttl <= destTTL; ttl++ {
+		pbs := GetProbesByTLL(printMap, ttl)
+		for _, pb := range pbs {
+			if pb.Saddr.Equal(dest) {
+				r.Reached = true
+			}
+		}
+		hop := newHop(ttl, opts.ProbesPerHop*max(flows, 1), pbs)
+		nat.mark(&hop)
+		r.Hops = append(r.Hops, hop)
+	}
+	return r
+}
+
+// newHop builds the hop of the probes answered for ttl out of sent, in
+// the order they were sent.
+func newHop(ttl, sent int, pbs []*Probe) Hop {
+	sort.Slice(pbs, func(i, j int) bool { return pbs[i].Sendtime.Before(pbs[j].Sendtime) })
+	hop := Hop{TTL: ttl, Probes: []HopProbe{}}
+	rtts := make([]time.Duration, 0, len(pbs))
+	for _, pb := range pbs {
+		hop.Probes = append(hop.Probes, newHopProbe(pb))
+		rtts = append(rtts, pb.RecvTime.Sub(pb.Sendtime))
+	}
+	hop.RTT = NewRTTStats(rtts)
+	hop.setSent(sent)
+	return hop
+}
+
+// setSent sets the number of probes sent for h and their loss.
+func (h *Hop) setSent(sent int) {
+	// Late answers to the probes of an earlier trace may outnumber them.
+	h.Sent = max(sent, len(h.Probes))
+	h.Loss = 0
+	if h.Sent > 0 {
+		h.Loss = 100 * float64(h.Sent-len(h.Probes)) / float64(h.Sent)
+	}
+}
+
+func newHopProbe(pb *Probe) HopProbe {
+	hp := HopProbe{
+		Addr:      pb.Saddr.String(),
+		Name:      pb.Name,
+		RTT:       float64(pb.RecvTime.Sub(pb.Sendtime)/time.Microsecond) / 1000,
+		Flow:      pb.Flow,
+		ASN:       pb.ASN,
+		MPLS:      pb.MPLS,
+		TOSChange: TOSChange(pb.TOS, pb.QuotedTOS),
+		Recorded:  pb.Recorded,
+		NAT:       NATChange(pb.Src, pb.SrcPort, pb.Port, pb.Quoted),
+	}
+	if pb.TCPFlags != 0 {
+		hp.TCPFlags = TCPFlagsString(pb.TCPFlags)
+	}
+	if pb.Geo != (Geo{}) {
+		g := pb.Geo
+		hp.Geo = &g
+	}
+	if pb.Unreach != nil {
+		hp.UnreachCode = pb.Unreach
+		hp.Flag = UnreachFlag(pb.Saddr.To4() == nil, *pb.Unreach)
+	}
+	if pb.FragMTU != nil {
+		hp.FragNeeded = true
+		hp.NextHopMTU = *pb.FragMTU
+		hp.Flag = FragFlag(*pb.FragMTU)
+	}
+	if pb.ReplyTTL != 0 {
+		hp.ReplyTTL = pb.ReplyTTL
+		hp.ReturnHops = ReturnHops(pb.ReplyTTL)
+		hp.Asymmetric = Asymmetric(pb.TTL, hp.ReturnHops)
+	}
+	return hp
+}
+
+// WriteJSON writes r as a single JSON document.
+func (r *Result) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// ndjsonHop and ndjsonSummary are the lines of NDJSON output.
+type ndjsonHop struct {
+	Type string `json:"type"`
+	Hop
+}
+
+type ndjsonSummary struct {
+	Type    string `json:"type"`
+	Host    string `json:"host"`
+	Dest    string `json:"dest"`
+	Proto   string `json:"proto"`
+	MaxHops int    `json:"max_hops"`
+	Flows   int    `json:"flows,omitempty"`
+	Reached bool   `json:"reached"`
+	// Hops is the number of hop records preceding the summary.
+	Hops int `json:"hops"`
+}
+
+// WriteNDJSON writes r as newline delimited JSON: one "hop" record per
+// TTL, then a "summary" record.
+func (r *Result) WriteNDJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for _, hop := range r.Hops {
+		if err := enc.Encode(ndjsonHop{Type: "hop", Hop: hop}); err != nil {
+			return err
+		}
+	}
+	return enc.Encode(r.summary())
+}
+
+// summary returns the closing NDJSON record of r.
+func (r *Result) summary() ndjsonSummary {
+	return ndjsonSummary{
+		Type:    "summary",
+		Host:    r.Host,
+		Dest:    r.Dest,
+		Proto:   r.Proto,
+		MaxHops: r.MaxHops,
+		Flows:   r.Flows,
+		Reached: r.Reached,
+		Hops:    len(r.Hops),
+	}
+}
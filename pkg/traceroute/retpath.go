@@ -0,0 +1,43 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+// ASYMMETRYHOPS is the difference in hops between the forward path to a
+// hop and the estimated return path from it at which the hop is flagged
+// asymmetric. Smaller differences are common, as the initial TTL of an
+// answer is only guessed.
+const ASYMMETRYHOPS = 3
+
+// initialTTLs are the TTLs hosts and routers commonly send with: 64 by
+// Linux and most routers, 128 by Windows and 255 by Cisco and Solaris.
+var initialTTLs = []int{64, 128, 255}
+
+// ReturnHops estimates the number of hops an answer that arrived with
+// ttl took back, counted like the TTL of a probe, so that a neighbor is
+// one hop away. It assumes the answer was sent with the smallest common
+// initial TTL not below ttl. It returns zero for a TTL of zero, which is
+// unknown.
+func ReturnHops(ttl int) int {
+	if ttl <= 0 {
+		return 0
+	}
+	for _, initial := range initialTTLs {
+		if ttl <= initial {
+			return initial - ttl + 1
+		}
+	}
+	return 0
+}
+
+// Asymmetric reports whether the return path of an answer returnHops
+// long differs from the forward path to the hop at ttl by ASYMMETRYHOPS
+// or more. Unknown return paths are not.
+func Asymmetric(ttl, returnHops int) bool {
+	if returnHops == 0 {
+		return false
+	}
+	d := returnHops - ttl
+	return d >= ASYMMETRYHOPS || -d >= ASYMMETRYHOPS
+}
@@ -0,0 +1,98 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReturnHops(t *testing.T) {
+	for _, tt := range []struct {
+		ttl  int
+		want int
+	}{
+		{ttl: 0, want: 0},
+		{ttl: 64, want: 1},
+		{ttl: 57, want: 8},
+		{ttl: 120, want: 9},
+		{ttl: 250, want: 6},
+		{ttl: 256, want: 0},
+	} {
+		if got := ReturnHops(tt.ttl); got != tt.want {
+			t.Errorf("ReturnHops(%d) = %d, want %d", tt.ttl, got, tt.want)
+		}
+	}
+}
+
+func TestAsymmetric(t *testing.T) {
+	for _, tt := range []struct {
+		ttl, returnHops int
+		want            bool
+	}{
+		{ttl: 5, returnHops: 0, want: false},
+		{ttl: 5, returnHops: 5, want: false},
+		{ttl: 5, returnHops: 5 + ASYMMETRYHOPS - 1, want: false},
+		{ttl: 5, returnHops: 5 + ASYMMETRYHOPS, want: true},
+		{ttl: 9, returnHops: 9 - ASYMMETRYHOPS, want: true},
+	} {
+		if got := Asymmetric(tt.ttl, tt.returnHops); got != tt.want {
+			t.Errorf("Asymmetric(%d, %d) = %t, want %t", tt.ttl, tt.returnHops, got, tt.want)
+		}
+	}
+}
+
+func TestAsymmetricFakeNetwork(t *testing.T) {
+	src := net.IPv4(192, 0, 2, 100)
+	dest := net.IPv4(198, 51, 100, 1)
+	routers := []net.IP{net.IPv4(192, 0, 2, 1), net.IPv4(203, 0, 113, 1)}
+
+	for _, tt := range []struct {
+		name       string
+		returnHops int
+		asymmetric bool
+	}{
+		{name: "Symmetric"},
+		{name: "Asymmetric", returnHops: ASYMMETRYHOPS, asymmetric: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cc := Coms{
+				SendChan: make(chan *Probe),
+				RecvChan: make(chan *Probe),
+			}
+			opts := TracerouteOptions{
+				MaxTTL:       5,
+				ProbesPerHop: 1,
+				Interval:     time.Millisecond,
+				Timeout:      time.Second,
+			}
+			tr := NewTrace("icmp4", dest, src, cc, &Flags{TracerouteOptions: opts})
+			n := newFakeNetwork(dest.To4(), routers...)
+			n.returnHops = tt.returnHops
+			tr.Network = n
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go tr.SendTracesICMP4(ctx)
+			printMap := runTransmission(ctx, cc, 1, tr.Options.Timeout, nil)
+
+			r := NewResult("dest", dest, "icmp4", opts, 1, printMap)
+			if len(r.Hops) != 3 {
+				t.Fatalf("%d hops, want 3", len(r.Hops))
+			}
+			for _, h := range r.Hops {
+				if len(h.Probes) != 1 {
+					t.Fatalf("TTL %d: %d answers, want 1", h.TTL, len(h.Probes))
+				}
+				p := h.Probes[0]
+				if p.ReturnHops != h.TTL+tt.returnHops || p.Asymmetric != tt.asymmetric {
+					t.Errorf("TTL %d: %d hops back, asymmetric %t, want %d and %t", h.TTL, p.ReturnHops, p.Asymmetric, h.TTL+tt.returnHops, tt.asymmetric)
+				}
+			}
+		})
+	}
+}
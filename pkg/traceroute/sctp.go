@@ -0,0 +1,113 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+)
+
+// SCTP chunk types, RFC 9260.
+const (
+	SCTPChunkInit    = 1
+	SCTPChunkInitAck = 2
+	SCTPChunkAbort   = 6
+)
+
+// sctpProto is the IP protocol number of SCTP. Go knows no name for it.
+const sctpProto = 132
+
+// sctpTagOffset is the offset of the initiate tag of an INIT chunk in a
+// packet starting with it.
+const sctpTagOffset = 16
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// SCTPHeader is the common header of an SCTP packet.
+type SCTPHeader struct {
+	Src      uint16
+	Dst      uint16
+	VerTag   uint32
+	Checksum uint32
+}
+
+// SCTPChunk is the header of an SCTP chunk.
+type SCTPChunk struct {
+	Type   uint8
+	Flags  uint8
+	Length uint16
+}
+
+// sctpInit is the body of an INIT chunk.
+type sctpInit struct {
+	InitiateTag uint32
+	ARwnd       uint32
+	OutStreams  uint16
+	InStreams   uint16
+	InitialTSN  uint32
+}
+
+// sctpInitLen is the length of an INIT chunk body without parameters.
+const sctpInitLen = 16
+
+func (i *sctpInit) marshal() []byte {
+	b := make([]byte, sctpInitLen)
+	binary.BigEndian.PutUint32(b[0:], i.InitiateTag)
+	binary.BigEndian.PutUint32(b[4:], i.ARwnd)
+	binary.BigEndian.PutUint16(b[8:], i.OutStreams)
+	binary.BigEndian.PutUint16(b[10:], i.InStreams)
+	binary.BigEndian.PutUint32(b[12:], i.InitialTSN)
+	return b
+}
+
+// ParseSCTP parses the common header of an SCTP packet and the header of
+// its first chunk.
+func ParseSCTP(data []byte) (*SCTPHeader, *SCTPChunk, error) {
+	r := bytes.NewReader(data)
+	hdr := &SCTPHeader{}
+	chunk := &SCTPChunk{}
+	if err := binary.Read(r, binary.BigEndian, hdr); err != nil {
+		return nil, nil, err
+	}
+	if err := binary.Read(r, binary.BigEndian, chunk); err != nil {
+		return nil, nil, err
+	}
+	return hdr, chunk, nil
+}
+
+// buildSCTPInit builds an SCTP packet holding a single INIT chunk with
+// the initiate tag tag. Whoever answers it, with an INIT ACK or an ABORT,
+// sends tag back as the verification tag.
+func buildSCTPInit(sport, dport uint16, tag uint32) []byte {
+	init := sctpInit{
+		InitiateTag: tag,
+		ARwnd:       65535,
+		OutStreams:  1,
+		InStreams:   1,
+		InitialTSN:  tag,
+	}
+	hdr := SCTPHeader{Src: sport, Dst: dport}
+	chunk := SCTPChunk{Type: SCTPChunkInit, Length: SCTPChunkLen + sctpInitLen}
+	pkt := packet(hdr.Marshal(), chunk.Marshal(), init.marshal())
+
+	// Unlike TCP and UDP, SCTP has no pseudo-header. The CRC32c is sent
+	// least significant byte first.
+	binary.LittleEndian.PutUint32(pkt[8:12], crc32.Checksum(pkt, castagnoli))
+	return pkt
+}
+
+// sctpAnswer returns the probe ID the destination answered if pkt is an
+// INIT ACK or ABORT sent from dport to sport.
+func sctpAnswer(pkt []byte, sport, dport uint16) (uint32, bool) {
+	hdr, chunk, err := ParseSCTP(pkt)
+	if err != nil || hdr.Src != dport || hdr.Dst != sport {
+		return 0, false
+	}
+	if chunk.Type != SCTPChunkInitAck && chunk.Type != SCTPChunkAbort {
+		return 0, false
+	}
+	return hdr.VerTag, true
+}
@@ -0,0 +1,175 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+// SendTracesSCTP4 sends SCTP INIT probes with increasing TTLs to the
+// destination port. The initiate tag and the IP ID identify the probe.
+// The destination keeps no state for an INIT it answers, so probes need
+// no teardown.
+func (t *Trace) SendTracesSCTP4(ctx context.Context) error {
+	defer close(t.SendChan)
+
+	sport := uint16(1000 + t.PortOffset + rand.Int31n(500))
+	network := fmt.Sprintf("ip4:%d", sctpProto)
+	conn, err := t.network().ProbeConn(network)
+	if err != nil {
+		return sockErr("opening probe socket", err)
+	}
+	defer conn.Close()
+
+	recvICMPConn, err := t.network().ReplyConn("ip4:icmp", t.SrcIP)
+	if err != nil {
+		return sockErr("opening ICMP socket", err)
+	}
+	recvSCTPConn, err := t.network().ReplyConn(network, t.SrcIP)
+	if err != nil {
+		recvICMPConn.Close()
+		return sockErr("opening SCTP socket", err)
+	}
+	t.receive(ctx, recvICMPConn, func() { t.ReceiveTracesSCTP4ICMP(ctx, recvICMPConn, sport) })
+	t.receive(ctx, recvSCTPConn, func() { t.ReceiveTracesSCTP4(ctx, recvSCTPConn, sport) })
+
+	id := uint16(1)
+	for ttl := t.Options.FirstTTL; ttl <= t.Options.MaxTTL; ttl++ {
+		for j := 0; j < t.Options.ProbesPerHop; j++ {
+			release, err := t.acquireSlot(ctx)
+			if err != nil {
+				return err
+			}
+			hdr, payload, err := t.BuildSCTP4InitPkt(sport, t.destPort, uint8(ttl), id, t.Options.TOS())
+			if err != nil {
+				return err
+			}
+			if err := t.setIPv4Options(hdr); err != nil {
+				return err
+			}
+			pb := &Probe{
+				ID:       uint32(id),
+				Dest:     t.DestIP,
+				Port:     t.destPort,
+				SrcPort:  sport,
+				TTL:      ttl,
+				Sendtime: time.Now(),
+				release:  release,
+				TOS:      uint8(t.Options.TOS()),
+			}
+			if err := t.sendProbe(ctx, pb); err != nil {
+				return err
+			}
+			if err := conn.WritePacket(&Packet{IPv4: hdr, Payload: payload}, t.DestIP); err != nil {
+				return sockErr("sending probe", err)
+			}
+			// Initiate tags must not be zero.
+			if id++; id == 0 {
+				id = 1
+			}
+			if err := t.pause(ctx, ttl); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ReceiveTracesSCTP4 waits for the destination to answer an INIT probe.
+// Both INIT ACK (port open) and ABORT (port closed) mark the final hop.
+func (t *Trace) ReceiveTracesSCTP4(ctx context.Context, recvSCTPConn ProbeConn, sport uint16) {
+	defer recvSCTPConn.Close()
+
+	buf := make([]byte, 1500)
+	for {
+		n, from, ri, err := readReply(recvSCTPConn, buf)
+		if err != nil {
+			return
+		}
+		if !from.Equal(t.DestIP) {
+			continue
+		}
+		id, ok := sctpAnswer(buf[:n], sport, t.destPort)
+		if !ok {
+			continue
+		}
+		pb := &Probe{
+			ID:        id,
+			Saddr:     from,
+			RecvTime:  ri.At,
+			ReplyTTL:  ri.TTL,
+			QuotedTOS: -1,
+		}
+		if !t.deliver(ctx, pb) {
+			return
+		}
+	}
+}
+
+// ReceiveTracesSCTP4ICMP matches ICMP errors quoting one of our INIT
+// probes to the probe. Only the ports of the SCTP header are sure to be
+// quoted, so the probe is told by the IP ID.
+func (t *Trace) ReceiveTracesSCTP4ICMP(ctx context.Context, recvICMPConn ProbeConn, sport uint16) {
+	defer recvICMPConn.Close()
+
+	buf := make([]byte, 1500)
+	for {
+		n, from, ri, err := readReply(recvICMPConn, buf)
+		if err != nil {
+			return
+		}
+
+		icmpErr, err := ParseICMP4Error(buf[:n])
+		if err != nil || icmpErr.Quoted.Protocol != sctpProto || !t.isDest(icmpErr.Quoted.Dst) {
+			continue
+		}
+		if binary.BigEndian.Uint16(icmpErr.Payload[0:2]) != sport {
+			continue
+		}
+		pb := &Probe{
+			ID:        uint32(icmpErr.Quoted.ID),
+			Saddr:     from,
+			RecvTime:  ri.At,
+			ReplyTTL:  ri.TTL,
+			MPLS:      MPLSLabels(icmpErr.Extensions),
+			Unreach:   icmpErr.unreach(),
+			FragMTU:   icmpErr.fragMTU(),
+			QuotedTOS: icmpErr.Quoted.TOS,
+			Quoted:    icmpErr.quotedHeader(),
+			Recorded:  ParseRecordedRoute(icmpErr.Quoted.Options),
+		}
+		if !t.deliver(ctx, pb) {
+			return
+		}
+	}
+}
+
+// BuildSCTP4InitPkt builds an INIT probe identified by id.
+func (t *Trace) BuildSCTP4InitPkt(srcPort, dstPort uint16, ttl uint8, id uint16, tos int) (*ipv4.Header, []byte, error) {
+	payload := buildSCTPInit(srcPort, dstPort, uint32(id))
+	iph := &ipv4.Header{
+		Version:  ipv4.Version,
+		TOS:      tos,
+		Len:      ipv4.HeaderLen,
+		TotalLen: ipv4.HeaderLen + len(payload),
+		ID:       int(id),
+		TTL:      int(ttl),
+		Protocol: sctpProto,
+		Src:      t.SrcIP,
+		Dst:      t.DestIP,
+	}
+	h, err := iph.Marshal()
+	if err != nil {
+		return nil, nil, err
+	}
+	iph.Checksum = int(checkSum(h))
+	return iph, payload, nil
+}
@@ -0,0 +1,153 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"golang.org/x/net/ipv6"
+)
+
+// SendTracesSCTP6 sends SCTP INIT probes with increasing hop limits to
+// the destination port. The initiate tag identifies the probe.
+func (t *Trace) SendTracesSCTP6(ctx context.Context) error {
+	defer close(t.SendChan)
+
+	sport := uint16(1000 + t.PortOffset + rand.Int31n(500))
+	network := fmt.Sprintf("ip6:%d", sctpProto)
+	conn, err := t.network().ProbeConn(network)
+	if err != nil {
+		return sockErr("opening probe socket", err)
+	}
+	defer conn.Close()
+
+	recvICMPConn, err := t.network().ReplyConn("ip6:ipv6-icmp", t.SrcIP)
+	if err != nil {
+		return sockErr("opening ICMPv6 socket", err)
+	}
+	recvSCTPConn, err := t.network().ReplyConn(network, t.SrcIP)
+	if err != nil {
+		recvICMPConn.Close()
+		return sockErr("opening SCTP socket", err)
+	}
+	t.receive(ctx, recvICMPConn, func() { t.ReceiveTracesSCTP6ICMP(ctx, recvICMPConn, sport) })
+	t.receive(ctx, recvSCTPConn, func() { t.ReceiveTracesSCTP6(ctx, recvSCTPConn, sport) })
+
+	tag := uint32(1)
+	for ttl := t.Options.FirstTTL; ttl <= t.Options.MaxTTL; ttl++ {
+		for j := 0; j < t.Options.ProbesPerHop; j++ {
+			release, err := t.acquireSlot(ctx)
+			if err != nil {
+				return err
+			}
+			cm, payload := t.BuildSCTP6InitPkt(sport, t.destPort, uint8(ttl), tag, t.Options.TOS())
+			pb := &Probe{
+				ID:       tag,
+				Dest:     t.DestIP,
+				Port:     t.destPort,
+				SrcPort:  sport,
+				TTL:      ttl,
+				Sendtime: time.Now(),
+				release:  release,
+				TOS:      uint8(t.Options.TOS()),
+			}
+			if err := t.sendProbe(ctx, pb); err != nil {
+				return err
+			}
+			if err := conn.WritePacket(t.packet6(cm, payload, 0), t.DestIP); err != nil {
+				return sockErr("sending probe", err)
+			}
+			tag++
+			if err := t.pause(ctx, ttl); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ReceiveTracesSCTP6 waits for the destination to answer an INIT probe.
+// Both INIT ACK (port open) and ABORT (port closed) mark the final hop.
+func (t *Trace) ReceiveTracesSCTP6(ctx context.Context, recvSCTPConn ProbeConn, sport uint16) {
+	defer recvSCTPConn.Close()
+
+	buf := make([]byte, 1500)
+	for {
+		n, from, ri, err := readReply(recvSCTPConn, buf)
+		if err != nil {
+			return
+		}
+		if !from.Equal(t.DestIP) {
+			continue
+		}
+		id, ok := sctpAnswer(buf[:n], sport, t.destPort)
+		if !ok {
+			continue
+		}
+		pb := &Probe{
+			ID:        id,
+			Saddr:     from,
+			RecvTime:  ri.At,
+			ReplyTTL:  ri.TTL,
+			QuotedTOS: -1,
+		}
+		if !t.deliver(ctx, pb) {
+			return
+		}
+	}
+}
+
+// ReceiveTracesSCTP6ICMP matches ICMPv6 errors quoting one of our INIT
+// probes to the probe by its initiate tag.
+func (t *Trace) ReceiveTracesSCTP6ICMP(ctx context.Context, recvICMPConn ProbeConn, sport uint16) {
+	defer recvICMPConn.Close()
+
+	buf := make([]byte, 1500)
+	for {
+		n, from, ri, err := readReply(recvICMPConn, buf)
+		if err != nil {
+			return
+		}
+
+		icmpErr, err := ParseICMP6Error(buf[:n])
+		if err != nil {
+			continue
+		}
+		if icmpErr.NextHeader != sctpProto || !icmpErr.dst().Equal(t.DestIP) {
+			continue
+		}
+		// ICMPv6 errors quote as much of the probe as fits.
+		if len(icmpErr.Payload) < sctpTagOffset+4 || binary.BigEndian.Uint16(icmpErr.Payload[0:2]) != sport {
+			continue
+		}
+		pb := &Probe{
+			ID:        binary.BigEndian.Uint32(icmpErr.Payload[sctpTagOffset : sctpTagOffset+4]),
+			Saddr:     from,
+			RecvTime:  ri.At,
+			ReplyTTL:  ri.TTL,
+			MPLS:      MPLSLabels(icmpErr.Extensions),
+			Unreach:   icmpErr.unreach(),
+			FragMTU:   icmpErr.fragMTU(),
+			QuotedTOS: icmpErr.Quoted.TrafficClass,
+			Quoted:    icmpErr.quotedHeader(),
+		}
+		if !t.deliver(ctx, pb) {
+			return
+		}
+	}
+}
+
+// BuildSCTP6InitPkt builds an INIT probe with the initiate tag tag.
+func (t *Trace) BuildSCTP6InitPkt(sport, dport uint16, ttl uint8, tag uint32, tc int) (*ipv6.ControlMessage, []byte) {
+	cm := &ipv6.ControlMessage{
+		TrafficClass: tc,
+		HopLimit:     int(ttl),
+	}
+	return cm, buildSCTPInit(sport, dport, tag)
+}
@@ -0,0 +1,68 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+)
+
+func TestBuildSCTPInit(t *testing.T) {
+	pkt := buildSCTPInit(1234, 80, 0xdeadbeef)
+	if len(pkt) != 32 {
+		t.Fatalf("INIT is %d bytes, want 32", len(pkt))
+	}
+
+	hdr, chunk, err := ParseSCTP(pkt)
+	if err != nil {
+		t.Fatalf("ParseSCTP() = %v", err)
+	}
+	if hdr.Src != 1234 || hdr.Dst != 80 || hdr.VerTag != 0 {
+		t.Errorf("header = %+v, want ports 1234 > 80 and verification tag 0", hdr)
+	}
+	if chunk.Type != SCTPChunkInit || chunk.Length != 20 {
+		t.Errorf("chunk = %+v, want INIT of 20 bytes", chunk)
+	}
+	if tag := binary.BigEndian.Uint32(pkt[sctpTagOffset:]); tag != 0xdeadbeef {
+		t.Errorf("initiate tag = %#x, want 0xdeadbeef", tag)
+	}
+
+	// The checksum is computed with the checksum field zeroed.
+	sum := binary.LittleEndian.Uint32(pkt[8:12])
+	b := append([]byte{}, pkt...)
+	copy(b[8:12], []byte{0, 0, 0, 0})
+	if want := crc32.Checksum(b, crc32.MakeTable(crc32.Castagnoli)); sum != want {
+		t.Errorf("checksum = %#x, want %#x", sum, want)
+	}
+}
+
+func TestSCTPAnswer(t *testing.T) {
+	answer := func(chunkType uint8, sport, dport uint16, tag uint32) []byte {
+		b := buildSCTPInit(sport, dport, 0)
+		binary.BigEndian.PutUint32(b[4:8], tag)
+		b[12] = chunkType
+		return b
+	}
+	for _, tt := range []struct {
+		name string
+		pkt  []byte
+		id   uint32
+		ok   bool
+	}{
+		{name: "InitAck", pkt: answer(SCTPChunkInitAck, 80, 1234, 7), id: 7, ok: true},
+		{name: "Abort", pkt: answer(SCTPChunkAbort, 80, 1234, 8), id: 8, ok: true},
+		{name: "Init", pkt: answer(SCTPChunkInit, 80, 1234, 9)},
+		{name: "OtherPort", pkt: answer(SCTPChunkInitAck, 81, 1234, 7)},
+		{name: "Short", pkt: []byte{0, 80, 4, 210}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			id, ok := sctpAnswer(tt.pkt, 1234, 80)
+			if ok != tt.ok || id != tt.id {
+				t.Errorf("sctpAnswer() = %d, %v, want %d, %v", id, ok, tt.id, tt.ok)
+			}
+		})
+	}
+}
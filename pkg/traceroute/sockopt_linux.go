@@ -0,0 +1,176 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Flow label management of Linux, see linux/in6.h.
+const (
+	ipv6FlowLabelMgr = 32
+	ipv6FlowInfoSend = 33
+	ipv6FlAGet       = 0
+	ipv6FlSExcl      = 1
+	ipv6FlFCreate    = 1
+)
+
+// setDontFragment6 keeps the kernel from fragmenting oversized IPv6
+// packets sent on conn, so that they fail with EMSGSIZE instead.
+func setDontFragment6(conn net.PacketConn) error {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return unix.ENOTSUP
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		serr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_DONTFRAG, 1)
+	}); err != nil {
+		return err
+	}
+	return serr
+}
+
+// bindToDevice makes the socket of rc send and receive on the network
+// interface iface only, regardless of the routing table.
+func bindToDevice(rc syscall.RawConn, iface string) error {
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		serr = unix.SetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE, iface)
+	}); err != nil {
+		return err
+	}
+	return serr
+}
+
+// leaseFlowLabel6 leases label for the packets conn sends to dst and
+// lets them carry it. Linux refuses to send flow labels that were not
+// leased first.
+func leaseFlowLabel6(conn net.PacketConn, dst net.IP, label uint32) error {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return unix.ENOTSUP
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	// struct in6_flowlabel_req
+	req := make([]byte, 32)
+	copy(req[0:16], dst.To16())
+	binary.BigEndian.PutUint32(req[16:], label)
+	req[20] = ipv6FlAGet
+	req[21] = ipv6FlSExcl
+	binary.NativeEndian.PutUint16(req[22:], ipv6FlFCreate)
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		if serr = unix.SetsockoptString(int(fd), unix.IPPROTO_IPV6, ipv6FlowLabelMgr, string(req)); serr != nil {
+			return
+		}
+		serr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, ipv6FlowInfoSend, 1)
+	}); err != nil {
+		return err
+	}
+	return serr
+}
+
+// writeFlowLabel6 sends p to dst with its flow label, which goes into
+// the flow info of the destination address.
+func writeFlowLabel6(conn net.PacketConn, p *Packet, dst net.IP) error {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return unix.ENOTSUP
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	sa := unix.RawSockaddrInet6{Family: unix.AF_INET6}
+	copy(sa.Addr[:], dst.To16())
+	binary.BigEndian.PutUint32((*[4]byte)(unsafe.Pointer(&sa.Flowinfo))[:], p.FlowLabel)
+
+	iov := unix.Iovec{Base: &p.Payload[0]}
+	iov.SetLen(len(p.Payload))
+	msg := unix.Msghdr{
+		Name:    (*byte)(unsafe.Pointer(&sa)),
+		Namelen: unix.SizeofSockaddrInet6,
+		Iov:     &iov,
+	}
+	msg.SetIovlen(1)
+	if oob := p.IPv6.Marshal(); len(oob) > 0 {
+		msg.Control = &oob[0]
+		msg.SetControllen(len(oob))
+	}
+	var serr error
+	if err := rc.Write(func(fd uintptr) bool {
+		_, _, errno := unix.Syscall(unix.SYS_SENDMSG, fd, uintptr(unsafe.Pointer(&msg)), 0)
+		if errno == unix.EAGAIN {
+			return false
+		}
+		if errno != 0 {
+			serr = errno
+		}
+		return true
+	}); err != nil {
+		return err
+	}
+	return serr
+}
+
+// enableReplyInfo has the kernel timestamp every message conn receives,
+// see SO_TIMESTAMPNS in socket(7), and for ipv6 report its hop limit.
+func enableReplyInfo(conn net.PacketConn, ipv6 bool) error {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return unix.ENOTSUP
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		serr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_TIMESTAMPNS, 1)
+		if serr == nil && ipv6 {
+			serr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_RECVHOPLIMIT, 1)
+		}
+	}); err != nil {
+		return err
+	}
+	return serr
+}
+
+// parseReplyInfo sets the receive time and hop limit ri among the
+// control messages oob, if there. The time is a struct timespec of the
+// native word size.
+func parseReplyInfo(oob []byte, ri *ReplyInfo) {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return
+	}
+	for _, m := range msgs {
+		switch {
+		case m.Header.Level == unix.SOL_SOCKET && m.Header.Type == unix.SCM_TIMESTAMPNS:
+			switch len(m.Data) {
+			case 16:
+				ri.At = time.Unix(int64(binary.NativeEndian.Uint64(m.Data)), int64(binary.NativeEndian.Uint64(m.Data[8:])))
+			case 8:
+				ri.At = time.Unix(int64(int32(binary.NativeEndian.Uint32(m.Data))), int64(binary.NativeEndian.Uint32(m.Data[4:])))
+			}
+		case m.Header.Level == unix.IPPROTO_IPV6 && m.Header.Type == unix.IPV6_HOPLIMIT && len(m.Data) >= 4:
+			ri.TTL = int(int32(binary.NativeEndian.Uint32(m.Data)))
+		}
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReceiveTimestamp(t *testing.T) {
+	c, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Skipf("no loopback: %v", err)
+	}
+	defer c.Close()
+	if err := enableReplyInfo(c, false); err != nil {
+		t.Fatalf("enableReplyInfo() = %v", err)
+	}
+	before := time.Now()
+	if _, err := c.WriteTo([]byte("probe"), c.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	b, oob := make([]byte, 16), make([]byte, 128)
+	_, oobn, _, _, err := c.ReadMsgUDP(b, oob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ri ReplyInfo
+	parseReplyInfo(oob[:oobn], &ri)
+	if ri.At.Before(before.Add(-time.Second)) || ri.At.After(time.Now()) {
+		t.Errorf("timestamp %v is not between %v and now", ri.At, before)
+	}
+	ri = ReplyInfo{}
+	if parseReplyInfo(nil, &ri); ri != (ReplyInfo{}) {
+		t.Errorf("parseReplyInfo(nil) = %+v, want nothing", ri)
+	}
+}
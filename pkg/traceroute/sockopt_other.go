@@ -0,0 +1,35 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package traceroute
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+func setDontFragment6(conn net.PacketConn) error {
+	return errors.New("IPv6 path MTU discovery is not supported on this platform")
+}
+
+func bindToDevice(rc syscall.RawConn, iface string) error {
+	return errors.New("binding to an interface is not supported on this platform")
+}
+
+func leaseFlowLabel6(conn net.PacketConn, dst net.IP, label uint32) error {
+	return errors.New("IPv6 flow labels are not supported on this platform")
+}
+
+func writeFlowLabel6(conn net.PacketConn, p *Packet, dst net.IP) error {
+	return errors.New("IPv6 flow labels are not supported on this platform")
+}
+
+func enableReplyInfo(conn net.PacketConn, ipv6 bool) error {
+	return errors.New("receive timestamps are not supported on this platform")
+}
+
+func parseReplyInfo(oob []byte, ri *ReplyInfo) {}
@@ -0,0 +1,134 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// MAXGATEWAYS4 is the largest number of gateways an IPv4 loose source
+// route holds, as the destination takes the last of its nine slots.
+const MAXGATEWAYS4 = 8
+
+var (
+	errGateways    = fmt.Errorf("more than %d gateways", MAXGATEWAYS4)
+	errGatewayAddr = errors.New("gateway is no IPv4 address")
+	errGatewayIPv6 = errors.New("IPv6 source routing is deprecated by RFC 5095, gateways need IPv4")
+)
+
+// IPv6 extension headers a quoted probe may carry in front of its
+// upper-layer header, RFC 8200.
+const (
+	ipv6HopByHop = 0
+	ipv6Routing  = 43
+	ipv6DestOpts = 60
+)
+
+// IPv6 routing header types naming the final destination, RFC 5095,
+// RFC 6275 and RFC 8754.
+const (
+	ipv6RoutingType0       = 0
+	ipv6RoutingType2       = 2
+	ipv6RoutingSegmentList = 4
+)
+
+// checkGateways returns an error unless gateways can be source routed
+// through on the way to a destination of proto.
+func checkGateways(gateways []net.IP, proto string) error {
+	if len(gateways) == 0 {
+		return nil
+	}
+	if strings.HasSuffix(proto, "6") {
+		return errGatewayIPv6
+	}
+	if len(gateways) > MAXGATEWAYS4 {
+		return errGateways
+	}
+	for _, gw := range gateways {
+		if gw.To4() == nil {
+			return fmt.Errorf("%w: %v", errGatewayAddr, gw)
+		}
+	}
+	return nil
+}
+
+// sourceRoute returns the loose source route option probes are sent
+// with, word aligned by a NOP in front, or nil without gateways. The
+// first gateway goes into the destination of the IP header, so the
+// route lists the others followed by the destination.
+func (t *Trace) sourceRoute() []byte {
+	gws := t.Options.Gateways
+	if len(gws) == 0 {
+		return nil
+	}
+	b := []byte{IPOptNOP, IPOptLSRR, byte(3 + 4*len(gws)), 4}
+	for _, gw := range gws[1:] {
+		b = append(b, gw.To4()...)
+	}
+	return append(b, t.DestIP.To4()...)
+}
+
+// isDest reports whether ip is the destination a probe is quoted with
+// in an ICMP error. On the way to each gateway of a source route, the
+// gateway is the destination.
+func (t *Trace) isDest(ip net.IP) bool {
+	if ip.Equal(t.DestIP) {
+		return true
+	}
+	for _, gw := range t.Options.Gateways {
+		if ip.Equal(gw) {
+			return true
+		}
+	}
+	return false
+}
+
+// skipIPv6ExtHeaders skips the extension headers starting b, the first
+// of which is nextHeader. It returns the upper-layer header that
+// follows and its protocol, together with the final destination if a
+// routing header names one.
+func skipIPv6ExtHeaders(b []byte, nextHeader int) ([]byte, int, net.IP) {
+	var final net.IP
+	for {
+		switch nextHeader {
+		case ipv6HopByHop, ipv6Routing, ipv6DestOpts:
+		default:
+			return b, nextHeader, final
+		}
+		if len(b) < 8 {
+			return b, nextHeader, final
+		}
+		n := 8 + int(b[1])*8
+		if n > len(b) {
+			return b, nextHeader, final
+		}
+		if nextHeader == ipv6Routing {
+			final = routingFinalDst(b[:n])
+		}
+		nextHeader = int(b[0])
+		b = b[n:]
+	}
+}
+
+// routingFinalDst returns the final destination listed in the IPv6
+// routing header h, nil if it has no addresses. Type 0 and 2 headers
+// list it last, segment routing headers first.
+func routingFinalDst(h []byte) net.IP {
+	addrs := h[8:]
+	if len(addrs) < net.IPv6len {
+		return nil
+	}
+	switch h[2] {
+	case ipv6RoutingType0, ipv6RoutingType2:
+		n := len(addrs) / net.IPv6len
+		return net.IP(append([]byte{}, addrs[(n-1)*net.IPv6len:n*net.IPv6len]...))
+	case ipv6RoutingSegmentList:
+		return net.IP(append([]byte{}, addrs[:net.IPv6len]...))
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+func TestCheckGateways(t *testing.T) {
+	gw := net.IPv4(192, 0, 2, 1)
+	for _, tt := range []struct {
+		name     string
+		gateways []net.IP
+		proto    string
+		err      error
+	}{
+		{name: "None", proto: "udp6"},
+		{name: "IPv4", gateways: []net.IP{gw}, proto: "udp4"},
+		{name: "IPv6", gateways: []net.IP{gw}, proto: "icmp6", err: errGatewayIPv6},
+		{name: "TooMany", gateways: make([]net.IP, MAXGATEWAYS4+1), proto: "udp4", err: errGateways},
+		{name: "IPv6Gateway", gateways: []net.IP{net.ParseIP("2001:db8::1")}, proto: "tcp4", err: errGatewayAddr},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkGateways(tt.gateways, tt.proto); !errors.Is(err, tt.err) {
+				t.Errorf("checkGateways() = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestSetIPv4OptionsSourceRoute(t *testing.T) {
+	dest := net.IPv4(198, 51, 100, 1).To4()
+	gws := []net.IP{net.IPv4(192, 0, 2, 1), net.IPv4(203, 0, 113, 1)}
+	tr := &Trace{
+		DestIP: dest,
+		SrcIP:  net.IPv4(192, 0, 2, 100).To4(),
+		Options: TracerouteOptions{
+			Gateways: gws,
+			IPOption: IPOptionRecordRoute,
+		},
+	}
+	iph, pl, err := tr.BuildUDP4Pkt(1234, 33434, 1, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.setIPv4Options(iph); err != nil {
+		t.Fatalf("setIPv4Options() = %v", err)
+	}
+	// The UDP checksum covers the final destination.
+	final := *iph
+	final.Dst = dest
+	if checkSum(packet(pseudoHeader4(&final, 17, len(pl)), pl)) != 0xffff {
+		t.Errorf("UDP checksum does not verify against the final destination")
+	}
+	b, err := iph.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkSum(b) != 0xffff {
+		t.Errorf("header checksum does not verify")
+	}
+	h, err := ipv4.ParseHeader(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !h.Dst.Equal(gws[0]) {
+		t.Errorf("destination = %v, want the first gateway %v", h.Dst, gws[0])
+	}
+	if len(h.Options) > ipOptMaxLen || h.TotalLen != h.Len+len(pl) {
+		t.Errorf("%d bytes of options, total length %d, want at most %d and %d", len(h.Options), h.TotalLen, ipOptMaxLen, h.Len+len(pl))
+	}
+	want := []byte{IPOptNOP, IPOptLSRR, 11, 4, 203, 0, 113, 1, 198, 51, 100, 1}
+	if string(h.Options[:len(want)]) != string(want) {
+		t.Errorf("source route = %v, want %v", h.Options[:len(want)], want)
+	}
+	// Record route makes do with the room left.
+	if rr := h.Options[len(want):]; rr[0] != IPOptRecordRoute || rr[1] != 3+4*6 {
+		t.Errorf("record route = %v, want six slots", rr)
+	}
+	if !tr.isDest(gws[1]) || !tr.isDest(dest) || tr.isDest(tr.SrcIP) {
+		t.Errorf("isDest() does not accept exactly the gateways and the destination")
+	}
+}
+
+func TestSourceRouteFakeNetwork(t *testing.T) {
+	src := net.IPv4(192, 0, 2, 100)
+	dest := net.IPv4(198, 51, 100, 1)
+	routers := []net.IP{net.IPv4(192, 0, 2, 1), net.IPv4(203, 0, 113, 1)}
+
+	cc := Coms{
+		SendChan: make(chan *Probe),
+		RecvChan: make(chan *Probe),
+	}
+	tr := NewTrace("udp4", dest, src, cc, &Flags{TracerouteOptions: TracerouteOptions{
+		MaxTTL:       5,
+		ProbesPerHop: 1,
+		Interval:     time.Millisecond,
+		Timeout:      time.Second,
+		Gateways:     []net.IP{routers[1]},
+	}})
+	tr.Network = newFakeNetwork(dest.To4(), routers...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go tr.SendTracesUDP4(ctx)
+	printMap := runTransmission(ctx, cc, 1, tr.Options.Timeout, nil)
+
+	// The fake network quotes probes with the gateway as destination.
+	for ttl, want := range map[int]net.IP{1: routers[0], 2: routers[1], 3: dest} {
+		pbs := GetProbesByTLL(printMap, ttl)
+		if len(pbs) != 1 || !pbs[0].Saddr.Equal(want) {
+			t.Errorf("TTL %d: answers %v, want one from %v", ttl, pbs, want)
+		}
+	}
+}
+
+func TestParseICMP6ErrorRoutingHeader(t *testing.T) {
+	src := net.ParseIP("2001:db8::100")
+	segment := net.ParseIP("2001:db8::1")
+	final := net.ParseIP("2001:db8:1::1")
+	udp := UDPHeader{Src: 1234, Dst: 33434, Length: UDPHeaderLen}
+
+	for _, tt := range []struct {
+		name    string
+		routing []byte
+	}{
+		{name: "SegmentList", routing: packet([]byte{17, 4, ipv6RoutingSegmentList, 1, 1, 0, 0, 0}, final.To16(), segment.To16())},
+		{name: "Type0", routing: packet([]byte{17, 4, ipv6RoutingType0, 1, 0, 0, 0, 0}, segment.To16(), final.To16())},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			// The probe carries a hop-by-hop header in front of its
+			// routing header.
+			ext := packet([]byte{ipv6Routing, 0, 1, 4, 0, 0, 0, 0}, tt.routing, udp.Marshal())
+			quoted := packet(ipv6Header(src, segment, ipv6HopByHop, 1, 0, 0, len(ext)), ext)
+			m, err := ParseICMP6Error(packet([]byte{ICMP6TimeExceeded, 0, 0, 0, 0, 0, 0, 0}, quoted))
+			if err != nil {
+				t.Fatalf("ParseICMP6Error() = %v", err)
+			}
+			if !m.Quoted.Dst.Equal(segment) || !m.FinalDst.Equal(final) || !m.dst().Equal(final) {
+				t.Errorf("destination %v, final %v, want %v and %v", m.Quoted.Dst, m.FinalDst, segment, final)
+			}
+			if m.NextHeader != 17 || string(m.Payload) != string(udp.Marshal()) {
+				t.Errorf("upper layer %d %x, want UDP %x", m.NextHeader, m.Payload, udp.Marshal())
+			}
+		})
+	}
+
+	// Without extension headers, the quoted destination is final.
+	quoted := packet(ipv6Header(src, final, 17, 1, 0, 0, UDPHeaderLen), udp.Marshal())
+	m, err := ParseICMP6Error(packet([]byte{ICMP6TimeExceeded, 0, 0, 0, 0, 0, 0, 0}, quoted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.FinalDst != nil || !m.dst().Equal(final) || m.NextHeader != 17 || len(m.Payload) != UDPHeaderLen {
+		t.Errorf("ParseICMP6Error() = %+v, want the UDP header to %v", m, final)
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"math"
+	"time"
+)
+
+// RTTStats summarizes the round trip times of the answered probes of a
+// hop, in milliseconds.
+type RTTStats struct {
+	Min    float64 `json:"min_ms"`
+	Avg    float64 `json:"avg_ms"`
+	Max    float64 `json:"max_ms"`
+	StdDev float64 `json:"stddev_ms"`
+	// Jitter is the mean difference between the round trip times of
+	// probes sent one after the other.
+	Jitter float64 `json:"jitter_ms"`
+}
+
+// NewRTTStats computes the statistics of rtts, given in the order the
+// probes were sent. It returns nil if rtts is empty.
+func NewRTTStats(rtts []time.Duration) *RTTStats {
+	if len(rtts) == 0 {
+		return nil
+	}
+	ms := func(d time.Duration) float64 {
+		return float64(d/time.Microsecond) / 1000
+	}
+	s := &RTTStats{Min: ms(rtts[0]), Max: ms(rtts[0])}
+	var sum, jitter float64
+	for i, rtt := range rtts {
+		v := ms(rtt)
+		s.Min = min(s.Min, v)
+		s.Max = max(s.Max, v)
+		sum += v
+		if i > 0 {
+			jitter += math.Abs(v - ms(rtts[i-1]))
+		}
+	}
+	n := float64(len(rtts))
+	s.Avg = sum / n
+	var sq float64
+	for _, rtt := range rtts {
+		d := ms(rtt) - s.Avg
+		sq += d * d
+	}
+	s.StdDev = math.Sqrt(sq / n)
+	if len(rtts) > 1 {
+		s.Jitter = jitter / (n - 1)
+	}
+	return s
+}
@@ -0,0 +1,53 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestNewRTTStats(t *testing.T) {
+	if s := NewRTTStats(nil); s != nil {
+		t.Errorf("NewRTTStats(nil) = %+v, want nil", s)
+	}
+
+	s := NewRTTStats([]time.Duration{10 * time.Millisecond, 14 * time.Millisecond, 12 * time.Millisecond})
+	want := RTTStats{Min: 10, Avg: 12, Max: 14, StdDev: math.Sqrt(8.0 / 3), Jitter: 3}
+	for _, f := range []struct {
+		name      string
+		got, want float64
+	}{
+		{"Min", s.Min, want.Min},
+		{"Avg", s.Avg, want.Avg},
+		{"Max", s.Max, want.Max},
+		{"StdDev", s.StdDev, want.StdDev},
+		{"Jitter", s.Jitter, want.Jitter},
+	} {
+		if math.Abs(f.got-f.want) > 1e-9 {
+			t.Errorf("%s = %v, want %v", f.name, f.got, f.want)
+		}
+	}
+
+	if s := NewRTTStats([]time.Duration{time.Millisecond}); s.Jitter != 0 || s.StdDev != 0 {
+		t.Errorf("single probe: %+v, want no jitter or deviation", s)
+	}
+}
+
+func TestHopLoss(t *testing.T) {
+	start := time.Now()
+	pbs := []*Probe{
+		{ID: 1, Saddr: []byte{192, 0, 2, 1}, Sendtime: start, RecvTime: start.Add(2 * time.Millisecond)},
+	}
+	h := newHop(3, 4, pbs)
+	if h.Sent != 4 || h.Loss != 75 || h.RTT == nil || h.RTT.Avg != 2 {
+		t.Errorf("newHop() = %+v, want 4 sent, 75%% loss, 2ms average", h)
+	}
+	h.setSent(0)
+	if h.Sent != 1 || h.Loss != 0 {
+		t.Errorf("setSent(0) = %d sent, %v%% loss, want answers counted as sent", h.Sent, h.Loss)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// ProbeStrategy selects how probes of a trace differ from each other.
+type ProbeStrategy int
+
+const (
+	// StrategyClassic varies the UDP destination port per probe like
+	// the original traceroute. Load balancers hashing on ports may send
+	// consecutive probes down different paths.
+	StrategyClassic ProbeStrategy = iota
+	// StrategyParis keeps every field that load balancers hash on
+	// constant across all probes of a trace: ports, the IPv6 flow label,
+	// and the UDP and ICMP checksums. The probe ID goes into fields that
+	// are not hashed, and compensation bytes in the payload keep the
+	// checksum unchanged.
+	StrategyParis
+)
+
+func (s ProbeStrategy) String() string {
+	switch s {
+	case StrategyClassic:
+		return "classic"
+	case StrategyParis:
+		return "paris"
+	}
+	return fmt.Sprintf("ProbeStrategy(%d)", int(s))
+}
+
+// numFlows returns the number of flows probed per TTL.
+func (t *Trace) numFlows() int {
+	if t.Flows < 1 {
+		return 1
+	}
+	return t.Flows
+}
+
+// probeDstPort returns the UDP destination port for the next probe of
+// the given flow. Classic traces send every probe to the port after the
+// previous one, starting at the destination port, so that the port
+// quoted in an ICMP error identifies the probe too. When enumerating
+// multiple paths, each flow is a Paris trace of its own, told apart
+// from the others by its port.
+func (t *Trace) probeDstPort(flow int) uint16 {
+	if t.numFlows() > 1 {
+		return t.destPort + uint16(flow)
+	}
+	if t.Strategy == StrategyParis || t.Options.FixedPort {
+		return t.destPort
+	}
+	port := t.destPort + t.portSeq
+	t.portSeq++
+	return port
+}
+
+// checksumIDs reports whether UDP probes carry their ID in their
+// checksum, which survives middleboxes rewriting ports or the IP ID.
+// Paris traces and the flows of multipath traces keep it constant
+// instead.
+func (t *Trace) checksumIDs() bool {
+	return t.Strategy != StrategyParis && t.numFlows() == 1
+}
+
+// udpChecksumID returns the UDP checksum encoding the probe ID id. IDs
+// stay below 1<<15, so the checksum is never zero, which means none.
+func udpChecksumID(id uint16) uint16 {
+	return id + 1
+}
+
+// putChecksumID writes the two bytes b of a UDP datagram such that its
+// checksum encodes id. b must be zero and at an even offset, and sum the
+// checksum of the datagram with b zero.
+func putChecksumID(b []byte, sum, id uint16) {
+	v := uint32(^udpChecksumID(id)) + uint32(sum)
+	binary.BigEndian.PutUint16(b, uint16(v>>16+v&0xffff))
+}
+
+// checksumProbeID decodes the probe ID from the checksum of the UDP
+// header udp, as quoted in an ICMP error. It returns false if the
+// checksum encodes none.
+func checksumProbeID(udp []byte) (uint16, bool) {
+	if len(udp) < UDPHeaderLen {
+		return 0, false
+	}
+	sum := binary.BigEndian.Uint16(udp[6:8])
+	if sum == 0 || sum > udpChecksumID(1<<15-1) {
+		return 0, false
+	}
+	return sum - 1, true
+}
+
+// putChecksumCompensation writes the one's complement of id to b. Since
+// id + ^id is constant in one's complement arithmetic, a packet carrying
+// both has the same checksum whatever the probe ID is.
+func putChecksumCompensation(b []byte, id uint16) {
+	binary.BigEndian.PutUint16(b, ^id)
+}
@@ -0,0 +1,124 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var errStreamMTU = errors.New("path MTU discovery can not be streamed")
+
+// HopFunc is called with each hop of a trace as soon as all probes sent
+// with its TTL have been answered or have timed out. Hops are reported
+// in TTL order, up to the destination.
+type HopFunc func(Hop)
+
+// StreamTraceroute runs the trace described by f like
+// RunTracerouteContext, but instead of printing the trace it calls fn
+// with every hop while the trace is still running. It returns the
+// result of the whole trace. Cancelling ctx reports the hops found so
+// far and returns their result together with the error of ctx.
+func StreamTraceroute(ctx context.Context, f *Flags, fn HopFunc) (_ *Result, err error) {
+	if f.MTU {
+		return nil, errStreamMTU
+	}
+	t, err := newTracer(f)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := t.close(); err == nil {
+			err = cerr
+		}
+	}()
+	printMap, sent, err := t.run(ctx, fn)
+	if err != nil {
+		return nil, err
+	}
+	return t.result(printMap, sent), ctx.Err()
+}
+
+// hopStream reports the hops of a trace while its answers come in.
+type hopStream struct {
+	// next is the TTL of the next hop to report.
+	next int
+	// perHop is the number of probes sent with each TTL.
+	perHop  int
+	timeout time.Duration
+	sent    map[int][]*Probe
+	// sendDone is set once no more probes are sent.
+	sendDone bool
+	report   func(ttl, sent int, answered []*Probe, limited bool)
+}
+
+// newHopStream returns the stream of the hops of t. report is called
+// with the number of probes of each hop that were answered or timed out,
+// the answered ones and whether the hop seems to rate-limit its answers.
+func newHopStream(t *Trace, report func(ttl, sent int, answered []*Probe, limited bool)) *hopStream {
+	return &hopStream{
+		next:    t.Options.FirstTTL,
+		perHop:  t.Options.ProbesPerHop * t.numFlows(),
+		timeout: t.Options.Timeout,
+		sent:    map[int][]*Probe{},
+		report:  report,
+	}
+}
+
+// add records a probe that is about to be sent.
+func (s *hopStream) add(pb *Probe) {
+	s.sent[pb.TTL] = append(s.sent[pb.TTL], pb)
+}
+
+// advance reports every pending hop whose probes all have been answered
+// or timed out at now. It returns the time the next hop times out, or
+// the zero time if it waits for more of its probes to be sent first.
+func (s *hopStream) advance(now time.Time) time.Time {
+	for {
+		pbs := s.sent[s.next]
+		if len(pbs) == 0 || len(pbs) < s.perHop && !s.sendDone {
+			return time.Time{}
+		}
+		var deadline time.Time
+		for _, pb := range pbs {
+			if d := pb.Sendtime.Add(s.timeout); !pb.Done && d.After(deadline) {
+				deadline = d
+			}
+		}
+		if deadline.After(now) {
+			return deadline
+		}
+		s.reportNext(now)
+	}
+}
+
+// flush reports the remaining hops up to lastTTL once the trace is over.
+func (s *hopStream) flush(lastTTL int) {
+	now := time.Now()
+	for s.next <= lastTTL {
+		s.reportNext(now)
+	}
+}
+
+// reportNext reports the next hop. Its probes that timed out by now
+// count as lost, those still in flight are left out.
+func (s *hopStream) reportNext(now time.Time) {
+	var answered []*Probe
+	sent := 0
+	for _, pb := range s.sent[s.next] {
+		switch {
+		case pb.Done:
+			answered = append(answered, pb)
+			sent++
+		case !now.Before(pb.Sendtime.Add(s.timeout)):
+			sent++
+		}
+	}
+	limited := s.rateLimited(s.next)
+	delete(s.sent, s.next)
+	s.report(s.next, sent, answered, limited)
+	s.next++
+}
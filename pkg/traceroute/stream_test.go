@@ -0,0 +1,114 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"context"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestHopStream(t *testing.T) {
+	tr := &Trace{Options: TracerouteOptions{FirstTTL: 1, ProbesPerHop: 2, Timeout: time.Second}}
+	var reported, sent []int
+	s := newHopStream(tr, func(ttl, n int, answered []*Probe, _ bool) {
+		reported = append(reported, ttl)
+		sent = append(sent, n)
+		for _, pb := range answered {
+			if !pb.Done {
+				t.Errorf("TTL %d: unanswered probe %d reported", ttl, pb.ID)
+			}
+		}
+	})
+
+	start := time.Now()
+	probes := make([]*Probe, 6)
+	for i := range probes {
+		probes[i] = &Probe{ID: uint32(i), TTL: i/2 + 1, Sendtime: start}
+	}
+
+	s.add(probes[0])
+	probes[0].Done = true
+	if d := s.advance(start); !d.IsZero() || len(reported) != 0 {
+		t.Fatalf("hop reported before all its probes were sent: %v, %v", reported, d)
+	}
+	s.add(probes[1])
+	s.add(probes[2])
+	s.add(probes[3])
+	probes[2].Done = true
+	probes[3].Done = true
+	if d := s.advance(start); !d.Equal(start.Add(time.Second)) || len(reported) != 0 {
+		t.Fatalf("advance() = %v, reported %v, want to wait for TTL 1 to time out", d, reported)
+	}
+	// TTL 2 is complete, but is reported after TTL 1.
+	if d := s.advance(start.Add(2 * time.Second)); !d.IsZero() || len(reported) != 2 {
+		t.Fatalf("advance() = %v, reported %v, want TTLs 1 and 2", d, reported)
+	}
+
+	s.add(probes[4])
+	s.flush(4)
+	if want := []int{1, 2, 3, 4}; len(reported) != len(want) {
+		t.Fatalf("reported %v, want %v", reported, want)
+	}
+	for i, ttl := range reported {
+		if ttl != i+1 {
+			t.Errorf("reported %v out of order", reported)
+		}
+	}
+	// The probe of TTL 3 was still in flight when the trace ended.
+	if want := []int{2, 2, 0, 0}; !slices.Equal(sent, want) {
+		t.Errorf("sent = %v, want %v", sent, want)
+	}
+}
+
+func TestTraceStream(t *testing.T) {
+	src := net.IPv4(192, 0, 2, 100)
+	dest := net.IPv4(198, 51, 100, 1)
+	routers := []net.IP{
+		net.IPv4(192, 0, 2, 1),
+		net.IPv4(203, 0, 113, 1),
+	}
+	cc := Coms{
+		SendChan: make(chan *Probe),
+		RecvChan: make(chan *Probe),
+	}
+	tr := NewTrace("udp4", dest, src, cc, &Flags{TracerouteOptions: TracerouteOptions{
+		MaxTTL:       10,
+		ProbesPerHop: 2,
+		Interval:     time.Millisecond,
+		Timeout:      time.Second,
+	}})
+	tr.Network = newFakeNetwork(dest.To4(), routers...)
+
+	var hops []Hop
+	s := newHopStream(tr, func(ttl, sent int, answered []*Probe, _ bool) {
+		hops = append(hops, newHop(ttl, sent, answered))
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go tr.SendTracesUDP4(ctx)
+	printMap := runTransmission(ctx, cc, 1, tr.Options.Timeout, s)
+	// The routers answered all their probes, so their hops were
+	// reported while the trace was running.
+	if len(hops) != len(routers) {
+		t.Fatalf("%d hops reported during the trace, want %d", len(hops), len(routers))
+	}
+	s.flush(DestTTL(printMap))
+
+	if len(hops) != len(routers)+1 {
+		t.Fatalf("%d hops reported, want %d", len(hops), len(routers)+1)
+	}
+	for i, h := range hops {
+		want := dest
+		if i < len(routers) {
+			want = routers[i]
+		}
+		if h.TTL != i+1 || len(h.Probes) == 0 || h.Probes[0].Addr != want.String() || h.RTT == nil {
+			t.Errorf("hop %d = %+v, want TTL %d answered by %v", i, h, i+1, want)
+		}
+	}
+}
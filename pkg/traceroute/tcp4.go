@@ -5,148 +5,227 @@
 package traceroute
 
 import (
-	"bytes"
+	"context"
 	"encoding/binary"
-	"fmt"
-	"log"
 	"math/rand"
-	"net"
 	"time"
 
 	"golang.org/x/net/ipv4"
 )
 
-func (t *Trace) SendTracesTCP4() {
+// SendTracesTCP4 sends TCP probes of the kind set by Options.TCPProbe
+// with increasing TTLs to the destination port. The sequence number
+// identifies the probe.
+func (t *Trace) SendTracesTCP4(ctx context.Context) error {
+	defer close(t.SendChan)
+
 	sport := uint16(1000 + t.PortOffset + rand.Int31n(500))
-	conn, err := net.ListenPacket("ip4:tcp", t.SrcIP.String())
+	conn, err := t.network().ProbeConn("ip4:tcp")
 	if err != nil {
-		log.Fatal(err)
+		return sockErr("opening probe socket", err)
 	}
-	defer conn.Close()
 
-	rSocket, err := ipv4.NewRawConn(conn)
+	recvICMPConn, err := t.network().ReplyConn("ip4:icmp", t.SrcIP)
+	if err != nil {
+		conn.Close()
+		return sockErr("opening ICMP socket", err)
+	}
+	recvTCPConn, err := t.network().ReplyConn("ip4:tcp", t.SrcIP)
 	if err != nil {
-		log.Fatal("can not create raw socket:", err)
+		conn.Close()
+		recvICMPConn.Close()
+		return sockErr("opening TCP socket", err)
 	}
+	t.receive(ctx, recvICMPConn, func() { t.ReceiveTracesTCP4ICMP(ctx, recvICMPConn, sport) })
+	// The TCP receive loop resets half-open connections through conn,
+	// so it outlives the send loop and closes conn when it ends.
+	t.receive(ctx, recvTCPConn, func() {
+		defer conn.Close()
+		t.ReceiveTracesTCP4(ctx, conn, recvTCPConn, sport)
+	})
+
 	seq := uint32(1000)
 	mod := uint32(1 << 30)
-	for ttl := 1; ttl <= int(t.MaxHops); ttl++ {
-		for j := 0; j < t.TracesPerHop; j++ {
-			hdr, payload := t.BuildTCP4SYNPkt(sport, t.destPort, uint8(ttl), seq, 0)
-			rSocket.WriteTo(hdr, payload, nil)
+	for ttl := t.Options.FirstTTL; ttl <= t.Options.MaxTTL; ttl++ {
+		for j := 0; j < t.Options.ProbesPerHop; j++ {
+			release, err := t.acquireSlot(ctx)
+			if err != nil {
+				return err
+			}
+			hdr, payload, err := t.BuildTCP4ProbePkt(sport, t.destPort, uint8(ttl), seq, t.Options.TOS(), t.Options.TCPProbe)
+			if err != nil {
+				return err
+			}
+			if err := t.setIPv4Options(hdr); err != nil {
+				return err
+			}
 			pb := &Probe{
 				ID:       seq,
 				Dest:     t.DestIP,
+				Port:     t.destPort,
+				SrcPort:  sport,
 				TTL:      ttl,
 				Sendtime: time.Now(),
+				release:  release,
+				TOS:      uint8(t.Options.TOS()),
+			}
+			if err := t.sendProbe(ctx, pb); err != nil {
+				return err
+			}
+			if err := conn.WritePacket(&Packet{IPv4: hdr, Payload: payload}, t.DestIP); err != nil {
+				return sockErr("sending probe", err)
 			}
-			t.SendChan <- pb
 			seq = (seq + 4) % mod
-			go t.ReceiveTracesTCP4ICMP()
-			go t.ReceiveTracesTCP4()
-			time.Sleep(time.Microsecond * time.Duration(200000/t.PacketRate))
+			if err := t.pause(ctx, ttl); err != nil {
+				return err
+			}
 		}
-
 	}
+	return nil
 }
 
-func (t *Trace) ReceiveTracesTCP4() {
-	recvTCPConn, err := net.ListenIP("ip4:tcp", &net.IPAddr{IP: t.SrcIP})
-	if err != nil {
-		log.Fatal("bind TCP failure:", err)
-	}
+// ReceiveTracesTCP4 waits for the destination to answer a probe. Both
+// SYN/ACK (port open) and RST mark the final hop. A SYN/ACK is answered
+// with a RST so that the handshake is never completed and the
+// destination does not keep the half-open connection.
+func (t *Trace) ReceiveTracesTCP4(ctx context.Context, conn, recvTCPConn ProbeConn, sport uint16) {
+	defer recvTCPConn.Close()
+
 	buf := make([]byte, 1500)
-	n, raddr, err := recvTCPConn.ReadFrom(buf)
-	if err != nil {
-		return
-	}
+	for {
+		n, from, ri, err := readReply(recvTCPConn, buf)
+		if err != nil {
+			return
+		}
+		if !from.Equal(t.DestIP) {
+			continue
+		}
+		tcphdr, err := ParseTCP(buf[:n])
+		if err != nil || tcphdr.Src != t.destPort || tcphdr.Dst != sport {
+			continue
+		}
 
-	if (n >= 20) && (n <= 100) {
-		if (buf[13] == TCP_ACK+TCP_SYN) && (raddr.String() == t.DestIP.String()) {
-			//no need to generate RST message, Linux will automatically send rst
-			//sport := binary.BigEndian.Uint16(buf[0:2])
-			//dport := binary.BigEndian.Uint16(buf[2:4])
-			ack := binary.BigEndian.Uint32(buf[8:12]) - 1
-			pb := &Probe{
-				ID:       ack,
-				Saddr:    net.ParseIP(raddr.String()),
-				RecvTime: time.Now(),
+		switch {
+		case tcphdr.Flags&(TCP_SYN|TCP_ACK) == TCP_SYN|TCP_ACK:
+			if hdr, payload, err := t.BuildTCP4RSTPkt(sport, t.destPort, tcphdr.AckNum, t.Options.TOS()); err == nil {
+				conn.WritePacket(&Packet{IPv4: hdr, Payload: payload}, t.DestIP)
 			}
-			t.ReceiveChan <- pb
+		case tcphdr.Flags&TCP_RST != 0:
+		default:
+			continue
+		}
+		pb := &Probe{
+			ID:        tcpAnswerID(tcphdr),
+			Saddr:     from,
+			RecvTime:  ri.At,
+			ReplyTTL:  ri.TTL,
+			QuotedTOS: -1,
+			TCPFlags:  tcphdr.Flags,
+		}
+		if !t.deliver(ctx, pb) {
+			return
 		}
 	}
 }
 
-func (t *Trace) ReceiveTracesTCP4ICMP() {
-	recvICMPConn, err := net.ListenIP("ip4:icmp", &net.IPAddr{IP: t.SrcIP})
-	if err != nil {
-		log.Fatal("bind failure:", err)
-	}
+// ReceiveTracesTCP4ICMP matches ICMP errors quoting one of our TCP
+// probes to the probe by its sequence number.
+func (t *Trace) ReceiveTracesTCP4ICMP(ctx context.Context, recvICMPConn ProbeConn, sport uint16) {
+	defer recvICMPConn.Close()
+
+	buf := make([]byte, 1500)
 	for {
-		buf := make([]byte, 1500)
-		n, raddr, err := recvICMPConn.ReadFrom(buf)
+		n, from, ri, err := readReply(recvICMPConn, buf)
 		if err != nil {
-			break
+			return
 		}
 
-		icmpType := buf[0]
-		if (icmpType == 11 || (icmpType == 3 && buf[1] == 3)) && (n >= 36) { //TTL Exceeded or Port Unreachable
-			seq := binary.BigEndian.Uint32(buf[32:36])
-			dstip := net.IP(buf[24:28])
-			//srcip := net.IP(buf[20:24])
-			//srcPort := binary.BigEndian.Uint16(buf[28:30])
-			//dstPort := binary.BigEndian.Uint16(buf[30:32])
-			if dstip.Equal(t.DestIP) { // && dstPort == t.dstPort {
-				pb := &Probe{
-					ID:       seq,
-					Saddr:    net.ParseIP(raddr.String()),
-					RecvTime: time.Now(),
-				}
-				t.ReceiveChan <- pb
-			}
+		icmpErr, err := ParseICMP4Error(buf[:n])
+		if err != nil {
+			continue
+		}
+		// Only the first 8 bytes of the TCP header are guaranteed to be
+		// quoted, which covers ports and sequence number.
+		if !t.isDest(icmpErr.Quoted.Dst) || binary.BigEndian.Uint16(icmpErr.Payload[0:2]) != sport {
+			continue
+		}
+		pb := &Probe{
+			ID:        binary.BigEndian.Uint32(icmpErr.Payload[4:8]),
+			Saddr:     from,
+			RecvTime:  ri.At,
+			ReplyTTL:  ri.TTL,
+			MPLS:      MPLSLabels(icmpErr.Extensions),
+			Unreach:   icmpErr.unreach(),
+			FragMTU:   icmpErr.fragMTU(),
+			QuotedTOS: icmpErr.Quoted.TOS,
+			Quoted:    icmpErr.quotedHeader(),
+			Recorded:  ParseRecordedRoute(icmpErr.Quoted.Options),
+		}
+		if !t.deliver(ctx, pb) {
+			return
 		}
 	}
 }
 
-func (t *Trace) IPv4TCPProbe(dport uint16) {
-	seq := uint32(1000)
-	mod := uint32(1 << 30)
-	for i := 0; i < t.MaxHops; i++ {
-		go t.IPv4TCPPing(seq, dport)
-		seq = (seq + 4) % mod
-		time.Sleep(time.Microsecond * time.Duration(200000/t.PacketRate))
+func (t *Trace) BuildTCP4SYNPkt(srcPort uint16, dstPort uint16, ttl uint8, seq uint32, tos int) (*ipv4.Header, []byte, error) {
+	tcp := TCPHeader{
+		Src:        srcPort,
+		Dst:        dstPort,
+		SeqNum:     seq,
+		AckNum:     0,
+		DataOffset: 160,
+		Flags:      TCP_SYN,
+		Window:     64240,
+		Urgent:     0,
 	}
 
+	//payload is TCP Optionheader
+	payload := []byte{0x02, 0x04, 0x05, 0xb4, 0x04, 0x02, 0x08, 0x0a, 0x7f, 0x73, 0xf9, 0x3a, 0x00, 0x00, 0x00, 0x00, 0x01, 0x03, 0x03, 0x07}
+	return t.buildTCP4Pkt(&tcp, ttl, tos, payload)
 }
 
-func (t *Trace) IPv4TCPPing(seq uint32, dport uint16) {
-	pbs := &Probe{
-		ID:       seq,
-		Dest:     t.DestIP,
-		TTL:      0,
-		Sendtime: time.Now(),
+// BuildTCP4ProbePkt builds a probe of kind probe with sequence number
+// seq. SYN probes carry the options of a real connection attempt, the
+// others none. ACK probes acknowledge seq as well, for RSTs answering
+// them to echo it.
+func (t *Trace) BuildTCP4ProbePkt(srcPort, dstPort uint16, ttl uint8, seq uint32, tos int, probe TCPProbe) (*ipv4.Header, []byte, error) {
+	if probe == TCPProbeSYN {
+		return t.BuildTCP4SYNPkt(srcPort, dstPort, ttl, seq, tos)
 	}
-	t.SendChan <- pbs
-
-	conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", t.DestIP.String(), dport), time.Second*2)
-	if err != nil {
-		log.Fatal(err)
+	tcp := TCPHeader{
+		Src:        srcPort,
+		Dst:        dstPort,
+		SeqNum:     seq,
+		DataOffset: 5 << 4,
+		Flags:      probe.flags(),
+		Window:     64240,
 	}
-	conn.Close()
-	pbr := &Probe{
-		ID:       seq,
-		Saddr:    t.DestIP,
-		RecvTime: time.Now(),
+	if probe == TCPProbeACK {
+		tcp.AckNum = seq
 	}
-	t.ReceiveChan <- pbr
+	return t.buildTCP4Pkt(&tcp, ttl, tos, nil)
 }
 
-func (t *Trace) BuildTCP4SYNPkt(srcPort uint16, dstPort uint16, ttl uint8, seq uint32, tos int) (*ipv4.Header, []byte) {
+// BuildTCP4RSTPkt builds the RST that tears down the half-open
+// connection left behind by a SYN/ACK. seq is the acknowledgement
+// number of the SYN/ACK.
+func (t *Trace) BuildTCP4RSTPkt(srcPort uint16, dstPort uint16, seq uint32, tos int) (*ipv4.Header, []byte, error) {
+	tcp := TCPHeader{
+		Src:        srcPort,
+		Dst:        dstPort,
+		SeqNum:     seq,
+		DataOffset: 5 << 4,
+		Flags:      TCP_RST,
+	}
+	return t.buildTCP4Pkt(&tcp, MAXHOPS, tos, nil)
+}
+
+func (t *Trace) buildTCP4Pkt(tcp *TCPHeader, ttl uint8, tos int, payload []byte) (*ipv4.Header, []byte, error) {
 	iph := &ipv4.Header{
 		Version:  ipv4.Version,
 		TOS:      tos,
 		Len:      ipv4.HeaderLen,
-		TotalLen: 60,
+		TotalLen: ipv4.HeaderLen + TCPHeaderLen + len(payload),
 		ID:       0,
 		Flags:    0,
 		FragOff:  0,
@@ -159,27 +238,10 @@ func (t *Trace) BuildTCP4SYNPkt(srcPort uint16, dstPort uint16, ttl uint8, seq u
 
 	h, err := iph.Marshal()
 	if err != nil {
-		log.Fatal(err)
+		return nil, nil, err
 	}
 	iph.Checksum = int(checkSum(h))
 
-	tcp := TCPHeader{
-		Src:        srcPort,
-		Dst:        dstPort,
-		SeqNum:     seq,
-		AckNum:     0,
-		DataOffset: 160,
-		Flags:      TCP_SYN,
-		Window:     64240,
-		Urgent:     0,
-	}
-
-	//payload is TCP Optionheader
-	payload := []byte{0x02, 0x04, 0x05, 0xb4, 0x04, 0x02, 0x08, 0x0a, 0x7f, 0x73, 0xf9, 0x3a, 0x00, 0x00, 0x00, 0x00, 0x01, 0x03, 0x03, 0x07}
 	tcp.checksum(iph, payload)
-
-	var buf bytes.Buffer
-	binary.Write(&buf, binary.BigEndian, &tcp)
-	binary.Write(&buf, binary.BigEndian, &payload)
-	return iph, buf.Bytes()
+	return iph, packet(tcp.Marshal(), payload), nil
 }
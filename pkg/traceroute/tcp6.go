@@ -5,140 +5,167 @@
 package traceroute
 
 import (
-	"bytes"
-	"encoding/binary"
-	"fmt"
-	"log"
+	"context"
 	"math/rand"
-	"net"
 	"time"
 
 	"golang.org/x/net/ipv6"
 )
 
-func (t *Trace) SendTracesTCP6() {
+// tcp6ChecksumOffset is the offset of the checksum field in the TCP header.
+const tcp6ChecksumOffset = 16
+
+// SendTracesTCP6 sends TCP probes of the kind set by Options.TCPProbe
+// with increasing hop limits to the destination port. The sequence
+// number identifies the probe.
+func (t *Trace) SendTracesTCP6(ctx context.Context) error {
+	defer close(t.SendChan)
+
 	sport := uint16(1000 + t.PortOffset + rand.Int31n(500))
-	fmt.Println(t.SrcIP.String())
-	conn, err := net.ListenPacket("ip6:tcp", t.SrcIP.String())
+	conn, err := t.network().ProbeConn("ip6:tcp")
 	if err != nil {
-		log.Fatal(err)
+		return sockErr("opening probe socket", err)
 	}
-	defer conn.Close()
 
-	rSocket := ipv6.NewPacketConn(conn)
-	rSocket.SetChecksum(true, 0x10)
+	recvICMPConn, err := t.network().ReplyConn("ip6:ipv6-icmp", t.SrcIP)
+	if err != nil {
+		conn.Close()
+		return sockErr("opening ICMPv6 socket", err)
+	}
+	recvTCPConn, err := t.network().ReplyConn("ip6:tcp", t.SrcIP)
+	if err != nil {
+		conn.Close()
+		recvICMPConn.Close()
+		return sockErr("opening TCP socket", err)
+	}
+	t.receive(ctx, recvICMPConn, func() { t.ReceiveTracesTCP6ICMP(ctx, recvICMPConn, sport) })
+	// The TCP receive loop resets half-open connections through conn,
+	// so it outlives the send loop and closes conn when it ends.
+	t.receive(ctx, recvTCPConn, func() {
+		defer conn.Close()
+		t.ReceiveTracesTCP6(ctx, conn, recvTCPConn, sport)
+	})
 
 	seq := uint32(1000)
 	mod := uint32(1 << 30)
-	for ttl := 1; ttl <= int(t.MaxHops); ttl++ {
-		for j := 0; j < t.TracesPerHop; j++ {
-			cm, payload := t.BuildTCP6SYNPkt(sport, t.destPort, uint16(ttl), seq, 0)
-			rSocket.WriteTo(payload, cm, &net.IPAddr{IP: t.DestIP})
+	for ttl := t.Options.FirstTTL; ttl <= t.Options.MaxTTL; ttl++ {
+		for j := 0; j < t.Options.ProbesPerHop; j++ {
+			release, err := t.acquireSlot(ctx)
+			if err != nil {
+				return err
+			}
+			cm, payload := t.BuildTCP6ProbePkt(sport, t.destPort, uint16(ttl), seq, t.Options.TOS(), t.Options.TCPProbe)
 			pb := &Probe{
 				ID:       seq,
 				Dest:     t.DestIP,
+				Port:     t.destPort,
+				SrcPort:  sport,
 				TTL:      ttl,
 				Sendtime: time.Now(),
+				release:  release,
+				TOS:      uint8(t.Options.TOS()),
+			}
+			if err := t.sendProbe(ctx, pb); err != nil {
+				return err
+			}
+			if err := conn.WritePacket(t.packet6(cm, payload, 0), t.DestIP); err != nil {
+				return sockErr("sending probe", err)
 			}
-			t.SendChan <- pb
 			seq = (seq + 4) % mod
-			go t.ReceiveTracesTCP6ICMP()
-			go t.ReceiveTracesTCP6()
-			time.Sleep(time.Microsecond * time.Duration(200000/t.PacketRate))
+			if err := t.pause(ctx, ttl); err != nil {
+				return err
+			}
 		}
 	}
+	return nil
 }
 
-func (t *Trace) ReceiveTracesTCP6() {
-	recvTCPConn, err := net.ListenIP("ip6:tcp", &net.IPAddr{IP: t.SrcIP})
-	if err != nil {
-		log.Fatal("bind TCP failure:", err)
-	}
+// ReceiveTracesTCP6 waits for the destination to answer a probe. Both
+// SYN/ACK (port open) and RST mark the final hop. A
+// SYN/ACK is answered with a RST so that the handshake is never
+// completed.
+func (t *Trace) ReceiveTracesTCP6(ctx context.Context, conn, recvTCPConn ProbeConn, sport uint16) {
+	defer recvTCPConn.Close()
+
 	buf := make([]byte, 1500)
-	n, raddr, err := recvTCPConn.ReadFrom(buf)
-	if err != nil {
-		log.Fatal(err)
-	}
+	for {
+		n, from, ri, err := readReply(recvTCPConn, buf)
+		if err != nil {
+			return
+		}
+		if !from.Equal(t.DestIP) {
+			continue
+		}
+		tcphdr, err := ParseTCP(buf[:n])
+		if err != nil || tcphdr.Src != t.destPort || tcphdr.Dst != sport {
+			continue
+		}
 
-	tcphdr, _ := ParseTCP(buf)
-	if (n >= 20) && (n <= 100) {
-		if (tcphdr.Flags == TCP_ACK+TCP_SYN) && (raddr.String() == t.DestIP.String()) {
-			pb := &Probe{
-				ID:       tcphdr.AckNum - 1,
-				Saddr:    net.ParseIP(raddr.String()),
-				RecvTime: time.Now(),
-			}
-			t.ReceiveChan <- pb
+		switch {
+		case tcphdr.Flags&(TCP_SYN|TCP_ACK) == TCP_SYN|TCP_ACK:
+			cm, payload := t.BuildTCP6RSTPkt(sport, t.destPort, tcphdr.AckNum, t.Options.TOS())
+			conn.WritePacket(&Packet{IPv6: cm, Payload: payload}, t.DestIP)
+		case tcphdr.Flags&TCP_RST != 0:
+		default:
+			continue
+		}
+		pb := &Probe{
+			ID:        tcpAnswerID(tcphdr),
+			Saddr:     from,
+			RecvTime:  ri.At,
+			ReplyTTL:  ri.TTL,
+			QuotedTOS: -1,
+			TCPFlags:  tcphdr.Flags,
+		}
+		if !t.deliver(ctx, pb) {
+			return
 		}
 	}
 }
 
-func (t *Trace) ReceiveTracesTCP6ICMP() {
-	//laddr := &net.IPAddr{IP: t.SrcIP}
-	recvICMPConn, err := net.ListenIP("ip6:ipv6-icmp", &net.IPAddr{IP: t.SrcIP})
-	if err != nil {
-		log.Fatal("bind failure:", err)
-	}
+// ReceiveTracesTCP6ICMP matches ICMPv6 errors quoting one of our TCP
+// probes to the probe by its sequence number.
+func (t *Trace) ReceiveTracesTCP6ICMP(ctx context.Context, recvICMPConn ProbeConn, sport uint16) {
+	defer recvICMPConn.Close()
+
+	buf := make([]byte, 1500)
 	for {
-		buf := make([]byte, 1500)
-		n, raddr, err := recvICMPConn.ReadFrom(buf)
+		n, from, ri, err := readReply(recvICMPConn, buf)
 		if err != nil {
-			break
+			return
 		}
 
-		icmpType := buf[0]
-		if (icmpType == 1 || (icmpType == 3 && buf[1] == 0)) && (n >= 36) { //TTL Exceeded or Port Unreachable
-			ipv6hdr, _ := ipv6.ParseHeader(buf[8:])
-			tcphdr, _ := ParseTCP(buf[8+ipv6.HeaderLen : 48+ipv6.HeaderLen])
-			if ipv6hdr.Dst.Equal(t.DestIP) { // && dstPort == t.dstPort {
-				pb := &Probe{
-					ID:       tcphdr.SeqNum,
-					Saddr:    net.ParseIP(raddr.String()),
-					RecvTime: time.Now(),
-				}
-				t.ReceiveChan <- pb
+		icmpErr, err := ParseICMP6Error(buf[:n])
+		if err != nil {
+			continue
+		}
+		tcphdr, err := ParseTCP(icmpErr.Payload)
+		if err != nil {
+			continue
+		}
+		if icmpErr.dst().Equal(t.DestIP) && tcphdr.Src == sport {
+			pb := &Probe{
+				ID:        tcphdr.SeqNum,
+				Saddr:     from,
+				RecvTime:  ri.At,
+				ReplyTTL:  ri.TTL,
+				MPLS:      MPLSLabels(icmpErr.Extensions),
+				Unreach:   icmpErr.unreach(),
+				FragMTU:   icmpErr.fragMTU(),
+				QuotedTOS: icmpErr.Quoted.TrafficClass,
+				Quoted:    icmpErr.quotedHeader(),
+			}
+			if !t.deliver(ctx, pb) {
+				return
 			}
 		}
 	}
 }
 
-func (t *Trace) IPv6TCPProbe(dport uint16) {
-	seq := uint32(1000)
-	mod := uint32(1 << 30)
-	for i := 0; i < t.MaxHops; i++ {
-		go t.IPv6TCPPing(seq, dport)
-		seq = (seq + 4) % mod
-		time.Sleep(time.Microsecond * time.Duration(200000/t.PacketRate))
-	}
-}
-
-func (t *Trace) IPv6TCPPing(seq uint32, dport uint16) {
-	pbs := &Probe{
-		ID:       seq,
-		Dest:     t.DestIP,
-		TTL:      0,
-		Sendtime: time.Now(),
-	}
-	t.SendChan <- pbs
-
-	conn, err := net.DialTimeout("ip6:tcp", fmt.Sprintf("%s:%d", t.DestIP.String(), dport), time.Second*2)
-	if err != nil {
-		log.Fatal(err)
-	}
-	conn.Close()
-
-	fmt.Println("tcp probe")
-	pbr := &Probe{
-		ID:       seq,
-		Saddr:    t.DestIP,
-		RecvTime: time.Now(),
-	}
-	t.ReceiveChan <- pbr
-}
-
 func (t *Trace) BuildTCP6SYNPkt(sport, dport, ttl uint16, seq uint32, tc int) (*ipv6.ControlMessage, []byte) {
 	cm := &ipv6.ControlMessage{
-		HopLimit: int(ttl),
+		TrafficClass: tc,
+		HopLimit:     int(ttl),
 	}
 
 	tcp := TCPHeader{
@@ -155,9 +182,51 @@ func (t *Trace) BuildTCP6SYNPkt(sport, dport, ttl uint16, seq uint32, tc int) (*
 	//payload is TCP Optionheader
 	payload := []byte{0x02, 0x04, 0x05, 0xb4, 0x04, 0x02, 0x08, 0x0a, 0x7f, 0x73, 0xf9, 0x3a, 0x00, 0x00, 0x00, 0x00, 0x01, 0x03, 0x03, 0x07}
 
-	var ret bytes.Buffer
-	binary.Write(&ret, binary.BigEndian, &tcp)
-	binary.Write(&ret, binary.BigEndian, &payload)
+	return cm, packet(tcp.Marshal(), payload)
+}
+
+// BuildTCP6ProbePkt builds a probe of kind probe with sequence number
+// seq, see BuildTCP4ProbePkt.
+func (t *Trace) BuildTCP6ProbePkt(sport, dport, ttl uint16, seq uint32, tc int, probe TCPProbe) (*ipv6.ControlMessage, []byte) {
+	if probe == TCPProbeSYN {
+		return t.BuildTCP6SYNPkt(sport, dport, ttl, seq, tc)
+	}
+	cm := &ipv6.ControlMessage{
+		TrafficClass: tc,
+		HopLimit:     int(ttl),
+	}
+
+	tcp := TCPHeader{
+		Src:        sport,
+		Dst:        dport,
+		SeqNum:     seq,
+		DataOffset: 5 << 4,
+		Flags:      probe.flags(),
+		Window:     64240,
+	}
+	if probe == TCPProbeACK {
+		tcp.AckNum = seq
+	}
+
+	return cm, tcp.Marshal()
+}
+
+// BuildTCP6RSTPkt builds the RST that tears down the half-open
+// connection left behind by a SYN/ACK. seq is the acknowledgement
+// number of the SYN/ACK.
+func (t *Trace) BuildTCP6RSTPkt(sport, dport uint16, seq uint32, tc int) (*ipv6.ControlMessage, []byte) {
+	cm := &ipv6.ControlMessage{
+		TrafficClass: tc,
+		HopLimit:     MAXHOPS,
+	}
+
+	tcp := TCPHeader{
+		Src:        sport,
+		Dst:        dport,
+		SeqNum:     seq,
+		DataOffset: 5 << 4,
+		Flags:      TCP_RST,
+	}
 
-	return cm, ret.Bytes()
+	return cm, tcp.Marshal()
 }
@@ -0,0 +1,91 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var errTCPProbe = errors.New("unknown kind of TCP probe")
+
+// TCPProbe selects the kind of segment TCP probes are.
+type TCPProbe int
+
+const (
+	// TCPProbeSYN opens a connection. Open ports answer with SYN/ACK,
+	// closed ones with RST.
+	TCPProbeSYN TCPProbe = iota
+	// TCPProbeACK acknowledges data of a connection that does not
+	// exist. Hosts answer with RST whether the port is open or not, but
+	// stateful firewalls drop it.
+	TCPProbeACK
+	// TCPProbeFIN closes a connection that does not exist. Closed ports
+	// answer with RST, open ones stay silent.
+	TCPProbeFIN
+)
+
+func (p TCPProbe) String() string {
+	switch p {
+	case TCPProbeSYN:
+		return "syn"
+	case TCPProbeACK:
+		return "ack"
+	case TCPProbeFIN:
+		return "fin"
+	}
+	return fmt.Sprintf("TCPProbe(%d)", int(p))
+}
+
+// ParseTCPProbe parses the name of a kind of TCP probe, e.g. "ack".
+func ParseTCPProbe(s string) (TCPProbe, error) {
+	for _, p := range []TCPProbe{TCPProbeSYN, TCPProbeACK, TCPProbeFIN} {
+		if strings.EqualFold(s, p.String()) {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %q", errTCPProbe, s)
+}
+
+// flags returns the TCP flags of probes of kind p.
+func (p TCPProbe) flags() uint8 {
+	switch p {
+	case TCPProbeACK:
+		return TCP_ACK
+	case TCPProbeFIN:
+		return TCP_FIN
+	}
+	return TCP_SYN
+}
+
+// tcpAnswerID returns the sequence number of the probe h answers. A
+// segment acknowledging the probe acknowledges its SYN or FIN, which
+// count as one byte. A RST answering an ACK probe carries the
+// acknowledgement number of the probe as its sequence number, RFC 9293,
+// which probes set to their sequence number.
+func tcpAnswerID(h *TCPHeader) uint32 {
+	if h.Flags&TCP_ACK != 0 {
+		return h.AckNum - 1
+	}
+	return h.SeqNum
+}
+
+// TCPFlagsString formats the flags of a TCP segment, e.g. "SYN,ACK".
+func TCPFlagsString(flags uint8) string {
+	var names []string
+	for _, f := range []struct {
+		flag uint8
+		name string
+	}{
+		{TCP_SYN, "SYN"}, {TCP_FIN, "FIN"}, {TCP_RST, "RST"},
+		{TCP_PSH, "PSH"}, {TCP_ACK, "ACK"}, {TCP_URG, "URG"},
+	} {
+		if flags&f.flag != 0 {
+			names = append(names, f.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
@@ -0,0 +1,121 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseTCPProbe(t *testing.T) {
+	for _, tt := range []struct {
+		s    string
+		want TCPProbe
+		err  error
+	}{
+		{s: "syn", want: TCPProbeSYN},
+		{s: "ACK", want: TCPProbeACK},
+		{s: "fin", want: TCPProbeFIN},
+		{s: "xmas", err: errTCPProbe},
+	} {
+		got, err := ParseTCPProbe(tt.s)
+		if got != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("ParseTCPProbe(%q) = %v, %v, want %v, %v", tt.s, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestBuildTCP4ProbePkt(t *testing.T) {
+	tr := &Trace{
+		DestIP: net.IPv4(192, 0, 2, 1).To4(),
+		SrcIP:  net.IPv4(192, 0, 2, 2).To4(),
+	}
+	for _, tt := range []struct {
+		probe  TCPProbe
+		flags  uint8
+		ackNum uint32
+	}{
+		{probe: TCPProbeSYN, flags: TCP_SYN},
+		{probe: TCPProbeACK, flags: TCP_ACK, ackNum: 4711},
+		{probe: TCPProbeFIN, flags: TCP_FIN},
+	} {
+		t.Run(tt.probe.String(), func(t *testing.T) {
+			_, pkt, err := tr.BuildTCP4ProbePkt(1234, 80, 1, 4711, 0, tt.probe)
+			if err != nil {
+				t.Fatalf("BuildTCP4ProbePkt() = %v", err)
+			}
+			h, err := ParseTCP(pkt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h.Flags != tt.flags || h.SeqNum != 4711 || h.AckNum != tt.ackNum {
+				t.Errorf("flags %s, seq %d, ack %d, want %s, 4711, %d",
+					TCPFlagsString(h.Flags), h.SeqNum, h.AckNum, TCPFlagsString(tt.flags), tt.ackNum)
+			}
+		})
+	}
+}
+
+func TestTCPFlagsString(t *testing.T) {
+	for flags, want := range map[uint8]string{
+		TCP_SYN | TCP_ACK: "SYN,ACK",
+		TCP_RST:           "RST",
+		TCP_RST | TCP_ACK: "RST,ACK",
+		0:                 "",
+	} {
+		if got := TCPFlagsString(flags); got != want {
+			t.Errorf("TCPFlagsString(%#x) = %q, want %q", flags, got, want)
+		}
+	}
+}
+
+func TestTCPProbesFakeNetwork(t *testing.T) {
+	src := net.IPv4(192, 0, 2, 100)
+	dest := net.IPv4(198, 51, 100, 1)
+	router := net.IPv4(192, 0, 2, 1)
+
+	for _, tt := range []struct {
+		probe TCPProbe
+		flags uint8
+	}{
+		{probe: TCPProbeSYN, flags: TCP_RST | TCP_ACK},
+		{probe: TCPProbeACK, flags: TCP_RST},
+		{probe: TCPProbeFIN, flags: TCP_RST | TCP_ACK},
+	} {
+		t.Run(tt.probe.String(), func(t *testing.T) {
+			cc := Coms{
+				SendChan: make(chan *Probe),
+				RecvChan: make(chan *Probe),
+			}
+			tr := NewTrace("tcp4", dest, src, cc, &Flags{TracerouteOptions: TracerouteOptions{
+				MaxTTL:       5,
+				ProbesPerHop: 1,
+				Interval:     time.Millisecond,
+				Timeout:      time.Second,
+				TCPProbe:     tt.probe,
+			}})
+			tr.Network = newFakeNetwork(dest.To4(), router)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go tr.SendTracesTCP4(ctx)
+			printMap := runTransmission(ctx, cc, 1, tr.Options.Timeout, nil)
+
+			if got := DestTTL(printMap); got != 2 {
+				t.Fatalf("DestTTL() = %d, want 2", got)
+			}
+			if pbs := GetProbesByTLL(printMap, 1); len(pbs) != 1 || pbs[0].TCPFlags != 0 {
+				t.Errorf("TTL 1 answers = %v, want one ICMP error", pbs)
+			}
+			pbs := GetProbesByTLL(printMap, 2)
+			if len(pbs) != 1 || pbs[0].TCPFlags != tt.flags {
+				t.Fatalf("TTL 2 answers = %v, want one %s", pbs, TCPFlagsString(tt.flags))
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import "fmt"
+
+// ECN codepoints, RFC 3168.
+const (
+	ECNNotECT = 0
+	ECNECT1   = 1
+	ECNECT0   = 2
+	ECNCE     = 3
+)
+
+// Largest values of the DSCP and ECN fields.
+const (
+	MAXDSCP = 63
+	MAXECN  = 3
+)
+
+// TOS returns the IPv4 TOS or IPv6 traffic class byte probes are sent
+// with.
+func (o TracerouteOptions) TOS() int {
+	return int(o.DSCP)<<2 | int(o.ECN&MAXECN)
+}
+
+// TOSChange describes how the TOS byte a probe was sent with differs
+// from the one quoted in the ICMP error it caused, e.g. "DSCP bleached"
+// or "ECN CE". It returns "" if the fields are unchanged or nothing was
+// quoted, i.e. quoted is negative.
+func TOSChange(sent uint8, quoted int) string {
+	if quoted < 0 {
+		return ""
+	}
+	q := uint8(quoted)
+	var change string
+	sd, qd := sent>>2, q>>2
+	switch {
+	case sd == qd:
+	case qd == 0:
+		change = "DSCP bleached"
+	default:
+		change = fmt.Sprintf("DSCP %d->%d", sd, qd)
+	}
+
+	se, qe := sent&MAXECN, q&MAXECN
+	var ecn string
+	switch {
+	case se == qe:
+	case qe == ECNCE:
+		ecn = "ECN CE"
+	case qe == ECNNotECT:
+		ecn = "ECN bleached"
+	default:
+		ecn = fmt.Sprintf("ECN %d->%d", se, qe)
+	}
+	if change != "" && ecn != "" {
+		return change + ", " + ecn
+	}
+	return change + ecn
+}
@@ -4,64 +4,180 @@
 
 package traceroute
 
-import "net"
+import (
+	"context"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
 
 type Trace struct {
 	DestIP   net.IP
 	destPort uint16
 	SrcIP    net.IP
 	//srcPort     uint16
-	PortOffset   int32
-	MaxHops      int
-	SendChan     chan<- *Probe
-	ReceiveChan  chan<- *Probe
-	TracesPerHop int
-	PacketRate   int
+	PortOffset  int32
+	SendChan    chan<- *Probe
+	ReceiveChan chan<- *Probe
+	Options     TracerouteOptions
+	Strategy    ProbeStrategy
+	// Flows is the number of flows to enumerate load balanced paths
+	// with. Values below 2 trace a single path.
+	Flows int
+	// slots limits the number of outstanding probes if
+	// Options.SimultaneousProbes is set.
+	slots chan struct{}
+	// icmpID is the ICMP echo identifier shared by all echo probes of
+	// this trace, so that replies to other processes can be told apart.
+	icmpID uint16
+	// Interface, if set, is the network interface probes are sent on.
+	Interface string
+	// Network opens the connections probes are sent and answers read
+	// on. Nil uses raw sockets.
+	Network Network
+	// Capture, if set, records the probes sent and messages received.
+	Capture *PcapWriter
+	// portSeq is the number of probes classic UDP traces have sent, the
+	// offset of the destination port of the next one.
+	portSeq uint16
+	// limiter slows down the probes to rate-limiting routers.
+	limiter *rateLimiter
+	// receivers tracks the receive loops started by the senders.
+	receivers sync.WaitGroup
 }
 
 func NewTrace(proto string, dAddr net.IP, sAddr net.IP, cc Coms, f *Flags) *Trace {
 	var ret *Trace
 	var destAddr, srcAddr net.IP
-	var dPort uint16
 
 	switch proto {
-	case "udp4":
+	case "udp4", "tcp4", "icmp4", "sctp4":
 		destAddr = dAddr.To4()
 		srcAddr = sAddr.To4()
-		dPort = 33434
-	case "udp6":
+	case "udp6", "tcp6", "icmp6", "sctp6":
 		destAddr = dAddr.To16()
 		srcAddr = sAddr.To16()
-		dPort = 33434
-	case "tcp4":
-		destAddr = dAddr.To4()
-		srcAddr = sAddr.To4()
-		dPort = 443
-	case "tcp6":
-		destAddr = dAddr.To16()
-		srcAddr = sAddr.To16()
-		dPort = 443
-	case "icmp4":
-		destAddr = dAddr.To4()
-		srcAddr = sAddr.To4()
-		dPort = 0
-	case "icmp6":
-		destAddr = dAddr.To16()
-		srcAddr = sAddr.To16()
-		dPort = 0
 	}
 
+	var strategy ProbeStrategy
+	var flows int
+	var opts TracerouteOptions
+	var iface string
+	if f != nil {
+		strategy = f.Strategy
+		flows = f.Flows
+		opts = f.TracerouteOptions
+		iface = f.Interface
+	}
+	// Every flow probes each TTL, so one probe per flow is enough.
+	if flows > 1 {
+		strategy = StrategyParis
+		if opts.ProbesPerHop == 0 {
+			opts.ProbesPerHop = 1
+		}
+	}
+	opts = opts.withDefaults(proto)
+
 	ret = &Trace{
-		DestIP:       destAddr,
-		destPort:     dPort,
-		SrcIP:        srcAddr,
-		PortOffset:   0,
-		MaxHops:      DEFNUMHOPS,
-		SendChan:     cc.SendChan,
-		ReceiveChan:  cc.RecvChan,
-		TracesPerHop: DEFNUMTRACES,
-		PacketRate:   1,
+		DestIP:      destAddr,
+		destPort:    opts.DestPort,
+		SrcIP:       srcAddr,
+		PortOffset:  0,
+		SendChan:    cc.SendChan,
+		ReceiveChan: cc.RecvChan,
+		Options:     opts,
+		Strategy:    strategy,
+		Flows:       flows,
+		icmpID:      uint16(os.Getpid() & 0xffff),
+		Interface:   iface,
+		limiter:     &rateLimiter{},
+	}
+	if opts.SimultaneousProbes > 0 {
+		ret.slots = make(chan struct{}, opts.SimultaneousProbes)
 	}
 
 	return ret
 }
+
+// acquireSlot blocks until another probe may be sent or ctx is done.
+// It returns the function that frees the slot again once the probe is
+// answered, or nil if probes are not sent simultaneously. The slot is
+// freed after Options.Timeout in any case.
+func (t *Trace) acquireSlot(ctx context.Context) (func(), error) {
+	if t.slots == nil {
+		return nil, ctx.Err()
+	}
+	select {
+	case t.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	var once sync.Once
+	release := func() {
+		once.Do(func() { <-t.slots })
+	}
+	time.AfterFunc(t.Options.Timeout, release)
+	return release, nil
+}
+
+// pause waits after sending a probe with ttl. Probes sent one after the
+// other are spaced by Options.Interval, those to rate-limiting routers
+// by the backoff of their TTL.
+func (t *Trace) pause(ctx context.Context, ttl int) error {
+	d := t.limiter.interval(ttl)
+	if t.slots == nil {
+		d = max(d, t.Options.Interval)
+	}
+	if d == 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// sendProbe hands a probe that is about to be sent to the collector.
+func (t *Trace) sendProbe(ctx context.Context, pb *Probe) error {
+	pb.Src = t.SrcIP
+	select {
+	case t.SendChan <- pb:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// deliver hands an answer to the collector. It returns false once ctx
+// is done and the receive loop should stop.
+func (t *Trace) deliver(ctx context.Context, pb *Probe) bool {
+	select {
+	case t.ReceiveChan <- pb:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// receive runs the receive loop reading from conn in its own goroutine.
+// conn is closed once ctx is done, which ends the loop.
+func (t *Trace) receive(ctx context.Context, conn ProbeConn, loop func()) {
+	t.receivers.Add(1)
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	go func() {
+		defer t.receivers.Done()
+		defer stop()
+		loop()
+	}()
+}
+
+// Wait waits for the receive loops of the trace to end after the
+// context the trace was sent with is done.
+func (t *Trace) Wait() {
+	t.receivers.Wait()
+}
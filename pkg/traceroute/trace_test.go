@@ -0,0 +1,183 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAcquireSlot(t *testing.T) {
+	cc := Coms{
+		SendChan: make(chan *Probe),
+		RecvChan: make(chan *Probe),
+	}
+	ip := net.IPv4(127, 0, 0, 1)
+
+	ctx := context.Background()
+	seq := NewTrace("udp4", ip, ip, cc, &Flags{})
+	if release, _ := seq.acquireSlot(ctx); release != nil {
+		t.Errorf("sequential trace has send slots")
+	}
+
+	tr := NewTrace("udp4", ip, ip, cc, &Flags{TracerouteOptions: TracerouteOptions{
+		SimultaneousProbes: 2,
+		Timeout:            50 * time.Millisecond,
+	}})
+
+	r1, _ := tr.acquireSlot(ctx)
+	tr.acquireSlot(ctx)
+
+	acquired := make(chan struct{})
+	go func() {
+		tr.acquireSlot(ctx)
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatalf("third probe sent with two slots taken")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// Answering a probe frees its slot, more than once is harmless.
+	r1()
+	r1()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatalf("slot not freed by answer")
+	}
+
+	// The remaining slots time out.
+	done := make(chan struct{})
+	go func() {
+		tr.acquireSlot(ctx)
+		tr.acquireSlot(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("slots not freed by timeout")
+	}
+}
+
+func TestAcquireSlotCanceled(t *testing.T) {
+	cc := Coms{
+		SendChan: make(chan *Probe),
+		RecvChan: make(chan *Probe),
+	}
+	ip := net.IPv4(127, 0, 0, 1)
+	tr := NewTrace("udp4", ip, ip, cc, &Flags{TracerouteOptions: TracerouteOptions{
+		SimultaneousProbes: 1,
+		Timeout:            time.Hour,
+	}})
+	ctx, cancel := context.WithCancel(context.Background())
+	if _, err := tr.acquireSlot(ctx); err != nil {
+		t.Fatalf("acquireSlot() = %v", err)
+	}
+	cancel()
+	if _, err := tr.acquireSlot(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("acquireSlot() = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestProbeDstPort(t *testing.T) {
+	ip := net.IPv4(192, 0, 2, 1)
+	for _, tt := range []struct {
+		name string
+		f    *Flags
+		want []uint16
+	}{
+		{name: "Classic", f: &Flags{}, want: []uint16{33434, 33435, 33436}},
+		{name: "FixedPort", f: &Flags{TracerouteOptions: TracerouteOptions{FixedPort: true}}, want: []uint16{33434, 33434, 33434}},
+		{name: "Paris", f: &Flags{Strategy: StrategyParis, TracerouteOptions: TracerouteOptions{DestPort: 53}}, want: []uint16{53, 53, 53}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := NewTrace("udp4", ip, ip, Coms{}, tt.f)
+			for i, want := range tt.want {
+				if got := tr.probeDstPort(0); got != want {
+					t.Errorf("probe %d: port %d, want %d", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestChecksumProbeID(t *testing.T) {
+	for _, strategy := range []ProbeStrategy{StrategyClassic, StrategyParis} {
+		t.Run(strategy.String(), func(t *testing.T) {
+			tr := &Trace{
+				DestIP:   net.IPv4(192, 0, 2, 1).To4(),
+				SrcIP:    net.IPv4(192, 0, 2, 2).To4(),
+				Strategy: strategy,
+				Options:  TracerouteOptions{PacketLen: 37},
+			}
+			sums := map[uint16]bool{}
+			for _, id := range []uint16{0, 1, 7, 1<<15 - 1} {
+				iph, udp, err := tr.BuildUDP4Pkt(1234, 33434, 1, id, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if checkSum(packet(pseudoHeader4(iph, 17, len(udp)), udp)) != 0xffff {
+					t.Errorf("ID %d: UDP checksum does not verify", id)
+				}
+				sums[binary.BigEndian.Uint16(udp[6:8])] = true
+				got, ok := checksumProbeID(udp)
+				if strategy == StrategyClassic && (!ok || got != id) {
+					t.Errorf("checksumProbeID() = %d, %t, want %d", got, ok, id)
+				}
+			}
+			// Paris probes keep the checksum constant.
+			if got := len(sums) == 1; got != (strategy == StrategyParis) {
+				t.Errorf("%d distinct checksums", len(sums))
+			}
+		})
+	}
+	if _, ok := checksumProbeID([]byte{0, 1, 0, 2, 0, 8, 0, 0}); ok {
+		t.Errorf("checksumProbeID() decoded an ID from no checksum")
+	}
+}
+
+func TestChecksumIDFakeNetwork(t *testing.T) {
+	src := net.IPv4(192, 0, 2, 100)
+	dest := net.IPv4(198, 51, 100, 1)
+	routers := []net.IP{net.IPv4(192, 0, 2, 1), net.IPv4(203, 0, 113, 1)}
+
+	cc := Coms{
+		SendChan: make(chan *Probe),
+		RecvChan: make(chan *Probe),
+	}
+	tr := NewTrace("udp4", dest, src, cc, &Flags{TracerouteOptions: TracerouteOptions{
+		MaxTTL:       5,
+		ProbesPerHop: 2,
+		Interval:     time.Millisecond,
+		Timeout:      time.Second,
+	}})
+	n := newFakeNetwork(dest.To4(), routers...)
+	n.ipID = 0x4242
+	tr.Network = n
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go tr.SendTracesUDP4(ctx)
+	printMap := runTransmission(ctx, cc, 1, tr.Options.Timeout, nil)
+
+	// The IP IDs quoted are all the same, the checksums tell the probes
+	// apart.
+	for ttl, want := range map[int]net.IP{1: routers[0], 2: routers[1]} {
+		pbs := GetProbesByTLL(printMap, ttl)
+		if len(pbs) != 2 || !pbs[0].Saddr.Equal(want) || !pbs[1].Saddr.Equal(want) {
+			t.Errorf("TTL %d: answers %v, want two from %v", ttl, pbs, want)
+		}
+	}
+	if pbs := GetProbesByTLL(printMap, 3); len(pbs) == 0 || !pbs[0].Saddr.Equal(dest) {
+		t.Errorf("TTL 3: answers %v, want the destination", pbs)
+	}
+}
@@ -5,11 +5,23 @@
 package traceroute
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"os"
+	"strings"
 	"time"
 )
 
+var (
+	errMultipathProto = errors.New("multipath enumeration requires UDP probes")
+	errOutputFormat   = errors.New("unknown output format")
+	errSourceAddr     = errors.New("invalid source address")
+	errProto          = errors.New("unknown probe protocol")
+)
+
 type Probe struct {
 	ID       uint32
 	Sendtime time.Time
@@ -19,90 +31,418 @@ type Probe struct {
 	TTL      int
 	Saddr    net.IP
 	Done     bool
+	// Flow is the flow the probe belongs to in multipath traces.
+	Flow int
+	// MPLS is the label stack the answering router quoted, RFC 4950.
+	MPLS []MPLSLabel
+	// ASN is the origin AS of Saddr, zero if unknown or not looked up.
+	ASN uint32
+	// Name is the reverse DNS name of Saddr, if any.
+	Name string
+	// Geo is the location of Saddr, zero if unknown or not looked up.
+	Geo Geo
+	// TOS is the TOS or traffic class byte the probe was sent with.
+	TOS uint8
+	// QuotedTOS is the TOS or traffic class byte of the probe as quoted
+	// in the ICMP error it caused, -1 if the answer quoted none.
+	QuotedTOS int
+	// TCPFlags are the flags of the TCP segment the destination answered
+	// the probe with, zero for other answers.
+	TCPFlags uint8
+	// Recorded is the IPv4 option data quoted in the ICMP error that
+	// answered the probe, nil without any.
+	Recorded *RecordedRoute
+	// Unreach is the code of the Destination Unreachable message that
+	// answered the probe, nil for any other answer.
+	Unreach *uint8
+	// FragMTU is the next-hop MTU of the Fragmentation Needed or Packet
+	// Too Big message that answered the probe, nil for any other answer.
+	FragMTU *int
+	// ReplyTTL is the TTL or hop limit the answer arrived with, zero if
+	// unknown.
+	ReplyTTL int
+	// Src and SrcPort are the source address and port the probe was
+	// sent with. ICMP probes have no port.
+	Src     net.IP
+	SrcPort uint16
+	// Quoted are the addresses and ports of the probe as quoted in the
+	// ICMP error that answered it, nil for any other answer.
+	Quoted *QuotedHeader
+	// release frees the send slot of a simultaneous probe.
+	release func()
 }
 
+// RunTraceroute runs the trace described by f to completion.
 func RunTraceroute(f *Flags) error {
-	dAddr, err := DestAddr(f.Host, f.Proto)
+	return RunTracerouteContext(context.Background(), f)
+}
+
+// RunTracerouteContext runs the trace described by f and prints its
+// result with f.Writer, or the writer of f.Output on stdout if that is
+// nil. Text, summary, CSV and NDJSON output print every hop as soon as
+// it is complete. Cancelling ctx stops the trace, closes its sockets
+// and prints the hops found so far before returning the error of ctx.
+func RunTracerouteContext(ctx context.Context, f *Flags) (err error) {
+	t, err := newTracer(f)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		if cerr := t.close(); err == nil {
+			err = cerr
+		}
+	}()
+	if f.MTU {
+		return runPathMTU(ctx, f, t.dest, t.mod)
+	}
+	if f.Continuous {
+		r, err := Monitor(ctx, f, nil)
+		if r != nil {
+			if werr := r.WriteText(os.Stdout); werr != nil {
+				return werr
+			}
+		}
+		return err
+	}
 
-	sAddr, err := SrcAddr(f.Proto)
+	w := f.Writer
+	if w == nil {
+		// newTracer made sure the format exists.
+		w, _ = NewOutputWriter(f.Output, os.Stdout)
+	}
+	if err := w.WriteHeader(Header{
+		Host:    f.Host,
+		Dest:    t.dest,
+		Proto:   t.f.Proto,
+		MaxHops: t.mod.Options.MaxTTL,
+		Flows:   t.mod.numFlows(),
+	}); err != nil {
+		return err
+	}
+	var werr error
+	printMap, sent, err := t.run(ctx, func(h Hop) {
+		if werr == nil {
+			werr = w.WriteHop(h)
+		}
+	})
 	if err != nil {
 		return err
 	}
+	if werr != nil {
+		return werr
+	}
+	if err := w.WriteResult(t.result(printMap, sent), BuildTopology(printMap, t.mod.numFlows())); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// tracer is a trace set up from Flags, ready to run.
+type tracer struct {
+	f    *Flags
+	dest net.IP
+	cc   Coms
+	mod  *Trace
+	// asn looks up the origin AS of hops, nil if AS lookups are off.
+	asn ASNResolver
+	// geo looks up the location of hops, nil without a GeoIP database.
+	geo GeoResolver
+	// capture records the traffic of the trace if Flags.Pcap is set,
+	// closeCapture finishes its file.
+	capture      *PcapWriter
+	closeCapture func() error
+	// limited holds the TTLs of the last run that seemed to rate-limit
+	// their answers.
+	limited map[int]bool
+}
+
+func newTracer(f *Flags) (*tracer, error) {
+	timeout := f.LookupTimeout
+	if timeout == 0 {
+		timeout = DEFLOOKUPSEC * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	dAddr, err := ResolveDest(ctx, NewResolver(f.DNSServer), f.Host, f.Proto, f.AnyFamily)
+	if err != nil {
+		return nil, err
+	}
+	if (dAddr.To4() == nil) != strings.HasSuffix(f.Proto, "6") {
+		fc := *f
+		fc.Proto = otherFamily(f.Proto)
+		f = &fc
+	}
+
+	if f.Flows > 1 && !strings.HasPrefix(f.Proto, "udp") {
+		return nil, errMultipathProto
+	}
+
+	if f.MTU && !strings.HasPrefix(f.Proto, "udp") {
+		return nil, errMTUProto
+	}
+
+	if err := checkGateways(f.Gateways, f.Proto); err != nil {
+		return nil, err
+	}
+
+	if f.Writer == nil {
+		if _, err := NewOutputWriter(f.Output, io.Discard); err != nil {
+			return nil, err
+		}
+	}
+
+	asnResolver, err := newASNResolver(f)
+	if err != nil {
+		return nil, err
+	}
+
+	var geo GeoResolver
+	if f.GeoIPDB != "" {
+		db, err := LoadMMDB(f.GeoIPDB)
+		if err != nil {
+			return nil, err
+		}
+		geo = db
+	}
+
+	sAddr, err := SourceAddr(f.Proto, f.Source, f.Interface)
+	if err != nil {
+		return nil, err
+	}
 
 	cc := Coms{
 		SendChan: make(chan *Probe),
 		RecvChan: make(chan *Probe),
 	}
 
-	mod := NewTrace(f.Proto, dAddr, *sAddr, cc, f)
+	t := &tracer{
+		f:    f,
+		dest: dAddr,
+		cc:   cc,
+		mod:  NewTrace(f.Proto, dAddr, sAddr, cc, f),
+		asn:  asnResolver,
+		geo:  geo,
+	}
+	if f.Pcap != "" {
+		if err := t.openCapture(f.Pcap); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
 
-	switch f.Proto {
+// run sends the probes and collects the answers, together with the
+// number of probes per TTL that were answered or timed out. Unless fn is
+// nil, it is called with every hop as soon as it is complete.
+func (t *tracer) run(ctx context.Context, fn HopFunc) (map[int]*Probe, map[int]int, error) {
+	var send func(context.Context) error
+	switch t.f.Proto {
 	case "udp4":
-		go mod.SendTracesUDP4()
+		send = t.mod.SendTracesUDP4
 	case "tcp4":
-		go mod.SendTracesTCP4()
+		send = t.mod.SendTracesTCP4
 	case "icmp4":
-		go mod.SendTracesICMP4()
+		send = t.mod.SendTracesICMP4
+	case "sctp4":
+		send = t.mod.SendTracesSCTP4
 	case "udp6":
-		go mod.SendTracesUDP6()
+		send = t.mod.SendTracesUDP6
 	case "tcp6":
-		go mod.SendTracesTCP6()
+		send = t.mod.SendTracesTCP6
 	case "icmp6":
-		go mod.SendTracesICMP6()
+		send = t.mod.SendTracesICMP6
+	case "sctp6":
+		send = t.mod.SendTracesSCTP6
+	default:
+		return nil, nil, fmt.Errorf("%w: %q", errProto, t.f.Proto)
 	}
 
-	printMap := runTransmission(cc)
-
-	destTTL := DestTTL(printMap)
-	fmt.Printf("traceroute to %s (%s), %d hops max, %d byte packets\n",
-		f.Host,
-		dAddr.String(),
-		mod.MaxHops,
-		60)
-
-	for i := 1; i <= destTTL; i++ {
-		pbs := GetProbesByTLL(printMap, i)
-		if len(pbs) == 0 {
-			continue
+	sent := map[int]int{}
+	t.limited = map[int]bool{}
+	var nat natTracker
+	hops := newHopStream(t.mod, func(ttl, n int, answered []*Probe, limited bool) {
+		sent[ttl] = n
+		if limited {
+			t.limited[ttl] = true
+			t.mod.limiter.backoff(ttl)
 		}
-		fmt.Printf("TTL: %-5d", i)
-		for _, pb := range pbs {
-			fmt.Printf("%-20s (%-7.3fms) ", pb.Saddr, float64(pb.RecvTime.Sub(pb.Sendtime)/time.Microsecond)/1000)
+		if fn == nil {
+			return
 		}
-		fmt.Printf("\n")
+		hopMap := make(map[int]*Probe, len(answered))
+		for _, pb := range answered {
+			hopMap[int(pb.ID)] = pb
+		}
+		t.annotate(ctx, hopMap)
+		hop := newHop(ttl, n, answered)
+		hop.RateLimited = limited
+		nat.mark(&hop)
+		fn(hop)
+	})
+
+	// Once the answers are in, the sender may still be sending probes to
+	// TTLs beyond the destination. Cancelling traceCtx stops it and the
+	// receive loops.
+	traceCtx, cancel := context.WithCancel(ctx)
+	errc := make(chan error, 1)
+	go func() { errc <- send(traceCtx) }()
+
+	printMap := runTransmission(traceCtx, t.cc, t.mod.numFlows(), t.mod.Options.Timeout, hops)
+	cancel()
+	err := <-errc
+	t.mod.Wait()
+	if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+		return nil, nil, err
 	}
 
-	return nil
+	hops.flush(DestTTL(printMap))
+	if fn == nil {
+		t.annotate(ctx, printMap)
+	}
+	return printMap, sent, nil
+}
+
+// annotate adds the AS, location and names selected by the flags to the
+// answered probes. Lookups of a cancelled trace fail right away, its hops
+// stay numeric.
+func (t *tracer) annotate(ctx context.Context, printMap map[int]*Probe) {
+	if t.asn != nil && ctx.Err() == nil {
+		annotateASN(printMap, t.asn)
+	}
+	if t.geo != nil {
+		annotateGeo(printMap, t.geo)
+	}
+	if !t.f.Numeric {
+		annotateNames(ctx, printMap, NewResolver(t.f.DNSServer), t.f.LookupTimeout)
+	}
 }
 
-func runTransmission(cc Coms) map[int]*Probe {
+// result builds the result of the trace, counting the probes sent per
+// TTL as in sent.
+func (t *tracer) result(printMap map[int]*Probe, sent map[int]int) *Result {
+	r := NewResult(t.f.Host, t.dest, t.f.Proto, t.mod.Options, t.mod.numFlows(), printMap)
+	for i := range r.Hops {
+		r.Hops[i].setSent(sent[r.Hops[i].TTL])
+		r.Hops[i].RateLimited = t.limited[r.Hops[i].TTL]
+	}
+	return r
+}
+
+// runTransmission matches received probes to sent ones. Answers arriving
+// later than timeout after their probe was sent are ignored. It returns
+// once every flow has reached the destination, or once all probes have
+// been sent and no more answers came in for timeout, or once ctx is done.
+// Complete hops are reported to hops unless it is nil; the hops left once
+// runTransmission returns are up to the caller to flush.
+func runTransmission(ctx context.Context, cc Coms, flows int, timeout time.Duration, hops *hopStream) map[int]*Probe {
 	sendProbes := make([]*Probe, 0)
 	printMap := map[int]*Probe{}
+	arrived := map[int]bool{}
+	sendChan := cc.SendChan
+	var wait, hopTimeout <-chan time.Time
+	advance := func() {
+		if hops == nil {
+			return
+		}
+		hopTimeout = nil
+		if d := hops.advance(time.Now()); !d.IsZero() {
+			hopTimeout = time.After(time.Until(d))
+		}
+	}
 	for {
-		var p *Probe
 		select {
-		case p = <-cc.SendChan:
+		case p, ok := <-sendChan:
+			if !ok {
+				// Nothing was sent if sending failed right away.
+				if len(sendProbes) == 0 {
+					return printMap
+				}
+				sendChan = nil
+				wait = time.After(timeout)
+				if hops != nil {
+					hops.sendDone = true
+				}
+				advance()
+				continue
+			}
 			sendProbes = append(sendProbes, p)
-			//fmt.Println(p.id)
-		case p = <-cc.RecvChan:
-			//fmt.Println(p.id)
+			if hops != nil {
+				hops.add(p)
+			}
+			advance()
+		case p := <-cc.RecvChan:
 			for i, sp := range sendProbes {
-				if sp.ID == p.ID {
+				if sp.ID == p.ID && p.RecvTime.Sub(sp.Sendtime) <= timeout {
+					if sp.release != nil {
+						sp.release()
+					}
 					sendProbes[i].RecvTime = p.RecvTime
 					sendProbes[i].Saddr = p.Saddr
+					sendProbes[i].MPLS = p.MPLS
+					sendProbes[i].QuotedTOS = p.QuotedTOS
+					sendProbes[i].Unreach = p.Unreach
+					sendProbes[i].FragMTU = p.FragMTU
+					sendProbes[i].ReplyTTL = p.ReplyTTL
+					sendProbes[i].Quoted = p.Quoted
+					sendProbes[i].TCPFlags = p.TCPFlags
+					sendProbes[i].Recorded = p.Recorded
 					sendProbes[i].Done = true
 					// Add to map
 					printMap[int(sp.ID)] = sendProbes[i]
 					if p.Saddr.Equal(sp.Dest) {
-						return printMap
+						arrived[sp.Flow] = true
+						if len(arrived) >= flows {
+							return printMap
+						}
 					}
 				}
 			}
-		default:
+			if sendChan == nil {
+				wait = time.After(timeout)
+			}
+			advance()
+		case <-hopTimeout:
+			advance()
+		case <-wait:
+			return printMap
+		case <-ctx.Done():
+			return printMap
+		}
+	}
+}
+
+// runPathMTU discovers and prints the path MTU to dAddr.
+func runPathMTU(ctx context.Context, f *Flags, dAddr net.IP, mod *Trace) error {
+	fmt.Printf("tracepath to %s (%s), %d hops max\n", f.Host, dAddr.String(), mod.Options.MaxTTL)
+	hops, err := mod.DiscoverPathMTU(ctx)
+	r := NewResolver(f.DNSServer)
+	timeout := f.LookupTimeout
+	if timeout == 0 {
+		timeout = DEFLOOKUPSEC * time.Second
+	}
+	for _, hop := range hops {
+		fmt.Printf("TTL: %-5d", hop.TTL)
+		if hop.Addr == nil {
+			fmt.Printf("no reply\n")
 			continue
 		}
+		addr := hop.Addr.String()
+		if !f.Numeric {
+			if name := lookupName(ctx, r, hop.Addr, timeout); name != "" {
+				addr = fmt.Sprintf("%s (%s)", name, addr)
+			}
+		}
+		fmt.Printf("%-20s (%-7.3fms) pmtu %d", addr, float64(hop.RTT/time.Microsecond)/1000, hop.PMTU)
+		if hop.Reached {
+			fmt.Printf(" reached")
+		}
+		fmt.Printf("\n")
 	}
+	if err != nil {
+		return err
+	}
+	if len(hops) > 0 {
+		fmt.Printf("path MTU %d\n", hops[len(hops)-1].PMTU)
+	}
+	return nil
 }
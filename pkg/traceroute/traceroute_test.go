@@ -7,10 +7,16 @@ package traceroute_test
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/json"
 	"net"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/u-root/u-root/pkg/traceroute"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
 )
 
 func TestUDP4Packet(t *testing.T) {
@@ -19,7 +25,9 @@ func TestUDP4Packet(t *testing.T) {
 		SrcIP:  net.IPv4(127, 0, 0, 1),
 	}
 
-	_, _ = tr.BuildUDP4Pkt(0, 0, 1, 0, 0)
+	if _, _, err := tr.BuildUDP4Pkt(0, 0, 1, 0, 0); err != nil {
+		t.Errorf("BuildUDP4Pkt() = %v", err)
+	}
 }
 
 func TestUDP6Packet(t *testing.T) {
@@ -37,7 +45,9 @@ func TestTCP4Packet(t *testing.T) {
 		SrcIP:  net.IPv4(127, 0, 0, 1),
 	}
 
-	_, _ = tr.BuildTCP4SYNPkt(0, 0, 1, 0, 0)
+	if _, _, err := tr.BuildTCP4SYNPkt(0, 0, 1, 0, 0); err != nil {
+		t.Errorf("BuildTCP4SYNPkt() = %v", err)
+	}
 }
 
 func TestTCP6Packet(t *testing.T) {
@@ -55,7 +65,9 @@ func TestICMP4Packet(t *testing.T) {
 		SrcIP:  net.IPv4(127, 0, 0, 1),
 	}
 
-	_, _ = tr.BuildICMP4Pkt(1, 0, 0, 0)
+	if _, _, err := tr.BuildICMP4Pkt(1, 0, 0, 0); err != nil {
+		t.Errorf("BuildICMP4Pkt() = %v", err)
+	}
 }
 
 func TestICMP6Packet(t *testing.T) {
@@ -130,11 +142,7 @@ func TestParseTCP(t *testing.T) {
 		Urgent:     0,
 	}
 
-	var data bytes.Buffer
-
-	binary.Write(&data, binary.BigEndian, hdr)
-
-	newhdr, err := traceroute.ParseTCP(data.Bytes())
+	newhdr, err := traceroute.ParseTCP(hdr.Marshal())
 	if err != nil {
 		t.Errorf("ParseTCP() = %v, not nil", err)
 	}
@@ -228,3 +236,657 @@ func TestGetProbeByTTL(t *testing.T) {
 		t.Errorf("len(GetProbesByTTL()) = %d, not %d", len(pbs), 5)
 	}
 }
+
+func TestParseICMP6Error(t *testing.T) {
+	dst := net.ParseIP("2001:db8::1")
+	src := net.ParseIP("2001:db8::2")
+	quoted := make([]byte, ipv6.HeaderLen)
+	quoted[0] = 6 << 4
+	binary.BigEndian.PutUint16(quoted[4:6], 40)
+	quoted[6] = 17
+	quoted[7] = 1
+	copy(quoted[8:24], src)
+	copy(quoted[24:40], dst)
+
+	udp := make([]byte, 40)
+	binary.BigEndian.PutUint16(udp[38:40], 0x1234)
+
+	for _, tt := range []struct {
+		name    string
+		msg     []byte
+		wantMTU int
+		wantErr bool
+	}{
+		{
+			name: "TimeExceeded",
+			msg:  append(append([]byte{traceroute.ICMP6TimeExceeded, 0, 0, 0, 0, 0, 0, 0}, quoted...), udp...),
+		},
+		{
+			name:    "PacketTooBig",
+			msg:     append(append([]byte{traceroute.ICMP6PacketTooBig, 0, 0, 0, 0, 0, 0x05, 0x00}, quoted...), udp...),
+			wantMTU: 1280,
+		},
+		{
+			name: "PortUnreachable",
+			msg:  append(append([]byte{traceroute.ICMP6DstUnreach, traceroute.ICMP6PortUnreach, 0, 0, 0, 0, 0, 0}, quoted...), udp...),
+		},
+		{
+			name:    "EchoReply",
+			msg:     append(append([]byte{traceroute.ICMP6EchoReply, 0, 0, 0, 0, 0, 0, 0}, quoted...), udp...),
+			wantErr: true,
+		},
+		{
+			name:    "Short",
+			msg:     []byte{traceroute.ICMP6TimeExceeded, 0, 0, 0, 0, 0, 0, 0},
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := traceroute.ParseICMP6Error(tt.msg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseICMP6Error() = %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if m.Type != tt.msg[0] || m.Code != tt.msg[1] {
+				t.Errorf("type/code = %d/%d, want %d/%d", m.Type, m.Code, tt.msg[0], tt.msg[1])
+			}
+			if !m.Quoted.Dst.Equal(dst) {
+				t.Errorf("quoted destination = %v, want %v", m.Quoted.Dst, dst)
+			}
+			if id := binary.BigEndian.Uint16(m.Payload[38:40]); id != 0x1234 {
+				t.Errorf("probe ID = %#x, want %#x", id, 0x1234)
+			}
+			if m.MTU != tt.wantMTU {
+				t.Errorf("MTU = %d, want %d", m.MTU, tt.wantMTU)
+			}
+		})
+	}
+}
+
+func TestParseICMP4Error(t *testing.T) {
+	dst := net.IPv4(192, 0, 2, 1).To4()
+	quoted := make([]byte, ipv4.HeaderLen)
+	quoted[0] = 4<<4 | ipv4.HeaderLen/4
+	binary.BigEndian.PutUint16(quoted[2:4], 60)
+	quoted[8] = 1
+	quoted[9] = 1
+	copy(quoted[12:16], net.IPv4(192, 0, 2, 2).To4())
+	copy(quoted[16:20], dst)
+	echo := []byte{traceroute.ICMP4EchoRequest, 0, 0, 0, 0x12, 0x34, 0x00, 0x07}
+
+	for _, tt := range []struct {
+		name    string
+		msg     []byte
+		wantMTU int
+		wantErr bool
+	}{
+		{
+			name: "TimeExceeded",
+			msg:  append(append([]byte{traceroute.ICMP4TimeExceeded, 0, 0, 0, 0, 0, 0, 0}, quoted...), echo...),
+		},
+		{
+			name:    "FragNeeded",
+			msg:     append(append([]byte{traceroute.ICMP4DstUnreach, traceroute.ICMP4FragNeeded, 0, 0, 0, 0, 0x05, 0xdc}, quoted...), echo...),
+			wantMTU: 1500,
+		},
+		{
+			name: "PortUnreachable",
+			msg:  append(append([]byte{traceroute.ICMP4DstUnreach, traceroute.ICMP4PortUnreach, 0, 0, 0, 0, 0, 0}, quoted...), echo...),
+		},
+		{
+			name:    "EchoReply",
+			msg:     append(append([]byte{traceroute.ICMP4EchoReply, 0, 0, 0, 0, 0, 0, 0}, quoted...), echo...),
+			wantErr: true,
+		},
+		{
+			name:    "Truncated",
+			msg:     append([]byte{traceroute.ICMP4TimeExceeded, 0, 0, 0, 0, 0, 0, 0}, quoted...),
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := traceroute.ParseICMP4Error(tt.msg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseICMP4Error() = %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !m.Quoted.Dst.Equal(dst) {
+				t.Errorf("quoted destination = %v, want %v", m.Quoted.Dst, dst)
+			}
+			if !bytes.Equal(m.Payload, echo) {
+				t.Errorf("payload = %x, want %x", m.Payload, echo)
+			}
+			if m.MTU != tt.wantMTU {
+				t.Errorf("MTU = %d, want %d", m.MTU, tt.wantMTU)
+			}
+		})
+	}
+}
+
+func TestICMP4EchoIdentifiers(t *testing.T) {
+	tr := traceroute.Trace{
+		DestIP: net.IPv4(127, 0, 0, 1),
+		SrcIP:  net.IPv4(127, 0, 0, 1),
+	}
+
+	hdr, pkt, err := tr.BuildICMP4Pkt(3, 0x1234, 7, 0)
+	if err != nil {
+		t.Fatalf("BuildICMP4Pkt() = %v", err)
+	}
+	if hdr.TTL != 3 {
+		t.Errorf("TTL = %d, want 3", hdr.TTL)
+	}
+	if hdr.TotalLen != ipv4.HeaderLen+len(pkt) {
+		t.Errorf("TotalLen = %d, want %d", hdr.TotalLen, ipv4.HeaderLen+len(pkt))
+	}
+	if pkt[0] != traceroute.ICMP4EchoRequest {
+		t.Errorf("type = %d, want %d", pkt[0], traceroute.ICMP4EchoRequest)
+	}
+	if id := binary.BigEndian.Uint16(pkt[4:6]); id != 0x1234 {
+		t.Errorf("echo ID = %#x, want %#x", id, 0x1234)
+	}
+	if seq := binary.BigEndian.Uint16(pkt[6:8]); seq != 7 {
+		t.Errorf("echo seq = %d, want 7", seq)
+	}
+}
+
+// onesSum folds the 16-bit one's complement sum of b.
+func onesSum(b []byte) uint16 {
+	var sum uint32
+	for ; len(b) >= 2; b = b[2:] {
+		sum += uint32(b[0])<<8 | uint32(b[1])
+	}
+	if len(b) > 0 {
+		sum += uint32(b[0]) << 8
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return uint16(sum)
+}
+
+func TestTCP4Packets(t *testing.T) {
+	tr := traceroute.Trace{
+		DestIP: net.IPv4(192, 0, 2, 1).To4(),
+		SrcIP:  net.IPv4(192, 0, 2, 2).To4(),
+	}
+
+	for _, tt := range []struct {
+		name  string
+		build func() (*ipv4.Header, []byte, error)
+		flags uint8
+		seq   uint32
+	}{
+		{
+			name:  "SYN",
+			build: func() (*ipv4.Header, []byte, error) { return tr.BuildTCP4SYNPkt(1234, 443, 5, 1000, 0) },
+			flags: traceroute.TCP_SYN,
+			seq:   1000,
+		},
+		{
+			name:  "RST",
+			build: func() (*ipv4.Header, []byte, error) { return tr.BuildTCP4RSTPkt(1234, 443, 1001, 0) },
+			flags: traceroute.TCP_RST,
+			seq:   1001,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			iph, seg, err := tt.build()
+			if err != nil {
+				t.Fatalf("build() = %v", err)
+			}
+			hdr, err := traceroute.ParseTCP(seg)
+			if err != nil {
+				t.Fatalf("ParseTCP() = %v", err)
+			}
+			if hdr.Flags != tt.flags || hdr.SeqNum != tt.seq || hdr.Src != 1234 || hdr.Dst != 443 {
+				t.Errorf("got flags %#x seq %d ports %d->%d, want flags %#x seq %d ports 1234->443", hdr.Flags, hdr.SeqNum, hdr.Src, hdr.Dst, tt.flags, tt.seq)
+			}
+			if iph.TotalLen != ipv4.HeaderLen+len(seg) {
+				t.Errorf("TotalLen = %d, want %d", iph.TotalLen, ipv4.HeaderLen+len(seg))
+			}
+
+			pseudo := append(append([]byte{}, tr.SrcIP...), tr.DestIP...)
+			pseudo = append(pseudo, 0, 6, byte(len(seg)>>8), byte(len(seg)))
+			if sum := onesSum(append(pseudo, seg...)); sum != 0xffff {
+				t.Errorf("TCP checksum does not verify: sum = %#x", sum)
+			}
+		})
+	}
+}
+
+func TestParisChecksumInvariant(t *testing.T) {
+	for _, tt := range []struct {
+		strategy traceroute.ProbeStrategy
+		want     bool
+	}{
+		{strategy: traceroute.StrategyClassic, want: false},
+		{strategy: traceroute.StrategyParis, want: true},
+	} {
+		t.Run(tt.strategy.String(), func(t *testing.T) {
+			tr := traceroute.Trace{
+				DestIP:   net.IPv4(192, 0, 2, 1).To4(),
+				SrcIP:    net.IPv4(192, 0, 2, 2).To4(),
+				Strategy: tt.strategy,
+			}
+
+			_, icmp1, _ := tr.BuildICMP4Pkt(1, 0x1234, 1, 0)
+			_, icmp2, _ := tr.BuildICMP4Pkt(2, 0x1234, 2, 0)
+			if got := bytes.Equal(icmp1[2:4], icmp2[2:4]); got != tt.want {
+				t.Errorf("ICMP4 checksums %x and %x equal = %t, want %t", icmp1[2:4], icmp2[2:4], got, tt.want)
+			}
+
+			_, icmp61 := tr.BuildICMP6Pkt(1, 0x1234, 1, 0)
+			_, icmp62 := tr.BuildICMP6Pkt(2, 0x1234, 2, 0)
+			if got := onesSum(icmp61) == onesSum(icmp62); got != tt.want {
+				t.Errorf("ICMP6 message sums equal = %t, want %t", got, tt.want)
+			}
+
+			_, udp1 := tr.BuildUDP6Pkt(1234, 33434, 1, 1, 0)
+			_, udp2 := tr.BuildUDP6Pkt(1234, 33434, 2, 2, 0)
+			if got := onesSum(udp1) == onesSum(udp2); got != tt.want {
+				t.Errorf("UDP6 datagram sums equal = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildTopology(t *testing.T) {
+	dest := net.IPv4(192, 0, 2, 9)
+	a := net.IPv4(192, 0, 2, 1)
+	b1 := net.IPv4(192, 0, 2, 2)
+	b2 := net.IPv4(192, 0, 2, 3)
+
+	// Two flows share the first hop, split at the second and meet again
+	// at the destination.
+	pbMap := map[int]*traceroute.Probe{
+		1: {TTL: 1, Flow: 0, Port: 33434, Saddr: a, Dest: dest},
+		2: {TTL: 1, Flow: 1, Port: 33435, Saddr: a, Dest: dest},
+		3: {TTL: 2, Flow: 0, Port: 33434, Saddr: b1, Dest: dest},
+		4: {TTL: 2, Flow: 1, Port: 33435, Saddr: b2, Dest: dest},
+		5: {TTL: 3, Flow: 0, Port: 33434, Saddr: dest, Dest: dest},
+		6: {TTL: 3, Flow: 1, Port: 33435, Saddr: dest, Dest: dest},
+		7: {TTL: 4, Flow: 1, Port: 33435, Saddr: dest, Dest: dest},
+	}
+
+	topo := traceroute.BuildTopology(pbMap, 2)
+	if len(topo.Paths) != 2 {
+		t.Fatalf("len(Paths) = %d, want 2", len(topo.Paths))
+	}
+	for _, p := range topo.Paths {
+		if len(p.Hops) != 3 {
+			t.Errorf("flow %d: len(Hops) = %d, want 3", p.Flow, len(p.Hops))
+		}
+		if p.Port != uint16(33434+p.Flow) {
+			t.Errorf("flow %d: Port = %d, want %d", p.Flow, p.Port, 33434+p.Flow)
+		}
+	}
+	if n := len(topo.Nodes[2]); n != 2 {
+		t.Errorf("len(Nodes[2]) = %d, want 2", n)
+	}
+	if len(topo.Links) != 4 {
+		t.Fatalf("len(Links) = %d, want 4", len(topo.Links))
+	}
+	for _, l := range topo.Links {
+		if l.Probes != 1 || len(l.Flows) != 1 {
+			t.Errorf("link %s -> %s: %d probes, flows %v, want 1 probe of 1 flow", l.From, l.To, l.Probes, l.Flows)
+		}
+	}
+}
+
+func TestWriteDOT(t *testing.T) {
+	dest := net.IPv4(192, 0, 2, 9)
+	a := net.IPv4(192, 0, 2, 1)
+	b := net.IPv4(192, 0, 2, 2)
+	pbMap := map[int]*traceroute.Probe{
+		1: {TTL: 1, Flow: 0, Saddr: a, Dest: dest},
+		2: {TTL: 1, Flow: 1, Saddr: a, Dest: dest},
+		3: {TTL: 2, Flow: 0, Saddr: b, Dest: dest},
+		4: {TTL: 2, Flow: 1, Saddr: b, Dest: dest},
+		5: {TTL: 3, Flow: 0, Saddr: dest, Dest: dest},
+	}
+
+	var buf bytes.Buffer
+	if err := traceroute.BuildTopology(pbMap, 2).WriteDOT(&buf, "trace", dest.String()); err != nil {
+		t.Fatalf("WriteDOT() = %v", err)
+	}
+	got := buf.String()
+	for _, want := range []string{
+		`digraph "trace" {`,
+		`"192.0.2.1" [label="192.0.2.1\nTTL 1"];`,
+		`"192.0.2.9" [label="192.0.2.9\nTTL 3", shape=doubleoctagon];`,
+		`"192.0.2.1" -> "192.0.2.2" [label="2", weight=2];`,
+		`"192.0.2.2" -> "192.0.2.9" [label="1", weight=1];`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("DOT output lacks %s:\n%s", want, got)
+		}
+	}
+	if !strings.HasSuffix(got, "}\n") {
+		t.Errorf("DOT output is not terminated:\n%s", got)
+	}
+}
+
+// mplsExtension returns an ICMP extension structure holding a single MPLS
+// label stack object with the given entries.
+func mplsExtension(entries ...uint32) []byte {
+	obj := make([]byte, 4+4*len(entries))
+	binary.BigEndian.PutUint16(obj[0:2], uint16(len(obj)))
+	obj[2] = traceroute.ICMPExtClassMPLS
+	obj[3] = traceroute.ICMPExtMPLSIncoming
+	for i, e := range entries {
+		binary.BigEndian.PutUint32(obj[4+4*i:], e)
+	}
+	ext := append([]byte{2 << 4, 0, 0, 0}, obj...)
+	binary.BigEndian.PutUint16(ext[2:4], ^onesSum(ext))
+	return ext
+}
+
+func TestICMPExtensionsMPLS(t *testing.T) {
+	dst := net.IPv4(192, 0, 2, 1).To4()
+	quoted := make([]byte, ipv4.HeaderLen)
+	quoted[0] = 4<<4 | ipv4.HeaderLen/4
+	binary.BigEndian.PutUint16(quoted[2:4], 60)
+	quoted[8] = 1
+	quoted[9] = 17
+	copy(quoted[16:20], dst)
+	// Original datagram, padded to 128 bytes as RFC 4884 requires.
+	datagram := append(quoted, make([]byte, 128-len(quoted))...)
+
+	// Label 24001, Exp 5, bottom of stack, TTL 1; then label 16, TTL 254.
+	top := uint32(24001)<<12 | 5<<9 | 1
+	bottom := uint32(16)<<12 | 1<<8 | 254
+	want := []traceroute.MPLSLabel{
+		{Label: 24001, Exp: 5, S: false, TTL: 1},
+		{Label: 16, Exp: 0, S: true, TTL: 254},
+	}
+
+	for _, tt := range []struct {
+		name   string
+		length byte
+	}{
+		{name: "RFC4884", length: 128 / 4},
+		{name: "Compat", length: 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := []byte{traceroute.ICMP4TimeExceeded, 0, 0, 0, 0, tt.length, 0, 0}
+			msg = append(msg, datagram...)
+			msg = append(msg, mplsExtension(top, bottom)...)
+
+			m, err := traceroute.ParseICMP4Error(msg)
+			if err != nil {
+				t.Fatalf("ParseICMP4Error() = %v", err)
+			}
+			if len(m.Payload) != 128-ipv4.HeaderLen {
+				t.Errorf("len(Payload) = %d, want %d", len(m.Payload), 128-ipv4.HeaderLen)
+			}
+			got := traceroute.MPLSLabels(m.Extensions)
+			if len(got) != len(want) {
+				t.Fatalf("MPLSLabels() = %v, want %v", got, want)
+			}
+			for i := range want {
+				if got[i] != want[i] {
+					t.Errorf("label %d = %v, want %v", i, got[i], want[i])
+				}
+			}
+		})
+	}
+
+	t.Run("BadChecksum", func(t *testing.T) {
+		ext := mplsExtension(top)
+		ext[2] ^= 0xff
+		if _, err := traceroute.ParseICMPExtensions(ext); err == nil {
+			t.Errorf("ParseICMPExtensions() = nil, want error")
+		}
+		// Without a length announced, a corrupt structure is taken as part
+		// of the original datagram.
+		msg := append(append([]byte{traceroute.ICMP4TimeExceeded, 0, 0, 0, 0, 0, 0, 0}, datagram...), ext...)
+		m, err := traceroute.ParseICMP4Error(msg)
+		if err != nil {
+			t.Fatalf("ParseICMP4Error() = %v", err)
+		}
+		if len(m.Extensions) != 0 {
+			t.Errorf("Extensions = %v, want none", m.Extensions)
+		}
+	})
+}
+
+func TestResultJSON(t *testing.T) {
+	dest := net.IPv4(192, 0, 2, 9)
+	start := time.Unix(1700000000, 0)
+	pbMap := map[int]*traceroute.Probe{
+		1: {TTL: 1, Saddr: net.IPv4(192, 0, 2, 1), Dest: dest, Sendtime: start, RecvTime: start.Add(1500 * time.Microsecond)},
+		2: {TTL: 1, Saddr: net.IPv4(192, 0, 2, 1), Dest: dest, Sendtime: start.Add(time.Second), RecvTime: start.Add(time.Second + 2*time.Millisecond)},
+		4: {
+			TTL: 3, Saddr: dest, Dest: dest, Sendtime: start, RecvTime: start.Add(10 * time.Millisecond),
+			MPLS: []traceroute.MPLSLabel{{Label: 16, S: true, TTL: 1}},
+		},
+	}
+
+	r := traceroute.NewResult("example.com", dest, "udp4", traceroute.TracerouteOptions{MaxTTL: 20}, 1, pbMap)
+	if !r.Reached {
+		t.Errorf("Reached = false, want true")
+	}
+	if len(r.Hops) != 3 {
+		t.Fatalf("len(Hops) = %d, want 3", len(r.Hops))
+	}
+	if len(r.Hops[1].Probes) != 0 {
+		t.Errorf("silent hop has %d probes, want 0", len(r.Hops[1].Probes))
+	}
+	if rtt := r.Hops[0].Probes[0].RTT; rtt != 1.5 {
+		t.Errorf("RTT = %v, want 1.5", rtt)
+	}
+
+	var b bytes.Buffer
+	if err := r.WriteJSON(&b); err != nil {
+		t.Fatalf("WriteJSON() = %v", err)
+	}
+	var got traceroute.Result
+	if err := json.Unmarshal(b.Bytes(), &got); err != nil {
+		t.Fatalf("json.Unmarshal() = %v", err)
+	}
+	if got.Dest != dest.String() || len(got.Hops) != 3 || got.Hops[2].Probes[0].MPLS[0].Label != 16 {
+		t.Errorf("decoded result %+v does not match", got)
+	}
+
+	b.Reset()
+	if err := r.WriteNDJSON(&b); err != nil {
+		t.Fatalf("WriteNDJSON() = %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("got %d NDJSON lines, want 4", len(lines))
+	}
+	var summary struct {
+		Type    string `json:"type"`
+		Hops    int    `json:"hops"`
+		Reached bool   `json:"reached"`
+	}
+	if err := json.Unmarshal([]byte(lines[3]), &summary); err != nil {
+		t.Fatalf("json.Unmarshal() = %v", err)
+	}
+	if summary.Type != "summary" || summary.Hops != 3 || !summary.Reached {
+		t.Errorf("summary = %+v, want 3 hops reached", summary)
+	}
+}
+
+func TestTracerouteOptions(t *testing.T) {
+	cc := traceroute.Coms{
+		SendChan: make(chan *traceroute.Probe),
+		RecvChan: make(chan *traceroute.Probe),
+	}
+	ip := net.IPv4(127, 0, 0, 1)
+
+	for _, tt := range []struct {
+		name  string
+		proto string
+		flags traceroute.Flags
+		want  traceroute.TracerouteOptions
+	}{
+		{
+			name:  "UDPDefaults",
+			proto: "udp4",
+			want:  traceroute.DefaultTracerouteOptions("udp4"),
+		},
+		{
+			name:  "TCPDefaults",
+			proto: "tcp6",
+			want:  traceroute.DefaultTracerouteOptions("tcp6"),
+		},
+		{
+			name:  "Custom",
+			proto: "udp4",
+			flags: traceroute.Flags{TracerouteOptions: traceroute.TracerouteOptions{
+				FirstTTL:     3,
+				MaxTTL:       300,
+				ProbesPerHop: 1,
+				Timeout:      time.Second,
+				Interval:     time.Millisecond,
+				DestPort:     53,
+			}},
+			want: traceroute.TracerouteOptions{
+				FirstTTL:     3,
+				MaxTTL:       traceroute.MAXHOPS,
+				ProbesPerHop: 1,
+				Timeout:      time.Second,
+				Interval:     time.Millisecond,
+				DestPort:     53,
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := traceroute.NewTrace(tt.proto, ip, ip, cc, &tt.flags)
+			if !reflect.DeepEqual(tr.Options, tt.want) {
+				t.Errorf("Options = %+v, want %+v", tr.Options, tt.want)
+			}
+		})
+	}
+
+	if port := traceroute.DefaultTracerouteOptions("udp4").DestPort; port != 33434 {
+		t.Errorf("default UDP port = %d, want 33434", port)
+	}
+}
+
+func TestTOSChange(t *testing.T) {
+	opts := traceroute.TracerouteOptions{DSCP: 46, ECN: traceroute.ECNECT0}
+	sent := uint8(opts.TOS())
+	if sent != 0xba {
+		t.Fatalf("TOS() = %#x, want 0xba", sent)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		quoted int
+		want   string
+	}{
+		{name: "NotQuoted", quoted: -1, want: ""},
+		{name: "Unchanged", quoted: 0xba, want: ""},
+		{name: "Bleached", quoted: 0, want: "DSCP bleached, ECN bleached"},
+		{name: "Remarked", quoted: 10<<2 | traceroute.ECNECT0, want: "DSCP 46->10"},
+		{name: "CongestionExperienced", quoted: 46<<2 | traceroute.ECNCE, want: "ECN CE"},
+		{name: "ECNChanged", quoted: 46<<2 | traceroute.ECNECT1, want: "ECN 2->1"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := traceroute.TOSChange(sent, tt.quoted); got != tt.want {
+				t.Errorf("TOSChange(%#x, %#x) = %q, want %q", sent, tt.quoted, got, tt.want)
+			}
+		})
+	}
+
+	tr := traceroute.Trace{
+		DestIP: net.IPv4(192, 0, 2, 1).To4(),
+		SrcIP:  net.IPv4(192, 0, 2, 2).To4(),
+	}
+	if hdr, _, _ := tr.BuildUDP4Pkt(1234, 33434, 1, 1, opts.TOS()); hdr.TOS != 0xba {
+		t.Errorf("UDP4 probe TOS = %#x, want 0xba", hdr.TOS)
+	}
+	if cm, _ := tr.BuildUDP6Pkt(1234, 33434, 1, 1, opts.TOS()); cm.TrafficClass != 0xba {
+		t.Errorf("UDP6 probe traffic class = %#x, want 0xba", cm.TrafficClass)
+	}
+}
+
+func TestSourceAddr(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		proto   string
+		source  string
+		iface   string
+		want    net.IP
+		wantErr bool
+	}{
+		{name: "Source4", proto: "udp4", source: "192.0.2.1", want: net.IPv4(192, 0, 2, 1)},
+		{name: "Source6", proto: "icmp6", source: "2001:db8::1", want: net.ParseIP("2001:db8::1")},
+		{name: "SourceWrongFamily", proto: "udp6", source: "192.0.2.1", wantErr: true},
+		{name: "SourceInvalid", proto: "udp4", source: "example.com", wantErr: true},
+		// The source address takes precedence over the interface.
+		{name: "SourceAndInterface", proto: "tcp4", source: "192.0.2.1", iface: "nonexistent0", want: net.IPv4(192, 0, 2, 1)},
+		{name: "NoInterface", proto: "udp4", iface: "nonexistent0", wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := traceroute.SourceAddr(tt.proto, tt.source, tt.iface)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SourceAddr() = %v, want error %t", err, tt.wantErr)
+			}
+			if err == nil && !got.Equal(tt.want) {
+				t.Errorf("SourceAddr() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skipf("no loopback interface: %v", err)
+	}
+	addrs, err := lo.Addrs()
+	if err != nil || len(addrs) == 0 {
+		t.Skipf("no loopback addresses: %v", err)
+	}
+	got, err := traceroute.SourceAddr("udp4", "", "lo")
+	if err != nil {
+		t.Fatalf("SourceAddr() on lo = %v", err)
+	}
+	if !got.IsLoopback() {
+		t.Errorf("SourceAddr() on lo = %v, want a loopback address", got)
+	}
+}
+
+func TestPacketLen(t *testing.T) {
+	tr := traceroute.Trace{
+		DestIP: net.IPv4(192, 0, 2, 1).To4(),
+		SrcIP:  net.IPv4(192, 0, 2, 2).To4(),
+		Options: traceroute.TracerouteOptions{
+			PacketLen: 100,
+			Fill:      []byte{0xaa, 0xbb},
+		},
+	}
+	hdr, udp, err := tr.BuildUDP4Pkt(1234, 33434, 1, 1, 0)
+	if err != nil {
+		t.Fatalf("BuildUDP4Pkt() = %v", err)
+	}
+	if hdr.TotalLen != 100 || len(udp) != 80 || binary.BigEndian.Uint16(udp[4:6]) != 80 {
+		t.Fatalf("TotalLen = %d, UDP datagram of %d bytes, want 100, 80", hdr.TotalLen, len(udp))
+	}
+	if !bytes.Equal(udp[8:13], []byte{0xaa, 0xbb, 0xaa, 0xbb, 0xaa}) {
+		t.Errorf("payload starts with %x, want the fill pattern", udp[8:13])
+	}
+
+	_, icmp, err := tr.BuildICMP4Pkt(1, 0x1234, 1, 0)
+	if err != nil {
+		t.Fatalf("BuildICMP4Pkt() = %v", err)
+	}
+	if len(icmp) != 80 || onesSum(icmp) != 0xffff {
+		t.Errorf("ICMP4 message of %d bytes, sum %#x, want 80 bytes that verify", len(icmp), onesSum(icmp))
+	}
+
+	tr.DestIP = net.ParseIP("2001:db8::1")
+	tr.Options.PacketLen = 1
+	_, udp6 := tr.BuildUDP6Pkt(1234, 33434, 1, 0x4242, 0)
+	// Too short a length still leaves room for the probe ID.
+	if len(udp6) != 12 || binary.BigEndian.Uint16(udp6[8:10]) != 0x4242 {
+		t.Errorf("UDP6 datagram %x, want 12 bytes with ID 0x4242 behind the header", udp6)
+	}
+}
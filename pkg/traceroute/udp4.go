@@ -5,98 +5,142 @@
 package traceroute
 
 import (
-	"bytes"
-	"encoding/binary"
-	"log"
+	"context"
 	"math/rand"
-	"net"
 	"time"
 
 	"golang.org/x/net/ipv4"
 )
 
-// SendTrace in a routine
-func (t *Trace) SendTracesUDP4() {
+// SendTracesUDP4 sends UDP probes with increasing TTLs. The IP ID
+// identifies the probe. With more than one flow, every TTL is probed
+// once per flow and each flow uses its own destination port.
+func (t *Trace) SendTracesUDP4(ctx context.Context) error {
+	defer close(t.SendChan)
+
 	id := uint16(1)
-	dport := uint16(int32(t.destPort) + rand.Int31n(64))
 	sport := uint16(1000 + t.PortOffset + rand.Int31n(500))
 	mod := uint16(1 << 15)
 
-	for ttl := 1; ttl <= int(t.MaxHops); ttl++ {
-		for j := 0; j < t.TracesPerHop; j++ {
-			conn, err := net.ListenPacket("ip4:udp", "")
-			if err != nil {
-				log.Fatalf("net.ListenPacket() = %v", err)
-			}
-			defer conn.Close()
-
-			rSock, err := ipv4.NewRawConn(conn)
-			if err != nil {
-				log.Fatalf("ipv4.NewRawConn() = %v", err)
-			}
-
-			pb := &Probe{
-				ID:   uint32(id),
-				Dest: t.DestIP,
-				Port: dport,
-				TTL:  ttl,
-			}
-			hdr, pl := t.BuildUDP4Pkt(sport, dport, uint8(ttl), id, 0)
+	conn, err := t.network().ProbeConn("ip4:udp")
+	if err != nil {
+		return sockErr("opening probe socket", err)
+	}
+	defer conn.Close()
 
-			pb.Sendtime = time.Now()
-			if err := rSock.WriteTo(hdr, pl, nil); err != nil {
-				log.Fatal(err)
+	recvConn, err := t.network().ReplyConn("ip4:icmp", nil)
+	if err != nil {
+		return sockErr("opening ICMP socket", err)
+	}
+	t.receive(ctx, recvConn, func() { t.ReceiveTracesUDP4(ctx, recvConn) })
+
+	for ttl := t.Options.FirstTTL; ttl <= t.Options.MaxTTL; ttl++ {
+		for flow := 0; flow < t.numFlows(); flow++ {
+			for j := 0; j < t.Options.ProbesPerHop; j++ {
+				release, err := t.acquireSlot(ctx)
+				if err != nil {
+					return err
+				}
+				dport := t.probeDstPort(flow)
+				pb := &Probe{
+					ID:      uint32(id),
+					Dest:    t.DestIP,
+					Port:    dport,
+					SrcPort: sport,
+					TTL:     ttl,
+					Flow:    flow,
+					release: release,
+					TOS:     uint8(t.Options.TOS()),
+				}
+				hdr, pl, err := t.BuildUDP4Pkt(sport, dport, uint8(ttl), id, t.Options.TOS())
+				if err != nil {
+					return err
+				}
+				if err := t.setIPv4Options(hdr); err != nil {
+					return err
+				}
+
+				pb.Sendtime = time.Now()
+				if err := t.sendProbe(ctx, pb); err != nil {
+					return err
+				}
+				if err := conn.WritePacket(&Packet{IPv4: hdr, Payload: pl}, t.DestIP); err != nil {
+					return sockErr("sending probe", err)
+				}
+
+				id = (id + 1) % mod
+				if err := t.pause(ctx, ttl); err != nil {
+					return err
+				}
 			}
-
-			t.SendChan <- pb
-			dport = uint16(int32(t.destPort) + rand.Int31n(64))
-			id = (id + 1) % mod
-			go t.ReceiveTracesUDP4()
-			time.Sleep(time.Microsecond * time.Duration(100000))
 		}
 	}
+	return nil
 }
 
-func (t *Trace) ReceiveTracesUDP4() {
-	dest := t.DestIP.To4()
-	var err error
-	recvICMPConn, err := net.ListenIP("ip4:icmp", nil)
-	if err != nil {
-		log.Fatal("bind failure:", err)
-	}
+// ReceiveTracesUDP4 reads the ICMP errors caused by UDP probes from
+// recvICMPConn until it is closed.
+func (t *Trace) ReceiveTracesUDP4(ctx context.Context, recvICMPConn ProbeConn) {
+	defer recvICMPConn.Close()
 
 	buf := make([]byte, 1500)
-	n, raddr, err := recvICMPConn.ReadFrom(buf)
-	if err != nil {
-		log.Fatal(err)
-	}
+	for {
+		n, from, ri, err := readReply(recvICMPConn, buf)
+		if err != nil {
+			return
+		}
 
-	icmpType := buf[0]
-	if (icmpType == 11 || (icmpType == 3 && buf[1] == 3)) && (n >= 36) { //TTL Exceeded or Port Unreachable
-		id := binary.BigEndian.Uint16(buf[12:14])
-		dstip := net.IP(buf[24:28])
-		//srcip := net.IP(buf[20:24])
-		_ = binary.BigEndian.Uint16(buf[28:30])
-		_ = binary.BigEndian.Uint16(buf[30:32])
-		if dstip.Equal(dest) { // && dstPort == t.dstPort {
+		icmpErr, err := ParseICMP4Error(buf[:n])
+		if err != nil {
+			continue
+		}
+		// TTL Exceeded, or Destination Unreachable of any code, which
+		// the hop is annotated with.
+		if t.isDest(icmpErr.Quoted.Dst) && icmpErr.Quoted.Protocol == 17 {
+			// NATs may rewrite the IP ID, but restore the checksum
+			// of the UDP header they quote.
+			id := uint16(icmpErr.Quoted.ID)
+			if cid, ok := checksumProbeID(icmpErr.Payload); ok && t.checksumIDs() {
+				id = cid
+			}
 			recvProbe := &Probe{
-				ID:       uint32(id),
-				Saddr:    net.ParseIP(raddr.String()),
-				RecvTime: time.Now(),
+				ID:        uint32(id),
+				Saddr:     from,
+				RecvTime:  ri.At,
+				ReplyTTL:  ri.TTL,
+				MPLS:      MPLSLabels(icmpErr.Extensions),
+				Unreach:   icmpErr.unreach(),
+				FragMTU:   icmpErr.fragMTU(),
+				QuotedTOS: icmpErr.Quoted.TOS,
+				Quoted:    icmpErr.quotedHeader(),
+				Recorded:  ParseRecordedRoute(icmpErr.Quoted.Options),
+			}
+			if !t.deliver(ctx, recvProbe) {
+				return
 			}
-			t.ReceiveChan <- recvProbe
 		}
 	}
 }
 
-func (t *Trace) BuildUDP4Pkt(srcPort uint16, dstPort uint16, ttl uint8, id uint16, tos int) (*ipv4.Header, []byte) {
+func (t *Trace) BuildUDP4Pkt(srcPort uint16, dstPort uint16, ttl uint8, id uint16, tos int) (*ipv4.Header, []byte, error) {
+	return t.buildUDP4Pkt(srcPort, dstPort, ttl, id, tos, t.Options.payloadLen(ipv4.HeaderLen+8, 2), false)
+}
+
+// buildUDP4Pkt builds a UDP probe with payloadLen bytes of payload,
+// setting the don't fragment flag if df. The last two bytes at an even
+// offset make the checksum encode the probe ID, if there is room.
+func (t *Trace) buildUDP4Pkt(srcPort uint16, dstPort uint16, ttl uint8, id uint16, tos int, payloadLen int, df bool) (*ipv4.Header, []byte, error) {
+	var flags ipv4.HeaderFlags
+	if df {
+		flags = ipv4.DontFragment
+	}
 	iph := &ipv4.Header{
 		Version:  ipv4.Version,
 		TOS:      tos,
 		Len:      ipv4.HeaderLen,
-		TotalLen: 60,
+		TotalLen: ipv4.HeaderLen + 8 + payloadLen,
 		ID:       int(id),
-		Flags:    0,
+		Flags:    flags,
 		FragOff:  0,
 		TTL:      int(ttl),
 		Protocol: 17,
@@ -107,7 +151,7 @@ func (t *Trace) BuildUDP4Pkt(srcPort uint16, dstPort uint16, ttl uint8, id uint1
 
 	h, err := iph.Marshal()
 	if err != nil {
-		log.Fatal(err)
+		return nil, nil, err
 	}
 	iph.Checksum = int(checkSum(h))
 
@@ -116,15 +160,15 @@ func (t *Trace) BuildUDP4Pkt(srcPort uint16, dstPort uint16, ttl uint8, id uint1
 		Dst: dstPort,
 	}
 
-	payload := make([]byte, 32)
-	for i := 0; i < 32; i++ {
-		payload[i] = uint8(i + 64)
+	payload := make([]byte, payloadLen)
+	t.Options.fill(payload)
+	udp.Length = uint16(UDPHeaderLen + len(payload))
+	if t.checksumIDs() && len(payload) >= 2 {
+		i := (len(payload) - 2) &^ 1
+		payload[i], payload[i+1] = 0, 0
+		udp.checksum(iph, payload)
+		putChecksumID(payload[i:], udp.Chksum, id)
 	}
-	udp.Length = uint16(len(payload) + 8)
 	udp.checksum(iph, payload)
-
-	var buf bytes.Buffer
-	binary.Write(&buf, binary.BigEndian, &udp)
-	binary.Write(&buf, binary.BigEndian, &payload)
-	return iph, buf.Bytes()
+	return iph, packet(udp.Marshal(), payload), nil
 }
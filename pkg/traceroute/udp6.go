@@ -5,85 +5,146 @@
 package traceroute
 
 import (
-	"bytes"
+	"context"
 	"encoding/binary"
-	"log"
 	"math/rand"
-	"net"
 	"time"
 
 	"golang.org/x/net/ipv6"
 )
 
-func (t *Trace) SendTracesUDP6() {
+// udp6IDOffset is where the probe ID sits in UDP6 probes, right behind
+// the UDP header, so that even short quotes of long probes include it.
+const udp6IDOffset = 8
+
+// udp6ChecksumOffset is the offset of the checksum field in the UDP
+// header. Raw IPv6 sockets do not compute upper layer checksums unless
+// told where to put them.
+const udp6ChecksumOffset = 6
+
+// SendTracesUDP6 sends UDP probes with increasing hop limits. The
+// last two payload bytes identify the probe. With more than one flow,
+// every hop limit is probed once per flow and each flow uses its own
+// destination port.
+func (t *Trace) SendTracesUDP6(ctx context.Context) error {
+	defer close(t.SendChan)
+
 	id := uint16(1)
-	dport := uint16(int32(t.destPort) + rand.Int31n(64))
 	sport := uint16(1000 + t.PortOffset + rand.Int31n(500))
 	mod := uint16(1 << 15)
 
-	for ttl := 1; ttl <= int(t.MaxHops); ttl++ {
-		for j := 0; j < t.TracesPerHop; j++ {
-			conn, err := net.ListenPacket("ip6:udp", "")
-			if err != nil {
-				log.Fatalf("net.ListenPacket() = %v", err)
-			}
-			defer conn.Close()
-
-			rSock := ipv6.NewPacketConn(conn)
-			rSock.SetChecksum(true, 0x8)
+	conn, err := t.network().ProbeConn("ip6:udp")
+	if err != nil {
+		return sockErr("opening probe socket", err)
+	}
+	defer conn.Close()
 
-			pb := &Probe{
-				ID:   uint32(id),
-				Dest: t.DestIP,
-				Port: dport,
-				TTL:  ttl,
+	recvConn, err := t.network().ReplyConn("ip6:ipv6-icmp", nil)
+	if err != nil {
+		return sockErr("opening ICMPv6 socket", err)
+	}
+	t.receive(ctx, recvConn, func() { t.ReceiveTracesUDP6(ctx, recvConn) })
+
+	for ttl := t.Options.FirstTTL; ttl <= t.Options.MaxTTL; ttl++ {
+		for flow := 0; flow < t.numFlows(); flow++ {
+			for j := 0; j < t.Options.ProbesPerHop; j++ {
+				release, err := t.acquireSlot(ctx)
+				if err != nil {
+					return err
+				}
+				dport := t.probeDstPort(flow)
+				pb := &Probe{
+					ID:      uint32(id),
+					Dest:    t.DestIP,
+					Port:    dport,
+					SrcPort: sport,
+					TTL:     ttl,
+					Flow:    flow,
+					release: release,
+					TOS:     uint8(t.Options.TOS()),
+				}
+				cm, payload := t.BuildUDP6Pkt(sport, dport, uint8(ttl), id, t.Options.TOS())
+
+				pb.Sendtime = time.Now()
+				if err := t.sendProbe(ctx, pb); err != nil {
+					return err
+				}
+				if err := conn.WritePacket(t.packet6(cm, payload, flow), t.DestIP); err != nil {
+					return sockErr("sending probe", err)
+				}
+
+				id = (id + 1) % mod
+				if err := t.pause(ctx, ttl); err != nil {
+					return err
+				}
 			}
-			cm, payload := t.BuildUDP6Pkt(sport, dport, uint8(ttl), id, 0)
-
-			pb.Sendtime = time.Now()
-			rSock.WriteTo(payload, cm, &net.IPAddr{IP: t.DestIP})
-
-			t.SendChan <- pb
-			dport = uint16(int32(t.destPort) + rand.Int31n(64))
-			id = (id + 1) % mod
-			go t.ReceiveTracesUDP6()
-			time.Sleep(time.Microsecond * time.Duration(100000))
 		}
 	}
+	return nil
 }
 
-func (t *Trace) ReceiveTracesUDP6() {
-	var err error
-	recvICMPConn, err := net.ListenIP("ip6:ipv6-icmp", nil)
-	if err != nil {
-		log.Fatal("bind failure:", err)
-	}
+// ReceiveTracesUDP6 reads the ICMPv6 errors caused by UDP probes from
+// recvICMPConn until it is closed.
+func (t *Trace) ReceiveTracesUDP6(ctx context.Context, recvICMPConn ProbeConn) {
+	defer recvICMPConn.Close()
 
 	buf := make([]byte, 1500)
-	n, raddr, err := recvICMPConn.ReadFrom(buf)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	ip6hdr, _ := ipv6.ParseHeader(buf[8:])
+	for {
+		n, from, ri, err := readReply(recvICMPConn, buf)
+		if err != nil {
+			return
+		}
 
-	icmpType := buf[0]
-	if (icmpType == 1 || (icmpType == 3 && buf[1] == 0)) && (n >= 36) { //TTL Exceeded or Port Unreachable
-		id := binary.BigEndian.Uint16(buf[46+ipv6.HeaderLen : 48+ipv6.HeaderLen])
-		if ip6hdr.Dst.Equal(t.DestIP) { // && dstPort == t.dstPort {
+		icmpErr, err := ParseICMP6Error(buf[:n])
+		if err != nil {
+			continue
+		}
+		// Hop Limit Exceeded or Port Unreachable
+		if icmpErr.Type == ICMP6TimeExceeded && icmpErr.Code != ICMP6HopLimitExcd {
+			continue
+		}
+		// Routers quoting no more than the UDP header leave the ID to
+		// the checksum.
+		var id uint16
+		if len(icmpErr.Payload) >= udp6IDOffset+2 {
+			id = binary.BigEndian.Uint16(icmpErr.Payload[udp6IDOffset : udp6IDOffset+2])
+		} else if cid, ok := checksumProbeID(icmpErr.Payload); ok && t.checksumIDs() {
+			id = cid
+		} else {
+			continue
+		}
+		if icmpErr.dst().Equal(t.DestIP) {
 			recvProbe := &Probe{
-				ID:       uint32(id),
-				Saddr:    net.ParseIP(raddr.String()),
-				RecvTime: time.Now(),
+				ID:        uint32(id),
+				Saddr:     from,
+				RecvTime:  ri.At,
+				ReplyTTL:  ri.TTL,
+				MPLS:      MPLSLabels(icmpErr.Extensions),
+				Unreach:   icmpErr.unreach(),
+				FragMTU:   icmpErr.fragMTU(),
+				QuotedTOS: icmpErr.Quoted.TrafficClass,
+				Quoted:    icmpErr.quotedHeader(),
+			}
+			if !t.deliver(ctx, recvProbe) {
+				return
 			}
-			t.ReceiveChan <- recvProbe
 		}
 	}
 }
 
 func (t *Trace) BuildUDP6Pkt(sport, dport uint16, ttl uint8, id uint16, tos int) (*ipv6.ControlMessage, []byte) {
+	return t.buildUDP6Pkt(sport, dport, ttl, id, tos, t.Options.payloadLen(ipv6.HeaderLen+8, 4))
+}
+
+// buildUDP6Pkt builds a UDP probe with payloadLen bytes of payload, at
+// least four. The first two hold the probe ID, the next two balance the
+// checksum of Paris probes or make it encode the ID of other ones. The
+// kernel computes the checksum, the same as here if it sends from
+// SrcIP.
+func (t *Trace) buildUDP6Pkt(sport, dport uint16, ttl uint8, id uint16, tos int, payloadLen int) (*ipv6.ControlMessage, []byte) {
 	cm := &ipv6.ControlMessage{
-		HopLimit: int(ttl),
+		TrafficClass: tos,
+		HopLimit:     int(ttl),
 	}
 
 	udphdr := UDPHeader{
@@ -91,20 +152,17 @@ func (t *Trace) BuildUDP6Pkt(sport, dport uint16, ttl uint8, id uint16, tos int)
 		Dst: dport,
 	}
 
-	payload := make([]byte, 30)
-	for i := 0; i < 30; i++ {
-		payload[i] = uint8(i + 64)
+	payload := make([]byte, payloadLen)
+	t.Options.fill(payload)
+	binary.BigEndian.PutUint16(payload, id)
+	udphdr.Length = uint16(UDPHeaderLen + len(payload))
+	switch {
+	case t.Strategy == StrategyParis:
+		putChecksumCompensation(payload[2:4], id)
+	case t.checksumIDs():
+		payload[2], payload[3] = 0, 0
+		sum := checkSum(packet(pseudoHeader6(t.SrcIP, t.DestIP, 17, int(udphdr.Length)), udphdr.Marshal(), payload))
+		putChecksumID(payload[2:4], sum, id)
 	}
-
-	// Place the ID at the end of the payload.
-	idBin := make([]byte, 2)
-	binary.BigEndian.PutUint16(idBin, id)
-	payload = append(payload, idBin...)
-
-	udphdr.Length = uint16(len(payload) + 8)
-
-	var b bytes.Buffer
-	binary.Write(&b, binary.BigEndian, &udphdr)
-	binary.Write(&b, binary.BigEndian, &payload)
-	return cm, b.Bytes()
+	return cm, packet(udphdr.Marshal(), payload)
 }
@@ -0,0 +1,132 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import "fmt"
+
+// ICMPv4 Destination Unreachable codes, RFC 792 and RFC 1812.
+const (
+	ICMP4NetUnreach      = 0
+	ICMP4HostUnreach     = 1
+	ICMP4ProtoUnreach    = 2
+	ICMP4SrcRouteFailed  = 5
+	ICMP4NetUnknown      = 6
+	ICMP4HostUnknown     = 7
+	ICMP4HostIsolated    = 8
+	ICMP4NetProhibited   = 9
+	ICMP4HostProhibited  = 10
+	ICMP4NetTOSUnreach   = 11
+	ICMP4HostTOSUnreach  = 12
+	ICMP4AdminProhibited = 13
+	ICMP4PrecViolation   = 14
+	ICMP4PrecCutoff      = 15
+)
+
+// ICMPv6 Destination Unreachable codes, RFC 4443.
+const (
+	ICMP6NoRoute         = 0
+	ICMP6AdminProhibited = 1
+	ICMP6BeyondScope     = 2
+	ICMP6AddrUnreach     = 3
+	ICMP6SrcPolicyFailed = 5
+	ICMP6RejectRoute     = 6
+)
+
+// UnreachFlag returns the annotation classic traceroute prints for a
+// Destination Unreachable message with code, e.g. "!H" for host
+// unreachable. Port unreachable is the expected answer of the
+// destination to UDP probes and has none.
+func UnreachFlag(ipv6 bool, code uint8) string {
+	if ipv6 {
+		switch code {
+		case ICMP6NoRoute:
+			return "!N"
+		case ICMP6AdminProhibited, ICMP6SrcPolicyFailed, ICMP6RejectRoute:
+			return "!X"
+		case ICMP6BeyondScope:
+			return "!S"
+		case ICMP6AddrUnreach:
+			return "!H"
+		case ICMP6PortUnreach:
+			return ""
+		}
+		return fmt.Sprintf("!<%d>", code)
+	}
+	switch code {
+	case ICMP4NetUnreach, ICMP4NetUnknown, ICMP4NetProhibited:
+		return "!N"
+	case ICMP4HostUnreach, ICMP4HostUnknown, ICMP4HostProhibited:
+		return "!H"
+	case ICMP4ProtoUnreach:
+		return "!P"
+	case ICMP4PortUnreach:
+		return ""
+	case ICMP4FragNeeded:
+		return "!F"
+	case ICMP4SrcRouteFailed:
+		return "!S"
+	case ICMP4HostIsolated:
+		return "!I"
+	case ICMP4NetTOSUnreach, ICMP4HostTOSUnreach:
+		return "!T"
+	case ICMP4AdminProhibited:
+		return "!X"
+	case ICMP4PrecViolation:
+		return "!V"
+	case ICMP4PrecCutoff:
+		return "!C"
+	}
+	return fmt.Sprintf("!<%d>", code)
+}
+
+// unreach returns the code of m if it is a Destination Unreachable
+// message, nil otherwise.
+func (m *ICMP4Error) unreach() *uint8 {
+	if m.Type != ICMP4DstUnreach {
+		return nil
+	}
+	code := m.Code
+	return &code
+}
+
+// unreach returns the code of m if it is a Destination Unreachable
+// message, nil otherwise.
+func (m *ICMP6Error) unreach() *uint8 {
+	if m.Type != ICMP6DstUnreach {
+		return nil
+	}
+	code := m.Code
+	return &code
+}
+
+// fragMTU returns the next-hop MTU of m if it is a Fragmentation Needed
+// message, nil otherwise. Routers predating RFC 1191 report zero.
+func (m *ICMP4Error) fragMTU() *int {
+	if m.Type != ICMP4DstUnreach || m.Code != ICMP4FragNeeded {
+		return nil
+	}
+	mtu := m.MTU
+	return &mtu
+}
+
+// fragMTU returns the MTU of m if it is a Packet Too Big message, nil
+// otherwise.
+func (m *ICMP6Error) fragMTU() *int {
+	if m.Type != ICMP6PacketTooBig {
+		return nil
+	}
+	mtu := m.MTU
+	return &mtu
+}
+
+// FragFlag returns the annotation of a probe that needed fragmenting
+// past a link of mtu bytes, e.g. "!F-1400", or "!F" if the MTU is not
+// known.
+func FragFlag(mtu int) string {
+	if mtu <= 0 {
+		return "!F"
+	}
+	return fmt.Sprintf("!F-%d", mtu)
+}
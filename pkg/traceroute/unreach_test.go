@@ -0,0 +1,187 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package traceroute
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnreachFlag(t *testing.T) {
+	for _, tt := range []struct {
+		ipv6 bool
+		code uint8
+		want string
+	}{
+		{code: ICMP4NetUnreach, want: "!N"},
+		{code: ICMP4HostUnreach, want: "!H"},
+		{code: ICMP4ProtoUnreach, want: "!P"},
+		{code: ICMP4PortUnreach, want: ""},
+		{code: ICMP4AdminProhibited, want: "!X"},
+		{code: 42, want: "!<42>"},
+		{ipv6: true, code: ICMP6NoRoute, want: "!N"},
+		{ipv6: true, code: ICMP6AdminProhibited, want: "!X"},
+		{ipv6: true, code: ICMP6AddrUnreach, want: "!H"},
+		{ipv6: true, code: ICMP6PortUnreach, want: ""},
+	} {
+		if got := UnreachFlag(tt.ipv6, tt.code); got != tt.want {
+			t.Errorf("UnreachFlag(%v, %d) = %q, want %q", tt.ipv6, tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestICMPErrorUnreach(t *testing.T) {
+	quoted := []byte{0x45, 0, 0, 28, 0, 1, 0, 0, 1, 17, 0, 0, 192, 0, 2, 1, 198, 51, 100, 1, 0, 0, 0, 0, 0, 0, 0, 0}
+	for _, tt := range []struct {
+		name     string
+		typ      uint8
+		code     uint8
+		wantCode int
+	}{
+		{name: "TimeExceeded", typ: ICMP4TimeExceeded, code: ICMP4TTLExcd, wantCode: -1},
+		{name: "HostUnreach", typ: ICMP4DstUnreach, code: ICMP4HostUnreach, wantCode: ICMP4HostUnreach},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := ParseICMP4Error(append([]byte{tt.typ, tt.code, 0, 0, 0, 0, 0, 0}, quoted...))
+			if err != nil {
+				t.Fatalf("ParseICMP4Error() = %v", err)
+			}
+			got := -1
+			if c := m.unreach(); c != nil {
+				got = int(*c)
+			}
+			if got != tt.wantCode {
+				t.Errorf("unreach() = %d, want %d", got, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestHopProbeUnreach(t *testing.T) {
+	now := time.Now()
+	code := uint8(ICMP4AdminProhibited)
+	h := newHop(3, 1, []*Probe{{
+		Saddr:     net.IPv4(192, 0, 2, 1),
+		Sendtime:  now,
+		RecvTime:  now.Add(time.Millisecond),
+		QuotedTOS: -1,
+		Unreach:   &code,
+	}})
+	p := h.Probes[0]
+	if p.UnreachCode == nil || *p.UnreachCode != ICMP4AdminProhibited || p.Flag != "!X" {
+		t.Fatalf("probe = %+v, want code %d flagged !X", p, ICMP4AdminProhibited)
+	}
+	b, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"unreach_code":13,"flag":"!X"`) {
+		t.Errorf("JSON %s lacks the unreachable code", b)
+	}
+}
+
+func TestUnreachFakeNetwork(t *testing.T) {
+	src := net.IPv4(192, 0, 2, 100)
+	dest := net.IPv4(198, 51, 100, 1)
+	routers := []net.IP{net.IPv4(192, 0, 2, 1), net.IPv4(203, 0, 113, 1)}
+
+	cc := Coms{
+		SendChan: make(chan *Probe),
+		RecvChan: make(chan *Probe),
+	}
+	tr := NewTrace("udp4", dest, src, cc, &Flags{TracerouteOptions: TracerouteOptions{
+		MaxTTL:       5,
+		ProbesPerHop: 1,
+		Interval:     time.Millisecond,
+		Timeout:      time.Second,
+	}})
+	n := newFakeNetwork(dest.To4(), routers...)
+	n.dstUnreach = ICMP4AdminProhibited
+	tr.Network = n
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go tr.SendTracesUDP4(ctx)
+	printMap := runTransmission(ctx, cc, 1, tr.Options.Timeout, nil)
+
+	for ttl := 1; ttl <= len(routers); ttl++ {
+		for _, pb := range GetProbesByTLL(printMap, ttl) {
+			if p := newHopProbe(pb); p.UnreachCode != nil || p.Flag != "" {
+				t.Errorf("TTL %d: probe = %+v, want no unreachable code", ttl, p)
+			}
+		}
+	}
+	pbs := GetProbesByTLL(printMap, len(routers)+1)
+	if len(pbs) != 1 {
+		t.Fatalf("%d answers at TTL %d, want 1", len(pbs), len(routers)+1)
+	}
+	p := newHopProbe(pbs[0])
+	if p.UnreachCode == nil || *p.UnreachCode != ICMP4AdminProhibited || p.Flag != "!X" || p.Addr != dest.String() {
+		t.Errorf("probe = %+v, want code %d of %v flagged !X", p, ICMP4AdminProhibited, dest)
+	}
+}
+
+func TestFragFlag(t *testing.T) {
+	for mtu, want := range map[int]string{0: "!F", 1400: "!F-1400"} {
+		if got := FragFlag(mtu); got != want {
+			t.Errorf("FragFlag(%d) = %q, want %q", mtu, got, want)
+		}
+	}
+}
+
+func TestFragNeededFakeNetwork(t *testing.T) {
+	src := net.IPv4(192, 0, 2, 100)
+	dest := net.IPv4(198, 51, 100, 1)
+	routers := []net.IP{net.IPv4(192, 0, 2, 1), net.IPv4(203, 0, 113, 1)}
+
+	for _, df := range []bool{false, true} {
+		cc := Coms{
+			SendChan: make(chan *Probe),
+			RecvChan: make(chan *Probe),
+		}
+		tr := NewTrace("udp4", dest, src, cc, &Flags{TracerouteOptions: TracerouteOptions{
+			MaxTTL:       3,
+			ProbesPerHop: 1,
+			Interval:     time.Millisecond,
+			Timeout:      time.Second,
+			PacketLen:    1400,
+			DontFragment: df,
+		}})
+		n := newFakeNetwork(dest.To4(), routers...)
+		n.mtu = 1000
+		tr.Network = n
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go tr.SendTracesUDP4(ctx)
+		printMap := runTransmission(ctx, cc, 1, tr.Options.Timeout, nil)
+		cancel()
+
+		pbs := GetProbesByTLL(printMap, 3)
+		if len(pbs) != 1 {
+			t.Fatalf("DontFragment %v: %d answers at TTL 3, want 1", df, len(pbs))
+		}
+		p := newHopProbe(pbs[0])
+		if !df {
+			if p.FragNeeded || p.Addr != dest.String() {
+				t.Errorf("fragmentable probe = %+v, want an answer of %v", p, dest)
+			}
+			continue
+		}
+		if !p.FragNeeded || p.NextHopMTU != 1000 || p.Flag != "!F-1000" || p.Addr != routers[0].String() {
+			t.Errorf("probe = %+v, want Fragmentation Needed by %v with MTU 1000", p, routers[0])
+		}
+		b, err := json.Marshal(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(b), `"frag_needed":true,"next_hop_mtu":1000`) {
+			t.Errorf("JSON %s lacks the next-hop MTU", b)
+		}
+	}
+}
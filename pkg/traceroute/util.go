@@ -5,11 +5,16 @@
 package traceroute
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"strings"
+	"sync"
 )
 
+var errNoDestAddr = errors.New("no address to trace")
+
 type Coms struct {
 	SendChan chan *Probe
 	RecvChan chan *Probe
@@ -17,32 +22,40 @@ type Coms struct {
 
 // Given a host name convert it to a IP address according to provided ip protocol.
 func DestAddr(dest, proto string) (net.IP, error) {
-	addrs, err := net.LookupHost(dest)
+	return ResolveDest(context.Background(), net.DefaultResolver, dest, proto, false)
+}
+
+// ResolveDest looks up the addresses of host with r and returns the
+// first one of the IP version of proto. With fallback, it returns one of
+// the other version if there is none.
+func ResolveDest(ctx context.Context, r *net.Resolver, host, proto string, fallback bool) (net.IP, error) {
+	addrs, err := r.LookupIPAddr(ctx, host)
 	if err != nil {
 		return nil, err
 	}
-
-	var addr string
+	v6 := strings.Contains(proto, "6")
+	var other net.IP
 	for _, a := range addrs {
-		if strings.Contains(a, ":") && strings.Contains(proto, "6") {
-			addr = a
-			break
-		} else if strings.Contains(a, ".") && strings.Contains(proto, "4") {
-			addr = a
-			break
+		if (a.IP.To4() == nil) == v6 {
+			return a.IP, nil
+		}
+		if other == nil {
+			other = a.IP
 		}
 	}
-
-	if len(addr) < 1 {
-		return nil, fmt.Errorf("no valid ip address for proto: %s", proto)
+	if fallback && other != nil {
+		return other, nil
 	}
+	return nil, fmt.Errorf("%w: %s has no address for proto %s", errNoDestAddr, host, proto)
+}
 
-	ipAddr, err := net.ResolveIPAddr("ip", addr)
-	if err != nil {
-		return nil, err
+// otherFamily returns proto with the other IP version, e.g. "udp6" for
+// "udp4".
+func otherFamily(proto string) string {
+	if strings.HasSuffix(proto, "6") {
+		return strings.TrimSuffix(proto, "6") + "4"
 	}
-
-	return ipAddr.IP, nil
+	return strings.TrimSuffix(proto, "4") + "6"
 }
 
 func SrcAddr(proto string) (*net.IP, error) {
@@ -50,14 +63,14 @@ func SrcAddr(proto string) (*net.IP, error) {
 	if strings.Contains(proto, "6") {
 		conn, err := net.Dial("udp6", "[2001:4860:4860::8844]:53")
 		if err != nil {
-			return nil, err
+			return nil, sockErr("picking source address", err)
 		}
 		sAddr = conn.LocalAddr().(*net.UDPAddr)
 		conn.Close()
 	} else {
 		conn, err := net.Dial("udp", "8.8.8.8:53")
 		if err != nil {
-			return nil, err
+			return nil, sockErr("picking source address", err)
 		}
 		sAddr = conn.LocalAddr().(*net.UDPAddr)
 		conn.Close()
@@ -65,6 +78,59 @@ func SrcAddr(proto string) (*net.IP, error) {
 	return &sAddr.(*net.UDPAddr).IP, nil
 }
 
+// SourceAddr returns the address probes of proto are sent from: source
+// if set, else the first address of the interface iface if set, else the
+// address the kernel uses to reach the internet.
+func SourceAddr(proto, source, iface string) (net.IP, error) {
+	v6 := strings.Contains(proto, "6")
+	if source != "" {
+		ip := net.ParseIP(source)
+		if ip == nil {
+			return nil, fmt.Errorf("%w: %q is not an IP address", errSourceAddr, source)
+		}
+		if (ip.To4() == nil) != v6 {
+			return nil, fmt.Errorf("%w: %s does not match proto %s", errSourceAddr, ip, proto)
+		}
+		return ip, nil
+	}
+
+	if iface == "" {
+		ip, err := SrcAddr(proto)
+		if err != nil {
+			return nil, err
+		}
+		return *ip, nil
+	}
+
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	var linkLocal net.IP
+	for _, addr := range addrs {
+		n, ok := addr.(*net.IPNet)
+		if !ok || (n.IP.To4() == nil) != v6 {
+			continue
+		}
+		// Link-local IPv6 addresses only reach the first hop.
+		if n.IP.IsLinkLocalUnicast() {
+			if linkLocal == nil {
+				linkLocal = n.IP
+			}
+			continue
+		}
+		return n.IP, nil
+	}
+	if linkLocal != nil {
+		return linkLocal, nil
+	}
+	return nil, fmt.Errorf("%w: no address for proto %s on %s", errSourceAddr, proto, iface)
+}
+
 func DestTTL(printMap map[int]*Probe) int {
 	icmp := false
 	destttl := 1
@@ -98,3 +164,39 @@ func GetProbesByTLL(printMap map[int]*Probe, ttl int) []*Probe {
 	}
 	return pbs
 }
+
+// forEachHopAddr calls fn once per distinct answering address with all
+// probes answered by it, running up to DEFLOOKUPWORKERS calls at once.
+func forEachHopAddr(printMap map[int]*Probe, fn func(pbs []*Probe)) {
+	byAddr := map[string][]*Probe{}
+	for _, pb := range printMap {
+		if pb.Saddr == nil {
+			continue
+		}
+		byAddr[pb.Saddr.String()] = append(byAddr[pb.Saddr.String()], pb)
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, DEFLOOKUPWORKERS)
+	for _, pbs := range byAddr {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(pbs []*Probe) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			fn(pbs)
+		}(pbs)
+	}
+	wg.Wait()
+}
+
+// hopAddr formats the address of the hop that answered pb, with its name
+// if known.
+func hopAddr(pb *Probe) string {
+	if pb.Name == "" {
+		return pb.Saddr.String()
+	}
+	return fmt.Sprintf("%s (%s)", pb.Name, pb.Saddr)
+}
@@ -21,6 +21,18 @@ const (
 	BootUUID = "8be4df61-93ca-11d2-aa0d-00e098032b8c"
 )
 
+// Attributes of load options, as defined in UEFI spec v2.8A section 3.1.3.
+const (
+	// LoadOptionActive marks entries that the boot manager tries.
+	LoadOptionActive = 0x00000001
+	// LoadOptionHidden marks entries that are not shown in boot menus.
+	LoadOptionHidden = 0x00000008
+	// LoadOptionCategoryMask masks the category of an entry, which is
+	// LoadOptionCategoryBoot for entries that boot an OS.
+	LoadOptionCategoryMask = 0x00001F00
+	LoadOptionCategoryBoot = 0x00000000
+)
+
 // BootEntryVar is a boot entry. It will have the name BootXXXX where XXXX is
 // hexadecimal.
 type BootEntryVar struct {
@@ -148,6 +160,58 @@ func BootVar(v uefivars.EfiVar) (b *BootEntryVar) {
 	return
 }
 
+// ParseBootVar decodes data, the contents of the BootXXXX var num without its
+// attributes, as read from efivarfs.
+//
+// Unlike BootVar, it returns an error for malformed load options.
+func ParseBootVar(num uint16, data []byte) (*BootEntryVar, error) {
+	if len(data) < 6 {
+		return nil, fmt.Errorf("Boot%04X of %d bytes: %w", num, len(data), ErrParse)
+	}
+	b := &BootEntryVar{Number: num}
+	b.Attributes = binary.LittleEndian.Uint32(data[:4])
+	b.FilePathListLength = binary.LittleEndian.Uint16(data[4:6])
+
+	// Description is null-terminated utf16
+	i := 6
+	for ; i+1 < len(data); i += 2 {
+		if data[i] == 0 && data[i+1] == 0 {
+			break
+		}
+	}
+	if i+1 >= len(data) {
+		return nil, fmt.Errorf("Boot%04X description is not terminated: %w", num, ErrParse)
+	}
+	var err error
+	if b.Description, err = uefivars.DecodeUTF16(data[6:i]); err != nil {
+		return nil, fmt.Errorf("Boot%04X description: %w", num, err)
+	}
+
+	paths := i + 2
+	end := paths + int(b.FilePathListLength)
+	if end > len(data) {
+		return nil, fmt.Errorf("Boot%04X FilePathList of %d bytes exceeds the var: %w", num, b.FilePathListLength, ErrParse)
+	}
+	if b.FilePathList, err = ParseFilePathList(data[paths:end]); err != nil {
+		return nil, fmt.Errorf("Boot%04X FilePathList: %w", num, err)
+	}
+	b.OptionalData = data[end:]
+	return b, nil
+}
+
+// ParseBootOrder decodes data, the contents of the BootOrder var without its
+// attributes, as read from efivarfs.
+func ParseBootOrder(data []byte) ([]uint16, error) {
+	if len(data)%2 != 0 {
+		return nil, fmt.Errorf("BootOrder of %d bytes: %w", len(data), ErrParse)
+	}
+	order := make([]uint16, 0, len(data)/2)
+	for i := 0; i < len(data); i += 2 {
+		order = append(order, binary.LittleEndian.Uint16(data[i:]))
+	}
+	return order, nil
+}
+
 // BootCurrentVar represents the UEFI BootCurrent var.
 type BootCurrentVar struct {
 	uefivars.EfiVar
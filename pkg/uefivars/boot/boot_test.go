@@ -8,7 +8,9 @@
 package boot
 
 import (
+	"errors"
 	"os"
+	"reflect"
 	"testing"
 
 	"github.com/u-root/u-root/pkg/uefivars"
@@ -110,3 +112,50 @@ func TestBootCurrent(t *testing.T) {
 		t.Errorf("want %d got %d", want, bc.Current)
 	}
 }
+
+func TestParseBootVar(t *testing.T) {
+	for _, v := range AllBootVars().Filter(BootEntryFilter) {
+		want := BootVar(v)
+		got, err := ParseBootVar(want.Number, v.Data)
+		if err != nil {
+			t.Errorf("ParseBootVar(%s) = %v", v.Name, err)
+			continue
+		}
+		if got.String() != want.String() {
+			t.Errorf("ParseBootVar(%s) = %s, want %s", v.Name, got, want)
+		}
+	}
+
+	for _, tt := range []struct {
+		name string
+		data []byte
+	}{
+		{name: "short", data: []byte{1, 0, 0, 0}},
+		{name: "unterminated description", data: []byte{1, 0, 0, 0, 0, 0, 'a', 0, 'b'}},
+		{name: "long FilePathList", data: []byte{1, 0, 0, 0, 8, 0, 'a', 0, 0, 0, 0x7f, 0xff, 4, 0}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseBootVar(1, tt.data); !errors.Is(err, ErrParse) {
+				t.Errorf("ParseBootVar() = %v, want %v", err, ErrParse)
+			}
+		})
+	}
+}
+
+func TestParseBootOrder(t *testing.T) {
+	v, err := uefivars.ReadVar(BootUUID, "BootOrder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseBootOrder(v.Data)
+	if err != nil {
+		t.Fatalf("ParseBootOrder() = %v", err)
+	}
+	want := []uint16{0xa, 0x7, 0x8, 0x0, 0x1, 0x2, 0x3, 0x5, 0x9, 0x4, 0x6}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseBootOrder() = %v, want %v", got, want)
+	}
+	if _, err := ParseBootOrder([]byte{1, 0, 2}); !errors.Is(err, ErrParse) {
+		t.Errorf("ParseBootOrder() of an odd length = %v, want %v", err, ErrParse)
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vfile
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"debug/pe"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// ErrNoAuthenticode is returned for PE images without an Authenticode
+// signature.
+var ErrNoAuthenticode = errors.New("PE image has no Authenticode signature")
+
+// The attribute certificates of a PE image, WIN_CERTIFICATE.
+const (
+	certRevision   = 0x0200
+	certPKCSSigned = 0x0002
+	certHeaderSize = 8
+)
+
+var oidSpcIndirectData = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 4}
+
+// spcIndirectDataContent is the signed content of Authenticode signatures.
+type spcIndirectDataContent struct {
+	Data          asn1.RawValue
+	MessageDigest struct {
+		Algorithm pkix.AlgorithmIdentifier
+		Digest    []byte
+	}
+}
+
+// peLayout has the file offsets that Authenticode excludes from the hash of
+// a PE image.
+type peLayout struct {
+	checksum  int64
+	certEntry int64
+	certTable pe.DataDirectory
+}
+
+func readPELayout(r io.ReaderAt) (*peLayout, error) {
+	f, err := pe.NewFile(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: not a PE image: %v", ErrNoAuthenticode, err)
+	}
+	var lfanew [4]byte
+	if _, err := r.ReadAt(lfanew[:], 0x3c); err != nil {
+		return nil, err
+	}
+	// The optional header follows the signature and the COFF header.
+	opt := int64(binary.LittleEndian.Uint32(lfanew[:])) + 4 + 20
+	l := &peLayout{checksum: opt + 64}
+	var dirs []pe.DataDirectory
+	switch oh := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		l.certEntry = opt + 96
+		dirs = oh.DataDirectory[:min(oh.NumberOfRvaAndSizes, 16)]
+	case *pe.OptionalHeader64:
+		l.certEntry = opt + 112
+		dirs = oh.DataDirectory[:min(oh.NumberOfRvaAndSizes, 16)]
+	}
+	if len(dirs) <= pe.IMAGE_DIRECTORY_ENTRY_SECURITY || dirs[pe.IMAGE_DIRECTORY_ENTRY_SECURITY].Size == 0 {
+		return nil, ErrNoAuthenticode
+	}
+	l.certEntry += pe.IMAGE_DIRECTORY_ENTRY_SECURITY * 8
+	l.certTable = dirs[pe.IMAGE_DIRECTORY_ENTRY_SECURITY]
+	return l, nil
+}
+
+// hash returns the Authenticode digest of the image: everything but the
+// checksum, the certificate table's directory entry and the certificate
+// table itself.
+//
+// Like sbsign, it hashes the file in order rather than section by section,
+// which is the same for images whose sections follow the headers in order.
+func (l *peLayout) hash(r io.ReaderAt, h crypto.Hash) ([]byte, error) {
+	d := h.New()
+	tableEnd := int64(l.certTable.VirtualAddress) + int64(l.certTable.Size)
+	for _, s := range []struct{ start, end int64 }{
+		{0, l.checksum},
+		{l.checksum + 4, l.certEntry},
+		{l.certEntry + 8, int64(l.certTable.VirtualAddress)},
+		{tableEnd, math.MaxInt64},
+	} {
+		if _, err := io.Copy(d, io.NewSectionReader(r, s.start, s.end-s.start)); err != nil {
+			return nil, err
+		}
+	}
+	return d.Sum(nil), nil
+}
+
+// CheckAuthenticode verifies the Authenticode signature of the PE image, e.g.
+// of an EFI stub kernel or a unified kernel image signed with sbsign or
+// pesign, against roots. It returns the certificate of the first signer that
+// chains to roots.
+func CheckAuthenticode(roots *x509.CertPool, image io.ReaderAt) (*x509.Certificate, error) {
+	l, err := readPELayout(image)
+	if err != nil {
+		return nil, err
+	}
+	// The certificate table is at a file offset, not a virtual address. The
+	// size comes from the image, so check the table is in it before
+	// allocating it.
+	var last [1]byte
+	if _, err := image.ReadAt(last[:], int64(l.certTable.VirtualAddress)+int64(l.certTable.Size)-1); err != nil {
+		return nil, fmt.Errorf("%w: certificate table of %d bytes at %#x is past the end of the image: %v", ErrBadPKCS7, l.certTable.Size, l.certTable.VirtualAddress, err)
+	}
+	table := make([]byte, l.certTable.Size)
+	if _, err := image.ReadAt(table, int64(l.certTable.VirtualAddress)); err != nil {
+		return nil, fmt.Errorf("%w: reading the certificate table: %v", ErrBadPKCS7, err)
+	}
+
+	err = ErrNoAuthenticode
+	for len(table) >= certHeaderSize {
+		length := binary.LittleEndian.Uint32(table[0:])
+		revision := binary.LittleEndian.Uint16(table[4:])
+		typ := binary.LittleEndian.Uint16(table[6:])
+		if length < certHeaderSize || uint64(length) > uint64(len(table)) {
+			return nil, fmt.Errorf("%w: certificate of %d bytes in a table of %d", ErrBadPKCS7, length, len(table))
+		}
+		if revision == certRevision && typ == certPKCSSigned {
+			var signer *x509.Certificate
+			if signer, err = checkAuthenticodeSignature(roots, image, l, table[certHeaderSize:length]); err == nil {
+				return signer, nil
+			}
+		}
+		// Entries are 8-byte aligned.
+		next := (uint64(length) + 7) &^ 7
+		if next >= uint64(len(table)) {
+			break
+		}
+		table = table[next:]
+	}
+	return nil, err
+}
+
+func checkAuthenticodeSignature(roots *x509.CertPool, image io.ReaderAt, l *peLayout, b []byte) (*x509.Certificate, error) {
+	s, err := parsePKCS7(b)
+	if err != nil {
+		return nil, err
+	}
+	ci := s.sd.ContentInfo
+	if !ci.ContentType.Equal(oidSpcIndirectData) {
+		return nil, fmt.Errorf("%w: content type %v is not Authenticode", ErrBadPKCS7, ci.ContentType)
+	}
+	var content asn1.RawValue
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &content); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadPKCS7, err)
+	}
+	var spc spcIndirectDataContent
+	if _, err := asn1.Unmarshal(content.FullBytes, &spc); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadPKCS7, err)
+	}
+
+	h, ok := digestAlgorithms[spc.MessageDigest.Algorithm.Algorithm.String()]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported digest algorithm %v", ErrBadPKCS7, spc.MessageDigest.Algorithm.Algorithm)
+	}
+	digest, err := l.hash(image, h)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(digest, spc.MessageDigest.Digest) {
+		return nil, fmt.Errorf("%w: digest does not match the image", ErrBadPKCS7)
+	}
+
+	// Authenticode signs the content without its SEQUENCE header.
+	d := s.hash.New()
+	d.Write(content.Bytes)
+	return s.verify(roots, d.Sum(nil))
+}
@@ -0,0 +1,139 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vfile
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// Offsets in the PE32+ image of testPE.
+const (
+	testPEChecksum  = 0x40 + 24 + 64
+	testPECertEntry = 0x40 + 24 + 112 + 4*8
+)
+
+// testPE returns a PE32+ image without sections, followed by payload.
+func testPE(payload string) []byte {
+	img := make([]byte, 0x40+24+240)
+	copy(img, "MZ")
+	binary.LittleEndian.PutUint32(img[0x3c:], 0x40)
+	copy(img[0x40:], "PE\x00\x00")
+	binary.LittleEndian.PutUint16(img[0x44:], 0x8664)
+	binary.LittleEndian.PutUint16(img[0x44+16:], 240)
+	opt := img[0x40+24:]
+	binary.LittleEndian.PutUint16(opt, 0x20b)
+	binary.LittleEndian.PutUint32(opt[108:], 16)
+	return append(img, payload...)
+}
+
+// signPE appends an Authenticode signature by s of img to img, as sbsign
+// does.
+func signPE(t *testing.T, s *testSigner, img []byte) []byte {
+	t.Helper()
+	var hashed []byte
+	hashed = append(hashed, img[:testPEChecksum]...)
+	hashed = append(hashed, img[testPEChecksum+4:testPECertEntry]...)
+	hashed = append(hashed, img[testPECertEntry+8:]...)
+	digest := sha256.Sum256(hashed)
+
+	var spc spcIndirectDataContent
+	spc.Data = asn1.RawValue{FullBytes: mustMarshal(t, []asn1.ObjectIdentifier{{1, 3, 6, 1, 4, 1, 311, 2, 1, 15}}, "")}
+	spc.MessageDigest.Algorithm = pkix.AlgorithmIdentifier{Algorithm: oidSHA256}
+	spc.MessageDigest.Digest = digest[:]
+	content := mustMarshal(t, spc, "")
+	var inner asn1.RawValue
+	if _, err := asn1.Unmarshal(content, &inner); err != nil {
+		t.Fatal(err)
+	}
+	sig := s.sign(t, oidSpcIndirectData, content, inner.Bytes, true)
+
+	cert := make([]byte, certHeaderSize, certHeaderSize+len(sig)+7)
+	binary.LittleEndian.PutUint32(cert[0:], uint32(certHeaderSize+len(sig)))
+	binary.LittleEndian.PutUint16(cert[4:], certRevision)
+	binary.LittleEndian.PutUint16(cert[6:], certPKCSSigned)
+	cert = append(cert, sig...)
+	for len(cert)%8 != 0 {
+		cert = append(cert, 0)
+	}
+
+	signed := append([]byte{}, img...)
+	binary.LittleEndian.PutUint32(signed[testPECertEntry:], uint32(len(img)))
+	binary.LittleEndian.PutUint32(signed[testPECertEntry+4:], uint32(len(cert)))
+	return append(signed, cert...)
+}
+
+func TestCheckAuthenticode(t *testing.T) {
+	ca := newTestSigner(t, "ca", nil)
+	leaf := newTestSigner(t, "leaf", ca)
+	other := newTestSigner(t, "other", nil)
+	img := testPE("kernel contents")
+	signed := signPE(t, leaf, img)
+
+	tampered := append([]byte{}, signed...)
+	copy(tampered[len(img)-len("contents"):], "CONTENTS")
+
+	oversized := append([]byte{}, signed...)
+	binary.LittleEndian.PutUint32(oversized[testPECertEntry+4:], 0xffffffff)
+
+	for _, tt := range []struct {
+		name  string
+		roots *x509.CertPool
+		image []byte
+		want  error
+	}{
+		{
+			name:  "signed",
+			roots: ca.pool(),
+			image: signed,
+		},
+		{
+			name:  "untrusted",
+			roots: other.pool(),
+			image: signed,
+			want:  ErrUntrustedSigner,
+		},
+		{
+			name:  "tampered",
+			roots: ca.pool(),
+			image: tampered,
+			want:  ErrBadPKCS7,
+		},
+		{
+			name:  "oversized table",
+			roots: ca.pool(),
+			image: oversized,
+			want:  ErrBadPKCS7,
+		},
+		{
+			name:  "unsigned",
+			roots: ca.pool(),
+			image: img,
+			want:  ErrNoAuthenticode,
+		},
+		{
+			name:  "not a PE image",
+			roots: ca.pool(),
+			image: []byte("kernel contents"),
+			want:  ErrNoAuthenticode,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, err := CheckAuthenticode(tt.roots, bytes.NewReader(tt.image))
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("CheckAuthenticode() = %v, want %v", err, tt.want)
+			}
+			if err == nil && signer.Subject.CommonName != "leaf" {
+				t.Errorf("CheckAuthenticode() signer = %v, want leaf", signer.Subject)
+			}
+		})
+	}
+}
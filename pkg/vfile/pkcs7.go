@@ -0,0 +1,218 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vfile
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	// Hashes of PKCS#7 signatures.
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+var (
+	// ErrBadPKCS7 is returned for PKCS#7 signatures that cannot be parsed
+	// or that do not match the signed content.
+	ErrBadPKCS7 = errors.New("invalid PKCS#7 signature")
+
+	// ErrUntrustedSigner is returned for PKCS#7 signatures whose signer
+	// does not chain to the trusted certificates.
+	ErrUntrustedSigner = errors.New("signer is not trusted")
+)
+
+var (
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+)
+
+var digestAlgorithms = map[string]crypto.Hash{
+	"2.16.840.1.101.3.4.2.1": crypto.SHA256,
+	"2.16.840.1.101.3.4.2.2": crypto.SHA384,
+	"2.16.840.1.101.3.4.2.3": crypto.SHA512,
+}
+
+// contentInfo is the ContentInfo of RFC 2315 Section 7.
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+// signedData is the SignedData of RFC 2315 Section 9.1.
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      contentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type issuerAndSerial struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+// signerInfo is the SignerInfo of RFC 2315 Section 9.2.
+type signerInfo struct {
+	Version                   int
+	IssuerAndSerial           issuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue `asn1:"set"`
+}
+
+// pkcs7Signature is a parsed PKCS#7 signature with a single signer.
+type pkcs7Signature struct {
+	sd     signedData
+	certs  []*x509.Certificate
+	signer *x509.Certificate
+	hash   crypto.Hash
+}
+
+func parsePKCS7(b []byte) (*pkcs7Signature, error) {
+	var ci contentInfo
+	// Signing tools may pad signatures, so ignore trailing data.
+	if _, err := asn1.Unmarshal(b, &ci); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadPKCS7, err)
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("%w: content type %v is not signed data", ErrBadPKCS7, ci.ContentType)
+	}
+	s := &pkcs7Signature{}
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &s.sd); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadPKCS7, err)
+	}
+	if len(s.sd.SignerInfos) != 1 {
+		return nil, fmt.Errorf("%w: %d signers, want 1", ErrBadPKCS7, len(s.sd.SignerInfos))
+	}
+	certs, err := x509.ParseCertificates(s.sd.Certificates.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadPKCS7, err)
+	}
+	s.certs = certs
+
+	si := s.sd.SignerInfos[0]
+	for _, c := range certs {
+		if bytes.Equal(c.RawIssuer, si.IssuerAndSerial.Issuer.FullBytes) && c.SerialNumber.Cmp(si.IssuerAndSerial.Serial) == 0 {
+			s.signer = c
+			break
+		}
+	}
+	if s.signer == nil {
+		return nil, fmt.Errorf("%w: no certificate of the signer", ErrBadPKCS7)
+	}
+	h, ok := digestAlgorithms[si.DigestAlgorithm.Algorithm.String()]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported digest algorithm %v", ErrBadPKCS7, si.DigestAlgorithm.Algorithm)
+	}
+	s.hash = h
+	return s, nil
+}
+
+// verify checks that the signer signed digest, the digest of the content,
+// and that it chains to roots.
+func (s *pkcs7Signature) verify(roots *x509.CertPool, digest []byte) (*x509.Certificate, error) {
+	si := s.sd.SignerInfos[0]
+	signed := digest
+	if len(si.AuthenticatedAttributes.Bytes) > 0 {
+		var found bool
+		rest := si.AuthenticatedAttributes.Bytes
+		for len(rest) > 0 {
+			var attr attribute
+			var err error
+			if rest, err = asn1.Unmarshal(rest, &attr); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrBadPKCS7, err)
+			}
+			if !attr.Type.Equal(oidMessageDigest) {
+				continue
+			}
+			var md []byte
+			if _, err := asn1.Unmarshal(attr.Values.Bytes, &md); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrBadPKCS7, err)
+			}
+			if !bytes.Equal(md, digest) {
+				return nil, fmt.Errorf("%w: digest does not match the content", ErrBadPKCS7)
+			}
+			found = true
+		}
+		if !found {
+			return nil, fmt.Errorf("%w: no message digest attribute", ErrBadPKCS7)
+		}
+
+		// The attributes are signed as a SET, not with their implicit
+		// [0] tag.
+		attrs := append([]byte{}, si.AuthenticatedAttributes.FullBytes...)
+		attrs[0] = 0x31
+		h := s.hash.New()
+		h.Write(attrs)
+		signed = h.Sum(nil)
+	}
+
+	switch pub := s.signer.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(pub, s.hash, signed, si.EncryptedDigest); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrBadPKCS7, err)
+		}
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, signed, si.EncryptedDigest) {
+			return nil, fmt.Errorf("%w: ECDSA verification failed", ErrBadPKCS7)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported public key %T", ErrBadPKCS7, pub)
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range s.certs {
+		intermediates.AddCert(c)
+	}
+	if _, err := s.signer.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		// Machines that boot often have no correct time yet, and
+		// firmware does not check the validity of signing
+		// certificates either. The chain must have been valid when
+		// the signer was issued.
+		CurrentTime: s.signer.NotBefore,
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUntrustedSigner, err)
+	}
+	return s.signer, nil
+}
+
+// CheckPKCS7Signature verifies that signature is a detached PKCS#7 or CMS
+// signature of content, as made by openssl cms -sign -binary -outform DER,
+// whose signer chains to roots. It returns the signer's certificate.
+//
+// Signatures need a single signer with an RSA PKCS#1 v1.5 or ECDSA key and
+// SHA-256, SHA-384 or SHA-512 digests.
+func CheckPKCS7Signature(roots *x509.CertPool, content io.Reader, signature []byte) (*x509.Certificate, error) {
+	s, err := parsePKCS7(signature)
+	if err != nil {
+		return nil, err
+	}
+	h := s.hash.New()
+	if _, err := io.Copy(h, content); err != nil {
+		return nil, err
+	}
+	return s.verify(roots, h.Sum(nil))
+}
@@ -0,0 +1,197 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vfile
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+type testSigner struct {
+	key  *ecdsa.PrivateKey
+	cert *x509.Certificate
+}
+
+var oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+
+// newTestSigner returns a signer with a certificate issued by parent, or a
+// self-signed one if parent is nil.
+func newTestSigner(t *testing.T, name string, parent *testSigner) *testSigner {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	issuer, signKey := tmpl, key
+	if parent != nil {
+		issuer, signKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, issuer, &key.PublicKey, signKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testSigner{key: key, cert: cert}
+}
+
+func (s *testSigner) pool() *x509.CertPool {
+	p := x509.NewCertPool()
+	p.AddCert(s.cert)
+	return p
+}
+
+func mustMarshal(t *testing.T, v any, params string) []byte {
+	t.Helper()
+	b, err := asn1.MarshalWithParams(v, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// sign returns a PKCS#7 signature by s of signed, the content without a
+// SEQUENCE header for Authenticode, with contentType and, unless it is
+// detached, content.
+func (s *testSigner) sign(t *testing.T, contentType asn1.ObjectIdentifier, content, signed []byte, attrs bool) []byte {
+	t.Helper()
+	digest := sha256.Sum256(signed)
+	si := signerInfo{
+		Version: 1,
+		IssuerAndSerial: issuerAndSerial{
+			Issuer: asn1.RawValue{FullBytes: s.cert.RawIssuer},
+			Serial: s.cert.SerialNumber,
+		},
+		DigestAlgorithm:           pkix.AlgorithmIdentifier{Algorithm: oidSHA256},
+		DigestEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}},
+	}
+	toSign := digest[:]
+	if attrs {
+		set := mustMarshal(t, []attribute{{
+			Type:   oidMessageDigest,
+			Values: asn1.RawValue{FullBytes: mustMarshal(t, []asn1.RawValue{{FullBytes: mustMarshal(t, digest[:], "")}}, "set")},
+		}}, "set")
+		h := sha256.Sum256(set)
+		toSign = h[:]
+		set[0] = 0xa0
+		si.AuthenticatedAttributes = asn1.RawValue{FullBytes: set}
+	}
+	sig, err := s.key.Sign(rand.Reader, toSign, crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	si.EncryptedDigest = sig
+
+	ci := contentInfo{ContentType: contentType}
+	if content != nil {
+		ci.Content = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: content}
+	}
+	sd := signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{{Algorithm: oidSHA256}},
+		ContentInfo:      ci,
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: s.cert.Raw},
+		SignerInfos:      []signerInfo{si},
+	}
+	return mustMarshal(t, contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: mustMarshal(t, sd, "")},
+	}, "")
+}
+
+func TestCheckPKCS7Signature(t *testing.T) {
+	oidData := asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	ca := newTestSigner(t, "ca", nil)
+	leaf := newTestSigner(t, "leaf", ca)
+	other := newTestSigner(t, "other", nil)
+	content := []byte("kernel contents")
+
+	for _, tt := range []struct {
+		name    string
+		roots   *x509.CertPool
+		content []byte
+		sig     []byte
+		want    error
+	}{
+		{
+			name:    "attributes",
+			roots:   ca.pool(),
+			content: content,
+			sig:     leaf.sign(t, oidData, nil, content, true),
+		},
+		{
+			name:    "no attributes",
+			roots:   ca.pool(),
+			content: content,
+			sig:     leaf.sign(t, oidData, nil, content, false),
+		},
+		{
+			name:    "self-signed",
+			roots:   other.pool(),
+			content: content,
+			sig:     other.sign(t, oidData, nil, content, true),
+		},
+		{
+			name:    "other content",
+			roots:   ca.pool(),
+			content: []byte("other contents"),
+			sig:     leaf.sign(t, oidData, nil, content, true),
+			want:    ErrBadPKCS7,
+		},
+		{
+			name:    "other content without attributes",
+			roots:   ca.pool(),
+			content: []byte("other contents"),
+			sig:     leaf.sign(t, oidData, nil, content, false),
+			want:    ErrBadPKCS7,
+		},
+		{
+			name:    "untrusted",
+			roots:   other.pool(),
+			content: content,
+			sig:     leaf.sign(t, oidData, nil, content, true),
+			want:    ErrUntrustedSigner,
+		},
+		{
+			name:    "garbage",
+			roots:   ca.pool(),
+			content: content,
+			sig:     []byte("garbage"),
+			want:    ErrBadPKCS7,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, err := CheckPKCS7Signature(tt.roots, bytes.NewReader(tt.content), tt.sig)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("CheckPKCS7Signature() = %v, want %v", err, tt.want)
+			}
+			if err == nil && signer.Subject.CommonName == "" {
+				t.Errorf("CheckPKCS7Signature() returned no signer")
+			}
+		})
+	}
+}
@@ -148,11 +148,6 @@ func OpenSignedFile(keyring openpgp.KeyRing, path, pathSig string, opts ...OpenS
 	if err != nil {
 		return nil, err
 	}
-	var o openSignedFileOptions
-	// Apply options if given.
-	for _, opt := range opts {
-		opt(&o)
-	}
 
 	signaturef, err := os.Open(pathSig)
 	if err != nil {
@@ -160,22 +155,36 @@ func OpenSignedFile(keyring openpgp.KeyRing, path, pathSig string, opts ...OpenS
 	}
 	defer signaturef.Close()
 
+	// After CheckDetachedSignature reads the whole file, seek back to the beginning.
+	defer f.Seek(0, io.SeekStart)
+
+	if err := CheckDetachedSignature(keyring, f, signaturef, opts...); err != nil {
+		return f, ErrUnsigned{Path: path, Err: err}
+	}
+	return f, nil
+}
+
+// CheckDetachedSignature verifies that signature is a detached OpenPGP
+// signature of content by a key of keyring.
+func CheckDetachedSignature(keyring openpgp.KeyRing, content, signature io.Reader, opts ...OpenSignedFileOption) error {
+	var o openSignedFileOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	var config packet.Config
 	if o.ignoreTimeConflict {
 		config.Time = getEndOfTime
 	}
 
-	// After CheckDetachedSignature reads the whole file, seek back to the beginning.
-	defer f.Seek(0, io.SeekStart)
-
 	if keyring == nil {
-		return f, ErrUnsigned{Path: path, Err: ErrNoKeyRing}
-	} else if signer, err := openpgp.CheckDetachedSignature(keyring, f, signaturef, &config); err != nil {
-		return f, ErrUnsigned{Path: path, Err: err}
+		return ErrNoKeyRing
+	} else if signer, err := openpgp.CheckDetachedSignature(keyring, content, signature, &config); err != nil {
+		return err
 	} else if signer == nil {
-		return f, ErrUnsigned{Path: path, Err: ErrWrongSigner{keyring}}
+		return ErrWrongSigner{keyring}
 	}
-	return f, nil
+	return nil
 }
 
 // ErrInvalidHash is returned when hash verification failed.
@@ -0,0 +1,224 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wireguard
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errConfig = errors.New("invalid configuration")
+
+// Config is a change to the configuration of an interface. Nil fields are
+// left as they are.
+type Config struct {
+	PrivateKey   *Key
+	ListenPort   *int
+	FirewallMark *int
+	// ReplacePeers removes the peers not in Peers.
+	ReplacePeers bool
+	Peers        []PeerConfig
+}
+
+// PeerConfig is a change to the configuration of a peer, which is added if
+// it does not exist. Nil fields are left as they are.
+type PeerConfig struct {
+	PublicKey Key
+	// Remove removes the peer, ignoring the other fields.
+	Remove bool
+	// PresharedKey sets the preshared key, the zero key removing it.
+	PresharedKey *Key
+	Endpoint     *net.UDPAddr
+	// PersistentKeepalive sets the interval of keepalives, 0 disabling them.
+	PersistentKeepalive *time.Duration
+	// ReplaceAllowedIPs removes the allowed IPs not in AllowedIPs.
+	ReplaceAllowedIPs bool
+	AllowedIPs        []net.IPNet
+}
+
+// Device is the configuration and state of an interface.
+type Device struct {
+	Name         string
+	Index        int
+	PrivateKey   Key
+	PublicKey    Key
+	ListenPort   int
+	FirewallMark int
+	Peers        []Peer
+}
+
+// Peer is the configuration and state of a peer.
+type Peer struct {
+	PublicKey           Key
+	PresharedKey        Key
+	Endpoint            *net.UDPAddr
+	PersistentKeepalive time.Duration
+	// LastHandshake is zero if there has been no handshake.
+	LastHandshake   time.Time
+	RxBytes         int64
+	TxBytes         int64
+	AllowedIPs      []net.IPNet
+	ProtocolVersion int
+}
+
+func parseOff(v string, bits int) (int, error) {
+	if v == "off" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(v, 0, bits)
+	return int(n), err
+}
+
+// ParseAllowedIPs parses a comma separated list of prefixes, addresses
+// standing for a prefix of only themselves.
+func ParseAllowedIPs(v string) ([]net.IPNet, error) {
+	var ips []net.IPNet
+	for _, s := range strings.Split(v, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		ip, n, err := net.ParseCIDR(s)
+		if err != nil {
+			// A bare address is a host route.
+			if ip = net.ParseIP(s); ip == nil {
+				return nil, fmt.Errorf("%w: allowed IP %q", errConfig, s)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			n = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		}
+		ips = append(ips, *n)
+	}
+	return ips, nil
+}
+
+// ParseConfig parses a configuration file of wg(8), as setconf applies it:
+// replacing the peers and their allowed IPs.
+func ParseConfig(r io.Reader) (*Config, error) {
+	c := &Config{ReplacePeers: true}
+	var peer *PeerConfig
+	section := ""
+
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		l, _, _ := strings.Cut(s.Text(), "#")
+		l = strings.TrimSpace(l)
+		if l == "" {
+			continue
+		}
+		if strings.HasPrefix(l, "[") && strings.HasSuffix(l, "]") {
+			section = strings.ToLower(strings.TrimSpace(l[1 : len(l)-1]))
+			switch section {
+			case "interface":
+			case "peer":
+				c.Peers = append(c.Peers, PeerConfig{ReplaceAllowedIPs: true})
+				peer = &c.Peers[len(c.Peers)-1]
+			default:
+				return nil, fmt.Errorf("%w: line %d: unknown section %q", errConfig, line, l)
+			}
+			continue
+		}
+
+		key, v, ok := strings.Cut(l, "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: line %d: %q is not a key = value", errConfig, line, l)
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		v = strings.TrimSpace(v)
+
+		var err error
+		switch section + "." + key {
+		case "interface.privatekey":
+			var k Key
+			k, err = ParseKey(v)
+			c.PrivateKey = &k
+		case "interface.listenport":
+			var port int
+			port, err = parseOff(v, 16)
+			c.ListenPort = &port
+		case "interface.fwmark":
+			var mark int
+			mark, err = parseOff(v, 32)
+			c.FirewallMark = &mark
+		case "peer.publickey":
+			peer.PublicKey, err = ParseKey(v)
+		case "peer.presharedkey":
+			var k Key
+			k, err = ParseKey(v)
+			peer.PresharedKey = &k
+		case "peer.allowedips":
+			var ips []net.IPNet
+			ips, err = ParseAllowedIPs(v)
+			peer.AllowedIPs = append(peer.AllowedIPs, ips...)
+		case "peer.endpoint":
+			peer.Endpoint, err = net.ResolveUDPAddr("udp", v)
+		case "peer.persistentkeepalive":
+			var secs int
+			secs, err = parseOff(v, 16)
+			d := time.Duration(secs) * time.Second
+			peer.PersistentKeepalive = &d
+		default:
+			return nil, fmt.Errorf("%w: line %d: unknown key %q", errConfig, line, key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", errConfig, line, err)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, p := range c.Peers {
+		if p.PublicKey.IsZero() {
+			return nil, fmt.Errorf("%w: peer without a public key", errConfig)
+		}
+	}
+	return c, nil
+}
+
+// WriteConfig writes the configuration of d in the format of ParseConfig.
+func (d *Device) WriteConfig(w io.Writer) error {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "[Interface]\n")
+	if d.ListenPort != 0 {
+		fmt.Fprintf(b, "ListenPort = %d\n", d.ListenPort)
+	}
+	if d.FirewallMark != 0 {
+		fmt.Fprintf(b, "FwMark = %#x\n", d.FirewallMark)
+	}
+	if !d.PrivateKey.IsZero() {
+		fmt.Fprintf(b, "PrivateKey = %s\n", d.PrivateKey)
+	}
+	for _, p := range d.Peers {
+		fmt.Fprintf(b, "\n[Peer]\nPublicKey = %s\n", p.PublicKey)
+		if !p.PresharedKey.IsZero() {
+			fmt.Fprintf(b, "PresharedKey = %s\n", p.PresharedKey)
+		}
+		if len(p.AllowedIPs) > 0 {
+			ips := make([]string, len(p.AllowedIPs))
+			for i, n := range p.AllowedIPs {
+				ips[i] = n.String()
+			}
+			fmt.Fprintf(b, "AllowedIPs = %s\n", strings.Join(ips, ", "))
+		}
+		if p.Endpoint != nil {
+			fmt.Fprintf(b, "Endpoint = %s\n", p.Endpoint)
+		}
+		if p.PersistentKeepalive != 0 {
+			fmt.Fprintf(b, "PersistentKeepalive = %d\n", int(p.PersistentKeepalive.Seconds()))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
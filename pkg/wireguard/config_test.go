@@ -0,0 +1,157 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wireguard
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const (
+	privKey = "yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk="
+	pubKey  = "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg="
+	pskKey  = "E4U4nfurq8pmOSj2FVzD1dtlXlj5hDFXCrN9UxUFVCY="
+)
+
+func mustKey(t *testing.T, s string) *Key {
+	t.Helper()
+	k, err := ParseKey(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &k
+}
+
+func mustCIDR(t *testing.T, s string) net.IPNet {
+	t.Helper()
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *n
+}
+
+func TestParseConfig(t *testing.T) {
+	port, mark := 51820, 0x10
+	keepalive := 25 * time.Second
+	want := &Config{
+		PrivateKey:   mustKey(t, privKey),
+		ListenPort:   &port,
+		FirewallMark: &mark,
+		ReplacePeers: true,
+		Peers: []PeerConfig{
+			{
+				PublicKey:           *mustKey(t, pubKey),
+				PresharedKey:        mustKey(t, pskKey),
+				Endpoint:            &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 51820},
+				PersistentKeepalive: &keepalive,
+				ReplaceAllowedIPs:   true,
+				AllowedIPs:          []net.IPNet{mustCIDR(t, "10.0.0.0/24"), mustCIDR(t, "10.0.1.1/32"), mustCIDR(t, "fd00::/64")},
+			},
+			{
+				PublicKey:         *mustKey(t, pskKey),
+				Endpoint:          &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1234},
+				ReplaceAllowedIPs: true,
+			},
+		},
+	}
+
+	got, err := ParseConfig(strings.NewReader(`# provisioning tunnel
+[Interface]
+PrivateKey = ` + privKey + `
+ListenPort = 51820
+FwMark = 0x10
+
+[Peer]
+PublicKey = ` + pubKey + `
+PresharedKey = ` + pskKey + ` # not shared with anyone else
+AllowedIPs = 10.0.0.0/24, 10.0.1.1
+allowedips = fd00::/64
+Endpoint = 192.0.2.1:51820
+PersistentKeepalive = 25
+
+[peer]
+PublicKey = ` + pskKey + `
+Endpoint = [2001:db8::1]:1234
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ParseConfig() mismatch (-want +got):\n%s", diff)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		config string
+	}{
+		{name: "section", config: "[Wireguard]\n"},
+		{name: "assignment", config: "[Interface]\nPrivateKey\n"},
+		{name: "unknown key", config: "[Interface]\nAddress = 10.0.0.1/24\n"},
+		{name: "key outside section", config: "PublicKey = " + pubKey + "\n"},
+		{name: "bad key", config: "[Interface]\nPrivateKey = abc\n"},
+		{name: "bad port", config: "[Interface]\nListenPort = 65536\n"},
+		{name: "bad allowed IP", config: "[Peer]\nPublicKey = " + pubKey + "\nAllowedIPs = 10.0.0/8\n"},
+		{name: "bad endpoint", config: "[Peer]\nPublicKey = " + pubKey + "\nEndpoint = 192.0.2.1\n"},
+		{name: "peer without key", config: "[Peer]\nAllowedIPs = 10.0.0.0/8\n"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseConfig(strings.NewReader(tt.config)); !errors.Is(err, errConfig) {
+				t.Errorf("ParseConfig() = %v, want %v", err, errConfig)
+			}
+		})
+	}
+}
+
+func TestWriteConfig(t *testing.T) {
+	d := &Device{
+		Name:         "wg0",
+		PrivateKey:   *mustKey(t, privKey),
+		PublicKey:    mustKey(t, privKey).PublicKey(),
+		ListenPort:   51820,
+		FirewallMark: 0x10,
+		Peers: []Peer{
+			{
+				PublicKey:           *mustKey(t, pubKey),
+				PresharedKey:        *mustKey(t, pskKey),
+				Endpoint:            &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 51820},
+				PersistentKeepalive: 25 * time.Second,
+				LastHandshake:       time.Now(),
+				AllowedIPs:          []net.IPNet{mustCIDR(t, "10.0.0.0/24"), mustCIDR(t, "fd00::/64")},
+			},
+			{PublicKey: *mustKey(t, pskKey)},
+		},
+	}
+	want := `[Interface]
+ListenPort = 51820
+FwMark = 0x10
+PrivateKey = ` + privKey + `
+
+[Peer]
+PublicKey = ` + pubKey + `
+PresharedKey = ` + pskKey + `
+AllowedIPs = 10.0.0.0/24, fd00::/64
+Endpoint = 192.0.2.1:51820
+PersistentKeepalive = 25
+
+[Peer]
+PublicKey = ` + pskKey + `
+`
+	var b strings.Builder
+	if err := d.WriteConfig(&b); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, b.String()); diff != "" {
+		t.Errorf("WriteConfig() mismatch (-want +got):\n%s", diff)
+	}
+	if _, err := ParseConfig(strings.NewReader(b.String())); err != nil {
+		t.Errorf("ParseConfig(WriteConfig()) = %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package wireguard configures kernel WireGuard interfaces over generic
+// netlink, and reads and writes the configuration files of wg(8).
+package wireguard
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// KeyLen is the length of WireGuard keys, Curve25519 ones and preshared
+// ones alike.
+const KeyLen = 32
+
+var errKey = errors.New("invalid key")
+
+// Key is a WireGuard key.
+type Key [KeyLen]byte
+
+// ParseKey parses a base64 encoded key.
+func ParseKey(s string) (Key, error) {
+	var k Key
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(b) != KeyLen {
+		return k, fmt.Errorf("%w: %q", errKey, s)
+	}
+	copy(k[:], b)
+	return k, nil
+}
+
+// GenerateKey returns a random key, to use as a preshared key.
+func GenerateKey() (Key, error) {
+	var k Key
+	if _, err := rand.Read(k[:]); err != nil {
+		return k, err
+	}
+	return k, nil
+}
+
+// GeneratePrivateKey returns a random Curve25519 private key, clamped like
+// wg genkey does.
+func GeneratePrivateKey() (Key, error) {
+	k, err := GenerateKey()
+	if err != nil {
+		return k, err
+	}
+	k[0] &= 248
+	k[31] = k[31]&127 | 64
+	return k, nil
+}
+
+// PublicKey returns the public key of the private key k.
+func (k Key) PublicKey() Key {
+	var pub Key
+	priv, err := ecdh.X25519().NewPrivateKey(k[:])
+	if err != nil {
+		// Any 32 bytes are an X25519 private key.
+		panic(err)
+	}
+	copy(pub[:], priv.PublicKey().Bytes())
+	return pub
+}
+
+// IsZero reports whether k is all zeros, which for a preshared key means
+// there is none.
+func (k Key) IsZero() bool {
+	return k == Key{}
+}
+
+// String returns k encoded in base64.
+func (k Key) String() string {
+	return base64.StdEncoding.EncodeToString(k[:])
+}
@@ -0,0 +1,49 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wireguard
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestKey(t *testing.T) {
+	// RFC 7748 Section 6.1.
+	priv, _ := hex.DecodeString("77076d0a7318a57d3c16c17251b26645df4c2f87ebc0992ab177fba51db92c2a")
+	pub, _ := hex.DecodeString("8520f0098930a754748b7ddcb43ef75a0dbf3a0d26381af4eba4a98eaa9b4e6a")
+
+	k, err := ParseKey(base64.StdEncoding.EncodeToString(priv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := k.PublicKey().String(), base64.StdEncoding.EncodeToString(pub); got != want {
+		t.Errorf("PublicKey() = %s, want %s", got, want)
+	}
+
+	for _, s := range []string{"", "not base64!", base64.StdEncoding.EncodeToString(priv[:31])} {
+		if _, err := ParseKey(s); !errors.Is(err, errKey) {
+			t.Errorf("ParseKey(%q) = %v, want %v", s, err, errKey)
+		}
+	}
+}
+
+func TestGeneratePrivateKey(t *testing.T) {
+	k, err := GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k[0]&7 != 0 || k[31]&0xc0 != 0x40 {
+		t.Errorf("GeneratePrivateKey() = %x, not clamped", k)
+	}
+	other, err := GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k == other || k.IsZero() {
+		t.Errorf("GeneratePrivateKey() = %x and %x", k, other)
+	}
+}
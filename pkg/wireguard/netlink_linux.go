@@ -0,0 +1,376 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wireguard
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// The generic netlink family of WireGuard, include/uapi/linux/wireguard.h.
+const (
+	genlName    = "wireguard"
+	genlVersion = 1
+
+	cmdGetDevice = 0
+	cmdSetDevice = 1
+)
+
+// Device attributes.
+const (
+	deviceIfindex = iota + 1
+	deviceIfname
+	devicePrivateKey
+	devicePublicKey
+	deviceFlags
+	deviceListenPort
+	deviceFwmark
+	devicePeers
+)
+
+// Peer attributes.
+const (
+	peerPublicKey = iota + 1
+	peerPresharedKey
+	peerFlags
+	peerEndpoint
+	peerPersistentKeepalive
+	peerLastHandshake
+	peerRxBytes
+	peerTxBytes
+	peerAllowedIPs
+	peerProtocolVersion
+)
+
+// Allowed IP attributes.
+const (
+	allowedIPFamily = iota + 1
+	allowedIPAddr
+	allowedIPCIDRMask
+)
+
+const (
+	deviceReplacePeers = 1 << 0
+
+	peerRemove            = 1 << 0
+	peerReplaceAllowedIPs = 1 << 1
+)
+
+var (
+	// ErrNotSupported is returned when the kernel has no WireGuard.
+	ErrNotSupported = errors.New("wireguard is not supported by the kernel")
+
+	errMessage = errors.New("invalid wireguard netlink message")
+)
+
+func nested(typ int) *nl.RtAttr {
+	return nl.NewRtAttr(typ|int(nl.NLA_F_NESTED), nil)
+}
+
+// sockaddr encodes a as a struct sockaddr_in or sockaddr_in6.
+func sockaddr(a *net.UDPAddr) []byte {
+	if ip4 := a.IP.To4(); ip4 != nil {
+		b := make([]byte, unix.SizeofSockaddrInet4)
+		binary.NativeEndian.PutUint16(b, unix.AF_INET)
+		binary.BigEndian.PutUint16(b[2:], uint16(a.Port))
+		copy(b[4:], ip4)
+		return b
+	}
+	b := make([]byte, unix.SizeofSockaddrInet6)
+	binary.NativeEndian.PutUint16(b, unix.AF_INET6)
+	binary.BigEndian.PutUint16(b[2:], uint16(a.Port))
+	copy(b[8:], a.IP.To16())
+	if a.Zone != "" {
+		if ifi, err := net.InterfaceByName(a.Zone); err == nil {
+			binary.NativeEndian.PutUint32(b[24:], uint32(ifi.Index))
+		}
+	}
+	return b
+}
+
+func parseSockaddr(b []byte) (*net.UDPAddr, error) {
+	if len(b) < 2 {
+		return nil, fmt.Errorf("%w: endpoint of %d bytes", errMessage, len(b))
+	}
+	switch binary.NativeEndian.Uint16(b) {
+	case unix.AF_INET:
+		if len(b) < 8 {
+			break
+		}
+		return &net.UDPAddr{IP: net.IP(append([]byte(nil), b[4:8]...)), Port: int(binary.BigEndian.Uint16(b[2:]))}, nil
+	case unix.AF_INET6:
+		if len(b) < unix.SizeofSockaddrInet6 {
+			break
+		}
+		a := &net.UDPAddr{IP: net.IP(append([]byte(nil), b[8:24]...)), Port: int(binary.BigEndian.Uint16(b[2:]))}
+		if scope := binary.NativeEndian.Uint32(b[24:]); scope != 0 {
+			if ifi, err := net.InterfaceByIndex(int(scope)); err == nil {
+				a.Zone = ifi.Name
+			}
+		}
+		return a, nil
+	}
+	return nil, fmt.Errorf("%w: endpoint %x", errMessage, b)
+}
+
+// attrs returns the attributes of a set device message configuring the
+// interface name as c says.
+func (c *Config) attrs(name string) []*nl.RtAttr {
+	attrs := []*nl.RtAttr{nl.NewRtAttr(deviceIfname, nl.ZeroTerminated(name))}
+	if c.PrivateKey != nil {
+		attrs = append(attrs, nl.NewRtAttr(devicePrivateKey, c.PrivateKey[:]))
+	}
+	if c.ListenPort != nil {
+		attrs = append(attrs, nl.NewRtAttr(deviceListenPort, nl.Uint16Attr(uint16(*c.ListenPort))))
+	}
+	if c.FirewallMark != nil {
+		attrs = append(attrs, nl.NewRtAttr(deviceFwmark, nl.Uint32Attr(uint32(*c.FirewallMark))))
+	}
+	if c.ReplacePeers {
+		attrs = append(attrs, nl.NewRtAttr(deviceFlags, nl.Uint32Attr(deviceReplacePeers)))
+	}
+	if len(c.Peers) == 0 {
+		return attrs
+	}
+
+	peers := nested(devicePeers)
+	for i := range c.Peers {
+		// The attributes keep slices of the keys, not copies.
+		p := &c.Peers[i]
+		peer := nested(0)
+		peer.AddRtAttr(peerPublicKey, p.PublicKey[:])
+		var flags uint32
+		if p.Remove {
+			flags |= peerRemove
+		}
+		if p.ReplaceAllowedIPs {
+			flags |= peerReplaceAllowedIPs
+		}
+		if flags != 0 {
+			peer.AddRtAttr(peerFlags, nl.Uint32Attr(flags))
+		}
+		if p.PresharedKey != nil {
+			peer.AddRtAttr(peerPresharedKey, p.PresharedKey[:])
+		}
+		if p.Endpoint != nil {
+			peer.AddRtAttr(peerEndpoint, sockaddr(p.Endpoint))
+		}
+		if p.PersistentKeepalive != nil {
+			peer.AddRtAttr(peerPersistentKeepalive, nl.Uint16Attr(uint16(p.PersistentKeepalive.Seconds())))
+		}
+		if len(p.AllowedIPs) > 0 {
+			ips := nested(peerAllowedIPs)
+			for _, n := range p.AllowedIPs {
+				family, ip := unix.AF_INET6, n.IP.To16()
+				if ip4 := n.IP.To4(); ip4 != nil {
+					family, ip = unix.AF_INET, ip4
+				}
+				ones, _ := n.Mask.Size()
+				a := nested(0)
+				a.AddRtAttr(allowedIPFamily, nl.Uint16Attr(uint16(family)))
+				a.AddRtAttr(allowedIPAddr, ip)
+				a.AddRtAttr(allowedIPCIDRMask, nl.Uint8Attr(uint8(ones)))
+				ips.AddChild(a)
+			}
+			peer.AddChild(ips)
+		}
+		peers.AddChild(peer)
+	}
+	return append(attrs, peers)
+}
+
+func attrType(a syscall.NetlinkRouteAttr) uint16 {
+	return a.Attr.Type & nl.NLA_TYPE_MASK
+}
+
+func parseAllowedIP(b []byte) (net.IPNet, error) {
+	attrs, err := nl.ParseRouteAttr(b)
+	if err != nil {
+		return net.IPNet{}, err
+	}
+	var n net.IPNet
+	bits := 0
+	for _, a := range attrs {
+		switch attrType(a) {
+		case allowedIPFamily:
+			bits = 8 * net.IPv6len
+			if binary.NativeEndian.Uint16(a.Value) == unix.AF_INET {
+				bits = 8 * net.IPv4len
+			}
+		case allowedIPAddr:
+			n.IP = net.IP(append([]byte(nil), a.Value...))
+		case allowedIPCIDRMask:
+			n.Mask = net.CIDRMask(int(a.Value[0]), 8*len(n.IP))
+		}
+	}
+	if bits == 0 || len(n.IP)*8 != bits || n.Mask == nil {
+		return net.IPNet{}, fmt.Errorf("%w: allowed IP %x", errMessage, b)
+	}
+	return n, nil
+}
+
+func parsePeer(b []byte) (Peer, error) {
+	var p Peer
+	attrs, err := nl.ParseRouteAttr(b)
+	if err != nil {
+		return p, err
+	}
+	for _, a := range attrs {
+		switch attrType(a) {
+		case peerPublicKey:
+			copy(p.PublicKey[:], a.Value)
+		case peerPresharedKey:
+			copy(p.PresharedKey[:], a.Value)
+		case peerEndpoint:
+			if p.Endpoint, err = parseSockaddr(a.Value); err != nil {
+				return p, err
+			}
+		case peerPersistentKeepalive:
+			p.PersistentKeepalive = time.Duration(binary.NativeEndian.Uint16(a.Value)) * time.Second
+		case peerLastHandshake:
+			// A struct __kernel_timespec.
+			if len(a.Value) < 16 {
+				return p, fmt.Errorf("%w: handshake time of %d bytes", errMessage, len(a.Value))
+			}
+			sec := int64(binary.NativeEndian.Uint64(a.Value))
+			nsec := int64(binary.NativeEndian.Uint64(a.Value[8:]))
+			if sec != 0 || nsec != 0 {
+				p.LastHandshake = time.Unix(sec, nsec)
+			}
+		case peerRxBytes:
+			p.RxBytes = int64(binary.NativeEndian.Uint64(a.Value))
+		case peerTxBytes:
+			p.TxBytes = int64(binary.NativeEndian.Uint64(a.Value))
+		case peerProtocolVersion:
+			p.ProtocolVersion = int(binary.NativeEndian.Uint32(a.Value))
+		case peerAllowedIPs:
+			ips, err := nl.ParseRouteAttr(a.Value)
+			if err != nil {
+				return p, err
+			}
+			for _, ip := range ips {
+				n, err := parseAllowedIP(ip.Value)
+				if err != nil {
+					return p, err
+				}
+				p.AllowedIPs = append(p.AllowedIPs, n)
+			}
+		}
+	}
+	return p, nil
+}
+
+// parseDevice parses the replies to a get device request. The kernel
+// splits large devices over several of them, a peer possibly continuing
+// in the next one.
+func parseDevice(msgs [][]byte) (*Device, error) {
+	d := &Device{}
+	for _, m := range msgs {
+		if len(m) < nl.SizeofGenlmsg {
+			return nil, fmt.Errorf("%w: %d bytes", errMessage, len(m))
+		}
+		attrs, err := nl.ParseRouteAttr(m[nl.SizeofGenlmsg:])
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range attrs {
+			switch attrType(a) {
+			case deviceIfindex:
+				d.Index = int(binary.NativeEndian.Uint32(a.Value))
+			case deviceIfname:
+				d.Name = nl.BytesToString(a.Value)
+			case devicePrivateKey:
+				copy(d.PrivateKey[:], a.Value)
+			case devicePublicKey:
+				copy(d.PublicKey[:], a.Value)
+			case deviceListenPort:
+				d.ListenPort = int(binary.NativeEndian.Uint16(a.Value))
+			case deviceFwmark:
+				d.FirewallMark = int(binary.NativeEndian.Uint32(a.Value))
+			case devicePeers:
+				peers, err := nl.ParseRouteAttr(a.Value)
+				if err != nil {
+					return nil, err
+				}
+				for _, pa := range peers {
+					p, err := parsePeer(pa.Value)
+					if err != nil {
+						return nil, err
+					}
+					if n := len(d.Peers); n > 0 && d.Peers[n-1].PublicKey == p.PublicKey {
+						d.Peers[n-1].AllowedIPs = append(d.Peers[n-1].AllowedIPs, p.AllowedIPs...)
+						continue
+					}
+					d.Peers = append(d.Peers, p)
+				}
+			}
+		}
+	}
+	return d, nil
+}
+
+func family() (*netlink.GenlFamily, error) {
+	f, err := netlink.GenlFamilyGet(genlName)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotSupported, err)
+	}
+	return f, nil
+}
+
+// Get returns the configuration and state of the interface name.
+func Get(name string) (*Device, error) {
+	f, err := family()
+	if err != nil {
+		return nil, err
+	}
+	req := nl.NewNetlinkRequest(int(f.ID), unix.NLM_F_DUMP)
+	req.AddData(&nl.Genlmsg{Command: cmdGetDevice, Version: genlVersion})
+	req.AddData(nl.NewRtAttr(deviceIfname, nl.ZeroTerminated(name)))
+	msgs, err := req.Execute(unix.NETLINK_GENERIC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return parseDevice(msgs)
+}
+
+// Configure changes the configuration of the interface name.
+func Configure(name string, c *Config) error {
+	f, err := family()
+	if err != nil {
+		return err
+	}
+	req := nl.NewNetlinkRequest(int(f.ID), unix.NLM_F_ACK)
+	req.AddData(&nl.Genlmsg{Command: cmdSetDevice, Version: genlVersion})
+	for _, a := range c.attrs(name) {
+		req.AddData(a)
+	}
+	if _, err := req.Execute(unix.NETLINK_GENERIC, 0); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// Devices returns the names of the WireGuard interfaces.
+func Devices() ([]string, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, l := range links {
+		if l.Type() == genlName {
+			names = append(names, l.Attrs().Name)
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,170 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wireguard
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// message returns a netlink message of the attributes, without its
+// netlink header, as Execute returns them.
+func message(attrs ...*nl.RtAttr) []byte {
+	b := make([]byte, nl.SizeofGenlmsg)
+	for _, a := range attrs {
+		b = append(b, a.Serialize()...)
+	}
+	return b
+}
+
+func TestAttrs(t *testing.T) {
+	port, mark := 51820, 0
+	keepalive := 25 * time.Second
+	c := &Config{
+		PrivateKey:   mustKey(t, privKey),
+		ListenPort:   &port,
+		FirewallMark: &mark,
+		ReplacePeers: true,
+		Peers: []PeerConfig{
+			{
+				PublicKey:           *mustKey(t, pubKey),
+				PresharedKey:        mustKey(t, pskKey),
+				Endpoint:            &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 51820},
+				PersistentKeepalive: &keepalive,
+				ReplaceAllowedIPs:   true,
+				AllowedIPs:          []net.IPNet{mustCIDR(t, "10.0.0.0/24"), mustCIDR(t, "fd00::/64")},
+			},
+			{
+				PublicKey: *mustKey(t, pskKey),
+				Remove:    true,
+				Endpoint:  &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1234},
+			},
+		},
+	}
+
+	attrs := c.attrs("wg0")
+	// The flags of the set message have no place in a device, check them
+	// separately.
+	var flags []uint32
+	for _, a := range attrs {
+		if a.Type == deviceFlags {
+			flags = append(flags, binary.NativeEndian.Uint32(a.Data))
+		}
+	}
+	if !cmp.Equal(flags, []uint32{deviceReplacePeers}) {
+		t.Errorf("device flags = %v, want [%d]", flags, deviceReplacePeers)
+	}
+
+	got, err := parseDevice([][]byte{message(attrs...)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Device{
+		Name:       "wg0",
+		PrivateKey: *mustKey(t, privKey),
+		ListenPort: 51820,
+		Peers: []Peer{
+			{
+				PublicKey:           *mustKey(t, pubKey),
+				PresharedKey:        *mustKey(t, pskKey),
+				Endpoint:            &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1).To4(), Port: 51820},
+				PersistentKeepalive: 25 * time.Second,
+				AllowedIPs:          []net.IPNet{mustCIDR(t, "10.0.0.0/24"), mustCIDR(t, "fd00::/64")},
+			},
+			{
+				PublicKey: *mustKey(t, pskKey),
+				Endpoint:  &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1234},
+			},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("parseDevice(attrs()) mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseDevice(t *testing.T) {
+	key := mustKey(t, pubKey)
+	peer := func(ips ...string) *nl.RtAttr {
+		p := nested(0)
+		p.AddRtAttr(peerPublicKey, key[:])
+		ts := make([]byte, 16)
+		binary.NativeEndian.PutUint64(ts, 1700000000)
+		binary.NativeEndian.PutUint64(ts[8:], 5)
+		p.AddRtAttr(peerLastHandshake, ts)
+		p.AddRtAttr(peerRxBytes, nl.Uint64Attr(1024))
+		p.AddRtAttr(peerTxBytes, nl.Uint64Attr(2048))
+		p.AddRtAttr(peerProtocolVersion, nl.Uint32Attr(1))
+		a := nested(peerAllowedIPs)
+		for _, s := range ips {
+			n := mustCIDR(t, s)
+			ip := nested(0)
+			ip.AddRtAttr(allowedIPFamily, nl.Uint16Attr(2))
+			ip.AddRtAttr(allowedIPAddr, n.IP.To4())
+			ones, _ := n.Mask.Size()
+			ip.AddRtAttr(allowedIPCIDRMask, nl.Uint8Attr(uint8(ones)))
+			a.AddChild(ip)
+		}
+		p.AddChild(a)
+		return p
+	}
+	peers := func(p *nl.RtAttr) *nl.RtAttr {
+		a := nested(devicePeers)
+		a.AddChild(p)
+		return a
+	}
+
+	// The peer continues in a second message.
+	msgs := [][]byte{
+		message(
+			nl.NewRtAttr(deviceIfindex, nl.Uint32Attr(7)),
+			nl.NewRtAttr(deviceIfname, nl.ZeroTerminated("wg0")),
+			nl.NewRtAttr(devicePublicKey, key[:]),
+			nl.NewRtAttr(deviceFwmark, nl.Uint32Attr(0x10)),
+			peers(peer("10.0.0.0/24")),
+		),
+		message(
+			nl.NewRtAttr(deviceIfindex, nl.Uint32Attr(7)),
+			nl.NewRtAttr(deviceIfname, nl.ZeroTerminated("wg0")),
+			peers(peer("10.0.1.0/24")),
+		),
+	}
+	got, err := parseDevice(msgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Device{
+		Name:         "wg0",
+		Index:        7,
+		PublicKey:    *key,
+		FirewallMark: 0x10,
+		Peers: []Peer{{
+			PublicKey:       *key,
+			LastHandshake:   time.Unix(1700000000, 5),
+			RxBytes:         1024,
+			TxBytes:         2048,
+			ProtocolVersion: 1,
+			AllowedIPs:      []net.IPNet{mustCIDR(t, "10.0.0.0/24"), mustCIDR(t, "10.0.1.0/24")},
+		}},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("parseDevice() mismatch (-want +got):\n%s", diff)
+	}
+
+	bad := nested(devicePeers)
+	p := bad.AddRtAttr(0|int(nl.NLA_F_NESTED), nil)
+	p.AddRtAttr(peerEndpoint, []byte{1})
+	if _, err := parseDevice([][]byte{message(bad)}); !errors.Is(err, errMessage) {
+		t.Errorf("parseDevice(bad endpoint) = %v, want %v", err, errMessage)
+	}
+	if _, err := parseDevice([][]byte{{1}}); !errors.Is(err, errMessage) {
+		t.Errorf("parseDevice(short) = %v, want %v", err, errMessage)
+	}
+}